@@ -45,6 +45,33 @@ func NewGroupMessageDeliveryError(from, groupID string, reason string, err error
 		WithInternal(err)
 }
 
+// NewMessageTooLong rejects content over the configured limit; hint tells the
+// sender what to do about it
+func NewMessageTooLong(length, maxLength int, hint string) *AppError {
+	return New(
+		ErrCodeMessageTooLong,
+		fmt.Sprintf("Message is too long (%d characters, the limit is %d). %s", length, maxLength, hint),
+		fiber.StatusRequestEntityTooLarge,
+	).
+		WithDetails("length", length).
+		WithDetails("max_length", maxLength).
+		WithContext("subsystem", "chat")
+}
+
+// NewMessageRequestPending rejects a message to a non-friend whose message
+// request already holds limit messages. A declined request is reported the
+// same way so senders cannot tell.
+func NewMessageRequestPending(recipient string, limit int) *AppError {
+	return New(
+		ErrCodeMessageRequest,
+		fmt.Sprintf("You can send %s %d messages until they reply or accept your message request", recipient, limit),
+		fiber.StatusForbidden,
+	).
+		WithDetails("recipient", recipient).
+		WithDetails("limit", limit).
+		WithContext("subsystem", "chat")
+}
+
 // Redis/Cache errors
 func NewCacheError(operation string, key string, err error) *AppError {
 	return New(ErrCodeInternal, "Cache operation failed", fiber.StatusInternalServerError).
@@ -116,6 +143,14 @@ func NewFileUploadError(filename string, reason string, err error) *AppError {
 		WithInternal(err)
 }
 
+// NewUploadQuotaExceeded reports an upload that would take the user's stored
+// uploads past quota bytes
+func NewUploadQuotaExceeded(quota int64) *AppError {
+	return New(ErrCodeUploadQuota, "Upload storage quota exceeded", fiber.StatusRequestEntityTooLarge).
+		WithDetails("quota_bytes", quota).
+		WithContext("subsystem", "upload")
+}
+
 func NewFileValidationError(filename string, violations []string) *AppError {
 	return New(ErrCodeInvalidFileType, "File validation failed", fiber.StatusBadRequest).
 		WithOperation("file_validation").
@@ -142,6 +177,33 @@ func NewAuthorizationError(username string, resource string, action string) *App
 		WithContext("subsystem", "auth")
 }
 
+// NewRestrictedError reports an action blocked by a temporary account restriction
+func NewRestrictedError(username string, action string, until time.Time) *AppError {
+	return New(ErrCodeRestricted, "Your account is temporarily restricted from this action pending moderator review", fiber.StatusForbidden).
+		WithOperation("restriction_check").
+		WithDetails("action", action).
+		WithDetails("until", until.UTC().Format(time.RFC3339)).
+		WithContext("username", username).
+		WithContext("subsystem", "moderation")
+}
+
+// NewImpersonationReadOnly reports a change attempted in a session an admin
+// opened as another user, where only looking is allowed
+func NewImpersonationReadOnly(impersonator string, action string) *AppError {
+	return New(ErrCodeImpersonating, "This is a read-only support session; changes are disabled", fiber.StatusForbidden).
+		WithOperation("impersonation_check").
+		WithDetails("action", action).
+		WithContext("impersonator", impersonator).
+		WithContext("subsystem", "auth")
+}
+
+// NewFeatureDisabled reports a request for a feature this deployment turned
+// off. It is a 404 since, for the deployment, the feature does not exist.
+func NewFeatureDisabled(feature string) *AppError {
+	return New(ErrCodeFeatureDisabled, fmt.Sprintf("The %s feature is disabled on this server", feature), fiber.StatusNotFound).
+		WithDetails("feature", feature)
+}
+
 // Helper functions
 func truncateString(s string, maxLen int) string {
 	if len(s) <= maxLen {
@@ -17,6 +17,8 @@ const (
 	ErrCodeInvalidCreds    ErrorCode = "INVALID_CREDENTIALS"
 	ErrCodeSessionExpired  ErrorCode = "SESSION_EXPIRED"
 	ErrCodeSessionNotFound ErrorCode = "SESSION_NOT_FOUND"
+	ErrCodeRestricted      ErrorCode = "ACCOUNT_RESTRICTED"
+	ErrCodeImpersonating   ErrorCode = "IMPERSONATION_READ_ONLY"
 
 	// User Management
 	ErrCodeUserNotFound     ErrorCode = "USER_NOT_FOUND"
@@ -30,11 +32,14 @@ const (
 	ErrCodeFileTooLarge    ErrorCode = "FILE_TOO_LARGE"
 	ErrCodeInvalidFilename ErrorCode = "INVALID_FILENAME"
 	ErrCodeUploadFailed    ErrorCode = "UPLOAD_FAILED"
+	ErrCodeUploadQuota     ErrorCode = "UPLOAD_QUOTA_EXCEEDED"
 
 	// Chat & Messaging
-	ErrCodeMessageEmpty  ErrorCode = "MESSAGE_EMPTY"
-	ErrCodeChatNotFound  ErrorCode = "CHAT_NOT_FOUND"
-	ErrCodeMessageFailed ErrorCode = "MESSAGE_SEND_FAILED"
+	ErrCodeMessageEmpty   ErrorCode = "MESSAGE_EMPTY"
+	ErrCodeChatNotFound   ErrorCode = "CHAT_NOT_FOUND"
+	ErrCodeMessageFailed  ErrorCode = "MESSAGE_SEND_FAILED"
+	ErrCodeMessageTooLong ErrorCode = "MESSAGE_TOO_LONG"
+	ErrCodeMessageRequest ErrorCode = "MESSAGE_REQUEST_PENDING"
 
 	// Database & Storage
 	ErrCodeDatabaseError ErrorCode = "DATABASE_ERROR"
@@ -51,6 +56,9 @@ const (
 	// Internal Errors
 	ErrCodeInternal       ErrorCode = "INTERNAL_ERROR"
 	ErrCodeServiceUnavail ErrorCode = "SERVICE_UNAVAILABLE"
+
+	// Features turned off for the deployment
+	ErrCodeFeatureDisabled ErrorCode = "FEATURE_DISABLED"
 )
 
 // AppError represents a structured application error with rich context
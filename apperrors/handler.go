@@ -50,6 +50,7 @@ func Handler(config HandlerConfig) fiber.ErrorHandler {
 		isHTMX := c.Get("HX-Request") == "true"
 		isAPI := strings.HasPrefix(c.Path(), "/api/") ||
 			strings.HasPrefix(c.Path(), "/call/") ||
+			strings.HasPrefix(c.Path(), "/admin/") ||
 			c.Path() == "/api"
 
 		// Handle HTMX requests
@@ -208,11 +209,18 @@ func logError(logger *log.Logger, c *fiber.Ctx, err *AppError) {
 	fields["method"] = c.Method()
 	fields["path"] = c.Path()
 	fields["ip"] = c.IP()
+	if clientIP, ok := c.Locals("client_ip").(string); ok && clientIP != "" {
+		// Set by the proxy middleware when requests arrive through a proxy
+		fields["ip"] = clientIP
+	}
 	fields["user_agent"] = c.Get("User-Agent")
 
 	if username := c.Locals("username"); username != nil {
 		fields["username"] = username
 	}
+	if impersonator := c.Locals("impersonator"); impersonator != nil {
+		fields["impersonator"] = impersonator
+	}
 
 	if requestID := c.Locals("requestid"); requestID != nil {
 		fields["request_id"] = requestID
@@ -0,0 +1,43 @@
+package apperrors
+
+import (
+	"exc6/tests/golden"
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestErrorFragmentGolden(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    *AppError
+		status int
+	}{
+		{name: "validation", err: NewValidationError(`Name can't contain "<script>alert(1)</script>"`), status: fiber.StatusBadRequest},
+		{name: "not-found", err: NewUserNotFound(), status: fiber.StatusNotFound},
+		{name: "rate-limited", err: NewRateLimitError(), status: fiber.StatusTooManyRequests},
+		{name: "internal", err: NewInternalError("Failed to load chat history"), status: fiber.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := fiber.New(fiber.Config{ErrorHandler: Handler(HandlerConfig{})})
+			app.Get("/", func(c *fiber.Ctx) error { return tt.err })
+
+			req := httptest.NewRequest(fiber.MethodGet, "/", nil)
+			req.Header.Set("HX-Request", "true")
+			resp, err := app.Test(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			assert.Equal(t, tt.status, resp.StatusCode)
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			golden.Assert(t, "fragment-"+tt.name, string(body))
+		})
+	}
+}
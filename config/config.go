@@ -1,24 +1,53 @@
 package config
 
 import (
+	goerrors "errors"
+	"exc6/pkg/httpclient"
 	"exc6/pkg/logger"
 	"fmt"
+	"net"
+	"net/mail"
+	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
+
+	"golang.org/x/crypto/bcrypt"
 )
 
 type Config struct {
-	Server    ServerConfig
-	Redis     RedisConfig
-	Kafka     KafkaConfig
-	Upload    UploadConfig
-	Session   SessionConfig
-	RateLimit RateLimitConfig
-	Database  DatabaseConfig
-	Log       LogConfig
+	Server      ServerConfig
+	Redis       RedisConfig
+	Kafka       KafkaConfig
+	Upload      UploadConfig
+	Session     SessionConfig
+	WebSocket   WebSocketConfig
+	RateLimit   RateLimitConfig
+	Database    DatabaseConfig
+	Log         LogConfig
+	Egress      EgressConfig
+	Bots        BotsConfig
+	Features    FeaturesConfig
+	Gifs        GifConfig
+	Messages    MessagesConfig
+	Groups      GroupsConfig
+	Search      SearchConfig
+	Export      ExportConfig
+	Passwords   PasswordConfig
+	Moderation  ModerationConfig
+	Canary      CanaryConfig
+	Experiments []ExperimentConfig
+	Recording   RecordingConfig
+	CallChat    CallChatConfig
+	WebRTC      WebRTCConfig
+	Maintenance MaintenanceConfig
+	ClientLogs  ClientLogsConfig
+	Summaries   SummaryConfig
+	Digests     DigestConfig
+	Timing      TimingConfig
 }
 
 type ServerConfig struct {
@@ -30,6 +59,17 @@ type ServerConfig struct {
 	UploadsDir   string
 	ReadTimeout  time.Duration
 	WriteTimeout time.Duration
+
+	// TLS is served when both files are set. TLSTerminatedUpstream says a
+	// proxy in front of the server handles TLS instead.
+	TLSCertFile           string
+	TLSKeyFile            string
+	TLSTerminatedUpstream bool
+
+	// TrustedProxies are the addresses (IPs or CIDRs) of reverse proxies in
+	// front of the server. Only they may set X-Forwarded-For and
+	// X-Forwarded-Proto; requests from anywhere else are taken at face value.
+	TrustedProxies []string
 }
 
 type RedisConfig struct {
@@ -39,9 +79,24 @@ type RedisConfig struct {
 	DB       int
 }
 
+// KafkaConfig names the topic of each message class. Direct messages are kept
+// longest; group messages and system notices are archived to Postgres and
+// need not stay in Kafka as long. Missing topics are created with
+// Partitions, ReplicationFactor and their retention.
 type KafkaConfig struct {
 	Address string
-	Topic   string
+	Direct  KafkaTopicConfig // Direct messages and their changes
+	Group   KafkaTopicConfig // Group messages and their changes
+	System  KafkaTopicConfig // System notices, such as new friendships
+
+	Partitions        int
+	ReplicationFactor int
+}
+
+// KafkaTopicConfig is a topic and how long it keeps records
+type KafkaTopicConfig struct {
+	Topic     string
+	Retention time.Duration
 }
 
 type UploadConfig struct {
@@ -49,12 +104,63 @@ type UploadConfig struct {
 	AllowedMimeTypes  []string
 	AllowedExtensions []string
 	IconsDir          string
+
+	// Unreferenced uploads are moved to QuarantineDir by a job running every
+	// GCInterval (0 disables it) and deleted after QuarantinePeriod
+	GCInterval       time.Duration
+	QuarantineDir    string
+	QuarantinePeriod time.Duration
+
+	// Storage keeps content-addressed uploads: "local" under the uploads
+	// directory, or "s3" in a bucket of an S3-compatible service such as
+	// MinIO. Objects in a bucket are served through SignedURLTTL-long
+	// redirects.
+	Storage      string
+	S3Endpoint   string // e.g. https://s3.eu-west-1.amazonaws.com or http://minio:9000
+	S3Region     string
+	S3Bucket     string
+	S3AccessKey  string
+	S3SecretKey  string
+	S3PathStyle  bool // Bucket in the path instead of the host name, as MinIO expects
+	SignedURLTTL time.Duration
+
+	// Each user may have MaxConcurrent uploads in progress on an instance,
+	// read at BytesPerSecond between them (0 for no limit), so one user's
+	// uploads can't slow the server down for everyone
+	MaxConcurrent  int
+	BytesPerSecond int64
+
+	// UserQuota bounds the bytes of uploads a user's icon, emoji and group
+	// icons refer to (0 for no limit). An object is counted once however
+	// often it is referred to, and is free to users already using it.
+	UserQuota int64
 }
 
 type SessionConfig struct {
 	TTL             time.Duration
 	CookieName      string
 	UpdateThreshold time.Duration // Minimum time between session updates
+
+	// DurableFallback also stores sessions in Postgres, read when Redis misses
+	DurableFallback bool
+
+	// LocalCacheSize is the number of sessions each instance keeps in memory
+	// to stay available while Redis is down
+	LocalCacheSize int
+}
+
+// WebSocketConfig bounds what is buffered for slow WebSocket clients
+type WebSocketConfig struct {
+	SendBuffer int    // Messages buffered per client
+	DropPolicy string // When the buffer is full: "drop-new", "drop-oldest", "disconnect" or "spill"
+	DropLimit  int    // Drops in a row before "disconnect" and "spill" close the connection
+
+	// WriteTimeout is how long a frame has to reach the client. A write that
+	// takes longer than SlowWrite counts as slow; clients are disconnected
+	// after SlowWriteLimit slow writes in a row, or one that times out.
+	WriteTimeout   time.Duration
+	SlowWrite      time.Duration
+	SlowWriteLimit int
 }
 
 type RateLimitConfig struct {
@@ -76,6 +182,217 @@ type LogConfig struct {
 	Level      string // "DEBUG", "INFO", "WARN", "ERROR"
 }
 
+// EgressConfig controls outbound HTTP traffic (Kafka and Redis are not affected)
+type EgressConfig struct {
+	ProxyURL     string   // HTTP(S)/SOCKS5 proxy for all outbound HTTP requests
+	NoProxy      []string // Destinations that bypass the proxy
+	AllowedHosts []string // Destination allowlist; empty allows everything
+
+	// Outbound integration destinations, checked against AllowedHosts at startup
+	WebhookURLs    []string
+	PushGatewayURL string
+}
+
+// Message backends
+const (
+	MessageBackendRedis  = "redis"  // Redis, Postgres and Kafka
+	MessageBackendMemory = "memory" // This process only; history is lost on restart
+)
+
+// MessagesConfig bounds message content so Redis and Kafka entries stay small
+type MessagesConfig struct {
+	Backend string // Where messages are kept: "redis" or "memory"
+
+	MaxLength int  // Characters per message
+	Chunking  bool // Split oversized text messages into numbered parts instead of rejecting them
+	MaxChunks int  // Parts a split message may produce
+
+	// RequestLimit is how many messages a non-friend may send before the
+	// recipient replies or accepts their message request
+	RequestLimit int
+}
+
+// GroupsConfig controls how people join groups
+type GroupsConfig struct {
+	// DirectAdd lets admins add members without inviting them, as before
+	// invitations existed
+	DirectAdd bool
+}
+
+// SearchConfig tunes the message search index
+type SearchConfig struct {
+	// BackfillRate is how many Kafka records per second the backfill applies
+	BackfillRate int
+}
+
+// ExportConfig configures conversation exports
+type ExportConfig struct {
+	PDFRendererURL string        // Gotenberg-compatible HTML-to-PDF service; empty disables PDF exports
+	PDFTimeout     time.Duration // Time allowed for rendering one PDF
+	MaxMessages    int           // Messages per export; older messages are left out
+}
+
+// PasswordConfig sets the bcrypt work factor for new password hashes
+type PasswordConfig struct {
+	Cost int // bcrypt cost for new hashes
+
+	// Calibrate measures the hash time at startup: "off", "warn" to log when
+	// hashing is slower than TargetLatency, or "adjust" to also lower the cost
+	// (never below MinCost) until it is not
+	Calibrate     string
+	TargetLatency time.Duration
+	MinCost       int
+}
+
+// ModerationConfig sets when abuse reports restrict an account
+type ModerationConfig struct {
+	// ReportThreshold distinct reporters within ReportWindow restrict the
+	// reported account for RestrictionDuration, pending moderator review
+	ReportThreshold     int
+	ReportWindow        time.Duration
+	RestrictionDuration time.Duration
+}
+
+// CanaryConfig routes some traffic to canary handler implementations
+type CanaryConfig struct {
+	Percent int      // Share of users served by canary handlers, 0-100
+	Testers []string // Usernames that may pick a variant with the X-Canary header
+}
+
+// ExperimentConfig defines an A/B experiment. Users are split between the
+// buckets by a hash of their user ID, each bucket taking its weight out of 100.
+type ExperimentConfig struct {
+	Name    string
+	Buckets []string
+	Weights []int
+}
+
+// RecordingConfig controls call recording. Both parties of a call must
+// consent before the recorder is started.
+type RecordingConfig struct {
+	// Policy says who may ask to record a call: "off", "everyone", or
+	// "roles" for users with one of Roles
+	Policy string
+	Roles  []string
+
+	RecorderURL string        // Recorder service; required unless Policy is "off"
+	Timeout     time.Duration // Time allowed for one recorder request
+	TokenTTL    time.Duration // Lifetime of the token handed to the recorder
+}
+
+// CallChatConfig controls text chat during calls
+type CallChatConfig struct {
+	TTL            time.Duration // How long in-call messages are kept
+	ToConversation bool          // Append in-call messages to the conversation when the call ends
+}
+
+// WebRTCConfig lists the ICE servers handed to clients for calls. TURN
+// credentials are derived from a secret shared with the TURN server, as
+// coturn does with use-auth-secret.
+type WebRTCConfig struct {
+	STUNURLs      []string      // stun: or stuns: URLs; public servers by default
+	TURNURLs      []string      // turn: or turns: URLs; TURN is off without them
+	TURNSecret    string        // coturn static-auth-secret, never sent to clients
+	CredentialTTL time.Duration // Lifetime of the TURN credentials handed out
+}
+
+// MaintenanceConfig controls maintenance window announcements
+type MaintenanceConfig struct {
+	// AnnounceAt lists how long before a window starts the countdown is
+	// pushed to clients
+	AnnounceAt []time.Duration
+}
+
+// TimingConfig controls per-stage timing of requests, used to find which
+// stage of a slow request is over the latency budget
+type TimingConfig struct {
+	Headers    bool          // Send the breakdown in a Server-Timing header; for debugging and load tests
+	SampleRate float64       // Share of requests whose breakdown is logged, 0-1
+	Budget     time.Duration // Latency target; slower requests are always logged
+}
+
+// ClientLogsConfig controls ingestion of client-side error reports
+type ClientLogsConfig struct {
+	SampleRate float64 // Share of reports kept, 0-1; call failures are always kept
+	PerMinute  int     // Batches accepted per user per minute
+	MaxStored  int     // Recent reports kept in Redis for the admin view
+}
+
+// SummaryConfig controls AI summaries of conversations. They are off for the
+// whole organization unless Enabled, since transcripts leave the server.
+type SummaryConfig struct {
+	Enabled     bool
+	ProviderURL string        // OpenAI-compatible chat completions endpoint
+	APIKey      string        // Provider API key, never sent to clients
+	Model       string        // Model named in provider requests
+	Timeout     time.Duration // Time allowed for one provider request
+	MaxMessages int           // Latest messages of a conversation summarized
+	MaxLength   int           // Longest summary returned, in characters
+	CacheTTL    time.Duration // How long a summary is reused while its messages are unchanged
+	PerMinute   int           // Summaries a user can request per minute
+}
+
+// DigestConfig controls daily emails listing what users missed while they
+// were not connected. They are off unless Enabled, which needs a mail server.
+type DigestConfig struct {
+	Enabled       bool
+	InactiveAfter time.Duration // Time without a connection before digests are sent
+	Interval      time.Duration // How often each instance looks for due digests
+	BaseURL       string        // Public URL of the app, for links in digests
+	SMTPAddr      string        // Mail server as host:port
+	SMTPUsername  string        // Mail server login; empty to send without one
+	SMTPPassword  string        // Mail server password
+	From          string        // Sender address of digests
+}
+
+// BotsConfig selects the built-in bots to run
+type BotsConfig struct {
+	Enabled []string // Bot usernames, e.g. "echobot"
+}
+
+// Features that a deployment can turn off
+const (
+	FeatureCalls   = "calls"
+	FeatureGroups  = "groups"
+	FeatureUploads = "uploads"
+)
+
+// Features lists every feature name FEATURES_DISABLED accepts
+var Features = []string{FeatureCalls, FeatureGroups, FeatureUploads}
+
+// FeaturesConfig turns off features a deployment does not want. Their routes
+// are not registered, their background workers do not start and the UI hides
+// them.
+type FeaturesConfig struct {
+	Disabled []string // Feature names, e.g. "calls"
+}
+
+// Enabled reports whether the feature is available
+func (f FeaturesConfig) Enabled(feature string) bool {
+	return !slices.Contains(f.Disabled, feature)
+}
+
+// GifConfig configures the server-side GIF search proxy
+type GifConfig struct {
+	Provider string        // "giphy" or "tenor"; empty disables GIF search
+	APIKey   string        // Provider API key, never sent to clients
+	Rating   string        // Maximum content rating: "g", "pg", "pg-13" or "r"
+	CacheTTL time.Duration // How long search results are cached in Redis
+	Limit    int           // Results per page
+
+	// SearchesPerMinute is the per-user search rate limit
+	SearchesPerMinute int
+}
+
+// HTTPClientConfig returns the outbound HTTP client configuration for this egress policy
+func (e EgressConfig) HTTPClientConfig() httpclient.Config {
+	cfg := httpclient.ConfigDefault
+	cfg.ProxyURL = e.ProxyURL
+	cfg.NoProxy = e.NoProxy
+	cfg.AllowedHosts = e.AllowedHosts
+	return cfg
+}
+
 // getProjectRoot finds the project root by looking for go.mod
 func getProjectRoot() (string, error) {
 	dir, err := os.Getwd()
@@ -147,6 +464,11 @@ func Load() (*Config, error) {
 		return nil, fmt.Errorf("failed to resolve icons directory: %w", err)
 	}
 
+	quarantineDir, err := resolvePath(getEnv("UPLOAD_QUARANTINE_DIR", "./server/quarantine"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve upload quarantine directory: %w", err)
+	}
+
 	logFile, err := resolvePath(getEnv("LOG_FILE", "./log/server.log"))
 	if err != nil {
 		return nil, fmt.Errorf("failed to resolve log file path: %w", err)
@@ -162,6 +484,11 @@ func Load() (*Config, error) {
 			ScriptsDir:   scriptsDir,
 			ReadTimeout:  getEnvAsDuration("READ_TIMEOUT", 5*time.Minute),
 			WriteTimeout: 0, // No write timeout by default (needed for SSE)
+
+			TLSCertFile:           getEnv("TLS_CERT_FILE", ""),
+			TLSKeyFile:            getEnv("TLS_KEY_FILE", ""),
+			TLSTerminatedUpstream: getEnvAsBool("TLS_TERMINATED_UPSTREAM", false),
+			TrustedProxies:        getEnvAsList("TRUSTED_PROXIES"),
 		},
 		Redis: RedisConfig{
 			Address:  getEnv("REDIS_ADDR", "localhost:6379"),
@@ -171,7 +498,20 @@ func Load() (*Config, error) {
 		},
 		Kafka: KafkaConfig{
 			Address: getEnv("KAFKA_ADDR", "localhost:9092"),
-			Topic:   getEnv("KAFKA_TOPIC", "chat-history"),
+			Direct: KafkaTopicConfig{
+				Topic:     getEnv("KAFKA_TOPIC", "chat-history"),
+				Retention: getEnvAsDuration("KAFKA_RETENTION", 365*24*time.Hour),
+			},
+			Group: KafkaTopicConfig{
+				Topic:     getEnv("KAFKA_GROUP_TOPIC", "chat-history-group"),
+				Retention: getEnvAsDuration("KAFKA_GROUP_RETENTION", 30*24*time.Hour),
+			},
+			System: KafkaTopicConfig{
+				Topic:     getEnv("KAFKA_SYSTEM_TOPIC", "chat-system"),
+				Retention: getEnvAsDuration("KAFKA_SYSTEM_RETENTION", 24*time.Hour),
+			},
+			Partitions:        getEnvAsInt("KAFKA_PARTITIONS", 6),
+			ReplicationFactor: getEnvAsInt("KAFKA_REPLICATION_FACTOR", 1),
 		},
 		Upload: UploadConfig{
 			MaxFileSize: getEnvAsInt64("MAX_FILE_SIZE", 5*1024*1024), // 5MB
@@ -189,11 +529,39 @@ func Load() (*Config, error) {
 				".webp",
 			},
 			IconsDir: iconsDir,
+
+			GCInterval:       getEnvAsDuration("UPLOAD_GC_INTERVAL", 6*time.Hour),
+			QuarantineDir:    quarantineDir,
+			QuarantinePeriod: time.Duration(getEnvAsInt("UPLOAD_QUARANTINE_DAYS", 7)) * 24 * time.Hour,
+
+			Storage:      strings.ToLower(getEnv("UPLOAD_STORAGE", "local")),
+			S3Endpoint:   getEnv("UPLOAD_S3_ENDPOINT", ""),
+			S3Region:     getEnv("UPLOAD_S3_REGION", "us-east-1"),
+			S3Bucket:     getEnv("UPLOAD_S3_BUCKET", ""),
+			S3AccessKey:  getEnv("UPLOAD_S3_ACCESS_KEY", ""),
+			S3SecretKey:  getEnv("UPLOAD_S3_SECRET_KEY", ""),
+			S3PathStyle:  getEnvAsBool("UPLOAD_S3_PATH_STYLE", false),
+			SignedURLTTL: getEnvAsDuration("UPLOAD_SIGNED_URL_TTL", 15*time.Minute),
+
+			MaxConcurrent:  getEnvAsInt("UPLOAD_MAX_CONCURRENT", 2),
+			BytesPerSecond: getEnvAsInt64("UPLOAD_BYTES_PER_SECOND", 2*1024*1024), // 2MB/s
+			UserQuota:      getEnvAsInt64("UPLOAD_USER_QUOTA", 50*1024*1024),      // 50MB
 		},
 		Session: SessionConfig{
 			TTL:             getEnvAsDuration("SESSION_TTL", 24*time.Hour),
 			CookieName:      getEnv("SESSION_COOKIE_NAME", "session_id"),
 			UpdateThreshold: getEnvAsDuration("SESSION_UPDATE_THRESHOLD", 60*time.Second),
+			DurableFallback: getEnvAsBool("SESSION_DURABLE_FALLBACK", false),
+			LocalCacheSize:  getEnvAsInt("SESSION_LOCAL_CACHE_SIZE", 10000),
+		},
+		WebSocket: WebSocketConfig{
+			SendBuffer: getEnvAsInt("WS_SEND_BUFFER", 256),
+			DropPolicy: strings.ToLower(getEnv("WS_DROP_POLICY", "drop-new")),
+			DropLimit:  getEnvAsInt("WS_DROP_LIMIT", 1),
+
+			WriteTimeout:   getEnvAsDuration("WS_WRITE_TIMEOUT", 10*time.Second),
+			SlowWrite:      getEnvAsDuration("WS_SLOW_WRITE", 2*time.Second),
+			SlowWriteLimit: getEnvAsInt("WS_SLOW_WRITE_LIMIT", 5),
 		},
 		RateLimit: RateLimitConfig{
 			Capacity:     getEnvAsInt64("RATE_LIMIT_CAPACITY", 200),
@@ -211,12 +579,130 @@ func Load() (*Config, error) {
 			Compress:   getEnvAsBool("LOG_COMPRESS", true),
 			Level:      getEnv("LOG_LEVEL", "INFO"),
 		},
+		Egress: EgressConfig{
+			ProxyURL:       getEnv("OUTBOUND_PROXY", ""),
+			NoProxy:        getEnvAsList("OUTBOUND_NO_PROXY"),
+			AllowedHosts:   getEnvAsList("OUTBOUND_ALLOWED_HOSTS"),
+			WebhookURLs:    getEnvAsList("WEBHOOK_URLS"),
+			PushGatewayURL: getEnv("PUSH_GATEWAY_URL", ""),
+		},
+		Bots: BotsConfig{
+			Enabled: getEnvAsList("BOTS_ENABLED"),
+		},
+		Features: FeaturesConfig{
+			Disabled: getEnvAsList("FEATURES_DISABLED"),
+		},
+		Gifs: GifConfig{
+			Provider: strings.ToLower(getEnv("GIF_PROVIDER", "")),
+			APIKey:   getEnv("GIF_API_KEY", ""),
+			Rating:   strings.ToLower(getEnv("GIF_RATING", "g")),
+			CacheTTL: getEnvAsDuration("GIF_CACHE_TTL", 10*time.Minute),
+			Limit:    getEnvAsInt("GIF_LIMIT", 24),
+
+			SearchesPerMinute: getEnvAsInt("GIF_SEARCHES_PER_MINUTE", 30),
+		},
+		Export: ExportConfig{
+			PDFRendererURL: getEnv("EXPORT_PDF_RENDERER_URL", ""),
+			PDFTimeout:     getEnvAsDuration("EXPORT_PDF_TIMEOUT", 60*time.Second),
+			MaxMessages:    getEnvAsInt("EXPORT_MAX_MESSAGES", 5000),
+		},
+		Passwords: PasswordConfig{
+			Cost:          getEnvAsInt("BCRYPT_COST", bcrypt.DefaultCost),
+			Calibrate:     strings.ToLower(getEnv("BCRYPT_CALIBRATE", "off")),
+			TargetLatency: getEnvAsDuration("BCRYPT_TARGET_LATENCY", 250*time.Millisecond),
+			MinCost:       getEnvAsInt("BCRYPT_MIN_COST", bcrypt.DefaultCost),
+		},
+		Moderation: ModerationConfig{
+			ReportThreshold:     getEnvAsInt("MODERATION_REPORT_THRESHOLD", 3),
+			ReportWindow:        getEnvAsDuration("MODERATION_REPORT_WINDOW", 24*time.Hour),
+			RestrictionDuration: getEnvAsDuration("MODERATION_RESTRICTION_DURATION", 72*time.Hour),
+		},
+		Canary: CanaryConfig{
+			Percent: getEnvAsInt("CANARY_PERCENT", 0),
+			Testers: getEnvAsList("CANARY_TESTERS"),
+		},
+		Experiments: getEnvAsExperiments("EXPERIMENTS"),
+		Recording: RecordingConfig{
+			Policy:      strings.ToLower(getEnv("CALL_RECORDING_POLICY", "off")),
+			Roles:       getEnvAsList("CALL_RECORDING_ROLES"),
+			RecorderURL: getEnv("CALL_RECORDER_URL", ""),
+			Timeout:     getEnvAsDuration("CALL_RECORDER_TIMEOUT", 10*time.Second),
+			TokenTTL:    getEnvAsDuration("CALL_RECORDING_TOKEN_TTL", 4*time.Hour),
+		},
+		Maintenance: MaintenanceConfig{
+			AnnounceAt: getEnvAsDurationList("MAINTENANCE_ANNOUNCE_AT", []time.Duration{
+				time.Hour, 30 * time.Minute, 10 * time.Minute, 5 * time.Minute, time.Minute,
+			}),
+		},
+		ClientLogs: ClientLogsConfig{
+			SampleRate: getEnvAsFloat("CLIENT_LOGS_SAMPLE_RATE", 1),
+			PerMinute:  getEnvAsInt("CLIENT_LOGS_PER_MINUTE", 12),
+			MaxStored:  getEnvAsInt("CLIENT_LOGS_MAX_STORED", 1000),
+		},
+		Timing: TimingConfig{
+			Headers:    getEnvAsBool("TIMING_HEADERS", false),
+			SampleRate: getEnvAsFloat("TIMING_SAMPLE_RATE", 0.01),
+			Budget:     getEnvAsDuration("TIMING_BUDGET", 500*time.Millisecond),
+		},
+		Summaries: SummaryConfig{
+			Enabled:     getEnvAsBool("SUMMARIES_ENABLED", false),
+			ProviderURL: getEnv("SUMMARY_PROVIDER_URL", ""),
+			APIKey:      getEnv("SUMMARY_API_KEY", ""),
+			Model:       getEnv("SUMMARY_MODEL", ""),
+			Timeout:     getEnvAsDuration("SUMMARY_TIMEOUT", 30*time.Second),
+			MaxMessages: getEnvAsInt("SUMMARY_MAX_MESSAGES", 100),
+			MaxLength:   getEnvAsInt("SUMMARY_MAX_LENGTH", 1200),
+			CacheTTL:    getEnvAsDuration("SUMMARY_CACHE_TTL", time.Hour),
+			PerMinute:   getEnvAsInt("SUMMARIES_PER_MINUTE", 5),
+		},
+		Digests: DigestConfig{
+			Enabled:       getEnvAsBool("DIGESTS_ENABLED", false),
+			InactiveAfter: getEnvAsDuration("DIGEST_INACTIVE_AFTER", 24*time.Hour),
+			Interval:      getEnvAsDuration("DIGEST_INTERVAL", 15*time.Minute),
+			BaseURL:       strings.TrimRight(getEnv("DIGEST_BASE_URL", ""), "/"),
+			SMTPAddr:      getEnv("SMTP_ADDR", ""),
+			SMTPUsername:  getEnv("SMTP_USERNAME", ""),
+			SMTPPassword:  getEnv("SMTP_PASSWORD", ""),
+			From:          getEnv("DIGEST_FROM", ""),
+		},
+		CallChat: CallChatConfig{
+			TTL:            getEnvAsDuration("CALL_CHAT_TTL", 2*time.Hour),
+			ToConversation: getEnvAsBool("CALL_CHAT_TO_CONVERSATION", true),
+		},
+		WebRTC: WebRTCConfig{
+			STUNURLs:      getEnvAsListDefault("WEBRTC_STUN_URLS", []string{"stun:stun.l.google.com:19302", "stun:stun1.l.google.com:19302"}),
+			TURNURLs:      getEnvAsList("WEBRTC_TURN_URLS"),
+			TURNSecret:    getEnv("WEBRTC_TURN_SECRET", ""),
+			CredentialTTL: getEnvAsDuration("WEBRTC_CREDENTIAL_TTL", 6*time.Hour),
+		},
+		Messages: MessagesConfig{
+			Backend:      strings.ToLower(getEnv("MESSAGE_BACKEND", MessageBackendRedis)),
+			MaxLength:    getEnvAsInt("MESSAGE_MAX_LENGTH", 4000),
+			Chunking:     getEnvAsBool("MESSAGE_CHUNKING", false),
+			MaxChunks:    getEnvAsInt("MESSAGE_MAX_CHUNKS", 10),
+			RequestLimit: getEnvAsInt("MESSAGE_REQUEST_LIMIT", 3),
+		},
+		Groups: GroupsConfig{
+			DirectAdd: getEnvAsBool("GROUP_DIRECT_ADD", false),
+		},
+		Search: SearchConfig{
+			BackfillRate: getEnvAsInt("SEARCH_BACKFILL_RATE", 200),
+		},
 	}
 
 	return cfg, cfg.Validate()
 }
 
+// Validate fails when Lint finds errors; warnings do not fail it
 func (c *Config) Validate() error {
+	if errors := c.validationErrors(); len(errors) > 0 {
+		return fmt.Errorf("configuration validation failed:\n  - %s", joinErrors(errors))
+	}
+	return nil
+}
+
+// validationErrors lists the settings the application cannot run with
+func (c *Config) validationErrors() []string {
 	var errors []string
 
 	// Server validation
@@ -229,6 +715,14 @@ func (c *Config) Validate() error {
 	if c.Server.UploadsDir == "" {
 		errors = append(errors, "uploads directory (UPLOADS_DIR) is required")
 	}
+	if (c.Server.TLSCertFile == "") != (c.Server.TLSKeyFile == "") {
+		errors = append(errors, "TLS needs both a certificate (TLS_CERT_FILE) and a key (TLS_KEY_FILE)")
+	}
+	for _, proxy := range c.Server.TrustedProxies {
+		if !isIPOrCIDR(proxy) {
+			errors = append(errors, fmt.Sprintf("invalid trusted proxy %q in TRUSTED_PROXIES (must be an IP address or CIDR)", proxy))
+		}
+	}
 
 	// Redis validation
 	if c.Redis.Address == "" {
@@ -242,8 +736,32 @@ func (c *Config) Validate() error {
 	if c.Kafka.Address == "" {
 		errors = append(errors, "kafka address (KAFKA_ADDR) is required")
 	}
-	if c.Kafka.Topic == "" {
-		errors = append(errors, "kafka topic (KAFKA_TOPIC) is required")
+	kafkaTopics := []struct {
+		topic KafkaTopicConfig
+		env   string
+	}{
+		{c.Kafka.Direct, "KAFKA"},
+		{c.Kafka.Group, "KAFKA_GROUP"},
+		{c.Kafka.System, "KAFKA_SYSTEM"},
+	}
+	seenTopics := make(map[string]bool)
+	for _, t := range kafkaTopics {
+		switch {
+		case t.topic.Topic == "":
+			errors = append(errors, fmt.Sprintf("kafka topic (%s_TOPIC) is required", t.env))
+		case seenTopics[t.topic.Topic]:
+			errors = append(errors, fmt.Sprintf("kafka topic (%s_TOPIC) %q is already used by another message class", t.env, t.topic.Topic))
+		}
+		seenTopics[t.topic.Topic] = true
+		if t.topic.Retention < time.Hour {
+			errors = append(errors, fmt.Sprintf("invalid kafka retention (%s_RETENTION): %s (must be at least 1h)", t.env, t.topic.Retention))
+		}
+	}
+	if c.Kafka.Partitions < 1 {
+		errors = append(errors, fmt.Sprintf("invalid kafka partitions (KAFKA_PARTITIONS): %d (must be at least 1)", c.Kafka.Partitions))
+	}
+	if c.Kafka.ReplicationFactor < 1 {
+		errors = append(errors, fmt.Sprintf("invalid kafka replication factor (KAFKA_REPLICATION_FACTOR): %d (must be at least 1)", c.Kafka.ReplicationFactor))
 	}
 
 	// Database validation
@@ -261,6 +779,44 @@ func (c *Config) Validate() error {
 	if c.Upload.IconsDir == "" {
 		errors = append(errors, "icons directory (ICONS_DIR) is required")
 	}
+	if c.Upload.GCInterval < 0 {
+		errors = append(errors, "upload GC interval (UPLOAD_GC_INTERVAL) cannot be negative")
+	}
+	if c.Upload.MaxConcurrent <= 0 {
+		errors = append(errors, "concurrent uploads per user (UPLOAD_MAX_CONCURRENT) must be > 0")
+	}
+	if c.Upload.BytesPerSecond < 0 {
+		errors = append(errors, "upload rate (UPLOAD_BYTES_PER_SECOND) cannot be negative")
+	}
+	if c.Upload.UserQuota < 0 {
+		errors = append(errors, "upload quota per user (UPLOAD_USER_QUOTA) cannot be negative")
+	}
+	if c.Upload.GCInterval > 0 {
+		if c.Upload.QuarantinePeriod < 24*time.Hour {
+			errors = append(errors, "upload quarantine period (UPLOAD_QUARANTINE_DAYS) must be at least 1 day")
+		}
+		if rel, err := filepath.Rel(c.Server.UploadsDir, c.Upload.QuarantineDir); err == nil && !strings.HasPrefix(rel, "..") {
+			errors = append(errors, "upload quarantine directory (UPLOAD_QUARANTINE_DIR) must be outside the uploads directory, which is served publicly")
+		}
+	}
+	switch c.Upload.Storage {
+	case "local":
+	case "s3":
+		if c.Upload.S3Endpoint == "" || c.Upload.S3Bucket == "" {
+			errors = append(errors, "S3 upload storage requires an endpoint (UPLOAD_S3_ENDPOINT) and a bucket (UPLOAD_S3_BUCKET)")
+		} else if err := httpclient.CheckDestination(c.Egress.AllowedHosts, c.Upload.S3Endpoint); err != nil {
+			errors = append(errors, fmt.Sprintf("upload storage %s (UPLOAD_S3_ENDPOINT): %v%s", c.Upload.S3Endpoint, err, allowlistHint(err)))
+		}
+		if c.Upload.S3AccessKey == "" || c.Upload.S3SecretKey == "" {
+			errors = append(errors, "S3 upload storage requires credentials (UPLOAD_S3_ACCESS_KEY, UPLOAD_S3_SECRET_KEY)")
+		}
+		// Presigned URLs cannot outlive a week
+		if c.Upload.SignedURLTTL <= 0 || c.Upload.SignedURLTTL > 7*24*time.Hour {
+			errors = append(errors, fmt.Sprintf("upload signed URL lifetime (UPLOAD_SIGNED_URL_TTL) must be > 0 and at most 7 days, got %s", c.Upload.SignedURLTTL))
+		}
+	default:
+		errors = append(errors, fmt.Sprintf("invalid upload storage (UPLOAD_STORAGE): %q (must be local or s3)", c.Upload.Storage))
+	}
 
 	// Session validation
 	if c.Session.TTL <= 0 {
@@ -272,6 +828,31 @@ func (c *Config) Validate() error {
 	if c.Session.UpdateThreshold <= 0 {
 		errors = append(errors, "session update threshold must be > 0")
 	}
+	if c.Session.LocalCacheSize < 1 {
+		errors = append(errors, fmt.Sprintf("invalid session cache size (SESSION_LOCAL_CACHE_SIZE): %d (must be >= 1)", c.Session.LocalCacheSize))
+	}
+
+	// WebSocket validation
+	if c.WebSocket.SendBuffer < 16 || c.WebSocket.SendBuffer > 65536 {
+		errors = append(errors, fmt.Sprintf("invalid WebSocket send buffer (WS_SEND_BUFFER): %d (must be 16-65536)", c.WebSocket.SendBuffer))
+	}
+	switch c.WebSocket.DropPolicy {
+	case "drop-new", "drop-oldest", "disconnect", "spill":
+	default:
+		errors = append(errors, fmt.Sprintf("invalid WebSocket drop policy (WS_DROP_POLICY): %q (must be drop-new, drop-oldest, disconnect or spill)", c.WebSocket.DropPolicy))
+	}
+	if c.WebSocket.DropLimit < 1 || c.WebSocket.DropLimit > 1000 {
+		errors = append(errors, fmt.Sprintf("invalid WebSocket drop limit (WS_DROP_LIMIT): %d (must be 1-1000)", c.WebSocket.DropLimit))
+	}
+	if c.WebSocket.WriteTimeout < time.Second || c.WebSocket.WriteTimeout > time.Minute {
+		errors = append(errors, fmt.Sprintf("invalid WebSocket write timeout (WS_WRITE_TIMEOUT): %s (must be 1s-1m)", c.WebSocket.WriteTimeout))
+	}
+	if c.WebSocket.SlowWrite <= 0 || c.WebSocket.SlowWrite >= c.WebSocket.WriteTimeout {
+		errors = append(errors, fmt.Sprintf("invalid WebSocket slow write (WS_SLOW_WRITE): %s (must be > 0 and below WS_WRITE_TIMEOUT)", c.WebSocket.SlowWrite))
+	}
+	if c.WebSocket.SlowWriteLimit < 1 || c.WebSocket.SlowWriteLimit > 100 {
+		errors = append(errors, fmt.Sprintf("invalid WebSocket slow write limit (WS_SLOW_WRITE_LIMIT): %d (must be 1-100)", c.WebSocket.SlowWriteLimit))
+	}
 
 	// Rate limit validation
 	if c.RateLimit.Capacity <= 0 {
@@ -301,11 +882,277 @@ func (c *Config) Validate() error {
 		errors = append(errors, "log max age (LOG_MAX_AGE) cannot be negative")
 	}
 
-	if len(errors) > 0 {
-		return fmt.Errorf("configuration validation failed:\n  - %s", joinErrors(errors))
+	// Egress validation
+	if c.Egress.ProxyURL != "" {
+		if _, err := httpclient.ParseProxyURL(c.Egress.ProxyURL); err != nil {
+			errors = append(errors, fmt.Sprintf("outbound proxy (OUTBOUND_PROXY): %v", err))
+		}
 	}
 
-	return nil
+	for _, webhook := range c.Egress.WebhookURLs {
+		if err := httpclient.CheckDestination(c.Egress.AllowedHosts, webhook); err != nil {
+			errors = append(errors, fmt.Sprintf("webhook destination %s (WEBHOOK_URLS): %v%s", webhook, err, allowlistHint(err)))
+		}
+	}
+
+	if c.Egress.PushGatewayURL != "" {
+		if err := httpclient.CheckDestination(c.Egress.AllowedHosts, c.Egress.PushGatewayURL); err != nil {
+			errors = append(errors, fmt.Sprintf("push destination %s (PUSH_GATEWAY_URL): %v%s", c.Egress.PushGatewayURL, err, allowlistHint(err)))
+		}
+	}
+
+	// GIF search validation
+	if c.Gifs.Provider != "" {
+		var apiURL string
+		switch c.Gifs.Provider {
+		case "giphy":
+			apiURL = "https://api.giphy.com"
+		case "tenor":
+			apiURL = "https://tenor.googleapis.com"
+		default:
+			errors = append(errors, fmt.Sprintf("invalid GIF provider (GIF_PROVIDER): %q (must be giphy or tenor)", c.Gifs.Provider))
+		}
+
+		if c.Gifs.APIKey == "" {
+			errors = append(errors, "GIF API key (GIF_API_KEY) is required when GIF_PROVIDER is set")
+		}
+
+		if apiURL != "" {
+			if err := httpclient.CheckDestination(c.Egress.AllowedHosts, apiURL); err != nil {
+				errors = append(errors, fmt.Sprintf("GIF provider %s: %v%s", apiURL, err, allowlistHint(err)))
+			}
+		}
+	}
+
+	switch c.Gifs.Rating {
+	case "g", "pg", "pg-13", "r":
+	default:
+		errors = append(errors, fmt.Sprintf("invalid GIF rating (GIF_RATING): %q (must be g, pg, pg-13 or r)", c.Gifs.Rating))
+	}
+
+	if c.Gifs.Limit < 1 || c.Gifs.Limit > 50 {
+		errors = append(errors, fmt.Sprintf("invalid GIF page size (GIF_LIMIT): %d (must be 1-50)", c.Gifs.Limit))
+	}
+
+	if c.Gifs.SearchesPerMinute <= 0 {
+		errors = append(errors, "GIF search rate limit (GIF_SEARCHES_PER_MINUTE) must be positive")
+	}
+
+	// Export validation
+	if c.Export.PDFRendererURL != "" {
+		if err := httpclient.CheckDestination(c.Egress.AllowedHosts, c.Export.PDFRendererURL); err != nil {
+			errors = append(errors, fmt.Sprintf("PDF renderer %s (EXPORT_PDF_RENDERER_URL): %v%s", c.Export.PDFRendererURL, err, allowlistHint(err)))
+		}
+	}
+	if c.Export.PDFTimeout <= 0 {
+		errors = append(errors, "PDF export timeout (EXPORT_PDF_TIMEOUT) must be > 0")
+	}
+	if c.Export.MaxMessages <= 0 {
+		errors = append(errors, "export message limit (EXPORT_MAX_MESSAGES) must be positive")
+	}
+
+	// Call recording validation
+	switch c.Recording.Policy {
+	case "off":
+	case "everyone", "roles":
+		if c.Recording.RecorderURL == "" {
+			errors = append(errors, fmt.Sprintf("call recording policy %q requires a recorder (CALL_RECORDER_URL)", c.Recording.Policy))
+		} else if err := httpclient.CheckDestination(c.Egress.AllowedHosts, c.Recording.RecorderURL); err != nil {
+			errors = append(errors, fmt.Sprintf("call recorder %s (CALL_RECORDER_URL): %v%s", c.Recording.RecorderURL, err, allowlistHint(err)))
+		}
+		if c.Recording.Policy == "roles" && len(c.Recording.Roles) == 0 {
+			errors = append(errors, "call recording policy \"roles\" requires at least one role (CALL_RECORDING_ROLES)")
+		}
+	default:
+		errors = append(errors, fmt.Sprintf("invalid call recording policy (CALL_RECORDING_POLICY): %q (must be off, everyone or roles)", c.Recording.Policy))
+	}
+	if c.Recording.Timeout <= 0 {
+		errors = append(errors, "call recorder timeout (CALL_RECORDER_TIMEOUT) must be > 0")
+	}
+	if c.Recording.TokenTTL <= 0 {
+		errors = append(errors, "call recording token lifetime (CALL_RECORDING_TOKEN_TTL) must be > 0")
+	}
+	if c.CallChat.TTL <= 0 {
+		errors = append(errors, "in-call message lifetime (CALL_CHAT_TTL) must be > 0")
+	}
+
+	// ICE server validation
+	for _, u := range c.WebRTC.STUNURLs {
+		if !strings.HasPrefix(u, "stun:") && !strings.HasPrefix(u, "stuns:") {
+			errors = append(errors, fmt.Sprintf("invalid STUN server (WEBRTC_STUN_URLS): %q (must start with stun: or stuns:)", u))
+		}
+	}
+	for _, u := range c.WebRTC.TURNURLs {
+		if !strings.HasPrefix(u, "turn:") && !strings.HasPrefix(u, "turns:") {
+			errors = append(errors, fmt.Sprintf("invalid TURN server (WEBRTC_TURN_URLS): %q (must start with turn: or turns:)", u))
+		}
+	}
+	if len(c.WebRTC.TURNURLs) > 0 && c.WebRTC.TURNSecret == "" {
+		errors = append(errors, "TURN servers (WEBRTC_TURN_URLS) require a shared secret (WEBRTC_TURN_SECRET)")
+	}
+	if c.WebRTC.CredentialTTL <= 0 {
+		errors = append(errors, "TURN credential lifetime (WEBRTC_CREDENTIAL_TTL) must be > 0")
+	}
+	for _, lead := range c.Maintenance.AnnounceAt {
+		if lead <= 0 {
+			errors = append(errors, fmt.Sprintf("maintenance announcement time (MAINTENANCE_ANNOUNCE_AT) must be > 0, got %s", lead))
+		}
+	}
+	if c.ClientLogs.SampleRate < 0 || c.ClientLogs.SampleRate > 1 {
+		errors = append(errors, fmt.Sprintf("client log sample rate (CLIENT_LOGS_SAMPLE_RATE) must be 0-1, got %g", c.ClientLogs.SampleRate))
+	}
+	if c.Timing.SampleRate < 0 || c.Timing.SampleRate > 1 {
+		errors = append(errors, fmt.Sprintf("request timing sample rate (TIMING_SAMPLE_RATE) must be 0-1, got %g", c.Timing.SampleRate))
+	}
+	if c.Timing.Budget <= 0 {
+		errors = append(errors, "request latency budget (TIMING_BUDGET) must be > 0")
+	}
+	if c.ClientLogs.PerMinute <= 0 {
+		errors = append(errors, "client log rate limit (CLIENT_LOGS_PER_MINUTE) must be > 0")
+	}
+	if c.ClientLogs.MaxStored < 0 {
+		errors = append(errors, "stored client log limit (CLIENT_LOGS_MAX_STORED) must be >= 0")
+	}
+	if c.Summaries.Enabled {
+		if c.Summaries.ProviderURL == "" {
+			errors = append(errors, "summaries (SUMMARIES_ENABLED) require a provider (SUMMARY_PROVIDER_URL)")
+		} else if err := httpclient.CheckDestination(c.Egress.AllowedHosts, c.Summaries.ProviderURL); err != nil {
+			errors = append(errors, fmt.Sprintf("summary provider %s (SUMMARY_PROVIDER_URL): %v%s", c.Summaries.ProviderURL, err, allowlistHint(err)))
+		}
+		if c.Summaries.Model == "" {
+			errors = append(errors, "summaries (SUMMARIES_ENABLED) require a model (SUMMARY_MODEL)")
+		}
+		if c.Summaries.Timeout <= 0 {
+			errors = append(errors, "summary provider timeout (SUMMARY_TIMEOUT) must be > 0")
+		}
+		if c.Summaries.MaxMessages < 1 || c.Summaries.MaxMessages > 500 {
+			errors = append(errors, fmt.Sprintf("invalid summarized messages (SUMMARY_MAX_MESSAGES): %d (must be 1-500)", c.Summaries.MaxMessages))
+		}
+		if c.Summaries.MaxLength < 100 {
+			errors = append(errors, fmt.Sprintf("summary length (SUMMARY_MAX_LENGTH) must be at least 100, got %d", c.Summaries.MaxLength))
+		}
+		if c.Summaries.PerMinute <= 0 {
+			errors = append(errors, "summary rate limit (SUMMARIES_PER_MINUTE) must be > 0")
+		}
+	}
+	if c.Digests.Enabled {
+		if c.Digests.InactiveAfter < time.Hour {
+			errors = append(errors, fmt.Sprintf("digest inactivity (DIGEST_INACTIVE_AFTER) must be at least 1h, got %s", c.Digests.InactiveAfter))
+		}
+		if c.Digests.Interval <= 0 {
+			errors = append(errors, "digest interval (DIGEST_INTERVAL) must be > 0")
+		}
+		if u, err := url.Parse(c.Digests.BaseURL); err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+			errors = append(errors, fmt.Sprintf("digests (DIGESTS_ENABLED) require the public URL of the app (DIGEST_BASE_URL), got %q", c.Digests.BaseURL))
+		}
+		if _, _, err := net.SplitHostPort(c.Digests.SMTPAddr); err != nil {
+			errors = append(errors, fmt.Sprintf("digests (DIGESTS_ENABLED) require a mail server as host:port (SMTP_ADDR), got %q", c.Digests.SMTPAddr))
+		}
+		if _, err := mail.ParseAddress(c.Digests.From); err != nil {
+			errors = append(errors, fmt.Sprintf("invalid digest sender (DIGEST_FROM): %q", c.Digests.From))
+		}
+	}
+
+	for _, feature := range c.Features.Disabled {
+		if !slices.Contains(Features, feature) {
+			errors = append(errors, fmt.Sprintf("unknown feature (FEATURES_DISABLED): %q (must be one of %s)", feature, strings.Join(Features, ", ")))
+		}
+	}
+
+	// Password hashing validation
+	if c.Passwords.Cost < bcrypt.MinCost || c.Passwords.Cost > bcrypt.MaxCost {
+		errors = append(errors, fmt.Sprintf("invalid bcrypt cost (BCRYPT_COST): %d (must be %d-%d)", c.Passwords.Cost, bcrypt.MinCost, bcrypt.MaxCost))
+	}
+	switch c.Passwords.Calibrate {
+	case "off", "warn", "adjust":
+	default:
+		errors = append(errors, fmt.Sprintf("invalid bcrypt calibration mode (BCRYPT_CALIBRATE): %q (must be off, warn or adjust)", c.Passwords.Calibrate))
+	}
+	if c.Passwords.Calibrate != "off" && c.Passwords.TargetLatency <= 0 {
+		errors = append(errors, "bcrypt target latency (BCRYPT_TARGET_LATENCY) must be > 0")
+	}
+	if c.Passwords.Calibrate == "adjust" && (c.Passwords.MinCost < bcrypt.MinCost || c.Passwords.MinCost > c.Passwords.Cost) {
+		errors = append(errors, fmt.Sprintf("invalid minimum bcrypt cost (BCRYPT_MIN_COST): %d (must be %d-%d)", c.Passwords.MinCost, bcrypt.MinCost, c.Passwords.Cost))
+	}
+
+	// Moderation validation
+	if c.Moderation.ReportThreshold < 1 {
+		errors = append(errors, fmt.Sprintf("invalid report threshold (MODERATION_REPORT_THRESHOLD): %d (must be >= 1)", c.Moderation.ReportThreshold))
+	}
+	if c.Moderation.ReportWindow <= 0 {
+		errors = append(errors, "report window (MODERATION_REPORT_WINDOW) must be > 0")
+	}
+	if c.Moderation.RestrictionDuration <= 0 {
+		errors = append(errors, "restriction duration (MODERATION_RESTRICTION_DURATION) must be > 0")
+	}
+
+	// Canary validation
+	if c.Canary.Percent < 0 || c.Canary.Percent > 100 {
+		errors = append(errors, fmt.Sprintf("invalid canary percentage (CANARY_PERCENT): %d (must be 0-100)", c.Canary.Percent))
+	}
+
+	// Experiment validation
+	seenExperiments := make(map[string]bool, len(c.Experiments))
+	for _, exp := range c.Experiments {
+		if !validExperimentName(exp.Name) {
+			errors = append(errors, fmt.Sprintf("invalid experiment name (EXPERIMENTS): %q (letters, digits, '_' and '-' only)", exp.Name))
+			continue
+		}
+		if seenExperiments[exp.Name] {
+			errors = append(errors, fmt.Sprintf("duplicate experiment (EXPERIMENTS): %s", exp.Name))
+		}
+		seenExperiments[exp.Name] = true
+
+		if len(exp.Buckets) < 2 {
+			errors = append(errors, fmt.Sprintf("experiment %s (EXPERIMENTS) needs at least 2 buckets", exp.Name))
+		}
+		seenBuckets := make(map[string]bool, len(exp.Buckets))
+		total := 0
+		for i, bucket := range exp.Buckets {
+			if !validExperimentName(bucket) || seenBuckets[bucket] {
+				errors = append(errors, fmt.Sprintf("experiment %s (EXPERIMENTS) has an invalid or duplicate bucket %q", exp.Name, bucket))
+			}
+			seenBuckets[bucket] = true
+			if exp.Weights[i] < 0 {
+				errors = append(errors, fmt.Sprintf("experiment %s (EXPERIMENTS) has an invalid weight for bucket %s", exp.Name, bucket))
+			}
+			total += exp.Weights[i]
+		}
+		if total != 100 {
+			errors = append(errors, fmt.Sprintf("experiment %s (EXPERIMENTS) bucket weights sum to %d (must be 100)", exp.Name, total))
+		}
+	}
+
+	// Message limits validation
+	switch c.Messages.Backend {
+	case MessageBackendRedis, MessageBackendMemory:
+	default:
+		errors = append(errors, fmt.Sprintf("invalid message backend (MESSAGE_BACKEND): %q (must be redis or memory)", c.Messages.Backend))
+	}
+	if c.Messages.MaxLength < 100 || c.Messages.MaxLength > 100000 {
+		errors = append(errors, fmt.Sprintf("invalid max message length (MESSAGE_MAX_LENGTH): %d (must be 100-100000)", c.Messages.MaxLength))
+	}
+	if c.Messages.Chunking && (c.Messages.MaxChunks < 2 || c.Messages.MaxChunks > 50) {
+		errors = append(errors, fmt.Sprintf("invalid message chunk limit (MESSAGE_MAX_CHUNKS): %d (must be 2-50)", c.Messages.MaxChunks))
+	}
+	if c.Messages.RequestLimit < 1 || c.Messages.RequestLimit > 100 {
+		errors = append(errors, fmt.Sprintf("invalid message request limit (MESSAGE_REQUEST_LIMIT): %d (must be 1-100)", c.Messages.RequestLimit))
+	}
+
+	// Search validation
+	if c.Search.BackfillRate < 1 || c.Search.BackfillRate > 10000 {
+		errors = append(errors, fmt.Sprintf("invalid search backfill rate (SEARCH_BACKFILL_RATE): %d (must be 1-10000)", c.Search.BackfillRate))
+	}
+
+	return errors
+}
+
+// allowlistHint tells the operator how to fix a destination rejected by the egress policy
+func allowlistHint(err error) string {
+	if goerrors.Is(err, httpclient.ErrDestinationNotAllowed) {
+		return " (add the host to OUTBOUND_ALLOWED_HOSTS)"
+	}
+	return ""
 }
 
 func joinErrors(errors []string) string {
@@ -327,11 +1174,91 @@ func (c *Config) ServerAddress() string {
 func (c *Config) PrintSummary() {
 	fmt.Println("Configuration Summary:")
 	fmt.Printf("  Server: %s\n", c.ServerAddress())
+	switch {
+	case c.Server.TLSCertFile != "":
+		fmt.Println("  TLS: enabled")
+	case c.Server.TLSTerminatedUpstream:
+		fmt.Println("  TLS: terminated upstream")
+	}
+	if len(c.Server.TrustedProxies) > 0 {
+		fmt.Printf("  Trusted proxies: %s\n", strings.Join(c.Server.TrustedProxies, ", "))
+	}
 	fmt.Printf("  Redis: %s (DB: %d)\n", c.Redis.Address, c.Redis.DB)
-	fmt.Printf("  Kafka: %s (Topic: %s)\n", c.Kafka.Address, c.Kafka.Topic)
+	fmt.Printf("  Kafka: %s (direct: %s %s, group: %s %s, system: %s %s)\n", c.Kafka.Address,
+		c.Kafka.Direct.Topic, c.Kafka.Direct.Retention,
+		c.Kafka.Group.Topic, c.Kafka.Group.Retention,
+		c.Kafka.System.Topic, c.Kafka.System.Retention)
 	fmt.Printf("  Database: %s\n", maskConnectionString(c.Database.ConnectionString))
-	fmt.Printf("  Session TTL: %s\n", c.Session.TTL)
+	fmt.Printf("  Session TTL: %s (%d cached locally)\n", c.Session.TTL, c.Session.LocalCacheSize)
+	if c.Session.DurableFallback {
+		fmt.Println("  Session Fallback: Postgres")
+	}
+	fmt.Printf("  WebSocket Send Buffer: %d (%s when full)\n", c.WebSocket.SendBuffer, c.WebSocket.DropPolicy)
+	if c.WebSocket.DropPolicy == "disconnect" || c.WebSocket.DropPolicy == "spill" {
+		fmt.Printf("  WebSocket Drop Limit: %d in a row\n", c.WebSocket.DropLimit)
+	}
+	fmt.Printf("  WebSocket Write Timeout: %s (disconnect after %d writes slower than %s)\n",
+		c.WebSocket.WriteTimeout, c.WebSocket.SlowWriteLimit, c.WebSocket.SlowWrite)
 	fmt.Printf("  Upload Max Size: %.2f MB\n", float64(c.Upload.MaxFileSize)/(1024*1024))
+	if c.Upload.BytesPerSecond > 0 {
+		fmt.Printf("  Upload Throttle: %d per user at %.2f MB/s\n", c.Upload.MaxConcurrent, float64(c.Upload.BytesPerSecond)/(1024*1024))
+	} else {
+		fmt.Printf("  Upload Throttle: %d per user\n", c.Upload.MaxConcurrent)
+	}
+	if c.Upload.UserQuota > 0 {
+		fmt.Printf("  Upload Quota: %.2f MB per user\n", float64(c.Upload.UserQuota)/(1024*1024))
+	}
+	if c.Upload.Storage == "s3" {
+		fmt.Printf("  Upload Storage: s3 (%s, bucket %s)\n", c.Upload.S3Endpoint, c.Upload.S3Bucket)
+	}
+	if c.Egress.ProxyURL != "" {
+		fmt.Printf("  Outbound Proxy: %s\n", maskProxyURL(c.Egress.ProxyURL))
+	}
+	if len(c.Egress.AllowedHosts) > 0 {
+		fmt.Printf("  Outbound Allowlist: %s\n", strings.Join(c.Egress.AllowedHosts, ", "))
+	}
+	if c.Messages.Backend == MessageBackendMemory {
+		fmt.Printf("  Message Backend: memory (history is lost on restart)\n")
+	}
+	if c.Messages.Chunking {
+		fmt.Printf("  Max Message Length: %d (split into up to %d parts)\n", c.Messages.MaxLength, c.Messages.MaxChunks)
+	} else {
+		fmt.Printf("  Max Message Length: %d\n", c.Messages.MaxLength)
+	}
+	if c.Groups.DirectAdd {
+		fmt.Printf("  Group Members: added directly or invited\n")
+	}
+	fmt.Printf("  Bcrypt Cost: %d\n", c.Passwords.Cost)
+	fmt.Printf("  Search Backfill Rate: %d records/s\n", c.Search.BackfillRate)
+	if c.Recording.Policy != "off" {
+		fmt.Printf("  Call Recording: %s\n", c.Recording.Policy)
+	}
+	fmt.Printf("  In-Call Chat: kept %s (to conversation: %t)\n", c.CallChat.TTL, c.CallChat.ToConversation)
+	if len(c.WebRTC.TURNURLs) > 0 {
+		fmt.Printf("  TURN: %d servers, credentials last %s\n", len(c.WebRTC.TURNURLs), c.WebRTC.CredentialTTL)
+	}
+	fmt.Printf("  Maintenance Announcements: %v before start\n", c.Maintenance.AnnounceAt)
+	fmt.Printf("  Client Logs: %g sampled, %d batches/min per user\n", c.ClientLogs.SampleRate, c.ClientLogs.PerMinute)
+	fmt.Printf("  Request Timing: %s budget, %g sampled", c.Timing.Budget, c.Timing.SampleRate)
+	if c.Timing.Headers {
+		fmt.Printf(", Server-Timing headers on")
+	}
+	fmt.Println()
+	if c.Summaries.Enabled {
+		fmt.Printf("  Summaries: %s, last %d messages\n", c.Summaries.Model, c.Summaries.MaxMessages)
+	}
+	if c.Digests.Enabled {
+		fmt.Printf("  Digests: after %s inactive, via %s\n", c.Digests.InactiveAfter, c.Digests.SMTPAddr)
+	}
+	if len(c.Features.Disabled) > 0 {
+		fmt.Printf("  Disabled Features: %s\n", strings.Join(c.Features.Disabled, ", "))
+	}
+	if c.Canary.Percent > 0 || len(c.Canary.Testers) > 0 {
+		fmt.Printf("  Canary: %d%% of users, %d testers\n", c.Canary.Percent, len(c.Canary.Testers))
+	}
+	for _, exp := range c.Experiments {
+		fmt.Printf("  Experiment %s: %v %v\n", exp.Name, exp.Buckets, exp.Weights)
+	}
 	fmt.Printf("  Rate Limit: %d requests/%s (capacity: %d)\n",
 		c.RateLimit.RefillRate, c.RateLimit.RefillPeriod, c.RateLimit.Capacity)
 }
@@ -345,6 +1272,18 @@ func maskConnectionString(connStr string) string {
 	return connStr[:20] + "..." + connStr[len(connStr)-10:]
 }
 
+// maskProxyURL hides proxy credentials
+func maskProxyURL(proxyURL string) string {
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return "***"
+	}
+	if u.User != nil {
+		u.User = url.UserPassword("***", "***")
+	}
+	return u.String()
+}
+
 // Helper functions to read environment variables with defaults
 func getEnv(key, defaultVal string) string {
 	if value := os.Getenv(key); value != "" {
@@ -369,6 +1308,14 @@ func getEnvAsInt64(key string, defaultVal int64) int64 {
 	return defaultVal
 }
 
+func getEnvAsFloat(key string, defaultVal float64) float64 {
+	valStr := os.Getenv(key)
+	if val, err := strconv.ParseFloat(valStr, 64); err == nil {
+		return val
+	}
+	return defaultVal
+}
+
 func getEnvAsDuration(key string, defaultVal time.Duration) time.Duration {
 	valStr := os.Getenv(key)
 	if val, err := time.ParseDuration(valStr); err == nil {
@@ -377,6 +1324,106 @@ func getEnvAsDuration(key string, defaultVal time.Duration) time.Duration {
 	return defaultVal
 }
 
+// isIPOrCIDR reports whether s is an IP address or a CIDR range
+func isIPOrCIDR(s string) bool {
+	if net.ParseIP(s) != nil {
+		return true
+	}
+	_, _, err := net.ParseCIDR(s)
+	return err == nil
+}
+
+// getEnvAsList reads a comma-separated list, skipping empty entries
+func getEnvAsList(key string) []string {
+	var list []string
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// getEnvAsListDefault reads a comma-separated list like getEnvAsList,
+// falling back to defaultVal when it has no entries
+func getEnvAsListDefault(key string, defaultVal []string) []string {
+	if list := getEnvAsList(key); len(list) > 0 {
+		return list
+	}
+	return defaultVal
+}
+
+// getEnvAsDurationList reads a comma-separated list of durations, falling
+// back to defaultVal when unset or when any entry doesn't parse
+func getEnvAsDurationList(key string, defaultVal []time.Duration) []time.Duration {
+	items := getEnvAsList(key)
+	if len(items) == 0 {
+		return defaultVal
+	}
+
+	list := make([]time.Duration, 0, len(items))
+	for _, item := range items {
+		val, err := time.ParseDuration(item)
+		if err != nil {
+			return defaultVal
+		}
+		list = append(list, val)
+	}
+	return list
+}
+
+// getEnvAsExperiments reads experiments as a comma-separated list of
+// "name:bucket=weight|bucket=weight". Without weights the buckets are split
+// evenly. Entries that don't parse are kept with a weight of -1 so Validate
+// reports them.
+func getEnvAsExperiments(key string) []ExperimentConfig {
+	var experiments []ExperimentConfig
+	for _, item := range getEnvAsList(key) {
+		name, spec, _ := strings.Cut(item, ":")
+		exp := ExperimentConfig{Name: strings.TrimSpace(name)}
+
+		weighted := false
+		for _, part := range strings.Split(spec, "|") {
+			bucket, weight, hasWeight := strings.Cut(part, "=")
+			w := 0
+			if hasWeight {
+				weighted = true
+				if v, err := strconv.Atoi(strings.TrimSpace(weight)); err == nil {
+					w = v
+				} else {
+					w = -1
+				}
+			}
+			exp.Buckets = append(exp.Buckets, strings.TrimSpace(bucket))
+			exp.Weights = append(exp.Weights, w)
+		}
+
+		if !weighted {
+			for i := range exp.Weights {
+				exp.Weights[i] = 100 / len(exp.Weights)
+			}
+			exp.Weights[0] += 100 % len(exp.Weights)
+		}
+
+		experiments = append(experiments, exp)
+	}
+	return experiments
+}
+
+// validExperimentName reports whether name is safe to use in Redis keys and
+// metric labels
+func validExperimentName(name string) bool {
+	if name == "" || len(name) > 50 {
+		return false
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-') {
+			return false
+		}
+	}
+	return true
+}
+
 func getEnvAsBool(key string, defaultVal bool) bool {
 	valStr := os.Getenv(key)
 	if val, err := strconv.ParseBool(valStr); err == nil {
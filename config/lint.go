@@ -0,0 +1,165 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Severity ranks a lint finding
+type Severity string
+
+const (
+	// SeverityError is a setting the application cannot run with
+	SeverityError Severity = "error"
+
+	// SeverityWarning is a combination of settings that works but is likely
+	// to cause trouble
+	SeverityWarning Severity = "warning"
+)
+
+// Thresholds for the rate limit warning. A notification stream that keeps
+// dropping reconnects every few seconds, and the dashboard polls on top of it.
+const (
+	minRateLimitPerMinute = 30
+	minRateLimitCapacity  = 10
+)
+
+// Finding is one problem with the configuration
+type Finding struct {
+	Severity Severity `json:"severity"`
+	Settings []string `json:"settings,omitempty"` // Environment variables involved
+	Message  string   `json:"message"`
+	Fix      string   `json:"fix,omitempty"`
+}
+
+// LintReport lists what Lint found, errors first
+type LintReport struct {
+	Findings []Finding `json:"findings"`
+}
+
+// Errors returns the findings that prevent startup
+func (r LintReport) Errors() []Finding {
+	return r.filter(SeverityError)
+}
+
+// Warnings returns the findings that are only reported
+func (r LintReport) Warnings() []Finding {
+	return r.filter(SeverityWarning)
+}
+
+func (r LintReport) filter(severity Severity) []Finding {
+	var findings []Finding
+	for _, f := range r.Findings {
+		if f.Severity == severity {
+			findings = append(findings, f)
+		}
+	}
+	return findings
+}
+
+// Print writes the report in a form meant for startup logs
+func (r LintReport) Print(w io.Writer) {
+	if len(r.Findings) == 0 {
+		fmt.Fprintln(w, "Configuration Lint: no problems found")
+		return
+	}
+
+	fmt.Fprintf(w, "Configuration Lint: %d error(s), %d warning(s)\n", len(r.Errors()), len(r.Warnings()))
+	for _, f := range r.Findings {
+		if len(f.Settings) > 0 {
+			fmt.Fprintf(w, "  [%s] %s: %s\n", f.Severity, strings.Join(f.Settings, ", "), f.Message)
+		} else {
+			fmt.Fprintf(w, "  [%s] %s\n", f.Severity, f.Message)
+		}
+		if f.Fix != "" {
+			fmt.Fprintf(w, "      fix: %s\n", f.Fix)
+		}
+	}
+}
+
+// Lint checks the configuration. Errors are what Validate rejects; warnings
+// flag risky combinations the application still starts with.
+func (c *Config) Lint() LintReport {
+	var report LintReport
+	for _, msg := range c.validationErrors() {
+		report.Findings = append(report.Findings, Finding{Severity: SeverityError, Message: msg})
+	}
+	report.Findings = append(report.Findings, c.warnings()...)
+	return report
+}
+
+// warnings lists risky combinations of otherwise valid settings
+func (c *Config) warnings() []Finding {
+	var findings []Finding
+	warn := func(settings []string, message, fix string) {
+		findings = append(findings, Finding{Severity: SeverityWarning, Settings: settings, Message: message, Fix: fix})
+	}
+
+	// The global limit also covers notification stream reconnects
+	if c.RateLimit.RefillRate > 0 && c.RateLimit.RefillPeriod > 0 {
+		perMinute := float64(c.RateLimit.RefillRate) * float64(time.Minute) / float64(c.RateLimit.RefillPeriod)
+		if perMinute < minRateLimitPerMinute || c.RateLimit.Capacity < minRateLimitCapacity {
+			warn([]string{"RATE_LIMIT_CAPACITY", "RATE_LIMIT_REFILL", "RATE_LIMIT_PERIOD"},
+				fmt.Sprintf("the rate limit allows %.0f requests/min with bursts of %d; clients reconnecting to /sse/notifications while the dashboard polls will be throttled", perMinute, c.RateLimit.Capacity),
+				fmt.Sprintf("allow at least %d requests/min and a capacity of at least %d", minRateLimitPerMinute, minRateLimitCapacity))
+		}
+	}
+
+	// A session is only extended when it is used after UpdateThreshold
+	if c.Session.TTL > 0 && c.Session.UpdateThreshold >= c.Session.TTL {
+		warn([]string{"SESSION_TTL", "SESSION_UPDATE_THRESHOLD"},
+			fmt.Sprintf("sessions expire after %s but are only extended after %s, so active users are logged out", c.Session.TTL, c.Session.UpdateThreshold),
+			"set SESSION_UPDATE_THRESHOLD well below SESSION_TTL")
+	}
+
+	if c.Server.UploadsDir != "" && c.Server.StaticDir != "" {
+		if rel, err := filepath.Rel(c.Server.StaticDir, c.Server.UploadsDir); err == nil && !strings.HasPrefix(rel, "..") {
+			warn([]string{"UPLOADS_DIR", "STATIC_DIR"},
+				"the uploads directory is inside the static directory, so uploads are also served under /static with a day-long cache and stay reachable after they are deleted or quarantined",
+				"move UPLOADS_DIR outside STATIC_DIR")
+		}
+	}
+
+	if c.Upload.Storage == "s3" && c.Upload.GCInterval > 0 {
+		warn([]string{"UPLOAD_STORAGE", "UPLOAD_GC_INTERVAL"},
+			"the upload collector only scans the uploads directory, so unreferenced objects in the S3 bucket are never deleted",
+			"add a lifecycle rule to the bucket, or set UPLOAD_GC_INTERVAL=0 to silence this warning")
+	}
+
+	if c.Server.TLSCertFile == "" && !c.Server.TLSTerminatedUpstream && !isLoopback(c.Server.Host) {
+		warn([]string{"SERVER_HOST", "TLS_CERT_FILE", "TLS_KEY_FILE"},
+			fmt.Sprintf("the server listens on %s without TLS, so session cookies and messages cross the network in clear text", c.Server.Host),
+			"set TLS_CERT_FILE and TLS_KEY_FILE, bind SERVER_HOST to 127.0.0.1 behind a proxy, or set TLS_TERMINATED_UPSTREAM=true if a proxy already handles TLS")
+	}
+
+	if c.Server.TLSTerminatedUpstream && len(c.Server.TrustedProxies) == 0 {
+		warn([]string{"TLS_TERMINATED_UPSTREAM", "TRUSTED_PROXIES"},
+			"a proxy terminates TLS but none is trusted, so rate limits and logs see the proxy's address instead of the client's",
+			"set TRUSTED_PROXIES to the proxy's address or network")
+	}
+
+	for _, proxy := range c.Server.TrustedProxies {
+		if _, network, err := net.ParseCIDR(proxy); err == nil {
+			if ones, _ := network.Mask.Size(); ones == 0 {
+				warn([]string{"TRUSTED_PROXIES"},
+					fmt.Sprintf("%s trusts every address, so any client can choose the IP it is rate limited and logged as", proxy),
+					"list only the networks your proxies connect from")
+			}
+		}
+	}
+
+	return findings
+}
+
+// isLoopback reports whether host only accepts local connections
+func isLoopback(host string) bool {
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
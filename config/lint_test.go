@@ -0,0 +1,122 @@
+package config
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func lintBase() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Host:       "127.0.0.1",
+			StaticDir:  "/srv/app/static",
+			UploadsDir: "/srv/app/uploads",
+		},
+		Session:   SessionConfig{TTL: 24 * time.Hour, UpdateThreshold: time.Minute},
+		RateLimit: RateLimitConfig{Capacity: 200, RefillRate: 10, RefillPeriod: time.Second},
+	}
+}
+
+func TestWarnings(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(c *Config)
+		setting string
+	}{
+		{name: "Defaults are clean", modify: func(c *Config) {}},
+		{
+			name:    "Rate limit too low for reconnecting streams",
+			modify:  func(c *Config) { c.RateLimit.RefillRate, c.RateLimit.RefillPeriod = 1, 10*time.Second },
+			setting: "RATE_LIMIT_REFILL",
+		},
+		{
+			name:    "Rate limit capacity too small",
+			modify:  func(c *Config) { c.RateLimit.Capacity = 5 },
+			setting: "RATE_LIMIT_CAPACITY",
+		},
+		{
+			name:    "Sessions expire before they are extended",
+			modify:  func(c *Config) { c.Session.UpdateThreshold = 24 * time.Hour },
+			setting: "SESSION_UPDATE_THRESHOLD",
+		},
+		{
+			name:    "Uploads inside static",
+			modify:  func(c *Config) { c.Server.UploadsDir = "/srv/app/static/uploads" },
+			setting: "UPLOADS_DIR",
+		},
+		{
+			name: "S3 storage with the upload collector",
+			modify: func(c *Config) {
+				c.Upload.Storage = "s3"
+				c.Upload.GCInterval = 6 * time.Hour
+			},
+			setting: "UPLOAD_GC_INTERVAL",
+		},
+		{
+			name:    "Public host without TLS",
+			modify:  func(c *Config) { c.Server.Host = "0.0.0.0" },
+			setting: "TLS_CERT_FILE",
+		},
+		{
+			name: "Public host with TLS",
+			modify: func(c *Config) {
+				c.Server.Host = "0.0.0.0"
+				c.Server.TLSCertFile, c.Server.TLSKeyFile = "cert.pem", "key.pem"
+			},
+		},
+		{
+			name: "Public host behind a TLS proxy",
+			modify: func(c *Config) {
+				c.Server.Host = "0.0.0.0"
+				c.Server.TLSTerminatedUpstream = true
+				c.Server.TrustedProxies = []string{"10.0.0.0/8"}
+			},
+		},
+		{
+			name:    "TLS proxy that is not trusted",
+			modify:  func(c *Config) { c.Server.TLSTerminatedUpstream = true },
+			setting: "TRUSTED_PROXIES",
+		},
+		{
+			name:    "Every address trusted as a proxy",
+			modify:  func(c *Config) { c.Server.TrustedProxies = []string{"10.0.0.1", "0.0.0.0/0"} },
+			setting: "TRUSTED_PROXIES",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := lintBase()
+			tt.modify(c)
+
+			findings := c.warnings()
+			if tt.setting == "" {
+				assert.Empty(t, findings)
+				return
+			}
+			if assert.Len(t, findings, 1) {
+				assert.Equal(t, SeverityWarning, findings[0].Severity)
+				assert.Contains(t, findings[0].Settings, tt.setting)
+				assert.NotEmpty(t, findings[0].Fix)
+			}
+		})
+	}
+}
+
+func TestLintReportPrint(t *testing.T) {
+	report := LintReport{Findings: []Finding{
+		{Severity: SeverityError, Message: "kafka address (KAFKA_ADDR) is required"},
+		{Severity: SeverityWarning, Settings: []string{"SESSION_TTL"}, Message: "too short", Fix: "raise it"},
+	}}
+
+	var buf bytes.Buffer
+	report.Print(&buf)
+
+	assert.Equal(t, "Configuration Lint: 1 error(s), 1 warning(s)\n"+
+		"  [error] kafka address (KAFKA_ADDR) is required\n"+
+		"  [warning] SESSION_TTL: too short\n"+
+		"      fix: raise it\n", buf.String())
+}
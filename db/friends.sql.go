@@ -16,7 +16,7 @@ import (
 const acceptFriend = `-- name: AcceptFriend :one
 UPDATE friends
 SET accepted = true
-WHERE user_id = $1 AND friend_id = $2
+WHERE user_id = $1 AND friend_id = $2 AND NOT accepted
 RETURNING id, user_id, friend_id, created_at, accepted
 `
 
@@ -62,6 +62,30 @@ func (q *Queries) AddFriend(ctx context.Context, arg AddFriendParams) (Friend, e
 	return i, err
 }
 
+const areFriends = `-- name: AreFriends :one
+SELECT EXISTS (
+    SELECT 1 FROM friends f
+    JOIN users a ON a.username = $1
+    JOIN users b ON b.username = $2
+    WHERE f.accepted = true AND (
+        (f.user_id = a.id AND f.friend_id = b.id) OR
+        (f.user_id = b.id AND f.friend_id = a.id)
+    )
+)
+`
+
+type AreFriendsParams struct {
+	User1 string
+	User2 string
+}
+
+func (q *Queries) AreFriends(ctx context.Context, arg AreFriendsParams) (bool, error) {
+	row := q.db.QueryRowContext(ctx, areFriends, arg.User1, arg.User2)
+	var exists bool
+	err := row.Scan(&exists)
+	return exists, err
+}
+
 const getFriendRequests = `-- name: GetFriendRequests :many
 SELECT id, user_id, friend_id, created_at, accepted FROM friends 
 WHERE friend_id = $1 AND accepted = false
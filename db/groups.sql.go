@@ -38,10 +38,39 @@ func (q *Queries) AddGroupMember(ctx context.Context, arg AddGroupMemberParams)
 	return i, err
 }
 
+const convertGroupDM = `-- name: ConvertGroupDM :one
+UPDATE groups
+SET kind = 'group', name = $2, updated_at = NOW()
+WHERE id = $1 AND kind = 'dm'
+RETURNING id, name, description, icon, custom_icon, created_by, created_at, updated_at, kind
+`
+
+type ConvertGroupDMParams struct {
+	ID   uuid.UUID
+	Name string
+}
+
+func (q *Queries) ConvertGroupDM(ctx context.Context, arg ConvertGroupDMParams) (Group, error) {
+	row := q.db.QueryRowContext(ctx, convertGroupDM, arg.ID, arg.Name)
+	var i Group
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Description,
+		&i.Icon,
+		&i.CustomIcon,
+		&i.CreatedBy,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Kind,
+	)
+	return i, err
+}
+
 const createGroup = `-- name: CreateGroup :one
 INSERT INTO groups (name, description, icon, custom_icon, created_by)
 VALUES ($1, $2, $3, $4, $5)
-RETURNING id, name, description, icon, custom_icon, created_by, created_at, updated_at
+RETURNING id, name, description, icon, custom_icon, created_by, created_at, updated_at, kind
 `
 
 type CreateGroupParams struct {
@@ -70,13 +99,37 @@ func (q *Queries) CreateGroup(ctx context.Context, arg CreateGroupParams) (Group
 		&i.CreatedBy,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.Kind,
+	)
+	return i, err
+}
+
+const createGroupDM = `-- name: CreateGroupDM :one
+INSERT INTO groups (name, created_by, kind)
+VALUES ('', $1, 'dm')
+RETURNING id, name, description, icon, custom_icon, created_by, created_at, updated_at, kind
+`
+
+func (q *Queries) CreateGroupDM(ctx context.Context, createdBy uuid.UUID) (Group, error) {
+	row := q.db.QueryRowContext(ctx, createGroupDM, createdBy)
+	var i Group
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Description,
+		&i.Icon,
+		&i.CustomIcon,
+		&i.CreatedBy,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Kind,
 	)
 	return i, err
 }
 
 const deleteGroup = `-- name: DeleteGroup :one
 DELETE FROM groups WHERE id = $1
-RETURNING id, name, description, icon, custom_icon, created_by, created_at, updated_at
+RETURNING id, name, description, icon, custom_icon, created_by, created_at, updated_at, kind
 `
 
 func (q *Queries) DeleteGroup(ctx context.Context, id uuid.UUID) (Group, error) {
@@ -91,12 +144,13 @@ func (q *Queries) DeleteGroup(ctx context.Context, id uuid.UUID) (Group, error)
 		&i.CreatedBy,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.Kind,
 	)
 	return i, err
 }
 
 const getGroupByID = `-- name: GetGroupByID :one
-SELECT id, name, description, icon, custom_icon, created_by, created_at, updated_at FROM groups WHERE id = $1
+SELECT id, name, description, icon, custom_icon, created_by, created_at, updated_at, kind FROM groups WHERE id = $1
 `
 
 func (q *Queries) GetGroupByID(ctx context.Context, id uuid.UUID) (Group, error) {
@@ -111,6 +165,7 @@ func (q *Queries) GetGroupByID(ctx context.Context, id uuid.UUID) (Group, error)
 		&i.CreatedBy,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.Kind,
 	)
 	return i, err
 }
@@ -197,7 +252,7 @@ func (q *Queries) GetGroupMembers(ctx context.Context, groupID uuid.UUID) ([]Get
 }
 
 const getUserGroups = `-- name: GetUserGroups :many
-SELECT g.id, g.name, g.description, g.icon, g.custom_icon, g.created_by, g.created_at, g.updated_at FROM groups g
+SELECT g.id, g.name, g.description, g.icon, g.custom_icon, g.created_by, g.created_at, g.updated_at, g.kind FROM groups g
 INNER JOIN group_members gm ON g.id = gm.group_id
 WHERE gm.user_id = $1
 ORDER BY g.updated_at DESC
@@ -221,6 +276,7 @@ func (q *Queries) GetUserGroups(ctx context.Context, userID uuid.UUID) ([]Group,
 			&i.CreatedBy,
 			&i.CreatedAt,
 			&i.UpdatedAt,
+			&i.Kind,
 		); err != nil {
 			return nil, err
 		}
@@ -238,7 +294,7 @@ func (q *Queries) GetUserGroups(ctx context.Context, userID uuid.UUID) ([]Group,
 const isGroupAdmin = `-- name: IsGroupAdmin :one
 SELECT EXISTS(
     SELECT 1 FROM group_members
-    WHERE group_id = $1 AND user_id = $2 AND role = 'admin'
+    WHERE group_id = $1 AND user_id = $2 AND role IN ('owner', 'admin')
 ) AS is_admin
 `
 
@@ -273,6 +329,32 @@ func (q *Queries) IsGroupMember(ctx context.Context, arg IsGroupMemberParams) (b
 	return is_member, err
 }
 
+const promoteGroupSuccessor = `-- name: PromoteGroupSuccessor :execrows
+UPDATE group_members
+SET role = 'owner'
+WHERE group_id = $1
+    AND user_id = (
+        SELECT m.user_id FROM group_members m
+        WHERE m.group_id = $1
+        ORDER BY CASE m.role WHEN 'admin' THEN 0 WHEN 'moderator' THEN 1 ELSE 2 END, m.joined_at
+        LIMIT 1
+    )
+    AND NOT EXISTS (
+        SELECT 1 FROM group_members o
+        WHERE o.group_id = $1 AND o.role = 'owner'
+    )
+`
+
+// Makes the highest ranked, longest standing member the owner of a group
+// left without one
+func (q *Queries) PromoteGroupSuccessor(ctx context.Context, groupID uuid.UUID) (int64, error) {
+	result, err := q.db.ExecContext(ctx, promoteGroupSuccessor, groupID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
 const removeGroupMember = `-- name: RemoveGroupMember :one
 DELETE FROM group_members
 WHERE group_id = $1 AND user_id = $2
@@ -297,11 +379,31 @@ func (q *Queries) RemoveGroupMember(ctx context.Context, arg RemoveGroupMemberPa
 	return i, err
 }
 
+const transferGroupOwnership = `-- name: TransferGroupOwnership :execrows
+UPDATE group_members
+SET role = CASE WHEN user_id = $1::uuid THEN 'owner' ELSE 'admin' END
+WHERE group_id = $2 AND (user_id = $1::uuid OR role = 'owner')
+`
+
+type TransferGroupOwnershipParams struct {
+	NewOwner uuid.UUID
+	GroupID  uuid.UUID
+}
+
+// Makes new_owner the owner and the previous owner an admin in one statement
+func (q *Queries) TransferGroupOwnership(ctx context.Context, arg TransferGroupOwnershipParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, transferGroupOwnership, arg.NewOwner, arg.GroupID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
 const updateGroup = `-- name: UpdateGroup :one
 UPDATE groups
 SET name = $2, description = $3, icon = $4, custom_icon = $5, updated_at = NOW()
 WHERE id = $1
-RETURNING id, name, description, icon, custom_icon, created_by, created_at, updated_at
+RETURNING id, name, description, icon, custom_icon, created_by, created_at, updated_at, kind
 `
 
 type UpdateGroupParams struct {
@@ -330,6 +432,7 @@ func (q *Queries) UpdateGroup(ctx context.Context, arg UpdateGroupParams) (Group
 		&i.CreatedBy,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.Kind,
 	)
 	return i, err
 }
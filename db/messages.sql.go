@@ -13,6 +13,120 @@ import (
 	"github.com/google/uuid"
 )
 
+const archiveMessage = `-- name: ArchiveMessage :execrows
+INSERT INTO messages (
+    message_id,
+    from_user_id,
+    to_user_id,
+    group_id,
+    content,
+    is_group,
+    subtype,
+    created_at
+)
+SELECT $1::text, u_from.id, u_to.id, g.id, $2::text, $3::boolean, $4::text, $5::timestamptz
+FROM users u_from
+LEFT JOIN users u_to ON u_to.username = $6::text
+LEFT JOIN groups g ON g.id = $7::uuid
+WHERE u_from.username = $8::text
+    AND CASE WHEN $3::boolean THEN g.id IS NOT NULL ELSE u_to.id IS NOT NULL END
+ON CONFLICT (message_id) DO NOTHING
+`
+
+type ArchiveMessageParams struct {
+	MessageID    string
+	Content      string
+	IsGroup      bool
+	Subtype      string
+	CreatedAt    time.Time
+	ToUsername   string
+	GroupID      uuid.NullUUID
+	FromUsername string
+}
+
+// Writes a message read from Kafka unless it is already stored or its
+// sender, recipient or group no longer exists
+func (q *Queries) ArchiveMessage(ctx context.Context, arg ArchiveMessageParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, archiveMessage,
+		arg.MessageID,
+		arg.Content,
+		arg.IsGroup,
+		arg.Subtype,
+		arg.CreatedAt,
+		arg.ToUsername,
+		arg.GroupID,
+		arg.FromUsername,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const archiveMessageDelete = `-- name: ArchiveMessageDelete :execrows
+UPDATE messages
+SET content = '', deleted_at = $1::timestamptz
+WHERE message_id = $2
+    AND deleted_at IS NULL
+`
+
+type ArchiveMessageDeleteParams struct {
+	DeletedAt time.Time
+	MessageID string
+}
+
+func (q *Queries) ArchiveMessageDelete(ctx context.Context, arg ArchiveMessageDeleteParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, archiveMessageDelete, arg.DeletedAt, arg.MessageID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const archiveMessageEdit = `-- name: ArchiveMessageEdit :execrows
+UPDATE messages
+SET content = $1, edited_at = $2::timestamptz
+WHERE message_id = $3
+    AND deleted_at IS NULL
+    AND (edited_at IS NULL OR edited_at < $2::timestamptz)
+`
+
+type ArchiveMessageEditParams struct {
+	Content   string
+	EditedAt  time.Time
+	MessageID string
+}
+
+// Applies an edit read from Kafka unless a later one is already stored
+func (q *Queries) ArchiveMessageEdit(ctx context.Context, arg ArchiveMessageEditParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, archiveMessageEdit, arg.Content, arg.EditedAt, arg.MessageID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const countUnreadMessages = `-- name: CountUnreadMessages :one
+SELECT COUNT(*) FROM messages
+WHERE from_user_id = (SELECT id FROM users WHERE username = $1::text)
+    AND to_user_id = (SELECT id FROM users WHERE username = $2::text)
+    AND created_at >= $3::timestamptz
+    AND deleted_at IS NULL
+`
+
+type CountUnreadMessagesParams struct {
+	FromUsername string
+	ToUsername   string
+	Since        time.Time
+}
+
+func (q *Queries) CountUnreadMessages(ctx context.Context, arg CountUnreadMessagesParams) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countUnreadMessages, arg.FromUsername, arg.ToUsername, arg.Since)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
 const createMessage = `-- name: CreateMessage :one
 INSERT INTO messages (
     message_id,
@@ -20,10 +134,11 @@ INSERT INTO messages (
     to_user_id,
     content,
     is_group,
-    group_id
+    group_id,
+    subtype
 ) VALUES (
-    $1, $2, $3, $4, $5, $6
-) RETURNING id, message_id, from_user_id, to_user_id, group_id, content, is_group, created_at
+    $1, $2, $3, $4, $5, $6, $7
+) RETURNING id, message_id, from_user_id, to_user_id, group_id, content, is_group, created_at, subtype, edited_at, deleted_at
 `
 
 type CreateMessageParams struct {
@@ -33,6 +148,7 @@ type CreateMessageParams struct {
 	Content    string
 	IsGroup    sql.NullBool
 	GroupID    uuid.NullUUID
+	Subtype    string
 }
 
 func (q *Queries) CreateMessage(ctx context.Context, arg CreateMessageParams) (Message, error) {
@@ -43,6 +159,7 @@ func (q *Queries) CreateMessage(ctx context.Context, arg CreateMessageParams) (M
 		arg.Content,
 		arg.IsGroup,
 		arg.GroupID,
+		arg.Subtype,
 	)
 	var i Message
 	err := row.Scan(
@@ -54,15 +171,201 @@ func (q *Queries) CreateMessage(ctx context.Context, arg CreateMessageParams) (M
 		&i.Content,
 		&i.IsGroup,
 		&i.CreatedAt,
+		&i.Subtype,
+		&i.EditedAt,
+		&i.DeletedAt,
 	)
 	return i, err
 }
 
+const deleteMessage = `-- name: DeleteMessage :execrows
+UPDATE messages
+SET content = '', deleted_at = NOW()
+WHERE message_id = $1
+    AND from_user_id = (SELECT id FROM users WHERE username = $2::text)
+    AND to_user_id = (SELECT id FROM users WHERE username = $3::text)
+    AND subtype <> 'system'
+    AND deleted_at IS NULL
+`
+
+type DeleteMessageParams struct {
+	MessageID    string
+	FromUsername string
+	ToUsername   string
+}
+
+func (q *Queries) DeleteMessage(ctx context.Context, arg DeleteMessageParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteMessage, arg.MessageID, arg.FromUsername, arg.ToUsername)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const editMessage = `-- name: EditMessage :execrows
+UPDATE messages
+SET content = $1, edited_at = NOW()
+WHERE message_id = $2
+    AND from_user_id = (SELECT id FROM users WHERE username = $3::text)
+    AND to_user_id = (SELECT id FROM users WHERE username = $4::text)
+    AND subtype = ''
+    AND deleted_at IS NULL
+`
+
+type EditMessageParams struct {
+	Content      string
+	MessageID    string
+	FromUsername string
+	ToUsername   string
+}
+
+func (q *Queries) EditMessage(ctx context.Context, arg EditMessageParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, editMessage,
+		arg.Content,
+		arg.MessageID,
+		arg.FromUsername,
+		arg.ToUsername,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const getGroupMessages = `-- name: GetGroupMessages :many
+SELECT
+    m.message_id,
+    m.content,
+    m.subtype,
+    m.created_at,
+    m.edited_at,
+    m.deleted_at,
+    u_from.username as from_username
+FROM messages m
+JOIN users u_from ON m.from_user_id = u_from.id
+WHERE m.group_id = $1::uuid
+ORDER BY m.created_at DESC, m.message_id DESC
+LIMIT $2
+`
+
+type GetGroupMessagesParams struct {
+	GroupID  uuid.UUID
+	RowLimit int32
+}
+
+type GetGroupMessagesRow struct {
+	MessageID    string
+	Content      string
+	Subtype      string
+	CreatedAt    time.Time
+	EditedAt     sql.NullTime
+	DeletedAt    sql.NullTime
+	FromUsername string
+}
+
+func (q *Queries) GetGroupMessages(ctx context.Context, arg GetGroupMessagesParams) ([]GetGroupMessagesRow, error) {
+	rows, err := q.db.QueryContext(ctx, getGroupMessages, arg.GroupID, arg.RowLimit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetGroupMessagesRow
+	for rows.Next() {
+		var i GetGroupMessagesRow
+		if err := rows.Scan(
+			&i.MessageID,
+			&i.Content,
+			&i.Subtype,
+			&i.CreatedAt,
+			&i.EditedAt,
+			&i.DeletedAt,
+			&i.FromUsername,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getGroupMessagesAfter = `-- name: GetGroupMessagesAfter :many
+SELECT
+    m.message_id,
+    m.content,
+    m.subtype,
+    m.created_at,
+    m.edited_at,
+    m.deleted_at,
+    u_from.username as from_username
+FROM messages m
+JOIN users u_from ON m.from_user_id = u_from.id
+WHERE m.group_id = $1::uuid
+    AND m.created_at >= $2::timestamptz
+ORDER BY m.created_at ASC, m.message_id ASC
+LIMIT $3
+`
+
+type GetGroupMessagesAfterParams struct {
+	GroupID        uuid.UUID
+	AfterCreatedAt time.Time
+	RowLimit       int32
+}
+
+type GetGroupMessagesAfterRow struct {
+	MessageID    string
+	Content      string
+	Subtype      string
+	CreatedAt    time.Time
+	EditedAt     sql.NullTime
+	DeletedAt    sql.NullTime
+	FromUsername string
+}
+
+func (q *Queries) GetGroupMessagesAfter(ctx context.Context, arg GetGroupMessagesAfterParams) ([]GetGroupMessagesAfterRow, error) {
+	rows, err := q.db.QueryContext(ctx, getGroupMessagesAfter, arg.GroupID, arg.AfterCreatedAt, arg.RowLimit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetGroupMessagesAfterRow
+	for rows.Next() {
+		var i GetGroupMessagesAfterRow
+		if err := rows.Scan(
+			&i.MessageID,
+			&i.Content,
+			&i.Subtype,
+			&i.CreatedAt,
+			&i.EditedAt,
+			&i.DeletedAt,
+			&i.FromUsername,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const getMessagesBetweenUsers = `-- name: GetMessagesBetweenUsers :many
 SELECT
     m.message_id,
     m.content,
+    m.subtype,
     m.created_at,
+    m.edited_at,
+    m.deleted_at,
     u_from.username as from_username,
     u_to.username as to_username
 FROM messages m
@@ -85,7 +388,10 @@ type GetMessagesBetweenUsersParams struct {
 type GetMessagesBetweenUsersRow struct {
 	MessageID    string
 	Content      string
+	Subtype      string
 	CreatedAt    time.Time
+	EditedAt     sql.NullTime
+	DeletedAt    sql.NullTime
 	FromUsername string
 	ToUsername   string
 }
@@ -104,10 +410,340 @@ func (q *Queries) GetMessagesBetweenUsers(ctx context.Context, arg GetMessagesBe
 	var items []GetMessagesBetweenUsersRow
 	for rows.Next() {
 		var i GetMessagesBetweenUsersRow
+		if err := rows.Scan(
+			&i.MessageID,
+			&i.Content,
+			&i.Subtype,
+			&i.CreatedAt,
+			&i.EditedAt,
+			&i.DeletedAt,
+			&i.FromUsername,
+			&i.ToUsername,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getMessagesBetweenUsersAfter = `-- name: GetMessagesBetweenUsersAfter :many
+SELECT
+    m.message_id,
+    m.content,
+    m.subtype,
+    m.created_at,
+    m.edited_at,
+    m.deleted_at,
+    u_from.username as from_username,
+    u_to.username as to_username
+FROM messages m
+JOIN users u_from ON m.from_user_id = u_from.id
+JOIN users u_to ON m.to_user_id = u_to.id
+WHERE
+    ((u_from.username = $1 AND u_to.username = $2) OR
+     (u_from.username = $2 AND u_to.username = $1))
+    AND m.created_at >= $3::timestamptz
+ORDER BY m.created_at ASC, m.message_id ASC
+LIMIT $4
+`
+
+type GetMessagesBetweenUsersAfterParams struct {
+	User1          string
+	User2          string
+	AfterCreatedAt time.Time
+	RowLimit       int32
+}
+
+type GetMessagesBetweenUsersAfterRow struct {
+	MessageID    string
+	Content      string
+	Subtype      string
+	CreatedAt    time.Time
+	EditedAt     sql.NullTime
+	DeletedAt    sql.NullTime
+	FromUsername string
+	ToUsername   string
+}
+
+func (q *Queries) GetMessagesBetweenUsersAfter(ctx context.Context, arg GetMessagesBetweenUsersAfterParams) ([]GetMessagesBetweenUsersAfterRow, error) {
+	rows, err := q.db.QueryContext(ctx, getMessagesBetweenUsersAfter,
+		arg.User1,
+		arg.User2,
+		arg.AfterCreatedAt,
+		arg.RowLimit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetMessagesBetweenUsersAfterRow
+	for rows.Next() {
+		var i GetMessagesBetweenUsersAfterRow
+		if err := rows.Scan(
+			&i.MessageID,
+			&i.Content,
+			&i.Subtype,
+			&i.CreatedAt,
+			&i.EditedAt,
+			&i.DeletedAt,
+			&i.FromUsername,
+			&i.ToUsername,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getMessagesBetweenUsersBefore = `-- name: GetMessagesBetweenUsersBefore :many
+SELECT
+    m.message_id,
+    m.content,
+    m.subtype,
+    m.created_at,
+    m.edited_at,
+    m.deleted_at,
+    u_from.username as from_username,
+    u_to.username as to_username
+FROM messages m
+JOIN users u_from ON m.from_user_id = u_from.id
+JOIN users u_to ON m.to_user_id = u_to.id
+WHERE
+    ((u_from.username = $1 AND u_to.username = $2) OR
+     (u_from.username = $2 AND u_to.username = $1))
+    AND (m.created_at, m.message_id) < ($3::timestamptz, $4::text)
+ORDER BY m.created_at DESC, m.message_id DESC
+LIMIT $5
+`
+
+type GetMessagesBetweenUsersBeforeParams struct {
+	User1           string
+	User2           string
+	BeforeCreatedAt time.Time
+	BeforeMessageID string
+	RowLimit        int32
+}
+
+type GetMessagesBetweenUsersBeforeRow struct {
+	MessageID    string
+	Content      string
+	Subtype      string
+	CreatedAt    time.Time
+	EditedAt     sql.NullTime
+	DeletedAt    sql.NullTime
+	FromUsername string
+	ToUsername   string
+}
+
+func (q *Queries) GetMessagesBetweenUsersBefore(ctx context.Context, arg GetMessagesBetweenUsersBeforeParams) ([]GetMessagesBetweenUsersBeforeRow, error) {
+	rows, err := q.db.QueryContext(ctx, getMessagesBetweenUsersBefore,
+		arg.User1,
+		arg.User2,
+		arg.BeforeCreatedAt,
+		arg.BeforeMessageID,
+		arg.RowLimit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetMessagesBetweenUsersBeforeRow
+	for rows.Next() {
+		var i GetMessagesBetweenUsersBeforeRow
+		if err := rows.Scan(
+			&i.MessageID,
+			&i.Content,
+			&i.Subtype,
+			&i.CreatedAt,
+			&i.EditedAt,
+			&i.DeletedAt,
+			&i.FromUsername,
+			&i.ToUsername,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const searchConversationMessages = `-- name: SearchConversationMessages :many
+SELECT
+    m.message_id,
+    m.content,
+    m.created_at,
+    m.edited_at,
+    u_from.username as from_username,
+    u_to.username as to_username
+FROM messages m
+JOIN users u_from ON m.from_user_id = u_from.id
+JOIN users u_to ON m.to_user_id = u_to.id
+WHERE
+    ((u_from.username = $1 AND u_to.username = $2) OR
+     (u_from.username = $2 AND u_to.username = $1))
+    AND m.subtype = ''
+    AND m.deleted_at IS NULL
+    AND m.content ILIKE $3::text
+    AND (m.created_at, m.message_id) < ($4::timestamptz, $5::text)
+ORDER BY m.created_at DESC, m.message_id DESC
+LIMIT $6
+`
+
+type SearchConversationMessagesParams struct {
+	User1           string
+	User2           string
+	Pattern         string
+	BeforeCreatedAt time.Time
+	BeforeMessageID string
+	RowLimit        int32
+}
+
+type SearchConversationMessagesRow struct {
+	MessageID    string
+	Content      string
+	CreatedAt    time.Time
+	EditedAt     sql.NullTime
+	FromUsername string
+	ToUsername   string
+}
+
+func (q *Queries) SearchConversationMessages(ctx context.Context, arg SearchConversationMessagesParams) ([]SearchConversationMessagesRow, error) {
+	rows, err := q.db.QueryContext(ctx, searchConversationMessages,
+		arg.User1,
+		arg.User2,
+		arg.Pattern,
+		arg.BeforeCreatedAt,
+		arg.BeforeMessageID,
+		arg.RowLimit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []SearchConversationMessagesRow
+	for rows.Next() {
+		var i SearchConversationMessagesRow
+		if err := rows.Scan(
+			&i.MessageID,
+			&i.Content,
+			&i.CreatedAt,
+			&i.EditedAt,
+			&i.FromUsername,
+			&i.ToUsername,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const searchMessages = `-- name: SearchMessages :many
+SELECT
+    m.message_id,
+    m.content,
+    m.created_at,
+    m.edited_at,
+    m.group_id,
+    u_from.username as from_username,
+    COALESCE(u_to.username, '')::text as to_username
+FROM messages m
+JOIN users me ON me.username = $1::text
+JOIN users u_from ON m.from_user_id = u_from.id
+LEFT JOIN users u_to ON m.to_user_id = u_to.id
+WHERE
+    to_tsvector('simple', m.content) @@ websearch_to_tsquery('simple', $2::text)
+    AND m.subtype = ''
+    AND m.deleted_at IS NULL
+    AND (
+        (m.group_id IS NULL
+            AND (m.from_user_id = me.id OR m.to_user_id = me.id)
+            AND ($3::text = '' OR
+                 CASE WHEN m.from_user_id = me.id THEN u_to.username ELSE u_from.username END = $3::text)
+            AND $4::uuid IS NULL)
+        OR
+        (m.group_id IS NOT NULL
+            AND EXISTS (SELECT 1 FROM group_members gm WHERE gm.group_id = m.group_id AND gm.user_id = me.id)
+            AND $3::text = ''
+            AND ($4::uuid IS NULL OR m.group_id = $4::uuid))
+    )
+    AND (m.created_at, m.message_id) < ($5::timestamptz, $6::text)
+ORDER BY m.created_at DESC, m.message_id DESC
+LIMIT $7
+`
+
+type SearchMessagesParams struct {
+	Username        string
+	Query           string
+	WithUser        string
+	WithGroup       uuid.NullUUID
+	BeforeCreatedAt time.Time
+	BeforeMessageID string
+	RowLimit        int32
+}
+
+type SearchMessagesRow struct {
+	MessageID    string
+	Content      string
+	CreatedAt    time.Time
+	EditedAt     sql.NullTime
+	GroupID      uuid.NullUUID
+	FromUsername string
+	ToUsername   string
+}
+
+// Full-text search over the conversations of username: direct messages they
+// sent or received and messages of the groups they belong to, optionally
+// limited to the conversation with with_user or to the group with_group
+func (q *Queries) SearchMessages(ctx context.Context, arg SearchMessagesParams) ([]SearchMessagesRow, error) {
+	rows, err := q.db.QueryContext(ctx, searchMessages,
+		arg.Username,
+		arg.Query,
+		arg.WithUser,
+		arg.WithGroup,
+		arg.BeforeCreatedAt,
+		arg.BeforeMessageID,
+		arg.RowLimit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []SearchMessagesRow
+	for rows.Next() {
+		var i SearchMessagesRow
 		if err := rows.Scan(
 			&i.MessageID,
 			&i.Content,
 			&i.CreatedAt,
+			&i.EditedAt,
+			&i.GroupID,
 			&i.FromUsername,
 			&i.ToUsername,
 		); err != nil {
@@ -6,11 +6,62 @@ package db
 
 import (
 	"database/sql"
+	"encoding/json"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+type AbuseReport struct {
+	ID         uuid.UUID
+	ReporterID uuid.UUID
+	ReportedID uuid.UUID
+	Reason     string
+	CreatedAt  time.Time
+}
+
+type Attachment struct {
+	ID            uuid.UUID
+	OwnerID       uuid.UUID
+	RecipientID   uuid.NullUUID
+	GroupID       uuid.NullUUID
+	ContentType   string
+	PlaintextSize int64
+	BlobSize      int64
+	KeyEnvelope   string
+	CreatedAt     time.Time
+}
+
+type CompliancePolicy struct {
+	Version   int32
+	Policy    json.RawMessage
+	ChangedBy uuid.NullUUID
+	Comment   string
+	CreatedAt time.Time
+}
+
+type ConversationMute struct {
+	UserID     uuid.UUID
+	ContactID  uuid.NullUUID
+	GroupID    uuid.NullUUID
+	MutedUntil sql.NullTime
+	CreatedAt  time.Time
+}
+
+type CustomEmoji struct {
+	ID        uuid.UUID
+	Shortcode string
+	ImageUrl  string
+	CreatedBy uuid.NullUUID
+	CreatedAt time.Time
+}
+
+type DirectoryListing struct {
+	UserID   uuid.UUID
+	Tags     []string
+	ListedAt time.Time
+}
+
 type Friend struct {
 	ID        uuid.UUID
 	UserID    uuid.NullUUID
@@ -28,6 +79,7 @@ type Group struct {
 	CreatedBy   uuid.UUID
 	CreatedAt   time.Time
 	UpdatedAt   time.Time
+	Kind        string
 }
 
 type GroupMember struct {
@@ -38,6 +90,19 @@ type GroupMember struct {
 	JoinedAt time.Time
 }
 
+type Impersonation struct {
+	ID            uuid.UUID
+	AdminID       uuid.NullUUID
+	AdminUsername string
+	UserID        uuid.UUID
+	Reason        string
+	SessionID     string
+	StartedAt     time.Time
+	ExpiresAt     time.Time
+	EndedAt       sql.NullTime
+	NotifiedAt    sql.NullTime
+}
+
 type Message struct {
 	ID         uuid.UUID
 	MessageID  string
@@ -47,6 +112,100 @@ type Message struct {
 	Content    string
 	IsGroup    sql.NullBool
 	CreatedAt  time.Time
+	Subtype    string
+	EditedAt   sql.NullTime
+	DeletedAt  sql.NullTime
+}
+
+type MessageReaction struct {
+	MessageID string
+	UserID    uuid.UUID
+	Emoji     string
+	CreatedAt time.Time
+}
+
+type MessageRequest struct {
+	SenderID    uuid.UUID
+	RecipientID uuid.UUID
+	Status      string
+	Messages    int32
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+type Notification struct {
+	ID        uuid.UUID
+	UserID    uuid.UUID
+	Type      string
+	ActorID   uuid.NullUUID
+	Content   string
+	Data      json.RawMessage
+	CreatedAt time.Time
+	ReadAt    sql.NullTime
+}
+
+type NotificationDigest struct {
+	UserID           uuid.UUID
+	OptedOut         bool
+	LastSeenAt       time.Time
+	LastSentAt       sql.NullTime
+	UnsubscribeToken uuid.UUID
+}
+
+type PendingInvite struct {
+	GroupID   uuid.UUID
+	InviteeID uuid.UUID
+	InviterID uuid.UUID
+	CreatedAt time.Time
+}
+
+type PinnedMessage struct {
+	MessageID string
+	UserLow   uuid.UUID
+	UserHigh  uuid.UUID
+	PinnedBy  uuid.UUID
+	PinnedAt  time.Time
+}
+
+type ProfileChange struct {
+	ID        uuid.UUID
+	UserID    uuid.UUID
+	Field     string
+	OldValue  sql.NullString
+	NewValue  sql.NullString
+	ChangedAt time.Time
+}
+
+type Session struct {
+	SessionID    string
+	UserID       uuid.UUID
+	Username     string
+	LoginTime    int64
+	LastActivity int64
+	ExpiresAt    time.Time
+}
+
+type ShareLink struct {
+	ID             uuid.UUID
+	TokenHash      string
+	OwnerID        uuid.UUID
+	ContactID      uuid.UUID
+	RangeFrom      sql.NullTime
+	RangeTo        time.Time
+	PasscodeHash   sql.NullString
+	ExpiresAt      sql.NullTime
+	RevokedAt      sql.NullTime
+	CreatedAt      time.Time
+	FailedAttempts int32
+}
+
+type UploadObject struct {
+	Sha256    string
+	Url       string
+	SizeBytes int64
+	RefCount  int32
+	CreatedAt time.Time
+	UpdatedAt time.Time
 }
 
 type User struct {
@@ -59,3 +218,21 @@ type User struct {
 	Icon         sql.NullString
 	CustomIcon   sql.NullString
 }
+
+type UserProfile struct {
+	UserID      uuid.UUID
+	DisplayName sql.NullString
+	Email       sql.NullString
+	Phone       sql.NullString
+	Website     sql.NullString
+	UpdatedAt   time.Time
+}
+
+type UserRestriction struct {
+	UserID     uuid.UUID
+	Reason     string
+	CreatedAt  time.Time
+	ExpiresAt  time.Time
+	ReviewedBy uuid.NullUUID
+	ReviewedAt sql.NullTime
+}
@@ -13,6 +13,40 @@ import (
 	"github.com/lib/pq"
 )
 
+const createBotUser = `-- name: CreateBotUser :one
+INSERT INTO users (username, password_hash, icon, custom_icon, role)
+VALUES ($1, $2, $3, $4, 'bot')
+RETURNING id, created_at, updated_at, username, role, password_hash, icon, custom_icon
+`
+
+type CreateBotUserParams struct {
+	Username     string
+	PasswordHash string
+	Icon         sql.NullString
+	CustomIcon   sql.NullString
+}
+
+func (q *Queries) CreateBotUser(ctx context.Context, arg CreateBotUserParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, createBotUser,
+		arg.Username,
+		arg.PasswordHash,
+		arg.Icon,
+		arg.CustomIcon,
+	)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Username,
+		&i.Role,
+		&i.PasswordHash,
+		&i.Icon,
+		&i.CustomIcon,
+	)
+	return i, err
+}
+
 const createUser = `-- name: CreateUser :one
 INSERT INTO users (username, password_hash, icon, custom_icon)
 VALUES ($1, $2, $3, $4)
@@ -135,6 +169,42 @@ func (q *Queries) GetUserByUsername(ctx context.Context, username string) (User,
 	return i, err
 }
 
+const getUsersByIDs = `-- name: GetUsersByIDs :many
+SELECT id, created_at, updated_at, username, role, password_hash, icon, custom_icon FROM users WHERE id = ANY($1::uuid[])
+`
+
+func (q *Queries) GetUsersByIDs(ctx context.Context, dollar_1 []uuid.UUID) ([]User, error) {
+	rows, err := q.db.QueryContext(ctx, getUsersByIDs, pq.Array(dollar_1))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []User
+	for rows.Next() {
+		var i User
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Username,
+			&i.Role,
+			&i.PasswordHash,
+			&i.Icon,
+			&i.CustomIcon,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const getUsersByUsernames = `-- name: GetUsersByUsernames :many
 SELECT id, created_at, updated_at, username, role, password_hash, icon, custom_icon FROM users WHERE username = ANY($1::text[])
 `
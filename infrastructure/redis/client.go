@@ -3,6 +3,7 @@ package redis
 import (
 	"context"
 	"exc6/config"
+	"exc6/pkg/timing"
 	"fmt"
 	"time"
 
@@ -33,6 +34,9 @@ func NewClient(cfg config.RedisConfig) (*redis.Client, error) {
 		ConnMaxLifetime: 30 * time.Minute, // Close connections after this lifetime
 	})
 
+	// Commands issued for a request count toward its Redis timing
+	client.AddHook(timing.RedisHook{})
+
 	// Test the connection
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
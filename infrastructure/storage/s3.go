@@ -0,0 +1,291 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"exc6/pkg/httpclient"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// amzDateFormat is the timestamp format of Signature Version 4
+	amzDateFormat = "20060102T150405Z"
+
+	// unsignedPayload stands in for the body hash of presigned URLs
+	unsignedPayload = "UNSIGNED-PAYLOAD"
+
+	// immutableCache lets browsers keep content-addressed objects forever
+	immutableCache = "public, max-age=31536000, immutable"
+)
+
+// S3Config locates a bucket of an S3-compatible service
+type S3Config struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+
+	// PathStyle addresses the bucket as the first path segment instead of
+	// a subdomain, as MinIO and most self-hosted services expect
+	PathStyle bool
+}
+
+// S3 keeps objects in a bucket of an S3-compatible service, signing requests
+// with AWS Signature Version 4
+type S3 struct {
+	client *httpclient.Client
+	cfg    S3Config
+	base   *url.URL
+
+	now func() time.Time
+}
+
+// NewS3 creates a storage for the bucket cfg names
+func NewS3(client *httpclient.Client, cfg S3Config) (*S3, error) {
+	base, err := url.Parse(strings.TrimRight(cfg.Endpoint, "/"))
+	if err != nil || base.Host == "" || (base.Scheme != "http" && base.Scheme != "https") {
+		return nil, fmt.Errorf("invalid S3 endpoint %q", cfg.Endpoint)
+	}
+	if cfg.Bucket == "" {
+		return nil, fmt.Errorf("S3 bucket is required")
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	return &S3{client: client, cfg: cfg, base: base, now: time.Now}, nil
+}
+
+// Put implements Storage. The content is read into memory to sign it and to
+// allow retries; uploads are small images.
+func (s *S3) Put(ctx context.Context, key string, r io.Reader, contentType string) error {
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	req, err := s.request(ctx, http.MethodPut, key, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Cache-Control", immutableCache)
+	s.sign(req, body)
+
+	return s.client.DoJSON(req, nil)
+}
+
+// Get implements Storage
+func (s *S3) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	req, err := s.request(ctx, http.MethodGet, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	s.sign(req, nil)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, ErrNotFound
+	}
+	if err := httpclient.CheckStatus(resp); err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Delete implements Storage
+func (s *S3) Delete(ctx context.Context, key string) error {
+	req, err := s.request(ctx, http.MethodDelete, key, nil)
+	if err != nil {
+		return err
+	}
+	s.sign(req, nil)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	if err := httpclient.CheckStatus(resp); err != nil {
+		return err
+	}
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+// SignedURL implements Storage with a presigned GET URL
+func (s *S3) SignedURL(ctx context.Context, key string, ttl time.Duration) (string, error) {
+	u, err := s.objectURL(key)
+	if err != nil {
+		return "", err
+	}
+
+	now := s.now().UTC()
+	query := url.Values{
+		"X-Amz-Algorithm":     {"AWS4-HMAC-SHA256"},
+		"X-Amz-Credential":    {s.cfg.AccessKey + "/" + s.scope(now)},
+		"X-Amz-Date":          {now.Format(amzDateFormat)},
+		"X-Amz-Expires":       {strconv.Itoa(int(ttl / time.Second))},
+		"X-Amz-SignedHeaders": {"host"},
+	}
+	u.RawQuery = canonicalQuery(query)
+
+	header := http.Header{}
+	header.Set("Host", u.Host)
+	signature := s.signature(now, http.MethodGet, u, header, []string{"host"}, unsignedPayload)
+
+	u.RawQuery += "&X-Amz-Signature=" + signature
+	return u.String(), nil
+}
+
+// request builds a request for the object under key
+func (s *S3) request(ctx context.Context, method, key string, body []byte) (*http.Request, error) {
+	u, err := s.objectURL(key)
+	if err != nil {
+		return nil, err
+	}
+
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	return http.NewRequestWithContext(ctx, method, u.String(), reader)
+}
+
+// objectURL addresses the object under key in the path or host style
+func (s *S3) objectURL(key string) (*url.URL, error) {
+	if err := checkKey(key); err != nil {
+		return nil, err
+	}
+
+	u := *s.base
+	if s.cfg.PathStyle {
+		u.Path = u.Path + "/" + s.cfg.Bucket + "/" + key
+	} else {
+		u.Host = s.cfg.Bucket + "." + u.Host
+		u.Path = u.Path + "/" + key
+	}
+	u.RawPath = escapePath(u.Path)
+	return &u, nil
+}
+
+// sign adds the Authorization header of Signature Version 4 to req
+func (s *S3) sign(req *http.Request, body []byte) {
+	now := s.now().UTC()
+	sum := sha256.Sum256(body)
+	payloadHash := hex.EncodeToString(sum[:])
+
+	req.Header.Set("X-Amz-Date", now.Format(amzDateFormat))
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	header := req.Header.Clone()
+	header.Set("Host", req.URL.Host)
+	signed := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+
+	signature := s.signature(now, req.Method, req.URL, header, signed, payloadHash)
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.cfg.AccessKey, s.scope(now), strings.Join(signed, ";"), signature))
+}
+
+// signature computes the Signature Version 4 of a request whose query is
+// already canonical; signed lists the lowercase names of signed headers in
+// order
+func (s *S3) signature(now time.Time, method string, u *url.URL, header http.Header, signed []string, payloadHash string) string {
+	var headers strings.Builder
+	for _, name := range signed {
+		headers.WriteString(name + ":" + strings.TrimSpace(header.Get(name)) + "\n")
+	}
+
+	canonicalRequest := strings.Join([]string{
+		method,
+		u.EscapedPath(),
+		u.RawQuery,
+		headers.String(),
+		strings.Join(signed, ";"),
+		payloadHash,
+	}, "\n")
+
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		now.Format(amzDateFormat),
+		s.scope(now),
+		hex.EncodeToString(requestHash[:]),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.cfg.SecretKey), now.Format("20060102"))
+	key = hmacSHA256(key, s.cfg.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+// scope is the credential scope of requests signed at now
+func (s *S3) scope(now time.Time) string {
+	return now.Format("20060102") + "/" + s.cfg.Region + "/s3/aws4_request"
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// canonicalQuery encodes query sorted by name with the URI encoding Signature
+// Version 4 expects, which escapes everything but unreserved characters
+func canonicalQuery(query url.Values) string {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		for _, value := range query[name] {
+			parts = append(parts, uriEncode(name, true)+"="+uriEncode(value, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// escapePath encodes each segment of a path, keeping the slashes
+func escapePath(path string) string {
+	return uriEncode(path, false)
+}
+
+// uriEncode escapes all but the unreserved characters of RFC 3986, and
+// slashes unless encodeSlash
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c >= '0' && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"exc6/config"
+	"exc6/pkg/httpclient"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Backends selectable with config.UploadConfig.Storage
+const (
+	BackendLocal = "local"
+	BackendS3    = "s3"
+)
+
+// ErrNotFound is returned by Get for a key that holds no object
+var ErrNotFound = errors.New("object not found")
+
+// Storage keeps uploaded objects under slash-separated keys such as
+// "objects/ab/abcd.png"
+type Storage interface {
+	// Put stores the content of r under key, replacing any object there
+	Put(ctx context.Context, key string, r io.Reader, contentType string) error
+
+	// Get opens the object under key; the caller closes it
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+
+	// Delete removes the object under key. Deleting a missing object is
+	// not an error.
+	Delete(ctx context.Context, key string) error
+
+	// SignedURL returns a URL browsers can fetch the object from for at
+	// least ttl
+	SignedURL(ctx context.Context, key string, ttl time.Duration) (string, error)
+}
+
+// New creates the storage cfg selects. Local storage keeps objects under
+// uploadsDir, which the server serves at urlPrefix; S3 requests go through
+// client.
+func New(cfg config.UploadConfig, uploadsDir, urlPrefix string, client *httpclient.Client) (Storage, error) {
+	switch cfg.Storage {
+	case BackendLocal, "":
+		return NewLocal(uploadsDir, urlPrefix), nil
+	case BackendS3:
+		return NewS3(client, S3Config{
+			Endpoint:  cfg.S3Endpoint,
+			Region:    cfg.S3Region,
+			Bucket:    cfg.S3Bucket,
+			AccessKey: cfg.S3AccessKey,
+			SecretKey: cfg.S3SecretKey,
+			PathStyle: cfg.S3PathStyle,
+		})
+	default:
+		return nil, fmt.Errorf("unknown upload storage %q", cfg.Storage)
+	}
+}
+
+// checkKey rejects keys that could leave the storage's root
+func checkKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return fmt.Errorf("invalid object key %q", key)
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return fmt.Errorf("invalid object key %q", key)
+		}
+	}
+	return nil
+}
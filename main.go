@@ -6,22 +6,61 @@ import (
 	"exc6/config"
 	"exc6/db"
 	infraredis "exc6/infrastructure/redis"
+	"exc6/infrastructure/storage"
+	"exc6/pkg/cache"
+	"exc6/pkg/httpclient"
+	"exc6/pkg/instance"
+	"exc6/pkg/keyspace"
+	"exc6/pkg/lifecycle"
 	"exc6/server"
+	"exc6/server/handlers"
+	"exc6/server/middleware/canary"
+	"exc6/server/sse"
 	"exc6/server/websocket"
+	"exc6/services/activity"
+	"exc6/services/bots"
 	"exc6/services/calls"
 	"exc6/services/chat"
+	"exc6/services/clientlogs"
+	"exc6/services/cluster"
+	"exc6/services/compliance"
+	"exc6/services/demo"
+	"exc6/services/digests"
+	"exc6/services/directory"
+	"exc6/services/emoji"
+	"exc6/services/events"
+	"exc6/services/experiments"
+	"exc6/services/export"
 	"exc6/services/friends"
+	"exc6/services/gifs"
 	"exc6/services/groups"
+	"exc6/services/impersonation"
+	"exc6/services/maintenance"
+	"exc6/services/moderation"
+	"exc6/services/notifications"
+	"exc6/services/presence"
+	"exc6/services/profiles"
+	"exc6/services/reminders"
+	"exc6/services/search"
 	"exc6/services/sessions"
+	"exc6/services/status"
+	"exc6/services/summaries"
+	"exc6/services/uploadgc"
+	"exc6/services/uploads"
+	"exc6/services/users"
+	"exc6/utils"
+	"flag"
 	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"slices"
 	"syscall"
 	"time"
 
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
+	"github.com/redis/go-redis/v9"
 )
 
 func main() {
@@ -31,6 +70,10 @@ func main() {
 }
 
 func run() error {
+	demoMode := flag.Bool("demo", false, "seed a demo dataset and run scripted demo bots")
+	auditRedis := flag.Bool("audit-redis", false, "report Redis memory and keys missing a TTL by key family, then exit")
+	flag.Parse()
+
 	// Load environment
 	if err := godotenv.Load(".env"); err != nil {
 		log.Printf("Warning: .env file not found: %v", err)
@@ -39,22 +82,49 @@ func run() error {
 	appCtx, appCancel := context.WithCancel(context.Background())
 	defer appCancel()
 
+	// Components register how they stop as they are created; a failed
+	// startup stops those created so far
+	lc := lifecycle.New()
+	lc.SetTimeout(lifecycle.PhaseListeners, 10*time.Second)
+	defer lc.Shutdown(context.Background())
+
 	// Load configuration
 	cfg, err := config.Load()
 	if err != nil {
 		return fmt.Errorf("failed to load configuration: %w", err)
 	}
 	log.Println("✓ Configuration loaded and validated")
+	log.Printf("✓ Instance ID: %s", instance.ID())
 	cfg.PrintSummary()
+	cfg.Lint().Print(os.Stdout)
+
+	utils.SetPasswordCost(cfg.Passwords.Cost)
+	if cfg.Passwords.Calibrate != "off" {
+		cal := utils.CalibratePasswordCost(cfg.Passwords.TargetLatency, cfg.Passwords.Calibrate == "adjust", cfg.Passwords.MinCost)
+		switch {
+		case cal.Cost != cfg.Passwords.Cost:
+			log.Printf("Warning: bcrypt cost %d is slower than %s on this host; lowered to %d (%s per hash)",
+				cfg.Passwords.Cost, cfg.Passwords.TargetLatency, cal.Cost, cal.Duration.Round(time.Millisecond))
+		case cal.Slow:
+			log.Printf("Warning: bcrypt cost %d takes %s per hash on this host, above the %s target",
+				cal.Cost, cal.Duration.Round(time.Millisecond), cfg.Passwords.TargetLatency)
+		default:
+			log.Printf("✓ Calibrated bcrypt cost %d (%s per hash)", cal.Cost, cal.Duration.Round(time.Millisecond))
+		}
+	}
 
 	// Initialize Redis with proper pooling
 	rdb, err := infraredis.NewClient(cfg.Redis)
 	if err != nil {
 		return fmt.Errorf("failed to initialize Redis client: %w", err)
 	}
-	defer rdb.Close()
+	lc.Register("redis", lifecycle.PhaseBackends, lifecycle.Closer(rdb))
 	log.Println("✓ Connected to Redis")
 
+	if *auditRedis {
+		return runRedisAudit(appCtx, rdb)
+	}
+
 	// Open users database
 	datb, err := sql.Open("postgres", cfg.Database.ConnectionString)
 	if err != nil {
@@ -66,38 +136,371 @@ func run() error {
 	datb.SetMaxIdleConns(10)
 	datb.SetConnMaxLifetime(5 * time.Minute)
 	datb.SetConnMaxIdleTime(10 * time.Minute)
+	lc.Register("postgres", lifecycle.PhaseBackends, lifecycle.Closer(datb))
 
 	dbqueries := db.New(datb)
 	log.Println("✓ Loaded users database")
 
-	csrv, err := chat.NewChatService(appCtx, rdb, dbqueries, cfg.Kafka.Address)
-	if err != nil {
-		return fmt.Errorf("failed to initialize chat service: %w", err)
+	// Conversation activity drives contact list revalidation and deltas
+	activityTracker := activity.NewTracker(rdb)
+
+	// Event feeds let clients keep their copy of a conversation up to date
+	eventLog := events.NewLog(rdb)
+
+	var csrv chat.Service
+	if cfg.Messages.Backend == config.MessageBackendMemory {
+		// Single-node deployments without Kafka; Redis still relays live events
+		csrv = chat.NewMemoryService(rdb)
+	} else {
+		// Each message class has its own topic and retention
+		topicsCtx, cancelTopics := context.WithTimeout(appCtx, 15*time.Second)
+		if err := chat.EnsureTopics(topicsCtx, cfg.Kafka); err != nil {
+			log.Printf("Warning: failed to ensure Kafka topics: %v", err)
+		}
+		cancelTopics()
+
+		csrv, err = chat.NewChatService(appCtx, rdb, dbqueries, cfg.Kafka)
+		if err != nil {
+			return fmt.Errorf("failed to initialize chat service: %w", err)
+		}
+
+		// Group messages and failed direct writes reach Postgres through Kafka
+		archiver, err := chat.NewConsumer(appCtx, dbqueries, cfg.Kafka)
+		if err != nil {
+			return fmt.Errorf("failed to start chat history consumer: %w", err)
+		}
+		lc.Register("chat history consumer", lifecycle.PhaseWorkers, lifecycle.Closer(archiver))
+		log.Println("✓ Chat history consumer started")
 	}
-	defer csrv.Close()
-	log.Println("✓ Initialized chat service")
+	lc.Register("chat service", lifecycle.PhaseWorkers, lifecycle.Closer(csrv))
+	csrv.SetLimits(chat.Limits{
+		MaxLength:    cfg.Messages.MaxLength,
+		Chunking:     cfg.Messages.Chunking,
+		MaxChunks:    cfg.Messages.MaxChunks,
+		RequestLimit: cfg.Messages.RequestLimit,
+	})
+	csrv.SetActivityTracker(activityTracker)
+	csrv.SetEventLog(eventLog)
+	log.Printf("✓ Initialized chat service (%s)", cfg.Messages.Backend)
 
 	// Initialize session manager
 	smngr := sessions.NewSessionManager(rdb)
-	log.Println("✓ Initialized session manager")
+	smngr.SetCacheCapacity(cfg.Session.LocalCacheSize)
+	if cfg.Session.DurableFallback {
+		smngr.SetDurableStore(appCtx, dbqueries)
+	}
+	log.Printf("✓ Initialized session manager (Postgres fallback: %t)", cfg.Session.DurableFallback)
 
 	fsrv := friends.NewFriendService(dbqueries)
+	fsrv.SetActivityTracker(activityTracker)
+	fsrv.SetConversations(datb, csrv)
 	log.Println("✓ Initialized friend service")
 
+	// Cross-instance invalidation for the in-process user/group caches
+	invalidator := cache.NewInvalidator(appCtx, rdb)
+	lc.Register("cache invalidator", lifecycle.PhaseWorkers, lifecycle.Func(invalidator.Close))
+
+	usrv := users.NewUserService(dbqueries, invalidator)
+	log.Println("✓ Initialized user service")
+
 	gsrv := groups.NewGroupService(dbqueries)
+	gsrv.SetInvalidator(invalidator)
+	gsrv.SetEventPublisher(rdb)
+	gsrv.SetActivityTracker(activityTracker)
+	gsrv.SetEventLog(eventLog)
+	gsrv.SetDirectAdd(cfg.Groups.DirectAdd)
 	log.Println("✓ Initialized group service")
 
+	policy := moderation.NewPolicy(dbqueries, invalidator, cfg.Moderation)
+	csrv.SetPolicy(policy)
+	gsrv.SetPolicy(policy)
+	log.Printf("✓ Initialized moderation policy (%d reports within %s restrict for %s)",
+		cfg.Moderation.ReportThreshold, cfg.Moderation.ReportWindow, cfg.Moderation.RestrictionDuration)
+
+	mutesSrv := notifications.NewService(dbqueries, invalidator)
+	csrv.SetMutes(mutesSrv)
+	log.Println("✓ Initialized conversation mutes")
+
 	websocketManager := websocket.NewManager(context.Background(), rdb)
+	websocketManager.SetSendBuffer(cfg.WebSocket.SendBuffer, websocket.DropPolicy(cfg.WebSocket.DropPolicy))
+	websocketManager.SetDropLimit(cfg.WebSocket.DropLimit)
+	websocketManager.SetWriteOptions(websocket.WriteOptions{
+		Timeout:        cfg.WebSocket.WriteTimeout,
+		SlowWrite:      cfg.WebSocket.SlowWrite,
+		SlowWriteLimit: cfg.WebSocket.SlowWriteLimit,
+	})
+	websocketManager.SetReadTracker(csrv)
+	websocketManager.SetDeliveryTracker(csrv)
+	websocketManager.SetTypingPublisher(csrv)
+	websocketManager.SetGroupService(gsrv)
 	log.Println("✓ Initialized WebSocket manager")
 
-	callsSrv := calls.NewCallService(context.Background(), rdb)
-	log.Println("✓ Initialized call service")
+	// Features the deployment turned off have no service; clients' messages
+	// for them are dropped
+	var callsSrv *calls.CallService
+	if cfg.Features.Enabled(config.FeatureCalls) {
+		callsSrv = calls.NewCallService(context.Background(), rdb)
+		lc.Register("call service", lifecycle.PhaseWorkers, lifecycle.Func(callsSrv.Close))
+		websocketManager.SetCallRooms(callsSrv)
+		log.Println("✓ Initialized call service")
+	} else {
+		websocketManager.DisableMessageTypes(websocket.CallSignalTypes...)
+	}
+	if !cfg.Features.Enabled(config.FeatureGroups) {
+		websocketManager.DisableMessageTypes(websocket.GroupMessageTypes...)
+	}
+
+	psrv := profiles.NewProfileService(dbqueries, datb)
+	log.Println("✓ Initialized profile service")
+
+	clusterSrv := cluster.NewClusterService(appCtx, rdb)
+	clusterSrv.SetConnectionCounter(websocketManager.ClientCount)
+	lc.Register("cluster heartbeat", lifecycle.PhaseWorkers, lifecycle.Func(clusterSrv.Close))
+	log.Println("✓ Initialized cluster heartbeat")
+
+	presenceSrv := presence.NewService(appCtx, rdb, fsrv)
+	presenceSrv.SetLocalUsers(websocketManager)
+	presenceSrv.OnChange(handlers.NotifyPresence(websocketManager))
+	websocketManager.SetPresenceTracker(presenceSrv)
+	// Friends see the users go offline before their connections drop
+	lc.Register("websocket manager", lifecycle.PhaseConnections, lifecycle.Func(func() {
+		presenceSrv.Close()
+		websocketManager.Close()
+	}))
+	log.Println("✓ Initialized presence tracking")
+
+	if *demoMode {
+		seedCtx, seedCancel := context.WithTimeout(appCtx, 2*time.Minute)
+		summary, err := demo.NewSeeder(dbqueries, csrv, fsrv, gsrv).Seed(seedCtx)
+		seedCancel()
+		if err != nil {
+			return fmt.Errorf("failed to seed demo data: %w", err)
+		}
+
+		demo.NewBotRunner(csrv, fsrv, gsrv, 45*time.Second).Start(appCtx)
+
+		if summary.Skipped {
+			log.Println("✓ Demo mode enabled (existing dataset)")
+		} else {
+			log.Printf("✓ Demo mode enabled: seeded %d users, %d groups, %d messages (password: %s)",
+				summary.Users, summary.Groups, summary.Messages, demo.Password)
+		}
+	}
+
+	remindersSrv := reminders.NewReminderService(appCtx, rdb, csrv)
+	log.Println("✓ Initialized reminder service")
+
+	// The HTTP server waits for streaming responses, so SSE streams end as
+	// it stops listening
+	sseCtx, sseCancel := context.WithCancel(appCtx)
+	sseBroker := sse.NewBroker(sseCtx, rdb, sse.Options{})
+	lc.Register("sse streams", lifecycle.PhaseListeners, lifecycle.Func(sseCancel))
+	log.Println("✓ Initialized SSE broker")
+
+	inboxSrv := notifications.NewNotificationService(dbqueries)
+	inboxSrv.SetPusher(websocketManager)
+	inboxSrv.SetStream(sseBroker)
+	sseBroker.SetArchive(handlers.NotificationArchive(inboxSrv))
+	csrv.SetNotifications(inboxSrv)
+	if callsSrv != nil {
+		callsSrv.SetNotifications(inboxSrv)
+	}
+	log.Println("✓ Initialized notification inbox")
+
+	impersonationSrv := impersonation.NewService(appCtx, dbqueries, rdb, smngr, usrv, inboxSrv)
+	log.Println("✓ Initialized support impersonation")
+
+	maintenanceSrv := maintenance.NewService(appCtx, rdb, cfg.Maintenance.AnnounceAt)
+	maintenanceSrv.OnAnnouncement(handlers.AnnounceMaintenance(websocketManager, sseBroker))
+	log.Println("✓ Initialized maintenance scheduler")
+
+	httpClient := httpclient.New(cfg.Egress.HTTPClientConfig())
+
+	emojiSrv := emoji.NewEmojiService(dbqueries, invalidator, cfg.Server.UploadsDir)
+
+	// Without uploads, images uploaded before stay served from the uploads
+	// directory but nothing new is stored or collected
+	var uploadStore *uploads.Store
+	if cfg.Features.Enabled(config.FeatureUploads) {
+		uploadBackend, err := storage.New(cfg.Upload, cfg.Server.UploadsDir, "/uploads/", httpClient)
+		if err != nil {
+			return fmt.Errorf("failed to initialize upload storage: %w", err)
+		}
+		uploadStore = uploads.NewStore(dbqueries, uploadBackend, cfg.Upload.UserQuota)
+		emojiSrv.SetUploadStore(uploadStore)
+		log.Printf("✓ Initialized upload store (%s storage)", cfg.Upload.Storage)
+
+		if uploadgc.NewCollector(appCtx, dbqueries, cfg.Server.UploadsDir, cfg.Upload) != nil {
+			log.Printf("✓ Initialized upload garbage collector (every %s, quarantine %s)", cfg.Upload.GCInterval, cfg.Upload.QuarantinePeriod)
+		}
+	}
+	log.Println("✓ Initialized emoji service")
+
+	// Alternate message-pipeline handlers under validation register here
+	canaries := canary.NewRegistry(canary.Config{
+		Percent: cfg.Canary.Percent,
+		Testers: cfg.Canary.Testers,
+	})
+	log.Printf("✓ Initialized canary routing (%d%% of users, %d testers)", cfg.Canary.Percent, len(cfg.Canary.Testers))
+
+	// A/B experiments count messages and WebSocket sessions of exposed users
+	experimentsSrv := experiments.NewService(appCtx, rdb, cfg.Experiments, csrv)
+	websocketManager.SetSessionObserver(experimentsSrv)
+	log.Printf("✓ Initialized experiments (%d configured)", len(cfg.Experiments))
+
+	// PDF exports render through an external service when one is configured
+	var pdfRenderer export.Renderer
+	if cfg.Export.PDFRendererURL != "" {
+		rendererClientCfg := cfg.Egress.HTTPClientConfig()
+		rendererClientCfg.Timeout = cfg.Export.PDFTimeout
+		pdfRenderer = export.NewChromiumRenderer(httpclient.New(rendererClientCfg), cfg.Export.PDFRendererURL)
+	}
+	exportSrv := export.NewExportService(dbqueries, csrv, gsrv, pdfRenderer, cfg.Export.MaxMessages)
+	log.Printf("✓ Initialized export service (PDF: %t)", exportSrv.PDFEnabled())
+
+	// Clients behind NAT get STUN servers and, when configured, TURN credentials
+	if callsSrv != nil {
+		callsSrv.SetICE(calls.ICEOptions{
+			STUNURLs: cfg.WebRTC.STUNURLs,
+			TURNURLs: cfg.WebRTC.TURNURLs,
+			Secret:   cfg.WebRTC.TURNSecret,
+			TTL:      cfg.WebRTC.CredentialTTL,
+		})
+		log.Printf("✓ Initialized ICE servers (TURN: %t)", len(cfg.WebRTC.TURNURLs) > 0)
+	}
+
+	// Calls are recorded through an external recorder when the policy allows it
+	if callsSrv != nil && cfg.Recording.Policy != calls.RecordingPolicyOff {
+		recorderClientCfg := cfg.Egress.HTTPClientConfig()
+		recorderClientCfg.Timeout = cfg.Recording.Timeout
+		callsSrv.SetRecorder(calls.NewHTTPRecorder(httpclient.New(recorderClientCfg), cfg.Recording.RecorderURL), calls.RecordingPolicy{
+			Mode:     cfg.Recording.Policy,
+			Roles:    cfg.Recording.Roles,
+			TokenTTL: cfg.Recording.TokenTTL,
+		})
+		log.Printf("✓ Initialized call recording (policy: %s)", cfg.Recording.Policy)
+	}
+
+	// In-call messages are appended to the participants' conversation once the call ends
+	callChat := calls.ChatOptions{TTL: cfg.CallChat.TTL}
+	if cfg.CallChat.ToConversation {
+		callChat.Archive = calls.ConversationArchiver(func(ctx context.Context, from, to, content string) error {
+			_, err := csrv.SendMessage(ctx, from, to, content)
+			return err
+		})
+	}
+	if callsSrv != nil {
+		callsSrv.SetChat(callChat)
+		log.Printf("✓ Initialized in-call chat (to conversation: %t)", cfg.CallChat.ToConversation)
+	}
+
+	// The compliance policy starts from the environment; admins change it at
+	// runtime and every instance applies the changes
+	complianceSrv := compliance.NewService(appCtx, dbqueries, compliance.DefaultPolicy(cfg))
+	complianceSrv.OnChange(func(p compliance.Policy) {
+		policy.SetConfig(p.ModerationConfig())
+		exportSrv.SetLimits(export.Limits{
+			MaxMessages:    p.Export.MaxMessages,
+			ShareLinks:     p.Export.ShareLinks,
+			ShareMaxExpiry: time.Duration(p.Export.ShareMaxExpiry),
+		})
+		if callsSrv != nil {
+			callChat.TTL = time.Duration(p.Retention.CallChat)
+			callsSrv.SetChat(callChat)
+		}
+	})
+	complianceSrv.StartRetention(appCtx)
+	log.Printf("✓ Initialized compliance policy (version %d)", complianceSrv.Current().Version)
+
+	clientLogsSrv := clientlogs.NewService(rdb, cfg.ClientLogs)
+	log.Printf("✓ Initialized client error reporting (sample rate %g)", cfg.ClientLogs.SampleRate)
+
+	// The public status page reports chat, calls and uploads from breaker
+	// states and self-checks run in the background
+	pingRedis := func(ctx context.Context) error { return rdb.Ping(ctx).Err() }
+	statusComponents := []status.Component{
+		{Name: "chat", Breakers: []string{"redis-chat", "kafka-chat"}, Check: pingRedis},
+	}
+	if callsSrv != nil {
+		statusComponents = append(statusComponents, status.Component{Name: "calls", Breakers: []string{"redis-calls"}, Check: pingRedis})
+	}
+	if uploadStore != nil {
+		statusComponents = append(statusComponents, status.Component{Name: "uploads", Breakers: []string{"postgres-uploads"}, Check: uploadStore.Check})
+	}
+	statusSrv := status.NewService(appCtx, rdb, statusComponents)
+	log.Println("✓ Initialized status page")
+
+	searchSrv := search.NewService(dbqueries)
+	if cfg.Messages.Backend == config.MessageBackendRedis {
+		searchSrv.SetBackfill(search.NewBackfill(appCtx, dbqueries, rdb, cfg.Kafka.Address, cfg.Search.BackfillRate))
+	}
+	log.Println("✓ Initialized message search")
+
+	directorySrv := directory.NewService(dbqueries)
+	log.Println("✓ Initialized user directory")
+
+	gifSrv := gifs.NewGifService(cfg.Gifs, httpClient, rdb)
+	if gifSrv != nil {
+		log.Printf("✓ Initialized GIF search (%s, rating %s)", cfg.Gifs.Provider, cfg.Gifs.Rating)
+	}
+
+	// Conversation summaries send transcripts to an LLM provider, so they
+	// are only available when enabled for the organization
+	var summarySrv *summaries.Service
+	if cfg.Summaries.Enabled {
+		summaryClientCfg := cfg.Egress.HTTPClientConfig()
+		summaryClientCfg.Timeout = cfg.Summaries.Timeout
+		summarizer := summaries.NewHTTPSummarizer(httpclient.New(summaryClientCfg), cfg.Summaries.ProviderURL, cfg.Summaries.APIKey, cfg.Summaries.Model)
+		summarySrv = summaries.NewService(cfg.Summaries, summarizer, csrv, rdb)
+		log.Printf("✓ Initialized conversation summaries (%s)", cfg.Summaries.Model)
+	}
+
+	// Digests email users who have been away about what they missed
+	var digestSrv *digests.Service
+	if cfg.Digests.Enabled {
+		mailer, err := digests.NewSMTPMailer(cfg.Digests.SMTPAddr, cfg.Digests.SMTPUsername, cfg.Digests.SMTPPassword, cfg.Digests.From)
+		if err != nil {
+			return fmt.Errorf("failed to create digest mailer: %w", err)
+		}
+		digestSrv = digests.NewService(appCtx, cfg.Digests, dbqueries, mailer, websocketManager)
+		log.Printf("✓ Initialized notification digests (after %s inactive)", cfg.Digests.InactiveAfter)
+	}
+
+	// Bots must register after demo seeding, which creates some bot accounts itself.
+	// The reminder bot is always on: its account delivers scheduled reminders.
+	botEngine := bots.NewEngine(appCtx, dbqueries, csrv, gsrv)
+
+	// Schedulers and bots run until the application context is cancelled
+	lc.Register("background services", lifecycle.PhaseWorkers, lifecycle.Func(func() {
+		appCancel()
+		botEngine.Wait()
+	}))
+
+	if err := botEngine.Register(appCtx, bots.NewReminderBot(remindersSrv)); err != nil {
+		return fmt.Errorf("failed to register reminder bot: %w", err)
+	}
+
+	// A copy, so appending cannot write into the config's backing array
+	enabledBots := slices.Clone(cfg.Bots.Enabled)
+	if *demoMode {
+		enabledBots = append(enabledBots, "echobot")
+	}
+	for _, bot := range bots.Builtin() {
+		if !slices.Contains(enabledBots, bot.Info().Username) {
+			continue
+		}
+		if err := botEngine.Register(appCtx, bot); err != nil {
+			return fmt.Errorf("failed to register bot: %w", err)
+		}
+		log.Printf("✓ Registered bot %s", bot.Info().Username)
+	}
 
 	// Create server
-	srv, err := server.NewServer(cfg, dbqueries, rdb, csrv, smngr, fsrv, gsrv, websocketManager, callsSrv)
+	srv, err := server.NewServer(cfg, dbqueries, rdb, csrv, smngr, fsrv, gsrv, websocketManager, callsSrv, psrv, clusterSrv, usrv, remindersSrv, gifSrv, emojiSrv, exportSrv, activityTracker, policy, canaries, uploadStore, sseBroker, maintenanceSrv, complianceSrv, clientLogsSrv, experimentsSrv, statusSrv, searchSrv, directorySrv, summarySrv, digestSrv, mutesSrv, inboxSrv, presenceSrv, impersonationSrv)
 	if err != nil {
 		return fmt.Errorf("failed to create server; err: %w", err)
 	}
+	lc.Register("http server", lifecycle.PhaseListeners, srv.Shutdown)
 
 	// Start server in goroutine
 	errChan := make(chan error, 1)
@@ -118,16 +521,32 @@ func run() error {
 		log.Printf("Received signal: %v. Shutting down gracefully...", sig)
 	}
 
-	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer shutdownCancel()
+	report := lc.Shutdown(context.Background())
+	if err := report.Print(os.Stdout); err != nil {
+		return err
+	}
+	if err := report.Err(); err != nil {
+		return fmt.Errorf("shutdown incomplete: %w", err)
+	}
 
-	// Cancel application context to stop background services
-	appCancel()
+	log.Println("✓ Server shutdown complete")
+	return nil
+}
 
-	if err := srv.Shutdown(shutdownCtx); err != nil {
-		return fmt.Errorf("server shutdown failed: %w", err)
+// runRedisAudit prints Redis usage by key family. It fails when keys lack a
+// TTL without a documented exemption, so it can gate deploys or run from cron.
+func runRedisAudit(ctx context.Context, rdb *redis.Client) error {
+	report, err := keyspace.Audit(ctx, rdb, keyspace.Options{})
+	if err != nil {
+		return fmt.Errorf("failed to audit Redis keyspace: %w", err)
 	}
 
-	log.Println("✓ Server shutdown complete")
+	if err := report.Print(os.Stdout); err != nil {
+		return err
+	}
+
+	if report.Violations > 0 {
+		return fmt.Errorf("%d Redis keys have no TTL and no documented exemption", report.Violations)
+	}
 	return nil
 }
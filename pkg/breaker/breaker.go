@@ -3,6 +3,7 @@ package breaker
 import (
 	"context"
 	"database/sql"
+	"exc6/pkg/instance"
 	"exc6/pkg/logger"
 	"sync"
 	"time"
@@ -36,8 +37,8 @@ var (
 
 func init() {
 	// Register metrics with Prometheus
-	prometheus.MustRegister(breakerState)
-	prometheus.MustRegister(breakerRequests)
+	instance.Registerer().MustRegister(breakerState)
+	instance.Registerer().MustRegister(breakerRequests)
 }
 
 // Config allows custom settings for specific breakers
@@ -114,6 +115,27 @@ func New(cfg Config) *gobreaker.CircuitBreaker {
 	return cb
 }
 
+// Forget stops reporting a breaker that is no longer used. The state of its
+// name is dropped with the last breaker sharing it.
+func Forget(cb *gobreaker.CircuitBreaker) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	name, ok := registry[cb]
+	if !ok {
+		return
+	}
+	delete(registry, cb)
+
+	for _, other := range registry {
+		if other == name {
+			return
+		}
+	}
+	breakerState.DeleteLabelValues(name)
+	breakerRequests.DeletePartialMatch(prometheus.Labels{"name": name})
+}
+
 // IsRecoverableError determines if an error should trip the circuit breaker
 func IsRecoverableError(err error) bool {
 	if err == nil {
@@ -185,3 +207,23 @@ func ExecuteCtx(ctx context.Context, cb *gobreaker.CircuitBreaker, fn func() (in
 
 	return Execute(cb, fn)
 }
+
+// States returns the state of every breaker by name. Breakers sharing a name
+// report the least healthy state among them.
+func States() map[string]string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	worst := make(map[string]gobreaker.State, len(registry))
+	for cb, name := range registry {
+		if state, seen := worst[name]; !seen || cb.State() > state {
+			worst[name] = cb.State()
+		}
+	}
+
+	states := make(map[string]string, len(worst))
+	for name, state := range worst {
+		states[name] = state.String()
+	}
+	return states
+}
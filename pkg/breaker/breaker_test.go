@@ -0,0 +1,42 @@
+package breaker
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStates(t *testing.T) {
+	healthy := New(Config{Name: "test-states"})
+	failing := New(Config{Name: "test-states", MinRequests: 2})
+	other := New(Config{Name: "test-states-other"})
+	t.Cleanup(func() {
+		Forget(healthy)
+		Forget(failing)
+		Forget(other)
+	})
+
+	assert.Equal(t, "closed", States()["test-states"])
+
+	// Not found is no failure
+	for range 3 {
+		_, err := Execute(healthy, func() (interface{}, error) { return nil, sql.ErrNoRows })
+		require.NoError(t, err)
+	}
+	assert.Equal(t, "closed", States()["test-states"])
+
+	for range 2 {
+		_, _ = Execute(failing, func() (interface{}, error) { return nil, errors.New("connection refused") })
+	}
+	states := States()
+	assert.Equal(t, "open", states["test-states"], "the least healthy breaker of a name")
+	assert.Equal(t, "closed", states["test-states-other"])
+
+	Forget(failing)
+	assert.Equal(t, "closed", States()["test-states"])
+	Forget(healthy)
+	assert.NotContains(t, States(), "test-states")
+}
@@ -0,0 +1,57 @@
+package breaker
+
+import (
+	"exc6/pkg/instance"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Reads that can fall back when a backend is unavailable return a Result
+// instead of failing or pretending the data is empty. Handlers render a
+// "temporarily unavailable" notice for degraded results, so an empty list
+// is only ever shown as "nothing here" when the backend said so.
+
+var degradedReads = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "degraded_reads_total",
+		Help: "Reads with a fallback by outcome: data, empty, or degraded when a backend failed",
+	},
+	[]string{"read", "outcome"}, // outcome: data, empty, degraded
+)
+
+func init() {
+	instance.Registerer().MustRegister(degradedReads)
+}
+
+// Result is the outcome of a read with a fallback
+type Result[T any] struct {
+	Value T
+
+	// Degraded means a backend failed and Value came from a fallback (stale
+	// cache or nothing); it may be incomplete, and empty says nothing about
+	// the real data
+	Degraded bool
+}
+
+// Healthy wraps a value read from the backend
+func Healthy[T any](value T) Result[T] {
+	return Result[T]{Value: value}
+}
+
+// Degraded wraps a fallback value served while the backend is failing
+func Degraded[T any](value T) Result[T] {
+	return Result[T]{Value: value, Degraded: true}
+}
+
+// ObserveRead records the outcome of a read; size is the number of items
+// returned
+func ObserveRead(read string, size int, degraded bool) {
+	outcome := "data"
+	switch {
+	case degraded:
+		outcome = "degraded"
+	case size == 0:
+		outcome = "empty"
+	}
+	degradedReads.WithLabelValues(read, outcome).Inc()
+}
@@ -0,0 +1,159 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"exc6/pkg/instance"
+	"exc6/pkg/logger"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// InvalidationChannel is the Redis Pub/Sub channel carrying invalidation events
+const InvalidationChannel = "cache:invalidate"
+
+// Kind identifies which family of cached entities an event refers to
+type Kind string
+
+const (
+	KindUser  Kind = "user"  // keys are usernames
+	KindGroup Kind = "group" // keys are group IDs
+	KindEmoji Kind = "emoji" // keys are custom emoji shortcodes
+
+	KindRestriction Kind = "restriction" // keys are usernames
+	KindMute        Kind = "mute"        // keys are "recipient:sender" username pairs
+
+	KindConversationMutes Kind = "conversation_mutes" // keys are usernames
+)
+
+// Event is a typed invalidation broadcast to every instance
+type Event struct {
+	Kind      Kind     `json:"kind"`
+	Keys      []string `json:"keys"`
+	Origin    string   `json:"origin"`
+	Timestamp int64    `json:"timestamp"`
+}
+
+// Handler drops the given keys from a local cache
+type Handler func(keys []string)
+
+// Invalidator fans invalidation events out to local caches on every instance.
+// Events are applied locally first and then published on InvalidationChannel;
+// each instance ignores the events it published itself.
+type Invalidator struct {
+	rdb    *redis.Client
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu       sync.RWMutex
+	handlers map[Kind][]Handler
+}
+
+// NewInvalidator creates an invalidator and starts listening for remote events
+func NewInvalidator(ctx context.Context, rdb *redis.Client) *Invalidator {
+	bgCtx, cancel := context.WithCancel(ctx)
+
+	inv := &Invalidator{
+		rdb:      rdb,
+		ctx:      bgCtx,
+		cancel:   cancel,
+		handlers: make(map[Kind][]Handler),
+	}
+
+	go inv.listen()
+
+	return inv
+}
+
+// OnInvalidate registers a handler for events of the given kind
+func (inv *Invalidator) OnInvalidate(kind Kind, handler Handler) {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	inv.handlers[kind] = append(inv.handlers[kind], handler)
+}
+
+// Invalidate drops the keys locally and tells the other instances to do the same
+func (inv *Invalidator) Invalidate(ctx context.Context, kind Kind, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	inv.apply(kind, keys)
+
+	payload, err := json.Marshal(Event{
+		Kind:      kind,
+		Keys:      keys,
+		Origin:    instance.ID(),
+		Timestamp: time.Now().Unix(),
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := inv.rdb.Publish(ctx, InvalidationChannel, payload).Err(); err != nil {
+		logger.WithFields(map[string]any{
+			"kind":  kind,
+			"keys":  keys,
+			"error": err.Error(),
+		}).Warn("Failed to publish cache invalidation")
+		return err
+	}
+
+	return nil
+}
+
+func (inv *Invalidator) apply(kind Kind, keys []string) {
+	inv.mu.RLock()
+	handlers := inv.handlers[kind]
+	inv.mu.RUnlock()
+
+	for _, handler := range handlers {
+		handler(keys)
+	}
+}
+
+// listen applies invalidation events published by other instances
+func (inv *Invalidator) listen() {
+	pubsub := inv.rdb.Subscribe(inv.ctx, InvalidationChannel)
+	defer pubsub.Close()
+
+	ch := pubsub.Channel()
+
+	for {
+		select {
+		case msg, ok := <-ch:
+			if !ok {
+				logger.Warn("Cache invalidation channel closed, stopping subscription")
+				return
+			}
+
+			var event Event
+			if err := json.Unmarshal([]byte(msg.Payload), &event); err != nil {
+				logger.WithError(err).Warn("Failed to unmarshal cache invalidation event")
+				continue
+			}
+
+			if event.Origin == instance.ID() {
+				continue
+			}
+
+			inv.apply(event.Kind, event.Keys)
+
+			logger.WithFields(map[string]any{
+				"kind":   event.Kind,
+				"keys":   event.Keys,
+				"origin": event.Origin,
+			}).Debug("Applied remote cache invalidation")
+
+		case <-inv.ctx.Done():
+			return
+		}
+	}
+}
+
+// Close stops listening for remote events
+func (inv *Invalidator) Close() {
+	inv.cancel()
+}
@@ -0,0 +1,87 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"exc6/pkg/instance"
+	"exc6/tests/fakeredis"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recorder collects the keys handed to a handler
+type recorder struct {
+	mu   sync.Mutex
+	keys []string
+}
+
+func (r *recorder) handle(keys []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.keys = append(r.keys, keys...)
+}
+
+func (r *recorder) seen() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.keys...)
+}
+
+func TestInvalidateLocally(t *testing.T) {
+	srv := fakeredis.New(t)
+	inv := NewInvalidator(context.Background(), srv.Client(t))
+	t.Cleanup(inv.Close)
+
+	users, groups := &recorder{}, &recorder{}
+	inv.OnInvalidate(KindUser, users.handle)
+	inv.OnInvalidate(KindGroup, groups.handle)
+
+	require.NoError(t, inv.Invalidate(context.Background(), KindUser, "alice", "bob"))
+	assert.Equal(t, []string{"alice", "bob"}, users.seen(), "applied before returning")
+	assert.Empty(t, groups.seen())
+
+	require.NoError(t, inv.Invalidate(context.Background(), KindUser))
+	assert.Len(t, users.seen(), 2, "nothing to invalidate")
+
+	// Local caches are dropped even when the others can't be told
+	srv.SetDown(true)
+	assert.Error(t, inv.Invalidate(context.Background(), KindUser, "carol"))
+	assert.Equal(t, []string{"alice", "bob", "carol"}, users.seen())
+}
+
+func TestInvalidateFromOtherInstances(t *testing.T) {
+	srv := fakeredis.New(t)
+	rdb := srv.Client(t)
+	inv := NewInvalidator(context.Background(), rdb)
+	t.Cleanup(inv.Close)
+
+	users := &recorder{}
+	inv.OnInvalidate(KindUser, users.handle)
+
+	publish := func(origin, key string) {
+		payload, err := json.Marshal(Event{Kind: KindUser, Keys: []string{key}, Origin: origin})
+		require.NoError(t, err)
+		require.NoError(t, rdb.Publish(context.Background(), InvalidationChannel, payload).Err())
+	}
+
+	// The subscription starts in the background
+	require.Eventually(t, func() bool {
+		publish("other", "alice")
+		return len(users.seen()) > 0
+	}, time.Second, 10*time.Millisecond)
+
+	// Events are handled in order, so once bob is seen carol's was skipped
+	publish(instance.ID(), "carol")
+	require.NoError(t, rdb.Publish(context.Background(), InvalidationChannel, "not json").Err())
+	publish("other", "bob")
+	require.Eventually(t, func() bool {
+		seen := users.seen()
+		return seen[len(seen)-1] == "bob"
+	}, time.Second, 10*time.Millisecond)
+
+	assert.NotContains(t, users.seen(), "carol", "an instance ignores its own events")
+}
@@ -0,0 +1,118 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Local is a size-bounded, in-process LRU cache with a per-entry TTL.
+// It is meant for small, hot lookups (users, groups) that are invalidated
+// across instances through an Invalidator.
+type Local[K comparable, V any] struct {
+	mu        sync.Mutex
+	items     map[K]*list.Element
+	evictList *list.List
+	capacity  int
+	ttl       time.Duration
+}
+
+type entry[K comparable, V any] struct {
+	key       K
+	value     V
+	expiresAt time.Time
+}
+
+// NewLocal creates a cache holding at most capacity entries for up to ttl each
+func NewLocal[K comparable, V any](capacity int, ttl time.Duration) *Local[K, V] {
+	if capacity <= 0 {
+		capacity = 1000
+	}
+	if ttl <= 0 {
+		ttl = 5 * time.Minute
+	}
+
+	return &Local[K, V]{
+		items:     make(map[K]*list.Element),
+		evictList: list.New(),
+		capacity:  capacity,
+		ttl:       ttl,
+	}
+}
+
+// Get returns the cached value if present and not expired
+func (l *Local[K, V]) Get(key K) (V, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var zero V
+
+	elem, ok := l.items[key]
+	if !ok {
+		return zero, false
+	}
+
+	e := elem.Value.(*entry[K, V])
+	if time.Now().After(e.expiresAt) {
+		l.evictList.Remove(elem)
+		delete(l.items, key)
+		return zero, false
+	}
+
+	l.evictList.MoveToFront(elem)
+	return e.value, true
+}
+
+// Set stores a value, evicting the least recently used entry when full
+func (l *Local[K, V]) Set(key K, value V) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	expiresAt := time.Now().Add(l.ttl)
+
+	if elem, ok := l.items[key]; ok {
+		l.evictList.MoveToFront(elem)
+		e := elem.Value.(*entry[K, V])
+		e.value = value
+		e.expiresAt = expiresAt
+		return
+	}
+
+	if l.evictList.Len() >= l.capacity {
+		if oldest := l.evictList.Back(); oldest != nil {
+			l.evictList.Remove(oldest)
+			delete(l.items, oldest.Value.(*entry[K, V]).key)
+		}
+	}
+
+	l.items[key] = l.evictList.PushFront(&entry[K, V]{key: key, value: value, expiresAt: expiresAt})
+}
+
+// Delete removes the given keys
+func (l *Local[K, V]) Delete(keys ...K) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, key := range keys {
+		if elem, ok := l.items[key]; ok {
+			l.evictList.Remove(elem)
+			delete(l.items, key)
+		}
+	}
+}
+
+// Purge removes every entry
+func (l *Local[K, V]) Purge() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.items = make(map[K]*list.Element)
+	l.evictList.Init()
+}
+
+// Len returns the number of entries, including ones that expired but were not yet evicted
+func (l *Local[K, V]) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.evictList.Len()
+}
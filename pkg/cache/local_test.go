@@ -0,0 +1,68 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocalEvictsLeastRecentlyUsed(t *testing.T) {
+	l := NewLocal[string, int](2, time.Minute)
+	l.Set("a", 1)
+	l.Set("b", 2)
+
+	// Reading a makes b the least recently used
+	_, ok := l.Get("a")
+	assert.True(t, ok)
+	l.Set("c", 3)
+
+	_, ok = l.Get("b")
+	assert.False(t, ok, "evicted")
+	v, ok := l.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, 1, v)
+	assert.Equal(t, 2, l.Len())
+
+	// Updating does not grow the cache
+	l.Set("c", 30)
+	v, _ = l.Get("c")
+	assert.Equal(t, 30, v)
+	assert.Equal(t, 2, l.Len())
+}
+
+func TestLocalExpiry(t *testing.T) {
+	l := NewLocal[string, int](10, 20*time.Millisecond)
+	l.Set("a", 1)
+	time.Sleep(30 * time.Millisecond)
+
+	_, ok := l.Get("a")
+	assert.False(t, ok)
+	assert.Zero(t, l.Len(), "expired entries are dropped when read")
+
+	// Setting again renews the entry
+	l.Set("b", 1)
+	time.Sleep(15 * time.Millisecond)
+	l.Set("b", 2)
+	time.Sleep(15 * time.Millisecond)
+	v, ok := l.Get("b")
+	assert.True(t, ok)
+	assert.Equal(t, 2, v)
+}
+
+func TestLocalDelete(t *testing.T) {
+	l := NewLocal[string, int](0, 0)
+	l.Set("a", 1)
+	l.Set("b", 2)
+	l.Set("c", 3)
+
+	l.Delete("a", "b", "missing")
+	_, ok := l.Get("a")
+	assert.False(t, ok)
+	assert.Equal(t, 1, l.Len())
+
+	l.Purge()
+	assert.Zero(t, l.Len())
+	_, ok = l.Get("c")
+	assert.False(t, ok)
+}
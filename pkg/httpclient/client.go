@@ -0,0 +1,379 @@
+package httpclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"exc6/pkg/breaker"
+	"exc6/pkg/logger"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/sony/gobreaker"
+)
+
+// IdempotencyKeyHeader marks a non-idempotent request (e.g. a webhook POST) as safe to retry
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// maxErrorBody limits how much of an error response body is kept for diagnostics
+const maxErrorBody = 4 << 10
+
+// maxRedirects is how many redirects a request follows, each of which must
+// be to an allowed destination
+const maxRedirects = 5
+
+// maxBreakers bounds the per-destination circuit breakers kept; past it the
+// least recently used one is dropped for a new destination
+const maxBreakers = 256
+
+// errTooManyRedirects stops a request after maxRedirects
+var errTooManyRedirects = fmt.Errorf("stopped after %d redirects", maxRedirects)
+
+// StatusError is returned for responses the client treats as failures
+type StatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("upstream responded with status %d", e.StatusCode)
+}
+
+// Client is a shared outbound HTTP client with connection pooling, per-destination
+// circuit breakers, retries with exponential backoff and Prometheus metrics.
+// It is safe for concurrent use; create one per process and share it.
+type Client struct {
+	cfg  Config
+	http *http.Client
+
+	mu          sync.Mutex
+	breakers    map[string]*hostBreaker
+	maxBreakers int
+}
+
+// hostBreaker is the circuit breaker of a destination and when it was last used
+type hostBreaker struct {
+	cb   *gobreaker.CircuitBreaker
+	used time.Time
+}
+
+// New creates a client
+func New(config ...Config) *Client {
+	cfg := configDefault(config...)
+
+	// The proxy URL is validated at startup (config.Validate); an invalid one
+	// here falls back to the environment rather than failing every request
+	var proxyURL *url.URL
+	if cfg.ProxyURL != "" {
+		parsed, err := ParseProxyURL(cfg.ProxyURL)
+		if err != nil {
+			logger.WithError(err).Warn("Ignoring invalid outbound proxy URL")
+		} else {
+			proxyURL = parsed
+		}
+	}
+
+	transport := &http.Transport{
+		Proxy: proxyFunc(proxyURL, cfg.NoProxy),
+		DialContext: (&net.Dialer{
+			Timeout:   5 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   cfg.MaxIdleConnsPerHost,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   5 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+
+	return &Client{
+		cfg: cfg,
+		http: &http.Client{
+			Transport:     transport,
+			Timeout:       cfg.Timeout,
+			CheckRedirect: checkRedirect(cfg.AllowedHosts),
+		},
+		breakers:    make(map[string]*hostBreaker),
+		maxBreakers: maxBreakers,
+	}
+}
+
+// checkRedirect holds every redirect to the egress policy, so an allowed
+// destination cannot send a request on to one that is not
+func checkRedirect(allowed []string) func(*http.Request, []*http.Request) error {
+	return func(req *http.Request, via []*http.Request) error {
+		if len(via) > maxRedirects {
+			return errTooManyRedirects
+		}
+
+		if !HostAllowed(allowed, destination(req.URL)) {
+			requestsTotal.WithLabelValues(hostLabel(req.URL.Host), "blocked").Inc()
+			logger.WithFields(map[string]interface{}{
+				"host":          req.URL.Host,
+				"redirect_from": via[len(via)-1].URL.Host,
+			}).Warn("Outbound redirect blocked by egress policy")
+			return fmt.Errorf("redirect to %q: %w", req.URL.Host, ErrDestinationNotAllowed)
+		}
+
+		return nil
+	}
+}
+
+// breakerFor returns the circuit breaker of a destination host, creating it on first use
+func (c *Client) breakerFor(host string) *gobreaker.CircuitBreaker {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if hb, ok := c.breakers[host]; ok {
+		hb.used = time.Now()
+		return hb.cb
+	}
+
+	if len(c.breakers) >= c.maxBreakers {
+		c.evictBreakerLocked()
+	}
+
+	cb := breaker.New(breaker.Config{
+		Name:        "http-" + host,
+		MaxRequests: 3,
+		Interval:    60 * time.Second,
+		Timeout:     30 * time.Second,
+		Threshold:   0.5,
+		MinRequests: 5,
+	})
+	c.breakers[host] = &hostBreaker{cb: cb, used: time.Now()}
+
+	return cb
+}
+
+// evictBreakerLocked drops the least recently used breaker. Must be called
+// with c.mu held.
+func (c *Client) evictBreakerLocked() {
+	var oldest string
+	for host, hb := range c.breakers {
+		if oldest == "" || hb.used.Before(c.breakers[oldest].used) {
+			oldest = host
+		}
+	}
+	if oldest == "" {
+		return
+	}
+
+	breaker.Forget(c.breakers[oldest].cb)
+	delete(c.breakers, oldest)
+}
+
+// Do sends the request. Network errors, 5xx and 429 responses are retried when
+// the request is idempotent (GET, HEAD, OPTIONS, PUT, DELETE) or carries an
+// Idempotency-Key header. Failures are returned as *apperrors.AppError; responses
+// below 500 are returned as-is and the caller owns the body.
+func (c *Client) Do(req *http.Request) (*http.Response, error) {
+	host := req.URL.Host
+
+	if !HostAllowed(c.cfg.AllowedHosts, destination(req.URL)) {
+		requestsTotal.WithLabelValues(hostLabel(host), "blocked").Inc()
+		logger.WithFields(map[string]interface{}{
+			"host":   host,
+			"method": req.Method,
+		}).Warn("Outbound request blocked by egress policy")
+		return nil, mapError(host, ErrDestinationNotAllowed)
+	}
+
+	cb := c.breakerFor(host)
+
+	if req.Header.Get("User-Agent") == "" {
+		req.Header.Set("User-Agent", c.cfg.UserAgent)
+	}
+
+	retryable := isRetryableRequest(req)
+
+	var lastErr error
+	for attempt := 0; attempt <= c.cfg.MaxRetries; attempt++ {
+		if attempt > 0 {
+			if !retryable || !shouldRetry(lastErr) {
+				break
+			}
+
+			if req.Body != nil && req.Body != http.NoBody {
+				if req.GetBody == nil {
+					break
+				}
+				body, err := req.GetBody()
+				if err != nil {
+					break
+				}
+				req.Body = body
+			}
+
+			retriesTotal.WithLabelValues(hostLabel(host)).Inc()
+
+			select {
+			case <-time.After(c.cfg.RetryBackoff << (attempt - 1)):
+			case <-req.Context().Done():
+				return nil, mapError(host, req.Context().Err())
+			}
+		}
+
+		resp, err := c.attempt(req, host, cb)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		// Rate limited: back off and retry if allowed, otherwise hand it to the caller
+		if resp.StatusCode == http.StatusTooManyRequests && retryable && attempt < c.cfg.MaxRetries {
+			lastErr = &StatusError{StatusCode: resp.StatusCode, Body: drain(resp)}
+			continue
+		}
+
+		return resp, nil
+	}
+
+	return nil, mapError(host, lastErr)
+}
+
+// attempt performs a single request through the destination's circuit breaker
+func (c *Client) attempt(req *http.Request, host string, cb *gobreaker.CircuitBreaker) (*http.Response, error) {
+	start := time.Now()
+
+	result, err := breaker.Execute(cb, func() (interface{}, error) {
+		resp, err := c.http.Do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode >= 500 {
+			return nil, &StatusError{StatusCode: resp.StatusCode, Body: drain(resp)}
+		}
+
+		return resp, nil
+	})
+
+	label := hostLabel(host)
+	requestDuration.WithLabelValues(label).Observe(time.Since(start).Seconds())
+
+	if err != nil {
+		var statusErr *StatusError
+		switch {
+		case errors.Is(err, gobreaker.ErrOpenState) || errors.Is(err, gobreaker.ErrTooManyRequests):
+			requestsTotal.WithLabelValues(label, "short_circuit").Inc()
+		case errors.Is(err, ErrDestinationNotAllowed):
+			// Counted as blocked by checkRedirect
+		case errors.As(err, &statusErr):
+			requestsTotal.WithLabelValues(label, statusClass(statusErr.StatusCode)).Inc()
+		default:
+			requestsTotal.WithLabelValues(label, "error").Inc()
+		}
+		return nil, err
+	}
+
+	resp := result.(*http.Response)
+	requestsTotal.WithLabelValues(label, statusClass(resp.StatusCode)).Inc()
+
+	return resp, nil
+}
+
+// NewJSONRequest builds a request with a JSON encoded body
+func NewJSONRequest(ctx context.Context, method, url string, body any) (*http.Request, error) {
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode request body: %w", err)
+		}
+		reader = bytes.NewReader(payload)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, reader)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	return req, nil
+}
+
+// DoJSON sends the request and decodes a successful JSON response into out (if non-nil).
+// Non-2xx responses are mapped to *apperrors.AppError.
+func (c *Client) DoJSON(req *http.Request, out any) error {
+	resp, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := CheckStatus(resp); err != nil {
+		return err
+	}
+
+	if out == nil {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return mapError(req.URL.Host, fmt.Errorf("failed to decode response: %w", err))
+	}
+
+	return nil
+}
+
+func isRetryableRequest(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return req.Header.Get(IdempotencyKeyHeader) != ""
+}
+
+// shouldRetry reports whether a failed attempt may succeed if repeated
+func shouldRetry(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	// An open breaker won't close within our backoff window
+	if errors.Is(err, gobreaker.ErrOpenState) || errors.Is(err, gobreaker.ErrTooManyRequests) {
+		return false
+	}
+
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	// Following the same redirects again ends the same way
+	if errors.Is(err, ErrDestinationNotAllowed) || errors.Is(err, errTooManyRedirects) {
+		return false
+	}
+
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		switch statusErr.StatusCode {
+		case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+
+	// Network-level failures (connection refused, reset, timeouts)
+	return true
+}
+
+// drain reads (a bounded prefix of) the body and closes it so the connection can be reused
+func drain(resp *http.Response) string {
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	io.Copy(io.Discard, resp.Body)
+	return string(body)
+}
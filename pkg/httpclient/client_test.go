@@ -0,0 +1,189 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"exc6/apperrors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func get(t *testing.T, c *Client, rawURL string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, rawURL, nil)
+	require.NoError(t, err)
+	return c.Do(req)
+}
+
+// host returns the host:port of a test server
+func host(t *testing.T, srv *httptest.Server) string {
+	u, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+	return u.Host
+}
+
+func TestHostAllowed(t *testing.T) {
+	allowed := []string{"hooks.example.com", "*.giphy.com", "10.0.0.1:8443"}
+
+	tests := []struct {
+		host string
+		want bool
+	}{
+		{"hooks.example.com", true},
+		{"HOOKS.example.com:443", true},
+		{"media.giphy.com:443", true},
+		{"giphy.com.evil.example", false},
+		{"10.0.0.1:8443", true},
+		{"10.0.0.1:80", false},
+		{"example.com", false},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, HostAllowed(allowed, tt.host), tt.host)
+	}
+	assert.True(t, HostAllowed(nil, "anything.internal"), "an empty allowlist allows everything")
+}
+
+func TestRedirectsFollowEgressPolicy(t *testing.T) {
+	var internalHits atomic.Int32
+	internal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		internalHits.Add(1)
+	}))
+	defer internal.Close()
+
+	public := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/internal":
+			http.Redirect(w, r, internal.URL+"/metadata", http.StatusFound)
+		case "/local":
+			http.Redirect(w, r, "/ok", http.StatusFound)
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer public.Close()
+
+	c := New(Config{AllowedHosts: []string{host(t, public)}, MaxRetries: 2, RetryBackoff: time.Millisecond})
+
+	resp, err := get(t, c, public.URL+"/local")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "redirects within the allowlist are followed")
+
+	_, err = get(t, c, public.URL+"/internal")
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, ErrDestinationNotAllowed))
+	var appErr *apperrors.AppError
+	require.True(t, errors.As(err, &appErr))
+	assert.Equal(t, http.StatusForbidden, appErr.StatusCode)
+	assert.Zero(t, internalHits.Load(), "the disallowed destination is never called, nor retried")
+}
+
+func TestRedirectLimit(t *testing.T) {
+	var hits atomic.Int32
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := hits.Add(1)
+		http.Redirect(w, r, fmt.Sprintf("%s/%d", srv.URL, n), http.StatusFound)
+	}))
+	defer srv.Close()
+
+	c := New(Config{MaxRetries: 2, RetryBackoff: time.Millisecond})
+
+	_, err := get(t, c, srv.URL)
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, errTooManyRedirects))
+	assert.Equal(t, int32(maxRedirects+1), hits.Load(), "one request and its redirects, not retried")
+}
+
+func TestRetries(t *testing.T) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hits.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	c := New(Config{MaxRetries: 2, RetryBackoff: time.Millisecond})
+
+	resp, err := get(t, c, srv.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, int32(3), hits.Load())
+
+	// POSTs are retried only with an idempotency key
+	hits.Store(0)
+	req, err := http.NewRequest(http.MethodPost, srv.URL, nil)
+	require.NoError(t, err)
+	_, err = c.Do(req)
+	require.Error(t, err)
+	assert.Equal(t, int32(1), hits.Load())
+}
+
+func TestBreakerPerHost(t *testing.T) {
+	var failingHits atomic.Int32
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		failingHits.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer healthy.Close()
+
+	c := New(Config{MaxRetries: 2, RetryBackoff: time.Millisecond})
+
+	// 500 is not retried, so each request is one failure towards the breaker
+	for i := 0; i < 5; i++ {
+		_, err := get(t, c, failing.URL)
+		require.Error(t, err)
+	}
+	require.Equal(t, int32(5), failingHits.Load())
+
+	_, err := get(t, c, failing.URL)
+	require.Error(t, err)
+	assert.Equal(t, int32(5), failingHits.Load(), "the open breaker keeps requests from the host")
+
+	resp, err := get(t, c, healthy.URL)
+	require.NoError(t, err, "other hosts have their own breaker")
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestBreakersAreBounded(t *testing.T) {
+	c := New()
+	c.maxBreakers = 3
+
+	for i := 0; i < 3; i++ {
+		c.breakerFor(fmt.Sprintf("host%d", i))
+	}
+	// host0 is used again, leaving host1 the least recently used
+	c.breakerFor("host0")
+	c.breakerFor("host3")
+
+	assert.Len(t, c.breakers, 3)
+	assert.Contains(t, c.breakers, "host0")
+	assert.NotContains(t, c.breakers, "host1")
+	assert.Contains(t, c.breakers, "host3")
+}
+
+func TestHostLabelsAreBounded(t *testing.T) {
+	for i := 0; i < maxHostLabels+10; i++ {
+		hostLabel(fmt.Sprintf("label-test-%d.example", i))
+	}
+	assert.Equal(t, "other", hostLabel("one-more.example"))
+	assert.LessOrEqual(t, len(hostLabels), maxHostLabels)
+}
@@ -0,0 +1,84 @@
+package httpclient
+
+import "time"
+
+// Config configures the outbound HTTP client
+type Config struct {
+	// Timeout bounds a single attempt, including reading the response body
+	//
+	// Optional. Default: 10 seconds
+	Timeout time.Duration
+
+	// MaxRetries is the number of additional attempts for retryable failures
+	//
+	// Optional. Default: 2
+	MaxRetries int
+
+	// RetryBackoff is the base delay between attempts; it doubles each retry
+	//
+	// Optional. Default: 200 milliseconds
+	RetryBackoff time.Duration
+
+	// MaxIdleConnsPerHost sizes the keep-alive pool per destination
+	//
+	// Optional. Default: 10
+	MaxIdleConnsPerHost int
+
+	// UserAgent is sent with every request that doesn't set one
+	//
+	// Optional. Default: "SArAChat/1.0"
+	UserAgent string
+
+	// ProxyURL routes all outbound requests through an HTTP(S) or SOCKS5 proxy.
+	// When empty, HTTP_PROXY/HTTPS_PROXY/NO_PROXY from the environment apply.
+	//
+	// Optional. Default: ""
+	ProxyURL string
+
+	// NoProxy lists destinations that bypass ProxyURL (same syntax as AllowedHosts)
+	//
+	// Optional. Default: nil
+	NoProxy []string
+
+	// AllowedHosts restricts outbound requests to these destinations. Entries are
+	// hostnames, IPs or "*.domain" wildcards, optionally with a ":port".
+	// An empty list allows every destination.
+	//
+	// Optional. Default: nil
+	AllowedHosts []string
+}
+
+// ConfigDefault provides default configuration
+var ConfigDefault = Config{
+	Timeout:             10 * time.Second,
+	MaxRetries:          2,
+	RetryBackoff:        200 * time.Millisecond,
+	MaxIdleConnsPerHost: 10,
+	UserAgent:           "SArAChat/1.0",
+}
+
+func configDefault(config ...Config) Config {
+	if len(config) < 1 {
+		return ConfigDefault
+	}
+
+	cfg := config[0]
+
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = ConfigDefault.Timeout
+	}
+	if cfg.MaxRetries < 0 {
+		cfg.MaxRetries = 0
+	}
+	if cfg.RetryBackoff <= 0 {
+		cfg.RetryBackoff = ConfigDefault.RetryBackoff
+	}
+	if cfg.MaxIdleConnsPerHost <= 0 {
+		cfg.MaxIdleConnsPerHost = ConfigDefault.MaxIdleConnsPerHost
+	}
+	if cfg.UserAgent == "" {
+		cfg.UserAgent = ConfigDefault.UserAgent
+	}
+
+	return cfg
+}
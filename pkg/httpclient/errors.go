@@ -0,0 +1,90 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"exc6/apperrors"
+	"net"
+	"net/http"
+
+	"github.com/sony/gobreaker"
+)
+
+// mapError converts a failed outbound request into an AppError.
+// Upstream failures are reported as 502/503/504 so they never leak as our own 500s.
+func mapError(host string, err error) *apperrors.AppError {
+	var appErr *apperrors.AppError
+	if errors.As(err, &appErr) {
+		return appErr
+	}
+
+	var statusErr *StatusError
+	var netErr net.Error
+
+	switch {
+	case errors.Is(err, ErrDestinationNotAllowed):
+		return upstreamError(host, apperrors.ErrCodeInvalidInput, "Destination is not allowed by the egress policy", http.StatusForbidden, err)
+
+	case errors.Is(err, gobreaker.ErrOpenState):
+		return apperrors.NewCircuitBreakerError("http:"+host, "open").WithInternal(err)
+
+	case errors.Is(err, gobreaker.ErrTooManyRequests):
+		return apperrors.NewCircuitBreakerError("http:"+host, "half-open").WithInternal(err)
+
+	case errors.As(err, &statusErr):
+		return upstreamError(host, apperrors.ErrCodeServiceUnavail, "Upstream service returned an error", http.StatusBadGateway, err).
+			WithDetails("upstream_status", statusErr.StatusCode)
+
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return upstreamError(host, apperrors.ErrCodeServiceUnavail, "Upstream request timed out", http.StatusGatewayTimeout, err)
+
+	case errors.Is(err, context.Canceled):
+		return upstreamError(host, apperrors.ErrCodeServiceUnavail, "Upstream request cancelled", http.StatusServiceUnavailable, err)
+
+	default:
+		return upstreamError(host, apperrors.ErrCodeServiceUnavail, "Upstream service unreachable", http.StatusBadGateway, err)
+	}
+}
+
+// CheckStatus maps a non-2xx response to an AppError, consuming and closing the body.
+// It returns nil for 2xx responses and leaves the body untouched.
+func CheckStatus(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+
+	host := resp.Request.URL.Host
+	statusErr := &StatusError{StatusCode: resp.StatusCode, Body: drain(resp)}
+
+	var appErr *apperrors.AppError
+	switch resp.StatusCode {
+	case http.StatusBadRequest, http.StatusUnprocessableEntity:
+		appErr = upstreamError(host, apperrors.ErrCodeInvalidInput, "Upstream service rejected the request", http.StatusBadGateway, statusErr)
+	case http.StatusUnauthorized, http.StatusForbidden:
+		appErr = upstreamError(host, apperrors.ErrCodeUnauthorized, "Upstream service rejected our credentials", http.StatusBadGateway, statusErr)
+	case http.StatusNotFound:
+		appErr = upstreamError(host, apperrors.ErrCodeNotFound, "Upstream resource not found", http.StatusBadGateway, statusErr)
+	case http.StatusTooManyRequests:
+		appErr = upstreamError(host, apperrors.ErrCodeRateLimited, "Upstream service is rate limiting us", http.StatusServiceUnavailable, statusErr).
+			WithDetails("retry_after", resp.Header.Get("Retry-After"))
+	default:
+		appErr = upstreamError(host, apperrors.ErrCodeServiceUnavail, "Upstream service returned an error", http.StatusBadGateway, statusErr)
+	}
+
+	return appErr.WithDetails("upstream_status", resp.StatusCode)
+}
+
+func upstreamError(host string, code apperrors.ErrorCode, message string, status int, err error) *apperrors.AppError {
+	appErr := apperrors.New(code, message, status).
+		WithOperation("http_client_request").
+		WithDetails("host", host).
+		WithContext("subsystem", "httpclient").
+		WithInternal(err)
+
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) && statusErr.Body != "" {
+		appErr = appErr.WithContext("upstream_body", statusErr.Body)
+	}
+
+	return appErr
+}
@@ -0,0 +1,79 @@
+package httpclient
+
+import (
+	"exc6/pkg/instance"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	requestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "http_client_requests_total",
+			Help: "Outbound HTTP attempts by destination host and result",
+		},
+		[]string{"host", "result"}, // result: 2xx, 3xx, 4xx, 5xx, error, short_circuit, blocked
+	)
+
+	requestDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "http_client_request_duration_seconds",
+			Help:    "Duration of outbound HTTP attempts by destination host",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"host"},
+	)
+
+	retriesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "http_client_retries_total",
+			Help: "Outbound HTTP retries by destination host",
+		},
+		[]string{"host"},
+	)
+)
+
+// maxHostLabels bounds the destinations the metrics are labelled with;
+// requests to any later ones are counted under "other"
+const maxHostLabels = 100
+
+var (
+	hostLabelsMu sync.Mutex
+	hostLabels   = make(map[string]struct{})
+)
+
+// hostLabel returns the host label of a destination
+func hostLabel(host string) string {
+	hostLabelsMu.Lock()
+	defer hostLabelsMu.Unlock()
+
+	if _, ok := hostLabels[host]; ok {
+		return host
+	}
+	if len(hostLabels) >= maxHostLabels {
+		return "other"
+	}
+	hostLabels[host] = struct{}{}
+	return host
+}
+
+func init() {
+	instance.Registerer().MustRegister(requestsTotal)
+	instance.Registerer().MustRegister(requestDuration)
+	instance.Registerer().MustRegister(retriesTotal)
+}
+
+// statusClass returns "2xx", "4xx", ... for a status code
+func statusClass(code int) string {
+	switch {
+	case code >= 500:
+		return "5xx"
+	case code >= 400:
+		return "4xx"
+	case code >= 300:
+		return "3xx"
+	default:
+		return "2xx"
+	}
+}
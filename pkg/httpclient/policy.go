@@ -0,0 +1,132 @@
+package httpclient
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ErrDestinationNotAllowed is returned when a request targets a host outside the egress allowlist
+var ErrDestinationNotAllowed = errors.New("destination not allowed by egress policy")
+
+// HostAllowed reports whether host (optionally with a port) matches one of the
+// allowlist entries. Entries are exact hostnames or IPs ("hooks.example.com"),
+// subdomain wildcards ("*.example.com") or either of those with a port
+// ("hooks.example.com:8443"). An empty allowlist allows every host.
+func HostAllowed(allowed []string, host string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+
+	hostname, port := splitHostPort(host)
+
+	for _, entry := range allowed {
+		entryHost, entryPort := splitHostPort(strings.ToLower(strings.TrimSpace(entry)))
+		if entryHost == "" {
+			continue
+		}
+
+		if entryPort != "" && entryPort != port {
+			continue
+		}
+
+		if strings.HasPrefix(entryHost, "*.") {
+			if strings.HasSuffix(hostname, entryHost[1:]) {
+				return true
+			}
+			continue
+		}
+
+		if hostname == entryHost {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CheckDestination validates an outbound URL against the allowlist. It is used at
+// startup to reject configured webhook/push destinations before they are first called.
+func CheckDestination(allowed []string, rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid URL: %w", err)
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("unsupported scheme %q (must be http or https)", u.Scheme)
+	}
+
+	if u.Host == "" {
+		return errors.New("missing host")
+	}
+
+	if !HostAllowed(allowed, destination(u)) {
+		return fmt.Errorf("host %q: %w", u.Host, ErrDestinationNotAllowed)
+	}
+
+	return nil
+}
+
+// ParseProxyURL validates an outbound proxy URL
+func ParseProxyURL(rawURL string) (*url.URL, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy URL: %w", err)
+	}
+
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q (must be http, https or socks5)", u.Scheme)
+	}
+
+	if u.Host == "" {
+		return nil, errors.New("proxy URL is missing a host")
+	}
+
+	return u, nil
+}
+
+// proxyFunc returns the transport's proxy selector. Without an explicit proxy the
+// standard HTTP_PROXY/HTTPS_PROXY/NO_PROXY environment variables apply.
+func proxyFunc(proxyURL *url.URL, noProxy []string) func(*http.Request) (*url.URL, error) {
+	if proxyURL == nil {
+		return http.ProxyFromEnvironment
+	}
+
+	return func(req *http.Request) (*url.URL, error) {
+		if len(noProxy) > 0 && HostAllowed(noProxy, destination(req.URL)) {
+			return nil, nil
+		}
+		return proxyURL, nil
+	}
+}
+
+// destination returns the URL's host with its explicit or scheme-default port
+func destination(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		switch u.Scheme {
+		case "https":
+			port = "443"
+		case "http":
+			port = "80"
+		}
+	}
+
+	if port == "" {
+		return u.Hostname()
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+func splitHostPort(host string) (string, string) {
+	if h, p, err := net.SplitHostPort(host); err == nil {
+		return strings.ToLower(h), p
+	}
+	return strings.ToLower(strings.Trim(host, "[]")), ""
+}
@@ -0,0 +1,64 @@
+package images
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"image/png"
+
+	// Decoders for every upload type
+	_ "image/gif"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// jpegQuality is used for resized JPEG photos
+const jpegQuality = 85
+
+// Fit scales img down so it fits within size×size, keeping its aspect ratio.
+// Images that already fit are returned as they are; nothing is enlarged.
+func Fit(img image.Image, size int) image.Image {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if w <= size && h <= size {
+		return img
+	}
+
+	if w >= h {
+		h = max(1, h*size/w)
+		w = size
+	} else {
+		w = max(1, w*size/h)
+		h = size
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, draw.Src, nil)
+	return dst
+}
+
+// Encode writes img as JPEG when it was decoded from a JPEG and as PNG
+// otherwise, which keeps the transparency of PNG, GIF and WebP images. It
+// returns the content and its MIME type; only the first frame of an
+// animation is kept.
+func Encode(img image.Image, format string) ([]byte, string, error) {
+	var buf bytes.Buffer
+	if format == "jpeg" {
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: jpegQuality}); err != nil {
+			return nil, "", err
+		}
+		return buf.Bytes(), "image/jpeg", nil
+	}
+
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), "image/png", nil
+}
+
+// Decode decodes an image of any upload type, returning its format name as
+// image.Decode does
+func Decode(content []byte) (image.Image, string, error) {
+	return image.Decode(bytes.NewReader(content))
+}
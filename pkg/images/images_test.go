@@ -0,0 +1,61 @@
+package images
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFit(t *testing.T) {
+	tests := []struct {
+		name          string
+		width, height int
+		size          int
+		wantW, wantH  int
+	}{
+		{"Landscape", 400, 200, 64, 64, 32},
+		{"Portrait", 100, 1000, 50, 5, 50},
+		{"Square", 512, 512, 64, 64, 64},
+		{"Already fits", 40, 30, 64, 40, 30},
+		{"Thin edge keeps a pixel", 1000, 2, 64, 64, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img := Fit(image.NewRGBA(image.Rect(0, 0, tt.width, tt.height)), tt.size)
+			assert.Equal(t, tt.wantW, img.Bounds().Dx())
+			assert.Equal(t, tt.wantH, img.Bounds().Dy())
+		})
+	}
+}
+
+func TestEncode(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+	img.Set(0, 0, color.NRGBA{R: 255, A: 128})
+
+	t.Run("JPEG stays JPEG", func(t *testing.T) {
+		content, mimeType, err := Encode(img, "jpeg")
+		require.NoError(t, err)
+		assert.Equal(t, "image/jpeg", mimeType)
+
+		_, format, err := Decode(content)
+		require.NoError(t, err)
+		assert.Equal(t, "jpeg", format)
+	})
+
+	t.Run("Other formats become PNG with transparency", func(t *testing.T) {
+		content, mimeType, err := Encode(img, "webp")
+		require.NoError(t, err)
+		assert.Equal(t, "image/png", mimeType)
+
+		decoded, err := png.Decode(bytes.NewReader(content))
+		require.NoError(t, err)
+		_, _, _, a := decoded.At(0, 0).RGBA()
+		assert.Less(t, a, uint32(0xffff))
+	})
+}
@@ -0,0 +1,56 @@
+package instance
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	hostname  string
+	id        string
+	startedAt time.Time
+)
+
+func init() {
+	startedAt = time.Now()
+
+	var err error
+	hostname, err = os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "unknown"
+	}
+
+	// A random suffix keeps IDs unique when several processes share a hostname
+	// (e.g. multiple instances on one machine or a restarted container)
+	suffix := make([]byte, 3)
+	if _, err := rand.Read(suffix); err != nil {
+		suffix = []byte{byte(startedAt.UnixNano()), byte(startedAt.UnixNano() >> 8), byte(startedAt.UnixNano() >> 16)}
+	}
+
+	id = hostname + "-" + hex.EncodeToString(suffix)
+}
+
+// ID returns the unique identifier of this server process
+func ID() string {
+	return id
+}
+
+// Hostname returns the hostname the instance is running on
+func Hostname() string {
+	return hostname
+}
+
+// StartedAt returns the time the process started
+func StartedAt() time.Time {
+	return startedAt
+}
+
+// Registerer returns a Prometheus registerer that stamps every metric
+// registered through it with the instance ID as a const label
+func Registerer() prometheus.Registerer {
+	return prometheus.WrapRegistererWith(prometheus.Labels{"instance_id": id}, prometheus.DefaultRegisterer)
+}
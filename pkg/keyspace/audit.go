@@ -0,0 +1,243 @@
+package keyspace
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// DefaultSampleSize is how many keys per family are measured for memory
+	DefaultSampleSize = 100
+
+	// maxExamples bounds the offending keys listed per family
+	maxExamples = 5
+
+	// TTL replies for keys without an expiry and keys that no longer exist
+	noExpiry = -1
+	missing  = -2
+)
+
+// Options tune an audit
+type Options struct {
+	// SampleSize is how many keys per family are measured with MEMORY USAGE;
+	// the family total is extrapolated from them
+	SampleSize int
+
+	// ScanCount is the COUNT hint passed to SCAN
+	ScanCount int64
+}
+
+// Stats summarizes the keys of one family
+type Stats struct {
+	Prefix      string `json:"prefix"`
+	Description string `json:"description,omitempty"`
+	Exempt      string `json:"exempt,omitempty"`
+
+	Keys    int64 `json:"keys"`
+	Sampled int   `json:"sampled"`
+
+	// MemoryBytes is estimated from the sampled keys
+	MemoryBytes int64 `json:"memory_bytes"`
+
+	// MissingTTL counts keys without an expiry
+	MissingTTL int64 `json:"missing_ttl"`
+
+	// Examples are some of the keys that violate the TTL policy
+	Examples []string `json:"examples,omitempty"`
+
+	sampledBytes int64
+}
+
+// Violations counts keys breaking the TTL policy
+func (s *Stats) Violations() int64 {
+	if s.Exempt != "" {
+		return 0
+	}
+	return s.MissingTTL
+}
+
+// Report is the result of an audit
+type Report struct {
+	Families []*Stats `json:"families"`
+
+	// Unregistered are keys that belong to no registered family
+	Unregistered *Stats `json:"unregistered"`
+
+	TotalKeys        int64 `json:"total_keys"`
+	TotalMemoryBytes int64 `json:"total_memory_bytes"`
+
+	// Violations counts keys without a TTL in non-exempt families plus
+	// unregistered keys
+	Violations int64 `json:"violations"`
+}
+
+// all returns the family stats followed by the unregistered keys
+func (r *Report) all() []*Stats {
+	return append(r.Families[:len(r.Families):len(r.Families)], r.Unregistered)
+}
+
+// Audit scans the whole keyspace, counting keys, their memory and missing
+// TTLs by family. SCAN is incremental, so it is safe against a live server,
+// but it reads every key and is meant to be run on demand.
+func Audit(ctx context.Context, rdb *redis.Client, opts Options) (*Report, error) {
+	if opts.SampleSize <= 0 {
+		opts.SampleSize = DefaultSampleSize
+	}
+	if opts.ScanCount <= 0 {
+		opts.ScanCount = 1000
+	}
+
+	fams := Families()
+	stats := make(map[string]*Stats, len(fams))
+	report := &Report{
+		Families:     make([]*Stats, 0, len(fams)),
+		Unregistered: &Stats{Description: "keys matching no registered family"},
+	}
+	for _, f := range fams {
+		s := &Stats{Prefix: f.Prefix, Description: f.Description, Exempt: f.Exempt}
+		stats[f.Prefix] = s
+		report.Families = append(report.Families, s)
+	}
+
+	var cursor uint64
+	for {
+		keys, next, err := rdb.Scan(ctx, cursor, "", opts.ScanCount).Result()
+		if err != nil {
+			return nil, fmt.Errorf("scan keyspace: %w", err)
+		}
+
+		if err := inspect(ctx, rdb, keys, fams, stats, report.Unregistered, opts.SampleSize); err != nil {
+			return nil, err
+		}
+
+		cursor = next
+		if cursor == 0 {
+			break
+		}
+	}
+
+	for _, s := range report.all() {
+		if s.Sampled > 0 {
+			s.MemoryBytes = s.sampledBytes * s.Keys / int64(s.Sampled)
+		}
+		report.TotalKeys += s.Keys
+		report.TotalMemoryBytes += s.MemoryBytes
+	}
+	for _, s := range report.Families {
+		report.Violations += s.Violations()
+	}
+	report.Violations += report.Unregistered.Keys
+
+	return report, nil
+}
+
+// inspect fetches the TTL of a batch of keys, and the memory of those still
+// needed for the sample, in one pipeline
+func inspect(ctx context.Context, rdb *redis.Client, keys []string, fams []Family, stats map[string]*Stats, unregistered *Stats, sampleSize int) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	owners := make([]*Stats, len(keys))
+	ttls := make([]*redis.DurationCmd, len(keys))
+	memory := make([]*redis.IntCmd, len(keys))
+
+	pipe := rdb.Pipeline()
+	for i, key := range keys {
+		owners[i] = unregistered
+		if f, ok := lookup(fams, key); ok {
+			owners[i] = stats[f.Prefix]
+		}
+
+		ttls[i] = pipe.TTL(ctx, key)
+		if owners[i].Sampled < sampleSize {
+			owners[i].Sampled++
+			memory[i] = pipe.MemoryUsage(ctx, key)
+		}
+	}
+
+	// Keys may expire between SCAN and the pipeline; their commands fail
+	// with redis.Nil and are skipped below
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return fmt.Errorf("inspect keys: %w", err)
+	}
+
+	for i, key := range keys {
+		s := owners[i]
+
+		ttl, err := ttls[i].Result()
+		if err != nil || ttl == missing {
+			if memory[i] != nil {
+				s.Sampled--
+			}
+			continue
+		}
+
+		s.Keys++
+		if memory[i] != nil {
+			if n, err := memory[i].Result(); err == nil {
+				s.sampledBytes += n
+			} else {
+				s.Sampled--
+			}
+		}
+
+		if ttl == noExpiry {
+			s.MissingTTL++
+		}
+		violates := s == unregistered || (ttl == noExpiry && s.Exempt == "")
+		if violates && len(s.Examples) < maxExamples {
+			s.Examples = append(s.Examples, key)
+		}
+	}
+
+	return nil
+}
+
+// Print writes the report as a table
+func (r *Report) Print(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintln(tw, "PREFIX\tKEYS\tMEMORY\tNO TTL\tPOLICY")
+	for _, s := range r.all() {
+		prefix, policy, violations := s.Prefix, "ttl required", s.Violations()
+		switch {
+		case s == r.Unregistered:
+			if s.Keys == 0 {
+				continue
+			}
+			prefix, policy, violations = "(unregistered)", "not allowed", s.Keys
+		case s.Exempt != "":
+			policy = "exempt: " + s.Exempt
+		}
+		if violations > 0 {
+			policy = "VIOLATION (" + policy + ")"
+		}
+
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%d\t%s\n", prefix, s.Keys, formatBytes(s.MemoryBytes), s.MissingTTL, policy)
+		for _, key := range s.Examples {
+			fmt.Fprintf(tw, "  %s\t\t\t\t\n", key)
+		}
+	}
+	fmt.Fprintf(tw, "TOTAL\t%d\t%s\t\t%d violations\n", r.TotalKeys, formatBytes(r.TotalMemoryBytes), r.Violations)
+
+	return tw.Flush()
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,75 @@
+package keyspace
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Family is a group of Redis keys sharing a prefix. Every key the app writes
+// must belong to a registered family and carry a TTL unless the family
+// documents why it doesn't need one.
+type Family struct {
+	// Prefix is the start of every key in the family. Exact keys such as
+	// queues are registered with their full name.
+	Prefix string
+
+	// Description says what the keys hold
+	Description string
+
+	// Exempt explains why keys in the family live without a TTL (e.g. they
+	// are deleted explicitly). Empty means every key must expire.
+	Exempt string
+}
+
+var (
+	mu       sync.RWMutex
+	families = make(map[string]Family)
+)
+
+// Register records the key families a package writes. Packages call it from
+// init next to the code that builds the keys.
+func Register(fams ...Family) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	for _, f := range fams {
+		if f.Prefix == "" {
+			panic("keyspace: family without prefix")
+		}
+		if _, exists := families[f.Prefix]; exists {
+			panic("keyspace: family registered twice: " + f.Prefix)
+		}
+		families[f.Prefix] = f
+	}
+}
+
+// Families returns the registered families sorted by prefix
+func Families() []Family {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	out := make([]Family, 0, len(families))
+	for _, f := range families {
+		out = append(out, f)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Prefix < out[j].Prefix })
+	return out
+}
+
+// Lookup returns the family a key belongs to, preferring the longest
+// matching prefix
+func Lookup(key string) (Family, bool) {
+	return lookup(Families(), key)
+}
+
+func lookup(fams []Family, key string) (Family, bool) {
+	var best Family
+	found := false
+	for _, f := range fams {
+		if strings.HasPrefix(key, f.Prefix) && len(f.Prefix) > len(best.Prefix) {
+			best, found = f, true
+		}
+	}
+	return best, found
+}
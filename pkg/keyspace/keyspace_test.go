@@ -0,0 +1,53 @@
+package keyspace
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLookup(t *testing.T) {
+	fams := []Family{
+		{Prefix: "chat:conv:"},
+		{Prefix: "chat:pending_messages", Exempt: "queue"},
+		{Prefix: "chat:"},
+	}
+
+	tests := []struct {
+		name       string
+		key        string
+		wantPrefix string
+		wantFound  bool
+	}{
+		{name: "Longest prefix wins", key: "chat:conv:alice:bob", wantPrefix: "chat:conv:", wantFound: true},
+		{name: "Exact key", key: "chat:pending_messages", wantPrefix: "chat:pending_messages", wantFound: true},
+		{name: "Shorter prefix", key: "chat:alice:bob", wantPrefix: "chat:", wantFound: true},
+		{name: "Unregistered", key: "session:abc", wantFound: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, found := lookup(fams, tt.key)
+			assert.Equal(t, tt.wantFound, found)
+			assert.Equal(t, tt.wantPrefix, f.Prefix)
+		})
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	tests := []struct {
+		name string
+		n    int64
+		want string
+	}{
+		{name: "Bytes", n: 512, want: "512 B"},
+		{name: "Kibibytes", n: 1536, want: "1.5 KiB"},
+		{name: "Mebibytes", n: 3 * 1024 * 1024, want: "3.0 MiB"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, formatBytes(tt.n))
+		})
+	}
+}
@@ -0,0 +1,250 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// Components register how they stop as they are created, each in a phase.
+// Shutdown runs the phases in order: new requests stop first, then the
+// WebSocket connections still open, then the background workers, and the
+// clients of the backends last so everything before them can still flush.
+// Components of one phase stop concurrently within the phase's timeout; one
+// that does not return in time is left behind and reported, and the next
+// phase starts.
+
+// Phase orders shutdown
+type Phase int
+
+const (
+	// PhaseListeners stop accepting requests and end the streaming
+	// responses the HTTP server waits for
+	PhaseListeners Phase = iota
+
+	// PhaseConnections close the WebSocket connections, which the HTTP
+	// server hands off and does not wait for
+	PhaseConnections
+
+	// PhaseWorkers stop schedulers, heartbeats, buffers and consumers
+	PhaseWorkers
+
+	// PhaseBackends flush and close the clients of Kafka, Redis and Postgres
+	PhaseBackends
+)
+
+// DefaultPhaseTimeout bounds a phase without a timeout of its own
+const DefaultPhaseTimeout = 5 * time.Second
+
+func (p Phase) String() string {
+	switch p {
+	case PhaseListeners:
+		return "listeners"
+	case PhaseConnections:
+		return "connections"
+	case PhaseWorkers:
+		return "workers"
+	case PhaseBackends:
+		return "backends"
+	default:
+		return fmt.Sprintf("phase %d", int(p))
+	}
+}
+
+// StopFunc stops a component, giving up when ctx is done
+type StopFunc func(ctx context.Context) error
+
+// Func adapts a stop function that neither fails nor takes a context
+func Func(fn func()) StopFunc {
+	return func(context.Context) error {
+		fn()
+		return nil
+	}
+}
+
+// Closer adapts an io.Closer
+func Closer(c io.Closer) StopFunc {
+	return func(context.Context) error {
+		return c.Close()
+	}
+}
+
+type component struct {
+	name  string
+	phase Phase
+	stop  StopFunc
+}
+
+// Coordinator stops the registered components in phases
+type Coordinator struct {
+	mu         sync.Mutex
+	components []component
+	timeouts   map[Phase]time.Duration
+}
+
+// New creates a coordinator without components
+func New() *Coordinator {
+	return &Coordinator{timeouts: make(map[Phase]time.Duration)}
+}
+
+// SetTimeout sets how long the components of a phase have to stop
+func (c *Coordinator) SetTimeout(phase Phase, d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.timeouts[phase] = d
+}
+
+// Register adds a component to stop in phase
+func (c *Coordinator) Register(name string, phase Phase, stop StopFunc) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.components = append(c.components, component{name: name, phase: phase, stop: stop})
+}
+
+// Result is how stopping one component went
+type Result struct {
+	Name     string        `json:"name"`
+	Phase    Phase         `json:"phase"`
+	Duration time.Duration `json:"duration"`
+	Err      error         `json:"-"`
+
+	// TimedOut is set when the component had not stopped by the end of its
+	// phase
+	TimedOut bool `json:"timed_out"`
+}
+
+// Failed reports whether the component did not stop cleanly
+func (r Result) Failed() bool {
+	return r.Err != nil || r.TimedOut
+}
+
+// Report lists how every component stopped, in shutdown order
+type Report struct {
+	Results  []Result      `json:"results"`
+	Duration time.Duration `json:"duration"`
+}
+
+// Failed returns the components that did not stop cleanly
+func (r *Report) Failed() []Result {
+	var failed []Result
+	for _, result := range r.Results {
+		if result.Failed() {
+			failed = append(failed, result)
+		}
+	}
+	return failed
+}
+
+// Err joins the errors of the components that did not stop cleanly
+func (r *Report) Err() error {
+	var errs []error
+	for _, result := range r.Failed() {
+		if result.TimedOut {
+			errs = append(errs, fmt.Errorf("%s: did not stop within the %s phase", result.Name, result.Phase))
+			continue
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", result.Name, result.Err))
+	}
+	return errors.Join(errs...)
+}
+
+// Print writes the report as a table
+func (r *Report) Print(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PHASE\tCOMPONENT\tTOOK\tRESULT")
+	for _, result := range r.Results {
+		outcome := "ok"
+		switch {
+		case result.TimedOut:
+			outcome = "timed out"
+		case result.Err != nil:
+			outcome = "failed: " + result.Err.Error()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", result.Phase, result.Name, result.Duration.Round(time.Millisecond), outcome)
+	}
+	fmt.Fprintf(tw, "\t\t%s\t%d failed\n", r.Duration.Round(time.Millisecond), len(r.Failed()))
+	return tw.Flush()
+}
+
+// Shutdown stops the registered components phase by phase and reports how
+// each went. Components are stopped once; a second call stops only those
+// registered since. Cancelling ctx ends the current phase early.
+func (c *Coordinator) Shutdown(ctx context.Context) *Report {
+	c.mu.Lock()
+	components := c.components
+	c.components = nil
+	timeouts := make(map[Phase]time.Duration, len(c.timeouts))
+	for phase, d := range c.timeouts {
+		timeouts[phase] = d
+	}
+	c.mu.Unlock()
+
+	sort.SliceStable(components, func(i, j int) bool {
+		return components[i].phase < components[j].phase
+	})
+
+	start := time.Now()
+	report := &Report{Results: make([]Result, 0, len(components))}
+	for i := 0; i < len(components); {
+		j := i
+		for j < len(components) && components[j].phase == components[i].phase {
+			j++
+		}
+
+		timeout, ok := timeouts[components[i].phase]
+		if !ok {
+			timeout = DefaultPhaseTimeout
+		}
+		report.Results = append(report.Results, stopPhase(ctx, components[i:j], timeout)...)
+		i = j
+	}
+	report.Duration = time.Since(start)
+
+	return report
+}
+
+// stopPhase stops components concurrently, waiting at most timeout
+func stopPhase(ctx context.Context, components []component, timeout time.Duration) []Result {
+	phaseCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	results := make([]Result, len(components))
+	done := make(chan int, len(components))
+	start := time.Now()
+
+	for i, comp := range components {
+		results[i] = Result{Name: comp.name, Phase: comp.phase}
+		go func() {
+			err := comp.stop(phaseCtx)
+			results[i].Err = err
+			results[i].Duration = time.Since(start)
+			done <- i
+		}()
+	}
+
+	finished := make([]bool, len(components))
+	for range components {
+		select {
+		case i := <-done:
+			finished[i] = true
+		case <-phaseCtx.Done():
+			// Components still running keep their goroutine; their results
+			// are copied out below so it cannot race with the report
+			out := make([]Result, len(components))
+			for i := range components {
+				if finished[i] {
+					out[i] = results[i]
+					continue
+				}
+				out[i] = Result{Name: components[i].name, Phase: components[i].phase, Duration: time.Since(start), TimedOut: true}
+			}
+			return out
+		}
+	}
+	return results
+}
@@ -0,0 +1,79 @@
+package lifecycle
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShutdownOrder(t *testing.T) {
+	c := New()
+
+	var mu sync.Mutex
+	var order []string
+	stop := func(name string) StopFunc {
+		return Func(func() {
+			mu.Lock()
+			defer mu.Unlock()
+			order = append(order, name)
+		})
+	}
+
+	c.Register("redis", PhaseBackends, stop("redis"))
+	c.Register("chat", PhaseWorkers, stop("chat"))
+	c.Register("http", PhaseListeners, stop("http"))
+	c.Register("websocket", PhaseConnections, stop("websocket"))
+
+	report := c.Shutdown(context.Background())
+	assert.Equal(t, []string{"http", "websocket", "chat", "redis"}, order)
+	require.Len(t, report.Results, 4)
+	assert.Empty(t, report.Failed())
+	assert.NoError(t, report.Err())
+
+	// Components are stopped once
+	assert.Empty(t, c.Shutdown(context.Background()).Results)
+	assert.Len(t, order, 4)
+}
+
+func TestShutdownFailures(t *testing.T) {
+	c := New()
+	c.SetTimeout(PhaseWorkers, 50*time.Millisecond)
+
+	release := make(chan struct{})
+	defer close(release)
+
+	c.Register("consumer", PhaseWorkers, func(ctx context.Context) error {
+		<-release
+		return nil
+	})
+	c.Register("scheduler", PhaseWorkers, Func(func() {}))
+	c.Register("producer", PhaseBackends, func(context.Context) error {
+		return errors.New("flush failed")
+	})
+
+	start := time.Now()
+	report := c.Shutdown(context.Background())
+	assert.Less(t, time.Since(start), time.Second, "a stuck component does not hold up shutdown")
+
+	failed := report.Failed()
+	require.Len(t, failed, 2)
+	assert.Equal(t, "consumer", failed[0].Name)
+	assert.True(t, failed[0].TimedOut)
+	assert.Equal(t, "producer", failed[1].Name)
+	assert.EqualError(t, failed[1].Err, "flush failed")
+
+	err := report.Err()
+	assert.ErrorContains(t, err, "consumer: did not stop within the workers phase")
+	assert.ErrorContains(t, err, "producer: flush failed")
+
+	var out bytes.Buffer
+	require.NoError(t, report.Print(&out))
+	assert.Contains(t, out.String(), "timed out")
+	assert.Contains(t, out.String(), "2 failed")
+}
@@ -1,6 +1,7 @@
 package logger
 
 import (
+	"exc6/pkg/instance"
 	"fmt"
 	"io"
 	"log"
@@ -63,6 +64,9 @@ type Config struct {
 
 	// Output allows setting custom output writer (for testing)
 	Output io.Writer
+
+	// Fields are attached to every line written by this logger
+	Fields map[string]any
 }
 
 // DefaultConfig returns sensible defaults
@@ -119,7 +123,7 @@ func NewWithConfig(cfg Config) (*Logger, error) {
 		return &Logger{
 			logger:       log.New(writer, "", 0),
 			level:        cfg.Level,
-			fields:       make(map[string]any),
+			fields:       copyFields(cfg.Fields),
 			rotator:      rotator,
 			OutputWriter: writer,
 		}, nil
@@ -128,12 +132,25 @@ func NewWithConfig(cfg Config) (*Logger, error) {
 	return &Logger{
 		logger:       log.New(writer, "", 0),
 		level:        cfg.Level,
-		fields:       make(map[string]any),
+		fields:       copyFields(cfg.Fields),
 		rotator:      nil,
 		OutputWriter: writer,
 	}, nil
 }
 
+// InstanceFields returns the base fields identifying this server instance
+func InstanceFields() map[string]any {
+	return map[string]any{"instance": instance.ID()}
+}
+
+func copyFields(fields map[string]any) map[string]any {
+	copied := make(map[string]any, len(fields))
+	for k, v := range fields {
+		copied[k] = v
+	}
+	return copied
+}
+
 // New creates a new logger with default rotation settings
 func New(logfile string) *Logger {
 	logger, err := NewWithConfig(DefaultConfig(logfile))
@@ -289,7 +306,7 @@ var defaultLogger *Logger
 
 func init() {
 	// Initialize with stdout by default
-	defaultLogger, _ = NewWithConfig(Config{Output: os.Stdout, Level: INFO})
+	defaultLogger, _ = NewWithConfig(Config{Output: os.Stdout, Level: INFO, Fields: InstanceFields()})
 }
 
 // SetDefault sets the default global logger
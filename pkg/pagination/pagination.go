@@ -0,0 +1,186 @@
+package pagination
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"exc6/apperrors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultLimit is the page size when none is requested
+	DefaultLimit = 20
+
+	// MaxLimit is the largest page size a client may request
+	MaxLimit = 100
+)
+
+// Order is the direction a list is sorted in
+type Order int
+
+const (
+	Ascending Order = iota
+	Descending
+)
+
+// Cursor is the position of the last item of a page. Lists are ordered by a
+// sort key with a unique ID as tie-breaker, and the next page starts strictly
+// after the cursor, so items added or removed between requests never cause
+// duplicates or gaps.
+type Cursor struct {
+	Key string `json:"k"`
+	ID  string `json:"i,omitempty"`
+}
+
+// Encode returns the opaque form of the cursor used in URLs
+func (c Cursor) Encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// Decode parses an opaque cursor. An empty string is the first page.
+func Decode(s string) (*Cursor, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, apperrors.NewValidationError("Invalid pagination cursor")
+	}
+
+	var c Cursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, apperrors.NewValidationError("Invalid pagination cursor")
+	}
+
+	return &c, nil
+}
+
+// Compare orders cursors by key, then ID
+func Compare(a, b Cursor) int {
+	if c := strings.Compare(a.Key, b.Key); c != 0 {
+		return c
+	}
+	return strings.Compare(a.ID, b.ID)
+}
+
+// TimeKey returns a sort key that orders times correctly as strings
+func TimeKey(t time.Time) string {
+	return IntKey(t.UnixNano())
+}
+
+// IntKey returns a sort key that orders non-negative integers correctly as strings
+func IntKey(n int64) string {
+	return fmt.Sprintf("%019d", n)
+}
+
+// ParseIntKey reverses IntKey
+func ParseIntKey(key string) (int64, error) {
+	return strconv.ParseInt(key, 10, 64)
+}
+
+// Params are the pagination inputs of a list request
+type Params struct {
+	Limit int
+
+	// After is the cursor of the previous page's last item; nil for the first page
+	After *Cursor
+}
+
+// ClampLimit returns limit bounded to [1, MaxLimit], or DefaultLimit if unset
+func ClampLimit(limit int) int {
+	switch {
+	case limit <= 0:
+		return DefaultLimit
+	case limit > MaxLimit:
+		return MaxLimit
+	default:
+		return limit
+	}
+}
+
+// Parse reads the "limit" and "cursor" query parameters of a request
+func Parse(limit, cursor string) (Params, error) {
+	var p Params
+
+	if limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil {
+			return p, apperrors.NewValidationError("limit must be a number")
+		}
+		p.Limit = n
+	}
+	p.Limit = ClampLimit(p.Limit)
+
+	after, err := Decode(cursor)
+	if err != nil {
+		return p, err
+	}
+	p.After = after
+
+	return p, nil
+}
+
+// Page is one page of a list
+type Page[T any] struct {
+	Items      []T    `json:"items"`
+	NextCursor string `json:"next_cursor,omitempty"`
+	HasMore    bool   `json:"has_more"`
+}
+
+// New builds a page from items that follow the cursor in order, fetched with
+// one extra item (Limit+1) to detect whether more exist
+func New[T any](items []T, p Params, key func(T) Cursor) Page[T] {
+	limit := ClampLimit(p.Limit)
+
+	page := Page[T]{Items: items}
+	if page.Items == nil {
+		page.Items = []T{}
+	}
+
+	if len(items) > limit {
+		page.Items = items[:limit]
+		page.HasMore = true
+		page.NextCursor = key(page.Items[limit-1]).Encode()
+	}
+
+	return page
+}
+
+// Apply sorts an in-memory list stably and returns the page following p.After
+func Apply[T any](items []T, p Params, order Order, key func(T) Cursor) Page[T] {
+	sorted := make([]T, len(items))
+	copy(sorted, items)
+	Sort(sorted, order, key)
+
+	start := 0
+	if p.After != nil {
+		start = sort.Search(len(sorted), func(i int) bool {
+			return Follows(key(sorted[i]), *p.After, order)
+		})
+	}
+
+	end := min(start+ClampLimit(p.Limit)+1, len(sorted))
+
+	return New(sorted[start:end], p, key)
+}
+
+// Sort orders items stably by their cursors
+func Sort[T any](items []T, order Order, key func(T) Cursor) {
+	sort.SliceStable(items, func(i, j int) bool {
+		return Follows(key(items[j]), key(items[i]), order)
+	})
+}
+
+// Follows reports whether c comes after the cursor in the given order
+func Follows(c, after Cursor, order Order) bool {
+	if order == Descending {
+		return Compare(c, after) < 0
+	}
+	return Compare(c, after) > 0
+}
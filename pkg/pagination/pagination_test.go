@@ -0,0 +1,156 @@
+package pagination
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type item struct {
+	name string
+	id   int
+}
+
+func itemCursor(it item) Cursor {
+	return Cursor{Key: it.name, ID: strconv.Itoa(it.id)}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name      string
+		limit     string
+		cursor    string
+		wantLimit int
+		wantAfter *Cursor
+		wantErr   bool
+	}{
+		{
+			name:      "Defaults",
+			wantLimit: DefaultLimit,
+		},
+		{
+			name:      "Explicit limit",
+			limit:     "5",
+			wantLimit: 5,
+		},
+		{
+			name:      "Limit clamped to maximum",
+			limit:     "1000",
+			wantLimit: MaxLimit,
+		},
+		{
+			name:      "Non-positive limit uses default",
+			limit:     "-3",
+			wantLimit: DefaultLimit,
+		},
+		{
+			name:      "Cursor round trip",
+			cursor:    Cursor{Key: "bob", ID: "7"}.Encode(),
+			wantLimit: DefaultLimit,
+			wantAfter: &Cursor{Key: "bob", ID: "7"},
+		},
+		{
+			name:    "Invalid limit",
+			limit:   "ten",
+			wantErr: true,
+		},
+		{
+			name:    "Invalid cursor",
+			cursor:  "not a cursor!",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := Parse(tt.limit, tt.cursor)
+			if tt.wantErr {
+				assert.NotNil(t, err)
+				return
+			}
+
+			assert.Nil(t, err)
+			assert.Equal(t, tt.wantLimit, p.Limit)
+			assert.Equal(t, tt.wantAfter, p.After)
+		})
+	}
+}
+
+func TestApply(t *testing.T) {
+	items := []item{
+		{"carol", 3}, {"alice", 1}, {"bob", 2}, {"bob", 4}, {"dave", 5},
+	}
+
+	tests := []struct {
+		name     string
+		order    Order
+		limit    int
+		after    *Cursor
+		wantIDs  []int
+		wantMore bool
+	}{
+		{
+			name:     "First page ascending",
+			order:    Ascending,
+			limit:    2,
+			wantIDs:  []int{1, 2},
+			wantMore: true,
+		},
+		{
+			name:     "Ties broken by ID",
+			order:    Ascending,
+			limit:    2,
+			after:    &Cursor{Key: "alice", ID: "1"},
+			wantIDs:  []int{2, 4},
+			wantMore: true,
+		},
+		{
+			name:     "Last page",
+			order:    Ascending,
+			limit:    2,
+			after:    &Cursor{Key: "bob", ID: "4"},
+			wantIDs:  []int{3, 5},
+			wantMore: false,
+		},
+		{
+			name:     "Descending",
+			order:    Descending,
+			limit:    3,
+			wantIDs:  []int{5, 3, 4},
+			wantMore: true,
+		},
+		{
+			name:     "Cursor of a removed item",
+			order:    Ascending,
+			limit:    10,
+			after:    &Cursor{Key: "bz", ID: "9"},
+			wantIDs:  []int{3, 5},
+			wantMore: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page := Apply(items, Params{Limit: tt.limit, After: tt.after}, tt.order, itemCursor)
+
+			ids := make([]int, 0, len(page.Items))
+			for _, it := range page.Items {
+				ids = append(ids, it.id)
+			}
+
+			assert.Equal(t, tt.wantIDs, ids)
+			assert.Equal(t, tt.wantMore, page.HasMore)
+			assert.Equal(t, tt.wantMore, page.NextCursor != "")
+		})
+	}
+}
+
+func TestIntKeyOrdering(t *testing.T) {
+	assert.Less(t, IntKey(9), IntKey(10))
+	assert.Less(t, IntKey(1700000000), IntKey(1800000000))
+
+	n, err := ParseIntKey(IntKey(42))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(42), n)
+}
@@ -0,0 +1,162 @@
+package timing
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A Breakdown records how long a request spent in each stage of serving it.
+// The timing middleware puts one in the request's context; code serving the
+// request times its stages with Track on a context derived from it, and
+// stages timed on other contexts are not recorded.
+//
+// Auth, session, service and render are consecutive parts of a request, so
+// together with "other" they add up to its total. Redis is timed by a client
+// hook wherever the commands are issued, mostly within the session and
+// service stages, and is reported alongside them rather than added.
+
+// Stage names a part of serving a request
+type Stage string
+
+const (
+	// StageAuth is checking credentials, such as a login's password hash
+	StageAuth Stage = "auth"
+
+	// StageSession is loading, saving or renewing the session
+	StageSession Stage = "session"
+
+	// StageService is the calls the handler makes to services
+	StageService Stage = "service"
+
+	// StageRender is rendering templates
+	StageRender Stage = "render"
+
+	// StageRedis is Redis commands, within the other stages
+	StageRedis Stage = "redis"
+
+	// StageOther is the rest of the request: middleware, parsing and
+	// writing the response
+	StageOther Stage = "other"
+)
+
+// Stages are the consecutive stages of a request, in the order they are
+// reported
+var Stages = []Stage{StageAuth, StageSession, StageService, StageRender}
+
+// Timing is the time spent in one stage and how many times it was entered
+type Timing struct {
+	Stage    Stage
+	Duration time.Duration
+	Count    int
+}
+
+// Breakdown accumulates the stages of one request. It is safe for the
+// goroutines serving the request to use concurrently.
+type Breakdown struct {
+	start time.Time
+
+	mu     sync.Mutex
+	stages map[Stage]*Timing
+}
+
+// NewBreakdown starts timing a request
+func NewBreakdown(start time.Time) *Breakdown {
+	return &Breakdown{start: start, stages: make(map[Stage]*Timing)}
+}
+
+type contextKey struct{}
+
+// NewContext returns a context carrying b
+func NewContext(ctx context.Context, b *Breakdown) context.Context {
+	return context.WithValue(ctx, contextKey{}, b)
+}
+
+// FromContext returns the breakdown of the request ctx belongs to, or nil
+func FromContext(ctx context.Context) *Breakdown {
+	if ctx == nil {
+		return nil
+	}
+	b, _ := ctx.Value(contextKey{}).(*Breakdown)
+	return b
+}
+
+// Track starts timing a stage of the request ctx belongs to and returns the
+// function that stops it. Without a breakdown it does nothing.
+func Track(ctx context.Context, stage Stage) func() {
+	return FromContext(ctx).Start(stage)
+}
+
+// Start starts timing a stage and returns the function that stops it. A nil
+// breakdown records nothing.
+func (b *Breakdown) Start(stage Stage) func() {
+	if b == nil {
+		return func() {}
+	}
+	start := time.Now()
+	return func() { b.Add(stage, time.Since(start)) }
+}
+
+// Add records time spent in a stage
+func (b *Breakdown) Add(stage Stage, d time.Duration) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	t, ok := b.stages[stage]
+	if !ok {
+		t = &Timing{Stage: stage}
+		b.stages[stage] = t
+	}
+	t.Duration += d
+	t.Count++
+}
+
+// Total is the time since the request started
+func (b *Breakdown) Total(now time.Time) time.Duration {
+	return now.Sub(b.start)
+}
+
+// Timings returns the consecutive stages entered, then "other" for the rest
+// of total, then Redis when it was used
+func (b *Breakdown) Timings(total time.Duration) []Timing {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var timings []Timing
+	other := total
+	for _, stage := range Stages {
+		if t, ok := b.stages[stage]; ok {
+			timings = append(timings, *t)
+			other -= t.Duration
+		}
+	}
+	timings = append(timings, Timing{Stage: StageOther, Duration: max(other, 0)})
+	if t, ok := b.stages[StageRedis]; ok {
+		timings = append(timings, *t)
+	}
+	return timings
+}
+
+// ServerTiming formats timings and the total as a Server-Timing header, in
+// milliseconds
+func ServerTiming(timings []Timing, total time.Duration) string {
+	var sb strings.Builder
+	for _, t := range timings {
+		fmt.Fprintf(&sb, "%s;dur=%.1f", t.Stage, milliseconds(t.Duration))
+		if t.Count > 1 {
+			fmt.Fprintf(&sb, `;desc="%d calls"`, t.Count)
+		}
+		sb.WriteString(", ")
+	}
+	fmt.Fprintf(&sb, "total;dur=%.1f", milliseconds(total))
+	return sb.String()
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
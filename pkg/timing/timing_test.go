@@ -0,0 +1,53 @@
+package timing
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBreakdownTimings(t *testing.T) {
+	b := NewBreakdown(time.Now())
+	b.Add(StageAuth, 200*time.Millisecond)
+	b.Add(StageService, 30*time.Millisecond)
+	b.Add(StageService, 20*time.Millisecond)
+	b.Add(StageRedis, 15*time.Millisecond)
+
+	timings := b.Timings(300 * time.Millisecond)
+	assert.Equal(t, []Timing{
+		{Stage: StageAuth, Duration: 200 * time.Millisecond, Count: 1},
+		{Stage: StageService, Duration: 50 * time.Millisecond, Count: 2},
+		{Stage: StageOther, Duration: 50 * time.Millisecond},
+		{Stage: StageRedis, Duration: 15 * time.Millisecond, Count: 1},
+	}, timings, "Redis is reported but not taken from the rest")
+
+	assert.Equal(t,
+		`auth;dur=200.0, service;dur=50.0;desc="2 calls", other;dur=50.0, redis;dur=15.0, total;dur=300.0`,
+		ServerTiming(timings, 300*time.Millisecond))
+}
+
+func TestTrackWithoutBreakdown(t *testing.T) {
+	// Code outside a timed request tracks stages all the same
+	stop := Track(context.Background(), StageService)
+	stop()
+
+	var b *Breakdown
+	b.Add(StageRedis, time.Second)
+	assert.Nil(t, FromContext(context.Background()))
+}
+
+func TestTrack(t *testing.T) {
+	b := NewBreakdown(time.Now())
+	ctx, cancel := context.WithTimeout(NewContext(context.Background(), b), time.Second)
+	defer cancel()
+
+	stop := Track(ctx, StageRender)
+	time.Sleep(5 * time.Millisecond)
+	stop()
+
+	timings := b.Timings(time.Second)
+	assert.Equal(t, StageRender, timings[0].Stage)
+	assert.GreaterOrEqual(t, timings[0].Duration, 5*time.Millisecond)
+}
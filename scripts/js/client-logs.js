@@ -0,0 +1,85 @@
+/**
+ * Client Error Reporting
+ *
+ * Collects uncaught errors, unexpected WebSocket disconnects and failed calls
+ * and sends them to the server in batches, so problems that only show up in
+ * browsers end up in the server logs next to the requests that caused them.
+ * Batches are sent every few seconds and when the page is hidden.
+ */
+
+(function() {
+    'use strict';
+
+    const endpoint = '/api/v1/client-logs';
+    const flushInterval = 10000;
+    const maxBatch = 50;
+    const maxQueued = 200;
+
+    let queue = [];
+    let disabled = false;
+
+    function csrfToken() {
+        const meta = document.querySelector('meta[name="csrf-token"]');
+        return meta ? meta.getAttribute('content') : '';
+    }
+
+    function report(kind, data) {
+        if (disabled || queue.length >= maxQueued) return;
+
+        queue.push(Object.assign({
+            kind: kind,
+            url: location.pathname,
+            occurred_at: Date.now()
+        }, data));
+
+        if (queue.length >= maxBatch) flush();
+    }
+
+    function flush() {
+        if (queue.length === 0) return;
+
+        const reports = queue.splice(0, maxBatch);
+        // keepalive lets the request outlive the page on pagehide
+        fetch(endpoint, {
+            method: 'POST',
+            credentials: 'same-origin',
+            keepalive: true,
+            headers: {
+                'Content-Type': 'application/json',
+                'X-CSRF-Token': csrfToken()
+            },
+            body: JSON.stringify({ reports: reports })
+        }).then(res => {
+            // Signed out; stop reporting until the next page load
+            if (res.status === 401) disabled = true;
+        }).catch(() => {
+            // Reporting must never produce errors of its own
+        });
+    }
+
+    window.addEventListener('error', e => {
+        report('js_error', {
+            message: e.message || 'Script error',
+            stack: e.error && e.error.stack ? e.error.stack : `${e.filename}:${e.lineno}:${e.colno}`
+        });
+    });
+
+    window.addEventListener('unhandledrejection', e => {
+        const reason = e.reason;
+        report('js_error', {
+            message: 'Unhandled rejection: ' + (reason && reason.message ? reason.message : String(reason)),
+            stack: reason && reason.stack ? reason.stack : undefined
+        });
+    });
+
+    window.addEventListener('pagehide', flush);
+    document.addEventListener('visibilitychange', () => {
+        if (document.visibilityState === 'hidden') flush();
+    });
+    setInterval(flush, flushInterval);
+
+    window.ClientLogs = {
+        report: report,
+        flush: flush
+    };
+})();
@@ -0,0 +1,107 @@
+/**
+ * Custom Emoji Rendering
+ *
+ * Replaces :shortcode: references to custom emoji in chat messages with their
+ * images. Built-in shortcodes are already expanded to Unicode by the server.
+ * The catalog is revalidated (ETag) whenever a chat window is loaded, so
+ * emoji added or removed by an admin show up without a page reload.
+ */
+
+(function() {
+    'use strict';
+
+    // Images are rendered at text height; this caps how many per message
+    const MAX_PER_MESSAGE = 50;
+    const SHORTCODE = /:([a-z0-9_+-]{1,32}):/g;
+
+    let custom = new Map();
+
+    function loadCatalog() {
+        return fetch('/api/v1/emoji', { credentials: 'same-origin' })
+            .then(res => res.ok ? res.json() : null)
+            .then(catalog => {
+                if (!catalog) return;
+                custom = new Map((catalog.custom || []).map(e => [e.shortcode, e.url]));
+                renderAll();
+            })
+            .catch(err => console.error('Failed to load emoji catalog:', err));
+    }
+
+    function createImage(shortcode, url) {
+        const img = document.createElement('img');
+        img.src = url;
+        img.alt = ':' + shortcode + ':';
+        img.title = ':' + shortcode + ':';
+        img.loading = 'lazy';
+        img.className = 'custom-emoji inline-block align-text-bottom';
+        img.style.height = '1.375em';
+        img.style.width = 'auto';
+        img.style.maxWidth = '2.75em';
+        return img;
+    }
+
+    // render replaces custom shortcodes in the text nodes of a message bubble.
+    // It is idempotent: replaced shortcodes are no longer text.
+    function render(bubble) {
+        if (custom.size === 0) return;
+
+        let count = bubble.querySelectorAll('img.custom-emoji').length;
+        const walker = document.createTreeWalker(bubble, NodeFilter.SHOW_TEXT);
+        const nodes = [];
+        while (walker.nextNode()) nodes.push(walker.currentNode);
+
+        for (const node of nodes) {
+            const text = node.nodeValue;
+            if (!text.includes(':')) continue;
+
+            const fragment = document.createDocumentFragment();
+            let last = 0;
+            let replaced = false;
+
+            for (const match of text.matchAll(SHORTCODE)) {
+                const url = custom.get(match[1]);
+                if (!url || count >= MAX_PER_MESSAGE) continue;
+
+                fragment.appendChild(document.createTextNode(text.slice(last, match.index)));
+                fragment.appendChild(createImage(match[1], url));
+                last = match.index + match[0].length;
+                replaced = true;
+                count++;
+            }
+
+            if (!replaced) continue;
+
+            fragment.appendChild(document.createTextNode(text.slice(last)));
+            node.parentNode.replaceChild(fragment, node);
+        }
+    }
+
+    function renderAll() {
+        document.querySelectorAll('#message-list [data-message-id]').forEach(render);
+    }
+
+    // Render messages appended by the WebSocket handlers
+    const observer = new MutationObserver(mutations => {
+        for (const mutation of mutations) {
+            for (const node of mutation.addedNodes) {
+                if (node.nodeType !== Node.ELEMENT_NODE) continue;
+                if (node.matches('[data-message-id]')) render(node);
+                node.querySelectorAll('[data-message-id]').forEach(render);
+            }
+        }
+    });
+
+    document.addEventListener('DOMContentLoaded', () => {
+        observer.observe(document.body, { childList: true, subtree: true });
+        loadCatalog();
+    });
+
+    // Chat windows are swapped in by HTMX; revalidate the catalog each time
+    document.addEventListener('htmx:afterSwap', event => {
+        if (event.target.querySelector && event.target.querySelector('#message-list')) {
+            loadCatalog();
+        }
+    });
+
+    window.CustomEmoji = { reload: loadCatalog, render: renderAll };
+})();
@@ -0,0 +1,106 @@
+/**
+ * Maintenance Banner
+ *
+ * Shows a countdown to a scheduled maintenance window. The current window is
+ * fetched on load; afterwards the server pushes announcements over the
+ * WebSocket (or SSE) as the window approaches, starts and ends. The banner
+ * counts down locally between announcements.
+ */
+
+(function() {
+    'use strict';
+
+    let timer = null;
+    let deadline = 0;
+    let state = 'none';
+    let message = '';
+
+    function banner() {
+        let el = document.getElementById('maintenance-banner');
+        if (!el) {
+            el = document.createElement('div');
+            el.id = 'maintenance-banner';
+            el.setAttribute('role', 'status');
+            el.className = 'hidden fixed top-0 inset-x-0 z-50 px-4 py-2 text-center text-sm bg-amber-500/90 text-black font-medium';
+            document.body.prepend(el);
+        }
+        return el;
+    }
+
+    function formatRemaining(seconds) {
+        if (seconds <= 0) return 'now';
+        const h = Math.floor(seconds / 3600);
+        const m = Math.floor((seconds % 3600) / 60);
+        const s = seconds % 60;
+        if (h > 0) return `in ${h}h ${m}m`;
+        if (m > 0) return `in ${m}m ${s.toString().padStart(2, '0')}s`;
+        return `in ${s}s`;
+    }
+
+    function render() {
+        const el = banner();
+        const remaining = Math.max(0, Math.round((deadline - Date.now()) / 1000));
+
+        let text;
+        if (state === 'active') {
+            text = `Maintenance in progress, back ${formatRemaining(remaining)}`;
+        } else {
+            text = `Scheduled maintenance starts ${formatRemaining(remaining)}`;
+        }
+        if (message) text += ` — ${message}`;
+
+        el.textContent = text;
+        el.classList.remove('hidden');
+    }
+
+    function clear() {
+        state = 'none';
+        if (timer) {
+            clearInterval(timer);
+            timer = null;
+        }
+        banner().classList.add('hidden');
+    }
+
+    // apply takes an announcement: {state, window, seconds_left}
+    function apply(announcement) {
+        if (!announcement || !announcement.state) return;
+
+        if (announcement.state === 'ended' || announcement.state === 'cancelled' || announcement.state === 'none') {
+            const wasActive = state === 'active';
+            clear();
+            // Pages failed while maintenance was on; start fresh
+            if (wasActive) window.location.reload();
+            return;
+        }
+
+        state = announcement.state;
+        message = (announcement.window && announcement.window.message) || '';
+        deadline = Date.now() + announcement.seconds_left * 1000;
+
+        render();
+        if (!timer) timer = setInterval(render, 1000);
+    }
+
+    function load() {
+        fetch('/api/v1/maintenance', { credentials: 'same-origin' })
+            .then(res => res.ok ? res.json() : null)
+            .then(apply)
+            .catch(err => console.error('Failed to load maintenance status:', err));
+    }
+
+    window.MaintenanceBanner = {
+        apply: apply,
+
+        // fromMessage takes a WebSocket maintenance message
+        fromMessage(message) {
+            if (message.data) apply(message.data);
+        }
+    };
+
+    if (document.readyState === 'loading') {
+        document.addEventListener('DOMContentLoaded', load);
+    } else {
+        load();
+    }
+})();
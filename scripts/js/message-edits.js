@@ -0,0 +1,81 @@
+/**
+ * Message Edits
+ *
+ * Lets senders edit and delete their own messages from the chat and group
+ * windows, and applies edit and delete events received over the WebSocket to
+ * the message on screen. The message list names its endpoint in
+ * data-messages-url. The sender's own window is updated by the same event as
+ * everyone else's, so requests do not touch the page.
+ */
+
+(function() {
+    'use strict';
+
+    const tombstoneHTML = '<span class="italic opacity-70">Message deleted</span>';
+
+    function csrfToken() {
+        const meta = document.querySelector('meta[name="csrf-token"]');
+        return meta ? meta.getAttribute('content') : '';
+    }
+
+    function request(list, messageId, method, body) {
+        return fetch(list.dataset.messagesUrl + encodeURIComponent(messageId), {
+            method,
+            credentials: 'same-origin',
+            headers: { 'Content-Type': 'application/x-www-form-urlencoded', 'X-CSRF-Token': csrfToken() },
+            body
+        }).then((res) => {
+            if (!res.ok) console.error('Failed to change message:', res.status);
+        }).catch((err) => console.error('Failed to change message:', err));
+    }
+
+    document.addEventListener('click', (e) => {
+        const btn = e.target.closest('[data-edit-message], [data-delete-message]');
+        if (!btn) return;
+
+        const list = btn.closest('[data-messages-url]');
+        const bubble = btn.closest('[data-message-id]');
+        if (!list || !bubble) return;
+
+        if (btn.hasAttribute('data-delete-message')) {
+            if (confirm('Delete this message for everyone?')) request(list, bubble.dataset.messageId, 'DELETE');
+            return;
+        }
+
+        const current = bubble.querySelector('.message-content').textContent.trim();
+        const content = prompt('Edit message', current);
+        if (content === null || !content.trim() || content.trim() === current) return;
+        request(list, bubble.dataset.messageId, 'PATCH', 'content=' + encodeURIComponent(content.trim()));
+    });
+
+    // Updates the message an edit or delete event refers to, if it is shown
+    function apply(list, message) {
+        const bubble = list.querySelector(`[data-message-id="${CSS.escape(message.id)}"]`);
+        if (!bubble) return;
+
+        const content = bubble.querySelector('.message-content');
+        if (!content) return;
+
+        if (message.type === 'delete') {
+            content.innerHTML = tombstoneHTML;
+            bubble.querySelectorAll('.message-actions, .edited-marker').forEach((el) => el.remove());
+            return;
+        }
+
+        content.textContent = message.content;
+        const time = bubble.querySelector('.message-time');
+        if (time && !time.querySelector('.edited-marker')) {
+            time.insertAdjacentHTML('afterbegin', '<span class="edited-marker">edited · </span>');
+        }
+    }
+
+    // Edit and delete buttons for the user's own message; GIFs can only be deleted
+    function actionsHTML(editable, colorClass) {
+        const button = 'underline opacity-80 hover:opacity-100';
+        return `<div class="message-actions hidden group-hover:flex gap-2 justify-end text-[10px] mt-1 ${colorClass}">` +
+            (editable ? `<button type="button" class="${button}" data-edit-message>Edit</button>` : '') +
+            `<button type="button" class="${button}" data-delete-message>Delete</button></div>`;
+    }
+
+    window.MessageEdits = { apply, actionsHTML };
+})();
@@ -0,0 +1,34 @@
+/**
+ * Message Order
+ *
+ * Puts messages received over the WebSocket in their place in the chat and
+ * group windows. Messages carry their sequence number in the conversation
+ * (data.seq), also rendered as data-seq, so one that arrives after a later
+ * one is shown before it, and one already shown is not shown twice.
+ * Messages without a sequence number are appended.
+ */
+
+(function() {
+    'use strict';
+
+    // Adds a rendered message to the list and reports whether it was new
+    function insert(list, element, message) {
+        if (message.id && list.querySelector(`[data-message-id="${CSS.escape(message.id)}"]`)) {
+            return false;
+        }
+
+        const seq = Number(message.data && message.data.seq) || 0;
+        if (!seq) {
+            list.appendChild(element);
+            return true;
+        }
+        element.dataset.seq = seq;
+
+        const next = Array.from(list.querySelectorAll(':scope > [data-seq]'))
+            .find((el) => Number(el.dataset.seq) > seq);
+        list.insertBefore(element, next || null);
+        return true;
+    }
+
+    window.MessageOrder = { insert };
+})();
@@ -0,0 +1,245 @@
+/**
+ * Shared Conversation Links
+ *
+ * Backs the conversation menu: creates a read-only link to a range of the
+ * open conversation, optionally protected by a passcode and an expiry, and
+ * lists the live links so they can be revoked. The link itself is only shown
+ * once, right after it is created.
+ */
+
+(function() {
+    'use strict';
+
+    const dialogClass = 'bg-signal-surface text-signal-text-main rounded-xl p-6 w-full max-w-md border border-white/10 backdrop:bg-black/60';
+    const inputClass = 'w-full bg-signal-bg rounded-lg px-3 py-2 mt-1 border border-white/10 focus:outline-none focus:border-signal-blue';
+
+    function csrfToken() {
+        const meta = document.querySelector('meta[name="csrf-token"]');
+        return meta ? meta.getAttribute('content') : '';
+    }
+
+    function dialog() {
+        closeMenus(null);
+
+        let el = document.getElementById('share-dialog');
+        if (!el) {
+            el = document.createElement('dialog');
+            el.id = 'share-dialog';
+            el.className = dialogClass;
+            document.body.appendChild(el);
+        }
+        el.replaceChildren();
+        return el;
+    }
+
+    function element(tag, className, text) {
+        const el = document.createElement(tag);
+        if (className) el.className = className;
+        if (text) el.textContent = text;
+        return el;
+    }
+
+    function field(label, input) {
+        const wrap = element('label', 'block text-sm text-signal-text-sub mb-3', label);
+        input.className = inputClass;
+        wrap.appendChild(input);
+        return wrap;
+    }
+
+    function button(text, primary) {
+        const b = element('button', primary
+            ? 'px-4 py-2 rounded-lg bg-signal-blue hover:bg-signal-bluehover text-white'
+            : 'px-4 py-2 rounded-lg hover:bg-white/5 text-signal-text-sub', text);
+        b.type = 'button';
+        return b;
+    }
+
+    async function errorMessage(res) {
+        try {
+            const body = await res.json();
+            return (body.error && body.error.message) || body.message || res.statusText;
+        } catch (e) {
+            return res.statusText;
+        }
+    }
+
+    function describe(link) {
+        const day = iso => new Date(iso).toLocaleDateString();
+        let text = link.from ? `${day(link.from)} – ${day(link.to)}` : `Until ${day(link.to)}`;
+        if (link.protected) text += ' · passcode';
+        text += link.expires_at ? ` · expires ${new Date(link.expires_at).toLocaleString()}` : ' · no expiry';
+        return text;
+    }
+
+    function create(contact) {
+        const el = dialog();
+        el.appendChild(element('h2', 'text-lg font-semibold mb-1', 'Share messages'));
+        el.appendChild(element('p', 'text-sm text-signal-text-sub mb-4',
+            `Anyone with the link can read this range. ${contact} is shown anonymized.`));
+
+        const from = document.createElement('input');
+        from.type = 'date';
+        const to = document.createElement('input');
+        to.type = 'date';
+        const passcode = document.createElement('input');
+        passcode.type = 'password';
+        passcode.placeholder = 'Optional';
+        passcode.autocomplete = 'new-password';
+        const expiry = document.createElement('select');
+        [['24h', '1 day'], ['168h', '7 days'], ['720h', '30 days'], ['', 'Longest allowed']].forEach(([value, label]) => {
+            const option = element('option', '', label);
+            option.value = value;
+            expiry.appendChild(option);
+        });
+
+        el.appendChild(field('From', from));
+        el.appendChild(field('To', to));
+        el.appendChild(field('Passcode', passcode));
+        el.appendChild(field('Expires after', expiry));
+
+        const status = element('p', 'text-sm text-red-400 mb-3 hidden');
+        el.appendChild(status);
+
+        const actions = element('div', 'flex justify-end gap-2');
+        const cancel = button('Cancel');
+        const submit = button('Create link', true);
+        actions.append(cancel, submit);
+        el.appendChild(actions);
+
+        cancel.onclick = () => el.close();
+        submit.onclick = async () => {
+            const body = new URLSearchParams({
+                from: from.value,
+                to: to.value,
+                passcode: passcode.value,
+                expires_in: expiry.value
+            });
+
+            submit.disabled = true;
+            try {
+                const res = await fetch(`/api/v1/share/chat/${encodeURIComponent(contact)}`, {
+                    method: 'POST',
+                    credentials: 'same-origin',
+                    headers: { 'X-CSRF-Token': csrfToken() },
+                    body: body
+                });
+                if (!res.ok) {
+                    status.textContent = await errorMessage(res);
+                    status.classList.remove('hidden');
+                    return;
+                }
+                showCreated((await res.json()).url);
+            } catch (err) {
+                console.error('Failed to create share link:', err);
+                status.textContent = 'Failed to create the link';
+                status.classList.remove('hidden');
+            } finally {
+                submit.disabled = false;
+            }
+        };
+
+        el.showModal();
+    }
+
+    function showCreated(url) {
+        const el = dialog();
+        el.appendChild(element('h2', 'text-lg font-semibold mb-1', 'Link created'));
+        el.appendChild(element('p', 'text-sm text-signal-text-sub mb-4',
+            'Copy it now, it will not be shown again. You can revoke it from the conversation menu.'));
+
+        const input = document.createElement('input');
+        input.readOnly = true;
+        input.value = url;
+        input.className = inputClass + ' mb-4';
+        el.appendChild(input);
+
+        const actions = element('div', 'flex justify-end gap-2');
+        const copy = button('Copy', true);
+        const done = button('Done');
+        actions.append(done, copy);
+        el.appendChild(actions);
+
+        copy.onclick = () => {
+            input.select();
+            navigator.clipboard.writeText(url).then(() => { copy.textContent = 'Copied'; });
+        };
+        done.onclick = () => el.close();
+
+        if (!el.open) el.showModal();
+        input.select();
+    }
+
+    async function manage(contact) {
+        const el = dialog();
+        el.appendChild(element('h2', 'text-lg font-semibold mb-4', 'Shared links'));
+        const list = element('ul', 'flex flex-col gap-2 mb-4');
+        el.appendChild(list);
+
+        const actions = element('div', 'flex justify-end');
+        const close = button('Close');
+        actions.appendChild(close);
+        el.appendChild(actions);
+        close.onclick = () => el.close();
+
+        el.showModal();
+
+        let links = [];
+        try {
+            const res = await fetch(`/api/v1/share/chat/${encodeURIComponent(contact)}`, { credentials: 'same-origin' });
+            if (res.ok) links = (await res.json()).links || [];
+        } catch (err) {
+            console.error('Failed to load share links:', err);
+        }
+
+        if (links.length === 0) {
+            list.appendChild(element('li', 'text-sm text-signal-text-sub', 'No active links.'));
+            return;
+        }
+
+        links.forEach(link => {
+            const item = element('li', 'flex items-center justify-between gap-3 text-sm');
+            item.appendChild(element('span', 'min-w-0 truncate', describe(link)));
+
+            const revoke = button('Revoke');
+            revoke.classList.add('hover:text-red-400');
+            revoke.onclick = async () => {
+                revoke.disabled = true;
+                const res = await fetch(`/api/v1/share/${encodeURIComponent(link.id)}`, {
+                    method: 'DELETE',
+                    credentials: 'same-origin',
+                    headers: { 'X-CSRF-Token': csrfToken() }
+                });
+                if (res.ok || res.status === 404) {
+                    item.remove();
+                } else {
+                    revoke.disabled = false;
+                }
+            };
+            item.appendChild(revoke);
+            list.appendChild(item);
+        });
+    }
+
+    function closeMenus(except) {
+        document.querySelectorAll('[data-conversation-menu]').forEach(menu => {
+            if (menu !== except) menu.classList.add('hidden');
+        });
+    }
+
+    window.ShareLinks = {
+        create: create,
+        manage: manage,
+
+        // toggleMenu opens the conversation menu next to its button
+        toggleMenu(btn) {
+            const menu = btn.parentElement.querySelector('[data-conversation-menu]');
+            if (!menu) return;
+            closeMenus(menu);
+            menu.classList.toggle('hidden');
+        }
+    };
+
+    document.addEventListener('click', e => {
+        if (!e.target.closest('[data-conversation-menu-root]')) closeMenus(null);
+    });
+})();
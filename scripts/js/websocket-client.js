@@ -1,17 +1,71 @@
+// Read receipts are debounced this long so a burst of messages read together
+// costs one receipt per conversation
+const RECEIPT_DELAY_MS = 1000;
+
+// An activity state (typing, recording, uploading) is repeated this often
+// while it lasts; the server expires it a few seconds after the last repeat
+const ACTIVITY_REFRESH_MS = 3000;
+
+// A reconnecting client resumes at most this many conversations, the server's
+// limit, and remembers this many message IDs to skip replayed duplicates
+const RESUME_CONVERSATIONS_MAX = 50;
+const SEEN_MESSAGES_MAX = 1000;
+
 class WebSocketClient {
     constructor(onMessage, onCallSignal) {
         this.ws = null;
         this.onMessage = onMessage;
         this.onCallSignal = onCallSignal;
+        this.onReceipt = null;
+        this.pendingReceipts = new Map();
+        this.receiptTimer = null;
+        this.onActivity = null;
+        this.onQuality = null;
+        this.onResync = null;
+        // The last message of each conversation and the IDs recently
+        // shown, so a reconnect resumes where the client left off
+        this.lastMessages = new Map();
+        this.seenMessages = new Set();
+        this.hasConnected = false;
+        this.sentActivity = new Map();
+        this.onMaintenance = window.MaintenanceBanner ? window.MaintenanceBanner.fromMessage : null;
         this.reconnectAttempts = 0;
         this.maxReconnectAttempts = 10;
         this.reconnectDelay = 1000;
         this.isIntentionallyClosed = false;
+        this.lite = WebSocketClient.prefersLite();
+    }
+
+    // Lite mode trades avatars and instant notifications for less traffic.
+    // It is used on slow connections, with data saver on, or when forced
+    // through localStorage.wsLiteMode = 'on' | 'off'.
+    static prefersLite() {
+        const forced = localStorage.getItem('wsLiteMode');
+        if (forced === 'on' || forced === 'off') {
+            return forced === 'on';
+        }
+
+        const connection = navigator.connection;
+        return !!connection && (connection.saveData || ['slow-2g', '2g'].includes(connection.effectiveType));
+    }
+
+    // expandLite restores the regular message shape from the compact lite form
+    static expandLite(m) {
+        return {
+            type: m.t,
+            id: m.i,
+            from: m.f,
+            to: m.r,
+            group_id: m.g,
+            content: m.c,
+            data: m.d,
+            timestamp: m.s
+        };
     }
 
     connect() {
         const protocol = window.location.protocol === 'https:' ? 'wss:' : 'ws:';
-        const wsUrl = `${protocol}//${window.location.host}/ws/chat`;
+        const wsUrl = `${protocol}//${window.location.host}/ws/chat${this.lite ? '?mode=lite' : ''}`;
         
         console.log('WebSocket: Connecting to', wsUrl);
         
@@ -30,12 +84,23 @@ class WebSocketClient {
             this.reconnectAttempts = 0;
             this.updateStatus('Connected', 'text-green-500');
             this.sendPing();
+            this.flushReceipts();
+            if (this.hasConnected) {
+                this.sendResume();
+            }
+            this.hasConnected = true;
         };
 
         this.ws.onmessage = (event) => {
             try {
                 const message = JSON.parse(event.data);
-                this.handleMessage(message);
+                if (!this.lite) {
+                    this.handleMessage(message);
+                } else if (message.t === 'batch') {
+                    message.m.forEach((item) => this.handleMessage(WebSocketClient.expandLite(item)));
+                } else {
+                    this.handleMessage(WebSocketClient.expandLite(message));
+                }
             } catch (error) {
                 console.error('WebSocket: Failed to parse message', error);
             }
@@ -50,6 +115,13 @@ class WebSocketClient {
             console.log('WebSocket: Closed', event.code, event.reason);
             
             if (!this.isIntentionallyClosed) {
+                if (window.ClientLogs) {
+                    window.ClientLogs.report('ws_disconnect', {
+                        message: event.reason || 'WebSocket closed unexpectedly',
+                        code: event.code,
+                        context: { attempts: String(this.reconnectAttempts || 0) }
+                    });
+                }
                 this.updateStatus('Reconnecting...', 'text-yellow-500');
                 this.scheduleReconnect();
             }
@@ -62,6 +134,16 @@ class WebSocketClient {
         switch (message.type) {
             case 'chat':
             case 'group_chat':
+                // Replayed messages may arrive live as well
+                if (!this.trackMessage(message)) {
+                    break;
+                }
+                if (this.onMessage) {
+                    this.onMessage(message);
+                }
+                break;
+            case 'edit':
+            case 'delete':
                 if (this.onMessage) {
                     this.onMessage(message);
                 }
@@ -76,11 +158,90 @@ class WebSocketClient {
             case 'call_ice':
             case 'call_end':
             case 'call_ringing':
+            case 'call_record_request':
+            case 'call_record_consent':
+            case 'call_record_start':
+            case 'call_record_stop':
+            case 'call_hold':
+            case 'call_resume':
+            case 'call_transfer':
+            case 'call_chat':
                 if (this.onCallSignal) {
                     this.onCallSignal(message);
                 }
                 break;
                 
+            case 'reaction':
+                // Carries all reactions to the message, so the client only
+                // replaces what it shows
+                if (this.onReaction) {
+                    this.onReaction(message);
+                }
+                break;
+
+            case 'pin':
+                // The client refetches the pinned list of the conversation
+                if (this.onPin) {
+                    this.onPin(message);
+                }
+                break;
+
+            case 'read':
+            case 'delivered':
+                if (this.onReceipt) {
+                    this.onReceipt(message);
+                }
+                break;
+
+            case 'activity':
+                if (this.onActivity) {
+                    this.onActivity(message);
+                }
+                break;
+
+            case 'typing':
+                // The simple form of an activity state, shown the same way
+                if (this.onActivity) {
+                    this.onActivity(Object.assign({}, message, {
+                        type: 'activity',
+                        data: { states: { [message.from]: 'typing' }, expires_in: message.data && message.data.expires_in }
+                    }));
+                }
+                break;
+
+            case 'maintenance':
+                if (this.onMaintenance) {
+                    this.onMaintenance(message);
+                }
+                break;
+
+            case 'resync':
+                // The server missed messages while its Redis subscription
+                // was down; refresh the badges and whatever is open
+                document.body.dispatchEvent(new Event('notifications-updated'));
+                if (this.onResync) {
+                    this.onResync(message);
+                }
+                break;
+
+            case 'connection_quality':
+                // The server switches poor connections to lite mode; frames
+                // after this one use the compact form until the page reloads
+                if (message.data && message.data.lite) {
+                    this.lite = true;
+                }
+                if (this.onQuality) {
+                    this.onQuality(message.data);
+                }
+                break;
+
+            case 'capabilities':
+                // Connection details, including send buffer occupancy, for debugging
+                this.capabilities = message.data;
+                this.username = message.data && message.data.username;
+                console.debug('WebSocket: Capabilities', message.data);
+                break;
+
             case 'ping':
                 this.sendPong();
                 break;
@@ -117,6 +278,103 @@ class WebSocketClient {
         return false;
     }
 
+    // markRead records that everything up to messageId was read in a
+    // conversation ({to: username} or {group_id: id}). Only the newest
+    // position per conversation is sent, after RECEIPT_DELAY_MS. timestamp,
+    // the message's send time, lets the server mark earlier group messages
+    // sent with read receipts as read.
+    markRead(conversation, messageId, timestamp) {
+        if (!messageId) return;
+
+        const key = conversation.group_id ? 'g:' + conversation.group_id : 'u:' + conversation.to;
+        this.pendingReceipts.set(key, { type: 'read', id: messageId, to: conversation.to, group_id: conversation.group_id, timestamp: timestamp || 0 });
+
+        if (!this.receiptTimer) {
+            this.receiptTimer = setTimeout(() => this.flushReceipts(), RECEIPT_DELAY_MS);
+        }
+    }
+
+    flushReceipts() {
+        clearTimeout(this.receiptTimer);
+        this.receiptTimer = null;
+
+        // Kept until the connection is back; a later position replaces them anyway
+        if (!this.ws || this.ws.readyState !== WebSocket.OPEN) return;
+
+        this.pendingReceipts.forEach((receipt) => this.ws.send(JSON.stringify(receipt)));
+        this.pendingReceipts.clear();
+    }
+
+    // setActivity reports what the user is doing in a conversation
+    // ({to: username} or {group_id: id}): 'typing', 'recording', 'uploading'
+    // or 'idle'. Call it on every keystroke or progress event; repeats of
+    // the same state are only sent every ACTIVITY_REFRESH_MS.
+    setActivity(conversation, state) {
+        if (!this.ws || this.ws.readyState !== WebSocket.OPEN) return;
+
+        const key = conversation.group_id ? 'g:' + conversation.group_id : 'u:' + conversation.to;
+        const last = this.sentActivity.get(key);
+        const now = Date.now();
+
+        if (state === 'idle') {
+            if (!last) return;
+            this.sentActivity.delete(key);
+        } else {
+            if (last && last.state === state && now - last.at < ACTIVITY_REFRESH_MS) return;
+            this.sentActivity.set(key, { state: state, at: now });
+        }
+
+        this.ws.send(JSON.stringify({ type: 'activity', content: state, to: conversation.to, group_id: conversation.group_id }));
+    }
+
+    // trackMessage records the last message of its conversation and reports
+    // whether it is new to this page
+    trackMessage(message) {
+        if (message.id) {
+            if (this.seenMessages.has(message.id)) return false;
+            this.seenMessages.add(message.id);
+            if (this.seenMessages.size > SEEN_MESSAGES_MAX) {
+                this.seenMessages.delete(this.seenMessages.values().next().value);
+            }
+        }
+
+        let key, point;
+        if (message.group_id) {
+            key = 'g:' + message.group_id;
+            point = { group_id: message.group_id };
+        } else if (this.username) {
+            const other = message.from === this.username ? message.to : message.from;
+            key = 'u:' + other;
+            point = { with: other };
+        } else {
+            return true;
+        }
+
+        const last = this.lastMessages.get(key);
+        if (message.id && (!last || message.timestamp >= last.since)) {
+            point.last_id = message.id;
+            point.since = message.timestamp;
+            this.lastMessages.delete(key);
+            this.lastMessages.set(key, point);
+        }
+        return true;
+    }
+
+    // sendResume asks for what was missed while disconnected in the most
+    // recently active conversations
+    sendResume() {
+        const conversations = Array.from(this.lastMessages.values()).slice(-RESUME_CONVERSATIONS_MAX);
+        if (conversations.length > 0) {
+            this.sendMessage('resume', { conversations: conversations });
+        }
+    }
+
+    // Ask the server for the current connection details; the reply updates
+    // this.capabilities
+    requestCapabilities() {
+        this.sendMessage('capabilities', {});
+    }
+
     sendPing() {
         this.sendMessage('ping', {});
     }
@@ -157,6 +415,7 @@ class WebSocketClient {
 
     close() {
         this.isIntentionallyClosed = true;
+        this.flushReceipts();
         if (this.ws) {
             this.ws.close();
         }
@@ -173,6 +432,10 @@ class VoiceCallManager {
         this.remoteStream = null;
         this.currentCallId = null;
         this.currentCallPeer = null;
+        this.onHold = null;
+        this.awaitingTransferCallId = null;
+        this.statsInterval = null;
+        this.lastStats = null;
         
         // Inject custom CSS for animations
         this.injectStyles();
@@ -183,12 +446,37 @@ class VoiceCallManager {
             console.warn('WebRTC is not fully supported in this browser context');
         }
         
+        // Replaced by the server's list, with TURN credentials, before
+        // each peer connection; see loadIceServers
         this.iceServers = {
             iceServers: [
                 { urls: 'stun:stun.l.google.com:19302' },
                 { urls: 'stun:stun1.l.google.com:19302' }
             ]
         };
+        this.iceServersExpireAt = 0;
+    }
+
+    // Fetch the ICE servers unless the ones held are still valid for a
+    // minute. On failure the previous list is kept.
+    async loadIceServers() {
+        if (this.iceServersExpireAt === null || Date.now() < this.iceServersExpireAt - 60000) {
+            return;
+        }
+        try {
+            const response = await fetch('/call/ice-servers', { credentials: 'same-origin' });
+            if (!response.ok) {
+                throw new Error(`HTTP ${response.status}`);
+            }
+            const data = await response.json();
+            if (data.ice_servers && data.ice_servers.length > 0) {
+                this.iceServers = { iceServers: data.ice_servers };
+            }
+            // Lists without TURN credentials never expire
+            this.iceServersExpireAt = data.expires_at ? data.expires_at * 1000 : null;
+        } catch (error) {
+            console.warn('Failed to load ICE servers, using the previous ones:', error);
+        }
     }
 
     injectStyles() {
@@ -294,6 +582,16 @@ class VoiceCallManager {
         return 'Unknown';
     }
 
+    // Report a failed call to the server logs
+    reportFailure(stage, message) {
+        if (!window.ClientLogs) return;
+        window.ClientLogs.report('call_failure', {
+            message: message || 'Call failed',
+            call_id: this.currentCallId || undefined,
+            context: { stage: stage, browser: this.detectBrowser() }
+        });
+    }
+
     getCSRFToken() {
         const meta = document.querySelector('meta[name="csrf-token"]');
         return meta ? meta.getAttribute('content') : '';
@@ -340,6 +638,7 @@ Please use HTTPS or test with Chrome which allows localhost over HTTP.`;
                                     window.webkitRTCPeerConnection ||
                                     window.mozRTCPeerConnection;
             
+            await this.loadIceServers();
             this.pc = new RTCPeerConnection(this.iceServers);
             this.setupPeerConnection();
             
@@ -376,6 +675,7 @@ Please use HTTPS or test with Chrome which allows localhost over HTTP.`;
             
         } catch (error) {
             console.error('Failed to initiate call:', error);
+            this.reportFailure('initiate', error.message);
             this.endCall();
             alert('Failed to start call: ' + error.message);
         }
@@ -407,6 +707,7 @@ Please use HTTPS or test with Chrome which allows localhost over HTTP.`;
                                      window.webkitRTCPeerConnection ||
                                      window.mozRTCPeerConnection;
             
+            await this.loadIceServers();
             this.pc = new RTCPeerConnection(this.iceServers);
             this.setupPeerConnection();
             
@@ -450,6 +751,7 @@ Please use HTTPS or test with Chrome which allows localhost over HTTP.`;
             
         } catch (error) {
             console.error('Failed to answer call:', error);
+            this.reportFailure('answer', error.message);
             this.endCall();
             alert('Failed to answer call: ' + error.message);
         }
@@ -531,13 +833,68 @@ Please use HTTPS or test with Chrome which allows localhost over HTTP.`;
             
             if (this.pc.connectionState === 'connected') {
                 this.showActiveCallUI();
+                this.startStatsReports();
             } else if (this.pc.connectionState === 'failed' || 
                        this.pc.connectionState === 'disconnected') {
+                if (this.pc.connectionState === 'failed') {
+                    this.reportFailure('connection', 'Peer connection failed');
+                }
                 this.endCall();
             }
         };
     }
 
+    // While connected, report what getStats measures of the received media
+    // every 10 seconds; packets are counted since the previous report
+    startStatsReports() {
+        if (this.statsInterval) return;
+        this.lastStats = { lost: 0, received: 0 };
+        this.statsInterval = setInterval(() => this.reportStats(), 10000);
+    }
+
+    stopStatsReports() {
+        if (this.statsInterval) {
+            clearInterval(this.statsInterval);
+            this.statsInterval = null;
+        }
+        this.lastStats = null;
+    }
+
+    async reportStats() {
+        if (!this.pc || !this.currentCallId || !this.lastStats) return;
+
+        try {
+            const stats = await this.pc.getStats();
+            let rtt = 0, jitter = 0, lost = 0, received = 0;
+            stats.forEach(report => {
+                if (report.type === 'candidate-pair' && report.nominated && report.currentRoundTripTime !== undefined) {
+                    rtt = report.currentRoundTripTime * 1000;
+                } else if (report.type === 'inbound-rtp' && report.kind === 'audio') {
+                    jitter = (report.jitter || 0) * 1000;
+                    lost = Math.max(report.packetsLost || 0, 0);
+                    received = report.packetsReceived || 0;
+                }
+            });
+
+            const body = new URLSearchParams({
+                rtt_ms: rtt.toFixed(1),
+                jitter_ms: jitter.toFixed(1),
+                packets_lost: Math.max(lost - this.lastStats.lost, 0),
+                packets_received: Math.max(received - this.lastStats.received, 0)
+            });
+            this.lastStats = { lost, received };
+
+            await fetch(`/call/${this.currentCallId}/stats`, {
+                method: 'POST',
+                headers: { 'X-CSRF-Token': this.getCSRFToken() },
+                body
+            });
+        } catch (error) {
+            // Stats are best effort; the next report will try again
+            console.warn('Failed to report call stats:', error);
+        }
+    }
+
     async handleCallSignal(message) {
         switch (message.type) {
             case 'call_offer':
@@ -559,6 +916,297 @@ Please use HTTPS or test with Chrome which allows localhost over HTTP.`;
             case 'call_ringing':
                 console.log('Call is ringing');
                 break;
+
+            case 'call_record_request':
+            case 'call_record_consent':
+            case 'call_record_start':
+            case 'call_record_stop':
+                this.handleRecordingSignal(message);
+                break;
+
+            case 'call_hold':
+            case 'call_resume':
+                this.handleHoldSignal(message);
+                break;
+
+            case 'call_transfer':
+                await this.handleTransfer(message);
+                break;
+
+            case 'call_chat':
+                this.handleCallChat(message);
+                break;
+        }
+    }
+
+    // Messages sent during a call go to its participants only and are kept
+    // with the call; the server may add them to the conversation afterwards
+    async sendCallChat(event) {
+        event.preventDefault();
+        const input = document.getElementById('call-chat-input');
+        if (!input || !this.currentCallId) return;
+
+        const content = input.value.trim();
+        if (!content) return;
+
+        try {
+            const response = await fetch(`/call/chat/${this.currentCallId}`, {
+                method: 'POST',
+                headers: {
+                    'Content-Type': 'application/x-www-form-urlencoded',
+                    'X-CSRF-Token': this.getCSRFToken()
+                },
+                body: new URLSearchParams({ content })
+            });
+            if (!response.ok) {
+                const error = await response.json().catch(() => ({}));
+                throw new Error(error.message || 'Failed to send message');
+            }
+            input.value = '';
+        } catch (error) {
+            alert(error.message);
+        }
+    }
+
+    handleCallChat(message) {
+        if (!message.data || message.data.call_id !== this.currentCallId) return;
+
+        const list = document.getElementById('call-chat-messages');
+        if (!list) return;
+
+        const line = document.createElement('p');
+        const sender = document.createElement('span');
+        sender.className = 'font-semibold text-white/80';
+        sender.textContent = message.from === this.username ? 'You: ' : `${message.from}: `;
+        line.appendChild(sender);
+
+        // Links are the usual reason to type during a call, so make them clickable
+        message.content.split(/(https?:\/\/\S+)/).forEach((part, i) => {
+            if (i % 2 === 1) {
+                const link = document.createElement('a');
+                link.href = part;
+                link.target = '_blank';
+                link.rel = 'noopener noreferrer';
+                link.className = 'text-blue-400 underline break-all';
+                link.textContent = part;
+                line.appendChild(link);
+            } else if (part) {
+                line.appendChild(document.createTextNode(part));
+            }
+        });
+
+        list.appendChild(line);
+        list.classList.remove('hidden');
+        list.scrollTop = list.scrollHeight;
+    }
+
+    clearCallChat() {
+        const list = document.getElementById('call-chat-messages');
+        if (list) {
+            list.replaceChildren();
+            list.classList.add('hidden');
+        }
+    }
+
+    // Either participant can hold; only the one who held can resume. Held
+    // calls keep the peer connection but send no audio.
+    async toggleHold() {
+        if (!this.currentCallId) return;
+        const action = this.onHold === 'self' ? 'resume' : 'hold';
+        try {
+            const response = await fetch(`/call/${action}/${this.currentCallId}`, {
+                method: 'POST',
+                headers: { 'X-CSRF-Token': this.getCSRFToken() }
+            });
+            if (!response.ok) {
+                const error = await response.json().catch(() => ({}));
+                throw new Error(error.message || `Failed to ${action} call`);
+            }
+            this.setHold(action === 'hold' ? 'self' : null);
+        } catch (error) {
+            alert(error.message);
+        }
+    }
+
+    handleHoldSignal(message) {
+        if (!message.data || message.data.call_id !== this.currentCallId) return;
+        this.setHold(message.type === 'call_hold' ? 'peer' : null);
+    }
+
+    // setHold records who holds the call ('self', 'peer' or null)
+    setHold(heldBy) {
+        this.onHold = heldBy;
+        if (this.localStream) {
+            this.localStream.getAudioTracks().forEach(track => { track.enabled = !heldBy; });
+        }
+        const status = document.getElementById('hold-status');
+        if (status) {
+            status.textContent = heldBy === 'peer' ? `${this.currentCallPeer} put you on hold` : 'On hold';
+            status.classList.toggle('hidden', !heldBy);
+        }
+        const button = document.getElementById('hold-toggle');
+        if (button) {
+            button.textContent = heldBy === 'self' ? 'Resume' : 'Hold';
+            button.disabled = heldBy === 'peer';
+        }
+    }
+
+    // A transfer replaces the current call with a new one to another user.
+    // The new caller places a fresh offer; the callee answers it without
+    // ringing since the server already made the call active.
+    async handleTransfer(message) {
+        if (!message.data) return;
+
+        if (this.pc) {
+            this.pc.close();
+            this.pc = null;
+        }
+        this.pendingOffer = null;
+        this.currentCallId = message.data.call_id;
+        this.currentCallPeer = message.data.peer;
+        this.setHold(null);
+        this.setRecordingStatus(null);
+        this.clearCallChat();
+
+        const peerName = document.getElementById('peer-name');
+        if (peerName) peerName.textContent = this.currentCallPeer;
+        const peerInitial = document.getElementById('peer-initial');
+        if (peerInitial) peerInitial.textContent = this.currentCallPeer.charAt(0).toUpperCase();
+        this.showToast('Call transferred', `${message.data.transferred_by} connected you with ${this.currentCallPeer}`);
+
+        if (message.data.role !== 'caller') {
+            this.awaitingTransferCallId = this.currentCallId;
+            return;
+        }
+
+        try {
+            this.isInitiator = true;
+            await this.loadIceServers();
+            this.pc = new RTCPeerConnection(this.iceServers);
+            this.setupPeerConnection();
+            this.localStream.getTracks().forEach(track => {
+                this.pc.addTrack(track, this.localStream);
+            });
+
+            const offer = await this.pc.createOffer();
+            await this.pc.setLocalDescription(offer);
+            this.wsClient.sendMessage('call_offer', {
+                call_id: this.currentCallId,
+                to: this.currentCallPeer,
+                sdp: offer.sdp
+            });
+        } catch (error) {
+            console.error('Failed to reconnect transferred call:', error);
+            this.endCall();
+        }
+    }
+
+    async acceptTransferredOffer(sdp) {
+        this.awaitingTransferCallId = null;
+        try {
+            this.isInitiator = false;
+            await this.loadIceServers();
+            this.pc = new RTCPeerConnection(this.iceServers);
+            this.setupPeerConnection();
+            this.localStream.getTracks().forEach(track => {
+                this.pc.addTrack(track, this.localStream);
+            });
+
+            await this.pc.setRemoteDescription(new RTCSessionDescription({ type: 'offer', sdp }));
+            const answer = await this.pc.createAnswer();
+            await this.pc.setLocalDescription(answer);
+            this.wsClient.sendMessage('call_answer', {
+                call_id: this.currentCallId,
+                to: this.currentCallPeer,
+                sdp: answer.sdp
+            });
+        } catch (error) {
+            console.error('Failed to answer transferred call:', error);
+            this.endCall();
+        }
+    }
+
+    // Recording needs both participants' consent: one asks, the other is
+    // prompted, and either can stop it. The server enforces who may ask.
+    async recordingAction(action, body) {
+        const response = await fetch(`/call/record/${this.currentCallId}/${action}`, {
+            method: 'POST',
+            headers: {
+                'Content-Type': 'application/x-www-form-urlencoded',
+                'X-CSRF-Token': this.getCSRFToken()
+            },
+            body: body || ''
+        });
+        if (!response.ok) {
+            const error = await response.json().catch(() => ({}));
+            throw new Error(error.message || `Recording ${action} failed`);
+        }
+        return response.json();
+    }
+
+    async requestRecording() {
+        if (!this.currentCallId) return;
+        try {
+            await this.recordingAction('request');
+            this.setRecordingStatus('Waiting for consent…');
+        } catch (error) {
+            alert(error.message);
+        }
+    }
+
+    async stopRecording() {
+        if (!this.currentCallId) return;
+        try {
+            await this.recordingAction('stop');
+            this.setRecordingStatus(null);
+        } catch (error) {
+            console.error('Failed to stop recording:', error);
+        }
+    }
+
+    async handleRecordingSignal(message) {
+        if (!message.data || message.data.call_id !== this.currentCallId) return;
+
+        switch (message.type) {
+            case 'call_record_request': {
+                const accept = confirm(`${message.from} wants to record this call. Allow recording?`);
+                try {
+                    await this.recordingAction('consent', 'accept=' + accept);
+                } catch (error) {
+                    console.error('Failed to answer recording request:', error);
+                }
+                break;
+            }
+            case 'call_record_consent':
+                if (!message.data.accepted) {
+                    this.setRecordingStatus(null);
+                    alert(`${message.from} declined recording this call.`);
+                }
+                break;
+
+            case 'call_record_start':
+                // The token admits this client's media to the recorder
+                this.recordingToken = message.data.token;
+                this.setRecordingStatus('Recording');
+                break;
+
+            case 'call_record_stop':
+                this.recordingToken = null;
+                this.setRecordingStatus(null);
+                break;
+        }
+    }
+
+    setRecordingStatus(text) {
+        const status = document.getElementById('recording-status');
+        if (status) {
+            status.textContent = text || '';
+            status.classList.toggle('hidden', !text);
+        }
+        const button = document.getElementById('recording-toggle');
+        if (button) {
+            button.textContent = text === 'Recording' ? 'Stop recording' : 'Record';
+            button.onclick = () => text === 'Recording' ? this.stopRecording() : this.requestRecording();
         }
     }
 
@@ -569,6 +1217,13 @@ Please use HTTPS or test with Chrome which allows localhost over HTTP.`;
             return;
         }
 
+        if (message.data.call_id === this.awaitingTransferCallId) {
+            if (message.data.sdp) {
+                await this.acceptTransferredOffer(message.data.sdp);
+            }
+            return;
+        }
+
         this.currentCallId = message.data.call_id;
         this.currentCallPeer = message.from;
         
@@ -794,6 +1449,9 @@ Please use HTTPS or test with Chrome which allows localhost over HTTP.`;
                         <p id="call-timer" class="text-sm font-mono tracking-wider">00:00</p>
                     </div>
 
+                    <p id="recording-status" class="hidden text-xs font-semibold text-red-400 uppercase tracking-wider mb-4"></p>
+                    <p id="hold-status" class="hidden text-xs font-semibold text-yellow-400 uppercase tracking-wider mb-4"></p>
+
                     <div class="flex justify-center gap-1 h-8 mb-8 items-center">
                         <div class="w-1 bg-white/40 rounded-full animate-[pulse_1s_ease-in-out_infinite] h-3"></div>
                         <div class="w-1 bg-white/60 rounded-full animate-[pulse_1.2s_ease-in-out_infinite] h-5"></div>
@@ -807,6 +1465,18 @@ Please use HTTPS or test with Chrome which allows localhost over HTTP.`;
                             <path d="M12 9c-1.6 0-3.15.25-4.6.72v3.1c0 .39-.23.74-.56.9-.98.49-1.87 1.12-2.66 1.85-.18.18-.43.28-.7.28-.28 0-.53-.11-.71-.29L.29 13.08c-.18-.17-.29-.42-.29-.7 0-.28.11-.53.29-.71C3.34 8.78 7.46 7 12 7s8.66 1.78 11.71 4.67c.18.18.29.43.29.71 0 .28-.11.53-.29.71l-2.48 2.48c-.18.18-.43.29-.71.29-.27 0-.52-.11-.7-.28-.79-.74-1.69-1.36-2.67-1.85-.33-.16-.56-.5-.56-.9v-3.1C15.15 9.25 13.6 9 12 9z"></path>
                         </svg>
                     </button>
+                    <div class="flex justify-center gap-6 mt-4">
+                        <button id="hold-toggle" onclick="window.voiceCall.toggleHold()" class="text-xs text-white/60 hover:text-white transition-colors disabled:opacity-30">Hold</button>
+                        <button id="recording-toggle" onclick="window.voiceCall.requestRecording()" class="text-xs text-white/60 hover:text-white transition-colors">Record</button>
+                    </div>
+
+                    <div class="mt-6 text-left">
+                        <div id="call-chat-messages" class="hidden max-h-32 overflow-y-auto space-y-1 text-sm text-white/70 mb-2"></div>
+                        <form onsubmit="window.voiceCall.sendCallChat(event)" class="flex gap-2">
+                            <input id="call-chat-input" type="text" maxlength="2000" autocomplete="off" placeholder="Message during call…" class="flex-1 bg-white/5 border border-white/10 rounded-lg px-3 py-2 text-sm text-white placeholder-white/30 focus:outline-none focus:border-white/30">
+                            <button type="submit" class="text-xs text-white/60 hover:text-white transition-colors">Send</button>
+                        </form>
+                    </div>
                 </div>
             </div>
             <audio id="remote-audio" autoplay></audio>
@@ -855,6 +1525,8 @@ Please use HTTPS or test with Chrome which allows localhost over HTTP.`;
     }
 
     cleanup() {
+        this.stopStatsReports();
+
         // Stop local stream
         if (this.localStream) {
             this.localStream.getTracks().forEach(track => track.stop());
@@ -873,6 +1545,11 @@ Please use HTTPS or test with Chrome which allows localhost over HTTP.`;
         this.isInitiator = false;
         this.remoteStream = null;
         this.pendingOffer = null;
+        this.recordingToken = null;
+        this.setRecordingStatus(null);
+        this.awaitingTransferCallId = null;
+        this.setHold(null);
+        this.clearCallChat();
         
         // Stop call timer
         if (this.callTimerInterval) {
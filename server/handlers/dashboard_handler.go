@@ -2,16 +2,22 @@ package handlers
 
 import (
 	"context"
-	"exc6/db"
 	"exc6/pkg/logger"
+	"exc6/pkg/pagination"
+	"exc6/pkg/timing"
+	"exc6/services/activity"
 	"exc6/services/calls"
 	"exc6/services/chat"
 	"exc6/services/friends"
 	"exc6/services/groups"
+	"exc6/services/notifications"
+	"exc6/services/users"
+	"exc6/utils"
 	"fmt"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
 )
 
 // ContactData represents a user or group in the contact list
@@ -25,7 +31,7 @@ type ContactData struct {
 }
 
 // Reusable function to get notifications
-func getNotificationData(ctx context.Context, username string, fsrv *friends.FriendService, cs *chat.ChatService, callSrv *calls.CallService) (fiber.Map, int) {
+func getNotificationData(ctx context.Context, username string, fsrv *friends.FriendService, cs chat.Service, callSrv *calls.CallService) (fiber.Map, int) {
 	// 1. Friend Requests
 	requests, err := fsrv.GetFriendRequests(ctx, username)
 	if err != nil {
@@ -38,10 +44,12 @@ func getNotificationData(ctx context.Context, username string, fsrv *friends.Fri
 		unreadMap = make(map[string]int)
 	}
 
-	// 3. Missed Calls
-	missedCalls, err := callSrv.GetMissedCalls(ctx, username)
-	if err != nil {
-		missedCalls = []*calls.Call{}
+	// 3. Missed Calls, unless calls are disabled
+	missedCalls := []*calls.Call{}
+	if callSrv != nil {
+		if missed, err := callSrv.GetMissedCalls(ctx, username); err == nil {
+			missedCalls = missed
+		}
 	}
 
 	total := len(requests) + len(unreadMap) + len(missedCalls)
@@ -53,28 +61,37 @@ func getNotificationData(ctx context.Context, username string, fsrv *friends.Fri
 	}, total
 }
 
-func HandleDashboard(fsrv *friends.FriendService, gsrv *groups.GroupService, cs *chat.ChatService, callSrv *calls.CallService, qdb *db.Queries) fiber.Handler {
+func HandleDashboard(fsrv *friends.FriendService, gsrv *groups.GroupService, cs chat.Service, callSrv *calls.CallService, usrv *users.UserService, tracker *activity.Tracker) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		username := c.Locals("username").(string)
 
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		ctx, cancel := context.WithTimeout(c.UserContext(), 5*time.Second)
 		defer cancel()
 
-		// Get Friends & Groups
-		friendsList, err := fsrv.GetUserFriends(ctx, username)
-		if err != nil {
-			return err
-		}
+		stopService := timing.Track(ctx, timing.StageService)
+
+		// Get Groups, then the list version before anything it covers is read
 		groupsList, err := gsrv.GetUserGroups(ctx, username)
 		if err != nil {
 			groupsList = []groups.GroupInfo{}
 		}
 
+		var contactsVersion string
+		if changes, err := tracker.Changes(ctx, username, groupIDs(groupsList), ""); err == nil {
+			contactsVersion = changes.Cursor
+		}
+
+		// Get Friends
+		friendsList, err := fsrv.GetUserFriends(ctx, username)
+		if err != nil {
+			return err
+		}
+
 		// Get Notifications
 		notifData, totalNotifications := getNotificationData(ctx, username, fsrv, cs, callSrv)
 
 		// Get user info
-		user, err := qdb.GetUserByUsername(ctx, username)
+		user, err := usrv.GetByUsername(ctx, username)
 		if err != nil {
 			return err
 		}
@@ -95,34 +112,19 @@ func HandleDashboard(fsrv *friends.FriendService, gsrv *groups.GroupService, cs
 			}
 		}
 
-		// Contacts logic
-		contacts := make([]ContactData, 0, len(friendsList)+len(groupsList))
-		unreadMap := notifData["UnreadMessages"].(map[string]int)
-
-		for _, friend := range friendsList {
-			contacts = append(contacts, ContactData{
-				Username:    friend.Username,
-				Icon:        friend.Icon,
-				CustomIcon:  friend.CustomIcon,
-				IsGroup:     false,
-				UnreadCount: unreadMap[friend.Username],
-			})
-		}
-		for _, group := range groupsList {
-			contacts = append(contacts, ContactData{
-				Username:   group.Name,
-				Icon:       group.Icon,
-				CustomIcon: group.CustomIcon,
-				IsGroup:    true,
-				GroupID:    group.ID,
-			})
-		}
+		groupUnread, _ := cs.GetGroupUnread(ctx, username, groupIDs(groupsList))
+		contacts := buildContacts(friendsList.Value, groupsList, notifData["UnreadMessages"].(map[string]int), groupUnread)
+		stopService()
 
+		defer timing.Track(ctx, timing.StageRender)()
 		return c.Render("dashboard", fiber.Map{
+			"OpenPath":            dashboardOpenPath(c),
 			"Username":            username,
 			"Icon":                iconValue,
 			"CustomIcon":          customIconValue,
 			"Contacts":            contacts,
+			"Degraded":            friendsList.Degraded,
+			"ContactsVersion":     contactsVersion,
 			"PendingRequestCount": totalNotifications,
 			"Notifications":       notifData["Notifications"],
 			"MissedCalls":         notifData["MissedCalls"],
@@ -132,58 +134,155 @@ func HandleDashboard(fsrv *friends.FriendService, gsrv *groups.GroupService, cs
 	}
 }
 
-// HandleGetContacts returns just the contact list HTML
-func HandleGetContacts(fsrv *friends.FriendService, gsrv *groups.GroupService, cs *chat.ChatService, callSrv *calls.CallService) fiber.Handler {
+// dashboardOpenPath returns the chat window to load with the dashboard, for
+// links that open a conversation: ?chat=<username> or ?group=<group ID>
+func dashboardOpenPath(c *fiber.Ctx) string {
+	if contact := c.Query("chat"); contact != "" {
+		if utils.ValidateUsername(contact) == nil {
+			return "/chat/" + contact
+		}
+		return ""
+	}
+	if groupID, err := uuid.Parse(c.Query("group")); err == nil {
+		return "/groups/" + groupID.String() + "/chat"
+	}
+	return ""
+}
+
+// HandleGetContacts returns the contact list HTML. Responses carry the list
+// version as ETag and X-Contacts-Version; passing that version back as
+// ?since= returns only the conversations that changed since, as out-of-band
+// swaps of the matching list items.
+func HandleGetContacts(fsrv *friends.FriendService, gsrv *groups.GroupService, cs chat.Service, usrv *users.UserService, tracker *activity.Tracker) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		username := c.Locals("username").(string)
 
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
 
-		// Get Friends & Groups
-		friendsList, err := fsrv.GetUserFriends(ctx, username)
-		if err != nil {
-			return err
-		}
 		groupsList, err := gsrv.GetUserGroups(ctx, username)
 		if err != nil {
 			groupsList = []groups.GroupInfo{}
 		}
 
-		// Get Notifications (for unread counts)
-		notifData, _ := getNotificationData(ctx, username, fsrv, cs, callSrv)
+		changes, err := tracker.Changes(ctx, username, groupIDs(groupsList), c.Query("since"))
+		if err != nil {
+			// Without activity data the full list is served uncached
+			changes = &activity.Changes{Full: true}
+		} else {
+			etag := fmt.Sprintf(`W/"%s"`, changes.Cursor)
+			c.Set(fiber.HeaderCacheControl, "private, no-cache")
+			c.Set(fiber.HeaderETag, etag)
+			c.Set("X-Contacts-Version", changes.Cursor)
+
+			if c.Get(fiber.HeaderIfNoneMatch) == etag {
+				return c.SendStatus(fiber.StatusNotModified)
+			}
+		}
 
-		// Build Contacts
-		contacts := make([]ContactData, 0, len(friendsList)+len(groupsList))
-		unreadMap := notifData["UnreadMessages"].(map[string]int)
+		unreadMap, _ := cs.GetUnreadMessages(ctx, username)
+		groupUnread, _ := cs.GetGroupUnread(ctx, username, groupIDs(groupsList))
 
-		for _, friend := range friendsList {
-			contacts = append(contacts, ContactData{
-				Username:    friend.Username,
-				Icon:        friend.Icon,
-				CustomIcon:  friend.CustomIcon,
-				IsGroup:     false,
-				UnreadCount: unreadMap[friend.Username],
+		if changes.Full {
+			friendsList, err := fsrv.GetUserFriends(ctx, username)
+			if err != nil {
+				return err
+			}
+			if friendsList.Degraded {
+				// A fallback list must not be taken for this version
+				c.Response().Header.Del(fiber.HeaderETag)
+				c.Response().Header.Del("X-Contacts-Version")
+				c.Set(fiber.HeaderCacheControl, "no-store")
+				c.Set(HeaderDegraded, "true")
+			}
+
+			return c.Render("partials/contact-list", fiber.Map{
+				"Contacts": buildContacts(friendsList.Value, groupsList, unreadMap, groupUnread),
+				"Degraded": friendsList.Degraded,
 			})
 		}
-		for _, group := range groupsList {
+
+		// Delta: changed friends come from the user cache instead of
+		// reloading the friend list; the set of friends itself is unchanged
+		contacts := make([]ContactData, 0, len(changes.Users)+len(changes.Groups))
+		for _, contact := range changes.Users {
+			user, err := usrv.GetByUsername(ctx, contact)
+			if err != nil {
+				continue
+			}
 			contacts = append(contacts, ContactData{
-				Username:   group.Name,
-				Icon:       group.Icon,
-				CustomIcon: group.CustomIcon,
-				IsGroup:    true,
-				GroupID:    group.ID,
+				Username:    user.Username,
+				Icon:        user.Icon.String,
+				CustomIcon:  user.CustomIcon.String,
+				UnreadCount: unreadMap[user.Username],
 			})
 		}
 
+		changedGroups := make(map[string]bool, len(changes.Groups))
+		for _, groupID := range changes.Groups {
+			changedGroups[groupID] = true
+		}
+		for _, group := range groupsList {
+			if changedGroups[group.ID] {
+				contacts = append(contacts, groupContact(group, groupUnread[group.ID]))
+			}
+		}
+
+		if len(contacts) == 0 {
+			return c.SendStatus(fiber.StatusNoContent)
+		}
+
+		// Items replace their counterparts by ID; the list itself is left alone
+		c.Set("HX-Reswap", "none")
+
 		return c.Render("partials/contact-list", fiber.Map{
 			"Contacts": contacts,
+			"Delta":    true,
+		})
+	}
+}
+
+// buildContacts lists friends followed by groups
+func buildContacts(friendsList []friends.FriendInfo, groupsList []groups.GroupInfo, unreadMap, groupUnread map[string]int) []ContactData {
+	contacts := make([]ContactData, 0, len(friendsList)+len(groupsList))
+
+	for _, friend := range friendsList {
+		contacts = append(contacts, ContactData{
+			Username:    friend.Username,
+			Icon:        friend.Icon,
+			CustomIcon:  friend.CustomIcon,
+			IsGroup:     false,
+			UnreadCount: unreadMap[friend.Username],
 		})
 	}
+	for _, group := range groupsList {
+		contacts = append(contacts, groupContact(group, groupUnread[group.ID]))
+	}
+
+	return contacts
+}
+
+func groupContact(group groups.GroupInfo, unread int) ContactData {
+	return ContactData{
+		Username:    group.Name,
+		Icon:        group.Icon,
+		CustomIcon:  group.CustomIcon,
+		IsGroup:     true,
+		GroupID:     group.ID,
+		UnreadCount: unread,
+	}
+}
+
+func groupIDs(groupsList []groups.GroupInfo) []string {
+	ids := make([]string, 0, len(groupsList))
+	for _, group := range groupsList {
+		ids = append(ids, group.ID)
+	}
+	return ids
 }
 
 // HandleGetNotifications returns just the notification list HTML
-func HandleGetNotifications(fsrv *friends.FriendService, cs *chat.ChatService, callSrv *calls.CallService) fiber.Handler {
+func HandleGetNotifications(fsrv *friends.FriendService, cs chat.Service, callSrv *calls.CallService) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		username := c.Locals("username").(string)
 		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
@@ -198,8 +297,77 @@ func HandleGetNotifications(fsrv *friends.FriendService, cs *chat.ChatService, c
 	}
 }
 
+// Notification is one entry of the JSON notification list
+type Notification struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+	From string `json:"from"`
+
+	// Count is the number of unread messages for unread_messages entries
+	Count int `json:"count,omitempty"`
+
+	// Media is what a missed call would have carried
+	Media calls.MediaType `json:"media,omitempty"`
+
+	// At is when the notification was raised in unix seconds; unread
+	// message counts have no single time and sort last
+	At int64 `json:"at,omitempty"`
+}
+
+// Cursor orders notifications by time
+func (n Notification) Cursor() pagination.Cursor {
+	return pagination.Cursor{Key: pagination.IntKey(n.At), ID: n.ID}
+}
+
+// HandleListNotifications returns a page of friend requests, missed calls and
+// unread conversations, newest first
+func HandleListNotifications(fsrv *friends.FriendService, cs chat.Service, callSrv *calls.CallService) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		username := c.Locals("username").(string)
+
+		params, err := pageParams(c)
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		notifData, _ := getNotificationData(ctx, username, fsrv, cs, callSrv)
+
+		var notifications []Notification
+		for _, request := range notifData["Notifications"].([]friends.FriendInfo) {
+			notifications = append(notifications, Notification{
+				ID:   "friend_request:" + request.FriendID,
+				Type: "friend_request",
+				From: request.Username,
+				At:   request.CreatedAt.Unix(),
+			})
+		}
+		for _, call := range notifData["MissedCalls"].([]*calls.Call) {
+			notifications = append(notifications, Notification{
+				ID:    "missed_call:" + call.ID,
+				Type:  "missed_call",
+				From:  call.Caller,
+				At:    call.EndedAt,
+				Media: call.Media,
+			})
+		}
+		for from, count := range notifData["UnreadMessages"].(map[string]int) {
+			notifications = append(notifications, Notification{
+				ID:    "unread_messages:" + from,
+				Type:  "unread_messages",
+				From:  from,
+				Count: count,
+			})
+		}
+
+		return c.JSON(pagination.Apply(notifications, params, pagination.Descending, Notification.Cursor))
+	}
+}
+
 // HandleMarkNotificationsRead clears notifications
-func HandleMarkNotificationsRead(cs *chat.ChatService, callSrv *calls.CallService) fiber.Handler {
+func HandleMarkNotificationsRead(cs chat.Service, callSrv *calls.CallService, inbox *notifications.NotificationService) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		username := c.Locals("username").(string)
 		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
@@ -211,8 +379,15 @@ func HandleMarkNotificationsRead(cs *chat.ChatService, callSrv *calls.CallServic
 		}
 
 		// 2. Mark calls as seen
-		if err := callSrv.MarkCallsSeen(ctx, username); err != nil {
-			logger.WithError(err).Error("Failed to mark calls seen")
+		if callSrv != nil {
+			if err := callSrv.MarkCallsSeen(ctx, username); err != nil {
+				logger.WithError(err).Error("Failed to mark calls seen")
+			}
+		}
+
+		// 3. Mark the inbox as read
+		if err := inbox.MarkAllRead(ctx, username); err != nil {
+			logger.WithError(err).Error("Failed to mark notifications read")
 		}
 
 		c.Set("HX-Trigger", "notifications-updated")
@@ -2,9 +2,11 @@ package handlers
 
 import (
 	"bytes"
+	"context"
 	"crypto/rand"
 	"encoding/hex"
 	"exc6/apperrors"
+	"exc6/services/uploads"
 	"fmt"
 	"image"
 	_ "image/gif"
@@ -17,6 +19,8 @@ import (
 	"strings"
 	"time"
 
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
 	_ "golang.org/x/image/webp"
 )
 
@@ -278,6 +282,53 @@ func GenerateSecureFilename(userID string, originalExt string) (string, error) {
 	return filename, nil
 }
 
+// storeUpload saves a validated upload of owner in the content-addressed store
+func storeUpload(ctx context.Context, store *uploads.Store, owner uuid.UUID, fileHeader *multipart.FileHeader, mimeType string) (*uploads.Object, error) {
+	file, err := fileHeader.Open()
+	if err != nil {
+		return nil, apperrors.NewFileUploadError(fileHeader.Filename, "failed to open file", err)
+	}
+	defer file.Close()
+
+	return store.Put(ctx, owner, file, mimeType)
+}
+
+// HandleUploadObject redirects object URLs to a signed URL of the storage
+// backend, for backends the server does not serve files from
+func HandleUploadObject(store *uploads.Store, ttl time.Duration) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		url, err := store.SignedURL(ctx, c.Path(), ttl)
+		if err != nil {
+			return fiber.ErrNotFound
+		}
+
+		// Let browsers reuse the redirect for part of the URL's lifetime
+		c.Set(fiber.HeaderCacheControl, fmt.Sprintf("private, max-age=%d", int(ttl/2/time.Second)))
+		return c.Redirect(url, fiber.StatusFound)
+	}
+}
+
+// HandleUploadVariant serves an image variant missing from the uploads
+// directory, generating the variants of its object. The static file server
+// passes requests for missing files on to it.
+func HandleUploadVariant(store *uploads.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		content, err := store.Variant(ctx, c.Path())
+		if err != nil {
+			return err
+		}
+
+		c.Set(fiber.HeaderContentType, http.DetectContentType(content))
+		return c.Send(content)
+	}
+}
+
 // GetSafeUploadPath returns a safe upload path preventing directory traversal
 func GetSafeUploadPath(baseDir, filename string) string {
 	// Clean the path to prevent directory traversal
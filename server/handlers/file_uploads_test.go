@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"mime/multipart"
+	"net/textproto"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fileHeader builds the multipart header an upload of content would produce
+func fileHeader(t testing.TB, filename, contentType string, content []byte) *multipart.FileHeader {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+
+	h := make(textproto.MIMEHeader)
+	h.Set("Content-Disposition", `form-data; name="file"; filename="`+filename+`"`)
+	h.Set("Content-Type", contentType)
+	part, err := w.CreatePart(h)
+	require.NoError(t, err)
+	_, err = part.Write(content)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	form, err := multipart.NewReader(&body, w.Boundary()).ReadForm(MaxFileSize * 2)
+	require.NoError(t, err)
+	t.Cleanup(func() { form.RemoveAll() })
+
+	files := form.File["file"]
+	require.Len(t, files, 1)
+	return files[0]
+}
+
+func pngImage(t testing.TB) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	img.Set(0, 0, color.White)
+
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, img))
+	return buf.Bytes()
+}
+
+func FuzzValidateImageUploadStrict(f *testing.F) {
+	f.Add("avatar.png", "image/png", pngImage(f))
+	f.Add("avatar.jpg", "image/jpeg", []byte{0xFF, 0xD8, 0xFF, 0xE0, 0x00, 0x10, 'J', 'F', 'I', 'F', 0x00})
+	f.Add("avatar.gif", "image/gif", []byte("GIF89a\x01\x00\x01\x00\x00\x00\x00;"))
+	f.Add("avatar.webp", "image/webp", []byte("RIFF\x1a\x00\x00\x00WEBPVP8L\x0d\x00\x00\x00\x2f\x00\x00\x00\x10\x07\x10\x11\x11\x88\x88\xfe\x07\x00"))
+	f.Add("avatar.webp", "image/webp", []byte("RIFF"))
+	f.Add("avatar.webp", "image/webp", []byte("RIFF\x00\x00\x00\x00WEB"))
+	f.Add("x.png", "image/png", []byte{0x89})
+
+	f.Fuzz(func(t *testing.T, filename, contentType string, content []byte) {
+		if len(content) == 0 || len(content) > MaxFileSize {
+			return
+		}
+		if !AllowedImageMIMETypes[contentType] || !validFilename(filename) {
+			return
+		}
+
+		result, err := ValidateImageUploadStrict(fileHeader(t, filename, contentType, content))
+		require.NotNil(t, result)
+		if err != nil {
+			require.False(t, result.Valid)
+			return
+		}
+
+		require.True(t, result.Valid)
+		require.LessOrEqual(t, result.Width, MaxImageDimension)
+		require.LessOrEqual(t, result.Height, MaxImageDimension)
+	})
+}
+
+// validFilename reports whether a browser could send name as an upload filename
+func validFilename(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if r < 0x20 || r == 0x7f || r == '"' || r == utf8.RuneError {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"context"
+	"exc6/apperrors"
+	"exc6/pkg/breaker"
+	"exc6/pkg/instance"
+	"exc6/pkg/keyspace"
+	"exc6/pkg/logger"
+	_websocket "exc6/server/websocket"
+	"exc6/services/chat"
+	"exc6/services/cluster"
+	"exc6/services/sessions"
+	"runtime"
+	"time"
+
+	"github.com/gofiber/contrib/websocket"
+	"github.com/gofiber/fiber/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// HandleClusterInstances lists the server instances seen recently through their heartbeat
+func HandleClusterInstances(clusterSrv *cluster.ClusterService) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		instances, err := clusterSrv.ListInstances(ctx)
+		if err != nil {
+			return apperrors.NewInternalError("Failed to list cluster instances").WithInternal(err)
+		}
+
+		return c.JSON(fiber.Map{
+			"self":      instance.ID(),
+			"instances": instances,
+		})
+	}
+}
+
+// HandleRedisKeyspace reports Redis keys by family with their memory usage and
+// keys missing a TTL. "sample" sets how many keys per family are measured.
+func HandleRedisKeyspace(rdb *redis.Client) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+		defer cancel()
+
+		report, err := keyspace.Audit(ctx, rdb, keyspace.Options{
+			SampleSize: c.QueryInt("sample", keyspace.DefaultSampleSize),
+		})
+		if err != nil {
+			return apperrors.NewInternalError("Failed to audit Redis keyspace").WithInternal(err)
+		}
+
+		return c.JSON(report)
+	}
+}
+
+// HandleSessionCache lists the sessions in this instance's local cache, most
+// recently used first, with their IDs masked. "limit" defaults to 100.
+func HandleSessionCache(smngr *sessions.SessionManager) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		limit := c.QueryInt("limit", 100)
+		if limit < 1 || limit > 1000 {
+			return apperrors.NewValidationError("limit must be between 1 and 1000")
+		}
+
+		return c.JSON(fiber.Map{
+			"instance": instance.ID(),
+			"cache":    smngr.CachedSessions(limit),
+		})
+	}
+}
+
+// metricsStreamInterval is how often live metrics are pushed to the admin dashboard
+const metricsStreamInterval = time.Second
+
+// MetricsSnapshot is one sample of the live admin metrics stream
+type MetricsSnapshot struct {
+	Timestamp int64  `json:"timestamp"` // unix milliseconds
+	Instance  string `json:"instance"`
+
+	WebSocket _websocket.Stats `json:"websocket"`
+
+	// ChatBuffer is the number of messages waiting to be written to Kafka
+	ChatBuffer         int `json:"chat_buffer"`
+	ChatBufferCapacity int `json:"chat_buffer_capacity"`
+
+	// Rates are per second over the interval since the previous snapshot
+	MessagesTotal     int64   `json:"messages_total"`
+	MessagesPerSecond float64 `json:"messages_per_sec"`
+	DeliveredPerSec   float64 `json:"delivered_per_sec"`
+
+	Breakers   map[string]string `json:"breakers"`
+	Goroutines int               `json:"goroutines"`
+}
+
+// takeMetricsSnapshot samples the gauges; rates are computed against prev when given
+func takeMetricsSnapshot(wsManager *_websocket.Manager, csrv chat.Service, prev *MetricsSnapshot) MetricsSnapshot {
+	snapshot := MetricsSnapshot{
+		Timestamp:          time.Now().UnixMilli(),
+		Instance:           instance.ID(),
+		WebSocket:          wsManager.Stats(),
+		ChatBuffer:         csrv.BufferDepth(),
+		ChatBufferCapacity: chat.MessageBufferSize,
+		MessagesTotal:      csrv.MessagesAccepted(),
+		Breakers:           breaker.States(),
+		Goroutines:         runtime.NumGoroutine(),
+	}
+
+	if prev != nil {
+		if elapsed := float64(snapshot.Timestamp-prev.Timestamp) / 1000; elapsed > 0 {
+			snapshot.MessagesPerSecond = float64(snapshot.MessagesTotal-prev.MessagesTotal) / elapsed
+			snapshot.DeliveredPerSec = float64(snapshot.WebSocket.Delivered-prev.WebSocket.Delivered) / elapsed
+		}
+	}
+
+	return snapshot
+}
+
+// HandleAdminMetricsStream pushes a snapshot of this instance's key gauges
+// every second so the admin dashboard can graph them without polling /metrics
+func HandleAdminMetricsStream(wsManager *_websocket.Manager, csrv chat.Service) fiber.Handler {
+	cfg := websocket.Config{
+		Filter: func(c *fiber.Ctx) bool {
+			return isAllowedOrigin(c.Get("Origin"))
+		},
+	}
+
+	return websocket.New(func(conn *websocket.Conn) {
+		username, _ := conn.Locals("username").(string)
+
+		// The stream is one-way; reading only detects the dashboard closing
+		closed := make(chan struct{})
+		go func() {
+			defer close(closed)
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+
+		ticker := time.NewTicker(metricsStreamInterval)
+		defer ticker.Stop()
+
+		var prev *MetricsSnapshot
+		for {
+			snapshot := takeMetricsSnapshot(wsManager, csrv, prev)
+			prev = &snapshot
+
+			conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+			if err := conn.WriteJSON(snapshot); err != nil {
+				logger.WithField("username", username).Debug("Admin metrics stream closed")
+				return
+			}
+
+			select {
+			case <-ticker.C:
+			case <-closed:
+				return
+			}
+		}
+	}, cfg)
+}
@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"exc6/apperrors"
+	"exc6/pkg/instance"
+	_websocket "exc6/server/websocket"
+	"exc6/services/chat"
+	"exc6/services/cluster"
+	"exc6/tests/fakeredis"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClusterInstances(t *testing.T) {
+	srv := fakeredis.New(t)
+	clusterSrv := cluster.NewClusterService(context.Background(), srv.Client(t))
+	t.Cleanup(clusterSrv.Close)
+
+	app := fiber.New(fiber.Config{ErrorHandler: apperrors.Handler(apperrors.HandlerConfig{})})
+	app.Get("/admin/cluster", HandleClusterInstances(clusterSrv))
+
+	list := func() (int, map[string]json.RawMessage) {
+		resp, err := app.Test(httptest.NewRequest(fiber.MethodGet, "/admin/cluster", nil))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		var body map[string]json.RawMessage
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+		return resp.StatusCode, body
+	}
+
+	// The first heartbeat is sent in the background
+	var instances []cluster.Instance
+	require.Eventually(t, func() bool {
+		status, body := list()
+		require.Equal(t, fiber.StatusOK, status)
+		require.NoError(t, json.Unmarshal(body["instances"], &instances))
+		return len(instances) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	_, body := list()
+	var self string
+	require.NoError(t, json.Unmarshal(body["self"], &self))
+	assert.Equal(t, instance.ID(), self)
+	assert.Equal(t, instance.ID(), instances[0].ID)
+	assert.True(t, instances[0].Self)
+
+	srv.SetDown(true)
+	status, _ := list()
+	assert.Equal(t, fiber.StatusInternalServerError, status)
+}
+
+// countingChat reports a fixed buffer depth and accepted message count
+type countingChat struct {
+	chat.Service
+	depth    int
+	accepted int64
+}
+
+func (c *countingChat) BufferDepth() int        { return c.depth }
+func (c *countingChat) MessagesAccepted() int64 { return c.accepted }
+
+func TestMetricsSnapshot(t *testing.T) {
+	wsManager := _websocket.NewManager(context.Background(), fakeredis.New(t).Client(t))
+	t.Cleanup(wsManager.Close)
+	csrv := &countingChat{depth: 3, accepted: 110}
+
+	first := takeMetricsSnapshot(wsManager, csrv, nil)
+	assert.Equal(t, instance.ID(), first.Instance)
+	assert.Equal(t, 3, first.ChatBuffer)
+	assert.Equal(t, chat.MessageBufferSize, first.ChatBufferCapacity)
+	assert.Equal(t, int64(110), first.MessagesTotal)
+	assert.Zero(t, first.MessagesPerSecond, "no rate without a previous snapshot")
+	assert.NotNil(t, first.Breakers)
+	assert.Positive(t, first.Goroutines)
+
+	prev := first
+	prev.Timestamp -= 2000
+	prev.MessagesTotal = 100
+	csrv.accepted = 130
+	next := takeMetricsSnapshot(wsManager, csrv, &prev)
+	assert.InDelta(t, 15, next.MessagesPerSecond, 0.5, "30 messages over about 2s")
+	assert.Zero(t, next.DeliveredPerSec)
+
+	// A clock step back gives no rate rather than a negative one
+	same := next
+	same.MessagesTotal = 0
+	same.Timestamp = time.Now().Add(time.Hour).UnixMilli()
+	assert.Zero(t, takeMetricsSnapshot(wsManager, csrv, &same).MessagesPerSecond)
+}
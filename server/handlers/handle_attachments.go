@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"context"
+	"exc6/apperrors"
+	"exc6/config"
+	"exc6/services/moderation"
+	"exc6/services/uploads"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// attachmentDownloadTimeout bounds streaming one attachment blob
+const attachmentDownloadTimeout = 2 * time.Minute
+
+// HandleUploadAttachment stores an attachment the client encrypted for a
+// conversation. The form carries the blob, either "to" or "group_id", and
+// the declared "type" and "size" of the plaintext and its key "envelope".
+func HandleUploadAttachment(store *uploads.Store, policy *moderation.Policy) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if store == nil {
+			return apperrors.NewFeatureDisabled(config.FeatureUploads)
+		}
+
+		username := c.Locals("username").(string)
+
+		file, err := c.FormFile("blob")
+		if err != nil {
+			return apperrors.NewBadRequest("Attachment blob is required")
+		}
+
+		size, err := strconv.ParseInt(c.FormValue("size"), 10, 64)
+		if err != nil {
+			return apperrors.NewValidationError("Attachment size must be declared")
+		}
+		declaration := uploads.Declaration{
+			Type:     c.FormValue("type"),
+			Size:     size,
+			Envelope: c.FormValue("envelope"),
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		if err := policy.Check(ctx, username, moderation.ActionSendAttachment); err != nil {
+			return err
+		}
+
+		blob, err := file.Open()
+		if err != nil {
+			return apperrors.NewFileUploadError("", "failed to open file", err)
+		}
+		defer blob.Close()
+
+		attachment, err := store.PutAttachment(ctx, username, c.FormValue("to"), c.FormValue("group_id"), declaration, blob, file.Size)
+		if err != nil {
+			return err
+		}
+
+		return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+			"attachment": attachment,
+		})
+	}
+}
+
+// HandleGetAttachment returns an attachment's declared metadata and key
+// envelope to a participant of its conversation
+func HandleGetAttachment(store *uploads.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if store == nil {
+			return apperrors.NewFeatureDisabled(config.FeatureUploads)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		attachment, err := store.GetAttachment(ctx, c.Locals("username").(string), c.Params("id"))
+		if err != nil {
+			return err
+		}
+
+		c.Set(fiber.HeaderCacheControl, "private, no-store")
+		return c.JSON(fiber.Map{
+			"attachment": attachment,
+		})
+	}
+}
+
+// HandleDownloadAttachment streams an attachment's blob to a participant of
+// its conversation. The blob is ciphertext; clients decrypt it with the key
+// in its envelope.
+func HandleDownloadAttachment(store *uploads.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if store == nil {
+			return apperrors.NewFeatureDisabled(config.FeatureUploads)
+		}
+
+		lookupCtx, lookupCancel := context.WithTimeout(context.Background(), 3*time.Second)
+		attachment, err := store.GetAttachment(lookupCtx, c.Locals("username").(string), c.Params("id"))
+		lookupCancel()
+		if err != nil {
+			return err
+		}
+
+		// The blob is read after the handler returns; closing it ends the
+		// download's context
+		ctx, cancel := context.WithTimeout(context.Background(), attachmentDownloadTimeout)
+		blob, err := store.OpenAttachment(ctx, attachment)
+		if err != nil {
+			cancel()
+			return err
+		}
+
+		c.Set(fiber.HeaderContentType, fiber.MIMEOctetStream)
+		c.Set(fiber.HeaderContentDisposition, "attachment")
+		c.Set(fiber.HeaderCacheControl, "private, max-age=86400, immutable")
+		c.Set(fiber.HeaderXContentTypeOptions, "nosniff")
+		return c.SendStream(cancelOnClose{ReadCloser: blob, cancel: cancel}, int(attachment.BlobSize))
+	}
+}
+
+// cancelOnClose cancels a context when the reader it was opened with is
+// closed
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (r cancelOnClose) Close() error {
+	defer r.cancel()
+	return r.ReadCloser.Close()
+}
@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"context"
+	"exc6/apperrors"
+	_websocket "exc6/server/websocket"
+	"exc6/services/calls"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// HandleCallChatSend sends the "content" form field to both participants of
+// a call. The message is kept under the call, not in the conversation.
+func HandleCallChatSend(callService *calls.CallService, wsManager *_websocket.Manager) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		username, err := getUsernameFromContext(c)
+		if err != nil {
+			return handleUnauthorized(c)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		msg, call, err := callService.SendChatMessage(ctx, c.Params("call_id"), username, c.FormValue("content"))
+		if err != nil {
+			if call == nil {
+				return apperrors.NewBadRequest(err.Error())
+			}
+			return apperrors.NewInternalError("Failed to send message").WithInternal(err)
+		}
+
+		for _, participant := range []string{call.Caller, call.Callee} {
+			wsManager.SendToUser(participant, &_websocket.Message{
+				Type:    _websocket.MessageTypeCallChat,
+				ID:      msg.ID,
+				From:    username,
+				To:      participant,
+				Content: msg.Content,
+				Data: map[string]any{
+					"call_id": call.ID,
+				},
+				Timestamp: msg.Timestamp,
+			})
+		}
+
+		return c.JSON(msg)
+	}
+}
+
+// HandleCallChatHistory lists the messages sent so far in a call
+func HandleCallChatHistory(callService *calls.CallService) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		username, err := getUsernameFromContext(c)
+		if err != nil {
+			return handleUnauthorized(c)
+		}
+
+		call, err := callParticipant(callService, c.Params("call_id"), username)
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		messages, err := callService.ChatMessages(ctx, call.ID, username)
+		if err != nil {
+			return apperrors.NewInternalError("Failed to load messages").WithInternal(err)
+		}
+
+		return c.JSON(fiber.Map{
+			"call_id":  call.ID,
+			"messages": messages,
+		})
+	}
+}
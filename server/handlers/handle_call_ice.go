@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"exc6/services/calls"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// HandleCallICEServers returns the ICE servers for the user's peer
+// connections, with TURN credentials when TURN is configured. "ttl" is how
+// many seconds the credentials last; clients fetch a new list before placing
+// or answering a call once it has passed.
+func HandleCallICEServers(callService *calls.CallService) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		username, err := getUsernameFromContext(c)
+		if err != nil {
+			return handleUnauthorized(c)
+		}
+
+		now := time.Now()
+		servers, expires := callService.ICEServers(username, now)
+
+		// Credentials are per user
+		c.Set(fiber.HeaderCacheControl, "no-store")
+
+		resp := fiber.Map{"ice_servers": servers}
+		if !expires.IsZero() {
+			resp["ttl"] = int(expires.Sub(now).Seconds())
+			resp["expires_at"] = expires.Unix()
+		}
+		return c.JSON(resp)
+	}
+}
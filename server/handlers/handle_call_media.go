@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"exc6/apperrors"
+	_websocket "exc6/server/websocket"
+	"exc6/services/calls"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// mediaConstraints reads the limits a participant offers from the
+// "max_width", "max_height", "max_frame_rate" and "max_bitrate_kbps" form
+// fields that are set
+func mediaConstraints(c *fiber.Ctx) (calls.MediaConstraints, error) {
+	var mc calls.MediaConstraints
+	for field, limit := range map[string]*int{
+		"max_width":        &mc.MaxWidth,
+		"max_height":       &mc.MaxHeight,
+		"max_frame_rate":   &mc.MaxFrameRate,
+		"max_bitrate_kbps": &mc.MaxBitrate,
+	} {
+		raw := c.FormValue(field)
+		if raw == "" {
+			continue
+		}
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return mc, apperrors.NewValidationError("Invalid value for " + field)
+		}
+		*limit = n
+	}
+	if err := mc.Validate(); err != nil {
+		return mc, apperrors.NewValidationError(err.Error())
+	}
+	return mc, nil
+}
+
+// HandleCallMediaRequest asks the other participant of a call to change its
+// media to the "media" form field, such as upgrading an audio call to video,
+// offering the user's constraints
+func HandleCallMediaRequest(callService *calls.CallService, wsManager *_websocket.Manager) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		username, err := getUsernameFromContext(c)
+		if err != nil {
+			return handleUnauthorized(c)
+		}
+
+		media, err := calls.ParseMediaType(c.FormValue("media"))
+		if err != nil {
+			return apperrors.NewValidationError("Invalid media type")
+		}
+		offered, err := mediaConstraints(c)
+		if err != nil {
+			return err
+		}
+
+		call, err := callService.RequestMedia(c.Params("call_id"), username, media, offered)
+		if err != nil {
+			return apperrors.NewBadRequest(err.Error())
+		}
+
+		other := otherCallParty(call, username)
+		wsManager.SendToUser(other, callSignal(_websocket.MessageTypeCallMediaRequest, call, username, other, map[string]any{
+			"media":       media,
+			"constraints": offered,
+		}))
+
+		return c.JSON(fiber.Map{
+			"call_id":       call.ID,
+			"media_request": call.MediaRequest,
+		})
+	}
+}
+
+// HandleCallMediaAnswer answers a media change with accept=true or
+// accept=false, offering the user's constraints. Both participants are told
+// the outcome; on accepting, the one who asked places a new offer.
+func HandleCallMediaAnswer(callService *calls.CallService, wsManager *_websocket.Manager) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		username, err := getUsernameFromContext(c)
+		if err != nil {
+			return handleUnauthorized(c)
+		}
+
+		answered, err := mediaConstraints(c)
+		if err != nil {
+			return err
+		}
+		accept := c.FormValue("accept") == "true"
+
+		call, err := callService.AnswerMedia(c.Params("call_id"), username, accept, answered)
+		if err != nil {
+			return apperrors.NewBadRequest(err.Error())
+		}
+
+		for _, participant := range []string{call.Caller, call.Callee} {
+			wsManager.SendToUser(participant, callSignal(_websocket.MessageTypeCallMediaChange, call, username, participant, map[string]any{
+				"accepted":    accept,
+				"media":       call.Media,
+				"constraints": call.Constraints,
+			}))
+		}
+
+		return c.JSON(fiber.Map{
+			"call_id":     call.ID,
+			"accepted":    accept,
+			"media":       call.Media,
+			"constraints": call.Constraints,
+		})
+	}
+}
@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"context"
+	"exc6/apperrors"
+	_websocket "exc6/server/websocket"
+	"exc6/services/calls"
+	"exc6/services/users"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// callParticipant returns the active call and checks username takes part
+func callParticipant(callService *calls.CallService, callID, username string) (*calls.Call, error) {
+	if callID == "" {
+		return nil, apperrors.NewBadRequest("Call ID required")
+	}
+
+	call, err := callService.GetCall(callID)
+	if err != nil {
+		return nil, apperrors.NewBadRequest("Call not found")
+	}
+	if call.Caller != username && call.Callee != username {
+		return nil, apperrors.NewBadRequest("You are not part of this call")
+	}
+	return call, nil
+}
+
+// recordingMessage builds a recording signal about call for to
+func recordingMessage(msgType _websocket.MessageType, call *calls.Call, from, to string, data map[string]any) *_websocket.Message {
+	if data == nil {
+		data = make(map[string]any)
+	}
+	data["call_id"] = call.ID
+	data["recording"] = call.Recording
+
+	return &_websocket.Message{
+		Type:      msgType,
+		ID:        call.ID,
+		From:      from,
+		To:        to,
+		Data:      data,
+		Timestamp: time.Now().Unix(),
+	}
+}
+
+// HandleCallRecordRequest asks the other participant to consent to
+// recording the call. The organisation's policy decides who may ask.
+func HandleCallRecordRequest(callService *calls.CallService, wsManager *_websocket.Manager, usrv *users.UserService) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		username, err := getUsernameFromContext(c)
+		if err != nil {
+			return handleUnauthorized(c)
+		}
+
+		if !callService.RecordingEnabled() {
+			return apperrors.NewBadRequest("Call recording is not enabled")
+		}
+
+		call, err := callParticipant(callService, c.Params("call_id"), username)
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		user, err := usrv.GetByUsername(ctx, username)
+		if err != nil {
+			return err
+		}
+		if !callService.CanRecord(user.Role) {
+			return apperrors.NewAuthorizationError(username, "call recording", "record")
+		}
+
+		call, err = callService.RequestRecording(call.ID, username)
+		if err != nil {
+			return apperrors.NewBadRequest(err.Error())
+		}
+
+		other := call.Callee
+		if other == username {
+			other = call.Caller
+		}
+		wsManager.SendToUser(other, recordingMessage(_websocket.MessageTypeCallRecordRequest, call, username, other, nil))
+
+		return c.JSON(fiber.Map{
+			"call_id":   call.ID,
+			"recording": call.Recording,
+		})
+	}
+}
+
+// HandleCallRecordConsent answers a recording request with accept=true or
+// accept=false. Recording starts once both participants consented.
+func HandleCallRecordConsent(callService *calls.CallService, wsManager *_websocket.Manager) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		username, err := getUsernameFromContext(c)
+		if err != nil {
+			return handleUnauthorized(c)
+		}
+
+		call, err := callParticipant(callService, c.Params("call_id"), username)
+		if err != nil {
+			return err
+		}
+
+		consent := c.FormValue("accept") == "true"
+
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		defer cancel()
+
+		call, token, err := callService.AnswerRecording(ctx, call.ID, username, consent)
+		if call == nil {
+			return apperrors.NewBadRequest(err.Error())
+		}
+
+		requester := call.Recording.RequestedBy
+		wsManager.SendToUser(requester, recordingMessage(_websocket.MessageTypeCallRecordConsent, call, username, requester, map[string]any{
+			"accepted": consent,
+		}))
+
+		if err != nil {
+			// Both consented but the recorder could not start
+			wsManager.SendToUser(requester, recordingMessage(_websocket.MessageTypeCallRecordStop, call, username, requester, nil))
+			return apperrors.NewInternalError("Failed to start recording").WithInternal(err)
+		}
+
+		if token != "" {
+			// Both clients send their media to the recorder with the token
+			for _, participant := range []string{call.Caller, call.Callee} {
+				wsManager.SendToUser(participant, recordingMessage(_websocket.MessageTypeCallRecordStart, call, username, participant, map[string]any{
+					"token": token,
+				}))
+			}
+		}
+
+		return c.JSON(fiber.Map{
+			"call_id":   call.ID,
+			"recording": call.Recording,
+		})
+	}
+}
+
+// HandleCallRecordStop stops recording; either participant may stop it
+func HandleCallRecordStop(callService *calls.CallService, wsManager *_websocket.Manager) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		username, err := getUsernameFromContext(c)
+		if err != nil {
+			return handleUnauthorized(c)
+		}
+
+		call, err := callParticipant(callService, c.Params("call_id"), username)
+		if err != nil {
+			return err
+		}
+
+		call, err = callService.StopRecording(call.ID, username)
+		if err != nil {
+			return apperrors.NewBadRequest(err.Error())
+		}
+
+		other := call.Callee
+		if other == username {
+			other = call.Caller
+		}
+		wsManager.SendToUser(other, recordingMessage(_websocket.MessageTypeCallRecordStop, call, username, other, nil))
+
+		return c.JSON(fiber.Map{
+			"call_id":   call.ID,
+			"recording": call.Recording,
+		})
+	}
+}
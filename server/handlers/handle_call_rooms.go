@@ -0,0 +1,251 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"exc6/apperrors"
+	_websocket "exc6/server/websocket"
+	"exc6/services/calls"
+	"exc6/services/groups"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// roomError turns a call room error into a response
+func roomError(err error) error {
+	if errors.Is(err, calls.ErrRoomNotFound) {
+		return apperrors.New(apperrors.ErrCodeNotFound, "Call room not found", http.StatusNotFound)
+	}
+	return apperrors.NewBadRequest(err.Error())
+}
+
+// roomUpdate tells everyone who may join a room that it changed: the members
+// of its group, or its creator and the users invited
+func roomUpdate(wsManager *_websocket.Manager, room *calls.CallRoom, event, username string) {
+	msg := func() *_websocket.Message {
+		return &_websocket.Message{
+			Type: _websocket.MessageTypeRoomUpdate,
+			ID:   room.ID,
+			From: username,
+			Data: map[string]any{
+				"event":    event,
+				"room":     room,
+				"username": username,
+			},
+			Timestamp: time.Now().Unix(),
+		}
+	}
+
+	if room.GroupID != "" {
+		wsManager.BroadcastToGroup(room.GroupID, msg())
+		return
+	}
+	for _, user := range append([]string{room.CreatedBy}, room.Invited...) {
+		wsManager.SendToUser(user, msg())
+	}
+}
+
+// checkRoomGroup refuses group rooms to users outside the group
+func checkRoomGroup(ctx context.Context, gsrv *groups.GroupService, room *calls.CallRoom, username string) error {
+	if room.GroupID == "" {
+		return nil
+	}
+	member, err := gsrv.IsMember(ctx, room.GroupID, username)
+	if err != nil || !member {
+		return roomError(calls.ErrRoomNotFound)
+	}
+	return nil
+}
+
+// HandleCallRoomCreate opens a call room for the group in the "group_id"
+// form field, or for the comma-separated users in "invite", with the creator
+// as its first participant. "max" limits its participants.
+func HandleCallRoomCreate(callService *calls.CallService, gsrv *groups.GroupService, wsManager *_websocket.Manager) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		username, err := getUsernameFromContext(c)
+		if err != nil {
+			return handleUnauthorized(c)
+		}
+
+		maxParticipants := 0
+		if raw := c.FormValue("max"); raw != "" {
+			if maxParticipants, err = strconv.Atoi(raw); err != nil {
+				return apperrors.NewValidationError("Invalid room size")
+			}
+		}
+
+		var invited []string
+		for _, user := range strings.Split(c.FormValue("invite"), ",") {
+			if user = strings.TrimSpace(user); user != "" {
+				invited = append(invited, user)
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		groupID := c.FormValue("group_id")
+		if groupID != "" {
+			if _, err := gsrv.GetGroupInfo(ctx, groupID, username); err != nil {
+				return err
+			}
+		}
+
+		room, err := callService.CreateRoom(ctx, username, groupID, invited, maxParticipants)
+		if err != nil {
+			return roomError(err)
+		}
+
+		roomUpdate(wsManager, room, "created", username)
+
+		return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+			"room": room,
+		})
+	}
+}
+
+// HandleCallRoomGet returns a room to a user who may join it
+func HandleCallRoomGet(callService *calls.CallService, gsrv *groups.GroupService) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		username, err := getUsernameFromContext(c)
+		if err != nil {
+			return handleUnauthorized(c)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		room, err := callService.GetRoom(ctx, c.Params("room_id"))
+		if err != nil {
+			return roomError(err)
+		}
+		if room.GroupID == "" && room.CreatedBy != username && room.Participant(username) == nil && !slices.Contains(room.Invited, username) {
+			return roomError(calls.ErrRoomNotFound)
+		}
+		if err := checkRoomGroup(ctx, gsrv, room, username); err != nil {
+			return err
+		}
+
+		return c.JSON(fiber.Map{
+			"room": room,
+		})
+	}
+}
+
+// HandleCallRoomJoin adds the user to a room. The client then sends an offer
+// to each participant already there.
+func HandleCallRoomJoin(callService *calls.CallService, gsrv *groups.GroupService, wsManager *_websocket.Manager) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		username, err := getUsernameFromContext(c)
+		if err != nil {
+			return handleUnauthorized(c)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		room, err := callService.GetRoom(ctx, c.Params("room_id"))
+		if err != nil {
+			return roomError(err)
+		}
+		if err := checkRoomGroup(ctx, gsrv, room, username); err != nil {
+			return err
+		}
+
+		room, err = callService.JoinRoom(ctx, room.ID, username)
+		if err != nil {
+			return roomError(err)
+		}
+
+		roomUpdate(wsManager, room, "joined", username)
+
+		return c.JSON(fiber.Map{
+			"room": room,
+		})
+	}
+}
+
+// HandleCallRoomLeave removes the user from a room, ending it when they were
+// the last participant
+func HandleCallRoomLeave(callService *calls.CallService, wsManager *_websocket.Manager) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		username, err := getUsernameFromContext(c)
+		if err != nil {
+			return handleUnauthorized(c)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		room, ended, err := callService.LeaveRoom(ctx, c.Params("room_id"), username)
+		if err != nil {
+			return roomError(err)
+		}
+
+		event := "left"
+		if ended {
+			event = "ended"
+		}
+		roomUpdate(wsManager, room, event, username)
+
+		return c.JSON(fiber.Map{
+			"room":  room,
+			"ended": ended,
+		})
+	}
+}
+
+// HandleCallRoomState updates the user's state in a room from the "state",
+// "muted" and "video" form fields that are set
+func HandleCallRoomState(callService *calls.CallService, wsManager *_websocket.Manager) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		username, err := getUsernameFromContext(c)
+		if err != nil {
+			return handleUnauthorized(c)
+		}
+
+		var update calls.ParticipantUpdate
+		if state := c.FormValue("state"); state != "" {
+			s := calls.ParticipantState(state)
+			update.State = &s
+		}
+		if update.Muted, err = formBool(c, "muted"); err != nil {
+			return err
+		}
+		if update.Video, err = formBool(c, "video"); err != nil {
+			return err
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		room, err := callService.UpdateParticipant(ctx, c.Params("room_id"), username, update)
+		if err != nil {
+			return roomError(err)
+		}
+
+		roomUpdate(wsManager, room, "state", username)
+
+		return c.JSON(fiber.Map{
+			"room": room,
+		})
+	}
+}
+
+// formBool parses an optional boolean form field; nil means it is not set
+func formBool(c *fiber.Ctx, field string) (*bool, error) {
+	raw := c.FormValue(field)
+	if raw == "" {
+		return nil, nil
+	}
+	b, err := strconv.ParseBool(raw)
+	if err != nil {
+		return nil, apperrors.NewValidationError("Invalid value for " + field)
+	}
+	return &b, nil
+}
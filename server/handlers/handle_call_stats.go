@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"context"
+	"exc6/apperrors"
+	"exc6/services/calls"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// HandleCallStats accepts the WebRTC stats a participant's client measured
+// during a call, as JSON or a form: rtt_ms, jitter_ms, and packets_lost and
+// packets_received since the previous report
+func HandleCallStats(callService *calls.CallService) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		username, err := getUsernameFromContext(c)
+		if err != nil {
+			return handleUnauthorized(c)
+		}
+
+		var report calls.StatsReport
+		if err := c.BodyParser(&report); err != nil {
+			return apperrors.NewValidationError("Invalid stats report")
+		}
+		if err := report.Validate(); err != nil {
+			return apperrors.NewValidationError(err.Error())
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		if err := callService.RecordStats(ctx, c.Params("call_id"), username, report); err != nil {
+			return apperrors.NewBadRequest(err.Error())
+		}
+
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
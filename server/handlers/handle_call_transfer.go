@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"context"
+	"exc6/apperrors"
+	_websocket "exc6/server/websocket"
+	"exc6/services/calls"
+	"exc6/services/notifications"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// callSignal builds a call signaling message about call for to
+func callSignal(msgType _websocket.MessageType, call *calls.Call, from, to string, data map[string]any) *_websocket.Message {
+	if data == nil {
+		data = make(map[string]any)
+	}
+	data["call_id"] = call.ID
+	data["state"] = call.State
+
+	return &_websocket.Message{
+		Type:      msgType,
+		ID:        call.ID,
+		From:      from,
+		To:        to,
+		Data:      data,
+		Timestamp: time.Now().Unix(),
+	}
+}
+
+// HandleCallHold puts a call on hold and tells the other participant
+func HandleCallHold(callService *calls.CallService, wsManager *_websocket.Manager) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		username, err := getUsernameFromContext(c)
+		if err != nil {
+			return handleUnauthorized(c)
+		}
+
+		call, err := callService.Hold(c.Params("call_id"), username)
+		if err != nil {
+			return apperrors.NewBadRequest(err.Error())
+		}
+
+		other := otherCallParty(call, username)
+		wsManager.SendToUser(other, callSignal(_websocket.MessageTypeCallHold, call, username, other, nil))
+
+		return c.JSON(fiber.Map{
+			"call_id": call.ID,
+			"status":  call.State,
+		})
+	}
+}
+
+// HandleCallResume takes a call off hold and tells the other participant
+func HandleCallResume(callService *calls.CallService, wsManager *_websocket.Manager) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		username, err := getUsernameFromContext(c)
+		if err != nil {
+			return handleUnauthorized(c)
+		}
+
+		call, err := callService.Resume(c.Params("call_id"), username)
+		if err != nil {
+			return apperrors.NewBadRequest(err.Error())
+		}
+
+		other := otherCallParty(call, username)
+		wsManager.SendToUser(other, callSignal(_websocket.MessageTypeCallResume, call, username, other, nil))
+
+		return c.JSON(fiber.Map{
+			"call_id": call.ID,
+			"status":  call.State,
+		})
+	}
+}
+
+// HandleCallTransferStart places a consultation call to the user in the
+// "to" form field for a call the transferring user holds. The consultation
+// call then rings and is answered like any other call.
+func HandleCallTransferStart(callService *calls.CallService, wsManager *_websocket.Manager, inbox *notifications.NotificationService) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		username, err := getUsernameFromContext(c)
+		if err != nil {
+			return handleUnauthorized(c)
+		}
+
+		target := c.FormValue("to")
+		if target == "" {
+			return apperrors.NewBadRequest("Transfer target required")
+		}
+		if target == username {
+			return apperrors.NewBadRequest("Cannot transfer a call to yourself")
+		}
+		if !wsManager.IsUserOnline(target) {
+			return apperrors.NewBadRequest("User is offline")
+		}
+
+		consult, err := callService.StartTransfer(c.Params("call_id"), username, target)
+		if err != nil {
+			return apperrors.NewBadRequest(err.Error())
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		inbox.Notify(ctx, target, notifications.TypeCall, username, "Incoming call", map[string]string{"call_id": consult.ID})
+
+		return c.JSON(fiber.Map{
+			"call_id":     consult.ID,
+			"transfer_of": consult.TransferOf,
+			"status":      consult.State,
+		})
+	}
+}
+
+// HandleCallTransferComplete joins the held participant and the answered
+// transfer target in a new call. Both are told the new call; the held
+// participant places the offer.
+func HandleCallTransferComplete(callService *calls.CallService, wsManager *_websocket.Manager) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		username, err := getUsernameFromContext(c)
+		if err != nil {
+			return handleUnauthorized(c)
+		}
+
+		call, err := callService.CompleteTransfer(c.Params("call_id"), username)
+		if err != nil {
+			return apperrors.NewBadRequest(err.Error())
+		}
+
+		roles := map[string]string{call.Caller: "caller", call.Callee: "callee"}
+		for participant, role := range roles {
+			wsManager.SendToUser(participant, callSignal(_websocket.MessageTypeCallTransfer, call, username, participant, map[string]any{
+				"peer":             otherCallParty(call, participant),
+				"role":             role,
+				"transferred_by":   username,
+				"transferred_from": call.TransferredFrom,
+			}))
+		}
+
+		return c.JSON(fiber.Map{
+			"call_id": call.ID,
+			"status":  "transferred",
+		})
+	}
+}
+
+// HandleCallTransferCancel hangs up the consultation call; the original
+// call stays on hold until the transferring user resumes it
+func HandleCallTransferCancel(callService *calls.CallService, wsManager *_websocket.Manager) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		username, err := getUsernameFromContext(c)
+		if err != nil {
+			return handleUnauthorized(c)
+		}
+
+		consult, err := callService.CancelTransfer(c.Params("call_id"), username)
+		if err != nil {
+			return apperrors.NewBadRequest(err.Error())
+		}
+
+		wsManager.SendToUser(consult.Callee, callSignal(_websocket.MessageTypeCallEnd, consult, username, consult.Callee, map[string]any{
+			"ended_by": username,
+		}))
+
+		return c.JSON(fiber.Map{
+			"call_id":     consult.ID,
+			"transfer_of": consult.TransferOf,
+			"status":      "cancelled",
+		})
+	}
+}
+
+// otherCallParty returns the participant of call that isn't username
+func otherCallParty(call *calls.Call, username string) string {
+	if call.Caller == username {
+		return call.Callee
+	}
+	return call.Caller
+}
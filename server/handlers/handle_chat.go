@@ -3,15 +3,74 @@ package handlers
 import (
 	"context"
 	"exc6/apperrors"
-	"exc6/db"
+	"exc6/config"
 	"exc6/pkg/logger"
 	"exc6/services/chat"
+	"exc6/services/emoji"
+	"exc6/services/gifs"
+	"exc6/services/uploads"
+	"exc6/services/users"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 )
 
-func HandleLoadChatWindow(cs *chat.ChatService, qdb *db.Queries) fiber.Handler {
+// HandleChatHistory returns a page of a conversation, newest first
+func HandleChatHistory(cs chat.Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		currentUser := c.Locals("username").(string)
+		targetUser := c.Params("contact")
+
+		if targetUser == "" {
+			return apperrors.NewBadRequest("Contact parameter is required")
+		}
+
+		params, err := pageParams(c)
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		page, err := cs.GetHistoryPage(ctx, currentUser, targetUser, params)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(page)
+	}
+}
+
+// HandleChatSearch returns the messages of a conversation containing the
+// query parameter q, with match offsets and an anchor into the history
+func HandleChatSearch(cs chat.Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		currentUser := c.Locals("username").(string)
+		targetUser := c.Params("contact")
+
+		if targetUser == "" {
+			return apperrors.NewBadRequest("Contact parameter is required")
+		}
+
+		params, err := pageParams(c)
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		page, err := cs.SearchConversation(ctx, currentUser, targetUser, c.Query("q"), params)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(page)
+	}
+}
+
+func HandleLoadChatWindow(cs chat.Service, usrv *users.UserService) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		currentUser := c.Locals("username").(string)
 		targetUser := c.Params("contact")
@@ -24,9 +83,11 @@ func HandleLoadChatWindow(cs *chat.ChatService, qdb *db.Queries) fiber.Handler {
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
 
-		// Mark conversation as read
-		if err := cs.MarkConversationRead(ctx, currentUser, targetUser); err != nil {
-			logger.WithError(err).Warn("Failed to mark conversation as read")
+		// Mark conversation as read, unless support is looking
+		if !impersonating(c) {
+			if err := cs.MarkConversationRead(ctx, currentUser, targetUser); err != nil {
+				logger.WithError(err).Warn("Failed to mark conversation as read")
+			}
 		}
 
 		c.Set("HX-Trigger", "notifications-updated")
@@ -42,7 +103,7 @@ func HandleLoadChatWindow(cs *chat.ChatService, qdb *db.Queries) fiber.Handler {
 		}
 
 		// Get contact's user info for icon
-		contactUser, err := qdb.GetUserByUsername(ctx, targetUser)
+		contactUser, err := usrv.GetByUsername(ctx, targetUser)
 		contactIcon := ""
 		contactCustomIcon := ""
 		if err == nil {
@@ -73,7 +134,8 @@ func HandleLoadChatWindow(cs *chat.ChatService, qdb *db.Queries) fiber.Handler {
 		return c.Render("partials/chat-window", fiber.Map{
 			"Me":                currentUser,
 			"Other":             targetUser,
-			"Messages":          history,
+			"Messages":          history.Value,
+			"Degraded":          history.Degraded,
 			"ContactIcon":       contactIcon,
 			"ContactCustomIcon": contactCustomIcon,
 			"CSRFToken":         csrfToken,
@@ -82,7 +144,7 @@ func HandleLoadChatWindow(cs *chat.ChatService, qdb *db.Queries) fiber.Handler {
 }
 
 // HandleSendMessage - don't return HTML, let WebSocket handle message display
-func HandleSendMessage(cs *chat.ChatService) fiber.Handler {
+func HandleSendMessage(cs chat.Service, gifSrv *gifs.GifService, attachments *uploads.Store) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		currentUser := c.Locals("username").(string)
 		targetUser := c.Params("contact")
@@ -97,20 +159,71 @@ func HandleSendMessage(cs *chat.ChatService) fiber.Handler {
 			return apperrors.NewBadRequest("Target user is required")
 		}
 
+		parts, opts, err := prepareMessage(c, cs, gifSrv, attachments, currentUser, chat.MessageRef{With: targetUser}, content)
+		if err != nil {
+			return err
+		}
+
 		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 		defer cancel()
 
-		_, err := cs.SendMessage(ctx, currentUser, targetUser, content)
-		if err != nil {
-			logger.WithFields(map[string]interface{}{
-				"from":  currentUser,
-				"to":    targetUser,
-				"error": err.Error(),
-			}).Error("Failed to send message")
-			return apperrors.NewInternalError("Failed to send message").WithInternal(err)
+		// Chunked messages are sent as sequential parts, each counted against
+		// a message request to a non-friend
+		for _, part := range parts {
+			if err := cs.AdmitMessage(ctx, currentUser, targetUser); err != nil {
+				return err
+			}
+			if _, err := cs.SendMessage(ctx, currentUser, targetUser, part, opts...); err != nil {
+				logger.WithFields(map[string]interface{}{
+					"from":  currentUser,
+					"to":    targetUser,
+					"error": err.Error(),
+				}).Error("Failed to send message")
+				return apperrors.NewInternalError("Failed to send message").WithInternal(err)
+			}
 		}
 
 		// Return 200 OK without HTML - WebSocket will handle displaying the message via Redis Pub/Sub
 		return c.SendStatus(fiber.StatusOK)
 	}
 }
+
+// prepareMessage validates the optional subtype form field of a send request
+// and returns the messages to store. Text has built-in :shortcode: emoji
+// expanded and is checked against the length limit, which may split it into
+// parts; GIF messages must carry a URL from the configured GIF provider, and
+// attachment messages an attachment the sender uploaded for the conversation.
+func prepareMessage(c *fiber.Ctx, cs chat.Service, gifSrv *gifs.GifService, attachments *uploads.Store, sender string, conversation chat.MessageRef, content string) ([]string, []chat.SendOption, error) {
+	switch c.FormValue("subtype") {
+	case chat.SubtypeText:
+		parts, err := cs.SplitContent(emoji.Expand(content))
+		return parts, nil, err
+	case chat.SubtypeGIF:
+		if gifSrv == nil {
+			return nil, nil, apperrors.NewBadRequest("GIF messages are not enabled")
+		}
+		gifURL, ok := gifSrv.GIFURL(content)
+		if !ok {
+			return nil, nil, apperrors.NewValidationError("GIF messages must link to the GIF provider")
+		}
+		return []string{gifURL}, []chat.SendOption{chat.WithSubtype(chat.SubtypeGIF)}, nil
+	case chat.SubtypeAttachment:
+		if attachments == nil {
+			return nil, nil, apperrors.NewFeatureDisabled(config.FeatureUploads)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		attachment, err := attachments.GetAttachment(ctx, sender, content)
+		if err != nil {
+			return nil, nil, err
+		}
+		if attachment.Owner != sender || attachment.Recipient != conversation.With || attachment.GroupID != conversation.GroupID {
+			return nil, nil, apperrors.NewValidationError("Attachments can only be sent by their owner, to the conversation they were uploaded for")
+		}
+		return []string{attachment.ID}, []chat.SendOption{chat.WithSubtype(chat.SubtypeAttachment)}, nil
+	default:
+		return nil, nil, apperrors.NewBadRequest("Unknown message subtype")
+	}
+}
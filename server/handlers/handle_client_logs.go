@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"context"
+	"exc6/apperrors"
+	"exc6/services/clientlogs"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// clientLogBatch is the body of a client error report upload
+type clientLogBatch struct {
+	Reports []clientlogs.Report `json:"reports"`
+}
+
+// HandleClientLogs accepts a batch of client-side error reports as JSON:
+// {"reports": [{"kind": "js_error", "message": "...", ...}]}
+func HandleClientLogs(svc *clientlogs.Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		username, err := getUsernameFromContext(c)
+		if err != nil {
+			return handleUnauthorized(c)
+		}
+
+		var batch clientLogBatch
+		if err := c.BodyParser(&batch); err != nil {
+			return apperrors.NewValidationError("Invalid report batch")
+		}
+
+		requestID, _ := c.Locals("requestid").(string)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		result, err := svc.Ingest(ctx, username, c.Get(fiber.HeaderUserAgent), requestID, batch.Reports)
+		if err != nil {
+			return err
+		}
+
+		return c.Status(fiber.StatusAccepted).JSON(result)
+	}
+}
+
+// HandleClientLogsRecent lists the most recent client error reports.
+// Query: limit (default 100, at most 500).
+func HandleClientLogsRecent(svc *clientlogs.Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		limit := c.QueryInt("limit", 100)
+		if limit < 1 || limit > 500 {
+			return apperrors.NewValidationError("limit must be between 1 and 500")
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		entries, err := svc.Recent(ctx, limit)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(fiber.Map{"reports": entries})
+	}
+}
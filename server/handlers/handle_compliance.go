@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"context"
+	"exc6/apperrors"
+	"exc6/services/compliance"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// complianceUpdate is the body of a policy change
+type complianceUpdate struct {
+	Version int32             `json:"version"` // The version being replaced
+	Policy  compliance.Policy `json:"policy"`
+	Comment string            `json:"comment"`
+}
+
+// HandleCompliancePolicy returns the compliance policy in force
+func HandleCompliancePolicy(svc *compliance.Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		return c.JSON(svc.Current())
+	}
+}
+
+// HandleCompliancePolicyUpdate replaces the compliance policy. The body is
+// JSON: {"version": <current version>, "policy": {...}, "comment": "..."}.
+// A stale version is rejected with 409.
+func HandleCompliancePolicyUpdate(svc *compliance.Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		username := c.Locals("username").(string)
+
+		var body complianceUpdate
+		if err := c.BodyParser(&body); err != nil {
+			return apperrors.NewValidationError("Invalid policy: " + err.Error())
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		rev, err := svc.Update(ctx, body.Version, body.Policy, username, body.Comment)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(rev)
+	}
+}
+
+// HandleComplianceHistory lists recent versions of the compliance policy,
+// newest first
+func HandleComplianceHistory(svc *compliance.Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		history, err := svc.History(ctx)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(fiber.Map{"versions": history})
+	}
+}
@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"context"
+	"exc6/apperrors"
+	"exc6/services/chat"
+	"exc6/services/groups"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// HandleConversationEvents returns the events of a conversation, a contact's
+// username or a group ID, after the sequence number since_seq, oldest first.
+// "limit" caps the page. When the response says reset, the client reloads
+// the history and continues from latest_seq.
+func HandleConversationEvents(csrv chat.Service, gsrv *groups.GroupService) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		username, err := getUsernameFromContext(c)
+		if err != nil {
+			return handleUnauthorized(c)
+		}
+
+		since, err := strconv.ParseInt(c.Query("since_seq", "0"), 10, 64)
+		if err != nil || since < 0 {
+			return apperrors.NewValidationError("since_seq must be a sequence number")
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		contact, groupID := c.Params("id"), ""
+		if _, err := uuid.Parse(contact); err == nil {
+			member, err := gsrv.IsMember(ctx, contact, username)
+			if err != nil {
+				return err
+			}
+			if !member {
+				return apperrors.New(apperrors.ErrCodeUnauthorized, "You are not a member of this group", fiber.StatusForbidden)
+			}
+			contact, groupID = "", contact
+		}
+
+		feed, err := csrv.ConversationEvents(ctx, username, contact, groupID, since, c.QueryInt("limit", 100))
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(feed)
+	}
+}
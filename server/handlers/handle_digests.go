@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"context"
+	"exc6/apperrors"
+	"exc6/services/digests"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// digestSettingsRequest opts the user in or out of digests
+type digestSettingsRequest struct {
+	Enabled bool `json:"enabled" form:"enabled"`
+}
+
+// Pages of the unsubscribe link. The link only shows a form, so mail
+// scanners that follow links do not unsubscribe anyone.
+const (
+	unsubscribeForm = `<!DOCTYPE html><html><head><meta charset="utf-8"><title>Unsubscribe</title></head>` +
+		`<body><p>Stop receiving email digests of missed activity?</p>` +
+		`<form method="post"><button type="submit">Unsubscribe</button></form></body></html>`
+	unsubscribeDone = `<!DOCTYPE html><html><head><meta charset="utf-8"><title>Unsubscribed</title></head>` +
+		`<body><p>You will no longer receive email digests.</p></body></html>`
+	unsubscribeUnknown = `<!DOCTYPE html><html><head><meta charset="utf-8"><title>Unsubscribe</title></head>` +
+		`<body><p>This unsubscribe link is not valid.</p></body></html>`
+)
+
+// HandleDigestSettings returns whether the user receives digests
+func HandleDigestSettings(dsrv *digests.Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		username, err := getUsernameFromContext(c)
+		if err != nil {
+			return handleUnauthorized(c)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		settings, err := dsrv.GetSettings(ctx, username)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(settings)
+	}
+}
+
+// HandleUpdateDigestSettings opts the user in or out of digests
+func HandleUpdateDigestSettings(dsrv *digests.Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		username, err := getUsernameFromContext(c)
+		if err != nil {
+			return handleUnauthorized(c)
+		}
+
+		var body digestSettingsRequest
+		if err := c.BodyParser(&body); err != nil {
+			return apperrors.NewBadRequest("Invalid request body")
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		settings, err := dsrv.UpdateSettings(ctx, username, body.Enabled)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(settings)
+	}
+}
+
+// HandleDigestUnsubscribe serves the unsubscribe link of digest emails:
+// GET asks for confirmation and POST, also sent by mail clients offering
+// one-click unsubscribe, opts the user out
+func HandleDigestUnsubscribe(dsrv *digests.Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		c.Set(fiber.HeaderContentType, fiber.MIMETextHTMLCharsetUTF8)
+		c.Set(fiber.HeaderCacheControl, "no-store")
+		c.Set("X-Robots-Tag", "noindex, nofollow")
+		c.Set(fiber.HeaderReferrerPolicy, "no-referrer")
+
+		if c.Method() != fiber.MethodPost {
+			return c.SendString(unsubscribeForm)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		err := dsrv.Unsubscribe(ctx, c.Params("token"))
+		switch {
+		case err == nil:
+			return c.SendString(unsubscribeDone)
+		case err == digests.ErrUnknownToken:
+			return c.Status(fiber.StatusNotFound).SendString(unsubscribeUnknown)
+		default:
+			return err
+		}
+	}
+}
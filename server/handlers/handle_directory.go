@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"context"
+	"exc6/apperrors"
+	"exc6/services/directory"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// directorySettingsRequest opts the user in or out of the directory
+type directorySettingsRequest struct {
+	Listed bool     `json:"listed" form:"listed"`
+	Tags   []string `json:"tags" form:"tags"`
+}
+
+// HandleDirectory returns a page of the users listed in the directory,
+// optionally only those with the interest tag given by the query parameter tag
+func HandleDirectory(dsrv *directory.Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		params, err := pageParams(c)
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		page, err := dsrv.List(ctx, c.Query("tag"), params)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(page)
+	}
+}
+
+// HandleDirectorySettings returns whether the user is listed in the directory
+func HandleDirectorySettings(dsrv *directory.Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		username, err := getUsernameFromContext(c)
+		if err != nil {
+			return handleUnauthorized(c)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		settings, err := dsrv.GetSettings(ctx, username)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(settings)
+	}
+}
+
+// HandleUpdateDirectorySettings lists the user in the directory with their
+// interest tags, or removes them from it
+func HandleUpdateDirectorySettings(dsrv *directory.Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		username, err := getUsernameFromContext(c)
+		if err != nil {
+			return handleUnauthorized(c)
+		}
+
+		var body directorySettingsRequest
+		if err := c.BodyParser(&body); err != nil {
+			return apperrors.NewBadRequest("Invalid request body")
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		settings, err := dsrv.UpdateSettings(ctx, username, body.Listed, body.Tags)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(settings)
+	}
+}
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"context"
+	"exc6/apperrors"
+	"exc6/config"
+	"exc6/services/emoji"
+	"exc6/services/uploads"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+const (
+	MaxEmojiFileSize  = 256 * 1024 // 256KB
+	MaxEmojiDimension = 256        // Max width/height in pixels
+)
+
+// HandleEmojiCatalog returns the built-in and custom emoji. Clients revalidate
+// with the catalog version as ETag so added or removed emoji show up promptly.
+func HandleEmojiCatalog(esrv *emoji.EmojiService) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		catalog, err := esrv.Catalog(ctx)
+		if err != nil {
+			return err
+		}
+
+		etag := `"` + catalog.Version + `"`
+		c.Set(fiber.HeaderCacheControl, "no-cache")
+		c.Set(fiber.HeaderETag, etag)
+
+		if c.Get(fiber.HeaderIfNoneMatch) == etag {
+			return c.SendStatus(fiber.StatusNotModified)
+		}
+
+		return c.JSON(catalog)
+	}
+}
+
+// HandleEmojiCreate uploads a custom emoji through the image validation pipeline
+func HandleEmojiCreate(esrv *emoji.EmojiService, store *uploads.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if store == nil {
+			return apperrors.NewFeatureDisabled(config.FeatureUploads)
+		}
+
+		userID, _ := c.Locals("user_id").(string)
+
+		shortcode := strings.Trim(strings.ToLower(c.FormValue("shortcode")), ":")
+		if err := emoji.ValidateShortcode(shortcode); err != nil {
+			return err
+		}
+
+		file, err := c.FormFile("image")
+		if err != nil {
+			return apperrors.NewBadRequest("Emoji image is required")
+		}
+
+		if file.Size > MaxEmojiFileSize {
+			return apperrors.NewFileTooLarge(MaxEmojiFileSize)
+		}
+
+		valRes, err := ValidateImageUploadStrict(file)
+		if err != nil {
+			return err
+		}
+
+		if valRes.Width > MaxEmojiDimension || valRes.Height > MaxEmojiDimension {
+			return apperrors.NewValidationError(
+				fmt.Sprintf("Emoji images must be at most %dx%d pixels", MaxEmojiDimension, MaxEmojiDimension),
+			)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		createdBy, _ := uuid.Parse(userID)
+
+		obj, err := storeUpload(ctx, store, createdBy, file, valRes.DetectedMIME)
+		if err != nil {
+			return err
+		}
+
+		custom, err := esrv.Add(ctx, shortcode, obj.URL, createdBy)
+		if err != nil {
+			store.Release(ctx, obj.URL)
+			return err
+		}
+
+		return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+			"emoji": custom,
+		})
+	}
+}
+
+// HandleEmojiDelete removes a custom emoji
+func HandleEmojiDelete(esrv *emoji.EmojiService) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		if err := esrv.Remove(ctx, strings.Trim(c.Params("shortcode"), ":")); err != nil {
+			return err
+		}
+
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"context"
+	"exc6/services/experiments"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// HandleExperimentBucket returns the caller's bucket in an experiment and
+// records their exposure. Clients call it when they are about to show the
+// variant, not ahead of time, so the results only count exposed users.
+func HandleExperimentBucket(svc *experiments.Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		username, err := getUsernameFromContext(c)
+		if err != nil {
+			return handleUnauthorized(c)
+		}
+		userID, _ := c.Locals("user_id").(string)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+
+		name := c.Params("name")
+		bucket, err := svc.Expose(ctx, name, userID, username)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(fiber.Map{
+			"experiment": name,
+			"bucket":     bucket,
+		})
+	}
+}
+
+// HandleExperimentsList lists the configured experiments
+func HandleExperimentsList(svc *experiments.Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		return c.JSON(fiber.Map{"experiments": svc.List()})
+	}
+}
+
+// HandleExperimentResults returns the per-bucket totals of an experiment
+func HandleExperimentResults(svc *experiments.Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		name := c.Params("name")
+		results, err := svc.Results(ctx, name)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(fiber.Map{
+			"experiment": name,
+			"buckets":    results,
+		})
+	}
+}
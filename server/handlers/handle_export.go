@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"context"
+	"exc6/apperrors"
+	"exc6/services/export"
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// exportTimeout covers loading history and rendering a PDF
+const exportTimeout = 90 * time.Second
+
+// HandleExportChat exports the current user's conversation with a contact.
+// Query: format=html|pdf (default pdf), from/to=YYYY-MM-DD (inclusive, UTC).
+func HandleExportChat(esrv *export.ExportService) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		username, err := getUsernameFromContext(c)
+		if err != nil {
+			return handleUnauthorized(c)
+		}
+
+		contact := c.Params("contact")
+		if contact == "" {
+			return apperrors.NewBadRequest("Contact is required")
+		}
+
+		rng, err := parseExportRange(c)
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+		defer cancel()
+
+		transcript, err := esrv.DirectTranscript(ctx, username, contact, rng)
+		if err != nil {
+			return err
+		}
+
+		return sendExport(ctx, c, esrv, transcript, "chat-"+contact)
+	}
+}
+
+// HandleExportGroup exports a group's history for a member. Query as HandleExportChat.
+func HandleExportGroup(esrv *export.ExportService) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		username, err := getUsernameFromContext(c)
+		if err != nil {
+			return handleUnauthorized(c)
+		}
+
+		groupID := c.Params("groupId")
+
+		rng, err := parseExportRange(c)
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+		defer cancel()
+
+		transcript, err := esrv.GroupTranscript(ctx, username, groupID, rng)
+		if err != nil {
+			return err
+		}
+
+		return sendExport(ctx, c, esrv, transcript, "group-"+groupID)
+	}
+}
+
+func sendExport(ctx context.Context, c *fiber.Ctx, esrv *export.ExportService, transcript *export.Transcript, name string) error {
+	format := export.Format(c.Query("format", string(export.FormatPDF)))
+
+	body, contentType, err := esrv.Render(ctx, transcript, format)
+	if err != nil {
+		return err
+	}
+
+	filename := SanitizeFilename(fmt.Sprintf("%s-%s.%s", name, transcript.GeneratedAt.UTC().Format("20060102"), format))
+
+	c.Set(fiber.HeaderContentType, contentType)
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", filename))
+	c.Set(fiber.HeaderCacheControl, "no-store")
+
+	return c.Send(body)
+}
+
+// parseExportRange reads the optional from/to query dates
+func parseExportRange(c *fiber.Ctx) (export.Range, error) {
+	return parseDateRange(c.Query("from"), c.Query("to"))
+}
+
+// parseDateRange parses optional YYYY-MM-DD bounds; to includes the whole day
+func parseDateRange(from, to string) (export.Range, error) {
+	var rng export.Range
+
+	if from != "" {
+		t, err := time.Parse(time.DateOnly, from)
+		if err != nil {
+			return rng, apperrors.NewValidationError("Invalid from date (use YYYY-MM-DD)")
+		}
+		rng.From = t
+	}
+
+	if to != "" {
+		t, err := time.Parse(time.DateOnly, to)
+		if err != nil {
+			return rng, apperrors.NewValidationError("Invalid to date (use YYYY-MM-DD)")
+		}
+		rng.To = t.AddDate(0, 0, 1)
+	}
+
+	if !rng.From.IsZero() && !rng.To.IsZero() && !rng.From.Before(rng.To) {
+		return rng, apperrors.NewValidationError("from must not be after to")
+	}
+
+	return rng, nil
+}
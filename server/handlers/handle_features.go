@@ -0,0 +1,15 @@
+package handlers
+
+import (
+	"exc6/apperrors"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// HandleFeatureDisabled answers every request under the paths of a feature
+// the deployment turned off, so clients can tell it from a missing resource
+func HandleFeatureDisabled(feature string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		return apperrors.NewFeatureDisabled(feature)
+	}
+}
@@ -3,13 +3,17 @@ package handlers
 import (
 	"context"
 	"exc6/apperrors"
-	"exc6/server/websocket"
+	"exc6/pkg/pagination"
 	"exc6/services/friends"
+	"exc6/services/notifications"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 )
 
+// searchResultsLimit is the number of users shown in the friend search dropdown
+const searchResultsLimit = 10
+
 // HandleFriendsPage renders the friend management page
 func HandleFriendsPage(fsrv *friends.FriendService) fiber.Handler {
 	return func(c *fiber.Ctx) error {
@@ -35,7 +39,8 @@ func HandleFriendsPage(fsrv *friends.FriendService) fiber.Handler {
 
 		return c.Render("friends", fiber.Map{
 			"Username": username,
-			"Friends":  friends,
+			"Friends":  friends.Value,
+			"Degraded": friends.Degraded,
 			"Requests": requests,
 		})
 	}
@@ -59,19 +64,72 @@ func HandleSearchUsers(fsrv *friends.FriendService) fiber.Handler {
 		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 		defer cancel()
 
-		results, err := fsrv.SearchUsers(ctx, username, query)
+		page, err := fsrv.SearchUsers(ctx, username, query, pagination.Params{Limit: searchResultsLimit})
 		if err != nil {
 			return err
 		}
 
 		return c.Render("partials/user-search-results", fiber.Map{
-			"Results": results,
+			"Results": page.Items,
 		})
 	}
 }
 
+// HandleSearchUsersJSON returns a page of users matching the q prefix
+func HandleSearchUsersJSON(fsrv *friends.FriendService) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		username, err := getUsernameFromContext(c)
+		if err != nil {
+			return handleUnauthorized(c)
+		}
+
+		params, err := pageParams(c)
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		page, err := fsrv.SearchUsers(ctx, username, c.Query("q"), params)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(page)
+	}
+}
+
+// HandleListFriends returns a page of the user's friends ordered by username
+func HandleListFriends(fsrv *friends.FriendService) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		username, err := getUsernameFromContext(c)
+		if err != nil {
+			return handleUnauthorized(c)
+		}
+
+		params, err := pageParams(c)
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		friendsList, err := fsrv.GetUserFriends(ctx, username)
+		if err != nil {
+			return err
+		}
+		if friendsList.Degraded {
+			c.Set(HeaderDegraded, "true")
+		}
+
+		return c.JSON(pagination.Apply(friendsList.Value, params, pagination.Ascending, friends.FriendInfo.Cursor))
+	}
+}
+
 // HandleSendFriendRequest sends a friend request
-func HandleSendFriendRequest(fsrv *friends.FriendService, wsManager *websocket.Manager) fiber.Handler {
+func HandleSendFriendRequest(fsrv *friends.FriendService, inbox *notifications.NotificationService) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		username, err := getUsernameFromContext(c)
 		if err != nil {
@@ -90,14 +148,7 @@ func HandleSendFriendRequest(fsrv *friends.FriendService, wsManager *websocket.M
 			return err
 		}
 
-		// Send real-time notification
-		wsManager.SendToUser(targetUsername, &websocket.Message{
-			Type:      websocket.MessageTypeNotification,
-			From:      username,
-			To:        targetUsername,
-			Content:   "New friend request",
-			Timestamp: time.Now().Unix(),
-		})
+		inbox.Notify(ctx, targetUsername, notifications.TypeFriendRequest, username, "New friend request", nil)
 
 		// Return success message
 		return c.SendString(`
@@ -109,7 +160,7 @@ func HandleSendFriendRequest(fsrv *friends.FriendService, wsManager *websocket.M
 }
 
 // HandleAcceptFriendRequest accepts a friend request
-func HandleAcceptFriendRequest(fsrv *friends.FriendService, wsManager *websocket.Manager) fiber.Handler {
+func HandleAcceptFriendRequest(fsrv *friends.FriendService, inbox *notifications.NotificationService) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		username, err := getUsernameFromContext(c)
 		if err != nil {
@@ -128,14 +179,7 @@ func HandleAcceptFriendRequest(fsrv *friends.FriendService, wsManager *websocket
 			return err
 		}
 
-		// Send real-time notification to the requester
-		wsManager.SendToUser(requesterUsername, &websocket.Message{
-			Type:      websocket.MessageTypeNotification,
-			From:      username,
-			To:        requesterUsername,
-			Content:   "Friend request accepted",
-			Timestamp: time.Now().Unix(),
-		})
+		inbox.Notify(ctx, requesterUsername, notifications.TypeFriendAccept, username, "Friend request accepted", nil)
 
 		// Reload the friend requests list
 		requests, err := fsrv.GetFriendRequests(ctx, username)
@@ -208,7 +252,8 @@ func HandleRemoveFriend(fsrv *friends.FriendService) fiber.Handler {
 		}
 
 		return c.Render("partials/friends-list", fiber.Map{
-			"Friends": friends,
+			"Friends":  friends.Value,
+			"Degraded": friends.Degraded,
 		})
 	}
 }
@@ -0,0 +1,29 @@
+package handlers
+
+import (
+	"context"
+	"exc6/services/gifs"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// HandleGifSearch proxies a GIF search to the configured provider.
+// An empty q returns trending GIFs; pos is the cursor from a previous page.
+func HandleGifSearch(gifSrv *gifs.GifService) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if _, err := getUsernameFromContext(c); err != nil {
+			return handleUnauthorized(c)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		page, err := gifSrv.Search(ctx, c.Query("q"), c.Query("pos"))
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(page)
+	}
+}
@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"context"
+	"exc6/apperrors"
+	"exc6/services/groups"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// groupDMRequest names the participants of a new group DM besides the caller
+type groupDMRequest struct {
+	Participants []string `json:"participants" form:"participants"`
+}
+
+// HandleCreateGroupDM starts a group DM between the user and the named
+// participants. Messages go through the group routes with the returned ID.
+func HandleCreateGroupDM(gsrv *groups.GroupService) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		username, err := getUsernameFromContext(c)
+		if err != nil {
+			return handleUnauthorized(c)
+		}
+
+		var body groupDMRequest
+		if err := c.BodyParser(&body); err != nil {
+			return apperrors.NewBadRequest("Invalid request body")
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		dm, err := gsrv.CreateDM(ctx, username, body.Participants)
+		if err != nil {
+			return err
+		}
+
+		return c.Status(fiber.StatusCreated).JSON(dm)
+	}
+}
+
+// HandleConvertGroupDM turns a group DM into a regular group named by the
+// form field name, with the user as its admin
+func HandleConvertGroupDM(gsrv *groups.GroupService) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		username, err := getUsernameFromContext(c)
+		if err != nil {
+			return handleUnauthorized(c)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		group, err := gsrv.ConvertDM(ctx, c.Params("groupId"), username, c.FormValue("name"))
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(group)
+	}
+}
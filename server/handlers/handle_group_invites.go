@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"context"
+	"exc6/pkg/logger"
+	"exc6/services/groups"
+	"exc6/services/notifications"
+	"html"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// HandleInviteGroupMember invites the form's username to the route's group
+// and notifies them
+func HandleInviteGroupMember(gsrv *groups.GroupService, inbox *notifications.NotificationService) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		username, err := getUsernameFromContext(c)
+		if err != nil {
+			return handleUnauthorized(c)
+		}
+
+		groupID := c.Params("groupId")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		invite, err := gsrv.InviteMember(ctx, groupID, username, c.FormValue("username"))
+		if err != nil {
+			return err
+		}
+
+		logger.WithFields(map[string]interface{}{
+			"username": username,
+			"group_id": groupID,
+			"invitee":  invite.To,
+		}).Info("Member invited to group")
+
+		inbox.Notify(ctx, invite.To, notifications.TypeGroupInvite, username, "Invitation to join "+invite.GroupName, map[string]string{
+			"group_id":   groupID,
+			"group_name": invite.GroupName,
+		})
+
+		if isHTMXRequest(c) {
+			return c.SendString(`
+			<div class="bg-green-500/10 border border-green-500/30 text-green-400 p-3 rounded-xl text-sm animate-fade-in">
+				Invitation sent to ` + html.EscapeString(invite.To) + `
+			</div>
+		`)
+		}
+		return c.Status(fiber.StatusCreated).JSON(invite)
+	}
+}
+
+// HandleGroupInvites returns the pending invitations to the route's group
+func HandleGroupInvites(gsrv *groups.GroupService) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		username, err := getUsernameFromContext(c)
+		if err != nil {
+			return handleUnauthorized(c)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		invites, err := gsrv.ListGroupInvites(ctx, c.Params("groupId"), username)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(fiber.Map{"invites": invites})
+	}
+}
+
+// HandleListInvites returns the current user's pending invitations, newest
+// first
+func HandleListInvites(gsrv *groups.GroupService) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		username, err := getUsernameFromContext(c)
+		if err != nil {
+			return handleUnauthorized(c)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		invites, err := gsrv.ListInvites(ctx, username)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(fiber.Map{"invites": invites})
+	}
+}
+
+// HandleAcceptGroupInvite joins the route's group and tells the inviter.
+// HTMX requests are sent to the group's chat.
+func HandleAcceptGroupInvite(gsrv *groups.GroupService, inbox *notifications.NotificationService) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		username, err := getUsernameFromContext(c)
+		if err != nil {
+			return handleUnauthorized(c)
+		}
+
+		groupID := c.Params("groupId")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		invite, err := gsrv.AcceptInvite(ctx, groupID, username)
+		if err != nil {
+			return err
+		}
+
+		logger.WithFields(map[string]interface{}{
+			"username": username,
+			"group_id": groupID,
+			"inviter":  invite.From,
+		}).Info("Group invitation accepted")
+
+		inbox.Notify(ctx, invite.From, notifications.TypeGroupJoin, username, "Joined "+invite.GroupName, map[string]string{
+			"group_id":   groupID,
+			"group_name": invite.GroupName,
+		})
+
+		if isHTMXRequest(c) {
+			c.Set("HX-Redirect", "/dashboard?group="+groupID)
+			return c.SendStatus(fiber.StatusOK)
+		}
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+// HandleDeclineGroupInvite drops the current user's invitation to the
+// route's group
+func HandleDeclineGroupInvite(gsrv *groups.GroupService) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		username, err := getUsernameFromContext(c)
+		if err != nil {
+			return handleUnauthorized(c)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		if err := gsrv.DeclineInvite(ctx, c.Params("groupId"), username); err != nil {
+			return err
+		}
+
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
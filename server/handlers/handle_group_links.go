@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"context"
+	"exc6/apperrors"
+	"exc6/pkg/logger"
+	"exc6/services/groups"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// HandleCreateGroupLink creates a link to join the route's group. Form:
+// optional max_uses (0 for unlimited) and expires_in (e.g. "72h").
+func HandleCreateGroupLink(gsrv *groups.GroupService) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		username, err := getUsernameFromContext(c)
+		if err != nil {
+			return handleUnauthorized(c)
+		}
+
+		maxUses := 0
+		if v := c.FormValue("max_uses"); v != "" {
+			if maxUses, err = strconv.Atoi(v); err != nil {
+				return apperrors.NewValidationError("max_uses must be a number")
+			}
+		}
+
+		var ttl time.Duration
+		if v := c.FormValue("expires_in"); v != "" {
+			ttl, err = time.ParseDuration(v)
+			if err != nil || ttl <= 0 {
+				return apperrors.NewValidationError("expires_in must be a duration such as 72h")
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		link, err := gsrv.CreateInviteLink(ctx, c.Params("groupId"), username, maxUses, ttl)
+		if err != nil {
+			return err
+		}
+
+		return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+			"link": link,
+			"url":  c.BaseURL() + "/groups/join/" + link.Token,
+		})
+	}
+}
+
+// HandleGroupLinks lists the live join links of the route's group
+func HandleGroupLinks(gsrv *groups.GroupService) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		username, err := getUsernameFromContext(c)
+		if err != nil {
+			return handleUnauthorized(c)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		links, err := gsrv.InviteLinks(ctx, c.Params("groupId"), username)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(fiber.Map{"links": links})
+	}
+}
+
+// HandleRevokeGroupLink disables one of the route's group's join links
+func HandleRevokeGroupLink(gsrv *groups.GroupService) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		username, err := getUsernameFromContext(c)
+		if err != nil {
+			return handleUnauthorized(c)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		if err := gsrv.RevokeInviteLink(ctx, c.Params("groupId"), username, c.Params("linkId")); err != nil {
+			return err
+		}
+
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+// HandleJoinGroupByLink joins the group behind the route's token and sends
+// the user to its chat
+func HandleJoinGroupByLink(gsrv *groups.GroupService) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		username, err := getUsernameFromContext(c)
+		if err != nil {
+			return handleUnauthorized(c)
+		}
+
+		// Following a link is a GET, which read-only support sessions may
+		// make, but it joins the user to the group
+		if impersonating(c) {
+			admin, _ := c.Locals("impersonator").(string)
+			return apperrors.NewImpersonationReadOnly(admin, c.Method()+" "+c.Path())
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		groupID, err := gsrv.JoinByLink(ctx, c.Params("token"), username)
+		if err != nil {
+			return err
+		}
+
+		logger.WithFields(map[string]any{
+			"username": username,
+			"group_id": groupID,
+		}).Info("User followed group invite link")
+
+		return c.Redirect("/dashboard?group=" + groupID)
+	}
+}
@@ -0,0 +1,26 @@
+package handlers
+
+import (
+	"exc6/apperrors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJoinGroupByLinkWhileImpersonating(t *testing.T) {
+	app := fiber.New(fiber.Config{ErrorHandler: apperrors.Handler(apperrors.HandlerConfig{})})
+	app.Use(func(c *fiber.Ctx) error {
+		c.Locals("username", "alice")
+		c.Locals("impersonator", "admin")
+		return c.Next()
+	})
+	// The group service is never reached
+	app.Get("/groups/join/:token", HandleJoinGroupByLink(nil))
+
+	resp, err := app.Test(httptest.NewRequest(fiber.MethodGet, "/groups/join/abc", nil))
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusForbidden, resp.StatusCode)
+}
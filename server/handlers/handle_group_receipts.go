@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"context"
+	"exc6/apperrors"
+	"exc6/services/chat"
+	"exc6/services/groups"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// HandleTrackedGroupMessages lists the delivered and read counts of the
+// group's messages sent with read receipts; group admins only
+func HandleTrackedGroupMessages(csrv chat.Service, gsrv *groups.GroupService) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		username, err := getUsernameFromContext(c)
+		if err != nil {
+			return handleUnauthorized(c)
+		}
+
+		groupID := c.Params("groupId")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		groupInfo, err := gsrv.GetGroupInfo(ctx, groupID, username)
+		if err != nil {
+			return err
+		}
+		if !groupInfo.IsAdmin() {
+			return apperrors.New(apperrors.ErrCodeUnauthorized, "Only group admins can view read receipts", fiber.StatusForbidden)
+		}
+
+		messages, err := csrv.TrackedMessages(ctx, groupID, chat.MaxTrackedListed)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(fiber.Map{"messages": messages})
+	}
+}
+
+// HandleTrackedMessageReceipts returns who received and read a message sent
+// with read receipts; visible to its sender and the group admins
+func HandleTrackedMessageReceipts(csrv chat.Service, gsrv *groups.GroupService) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		username, err := getUsernameFromContext(c)
+		if err != nil {
+			return handleUnauthorized(c)
+		}
+
+		groupID := c.Params("groupId")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		groupInfo, err := gsrv.GetGroupInfo(ctx, groupID, username)
+		if err != nil {
+			return err
+		}
+
+		receipts, err := csrv.TrackedReceipts(ctx, groupID, c.Params("messageId"))
+		if err != nil {
+			return err
+		}
+		if !groupInfo.IsAdmin() && receipts.From != username {
+			return apperrors.New(apperrors.ErrCodeUnauthorized, "Only the sender and group admins can view read receipts", fiber.StatusForbidden)
+		}
+
+		return c.JSON(receipts)
+	}
+}
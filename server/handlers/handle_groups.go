@@ -3,12 +3,15 @@ package handlers
 import (
 	"context"
 	"exc6/apperrors"
-	"exc6/db"
 	"exc6/pkg/logger"
+	"exc6/pkg/pagination"
 	"exc6/server/websocket"
 	"exc6/services/chat"
+	"exc6/services/gifs"
 	"exc6/services/groups"
+	"exc6/services/uploads"
 	"html"
+	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
@@ -37,8 +40,33 @@ func HandleGetGroups(gsrv *groups.GroupService) fiber.Handler {
 	}
 }
 
+// HandleListGroups returns a page of the user's groups ordered by name
+func HandleListGroups(gsrv *groups.GroupService) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		username, err := getUsernameFromContext(c)
+		if err != nil {
+			return handleUnauthorized(c)
+		}
+
+		params, err := pageParams(c)
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		groupsList, err := gsrv.GetUserGroups(ctx, username)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(pagination.Apply(groupsList, params, pagination.Ascending, groups.GroupInfo.Cursor))
+	}
+}
+
 // HandleSendGroupMessage sends a message to a group
-func HandleSendGroupMessage(csrv *chat.ChatService, gsrv *groups.GroupService, wsManager *websocket.Manager) fiber.Handler {
+func HandleSendGroupMessage(csrv chat.Service, gsrv *groups.GroupService, gifSrv *gifs.GifService, attachments *uploads.Store, wsManager *websocket.Manager) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		username, err := getUsernameFromContext(c)
 		if err != nil {
@@ -56,31 +84,68 @@ func HandleSendGroupMessage(csrv *chat.ChatService, gsrv *groups.GroupService, w
 			return apperrors.NewBadRequest("Group ID required")
 		}
 
+		parts, opts, err := prepareMessage(c, csrv, gifSrv, attachments, username, chat.MessageRef{GroupID: groupID}, content)
+		if err != nil {
+			return err
+		}
+
 		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 		defer cancel()
 
 		// Verify user is member
-		_, err = gsrv.GetGroupInfo(ctx, groupID, username)
+		groupInfo, err := gsrv.GetGroupInfo(ctx, groupID, username)
 		if err != nil {
 			return err
 		}
 
-		// Send message (Persist to DB/Redis)
-		msg, err := csrv.SendGroupMessage(ctx, username, groupID, content)
-		if err != nil {
-			logger.WithError(err).Error("Failed to send group message")
-			return apperrors.NewInternalError("Failed to send message").WithInternal(err)
+		// Read receipts are opt-in per message, for group admins only
+		if c.FormValue("track") != "" {
+			trackOpt, err := trackingOption(ctx, gsrv, groupInfo, username)
+			if err != nil {
+				return err
+			}
+			opts = append(opts, trackOpt)
 		}
 
-		wsMsg := &websocket.Message{
-			Type:      websocket.MessageTypeGroupChat,
-			ID:        msg.MessageID,
-			From:      msg.FromID,
-			GroupID:   msg.GroupID,
-			Content:   msg.Content,
-			Timestamp: msg.Timestamp,
+		// Mentions are resolved against the members so that @word only tags users
+		if strings.Contains(content, "@") {
+			members, err := gsrv.GetGroupMembers(ctx, groupID, username)
+			if err != nil {
+				return err
+			}
+			opts = append(opts, chat.WithMentions(otherMembers(members, username)))
+		}
+
+		// Send message (Persist to DB/Redis); chunked messages go out as sequential parts
+		for _, part := range parts {
+			msg, err := csrv.SendGroupMessage(ctx, username, groupID, part, opts...)
+			if err != nil {
+				logger.WithError(err).Error("Failed to send group message")
+				return apperrors.NewInternalError("Failed to send message").WithInternal(err)
+			}
+
+			wsMsg := &websocket.Message{
+				Type:      websocket.MessageTypeGroupChat,
+				ID:        msg.MessageID,
+				From:      msg.FromID,
+				GroupID:   msg.GroupID,
+				Content:   msg.Content,
+				Timestamp: msg.Timestamp,
+			}
+			if msg.Subtype != "" || msg.Tracked || len(msg.Mentions) > 0 {
+				wsMsg.Data = map[string]any{}
+			}
+			if msg.Subtype != "" {
+				wsMsg.Data["subtype"] = msg.Subtype
+			}
+			if msg.Tracked {
+				wsMsg.Data[websocket.TrackedDataKey] = true
+			}
+			if len(msg.Mentions) > 0 {
+				wsMsg.Data["mentions"] = msg.Mentions
+			}
+			wsManager.BroadcastToGroup(groupID, wsMsg)
 		}
-		wsManager.BroadcastToGroup(groupID, wsMsg)
 
 		logger.WithFields(map[string]interface{}{
 			"username": username,
@@ -91,8 +156,22 @@ func HandleSendGroupMessage(csrv *chat.ChatService, gsrv *groups.GroupService, w
 	}
 }
 
+// trackingOption requests read receipts from everyone in the group but the sender
+func trackingOption(ctx context.Context, gsrv *groups.GroupService, groupInfo *groups.GroupInfo, username string) (chat.SendOption, error) {
+	if !groupInfo.IsAdmin() {
+		return nil, apperrors.New(apperrors.ErrCodeUnauthorized, "Only group admins can request read receipts", fiber.StatusForbidden)
+	}
+
+	members, err := gsrv.GetGroupMembers(ctx, groupInfo.ID, username)
+	if err != nil {
+		return nil, err
+	}
+
+	return chat.WithTracking(otherMembers(members, username)), nil
+}
+
 // HandleLoadGroupChatIntegrated loads a group chat window (integrated with dashboard)
-func HandleLoadGroupChatIntegrated(csrv *chat.ChatService, gsrv *groups.GroupService, qdb *db.Queries) fiber.Handler {
+func HandleLoadGroupChatIntegrated(csrv chat.Service, gsrv *groups.GroupService) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		username, err := getUsernameFromContext(c)
 		if err != nil {
@@ -120,6 +199,18 @@ func HandleLoadGroupChatIntegrated(csrv *chat.ChatService, gsrv *groups.GroupSer
 			history = []*chat.ChatMessage{}
 		}
 
+		// Tracked messages sent while the user was offline arrive with the
+		// history, unless support is looking
+		if !impersonating(c) {
+			if err := csrv.MarkGroupDelivered(ctx, username, groupID); err != nil {
+				logger.WithError(err).Debug("Failed to record delivery of tracked group messages")
+			}
+
+			if err := csrv.MarkGroupRead(ctx, username, groupID); err != nil {
+				logger.WithError(err).Warn("Failed to mark group as read")
+			}
+		}
+
 		// Get CSRF token
 		csrfToken := ""
 		if token := c.Locals("csrf_token"); token != nil {
@@ -260,6 +351,49 @@ func HandleRemoveGroupMemberPartial(gsrv *groups.GroupService) fiber.Handler {
 	}
 }
 
+// HandleUpdateGroupMemberRolePartial changes a member's role and returns the
+// updated list
+func HandleUpdateGroupMemberRolePartial(gsrv *groups.GroupService) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		username, err := getUsernameFromContext(c)
+		if err != nil {
+			return handleUnauthorized(c)
+		}
+
+		groupID := c.Params("groupId")
+		targetUsername := c.Params("username")
+		role := c.FormValue("role")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		err = gsrv.UpdateMemberRole(ctx, groupID, username, targetUsername, role)
+		if err != nil {
+			return err
+		}
+
+		logger.WithFields(map[string]interface{}{
+			"username": username,
+			"group_id": groupID,
+			"member":   targetUsername,
+			"role":     role,
+		}).Info("Group member role changed")
+
+		// Return updated member list
+		members, err := gsrv.GetGroupMembers(ctx, groupID, username)
+		if err != nil {
+			return err
+		}
+
+		groupInfo, _ := gsrv.GetGroupInfo(ctx, groupID, username)
+
+		return c.Render("partials/group-members-list", fiber.Map{
+			"Group":   groupInfo,
+			"Members": members,
+		})
+	}
+}
+
 // HandleCreateGroupFromDashboard creates a group and returns success message
 func HandleCreateGroupFromDashboard(gsrv *groups.GroupService) fiber.Handler {
 	return func(c *fiber.Ctx) error {
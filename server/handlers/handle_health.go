@@ -14,11 +14,11 @@ import (
 type HealthCheckHandler struct {
 	rdb  *redis.Client
 	qdb  *db.Queries
-	csrv *chat.ChatService
+	csrv chat.Service
 }
 
 // NewHealthCheckHandler creates a new health check handler
-func NewHealthCheckHandler(rdb *redis.Client, qdb *db.Queries, csrv *chat.ChatService) *HealthCheckHandler {
+func NewHealthCheckHandler(rdb *redis.Client, qdb *db.Queries, csrv chat.Service) *HealthCheckHandler {
 	return &HealthCheckHandler{
 		rdb:  rdb,
 		qdb:  qdb,
@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"context"
+	"exc6/apperrors"
+	"exc6/server/middleware/proxy"
+	"exc6/services/impersonation"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// impersonatorCookie keeps the admin's own session while they use another
+// user's, so ending the impersonation signs them back in
+const impersonatorCookie = "impersonator_session_id"
+
+// HandleSupportAccess reports whether the user lets support staff sign in
+// as them, and until when
+func HandleSupportAccess(isrv *impersonation.Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		username := c.Locals("username").(string)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		consent, err := isrv.Consent(ctx, username)
+		if err != nil {
+			return err
+		}
+		if consent == nil {
+			return c.JSON(fiber.Map{"granted": false})
+		}
+
+		return c.JSON(fiber.Map{
+			"granted":    true,
+			"expires_at": consent.ExpiresAt,
+		})
+	}
+}
+
+// HandleSupportAccessGrant lets support staff sign in as the user for
+// impersonation.ConsentTTL
+func HandleSupportAccessGrant(isrv *impersonation.Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		username := c.Locals("username").(string)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		consent, err := isrv.Grant(ctx, username)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(fiber.Map{
+			"granted":    true,
+			"expires_at": consent.ExpiresAt,
+		})
+	}
+}
+
+// HandleSupportAccessRevoke withdraws the user's grant of support access
+func HandleSupportAccessRevoke(isrv *impersonation.Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		username := c.Locals("username").(string)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		if err := isrv.Revoke(ctx, username); err != nil {
+			return err
+		}
+
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+// HandleImpersonationStart signs the admin in as a user who granted support
+// access. The reason comes from the form or, for htmx prompts, the
+// HX-Prompt header. The admin's own session is kept aside for when they end
+// the impersonation.
+func HandleImpersonationStart(isrv *impersonation.Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		admin := c.Locals("username").(string)
+
+		reason := c.FormValue("reason")
+		if reason == "" {
+			reason = c.Get("HX-Prompt")
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		session, record, err := isrv.Start(ctx, admin, c.Params("username"), reason)
+		if err != nil {
+			return err
+		}
+
+		c.Cookie(&fiber.Cookie{
+			Name:     impersonatorCookie,
+			Value:    c.Cookies("session_id"),
+			Expires:  record.ExpiresAt,
+			HTTPOnly: true,
+			SameSite: "Lax",
+			Secure:   proxy.SecureCookie(c),
+			Path:     "/",
+		})
+		c.Cookie(&fiber.Cookie{
+			Name:     "session_id",
+			Value:    session.SessionID,
+			Expires:  record.ExpiresAt,
+			HTTPOnly: true,
+			SameSite: "Lax",
+			Secure:   proxy.SecureCookie(c),
+			Path:     "/",
+		})
+
+		c.Set("HX-Redirect", "/dashboard")
+		return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+			"impersonation": record,
+		})
+	}
+}
+
+// HandleImpersonationEnd ends the impersonation of the current session and
+// signs the admin back in with the session they started it from
+func HandleImpersonationEnd(isrv *impersonation.Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if !impersonating(c) {
+			return apperrors.NewBadRequest("This is not a support session")
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		if _, err := isrv.End(ctx, c.Cookies("session_id")); err != nil {
+			return err
+		}
+
+		// Without the admin's session the cookie is cleared, signing them out
+		c.Cookie(&fiber.Cookie{
+			Name:     "session_id",
+			Value:    c.Cookies(impersonatorCookie),
+			Expires:  time.Now().Add(24 * time.Hour),
+			HTTPOnly: true,
+			SameSite: "Lax",
+			Secure:   proxy.SecureCookie(c),
+			Path:     "/",
+		})
+		c.Cookie(&fiber.Cookie{
+			Name:     impersonatorCookie,
+			Value:    "",
+			Expires:  time.Now().Add(-1 * time.Hour),
+			HTTPOnly: true,
+			Path:     "/",
+		})
+
+		c.Set("HX-Redirect", "/dashboard")
+		return c.SendStatus(fiber.StatusOK)
+	}
+}
+
+// HandleImpersonationsList shows the impersonation audit trail, of one user
+// with ?username=
+func HandleImpersonationsList(isrv *impersonation.Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		records, err := isrv.List(ctx, c.Query("username"))
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(fiber.Map{
+			"impersonations": records,
+		})
+	}
+}
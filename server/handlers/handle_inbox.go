@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"context"
+	"exc6/services/notifications"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// HandleInbox returns a page of the user's notification inbox, newest first,
+// with their unread count. ?unread=true lists only unread notifications.
+func HandleInbox(inbox *notifications.NotificationService) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		username, err := getUsernameFromContext(c)
+		if err != nil {
+			return handleUnauthorized(c)
+		}
+
+		params, err := pageParams(c)
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		page, err := inbox.List(ctx, username, params, notifications.Filter{UnreadOnly: c.QueryBool("unread")})
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(page)
+	}
+}
+
+// HandleMentions returns a page of the user's recent mentions in groups,
+// newest first, with their unread count. ?unread=true lists only unread
+// mentions.
+func HandleMentions(inbox *notifications.NotificationService) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		username, err := getUsernameFromContext(c)
+		if err != nil {
+			return handleUnauthorized(c)
+		}
+
+		params, err := pageParams(c)
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		page, err := inbox.List(ctx, username, params, notifications.Filter{
+			UnreadOnly: c.QueryBool("unread"),
+			Type:       notifications.TypeMention,
+		})
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(page)
+	}
+}
+
+// HandleMarkInboxRead marks the route's notification as read
+func HandleMarkInboxRead(inbox *notifications.NotificationService) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		username, err := getUsernameFromContext(c)
+		if err != nil {
+			return handleUnauthorized(c)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		if err := inbox.MarkRead(ctx, username, c.Params("id")); err != nil {
+			return err
+		}
+
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+// HandleMarkInboxAllRead marks every notification in the user's inbox as read
+func HandleMarkInboxAllRead(inbox *notifications.NotificationService) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		username, err := getUsernameFromContext(c)
+		if err != nil {
+			return handleUnauthorized(c)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		if err := inbox.MarkAllRead(ctx, username); err != nil {
+			return err
+		}
+
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
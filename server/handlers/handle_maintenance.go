@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"context"
+	"exc6/apperrors"
+	"exc6/pkg/logger"
+	"exc6/server/sse"
+	_websocket "exc6/server/websocket"
+	"exc6/services/maintenance"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// maintenanceEvent is the WS message type and SSE event type of maintenance
+// announcements
+const maintenanceEvent = "maintenance"
+
+// AnnounceMaintenance returns a notifier that pushes announcements to every
+// WS and SSE client connected to this instance
+func AnnounceMaintenance(wsManager *_websocket.Manager, broker *sse.Broker) maintenance.Notifier {
+	return func(a maintenance.Announcement) {
+		wsManager.BroadcastLocal(&_websocket.Message{
+			Type:    _websocket.MessageTypeMaintenance,
+			Content: a.Window.Message,
+			Data: map[string]any{
+				"state":        a.State,
+				"window":       a.Window,
+				"seconds_left": a.SecondsLeft,
+			},
+			Timestamp: time.Now().Unix(),
+		})
+
+		if err := broker.BroadcastLocal(maintenanceEvent, a); err != nil {
+			logger.WithError(err).Warn("Failed to broadcast maintenance announcement")
+		}
+	}
+}
+
+// HandleMaintenanceStatus returns the scheduled maintenance window, if any,
+// so clients can show the banner when they load
+func HandleMaintenanceStatus(svc *maintenance.Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		status := svc.Status(time.Now())
+		if status == nil {
+			return c.JSON(fiber.Map{"state": "none"})
+		}
+		return c.JSON(status)
+	}
+}
+
+// HandleMaintenanceSchedule schedules a maintenance window from the form
+// fields starts_at (RFC 3339) or starts_in (e.g. "30m"), duration and an
+// optional message
+func HandleMaintenanceSchedule(svc *maintenance.Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		username := c.Locals("username").(string)
+
+		var startsAt time.Time
+		switch {
+		case c.FormValue("starts_at") != "":
+			t, err := time.Parse(time.RFC3339, c.FormValue("starts_at"))
+			if err != nil {
+				return apperrors.NewValidationError("starts_at must be an RFC 3339 time")
+			}
+			startsAt = t
+		case c.FormValue("starts_in") != "":
+			d, err := time.ParseDuration(c.FormValue("starts_in"))
+			if err != nil {
+				return apperrors.NewValidationError("starts_in must be a duration such as 30m")
+			}
+			startsAt = time.Now().Add(d)
+		default:
+			return apperrors.NewValidationError("starts_at or starts_in is required")
+		}
+
+		duration, err := time.ParseDuration(c.FormValue("duration"))
+		if err != nil {
+			return apperrors.NewValidationError("duration must be a duration such as 15m")
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		window, err := svc.Schedule(ctx, startsAt, startsAt.Add(duration), c.FormValue("message"), username)
+		if err != nil {
+			return err
+		}
+
+		return c.Status(fiber.StatusCreated).JSON(window)
+	}
+}
+
+// HandleMaintenanceCancel cancels the scheduled maintenance window, ending
+// maintenance mode early if it already started
+func HandleMaintenanceCancel(svc *maintenance.Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		if err := svc.Cancel(ctx); err != nil {
+			return err
+		}
+
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
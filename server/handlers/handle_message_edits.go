@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"context"
+	"exc6/apperrors"
+	"exc6/server/sse"
+	"exc6/services/chat"
+	"exc6/services/emoji"
+	"exc6/services/groups"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// HandleEditMessage replaces the content of a direct message the user sent
+func HandleEditMessage(cs chat.Service, broker *sse.Broker) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		username, err := getUsernameFromContext(c)
+		if err != nil {
+			return handleUnauthorized(c)
+		}
+
+		contact := c.Params("contact")
+		if contact == "" {
+			return apperrors.NewBadRequest("Contact parameter is required")
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		ref := chat.MessageRef{ID: c.Params("messageId"), With: contact}
+		msg, err := cs.EditMessage(ctx, username, ref, emoji.Expand(c.FormValue("content")))
+		if err != nil {
+			return err
+		}
+
+		notifyMessageChange(broker, []string{contact}, msg, NotificationMessageEdit)
+		return c.JSON(msg)
+	}
+}
+
+// HandleDeleteMessage withdraws a direct message the user sent
+func HandleDeleteMessage(cs chat.Service, broker *sse.Broker) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		username, err := getUsernameFromContext(c)
+		if err != nil {
+			return handleUnauthorized(c)
+		}
+
+		contact := c.Params("contact")
+		if contact == "" {
+			return apperrors.NewBadRequest("Contact parameter is required")
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		msg, err := cs.DeleteMessage(ctx, username, chat.MessageRef{ID: c.Params("messageId"), With: contact})
+		if err != nil {
+			return err
+		}
+
+		notifyMessageChange(broker, []string{contact}, msg, NotificationMessageDelete)
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+// HandleEditGroupMessage replaces the content of a group message the user sent
+func HandleEditGroupMessage(cs chat.Service, gsrv *groups.GroupService, broker *sse.Broker) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		username, err := getUsernameFromContext(c)
+		if err != nil {
+			return handleUnauthorized(c)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		groupID := c.Params("groupId")
+		members, err := gsrv.GetGroupMembers(ctx, groupID, username)
+		if err != nil {
+			return err
+		}
+
+		ref := chat.MessageRef{ID: c.Params("messageId"), GroupID: groupID}
+		msg, err := cs.EditMessage(ctx, username, ref, emoji.Expand(c.FormValue("content")))
+		if err != nil {
+			return err
+		}
+
+		notifyMessageChange(broker, otherMembers(members, username), msg, NotificationMessageEdit)
+		return c.JSON(msg)
+	}
+}
+
+// HandleDeleteGroupMessage withdraws a group message the user sent
+func HandleDeleteGroupMessage(cs chat.Service, gsrv *groups.GroupService, broker *sse.Broker) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		username, err := getUsernameFromContext(c)
+		if err != nil {
+			return handleUnauthorized(c)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		groupID := c.Params("groupId")
+		members, err := gsrv.GetGroupMembers(ctx, groupID, username)
+		if err != nil {
+			return err
+		}
+
+		msg, err := cs.DeleteMessage(ctx, username, chat.MessageRef{ID: c.Params("messageId"), GroupID: groupID})
+		if err != nil {
+			return err
+		}
+
+		notifyMessageChange(broker, otherMembers(members, username), msg, NotificationMessageDelete)
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+// notifyMessageChange tells the other participants' notification streams
+// about an edit or delete, so a client showing the message from a
+// notification can update it
+func notifyMessageChange(broker *sse.Broker, recipients []string, msg *chat.ChatMessage, notificationType string) {
+	data := map[string]string{"message_id": msg.MessageID}
+	if msg.GroupID != "" {
+		data["group_id"] = msg.GroupID
+	}
+	for _, recipient := range recipients {
+		publishNotification(broker, recipient, notificationType, msg.FromID, msg.Content, data)
+	}
+}
+
+// otherMembers returns the usernames of a group's members except username
+func otherMembers(members []groups.MemberInfo, username string) []string {
+	others := make([]string, 0, len(members))
+	for _, m := range members {
+		if m.Username != username {
+			others = append(others, m.Username)
+		}
+	}
+	return others
+}
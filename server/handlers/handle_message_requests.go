@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"context"
+	"exc6/services/chat"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// HandleMessageRequests returns the pending message requests to the current
+// user, most recently active first
+func HandleMessageRequests(cs chat.Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		username, err := getUsernameFromContext(c)
+		if err != nil {
+			return handleUnauthorized(c)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		requests, err := cs.ListMessageRequests(ctx, username)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(fiber.Map{"requests": requests})
+	}
+}
+
+// HandleAcceptMessageRequest lets the route's sender message the current user
+// freely
+func HandleAcceptMessageRequest(cs chat.Service) fiber.Handler {
+	return handleMessageRequest(cs.AcceptMessageRequest)
+}
+
+// HandleDeclineMessageRequest stops the route's sender from messaging the
+// current user
+func HandleDeclineMessageRequest(cs chat.Service) fiber.Handler {
+	return handleMessageRequest(cs.DeclineMessageRequest)
+}
+
+func handleMessageRequest(answer func(context.Context, string, string) error) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		username, err := getUsernameFromContext(c)
+		if err != nil {
+			return handleUnauthorized(c)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		if err := answer(ctx, username, c.Params("username")); err != nil {
+			return err
+		}
+
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
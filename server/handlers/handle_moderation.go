@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"context"
+	"exc6/apperrors"
+	"exc6/services/moderation"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// HandleReportUser files an abuse report against a user. The reason comes
+// from the form or, for htmx prompts, the HX-Prompt header.
+func HandleReportUser(policy *moderation.Policy) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		username := c.Locals("username").(string)
+
+		reason := c.FormValue("reason")
+		if reason == "" {
+			reason = c.Get("HX-Prompt")
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		if err := policy.Report(ctx, username, c.Params("username"), reason); err != nil {
+			return err
+		}
+
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+// HandleRestrictionsList lists active restrictions for the admin dashboard
+func HandleRestrictionsList(policy *moderation.Policy) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		restrictions, err := policy.ListRestrictions(ctx)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(fiber.Map{
+			"restrictions": restrictions,
+		})
+	}
+}
+
+// HandleRestrictionReports lists the reports filed against a user
+func HandleRestrictionReports(policy *moderation.Policy) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		reports, err := policy.ReportsAgainst(ctx, c.Params("username"))
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(fiber.Map{
+			"reports": reports,
+		})
+	}
+}
+
+// HandleRestrictionReview upholds or lifts a restriction ("decision" is
+// "uphold" or "lift")
+func HandleRestrictionReview(policy *moderation.Policy) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		reviewer := c.Locals("username").(string)
+
+		var uphold bool
+		switch c.FormValue("decision") {
+		case "uphold":
+			uphold = true
+		case "lift":
+		default:
+			return apperrors.NewValidationError("decision must be uphold or lift")
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		if err := policy.Review(ctx, c.Params("username"), reviewer, uphold); err != nil {
+			return err
+		}
+
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"context"
+	"exc6/apperrors"
+	"exc6/services/notifications"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// muteRequest mutes a direct conversation or a group
+type muteRequest struct {
+	Contact string `json:"contact" form:"contact"`
+	GroupID string `json:"group_id" form:"group_id"`
+
+	// Duration is a Go duration such as "8h"; empty mutes until unmuted
+	Duration string `json:"duration" form:"duration"`
+}
+
+// HandleListMutes returns the user's muted conversations
+func HandleListMutes(msrv *notifications.Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		username, err := getUsernameFromContext(c)
+		if err != nil {
+			return handleUnauthorized(c)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		mutes, err := msrv.List(ctx, username)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(fiber.Map{"mutes": mutes})
+	}
+}
+
+// HandleMuteConversation mutes a direct conversation or a group for the
+// requested duration, or until unmuted
+func HandleMuteConversation(msrv *notifications.Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		username, err := getUsernameFromContext(c)
+		if err != nil {
+			return handleUnauthorized(c)
+		}
+
+		var body muteRequest
+		if err := c.BodyParser(&body); err != nil {
+			return apperrors.NewBadRequest("Invalid request body")
+		}
+
+		var duration time.Duration
+		if body.Duration != "" {
+			if duration, err = time.ParseDuration(body.Duration); err != nil || duration <= 0 {
+				return apperrors.NewValidationError("Duration must be positive, such as 8h")
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		mute, err := msrv.Mute(ctx, username, body.Contact, body.GroupID, duration)
+		if err != nil {
+			return err
+		}
+
+		return c.Status(fiber.StatusCreated).JSON(mute)
+	}
+}
+
+// HandleUnmuteConversation lifts the mute of the conversation given by the
+// contact or group_id query parameter
+func HandleUnmuteConversation(msrv *notifications.Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		username, err := getUsernameFromContext(c)
+		if err != nil {
+			return handleUnauthorized(c)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		if err := msrv.Unmute(ctx, username, c.Query("contact"), c.Query("group_id")); err != nil {
+			return err
+		}
+
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"context"
+	"exc6/services/chat"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// HandlePinnedMessages returns the pinned messages of the conversation with
+// the route's contact
+func HandlePinnedMessages(cs chat.Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		username, err := getUsernameFromContext(c)
+		if err != nil {
+			return handleUnauthorized(c)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		pins, err := cs.GetPinnedMessages(ctx, username, c.Params("contact"))
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(fiber.Map{"pins": pins})
+	}
+}
+
+// HandlePinMessage pins a message of the conversation with the route's
+// contact for both participants
+func HandlePinMessage(cs chat.Service) fiber.Handler {
+	return handlePin(cs.PinMessage)
+}
+
+// HandleUnpinMessage unpins a message of the conversation with the route's
+// contact
+func HandleUnpinMessage(cs chat.Service) fiber.Handler {
+	return handlePin(cs.UnpinMessage)
+}
+
+func handlePin(change func(context.Context, string, string, string) ([]chat.Pin, error)) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		username, err := getUsernameFromContext(c)
+		if err != nil {
+			return handleUnauthorized(c)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		pins, err := change(ctx, username, c.Params("contact"), c.Params("messageId"))
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(fiber.Map{"pins": pins})
+	}
+}
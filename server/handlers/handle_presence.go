@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"context"
+	_websocket "exc6/server/websocket"
+	"exc6/services/presence"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// NotifyPresence returns a notifier that pushes presence changes to the
+// friends connected to this instance
+func NotifyPresence(wsManager *_websocket.Manager) presence.Notifier {
+	return func(recipient string, change presence.Change) {
+		wsManager.SendLocal(recipient, &_websocket.Message{
+			Type: _websocket.MessageTypePresence,
+			From: change.Username,
+			To:   recipient,
+			Data: map[string]any{
+				"online":    change.Online,
+				"last_seen": change.LastSeen.Unix(),
+			},
+			Timestamp: time.Now().Unix(),
+		})
+	}
+}
+
+// HandleOnlineFriends lists the user's friends who are online on any instance
+func HandleOnlineFriends(presenceSrv *presence.Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		username, err := getUsernameFromContext(c)
+		if err != nil {
+			return handleUnauthorized(c)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		online, err := presenceSrv.GetOnlineFriends(ctx, username)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(fiber.Map{"online": online})
+	}
+}
+
+// HandleFriendPresence returns whether the route's friend is online and when
+// they were last seen
+func HandleFriendPresence(presenceSrv *presence.Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		username, err := getUsernameFromContext(c)
+		if err != nil {
+			return handleUnauthorized(c)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		p, err := presenceSrv.FriendPresence(ctx, username, c.Params("username"))
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(p)
+	}
+}
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"context"
+	"exc6/services/chat"
+	"exc6/services/emoji"
+	"exc6/services/groups"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// HandleGetReactions returns the reactions to a message, whose conversation
+// is named by the query parameters contact or group_id
+func HandleGetReactions(cs chat.Service, gsrv *groups.GroupService) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		username, err := getUsernameFromContext(c)
+		if err != nil {
+			return handleUnauthorized(c)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		ref, err := reactionRef(ctx, c, gsrv, username, c.Query("contact"), c.Query("group_id"))
+		if err != nil {
+			return err
+		}
+
+		reactions, err := cs.GetReactions(ctx, username, ref)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(fiber.Map{"reactions": reactions})
+	}
+}
+
+// HandleAddReaction reacts to a message with the form field emoji. The form
+// fields contact or group_id name the message's conversation.
+func HandleAddReaction(cs chat.Service, gsrv *groups.GroupService) fiber.Handler {
+	return handleReaction(gsrv, cs.AddReaction)
+}
+
+// HandleRemoveReaction withdraws the user's reaction named by the form field
+// emoji
+func HandleRemoveReaction(cs chat.Service, gsrv *groups.GroupService) fiber.Handler {
+	return handleReaction(gsrv, cs.RemoveReaction)
+}
+
+func handleReaction(gsrv *groups.GroupService, change func(context.Context, string, chat.MessageRef, string) ([]chat.Reaction, error)) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		username, err := getUsernameFromContext(c)
+		if err != nil {
+			return handleUnauthorized(c)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		ref, err := reactionRef(ctx, c, gsrv, username, c.FormValue("contact"), c.FormValue("group_id"))
+		if err != nil {
+			return err
+		}
+
+		reactions, err := change(ctx, username, ref, emoji.Expand(c.FormValue("emoji")))
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(fiber.Map{"reactions": reactions})
+	}
+}
+
+// reactionRef identifies the message of the route in the conversation named
+// by contact or groupID, checking group membership
+func reactionRef(ctx context.Context, c *fiber.Ctx, gsrv *groups.GroupService, username, contact, groupID string) (chat.MessageRef, error) {
+	ref := chat.MessageRef{ID: c.Params("id"), With: contact, GroupID: groupID}
+	if groupID != "" {
+		if _, err := gsrv.GetGroupMembers(ctx, groupID, username); err != nil {
+			return ref, err
+		}
+	}
+	return ref, nil
+}
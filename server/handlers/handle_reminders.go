@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"context"
+	"exc6/apperrors"
+	"exc6/services/reminders"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// reminderRequest creates a reminder either from structured fields or from
+// the natural language form used by /remind (e.g. "me in 2h to stretch")
+type reminderRequest struct {
+	Command string `json:"command"`
+	Text    string `json:"text"`
+	In      string `json:"in"`
+	Every   string `json:"every"`
+}
+
+// HandleReminderCreate schedules a reminder for the current user
+func HandleReminderCreate(rsrv *reminders.ReminderService) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		username, err := getUsernameFromContext(c)
+		if err != nil {
+			return handleUnauthorized(c)
+		}
+
+		var body reminderRequest
+		if err := c.BodyParser(&body); err != nil {
+			return apperrors.NewBadRequest("Invalid request body")
+		}
+
+		var text string
+		var delay, every time.Duration
+
+		if body.Command != "" {
+			req, err := reminders.ParseRequest(body.Command)
+			if err != nil {
+				return apperrors.NewValidationError("Invalid reminder: " + err.Error())
+			}
+			text, delay, every = req.Text, req.Delay, req.Every
+		} else {
+			text = body.Text
+
+			if body.Every != "" {
+				if every, err = reminders.ParseDuration(body.Every); err != nil {
+					return apperrors.NewValidationError("Invalid every: " + err.Error())
+				}
+				delay = every
+			}
+
+			if body.In != "" {
+				if delay, err = reminders.ParseDuration(body.In); err != nil {
+					return apperrors.NewValidationError("Invalid in: " + err.Error())
+				}
+			}
+
+			if delay == 0 {
+				return apperrors.NewValidationError("Either in or every is required")
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		reminder, err := rsrv.Create(ctx, username, text, delay, every)
+		if err != nil {
+			return err
+		}
+
+		return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+			"reminder": reminder,
+		})
+	}
+}
+
+// HandleRemindersList returns the current user's pending reminders
+func HandleRemindersList(rsrv *reminders.ReminderService) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		username, err := getUsernameFromContext(c)
+		if err != nil {
+			return handleUnauthorized(c)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		pending, err := rsrv.List(ctx, username)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(fiber.Map{
+			"reminders": pending,
+		})
+	}
+}
+
+// HandleReminderCancel deletes one of the current user's reminders
+func HandleReminderCancel(rsrv *reminders.ReminderService) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		username, err := getUsernameFromContext(c)
+		if err != nil {
+			return handleUnauthorized(c)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		if err := rsrv.Cancel(ctx, username, c.Params("id")); err != nil {
+			return err
+		}
+
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"context"
+	"exc6/apperrors"
+	"exc6/pkg/logger"
+	"exc6/services/search"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// HandleMessageSearch returns the messages of the current user's
+// conversations matching the query parameter q, with match offsets. The
+// optional parameter with limits the search to a contact or a group ID.
+func HandleMessageSearch(ssrv *search.Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		currentUser := c.Locals("username").(string)
+
+		params, err := pageParams(c)
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		page, err := ssrv.Search(ctx, currentUser, c.Query("q"), c.Query("with"), params)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(page)
+	}
+}
+
+// backfillFor returns the search index backfill, failing when it is not
+// enabled
+func backfillFor(ssrv *search.Service) (*search.Backfill, error) {
+	b := ssrv.Backfill()
+	if b == nil {
+		return nil, apperrors.New(apperrors.ErrCodeInternal, "The search backfill is not enabled", fiber.StatusServiceUnavailable)
+	}
+	return b, nil
+}
+
+// HandleSearchBackfillProgress reports how far the search index backfill got
+func HandleSearchBackfillProgress(ssrv *search.Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		b, err := backfillFor(ssrv)
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		progress, err := b.Progress(ctx)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(progress)
+	}
+}
+
+// HandleSearchBackfillStart starts or resumes the search index backfill on
+// this instance. "reset=true" starts over from the beginning of the topic.
+func HandleSearchBackfillStart(ssrv *search.Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		b, err := backfillFor(ssrv)
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		if err := b.Start(ctx, c.QueryBool("reset")); err != nil {
+			return err
+		}
+
+		logger.WithFields(map[string]any{
+			"admin": c.Locals("username"),
+			"reset": c.QueryBool("reset"),
+		}).Info("Search backfill started by admin")
+
+		return c.SendStatus(fiber.StatusAccepted)
+	}
+}
+
+// HandleSearchBackfillStop stops the search index backfill at its next
+// checkpoint, wherever it runs
+func HandleSearchBackfillStop(ssrv *search.Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		b, err := backfillFor(ssrv)
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		if err := b.Stop(ctx); err != nil {
+			return err
+		}
+
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"context"
+	"exc6/apperrors"
+	"exc6/pkg/logger"
+	"exc6/services/export"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// HandleShareCreate creates a read-only link to a range of the current
+// user's conversation with a contact. Form: from/to=YYYY-MM-DD (inclusive,
+// UTC), optional passcode and expires_in (e.g. "72h").
+func HandleShareCreate(esrv *export.ExportService) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		username, err := getUsernameFromContext(c)
+		if err != nil {
+			return handleUnauthorized(c)
+		}
+
+		rng, err := parseDateRange(c.FormValue("from"), c.FormValue("to"))
+		if err != nil {
+			return err
+		}
+
+		var expiry time.Duration
+		if v := c.FormValue("expires_in"); v != "" {
+			expiry, err = time.ParseDuration(v)
+			if err != nil || expiry <= 0 {
+				return apperrors.NewValidationError("expires_in must be a duration such as 72h")
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		link, err := esrv.CreateShareLink(ctx, username, export.ShareRequest{
+			Contact:  c.Params("contact"),
+			Range:    rng,
+			Passcode: c.FormValue("passcode"),
+			Expiry:   expiry,
+		})
+		if err != nil {
+			return err
+		}
+
+		return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+			"link": link,
+			"url":  c.BaseURL() + "/share/" + link.Token,
+		})
+	}
+}
+
+// HandleShareList lists the current user's live links to a conversation
+func HandleShareList(esrv *export.ExportService) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		username, err := getUsernameFromContext(c)
+		if err != nil {
+			return handleUnauthorized(c)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		links, err := esrv.ShareLinks(ctx, username, c.Params("contact"))
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(fiber.Map{"links": links})
+	}
+}
+
+// HandleShareRevoke disables one of the current user's links
+func HandleShareRevoke(esrv *export.ExportService) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		username, err := getUsernameFromContext(c)
+		if err != nil {
+			return handleUnauthorized(c)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		if err := esrv.RevokeShareLink(ctx, username, c.Params("id")); err != nil {
+			return err
+		}
+
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+// HandleSharePage serves the public page of a share link. A protected link
+// shows a passcode form, which is posted back to the same URL.
+func HandleSharePage(esrv *export.ExportService) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+		defer cancel()
+
+		passcode := ""
+		if c.Method() == fiber.MethodPost {
+			passcode = c.FormValue("passcode")
+		}
+
+		var page export.SharePage
+		status := fiber.StatusOK
+
+		transcript, err := esrv.OpenShareLink(ctx, c.Params("token"), passcode)
+		switch {
+		case err == nil:
+			page.Transcript = transcript
+		case err == export.ErrShareUnavailable:
+			page.Unavailable = true
+			status = fiber.StatusNotFound
+		case err == export.ErrSharePasscode:
+			page.WrongPasscode = passcode != ""
+			if page.WrongPasscode {
+				status = fiber.StatusUnauthorized
+			}
+		default:
+			return err
+		}
+
+		body, err := export.RenderSharePage(page)
+		if err != nil {
+			logger.WithError(err).Error("Failed to render share page")
+			return apperrors.NewInternalError("Failed to render page")
+		}
+
+		c.Set(fiber.HeaderContentType, fiber.MIMETextHTMLCharsetUTF8)
+		c.Set(fiber.HeaderCacheControl, "no-store")
+		c.Set("X-Robots-Tag", "noindex, nofollow")
+		c.Set(fiber.HeaderReferrerPolicy, "no-referrer")
+
+		return c.Status(status).Send(body)
+	}
+}
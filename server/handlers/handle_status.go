@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"context"
+	"exc6/services/status"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// statusCacheControl lets clients and shared caches keep the status page for
+// 30 seconds, so a crowd checking it during an incident reaches the instances
+// rarely
+const statusCacheControl = "public, max-age=30"
+
+// HandleStatus returns the public availability of chat, calls and uploads
+// with recent incidents, so users can tell whether a problem is on their end
+func HandleStatus(svc *status.Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+
+		c.Set(fiber.HeaderCacheControl, statusCacheControl)
+		return c.JSON(svc.Status(ctx))
+	}
+}
+
+// HandleIncidentCreate adds an incident to the status page from the form
+// fields title, an optional message and components, a comma-separated list
+// of affected components
+func HandleIncidentCreate(svc *status.Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		username := c.Locals("username").(string)
+
+		var components []string
+		for _, name := range strings.Split(c.FormValue("components"), ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				components = append(components, name)
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		incident, err := svc.CreateIncident(ctx, c.FormValue("title"), c.FormValue("message"), components, username)
+		if err != nil {
+			return err
+		}
+
+		return c.Status(fiber.StatusCreated).JSON(incident)
+	}
+}
+
+// HandleIncidentResolve marks an incident resolved
+func HandleIncidentResolve(svc *status.Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		incident, err := svc.ResolveIncident(ctx, c.Params("id"))
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(incident)
+	}
+}
+
+// HandleIncidentDelete removes an incident from the status page
+func HandleIncidentDelete(svc *status.Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		if err := svc.DeleteIncident(ctx, c.Params("id")); err != nil {
+			return err
+		}
+
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
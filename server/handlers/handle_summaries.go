@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"context"
+	"exc6/services/groups"
+	"exc6/services/summaries"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// HandleSummarize returns an AI-generated summary of the latest messages of
+// the conversation named by the route's id: a group ID, or else a contact.
+// Usernames are too short to be mistaken for group IDs.
+func HandleSummarize(ssrv *summaries.Service, gsrv *groups.GroupService) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		username, err := getUsernameFromContext(c)
+		if err != nil {
+			return handleUnauthorized(c)
+		}
+
+		// The provider may take a while to write the summary
+		ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+		defer cancel()
+
+		id := c.Params("id")
+		conv := summaries.Conversation{Contact: id}
+		if _, err := uuid.Parse(id); err == nil {
+			if _, err := gsrv.GetGroupMembers(ctx, id, username); err != nil {
+				return err
+			}
+			conv = summaries.Conversation{GroupID: id}
+		}
+
+		summary, err := ssrv.Summarize(ctx, username, conv)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(summary)
+	}
+}
@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"context"
+	"exc6/apperrors"
+	"exc6/services/users"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// userLookupRequest names the users to look up by username or ID
+type userLookupRequest struct {
+	Users []string `json:"users" form:"users"`
+}
+
+// UserCard is the public part of an account, enough to render an avatar
+type UserCard struct {
+	ID         string `json:"id"`
+	Username   string `json:"username"`
+	Icon       string `json:"icon"`
+	CustomIcon string `json:"custom_icon,omitempty"`
+}
+
+// userLookupResponse lists the cards found in request order. Keys that match
+// no user are listed in not_found; while the database is unavailable, keys
+// not in the cache are listed in unresolved instead and degraded is set.
+type userLookupResponse struct {
+	Users      []UserCard `json:"users"`
+	NotFound   []string   `json:"not_found"`
+	Unresolved []string   `json:"unresolved,omitempty"`
+	Degraded   bool       `json:"degraded,omitempty"`
+}
+
+// HandleUserLookup returns the public cards of up to users.MaxLookup users
+// named in one request, so a screen full of avatars costs one call
+func HandleUserLookup(usrv *users.UserService) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var body userLookupRequest
+		if err := c.BodyParser(&body); err != nil {
+			return apperrors.NewBadRequest("Invalid request body")
+		}
+
+		keys := make([]string, 0, len(body.Users))
+		seen := make(map[string]bool, len(body.Users))
+		for _, key := range body.Users {
+			key = strings.TrimSpace(key)
+			if key != "" && !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+		if len(keys) == 0 {
+			return apperrors.NewValidationError("At least one username or ID is required")
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		result, err := usrv.Lookup(ctx, keys)
+		if err != nil {
+			return err
+		}
+
+		response := userLookupResponse{
+			Users:    make([]UserCard, 0, len(result.Value)),
+			NotFound: []string{},
+			Degraded: result.Degraded,
+		}
+		for _, key := range keys {
+			user, ok := result.Value[key]
+			switch {
+			case ok:
+				response.Users = append(response.Users, UserCard{
+					ID:         user.ID.String(),
+					Username:   user.Username,
+					Icon:       user.Icon.String,
+					CustomIcon: user.CustomIcon.String,
+				})
+			case result.Degraded:
+				response.Unresolved = append(response.Unresolved, key)
+			default:
+				response.NotFound = append(response.NotFound, key)
+			}
+		}
+
+		return c.JSON(response)
+	}
+}
@@ -2,14 +2,14 @@ package handlers
 
 import (
 	"context"
-	"database/sql"
 	"exc6/apperrors"
-	"exc6/db"
 	"exc6/pkg/logger"
+	"exc6/pkg/timing"
+	"exc6/server/middleware/proxy"
 	"exc6/services/sessions"
+	"exc6/services/users"
 	"exc6/utils"
 	"math/rand"
-	"os"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
@@ -30,7 +30,7 @@ var defaultIcons = []string{
 	"solid-signal",
 }
 
-func HandleUserRegister(qdb *db.Queries) fiber.Handler {
+func HandleUserRegister(usrv *users.UserService) fiber.Handler {
 	return func(ctx *fiber.Ctx) error {
 		username := ctx.FormValue("username")
 		password := ctx.FormValue("password")
@@ -61,7 +61,7 @@ func HandleUserRegister(qdb *db.Queries) fiber.Handler {
 		defer cancel()
 
 		// Check if user exists
-		if _, err := qdb.GetUserByUsername(dbCtx, username); err == nil {
+		if exists, err := usrv.Exists(dbCtx, username); err == nil && exists {
 			err := apperrors.NewUserExists(username)
 			// FIX: Return proper status code
 			return ctx.Status(fiber.StatusConflict).Render("partials/register", fiber.Map{
@@ -78,12 +78,7 @@ func HandleUserRegister(qdb *db.Queries) fiber.Handler {
 
 		// Create user
 		randomIcon := defaultIcons[rand.Intn(len(defaultIcons))]
-		if _, err := qdb.CreateUser(dbCtx, db.CreateUserParams{
-			Username:     username,
-			PasswordHash: passwordHash,
-			Icon:         sql.NullString{String: randomIcon, Valid: true},
-			CustomIcon:   sql.NullString{String: "", Valid: true},
-		}); err != nil {
+		if _, err := usrv.Create(dbCtx, username, passwordHash, randomIcon); err != nil {
 			appErr := apperrors.FromError(err)
 			return ctx.Status(fiber.StatusInternalServerError).Render("partials/register", fiber.Map{
 				"Error": appErr.Message,
@@ -94,23 +89,22 @@ func HandleUserRegister(qdb *db.Queries) fiber.Handler {
 	}
 }
 
-func HandleUserLogin(qdb *db.Queries, smngr *sessions.SessionManager) fiber.Handler {
+func HandleUserLogin(usrv *users.UserService, smngr *sessions.SessionManager) fiber.Handler {
 	return func(ctx *fiber.Ctx) error {
 		username := ctx.FormValue("username")
 		password := ctx.FormValue("password")
 
-		dbCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		dbCtx, cancel := context.WithTimeout(ctx.UserContext(), 5*time.Second)
 		defer cancel()
 
-		user, err := qdb.GetUserByUsername(dbCtx, username)
+		stop := timing.Track(dbCtx, timing.StageService)
+		user, err := usrv.GetByUsername(dbCtx, username)
+		stop()
 		if err != nil {
-			if err == sql.ErrNoRows {
+			if users.IsNotFound(err) {
 				// User not found
-				appErr := apperrors.NewInvalidCredentials()
-				return ctx.Render("partials/login", fiber.Map{
-					"Error":    appErr.Message,
-					"Username": username,
-				})
+				logFailedLogin(ctx, username)
+				return renderLoginError(ctx, username)
 			}
 			// Other DB error
 			logger.WithFields(map[string]any{
@@ -121,13 +115,13 @@ func HandleUserLogin(qdb *db.Queries, smngr *sessions.SessionManager) fiber.Hand
 		}
 
 		// Verify password
-		if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
+		stop = timing.Track(dbCtx, timing.StageAuth)
+		err = bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password))
+		stop()
+		if err != nil {
 			// Invalid password
-			appErr := apperrors.NewInvalidCredentials()
-			return ctx.Render("partials/login", fiber.Map{
-				"Error":    appErr.Message,
-				"Username": username,
-			})
+			logFailedLogin(ctx, username)
+			return renderLoginError(ctx, username)
 		}
 
 		// Create session
@@ -141,10 +135,13 @@ func HandleUserLogin(qdb *db.Queries, smngr *sessions.SessionManager) fiber.Hand
 		)
 
 		// Save session with background context
-		sessCtx, sessCancel := context.WithTimeout(context.Background(), 3*time.Second)
+		sessCtx, sessCancel := context.WithTimeout(ctx.UserContext(), 3*time.Second)
 		defer sessCancel()
 
-		if err := smngr.SaveSession(sessCtx, newSession); err != nil {
+		stop = timing.Track(sessCtx, timing.StageSession)
+		err = smngr.SaveSession(sessCtx, newSession)
+		stop()
+		if err != nil {
 			logger.WithFields(map[string]any{
 				"username":   username,
 				"session_id": sessionID,
@@ -153,15 +150,20 @@ func HandleUserLogin(qdb *db.Queries, smngr *sessions.SessionManager) fiber.Hand
 			return apperrors.NewInternalError("Failed to create session")
 		}
 
+		logger.WithFields(map[string]any{
+			"username":   username,
+			"session_id": sessionID,
+			"ip":         proxy.ClientIP(ctx),
+		}).Info("User logged in")
+
 		// Set secure cookie
-		isSecure := os.Getenv("APP_ENV") != "development"
 		ctx.Cookie(&fiber.Cookie{
 			Name:     "session_id",
 			Value:    sessionID,
 			Expires:  time.Now().Add(24 * time.Hour),
 			HTTPOnly: true,
 			SameSite: "Lax",
-			Secure:   isSecure,
+			Secure:   proxy.SecureCookie(ctx),
 			Path:     "/",
 		})
 
@@ -171,6 +173,24 @@ func HandleUserLogin(qdb *db.Queries, smngr *sessions.SessionManager) fiber.Hand
 	}
 }
 
+// renderLoginError shows the login form again with the credentials error
+func renderLoginError(ctx *fiber.Ctx, username string) error {
+	defer timing.Track(ctx.UserContext(), timing.StageRender)()
+
+	return ctx.Render("partials/login", fiber.Map{
+		"Error":    apperrors.NewInvalidCredentials().Message,
+		"Username": username,
+	})
+}
+
+// logFailedLogin records a rejected login with the client's address
+func logFailedLogin(ctx *fiber.Ctx, username string) {
+	logger.WithFields(map[string]any{
+		"username": username,
+		"ip":       proxy.ClientIP(ctx),
+	}).Warn("Login failed")
+}
+
 func HandleUserLogout(smngr *sessions.SessionManager) fiber.Handler {
 	return func(ctx *fiber.Ctx) error {
 		sessionID := ctx.Cookies("session_id")
@@ -4,23 +4,42 @@ import (
 	"context"
 	"encoding/json"
 	"exc6/apperrors"
-	"exc6/db"
+	"exc6/pkg/instance"
 	"exc6/pkg/logger"
+	"exc6/pkg/pagination"
 	_websocket "exc6/server/websocket"
 	"exc6/services/calls"
 	"exc6/services/chat"
 	"exc6/services/groups"
+	"exc6/services/notifications"
+	"exc6/services/users"
 	"os"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/gofiber/contrib/websocket"
 	"github.com/gofiber/fiber/v2"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/redis/go-redis/v9"
 )
 
+// relayPayloads counts chat payloads received by WebSocket relays by outcome.
+// A high "filtered" share means connections receive traffic they don't need.
+var relayPayloads = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "ws_relay_payloads_total",
+		Help: "Chat payloads received from Redis by WebSocket relays",
+	},
+	[]string{"result"}, // result: delivered, filtered, dropped
+)
+
+func init() {
+	instance.Registerer().MustRegister(relayPayloads)
+}
+
 // HandleWebSocketUpgrade upgrades HTTP connection to WebSocket
-func HandleWebSocketUpgrade(wsManager *_websocket.Manager, csrv *chat.ChatService, callService *calls.CallService, gsrv *groups.GroupService, qdb *db.Queries) fiber.Handler {
+func HandleWebSocketUpgrade(wsManager *_websocket.Manager, csrv chat.Service, callService *calls.CallService, gsrv *groups.GroupService) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		if websocket.IsWebSocketUpgrade(c) {
 			// Pre-check origin here as well for early rejection
@@ -75,7 +94,7 @@ func isAllowedOrigin(origin string) bool {
 }
 
 // HandleWebSocket handles WebSocket connections for chat and calls
-func HandleWebSocket(wsManager *_websocket.Manager, csrv *chat.ChatService, callService *calls.CallService, gsrv *groups.GroupService, qdb *db.Queries) fiber.Handler {
+func HandleWebSocket(wsManager *_websocket.Manager, csrv chat.Service, callService *calls.CallService, gsrv *groups.GroupService, usrv *users.UserService, mutes *notifications.Service) fiber.Handler {
 	// Configure WebSocket with strict Origin validation inside the Upgrader
 	cfg := websocket.Config{
 		Origins: []string{"*"}, // We handle custom validation logic below or use specific list
@@ -93,109 +112,141 @@ func HandleWebSocket(wsManager *_websocket.Manager, csrv *chat.ChatService, call
 		// Create client
 		client := _websocket.NewClient(username, conn, wsManager)
 
+		// Clients on poor connections negotiate the low-bandwidth protocol
+		client.Lite = conn.Query("mode") == "lite"
+
 		// Register client
 		wsManager.Register <- client
+		client.SendCapabilities()
 
 		// Fetch user's groups to filter incoming messages
 		ctxGroups, cancelGroups := context.WithTimeout(context.Background(), 5*time.Second)
 		userGroups, err := gsrv.GetUserGroups(ctxGroups, username)
 		cancelGroups()
 
-		allowedGroups := make(map[string]bool)
+		groupIDs := make([]string, 0, len(userGroups))
 		if err == nil {
 			for _, g := range userGroups {
-				allowedGroups[g.ID] = true
+				groupIDs = append(groupIDs, g.ID)
 			}
 		} else {
 			logger.WithError(err).Warn("Failed to fetch user groups for WebSocket")
 		}
 
-		// Subscribe to Redis Pub/Sub for chat messages
+		// Subscribe only to this user's conversations: their own channel plus one per group
 		ctx, cancel := context.WithCancel(context.Background())
 		defer cancel()
 
-		pubsub := csrv.SubscribeToMessages(ctx)
-		defer pubsub.Close()
+		pubsub := csrv.SubscribeToConversations(ctx, username, groupIDs)
+		if pubsub != nil {
+			defer pubsub.Close()
 
-		// Start message relay from Redis to WebSocket
-		go relayRedisToWebSocket(ctx, client, pubsub, username, allowedGroups, qdb)
+			// Membership changes keep the group set current without a reconnect
+			if err := pubsub.Subscribe(ctx, groups.MembershipChannel(username)); err != nil {
+				logger.WithError(err).Warn("Failed to subscribe to group membership events")
+			}
+			memberships := newGroupSubscription(username, pubsub, groupIDs)
+
+			// Start message relay from Redis to WebSocket
+			go relayRedisToWebSocket(ctx, client, pubsub, username, memberships, csrv, gsrv, usrv, mutes)
+		} else {
+			logger.WithField("username", username).Warn("WebSocket connected without live chat relay")
+		}
 
 		// Start read and write pumps
 		go client.WritePump()
 		client.ReadPump() // Blocks until connection closes
 
-		logger.WithField("username", username).Info("WebSocket connection closed")
+		logger.WithFields(map[string]interface{}{
+			"username": username,
+			"lite":     client.Lite,
+		}).Info("WebSocket connection closed")
 	}, cfg)
 }
 
 // relayRedisToWebSocket relays messages from Redis Pub/Sub to WebSocket
-func relayRedisToWebSocket(ctx context.Context, client *_websocket.Client, pubsub *redis.PubSub, username string, allowedGroups map[string]bool, qdb *db.Queries) {
+func relayRedisToWebSocket(ctx context.Context, client *_websocket.Client, pubsub *redis.PubSub, username string, memberships *groupSubscription, csrv chat.Service, gsrv *groups.GroupService, usrv *users.UserService, mutes *notifications.Service) {
 	ch := pubsub.Channel()
 
+	var replayed resumeReplay
+
+	// order follows the sequence numbers of the messages sent
+	order := chat.NewSequenceWatch()
+
+	reconcileTicker := time.NewTicker(membershipReconcileInterval)
+	defer reconcileTicker.Stop()
+
+	membershipChannel := groups.MembershipChannel(username)
+
 	for {
 		select {
+		case <-reconcileTicker.C:
+			memberships.reconcile(ctx, gsrv)
+			replayed.forget(time.Now())
+
+		case points := <-client.Resumes():
+			// Live messages wait in the subscription meanwhile
+			replayed.add(replayMissed(ctx, client, csrv, username, points, memberships, order, usrv, mutes), time.Now())
+
 		case msg, ok := <-ch:
 			if !ok {
 				return
 			}
 
+			if msg.Channel == membershipChannel {
+				memberships.handleEvent(ctx, msg.Payload)
+				continue
+			}
+
 			var chatMsg chat.ChatMessage
 			if err := json.Unmarshal([]byte(msg.Payload), &chatMsg); err != nil {
 				logger.WithError(err).Warn("Failed to unmarshal chat message")
 				continue
 			}
 
-			// Filter messages for this user
+			// Channels are already scoped to this user; the check below is a
+			// safety net and should practically never filter anything
 			// 1. Direct messages where user is sender or recipient
 			// 2. Group messages where user is a member of the group
 			isRelevant := (chatMsg.FromID == username || chatMsg.ToID == username) ||
-				(chatMsg.IsGroup && allowedGroups[chatMsg.GroupID])
+				(chatMsg.IsGroup && memberships.has(chatMsg.GroupID))
 
 			if !isRelevant {
+				relayPayloads.WithLabelValues("filtered").Inc()
 				continue
 			}
 
-			// Convert to WebSocket message
-			wsMsg := &_websocket.Message{
-				Type:      _websocket.MessageTypeChat,
-				ID:        chatMsg.MessageID,
-				From:      chatMsg.FromID,
-				To:        chatMsg.ToID,
-				GroupID:   chatMsg.GroupID,
-				Content:   chatMsg.Content,
-				Timestamp: chatMsg.Timestamp,
+			// Typists do not need their own indicator
+			if chatMsg.Event == chat.EventTyping && chatMsg.FromID == username {
+				relayPayloads.WithLabelValues("filtered").Inc()
+				continue
 			}
 
-			if chatMsg.IsGroup {
-				wsMsg.Type = _websocket.MessageTypeGroupChat
-
-				// Enrich group message with sender info (icon) for the frontend
-				if chatMsg.FromID != username {
-					fetchCtx, fetchCancel := context.WithTimeout(ctx, 2*time.Second)
-					sender, err := qdb.GetUserByUsername(fetchCtx, chatMsg.FromID)
-					fetchCancel()
-
-					if err == nil {
-						data := map[string]interface{}{
-							"icon":        "",
-							"custom_icon": "",
-						}
-						if sender.Icon.Valid {
-							data["icon"] = sender.Icon.String
-						}
-						if sender.CustomIcon.Valid {
-							data["custom_icon"] = sender.CustomIcon.String
-						}
-						wsMsg.Data = data
-					}
+			if chatMsg.Event != "" {
+				if err := client.SendMessage(messageEvent(&chatMsg)); err != nil {
+					relayPayloads.WithLabelValues("dropped").Inc()
+					logger.WithError(err).Warn("Failed to send message event to WebSocket client")
+					return
 				}
+				relayPayloads.WithLabelValues("delivered").Inc()
+				continue
 			}
 
+			// A message replayed on resume is not sent again
+			if replayed.has(chatMsg.MessageID) || order.Observe(&chatMsg) {
+				relayPayloads.WithLabelValues("filtered").Inc()
+				continue
+			}
+
+			wsMsg := chatToWebSocket(ctx, &chatMsg, client, username, usrv, mutes)
+
 			// Send to client
 			if err := client.SendMessage(wsMsg); err != nil {
+				relayPayloads.WithLabelValues("dropped").Inc()
 				logger.WithError(err).Warn("Failed to send message to WebSocket client")
 				return
 			}
+			relayPayloads.WithLabelValues("delivered").Inc()
 
 		case <-ctx.Done():
 			return
@@ -203,8 +254,124 @@ func relayRedisToWebSocket(ctx context.Context, client *_websocket.Client, pubsu
 	}
 }
 
-// HandleCallInitiate initiates a voice call
-func HandleCallInitiate(callService *calls.CallService, wsManager *_websocket.Manager) fiber.Handler {
+// chatToWebSocket converts a chat message to the WebSocket message shown to
+// username
+func chatToWebSocket(ctx context.Context, chatMsg *chat.ChatMessage, client *_websocket.Client, username string, usrv *users.UserService, mutes *notifications.Service) *_websocket.Message {
+	wsMsg := &_websocket.Message{
+		Type:      _websocket.MessageTypeChat,
+		ID:        chatMsg.MessageID,
+		From:      chatMsg.FromID,
+		To:        chatMsg.ToID,
+		GroupID:   chatMsg.GroupID,
+		Content:   chatMsg.Content,
+		Timestamp: chatMsg.Timestamp,
+	}
+
+	if chatMsg.IsGroup {
+		wsMsg.Type = _websocket.MessageTypeGroupChat
+
+		// Enrich group message with sender info (icon) for the frontend;
+		// lite clients render initials instead
+		if chatMsg.FromID != username && !client.IsLite() {
+			fetchCtx, fetchCancel := context.WithTimeout(ctx, 2*time.Second)
+			sender, err := usrv.GetByUsername(fetchCtx, chatMsg.FromID)
+			fetchCancel()
+
+			if err == nil {
+				data := map[string]interface{}{
+					"icon":        "",
+					"custom_icon": "",
+				}
+				if sender.Icon.Valid {
+					data["icon"] = sender.Icon.String
+				}
+				if sender.CustomIcon.Valid {
+					data["custom_icon"] = sender.CustomIcon.String
+				}
+				wsMsg.Data = data
+			}
+		}
+	}
+
+	if chatMsg.Subtype != chat.SubtypeText {
+		if wsMsg.Data == nil {
+			wsMsg.Data = make(map[string]any)
+		}
+		wsMsg.Data["subtype"] = chatMsg.Subtype
+	}
+
+	if len(chatMsg.Mentions) > 0 {
+		if wsMsg.Data == nil {
+			wsMsg.Data = make(map[string]any)
+		}
+		wsMsg.Data["mentions"] = chatMsg.Mentions
+	}
+
+	// Clients order and deduplicate messages by it
+	if chatMsg.Seq > 0 {
+		if wsMsg.Data == nil {
+			wsMsg.Data = make(map[string]any)
+		}
+		wsMsg.Data["seq"] = chatMsg.Seq
+	}
+
+	// Messages in muted conversations are shown without notifying,
+	// unless they mention the user
+	if chatMsg.FromID != username && !slices.Contains(chatMsg.Mentions, username) &&
+		mutes.Muted(ctx, username, chatMsg.FromID, chatMsg.GroupID) {
+		if wsMsg.Data == nil {
+			wsMsg.Data = make(map[string]any)
+		}
+		wsMsg.Data["muted"] = true
+	}
+
+	return wsMsg
+}
+
+// messageEvent converts an edit, delete, receipt, reaction or pin event to the
+// WebSocket message that updates the client's copy in place, and a typing
+// event to the message that shows the indicator
+func messageEvent(event *chat.ChatMessage) *_websocket.Message {
+	wsMsg := &_websocket.Message{
+		Type:      _websocket.MessageTypeEdit,
+		ID:        event.MessageID,
+		From:      event.FromID,
+		To:        event.ToID,
+		GroupID:   event.GroupID,
+		Content:   event.Content,
+		Timestamp: event.Timestamp,
+		Data:      map[string]any{"edited_at": event.EditedAt},
+	}
+	switch event.Event {
+	case chat.EventDelete:
+		wsMsg.Type = _websocket.MessageTypeDelete
+		wsMsg.Content = ""
+		wsMsg.Data = nil
+	case chat.EventDelivered:
+		wsMsg.Type = _websocket.MessageTypeDelivered
+		wsMsg.Data = map[string]any{"delivered_at": event.DeliveredAt}
+	case chat.EventRead:
+		wsMsg.Type = _websocket.MessageTypeRead
+		wsMsg.Data = map[string]any{"read_at": event.ReadAt}
+	case chat.EventTyping:
+		wsMsg.Type = _websocket.MessageTypeTyping
+		wsMsg.Data = map[string]any{"expires_in": int(chat.TypingDisplay / time.Second)}
+	case chat.EventReactionAdded, chat.EventReactionRemoved:
+		wsMsg.Type = _websocket.MessageTypeReaction
+		wsMsg.Data = map[string]any{
+			"added":     event.Event == chat.EventReactionAdded,
+			"reactions": event.Reactions,
+		}
+	case chat.EventPinned, chat.EventUnpinned:
+		wsMsg.Type = _websocket.MessageTypePin
+		wsMsg.Data = map[string]any{"pinned": event.Event == chat.EventPinned}
+	}
+	return wsMsg
+}
+
+// HandleCallInitiate initiates a call of the "media" form field, audio by
+// default, offering the caller's constraints
+func HandleCallInitiate(callService *calls.CallService, wsManager *_websocket.Manager, inbox *notifications.NotificationService) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		caller, err := getUsernameFromContext(c)
 		if err != nil {
@@ -220,6 +387,15 @@ func HandleCallInitiate(callService *calls.CallService, wsManager *_websocket.Ma
 			return apperrors.NewBadRequest("Cannot call yourself")
 		}
 
+		media, err := calls.ParseMediaType(c.FormValue("media"))
+		if err != nil {
+			return apperrors.NewValidationError("Invalid media type")
+		}
+		offered, err := mediaConstraints(c)
+		if err != nil {
+			return err
+		}
+
 		// Check if callee is online
 		if !wsManager.IsUserOnline(callee) {
 			return apperrors.NewBadRequest("User is offline")
@@ -233,8 +409,18 @@ func HandleCallInitiate(callService *calls.CallService, wsManager *_websocket.Ma
 			return apperrors.NewBadRequest("User is already in a call")
 		}
 
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		if roomID, _ := callService.RoomOf(ctx, caller); roomID != "" {
+			return apperrors.NewBadRequest("You are already in a call")
+		}
+		if roomID, _ := callService.RoomOf(ctx, callee); roomID != "" {
+			return apperrors.NewBadRequest("User is already in a call")
+		}
+
 		// Initiate call
-		call, err := callService.InitiateCall(caller, callee)
+		call, err := callService.InitiateMediaCall(caller, callee, media, offered)
 		if err != nil {
 			return apperrors.NewInternalError("Failed to initiate call").WithInternal(err)
 		}
@@ -242,14 +428,22 @@ func HandleCallInitiate(callService *calls.CallService, wsManager *_websocket.Ma
 		// Update call state to ringing
 		callService.UpdateCallState(call.ID, calls.CallStateRinging)
 
-		return c.JSON(fiber.Map{
+		inbox.Notify(ctx, callee, notifications.TypeCall, caller, "Incoming "+media.Noun(), map[string]string{
 			"call_id": call.ID,
-			"status":  "ringing",
+			"media":   string(media),
+		})
+
+		return c.JSON(fiber.Map{
+			"call_id":     call.ID,
+			"status":      "ringing",
+			"media":       call.Media,
+			"constraints": call.Constraints,
 		})
 	}
 }
 
-// HandleCallAnswer answers an incoming call
+// HandleCallAnswer answers an incoming call, offering the callee's
+// constraints; both participants then keep to the negotiated ones
 func HandleCallAnswer(callService *calls.CallService, wsManager *_websocket.Manager) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		username, err := getUsernameFromContext(c)
@@ -262,13 +456,17 @@ func HandleCallAnswer(callService *calls.CallService, wsManager *_websocket.Mana
 			return apperrors.NewBadRequest("Call ID required")
 		}
 
+		answered, err := mediaConstraints(c)
+		if err != nil {
+			return err
+		}
+
 		// Answer the call
-		if err := callService.AnswerCall(callID, username); err != nil {
+		call, err := callService.AnswerMediaCall(callID, username, answered)
+		if err != nil {
 			return apperrors.NewBadRequest(err.Error())
 		}
 
-		call, _ := callService.GetCall(callID)
-
 		// Notify caller that call was answered
 		answerMsg := &_websocket.Message{
 			Type: _websocket.MessageTypeCallAnswer,
@@ -276,8 +474,10 @@ func HandleCallAnswer(callService *calls.CallService, wsManager *_websocket.Mana
 			From: username,
 			To:   call.Caller,
 			Data: map[string]interface{}{
-				"call_id":  callID,
-				"accepted": true,
+				"call_id":     callID,
+				"accepted":    true,
+				"media":       call.Media,
+				"constraints": call.Constraints,
 			},
 			Timestamp: time.Now().Unix(),
 		}
@@ -285,8 +485,10 @@ func HandleCallAnswer(callService *calls.CallService, wsManager *_websocket.Mana
 		wsManager.SendToUser(call.Caller, answerMsg)
 
 		return c.JSON(fiber.Map{
-			"call_id": callID,
-			"status":  "active",
+			"call_id":     callID,
+			"status":      "active",
+			"media":       call.Media,
+			"constraints": call.Constraints,
 		})
 	}
 }
@@ -398,18 +600,23 @@ func HandleCallHistory(callService *calls.CallService) fiber.Handler {
 			return handleUnauthorized(c)
 		}
 
-		limit := c.QueryInt("limit", 20)
-		if limit > 100 {
-			limit = 100
+		params, err := pageParams(c)
+		if err != nil {
+			return err
 		}
 
-		history, err := callService.GetCallHistory(username, limit)
+		// History is capped at calls.HistorySize, so it is paged in memory
+		history, err := callService.GetCallHistory(username, calls.HistorySize)
 		if err != nil {
 			return apperrors.NewInternalError("Failed to retrieve call history").WithInternal(err)
 		}
 
+		page := pagination.Apply(history, params, pagination.Descending, (*calls.Call).Cursor)
+
 		return c.JSON(fiber.Map{
-			"calls": history,
+			"calls":       page.Items,
+			"next_cursor": page.NextCursor,
+			"has_more":    page.HasMore,
 		})
 	}
 }
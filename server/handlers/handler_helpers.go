@@ -2,15 +2,28 @@ package handlers
 
 import (
 	"exc6/db"
+	"exc6/pkg/pagination"
 
 	"github.com/gofiber/fiber/v2"
 )
 
+// HeaderDegraded marks responses built from fallback data while a backend is
+// unavailable
+const HeaderDegraded = "X-Degraded"
+
 // isHTMXRequest checks if the request is from HTMX
 func isHTMXRequest(c *fiber.Ctx) bool {
 	return c.Get("HX-Request") == "true"
 }
 
+// impersonating reports whether an admin is using the session as the user.
+// Such requests must not leave traces the user would notice, like read
+// receipts.
+func impersonating(c *fiber.Ctx) bool {
+	admin, _ := c.Locals("impersonator").(string)
+	return admin != ""
+}
+
 // getUsernameFromContext safely extracts username from context locals
 func getUsernameFromContext(c *fiber.Ctx) (string, error) {
 	val := c.Locals("username")
@@ -26,6 +39,11 @@ func getUsernameFromContext(c *fiber.Ctx) (string, error) {
 	return username, nil
 }
 
+// pageParams reads the limit and cursor query parameters of a list request
+func pageParams(c *fiber.Ctx) (pagination.Params, error) {
+	return pagination.Parse(c.Query("limit"), c.Query("cursor"))
+}
+
 // handleUnauthorized redirects to login for unauthorized requests
 func handleUnauthorized(c *fiber.Ctx) error {
 	if isHTMXRequest(c) {
@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"exc6/apperrors"
+	"exc6/server/sse"
+	"exc6/services/moderation"
+	"exc6/services/notifications"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Notification types delivered on /sse/notifications
+const (
+	NotificationFriendRequest = notifications.TypeFriendRequest
+	NotificationFriendAccept  = notifications.TypeFriendAccept
+	NotificationMention       = notifications.TypeMention
+	NotificationCall          = notifications.TypeCall
+	NotificationMissedCall    = notifications.TypeMissedCall
+
+	// Support staff ended a session they opened as the subscriber
+	NotificationImpersonation = notifications.TypeImpersonation
+
+	// A message the subscriber received was edited or deleted by its sender
+	NotificationMessageEdit   = "message_edit"
+	NotificationMessageDelete = "message_delete"
+
+	// The subscriber was invited to a group, or someone they invited joined
+	NotificationGroupInvite = notifications.TypeGroupInvite
+	NotificationGroupJoin   = notifications.TypeGroupJoin
+)
+
+// notificationTypes are the types a client may subscribe to
+var notificationTypes = map[string]bool{
+	NotificationFriendRequest: true,
+	NotificationFriendAccept:  true,
+	NotificationMention:       true,
+	NotificationCall:          true,
+	NotificationMissedCall:    true,
+	NotificationMessageEdit:   true,
+	NotificationMessageDelete: true,
+	NotificationGroupInvite:   true,
+	NotificationGroupJoin:     true,
+	NotificationImpersonation: true,
+}
+
+// notification is the data of a notification event
+type notification struct {
+	From      string            `json:"from"`
+	Content   string            `json:"content"`
+	Timestamp int64             `json:"timestamp"`
+	Data      map[string]string `json:"data,omitempty"`
+	Priority  string            `json:"priority,omitempty"`
+}
+
+// publishNotification sends a notification to the user's stream without
+// waiting for Redis
+func publishNotification(broker *sse.Broker, to, notificationType, from, content string, data map[string]string) {
+	broker.PublishAsync(to, notificationType, notification{
+		From:      from,
+		Content:   content,
+		Timestamp: time.Now().Unix(),
+		Data:      data,
+	})
+}
+
+// parseNotificationTypes parses the comma-separated types filter. An empty
+// filter subscribes to every type.
+func parseNotificationTypes(raw string) (map[string]bool, error) {
+	types := make(map[string]bool)
+	var unknown []string
+
+	for _, t := range strings.Split(raw, ",") {
+		t = strings.TrimSpace(t)
+		if t == "" {
+			continue
+		}
+		if !notificationTypes[t] {
+			unknown = append(unknown, t)
+			continue
+		}
+		types[t] = true
+	}
+
+	if len(unknown) > 0 {
+		valid := make([]string, 0, len(notificationTypes))
+		for t := range notificationTypes {
+			valid = append(valid, t)
+		}
+		sort.Strings(valid)
+		return nil, apperrors.NewValidationError("Unknown notification type: "+strings.Join(unknown, ", ")).
+			WithDetails("valid_types", valid)
+	}
+
+	if len(types) == 0 {
+		return notificationTypes, nil
+	}
+	return types, nil
+}
+
+// NotificationArchive replays notifications missing from a user's stream log
+// from their inbox. Live-only notifications, such as incoming calls, are not
+// kept there and cannot be replayed.
+func NotificationArchive(inbox *notifications.NotificationService) sse.Archive {
+	return notificationArchive{inbox: inbox}
+}
+
+type notificationArchive struct {
+	inbox *notifications.NotificationService
+}
+
+func (a notificationArchive) Replay(ctx context.Context, username string, after, before time.Time, limit int) ([]sse.ArchivedEvent, error) {
+	entries, err := a.inbox.Between(ctx, username, after, before, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]sse.ArchivedEvent, len(entries))
+	for i, n := range entries {
+		events[i] = sse.ArchivedEvent{Type: n.Type, Data: n, RecordedAt: n.CreatedAt()}
+	}
+	return events, nil
+}
+
+// HandleNotificationStream streams the user's notifications as Server-Sent
+// Events, optionally limited with ?types=friend_request,mention,call.
+// Notifications from users the subscriber reported are left out, as are those
+// about conversations they muted unless high priority, such as mentions. Clients
+// resume with the Last-Event-ID header, or last_event_id for EventSource
+// polyfills that cannot set headers; what the stream's log no longer holds
+// is replayed from the inbox.
+func HandleNotificationStream(broker *sse.Broker, policy *moderation.Policy, mutes *notifications.Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		username, err := getUsernameFromContext(c)
+		if err != nil {
+			return handleUnauthorized(c)
+		}
+
+		types, err := parseNotificationTypes(c.Query("types"))
+		if err != nil {
+			return err
+		}
+
+		filter := func(ev sse.Event) bool {
+			if !types[ev.Type] {
+				return false
+			}
+
+			var n notification
+			if err := json.Unmarshal(ev.Data, &n); err != nil {
+				return false
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+
+			if policy.Muted(ctx, username, n.From) {
+				return false
+			}
+			return n.Priority == notifications.PriorityHigh || !mutes.Muted(ctx, username, n.From, n.Data["group_id"])
+		}
+
+		if err := broker.Serve(c, username, filter); err != nil {
+			return apperrors.NewInternalError("Failed to open notification stream").WithInternal(err)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseNotificationTypes(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		want    []string
+		wantErr bool
+	}{
+		{name: "Empty means all", raw: "", want: []string{NotificationFriendRequest, NotificationFriendAccept, NotificationMention, NotificationCall, NotificationMissedCall, NotificationMessageEdit, NotificationMessageDelete, NotificationGroupInvite, NotificationGroupJoin, NotificationImpersonation}},
+		{name: "Subset", raw: "friend_request,call", want: []string{NotificationFriendRequest, NotificationCall}},
+		{name: "Whitespace and blanks", raw: " mention , ,call", want: []string{NotificationMention, NotificationCall}},
+		{name: "Unknown type", raw: "mention,chat", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			types, err := parseNotificationTypes(tt.raw)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			assert.Len(t, types, len(tt.want))
+			for _, want := range tt.want {
+				assert.True(t, types[want], want)
+			}
+		})
+	}
+}
@@ -2,8 +2,16 @@ package handlers
 
 import (
 	"context"
+	"errors"
+	"exc6/apperrors"
+	"exc6/config"
 	"exc6/db"
+	"exc6/pkg/logger"
+	"exc6/services/activity"
+	"exc6/services/profiles"
 	"exc6/services/sessions"
+	"exc6/services/uploads"
+	"exc6/services/users"
 	"exc6/utils"
 	"os"
 	"time"
@@ -12,14 +20,14 @@ import (
 )
 
 // HandleUserProfileUpdate handles profile updates with secure file uploads
-func HandleUserProfileUpdate(qdb *db.Queries, smngr *sessions.SessionManager) fiber.Handler {
+func HandleUserProfileUpdate(smngr *sessions.SessionManager, usrv *users.UserService, store *uploads.Store, tracker *activity.Tracker) fiber.Handler {
 	return func(ctx *fiber.Ctx) error {
 		oldUsername := ctx.Locals("username").(string)
 
 		dbCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
 
-		user, err := qdb.GetUserByUsername(dbCtx, oldUsername)
+		user, err := usrv.GetByUsername(dbCtx, oldUsername)
 		if err != nil {
 			return renderProfileEditError(ctx, &db.User{}, "User not found")
 		}
@@ -28,9 +36,18 @@ func HandleUserProfileUpdate(qdb *db.Queries, smngr *sessions.SessionManager) fi
 		newUsername := ctx.FormValue("username")
 		selectedIcon := ctx.FormValue("icon")
 
+		// The icon replaced, released once the profile no longer refers to
+		// it, and the object stored for its replacement
+		var replacedIcon string
+		var stored *uploads.Object
+
 		// Handle custom icon upload
 		file, err := ctx.FormFile("custom_icon")
 		if err == nil && file != nil {
+			if store == nil {
+				return renderProfileEditError(ctx, &user, apperrors.NewFeatureDisabled(config.FeatureUploads).Message)
+			}
+
 			// Validate the upload
 			valRes, err := ValidateImageUploadStrict(file)
 			if err != nil {
@@ -41,45 +58,33 @@ func HandleUserProfileUpdate(qdb *db.Queries, smngr *sessions.SessionManager) fi
 				return renderProfileEditError(ctx, &user, "Invalid file upload")
 			}
 
-			// Generate secure filename
-			filename, err := GenerateSecureFilename(user.ID.String(), file.Filename)
+			// Identical images are stored once and shared
+			obj, err := storeUpload(dbCtx, store, user.ID, file, valRes.DetectedMIME)
 			if err != nil {
-				return renderProfileEditError(ctx, &user, "Failed to generate filename")
-			}
-
-			// Get safe upload path
-			uploadDir := "./server/uploads/icons"
-			safePath := GetSafeUploadPath(uploadDir, filename)
-
-			// Ensure directory exists
-			if err := os.MkdirAll(uploadDir, 0755); err != nil {
-				return renderProfileEditError(ctx, &user, "Failed to create upload directory")
-			}
-
-			// Save file
-			if err := ctx.SaveFile(file, safePath); err != nil {
+				var appErr *apperrors.AppError
+				if errors.As(err, &appErr) && appErr.Code == apperrors.ErrCodeUploadQuota {
+					return renderProfileEditError(ctx, &user, appErr.Message)
+				}
 				return renderProfileEditError(ctx, &user, "Failed to upload file")
 			}
+			stored = obj
 
-			// Delete old custom icon if exists
 			if user.CustomIcon.Valid && user.CustomIcon.String != "" {
-				oldPath := "./server" + user.CustomIcon.String
-				os.Remove(oldPath)
+				replacedIcon = user.CustomIcon.String
 			}
 
 			// Update user profile
 			user.CustomIcon.Valid = true
-			user.CustomIcon.String = "/uploads/icons/" + filename
+			user.CustomIcon.String = obj.URL
 			user.Icon.Valid = false
 			user.Icon.String = "" // Clear default icon when custom is set
 		} else if selectedIcon != "" {
 			// User selected a default icon
 			user.Icon.String = selectedIcon
 
-			// Delete old custom icon if switching to default
+			// Drop the custom icon if switching to default
 			if user.CustomIcon.Valid && user.CustomIcon.String != "" {
-				oldPath := "./server" + user.CustomIcon.String
-				os.Remove(oldPath)
+				replacedIcon = user.CustomIcon.String
 				user.CustomIcon.String = ""
 			}
 		}
@@ -92,6 +97,20 @@ func HandleUserProfileUpdate(qdb *db.Queries, smngr *sessions.SessionManager) fi
 			user.Username = newUsername
 		}
 
+		updated, err := usrv.UpdateProfile(dbCtx, oldUsername, user)
+		if err != nil {
+			// The old icon is still in use; the new one is not
+			if stored != nil {
+				releaseIcon(dbCtx, store, stored.URL)
+			}
+			return renderProfileEditError(ctx, &user, "Failed to save profile")
+		}
+		user = updated
+
+		if replacedIcon != "" {
+			releaseIcon(dbCtx, store, replacedIcon)
+		}
+
 		// Update session with new username
 		sessionID := ctx.Cookies("session_id")
 		if sessionID != "" {
@@ -118,12 +137,8 @@ func HandleUserProfileUpdate(qdb *db.Queries, smngr *sessions.SessionManager) fi
 			customIconValue = user.CustomIcon.String
 		}
 
-		qdb.UpdateUser(dbCtx, db.UpdateUserParams{
-			ID:         user.ID,
-			Username:   user.Username,
-			Icon:       user.Icon,
-			CustomIcon: user.CustomIcon,
-		})
+		// Contact lists showing this user must be reloaded in full
+		tracker.TouchProfiles(dbCtx)
 
 		// Render success
 		return ctx.Render("partials/profile-edit", fiber.Map{
@@ -137,8 +152,25 @@ func HandleUserProfileUpdate(qdb *db.Queries, smngr *sessions.SessionManager) fi
 	}
 }
 
+// releaseIcon drops the user's reference to a shared icon. Icons saved before
+// the upload store belong to the user alone and are deleted.
+func releaseIcon(ctx context.Context, store *uploads.Store, url string) {
+	if uploads.IsObjectURL(url) {
+		if store == nil {
+			return
+		}
+		if err := store.Release(ctx, url); err != nil {
+			// The upload collector corrects the reference count
+			logger.WithError(err).Warn("Failed to release custom icon")
+		}
+		return
+	}
+
+	os.Remove("./server" + url)
+}
+
 // HandleProfileView renders the user's profile page
-func HandleProfileView(qdb *db.Queries) fiber.Handler {
+func HandleProfileView(usrv *users.UserService) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		username, err := getUsernameFromContext(c)
 		if err != nil {
@@ -148,7 +180,7 @@ func HandleProfileView(qdb *db.Queries) fiber.Handler {
 		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 		defer cancel()
 
-		user, err := qdb.GetUserByUsername(ctx, username)
+		user, err := usrv.GetByUsername(ctx, username)
 		if err != nil {
 			return c.Status(fiber.StatusNotFound).SendString("User not found")
 		}
@@ -187,7 +219,7 @@ func HandleProfileView(qdb *db.Queries) fiber.Handler {
 }
 
 // HandleProfileEdit renders the profile edit form
-func HandleProfileEdit(qdb *db.Queries) fiber.Handler {
+func HandleProfileEdit(usrv *users.UserService) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		username, err := getUsernameFromContext(c)
 		if err != nil {
@@ -197,7 +229,7 @@ func HandleProfileEdit(qdb *db.Queries) fiber.Handler {
 		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 		defer cancel()
 
-		user, err := qdb.GetUserByUsername(ctx, username)
+		user, err := usrv.GetByUsername(ctx, username)
 		if err != nil {
 			return c.Status(fiber.StatusNotFound).SendString("User not found")
 		}
@@ -223,3 +255,84 @@ func HandleProfileEdit(qdb *db.Queries) fiber.Handler {
 		})
 	}
 }
+
+// HandleProfilePatch applies a partial update to the extended profile fields.
+// The body is a flat object of field name to new value; an empty value clears the field.
+func HandleProfilePatch(psrv *profiles.ProfileService) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		username, err := getUsernameFromContext(c)
+		if err != nil {
+			return handleUnauthorized(c)
+		}
+
+		changes := make(map[string]string)
+		if err := c.BodyParser(&changes); err != nil {
+			return apperrors.NewBadRequest("Invalid request body")
+		}
+
+		if len(changes) == 0 {
+			return apperrors.NewBadRequest("No profile fields provided")
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		profile, err := psrv.UpdateFields(ctx, username, changes)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(fiber.Map{
+			"profile": profile,
+		})
+	}
+}
+
+// HandleProfileGet returns the extended profile fields of the current user
+func HandleProfileGet(psrv *profiles.ProfileService) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		username, err := getUsernameFromContext(c)
+		if err != nil {
+			return handleUnauthorized(c)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		profile, err := psrv.GetProfile(ctx, username)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(fiber.Map{
+			"profile": profile,
+		})
+	}
+}
+
+// HandleProfileHistory returns the audited change history of the current user's profile
+func HandleProfileHistory(psrv *profiles.ProfileService) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		username, err := getUsernameFromContext(c)
+		if err != nil {
+			return handleUnauthorized(c)
+		}
+
+		limit := c.QueryInt("limit", 20)
+		if limit <= 0 || limit > 100 {
+			limit = 100
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		history, err := psrv.GetChangeHistory(ctx, username, limit)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(fiber.Map{
+			"changes": history,
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"exc6/infrastructure/storage"
+	"exc6/pkg/cache"
+	"exc6/services/uploads"
+	"exc6/services/users"
+	"exc6/tests/fakedb"
+	"io"
+	"mime/multipart"
+	"net/http/httptest"
+	"net/textproto"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingViews keeps the data of the last rendered template
+type recordingViews struct {
+	last fiber.Map
+}
+
+func (v *recordingViews) Load() error { return nil }
+
+func (v *recordingViews) Render(_ io.Writer, _ string, bind interface{}, _ ...string) error {
+	v.last, _ = bind.(fiber.Map)
+	return nil
+}
+
+// profileRequest returns a profile update uploading content as custom icon
+func profileRequest(t *testing.T, content []byte) (*bytes.Buffer, string) {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+
+	h := make(textproto.MIMEHeader)
+	h.Set("Content-Disposition", `form-data; name="custom_icon"; filename="icon.png"`)
+	h.Set("Content-Type", "image/png")
+	part, err := w.CreatePart(h)
+	require.NoError(t, err)
+	_, err = part.Write(content)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	return &body, w.FormDataContentType()
+}
+
+func TestProfileIconReleasedAfterUpdate(t *testing.T) {
+	fake := fakedb.New(t)
+	aliceID := uuid.New()
+	const oldIcon = uploads.URLPrefix + "ab/old.png"
+
+	now := time.Now()
+	fake.Return("GetUserByUsername", fakedb.Row(aliceID, now, now, "alice", "user", "hash", nil, oldIcon))
+	fake.On("AcquireUploadObject", func(args []any) (fakedb.Result, error) {
+		return fakedb.Row(args[0], args[1], args[2], int32(1), now, now), nil
+	})
+	fake.Return("ReleaseUploadObject", fakedb.Row(int32(0)))
+
+	rdb := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", MaxRetries: -1, DialerRetries: 1})
+	t.Cleanup(func() { rdb.Close() })
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	usrv := users.NewUserService(fake.Queries(), cache.NewInvalidator(ctx, rdb))
+	store := uploads.NewStore(fake.Queries(), storage.NewLocal(t.TempDir(), "/uploads/"), 0)
+
+	views := &recordingViews{}
+	app := fiber.New(fiber.Config{Views: views})
+	app.Use(func(c *fiber.Ctx) error {
+		c.Locals("username", "alice")
+		return c.Next()
+	})
+	app.Put("/profile", HandleUserProfileUpdate(nil, usrv, store, nil))
+
+	send := func() {
+		body, contentType := profileRequest(t, pngImage(t))
+		req := httptest.NewRequest(fiber.MethodPut, "/profile", body)
+		req.Header.Set("Content-Type", contentType)
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+		assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+	}
+
+	// A failed update keeps the old icon and gives up the new one
+	fake.Fail("UpdateUser", errors.New("connection reset"))
+	send()
+	assert.Equal(t, "Failed to save profile", views.last["Error"])
+
+	acquired := fake.Calls("AcquireUploadObject")
+	require.Len(t, acquired, 1)
+	newIcon := acquired[0][1]
+
+	released := fake.Calls("ReleaseUploadObject")
+	require.Len(t, released, 1)
+	assert.Equal(t, newIcon, released[0][0], "only the icon that was never saved")
+
+	// A saved update gives up the old icon
+	fake.On("UpdateUser", func(args []any) (fakedb.Result, error) {
+		return fakedb.Row(args[0], now, now, args[1], "user", "hash", args[2], args[3]), nil
+	})
+	send()
+	assert.Equal(t, true, views.last["Saved"])
+
+	released = fake.Calls("ReleaseUploadObject")
+	require.Len(t, released, 2)
+	assert.Equal(t, oldIcon, released[1][0])
+}
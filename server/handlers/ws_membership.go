@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"exc6/pkg/logger"
+	"exc6/services/chat"
+	"exc6/services/groups"
+	"maps"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// membershipReconcileInterval is how often a connection re-reads its groups
+// from the database in case a membership event was missed
+const membershipReconcileInterval = 5 * time.Minute
+
+// resumeReplay holds the messages sent on resume, which may also arrive live
+// until the subscription's backlog from the replay has drained
+type resumeReplay struct {
+	sent map[string]bool
+	at   time.Time
+}
+
+// add records the messages of a replay finished at at
+func (r *resumeReplay) add(sent map[string]bool, at time.Time) {
+	if r.sent == nil {
+		r.sent = sent
+	} else {
+		maps.Copy(r.sent, sent)
+	}
+	r.at = at
+}
+
+// has reports whether a message was sent on resume
+func (r *resumeReplay) has(messageID string) bool {
+	return r.sent[messageID]
+}
+
+// forget drops the replayed messages on a reconcile tick a full interval
+// after the last replay; a tick right after one may find its backlog queued
+func (r *resumeReplay) forget(now time.Time) {
+	if now.Sub(r.at) >= membershipReconcileInterval {
+		r.sent = nil
+	}
+}
+
+// groupSubscription is the live set of groups a WebSocket connection relays.
+// It is owned by the relay goroutine and keeps the Redis subscription in sync.
+type groupSubscription struct {
+	username string
+	pubsub   *redis.PubSub
+	allowed  map[string]bool
+}
+
+func newGroupSubscription(username string, pubsub *redis.PubSub, groupIDs []string) *groupSubscription {
+	allowed := make(map[string]bool, len(groupIDs))
+	for _, id := range groupIDs {
+		allowed[id] = true
+	}
+	return &groupSubscription{username: username, pubsub: pubsub, allowed: allowed}
+}
+
+// has reports whether messages of the group should be relayed
+func (gs *groupSubscription) has(groupID string) bool {
+	return gs.allowed[groupID]
+}
+
+func (gs *groupSubscription) join(ctx context.Context, groupID string) {
+	if gs.allowed[groupID] {
+		return
+	}
+	if err := gs.pubsub.Subscribe(ctx, chat.GroupChannel(groupID)); err != nil {
+		logger.WithFields(map[string]any{
+			"username": gs.username,
+			"group_id": groupID,
+			"error":    err.Error(),
+		}).Warn("Failed to subscribe to group channel")
+		return
+	}
+	gs.allowed[groupID] = true
+}
+
+func (gs *groupSubscription) leave(ctx context.Context, groupID string) {
+	if !gs.allowed[groupID] {
+		return
+	}
+	delete(gs.allowed, groupID)
+	if err := gs.pubsub.Unsubscribe(ctx, chat.GroupChannel(groupID)); err != nil {
+		logger.WithFields(map[string]any{
+			"username": gs.username,
+			"group_id": groupID,
+			"error":    err.Error(),
+		}).Warn("Failed to unsubscribe from group channel")
+	}
+}
+
+// handleEvent applies a membership event received on the user's membership channel
+func (gs *groupSubscription) handleEvent(ctx context.Context, payload string) {
+	var event groups.MembershipEvent
+	if err := json.Unmarshal([]byte(payload), &event); err != nil {
+		logger.WithError(err).Warn("Failed to unmarshal membership event")
+		return
+	}
+
+	if event.Username != gs.username {
+		return
+	}
+
+	switch event.Change {
+	case groups.MembershipJoined:
+		gs.join(ctx, event.GroupID)
+	case groups.MembershipLeft:
+		gs.leave(ctx, event.GroupID)
+	}
+
+	logger.WithFields(map[string]any{
+		"username": gs.username,
+		"group_id": event.GroupID,
+		"change":   event.Change,
+	}).Debug("Applied group membership change to WebSocket connection")
+}
+
+// reconcile brings the live set in line with the database
+func (gs *groupSubscription) reconcile(ctx context.Context, gsrv *groups.GroupService) {
+	fetchCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	userGroups, err := gsrv.GetUserGroups(fetchCtx, gs.username)
+	if err != nil {
+		logger.WithError(err).WithField("username", gs.username).Warn("Group membership reconciliation failed")
+		return
+	}
+
+	current := make(map[string]bool, len(userGroups))
+	for _, g := range userGroups {
+		current[g.ID] = true
+		gs.join(ctx, g.ID)
+	}
+
+	for id := range gs.allowed {
+		if !current[id] {
+			gs.leave(ctx, id)
+		}
+	}
+}
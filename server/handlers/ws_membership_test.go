@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"exc6/services/chat"
+	"exc6/services/groups"
+	"exc6/tests/fakedb"
+	"exc6/tests/fakeredis"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newMembershipSubscription returns alice's subscription to groupIDs and a
+// client to publish with
+func newMembershipSubscription(t *testing.T, groupIDs ...string) (*groupSubscription, *redis.PubSub, *redis.Client) {
+	rdb := fakeredis.New(t).Client(t)
+	ctx := context.Background()
+
+	channels := []string{groups.MembershipChannel("alice")}
+	for _, id := range groupIDs {
+		channels = append(channels, chat.GroupChannel(id))
+	}
+	pubsub := rdb.Subscribe(ctx, channels...)
+	t.Cleanup(func() { pubsub.Close() })
+	for range channels {
+		_, err := pubsub.Receive(ctx)
+		require.NoError(t, err)
+	}
+
+	return newGroupSubscription("alice", pubsub, groupIDs), pubsub, rdb
+}
+
+// settle waits for Redis to process what pubsub sent so far, as subscribing
+// does not wait for the reply
+func settle(t *testing.T, pubsub *redis.PubSub) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	require.NoError(t, pubsub.Ping(ctx, "settle"))
+	for {
+		reply, err := pubsub.Receive(ctx)
+		require.NoError(t, err)
+		if pong, ok := reply.(*redis.Pong); ok && pong.Payload == "settle" {
+			return
+		}
+	}
+}
+
+// relayed reports whether a message published to the group reaches pubsub
+func relayed(t *testing.T, pubsub *redis.PubSub, rdb *redis.Client, groupID string) bool {
+	t.Helper()
+	ctx := context.Background()
+
+	settle(t, pubsub)
+	require.NoError(t, rdb.Publish(ctx, chat.GroupChannel(groupID), "hello").Err())
+	// A marker on the membership channel arrives after the message, if any
+	require.NoError(t, rdb.Publish(ctx, groups.MembershipChannel("alice"), "marker").Err())
+
+	for {
+		msgCtx, cancel := context.WithTimeout(ctx, time.Second)
+		msg, err := pubsub.ReceiveMessage(msgCtx)
+		cancel()
+		require.NoError(t, err)
+		switch msg.Channel {
+		case chat.GroupChannel(groupID):
+			// Drain the marker
+			_, err := pubsub.ReceiveMessage(ctx)
+			require.NoError(t, err)
+			return true
+		case groups.MembershipChannel("alice"):
+			return false
+		}
+	}
+}
+
+func membershipEvent(t *testing.T, username, groupID string, change groups.MembershipChange) string {
+	payload, err := json.Marshal(groups.MembershipEvent{GroupID: groupID, Username: username, Change: change})
+	require.NoError(t, err)
+	return string(payload)
+}
+
+func TestGroupSubscriptionEvents(t *testing.T) {
+	subs, pubsub, rdb := newMembershipSubscription(t, "g1")
+	ctx := context.Background()
+
+	assert.True(t, subs.has("g1"))
+	assert.True(t, relayed(t, pubsub, rdb, "g1"))
+
+	subs.handleEvent(ctx, membershipEvent(t, "alice", "g2", groups.MembershipJoined))
+	assert.True(t, subs.has("g2"))
+	assert.True(t, relayed(t, pubsub, rdb, "g2"), "joined groups are subscribed")
+
+	subs.handleEvent(ctx, membershipEvent(t, "alice", "g1", groups.MembershipLeft))
+	assert.False(t, subs.has("g1"))
+	assert.False(t, relayed(t, pubsub, rdb, "g1"), "left groups are unsubscribed")
+
+	// Events about others and malformed ones change nothing
+	subs.handleEvent(ctx, membershipEvent(t, "bob", "g3", groups.MembershipJoined))
+	subs.handleEvent(ctx, "not json")
+	assert.False(t, subs.has("g3"))
+	assert.True(t, subs.has("g2"))
+}
+
+func TestGroupSubscriptionJoinFails(t *testing.T) {
+	subs, pubsub, _ := newMembershipSubscription(t)
+
+	require.NoError(t, pubsub.Close())
+	subs.join(context.Background(), "g1")
+	assert.False(t, subs.has("g1"), "not relayed unless subscribed")
+}
+
+func TestGroupSubscriptionReconcile(t *testing.T) {
+	stale, kept, added := uuid.New(), uuid.New(), uuid.New()
+	subs, pubsub, rdb := newMembershipSubscription(t, stale.String(), kept.String())
+	ctx := context.Background()
+
+	fake := fakedb.New(t)
+	now := time.Now()
+	fake.Return("GetUserByUsername", fakedb.Row(uuid.New(), now, now, "alice", "user", "hash", nil, nil))
+	fake.Return("GetUserGroups", fakedb.Result{Rows: [][]any{
+		{kept, "Kept", nil, nil, nil, uuid.New(), now, now, groups.KindGroup},
+		{added, "Added", nil, nil, nil, uuid.New(), now, now, groups.KindGroup},
+	}})
+	gsrv := groups.NewGroupService(fake.Queries())
+
+	// Membership events were missed while the groups changed
+	subs.reconcile(ctx, gsrv)
+	assert.False(t, subs.has(stale.String()))
+	assert.True(t, subs.has(kept.String()))
+	assert.True(t, subs.has(added.String()))
+	assert.False(t, relayed(t, pubsub, rdb, stale.String()))
+	assert.True(t, relayed(t, pubsub, rdb, added.String()))
+
+	// A failed lookup leaves the set alone
+	fake.Fail("GetUserGroups", assert.AnError)
+	subs.reconcile(ctx, gsrv)
+	assert.True(t, subs.has(kept.String()))
+	assert.True(t, subs.has(added.String()))
+}
+
+func TestResumeThenReconcile(t *testing.T) {
+	var replayed resumeReplay
+	resumedAt := time.Now()
+	replayed.add(map[string]bool{"m1": true}, resumedAt)
+
+	// A tick right after the resume may find the replay's backlog still queued
+	replayed.forget(resumedAt.Add(time.Second))
+	assert.True(t, replayed.has("m1"))
+
+	// Another resume adds to the messages held and restarts the wait
+	replayed.add(map[string]bool{"m2": true}, resumedAt.Add(time.Minute))
+	replayed.forget(resumedAt.Add(membershipReconcileInterval))
+	assert.True(t, replayed.has("m1"))
+	assert.True(t, replayed.has("m2"))
+
+	replayed.forget(resumedAt.Add(time.Minute + membershipReconcileInterval))
+	assert.False(t, replayed.has("m1"))
+	assert.False(t, replayed.has("m2"))
+
+	// Ticks without any resume have nothing to forget
+	var idle resumeReplay
+	idle.forget(time.Now())
+	assert.False(t, idle.has("m1"))
+}
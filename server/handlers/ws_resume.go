@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"context"
+	"exc6/pkg/instance"
+	"exc6/pkg/logger"
+	_websocket "exc6/server/websocket"
+	"exc6/services/chat"
+	"exc6/services/notifications"
+	"exc6/services/users"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// resumeTimeout bounds reading the missed messages of one conversation
+const resumeTimeout = 3 * time.Second
+
+// resumedConversations counts the conversations resumed on reconnect by
+// outcome. "truncated" conversations missed more than chat.MaxResumeMessages
+// and are reloaded by the client.
+var resumedConversations = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "ws_resumed_conversations_total",
+		Help: "Conversations WebSocket clients resumed after reconnecting, by outcome",
+	},
+	[]string{"result"}, // result: complete, truncated, failed, forbidden
+)
+
+var resumedMessages = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Name: "ws_resumed_messages_total",
+		Help: "Missed messages replayed to WebSocket clients that resumed after reconnecting",
+	},
+)
+
+func init() {
+	instance.Registerer().MustRegister(resumedConversations, resumedMessages)
+}
+
+// replayMissed sends the client the messages of each conversation it missed
+// after the last one it has, and returns their IDs. A client that missed too
+// much of a conversation, or whose gap could not be read, is told to reload.
+func replayMissed(ctx context.Context, client *_websocket.Client, csrv chat.Service, username string, points []_websocket.ResumePoint, memberships *groupSubscription, order *chat.SequenceWatch, usrv *users.UserService, mutes *notifications.Service) map[string]bool {
+	replayed := make(map[string]bool)
+
+	// reloadSince is the earliest gap the client has to reload
+	var reloadSince int64
+	reload := func(since int64) {
+		if reloadSince == 0 || since < reloadSince {
+			reloadSince = since
+		}
+	}
+
+	for _, point := range points {
+		if point.GroupID != "" && !memberships.has(point.GroupID) {
+			resumedConversations.WithLabelValues("forbidden").Inc()
+			continue
+		}
+
+		readCtx, cancel := context.WithTimeout(ctx, resumeTimeout)
+		missed, more, err := csrv.MessagesAfter(readCtx, username, chat.MessageRef{
+			ID:      point.LastID,
+			With:    point.With,
+			GroupID: point.GroupID,
+		}, point.Since, chat.MaxResumeMessages)
+		cancel()
+
+		if err != nil {
+			resumedConversations.WithLabelValues("failed").Inc()
+			logger.WithFields(map[string]any{
+				"username": username,
+				"with":     point.With,
+				"group_id": point.GroupID,
+				"error":    err.Error(),
+			}).Warn("Failed to read missed messages")
+			reload(point.Since)
+			continue
+		}
+
+		for _, msg := range missed {
+			// Withdrawn before the client could see them
+			if msg.Deleted {
+				continue
+			}
+			order.Observe(msg)
+			if err := client.SendMessage(chatToWebSocket(ctx, msg, client, username, usrv, mutes)); err != nil {
+				return replayed
+			}
+			replayed[msg.MessageID] = true
+			resumedMessages.Inc()
+		}
+
+		if more {
+			resumedConversations.WithLabelValues("truncated").Inc()
+			reload(point.Since)
+		} else {
+			resumedConversations.WithLabelValues("complete").Inc()
+		}
+	}
+
+	if reloadSince > 0 {
+		client.SendMessage(&_websocket.Message{
+			Type:      _websocket.MessageTypeResync,
+			Data:      map[string]any{"since": reloadSince},
+			Timestamp: time.Now().Unix(),
+		})
+	}
+	return replayed
+}
@@ -2,6 +2,7 @@ package server
 
 import (
 	"exc6/config"
+	"exc6/pkg/instance"
 	"exc6/pkg/logger"
 	"fmt"
 	"log"
@@ -28,10 +29,19 @@ func setupLogging(app *fiber.App, cfg config.LogConfig) error {
 
 	// Setup Fiber logger middleware
 	app.Use(fiberlogger.New(fiberlogger.Config{
-		Format:     "[${time}] WEB: ${status} | ${latency} | ${method} ${path} | ${ip}\n",
+		Format:     "[${time}] WEB: ${status} | ${latency} | ${method} ${path} | ${locals:client_ip} | instance=" + instance.ID() + "${impersonator}\n",
 		TimeFormat: "2006-01-02 15:04:05.999",
 		TimeZone:   "Local",
 		Output:     httpLogger.OutputWriter, // Use the rotating writer
+		CustomTags: map[string]fiberlogger.LogFunc{
+			// Marks the requests of sessions an admin opened as the user
+			"impersonator": func(output fiberlogger.Buffer, c *fiber.Ctx, _ *fiberlogger.Data, _ string) (int, error) {
+				if admin, ok := c.Locals("impersonator").(string); ok && admin != "" {
+					return output.WriteString(" | IMPERSONATED by " + admin)
+				}
+				return 0, nil
+			},
+		},
 	}))
 
 	return nil
@@ -48,6 +58,7 @@ func setupErrorLogging(cfg config.LogConfig) (*logger.Logger, error) {
 		Compress:   cfg.Compress,
 		LocalTime:  true,
 		Level:      config.ParseLogLevel(cfg.Level),
+		Fields:     logger.InstanceFields(),
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to create error logger: %w", err)
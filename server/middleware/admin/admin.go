@@ -0,0 +1,51 @@
+package admin
+
+import (
+	"context"
+	"exc6/apperrors"
+	"exc6/pkg/logger"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// New creates a middleware that only lets users with the configured role through.
+// It must run after the auth middleware so the username is available in Locals.
+func New(config ...Config) fiber.Handler {
+	cfg := configDefault(config...)
+
+	return func(c *fiber.Ctx) error {
+		if cfg.Next != nil && cfg.Next(c) {
+			return c.Next()
+		}
+
+		username, ok := c.Locals(cfg.ContextUsername).(string)
+		if !ok || username == "" {
+			return apperrors.NewUnauthorized("")
+		}
+
+		if cfg.Users == nil {
+			return apperrors.NewInternalError("Admin middleware is not configured")
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		user, err := cfg.Users.GetByUsername(ctx, username)
+		if err != nil {
+			return err
+		}
+
+		if user.Role != cfg.Role {
+			logger.WithFields(map[string]any{
+				"username": username,
+				"path":     c.Path(),
+			}).Warn("Admin access denied")
+			return apperrors.NewAuthorizationError(username, c.Path(), c.Method())
+		}
+
+		c.Locals("role", user.Role)
+
+		return c.Next()
+	}
+}
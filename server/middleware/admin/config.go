@@ -0,0 +1,56 @@
+package admin
+
+import (
+	"exc6/services/users"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type Config struct {
+	// Next defines a function to skip middleware.
+	//
+	// Optional. Default: nil
+	Next func(c *fiber.Ctx) bool
+
+	// Users is used to look up the role of the authenticated user
+	//
+	// Required. Default: nil
+	Users *users.UserService
+
+	// Role is the user role required to pass
+	//
+	// Optional. Default: "admin"
+	Role string
+
+	// ContextUsername is the Locals key holding the authenticated username
+	//
+	// Optional. Default: "username"
+	ContextUsername string
+}
+
+var ConfigDefault = Config{
+	Next:            nil,
+	Users:           nil,
+	Role:            "admin",
+	ContextUsername: "username",
+}
+
+func configDefault(config ...Config) Config {
+	// Return default config if nothing provided
+	if len(config) < 1 {
+		return ConfigDefault
+	}
+
+	// Override default config
+	cfg := config[0]
+
+	// Set default values
+	if cfg.Role == "" {
+		cfg.Role = ConfigDefault.Role
+	}
+	if cfg.ContextUsername == "" {
+		cfg.ContextUsername = ConfigDefault.ContextUsername
+	}
+
+	return cfg
+}
@@ -3,6 +3,8 @@ package auth
 import (
 	"context"
 	"exc6/apperrors"
+	"exc6/pkg/timing"
+	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
@@ -22,11 +24,13 @@ func New(config Config) fiber.Handler {
 			return apperrors.NewUnauthorized("No session found")
 		}
 
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		ctx, cancel := context.WithTimeout(c.UserContext(), 5*time.Second)
 		defer cancel()
 
 		// Retrieve session from Redis
+		stop := timing.Track(ctx, timing.StageSession)
 		sess, err := cfg.SessionManager.GetSession(ctx, sessionID)
+		stop()
 		if err != nil {
 			return apperrors.NewInternalError("Failed to retrieve session").WithInternal(err)
 		}
@@ -39,16 +43,25 @@ func New(config Config) fiber.Handler {
 		c.Locals("username", sess.Username)
 		c.Locals("user_id", sess.UserID)
 
+		// Sessions an admin opened as the user may only look around
+		if sess.Impersonator != "" {
+			c.Locals("impersonator", sess.Impersonator)
+			if changes(c) && (cfg.ImpersonationExempt == nil || !cfg.ImpersonationExempt(c)) {
+				return apperrors.NewImpersonationReadOnly(sess.Impersonator, c.Method()+" "+c.Path())
+			}
+		}
+
 		// Only update session if last activity exceeds the threshold
 		// This reduces Redis writes by ~95% for active users
 		now := time.Now().Unix()
 		timeSinceLastUpdate := now - sess.LastActivity
 
 		if timeSinceLastUpdate >= int64(cfg.UpdateThreshold.Seconds()) {
-			updateCtx, updateCancel := context.WithTimeout(context.Background(), 3*time.Second)
+			updateCtx, updateCancel := context.WithTimeout(c.UserContext(), 3*time.Second)
 			defer updateCancel()
 
 			// Renew session TTL
+			stop := timing.Track(updateCtx, timing.StageSession)
 			if err := cfg.SessionManager.RenewSession(updateCtx, sessionID); err != nil {
 				// Log but don't fail the request if session renewal fails
 				// The session is still valid
@@ -56,8 +69,19 @@ func New(config Config) fiber.Handler {
 			} else {
 				cfg.SessionManager.RenewSession(updateCtx, sessionID)
 			}
+			stop()
 		}
 
 		return c.Next()
 	}
 }
+
+// changes reports whether a request may change something: anything but a
+// safe method, and WebSocket connections, over which messages are sent
+func changes(c *fiber.Ctx) bool {
+	switch c.Method() {
+	case fiber.MethodGet, fiber.MethodHead, fiber.MethodOptions:
+		return strings.EqualFold(c.Get(fiber.HeaderUpgrade), "websocket")
+	}
+	return true
+}
@@ -2,8 +2,8 @@ package auth
 
 import (
 	"context"
-	"exc6/db"
 	"exc6/services/sessions"
+	"exc6/services/users"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
@@ -16,10 +16,10 @@ type Config struct {
 	// Optional. Default: nil
 	Next func(c *fiber.Ctx) bool
 
-	// Users DB stores the user information.
+	// Users looks up the stored user information.
 	//
 	// Required. Default: nil
-	DB *db.Queries
+	Users *users.UserService
 
 	// Realm is a string to define realm attribute of BasicAuth.
 	// the realm identifies the system to authenticate against
@@ -67,11 +67,18 @@ type Config struct {
 	//
 	// Optional. Default: 60 seconds
 	UpdateThreshold time.Duration
+
+	// ImpersonationExempt lets through requests of impersonation sessions
+	// that would otherwise be refused for changing something, such as the
+	// one ending the session.
+	//
+	// Optional. Default: nil
+	ImpersonationExempt func(c *fiber.Ctx) bool
 }
 
 var ConfigDefault = Config{
 	Next:            nil,
-	DB:              nil,
+	Users:           nil,
 	Authorizer:      nil,
 	SessionManager:  nil,
 	Unauthorized:    nil,
@@ -94,16 +101,16 @@ func configDefault(config ...Config) Config {
 	if cfg.Next == nil {
 		cfg.Next = ConfigDefault.Next
 	}
-	if cfg.DB == nil {
-		cfg.DB = ConfigDefault.DB
+	if cfg.Users == nil {
+		cfg.Users = ConfigDefault.Users
 	}
 	if cfg.Authorizer == nil {
 		cfg.Authorizer = func(user, pass string) bool {
-			if cfg.DB == nil {
+			if cfg.Users == nil {
 				return false
 			}
 
-			usr, err := cfg.DB.GetUserByUsername(context.Background(), user)
+			usr, err := cfg.Users.GetByUsername(context.Background(), user)
 			if err != nil {
 				return false
 			}
@@ -0,0 +1,89 @@
+package canary
+
+import (
+	"hash/fnv"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// localsKey marks a request to be served by canary handlers
+const localsKey = "canary"
+
+// New creates a middleware that decides whether a request is served by the
+// canary handlers in a Registry. It must run after the auth middleware so
+// the username is available in Locals.
+func New(config ...Config) fiber.Handler {
+	cfg := configDefault(config...)
+
+	testers := make(map[string]bool, len(cfg.Testers))
+	for _, tester := range cfg.Testers {
+		testers[tester] = true
+	}
+
+	return func(c *fiber.Ctx) error {
+		if cfg.Next != nil && cfg.Next(c) {
+			return c.Next()
+		}
+
+		username, _ := c.Locals(cfg.ContextUsername).(string)
+		if username == "" {
+			return c.Next()
+		}
+
+		canary := InCohort(username, cfg.Percent)
+		if testers[username] {
+			switch c.Get(cfg.Header) {
+			case "1":
+				canary = true
+			case "0":
+				canary = false
+			}
+		}
+
+		if canary {
+			c.Locals(localsKey, true)
+		}
+
+		return c.Next()
+	}
+}
+
+// IsCanary reports whether the request was routed to canary handlers
+func IsCanary(c *fiber.Ctx) bool {
+	canary, _ := c.Locals(localsKey).(bool)
+	return canary
+}
+
+// InCohort reports whether key falls within the first percent of the hash
+// space, so the same key is always assigned the same variant
+func InCohort(key string, percent int) bool {
+	if percent <= 0 {
+		return false
+	}
+	if percent >= 100 {
+		return true
+	}
+
+	return slot(key) < percent
+}
+
+// Bucket assigns key to one of several buckets, each taking its weight out
+// of 100, and returns the bucket's index. The same key always lands in the
+// same bucket as long as the weights don't change. Weights must sum to 100.
+func Bucket(key string, weights []int) int {
+	s, upper := slot(key), 0
+	for i, w := range weights {
+		upper += w
+		if s < upper {
+			return i
+		}
+	}
+	return len(weights) - 1
+}
+
+// slot hashes key to 0-99
+func slot(key string) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % 100)
+}
@@ -0,0 +1,62 @@
+package canary
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInCohort(t *testing.T) {
+	tests := []struct {
+		name    string
+		percent int
+		wantMin int
+		wantMax int
+	}{
+		{name: "Disabled", percent: 0, wantMin: 0, wantMax: 0},
+		{name: "Everyone", percent: 100, wantMin: 1000, wantMax: 1000},
+		{name: "Ten percent", percent: 10, wantMin: 60, wantMax: 140},
+		{name: "Half", percent: 50, wantMin: 440, wantMax: 560},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			count := 0
+			for i := 0; i < 1000; i++ {
+				if InCohort(fmt.Sprintf("user%d", i), tt.percent) {
+					count++
+				}
+			}
+			assert.GreaterOrEqual(t, count, tt.wantMin)
+			assert.LessOrEqual(t, count, tt.wantMax)
+		})
+	}
+}
+
+func TestInCohortIsSticky(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("user%d", i)
+		assert.Equal(t, InCohort(key, 25), InCohort(key, 25))
+
+		// Raising the percentage never moves a user out of the canary
+		if InCohort(key, 25) {
+			assert.True(t, InCohort(key, 50), key)
+		}
+	}
+}
+
+func TestBucket(t *testing.T) {
+	weights := []int{20, 30, 50}
+	counts := make([]int, len(weights))
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("exp:user%d", i)
+		b := Bucket(key, weights)
+		assert.Equal(t, b, Bucket(key, weights), key)
+		counts[b]++
+	}
+
+	assert.InDelta(t, 200, counts[0], 60)
+	assert.InDelta(t, 300, counts[1], 60)
+	assert.InDelta(t, 500, counts[2], 60)
+}
@@ -0,0 +1,62 @@
+package canary
+
+import (
+	"github.com/gofiber/fiber/v2"
+)
+
+type Config struct {
+	// Next defines a function to skip middleware.
+	//
+	// Optional. Default: nil
+	Next func(c *fiber.Ctx) bool
+
+	// Percent of users routed to canary handlers. Users are assigned by a
+	// hash of their username, so each user consistently sees one variant.
+	//
+	// Optional. Default: 0
+	Percent int
+
+	// Testers are usernames allowed to choose a variant with the Header
+	// ("1" for canary, "0" for stable) regardless of Percent
+	//
+	// Optional. Default: nil
+	Testers []string
+
+	// Header is the request header testers use to choose a variant
+	//
+	// Optional. Default: "X-Canary"
+	Header string
+
+	// ContextUsername is the Locals key holding the authenticated username
+	//
+	// Optional. Default: "username"
+	ContextUsername string
+}
+
+var ConfigDefault = Config{
+	Next:            nil,
+	Percent:         0,
+	Testers:         nil,
+	Header:          "X-Canary",
+	ContextUsername: "username",
+}
+
+func configDefault(config ...Config) Config {
+	// Return default config if nothing provided
+	if len(config) < 1 {
+		return ConfigDefault
+	}
+
+	// Override default config
+	cfg := config[0]
+
+	// Set default values
+	if cfg.Header == "" {
+		cfg.Header = ConfigDefault.Header
+	}
+	if cfg.ContextUsername == "" {
+		cfg.ContextUsername = ConfigDefault.ContextUsername
+	}
+
+	return cfg
+}
@@ -0,0 +1,134 @@
+package canary
+
+import (
+	"errors"
+	"exc6/apperrors"
+	"exc6/pkg/instance"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	VariantStable = "stable"
+	VariantCanary = "canary"
+
+	// ResponseHeader tells testers which variant served their request
+	ResponseHeader = "X-Canary-Variant"
+)
+
+var (
+	requestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "canary_requests_total",
+			Help: "Requests to canary-enabled routes by route, variant and result",
+		},
+		[]string{"route", "variant", "result"}, // result: 2xx, 3xx, 4xx, 5xx
+	)
+
+	requestDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "canary_request_duration_seconds",
+			Help:    "Duration of requests to canary-enabled routes by route and variant",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"route", "variant"},
+	)
+)
+
+func init() {
+	instance.Registerer().MustRegister(requestsTotal)
+	instance.Registerer().MustRegister(requestDuration)
+}
+
+// Registry holds alternate implementations of routes. Routes wrapped with
+// Handler serve the canary implementation, when one is registered, to
+// requests its middleware routed to the canary, and record both variants
+// under separate metric labels so they can be compared.
+type Registry struct {
+	cfg Config
+
+	mu     sync.RWMutex
+	canary map[string]fiber.Handler
+}
+
+// NewRegistry creates an empty registry routing requests as configured
+func NewRegistry(config ...Config) *Registry {
+	return &Registry{
+		cfg:    configDefault(config...),
+		canary: make(map[string]fiber.Handler),
+	}
+}
+
+// Middleware returns the middleware deciding which requests use canary handlers
+func (r *Registry) Middleware() fiber.Handler {
+	return New(r.cfg)
+}
+
+// Register sets the canary implementation of a route
+func (r *Registry) Register(route string, handler fiber.Handler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.canary[route] = handler
+}
+
+// Handler wraps the stable implementation of a route
+func (r *Registry) Handler(route string, stable fiber.Handler) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		handler, variant := stable, VariantStable
+		if IsCanary(c) {
+			if canary := r.lookup(route); canary != nil {
+				handler, variant = canary, VariantCanary
+			}
+		}
+
+		c.Set(ResponseHeader, variant)
+
+		start := time.Now()
+		err := handler(c)
+
+		requestDuration.WithLabelValues(route, variant).Observe(time.Since(start).Seconds())
+		requestsTotal.WithLabelValues(route, variant, statusClass(responseStatus(c, err))).Inc()
+
+		return err
+	}
+}
+
+func (r *Registry) lookup(route string) fiber.Handler {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.canary[route]
+}
+
+// responseStatus returns the status the error handler will send for err
+func responseStatus(c *fiber.Ctx, err error) int {
+	if err == nil {
+		return c.Response().StatusCode()
+	}
+
+	var appErr *apperrors.AppError
+	if errors.As(err, &appErr) {
+		return appErr.StatusCode
+	}
+	var fiberErr *fiber.Error
+	if errors.As(err, &fiberErr) {
+		return fiberErr.Code
+	}
+	return fiber.StatusInternalServerError
+}
+
+// statusClass returns "2xx", "4xx", ... for a status code
+func statusClass(code int) string {
+	switch {
+	case code >= 500:
+		return "5xx"
+	case code >= 400:
+		return "4xx"
+	case code >= 300:
+		return "3xx"
+	default:
+		return "2xx"
+	}
+}
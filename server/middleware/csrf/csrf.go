@@ -5,7 +5,7 @@ import (
 	"encoding/base64"
 	"exc6/apperrors"
 	"exc6/pkg/logger"
-	"os"
+	"exc6/server/middleware/proxy"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
@@ -126,13 +126,12 @@ func GenerateToken(c *fiber.Ctx, storage Storage, expiration time.Duration) (str
 		return "", err
 	}
 
-	isSecure := os.Getenv("APP_ENV") != "development"
 	c.Cookie(&fiber.Cookie{
 		Name:     "csrf_token",
 		Value:    token,
 		Expires:  time.Now().Add(expiration),
 		HTTPOnly: false,
-		Secure:   isSecure,
+		Secure:   proxy.SecureCookie(c),
 		SameSite: "Strict",
 		Path:     "/",
 	})
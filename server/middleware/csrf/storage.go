@@ -4,6 +4,7 @@ import (
 	"container/list"
 	"context"
 	"exc6/apperrors"
+	"exc6/pkg/keyspace"
 	"exc6/pkg/logger"
 	"sync"
 	"time"
@@ -138,6 +139,13 @@ func (s *InMemoryStorage) cleanup() {
 	}
 }
 
+// keyPrefix namespaces CSRF tokens in Redis
+const keyPrefix = "csrf:"
+
+func init() {
+	keyspace.Register(keyspace.Family{Prefix: keyPrefix, Description: "CSRF tokens per session"})
+}
+
 type RedisStorage struct {
 	client    *redis.Client
 	prefix    string
@@ -151,7 +159,7 @@ type RedisStorage struct {
 func NewRedisStorage(client *redis.Client, ttl time.Duration) *RedisStorage {
 	return &RedisStorage{
 		client:    client,
-		prefix:    "csrf:",
+		prefix:    keyPrefix,
 		ttl:       ttl,
 		cache:     make(map[string]*list.Element),
 		evictList: list.New(),
@@ -1,6 +1,7 @@
 package limiter
 
 import (
+	"exc6/server/middleware/proxy"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
@@ -30,7 +31,7 @@ type Config struct {
 
 	// KeyGenerator allows you to generate custom keys for rate limiting
 	//
-	// Optional. Default: uses IP address
+	// Optional. Default: uses the client address resolved by the proxy middleware
 	KeyGenerator func(c *fiber.Ctx) string
 
 	// Handler is called when rate limit is exceeded
@@ -48,7 +49,7 @@ var ConfigDefault = Config{
 	RefillRate:   10,
 	RefillPeriod: time.Second,
 	KeyGenerator: func(c *fiber.Ctx) string {
-		return c.IP()
+		return proxy.ClientIP(c)
 	},
 	LimitReachedHandler: func(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
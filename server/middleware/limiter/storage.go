@@ -3,6 +3,7 @@ package limiter
 import (
 	"context"
 	"encoding/json"
+	"exc6/pkg/keyspace"
 	"fmt"
 	"sync"
 	"time"
@@ -83,6 +84,13 @@ func (s *InMemoryStorage) Reset() error {
 	return nil
 }
 
+// keyPrefix namespaces rate limit buckets in Redis
+const keyPrefix = "ratelimit:"
+
+func init() {
+	keyspace.Register(keyspace.Family{Prefix: keyPrefix, Description: "rate limit buckets"})
+}
+
 type RedisStorage struct {
 	client *redis.Client
 	ctx    context.Context
@@ -98,7 +106,7 @@ func NewRedisStorage(client *redis.Client, ttl time.Duration) *RedisStorage {
 }
 
 func (s *RedisStorage) Get(key string) (*TokenBucket, error) {
-	data, err := s.client.Get(s.ctx, "ratelimit:"+key).Bytes()
+	data, err := s.client.Get(s.ctx, keyPrefix+key).Bytes()
 	if err == redis.Nil {
 		return nil, nil
 	}
@@ -124,7 +132,7 @@ func (s *RedisStorage) Set(key string, bucket *TokenBucket) error {
 	if err != nil {
 		return err
 	}
-	return s.client.Set(s.ctx, "ratelimit:"+key, data, s.ttl).Err()
+	return s.client.Set(s.ctx, keyPrefix+key, data, s.ttl).Err()
 }
 
 // SetIfNotExists atomically sets the bucket only if the key doesn't exist
@@ -137,7 +145,7 @@ func (s *RedisStorage) SetIfNotExists(key string, bucket *TokenBucket) (bool, er
 
 	// Use Redis SETNX (SET if Not eXists) operation
 	// Returns true if the key was set, false if it already exists
-	result, err := s.client.SetNX(context.Background(), key, data, s.ttl).Result()
+	result, err := s.client.SetNX(context.Background(), keyPrefix+key, data, s.ttl).Result()
 	if err != nil {
 		return false, fmt.Errorf("failed to set bucket: %w", err)
 	}
@@ -146,7 +154,7 @@ func (s *RedisStorage) SetIfNotExists(key string, bucket *TokenBucket) (bool, er
 }
 
 func (s *RedisStorage) Delete(key string) error {
-	return s.client.Del(s.ctx, "ratelimit:"+key).Err()
+	return s.client.Del(s.ctx, keyPrefix+key).Err()
 }
 
 func (s *RedisStorage) Reset() error {
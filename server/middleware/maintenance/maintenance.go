@@ -0,0 +1,46 @@
+package maintenance
+
+import (
+	"context"
+	"exc6/apperrors"
+	"exc6/services/maintenance"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// New creates a middleware that answers 503 while a maintenance window is
+// active, except to users with the configured role. It must run after the
+// auth middleware so the username is available in Locals.
+func New(config ...Config) fiber.Handler {
+	cfg := configDefault(config...)
+
+	return func(c *fiber.Ctx) error {
+		if cfg.Next != nil && cfg.Next(c) {
+			return c.Next()
+		}
+
+		if cfg.Windows == nil {
+			return c.Next()
+		}
+
+		status := cfg.Windows.Status(time.Now())
+		if status == nil || status.State != maintenance.StateActive {
+			return c.Next()
+		}
+
+		if username, ok := c.Locals(cfg.ContextUsername).(string); ok && username != "" && cfg.Users != nil {
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+
+			if user, err := cfg.Users.GetByUsername(ctx, username); err == nil && user.Role == cfg.Role {
+				return c.Next()
+			}
+		}
+
+		c.Set(fiber.HeaderRetryAfter, strconv.FormatInt(status.SecondsLeft, 10))
+		return apperrors.New(apperrors.ErrCodeServiceUnavail, "Down for scheduled maintenance", fiber.StatusServiceUnavailable).
+			WithDetails("ends_at", status.Window.EndsAt)
+	}
+}
@@ -0,0 +1,47 @@
+package proxy
+
+import (
+	"github.com/gofiber/fiber/v2"
+)
+
+type Config struct {
+	// Next defines a function to skip middleware.
+	//
+	// Optional. Default: nil
+	Next func(c *fiber.Ctx) bool
+
+	// TrustedProxies lists the addresses and CIDR ranges of the reverse
+	// proxies in front of the server. Forwarding headers are only read from
+	// requests they make.
+	//
+	// Optional. Default: nil
+	TrustedProxies []string
+
+	// Header is the header proxies append the client address to
+	//
+	// Optional. Default: "X-Forwarded-For"
+	Header string
+}
+
+var ConfigDefault = Config{
+	Next:           nil,
+	TrustedProxies: nil,
+	Header:         fiber.HeaderXForwardedFor,
+}
+
+func configDefault(config ...Config) Config {
+	// Return default config if nothing provided
+	if len(config) < 1 {
+		return ConfigDefault
+	}
+
+	// Override default config
+	cfg := config[0]
+
+	// Set default values
+	if cfg.Header == "" {
+		cfg.Header = ConfigDefault.Header
+	}
+
+	return cfg
+}
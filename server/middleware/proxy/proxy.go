@@ -0,0 +1,123 @@
+package proxy
+
+import (
+	"net"
+	"os"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// localsKey holds the resolved client address
+const localsKey = "client_ip"
+
+// New creates a middleware that resolves the address of the client behind
+// any trusted reverse proxies. Each proxy appends the address it received the
+// request from to the forwarding header, so the header is read from the right
+// and the first address that is not a trusted proxy is the client. Entries to
+// its left were supplied by the client and are ignored.
+//
+// It should run before anything that identifies clients by address, such as
+// the rate limiter. Use ClientIP to read the result.
+func New(config ...Config) fiber.Handler {
+	cfg := configDefault(config...)
+	trusted := parseNetworks(cfg.TrustedProxies)
+
+	return func(c *fiber.Ctx) error {
+		if cfg.Next != nil && cfg.Next(c) {
+			return c.Next()
+		}
+
+		c.Locals(localsKey, resolve(c, cfg.Header, trusted))
+		return c.Next()
+	}
+}
+
+// ClientIP returns the client address New resolved, or the address of the
+// peer when the middleware did not run
+func ClientIP(c *fiber.Ctx) string {
+	if ip, ok := c.Locals(localsKey).(string); ok && ip != "" {
+		return ip
+	}
+	return c.IP()
+}
+
+// SecureCookie reports whether cookies set on the response should be marked
+// Secure. They always are outside development; in development they are when
+// the client connected over HTTPS, including through a trusted proxy that
+// terminates TLS.
+func SecureCookie(c *fiber.Ctx) bool {
+	return c.Secure() || os.Getenv("APP_ENV") != "development"
+}
+
+// resolve returns the client address of a request
+func resolve(c *fiber.Ctx, header string, trusted []*net.IPNet) string {
+	remote := c.Context().RemoteIP()
+	if len(trusted) == 0 || !contains(trusted, remote) {
+		return remote.String()
+	}
+
+	// A header may be repeated; together the values form one list
+	var hops []string
+	for _, value := range c.GetReqHeaders()[header] {
+		hops = append(hops, strings.Split(value, ",")...)
+	}
+
+	client := remote
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := parseHop(hops[i])
+		if ip == nil {
+			// A malformed entry cannot be attributed to anyone, so the last
+			// trusted hop is the best known client
+			break
+		}
+		client = ip
+		if !contains(trusted, ip) {
+			break
+		}
+	}
+	return client.String()
+}
+
+// parseHop parses one entry of a forwarding header, which some proxies write
+// with a port
+func parseHop(hop string) net.IP {
+	hop = strings.TrimSpace(hop)
+	if ip := net.ParseIP(hop); ip != nil {
+		return ip
+	}
+	if host, _, err := net.SplitHostPort(hop); err == nil {
+		return net.ParseIP(host)
+	}
+	return nil
+}
+
+// parseNetworks turns addresses and CIDR ranges into networks, skipping
+// entries that are neither; the configuration rejects those at startup
+func parseNetworks(entries []string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(entries))
+	for _, entry := range entries {
+		if ip := net.ParseIP(entry); ip != nil {
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			networks = append(networks, network)
+		}
+	}
+	return networks
+}
+
+// contains reports whether ip is in any of the networks
+func contains(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,73 @@
+package proxy
+
+import (
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Test requests come from 0.0.0.0
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name    string
+		trusted []string
+		header  []string
+		want    string
+	}{
+		{name: "No proxies trusted", header: []string{"203.0.113.7"}, want: "0.0.0.0"},
+		{name: "Peer not trusted", trusted: []string{"10.0.0.0/8"}, header: []string{"203.0.113.7"}, want: "0.0.0.0"},
+		{name: "Trusted peer without header", trusted: []string{"0.0.0.0"}, want: "0.0.0.0"},
+		{name: "Single proxy", trusted: []string{"0.0.0.0"}, header: []string{"203.0.113.7"}, want: "203.0.113.7"},
+		{
+			name:    "Spoofed entries are ignored",
+			trusted: []string{"0.0.0.0"},
+			header:  []string{"198.51.100.1, 203.0.113.7"},
+			want:    "203.0.113.7",
+		},
+		{
+			name:    "Chain of trusted proxies",
+			trusted: []string{"0.0.0.0", "10.0.0.0/8"},
+			header:  []string{"198.51.100.1, 203.0.113.7, 10.0.0.5"},
+			want:    "203.0.113.7",
+		},
+		{
+			name:    "Repeated headers",
+			trusted: []string{"0.0.0.0", "10.0.0.0/8"},
+			header:  []string{"203.0.113.7", "10.0.0.5"},
+			want:    "203.0.113.7",
+		},
+		{name: "Entry with port", trusted: []string{"0.0.0.0"}, header: []string{"203.0.113.7:4711"}, want: "203.0.113.7"},
+		{name: "IPv6 entry", trusted: []string{"0.0.0.0"}, header: []string{"[2001:db8::1]:4711"}, want: "2001:db8::1"},
+		{
+			name:    "Malformed entry stops at the last trusted hop",
+			trusted: []string{"0.0.0.0", "10.0.0.0/8"},
+			header:  []string{"203.0.113.7, unknown, 10.0.0.5"},
+			want:    "10.0.0.5",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := fiber.New()
+			app.Use(New(Config{TrustedProxies: tt.trusted}))
+			app.Get("/", func(c *fiber.Ctx) error {
+				return c.SendString(ClientIP(c))
+			})
+
+			req := httptest.NewRequest(fiber.MethodGet, "/", nil)
+			for _, value := range tt.header {
+				req.Header.Add(fiber.HeaderXForwardedFor, value)
+			}
+
+			resp, err := app.Test(req)
+			require.NoError(t, err)
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, string(body))
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package throttle
+
+import (
+	"exc6/apperrors"
+	"exc6/server/middleware/proxy"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Config defines the configuration for the upload throttle
+type Config struct {
+	// Next defines a function to skip middleware.
+	//
+	// Optional. Default: nil
+	Next func(c *fiber.Ctx) bool
+
+	// Slots is the number of uploads each user may have in progress at once
+	//
+	// Optional. Default: 2
+	Slots int
+
+	// BytesPerSecond is how fast the uploads of each user are read, across
+	// all of their slots. Zero reads them as fast as they arrive.
+	//
+	// Optional. Default: 0
+	BytesPerSecond int64
+
+	// KeyGenerator names the user an upload counts against
+	//
+	// Optional. Default: the username in c.Locals, else the client address
+	KeyGenerator func(c *fiber.Ctx) string
+
+	// SlotsFullHandler is called when the user has no free slot
+	//
+	// Optional. Default: returns a rate limit error
+	SlotsFullHandler fiber.Handler
+}
+
+// ConfigDefault provides default configuration
+var ConfigDefault = Config{
+	Next:           nil,
+	Slots:          2,
+	BytesPerSecond: 0,
+	KeyGenerator: func(c *fiber.Ctx) string {
+		if username, ok := c.Locals("username").(string); ok && username != "" {
+			return username
+		}
+		return proxy.ClientIP(c)
+	},
+	SlotsFullHandler: func(c *fiber.Ctx) error {
+		return apperrors.New(apperrors.ErrCodeRateLimited, "Too many uploads in progress. Please wait for one to finish.", fiber.StatusTooManyRequests)
+	},
+}
+
+func configDefault(config ...Config) Config {
+	// Return default config if nothing provided
+	if len(config) < 1 {
+		return ConfigDefault
+	}
+
+	// Override default config
+	cfg := config[0]
+
+	// Set default values
+	if cfg.Slots <= 0 {
+		cfg.Slots = ConfigDefault.Slots
+	}
+	if cfg.BytesPerSecond < 0 {
+		cfg.BytesPerSecond = ConfigDefault.BytesPerSecond
+	}
+	if cfg.KeyGenerator == nil {
+		cfg.KeyGenerator = ConfigDefault.KeyGenerator
+	}
+	if cfg.SlotsFullHandler == nil {
+		cfg.SlotsFullHandler = ConfigDefault.SlotsFullHandler
+	}
+
+	return cfg
+}
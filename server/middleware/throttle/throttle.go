@@ -0,0 +1,187 @@
+package throttle
+
+import (
+	"exc6/apperrors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Request bodies are streamed (fiber's StreamRequestBody): past the first
+// few kilobytes, a body is read from the connection only when a handler asks
+// for it. Upload routes have theirs read by New, which lets each user read
+// at most Slots uploads at once, at no more than BytesPerSecond between
+// them, so one user's uploads can't take the time the server needs for
+// everyone's SSE and WebSocket traffic. Slots and rates are per instance.
+
+// maxChunk is the most read from an upload between two waits
+const maxChunk = 32 << 10
+
+// user is the uploads of one user in progress
+type user struct {
+	active int
+	tokens float64 // bytes that can be read without waiting
+	last   time.Time
+}
+
+type throttle struct {
+	cfg Config
+
+	mu    sync.Mutex
+	users map[string]*user
+}
+
+// New creates a middleware that reads upload bodies within the user's slots
+// and rate before the handler sees them in full
+func New(config ...Config) fiber.Handler {
+	cfg := configDefault(config...)
+	t := &throttle{cfg: cfg, users: make(map[string]*user)}
+
+	return func(c *fiber.Ctx) error {
+		if cfg.Next != nil && cfg.Next(c) {
+			return c.Next()
+		}
+
+		key := cfg.KeyGenerator(c)
+		if !t.acquire(key) {
+			// The body is left unread on the connection
+			c.Context().SetConnectionClose()
+			return cfg.SlotsFullHandler(c)
+		}
+		defer t.release(key)
+
+		if c.Request().IsBodyStream() {
+			body, err := io.ReadAll(&reader{r: c.Request().BodyStream(), t: t, key: key})
+			if err != nil {
+				c.Context().SetConnectionClose()
+				return apperrors.NewBadRequest("Upload interrupted").WithInternal(err)
+			}
+			c.Request().SetBodyRaw(body)
+		}
+
+		return c.Next()
+	}
+}
+
+// acquire takes one of the user's slots, reporting false when all are taken
+func (t *throttle) acquire(key string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	u, ok := t.users[key]
+	if !ok {
+		u = &user{tokens: float64(t.cfg.BytesPerSecond), last: time.Now()}
+		t.users[key] = u
+	}
+	if u.active >= t.cfg.Slots {
+		return false
+	}
+	u.active++
+	return true
+}
+
+// release frees a slot, forgetting users with none taken
+func (t *throttle) release(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if u := t.users[key]; u != nil {
+		if u.active--; u.active <= 0 {
+			delete(t.users, key)
+		}
+	}
+}
+
+// take counts n bytes read for the user and returns how long to wait before
+// reading more to stay within the rate. A second's worth of bytes can be
+// read at once.
+func (t *throttle) take(key string, n int) time.Duration {
+	if t.cfg.BytesPerSecond == 0 {
+		return 0
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	u := t.users[key]
+	if u == nil {
+		return 0
+	}
+
+	rate := float64(t.cfg.BytesPerSecond)
+	now := time.Now()
+	u.tokens = min(rate, u.tokens+now.Sub(u.last).Seconds()*rate) - float64(n)
+	u.last = now
+	if u.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-u.tokens / rate * float64(time.Second))
+}
+
+// reader reads an upload in chunks, waiting between them as the rate needs
+type reader struct {
+	r   io.Reader
+	t   *throttle
+	key string
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	chunk := int64(maxChunk)
+	if bps := r.t.cfg.BytesPerSecond; bps > 0 && bps < chunk {
+		chunk = bps
+	}
+	if int64(len(p)) > chunk {
+		p = p[:chunk]
+	}
+
+	n, err := r.r.Read(p)
+	if wait := r.t.take(r.key, n); wait > 0 {
+		time.Sleep(wait)
+	}
+	return n, err
+}
+
+// BodyLimit creates a middleware that keeps streamed bodies within the app's
+// BodyLimit, which fiber enforces only for bodies it reads up front. Bodies
+// of unknown length are read here in full. Connections whose body was left
+// unread are closed after the response, since the next request on them
+// would start inside it.
+func BodyLimit() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if !c.Request().IsBodyStream() {
+			return c.Next()
+		}
+
+		limit := c.App().Config().BodyLimit
+		if c.Request().Header.ContentLength() > limit {
+			c.Context().SetConnectionClose()
+			return errBodyTooLarge(limit)
+		}
+		if c.Request().Header.ContentLength() < 0 {
+			body, err := io.ReadAll(io.LimitReader(c.Request().BodyStream(), int64(limit)+1))
+			if err != nil {
+				c.Context().SetConnectionClose()
+				return apperrors.NewBadRequest("Failed to read request body").WithInternal(err)
+			}
+			if len(body) > limit {
+				c.Context().SetConnectionClose()
+				return errBodyTooLarge(limit)
+			}
+			c.Request().SetBodyRaw(body)
+			c.Request().Header.SetContentLength(len(body))
+		}
+
+		err := c.Next()
+		if c.Request().IsBodyStream() {
+			c.Context().SetConnectionClose()
+		}
+		return err
+	}
+}
+
+func errBodyTooLarge(limit int) error {
+	return apperrors.New(apperrors.ErrCodeInvalidInput, "Request body too large", fiber.StatusRequestEntityTooLarge).
+		WithDetails("max_size_bytes", limit)
+}
@@ -0,0 +1,105 @@
+package throttle
+
+import (
+	"bytes"
+	"exc6/apperrors"
+	"io"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newApp serves uploads that report the size of the body they received,
+// calling hold first when it is set
+func newApp(cfg Config, hold func()) *fiber.App {
+	app := fiber.New(fiber.Config{
+		StreamRequestBody:            true,
+		DisablePreParseMultipartForm: true,
+		BodyLimit:                    1 << 20,
+		ErrorHandler:                 apperrors.Handler(apperrors.HandlerConfig{}),
+	})
+	app.Use(BodyLimit())
+
+	cfg.KeyGenerator = func(c *fiber.Ctx) string { return "alice" }
+	app.Post("/api/upload", New(cfg), func(c *fiber.Ctx) error {
+		if hold != nil {
+			hold()
+		}
+		return c.SendString(strconv.Itoa(len(c.Body())))
+	})
+	return app
+}
+
+func upload(t *testing.T, app *fiber.App, size int) (int, string) {
+	req := httptest.NewRequest(fiber.MethodPost, "/api/upload", bytes.NewReader(make([]byte, size)))
+	resp, err := app.Test(req, -1)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp.StatusCode, string(body)
+}
+
+func TestSlots(t *testing.T) {
+	started, block := make(chan struct{}), make(chan struct{})
+	var once sync.Once
+	app := newApp(Config{Slots: 1}, func() {
+		once.Do(func() {
+			close(started)
+			<-block
+		})
+	})
+
+	done := make(chan int)
+	go func() {
+		req := httptest.NewRequest(fiber.MethodPost, "/api/upload", bytes.NewReader(make([]byte, 64<<10)))
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			done <- 0
+			return
+		}
+		resp.Body.Close()
+		done <- resp.StatusCode
+	}()
+	<-started
+
+	// The first upload holds the only slot until it is done
+	status, _ := upload(t, app, 1<<10)
+	assert.Equal(t, fiber.StatusTooManyRequests, status)
+
+	close(block)
+	assert.Equal(t, fiber.StatusOK, <-done)
+
+	status, body := upload(t, app, 1<<10)
+	assert.Equal(t, fiber.StatusOK, status, "the slot is free again")
+	assert.Equal(t, "1024", body)
+}
+
+func TestBytesPerSecond(t *testing.T) {
+	app := newApp(Config{BytesPerSecond: 200 << 10}, nil)
+
+	// A second's worth is read at once, the rest at the rate
+	start := time.Now()
+	status, body := upload(t, app, 300<<10)
+	assert.Equal(t, fiber.StatusOK, status)
+	assert.Equal(t, strconv.Itoa(300<<10), body, "the handler sees the whole body")
+	assert.GreaterOrEqual(t, time.Since(start), 400*time.Millisecond)
+}
+
+func TestBodyLimit(t *testing.T) {
+	app := newApp(Config{}, nil)
+
+	status, _ := upload(t, app, 2<<20)
+	assert.Equal(t, fiber.StatusRequestEntityTooLarge, status)
+
+	status, body := upload(t, app, 512<<10)
+	assert.Equal(t, fiber.StatusOK, status)
+	assert.Equal(t, strconv.Itoa(512<<10), body)
+}
@@ -0,0 +1,56 @@
+package timing
+
+import (
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type Config struct {
+	// Next defines a function to skip middleware.
+	//
+	// Optional. Default: nil
+	Next func(c *fiber.Ctx) bool
+
+	// Headers adds the breakdown to responses as a Server-Timing header.
+	// It tells clients how the server spends its time, so it is meant for
+	// debugging and load tests.
+	//
+	// Optional. Default: false
+	Headers bool
+
+	// SampleRate is the share of requests whose breakdown is logged, 0-1
+	//
+	// Optional. Default: 0
+	SampleRate float64
+
+	// Budget is the latency target of a request. Breakdowns of requests
+	// over it are always logged.
+	//
+	// Optional. Default: 500ms
+	Budget time.Duration
+}
+
+var ConfigDefault = Config{
+	Next:       nil,
+	Headers:    false,
+	SampleRate: 0,
+	Budget:     500 * time.Millisecond,
+}
+
+func configDefault(config ...Config) Config {
+	// Return default config if nothing provided
+	if len(config) < 1 {
+		return ConfigDefault
+	}
+
+	// Override default config
+	cfg := config[0]
+
+	// Set default values
+	if cfg.Budget <= 0 {
+		cfg.Budget = ConfigDefault.Budget
+	}
+
+	return cfg
+}
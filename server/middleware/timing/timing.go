@@ -0,0 +1,90 @@
+package timing
+
+import (
+	"exc6/pkg/instance"
+	"exc6/pkg/logger"
+	"exc6/pkg/timing"
+	"math/rand"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// HeaderServerTiming carries the breakdown when headers are enabled
+const HeaderServerTiming = "Server-Timing"
+
+var stageSeconds = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Name:    "http_request_stage_seconds",
+		Help:    "Time requests spent in each stage of being served",
+		Buckets: []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	},
+	[]string{"stage"}, // auth, session, service, render, other, redis
+)
+
+func init() {
+	instance.Registerer().MustRegister(stageSeconds)
+}
+
+// New creates a middleware that times the stages of each request, see
+// timing.Breakdown. Handlers take their contexts from c.UserContext() for
+// their stages to be recorded. Errors are handled here, so the breakdown
+// includes rendering the error page.
+func New(config ...Config) fiber.Handler {
+	cfg := configDefault(config...)
+
+	return func(c *fiber.Ctx) error {
+		if cfg.Next != nil && cfg.Next(c) {
+			return c.Next()
+		}
+
+		b := timing.NewBreakdown(time.Now())
+		c.SetUserContext(timing.NewContext(c.UserContext(), b))
+
+		if err := c.Next(); err != nil {
+			if err := c.App().ErrorHandler(c, err); err != nil {
+				_ = c.SendStatus(fiber.StatusInternalServerError)
+			}
+		}
+
+		total := b.Total(time.Now())
+		timings := b.Timings(total)
+		for _, t := range timings {
+			stageSeconds.WithLabelValues(string(t.Stage)).Observe(t.Duration.Seconds())
+		}
+
+		if cfg.Headers {
+			c.Set(HeaderServerTiming, timing.ServerTiming(timings, total))
+		}
+
+		overBudget := total > cfg.Budget
+		if overBudget || rand.Float64() < cfg.SampleRate {
+			logBreakdown(c, timings, total, overBudget)
+		}
+		return nil
+	}
+}
+
+// logBreakdown logs the stages of a request in milliseconds
+func logBreakdown(c *fiber.Ctx, timings []timing.Timing, total time.Duration, overBudget bool) {
+	fields := map[string]any{
+		"method":      c.Method(),
+		"path":        c.Path(),
+		"status":      c.Response().StatusCode(),
+		"total_ms":    total.Milliseconds(),
+		"over_budget": overBudget,
+	}
+	if requestID, ok := c.Locals("requestid").(string); ok {
+		fields["request_id"] = requestID
+	}
+	for _, t := range timings {
+		fields[string(t.Stage)+"_ms"] = t.Duration.Milliseconds()
+	}
+
+	if overBudget {
+		logger.WithFields(fields).Warn("Request over latency budget")
+	} else {
+		logger.WithFields(fields).Info("Request timing")
+	}
+}
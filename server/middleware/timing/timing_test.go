@@ -0,0 +1,60 @@
+package timing
+
+import (
+	"exc6/pkg/timing"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServerTimingHeader(t *testing.T) {
+	handler := func(c *fiber.Ctx) error {
+		timing.FromContext(c.UserContext()).Add(timing.StageAuth, 120*time.Millisecond)
+		return c.SendString("ok")
+	}
+
+	tests := []struct {
+		name    string
+		headers bool
+	}{
+		{name: "Headers on", headers: true},
+		{name: "Headers off", headers: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := fiber.New()
+			app.Use(New(Config{Headers: tt.headers}))
+			app.Get("/", handler)
+
+			resp, err := app.Test(httptest.NewRequest(fiber.MethodGet, "/", nil))
+			require.NoError(t, err)
+
+			header := resp.Header.Get(HeaderServerTiming)
+			if !tt.headers {
+				assert.Empty(t, header)
+				return
+			}
+			assert.True(t, strings.HasPrefix(header, "auth;dur=120.0, other;dur="), header)
+			assert.Contains(t, header, "total;dur=")
+		})
+	}
+}
+
+func TestErrorsHandledInBreakdown(t *testing.T) {
+	app := fiber.New()
+	app.Use(New(Config{Headers: true}))
+	app.Get("/", func(c *fiber.Ctx) error {
+		return fiber.ErrTeapot
+	})
+
+	resp, err := app.Test(httptest.NewRequest(fiber.MethodGet, "/", nil))
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusTeapot, resp.StatusCode)
+	assert.NotEmpty(t, resp.Header.Get(HeaderServerTiming))
+}
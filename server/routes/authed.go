@@ -1,16 +1,45 @@
 package routes
 
 import (
-	"exc6/db"
+	"exc6/apperrors"
+	"exc6/config"
 	"exc6/server/handlers"
+	"exc6/server/middleware/admin"
 	"exc6/server/middleware/auth"
+	"exc6/server/middleware/canary"
 	"exc6/server/middleware/csrf"
+	"exc6/server/middleware/limiter"
+	maintenancemw "exc6/server/middleware/maintenance"
+	"exc6/server/middleware/throttle"
+	"exc6/server/sse"
 	"exc6/server/websocket"
+	"exc6/services/activity"
 	"exc6/services/calls"
 	"exc6/services/chat"
+	"exc6/services/clientlogs"
+	"exc6/services/cluster"
+	"exc6/services/compliance"
+	"exc6/services/digests"
+	"exc6/services/directory"
+	"exc6/services/emoji"
+	"exc6/services/experiments"
+	"exc6/services/export"
 	"exc6/services/friends"
+	"exc6/services/gifs"
 	"exc6/services/groups"
+	"exc6/services/impersonation"
+	"exc6/services/maintenance"
+	"exc6/services/moderation"
+	"exc6/services/notifications"
+	"exc6/services/presence"
+	"exc6/services/profiles"
+	"exc6/services/reminders"
+	"exc6/services/search"
 	"exc6/services/sessions"
+	"exc6/services/status"
+	"exc6/services/summaries"
+	"exc6/services/uploads"
+	"exc6/services/users"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
@@ -19,36 +48,120 @@ import (
 
 // AuthRoutes handles all authenticated routes (requires valid session)
 type AuthRoutes struct {
-	db          *db.Queries
-	csrv        *chat.ChatService
-	fsrv        *friends.FriendService
-	gsrv        *groups.GroupService
-	smngr       *sessions.SessionManager
-	wsManager   *websocket.Manager
-	callService *calls.CallService
-	rdb         *redis.Client
+	csrv           chat.Service
+	fsrv           *friends.FriendService
+	gsrv           *groups.GroupService
+	smngr          *sessions.SessionManager
+	wsManager      *websocket.Manager
+	callService    *calls.CallService
+	psrv           *profiles.ProfileService
+	clusterSrv     *cluster.ClusterService
+	usrv           *users.UserService
+	rsrv           *reminders.ReminderService
+	gifSrv         *gifs.GifService
+	emojiSrv       *emoji.EmojiService
+	exportSrv      *export.ExportService
+	activity       *activity.Tracker
+	policy         *moderation.Policy
+	canaries       *canary.Registry
+	uploadStore    *uploads.Store
+	sseBroker      *sse.Broker
+	maintenanceSrv *maintenance.Service
+	complianceSrv  *compliance.Service
+	clientLogs     *clientlogs.Service
+	experimentsSrv *experiments.Service
+	statusSrv      *status.Service
+	searchSrv      *search.Service
+	directorySrv   *directory.Service
+	summarySrv     *summaries.Service
+	digestSrv      *digests.Service
+	mutes          *notifications.Service
+	inbox          *notifications.NotificationService
+	presenceSrv    *presence.Service
+	impersonation  *impersonation.Service
+	features       config.FeaturesConfig
+	rdb            *redis.Client
+
+	// uploadThrottle reads the bodies of all upload routes, so a user's
+	// slots are shared between them
+	uploadThrottle fiber.Handler
 }
 
 // NewAuthRoutes creates a new authenticated routes handler
 func NewAuthRoutes(
-	db *db.Queries,
-	csrv *chat.ChatService,
+	csrv chat.Service,
 	fsrv *friends.FriendService,
 	gsrv *groups.GroupService,
 	smngr *sessions.SessionManager,
 	wsManager *websocket.Manager,
 	callService *calls.CallService,
+	psrv *profiles.ProfileService,
+	clusterSrv *cluster.ClusterService,
+	usrv *users.UserService,
+	rsrv *reminders.ReminderService,
+	gifSrv *gifs.GifService,
+	emojiSrv *emoji.EmojiService,
+	exportSrv *export.ExportService,
+	tracker *activity.Tracker,
+	policy *moderation.Policy,
+	canaries *canary.Registry,
+	uploadStore *uploads.Store,
+	sseBroker *sse.Broker,
+	maintenanceSrv *maintenance.Service,
+	complianceSrv *compliance.Service,
+	clientLogs *clientlogs.Service,
+	experimentsSrv *experiments.Service,
+	statusSrv *status.Service,
+	searchSrv *search.Service,
+	directorySrv *directory.Service,
+	summarySrv *summaries.Service,
+	digestSrv *digests.Service,
+	mutes *notifications.Service,
+	inbox *notifications.NotificationService,
+	presenceSrv *presence.Service,
+	impersonationSrv *impersonation.Service,
+	features config.FeaturesConfig,
+	upload config.UploadConfig,
 	rdb *redis.Client,
 ) *AuthRoutes {
 	return &AuthRoutes{
-		db:          db,
-		csrv:        csrv,
-		fsrv:        fsrv,
-		gsrv:        gsrv,
-		smngr:       smngr,
-		wsManager:   wsManager,
-		callService: callService,
-		rdb:         rdb,
+		csrv:           csrv,
+		fsrv:           fsrv,
+		gsrv:           gsrv,
+		smngr:          smngr,
+		wsManager:      wsManager,
+		callService:    callService,
+		psrv:           psrv,
+		clusterSrv:     clusterSrv,
+		usrv:           usrv,
+		rsrv:           rsrv,
+		gifSrv:         gifSrv,
+		emojiSrv:       emojiSrv,
+		exportSrv:      exportSrv,
+		activity:       tracker,
+		policy:         policy,
+		canaries:       canaries,
+		uploadStore:    uploadStore,
+		sseBroker:      sseBroker,
+		maintenanceSrv: maintenanceSrv,
+		complianceSrv:  complianceSrv,
+		clientLogs:     clientLogs,
+		experimentsSrv: experimentsSrv,
+		statusSrv:      statusSrv,
+		searchSrv:      searchSrv,
+		directorySrv:   directorySrv,
+		summarySrv:     summarySrv,
+		digestSrv:      digestSrv,
+		mutes:          mutes,
+		inbox:          inbox,
+		presenceSrv:    presenceSrv,
+		impersonation:  impersonationSrv,
+		features:       features,
+		rdb:            rdb,
+		uploadThrottle: throttle.New(throttle.Config{
+			Slots:          upload.MaxConcurrent,
+			BytesPerSecond: upload.BytesPerSecond,
+		}),
 	}
 }
 
@@ -59,9 +172,12 @@ func (ar *AuthRoutes) Register(app *fiber.App) {
 
 	// 1. First, apply Auth Middleware (loads user into context)
 	authed.Use(auth.New(auth.Config{
-		DB:             ar.db,
+		Users:          ar.usrv,
 		SessionManager: ar.smngr,
 		Next:           nil,
+		ImpersonationExempt: func(c *fiber.Ctx) bool {
+			return c.Path() == "/impersonation/end"
+		},
 	}))
 
 	csrfStorage := csrf.NewRedisStorage(ar.rdb, 1*time.Hour)
@@ -95,8 +211,22 @@ func (ar *AuthRoutes) Register(app *fiber.App) {
 	// Now when it runs, c.Locals("username") will be populated, fixing "User: <nil>" logs
 	authed.Use(csrfMiddleware)
 
+	// 3. Decide which requests are served by canary handler implementations
+	authed.Use(ar.canaries.Middleware())
+
+	// 4. During a maintenance window only admins get through; everyone can
+	// still read the window to show the banner
+	authed.Use(maintenancemw.New(maintenancemw.Config{
+		Windows: ar.maintenanceSrv,
+		Users:   ar.usrv,
+		Next: func(c *fiber.Ctx) bool {
+			return c.Path() == "/api/v1/maintenance"
+		},
+	}))
+	authed.Get("/api/v1/maintenance", handlers.HandleMaintenanceStatus(ar.maintenanceSrv))
+
 	// Dashboard - main chat interface
-	authed.Get("/dashboard", handlers.HandleDashboard(ar.fsrv, ar.gsrv, ar.csrv, ar.callService, ar.db))
+	authed.Get("/dashboard", handlers.HandleDashboard(ar.fsrv, ar.gsrv, ar.csrv, ar.callService, ar.usrv, ar.activity))
 
 	// WebSocket endpoint for real-time chat and calls
 	ar.registerWebSocketRoutes(authed)
@@ -105,7 +235,11 @@ func (ar *AuthRoutes) Register(app *fiber.App) {
 	ar.registerChatRoutes(authed)
 
 	// Voice call routes
-	ar.registerCallRoutes(authed)
+	if ar.features.Enabled(config.FeatureCalls) {
+		ar.registerCallRoutes(authed)
+	} else {
+		registerDisabledFeature(authed, config.FeatureCalls, "/call")
+	}
 
 	// Profile routes
 	ar.registerProfileRoutes(authed)
@@ -113,36 +247,128 @@ func (ar *AuthRoutes) Register(app *fiber.App) {
 	// Friend management routes
 	ar.registerFriendRoutes(authed)
 
+	// Opt-in directory of discoverable users
+	ar.registerDirectoryRoutes(authed)
+
+	// Personal reminders
+	ar.registerReminderRoutes(authed)
+
+	// GIF search proxy
+	ar.registerGifRoutes(authed)
+
+	// AI summaries of conversations, when enabled
+	ar.registerSummaryRoutes(authed)
+	ar.registerDigestRoutes(authed)
+
+	// Muted conversations
+	ar.registerMuteRoutes(authed)
+
+	// Support access and the sessions admins open with it
+	ar.registerImpersonationRoutes(authed)
+
+	// Conversation exports
+	ar.registerExportRoutes(authed)
+
+	// Client error reports
+	ar.registerClientLogRoutes(authed)
+
+	// A/B experiment buckets
+	authed.Get("/api/v1/experiments/:name", handlers.HandleExperimentBucket(ar.experimentsSrv))
+
+	// Emoji catalog (built-in shortcodes and custom emoji)
+	authed.Get("/api/v1/emoji", handlers.HandleEmojiCatalog(ar.emojiSrv))
+
 	authed.Get("/notifications", handlers.HandleGetNotifications(ar.fsrv, ar.csrv, ar.callService))
-	authed.Post("/notifications/mark-read", handlers.HandleMarkNotificationsRead(ar.csrv, ar.callService))
+	authed.Get("/api/v1/notifications", handlers.HandleListNotifications(ar.fsrv, ar.csrv, ar.callService))
+	authed.Get("/sse/notifications", handlers.HandleNotificationStream(ar.sseBroker, ar.policy, ar.mutes))
+	authed.Post("/notifications/mark-read", handlers.HandleMarkNotificationsRead(ar.csrv, ar.callService, ar.inbox))
+
+	// The notification inbox
+	authed.Get("/api/v1/notifications/inbox", handlers.HandleInbox(ar.inbox))
+	authed.Post("/api/v1/notifications/inbox/read", handlers.HandleMarkInboxAllRead(ar.inbox))
+	authed.Post("/api/v1/notifications/inbox/:id/read", handlers.HandleMarkInboxRead(ar.inbox))
+	authed.Get("/api/v1/notifications/mentions", handlers.HandleMentions(ar.inbox))
 
-	authed.Get("/contacts", handlers.HandleGetContacts(ar.fsrv, ar.gsrv, ar.csrv, ar.callService))
+	authed.Get("/contacts", handlers.HandleGetContacts(ar.fsrv, ar.gsrv, ar.csrv, ar.usrv, ar.activity))
 
 	// Group management routes
-	RegisterGroupRoutes(authed, ar.db, ar.csrv, ar.gsrv, ar.wsManager)
+	if ar.features.Enabled(config.FeatureGroups) {
+		RegisterGroupRoutes(authed, ar.csrv, ar.gsrv, ar.gifSrv, ar.uploadStore, ar.wsManager, ar.canaries, ar.sseBroker, ar.inbox)
+	} else {
+		registerDisabledFeature(authed, config.FeatureGroups, "/groups", "/api/v1/groups", "/api/v1/invites", "/api/v1/dms")
+	}
+
+	// Operator routes (admin role required)
+	ar.registerAdminRoutes(authed)
+}
+
+// registerDisabledFeature answers everything under the paths of a feature
+// the deployment turned off with a feature-disabled error
+func registerDisabledFeature(router fiber.Router, feature string, prefixes ...string) {
+	for _, prefix := range prefixes {
+		router.Use(prefix, handlers.HandleFeatureDisabled(feature))
+	}
 }
 
 // registerWebSocketRoutes sets up WebSocket endpoints
 func (ar *AuthRoutes) registerWebSocketRoutes(router fiber.Router) {
 	// WebSocket upgrade check
 	// Updated to pass GroupService and DB Queries
-	router.Use("/ws", handlers.HandleWebSocketUpgrade(ar.wsManager, ar.csrv, ar.callService, ar.gsrv, ar.db))
+	router.Use("/ws", handlers.HandleWebSocketUpgrade(ar.wsManager, ar.csrv, ar.callService, ar.gsrv))
 
 	// WebSocket endpoint
 	// Updated to pass GroupService and DB Queries
-	router.Get("/ws/chat", handlers.HandleWebSocket(ar.wsManager, ar.csrv, ar.callService, ar.gsrv, ar.db))
+	router.Get("/ws/chat", handlers.HandleWebSocket(ar.wsManager, ar.csrv, ar.callService, ar.gsrv, ar.usrv, ar.mutes))
 }
 
 // registerChatRoutes sets up chat-related endpoints
 func (ar *AuthRoutes) registerChatRoutes(router fiber.Router) {
-	router.Get("/chat/:contact", handlers.HandleLoadChatWindow(ar.csrv, ar.db))
-	router.Post("/chat/:contact", handlers.HandleSendMessage(ar.csrv))
+	// Before /chat/:contact, which would take "search" for a contact
+	router.Get("/chat/search", handlers.HandleMessageSearch(ar.searchSrv))
+	router.Get("/chat/:contact", handlers.HandleLoadChatWindow(ar.csrv, ar.usrv))
+	router.Post("/chat/:contact", ar.canaries.Handler("chat.send", handlers.HandleSendMessage(ar.csrv, ar.gifSrv, ar.uploadStore)))
+	router.Get("/api/v1/chat/:contact/history", handlers.HandleChatHistory(ar.csrv))
+	router.Get("/chat/:contact/search", handlers.HandleChatSearch(ar.csrv))
+
+	// Ordered events of a conversation for clients keeping a local copy
+	router.Get("/conversations/:id/events", handlers.HandleConversationEvents(ar.csrv, ar.gsrv))
+
+	// Senders edit and delete their own messages
+	router.Patch("/api/v1/chat/:contact/messages/:messageId", handlers.HandleEditMessage(ar.csrv, ar.sseBroker))
+	router.Delete("/api/v1/chat/:contact/messages/:messageId", handlers.HandleDeleteMessage(ar.csrv, ar.sseBroker))
+
+	// Pinned messages of direct conversations, shared by both participants
+	router.Get("/api/v1/chat/:contact/pins", handlers.HandlePinnedMessages(ar.csrv))
+	router.Post("/api/v1/chat/:contact/pins/:messageId", handlers.HandlePinMessage(ar.csrv))
+	router.Delete("/api/v1/chat/:contact/pins/:messageId", handlers.HandleUnpinMessage(ar.csrv))
+
+	// Message requests: direct messages from users who are not friends
+	router.Get("/api/v1/message-requests", handlers.HandleMessageRequests(ar.csrv))
+	router.Post("/api/v1/message-requests/:username/accept", handlers.HandleAcceptMessageRequest(ar.csrv))
+	router.Post("/api/v1/message-requests/:username/decline", handlers.HandleDeclineMessageRequest(ar.csrv))
+
+	// Emoji reactions to direct and group messages
+	router.Get("/chat/message/:id/reactions", handlers.HandleGetReactions(ar.csrv, ar.gsrv))
+	router.Post("/chat/message/:id/reactions", handlers.HandleAddReaction(ar.csrv, ar.gsrv))
+	router.Delete("/chat/message/:id/reactions", handlers.HandleRemoveReaction(ar.csrv, ar.gsrv))
+
+	// Attachments encrypted by the sender's client, for the participants of
+	// the conversation they were uploaded for
+	router.Post("/api/v1/attachments", ar.uploadThrottle, handlers.HandleUploadAttachment(ar.uploadStore, ar.policy))
+	router.Get("/api/v1/attachments/:id", handlers.HandleGetAttachment(ar.uploadStore))
+	router.Get("/api/v1/attachments/:id/blob", handlers.HandleDownloadAttachment(ar.uploadStore))
+
+	// Abuse reports; also mutes the reported user for the reporter
+	router.Post("/api/v1/reports/:username", handlers.HandleReportUser(ar.policy))
 }
 
 // registerCallRoutes sets up voice call endpoints
 func (ar *AuthRoutes) registerCallRoutes(router fiber.Router) {
+	// STUN servers and TURN credentials for peer connections
+	router.Get("/call/ice-servers", handlers.HandleCallICEServers(ar.callService))
+
 	// Initiate call
-	router.Post("/call/initiate/:username", handlers.HandleCallInitiate(ar.callService, ar.wsManager))
+	router.Post("/call/initiate/:username", handlers.HandleCallInitiate(ar.callService, ar.wsManager, ar.inbox))
 
 	// Answer call
 	router.Post("/call/answer/:call_id", handlers.HandleCallAnswer(ar.callService, ar.wsManager))
@@ -153,15 +379,194 @@ func (ar *AuthRoutes) registerCallRoutes(router fiber.Router) {
 	// Reject call
 	router.Post("/call/reject/:call_id", handlers.HandleCallReject(ar.callService, ar.wsManager))
 
+	// Call recording: a participant asks, the other consents, either stops
+	router.Post("/call/record/:call_id/request", handlers.HandleCallRecordRequest(ar.callService, ar.wsManager, ar.usrv))
+	router.Post("/call/record/:call_id/consent", handlers.HandleCallRecordConsent(ar.callService, ar.wsManager))
+	router.Post("/call/record/:call_id/stop", handlers.HandleCallRecordStop(ar.callService, ar.wsManager))
+
+	// Hold/resume, and attended transfer of a held call
+	router.Post("/call/hold/:call_id", handlers.HandleCallHold(ar.callService, ar.wsManager))
+	router.Post("/call/resume/:call_id", handlers.HandleCallResume(ar.callService, ar.wsManager))
+	router.Post("/call/transfer/:call_id", handlers.HandleCallTransferStart(ar.callService, ar.wsManager, ar.inbox))
+	router.Post("/call/transfer/:call_id/complete", handlers.HandleCallTransferComplete(ar.callService, ar.wsManager))
+	router.Post("/call/transfer/:call_id/cancel", handlers.HandleCallTransferCancel(ar.callService, ar.wsManager))
+
+	// Changing the media of a call, such as upgrading audio to video
+	router.Post("/call/media/:call_id", handlers.HandleCallMediaRequest(ar.callService, ar.wsManager))
+	router.Post("/call/media/:call_id/answer", handlers.HandleCallMediaAnswer(ar.callService, ar.wsManager))
+
+	// Text chat scoped to a call and its participants
+	router.Post("/call/chat/:call_id", handlers.HandleCallChatSend(ar.callService, ar.wsManager))
+	router.Get("/call/chat/:call_id", handlers.HandleCallChatHistory(ar.callService))
+
+	// Media quality measured by the participants' clients while connected
+	router.Post("/call/:call_id/stats", handlers.HandleCallStats(ar.callService))
+
+	// Call rooms: calls between the members of a group or invited users
+	router.Post("/call/rooms", handlers.HandleCallRoomCreate(ar.callService, ar.gsrv, ar.wsManager))
+	router.Get("/call/rooms/:room_id", handlers.HandleCallRoomGet(ar.callService, ar.gsrv))
+	router.Post("/call/rooms/:room_id/join", handlers.HandleCallRoomJoin(ar.callService, ar.gsrv, ar.wsManager))
+	router.Post("/call/rooms/:room_id/leave", handlers.HandleCallRoomLeave(ar.callService, ar.wsManager))
+	router.Post("/call/rooms/:room_id/state", handlers.HandleCallRoomState(ar.callService, ar.wsManager))
+
 	// Call history
 	router.Get("/call/history", handlers.HandleCallHistory(ar.callService))
 }
 
 // registerProfileRoutes sets up profile management endpoints
 func (ar *AuthRoutes) registerProfileRoutes(router fiber.Router) {
-	router.Get("/profile", handlers.HandleProfileView(ar.db))
-	router.Get("/profile/edit", handlers.HandleProfileEdit(ar.db))
-	router.Put("/profile", handlers.HandleUserProfileUpdate(ar.db, ar.smngr))
+	router.Get("/profile", handlers.HandleProfileView(ar.usrv))
+	router.Get("/profile/edit", handlers.HandleProfileEdit(ar.usrv))
+	router.Put("/profile", ar.uploadThrottle, handlers.HandleUserProfileUpdate(ar.smngr, ar.usrv, ar.uploadStore, ar.activity))
+
+	// Self-service profile fields (JSON API)
+	router.Get("/api/v1/profile", handlers.HandleProfileGet(ar.psrv))
+	router.Patch("/api/v1/profile", handlers.HandleProfilePatch(ar.psrv))
+	router.Get("/api/v1/profile/history", handlers.HandleProfileHistory(ar.psrv))
+
+	// Public cards of many users at once, for rendering avatars
+	router.Post("/api/v1/users/lookup", handlers.HandleUserLookup(ar.usrv))
+}
+
+// registerReminderRoutes sets up personal reminder endpoints
+func (ar *AuthRoutes) registerReminderRoutes(router fiber.Router) {
+	router.Get("/api/v1/reminders", handlers.HandleRemindersList(ar.rsrv))
+	router.Post("/api/v1/reminders", handlers.HandleReminderCreate(ar.rsrv))
+	router.Delete("/api/v1/reminders/:id", handlers.HandleReminderCancel(ar.rsrv))
+}
+
+// registerGifRoutes sets up the GIF search proxy when a provider is configured.
+// Searches are rate limited per user since every cache miss spends provider quota.
+func (ar *AuthRoutes) registerGifRoutes(router fiber.Router) {
+	if ar.gifSrv == nil {
+		return
+	}
+
+	searchesPerMinute := ar.gifSrv.SearchesPerMinute()
+
+	router.Get("/api/v1/gifs/search", limiter.New(limiter.Config{
+		Capacity:     searchesPerMinute,
+		RefillRate:   searchesPerMinute,
+		RefillPeriod: time.Minute,
+		KeyGenerator: func(c *fiber.Ctx) string {
+			username, _ := c.Locals("username").(string)
+			return "gifs:" + username
+		},
+		Storage: limiter.NewRedisStorage(ar.rdb, 5*time.Minute),
+		LimitReachedHandler: func(c *fiber.Ctx) error {
+			return apperrors.NewRateLimitError()
+		},
+	}), handlers.HandleGifSearch(ar.gifSrv))
+}
+
+// registerSummaryRoutes sets up conversation summaries when they are
+// enabled. Requests are rate limited per user since each one that misses the
+// cache is sent to the provider.
+func (ar *AuthRoutes) registerSummaryRoutes(router fiber.Router) {
+	if ar.summarySrv == nil {
+		return
+	}
+
+	perMinute := ar.summarySrv.PerMinute()
+
+	router.Post("/chat/:id/summarize", limiter.New(limiter.Config{
+		Capacity:     perMinute,
+		RefillRate:   perMinute,
+		RefillPeriod: time.Minute,
+		KeyGenerator: func(c *fiber.Ctx) string {
+			username, _ := c.Locals("username").(string)
+			return "summaries:" + username
+		},
+		Storage: limiter.NewRedisStorage(ar.rdb, 5*time.Minute),
+		LimitReachedHandler: func(c *fiber.Ctx) error {
+			return apperrors.NewRateLimitError()
+		},
+	}), handlers.HandleSummarize(ar.summarySrv, ar.gsrv))
+}
+
+// registerDigestRoutes sets up the digest email preference when digests are
+// enabled
+func (ar *AuthRoutes) registerDigestRoutes(router fiber.Router) {
+	if ar.digestSrv == nil {
+		return
+	}
+
+	router.Get("/api/v1/digests/settings", handlers.HandleDigestSettings(ar.digestSrv))
+	router.Put("/api/v1/digests/settings", handlers.HandleUpdateDigestSettings(ar.digestSrv))
+}
+
+// registerMuteRoutes sets up muting direct conversations and groups
+func (ar *AuthRoutes) registerMuteRoutes(router fiber.Router) {
+	router.Get("/api/v1/notifications/mutes", handlers.HandleListMutes(ar.mutes))
+	router.Post("/api/v1/notifications/mutes", handlers.HandleMuteConversation(ar.mutes))
+	router.Delete("/api/v1/notifications/mutes", handlers.HandleUnmuteConversation(ar.mutes))
+}
+
+// registerClientLogRoutes sets up client error reporting, rate limited per
+// user so a page stuck in an error loop cannot flood the logs
+func (ar *AuthRoutes) registerClientLogRoutes(router fiber.Router) {
+	batchesPerMinute := ar.clientLogs.BatchesPerMinute()
+
+	router.Post("/api/v1/client-logs", limiter.New(limiter.Config{
+		Capacity:     batchesPerMinute,
+		RefillRate:   batchesPerMinute,
+		RefillPeriod: time.Minute,
+		KeyGenerator: func(c *fiber.Ctx) string {
+			username, _ := c.Locals("username").(string)
+			return "client-logs:" + username
+		},
+		Storage: limiter.NewRedisStorage(ar.rdb, 5*time.Minute),
+		LimitReachedHandler: func(c *fiber.Ctx) error {
+			return apperrors.NewRateLimitError()
+		},
+	}), handlers.HandleClientLogs(ar.clientLogs))
+}
+
+// registerExportRoutes sets up transcript downloads, rate limited per user
+// since PDF rendering is expensive
+func (ar *AuthRoutes) registerExportRoutes(router fiber.Router) {
+	exportLimiter := limiter.New(limiter.Config{
+		Capacity:     5,
+		RefillRate:   5,
+		RefillPeriod: time.Minute,
+		KeyGenerator: func(c *fiber.Ctx) string {
+			username, _ := c.Locals("username").(string)
+			return "export:" + username
+		},
+		Storage: limiter.NewRedisStorage(ar.rdb, 5*time.Minute),
+		LimitReachedHandler: func(c *fiber.Ctx) error {
+			return apperrors.NewRateLimitError()
+		},
+	})
+
+	router.Get("/api/v1/export/chat/:contact", exportLimiter, handlers.HandleExportChat(ar.exportSrv))
+	router.Get("/api/v1/export/groups/:groupId", exportLimiter, handlers.HandleExportGroup(ar.exportSrv))
+
+	// Read-only links to part of a conversation, revocable by their owner
+	router.Get("/api/v1/share/chat/:contact", handlers.HandleShareList(ar.exportSrv))
+	router.Post("/api/v1/share/chat/:contact", handlers.HandleShareCreate(ar.exportSrv))
+	router.Delete("/api/v1/share/:id", handlers.HandleShareRevoke(ar.exportSrv))
+}
+
+// registerDirectoryRoutes sets up the user directory. Listing it is rate
+// limited per user so it cannot be scraped in bulk.
+func (ar *AuthRoutes) registerDirectoryRoutes(router fiber.Router) {
+	router.Get("/api/v1/directory", limiter.New(limiter.Config{
+		Capacity:     30,
+		RefillRate:   30,
+		RefillPeriod: time.Minute,
+		KeyGenerator: func(c *fiber.Ctx) string {
+			username, _ := c.Locals("username").(string)
+			return "directory:" + username
+		},
+		Storage: limiter.NewRedisStorage(ar.rdb, 5*time.Minute),
+		LimitReachedHandler: func(c *fiber.Ctx) error {
+			return apperrors.NewRateLimitError()
+		},
+	}), handlers.HandleDirectory(ar.directorySrv))
+
+	router.Get("/api/v1/directory/settings", handlers.HandleDirectorySettings(ar.directorySrv))
+	router.Put("/api/v1/directory/settings", handlers.HandleUpdateDirectorySettings(ar.directorySrv))
 }
 
 // registerFriendRoutes sets up friend management endpoints
@@ -172,11 +577,19 @@ func (ar *AuthRoutes) registerFriendRoutes(router fiber.Router) {
 	// Search for users
 	router.Get("/friends/search", handlers.HandleSearchUsers(ar.fsrv))
 
+	// Paginated JSON lists
+	router.Get("/api/v1/friends", handlers.HandleListFriends(ar.fsrv))
+	router.Get("/api/v1/friends/search", handlers.HandleSearchUsersJSON(ar.fsrv))
+
+	// Presence of friends across instances
+	router.Get("/api/v1/friends/online", handlers.HandleOnlineFriends(ar.presenceSrv))
+	router.Get("/api/v1/friends/:username/presence", handlers.HandleFriendPresence(ar.presenceSrv))
+
 	// Send friend request
-	router.Post("/friends/request/:username", handlers.HandleSendFriendRequest(ar.fsrv, ar.wsManager))
+	router.Post("/friends/request/:username", handlers.HandleSendFriendRequest(ar.fsrv, ar.inbox))
 
 	// Accept friend request
-	router.Post("/friends/accept/:username", handlers.HandleAcceptFriendRequest(ar.fsrv, ar.wsManager))
+	router.Post("/friends/accept/:username", handlers.HandleAcceptFriendRequest(ar.fsrv, ar.inbox))
 
 	// Reject friend request
 	router.Delete("/friends/reject/:username", handlers.HandleRejectFriendRequest(ar.fsrv))
@@ -184,3 +597,70 @@ func (ar *AuthRoutes) registerFriendRoutes(router fiber.Router) {
 	// Remove friend
 	router.Delete("/friends/remove/:username", handlers.HandleRemoveFriend(ar.fsrv))
 }
+
+// registerImpersonationRoutes sets up the user's consent to support access
+// and the end of an impersonation session
+func (ar *AuthRoutes) registerImpersonationRoutes(router fiber.Router) {
+	router.Get("/api/v1/support-access", handlers.HandleSupportAccess(ar.impersonation))
+	router.Post("/api/v1/support-access", handlers.HandleSupportAccessGrant(ar.impersonation))
+	router.Delete("/api/v1/support-access", handlers.HandleSupportAccessRevoke(ar.impersonation))
+
+	router.Post("/impersonation/end", handlers.HandleImpersonationEnd(ar.impersonation))
+}
+
+// registerAdminRoutes sets up operator endpoints restricted to admin users
+func (ar *AuthRoutes) registerAdminRoutes(router fiber.Router) {
+	adminRouter := router.Group("/admin", admin.New(admin.Config{Users: ar.usrv}))
+
+	// Instances seen recently through the Redis heartbeat
+	adminRouter.Get("/cluster", handlers.HandleClusterInstances(ar.clusterSrv))
+
+	// Redis memory and TTL audit by key family
+	adminRouter.Get("/redis/keyspace", handlers.HandleRedisKeyspace(ar.rdb))
+
+	// Sessions cached in this instance's memory
+	adminRouter.Get("/sessions/cache", handlers.HandleSessionCache(ar.smngr))
+
+	// Signing in as users who granted support access, and the audit trail
+	adminRouter.Post("/impersonate/:username", handlers.HandleImpersonationStart(ar.impersonation))
+	adminRouter.Get("/impersonations", handlers.HandleImpersonationsList(ar.impersonation))
+
+	// Live gauges for the admin dashboard, pushed every second
+	adminRouter.Use("/ws", handlers.HandleWebSocketUpgrade(ar.wsManager, ar.csrv, ar.callService, ar.gsrv))
+	adminRouter.Get("/ws/metrics", handlers.HandleAdminMetricsStream(ar.wsManager, ar.csrv))
+
+	// Custom emoji management
+	adminRouter.Post("/emoji", ar.uploadThrottle, handlers.HandleEmojiCreate(ar.emojiSrv, ar.uploadStore))
+	adminRouter.Delete("/emoji/:shortcode", handlers.HandleEmojiDelete(ar.emojiSrv))
+
+	// Accounts restricted after abuse reports, awaiting moderator review
+	adminRouter.Get("/restrictions", handlers.HandleRestrictionsList(ar.policy))
+	adminRouter.Get("/restrictions/:username/reports", handlers.HandleRestrictionReports(ar.policy))
+	adminRouter.Post("/restrictions/:username/review", handlers.HandleRestrictionReview(ar.policy))
+
+	// Maintenance windows, announced to every connected client
+	adminRouter.Post("/maintenance", handlers.HandleMaintenanceSchedule(ar.maintenanceSrv))
+	adminRouter.Delete("/maintenance", handlers.HandleMaintenanceCancel(ar.maintenanceSrv))
+
+	// Incidents shown on the public status page
+	adminRouter.Post("/incidents", handlers.HandleIncidentCreate(ar.statusSrv))
+	adminRouter.Post("/incidents/:id/resolve", handlers.HandleIncidentResolve(ar.statusSrv))
+	adminRouter.Delete("/incidents/:id", handlers.HandleIncidentDelete(ar.statusSrv))
+
+	// Retention, legal hold, export and moderation policy, with its history
+	adminRouter.Get("/compliance/policy", handlers.HandleCompliancePolicy(ar.complianceSrv))
+	adminRouter.Put("/compliance/policy", handlers.HandleCompliancePolicyUpdate(ar.complianceSrv))
+	adminRouter.Get("/compliance/policy/history", handlers.HandleComplianceHistory(ar.complianceSrv))
+
+	// Experiments and their per-bucket results
+	adminRouter.Get("/experiments", handlers.HandleExperimentsList(ar.experimentsSrv))
+	adminRouter.Get("/experiments/:name/results", handlers.HandleExperimentResults(ar.experimentsSrv))
+
+	// Recent client-side error reports
+	adminRouter.Get("/client-logs", handlers.HandleClientLogsRecent(ar.clientLogs))
+
+	// Backfill of the search index from the Kafka history
+	adminRouter.Get("/search/backfill", handlers.HandleSearchBackfillProgress(ar.searchSrv))
+	adminRouter.Post("/search/backfill", handlers.HandleSearchBackfillStart(ar.searchSrv))
+	adminRouter.Delete("/search/backfill", handlers.HandleSearchBackfillStop(ar.searchSrv))
+}
@@ -1,33 +1,66 @@
 package routes
 
 import (
-	"exc6/db"
 	"exc6/server/handlers"
+	"exc6/server/middleware/canary"
+	"exc6/server/sse"
 	"exc6/server/websocket" // Import websocket package
 	"exc6/services/chat"
+	"exc6/services/gifs"
 	"exc6/services/groups"
+	"exc6/services/notifications"
+	"exc6/services/uploads"
 
 	"github.com/gofiber/fiber/v2"
 )
 
 // RegisterGroupRoutes sets up group-related endpoints
-func RegisterGroupRoutes(router fiber.Router, qdb *db.Queries, csrv *chat.ChatService, gsrv *groups.GroupService, wsManager *websocket.Manager) {
+func RegisterGroupRoutes(router fiber.Router, csrv chat.Service, gsrv *groups.GroupService, gifSrv *gifs.GifService, uploadStore *uploads.Store, wsManager *websocket.Manager, canaries *canary.Registry, sseBroker *sse.Broker, inbox *notifications.NotificationService) {
 	// Group creation from dashboard
 	router.Post("/groups/create", handlers.HandleCreateGroupFromDashboard(gsrv))
 
 	// Group chat (integrated with dashboard)
-	router.Get("/groups/:groupId/chat", handlers.HandleLoadGroupChatIntegrated(csrv, gsrv, qdb))
+	router.Get("/groups/:groupId/chat", handlers.HandleLoadGroupChatIntegrated(csrv, gsrv))
 
-	router.Post("/groups/:groupId/send", handlers.HandleSendGroupMessage(csrv, gsrv, wsManager))
+	router.Post("/groups/:groupId/send", canaries.Handler("groups.send", handlers.HandleSendGroupMessage(csrv, gsrv, gifSrv, uploadStore, wsManager)))
+
+	// Senders edit and delete their own messages
+	router.Patch("/api/v1/groups/:groupId/messages/:messageId", handlers.HandleEditGroupMessage(csrv, gsrv, sseBroker))
+	router.Delete("/api/v1/groups/:groupId/messages/:messageId", handlers.HandleDeleteGroupMessage(csrv, gsrv, sseBroker))
 
 	// Group members management
 	router.Get("/groups/:groupId/members", handlers.HandleGroupMembersPartial(gsrv))
 	router.Post("/groups/:groupId/members", handlers.HandleAddGroupMemberPartial(gsrv))
 	router.Delete("/groups/:groupId/members/:username", handlers.HandleRemoveGroupMemberPartial(gsrv))
+	router.Put("/groups/:groupId/members/:username/role", handlers.HandleUpdateGroupMemberRolePartial(gsrv))
+
+	// Invitations: admins invite, invitees accept or decline
+	router.Get("/api/v1/invites", handlers.HandleListInvites(gsrv))
+	router.Get("/groups/:groupId/invites", handlers.HandleGroupInvites(gsrv))
+	router.Post("/groups/:groupId/invites", handlers.HandleInviteGroupMember(gsrv, inbox))
+	router.Post("/groups/:groupId/invites/accept", handlers.HandleAcceptGroupInvite(gsrv, inbox))
+	router.Post("/groups/:groupId/invites/decline", handlers.HandleDeclineGroupInvite(gsrv))
+
+	// Join links, managed by the group's admins
+	router.Get("/groups/join/:token", handlers.HandleJoinGroupByLink(gsrv))
+	router.Get("/groups/:groupId/links", handlers.HandleGroupLinks(gsrv))
+	router.Post("/groups/:groupId/links", handlers.HandleCreateGroupLink(gsrv))
+	router.Delete("/groups/:groupId/links/:linkId", handlers.HandleRevokeGroupLink(gsrv))
 
-	// Group deletion
+	// Group deletion (owner only)
 	router.Delete("/groups/:groupId", handlers.HandleDeleteGroupFromChat(gsrv))
 
+	// Paginated JSON list
+	router.Get("/api/v1/groups", handlers.HandleListGroups(gsrv))
+
+	// Group DMs: ad-hoc groups without admins, convertible into regular groups
+	router.Post("/api/v1/dms", handlers.HandleCreateGroupDM(gsrv))
+	router.Post("/api/v1/dms/:groupId/convert", handlers.HandleConvertGroupDM(gsrv))
+
+	// Delivered and read status of messages sent with read receipts
+	router.Get("/api/v1/groups/:groupId/receipts", handlers.HandleTrackedGroupMessages(csrv, gsrv))
+	router.Get("/api/v1/groups/:groupId/messages/:messageId/receipts", handlers.HandleTrackedMessageReceipts(csrv, gsrv))
+
 	// Legacy
 	router.Get("/groups", handlers.HandleGetGroups(gsrv))
 }
@@ -1,24 +1,40 @@
 package routes
 
 import (
-	"exc6/db"
+	"exc6/apperrors"
 	"exc6/server/handlers"
+	"exc6/server/middleware/limiter"
+	"exc6/server/middleware/proxy"
+	"exc6/services/digests"
+	"exc6/services/export"
 	"exc6/services/sessions"
+	"exc6/services/status"
+	"exc6/services/users"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/redis/go-redis/v9"
 )
 
 // PublicRoutes handles all public-facing routes (no authentication required)
 type PublicRoutes struct {
-	db    *db.Queries
-	smngr *sessions.SessionManager
+	usrv      *users.UserService
+	smngr     *sessions.SessionManager
+	exportSrv *export.ExportService
+	statusSrv *status.Service
+	digestSrv *digests.Service
+	rdb       *redis.Client
 }
 
 // NewPublicRoutes creates a new public routes handler
-func NewPublicRoutes(db *db.Queries, smngr *sessions.SessionManager) *PublicRoutes {
+func NewPublicRoutes(usrv *users.UserService, smngr *sessions.SessionManager, exportSrv *export.ExportService, statusSrv *status.Service, digestSrv *digests.Service, rdb *redis.Client) *PublicRoutes {
 	return &PublicRoutes{
-		db:    db,
-		smngr: smngr,
+		usrv:      usrv,
+		smngr:     smngr,
+		exportSrv: exportSrv,
+		statusSrv: statusSrv,
+		digestSrv: digestSrv,
+		rdb:       rdb,
 	}
 }
 
@@ -27,12 +43,50 @@ func (pr *PublicRoutes) Register(app *fiber.App) {
 	// Landing page
 	app.Get("/", handlers.HandleHomepage())
 
+	// Service status, so users can tell whether a problem is on their end
+	app.Get("/status", handlers.HandleStatus(pr.statusSrv))
+
 	// Authentication forms (with HTMX support)
 	app.Get("/login-form", handlers.HandleLoginForm())
 	app.Get("/register-form", handlers.HandleRegisterForm())
 
 	// Authentication actions
-	app.Post("/register", handlers.HandleUserRegister(pr.db))
-	app.Post("/login", handlers.HandleUserLogin(pr.db, pr.smngr))
+	app.Post("/register", handlers.HandleUserRegister(pr.usrv))
+	app.Post("/login", handlers.HandleUserLogin(pr.usrv, pr.smngr))
 	app.Post("/logout", handlers.HandleUserLogout(pr.smngr))
+
+	// Shared conversation links; passcode attempts are rate limited per client
+	shareLimiter := limiter.New(limiter.Config{
+		Capacity:     10,
+		RefillRate:   10,
+		RefillPeriod: time.Minute,
+		KeyGenerator: func(c *fiber.Ctx) string {
+			return "share:" + proxy.ClientIP(c)
+		},
+		Storage: limiter.NewRedisStorage(pr.rdb, 5*time.Minute),
+		LimitReachedHandler: func(c *fiber.Ctx) error {
+			return apperrors.NewRateLimitError()
+		},
+	})
+	app.Get("/share/:token", handlers.HandleSharePage(pr.exportSrv))
+	app.Post("/share/:token", shareLimiter, handlers.HandleSharePage(pr.exportSrv))
+
+	// Unsubscribe links of digest emails. The page asks for confirmation so
+	// link scanners do not unsubscribe anyone; mail clients POST directly,
+	// rate limited per client so tokens cannot be guessed.
+	if pr.digestSrv != nil {
+		app.Get(digests.UnsubscribePath+":token", handlers.HandleDigestUnsubscribe(pr.digestSrv))
+		app.Post(digests.UnsubscribePath+":token", limiter.New(limiter.Config{
+			Capacity:     10,
+			RefillRate:   10,
+			RefillPeriod: time.Minute,
+			KeyGenerator: func(c *fiber.Ctx) string {
+				return "digests:" + proxy.ClientIP(c)
+			},
+			Storage: limiter.NewRedisStorage(pr.rdb, 5*time.Minute),
+			LimitReachedHandler: func(c *fiber.Ctx) error {
+				return apperrors.NewRateLimitError()
+			},
+		}), handlers.HandleDigestUnsubscribe(pr.digestSrv))
+	}
 }
@@ -1,13 +1,37 @@
 package routes
 
 import (
-	"exc6/db"
+	"exc6/config"
+	"exc6/server/middleware/canary"
+	"exc6/server/sse"
 	"exc6/server/websocket"
+	"exc6/services/activity"
 	"exc6/services/calls"
 	"exc6/services/chat"
+	"exc6/services/clientlogs"
+	"exc6/services/cluster"
+	"exc6/services/compliance"
+	"exc6/services/digests"
+	"exc6/services/directory"
+	"exc6/services/emoji"
+	"exc6/services/experiments"
+	"exc6/services/export"
 	"exc6/services/friends"
+	"exc6/services/gifs"
 	"exc6/services/groups"
+	"exc6/services/impersonation"
+	"exc6/services/maintenance"
+	"exc6/services/moderation"
+	"exc6/services/notifications"
+	"exc6/services/presence"
+	"exc6/services/profiles"
+	"exc6/services/reminders"
+	"exc6/services/search"
 	"exc6/services/sessions"
+	"exc6/services/status"
+	"exc6/services/summaries"
+	"exc6/services/uploads"
+	"exc6/services/users"
 
 	"github.com/gofiber/adaptor/v2"
 	"github.com/gofiber/fiber/v2"
@@ -16,13 +40,13 @@ import (
 )
 
 // RegisterRoutes configures all application routes and middleware
-func RegisterRoutes(app *fiber.App, db *db.Queries, csrv *chat.ChatService, fsrv *friends.FriendService, gsrv *groups.GroupService, smngr *sessions.SessionManager, websocketManager websocket.Manager, callssrv *calls.CallService, rdb *redis.Client) {
+func RegisterRoutes(app *fiber.App, csrv chat.Service, fsrv *friends.FriendService, gsrv *groups.GroupService, smngr *sessions.SessionManager, websocketManager websocket.Manager, callssrv *calls.CallService, psrv *profiles.ProfileService, clusterSrv *cluster.ClusterService, usrv *users.UserService, rsrv *reminders.ReminderService, gifSrv *gifs.GifService, emojiSrv *emoji.EmojiService, exportSrv *export.ExportService, tracker *activity.Tracker, policy *moderation.Policy, canaries *canary.Registry, uploadStore *uploads.Store, sseBroker *sse.Broker, maintenanceSrv *maintenance.Service, complianceSrv *compliance.Service, clientLogs *clientlogs.Service, experimentsSrv *experiments.Service, statusSrv *status.Service, searchSrv *search.Service, directorySrv *directory.Service, summarySrv *summaries.Service, digestSrv *digests.Service, mutes *notifications.Service, inbox *notifications.NotificationService, presenceSrv *presence.Service, impersonationSrv *impersonation.Service, features config.FeaturesConfig, upload config.UploadConfig, rdb *redis.Client) {
 	app.Get("/metrics", adaptor.HTTPHandler(promhttp.Handler()))
 
 	// Initialize route handlers
-	publicRoutes := NewPublicRoutes(db, smngr)
+	publicRoutes := NewPublicRoutes(usrv, smngr, exportSrv, statusSrv, digestSrv, rdb)
 	apiRoutes := NewAPIRoutes()
-	authRoutes := NewAuthRoutes(db, csrv, fsrv, gsrv, smngr, &websocketManager, callssrv, rdb)
+	authRoutes := NewAuthRoutes(csrv, fsrv, gsrv, smngr, &websocketManager, callssrv, psrv, clusterSrv, usrv, rsrv, gifSrv, emojiSrv, exportSrv, tracker, policy, canaries, uploadStore, sseBroker, maintenanceSrv, complianceSrv, clientLogs, experimentsSrv, statusSrv, searchSrv, directorySrv, summarySrv, digestSrv, mutes, inbox, presenceSrv, impersonationSrv, features, upload, rdb)
 
 	// Register public routes (no auth required)
 	publicRoutes.Register(app)
@@ -5,16 +5,45 @@ import (
 	"exc6/apperrors"
 	"exc6/config"
 	"exc6/db"
+	"exc6/infrastructure/storage"
 	"exc6/pkg/logger"
+	"exc6/server/handlers"
+	"exc6/server/middleware/canary"
 	"exc6/server/middleware/limiter"
+	"exc6/server/middleware/proxy"
 	"exc6/server/middleware/security"
+	"exc6/server/middleware/throttle"
+	timingmw "exc6/server/middleware/timing"
 	"exc6/server/routes"
+	"exc6/server/sse"
 	"exc6/server/websocket"
+	"exc6/services/activity"
 	"exc6/services/calls"
 	"exc6/services/chat"
+	"exc6/services/clientlogs"
+	"exc6/services/cluster"
+	"exc6/services/compliance"
+	"exc6/services/digests"
+	"exc6/services/directory"
+	"exc6/services/emoji"
+	"exc6/services/experiments"
+	"exc6/services/export"
 	"exc6/services/friends"
+	"exc6/services/gifs"
 	"exc6/services/groups"
+	"exc6/services/impersonation"
+	"exc6/services/maintenance"
+	"exc6/services/moderation"
+	"exc6/services/notifications"
+	"exc6/services/presence"
+	"exc6/services/profiles"
+	"exc6/services/reminders"
+	"exc6/services/search"
 	"exc6/services/sessions"
+	"exc6/services/status"
+	"exc6/services/summaries"
+	"exc6/services/uploads"
+	"exc6/services/users"
 	"fmt"
 	"log"
 	"os"
@@ -31,19 +60,19 @@ type Server struct {
 	App   *fiber.App
 	db    *db.Queries
 	rdb   *redis.Client
-	csrv  *chat.ChatService
+	csrv  chat.Service
 	smngr *sessions.SessionManager
 	fsrv  *friends.FriendService
 	gsrv  *groups.GroupService
 	cfg   *config.Config
 }
 
-func NewServer(cfg *config.Config, db *db.Queries, rdb *redis.Client, csrv *chat.ChatService, smngr *sessions.SessionManager, fsrv *friends.FriendService, gsrv *groups.GroupService, websocketManager *websocket.Manager, callsSrv *calls.CallService) (*Server, error) {
+func NewServer(cfg *config.Config, db *db.Queries, rdb *redis.Client, csrv chat.Service, smngr *sessions.SessionManager, fsrv *friends.FriendService, gsrv *groups.GroupService, websocketManager *websocket.Manager, callsSrv *calls.CallService, psrv *profiles.ProfileService, clusterSrv *cluster.ClusterService, usrv *users.UserService, rsrv *reminders.ReminderService, gifSrv *gifs.GifService, emojiSrv *emoji.EmojiService, exportSrv *export.ExportService, tracker *activity.Tracker, policy *moderation.Policy, canaries *canary.Registry, uploadStore *uploads.Store, sseBroker *sse.Broker, maintenanceSrv *maintenance.Service, complianceSrv *compliance.Service, clientLogs *clientlogs.Service, experimentsSrv *experiments.Service, statusSrv *status.Service, searchSrv *search.Service, directorySrv *directory.Service, summarySrv *summaries.Service, digestSrv *digests.Service, mutes *notifications.Service, inbox *notifications.NotificationService, presenceSrv *presence.Service, impersonationSrv *impersonation.Service) (*Server, error) {
 	// Initialize template engine
 	engine := html.New(cfg.Server.ViewsDir, ".html")
 
 	// Add template functions
-	if err := addTemplateFunctions(engine); err != nil {
+	if err := addTemplateFunctions(engine, cfg.Features); err != nil {
 		return nil, fmt.Errorf("failed to add template functions: %w", err)
 	}
 
@@ -56,6 +85,7 @@ func NewServer(cfg *config.Config, db *db.Queries, rdb *redis.Client, csrv *chat
 		Compress:   cfg.Log.Compress,
 		LocalTime:  true,
 		Level:      config.ParseLogLevel(cfg.Log.Level),
+		Fields:     logger.InstanceFields(),
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize logger: %w", err)
@@ -86,6 +116,15 @@ func NewServer(cfg *config.Config, db *db.Queries, rdb *redis.Client, csrv *chat
 		ReadTimeout:  cfg.Server.ReadTimeout,
 		WriteTimeout: cfg.Server.WriteTimeout,
 		ErrorHandler: apperrors.Handler(errorConfig),
+
+		// Request bodies are read as handlers need them, see throttle
+		StreamRequestBody:            true,
+		DisablePreParseMultipartForm: true,
+
+		// X-Forwarded-Proto and friends are only honoured from trusted
+		// proxies, so secure cookies and HSTS follow the client's scheme
+		EnableTrustedProxyCheck: true,
+		TrustedProxies:          cfg.Server.TrustedProxies,
 	})
 
 	// Setup HTTP request logging
@@ -95,6 +134,16 @@ func NewServer(cfg *config.Config, db *db.Queries, rdb *redis.Client, csrv *chat
 
 	app.Use(requestid.New())
 
+	// Bodies are streamed so uploads can be throttled as they are read; the
+	// body limit still applies to all of them
+	app.Use(throttle.BodyLimit())
+
+	// Resolve the client address behind trusted proxies before anything
+	// limits or logs by it
+	app.Use(proxy.New(proxy.Config{
+		TrustedProxies: cfg.Server.TrustedProxies,
+	}))
+
 	// Security headers middleware
 	app.Use(security.New(security.Config{
 		Development: os.Getenv("APP_ENV") == "development",
@@ -129,13 +178,36 @@ func NewServer(cfg *config.Config, db *db.Queries, rdb *redis.Client, csrv *chat
 		MaxAge:    86400,
 	})
 
+	// Objects in a remote backend are fetched from signed URLs; older
+	// uploads outside the object store are still served from disk
+	if cfg.Upload.Storage != storage.BackendLocal && uploadStore != nil {
+		app.Get(uploads.URLPrefix+"*", handlers.HandleUploadObject(uploadStore, cfg.Upload.SignedURLTTL))
+	}
+	// Attachment blobs are only served to their conversation, see
+	// HandleDownloadAttachment
+	app.All(uploads.AttachmentsPath+"*", func(c *fiber.Ctx) error {
+		return fiber.ErrNotFound
+	})
 	app.Static("/uploads", cfg.Server.UploadsDir)
 
+	// Variants of objects stored before resizing existed are generated on
+	// first request. Every object in a remote backend was stored with them.
+	if cfg.Upload.Storage == storage.BackendLocal && uploadStore != nil {
+		app.Get(uploads.URLPrefix+"*", handlers.HandleUploadVariant(uploadStore))
+	}
+
 	// Setup logging
 	if err := setupLogging(app, cfg.Log); err != nil {
 		return nil, fmt.Errorf("failed to setup logging: %w", err)
 	}
 
+	// Time the stages of each request against the latency budget
+	app.Use(timingmw.New(timingmw.Config{
+		Headers:    cfg.Timing.Headers,
+		SampleRate: cfg.Timing.SampleRate,
+		Budget:     cfg.Timing.Budget,
+	}))
+
 	// Setup rate limiting
 	app.Use(limiter.New(limiter.Config{
 		Capacity:     cfg.RateLimit.Capacity,
@@ -163,7 +235,7 @@ func NewServer(cfg *config.Config, db *db.Queries, rdb *redis.Client, csrv *chat
 	}
 
 	// Register all routes, passing the CSRF middleware
-	routes.RegisterRoutes(app, db, csrv, fsrv, gsrv, smngr, *websocketManager, callsSrv, rdb)
+	routes.RegisterRoutes(app, csrv, fsrv, gsrv, smngr, *websocketManager, callsSrv, psrv, clusterSrv, usrv, rsrv, gifSrv, emojiSrv, exportSrv, tracker, policy, canaries, uploadStore, sseBroker, maintenanceSrv, complianceSrv, clientLogs, experimentsSrv, statusSrv, searchSrv, directorySrv, summarySrv, digestSrv, mutes, inbox, presenceSrv, impersonationSrv, cfg.Features, cfg.Upload, rdb)
 
 	return srv, nil
 }
@@ -171,8 +243,8 @@ func NewServer(cfg *config.Config, db *db.Queries, rdb *redis.Client, csrv *chat
 func (s *Server) Start() error {
 	addr := s.cfg.ServerAddress()
 
-	certFile := os.Getenv("TLS_CERT_FILE")
-	keyFile := os.Getenv("TLS_KEY_FILE")
+	certFile := s.cfg.Server.TLSCertFile
+	keyFile := s.cfg.Server.TLSKeyFile
 
 	if certFile != "" && keyFile != "" {
 		log.Printf("Starting HTTPS server on %s", addr)
@@ -0,0 +1,499 @@
+package sse
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"exc6/pkg/instance"
+	"exc6/pkg/keyspace"
+	"exc6/pkg/logger"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/redis/go-redis/v9"
+)
+
+// Events are published to a topic (usually a username). Each topic keeps a
+// short log in a Redis stream whose entry IDs are the event IDs, so a client
+// reconnecting with Last-Event-ID is replayed what it missed, on any
+// instance, before live events continue from Redis Pub/Sub. Event IDs
+// increase with time, as the stream IDs start with the time in milliseconds.
+//
+// The log is capped and expires, and is gone if Redis loses it. When it no
+// longer reaches back to a client's Last-Event-ID, the missing stretch is
+// replayed from the topic's Archive, the durable record of its events, when
+// the broker has one. Archived events get IDs in the same form from the time
+// they were recorded. Events whose data carries an "id" are not replayed
+// from both.
+//
+// When the server shuts down for a deploy, open streams are told to
+// reconnect after a random delay, so clients spread over the instances left
+// instead of arriving at once.
+//
+// Broadcasts go to every stream open on the instance instead. They have no
+// ID, are never replayed and bypass stream filters.
+
+const (
+	logKeyPrefix   = "sse:log:"
+	livePrefix     = "sse:live:"
+	keepAlive      = 15 * time.Second
+	eventBuffer    = 64
+	publishTimeout = 3 * time.Second
+
+	// shutdownRetryMin and shutdownRetryMax bound the reconnection delay
+	// streams are given when the server shuts down
+	shutdownRetryMin = 500 * time.Millisecond
+	shutdownRetryMax = 5 * time.Second
+
+	// HeaderLastEventID is how EventSource resumes a stream. Clients that
+	// cannot set headers pass QueryLastEventID instead.
+	HeaderLastEventID = "Last-Event-ID"
+	QueryLastEventID  = "last_event_id"
+)
+
+var (
+	streamsActive = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "sse_streams_active",
+			Help: "Open Server-Sent Event streams on this instance",
+		},
+	)
+
+	eventsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "sse_events_total",
+			Help: "Server-Sent Events by stage: published, replayed, archived, delivered, broadcast, filtered, dropped",
+		},
+		[]string{"stage"},
+	)
+)
+
+func init() {
+	instance.Registerer().MustRegister(streamsActive)
+	instance.Registerer().MustRegister(eventsTotal)
+
+	keyspace.Register(keyspace.Family{
+		Prefix:      logKeyPrefix,
+		Description: "recent events per SSE topic, replayed on reconnect",
+	})
+}
+
+// Event is one Server-Sent Event
+type Event struct {
+	ID   string          `json:"id"`
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+// write encodes the event in the text/event-stream format. Data is JSON and
+// never spans lines, but is split defensively.
+func (e Event) write(w *bufio.Writer) error {
+	var buf bytes.Buffer
+	if e.ID != "" {
+		buf.WriteString("id: " + e.ID + "\n")
+	}
+	if e.Type != "" {
+		buf.WriteString("event: " + e.Type + "\n")
+	}
+	for _, line := range strings.Split(string(e.Data), "\n") {
+		buf.WriteString("data: " + strings.TrimSuffix(line, "\r") + "\n")
+	}
+	buf.WriteString("\n")
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// Archive is the durable record of topics' events. Replay returns the events
+// of a topic recorded after after and before before, oldest first, at most
+// limit of them.
+type Archive interface {
+	Replay(ctx context.Context, topic string, after, before time.Time, limit int) ([]ArchivedEvent, error)
+}
+
+// ArchivedEvent is an event of an Archive and when it was recorded
+type ArchivedEvent struct {
+	Type       string
+	Data       any
+	RecordedAt time.Time
+}
+
+// ArchiveID returns the event ID of an event recorded at t: its millisecond,
+// then the microseconds within it, so archived events order among each other
+// and before anything logged later
+func ArchiveID(t time.Time) string {
+	micros := t.UnixMicro()
+	return strconv.FormatInt(micros/1000, 10) + "-" + strconv.FormatInt(micros%1000, 10)
+}
+
+// idTime returns the time an event ID was assigned, with the sequence of
+// archived IDs as microseconds
+func idTime(id string) (time.Time, bool) {
+	ms, seq, ok := parseID(id)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.UnixMicro(int64(ms)*1000 + int64(min(seq, 999))), true
+}
+
+// LastEventID returns the ID of the last event a reconnecting client saw,
+// or "" for a new stream
+func LastEventID(c *fiber.Ctx) string {
+	if id := c.Get(HeaderLastEventID); id != "" {
+		return id
+	}
+	return c.Query(QueryLastEventID)
+}
+
+// Options tune the replay log
+type Options struct {
+	// ReplaySize is how many events a topic keeps for replay
+	ReplaySize int64
+
+	// ReplayTTL is how long a topic's log outlives its last event
+	ReplayTTL time.Duration
+}
+
+// Broker publishes events to topics and serves them as event streams
+type Broker struct {
+	ctx  context.Context
+	rdb  *redis.Client
+	opts Options
+
+	mu    *sync.Mutex
+	local map[chan Event]struct{} // broadcast channels of open streams
+
+	archive Archive
+}
+
+// NewBroker creates a broker; streams end when ctx is cancelled
+func NewBroker(ctx context.Context, rdb *redis.Client, opts Options) *Broker {
+	if opts.ReplaySize <= 0 {
+		opts.ReplaySize = 100
+	}
+	if opts.ReplayTTL <= 0 {
+		opts.ReplayTTL = 24 * time.Hour
+	}
+
+	return &Broker{
+		ctx:   ctx,
+		rdb:   rdb,
+		opts:  opts,
+		mu:    &sync.Mutex{},
+		local: make(map[chan Event]struct{}),
+	}
+}
+
+// SetArchive sets where events missing from a topic's log are replayed from
+func (b *Broker) SetArchive(archive Archive) {
+	b.archive = archive
+}
+
+// Publish appends an event to the topic's log and delivers it to the
+// topic's open streams. It returns the event ID.
+func (b *Broker) Publish(ctx context.Context, topic, eventType string, data any) (string, error) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return "", err
+	}
+
+	key := logKeyPrefix + topic
+	pipe := b.rdb.TxPipeline()
+	add := pipe.XAdd(ctx, &redis.XAddArgs{
+		Stream: key,
+		MaxLen: b.opts.ReplaySize,
+		Approx: true,
+		Values: map[string]any{"type": eventType, "data": payload},
+	})
+	pipe.Expire(ctx, key, b.opts.ReplayTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return "", err
+	}
+	id := add.Val()
+
+	event, err := json.Marshal(Event{ID: id, Type: eventType, Data: payload})
+	if err != nil {
+		return "", err
+	}
+
+	if err := b.rdb.Publish(ctx, livePrefix+topic, event).Err(); err != nil {
+		return id, err
+	}
+
+	eventsTotal.WithLabelValues("published").Inc()
+	return id, nil
+}
+
+// PublishAsync publishes in the background, logging failures. Callers use
+// it for notifications that must not hold up the request.
+func (b *Broker) PublishAsync(topic, eventType string, data any) {
+	go func() {
+		ctx, cancel := context.WithTimeout(b.ctx, publishTimeout)
+		defer cancel()
+
+		if _, err := b.Publish(ctx, topic, eventType, data); err != nil {
+			logger.WithFields(map[string]any{
+				"topic": topic,
+				"type":  eventType,
+				"error": err.Error(),
+			}).Warn("Failed to publish server-sent event")
+		}
+	}()
+}
+
+// BroadcastLocal sends an event to every stream open on this instance.
+// Streams too slow to take it miss it.
+func (b *Broker) BroadcastLocal(eventType string, data any) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	ev := Event{Type: eventType, Data: payload}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.local {
+		select {
+		case ch <- ev:
+		default:
+			eventsTotal.WithLabelValues("dropped").Inc()
+		}
+	}
+	return nil
+}
+
+// Serve streams a topic to the client, starting after the client's
+// Last-Event-ID when it resumes. Events for which filter returns false are
+// skipped.
+func (b *Broker) Serve(c *fiber.Ctx, topic string, filter func(Event) bool) error {
+	lastEventID := LastEventID(c)
+	ctx, cancel := context.WithCancel(b.ctx)
+
+	// Subscribe before reading the log so nothing published in between is lost
+	pubsub := b.rdb.Subscribe(ctx, livePrefix+topic)
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		cancel()
+		return err
+	}
+
+	c.Set(fiber.HeaderContentType, "text/event-stream")
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	c.Set(fiber.HeaderConnection, "keep-alive")
+	c.Set("X-Accel-Buffering", "no")
+
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer cancel()
+		defer pubsub.Close()
+
+		broadcasts := make(chan Event, eventBuffer)
+		b.mu.Lock()
+		b.local[broadcasts] = struct{}{}
+		b.mu.Unlock()
+		defer func() {
+			b.mu.Lock()
+			delete(b.local, broadcasts)
+			b.mu.Unlock()
+		}()
+
+		streamsActive.Inc()
+		defer streamsActive.Dec()
+
+		last := lastEventID
+		send := func(ev Event, stage string) bool {
+			if last != "" && !After(ev.ID, last) {
+				return true // already sent by the replay
+			}
+			last = ev.ID
+
+			if filter != nil && !filter(ev) {
+				eventsTotal.WithLabelValues("filtered").Inc()
+				return true
+			}
+			if err := ev.write(w); err != nil {
+				return false
+			}
+			eventsTotal.WithLabelValues(stage).Inc()
+			return w.Flush() == nil
+		}
+
+		// Tell the client the stream is open, as proxies may hold the headers
+		if _, err := w.WriteString(": connected\n\n"); err != nil || w.Flush() != nil {
+			return
+		}
+
+		if lastEventID != "" {
+			replay, err := b.replay(ctx, topic, lastEventID)
+			if err != nil {
+				logger.WithFields(map[string]any{
+					"topic": topic,
+					"error": err.Error(),
+				}).Warn("Failed to replay server-sent events")
+			}
+			for _, ev := range replay {
+				if !send(ev, "replayed") {
+					return
+				}
+			}
+		}
+
+		ticker := time.NewTicker(keepAlive)
+		defer ticker.Stop()
+
+		live := pubsub.Channel(redis.WithChannelSize(eventBuffer))
+		for {
+			select {
+			case msg, ok := <-live:
+				if !ok {
+					return
+				}
+				var ev Event
+				if err := json.Unmarshal([]byte(msg.Payload), &ev); err != nil {
+					eventsTotal.WithLabelValues("dropped").Inc()
+					continue
+				}
+				if !send(ev, "delivered") {
+					return
+				}
+
+			case ev := <-broadcasts:
+				if ev.write(w) != nil || w.Flush() != nil {
+					return
+				}
+				eventsTotal.WithLabelValues("broadcast").Inc()
+
+			case <-ticker.C:
+				// Comments keep idle connections open and detect disconnects
+				if _, err := w.WriteString(": ping\n\n"); err != nil || w.Flush() != nil {
+					return
+				}
+
+			case <-ctx.Done():
+				// The server is shutting down
+				retry := shutdownRetryMin + time.Duration(rand.Int63n(int64(shutdownRetryMax-shutdownRetryMin)))
+				w.WriteString("retry: " + strconv.FormatInt(retry.Milliseconds(), 10) + "\n\n")
+				w.Flush()
+				return
+			}
+		}
+	})
+
+	return nil
+}
+
+// replay returns the events after lastEventID: those of the log, preceded
+// by those of the archive when the log does not reach back that far
+func (b *Broker) replay(ctx context.Context, topic, lastEventID string) ([]Event, error) {
+	after, ok := idTime(lastEventID)
+	if !ok {
+		return nil, nil
+	}
+
+	key := logKeyPrefix + topic
+	var oldest, entries *redis.XMessageSliceCmd
+	_, err := b.rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		oldest = pipe.XRangeN(ctx, key, "-", "+", 1)
+		entries = pipe.XRangeN(ctx, key, "("+lastEventID, "+", b.opts.ReplaySize)
+		return nil
+	})
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, err
+	}
+
+	logged := make([]Event, 0, len(entries.Val()))
+	for _, entry := range entries.Val() {
+		eventType, _ := entry.Values["type"].(string)
+		data, _ := entry.Values["data"].(string)
+		logged = append(logged, Event{ID: entry.ID, Type: eventType, Data: json.RawMessage(data)})
+	}
+
+	// The log reaches back to lastEventID when its oldest entry is not after
+	// it; otherwise entries were trimmed, expired or lost
+	before := time.Now()
+	if first := oldest.Val(); len(first) > 0 {
+		if !After(first[0].ID, lastEventID) {
+			return logged, nil
+		}
+		before, _ = idTime(first[0].ID)
+	}
+	if b.archive == nil {
+		return logged, nil
+	}
+
+	archived, err := b.archive.Replay(ctx, topic, after, before, int(b.opts.ReplaySize))
+	if err != nil {
+		return logged, err
+	}
+
+	seen := make(map[string]bool, len(logged))
+	for _, ev := range logged {
+		if key := eventKey(ev); key != "" {
+			seen[key] = true
+		}
+	}
+
+	events := make([]Event, 0, len(archived)+len(logged))
+	for _, a := range archived {
+		payload, err := json.Marshal(a.Data)
+		if err != nil {
+			continue
+		}
+		ev := Event{ID: ArchiveID(a.RecordedAt), Type: a.Type, Data: payload}
+		if key := eventKey(ev); key != "" && seen[key] {
+			continue
+		}
+		events = append(events, ev)
+		eventsTotal.WithLabelValues("archived").Inc()
+	}
+	return append(events, logged...), nil
+}
+
+// eventKey returns the "id" of an event's data, if it has one
+func eventKey(ev Event) string {
+	var data struct {
+		ID string `json:"id"`
+	}
+	if json.Unmarshal(ev.Data, &data) != nil {
+		return ""
+	}
+	return data.ID
+}
+
+// After reports whether stream ID a comes after b. IDs that don't parse
+// come after everything, so they are never dropped as duplicates.
+func After(a, b string) bool {
+	aMs, aSeq, ok := parseID(a)
+	if !ok {
+		return true
+	}
+	bMs, bSeq, ok := parseID(b)
+	if !ok {
+		return true
+	}
+	return aMs > bMs || (aMs == bMs && aSeq > bSeq)
+}
+
+// parseID splits a Redis stream ID "<ms>-<seq>"
+func parseID(id string) (uint64, uint64, bool) {
+	msPart, seqPart, found := strings.Cut(id, "-")
+	if !found {
+		return 0, 0, false
+	}
+	ms, err := strconv.ParseUint(msPart, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	seq, err := strconv.ParseUint(seqPart, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	return ms, seq, true
+}
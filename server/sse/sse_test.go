@@ -0,0 +1,54 @@
+package sse
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAfter(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want bool
+	}{
+		{name: "Later millisecond", a: "1700000000001-0", b: "1700000000000-5", want: true},
+		{name: "Same millisecond later sequence", a: "1700000000000-2", b: "1700000000000-1", want: true},
+		{name: "Equal", a: "1700000000000-1", b: "1700000000000-1", want: false},
+		{name: "Earlier", a: "1699999999999-9", b: "1700000000000-0", want: false},
+		{name: "Numeric not lexical", a: "10-0", b: "9-0", want: true},
+		{name: "Unparsable event ID", a: "bogus", b: "1700000000000-0", want: true},
+		{name: "Unparsable last ID", a: "1700000000000-0", b: "bogus", want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, After(tt.a, tt.b))
+		})
+	}
+}
+
+func TestArchiveID(t *testing.T) {
+	recorded := time.UnixMicro(1700000000000250)
+
+	id := ArchiveID(recorded)
+	assert.Equal(t, "1700000000000-250", id)
+
+	got, ok := idTime(id)
+	assert.True(t, ok)
+	assert.True(t, got.Equal(recorded))
+
+	assert.True(t, After(ArchiveID(recorded.Add(time.Microsecond)), id), "archived IDs keep their order")
+	assert.True(t, After("1700000000001-0", id), "stream IDs of later events come after")
+
+	_, ok = idTime("bogus")
+	assert.False(t, ok)
+}
+
+func TestEventKey(t *testing.T) {
+	assert.Equal(t, "n1", eventKey(Event{Data: json.RawMessage(`{"id":"n1","type":"mention"}`)}))
+	assert.Equal(t, "", eventKey(Event{Data: json.RawMessage(`{"type":"call"}`)}))
+	assert.Equal(t, "", eventKey(Event{Data: json.RawMessage(`"text"`)}))
+}
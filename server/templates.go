@@ -2,13 +2,16 @@ package server
 
 import (
 	"errors"
+	"exc6/config"
+	"exc6/services/uploads"
 	"time"
+	"unicode/utf8"
 
 	"github.com/gofiber/template/html/v2"
 )
 
 // addTemplateFunctions adds custom functions to the template engine
-func addTemplateFunctions(engine *html.Engine) error {
+func addTemplateFunctions(engine *html.Engine, features config.FeaturesConfig) error {
 	// Dict function for template maps
 	engine.AddFunc("dict", func(values ...any) (map[string]any, error) {
 		if len(values)%2 != 0 {
@@ -44,16 +47,17 @@ func addTemplateFunctions(engine *html.Engine) error {
 
 	engine.AddFunc("iconClass", GetIconClass)
 
+	// Hide features the deployment turned off: featureEnabled "calls"
+	engine.AddFunc("featureEnabled", features.Enabled)
+
+	// Resized copy of an uploaded image: variant .CustomIcon "avatar"
+	engine.AddFunc("variant", uploads.VariantURL)
+
 	// String truncation helper
-	engine.AddFunc("truncate", func(s string, length int) string {
-		if len(s) <= length {
-			return s
-		}
-		if length <= 3 {
-			return s[:length]
-		}
-		return s[:length-3] + "..."
-	})
+	engine.AddFunc("truncate", truncate)
+
+	// First letter of a name for avatar placeholders
+	engine.AddFunc("initial", initial)
 
 	// Check if string is empty or whitespace
 	engine.AddFunc("isEmpty", func(s string) bool {
@@ -105,3 +109,29 @@ func GetIconClass(icon string) string {
 	// Default fallback
 	return "bg-signal-blue"
 }
+
+// truncate shortens s to at most length characters, ending with "..." when
+// there is room for it. It counts runes so multi-byte characters are never split.
+func truncate(s string, length int) string {
+	if length <= 0 {
+		return ""
+	}
+	if utf8.RuneCountInString(s) <= length {
+		return s
+	}
+
+	runes := []rune(s)
+	if length <= 3 {
+		return string(runes[:length])
+	}
+	return string(runes[:length-3]) + "..."
+}
+
+// initial returns the first character of a name, or "?" if it is empty
+func initial(s string) string {
+	r, _ := utf8.DecodeRuneInString(s)
+	if r == utf8.RuneError {
+		return "?"
+	}
+	return string(r)
+}
@@ -0,0 +1,244 @@
+package server
+
+import (
+	"exc6/config"
+	"exc6/server/handlers"
+	"exc6/services/chat"
+	"exc6/services/friends"
+	"exc6/services/groups"
+	"exc6/tests/factories"
+	"exc6/tests/golden"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/gofiber/template/html/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		length int
+		want   string
+	}{
+		{name: "Short string unchanged", input: "hello", length: 10, want: "hello"},
+		{name: "Ellipsis added", input: "hello world", length: 8, want: "hello..."},
+		{name: "No room for ellipsis", input: "hello", length: 2, want: "he"},
+		{name: "Multi-byte characters kept whole", input: "héllo wörld", length: 6, want: "hél..."},
+		{name: "Negative length", input: "hello", length: -1, want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, truncate(tt.input, tt.length))
+		})
+	}
+}
+
+func TestInitial(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{name: "ASCII", input: "alice", want: "a"},
+		{name: "Multi-byte", input: "Ωmega", want: "Ω"},
+		{name: "Empty", input: "", want: "?"},
+		{name: "Invalid UTF-8", input: "\xff", want: "?"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, initial(tt.input))
+		})
+	}
+}
+
+func FuzzTruncate(f *testing.F) {
+	f.Add("hello world", 8)
+	f.Add("héllo", 2)
+	f.Add("", -5)
+
+	f.Fuzz(func(t *testing.T, s string, length int) {
+		out := truncate(s, length)
+		if utf8.ValidString(s) && !utf8.ValidString(out) {
+			t.Fatalf("truncate(%q, %d) = %q splits a character", s, length, out)
+		}
+		if n := utf8.RuneCountInString(out); length >= 0 && n > length {
+			t.Fatalf("truncate(%q, %d) = %q is %d characters", s, length, out, n)
+		}
+	})
+}
+
+func TestDegradedBanner(t *testing.T) {
+	engine := html.New("./views", ".html")
+	require.NoError(t, addTemplateFunctions(engine, config.FeaturesConfig{}))
+	require.NoError(t, engine.Load())
+
+	tests := []struct {
+		name       string
+		template   string
+		binding    map[string]any
+		banner     bool
+		emptyState bool
+	}{
+		{name: "Friends degraded", template: "partials/friends-list", binding: map[string]any{"Degraded": true}, banner: true},
+		{name: "Friends empty", template: "partials/friends-list", binding: map[string]any{"Degraded": false}, emptyState: true},
+		{name: "Contacts degraded", template: "partials/contact-list", binding: map[string]any{"Degraded": true}, banner: true},
+		{name: "Contacts delta", template: "partials/contact-list", binding: map[string]any{"Degraded": true, "Delta": true}},
+		{name: "Chat degraded", template: "partials/chat-window", binding: map[string]any{"Degraded": true, "Me": "alice", "Other": "bob", "ContactIcon": ""}, banner: true},
+		{name: "Chat healthy", template: "partials/chat-window", binding: map[string]any{"Me": "alice", "Other": "bob", "ContactIcon": ""}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out strings.Builder
+			require.NoError(t, engine.Render(&out, tt.template, tt.binding))
+			assert.Equal(t, tt.banner, strings.Contains(out.String(), "degraded-banner"))
+			assert.Equal(t, tt.emptyState, strings.Contains(out.String(), "No friends yet"))
+		})
+	}
+}
+
+func FuzzRenderGroupChatWindow(f *testing.F) {
+	engine := html.New("./views", ".html")
+	require.NoError(f, addTemplateFunctions(engine, config.FeaturesConfig{}))
+	renderer := NewTemplateRenderer(engine)
+
+	f.Add("general", "alice", "hello")
+	f.Add("", "", "")
+	f.Add("Ωmega", "ü", "<script>alert(1)</script>")
+
+	f.Fuzz(func(t *testing.T, groupName, sender, content string) {
+		binding := map[string]any{
+			"Username": "bob",
+			"Group":    &groups.GroupInfo{ID: "g1", Name: groupName},
+			"Messages": []*chat.ChatMessage{
+				{MessageID: "m1", FromID: sender, GroupID: "g1", Content: content, IsGroup: true},
+			},
+		}
+
+		out, err := renderer.RenderToSingleLine("partials/group-chat-window", binding)
+		require.NoError(t, err)
+		assert.False(t, strings.ContainsAny(out, "\r\n"), "SSE data must be a single line")
+	})
+}
+
+func TestDisabledFeaturesHidden(t *testing.T) {
+	contacts := map[string]any{"Contacts": []handlers.ContactData{
+		{Username: "bob"},
+		{Username: "Team", IsGroup: true, GroupID: "g1"},
+	}}
+	chatWindow := map[string]any{"Me": "alice", "Other": "bob", "ContactIcon": ""}
+
+	tests := []struct {
+		name     string
+		disabled []string
+		calls    bool
+		groups   bool
+	}{
+		{name: "All enabled", calls: true, groups: true},
+		{name: "Calls disabled", disabled: []string{config.FeatureCalls}, groups: true},
+		{name: "Groups disabled", disabled: []string{config.FeatureGroups}, calls: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := html.New("./views", ".html")
+			require.NoError(t, addTemplateFunctions(engine, config.FeaturesConfig{Disabled: tt.disabled}))
+			require.NoError(t, engine.Load())
+
+			var out strings.Builder
+			require.NoError(t, engine.Render(&out, "partials/chat-window", chatWindow))
+			assert.Equal(t, tt.calls, strings.Contains(out.String(), "startCall()\" title"))
+
+			out.Reset()
+			require.NoError(t, engine.Render(&out, "partials/contact-list", contacts))
+			assert.Contains(t, out.String(), "contact-user-bob")
+			assert.Equal(t, tt.groups, strings.Contains(out.String(), "contact-group-g1"))
+		})
+	}
+}
+
+func TestRenderedPartialsGolden(t *testing.T) {
+	engine := html.New("./views", ".html")
+	require.NoError(t, addTemplateFunctions(engine, config.FeaturesConfig{}))
+	renderer := NewTemplateRenderer(engine)
+
+	f := factories.New()
+	alice, bob := f.User("alice"), f.User("bob")
+	group := f.Group("Climbing", "alice", groups.RoleMember, 3)
+
+	edited := f.Message("bob", "alice", "see you at 6")
+	edited.EditedAt = edited.Timestamp + 60
+	deleted := f.Message("alice", "bob", "")
+	deleted.Deleted = true
+	read := f.Message("alice", "bob", "on my way")
+	read.DeliveredAt, read.ReadAt = read.Timestamp+1, read.Timestamp+5
+
+	tracked := f.GroupMessage("bob", group, "who's in for <b>Saturday</b>?")
+	tracked.Tracked = true
+
+	tests := []struct {
+		name     string
+		template string
+		binding  map[string]any
+	}{
+		{
+			name:     "chat-message",
+			template: "partials/chat-message",
+			binding:  map[string]any{"From": "bob", "Me": "alice", "MessageID": "m1", "Content": "<img src=x onerror=alert(1)>"},
+		},
+		{
+			name:     "chat-window",
+			template: "partials/chat-window",
+			binding: map[string]any{
+				"Me":          alice.Username,
+				"Other":       bob.Username,
+				"ContactIcon": bob.Icon.String,
+				"CSRFToken":   "csrf-token",
+				"Messages": []*chat.ChatMessage{
+					f.Message("bob", "alice", "<script>alert('hi')</script>"),
+					edited,
+					deleted,
+					read,
+				},
+			},
+		},
+		{
+			name:     "group-chat-window",
+			template: "partials/group-chat-window",
+			binding: map[string]any{
+				"Username":  alice.Username,
+				"Group":     group,
+				"CSRFToken": "csrf-token",
+				"Messages": []*chat.ChatMessage{
+					f.GroupMessage("alice", group, "hello"),
+					f.GroupMessage("alice", group, "anyone around?"),
+					tracked,
+				},
+			},
+		},
+		{
+			name:     "friends-list",
+			template: "partials/friends-list",
+			binding:  map[string]any{"Friends": []friends.FriendInfo{f.Friend(bob)}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Partials are swapped in by HTMX and sent as single-line SSE data
+			out, err := renderer.RenderToString(tt.template, tt.binding)
+			require.NoError(t, err)
+			golden.Assert(t, tt.name, out)
+
+			line, err := renderer.RenderToSingleLine(tt.template, tt.binding)
+			require.NoError(t, err)
+			golden.Assert(t, tt.name+".sse", line)
+		})
+	}
+}
@@ -0,0 +1,284 @@
+package websocket
+
+import (
+	"context"
+	"exc6/pkg/instance"
+	"exc6/pkg/logger"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Activity states ("typing", "recording a voice message", "uploading an
+// attachment") are ephemeral: a client repeats its state while it lasts and
+// the state expires activityTTL after the last repeat, or at once when the
+// client sends "idle". The manager coalesces states per conversation and
+// sends each conversation one update per activityFlushInterval carrying only
+// the participants whose state changed, so N people typing in a group of M
+// cost M messages per flush instead of N×M per keystroke burst.
+
+const (
+	// MessageTypeActivity carries activity states. From a client it has the
+	// state in Content and the conversation in To or GroupID. To clients it
+	// has Data "states" (username -> state, "idle" when cleared) and
+	// "expires_in" (seconds a state lasts unless repeated).
+	MessageTypeActivity MessageType = "activity"
+
+	ActivityTyping    = "typing"
+	ActivityRecording = "recording"
+	ActivityUploading = "uploading"
+	ActivityIdle      = "idle"
+
+	activityTTL           = 6 * time.Second
+	activityFlushInterval = 500 * time.Millisecond
+	activityTimeout       = 3 * time.Second
+)
+
+// activityStates are the states a client may send
+var activityStates = map[string]bool{
+	ActivityTyping:    true,
+	ActivityRecording: true,
+	ActivityUploading: true,
+	ActivityIdle:      true,
+}
+
+var activityTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "ws_activity_states_total",
+		Help: "Activity states received from clients and conversation updates forwarded after coalescing",
+	},
+	[]string{"stage"}, // received, rejected, forwarded, dropped
+)
+
+func init() {
+	instance.Registerer().MustRegister(activityTotal)
+}
+
+// activityKey identifies a conversation: a group, or a pair of users stored
+// in sorted order so both directions share a key
+type activityKey struct {
+	group string
+	a, b  string
+}
+
+func activityKeyFor(msg *Message) activityKey {
+	if msg.GroupID != "" {
+		return activityKey{group: msg.GroupID}
+	}
+	if msg.From < msg.To {
+		return activityKey{a: msg.From, b: msg.To}
+	}
+	return activityKey{a: msg.To, b: msg.From}
+}
+
+type activityEntry struct {
+	state   string
+	expires time.Time
+	changed bool
+}
+
+// activityUpdate is one conversation's changes since the last flush
+type activityUpdate struct {
+	key    activityKey
+	states map[string]string
+}
+
+// activityBatcher holds the live states of each conversation
+type activityBatcher struct {
+	mu    sync.Mutex
+	convs map[activityKey]map[string]*activityEntry
+}
+
+func newActivityBatcher() *activityBatcher {
+	return &activityBatcher{convs: make(map[activityKey]map[string]*activityEntry)}
+}
+
+// known reports whether the user has a live state in the conversation
+func (b *activityBatcher) known(key activityKey, username string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	_, ok := b.convs[key][username]
+	return ok
+}
+
+// add records a state; repeating the current state only extends it
+func (b *activityBatcher) add(msg *Message, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	key := activityKeyFor(msg)
+	users := b.convs[key]
+	entry, exists := users[msg.From]
+
+	if msg.Content == ActivityIdle {
+		if exists && entry.state != ActivityIdle {
+			entry.state = ActivityIdle
+			entry.changed = true
+		}
+		return
+	}
+
+	if users == nil {
+		users = make(map[string]*activityEntry)
+		b.convs[key] = users
+	}
+	if !exists {
+		entry = &activityEntry{}
+		users[msg.From] = entry
+	}
+	if entry.state != msg.Content {
+		entry.state = msg.Content
+		entry.changed = true
+	}
+	entry.expires = now.Add(activityTTL)
+}
+
+// drain expires stale states and returns the changes of every conversation.
+// Cleared states are reported once as idle and then forgotten.
+func (b *activityBatcher) drain(now time.Time) []activityUpdate {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var updates []activityUpdate
+	for key, users := range b.convs {
+		var states map[string]string
+		for username, entry := range users {
+			if entry.state != ActivityIdle && now.After(entry.expires) {
+				entry.state = ActivityIdle
+				entry.changed = true
+			}
+			if entry.changed {
+				if states == nil {
+					states = make(map[string]string)
+				}
+				states[username] = entry.state
+				entry.changed = false
+			}
+			if entry.state == ActivityIdle {
+				delete(users, username)
+			}
+		}
+
+		if len(users) == 0 {
+			delete(b.convs, key)
+		}
+		if states != nil {
+			updates = append(updates, activityUpdate{key: key, states: states})
+		}
+	}
+
+	return updates
+}
+
+// queueActivity accepts an activity state from a client for the next flush
+func (m *Manager) queueActivity(msg *Message) {
+	if !activityStates[msg.Content] || (msg.To == "") == (msg.GroupID == "") || msg.To == msg.From {
+		activityTotal.WithLabelValues("rejected").Inc()
+		return
+	}
+
+	// Group states are only relayed for members, checked once per burst
+	if msg.GroupID != "" && msg.Content != ActivityIdle && !m.activity.known(activityKeyFor(msg), msg.From) {
+		if !m.isGroupMember(msg.GroupID, msg.From) {
+			activityTotal.WithLabelValues("rejected").Inc()
+			return
+		}
+	}
+
+	m.activity.add(msg, time.Now())
+	activityTotal.WithLabelValues("received").Inc()
+}
+
+func (m *Manager) isGroupMember(groupID, username string) bool {
+	m.mu.RLock()
+	gs := m.groupService
+	m.mu.RUnlock()
+
+	if gs == nil {
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(m.ctx, activityTimeout)
+	defer cancel()
+
+	member, err := gs.IsMember(ctx, groupID, username)
+	if err != nil {
+		logger.WithFields(map[string]any{
+			"group_id": groupID,
+			"username": username,
+			"error":    err.Error(),
+		}).Warn("Failed to check group membership for activity state")
+		return false
+	}
+	return member
+}
+
+func (m *Manager) runActivity() {
+	ticker := time.NewTicker(activityFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			m.flushActivity()
+
+		case <-m.ctx.Done():
+			return
+		}
+	}
+}
+
+// flushActivity sends each conversation with changes one update
+func (m *Manager) flushActivity() {
+	now := time.Now()
+	for _, update := range m.activity.drain(now) {
+		for _, msg := range activityMessages(update, now) {
+			select {
+			case m.broadcast <- msg:
+				activityTotal.WithLabelValues("forwarded").Inc()
+			default:
+				activityTotal.WithLabelValues("dropped").Inc()
+				logger.Warn("Broadcast channel full for activity state")
+			}
+		}
+	}
+}
+
+// activityMessages builds the messages for a conversation update: one to the
+// group, or one to each side of a direct conversation about the other side
+func activityMessages(update activityUpdate, now time.Time) []*Message {
+	message := func(from, to string, states map[string]string) *Message {
+		return &Message{
+			Type:    MessageTypeActivity,
+			From:    from,
+			To:      to,
+			GroupID: update.key.group,
+			Data: map[string]any{
+				"states":     states,
+				"expires_in": int(activityTTL / time.Second),
+			},
+			Timestamp: now.Unix(),
+		}
+	}
+
+	if update.key.group != "" {
+		// Any member in the update authorizes the fan-out to the group
+		var from string
+		for username := range update.states {
+			from = username
+			break
+		}
+		return []*Message{message(from, "", update.states)}
+	}
+
+	var messages []*Message
+	for _, pair := range [][2]string{{update.key.a, update.key.b}, {update.key.b, update.key.a}} {
+		from, to := pair[0], pair[1]
+		if state, ok := update.states[from]; ok {
+			messages = append(messages, message(from, to, map[string]string{from: state}))
+		}
+	}
+	return messages
+}
@@ -0,0 +1,119 @@
+package websocket
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestActivityBatcherCoalesces(t *testing.T) {
+	b := newActivityBatcher()
+	now := time.Now()
+
+	// Repeats within a flush interval collapse into one update per conversation
+	for i := 0; i < 5; i++ {
+		b.add(&Message{From: "alice", GroupID: "g1", Content: ActivityTyping}, now)
+		b.add(&Message{From: "bob", GroupID: "g1", Content: ActivityRecording}, now)
+	}
+	b.add(&Message{From: "alice", To: "carol", Content: ActivityUploading}, now)
+
+	updates := b.drain(now)
+	require.Len(t, updates, 2)
+	for _, u := range updates {
+		if u.key.group == "g1" {
+			assert.Equal(t, map[string]string{"alice": ActivityTyping, "bob": ActivityRecording}, u.states)
+		} else {
+			assert.Equal(t, map[string]string{"alice": ActivityUploading}, u.states)
+		}
+	}
+
+	// Refreshing an unchanged state sends nothing
+	b.add(&Message{From: "alice", GroupID: "g1", Content: ActivityTyping}, now.Add(time.Second))
+	assert.Empty(t, b.drain(now.Add(time.Second)))
+
+	// Explicit idle is reported once, then forgotten
+	b.add(&Message{From: "bob", GroupID: "g1", Content: ActivityIdle}, now.Add(time.Second))
+	updates = b.drain(now.Add(time.Second))
+	require.Len(t, updates, 1)
+	assert.Equal(t, map[string]string{"bob": ActivityIdle}, updates[0].states)
+	assert.False(t, b.known(activityKey{group: "g1"}, "bob"))
+}
+
+func TestActivityBatcherExpires(t *testing.T) {
+	b := newActivityBatcher()
+	now := time.Now()
+
+	b.add(&Message{From: "alice", To: "bob", Content: ActivityTyping}, now)
+	b.drain(now)
+
+	assert.Empty(t, b.drain(now.Add(activityTTL-time.Second)), "still active")
+
+	updates := b.drain(now.Add(activityTTL + time.Second))
+	require.Len(t, updates, 1)
+	assert.Equal(t, map[string]string{"alice": ActivityIdle}, updates[0].states)
+
+	assert.Empty(t, b.drain(now.Add(2*activityTTL)))
+	assert.Empty(t, b.convs)
+}
+
+func TestActivityKeySharedByBothDirections(t *testing.T) {
+	assert.Equal(t,
+		activityKeyFor(&Message{From: "alice", To: "bob"}),
+		activityKeyFor(&Message{From: "bob", To: "alice"}))
+}
+
+func TestActivityMessages(t *testing.T) {
+	now := time.Now()
+
+	t.Run("Direct", func(t *testing.T) {
+		states := map[string]string{"alice": ActivityTyping, "bob": ActivityIdle}
+		msgs := activityMessages(activityUpdate{key: activityKey{a: "alice", b: "bob"}, states: states}, now)
+		require.Len(t, msgs, 2)
+
+		for _, msg := range msgs {
+			assert.Equal(t, MessageTypeActivity, msg.Type)
+			assert.NotEqual(t, msg.From, msg.To)
+			assert.Equal(t, map[string]string{msg.From: states[msg.From]}, msg.Data["states"], "each side only hears about the other")
+		}
+	})
+
+	t.Run("Group", func(t *testing.T) {
+		msgs := activityMessages(activityUpdate{
+			key:    activityKey{group: "g1"},
+			states: map[string]string{"alice": ActivityTyping, "bob": ActivityRecording},
+		}, now)
+		require.Len(t, msgs, 1)
+		assert.Equal(t, "g1", msgs[0].GroupID)
+		assert.Empty(t, msgs[0].To)
+		assert.Contains(t, []string{"alice", "bob"}, msgs[0].From)
+	})
+}
+
+func TestQueueActivity(t *testing.T) {
+	tests := []struct {
+		name   string
+		msg    Message
+		queued bool
+	}{
+		{name: "Direct typing", msg: Message{From: "alice", To: "bob", Content: ActivityTyping}, queued: true},
+		{name: "Unknown state", msg: Message{From: "alice", To: "bob", Content: "dancing"}},
+		{name: "No conversation", msg: Message{From: "alice", Content: ActivityTyping}},
+		{name: "Both recipient and group", msg: Message{From: "alice", To: "bob", GroupID: "g1", Content: ActivityTyping}},
+		{name: "Own conversation", msg: Message{From: "alice", To: "alice", Content: ActivityTyping}},
+		{name: "Group without membership check", msg: Message{From: "alice", GroupID: "g1", Content: ActivityTyping}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &Manager{activity: newActivityBatcher(), mu: &sync.RWMutex{}}
+			msg := tt.msg
+			msg.Type = MessageTypeActivity
+			m.queueActivity(&msg)
+
+			assert.Equal(t, tt.queued, len(m.activity.drain(time.Now())) == 1)
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package websocket
+
+import (
+	"exc6/pkg/instance"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Each client has a bounded send buffer drained by its write pump. When a
+// slow client lets it fill up, the drop policy decides what gives way. Under
+// the disconnect and spill policies a client is disconnected once the drop
+// limit is reached, counting drops since its buffer last took a message.
+
+// DropPolicy says what happens to a message for a client whose send buffer is full
+type DropPolicy string
+
+const (
+	// DropNew discards the message being sent
+	DropNew DropPolicy = "drop-new"
+
+	// DropOldest discards the oldest buffered message to make room
+	DropOldest DropPolicy = "drop-oldest"
+
+	// DropDisconnect closes the connection; the client reconnects and
+	// reloads what it missed
+	DropDisconnect DropPolicy = "disconnect"
+
+	// DropSpill keeps the message in Redis and replays it when the user
+	// reconnects, closing the connection like DropDisconnect
+	DropSpill DropPolicy = "spill"
+
+	// DefaultSendBuffer is the number of messages buffered per client
+	DefaultSendBuffer = 256
+
+	// MessageTypeCapabilities describes the connection to the client. It is
+	// sent on connect and again whenever the client asks for it.
+	MessageTypeCapabilities MessageType = "capabilities"
+)
+
+// ValidDropPolicy reports whether p is a known drop policy
+func ValidDropPolicy(p DropPolicy) bool {
+	return p == DropNew || p == DropOldest || p == DropDisconnect || p == DropSpill
+}
+
+var (
+	sendBufferDrops = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "ws_send_buffer_drops_total",
+			Help: "Messages dropped or connections closed because a client's send buffer was full, by drop policy",
+		},
+		[]string{"policy"},
+	)
+
+	// userDrops has a series per user with drops, removed when the user's
+	// last device disconnects
+	userDrops = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "ws_user_send_buffer_drops_total",
+			Help: "Messages that did not fit a send buffer, by user while connected",
+		},
+		[]string{"username"},
+	)
+)
+
+func init() {
+	instance.Registerer().MustRegister(sendBufferDrops, userDrops)
+}
+
+// SetSendBuffer sets the buffer size and drop policy of clients created
+// afterwards
+func (m *Manager) SetSendBuffer(size int, policy DropPolicy) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sendBuffer = max(size, 1)
+	if ValidDropPolicy(policy) {
+		m.dropPolicy = policy
+	}
+}
+
+// SetDropLimit sets how many drops in a row disconnect clients created
+// afterwards under the disconnect and spill policies
+func (m *Manager) SetDropLimit(limit int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.dropLimit = max(limit, 1)
+}
+
+// enqueue buffers a message for the write pump, applying the drop policy
+// when the buffer is full. It reports whether the message was buffered.
+func (c *Client) enqueue(msg *Message) bool {
+	select {
+	case c.Send <- msg:
+		if c.consecutiveDrops.Load() != 0 {
+			c.consecutiveDrops.Store(0)
+		}
+		return true
+	default:
+	}
+
+	sendBufferDrops.WithLabelValues(string(c.dropPolicy)).Inc()
+	userDrops.WithLabelValues(c.Username).Inc()
+	c.dropped.Add(1)
+	drops := int(c.consecutiveDrops.Add(1))
+
+	switch c.dropPolicy {
+	case DropOldest:
+		select {
+		case <-c.Send:
+		default:
+		}
+		select {
+		case c.Send <- msg:
+			return true
+		default:
+			return false
+		}
+
+	case DropDisconnect, DropSpill:
+		if c.dropPolicy == DropSpill {
+			c.Manager.spill(c.Username, msg)
+		}
+		if drops >= max(c.dropLimit, 1) {
+			c.disconnectSlow("send_buffer_full")
+		}
+		return false
+
+	default:
+		return false
+	}
+}
+
+// capabilities describes the connection, including how full its send
+// buffer is, to help debug slow clients
+func (c *Client) capabilities() *Message {
+	return &Message{
+		Type: MessageTypeCapabilities,
+		Data: map[string]any{
+			"username":        c.Username,
+			"lite":            c.IsLite(),
+			"send_buffer":     cap(c.Send),
+			"send_buffered":   len(c.Send),
+			"send_dropped":    c.dropped.Load(),
+			"drop_policy":     string(c.dropPolicy),
+			"ping_interval_s": int(c.pingInterval() / time.Second),
+		},
+		Timestamp: time.Now().Unix(),
+	}
+}
+
+// SendCapabilities sends the capabilities message to the client
+func (c *Client) SendCapabilities() {
+	c.enqueue(c.capabilities())
+}
@@ -0,0 +1,59 @@
+package websocket
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEnqueueDropPolicy(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   DropPolicy
+		want     []string
+		buffered bool
+	}{
+		{name: "Drop new", policy: DropNew, want: []string{"1", "2"}, buffered: false},
+		{name: "Drop oldest", policy: DropOldest, want: []string{"2", "3"}, buffered: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &Client{Send: make(chan *Message, 2), dropPolicy: tt.policy}
+
+			assert.True(t, client.enqueue(&Message{ID: "1"}))
+			assert.True(t, client.enqueue(&Message{ID: "2"}))
+			assert.Equal(t, tt.buffered, client.enqueue(&Message{ID: "3"}))
+			assert.EqualValues(t, 1, client.dropped.Load())
+
+			close(client.Send)
+			var got []string
+			for msg := range client.Send {
+				got = append(got, msg.ID)
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestEnqueueConsecutiveDrops(t *testing.T) {
+	client := &Client{Username: "alice", Send: make(chan *Message, 1), dropPolicy: DropDisconnect, dropLimit: 3}
+
+	assert.True(t, client.enqueue(&Message{ID: "1"}))
+	assert.False(t, client.enqueue(&Message{ID: "2"}))
+	assert.False(t, client.enqueue(&Message{ID: "3"}))
+	assert.EqualValues(t, 2, client.consecutiveDrops.Load())
+	assert.False(t, client.closing.Load(), "client closed below the drop limit")
+
+	// A message getting through starts the count again
+	<-client.Send
+	assert.True(t, client.enqueue(&Message{ID: "4"}))
+	assert.Zero(t, client.consecutiveDrops.Load())
+	assert.EqualValues(t, 2, client.dropped.Load())
+}
+
+func TestSpillable(t *testing.T) {
+	assert.True(t, spillable(&Message{Type: MessageTypeChat}))
+	assert.False(t, spillable(&Message{Type: MessageTypePing}))
+	assert.False(t, spillable(&Message{Type: MessageTypeTyping}))
+}
@@ -0,0 +1,46 @@
+package websocket
+
+import "exc6/pkg/logger"
+
+// A deployment can turn features off. Messages clients send for them are
+// dropped by the manager instead of being forwarded to other clients.
+
+// CallSignalTypes are the messages clients send to set up and end calls
+var CallSignalTypes = []MessageType{
+	MessageTypeCallOffer,
+	MessageTypeCallAnswer,
+	MessageTypeCallICE,
+	MessageTypeCallRinging,
+	MessageTypeCallEnd,
+	MessageTypeRoomOffer,
+	MessageTypeRoomAnswer,
+	MessageTypeRoomICE,
+}
+
+// GroupMessageTypes are the messages clients send to groups
+var GroupMessageTypes = []MessageType{MessageTypeGroupChat}
+
+// DisableMessageTypes drops messages of these types sent by clients
+func (m *Manager) DisableMessageTypes(types ...MessageType) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.disabledTypes == nil {
+		m.disabledTypes = make(map[MessageType]bool, len(types))
+	}
+	for _, t := range types {
+		m.disabledTypes[t] = true
+	}
+}
+
+// messageTypeDisabled reports whether messages of type t are dropped
+func (m *Manager) messageTypeDisabled(t MessageType) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.disabledTypes[t] {
+		logger.WithField("type", string(t)).Debug("Dropped message of a disabled feature")
+		return true
+	}
+	return false
+}
@@ -0,0 +1,109 @@
+package websocket
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Lite mode is negotiated at connect (?mode=lite) by clients on slow or
+// metered connections, or switched to by the server when pings show a poor
+// connection. Lite clients receive compact JSON with short keys and no avatar
+// data, low-priority updates are batched, and heartbeats are less frequent.
+
+const (
+	// MessageTypeBatch carries several coalesced updates to a lite client
+	MessageTypeBatch MessageType = "batch"
+
+	pingInterval     = 30 * time.Second
+	litePingInterval = 90 * time.Second
+
+	// liteBatchInterval is how long batchable updates wait before being flushed
+	liteBatchInterval = 5 * time.Second
+
+	// liteBatchMax flushes a batch early once it holds this many updates
+	liteBatchMax = 50
+)
+
+// IsLite reports whether the client uses the lite protocol, negotiated at
+// connect or switched to because of a poor connection
+func (c *Client) IsLite() bool {
+	return c.Lite || c.downgraded.Load()
+}
+
+// avatarKeys are Data fields holding sender avatars, dropped for lite clients
+var avatarKeys = []string{"icon", "custom_icon"}
+
+// batchable reports whether a message may be delayed and coalesced for lite
+// clients. Chat messages and call signaling are always sent immediately.
+func batchable(t MessageType) bool {
+	return t == MessageTypeNotification || t == MessageTypeRead || t == MessageTypeDelivered ||
+		t == MessageTypeReaction
+}
+
+// liteMessage is the compact wire form of Message
+type liteMessage struct {
+	Type      MessageType    `json:"t"`
+	ID        string         `json:"i,omitempty"`
+	From      string         `json:"f,omitempty"`
+	To        string         `json:"r,omitempty"`
+	GroupID   string         `json:"g,omitempty"`
+	Content   string         `json:"c,omitempty"`
+	Data      map[string]any `json:"d,omitempty"`
+	Timestamp int64          `json:"s,omitempty"`
+}
+
+// liteBatch is the compact wire form of several batched messages
+type liteBatch struct {
+	Type  MessageType   `json:"t"`
+	Items []liteMessage `json:"m"`
+}
+
+func toLite(msg *Message) liteMessage {
+	lm := liteMessage{
+		Type:      msg.Type,
+		ID:        msg.ID,
+		From:      msg.From,
+		To:        msg.To,
+		GroupID:   msg.GroupID,
+		Content:   msg.Content,
+		Timestamp: msg.Timestamp,
+	}
+
+	for key, value := range msg.Data {
+		if isAvatarKey(key) {
+			continue
+		}
+		if lm.Data == nil {
+			lm.Data = make(map[string]any, len(msg.Data))
+		}
+		lm.Data[key] = value
+	}
+
+	return lm
+}
+
+func isAvatarKey(key string) bool {
+	for _, avatarKey := range avatarKeys {
+		if key == avatarKey {
+			return true
+		}
+	}
+	return false
+}
+
+// encodeLite returns the compact form of a single message
+func encodeLite(msg *Message) ([]byte, error) {
+	return json.Marshal(toLite(msg))
+}
+
+// encodeLiteBatch returns the compact form of a batch of messages
+func encodeLiteBatch(msgs []*Message) ([]byte, error) {
+	batch := liteBatch{
+		Type:  MessageTypeBatch,
+		Items: make([]liteMessage, 0, len(msgs)),
+	}
+	for _, msg := range msgs {
+		batch.Items = append(batch.Items, toLite(msg))
+	}
+	return json.Marshal(batch)
+}
@@ -0,0 +1,146 @@
+package websocket
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	fasthttpws "github.com/fasthttp/websocket"
+	"github.com/gofiber/contrib/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newPumpedClient returns a client whose WritePump writes to a real
+// connection, the other end of that connection, and a function closing Send
+// as the manager does and waiting for the pump to return
+func newPumpedClient(t *testing.T, lite bool) (*Client, *fasthttpws.Conn, func()) {
+	conns := make(chan *fasthttpws.Conn, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upgrader := fasthttpws.Upgrader{}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade: %v", err)
+			return
+		}
+		conns <- conn
+	}))
+	t.Cleanup(server.Close)
+
+	peer, _, err := fasthttpws.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { peer.Close() })
+
+	c := &Client{
+		Username:     "alice",
+		Conn:         &websocket.Conn{Conn: <-conns},
+		Send:         make(chan *Message, 2*liteBatchMax),
+		Manager:      &Manager{delivered: &atomic.Int64{}, deliveries: make(chan delivery, 10)},
+		Lite:         lite,
+		writeOptions: DefaultWriteOptions,
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.WritePump()
+	}()
+	var once sync.Once
+	stop := func() {
+		once.Do(func() { close(c.Send) })
+		<-done
+	}
+	t.Cleanup(stop)
+	return c, peer, stop
+}
+
+// readFrame returns the next frame the peer receives as JSON fields
+func readFrame(t *testing.T, peer *fasthttpws.Conn) map[string]any {
+	t.Helper()
+	peer.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, payload, err := peer.ReadMessage()
+	require.NoError(t, err)
+
+	var frame map[string]any
+	require.NoError(t, json.Unmarshal(payload, &frame))
+	return frame
+}
+
+func TestEncodeLite(t *testing.T) {
+	payload, err := encodeLite(&Message{
+		Type:      MessageTypeChat,
+		ID:        "m1",
+		From:      "bob",
+		Content:   "hi",
+		Data:      map[string]any{"icon": "cat", "custom_icon": "/uploads/a.png", "reply_to": "m0"},
+		Timestamp: 100,
+		Origin:    "instance-1",
+	})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"t":"chat","i":"m1","f":"bob","c":"hi","d":{"reply_to":"m0"},"s":100}`, string(payload))
+
+	// Avatars alone leave no data
+	payload, err = encodeLite(&Message{Type: MessageTypeChat, Data: map[string]any{"icon": "cat"}})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"t":"chat"}`, string(payload))
+
+	payload, err = encodeLiteBatch([]*Message{
+		{Type: MessageTypeRead, From: "bob", Timestamp: 1},
+		{Type: MessageTypeReaction, From: "carol", Content: "👍"},
+	})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"t":"batch","m":[{"t":"read","f":"bob","s":1},{"t":"reaction","f":"carol","c":"👍"}]}`, string(payload))
+}
+
+func TestBatchable(t *testing.T) {
+	assert.True(t, batchable(MessageTypeNotification))
+	assert.True(t, batchable(MessageTypeReaction))
+	assert.False(t, batchable(MessageTypeChat), "chat messages are never delayed")
+	assert.False(t, batchable(MessageTypeGroupChat))
+}
+
+func TestLiteWritePump(t *testing.T) {
+	c, peer, _ := newPumpedClient(t, true)
+
+	// Activity is dropped; chat is sent at once, in compact form
+	c.Send <- &Message{Type: MessageTypeActivity, From: "bob"}
+	c.Send <- &Message{Type: MessageTypeChat, From: "bob", Content: "hi", Data: map[string]any{"icon": "cat"}}
+	frame := readFrame(t, peer)
+	assert.Equal(t, map[string]any{"t": "chat", "f": "bob", "c": "hi"}, frame)
+
+	// Low-priority updates are held until the batch is full
+	for range liteBatchMax - 1 {
+		c.Send <- &Message{Type: MessageTypeNotification, From: "bob"}
+	}
+	c.Send <- &Message{Type: MessageTypeChat, From: "bob", Content: "again"}
+	assert.Equal(t, "again", readFrame(t, peer)["c"], "chat overtakes pending updates")
+
+	c.Send <- &Message{Type: MessageTypeReaction, From: "carol"}
+	frame = readFrame(t, peer)
+	assert.Equal(t, string(MessageTypeBatch), frame["t"])
+	items, _ := frame["m"].([]any)
+	require.Len(t, items, liteBatchMax)
+	assert.Equal(t, "reaction", items[liteBatchMax-1].(map[string]any)["t"])
+}
+
+func TestFullWritePump(t *testing.T) {
+	c, peer, _ := newPumpedClient(t, false)
+
+	c.Send <- &Message{Type: MessageTypeNotification, From: "bob", Data: map[string]any{"icon": "cat"}}
+	frame := readFrame(t, peer)
+	assert.Equal(t, "notification", frame["type"], "sent at once in full form")
+	assert.Equal(t, map[string]any{"icon": "cat"}, frame["data"])
+}
+
+func TestPingInterval(t *testing.T) {
+	c := &Client{}
+	assert.Equal(t, pingInterval, c.pingInterval())
+
+	c.downgraded.Store(true)
+	assert.True(t, c.IsLite(), "a poor connection switches to lite mode")
+	assert.Equal(t, litePingInterval, c.pingInterval())
+}
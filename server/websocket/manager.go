@@ -2,11 +2,13 @@ package websocket
 
 import (
 	"context"
-	"encoding/json"
 	"exc6/apperrors"
+	"exc6/pkg/instance"
 	"exc6/pkg/logger"
 	"exc6/services/groups"
+	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gofiber/contrib/websocket"
@@ -30,6 +32,46 @@ const (
 	MessageTypePing         MessageType = "ping"
 	MessageTypePong         MessageType = "pong"
 
+	// An edited or deleted chat or group message, matched by ID. Edits carry
+	// the new content and data.edited_at; deletes leave a tombstone.
+	MessageTypeEdit   MessageType = "edit"
+	MessageTypeDelete MessageType = "delete"
+
+	// A reaction added to or removed from a chat or group message, matched by
+	// ID. From is the reactor and Content the emoji; data "added" tells which,
+	// and "reactions" lists all reactions to the message.
+	MessageTypeReaction MessageType = "reaction"
+
+	// A direct message pinned or unpinned, matched by ID. From is the user
+	// who changed the pin and data "pinned" tells which.
+	MessageTypePin MessageType = "pin"
+
+	// Call recording consent: a participant's request, the other's answer,
+	// and the recording starting and stopping
+	MessageTypeCallRecordRequest MessageType = "call_record_request"
+	MessageTypeCallRecordConsent MessageType = "call_record_consent"
+	MessageTypeCallRecordStart   MessageType = "call_record_start"
+	MessageTypeCallRecordStop    MessageType = "call_record_stop"
+
+	// Hold/resume and attended transfer. A transfer tells both remaining
+	// participants the new call and which of them places the offer.
+	MessageTypeCallHold     MessageType = "call_hold"
+	MessageTypeCallResume   MessageType = "call_resume"
+	MessageTypeCallTransfer MessageType = "call_transfer"
+
+	// Text sent during a call, delivered to its participants only
+	MessageTypeCallChat MessageType = "call_chat"
+
+	// Changing the media of a call, such as upgrading audio to video: one
+	// participant's request, then the change taking effect or being
+	// declined. Data carries "media" and "constraints", the limits offered
+	// or negotiated; offers and answers for the new media follow as usual.
+	MessageTypeCallMediaRequest MessageType = "call_media_request"
+	MessageTypeCallMediaChange  MessageType = "call_media_change"
+
+	// Maintenance window announcements, sent to every client
+	MessageTypeMaintenance MessageType = "maintenance"
+
 	// Redis Channels
 	PubSubChannelGlobal = "ws:broadcast:global"
 	PubSubPrefixUser    = "ws:user:"
@@ -45,6 +87,7 @@ type Message struct {
 	Content   string         `json:"content,omitempty"`
 	Data      map[string]any `json:"data,omitempty"`
 	Timestamp int64          `json:"timestamp"`
+	Origin    string         `json:"origin,omitempty"` // instance that routed the message through Redis
 }
 
 // Client represents a WebSocket client connection
@@ -55,11 +98,40 @@ type Client struct {
 	Send     chan *Message
 	Manager  *Manager
 	mu       sync.Mutex
+
+	// Lite selects the low-bandwidth protocol; set before the pumps start.
+	// Use IsLite, which includes switches made because of a poor connection.
+	Lite bool
+
+	// downgraded is set by the write pump when it switches a poor connection
+	// to lite mode; quality times pings to decide
+	downgraded atomic.Bool
+	quality    connectionQuality
+
+	connectedAt time.Time
+
+	// dropPolicy applies when Send is full; dropped counts the drops and
+	// consecutiveDrops those since Send last took a message
+	dropPolicy       DropPolicy
+	dropLimit        int
+	dropped          atomic.Int64
+	consecutiveDrops atomic.Int32
+	closing          atomic.Bool
+
+	// writeOptions bound each write; slowWrites counts slow writes in a row
+	writeOptions WriteOptions
+	slowWrites   atomic.Int32
+
+	// typing throttles the client's typing events
+	typing typingThrottle
+
+	// resumes carries the client's resume request to its relay
+	resumes chan []ResumePoint
 }
 
 // Manager manages WebSocket connections
 type Manager struct {
-	clients      map[string]*Client // username -> client
+	clients      *registry
 	Register     chan *Client
 	unRegister   chan *Client
 	broadcast    chan *Message
@@ -68,6 +140,61 @@ type Manager struct {
 	cancel       context.CancelFunc
 	groupService *groups.GroupService
 	rdb          *redis.Client
+
+	// delivered counts messages written to local clients
+	delivered *atomic.Int64
+
+	// receipts coalesces read receipts until the next flush
+	receipts    *receiptBatcher
+	readTracker ReadTracker
+
+	// deliveries queues direct and tracked group messages written to local
+	// clients
+	deliveries      chan delivery
+	deliveryTracker DeliveryTracker
+
+	// activity coalesces activity states per conversation
+	activity *activityBatcher
+
+	typingPublisher TypingPublisher
+
+	callRooms CallRooms
+
+	sessionObserver SessionObserver
+
+	presenceTracker PresenceTracker
+
+	// channelChanges queues subscriptions to the channels of users
+	// connecting and disconnecting
+	channelChanges chan channelChange
+
+	// origin marks the messages this manager publishes, so it skips them
+	// when Redis hands them back; the instance ID
+	origin string
+
+	// disabledTypes are dropped when clients send them
+	disabledTypes map[MessageType]bool
+
+	// sendBuffer, dropPolicy and dropLimit configure new clients' send
+	// buffers
+	sendBuffer int
+	dropPolicy DropPolicy
+	dropLimit  int
+
+	// spills queues messages to park in Redis, and replays of them
+	spills chan spillOp
+
+	// writeOptions bound new clients' writes
+	writeOptions WriteOptions
+
+	// done is closed once the run loop closed every client on shutdown
+	done chan struct{}
+}
+
+// SessionObserver is told how long each connection lasted. It is called from
+// the manager's registration loop and must not block.
+type SessionObserver interface {
+	SessionEnded(username string, duration time.Duration)
 }
 
 // NewManager creates a new WebSocket manager
@@ -75,18 +202,39 @@ func NewManager(ctx context.Context, rdb *redis.Client) *Manager {
 	bgCtx, cancel := context.WithCancel(context.Background())
 
 	m := &Manager{
-		clients:    make(map[string]*Client),
+		clients:    newRegistry(clientShards),
 		Register:   make(chan *Client, 10),
 		unRegister: make(chan *Client, 10),
 		broadcast:  make(chan *Message, 1000),
 		mu:         &sync.RWMutex{},
+		delivered:  &atomic.Int64{},
+		receipts:   newReceiptBatcher(),
+		deliveries: make(chan delivery, deliveryQueueSize),
+		activity:   newActivityBatcher(),
+		sendBuffer: DefaultSendBuffer,
+		dropPolicy: DropNew,
+		dropLimit:  1,
+		spills:     make(chan spillOp, spillQueueSize),
 		ctx:        bgCtx,
 		cancel:     cancel,
 		rdb:        rdb,
+		done:       make(chan struct{}),
+
+		channelChanges: make(chan channelChange, channelChangeQueueSize),
+		origin:         instance.ID(),
+
+		writeOptions: DefaultWriteOptions,
 	}
 
 	go m.run()
-	go m.subscribeToGlobalBroadcast()
+	for i, s := range m.clients.shards {
+		go m.runPings(s, appPingInterval*time.Duration(i)/clientShards)
+	}
+	go m.runReceipts()
+	go m.runDeliveries()
+	go m.runActivity()
+	go m.runPubSub()
+	go m.runSpills()
 	return m
 }
 
@@ -96,10 +244,14 @@ func (m *Manager) SetGroupService(gs *groups.GroupService) {
 	m.groupService = gs
 }
 
-func (m *Manager) run() {
-	ticker := time.NewTicker(30 * time.Second)
-	defer ticker.Stop()
+// SetSessionObserver sets who is told when a connection ends
+func (m *Manager) SetSessionObserver(observer SessionObserver) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sessionObserver = observer
+}
 
+func (m *Manager) run() {
 	for {
 		select {
 		case client := <-m.Register:
@@ -111,98 +263,87 @@ func (m *Manager) run() {
 		case message := <-m.broadcast:
 			m.broadcastMessage(message)
 
-		case <-ticker.C:
-			m.sendPingToAll()
-
 		case <-m.ctx.Done():
 			m.closeAllClients()
+			close(m.done)
 			return
 		}
 	}
 }
 
-// subscribeToGlobalBroadcast listens for messages published by other server instances
-func (m *Manager) subscribeToGlobalBroadcast() {
-	pubsub := m.rdb.Subscribe(m.ctx, PubSubChannelGlobal)
-	defer pubsub.Close()
-
-	ch := pubsub.Channel()
-
-	for {
-		select {
-		case msg := <-ch:
-			if msg == nil {
-				logger.Warn("Redis PubSub channel closed, stopping subscription")
-				return
-			}
-
-			var message Message
-			if err := json.Unmarshal([]byte(msg.Payload), &message); err != nil {
-				logger.WithError(err).Error("Failed to unmarshal redis message")
-				continue
-			}
-			// Route the message locally
-			m.handleRemoteMessage(&message)
-		case <-m.ctx.Done():
-			return
-		}
+// handleRemoteMessage delivers a message received from Redis to the local
+// devices of its recipient, or to every local client when it has none.
+// Group messages arrive once per member since the originating instance
+// resolves the members.
+func (m *Manager) handleRemoteMessage(message *Message) {
+	if message.To == "" {
+		m.BroadcastLocal(message)
+		return
 	}
-}
 
-// handleRemoteMessage attempts to deliver a message received from Redis
-func (m *Manager) handleRemoteMessage(message *Message) {
-	// If it's a direct message, check if user is local
-	if message.To != "" {
-		m.mu.RLock()
-		client, exists := m.clients[message.To]
-		m.mu.RUnlock()
-
-		if exists {
-			select {
-			case client.Send <- message:
-			default:
-				logger.WithField("to", message.To).Warn("Local client buffer full for remote message")
-			}
+	for _, client := range m.clients.get(message.To) {
+		if !client.enqueue(message) {
+			logger.WithField("to", message.To).Warn("Local client buffer full for remote message")
 		}
 	}
-	// Group logic is handled by the instance that originated the broadcast
-	// because getting group members on every instance is expensive.
-	// Alternative: The originator sends individual messages to users via Redis.
 }
 
 func (m *Manager) RegisterClient(client *Client) {
-	m.mu.Lock()
-	defer m.mu.Unlock()
+	// Messages for the user are routed here once their first device connects
+	if !m.clients.has(client.Username) {
+		m.queueChannelChange(client.Username, true)
+	}
 
-	if existingClient, exists := m.clients[client.Username]; exists {
-		existingClient.Close()
+	// A user over the device limit loses their oldest connection
+	if evicted := m.clients.put(client); evicted != nil {
+		evicted.Close()
 	}
 
-	m.clients[client.Username] = client
+	if client.dropPolicy == DropSpill {
+		m.replaySpilled(client)
+	}
 
-	// Optional: Subscribe to user-specific Redis channel for highly scalable architecture
-	// For now, Global Broadcast + Local Check is sufficient for <10k users
+	if tracker := m.presence(); tracker != nil {
+		tracker.Connected(client.Username)
+	}
 
 	logger.WithFields(map[string]any{
 		"username":      client.Username,
-		"total_clients": len(m.clients),
+		"total_clients": m.clients.count(),
 	}).Info("Client Registered")
 }
 
 func (m *Manager) unRegisterClient(client *Client) {
-	m.mu.Lock()
-	defer m.mu.Unlock()
+	removed, last := m.clients.remove(client)
+	if !removed {
+		return
+	}
+	close(client.Send)
 
-	if existingClient, exists := m.clients[client.Username]; exists {
-		if existingClient.ID == client.ID {
-			delete(m.clients, client.Username)
-			close(client.Send)
-		}
+	m.mu.RLock()
+	observer := m.sessionObserver
+	m.mu.RUnlock()
+
+	if observer != nil {
+		observer.SessionEnded(client.Username, time.Since(client.connectedAt))
+	}
+	// The user stays online while another of their devices is connected
+	if !last {
+		return
+	}
+	userDrops.DeleteLabelValues(client.Username)
+	m.queueChannelChange(client.Username, false)
+	if tracker := m.presence(); tracker != nil {
+		tracker.Disconnected(client.Username)
 	}
 }
 
 // broadcastMessage sends a message to specific recipients
 func (m *Manager) broadcastMessage(message *Message) {
+	if m.messageTypeDisabled(message.Type) {
+		return
+	}
+
 	// 1. Handle Direct Messages
 	if message.To != "" {
 		m.sendDirectMessage(message)
@@ -216,20 +357,15 @@ func (m *Manager) broadcastMessage(message *Message) {
 }
 
 func (m *Manager) sendDirectMessage(message *Message) {
-	m.mu.RLock()
-	client, isLocal := m.clients[message.To]
-	m.mu.RUnlock()
-
-	if isLocal {
-		select {
-		case client.Send <- message:
-		default:
+	for _, client := range m.clients.get(message.To) {
+		if !client.enqueue(message) {
 			logger.WithField("to", message.To).Warn("Client buffer full")
 		}
-	} else {
-		// Publish to Redis if not local
-		m.publishToRedis(message)
 	}
+
+	// The recipient may have other devices on other instances
+	routed := *message
+	m.publishToRedis(&routed)
 }
 
 // Optimized Group Broadcast (O(M) instead of O(N))
@@ -245,76 +381,54 @@ func (m *Manager) sendGroupMessage(message *Message) {
 		return
 	}
 
-	// One lock to get all local clients
-	m.mu.RLock()
+	// Look up local clients shard by shard
 	localClients := make([]*Client, 0, len(members))
-	remoteUsers := make([]string, 0)
-
 	for _, member := range members {
-		if client, exists := m.clients[member.Username]; exists {
-			localClients = append(localClients, client)
-		} else {
-			remoteUsers = append(remoteUsers, member.Username)
-		}
+		localClients = append(localClients, m.clients.get(member.Username)...)
 	}
-	m.mu.RUnlock()
 
 	// Send to local clients without holding lock
 	for _, client := range localClients {
-		select {
-		case client.Send <- message:
-		default:
-		}
-	}
-
-	// Batch publish to Redis for remote users
-	if len(remoteUsers) > 0 {
-		m.batchPublishToRedis(message, remoteUsers)
+		client.enqueue(message)
 	}
-}
-
-func (m *Manager) batchPublishToRedis(message *Message, remoteUsers []string) {
-	// For each remote user, publish a targeted message to their user-specific channel
-	// or use the global channel with the 'To' field set
-	for _, username := range remoteUsers {
-		userMessage := *message // Copy the message
-		userMessage.To = username
-
-		payload, err := json.Marshal(userMessage)
-		if err != nil {
-			logger.WithError(err).Error("Failed to marshal message for Redis")
-			continue
-		}
 
-		// Publish to global channel
-		if err := m.rdb.Publish(m.ctx, PubSubChannelGlobal, payload).Err(); err != nil {
-			logger.WithError(err).WithField("user", username).Warn("Failed to publish to Redis")
-		}
+	// Every member may have devices elsewhere, so each gets a copy on their
+	// own channel
+	for _, member := range members {
+		userMessage := *message
+		userMessage.To = member.Username
+		m.publishToRedis(&userMessage)
 	}
 }
 
+// publishToRedis routes a message to the instances its recipient is
+// connected to
 func (m *Manager) publishToRedis(message *Message) {
-	payload, _ := json.Marshal(message)
-	m.rdb.Publish(m.ctx, PubSubChannelGlobal, payload)
+	if err := m.publish(message); err != nil {
+		logger.WithError(err).WithField("to", message.To).Warn("Failed to publish to Redis")
+	}
 }
 
+// SendToUser sends a message to every device of the user: directly to those
+// connected here, and on the user's channel to those on other instances. It
+// fails only when no local device took it and it could not be published.
 func (m *Manager) SendToUser(username string, message *Message) error {
-	m.mu.RLock()
-	client, exists := m.clients[username]
-	m.mu.RUnlock()
-
-	if exists {
-		select {
-		case client.Send <- message:
-			return nil
-		default:
-			return apperrors.New(apperrors.ErrCodeInternal, "Buffer full", 500)
+	accepted := false
+	for _, client := range m.clients.get(username) {
+		if client.enqueue(message) {
+			accepted = true
 		}
 	}
 
-	// User not local, try Redis
-	message.To = username
-	m.publishToRedis(message)
+	// The local devices hold message, so the routed copy is a new one
+	routed := *message
+	routed.To = username
+	if err := m.publish(&routed); err != nil {
+		logger.WithError(err).WithField("to", username).Warn("Failed to publish to Redis")
+		if !accepted {
+			return apperrors.New(apperrors.ErrCodeInternal, "Failed to deliver message", 500)
+		}
+	}
 	return nil
 }
 
@@ -328,86 +442,94 @@ func (m *Manager) BroadcastToGroup(groupID string, message *Message) {
 	}
 }
 
-// sendPingToAll sends ping to all connected clients
-func (m *Manager) sendPingToAll() {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
+// BroadcastLocal sends a message to every client connected to this
+// instance. Callers run it on each instance rather than relaying through Redis.
+func (m *Manager) BroadcastLocal(message *Message) {
+	m.clients.each(func(client *Client) {
+		if !client.enqueue(message) {
+			logger.WithField("username", client.Username).Warn("Could not send broadcast, buffer full")
+		}
+	})
+}
 
+// sendPings sends ping to the shard's connected clients
+func (m *Manager) sendPings(s *clientShard) {
 	ping := &Message{
 		Type:      MessageTypePing,
 		Timestamp: time.Now().Unix(),
 	}
 
-	for username, client := range m.clients {
-		select {
-		case client.Send <- ping:
-		default:
-			logger.WithField("username", username).Warn("Could not send ping, buffer full")
+	s.each(func(client *Client) {
+		// Lite clients rely on the less frequent protocol-level pings
+		if client.IsLite() {
+			return
 		}
-	}
-}
 
-func (m *Manager) IsUserOnline(username string) bool {
-	// Note: This only checks LOCAL online status.
-	// For distributed checking, you'd need to query Redis keys (e.g., SET "users:online" "username")
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-	_, exists := m.clients[username]
-	return exists
+		if !client.enqueue(ping) {
+			logger.WithField("username", client.Username).Warn("Could not send ping, buffer full")
+		}
+	})
 }
 
 // GetOnlineUsers returns list of online usernames
 func (m *Manager) GetOnlineUsers() []string {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
+	return m.clients.usernames()
+}
 
-	users := make([]string, 0, len(m.clients))
-	for username := range m.clients {
-		users = append(users, username)
-	}
-	return users
+// ClientCount returns the number of clients connected to this instance
+func (m *Manager) ClientCount() int {
+	return m.clients.count()
 }
 
 // closeAllClients closes all client connections
 func (m *Manager) closeAllClients() {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-
-	for _, client := range m.clients {
+	for _, client := range m.clients.clear() {
 		client.Close()
 	}
-	m.clients = make(map[string]*Client)
 }
 
-// Close shuts down the manager
+// Close shuts down the manager, closing every client connection
 func (m *Manager) Close() {
 	m.cancel()
-	close(m.Register)
-	close(m.unRegister)
-	close(m.broadcast)
+	<-m.done
 }
 
 // NewClient creates a new WebSocket client
 func NewClient(username string, conn *websocket.Conn, manager *Manager) *Client {
+	manager.mu.RLock()
+	size, policy, dropLimit, writeOptions := manager.sendBuffer, manager.dropPolicy, manager.dropLimit, manager.writeOptions
+	manager.mu.RUnlock()
+
 	return &Client{
 		ID:       uuid.NewString(),
 		Username: username,
 		Conn:     conn,
-		Send:     make(chan *Message, 256),
+		Send:     make(chan *Message, size),
 		Manager:  manager,
+
+		connectedAt:  time.Now(),
+		resumes:      make(chan []ResumePoint, 1),
+		dropPolicy:   policy,
+		dropLimit:    dropLimit,
+		writeOptions: writeOptions,
 	}
 }
 
 // ReadPump reads messages from the WebSocket connection
 func (c *Client) ReadPump() {
 	defer func() {
-		c.Manager.unRegister <- c
+		// Once the manager is closed nobody unregisters clients anymore
+		select {
+		case c.Manager.unRegister <- c:
+		case <-c.Manager.ctx.Done():
+		}
 		c.Conn.Close()
 	}()
 
-	c.Conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+	c.Conn.SetReadDeadline(c.readDeadline())
 	c.Conn.SetPongHandler(func(string) error {
-		c.Conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+		c.observePong(time.Now())
+		c.Conn.SetReadDeadline(c.readDeadline())
 		return nil
 	})
 
@@ -422,7 +544,12 @@ func (c *Client) ReadPump() {
 		}
 
 		msg.From = c.Username
-		msg.Timestamp = time.Now().Unix()
+
+		// A read receipt may name the read message's send time; the
+		// tracker keeps it from running ahead of the clock
+		if msg.Type != MessageTypeRead || msg.Timestamp <= 0 {
+			msg.Timestamp = time.Now().Unix()
+		}
 
 		// Handle different message types
 		c.handleMessage(&msg)
@@ -431,11 +558,19 @@ func (c *Client) ReadPump() {
 
 // WritePump writes messages to the WebSocket connection
 func (c *Client) WritePump() {
-	ticker := time.NewTicker(30 * time.Second)
+	ticker := time.NewTicker(c.pingInterval())
+
+	// Batched updates for lite clients, flushed by batchTimer
+	var pending []*Message
+	batchTimer := time.NewTimer(liteBatchInterval)
+	batchTimer.Stop()
+
 	defer func() {
 		ticker.Stop()
+		batchTimer.Stop()
 
-		// Recover from panics to prevent server crash during connection storms
+		// Dead and slow connections end the pump through write errors; this
+		// is a last resort
 		if r := recover(); r != nil {
 			logger.WithFields(map[string]any{
 				"username": c.Username,
@@ -451,6 +586,27 @@ func (c *Client) WritePump() {
 	for {
 		select {
 		case message, ok := <-c.Send:
+			if ok && c.IsLite() && message.Type == MessageTypeActivity {
+				// Activity states would expire before a batch is flushed
+				continue
+			}
+			if ok && c.IsLite() && batchable(message.Type) {
+				pending = append(pending, message)
+				if len(pending) == 1 {
+					batchTimer.Reset(liteBatchInterval)
+				}
+				if len(pending) < liteBatchMax {
+					continue
+				}
+
+				batchTimer.Stop()
+				if err := c.flush(pending); err != nil {
+					return
+				}
+				pending = nil
+				continue
+			}
+
 			// Add mutex lock around write operations
 			c.mu.Lock()
 			if c.Conn == nil {
@@ -458,16 +614,15 @@ func (c *Client) WritePump() {
 				return
 			}
 
-			// This SetWriteDeadline is often where the panic happens if connection is dead
-			c.Conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
-
 			if !ok {
 				// The channel was closed by the manager
+				c.Conn.SetWriteDeadline(time.Now().Add(c.writeOptions.Timeout))
 				c.Conn.WriteMessage(websocket.CloseMessage, []byte{})
+				c.mu.Unlock()
 				return
 			}
 
-			err := c.Conn.WriteJSON(message)
+			err := c.timedWrite(func() error { return c.write(message) })
 			c.mu.Unlock()
 			if err != nil {
 				// Log at debug level to avoid spamming logs during load tests
@@ -475,26 +630,126 @@ func (c *Client) WritePump() {
 				return
 			}
 
+		case <-batchTimer.C:
+			if err := c.flush(pending); err != nil {
+				return
+			}
+			pending = nil
+
 		case <-ticker.C:
 			// Safety check before setting deadline
 			if c.Conn == nil {
 				return
 			}
 
-			c.Conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
-			if err := c.Conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+			switched, err := c.reportQuality()
+			if err != nil {
+				return
+			}
+			if switched {
+				ticker.Reset(litePingInterval)
+			}
+
+			c.quality.pinged(time.Now())
+			c.mu.Lock()
+			err = c.timedWrite(func() error { return c.Conn.WriteMessage(websocket.PingMessage, nil) })
+			c.mu.Unlock()
+			if err != nil {
 				return
 			}
 		}
 	}
 }
 
+// readDeadline is when the connection is considered dead unless the client
+// sends something, a pong at the latest. It follows the ping interval, which
+// grows when the connection switches to lite mode.
+func (c *Client) readDeadline() time.Time {
+	return time.Now().Add(2 * c.pingInterval())
+}
+
+// pingInterval is how often protocol-level pings are sent
+func (c *Client) pingInterval() time.Duration {
+	if c.IsLite() {
+		return litePingInterval
+	}
+	return pingInterval
+}
+
+// write sends a single message in the client's protocol
+func (c *Client) write(message *Message) error {
+	var err error
+	if c.IsLite() {
+		var payload []byte
+		if payload, err = encodeLite(message); err != nil {
+			return err
+		}
+		err = c.Conn.WriteMessage(websocket.TextMessage, payload)
+	} else {
+		err = c.Conn.WriteJSON(message)
+	}
+
+	if err == nil {
+		c.Manager.delivered.Add(1)
+		if isTracked(message) || isDelivery(c.Username, message) {
+			c.Manager.trackDelivery(c.Username, message)
+		}
+	}
+	return err
+}
+
+// flush writes batched updates to a lite client
+func (c *Client) flush(messages []*Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.Conn == nil {
+		return net.ErrClosed
+	}
+
+	if err := c.timedWrite(func() error { return c.writeBatch(messages) }); err != nil {
+		logger.WithField("user", c.Username).Debug("WebSocket write error (client likely disconnected)")
+		return err
+	}
+	return nil
+}
+
+// writeBatch sends coalesced updates to a lite client as one frame
+func (c *Client) writeBatch(messages []*Message) error {
+	if len(messages) == 1 {
+		return c.write(messages[0])
+	}
+
+	payload, err := encodeLiteBatch(messages)
+	if err != nil {
+		return err
+	}
+	if err := c.Conn.WriteMessage(websocket.TextMessage, payload); err != nil {
+		return err
+	}
+
+	c.Manager.delivered.Add(int64(len(messages)))
+	for _, message := range messages {
+		if isTracked(message) || isDelivery(c.Username, message) {
+			c.Manager.trackDelivery(c.Username, message)
+		}
+	}
+	return nil
+}
+
 // handleMessage processes incoming messages
 func (c *Client) handleMessage(msg *Message) {
 	switch msg.Type {
 	case MessageTypePong:
 		// Pong received, connection is alive
 
+	case MessageTypeCapabilities:
+		c.SendCapabilities()
+
 	case MessageTypeChat, MessageTypeGroupChat:
 		// Forward to broadcast channel
 		select {
@@ -503,6 +758,22 @@ func (c *Client) handleMessage(msg *Message) {
 			logger.Warn("Broadcast channel full")
 		}
 
+	case MessageTypeRead:
+		// Coalesced with other receipts for the conversation before delivery
+		c.Manager.queueReceipt(msg)
+
+	case MessageTypeActivity:
+		// Coalesced per conversation and expired by the manager
+		c.Manager.queueActivity(msg)
+
+	case MessageTypeTyping:
+		// Throttled per client and published to the other participants
+		c.handleTyping(msg)
+
+	case MessageTypeResume:
+		// Replayed by the connection's relay
+		c.queueResume(msg)
+
 	case MessageTypeCallOffer, MessageTypeCallAnswer, MessageTypeCallICE, MessageTypeCallRinging, MessageTypeCallEnd:
 		// Forward call signaling messages
 		select {
@@ -510,6 +781,10 @@ func (c *Client) handleMessage(msg *Message) {
 		default:
 			logger.Warn("Broadcast channel full for call signal")
 		}
+
+	case MessageTypeRoomOffer, MessageTypeRoomAnswer, MessageTypeRoomICE:
+		// Relayed between participants of a call room
+		c.handleRoomSignal(msg)
 	}
 }
 
@@ -518,13 +793,11 @@ func (c *Client) SendMessage(msg *Message) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	select {
-	case c.Send <- msg:
-		return nil
-	default:
+	if !c.enqueue(msg) {
 		logger.Error("Client send buffer full")
 		return apperrors.New(apperrors.ErrCodeInternal, "Client send buffer full", 500)
 	}
+	return nil
 }
 
 // Close closes the client connection
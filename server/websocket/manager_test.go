@@ -0,0 +1,58 @@
+package websocket
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	fasthttpws "github.com/fasthttp/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWritePumpClosedReleasesLock(t *testing.T) {
+	c, peer, stop := newPumpedClient(t, false)
+	stop()
+
+	peer.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, _, err := peer.ReadMessage()
+	assert.True(t, fasthttpws.IsCloseError(err, fasthttpws.CloseNoStatusReceived), "got %v", err)
+
+	// Writers that raced the close, such as a quality report, must not hang
+	require.True(t, c.mu.TryLock(), "the pump returned holding the client lock")
+	c.mu.Unlock()
+}
+
+func FuzzMessageDecode(f *testing.F) {
+	f.Add([]byte(`{"type":"chat","to":"bob","content":"hi"}`))
+	f.Add([]byte(`{"type":"group_chat","group_id":"g1","content":"hi","data":{"icon":"x","n":[1,{"a":null}]}}`))
+	f.Add([]byte(`{"type":"call_offer","to":"bob","data":{"sdp":"v=0"}}`))
+	f.Add([]byte(`{"type":"room_offer","to":"bob","data":{"sdp":"v=0"}}`))
+	f.Add([]byte(`{"type":"pong"}`))
+	f.Add([]byte(`{"type":"resume","data":{"conversations":[{"with":"bob","last_id":"m1","since":1700000000},{"group_id":"g1","since":"x"}]}}`))
+	f.Add([]byte(`{"type":1,"data":"x"}`))
+	f.Add([]byte(`null`))
+
+	f.Fuzz(func(t *testing.T, payload []byte) {
+		var msg Message
+		if err := json.Unmarshal(payload, &msg); err != nil {
+			return
+		}
+
+		// What ReadPump does with every decoded message
+		msg.From = "alice"
+		client := &Client{
+			Username: "alice",
+			Manager:  &Manager{broadcast: make(chan *Message, 1), receipts: newReceiptBatcher()},
+		}
+		client.handleMessage(&msg)
+
+		// Everything a client sends must be re-encodable for delivery
+		_, err := json.Marshal(&msg)
+		require.NoError(t, err)
+		_, err = encodeLite(&msg)
+		require.NoError(t, err)
+		_, err = encodeLiteBatch([]*Message{&msg, &msg})
+		require.NoError(t, err)
+	})
+}
@@ -0,0 +1,21 @@
+package websocket
+
+import "exc6/services/notifications"
+
+// PushNotification delivers a new inbox entry to username's connection on
+// any instance, with their unread count for the badge
+func (m *Manager) PushNotification(username string, n *notifications.Notification, unread int64) {
+	m.SendToUser(username, &Message{
+		Type:    MessageTypeNotification,
+		From:    n.From,
+		To:      username,
+		GroupID: n.Data["group_id"],
+		Content: n.Content,
+		Data: map[string]any{
+			"notification_id":   n.ID,
+			"notification_type": n.Type,
+			"unread":            unread,
+		},
+		Timestamp: n.Timestamp,
+	})
+}
@@ -0,0 +1,78 @@
+package websocket
+
+import (
+	"context"
+	"exc6/pkg/logger"
+	"time"
+)
+
+// A user's presence spans instances: the manager reports each connection
+// opening and closing to a PresenceTracker, which keeps the cluster-wide
+// view in Redis and tells friends when the user goes online or offline.
+
+const (
+	// MessageTypePresence tells a client that a friend went online or
+	// offline. From is the friend; data "online" (bool) and "last_seen"
+	// (unix seconds).
+	MessageTypePresence MessageType = "presence"
+
+	presenceTimeout = time.Second
+)
+
+// PresenceTracker keeps which users are connected to any instance.
+// Connected and Disconnected are called from the manager's registration loop
+// and must not block.
+type PresenceTracker interface {
+	Connected(username string)
+	Disconnected(username string)
+	IsOnline(ctx context.Context, username string) (bool, error)
+}
+
+// SetPresenceTracker sets who is told when users connect and disconnect
+func (m *Manager) SetPresenceTracker(tracker PresenceTracker) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.presenceTracker = tracker
+}
+
+// presence returns the presence tracker, nil when none is set
+func (m *Manager) presence() PresenceTracker {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.presenceTracker
+}
+
+// IsUserOnline reports whether the user is connected to this or, when a
+// presence tracker is set, any other instance
+func (m *Manager) IsUserOnline(username string) bool {
+	if m.clients.has(username) {
+		return true
+	}
+
+	tracker := m.presence()
+	if tracker == nil {
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(m.ctx, presenceTimeout)
+	defer cancel()
+
+	online, err := tracker.IsOnline(ctx, username)
+	if err != nil {
+		logger.WithError(err).WithField("username", username).Debug("Failed to check presence")
+		return false
+	}
+	return online
+}
+
+// SendLocal sends a message to the user's devices connected to this
+// instance, reporting whether there were any
+func (m *Manager) SendLocal(username string, message *Message) bool {
+	clients := m.clients.get(username)
+	for _, client := range clients {
+		if !client.enqueue(message) {
+			logger.WithField("username", username).Warn("Could not send message, buffer full")
+		}
+	}
+	return len(clients) > 0
+}
@@ -0,0 +1,332 @@
+package websocket
+
+import (
+	"encoding/json"
+	"exc6/pkg/instance"
+	"exc6/pkg/logger"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/redis/go-redis/v9"
+)
+
+// A user's devices may be connected to several instances, so besides
+// writing a message to the recipient's devices connected here an instance
+// always publishes it through Redis Pub/Sub. Each instance subscribes to the
+// channel of every user connected to it, ws:user:<name>, when their first
+// device registers and unsubscribes when the last one leaves, so a message is
+// only decoded by the instances its recipient is connected to. The global
+// channel carries messages for every connected client.
+//
+// A message published while a user's first device is still subscribing is
+// missed; clients load the conversation history when they connect.
+//
+// When the subscription's connection fails, the manager subscribes again to
+// the global channel and those of its users, backing off between attempts.
+// Messages published in the meantime are lost, so once it is back every local
+// client is sent a resync message and reloads what changed since the gap
+// began.
+
+const (
+	// channelChangeQueueSize bounds the subscriptions waiting to be sent to
+	// Redis
+	channelChangeQueueSize = 1024
+
+	// pubsubMinBackoff doubles after each failed attempt to subscribe, up to
+	// pubsubMaxBackoff
+	pubsubMinBackoff = 500 * time.Millisecond
+	pubsubMaxBackoff = 30 * time.Second
+
+	// pubsubHealthInterval is how often the subscription's connection is
+	// pinged
+	pubsubHealthInterval = 15 * time.Second
+)
+
+// MessageTypeResync tells a client this instance missed messages routed
+// from other instances; data.since is the unix time the gap began
+const MessageTypeResync MessageType = "resync"
+
+var (
+	pubsubUserChannels = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "ws_pubsub_user_channels",
+			Help: "User channels this instance is subscribed to",
+		},
+	)
+
+	pubsubMessages = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "ws_pubsub_messages_total",
+			Help: "Messages routed between instances through Redis Pub/Sub",
+		},
+		[]string{"channel", "direction"}, // channel: user, global; direction: published, received
+	)
+
+	pubsubHealthy = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "ws_pubsub_healthy",
+			Help: "1 while this instance is subscribed to Redis Pub/Sub, 0 while it reconnects",
+		},
+	)
+
+	pubsubReconnects = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "ws_pubsub_reconnects_total",
+			Help: "Times the Redis Pub/Sub subscription was restored after its connection failed",
+		},
+	)
+)
+
+func init() {
+	instance.Registerer().MustRegister(pubsubUserChannels, pubsubMessages, pubsubHealthy, pubsubReconnects)
+}
+
+// UserChannel is the Pub/Sub channel of messages for a user's devices
+func UserChannel(username string) string {
+	return PubSubPrefixUser + username
+}
+
+// channelFor returns the channel a message is published on and its kind
+// for metrics: the recipient's channel, or the global one for broadcasts
+func channelFor(message *Message) (channel, kind string) {
+	if message.To != "" {
+		return UserChannel(message.To), "user"
+	}
+	return PubSubChannelGlobal, "global"
+}
+
+// channelChange subscribes to or unsubscribes from a user's channel
+type channelChange struct {
+	username  string
+	subscribe bool
+}
+
+// queueChannelChange asks the subscriber to follow a user's channel or stop.
+// Changes are applied in order, so a quick reconnect cannot leave the user
+// unsubscribed.
+func (m *Manager) queueChannelChange(username string, subscribe bool) {
+	select {
+	case m.channelChanges <- channelChange{username: username, subscribe: subscribe}:
+	case <-m.ctx.Done():
+	}
+}
+
+// runPubSub receives messages published by other instances and keeps the
+// subscriptions to the channels of local users, subscribing again whenever
+// the connection fails
+func (m *Manager) runPubSub() {
+	defer pubsubHealthy.Set(0)
+
+	var lostAt time.Time
+	backoff := pubsubMinBackoff
+	for {
+		pubsub, users, err := m.subscribe()
+		if err == nil {
+			pubsubHealthy.Set(1)
+			if !lostAt.IsZero() {
+				pubsubReconnects.Inc()
+				logger.WithField("gap", time.Since(lostAt).Round(time.Millisecond).String()).Info("Redis PubSub subscription restored")
+				m.resync(lostAt)
+			}
+			backoff = pubsubMinBackoff
+
+			lostAt = m.receive(pubsub, users)
+			pubsub.Close()
+			pubsubHealthy.Set(0)
+			if m.ctx.Err() != nil {
+				return
+			}
+		} else {
+			logger.WithError(err).WithField("retry_in", backoff.String()).Warn("Failed to subscribe to Redis PubSub")
+			if lostAt.IsZero() {
+				lostAt = time.Now()
+			}
+		}
+
+		if !m.waitToResubscribe(backoff) {
+			return
+		}
+		backoff = min(backoff*2, pubsubMaxBackoff)
+	}
+}
+
+// subscribe subscribes to the global channel and those of the users
+// connected here, and returns the users subscribed to
+func (m *Manager) subscribe() (*redis.PubSub, map[string]bool, error) {
+	users := make(map[string]bool)
+	channels := []string{PubSubChannelGlobal}
+	for _, username := range m.clients.usernames() {
+		users[username] = true
+		channels = append(channels, UserChannel(username))
+	}
+
+	// Subscribe does not wait for Redis; the first confirmation shows the
+	// connection works
+	pubsub := m.rdb.Subscribe(m.ctx, channels...)
+	if _, err := pubsub.Receive(m.ctx); err != nil {
+		pubsub.Close()
+		return nil, nil, err
+	}
+
+	pubsubUserChannels.Set(float64(len(users)))
+	return pubsub, users, nil
+}
+
+// receive routes messages from a subscription and applies channel changes
+// until its connection fails, and returns when it was last known to work.
+// The client reconnects by itself after failed reads; the health check
+// catches a connection it cannot restore.
+func (m *Manager) receive(pubsub *redis.PubSub, users map[string]bool) time.Time {
+	ch := pubsub.ChannelWithSubscriptions()
+
+	ticker := time.NewTicker(pubsubHealthInterval)
+	defer ticker.Stop()
+
+	lastSeen := time.Now()
+	for {
+		select {
+		case change := <-m.channelChanges:
+			m.applyChannelChange(pubsub, users, change)
+
+		case msg, ok := <-ch:
+			if !ok {
+				logger.Warn("Redis PubSub channel closed, subscribing again")
+				return lastSeen
+			}
+			switch msg := msg.(type) {
+			case *redis.Message:
+				m.handlePubSubMessage(msg)
+			case *redis.Subscription:
+				// Confirmations arrive again when the client resubscribes
+				// after reconnecting by itself
+				if msg.Kind == "subscribe" && msg.Channel == PubSubChannelGlobal {
+					pubsubReconnects.Inc()
+					m.resync(lastSeen)
+				}
+			}
+			lastSeen = time.Now()
+
+		case <-ticker.C:
+			if err := pubsub.Ping(m.ctx); err != nil {
+				logger.WithError(err).Warn("Redis PubSub health check failed, subscribing again")
+				return lastSeen
+			}
+			lastSeen = time.Now()
+
+		case <-m.ctx.Done():
+			return lastSeen
+		}
+	}
+}
+
+// waitToResubscribe waits before subscribing again and reports whether the
+// manager is still running. Channel changes queued meanwhile are dropped, as
+// subscribing again follows the users connected by then.
+func (m *Manager) waitToResubscribe(backoff time.Duration) bool {
+	timer := time.NewTimer(backoff)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-m.channelChanges:
+		case <-timer.C:
+			return true
+		case <-m.ctx.Done():
+			return false
+		}
+	}
+}
+
+// applyChannelChange subscribes to or unsubscribes from a user's channel.
+// users tracks the subscriptions, as a change queued before subscribing again
+// may already be applied.
+func (m *Manager) applyChannelChange(pubsub *redis.PubSub, users map[string]bool, change channelChange) {
+	if users[change.username] == change.subscribe {
+		return
+	}
+	channel := UserChannel(change.username)
+
+	if change.subscribe {
+		if err := pubsub.Subscribe(m.ctx, channel); err != nil {
+			logger.WithError(err).WithField("username", change.username).Warn("Failed to subscribe to user channel")
+			return
+		}
+	} else if err := pubsub.Unsubscribe(m.ctx, channel); err != nil {
+		logger.WithError(err).WithField("username", change.username).Warn("Failed to unsubscribe from user channel")
+		return
+	}
+
+	if change.subscribe {
+		users[change.username] = true
+	} else {
+		delete(users, change.username)
+	}
+	pubsubUserChannels.Set(float64(len(users)))
+}
+
+// resync tells every local client to reload what changed since messages
+// from other instances stopped arriving
+func (m *Manager) resync(since time.Time) {
+	m.BroadcastLocal(&Message{
+		Type:      MessageTypeResync,
+		Data:      map[string]any{"since": since.Unix()},
+		Timestamp: time.Now().Unix(),
+	})
+}
+
+// handlePubSubMessage delivers a message published by another instance
+func (m *Manager) handlePubSubMessage(msg *redis.Message) {
+	kind := "user"
+	if msg.Channel == PubSubChannelGlobal {
+		kind = "global"
+	}
+	pubsubMessages.WithLabelValues(kind, "received").Inc()
+
+	var message Message
+	if err := json.Unmarshal([]byte(msg.Payload), &message); err != nil {
+		logger.WithError(err).Error("Failed to unmarshal redis message")
+		return
+	}
+
+	// Messages we published ourselves were already delivered to the devices
+	// connected here
+	if message.Origin == m.origin {
+		return
+	}
+
+	logger.WithFields(map[string]any{
+		"origin":  message.Origin,
+		"channel": msg.Channel,
+		"to":      message.To,
+		"type":    message.Type,
+	}).Debug("Received routed WebSocket message")
+
+	m.handleRemoteMessage(&message)
+}
+
+// publish sends a message to the other instances
+func (m *Manager) publish(message *Message) error {
+	message.Origin = m.origin
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	channel, kind := channelFor(message)
+	if err := m.rdb.Publish(m.ctx, channel, payload).Err(); err != nil {
+		return err
+	}
+	pubsubMessages.WithLabelValues(kind, "published").Inc()
+	return nil
+}
+
+// Broadcast sends a message to every client connected to any instance
+func (m *Manager) Broadcast(message *Message) {
+	m.BroadcastLocal(message)
+
+	global := *message
+	global.To, global.GroupID = "", ""
+	if err := m.publish(&global); err != nil {
+		logger.WithError(err).Warn("Failed to publish broadcast to Redis")
+	}
+}
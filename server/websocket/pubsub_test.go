@@ -0,0 +1,183 @@
+package websocket
+
+import (
+	"context"
+	"exc6/services/groups"
+	"exc6/tests/fakedb"
+	"exc6/tests/fakeredis"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newInstances returns two managers sharing one Redis, as two instances of
+// the server would
+func newInstances(t *testing.T) (*fakeredis.Server, *Manager, *Manager) {
+	srv := fakeredis.New(t)
+	a := NewManager(context.Background(), srv.Client(t))
+	t.Cleanup(a.Close)
+	b := NewManager(context.Background(), srv.Client(t))
+	t.Cleanup(b.Close)
+	b.origin = "other-instance"
+	return srv, a, b
+}
+
+// connect registers a device of username on m
+func connect(t *testing.T, m *Manager, username, id string) *Client {
+	client := &Client{ID: id, Username: username, Send: make(chan *Message, 10), connectedAt: time.Now()}
+	m.RegisterClient(client)
+	// The client has no connection for the manager to close on shutdown
+	t.Cleanup(func() { m.unRegisterClient(client) })
+	return client
+}
+
+// following waits until n instances follow the user's channel
+func following(t *testing.T, srv *fakeredis.Server, username string, n int) {
+	t.Helper()
+	require.Eventually(t, func() bool {
+		return srv.Subscribers(UserChannel(username)) == n
+	}, time.Second, 5*time.Millisecond)
+}
+
+// received returns the messages a client is sent within a short wait
+func received(client *Client) []*Message {
+	var messages []*Message
+	timeout := time.After(100 * time.Millisecond)
+	for {
+		select {
+		case message := <-client.Send:
+			messages = append(messages, message)
+		case <-timeout:
+			return messages
+		}
+	}
+}
+
+func TestChannelFor(t *testing.T) {
+	channel, kind := channelFor(&Message{To: "bob", GroupID: "g1"})
+	assert.Equal(t, "ws:user:bob", channel)
+	assert.Equal(t, "user", kind)
+
+	channel, kind = channelFor(&Message{Type: MessageTypeMaintenance})
+	assert.Equal(t, PubSubChannelGlobal, channel)
+	assert.Equal(t, "global", kind)
+}
+
+func TestUserChannelSubscriptions(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	m := &Manager{
+		clients:        newRegistry(4),
+		mu:             &sync.RWMutex{},
+		ctx:            ctx,
+		channelChanges: make(chan channelChange, 8),
+	}
+	newClient := func(id string) *Client {
+		return &Client{ID: id, Username: "alice", Send: make(chan *Message, 1)}
+	}
+
+	// Only the first device subscribes and only the last one unsubscribes
+	phone, laptop := newClient("1"), newClient("2")
+	m.RegisterClient(phone)
+	m.RegisterClient(laptop)
+	m.unRegisterClient(phone)
+	m.unRegisterClient(laptop)
+
+	// Reconnecting subscribes again after the unsubscribe
+	m.RegisterClient(newClient("3"))
+
+	close(m.channelChanges)
+	var changes []channelChange
+	for change := range m.channelChanges {
+		changes = append(changes, change)
+	}
+	assert.Equal(t, []channelChange{
+		{username: "alice", subscribe: true},
+		{username: "alice", subscribe: false},
+		{username: "alice", subscribe: true},
+	}, changes)
+}
+
+func TestWaitToResubscribe(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	m := &Manager{ctx: ctx, channelChanges: make(chan channelChange, 8)}
+
+	// Subscribing again follows the connected users, so queued changes go
+	m.channelChanges <- channelChange{username: "alice", subscribe: true}
+	m.channelChanges <- channelChange{username: "bob", subscribe: false}
+	assert.True(t, m.waitToResubscribe(10*time.Millisecond))
+	assert.Empty(t, m.channelChanges)
+
+	cancel()
+	assert.False(t, m.waitToResubscribe(time.Minute))
+}
+
+func TestSendToUserEveryInstance(t *testing.T) {
+	srv, a, b := newInstances(t)
+	phone := connect(t, a, "alice", "phone")
+	laptop := connect(t, b, "alice", "laptop")
+	bob := connect(t, b, "bob", "bob")
+	following(t, srv, "alice", 2)
+	following(t, srv, "bob", 1)
+
+	require.NoError(t, a.SendToUser("alice", &Message{Type: MessageTypeNotification, Content: "hi"}))
+	for _, device := range []*Client{phone, laptop} {
+		messages := received(device)
+		require.Len(t, messages, 1, "each device gets one copy")
+		assert.Equal(t, "hi", messages[0].Content)
+	}
+
+	require.NoError(t, a.SendToUser("bob", &Message{Type: MessageTypeNotification, Content: "hey"}))
+	messages := received(bob)
+	require.Len(t, messages, 1)
+	assert.Equal(t, "bob", messages[0].To)
+
+	// A local device still gets the message while Redis is down
+	srv.SetDown(true)
+	assert.NoError(t, a.SendToUser("alice", &Message{Type: MessageTypeNotification}))
+	assert.Len(t, received(phone), 1)
+	assert.Error(t, a.SendToUser("bob", &Message{Type: MessageTypeNotification}), "bob is reachable only through Redis")
+}
+
+func TestMessagesReachEveryInstance(t *testing.T) {
+	srv, a, b := newInstances(t)
+
+	fake := fakedb.New(t)
+	now := time.Now()
+	fake.Return("GetUserByUsername", fakedb.Row(uuid.New(), now, now, "bob", "user", "hash", nil, nil))
+	fake.Return("IsGroupMember", fakedb.Row(true))
+	fake.Return("GetGroupMembers", fakedb.Result{Rows: [][]any{
+		{uuid.New(), "alice", nil, nil, groups.RoleMember, now},
+		{uuid.New(), "bob", nil, nil, groups.RoleOwner, now},
+		{uuid.New(), "carol", nil, nil, groups.RoleMember, now},
+	}})
+	a.SetGroupService(groups.NewGroupService(fake.Queries()))
+
+	phone := connect(t, a, "alice", "phone")
+	laptop := connect(t, b, "alice", "laptop")
+	bob := connect(t, b, "bob", "bob")
+	following(t, srv, "alice", 2)
+	following(t, srv, "bob", 1)
+
+	a.broadcastMessage(&Message{Type: MessageTypeChat, From: "bob", To: "alice", Content: "hi"})
+	for _, device := range []*Client{phone, laptop} {
+		messages := received(device)
+		require.Len(t, messages, 1, "each device gets one copy")
+		assert.Equal(t, "hi", messages[0].Content)
+	}
+
+	// Members connected here and elsewhere alike get one copy per device
+	groupID := uuid.NewString()
+	a.broadcastMessage(&Message{Type: MessageTypeGroupChat, From: "bob", GroupID: groupID, Content: "hello all"})
+	for _, device := range []*Client{phone, laptop, bob} {
+		messages := received(device)
+		require.Len(t, messages, 1, "each device gets one copy")
+		assert.Equal(t, "hello all", messages[0].Content)
+		assert.Equal(t, groupID, messages[0].GroupID)
+	}
+}
@@ -0,0 +1,186 @@
+package websocket
+
+import (
+	"exc6/pkg/instance"
+	"exc6/pkg/logger"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Connection quality is measured from the round trip of protocol-level
+// pings: the write pump stamps each ping and the pong handler times the
+// answer. Samples are smoothed and classified with some hysteresis, and the
+// client is told its quality whenever it changes, at the next ping. A
+// connection that stays poor for downgradeAfter pings is switched to lite
+// mode: the status message announcing it is the last frame in the full
+// protocol. Clients get the full protocol back by reconnecting.
+
+const (
+	// MessageTypeConnectionQuality tells the client how its connection
+	// performs: data "quality" (good or poor), "rtt_ms" (smoothed round trip)
+	// and "lite" (whether the following frames use the lite protocol)
+	MessageTypeConnectionQuality MessageType = "connection_quality"
+
+	QualityGood = "good"
+	QualityPoor = "poor"
+
+	// A smoothed round trip from poorRTT up makes a connection poor and one
+	// up to goodRTT makes it good again; in between it keeps its quality
+	poorRTT = 600 * time.Millisecond
+	goodRTT = 300 * time.Millisecond
+
+	// rttSmoothing is the weight of a new sample in the smoothed round trip
+	rttSmoothing = 0.25
+
+	// downgradeAfter is how many pings in a row a connection must be poor
+	// before it is switched to lite mode
+	downgradeAfter = 3
+)
+
+var (
+	pingRTT = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "ws_ping_rtt_seconds",
+			Help:    "Round trip time of WebSocket pings",
+			Buckets: []float64{.025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+		},
+		[]string{"mode"}, // mode: full, lite
+	)
+
+	qualityDowngrades = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "ws_quality_downgrades_total",
+			Help: "Connections switched to lite mode because of a poor round trip",
+		},
+	)
+)
+
+func init() {
+	instance.Registerer().MustRegister(pingRTT)
+	instance.Registerer().MustRegister(qualityDowngrades)
+}
+
+// connectionQuality tracks the round trip of a client's pings. The write
+// pump and the pong handler run on different goroutines.
+type connectionQuality struct {
+	mu       sync.Mutex
+	pingSent time.Time // zero when no ping is outstanding
+	smoothed time.Duration
+	level    string // empty until the first sample
+	poorRun  int    // samples in a row the connection was poor
+	reported string // level last sent to the client
+}
+
+// pinged records that a ping was sent at now
+func (q *connectionQuality) pinged(now time.Time) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.pingSent = now
+}
+
+// ponged times the answer to the outstanding ping, or reports false when
+// there is none
+func (q *connectionQuality) ponged(now time.Time) (time.Duration, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.pingSent.IsZero() {
+		return 0, false
+	}
+	rtt := now.Sub(q.pingSent)
+	q.pingSent = time.Time{}
+	q.add(rtt)
+	return rtt, true
+}
+
+// add folds a round trip into the smoothed one and classifies the result
+func (q *connectionQuality) add(rtt time.Duration) {
+	if q.level == "" {
+		q.smoothed = rtt
+	} else {
+		q.smoothed += time.Duration(rttSmoothing * float64(rtt-q.smoothed))
+	}
+
+	switch {
+	case q.smoothed >= poorRTT:
+		q.level = QualityPoor
+	case q.smoothed <= goodRTT || q.level == "":
+		q.level = QualityGood
+	}
+
+	if q.level == QualityPoor {
+		q.poorRun++
+	} else {
+		q.poorRun = 0
+	}
+}
+
+// report returns the quality to tell the client and whether to switch it to
+// lite mode, with changed false when there is nothing new to tell
+func (q *connectionQuality) report(lite bool) (level string, smoothed time.Duration, downgrade, changed bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	downgrade = !lite && q.poorRun >= downgradeAfter
+	changed = q.level != q.reported || downgrade
+	q.reported = q.level
+	return q.level, q.smoothed, downgrade, changed
+}
+
+// observePong records the round trip of the ping a pong answers
+func (c *Client) observePong(now time.Time) {
+	rtt, ok := c.quality.ponged(now)
+	if !ok {
+		return
+	}
+
+	mode := "full"
+	if c.IsLite() {
+		mode = "lite"
+	}
+	pingRTT.WithLabelValues(mode).Observe(rtt.Seconds())
+}
+
+// reportQuality tells the client when its connection quality changed and
+// switches a poor connection to lite mode, reporting whether it did. Only
+// the write pump calls it.
+func (c *Client) reportQuality() (bool, error) {
+	lite := c.IsLite()
+	level, smoothed, downgrade, changed := c.quality.report(lite)
+	if !changed {
+		return false, nil
+	}
+
+	msg := &Message{
+		Type: MessageTypeConnectionQuality,
+		Data: map[string]any{
+			"quality": level,
+			"rtt_ms":  smoothed.Milliseconds(),
+			"lite":    lite || downgrade,
+		},
+		Timestamp: time.Now().Unix(),
+	}
+
+	c.mu.Lock()
+	if c.Conn == nil {
+		c.mu.Unlock()
+		return false, nil
+	}
+	err := c.timedWrite(func() error { return c.write(msg) })
+	c.mu.Unlock()
+	if err != nil {
+		return false, err
+	}
+
+	if downgrade {
+		c.downgraded.Store(true)
+		qualityDowngrades.Inc()
+		logger.WithFields(map[string]any{
+			"username": c.Username,
+			"rtt_ms":   smoothed.Milliseconds(),
+		}).Info("Switched WebSocket client to lite mode after poor round trips")
+	}
+	return downgrade, nil
+}
@@ -0,0 +1,65 @@
+package websocket
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConnectionQuality(t *testing.T) {
+	var q connectionQuality
+	now := time.Now()
+
+	sample := func(rtt time.Duration) {
+		q.pinged(now)
+		_, ok := q.ponged(now.Add(rtt))
+		assert.True(t, ok)
+	}
+
+	_, ok := q.ponged(now)
+	assert.False(t, ok, "a pong without a ping is not timed")
+
+	_, _, _, changed := q.report(false)
+	assert.False(t, changed, "nothing to report before the first sample")
+
+	sample(50 * time.Millisecond)
+	level, _, downgrade, changed := q.report(false)
+	assert.Equal(t, QualityGood, level)
+	assert.True(t, changed)
+	assert.False(t, downgrade)
+
+	_, _, _, changed = q.report(false)
+	assert.False(t, changed, "an unchanged quality is reported once")
+
+	// A single slow round trip is smoothed away
+	sample(time.Second)
+	level, _, _, _ = q.report(false)
+	assert.Equal(t, QualityGood, level)
+
+	for range 10 {
+		sample(2 * time.Second)
+	}
+	level, smoothed, downgrade, changed := q.report(false)
+	assert.Equal(t, QualityPoor, level)
+	assert.GreaterOrEqual(t, smoothed, poorRTT)
+	assert.True(t, changed)
+	assert.True(t, downgrade, "poor for downgradeAfter pings in a row")
+
+	_, _, downgrade, _ = q.report(true)
+	assert.False(t, downgrade, "lite clients are not switched again")
+
+	// Between the thresholds the quality stays poor
+	for range 20 {
+		sample(400 * time.Millisecond)
+	}
+	level, _, _, _ = q.report(true)
+	assert.Equal(t, QualityPoor, level)
+
+	for range 20 {
+		sample(50 * time.Millisecond)
+	}
+	level, _, _, changed = q.report(true)
+	assert.Equal(t, QualityGood, level)
+	assert.True(t, changed)
+}
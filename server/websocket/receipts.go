@@ -0,0 +1,198 @@
+package websocket
+
+import (
+	"context"
+	"exc6/pkg/instance"
+	"exc6/pkg/logger"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Read receipts tell the other side of a conversation how far a user has
+// read. A receipt means "read everything up to message ID", so only the
+// newest one per reader and conversation matters: clients debounce them, and
+// the manager holds them for receiptFlushInterval, keeping the newest per
+// conversation, before recording them with the ReadTracker, which announces
+// the ones that moved the reader's position. Direct messages written to
+// their recipient's connection are recorded as delivered the same way.
+
+const (
+	// MessageTypeRead is a read receipt. From a client it carries the last
+	// read message ID and the conversation (To or GroupID), and may carry the
+	// read message's Timestamp. To the conversation it arrives with From set
+	// to the reader, Timestamp to the position and data.read_at.
+	MessageTypeRead MessageType = "read"
+
+	// MessageTypeDelivered tells the sender of direct messages that they
+	// reached the recipient up to ID, with Timestamp set to the position and
+	// data.delivered_at
+	MessageTypeDelivered MessageType = "delivered"
+
+	receiptFlushInterval = time.Second
+
+	receiptTimeout = 3 * time.Second
+)
+
+var (
+	receiptsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "ws_read_receipts_total",
+			Help: "Read receipts received from clients and forwarded after coalescing",
+		},
+		[]string{"stage"}, // received, forwarded, dropped
+	)
+
+	receiptCompression = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "ws_read_receipt_compression_ratio",
+			Help:    "Receipts received per receipt forwarded, observed per flush",
+			Buckets: []float64{1, 1.5, 2, 3, 5, 10, 20, 50},
+		},
+	)
+)
+
+func init() {
+	instance.Registerer().MustRegister(receiptsTotal)
+	instance.Registerer().MustRegister(receiptCompression)
+}
+
+// ReadTracker records and announces delivered and read positions
+type ReadTracker interface {
+	MarkDelivered(ctx context.Context, username, sender, messageID string, timestamp int64) error
+	MarkRead(ctx context.Context, username, peer, groupID, messageID string, timestamp int64) error
+}
+
+// receiptKey identifies a reader's position in one conversation
+type receiptKey struct {
+	reader string
+	peer   string
+	group  string
+}
+
+// receiptBatcher keeps the newest pending receipt per conversation
+type receiptBatcher struct {
+	mu       sync.Mutex
+	pending  map[receiptKey]*Message
+	received int
+}
+
+func newReceiptBatcher() *receiptBatcher {
+	return &receiptBatcher{pending: make(map[receiptKey]*Message)}
+}
+
+// add queues a receipt, replacing an older one for the same conversation
+func (b *receiptBatcher) add(msg *Message) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.pending[receiptKey{reader: msg.From, peer: msg.To, group: msg.GroupID}] = msg
+	b.received++
+}
+
+// drain returns the pending receipts and how many were received for them
+func (b *receiptBatcher) drain() ([]*Message, int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(b.pending) == 0 {
+		return nil, 0
+	}
+
+	batch := make([]*Message, 0, len(b.pending))
+	for _, msg := range b.pending {
+		batch = append(batch, msg)
+	}
+	received := b.received
+
+	b.pending = make(map[receiptKey]*Message)
+	b.received = 0
+
+	return batch, received
+}
+
+// SetReadTracker sets where deliveries and flushed receipts are recorded
+func (m *Manager) SetReadTracker(tracker ReadTracker) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.readTracker = tracker
+}
+
+// queueReceipt accepts a receipt from a client for the next flush
+func (m *Manager) queueReceipt(msg *Message) {
+	if msg.ID == "" || (msg.To == "") == (msg.GroupID == "") || msg.To == msg.From {
+		return
+	}
+
+	// Only the position is forwarded
+	msg.Content = ""
+	msg.Data = nil
+
+	m.receipts.add(msg)
+	receiptsTotal.WithLabelValues("received").Inc()
+}
+
+func (m *Manager) runReceipts() {
+	ticker := time.NewTicker(receiptFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			m.flushReceipts()
+
+		case <-m.ctx.Done():
+			return
+		}
+	}
+}
+
+// flushReceipts records, or without a tracker forwards, the receipts
+// coalesced since the last flush
+func (m *Manager) flushReceipts() {
+	batch, received := m.receipts.drain()
+	if len(batch) == 0 {
+		return
+	}
+	receiptCompression.Observe(float64(received) / float64(len(batch)))
+
+	m.mu.RLock()
+	tracker := m.readTracker
+	deliveryTracker := m.deliveryTracker
+	m.mu.RUnlock()
+
+	for _, msg := range batch {
+		if deliveryTracker != nil && msg.GroupID != "" {
+			m.markTrackedRead(deliveryTracker, msg)
+		}
+		if tracker != nil {
+			// The tracker announces the receipt if it moved the position
+			m.markRead(tracker, msg)
+			receiptsTotal.WithLabelValues("forwarded").Inc()
+			continue
+		}
+
+		select {
+		case m.broadcast <- msg:
+			receiptsTotal.WithLabelValues("forwarded").Inc()
+		default:
+			receiptsTotal.WithLabelValues("dropped").Inc()
+			logger.Warn("Broadcast channel full for read receipt")
+		}
+	}
+}
+
+func (m *Manager) markRead(tracker ReadTracker, msg *Message) {
+	ctx, cancel := context.WithTimeout(m.ctx, receiptTimeout)
+	defer cancel()
+
+	if err := tracker.MarkRead(ctx, msg.From, msg.To, msg.GroupID, msg.ID, msg.Timestamp); err != nil {
+		logger.WithFields(map[string]any{
+			"reader":   msg.From,
+			"to":       msg.To,
+			"group_id": msg.GroupID,
+			"error":    err.Error(),
+		}).Warn("Failed to apply read receipt")
+	}
+}
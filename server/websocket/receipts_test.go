@@ -0,0 +1,58 @@
+package websocket
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReceiptBatcherCoalesces(t *testing.T) {
+	b := newReceiptBatcher()
+
+	b.add(&Message{Type: MessageTypeRead, From: "alice", To: "bob", ID: "m1"})
+	b.add(&Message{Type: MessageTypeRead, From: "alice", To: "bob", ID: "m2"})
+	b.add(&Message{Type: MessageTypeRead, From: "alice", To: "bob", ID: "m3"})
+	b.add(&Message{Type: MessageTypeRead, From: "alice", GroupID: "g1", ID: "m9"})
+
+	batch, received := b.drain()
+	require.Len(t, batch, 2)
+	assert.Equal(t, 4, received)
+
+	for _, msg := range batch {
+		if msg.To == "bob" {
+			assert.Equal(t, "m3", msg.ID, "newest position wins")
+		}
+	}
+
+	batch, received = b.drain()
+	assert.Empty(t, batch)
+	assert.Zero(t, received)
+}
+
+func TestQueueReceipt(t *testing.T) {
+	tests := []struct {
+		name   string
+		msg    Message
+		queued bool
+	}{
+		{name: "Direct conversation", msg: Message{From: "alice", To: "bob", ID: "m1"}, queued: true},
+		{name: "Group", msg: Message{From: "alice", GroupID: "g1", ID: "m1"}, queued: true},
+		{name: "Missing message ID", msg: Message{From: "alice", To: "bob"}},
+		{name: "No conversation", msg: Message{From: "alice", ID: "m1"}},
+		{name: "Both recipient and group", msg: Message{From: "alice", To: "bob", GroupID: "g1", ID: "m1"}},
+		{name: "Own conversation", msg: Message{From: "alice", To: "alice", ID: "m1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &Manager{receipts: newReceiptBatcher()}
+			msg := tt.msg
+			msg.Type = MessageTypeRead
+			m.queueReceipt(&msg)
+
+			batch, _ := m.receipts.drain()
+			assert.Equal(t, tt.queued, len(batch) == 1)
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package websocket
+
+import "encoding/json"
+
+// A client that reconnects sends a resume frame naming the last message it
+// has of each open conversation, in data.conversations. The connection's
+// relay replays what the client missed before it delivers anything newer;
+// the manager only hands the request over.
+
+// MessageTypeResume asks for the messages missed while disconnected
+const MessageTypeResume MessageType = "resume"
+
+// MaxResumePoints bounds the conversations one resume frame may name
+const MaxResumePoints = 50
+
+// ResumePoint is the last message a client has of one conversation: a
+// direct one with a user, or a group
+type ResumePoint struct {
+	With    string `json:"with,omitempty"`
+	GroupID string `json:"group_id,omitempty"`
+
+	// LastID is the message's ID and Since its unix time
+	LastID string `json:"last_id,omitempty"`
+	Since  int64  `json:"since"`
+}
+
+// resumePoints decodes the conversations of a resume frame, skipping those
+// that name no conversation or no time
+func resumePoints(msg *Message) []ResumePoint {
+	raw, err := json.Marshal(msg.Data["conversations"])
+	if err != nil {
+		return nil
+	}
+	var decoded []ResumePoint
+	if err := json.Unmarshal(raw, &decoded); err != nil {
+		return nil
+	}
+
+	points := make([]ResumePoint, 0, min(len(decoded), MaxResumePoints))
+	for _, point := range decoded {
+		if (point.With == "") == (point.GroupID == "") || point.Since <= 0 {
+			continue
+		}
+		points = append(points, point)
+		if len(points) == MaxResumePoints {
+			break
+		}
+	}
+	return points
+}
+
+// Resumes delivers the client's resume requests to its relay
+func (c *Client) Resumes() <-chan []ResumePoint {
+	return c.resumes
+}
+
+// queueResume hands a resume request to the relay. A client resumes once
+// per connection, so a request arriving while one is pending is dropped.
+func (c *Client) queueResume(msg *Message) {
+	points := resumePoints(msg)
+	if len(points) == 0 {
+		return
+	}
+	select {
+	case c.resumes <- points:
+	default:
+	}
+}
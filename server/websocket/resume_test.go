@@ -0,0 +1,40 @@
+package websocket
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResumePoints(t *testing.T) {
+	var msg Message
+	require.NoError(t, json.Unmarshal([]byte(`{"type":"resume","data":{"conversations":[
+		{"with":"bob","last_id":"m1","since":1700000000},
+		{"group_id":"g1","since":1700000100},
+		{"with":"carol","group_id":"g2","since":1700000000},
+		{"with":"dave"},
+		{"group_id":"g3","since":"soon"}
+	]}}`), &msg))
+
+	// Points must name one conversation and a time; a malformed one
+	// discards the frame
+	assert.Empty(t, resumePoints(&msg))
+
+	msg.Data["conversations"] = []any{
+		map[string]any{"with": "bob", "last_id": "m1", "since": 1700000000},
+		map[string]any{"group_id": "g1", "since": 1700000100},
+		map[string]any{"with": "carol", "group_id": "g2", "since": 1700000000},
+		map[string]any{"with": "dave"},
+	}
+	assert.Equal(t, []ResumePoint{
+		{With: "bob", LastID: "m1", Since: 1700000000},
+		{GroupID: "g1", Since: 1700000100},
+	}, resumePoints(&msg))
+
+	client := &Client{resumes: make(chan []ResumePoint, 1)}
+	client.queueResume(&msg)
+	client.queueResume(&msg)
+	assert.Len(t, client.Resumes(), 1, "a pending resume is not queued twice")
+}
@@ -0,0 +1,93 @@
+package websocket
+
+import (
+	"context"
+	"exc6/pkg/instance"
+	"exc6/pkg/logger"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Participants of a call room each hold a peer connection with every other
+// participant, and set each one up with its own offer, answer and ICE
+// candidates. A room signal names the peer in To and the room in data
+// "room_id", and is only relayed between participants of that room.
+
+const (
+	// MessageTypeRoomOffer, MessageTypeRoomAnswer and MessageTypeRoomICE
+	// carry WebRTC signaling between two participants of a call room
+	MessageTypeRoomOffer  MessageType = "room_offer"
+	MessageTypeRoomAnswer MessageType = "room_answer"
+	MessageTypeRoomICE    MessageType = "room_ice"
+
+	// MessageTypeRoomUpdate tells participants and invitees that a room
+	// changed; data "event" is created, joined, left, ended or state, data
+	// "room" the room, and data "username" who changed it
+	MessageTypeRoomUpdate MessageType = "room_update"
+
+	roomSignalTimeout = time.Second
+)
+
+var roomSignalsTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "ws_room_signals_total",
+		Help: "Call room signaling messages received from clients by outcome",
+	},
+	[]string{"result"}, // relayed, rejected
+)
+
+func init() {
+	instance.Registerer().MustRegister(roomSignalsTotal)
+}
+
+// CallRooms tells whether users are participants of a call room
+type CallRooms interface {
+	InRoom(ctx context.Context, roomID string, usernames ...string) bool
+}
+
+// SetCallRooms sets where call room signals are checked. Without it they are
+// dropped.
+func (m *Manager) SetCallRooms(rooms CallRooms) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.callRooms = rooms
+}
+
+// handleRoomSignal relays a signal from the client to another participant of
+// the room it names
+func (c *Client) handleRoomSignal(msg *Message) {
+	roomID, _ := msg.Data["room_id"].(string)
+	if roomID == "" || msg.To == "" || msg.To == msg.From || msg.GroupID != "" {
+		roomSignalsTotal.WithLabelValues("rejected").Inc()
+		return
+	}
+
+	m := c.Manager
+	m.mu.RLock()
+	rooms := m.callRooms
+	m.mu.RUnlock()
+	if rooms == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(m.ctx, roomSignalTimeout)
+	defer cancel()
+
+	if !rooms.InRoom(ctx, roomID, msg.From, msg.To) {
+		roomSignalsTotal.WithLabelValues("rejected").Inc()
+		logger.WithFields(map[string]any{
+			"from":    msg.From,
+			"to":      msg.To,
+			"room_id": roomID,
+		}).Debug("Dropped signal for a call room the users are not both in")
+		return
+	}
+
+	select {
+	case m.broadcast <- msg:
+		roomSignalsTotal.WithLabelValues("relayed").Inc()
+	default:
+		logger.Warn("Broadcast channel full for call room signal")
+	}
+}
@@ -0,0 +1,217 @@
+package websocket
+
+import (
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Connected clients are kept in shards by username hash, each with its own
+// lock, so connection storms and broadcasts to many users do not all wait on
+// one mutex. A user may be connected from several devices at once; all of a
+// user's clients live in the same shard, keyed by client ID. Application-level
+// pings are sent by a worker per shard, started at staggered offsets so the
+// shards do not all ping at once.
+
+const (
+	// clientShards is the number of shards of the client registry
+	clientShards = 32
+
+	// MaxDevicesPerUser is how many connections a user may hold on one
+	// instance; connecting another one closes their oldest
+	MaxDevicesPerUser = 8
+
+	// appPingInterval is how often each shard pings its non-lite clients
+	appPingInterval = 30 * time.Second
+)
+
+// clientShard holds the clients whose usernames hash to it
+type clientShard struct {
+	mu      sync.RWMutex
+	clients map[string]map[string]*Client // username -> client ID -> client
+}
+
+// registry is the sharded set of clients connected to this instance
+type registry struct {
+	shards []*clientShard
+
+	// size counts clients across shards without locking them all
+	size atomic.Int64
+}
+
+// newRegistry creates a registry with n shards
+func newRegistry(n int) *registry {
+	r := &registry{shards: make([]*clientShard, max(n, 1))}
+	for i := range r.shards {
+		r.shards[i] = &clientShard{clients: make(map[string]map[string]*Client)}
+	}
+	return r
+}
+
+// shard returns the shard holding username
+func (r *registry) shard(username string) *clientShard {
+	h := fnv.New32a()
+	h.Write([]byte(username))
+	return r.shards[h.Sum32()%uint32(len(r.shards))]
+}
+
+// get returns username's clients
+func (r *registry) get(username string) []*Client {
+	s := r.shard(username)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	devices := s.clients[username]
+	if len(devices) == 0 {
+		return nil
+	}
+	clients := make([]*Client, 0, len(devices))
+	for _, client := range devices {
+		clients = append(clients, client)
+	}
+	return clients
+}
+
+// has reports whether username has a client
+func (r *registry) has(username string) bool {
+	s := r.shard(username)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return len(s.clients[username]) > 0
+}
+
+// put adds client. When the user already holds MaxDevicesPerUser clients,
+// their oldest is removed and returned so it can be closed.
+func (r *registry) put(client *Client) *Client {
+	s := r.shard(client.Username)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	devices := s.clients[client.Username]
+	if devices == nil {
+		devices = make(map[string]*Client)
+		s.clients[client.Username] = devices
+	}
+
+	if _, ok := devices[client.ID]; ok {
+		devices[client.ID] = client
+		return nil
+	}
+
+	var evicted *Client
+	if len(devices) >= MaxDevicesPerUser {
+		for _, device := range devices {
+			if evicted == nil || device.connectedAt.Before(evicted.connectedAt) {
+				evicted = device
+			}
+		}
+		delete(devices, evicted.ID)
+		r.size.Add(-1)
+	}
+
+	devices[client.ID] = client
+	r.size.Add(1)
+	return evicted
+}
+
+// remove removes client unless it was already evicted, reporting whether it
+// was removed and whether it was the user's last client
+func (r *registry) remove(client *Client) (removed, last bool) {
+	s := r.shard(client.Username)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	devices := s.clients[client.Username]
+	if _, ok := devices[client.ID]; !ok {
+		return false, false
+	}
+	delete(devices, client.ID)
+	r.size.Add(-1)
+	if len(devices) > 0 {
+		return true, false
+	}
+	delete(s.clients, client.Username)
+	return true, true
+}
+
+// count returns the number of connected clients
+func (r *registry) count() int {
+	return int(r.size.Load())
+}
+
+// usernames returns the users with at least one client
+func (r *registry) usernames() []string {
+	var users []string
+	for _, s := range r.shards {
+		s.mu.RLock()
+		for username := range s.clients {
+			users = append(users, username)
+		}
+		s.mu.RUnlock()
+	}
+	return users
+}
+
+// each calls fn for every client, holding one shard's read lock at a time.
+// fn must not block.
+func (r *registry) each(fn func(*Client)) {
+	for _, s := range r.shards {
+		s.each(fn)
+	}
+}
+
+// each calls fn for every client in the shard under its read lock
+func (s *clientShard) each(fn func(*Client)) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, devices := range s.clients {
+		for _, client := range devices {
+			fn(client)
+		}
+	}
+}
+
+// clear removes every client, returning them
+func (r *registry) clear() []*Client {
+	var clients []*Client
+	for _, s := range r.shards {
+		s.mu.Lock()
+		for _, devices := range s.clients {
+			for _, client := range devices {
+				clients = append(clients, client)
+			}
+			r.size.Add(-int64(len(devices)))
+		}
+		s.clients = make(map[string]map[string]*Client)
+		s.mu.Unlock()
+	}
+	return clients
+}
+
+// runPings pings the shard's clients every appPingInterval, the first time
+// offset later than that
+func (m *Manager) runPings(s *clientShard, offset time.Duration) {
+	start := time.NewTimer(offset)
+	select {
+	case <-start.C:
+	case <-m.ctx.Done():
+		start.Stop()
+		return
+	}
+
+	ticker := time.NewTicker(appPingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			m.sendPings(s)
+
+		case <-m.ctx.Done():
+			return
+		}
+	}
+}
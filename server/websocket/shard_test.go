@@ -0,0 +1,126 @@
+package websocket
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistry(t *testing.T) {
+	r := newRegistry(4)
+
+	phone := &Client{ID: "1", Username: "alice"}
+	assert.Nil(t, r.put(phone))
+	assert.Nil(t, r.put(&Client{ID: "2", Username: "bob"}))
+	assert.Equal(t, 2, r.count())
+
+	// A second device joins the first instead of replacing it
+	laptop := &Client{ID: "3", Username: "alice"}
+	assert.Nil(t, r.put(laptop))
+	assert.Equal(t, 3, r.count())
+	assert.ElementsMatch(t, []*Client{phone, laptop}, r.get("alice"))
+	assert.ElementsMatch(t, []string{"alice", "bob"}, r.usernames())
+
+	var ids []string
+	r.each(func(c *Client) { ids = append(ids, c.ID) })
+	assert.ElementsMatch(t, []string{"1", "2", "3"}, ids)
+
+	removed, last := r.remove(phone)
+	assert.True(t, removed)
+	assert.False(t, last, "alice is still connected from the laptop")
+	assert.True(t, r.has("alice"))
+
+	removed, _ = r.remove(phone)
+	assert.False(t, removed)
+
+	removed, last = r.remove(laptop)
+	assert.True(t, removed)
+	assert.True(t, last)
+	assert.False(t, r.has("alice"))
+	assert.Empty(t, r.get("alice"))
+	assert.Equal(t, 1, r.count())
+
+	assert.Len(t, r.clear(), 1)
+	assert.Equal(t, 0, r.count())
+}
+
+func TestRegistryDeviceLimit(t *testing.T) {
+	r := newRegistry(4)
+	start := time.Now()
+
+	clients := make([]*Client, MaxDevicesPerUser)
+	for i := range clients {
+		clients[i] = &Client{ID: fmt.Sprint(i), Username: "alice", connectedAt: start.Add(time.Duration(i) * time.Second)}
+		assert.Nil(t, r.put(clients[i]))
+	}
+
+	// The oldest device makes room for a new one
+	newest := &Client{ID: "new", Username: "alice", connectedAt: start.Add(time.Hour)}
+	assert.Same(t, clients[0], r.put(newest))
+	assert.Equal(t, MaxDevicesPerUser, r.count())
+	assert.NotContains(t, r.get("alice"), clients[0])
+
+	removed, _ := r.remove(clients[0])
+	assert.False(t, removed, "an evicted client cannot remove a newer one")
+	assert.Equal(t, MaxDevicesPerUser, r.count())
+}
+
+func TestRegistryShardsSpread(t *testing.T) {
+	r := newRegistry(clientShards)
+	for i := range 1000 {
+		r.put(&Client{ID: fmt.Sprint(i), Username: fmt.Sprintf("user%d", i)})
+	}
+
+	for _, s := range r.shards {
+		assert.NotEmpty(t, s.clients)
+	}
+}
+
+// BenchmarkRegistryConnectionStorm replays the TestWebSocketConnectionStorm
+// profile against the registry: 1000 users connecting and disconnecting
+// while messages are routed to them and pings go out to everyone. With one
+// shard it behaves like the former single map and lock.
+func BenchmarkRegistryConnectionStorm(b *testing.B) {
+	const numConnections = 1000
+
+	clients := make([]*Client, numConnections)
+	for i := range clients {
+		clients[i] = &Client{ID: fmt.Sprint(i), Username: fmt.Sprintf("loadtest_user_%d", i), Send: make(chan *Message, 1)}
+	}
+
+	for _, shards := range []int{1, clientShards} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			r := newRegistry(shards)
+			for _, client := range clients {
+				r.put(client)
+			}
+
+			var next atomic.Int64
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				queued := 0
+				for pb.Next() {
+					i := next.Add(1)
+					client := clients[i%numConnections]
+
+					switch i % 100 {
+					case 0:
+						// Reconnect
+						r.remove(client)
+						r.put(client)
+					case 1:
+						// Ping one shard's worth of clients
+						r.shards[int(i/100)%shards].each(func(c *Client) { queued += len(c.Send) })
+					default:
+						// Route a message
+						r.get(client.Username)
+					}
+				}
+				_ = queued
+			})
+		})
+	}
+}
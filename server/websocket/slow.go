@@ -0,0 +1,122 @@
+package websocket
+
+import (
+	"errors"
+	"exc6/pkg/instance"
+	"exc6/pkg/logger"
+	"net"
+	"time"
+
+	"github.com/gofiber/contrib/websocket"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Every frame written to a client has a write deadline. A write that takes
+// longer than the slow write threshold counts against the client; after too
+// many in a row, or one that misses the deadline, the client is disconnected
+// with CloseSlowClient. It reconnects and reloads what it missed instead of
+// holding a write pump and a full send buffer.
+
+// CloseSlowClient is the close code sent to clients disconnected for being
+// too slow to keep up
+const CloseSlowClient = 4008
+
+// WriteOptions bound how long writes to a client may take
+type WriteOptions struct {
+	// Timeout is the write deadline of each frame
+	Timeout time.Duration
+
+	// SlowWrite is how long a write may take before it counts as slow
+	SlowWrite time.Duration
+
+	// SlowWriteLimit is how many slow writes in a row disconnect a client
+	SlowWriteLimit int
+}
+
+// DefaultWriteOptions are used until SetWriteOptions is called
+var DefaultWriteOptions = WriteOptions{
+	Timeout:        10 * time.Second,
+	SlowWrite:      2 * time.Second,
+	SlowWriteLimit: 5,
+}
+
+// errSlowClient is returned by writes to a client disconnected for being slow
+var errSlowClient = errors.New("websocket client too slow")
+
+var slowClientDisconnects = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "ws_slow_client_disconnects_total",
+		Help: "WebSocket clients disconnected for being too slow, by reason (write_timeout, slow_writes or send_buffer_full)",
+	},
+	[]string{"reason"},
+)
+
+func init() {
+	instance.Registerer().MustRegister(slowClientDisconnects)
+}
+
+// SetWriteOptions sets the write deadlines of clients created afterwards
+func (m *Manager) SetWriteOptions(opts WriteOptions) {
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultWriteOptions.Timeout
+	}
+	if opts.SlowWrite <= 0 || opts.SlowWrite >= opts.Timeout {
+		opts.SlowWrite = opts.Timeout / 2
+	}
+	opts.SlowWriteLimit = max(opts.SlowWriteLimit, 1)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.writeOptions = opts
+}
+
+// timedWrite runs write under the write deadline and keeps count of slow
+// writes, disconnecting the client when it cannot keep up
+func (c *Client) timedWrite(write func() error) error {
+	start := time.Now()
+	c.Conn.SetWriteDeadline(start.Add(c.writeOptions.Timeout))
+
+	if err := write(); err != nil {
+		if isTimeout(err) {
+			c.disconnectSlow("write_timeout")
+		}
+		return err
+	}
+
+	if time.Since(start) < c.writeOptions.SlowWrite {
+		c.slowWrites.Store(0)
+		return nil
+	}
+	if int(c.slowWrites.Add(1)) >= c.writeOptions.SlowWriteLimit {
+		c.disconnectSlow("slow_writes")
+		return errSlowClient
+	}
+	return nil
+}
+
+// disconnectSlow closes a client that cannot keep up, telling it why when
+// the connection still accepts a close frame
+func (c *Client) disconnectSlow(reason string) {
+	if !c.closing.CompareAndSwap(false, true) {
+		return
+	}
+
+	slowClientDisconnects.WithLabelValues(reason).Inc()
+	logger.WithFields(map[string]any{
+		"username":    c.Username,
+		"reason":      reason,
+		"slow_writes": c.slowWrites.Load(),
+		"drops":       c.consecutiveDrops.Load(),
+	}).Warn("Disconnecting slow WebSocket client")
+
+	c.Conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(CloseSlowClient, "client too slow"),
+		time.Now().Add(time.Second))
+	c.Close()
+}
+
+// isTimeout reports whether err is a missed deadline
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
@@ -0,0 +1,33 @@
+package websocket
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetWriteOptions(t *testing.T) {
+	m := &Manager{mu: &sync.RWMutex{}}
+
+	m.SetWriteOptions(WriteOptions{Timeout: 4 * time.Second, SlowWrite: time.Second, SlowWriteLimit: 3})
+	assert.Equal(t, WriteOptions{Timeout: 4 * time.Second, SlowWrite: time.Second, SlowWriteLimit: 3}, m.writeOptions)
+
+	// A slow write threshold past the deadline could never trigger
+	m.SetWriteOptions(WriteOptions{Timeout: 4 * time.Second, SlowWrite: 5 * time.Second})
+	assert.Equal(t, WriteOptions{Timeout: 4 * time.Second, SlowWrite: 2 * time.Second, SlowWriteLimit: 1}, m.writeOptions)
+
+	m.SetWriteOptions(WriteOptions{})
+	assert.Equal(t, DefaultWriteOptions.Timeout, m.writeOptions.Timeout)
+}
+
+func TestIsTimeout(t *testing.T) {
+	assert.True(t, isTimeout(os.ErrDeadlineExceeded))
+	assert.True(t, isTimeout(fmt.Errorf("write: %w", os.ErrDeadlineExceeded)))
+	assert.False(t, isTimeout(errors.New("broken pipe")))
+	assert.False(t, isTimeout(errSlowClient))
+}
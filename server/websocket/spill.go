@@ -0,0 +1,194 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"exc6/pkg/instance"
+	"exc6/pkg/keyspace"
+	"exc6/pkg/logger"
+	"slices"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/redis/go-redis/v9"
+)
+
+// Under the spill policy, messages that do not fit a client's send buffer
+// are appended to a Redis list per user instead of being lost, and replayed
+// to the next device of the user that connects. Spilling and replaying are
+// queued to one goroutine so a slow Redis never holds up a sender, and a
+// replay sees every message spilled before it.
+
+const (
+	// SpillTTL is how long spilled messages wait for the user to reconnect
+	SpillTTL = 24 * time.Hour
+
+	// SpillLimit is how many spilled messages are kept per user; older ones
+	// are discarded first
+	SpillLimit = 1000
+
+	spillKeyPrefix = "ws:spill:"
+	spillQueueSize = 1024
+	spillTimeout   = 2 * time.Second
+)
+
+var spilledTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "ws_spilled_messages_total",
+		Help: "Messages spilled to Redis for clients with a full send buffer, and replayed on reconnect",
+	},
+	[]string{"outcome"}, // spilled, replayed, failed, dropped
+)
+
+func init() {
+	instance.Registerer().MustRegister(spilledTotal)
+
+	keyspace.Register(keyspace.Family{
+		Prefix:      spillKeyPrefix,
+		Description: "WebSocket messages for slow clients, replayed on reconnect",
+	})
+}
+
+func spillKey(username string) string {
+	return spillKeyPrefix + username
+}
+
+// spillable reports whether a message is worth replaying later. Pings and
+// typing or activity states are stale by the time the user reconnects.
+func spillable(msg *Message) bool {
+	switch msg.Type {
+	case MessageTypePing, MessageTypeCapabilities, MessageTypeTyping, MessageTypeActivity:
+		return false
+	}
+	return true
+}
+
+// spillOp spills a message for a user, or replays a user's spilled messages
+// to a client when client is set
+type spillOp struct {
+	username string
+	message  *Message
+	client   *Client
+}
+
+// spill queues a message that did not fit the user's send buffer
+func (m *Manager) spill(username string, msg *Message) {
+	if !spillable(msg) {
+		return
+	}
+
+	select {
+	case m.spills <- spillOp{username: username, message: msg}:
+	default:
+		spilledTotal.WithLabelValues("dropped").Inc()
+	}
+}
+
+// replaySpilled queues sending the user's spilled messages to a client that
+// just connected
+func (m *Manager) replaySpilled(client *Client) {
+	select {
+	case m.spills <- spillOp{username: client.Username, client: client}:
+	default:
+		logger.WithField("username", client.Username).Warn("Spill queue full, spilled messages replay on the next connection")
+	}
+}
+
+func (m *Manager) runSpills() {
+	for {
+		select {
+		case op := <-m.spills:
+			ctx, cancel := context.WithTimeout(m.ctx, spillTimeout)
+			if op.client != nil {
+				m.replay(ctx, op.client)
+			} else {
+				m.store(ctx, op.username, op.message)
+			}
+			cancel()
+
+		case <-m.ctx.Done():
+			return
+		}
+	}
+}
+
+// store appends a message to the user's spill list, keeping the newest
+// SpillLimit
+func (m *Manager) store(ctx context.Context, username string, msg *Message) {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		spilledTotal.WithLabelValues("failed").Inc()
+		return
+	}
+
+	key := spillKey(username)
+	_, err = m.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.RPush(ctx, key, payload)
+		pipe.LTrim(ctx, key, -SpillLimit, -1)
+		pipe.Expire(ctx, key, SpillTTL)
+		return nil
+	})
+	if err != nil {
+		spilledTotal.WithLabelValues("failed").Inc()
+		logger.WithError(err).WithField("username", username).Warn("Failed to spill WebSocket message")
+		return
+	}
+	spilledTotal.WithLabelValues("spilled").Inc()
+}
+
+// replay takes the user's spilled messages and sends them to the client in
+// the order they were spilled
+func (m *Manager) replay(ctx context.Context, client *Client) {
+	key := spillKey(client.Username)
+
+	var entries *redis.StringSliceCmd
+	_, err := m.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		entries = pipe.LRange(ctx, key, 0, -1)
+		pipe.Del(ctx, key)
+		return nil
+	})
+	if err != nil {
+		logger.WithError(err).WithField("username", client.Username).Warn("Failed to replay spilled WebSocket messages")
+		return
+	}
+
+	// Like other senders, only write to clients still registered
+	if !slices.Contains(m.clients.get(client.Username), client) {
+		m.restore(ctx, client.Username, entries.Val())
+		return
+	}
+
+	for _, entry := range entries.Val() {
+		var msg Message
+		if err := json.Unmarshal([]byte(entry), &msg); err != nil {
+			continue
+		}
+		// A client that cannot take them spills them again
+		client.enqueue(&msg)
+		spilledTotal.WithLabelValues("replayed").Inc()
+	}
+}
+
+// restore puts back messages taken for a client that disconnected before
+// they could be replayed
+func (m *Manager) restore(ctx context.Context, username string, entries []string) {
+	if len(entries) == 0 {
+		return
+	}
+
+	// LPUSH prepends one value at a time, so the oldest goes last
+	key := spillKey(username)
+	values := make([]any, len(entries))
+	for i, entry := range entries {
+		values[len(entries)-1-i] = entry
+	}
+	_, err := m.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.LPush(ctx, key, values...)
+		pipe.LTrim(ctx, key, -SpillLimit, -1)
+		pipe.Expire(ctx, key, SpillTTL)
+		return nil
+	})
+	if err != nil {
+		spilledTotal.WithLabelValues("failed").Add(float64(len(entries)))
+	}
+}
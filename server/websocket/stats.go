@@ -0,0 +1,39 @@
+package websocket
+
+// Stats is a point-in-time view of the WebSocket load on this instance
+type Stats struct {
+	Connections     int `json:"connections"`
+	LiteConnections int `json:"lite_connections"`
+
+	// BroadcastQueue is the number of inbound messages waiting to be routed
+	BroadcastQueue int `json:"broadcast_queue"`
+
+	// SendQueued is the number of messages waiting in client send buffers;
+	// MaxSendQueue is the fullest single buffer
+	SendQueued   int `json:"send_queued"`
+	MaxSendQueue int `json:"max_send_queue"`
+
+	// Delivered is the running total of messages written to clients
+	Delivered int64 `json:"delivered_total"`
+}
+
+// Stats returns the current connection count and buffer depths
+func (m *Manager) Stats() Stats {
+	stats := Stats{
+		BroadcastQueue: len(m.broadcast),
+		Delivered:      m.delivered.Load(),
+	}
+
+	m.clients.each(func(client *Client) {
+		stats.Connections++
+		if client.IsLite() {
+			stats.LiteConnections++
+		}
+
+		queued := len(client.Send)
+		stats.SendQueued += queued
+		stats.MaxSendQueue = max(stats.MaxSendQueue, queued)
+	})
+
+	return stats
+}
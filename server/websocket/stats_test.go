@@ -0,0 +1,41 @@
+package websocket
+
+import (
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStats(t *testing.T) {
+	m := &Manager{
+		clients:   newRegistry(4),
+		broadcast: make(chan *Message, 10),
+		delivered: &atomic.Int64{},
+	}
+	assert.Equal(t, Stats{}, m.Stats())
+
+	client := func(id, username string, queued int) *Client {
+		c := &Client{ID: id, Username: username, Send: make(chan *Message, 10)}
+		for range queued {
+			c.Send <- &Message{}
+		}
+		m.clients.put(c)
+		return c
+	}
+	client("1", "alice", 2)
+	client("2", "alice", 0).Lite = true
+	client("3", "bob", 5).downgraded.Store(true)
+
+	m.broadcast <- &Message{}
+	m.delivered.Add(42)
+
+	assert.Equal(t, Stats{
+		Connections:     3,
+		LiteConnections: 2,
+		BroadcastQueue:  1,
+		SendQueued:      7,
+		MaxSendQueue:    5,
+		Delivered:       42,
+	}, m.Stats())
+}
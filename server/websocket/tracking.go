@@ -0,0 +1,142 @@
+package websocket
+
+import (
+	"context"
+	"exc6/pkg/instance"
+	"exc6/pkg/logger"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Group messages sent with read receipts carry data.tracked. Writing one to a
+// member's connection records its delivery, and group read receipts record
+// it as read. Writing a direct message to its recipient's connection records
+// its delivery with the ReadTracker. Writes only queue the delivery, so a
+// slow Redis does not hold up the write pump; untracked group messages are
+// never recorded.
+
+const (
+	// TrackedDataKey marks a group message sent with read receipts
+	TrackedDataKey = "tracked"
+
+	deliveryQueueSize = 1024
+)
+
+var trackedDeliveries = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "ws_tracked_deliveries_total",
+		Help: "Deliveries of direct messages and of group messages sent with read receipts, by whether they were recorded",
+	},
+	[]string{"outcome"}, // recorded, failed, dropped
+)
+
+func init() {
+	instance.Registerer().MustRegister(trackedDeliveries)
+}
+
+// DeliveryTracker records deliveries and reads of tracked group messages
+type DeliveryTracker interface {
+	MarkTrackedDelivered(ctx context.Context, username, groupID, messageID string) error
+	MarkTrackedRead(ctx context.Context, username, groupID, messageID string, upTo int64) error
+}
+
+// delivery is a tracked group message, or a direct message, written to its
+// recipient's connection
+type delivery struct {
+	username  string
+	groupID   string
+	messageID string
+
+	// sender and timestamp are set for direct messages
+	sender    string
+	timestamp int64
+}
+
+// SetDeliveryTracker sets where deliveries and reads of tracked messages are
+// recorded
+func (m *Manager) SetDeliveryTracker(tracker DeliveryTracker) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.deliveryTracker = tracker
+}
+
+// isTracked reports whether message is a group message sent with read receipts
+func isTracked(message *Message) bool {
+	if message.Type != MessageTypeGroupChat || message.ID == "" {
+		return false
+	}
+	tracked, _ := message.Data[TrackedDataKey].(bool)
+	return tracked
+}
+
+// isDelivery reports whether writing message to username's connection
+// delivers a direct message to its recipient
+func isDelivery(username string, message *Message) bool {
+	return message.Type == MessageTypeChat && message.ID != "" && message.To == username && message.From != username
+}
+
+// trackDelivery queues the delivery of a tracked or direct message to username
+func (m *Manager) trackDelivery(username string, message *Message) {
+	d := delivery{username: username, groupID: message.GroupID, messageID: message.ID}
+	if message.GroupID == "" {
+		d.sender, d.timestamp = message.From, message.Timestamp
+	}
+
+	select {
+	case m.deliveries <- d:
+	default:
+		trackedDeliveries.WithLabelValues("dropped").Inc()
+	}
+}
+
+func (m *Manager) runDeliveries() {
+	for {
+		select {
+		case d := <-m.deliveries:
+			m.mu.RLock()
+			tracker, readTracker := m.deliveryTracker, m.readTracker
+			m.mu.RUnlock()
+
+			var record func(ctx context.Context) error
+			switch {
+			case d.sender != "" && readTracker != nil:
+				record = func(ctx context.Context) error {
+					return readTracker.MarkDelivered(ctx, d.username, d.sender, d.messageID, d.timestamp)
+				}
+			case d.sender == "" && tracker != nil:
+				record = func(ctx context.Context) error {
+					return tracker.MarkTrackedDelivered(ctx, d.username, d.groupID, d.messageID)
+				}
+			default:
+				continue
+			}
+
+			ctx, cancel := context.WithTimeout(m.ctx, receiptTimeout)
+			err := record(ctx)
+			cancel()
+
+			if err != nil {
+				trackedDeliveries.WithLabelValues("failed").Inc()
+				continue
+			}
+			trackedDeliveries.WithLabelValues("recorded").Inc()
+
+		case <-m.ctx.Done():
+			return
+		}
+	}
+}
+
+// markTrackedRead applies a group read receipt to tracked messages
+func (m *Manager) markTrackedRead(tracker DeliveryTracker, msg *Message) {
+	ctx, cancel := context.WithTimeout(m.ctx, receiptTimeout)
+	defer cancel()
+
+	if err := tracker.MarkTrackedRead(ctx, msg.From, msg.GroupID, msg.ID, msg.Timestamp); err != nil {
+		logger.WithFields(map[string]any{
+			"reader":   msg.From,
+			"group_id": msg.GroupID,
+			"error":    err.Error(),
+		}).Warn("Failed to record read of tracked messages")
+	}
+}
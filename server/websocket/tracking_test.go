@@ -0,0 +1,67 @@
+package websocket
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsTracked(t *testing.T) {
+	tests := []struct {
+		name    string
+		msg     Message
+		tracked bool
+	}{
+		{name: "Tracked group message", msg: Message{Type: MessageTypeGroupChat, ID: "m1", Data: map[string]any{TrackedDataKey: true}}, tracked: true},
+		{name: "Plain group message", msg: Message{Type: MessageTypeGroupChat, ID: "m1"}},
+		{name: "Flag set to false", msg: Message{Type: MessageTypeGroupChat, ID: "m1", Data: map[string]any{TrackedDataKey: false}}},
+		{name: "Flag not a boolean", msg: Message{Type: MessageTypeGroupChat, ID: "m1", Data: map[string]any{TrackedDataKey: "yes"}}},
+		{name: "Direct message", msg: Message{Type: MessageTypeChat, ID: "m1", Data: map[string]any{TrackedDataKey: true}}},
+		{name: "Missing message ID", msg: Message{Type: MessageTypeGroupChat, Data: map[string]any{TrackedDataKey: true}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.tracked, isTracked(&tt.msg))
+		})
+	}
+}
+
+func TestIsDelivery(t *testing.T) {
+	tests := []struct {
+		name     string
+		msg      Message
+		delivery bool
+	}{
+		{name: "Direct message to the user", msg: Message{Type: MessageTypeChat, ID: "m1", From: "alice", To: "bob"}, delivery: true},
+		{name: "Own message echoed back", msg: Message{Type: MessageTypeChat, ID: "m1", From: "bob", To: "alice"}},
+		{name: "Message to oneself", msg: Message{Type: MessageTypeChat, ID: "m1", From: "bob", To: "bob"}},
+		{name: "Missing message ID", msg: Message{Type: MessageTypeChat, From: "alice", To: "bob"}},
+		{name: "Group message", msg: Message{Type: MessageTypeGroupChat, ID: "m1", From: "alice", To: "bob", GroupID: "g1"}},
+		{name: "Read receipt", msg: Message{Type: MessageTypeRead, ID: "m1", From: "alice", To: "bob"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.delivery, isDelivery("bob", &tt.msg))
+		})
+	}
+}
+
+func TestTrackDeliveryRecordsDirectPosition(t *testing.T) {
+	m := &Manager{deliveries: make(chan delivery, 1)}
+	m.trackDelivery("bob", &Message{Type: MessageTypeChat, ID: "m1", From: "alice", To: "bob", Timestamp: 1700000000})
+
+	assert.Equal(t, delivery{username: "bob", messageID: "m1", sender: "alice", timestamp: 1700000000}, <-m.deliveries)
+}
+
+func TestTrackDeliveryDropsWhenQueueFull(t *testing.T) {
+	m := &Manager{deliveries: make(chan delivery, 1)}
+	msg := &Message{Type: MessageTypeGroupChat, ID: "m1", GroupID: "g1"}
+
+	m.trackDelivery("alice", msg)
+	m.trackDelivery("bob", msg)
+
+	assert.Len(t, m.deliveries, 1)
+	assert.Equal(t, delivery{username: "alice", groupID: "g1", messageID: "m1"}, <-m.deliveries)
+}
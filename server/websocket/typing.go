@@ -0,0 +1,111 @@
+package websocket
+
+import (
+	"context"
+	"exc6/pkg/instance"
+	"exc6/pkg/logger"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Typing events are the simple form of activity states for clients that only
+// show "typing…": a client sends one per keystroke burst and the recipients
+// show the indicator for a few seconds. They skip the activity batcher and
+// go straight to the TypingPublisher, which suppresses repeats and fans them
+// out over Redis Pub/Sub. Clients sending more than typingRateLimit events per
+// second have the excess dropped here.
+
+const (
+	// MessageTypeTyping is a typing event. From a client it names the
+	// conversation in To or GroupID. To clients it arrives with From set to
+	// the typist and data "expires_in" (seconds to show the indicator).
+	MessageTypeTyping MessageType = "typing"
+
+	// typingRateLimit is how many typing events a client may send per second
+	typingRateLimit = 3
+
+	typingTimeout = time.Second
+)
+
+var typingTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "ws_typing_events_total",
+		Help: "Typing events received from clients by outcome",
+	},
+	[]string{"result"}, // result: published, rejected, throttled, failed
+)
+
+func init() {
+	instance.Registerer().MustRegister(typingTotal)
+}
+
+// TypingPublisher fans typing events out to the other participants
+type TypingPublisher interface {
+	PublishTyping(ctx context.Context, from, to, groupID string) error
+}
+
+// typingThrottle counts a client's typing events in the current second. It
+// is only used by the client's read pump.
+type typingThrottle struct {
+	window time.Time
+	count  int
+}
+
+// allow reports whether another event fits in the second that started the
+// window, starting a new window once it passed
+func (t *typingThrottle) allow(now time.Time) bool {
+	if now.Sub(t.window) >= time.Second {
+		t.window = now
+		t.count = 0
+	}
+	t.count++
+	return t.count <= typingRateLimit
+}
+
+// SetTypingPublisher sets where typing events from clients are published
+func (m *Manager) SetTypingPublisher(publisher TypingPublisher) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.typingPublisher = publisher
+}
+
+// handleTyping publishes a typing event from the client
+func (c *Client) handleTyping(msg *Message) {
+	if (msg.To == "") == (msg.GroupID == "") || msg.To == msg.From {
+		typingTotal.WithLabelValues("rejected").Inc()
+		return
+	}
+	if !c.typing.allow(time.Now()) {
+		typingTotal.WithLabelValues("throttled").Inc()
+		return
+	}
+
+	m := c.Manager
+	m.mu.RLock()
+	publisher := m.typingPublisher
+	m.mu.RUnlock()
+	if publisher == nil {
+		return
+	}
+
+	if msg.GroupID != "" && !m.isGroupMember(msg.GroupID, msg.From) {
+		typingTotal.WithLabelValues("rejected").Inc()
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(m.ctx, typingTimeout)
+	defer cancel()
+
+	if err := publisher.PublishTyping(ctx, msg.From, msg.To, msg.GroupID); err != nil {
+		typingTotal.WithLabelValues("failed").Inc()
+		logger.WithFields(map[string]any{
+			"from":     msg.From,
+			"to":       msg.To,
+			"group_id": msg.GroupID,
+			"error":    err.Error(),
+		}).Debug("Failed to publish typing event")
+		return
+	}
+	typingTotal.WithLabelValues("published").Inc()
+}
@@ -0,0 +1,54 @@
+package websocket
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeTypingPublisher struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (p *fakeTypingPublisher) PublishTyping(ctx context.Context, from, to, groupID string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.events = append(p.events, from+">"+to+groupID)
+	return nil
+}
+
+func TestTypingThrottle(t *testing.T) {
+	var throttle typingThrottle
+	now := time.Now()
+
+	for i := 0; i < typingRateLimit; i++ {
+		assert.True(t, throttle.allow(now.Add(time.Duration(i)*time.Millisecond)))
+	}
+	assert.False(t, throttle.allow(now.Add(500*time.Millisecond)), "over the limit within a second")
+	assert.True(t, throttle.allow(now.Add(time.Second)), "a new second starts a new window")
+}
+
+func TestHandleTyping(t *testing.T) {
+	publisher := &fakeTypingPublisher{}
+	m := &Manager{ctx: context.Background(), mu: &sync.RWMutex{}, typingPublisher: publisher}
+	c := &Client{Username: "alice", Manager: m}
+
+	c.handleTyping(&Message{Type: MessageTypeTyping, From: "alice"})
+	c.handleTyping(&Message{Type: MessageTypeTyping, From: "alice", To: "alice"})
+	c.handleTyping(&Message{Type: MessageTypeTyping, From: "alice", To: "bob", GroupID: "g1"})
+	assert.Empty(t, publisher.events, "events without exactly one other conversation are rejected")
+
+	for i := 0; i < typingRateLimit+2; i++ {
+		c.handleTyping(&Message{Type: MessageTypeTyping, From: "alice", To: "bob"})
+	}
+	assert.Len(t, publisher.events, typingRateLimit, "excess events are throttled")
+
+	// Without a group service membership cannot be confirmed
+	c.typing = typingThrottle{}
+	c.handleTyping(&Message{Type: MessageTypeTyping, From: "alice", GroupID: "g1"})
+	assert.Len(t, publisher.events, typingRateLimit)
+}
@@ -0,0 +1,362 @@
+package activity
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"exc6/pkg/breaker"
+	"exc6/pkg/keyspace"
+	"exc6/pkg/logger"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/sony/gobreaker"
+)
+
+// Each user has a sorted set of the conversations in their contact list that
+// changed, scored by the time of the change in unix milliseconds. Group
+// messages are recorded once per group instead of per member; a member
+// reading a group is recorded in their own set.
+const (
+	userKeyPrefix = "activity:user:"
+	groupsKey     = "activity:groups"
+
+	// profilesKey holds the last time any user's name or avatar changed
+	profilesKey = "activity:profiles"
+
+	// listMember marks a change to the set of conversations itself (friend
+	// added or removed, group joined or left); clients must reload the list
+	listMember = "*"
+
+	userPrefix  = "user:"
+	groupPrefix = "group:"
+
+	// Retention is how long changes are remembered; older cursors get the full list
+	Retention = 30 * 24 * time.Hour
+)
+
+func init() {
+	keyspace.Register(
+		keyspace.Family{Prefix: userKeyPrefix, Description: "contact list changes per user"},
+		keyspace.Family{Prefix: groupsKey, Description: "last message time per group"},
+		keyspace.Family{Prefix: profilesKey, Description: "last profile change"},
+	)
+}
+
+// Changes is what changed in a user's contact list since a cursor
+type Changes struct {
+	// Version is the time of the latest change, in unix milliseconds
+	Version int64
+
+	// Cursor is passed back to Changes to get what changed next. It holds
+	// Version and the changes recorded at that millisecond, so those are not
+	// sent again while others recorded within the same millisecond are.
+	Cursor string
+
+	// Full is set when the whole list must be reloaded
+	Full bool
+
+	Users  []string
+	Groups []string
+}
+
+// Tracker records when conversations change so contact lists can be
+// revalidated and fetched as deltas. A nil Tracker records nothing.
+type Tracker struct {
+	rdb *redis.Client
+	cb  *gobreaker.CircuitBreaker
+}
+
+// NewTracker creates a tracker backed by Redis
+func NewTracker(rdb *redis.Client) *Tracker {
+	return &Tracker{
+		rdb: rdb,
+		cb: breaker.New(breaker.Config{
+			Name:        "redis-activity",
+			MaxRequests: 5,
+			Interval:    60 * time.Second,
+			Timeout:     30 * time.Second,
+			Threshold:   0.5,
+			MinRequests: 5,
+		}),
+	}
+}
+
+// TouchConversation records a direct message between two users
+func (t *Tracker) TouchConversation(ctx context.Context, user1, user2 string) {
+	t.touch(ctx, "direct", func(ctx context.Context, pipe redis.Pipeliner, now float64) {
+		t.add(ctx, pipe, user1, userPrefix+user2, now)
+		if user1 != user2 {
+			t.add(ctx, pipe, user2, userPrefix+user1, now)
+		}
+	})
+}
+
+// TouchRead records that username read their conversation with other
+func (t *Tracker) TouchRead(ctx context.Context, username, other string) {
+	t.touch(ctx, "read", func(ctx context.Context, pipe redis.Pipeliner, now float64) {
+		t.add(ctx, pipe, username, userPrefix+other, now)
+	})
+}
+
+// TouchGroupRead records that username read a group
+func (t *Tracker) TouchGroupRead(ctx context.Context, username, groupID string) {
+	t.touch(ctx, "read", func(ctx context.Context, pipe redis.Pipeliner, now float64) {
+		t.add(ctx, pipe, username, groupPrefix+groupID, now)
+	})
+}
+
+// TouchGroup records a message in a group
+func (t *Tracker) TouchGroup(ctx context.Context, groupID string) {
+	t.touch(ctx, "group", func(ctx context.Context, pipe redis.Pipeliner, now float64) {
+		pipe.ZAdd(ctx, groupsKey, redis.Z{Score: now, Member: groupID})
+		pipe.ZRemRangeByScore(ctx, groupsKey, "-inf", strconv.FormatInt(int64(now)-Retention.Milliseconds(), 10))
+		pipe.Expire(ctx, groupsKey, Retention)
+	})
+}
+
+// TouchList records that the contact lists of the given users changed as a whole
+func (t *Tracker) TouchList(ctx context.Context, usernames ...string) {
+	if len(usernames) == 0 {
+		return
+	}
+
+	t.touch(ctx, "list", func(ctx context.Context, pipe redis.Pipeliner, now float64) {
+		for _, username := range usernames {
+			t.add(ctx, pipe, username, listMember, now)
+		}
+	})
+}
+
+// TouchProfiles records that a user's name or avatar changed. Every contact
+// list may show that user, so all clients reload their lists.
+func (t *Tracker) TouchProfiles(ctx context.Context) {
+	t.touch(ctx, "profiles", func(ctx context.Context, pipe redis.Pipeliner, now float64) {
+		pipe.Set(ctx, profilesKey, int64(now), Retention)
+	})
+}
+
+// Changes returns what changed in the contact list of username since the
+// cursor of an earlier call. groupIDs are the groups the user belongs to.
+// An empty cursor always returns Full.
+func (t *Tracker) Changes(ctx context.Context, username string, groupIDs []string, since string) (*Changes, error) {
+	if t == nil {
+		return &Changes{Full: true}, nil
+	}
+
+	sinceAt, sent := parseCursor(since)
+
+	var (
+		changed  *redis.ZSliceCmd
+		latest   *redis.ZSliceCmd
+		groups   *redis.FloatSliceCmd
+		profiles *redis.StringCmd
+	)
+
+	_, err := breaker.ExecuteCtx(ctx, t.cb, func() (interface{}, error) {
+		// MULTI keeps the changes and the version consistent with each other.
+		// The cursor's millisecond is included, as changes may have been
+		// recorded in it after the cursor was handed out.
+		pipe := t.rdb.TxPipeline()
+		changed = pipe.ZRangeByScoreWithScores(ctx, userKeyPrefix+username, &redis.ZRangeBy{
+			Min: strconv.FormatInt(sinceAt, 10),
+			Max: "+inf",
+		})
+		latest = pipe.ZRevRangeWithScores(ctx, userKeyPrefix+username, 0, 0)
+		if len(groupIDs) > 0 {
+			groups = pipe.ZMScore(ctx, groupsKey, groupIDs...)
+		}
+		profiles = pipe.Get(ctx, profilesKey)
+
+		_, err := pipe.Exec(ctx)
+		if err == redis.Nil {
+			// Only the profiles key may be missing
+			err = nil
+		}
+		return nil, err
+	})
+	if err != nil {
+		logger.WithFields(map[string]interface{}{
+			"username": username,
+			"error":    err.Error(),
+		}).Warn("Circuit breaker: Failed to read conversation activity")
+		return nil, err
+	}
+
+	result := &Changes{
+		Full: sinceAt <= 0 || sinceAt < time.Now().Add(-Retention).UnixMilli(),
+	}
+
+	if entries := latest.Val(); len(entries) > 0 {
+		result.Version = int64(entries[0].Score)
+	}
+
+	records := make([]change, 0, len(changed.Val())+len(groupIDs)+1)
+	for _, entry := range changed.Val() {
+		member, _ := entry.Member.(string)
+		records = append(records, change{id: member, at: int64(entry.Score)})
+	}
+	if groups != nil {
+		for i, score := range groups.Val() {
+			records = append(records, change{id: groupMessagePrefix + groupIDs[i], at: int64(score)})
+			result.Version = max(result.Version, int64(score))
+		}
+	}
+	if profilesChanged, _ := profiles.Int64(); profilesChanged > 0 {
+		records = append(records, change{id: profilesID, at: profilesChanged})
+		result.Version = max(result.Version, profilesChanged)
+	}
+
+	groupChanged := make(map[string]bool)
+	for _, record := range records {
+		if record.at < sinceAt || record.at == sinceAt && sent[changeHash(record.id)] {
+			continue
+		}
+
+		switch {
+		case record.id == listMember, record.id == profilesID:
+			result.Full = true
+		case strings.HasPrefix(record.id, userPrefix):
+			result.Users = append(result.Users, strings.TrimPrefix(record.id, userPrefix))
+		case strings.HasPrefix(record.id, groupPrefix):
+			groupChanged[strings.TrimPrefix(record.id, groupPrefix)] = true
+		case strings.HasPrefix(record.id, groupMessagePrefix):
+			groupChanged[strings.TrimPrefix(record.id, groupMessagePrefix)] = true
+		}
+	}
+	for _, groupID := range groupIDs {
+		if groupChanged[groupID] {
+			result.Groups = append(result.Groups, groupID)
+		}
+	}
+
+	if result.Version < sinceAt {
+		// Nothing was recorded since; the records of the cursor's
+		// millisecond may have been trimmed
+		result.Version, result.Cursor = sinceAt, since
+	} else {
+		result.Cursor = newCursor(records, result.Version)
+	}
+
+	return result, nil
+}
+
+// change is a recorded change: a member of a user's set, a group message or
+// a profile change
+type change struct {
+	id string
+	at int64
+}
+
+const (
+	// groupMessagePrefix and profilesID name changes recorded outside the
+	// user's set, so they are told apart in cursors
+	groupMessagePrefix = "message:"
+	profilesID         = "profiles"
+
+	// maxCursorChanges bounds the changes a cursor lists; past it the
+	// changes of its millisecond are all sent again
+	maxCursorChanges = 32
+)
+
+// newCursor returns the cursor of the changes recorded up to at: the
+// millisecond followed by hashes of the changes recorded in it
+func newCursor(records []change, at int64) string {
+	hashes := make([]string, 0, 4)
+	for _, record := range records {
+		if record.at == at {
+			hashes = append(hashes, changeHash(record.id))
+		}
+	}
+
+	cursor := strconv.FormatInt(at, 10)
+	if len(hashes) == 0 || len(hashes) > maxCursorChanges {
+		return cursor
+	}
+	sort.Strings(hashes)
+	return cursor + "-" + strings.Join(hashes, ".")
+}
+
+// parseCursor returns the millisecond of a cursor and the hashes of the
+// changes already sent for it
+func parseCursor(cursor string) (int64, map[string]bool) {
+	at, hashes, _ := strings.Cut(cursor, "-")
+	ms, err := strconv.ParseInt(at, 10, 64)
+	if err != nil {
+		return 0, nil
+	}
+
+	sent := make(map[string]bool)
+	for _, hash := range strings.Split(hashes, ".") {
+		if hash != "" {
+			sent[hash] = true
+		}
+	}
+	return ms, sent
+}
+
+// changeHash shortens a change's ID for cursors
+func changeHash(id string) string {
+	sum := sha1.Sum([]byte(id))
+	return hex.EncodeToString(sum[:4])
+}
+
+// Enabled reports whether changes are recorded. Callers that record them in
+// their own Redis scripts check it and use the keys below.
+func (t *Tracker) Enabled() bool {
+	return t != nil
+}
+
+// UserKey is the sorted set of changes in username's contact list. Writers
+// score members with the time in unix milliseconds, trim entries older than
+// Retention and extend the TTL to Retention.
+func UserKey(username string) string {
+	return userKeyPrefix + username
+}
+
+// ConversationMember is the member of a UserKey set recording a change to
+// the direct conversation with other
+func ConversationMember(other string) string {
+	return userPrefix + other
+}
+
+// GroupsKey is the sorted set of the last message time per group, kept like
+// a UserKey set
+func GroupsKey() string {
+	return groupsKey
+}
+
+// touch runs a best-effort write; a missed change only delays a client's update
+func (t *Tracker) touch(ctx context.Context, kind string, write func(ctx context.Context, pipe redis.Pipeliner, now float64)) {
+	if t == nil {
+		return
+	}
+
+	// The request context may already be close to its deadline
+	writeCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 2*time.Second)
+	defer cancel()
+
+	_, err := breaker.ExecuteCtx(writeCtx, t.cb, func() (interface{}, error) {
+		pipe := t.rdb.Pipeline()
+		write(writeCtx, pipe, float64(time.Now().UnixMilli()))
+		_, err := pipe.Exec(writeCtx)
+		return nil, err
+	})
+	if err != nil {
+		logger.WithFields(map[string]interface{}{
+			"kind":  kind,
+			"error": err.Error(),
+		}).Warn("Circuit breaker: Failed to record conversation activity")
+	}
+}
+
+// add records a change for one user and trims entries past the retention
+func (t *Tracker) add(ctx context.Context, pipe redis.Pipeliner, username, member string, now float64) {
+	key := userKeyPrefix + username
+	pipe.ZAdd(ctx, key, redis.Z{Score: now, Member: member})
+	pipe.ZRemRangeByScore(ctx, key, "-inf", strconv.FormatInt(int64(now)-Retention.Milliseconds(), 10))
+	pipe.Expire(ctx, key, Retention)
+}
@@ -0,0 +1,124 @@
+package activity
+
+import (
+	"context"
+	"exc6/tests/fakeredis"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTracker(t *testing.T) (*Tracker, *redis.Client) {
+	rdb := fakeredis.New(t).Client(t)
+	return NewTracker(rdb), rdb
+}
+
+// record adds a change to username's set at the given millisecond, as the
+// chat service's scripts do
+func record(t *testing.T, rdb *redis.Client, username, member string, at int64) {
+	t.Helper()
+	require.NoError(t, rdb.ZAdd(context.Background(), UserKey(username), redis.Z{Score: float64(at), Member: member}).Err())
+}
+
+func TestChangesSameMillisecond(t *testing.T) {
+	tracker, rdb := newTracker(t)
+	ctx := context.Background()
+	at := time.Now().UnixMilli()
+
+	record(t, rdb, "alice", ConversationMember("bob"), at)
+	changes, err := tracker.Changes(ctx, "alice", nil, "")
+	require.NoError(t, err)
+	assert.True(t, changes.Full)
+	assert.Equal(t, at, changes.Version)
+	first := changes.Cursor
+
+	// Nothing new: the change at the cursor's millisecond was already sent
+	changes, err = tracker.Changes(ctx, "alice", nil, first)
+	require.NoError(t, err)
+	assert.False(t, changes.Full)
+	assert.Empty(t, changes.Users)
+	assert.Equal(t, first, changes.Cursor)
+
+	// Recorded within the same millisecond after the cursor was handed out
+	record(t, rdb, "alice", ConversationMember("carol"), at)
+	changes, err = tracker.Changes(ctx, "alice", nil, first)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"carol"}, changes.Users, "only the change not sent yet")
+	assert.Equal(t, at, changes.Version)
+	assert.NotEqual(t, first, changes.Cursor, "the list changed, so must its version")
+
+	changes, err = tracker.Changes(ctx, "alice", nil, changes.Cursor)
+	require.NoError(t, err)
+	assert.Empty(t, changes.Users)
+
+	// Clients holding a bare millisecond get its changes again
+	changes, err = tracker.Changes(ctx, "alice", nil, strconv.FormatInt(at, 10))
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"bob", "carol"}, changes.Users)
+}
+
+func TestChanges(t *testing.T) {
+	tracker, rdb := newTracker(t)
+	ctx := context.Background()
+	at := time.Now().UnixMilli() - 1000
+
+	record(t, rdb, "alice", ConversationMember("bob"), at)
+	require.NoError(t, rdb.ZAdd(ctx, GroupsKey(), redis.Z{Score: float64(at), Member: "g1"}, redis.Z{Score: float64(at), Member: "g2"}).Err())
+	changes, err := tracker.Changes(ctx, "alice", []string{"g1", "g2"}, "")
+	require.NoError(t, err)
+	cursor := changes.Cursor
+
+	record(t, rdb, "alice", ConversationMember("carol"), at+1)
+	record(t, rdb, "alice", groupPrefix+"g2", at+2)
+	require.NoError(t, rdb.ZAdd(ctx, GroupsKey(), redis.Z{Score: float64(at + 3), Member: "g1"}, redis.Z{Score: float64(at + 3), Member: "g3"}).Err())
+
+	changes, err = tracker.Changes(ctx, "alice", []string{"g1", "g2"}, cursor)
+	require.NoError(t, err)
+	assert.False(t, changes.Full)
+	assert.Equal(t, []string{"carol"}, changes.Users)
+	assert.Equal(t, []string{"g1", "g2"}, changes.Groups, "messages and reads in the user's groups only")
+	assert.Equal(t, at+3, changes.Version)
+
+	t.Run("List changes reload the list", func(t *testing.T) {
+		tracker.TouchList(ctx, "alice")
+		changes, err := tracker.Changes(ctx, "alice", nil, cursor)
+		require.NoError(t, err)
+		assert.True(t, changes.Full)
+	})
+
+	t.Run("Profile changes reload every list", func(t *testing.T) {
+		changes, err := tracker.Changes(ctx, "dave", nil, cursor)
+		require.NoError(t, err)
+		require.False(t, changes.Full)
+
+		tracker.TouchProfiles(ctx)
+		changes, err = tracker.Changes(ctx, "dave", nil, cursor)
+		require.NoError(t, err)
+		assert.True(t, changes.Full)
+
+		changes, err = tracker.Changes(ctx, "dave", nil, changes.Cursor)
+		require.NoError(t, err)
+		assert.False(t, changes.Full, "once")
+	})
+
+	t.Run("Cursors past the retention get the full list", func(t *testing.T) {
+		old := strconv.FormatInt(time.Now().Add(-Retention-time.Hour).UnixMilli(), 10)
+		changes, err := tracker.Changes(ctx, "alice", nil, old)
+		require.NoError(t, err)
+		assert.True(t, changes.Full)
+	})
+}
+
+func TestNilTracker(t *testing.T) {
+	var tracker *Tracker
+	tracker.TouchConversation(context.Background(), "alice", "bob")
+
+	changes, err := tracker.Changes(context.Background(), "alice", nil, "123")
+	require.NoError(t, err)
+	assert.True(t, changes.Full)
+	assert.False(t, tracker.Enabled())
+}
@@ -0,0 +1,114 @@
+package bots
+
+import (
+	"context"
+	"exc6/services/chat"
+	"strings"
+)
+
+// CommandPrefix starts a bot command in a chat message, e.g. "/remind 10m stretch"
+const CommandPrefix = "/"
+
+// Permission is a bitmask of what a bot may see and do
+type Permission uint8
+
+const (
+	// PermReadDirect delivers direct messages sent to the bot to OnMessage
+	PermReadDirect Permission = 1 << iota
+
+	// PermReadGroup delivers every message of groups the bot belongs to to OnMessage
+	PermReadGroup
+
+	// PermGroupCommands routes /commands in groups the bot belongs to to OnCommand.
+	// Commands sent in a direct message to the bot are always routed.
+	PermGroupCommands
+
+	// PermSendDirect allows the bot to send direct messages
+	PermSendDirect
+
+	// PermSendGroup allows the bot to post in groups it belongs to
+	PermSendGroup
+)
+
+// Has reports whether every permission in want is granted
+func (p Permission) Has(want Permission) bool {
+	return p&want == want
+}
+
+// DefaultRateLimit is the number of events a bot handles per conversation per minute
+const DefaultRateLimit = 20
+
+// Info describes a bot to the engine
+type Info struct {
+	// Username of the bot's account; created on registration if missing
+	Username string
+
+	Description string
+
+	// Commands this bot handles, without the prefix (e.g. "remind")
+	Commands []string
+
+	Permissions Permission
+
+	// RateLimit caps handled events per conversation per minute.
+	// Zero uses DefaultRateLimit.
+	RateLimit int
+}
+
+// Command is a parsed /command
+type Command struct {
+	Name    string
+	Args    []string
+	RawArgs string
+	Message *chat.ChatMessage
+}
+
+// Responder sends messages on behalf of a bot. It checks the bot's send
+// permissions and can be kept to respond later (e.g. from a timer).
+type Responder interface {
+	// Reply posts into the conversation the event came from
+	Reply(ctx context.Context, content string) error
+
+	// SendDirect sends a direct message to a user
+	SendDirect(ctx context.Context, to, content string) error
+}
+
+// Bot is an in-process chat bot. OnMessage receives plain messages the bot is
+// permitted to read; OnCommand receives /commands listed in Info().Commands.
+// Returning an *apperrors.AppError replies with its message to the sender;
+// other errors are logged.
+type Bot interface {
+	Info() Info
+	OnMessage(ctx context.Context, msg *chat.ChatMessage, r Responder) error
+	OnCommand(ctx context.Context, cmd *Command, r Responder) error
+}
+
+// ParseCommand parses a message starting with CommandPrefix. It returns nil
+// for anything else.
+func ParseCommand(msg *chat.ChatMessage) *Command {
+	content := strings.TrimSpace(msg.Content)
+	if !strings.HasPrefix(content, CommandPrefix) || len(content) == len(CommandPrefix) {
+		return nil
+	}
+
+	name, rawArgs, _ := strings.Cut(content[len(CommandPrefix):], " ")
+	if name == "" {
+		return nil
+	}
+
+	rawArgs = strings.TrimSpace(rawArgs)
+
+	return &Command{
+		Name:    strings.ToLower(name),
+		Args:    strings.Fields(rawArgs),
+		RawArgs: rawArgs,
+		Message: msg,
+	}
+}
+
+// Builtin returns the optional bots shipped with the server, enabled through BOTS_ENABLED
+func Builtin() []Bot {
+	return []Bot{
+		NewEchoBot(),
+	}
+}
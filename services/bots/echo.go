@@ -0,0 +1,35 @@
+package bots
+
+import (
+	"context"
+	"exc6/apperrors"
+	"exc6/services/chat"
+)
+
+// EchoBot repeats direct messages and /echo commands. It is the minimal
+// example of the bot API.
+type EchoBot struct{}
+
+func NewEchoBot() *EchoBot {
+	return &EchoBot{}
+}
+
+func (b *EchoBot) Info() Info {
+	return Info{
+		Username:    "echobot",
+		Description: "Repeats what you say",
+		Commands:    []string{"echo"},
+		Permissions: PermReadDirect | PermGroupCommands | PermSendDirect | PermSendGroup,
+	}
+}
+
+func (b *EchoBot) OnMessage(ctx context.Context, msg *chat.ChatMessage, r Responder) error {
+	return r.Reply(ctx, "🔁 "+msg.Content)
+}
+
+func (b *EchoBot) OnCommand(ctx context.Context, cmd *Command, r Responder) error {
+	if cmd.RawArgs == "" {
+		return apperrors.NewValidationError("Usage: /echo <text>")
+	}
+	return r.Reply(ctx, "🔁 "+cmd.RawArgs)
+}
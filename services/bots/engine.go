@@ -0,0 +1,393 @@
+package bots
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"exc6/apperrors"
+	"exc6/db"
+	"exc6/pkg/cache"
+	"exc6/pkg/logger"
+	"exc6/server/middleware/limiter"
+	"exc6/services/chat"
+	"exc6/services/groups"
+	"exc6/utils"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	queueSize   = 1000
+	workerCount = 4
+
+	// handlerTimeout bounds a single OnMessage/OnCommand call
+	handlerTimeout = 10 * time.Second
+
+	// membershipTTL is how long a bot's group membership check is cached
+	membershipTTL = time.Minute
+
+	// botRole is the role of bot accounts
+	botRole = "bot"
+)
+
+// Engine routes chat messages to registered bots. It observes messages through
+// a chat.MessageHook, so every message is dispatched once, by the instance that
+// accepted it.
+type Engine struct {
+	qdb  *db.Queries
+	csrv chat.Service
+	gsrv *groups.GroupService
+
+	mu       sync.RWMutex
+	bots     map[string]Bot // by username
+	commands map[string]Bot // by command name
+
+	limits      *cache.Local[string, *limiter.TokenBucket]
+	memberships *cache.Local[string, bool]
+
+	queue chan *chat.ChatMessage
+	wg    sync.WaitGroup
+	ctx   context.Context
+}
+
+func NewEngine(ctx context.Context, qdb *db.Queries, csrv chat.Service, gsrv *groups.GroupService) *Engine {
+	e := &Engine{
+		qdb:         qdb,
+		csrv:        csrv,
+		gsrv:        gsrv,
+		bots:        make(map[string]Bot),
+		commands:    make(map[string]Bot),
+		limits:      cache.NewLocal[string, *limiter.TokenBucket](10000, 10*time.Minute),
+		memberships: cache.NewLocal[string, bool](10000, membershipTTL),
+		queue:       make(chan *chat.ChatMessage, queueSize),
+		ctx:         ctx,
+	}
+
+	for i := 0; i < workerCount; i++ {
+		e.wg.Add(1)
+		go e.worker()
+	}
+
+	csrv.AddMessageHook(e.enqueue)
+
+	return e
+}
+
+// Register adds a bot, creating its account if needed. Command names must be unique.
+func (e *Engine) Register(ctx context.Context, bot Bot) error {
+	info := bot.Info()
+	if err := utils.ValidateUsername(info.Username); err != nil {
+		return fmt.Errorf("invalid bot username %q: %s", info.Username, err.Message)
+	}
+
+	e.mu.Lock()
+	if _, exists := e.bots[info.Username]; exists {
+		e.mu.Unlock()
+		return fmt.Errorf("bot %s is already registered", info.Username)
+	}
+	for _, name := range info.Commands {
+		if owner, exists := e.commands[name]; exists {
+			e.mu.Unlock()
+			return fmt.Errorf("command /%s of bot %s is already handled by %s", name, info.Username, owner.Info().Username)
+		}
+	}
+	e.mu.Unlock()
+
+	if err := e.ensureAccount(ctx, info.Username); err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	e.bots[info.Username] = bot
+	for _, name := range info.Commands {
+		e.commands[name] = bot
+	}
+	e.mu.Unlock()
+
+	logger.WithFields(map[string]interface{}{
+		"bot":      info.Username,
+		"commands": info.Commands,
+	}).Info("Bot registered")
+
+	return nil
+}
+
+// ensureAccount creates the bot's user account if it doesn't exist yet.
+// The password is random and never stored, so the account cannot log in.
+// An existing account is only used if it is a bot's: a person who signed up
+// with the bot's username keeps their account.
+func (e *Engine) ensureAccount(ctx context.Context, username string) error {
+	user, err := e.qdb.GetUserByUsername(ctx, username)
+	if err == nil {
+		if user.Role != botRole {
+			return fmt.Errorf("bot username %s is taken by an account that is not a bot", username)
+		}
+		return nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("failed to look up bot account %s: %w", username, err)
+	}
+
+	passwordHash, appErr := utils.HashPassword(uuid.NewString())
+	if appErr != nil {
+		return appErr
+	}
+
+	if _, err := e.qdb.CreateBotUser(ctx, db.CreateBotUserParams{
+		Username:     username,
+		PasswordHash: passwordHash,
+		Icon:         sql.NullString{String: "solid-signal", Valid: true},
+		CustomIcon:   sql.NullString{String: "", Valid: true},
+	}); err != nil {
+		return fmt.Errorf("failed to create bot account %s: %w", username, err)
+	}
+
+	return nil
+}
+
+// IsBot reports whether username belongs to a registered bot
+func (e *Engine) IsBot(username string) bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	_, ok := e.bots[username]
+	return ok
+}
+
+// enqueue is the chat.MessageHook; it never blocks the sender
+func (e *Engine) enqueue(msg *chat.ChatMessage) {
+	// Bots never react to bots, which also prevents reply loops
+	if e.IsBot(msg.FromID) {
+		return
+	}
+
+	select {
+	case e.queue <- msg:
+	default:
+		logger.WithFields(map[string]interface{}{
+			"message_id": msg.MessageID,
+			"queue_size": len(e.queue),
+		}).Warn("Bot queue full, dropping message")
+	}
+}
+
+func (e *Engine) worker() {
+	defer e.wg.Done()
+
+	for {
+		select {
+		case msg := <-e.queue:
+			e.dispatch(msg)
+		case <-e.ctx.Done():
+			return
+		}
+	}
+}
+
+// Wait blocks until the workers have stopped after the engine's context is cancelled
+func (e *Engine) Wait() {
+	e.wg.Wait()
+}
+
+// dispatch routes a message to the bots allowed to see it
+func (e *Engine) dispatch(msg *chat.ChatMessage) {
+	cmd := ParseCommand(msg)
+
+	if !msg.IsGroup {
+		bot, ok := e.botByName(msg.ToID)
+		if !ok {
+			return
+		}
+
+		if cmd != nil && handlesCommand(bot, cmd.Name) {
+			e.handleCommand(bot, cmd)
+			return
+		}
+
+		if bot.Info().Permissions.Has(PermReadDirect) {
+			e.handleMessage(bot, msg)
+		}
+		return
+	}
+
+	if cmd != nil {
+		if bot, ok := e.botByCommand(cmd.Name); ok &&
+			bot.Info().Permissions.Has(PermGroupCommands) &&
+			e.isMember(bot.Info().Username, msg.GroupID) {
+			e.handleCommand(bot, cmd)
+		}
+		return
+	}
+
+	for _, bot := range e.botsWith(PermReadGroup) {
+		if e.isMember(bot.Info().Username, msg.GroupID) {
+			e.handleMessage(bot, msg)
+		}
+	}
+}
+
+func (e *Engine) handleMessage(bot Bot, msg *chat.ChatMessage) {
+	if !e.allow(bot, msg) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(e.ctx, handlerTimeout)
+	defer cancel()
+
+	r := e.responder(bot, msg)
+	e.handleError(bot, msg, r, bot.OnMessage(ctx, msg, r))
+}
+
+func (e *Engine) handleCommand(bot Bot, cmd *Command) {
+	if !e.allow(bot, cmd.Message) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(e.ctx, handlerTimeout)
+	defer cancel()
+
+	r := e.responder(bot, cmd.Message)
+	e.handleError(bot, cmd.Message, r, bot.OnCommand(ctx, cmd, r))
+}
+
+// handleError shows user-facing errors to the sender and logs everything else
+func (e *Engine) handleError(bot Bot, msg *chat.ChatMessage, r Responder, err error) {
+	if err == nil {
+		return
+	}
+
+	var appErr *apperrors.AppError
+	if errors.As(err, &appErr) {
+		ctx, cancel := context.WithTimeout(e.ctx, handlerTimeout)
+		defer cancel()
+		if replyErr := r.Reply(ctx, "⚠️ "+appErr.Message); replyErr == nil {
+			return
+		}
+	}
+
+	logger.WithFields(map[string]interface{}{
+		"bot":        bot.Info().Username,
+		"message_id": msg.MessageID,
+		"error":      err.Error(),
+	}).Error("Bot failed to handle message")
+}
+
+// allow applies the bot's per-conversation rate limit
+func (e *Engine) allow(bot Bot, msg *chat.ChatMessage) bool {
+	info := bot.Info()
+
+	limit := info.RateLimit
+	if limit <= 0 {
+		limit = DefaultRateLimit
+	}
+
+	conversation := msg.GroupID
+	if !msg.IsGroup {
+		conversation = msg.FromID
+	}
+	key := info.Username + ":" + conversation
+
+	bucket, ok := e.limits.Get(key)
+	if !ok {
+		bucket = limiter.NewTokenBucket(int64(limit), int64(limit), time.Minute)
+		e.limits.Set(key, bucket)
+	}
+
+	if !bucket.Take(1) {
+		logger.WithFields(map[string]interface{}{
+			"bot":          info.Username,
+			"conversation": conversation,
+		}).Debug("Bot rate limit exceeded, skipping message")
+		return false
+	}
+
+	return true
+}
+
+// isMember reports (with a short cache) whether a bot belongs to a group
+func (e *Engine) isMember(botName, groupID string) bool {
+	key := botName + ":" + groupID
+	if member, ok := e.memberships.Get(key); ok {
+		return member
+	}
+
+	ctx, cancel := context.WithTimeout(e.ctx, 3*time.Second)
+	defer cancel()
+
+	member, err := e.gsrv.IsMember(ctx, groupID, botName)
+	if err != nil {
+		return false
+	}
+
+	e.memberships.Set(key, member)
+	return member
+}
+
+func (e *Engine) botByName(username string) (Bot, bool) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	bot, ok := e.bots[username]
+	return bot, ok
+}
+
+func (e *Engine) botByCommand(name string) (Bot, bool) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	bot, ok := e.commands[name]
+	return bot, ok
+}
+
+func (e *Engine) botsWith(perm Permission) []Bot {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	var matched []Bot
+	for _, bot := range e.bots {
+		if bot.Info().Permissions.Has(perm) {
+			matched = append(matched, bot)
+		}
+	}
+	return matched
+}
+
+func handlesCommand(bot Bot, name string) bool {
+	for _, c := range bot.Info().Commands {
+		if c == name {
+			return true
+		}
+	}
+	return false
+}
+
+// responder sends on behalf of a bot, scoped to the conversation of msg
+type responder struct {
+	engine *Engine
+	bot    Info
+	msg    *chat.ChatMessage
+}
+
+func (e *Engine) responder(bot Bot, msg *chat.ChatMessage) Responder {
+	return &responder{engine: e, bot: bot.Info(), msg: msg}
+}
+
+func (r *responder) Reply(ctx context.Context, content string) error {
+	if r.msg.IsGroup {
+		if !r.bot.Permissions.Has(PermSendGroup) {
+			return apperrors.NewAuthorizationError(r.bot.Username, "group:"+r.msg.GroupID, "send")
+		}
+		_, err := r.engine.csrv.SendGroupMessage(ctx, r.bot.Username, r.msg.GroupID, content)
+		return err
+	}
+
+	return r.SendDirect(ctx, r.msg.FromID, content)
+}
+
+func (r *responder) SendDirect(ctx context.Context, to, content string) error {
+	if !r.bot.Permissions.Has(PermSendDirect) {
+		return apperrors.NewAuthorizationError(r.bot.Username, "user:"+to, "send")
+	}
+	_, err := r.engine.csrv.SendMessage(ctx, r.bot.Username, to, content)
+	return err
+}
@@ -0,0 +1,47 @@
+package bots
+
+import (
+	"context"
+	"exc6/tests/fakedb"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnsureAccount(t *testing.T) {
+	fake := fakedb.New(t)
+	e := &Engine{qdb: fake.Queries()}
+	ctx := context.Background()
+
+	roles := map[string]string{"echobot": botRole, "helpbot": "member"}
+	fake.On("GetUserByUsername", func(args []any) (fakedb.Result, error) {
+		username := args[0].(string)
+		role, ok := roles[username]
+		if !ok {
+			return fakedb.Result{}, nil
+		}
+		now := time.Now()
+		return fakedb.Row(uuid.New(), now, now, username, role, "hash", "solid-signal", ""), nil
+	})
+	fake.On("CreateBotUser", func(args []any) (fakedb.Result, error) {
+		now := time.Now()
+		return fakedb.Row(uuid.New(), now, now, args[0], botRole, args[1], args[2], args[3]), nil
+	})
+
+	require.NoError(t, e.ensureAccount(ctx, "echobot"), "an existing bot account")
+	assert.Empty(t, fake.Calls("CreateBotUser"))
+
+	err := e.ensureAccount(ctx, "helpbot")
+	require.Error(t, err, "a person signed up as helpbot")
+	assert.Contains(t, err.Error(), "not a bot")
+	assert.Empty(t, fake.Calls("CreateBotUser"))
+
+	require.NoError(t, e.ensureAccount(ctx, "newbot"))
+	created := fake.Calls("CreateBotUser")
+	require.Len(t, created, 1)
+	assert.Equal(t, "newbot", created[0][0])
+	assert.Empty(t, fake.Calls("CreateUser"), "never created as a regular account")
+}
@@ -0,0 +1,110 @@
+package bots
+
+import (
+	"context"
+	"exc6/apperrors"
+	"exc6/services/chat"
+	"exc6/services/reminders"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const reminderUsage = "Usage: /remind me in 2h to stretch, /remind me every 1d to drink water, /remind list, /remind cancel <number>"
+
+// ReminderBot is the chat front end of the reminders subsystem:
+//
+//	/remind me in 2h to stretch
+//	/remind me every 1d to drink water
+//	/remind list
+//	/remind cancel 2
+type ReminderBot struct {
+	rsrv *reminders.ReminderService
+}
+
+func NewReminderBot(rsrv *reminders.ReminderService) *ReminderBot {
+	return &ReminderBot{rsrv: rsrv}
+}
+
+func (b *ReminderBot) Info() Info {
+	return Info{
+		Username:    reminders.SenderUsername,
+		Description: "Sends you a reminder later",
+		Commands:    []string{"remind"},
+		Permissions: PermReadDirect | PermGroupCommands | PermSendDirect | PermSendGroup,
+		RateLimit:   10,
+	}
+}
+
+func (b *ReminderBot) OnMessage(ctx context.Context, msg *chat.ChatMessage, r Responder) error {
+	return r.Reply(ctx, reminderUsage)
+}
+
+func (b *ReminderBot) OnCommand(ctx context.Context, cmd *Command, r Responder) error {
+	username := cmd.Message.FromID
+
+	if len(cmd.Args) == 0 {
+		return apperrors.NewValidationError(reminderUsage)
+	}
+
+	switch strings.ToLower(cmd.Args[0]) {
+	case "list":
+		pending, err := b.rsrv.List(ctx, username)
+		if err != nil {
+			return err
+		}
+		return r.SendDirect(ctx, username, formatReminders(pending))
+
+	case "cancel":
+		if len(cmd.Args) != 2 {
+			return apperrors.NewValidationError("Usage: /remind cancel <number>")
+		}
+
+		pending, err := b.rsrv.List(ctx, username)
+		if err != nil {
+			return err
+		}
+
+		n, err := strconv.Atoi(cmd.Args[1])
+		if err != nil || n < 1 || n > len(pending) {
+			return apperrors.NewValidationError("Unknown reminder number, see /remind list")
+		}
+
+		if err := b.rsrv.Cancel(ctx, username, pending[n-1].ID); err != nil {
+			return err
+		}
+		return r.Reply(ctx, fmt.Sprintf("🗑️ Cancelled: %s", pending[n-1].Text))
+	}
+
+	req, err := reminders.ParseRequest(cmd.RawArgs)
+	if err != nil {
+		return apperrors.NewValidationError(fmt.Sprintf("%s. %s", err.Error(), reminderUsage))
+	}
+
+	reminder, err := b.rsrv.Create(ctx, username, req.Text, req.Delay, req.Every)
+	if err != nil {
+		return err
+	}
+
+	if req.Every > 0 {
+		return r.Reply(ctx, fmt.Sprintf("👍 I'll remind you every %s, starting %s", req.Every, reminder.DueAt.Format(time.RFC1123)))
+	}
+	return r.Reply(ctx, fmt.Sprintf("👍 I'll remind you in %s", req.Delay))
+}
+
+func formatReminders(pending []reminders.Reminder) string {
+	if len(pending) == 0 {
+		return "You have no pending reminders"
+	}
+
+	var sb strings.Builder
+	sb.WriteString("Your reminders:")
+	for i, reminder := range pending {
+		fmt.Fprintf(&sb, "\n%d. %s — %s", i+1, reminder.Text, reminder.DueAt.Format(time.RFC1123))
+		if reminder.EverySeconds > 0 {
+			fmt.Fprintf(&sb, " (every %s)", reminder.Interval())
+		}
+	}
+	return sb.String()
+}
@@ -4,8 +4,12 @@ import (
 	"context"
 	"encoding/json"
 	"exc6/pkg/breaker"
+	"exc6/pkg/keyspace"
 	"exc6/pkg/logger"
+	"exc6/pkg/pagination"
+	"exc6/services/notifications"
 	"fmt"
+	"slices"
 	"sync"
 	"time"
 
@@ -14,6 +18,22 @@ import (
 	"github.com/sony/gobreaker"
 )
 
+// HistorySize is the number of past calls kept per user
+const HistorySize = 100
+
+// HistoryTTL is how long call history, and the time a user last checked it,
+// is kept after the last update
+const HistoryTTL = 30 * 24 * time.Hour
+
+func init() {
+	keyspace.Register(
+		keyspace.Family{Prefix: "call:", Description: "active and recently ended calls"},
+		keyspace.Family{Prefix: "call_user:", Description: "the live call each user is in"},
+		keyspace.Family{Prefix: "call_history:", Description: "past calls per user"},
+		keyspace.Family{Prefix: "calls:seen:", Description: "when each user last viewed their calls"},
+	)
+}
+
 // CallState represents the state of a call
 type CallState string
 
@@ -21,10 +41,28 @@ const (
 	CallStateInitiating CallState = "initiating"
 	CallStateRinging    CallState = "ringing"
 	CallStateActive     CallState = "active"
+	CallStateHeld       CallState = "held"
 	CallStateEnding     CallState = "ending"
 	CallStateEnded      CallState = "ended"
 )
 
+// transitions lists the states each state may move to. Calls can end from
+// any state.
+var transitions = map[CallState][]CallState{
+	CallStateInitiating: {CallStateRinging, CallStateActive},
+	CallStateRinging:    {CallStateActive},
+	CallStateActive:     {CallStateHeld, CallStateEnding},
+	CallStateHeld:       {CallStateActive, CallStateEnding},
+}
+
+// CanTransition reports whether a call may move from one state to another
+func CanTransition(from, to CallState) bool {
+	if to == CallStateEnded {
+		return from != CallStateEnded
+	}
+	return slices.Contains(transitions[from], to)
+}
+
 // Call represents an active or past call
 type Call struct {
 	ID         string    `json:"id"`
@@ -36,17 +74,69 @@ type Call struct {
 	EndedAt    int64     `json:"ended_at,omitempty"`
 	Duration   int64     `json:"duration,omitempty"`
 	EndedBy    string    `json:"ended_by,omitempty"`
+
+	// HeldBy is the participant who put the call on hold
+	HeldBy string `json:"held_by,omitempty"`
+
+	// Transfers: TransferOf is the held call a consultation call is for;
+	// TransferredTo/TransferredFrom link a transferred call and the call
+	// that replaced it, and TransferredBy is who transferred it
+	TransferOf      string `json:"transfer_of,omitempty"`
+	TransferredTo   string `json:"transferred_to,omitempty"`
+	TransferredFrom string `json:"transferred_from,omitempty"`
+	TransferredBy   string `json:"transferred_by,omitempty"`
+
+	Recording *Recording `json:"recording,omitempty"`
+
+	// Media is what the call carries, see media.go; a call keeps the media
+	// it was last changed to, so upgraded calls are listed as such in
+	// history. Calls recorded before media was tracked have none.
+	Media        MediaType        `json:"media,omitempty"`
+	Constraints  MediaConstraints `json:"constraints"`
+	MediaRequest *MediaRequest    `json:"media_request,omitempty"`
+
+	// Offered is what the caller, or the participant who asked for the
+	// media, offered; it is negotiated with the other's answer
+	Offered MediaConstraints `json:"offered"`
+
+	// Quality sums up the stats the participants reported, see quality.go;
+	// set once the call ended, for calls with any
+	Quality *Quality `json:"quality,omitempty"`
+}
+
+// Cursor orders call history by end time
+func (c *Call) Cursor() pagination.Cursor {
+	return pagination.Cursor{Key: pagination.IntKey(c.EndedAt), ID: c.ID}
 }
 
 // CallService manages voice calls and WebRTC signaling
 type CallService struct {
-	rdb         *redis.Client
-	cb          *gobreaker.CircuitBreaker
+	rdb    *redis.Client
+	cb     *gobreaker.CircuitBreaker
+	mu     sync.RWMutex
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// activeCalls and userCalls cache live calls and the call each user is
+	// in, see state.go. synced is when each key was last read, used when
+	// a participant last changed or acted on each call here, dirty the
+	// calls changed while Redis was unreachable, and committed the effects
+	// of the change being made.
 	activeCalls map[string]*Call
 	userCalls   map[string]string
-	mu          sync.RWMutex
-	ctx         context.Context
-	cancel      context.CancelFunc
+	synced      map[string]time.Time
+	used        map[string]time.Time
+	dirty       map[string]*Call
+	committed   []func()
+
+	recorder        Recorder
+	recordingPolicy RecordingPolicy
+
+	chat ChatOptions
+	ice  ICEOptions
+
+	// inbox is told about missed calls; may be nil
+	inbox *notifications.NotificationService
 }
 
 // NewCallService creates a new call service
@@ -57,8 +147,12 @@ func NewCallService(ctx context.Context, rdb *redis.Client) *CallService {
 		rdb:         rdb,
 		activeCalls: make(map[string]*Call),
 		userCalls:   make(map[string]string),
+		synced:      make(map[string]time.Time),
+		used:        make(map[string]time.Time),
+		dirty:       make(map[string]*Call),
 		ctx:         bgCtx,
 		cancel:      cancel,
+		chat:        ChatOptions{TTL: DefaultChatTTL},
 		cb: breaker.New(breaker.Config{
 			Name:        "redis-calls",
 			MaxRequests: 5,
@@ -74,35 +168,45 @@ func NewCallService(ctx context.Context, rdb *redis.Client) *CallService {
 	return cs
 }
 
-// InitiateCall initiates a new call
+// InitiateCall initiates a new audio call
 func (cs *CallService) InitiateCall(caller, callee string) (*Call, error) {
+	return cs.initiate(caller, callee, MediaAudio, MediaConstraints{})
+}
+
+// initiate places caller and callee in a new call at once, so instances
+// racing to call either of them cannot both succeed
+func (cs *CallService) initiate(caller, callee string, media MediaType, offered MediaConstraints) (*Call, error) {
 	cs.mu.Lock()
 	defer cs.mu.Unlock()
 
-	// Check if either user is already in a call
-	if existingCallID, inCall := cs.userCalls[caller]; inCall {
-		return nil, fmt.Errorf("caller already in call: %s", existingCallID)
-	}
-	if existingCallID, inCall := cs.userCalls[callee]; inCall {
-		return nil, fmt.Errorf("callee already in call: %s", existingCallID)
-	}
-
-	call := &Call{
-		ID:        uuid.NewString(),
-		Caller:    caller,
-		Callee:    callee,
-		State:     CallStateInitiating,
-		StartedAt: time.Now().Unix(),
-	}
+	var call *Call
+	err := cs.updateLocked(cs.ctx, nil, []string{caller, callee}, func() ([]*Call, error) {
+		// Check if either user is already in a call
+		if existingCallID, inCall := cs.userCalls[caller]; inCall {
+			return nil, fmt.Errorf("caller already in call: %s", existingCallID)
+		}
+		if existingCallID, inCall := cs.userCalls[callee]; inCall {
+			return nil, fmt.Errorf("callee already in call: %s", existingCallID)
+		}
 
-	cs.activeCalls[call.ID] = call
-	cs.userCalls[caller] = call.ID
-	cs.userCalls[callee] = call.ID
+		call = &Call{
+			ID:          uuid.NewString(),
+			Caller:      caller,
+			Callee:      callee,
+			State:       CallStateInitiating,
+			StartedAt:   time.Now().Unix(),
+			Media:       media,
+			Offered:     offered,
+			Constraints: Negotiate(media, offered),
+		}
 
-	// Persist to Redis with circuit breaker
-	if err := cs.saveCallToRedis(call); err != nil {
-		logger.WithError(err).Error("Circuit breaker: Failed to save call to Redis")
-		// Don't fail the call initiation if Redis is down
+		cs.activeCalls[call.ID] = call
+		cs.userCalls[caller] = call.ID
+		cs.userCalls[callee] = call.ID
+		return []*Call{call}, nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	logger.WithFields(map[string]any{
@@ -114,32 +218,6 @@ func (cs *CallService) InitiateCall(caller, callee string) (*Call, error) {
 	return call, nil
 }
 
-// saveCallToRedis saves call state to Redis with circuit breaker
-func (cs *CallService) saveCallToRedis(call *Call) error {
-	ctx, cancel := context.WithTimeout(cs.ctx, 3*time.Second)
-	defer cancel()
-
-	key := fmt.Sprintf("call:%s", call.ID)
-
-	_, err := breaker.ExecuteCtx(ctx, cs.cb, func() (interface{}, error) {
-		data, err := json.Marshal(call)
-		if err != nil {
-			return nil, err
-		}
-		return nil, cs.rdb.Set(ctx, key, data, 24*time.Hour).Err()
-	})
-
-	if err != nil {
-		logger.WithFields(map[string]interface{}{
-			"call_id": call.ID,
-			"error":   err.Error(),
-		}).Error("Circuit breaker: Failed to save call")
-		return err
-	}
-
-	return nil
-}
-
 // saveCallHistory saves completed call to history with circuit breaker
 func (cs *CallService) saveCallHistory(call *Call) error {
 	if call.State != CallStateEnded {
@@ -165,13 +243,12 @@ func (cs *CallService) saveCallHistory(call *Call) error {
 		pipe.ZAdd(ctx, callerKey, redis.Z{Score: score, Member: data})
 		pipe.ZAdd(ctx, calleeKey, redis.Z{Score: score, Member: data})
 
-		// Keep only last 100 calls
-		pipe.ZRemRangeByRank(ctx, callerKey, 0, -101)
-		pipe.ZRemRangeByRank(ctx, calleeKey, 0, -101)
+		// Keep only the last HistorySize calls
+		pipe.ZRemRangeByRank(ctx, callerKey, 0, -HistorySize-1)
+		pipe.ZRemRangeByRank(ctx, calleeKey, 0, -HistorySize-1)
 
-		// Expire after 30 days
-		pipe.Expire(ctx, callerKey, 30*24*time.Hour)
-		pipe.Expire(ctx, calleeKey, 30*24*time.Hour)
+		pipe.Expire(ctx, callerKey, HistoryTTL)
+		pipe.Expire(ctx, calleeKey, HistoryTTL)
 
 		_, err = pipe.Exec(ctx)
 		return nil, err
@@ -240,9 +317,10 @@ func (cs *CallService) GetMissedCalls(ctx context.Context, username string) ([]*
 		return cs.rdb.Get(ctx, lastSeenKey).Int64()
 	})
 
-	lastSeenVal := int64(0)
+	// A user who never checked, or whose timestamp expired, has seen nothing
+	var lastSeenVal int64
 	if err == nil {
-		lastSeenVal = result.(int64)
+		lastSeenVal, _ = result.(int64)
 	}
 
 	missed := make([]*Call, 0)
@@ -261,7 +339,7 @@ func (cs *CallService) MarkCallsSeen(ctx context.Context, username string) error
 	key := fmt.Sprintf("calls:seen:%s", username)
 
 	_, err := breaker.ExecuteCtx(ctx, cs.cb, func() (interface{}, error) {
-		return nil, cs.rdb.Set(ctx, key, time.Now().Unix(), 0).Err()
+		return nil, cs.rdb.Set(ctx, key, time.Now().Unix(), HistoryTTL).Err()
 	})
 
 	if err != nil {
@@ -280,17 +358,30 @@ func (cs *CallService) UpdateCallState(callID string, newState CallState) error
 	cs.mu.Lock()
 	defer cs.mu.Unlock()
 
-	call, exists := cs.activeCalls[callID]
-	if !exists {
-		return fmt.Errorf("call not found: %s", callID)
-	}
+	return cs.updateLocked(cs.ctx, []string{callID}, nil, func() ([]*Call, error) {
+		call, exists := cs.activeCalls[callID]
+		if !exists {
+			return nil, fmt.Errorf("call not found: %s", callID)
+		}
+		return cs.transitionLocked(call, newState)
+	})
+}
 
+// transitionLocked moves a call to a new state. cs.mu must be held.
+func (cs *CallService) transitionLocked(call *Call, newState CallState) ([]*Call, error) {
 	oldState := call.State
+	if !CanTransition(oldState, newState) {
+		return nil, fmt.Errorf("call cannot go from %s to %s", oldState, newState)
+	}
 	call.State = newState
 
 	switch newState {
 	case CallStateActive:
-		call.AnsweredAt = time.Now().Unix()
+		// Resuming from hold keeps the original answer time
+		if call.AnsweredAt == 0 {
+			call.AnsweredAt = time.Now().Unix()
+		}
+		call.HeldBy = ""
 	case CallStateEnded:
 		call.EndedAt = time.Now().Unix()
 		if call.AnsweredAt > 0 {
@@ -298,35 +389,21 @@ func (cs *CallService) UpdateCallState(callID string, newState CallState) error
 		}
 	}
 
-	// Persist with circuit breaker (non-blocking)
-	if err := cs.saveCallToRedis(call); err != nil {
-		logger.WithError(err).Warn("Failed to update call in Redis (continuing anyway)")
-	}
-
-	logger.WithFields(map[string]any{
-		"call_id":   callID,
-		"old_state": oldState,
-		"new_state": newState,
-	}).Info("Call state updated")
+	cs.afterLocked(func() {
+		logger.WithFields(map[string]any{
+			"call_id":   call.ID,
+			"old_state": oldState,
+			"new_state": newState,
+		}).Info("Call state updated")
+	})
 
-	return nil
+	return []*Call{call}, nil
 }
 
 // AnswerCall marks a call as answered
 func (cs *CallService) AnswerCall(callID, username string) error {
-	cs.mu.RLock()
-	call, exists := cs.activeCalls[callID]
-	cs.mu.RUnlock()
-
-	if !exists {
-		return fmt.Errorf("call not found: %s", callID)
-	}
-
-	if call.Callee != username {
-		return fmt.Errorf("user %s is not the callee", username)
-	}
-
-	return cs.UpdateCallState(callID, CallStateActive)
+	_, err := cs.AnswerMediaCall(callID, username, MediaConstraints{})
+	return err
 }
 
 // EndCall ends a call
@@ -334,52 +411,93 @@ func (cs *CallService) EndCall(callID, username string) error {
 	cs.mu.Lock()
 	defer cs.mu.Unlock()
 
-	call, exists := cs.activeCalls[callID]
-	if !exists {
-		return fmt.Errorf("call not found: %s", callID)
-	}
+	return cs.updateLocked(cs.ctx, []string{callID}, nil, func() ([]*Call, error) {
+		call, err := cs.participantCallLocked(callID, username)
+		if err != nil {
+			return nil, err
+		}
 
-	if call.Caller != username && call.Callee != username {
-		return fmt.Errorf("user %s is not part of this call", username)
-	}
+		cs.endCallLocked(call, username)
+		return []*Call{call}, nil
+	})
+}
+
+// endCallLocked ends a call and frees its participants; once stored, it is
+// moved to history. The caller stores the call. cs.mu must be held.
+func (cs *CallService) endCallLocked(call *Call, username string) {
+	callID := call.ID
 
 	call.State = CallStateEnded
 	call.EndedAt = time.Now().Unix()
 	call.EndedBy = username
 
+	// Recording never outlives the call, nor do media changes
+	cs.stopRecordingLocked(call, username)
+	call.MediaRequest = nil
+
 	if call.AnsweredAt > 0 {
 		call.Duration = call.EndedAt - call.AnsweredAt
 	}
 
-	// Remove from active tracking
-	delete(cs.userCalls, call.Caller)
-	delete(cs.userCalls, call.Callee)
+	// Remove from active tracking. The transferring user of a consultation
+	// call is still tracked on the held call.
+	for _, participant := range []string{call.Caller, call.Callee} {
+		if cs.userCalls[participant] == callID {
+			delete(cs.userCalls, participant)
+		}
+	}
 	delete(cs.activeCalls, callID)
 
-	// Persist to Redis for history
-	if err := cs.saveCallToRedis(call); err != nil {
-		logger.WithError(err).Error("Failed to save ended call to Redis")
-	}
+	cs.afterLocked(func() {
+		// In-call messages go to the conversation, if configured
+		cs.archiveChatLocked(call)
 
-	// Store in call history
-	if err := cs.saveCallHistory(call); err != nil {
-		logger.WithError(err).Error("Failed to save call history")
-	}
+		if call.AnsweredAt == 0 && username != call.Callee {
+			cs.notifyMissed(call)
+		}
 
-	logger.WithFields(map[string]any{
-		"call_id":  callID,
-		"ended_by": username,
-		"duration": call.Duration,
-	}).Info("Call ended")
+		// Store in call history, with the quality the participants saw
+		cs.attachQuality(call)
+		if err := cs.saveCallHistory(call); err != nil {
+			logger.WithError(err).Error("Failed to save call history")
+		}
 
-	return nil
+		logger.WithFields(map[string]any{
+			"call_id":  callID,
+			"ended_by": username,
+			"duration": call.Duration,
+		}).Info("Call ended")
+	})
+}
+
+// SetNotifications records calls that went unanswered in the callee's inbox
+func (cs *CallService) SetNotifications(inbox *notifications.NotificationService) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.inbox = inbox
+}
+
+// notifyMissed tells the callee of a call ended before they answered or
+// declined it. cs.mu must be held.
+func (cs *CallService) notifyMissed(call *Call) {
+	if cs.inbox == nil {
+		return
+	}
+
+	inbox, callee, caller, callID := cs.inbox, call.Callee, call.Caller, call.ID
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		inbox.Notify(ctx, callee, notifications.TypeMissedCall, caller, "Missed call", map[string]string{"call_id": callID})
+	}()
 }
 
 // GetCall retrieves a call by ID
 func (cs *CallService) GetCall(callID string) (*Call, error) {
-	cs.mu.RLock()
-	defer cs.mu.RUnlock()
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
 
+	cs.syncLocked(cs.ctx, []string{callID}, nil)
 	call, exists := cs.activeCalls[callID]
 	if !exists {
 		return nil, fmt.Errorf("call not found: %s", callID)
@@ -390,9 +508,10 @@ func (cs *CallService) GetCall(callID string) (*Call, error) {
 
 // GetUserActiveCall gets the active call for a user
 func (cs *CallService) GetUserActiveCall(username string) (*Call, error) {
-	cs.mu.RLock()
-	defer cs.mu.RUnlock()
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
 
+	cs.syncLocked(cs.ctx, nil, []string{username})
 	callID, inCall := cs.userCalls[username]
 	if !inCall {
 		return nil, fmt.Errorf("user not in active call")
@@ -408,14 +527,16 @@ func (cs *CallService) GetUserActiveCall(username string) (*Call, error) {
 
 // IsUserInCall checks if a user is currently in a call
 func (cs *CallService) IsUserInCall(username string) bool {
-	cs.mu.RLock()
-	defer cs.mu.RUnlock()
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
 
+	cs.syncLocked(cs.ctx, nil, []string{username})
 	_, inCall := cs.userCalls[username]
 	return inCall
 }
 
-// cleanupStaleCalls removes stale calls
+// cleanupStaleCalls removes stale calls and keeps the live calls in the
+// cache from expiring
 func (cs *CallService) cleanupStaleCall() {
 	ticker := time.NewTicker(30 * time.Second)
 	defer ticker.Stop()
@@ -424,28 +545,37 @@ func (cs *CallService) cleanupStaleCall() {
 		select {
 		case <-ticker.C:
 			cs.mu.Lock()
-			now := time.Now().Unix()
+			if err := cs.refreshCallsLocked(cs.ctx); err != nil {
+				logger.WithError(err).Warn("Failed to refresh live calls")
+			}
 
+			var stale []string
 			for callID, call := range cs.activeCalls {
-				if call.State == CallStateRinging || call.State == CallStateInitiating {
-					if now-call.StartedAt > 60 {
+				if (call.State == CallStateRinging || call.State == CallStateInitiating) && time.Now().Unix()-call.StartedAt > 60 {
+					stale = append(stale, callID)
+				}
+			}
+
+			for _, callID := range stale {
+				cs.updateLocked(cs.ctx, []string{callID}, nil, func() ([]*Call, error) {
+					// It may have been answered elsewhere meanwhile
+					call, exists := cs.activeCalls[callID]
+					if !exists || (call.State != CallStateRinging && call.State != CallStateInitiating) {
+						return nil, nil
+					}
+
+					state, age := call.State, time.Now().Unix()-call.StartedAt
+					cs.afterLocked(func() {
 						logger.WithFields(map[string]any{
 							"call_id": callID,
-							"state":   call.State,
-							"age":     now - call.StartedAt,
+							"state":   state,
+							"age":     age,
 						}).Info("Cleaning up stale call")
+					})
 
-						call.State = CallStateEnded
-						call.EndedAt = now
-						call.EndedBy = "system"
-
-						delete(cs.userCalls, call.Caller)
-						delete(cs.userCalls, call.Callee)
-						delete(cs.activeCalls, callID)
-
-						cs.saveCallHistory(call)
-					}
-				}
+					cs.endCallLocked(call, "system")
+					return []*Call{call}, nil
+				})
 			}
 
 			cs.mu.Unlock()
@@ -465,6 +595,7 @@ func (cs *CallService) GetStats() map[string]any {
 	cbState := cs.cb.State()
 	cbCounts := cs.cb.Counts()
 
+	// Calls and users in this instance's cache
 	return map[string]any{
 		"active_calls":  len(cs.activeCalls),
 		"users_in_call": len(cs.userCalls),
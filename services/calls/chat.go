@@ -0,0 +1,209 @@
+package calls
+
+import (
+	"context"
+	"encoding/json"
+	"exc6/pkg/breaker"
+	"exc6/pkg/keyspace"
+	"exc6/pkg/logger"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+// Participants can exchange text during a call (e.g. to share a link). The
+// messages are kept under the call ID only for a while and go to the two
+// participants alone. When the call ends they can be handed to an archiver,
+// which appends them to the participants' regular conversation.
+
+func init() {
+	keyspace.Register(keyspace.Family{Prefix: "call_chat:", Description: "messages exchanged during a call"})
+}
+
+const (
+	// DefaultChatTTL is how long in-call messages are kept by default
+	DefaultChatTTL = 2 * time.Hour
+
+	// ChatMaxLength is the longest in-call message, in characters
+	ChatMaxLength = 2000
+
+	// ChatMaxMessages is the number of messages kept per call
+	ChatMaxMessages = 200
+)
+
+// CallMessage is a text message sent during a call
+type CallMessage struct {
+	ID        string `json:"id"`
+	CallID    string `json:"call_id"`
+	From      string `json:"from"`
+	Content   string `json:"content"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// ChatArchiver receives a call's messages once the call has ended
+type ChatArchiver func(ctx context.Context, call *Call, messages []*CallMessage)
+
+// ChatOptions configures in-call chat
+type ChatOptions struct {
+	TTL time.Duration // How long messages are kept under the call
+
+	// Archive, when set, is given the messages of every call that ends
+	// with any
+	Archive ChatArchiver
+}
+
+// SetChat configures in-call chat
+func (cs *CallService) SetChat(opts ChatOptions) {
+	if opts.TTL <= 0 {
+		opts.TTL = DefaultChatTTL
+	}
+
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.chat = opts
+}
+
+func callChatKey(callID string) string {
+	return fmt.Sprintf("call_chat:%s", callID)
+}
+
+// SendChatMessage stores a message from username in a live call and returns
+// it with the call, whose participants it should be delivered to. The call
+// is also returned when the message was valid but could not be stored.
+func (cs *CallService) SendChatMessage(ctx context.Context, callID, username, content string) (*CallMessage, *Call, error) {
+	content = strings.TrimSpace(content)
+	if content == "" {
+		return nil, nil, fmt.Errorf("message is empty")
+	}
+	if utf8.RuneCountInString(content) > ChatMaxLength {
+		return nil, nil, fmt.Errorf("message is longer than %d characters", ChatMaxLength)
+	}
+
+	cs.mu.Lock()
+	cs.syncLocked(ctx, []string{callID}, nil)
+	call, err := cs.participantCallLocked(callID, username)
+	if err == nil && call.State != CallStateActive && call.State != CallStateHeld {
+		err = fmt.Errorf("call is not connected")
+	}
+	ttl := cs.chat.TTL
+	cs.mu.Unlock()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	msg := &CallMessage{
+		ID:        uuid.NewString(),
+		CallID:    callID,
+		From:      username,
+		Content:   content,
+		Timestamp: time.Now().Unix(),
+	}
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	key := callChatKey(callID)
+	if _, err := breaker.ExecuteCtx(ctx, cs.cb, func() (any, error) {
+		pipe := cs.rdb.TxPipeline()
+		pipe.RPush(ctx, key, data)
+		pipe.LTrim(ctx, key, -ChatMaxMessages, -1)
+		pipe.Expire(ctx, key, ttl)
+		_, err := pipe.Exec(ctx)
+		return nil, err
+	}); err != nil {
+		return nil, call, err
+	}
+
+	return msg, call, nil
+}
+
+// ChatMessages returns the messages of a live call, oldest first
+func (cs *CallService) ChatMessages(ctx context.Context, callID, username string) ([]*CallMessage, error) {
+	cs.mu.Lock()
+	cs.syncLocked(ctx, []string{callID}, nil)
+	_, err := cs.participantCallLocked(callID, username)
+	cs.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	return cs.loadChatMessages(ctx, callID)
+}
+
+func (cs *CallService) loadChatMessages(ctx context.Context, callID string) ([]*CallMessage, error) {
+	result, err := breaker.ExecuteCtx(ctx, cs.cb, func() (any, error) {
+		return cs.rdb.LRange(ctx, callChatKey(callID), 0, -1).Result()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	raw, _ := result.([]string)
+	messages := make([]*CallMessage, 0, len(raw))
+	for _, item := range raw {
+		var msg CallMessage
+		if err := json.Unmarshal([]byte(item), &msg); err != nil {
+			continue
+		}
+		messages = append(messages, &msg)
+	}
+	return messages, nil
+}
+
+// archiveChatLocked hands an ended call's messages to the archiver in the
+// background. cs.mu must be held.
+func (cs *CallService) archiveChatLocked(call *Call) {
+	archive := cs.chat.Archive
+	if archive == nil || call.AnsweredAt == 0 {
+		return
+	}
+
+	ended := *call
+	go func() {
+		ctx, cancel := context.WithTimeout(cs.ctx, 10*time.Second)
+		defer cancel()
+
+		messages, err := cs.loadChatMessages(ctx, ended.ID)
+		if err != nil {
+			logger.WithFields(map[string]any{
+				"call_id": ended.ID,
+				"error":   err.Error(),
+			}).Warn("Failed to load in-call messages for archiving")
+			return
+		}
+		if len(messages) == 0 {
+			return
+		}
+
+		archive(ctx, &ended, messages)
+
+		if err := cs.rdb.Del(ctx, callChatKey(ended.ID)).Err(); err != nil {
+			logger.WithFields(map[string]any{
+				"call_id": ended.ID,
+				"error":   err.Error(),
+			}).Warn("Failed to delete archived in-call messages")
+		}
+	}()
+}
+
+// ConversationArchiver returns an archiver that sends each message again
+// through send, from its sender to the other participant, which appends it
+// to their conversation
+func ConversationArchiver(send func(ctx context.Context, from, to, content string) error) ChatArchiver {
+	return func(ctx context.Context, call *Call, messages []*CallMessage) {
+		for _, msg := range messages {
+			if err := send(ctx, msg.From, otherParty(call, msg.From), msg.Content); err != nil {
+				logger.WithFields(map[string]any{
+					"call_id":    call.ID,
+					"message_id": msg.ID,
+					"error":      err.Error(),
+				}).Warn("Failed to append in-call message to conversation")
+			}
+		}
+	}
+}
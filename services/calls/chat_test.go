@@ -0,0 +1,64 @@
+package calls
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSendChatMessageValidation(t *testing.T) {
+	cs, _ := newRecordingService(t)
+
+	ringing, err := cs.InitiateCall("alice", "bob")
+	require.NoError(t, err)
+
+	active, err := cs.InitiateCall("carol", "dave")
+	require.NoError(t, err)
+	require.NoError(t, cs.AnswerCall(active.ID, "dave"))
+
+	tests := []struct {
+		name     string
+		callID   string
+		username string
+		content  string
+	}{
+		{name: "Empty message", callID: active.ID, username: "carol", content: "   "},
+		{name: "Too long", callID: active.ID, username: "carol", content: strings.Repeat("a", ChatMaxLength+1)},
+		{name: "Not a participant", callID: active.ID, username: "alice", content: "hi"},
+		{name: "Unknown call", callID: "missing", username: "carol", content: "hi"},
+		{name: "Call not answered", callID: ringing.ID, username: "alice", content: "hi"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, call, err := cs.SendChatMessage(context.Background(), tt.callID, tt.username, tt.content)
+			assert.Error(t, err)
+			assert.Nil(t, msg)
+			assert.Nil(t, call, "rejected messages don't name a call to deliver to")
+		})
+	}
+}
+
+func TestConversationArchiver(t *testing.T) {
+	type sent struct{ from, to, content string }
+	var got []sent
+
+	archive := ConversationArchiver(func(ctx context.Context, from, to, content string) error {
+		got = append(got, sent{from, to, content})
+		return nil
+	})
+
+	call := &Call{ID: "call-1", Caller: "alice", Callee: "bob"}
+	archive(context.Background(), call, []*CallMessage{
+		{From: "alice", Content: "https://example.com/doc"},
+		{From: "bob", Content: "thanks"},
+	})
+
+	assert.Equal(t, []sent{
+		{"alice", "bob", "https://example.com/doc"},
+		{"bob", "alice", "thanks"},
+	}, got)
+}
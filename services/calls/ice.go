@@ -0,0 +1,96 @@
+package calls
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"exc6/pkg/instance"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Clients behind NAT reach each other through STUN and, when no direct path
+// exists, relay media through TURN. TURN credentials are not stored: they
+// follow the TURN REST API that coturn implements (use-auth-secret), where
+// the username is the expiry time and the user, and the password an HMAC of
+// the username with a secret shared with the TURN server. The TURN server
+// checks them on its own and refuses them once they expire.
+
+// DefaultICECredentialTTL is how long TURN credentials last by default
+const DefaultICECredentialTTL = 6 * time.Hour
+
+var iceCredentials = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "call_ice_credentials_issued_total",
+		Help: "ICE server lists handed to clients, by whether they carry TURN credentials",
+	},
+	[]string{"kind"}, // turn, stun
+)
+
+func init() {
+	instance.Registerer().MustRegister(iceCredentials)
+}
+
+// ICEServer is an entry of RTCConfiguration.iceServers
+type ICEServer struct {
+	URLs       []string `json:"urls"`
+	Username   string   `json:"username,omitempty"`
+	Credential string   `json:"credential,omitempty"`
+}
+
+// ICEOptions configures the ICE servers handed to clients
+type ICEOptions struct {
+	STUNURLs []string
+	TURNURLs []string
+
+	// Secret is shared with the TURN server; TURN is offered only with one
+	Secret string
+
+	// TTL is how long TURN credentials last
+	TTL time.Duration
+}
+
+// SetICE configures the ICE servers handed to clients
+func (cs *CallService) SetICE(opts ICEOptions) {
+	if opts.TTL <= 0 {
+		opts.TTL = DefaultICECredentialTTL
+	}
+
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.ice = opts
+}
+
+// ICEServers returns the ICE servers for username and when the credentials
+// in them expire. Without TURN the list never expires and the time is zero.
+func (cs *CallService) ICEServers(username string, now time.Time) ([]ICEServer, time.Time) {
+	cs.mu.RLock()
+	opts := cs.ice
+	cs.mu.RUnlock()
+
+	servers := []ICEServer{}
+	if len(opts.STUNURLs) > 0 {
+		servers = append(servers, ICEServer{URLs: opts.STUNURLs})
+	}
+	if len(opts.TURNURLs) == 0 || opts.Secret == "" {
+		iceCredentials.WithLabelValues("stun").Inc()
+		return servers, time.Time{}
+	}
+
+	expires := now.Add(opts.TTL).Truncate(time.Second)
+	user, credential := TURNCredentials(opts.Secret, username, expires)
+	servers = append(servers, ICEServer{URLs: opts.TURNURLs, Username: user, Credential: credential})
+	iceCredentials.WithLabelValues("turn").Inc()
+	return servers, expires
+}
+
+// TURNCredentials derives the TURN username and password letting username
+// relay media until expires, for a TURN server sharing secret
+func TURNCredentials(secret, username string, expires time.Time) (string, string) {
+	user := strconv.FormatInt(expires.Unix(), 10) + ":" + username
+	mac := hmac.New(sha1.New, []byte(secret))
+	mac.Write([]byte(user))
+	return user, base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,47 @@
+package calls
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTURNCredentials(t *testing.T) {
+	// coturn checks the password as base64(HMAC-SHA1(secret, username))
+	user, credential := TURNCredentials("north", "alice", time.Unix(1700000000, 0))
+	assert.Equal(t, "1700000000:alice", user)
+	assert.Equal(t, "Cd/49soE35ICqcJF/bCTn8Z4OyE=", credential)
+}
+
+func TestICEServers(t *testing.T) {
+	cs, _ := newRecordingService(t)
+	now := time.Unix(1700000000, 0)
+
+	servers, expires := cs.ICEServers("alice", now)
+	assert.Empty(t, servers, "nothing configured")
+	assert.True(t, expires.IsZero())
+
+	cs.SetICE(ICEOptions{STUNURLs: []string{"stun:stun.example.com:3478"}, TURNURLs: []string{"turn:turn.example.com:3478"}})
+	servers, expires = cs.ICEServers("alice", now)
+	require.Len(t, servers, 1, "TURN needs a secret")
+	assert.True(t, expires.IsZero())
+
+	cs.SetICE(ICEOptions{
+		STUNURLs: []string{"stun:stun.example.com:3478"},
+		TURNURLs: []string{"turn:turn.example.com:3478?transport=udp", "turns:turn.example.com:5349"},
+		Secret:   "north",
+		TTL:      time.Hour,
+	})
+	servers, expires = cs.ICEServers("alice", now)
+	require.Len(t, servers, 2)
+	assert.Equal(t, now.Add(time.Hour), expires)
+
+	turn := servers[1]
+	assert.Len(t, turn.URLs, 2)
+	user, credential := TURNCredentials("north", "alice", expires)
+	assert.Equal(t, user, turn.Username)
+	assert.Equal(t, credential, turn.Credential)
+	assert.Empty(t, servers[0].Username, "STUN needs no credentials")
+}
@@ -0,0 +1,236 @@
+package calls
+
+import (
+	"fmt"
+	"time"
+)
+
+// A call starts as audio, video or screen sharing. The caller offers the
+// limits it wants to receive within and the callee answers with its own; the
+// call keeps the tighter of each, within what the server allows for the
+// media. During an active call either participant can ask to change the
+// media, such as upgrading to video, and the change takes effect once the
+// other participant accepts it, negotiated the same way.
+
+// MediaType is what a call carries
+type MediaType string
+
+const (
+	MediaAudio  MediaType = "audio"
+	MediaVideo  MediaType = "video"
+	MediaScreen MediaType = "screen"
+)
+
+// ParseMediaType checks a media type, with "" as audio
+func ParseMediaType(s string) (MediaType, error) {
+	switch m := MediaType(s); m {
+	case "":
+		return MediaAudio, nil
+	case MediaAudio, MediaVideo, MediaScreen:
+		return m, nil
+	default:
+		return "", fmt.Errorf("unknown media type: %s", s)
+	}
+}
+
+// Noun names a call of this media in the UI. Calls recorded without a media
+// type are audio calls.
+func (m MediaType) Noun() string {
+	switch m {
+	case MediaVideo:
+		return "video call"
+	case MediaScreen:
+		return "screen share"
+	default:
+		return "call"
+	}
+}
+
+// MediaConstraints are limits on the media a participant sends; zero means
+// no limit
+type MediaConstraints struct {
+	MaxWidth     int `json:"max_width,omitempty"`
+	MaxHeight    int `json:"max_height,omitempty"`
+	MaxFrameRate int `json:"max_frame_rate,omitempty"`
+	MaxBitrate   int `json:"max_bitrate_kbps,omitempty"`
+}
+
+// mediaLimits are the most the server lets calls of each media use
+var mediaLimits = map[MediaType]MediaConstraints{
+	MediaAudio:  {MaxBitrate: 128},
+	MediaVideo:  {MaxWidth: 1920, MaxHeight: 1080, MaxFrameRate: 30, MaxBitrate: 2500},
+	MediaScreen: {MaxWidth: 2560, MaxHeight: 1440, MaxFrameRate: 15, MaxBitrate: 3000},
+}
+
+// Validate rejects negative limits
+func (c MediaConstraints) Validate() error {
+	if c.MaxWidth < 0 || c.MaxHeight < 0 || c.MaxFrameRate < 0 || c.MaxBitrate < 0 {
+		return fmt.Errorf("media constraints cannot be negative")
+	}
+	return nil
+}
+
+// Negotiate returns the tightest of each limit offered, within the server's
+// limits for media. Audio calls carry no video, so only their bitrate is
+// kept.
+func Negotiate(media MediaType, offers ...MediaConstraints) MediaConstraints {
+	c := mediaLimits[media]
+	for _, o := range offers {
+		c.MaxWidth = tighter(c.MaxWidth, o.MaxWidth)
+		c.MaxHeight = tighter(c.MaxHeight, o.MaxHeight)
+		c.MaxFrameRate = tighter(c.MaxFrameRate, o.MaxFrameRate)
+		c.MaxBitrate = tighter(c.MaxBitrate, o.MaxBitrate)
+	}
+	if media == MediaAudio {
+		c = MediaConstraints{MaxBitrate: c.MaxBitrate}
+	}
+	return c
+}
+
+// tighter returns the lower of two limits, where zero is no limit
+func tighter(a, b int) int {
+	if a == 0 || (b > 0 && b < a) {
+		return b
+	}
+	return a
+}
+
+// MediaRequest is a participant's request to change the media of a call
+type MediaRequest struct {
+	Media       MediaType        `json:"media"`
+	Constraints MediaConstraints `json:"constraints"`
+	RequestedBy string           `json:"requested_by"`
+	RequestedAt int64            `json:"requested_at"`
+}
+
+// InitiateMediaCall initiates a call of media, offering the caller's
+// constraints
+func (cs *CallService) InitiateMediaCall(caller, callee string, media MediaType, offered MediaConstraints) (*Call, error) {
+	media, err := ParseMediaType(string(media))
+	if err != nil {
+		return nil, err
+	}
+	if err := offered.Validate(); err != nil {
+		return nil, err
+	}
+	return cs.initiate(caller, callee, media, offered)
+}
+
+// AnswerMediaCall answers a call like AnswerCall, negotiating the callee's
+// constraints with the caller's
+func (cs *CallService) AnswerMediaCall(callID, username string, answered MediaConstraints) (*Call, error) {
+	if err := answered.Validate(); err != nil {
+		return nil, err
+	}
+
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	var call *Call
+	err := cs.updateLocked(cs.ctx, []string{callID}, nil, func() ([]*Call, error) {
+		var exists bool
+		call, exists = cs.activeCalls[callID]
+		if !exists {
+			return nil, fmt.Errorf("call not found: %s", callID)
+		}
+		if call.Callee != username {
+			return nil, fmt.Errorf("user %s is not the callee", username)
+		}
+		if call.AnsweredAt != 0 {
+			return nil, fmt.Errorf("call already answered")
+		}
+
+		call.Constraints = Negotiate(call.Media, call.Offered, answered)
+		return cs.transitionLocked(call, CallStateActive)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return call, nil
+}
+
+// RequestMedia asks to change the media of an active call on behalf of
+// username, offering their constraints
+func (cs *CallService) RequestMedia(callID, username string, media MediaType, offered MediaConstraints) (*Call, error) {
+	media, err := ParseMediaType(string(media))
+	if err != nil {
+		return nil, err
+	}
+	if err := offered.Validate(); err != nil {
+		return nil, err
+	}
+
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	var call *Call
+	err = cs.updateLocked(cs.ctx, []string{callID}, nil, func() ([]*Call, error) {
+		var err error
+		if call, err = cs.participantCallLocked(callID, username); err != nil {
+			return nil, err
+		}
+		if call.State != CallStateActive {
+			return nil, fmt.Errorf("call is not active")
+		}
+		if call.Media == media {
+			return nil, fmt.Errorf("call is already a %s", media.Noun())
+		}
+		if call.MediaRequest != nil {
+			return nil, fmt.Errorf("a media change is already pending")
+		}
+
+		call.MediaRequest = &MediaRequest{
+			Media:       media,
+			Constraints: offered,
+			RequestedBy: username,
+			RequestedAt: time.Now().Unix(),
+		}
+		return []*Call{call}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return call, nil
+}
+
+// AnswerMedia records the other participant's answer to a media change. On
+// accepting, the call changes media with the constraints negotiated from
+// both offers.
+func (cs *CallService) AnswerMedia(callID, username string, accept bool, answered MediaConstraints) (*Call, error) {
+	if err := answered.Validate(); err != nil {
+		return nil, err
+	}
+
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	var call *Call
+	err := cs.updateLocked(cs.ctx, []string{callID}, nil, func() ([]*Call, error) {
+		var err error
+		if call, err = cs.participantCallLocked(callID, username); err != nil {
+			return nil, err
+		}
+		if call.State != CallStateActive {
+			return nil, fmt.Errorf("call is not active")
+		}
+		req := call.MediaRequest
+		if req == nil {
+			return nil, fmt.Errorf("no media change to answer")
+		}
+		if username == req.RequestedBy {
+			return nil, fmt.Errorf("user %s cannot answer their own media change", username)
+		}
+
+		call.MediaRequest = nil
+		if accept {
+			call.Media = req.Media
+			call.Offered = req.Constraints
+			call.Constraints = Negotiate(req.Media, req.Constraints, answered)
+		}
+		return []*Call{call}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return call, nil
+}
@@ -0,0 +1,104 @@
+package calls
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		name   string
+		media  MediaType
+		offers []MediaConstraints
+		want   MediaConstraints
+	}{
+		{
+			name:  "Server limits without offers",
+			media: MediaVideo,
+			want:  mediaLimits[MediaVideo],
+		},
+		{
+			name:   "Tighter of each offer",
+			media:  MediaVideo,
+			offers: []MediaConstraints{{MaxWidth: 1280, MaxFrameRate: 24}, {MaxWidth: 640, MaxHeight: 480, MaxBitrate: 800}},
+			want:   MediaConstraints{MaxWidth: 640, MaxHeight: 480, MaxFrameRate: 24, MaxBitrate: 800},
+		},
+		{
+			name:   "Offers above the server limits",
+			media:  MediaScreen,
+			offers: []MediaConstraints{{MaxWidth: 3840, MaxHeight: 2160, MaxFrameRate: 60}},
+			want:   mediaLimits[MediaScreen],
+		},
+		{
+			name:   "Audio keeps only the bitrate",
+			media:  MediaAudio,
+			offers: []MediaConstraints{{MaxWidth: 640, MaxBitrate: 64}},
+			want:   MediaConstraints{MaxBitrate: 64},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, Negotiate(tt.media, tt.offers...))
+		})
+	}
+}
+
+func TestParseMediaType(t *testing.T) {
+	m, err := ParseMediaType("")
+	require.NoError(t, err)
+	assert.Equal(t, MediaAudio, m)
+
+	m, err = ParseMediaType("screen")
+	require.NoError(t, err)
+	assert.Equal(t, MediaScreen, m)
+
+	_, err = ParseMediaType("hologram")
+	assert.Error(t, err)
+
+	assert.Equal(t, "call", MediaType("").Noun(), "calls recorded without a media type are audio")
+}
+
+func TestMediaUpgrade(t *testing.T) {
+	cs, _ := newRecordingService(t)
+
+	call, err := cs.InitiateMediaCall("alice", "bob", MediaAudio, MediaConstraints{MaxBitrate: 64})
+	require.NoError(t, err)
+	assert.Equal(t, MediaAudio, call.Media)
+
+	_, err = cs.RequestMedia(call.ID, "alice", MediaVideo, MediaConstraints{})
+	require.Error(t, err, "call is not answered yet")
+
+	call, err = cs.AnswerMediaCall(call.ID, "bob", MediaConstraints{MaxBitrate: 96})
+	require.NoError(t, err)
+	assert.Equal(t, MediaConstraints{MaxBitrate: 64}, call.Constraints)
+
+	_, err = cs.RequestMedia(call.ID, "alice", MediaAudio, MediaConstraints{})
+	require.Error(t, err, "call is already audio")
+
+	call, err = cs.RequestMedia(call.ID, "alice", MediaVideo, MediaConstraints{MaxWidth: 1280, MaxHeight: 720})
+	require.NoError(t, err)
+	require.NotNil(t, call.MediaRequest)
+
+	_, err = cs.RequestMedia(call.ID, "bob", MediaScreen, MediaConstraints{})
+	require.Error(t, err, "a change is pending")
+	_, err = cs.AnswerMedia(call.ID, "alice", true, MediaConstraints{})
+	require.Error(t, err, "alice asked for it")
+
+	call, err = cs.AnswerMedia(call.ID, "bob", true, MediaConstraints{MaxFrameRate: 15})
+	require.NoError(t, err)
+	assert.Equal(t, MediaVideo, call.Media)
+	assert.Nil(t, call.MediaRequest)
+	assert.Equal(t, MediaConstraints{MaxWidth: 1280, MaxHeight: 720, MaxFrameRate: 15, MaxBitrate: 2500}, call.Constraints)
+
+	call, err = cs.RequestMedia(call.ID, "bob", MediaScreen, MediaConstraints{})
+	require.NoError(t, err)
+	call, err = cs.AnswerMedia(call.ID, "alice", false, MediaConstraints{})
+	require.NoError(t, err)
+	assert.Equal(t, MediaVideo, call.Media, "declined changes keep the media")
+
+	require.NoError(t, cs.EndCall(call.ID, "alice"))
+	assert.Equal(t, MediaVideo, call.Media, "history lists the call as upgraded")
+}
@@ -0,0 +1,236 @@
+package calls
+
+import (
+	"context"
+	"exc6/pkg/breaker"
+	"exc6/pkg/instance"
+	"exc6/pkg/keyspace"
+	"exc6/pkg/logger"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/redis/go-redis/v9"
+)
+
+// While a call is connected, each participant's client reports what WebRTC
+// measures of the media it receives every few seconds. The reports go into
+// the Prometheus histograms as they arrive and are added up per call in
+// Redis; when the call ends the totals become its Quality in history.
+
+func init() {
+	keyspace.Register(keyspace.Family{Prefix: "call_stats:", Description: "media quality reported during a call"})
+	instance.Registerer().MustRegister(statsRTT, statsJitter, statsPacketLoss)
+}
+
+const (
+	// StatsTTL is how long the reports of a call are kept after the last one
+	StatsTTL = 2 * time.Hour
+
+	// maxStatsMs bounds the round trip time and jitter of a report; larger
+	// values are measurement errors, not calls
+	maxStatsMs = 60_000
+)
+
+var (
+	statsRTT = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "call_rtt_seconds",
+			Help:    "Round trip time of call media, as reported by clients",
+			Buckets: []float64{.01, .025, .05, .1, .15, .2, .3, .5, 1, 2},
+		},
+		[]string{"media"}, // audio, video
+	)
+
+	statsJitter = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "call_jitter_seconds",
+			Help:    "Jitter of received call media, as reported by clients",
+			Buckets: []float64{.001, .005, .01, .02, .03, .05, .1, .2, .5},
+		},
+		[]string{"media"},
+	)
+
+	statsPacketLoss = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "call_packet_loss_ratio",
+			Help:    "Share of call media packets lost between two client reports",
+			Buckets: []float64{0, .001, .005, .01, .02, .05, .1, .2, .5},
+		},
+		[]string{"media"},
+	)
+)
+
+// StatsReport is what a participant's client measured of the media it
+// received. Packets are counted since the client's previous report.
+type StatsReport struct {
+	RTTMs           float64 `json:"rtt_ms" form:"rtt_ms"`
+	JitterMs        float64 `json:"jitter_ms" form:"jitter_ms"`
+	PacketsLost     int64   `json:"packets_lost" form:"packets_lost"`
+	PacketsReceived int64   `json:"packets_received" form:"packets_received"`
+}
+
+// Validate reports whether the measurements are plausible
+func (r StatsReport) Validate() error {
+	// Written so that NaN fails too
+	if !(r.RTTMs >= 0 && r.RTTMs <= maxStatsMs) {
+		return fmt.Errorf("rtt_ms must be between 0 and %d", maxStatsMs)
+	}
+	if !(r.JitterMs >= 0 && r.JitterMs <= maxStatsMs) {
+		return fmt.Errorf("jitter_ms must be between 0 and %d", maxStatsMs)
+	}
+	if r.PacketsLost < 0 || r.PacketsReceived < 0 {
+		return fmt.Errorf("packet counts cannot be negative")
+	}
+	return nil
+}
+
+// Quality sums up the stats reported during a call
+type Quality struct {
+	Reports         int64   `json:"reports"`
+	AvgRTTMs        float64 `json:"avg_rtt_ms"`
+	MaxRTTMs        float64 `json:"max_rtt_ms"`
+	AvgJitterMs     float64 `json:"avg_jitter_ms"`
+	MaxJitterMs     float64 `json:"max_jitter_ms"`
+	PacketsLost     int64   `json:"packets_lost"`
+	PacketsReceived int64   `json:"packets_received"`
+
+	// PacketLoss is the share of packets lost over the whole call
+	PacketLoss float64 `json:"packet_loss"`
+}
+
+func callStatsKey(callID string) string {
+	return "call_stats:" + callID
+}
+
+// statsScript adds a report to the totals of a call.
+//
+// KEYS: call stats
+//
+// ARGV: RTT (ms), jitter (ms), packets lost, packets received, TTL (s)
+var statsScript = redis.NewScript(`
+redis.call('HINCRBY', KEYS[1], 'reports', 1)
+redis.call('HINCRBYFLOAT', KEYS[1], 'rtt_sum', ARGV[1])
+redis.call('HINCRBYFLOAT', KEYS[1], 'jitter_sum', ARGV[2])
+redis.call('HINCRBY', KEYS[1], 'packets_lost', ARGV[3])
+redis.call('HINCRBY', KEYS[1], 'packets_received', ARGV[4])
+if tonumber(ARGV[1]) > tonumber(redis.call('HGET', KEYS[1], 'rtt_max') or '-1') then
+	redis.call('HSET', KEYS[1], 'rtt_max', ARGV[1])
+end
+if tonumber(ARGV[2]) > tonumber(redis.call('HGET', KEYS[1], 'jitter_max') or '-1') then
+	redis.call('HSET', KEYS[1], 'jitter_max', ARGV[2])
+end
+redis.call('EXPIRE', KEYS[1], ARGV[5])
+return 1
+`)
+
+// RecordStats adds a report from username to the totals of a connected
+// call they are in. Errors are about the report or the call; reports that
+// cannot be stored are only logged, as the next ones will be.
+func (cs *CallService) RecordStats(ctx context.Context, callID, username string, report StatsReport) error {
+	if err := report.Validate(); err != nil {
+		return err
+	}
+
+	cs.mu.Lock()
+	cs.syncLocked(ctx, []string{callID}, nil)
+	call, err := cs.participantCallLocked(callID, username)
+	if err == nil && call.State != CallStateActive && call.State != CallStateHeld {
+		err = fmt.Errorf("call is not connected")
+	}
+	var media MediaType
+	if err == nil {
+		media = call.Media
+	}
+	cs.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if media == "" {
+		media = MediaAudio
+	}
+	statsRTT.WithLabelValues(string(media)).Observe(report.RTTMs / 1000)
+	statsJitter.WithLabelValues(string(media)).Observe(report.JitterMs / 1000)
+	if total := report.PacketsLost + report.PacketsReceived; total > 0 {
+		statsPacketLoss.WithLabelValues(string(media)).Observe(float64(report.PacketsLost) / float64(total))
+	}
+
+	_, err = breaker.ExecuteCtx(ctx, cs.cb, func() (any, error) {
+		return nil, statsScript.Run(ctx, cs.rdb, []string{callStatsKey(callID)},
+			report.RTTMs, report.JitterMs, report.PacketsLost, report.PacketsReceived, int(StatsTTL.Seconds()),
+		).Err()
+	})
+	if err != nil {
+		logger.WithFields(map[string]any{
+			"call_id": callID,
+			"error":   err.Error(),
+		}).Warn("Circuit breaker: Failed to record call stats")
+	}
+	return nil
+}
+
+// loadQuality returns the totals of a call's reports, or nil without any
+func (cs *CallService) loadQuality(ctx context.Context, callID string) (*Quality, error) {
+	result, err := breaker.ExecuteCtx(ctx, cs.cb, func() (any, error) {
+		return cs.rdb.HGetAll(ctx, callStatsKey(callID)).Result()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return qualityFrom(result.(map[string]string)), nil
+}
+
+// qualityFrom sums up the totals kept by statsScript
+func qualityFrom(fields map[string]string) *Quality {
+	reports, _ := strconv.ParseInt(fields["reports"], 10, 64)
+	if reports <= 0 {
+		return nil
+	}
+
+	float := func(name string) float64 {
+		v, _ := strconv.ParseFloat(fields[name], 64)
+		return v
+	}
+	q := &Quality{
+		Reports:     reports,
+		AvgRTTMs:    float("rtt_sum") / float64(reports),
+		MaxRTTMs:    float("rtt_max"),
+		AvgJitterMs: float("jitter_sum") / float64(reports),
+		MaxJitterMs: float("jitter_max"),
+	}
+	q.PacketsLost, _ = strconv.ParseInt(fields["packets_lost"], 10, 64)
+	q.PacketsReceived, _ = strconv.ParseInt(fields["packets_received"], 10, 64)
+	if total := q.PacketsLost + q.PacketsReceived; total > 0 {
+		q.PacketLoss = float64(q.PacketsLost) / float64(total)
+	}
+	return q
+}
+
+// attachQuality sets the Quality of an ended call from its reports, which
+// are then dropped
+func (cs *CallService) attachQuality(call *Call) {
+	if call.AnsweredAt == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(cs.ctx, 3*time.Second)
+	defer cancel()
+
+	quality, err := cs.loadQuality(ctx, call.ID)
+	if err != nil {
+		logger.WithFields(map[string]any{
+			"call_id": call.ID,
+			"error":   err.Error(),
+		}).Warn("Failed to load call quality stats")
+		return
+	}
+	if quality == nil {
+		return
+	}
+
+	call.Quality = quality
+	cs.rdb.Del(ctx, callStatsKey(call.ID))
+}
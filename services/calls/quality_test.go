@@ -0,0 +1,75 @@
+package calls
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatsReportValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		report  StatsReport
+		wantErr bool
+	}{
+		{name: "Typical", report: StatsReport{RTTMs: 42.5, JitterMs: 3.1, PacketsLost: 2, PacketsReceived: 500}},
+		{name: "Nothing received yet", report: StatsReport{}},
+		{name: "Negative RTT", report: StatsReport{RTTMs: -1}, wantErr: true},
+		{name: "NaN jitter", report: StatsReport{JitterMs: math.NaN()}, wantErr: true},
+		{name: "RTT out of range", report: StatsReport{RTTMs: maxStatsMs + 1}, wantErr: true},
+		{name: "Negative packets", report: StatsReport{PacketsLost: -5}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.report.Validate()
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestQualityFrom(t *testing.T) {
+	assert.Nil(t, qualityFrom(map[string]string{}), "no reports, no quality")
+
+	q := qualityFrom(map[string]string{
+		"reports":          "4",
+		"rtt_sum":          "200",
+		"rtt_max":          "90.5",
+		"jitter_sum":       "20",
+		"jitter_max":       "12",
+		"packets_lost":     "10",
+		"packets_received": "990",
+	})
+	require.NotNil(t, q)
+	assert.Equal(t, &Quality{
+		Reports:         4,
+		AvgRTTMs:        50,
+		MaxRTTMs:        90.5,
+		AvgJitterMs:     5,
+		MaxJitterMs:     12,
+		PacketsLost:     10,
+		PacketsReceived: 990,
+		PacketLoss:      0.01,
+	}, q)
+}
+
+func TestRecordStatsNeedsConnectedCall(t *testing.T) {
+	cs, _ := newRecordingService(t)
+
+	call, err := cs.InitiateCall("alice", "bob")
+	require.NoError(t, err)
+
+	report := StatsReport{RTTMs: 40}
+	assert.Error(t, cs.RecordStats(context.Background(), call.ID, "alice", report), "call is not answered yet")
+
+	require.NoError(t, cs.AnswerCall(call.ID, "bob"))
+	assert.Error(t, cs.RecordStats(context.Background(), call.ID, "carol", report), "only participants report")
+	assert.Error(t, cs.RecordStats(context.Background(), call.ID, "alice", StatsReport{RTTMs: -1}))
+}
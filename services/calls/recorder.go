@@ -0,0 +1,61 @@
+package calls
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"exc6/pkg/httpclient"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// HTTPRecorder drives a recorder service over HTTP:
+// POST /recordings with a RecordingSession returns {"id": "..."}, and
+// DELETE /recordings/{id} stops the recording. The service admits the
+// participants' media with the session token.
+type HTTPRecorder struct {
+	client  *httpclient.Client
+	baseURL string
+}
+
+// NewHTTPRecorder creates a recorder for the service at baseURL
+func NewHTTPRecorder(client *httpclient.Client, baseURL string) *HTTPRecorder {
+	return &HTTPRecorder{
+		client:  client,
+		baseURL: strings.TrimRight(baseURL, "/"),
+	}
+}
+
+// Start implements Recorder
+func (r *HTTPRecorder) Start(ctx context.Context, session RecordingSession) (string, error) {
+	body, err := json.Marshal(session)
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.baseURL+"/recordings", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	// The key lets the recorder drop a retried start of the same session
+	req.Header.Set(httpclient.IdempotencyKeyHeader, session.CallID)
+
+	var out struct {
+		ID string `json:"id"`
+	}
+	if err := r.client.DoJSON(req, &out); err != nil {
+		return "", err
+	}
+	return out.ID, nil
+}
+
+// Stop implements Recorder
+func (r *HTTPRecorder) Stop(ctx context.Context, recordingID string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, r.baseURL+"/recordings/"+url.PathEscape(recordingID), nil)
+	if err != nil {
+		return err
+	}
+	return r.client.DoJSON(req, nil)
+}
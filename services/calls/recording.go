@@ -0,0 +1,340 @@
+package calls
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"exc6/pkg/logger"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// A participant asks to record an active call; recording starts only once
+// the other participant consents, at which point the service issues a token
+// and hands it to the recorder. Recording metadata (never the token) is kept
+// on the call and so ends up in both users' call history.
+
+// RecordingState is the state of a call recording
+type RecordingState string
+
+const (
+	RecordingRequested RecordingState = "requested"
+	RecordingActive    RecordingState = "recording"
+	RecordingDeclined  RecordingState = "declined"
+	RecordingStopped   RecordingState = "stopped"
+	RecordingFailed    RecordingState = "failed"
+)
+
+// Recording policies, set by the organisation
+const (
+	RecordingPolicyOff      = "off"
+	RecordingPolicyEveryone = "everyone"
+	RecordingPolicyRoles    = "roles"
+)
+
+// recorderTimeout bounds stopping a recording when a call ends
+const recorderTimeout = 10 * time.Second
+
+// Recording is the recording of a call
+type Recording struct {
+	ID          string         `json:"id,omitempty"` // assigned by the recorder
+	State       RecordingState `json:"state"`
+	RequestedBy string         `json:"requested_by"`
+	ConsentedBy []string       `json:"consented_by"`
+	RequestedAt int64          `json:"requested_at"`
+	StartedAt   int64          `json:"started_at,omitempty"`
+	StoppedAt   int64          `json:"stopped_at,omitempty"`
+	StoppedBy   string         `json:"stopped_by,omitempty"`
+
+	// token authenticates the recorder; it is never persisted
+	token string
+}
+
+// RecordingSession is what the recorder is given to record a call
+type RecordingSession struct {
+	CallID       string    `json:"call_id"`
+	Token        string    `json:"token"`
+	Participants []string  `json:"participants"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+// Recorder records calls
+type Recorder interface {
+	// Start begins recording and returns the recorder's ID for it
+	Start(ctx context.Context, session RecordingSession) (string, error)
+	Stop(ctx context.Context, recordingID string) error
+}
+
+// RecordingPolicy decides who may ask to record a call
+type RecordingPolicy struct {
+	Mode     string // RecordingPolicyOff, RecordingPolicyEveryone or RecordingPolicyRoles
+	Roles    []string
+	TokenTTL time.Duration
+}
+
+// Allows reports whether a user with role may ask to record
+func (p RecordingPolicy) Allows(role string) bool {
+	switch p.Mode {
+	case RecordingPolicyEveryone:
+		return true
+	case RecordingPolicyRoles:
+		return slices.Contains(p.Roles, role)
+	default:
+		return false
+	}
+}
+
+// SetRecorder enables call recording through rec, subject to policy
+func (cs *CallService) SetRecorder(rec Recorder, policy RecordingPolicy) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.recorder = rec
+	cs.recordingPolicy = policy
+}
+
+// RecordingEnabled reports whether calls can be recorded at all
+func (cs *CallService) RecordingEnabled() bool {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	return cs.recorder != nil && cs.recordingPolicy.Mode != RecordingPolicyOff
+}
+
+// CanRecord reports whether a user with role may ask to record calls
+func (cs *CallService) CanRecord(role string) bool {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	return cs.recorder != nil && cs.recordingPolicy.Allows(role)
+}
+
+// otherParty returns the participant of call that isn't username
+func otherParty(call *Call, username string) string {
+	if call.Caller == username {
+		return call.Callee
+	}
+	return call.Caller
+}
+
+// RequestRecording asks to record an active call on behalf of username, who
+// thereby consents. The caller checks the policy with CanRecord first.
+func (cs *CallService) RequestRecording(callID, username string) (*Call, error) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	var call *Call
+	err := cs.updateLocked(cs.ctx, []string{callID}, nil, func() ([]*Call, error) {
+		var err error
+		if call, err = cs.participantCallLocked(callID, username); err != nil {
+			return nil, err
+		}
+		if call.State != CallStateActive {
+			return nil, fmt.Errorf("call is not active")
+		}
+		if call.Recording != nil && (call.Recording.State == RecordingRequested || call.Recording.State == RecordingActive) {
+			return nil, fmt.Errorf("call is already %s", call.Recording.State)
+		}
+
+		call.Recording = &Recording{
+			State:       RecordingRequested,
+			RequestedBy: username,
+			ConsentedBy: []string{username},
+			RequestedAt: time.Now().Unix(),
+		}
+		return []*Call{call}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return call, nil
+}
+
+// AnswerRecording records the other participant's answer to a recording
+// request. On consent the recorder is started; the returned token is only
+// for the participants and is empty unless recording started.
+func (cs *CallService) AnswerRecording(ctx context.Context, callID, username string, consent bool) (*Call, string, error) {
+	var call *Call
+	var token string
+	var recorder Recorder
+	var session RecordingSession
+
+	cs.mu.Lock()
+	err := cs.updateLocked(cs.ctx, []string{callID}, nil, func() ([]*Call, error) {
+		var exists bool
+		call, exists = cs.activeCalls[callID]
+		if !exists {
+			return nil, fmt.Errorf("call not found: %s", callID)
+		}
+		rec := call.Recording
+		if rec == nil || rec.State != RecordingRequested || slices.Contains(rec.ConsentedBy, username) {
+			return nil, fmt.Errorf("no recording request to answer")
+		}
+		if username != otherParty(call, rec.RequestedBy) {
+			return nil, fmt.Errorf("user %s cannot answer this recording request", username)
+		}
+
+		if !consent {
+			rec.State = RecordingDeclined
+			return []*Call{call}, nil
+		}
+
+		var err error
+		if token, err = newRecordingToken(); err != nil {
+			return nil, err
+		}
+		rec.ConsentedBy = append(rec.ConsentedBy, username)
+		rec.token = token
+
+		recorder = cs.recorder
+		session = RecordingSession{
+			CallID:       call.ID,
+			Token:        token,
+			Participants: []string{call.Caller, call.Callee},
+			ExpiresAt:    time.Now().Add(cs.recordingPolicy.TokenTTL),
+		}
+		return []*Call{call}, nil
+	})
+	cs.mu.Unlock()
+	if err != nil {
+		return nil, "", err
+	}
+	if !consent {
+		return call, "", nil
+	}
+
+	// The recorder is called without holding the lock; having consented
+	// keeps a second answer out in the meantime
+	var recordingID string
+	var startErr error
+	if recorder == nil {
+		startErr = fmt.Errorf("call recording is not enabled")
+	} else {
+		recordingID, startErr = recorder.Start(ctx, session)
+	}
+
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	ended := false
+	err = cs.updateLocked(cs.ctx, []string{callID}, nil, func() ([]*Call, error) {
+		current, exists := cs.activeCalls[callID]
+		if !exists || current.Recording == nil || current.Recording.token != token {
+			// The call ended while the recorder was starting
+			ended = true
+			return nil, nil
+		}
+		call = current
+		rec := call.Recording
+
+		if startErr != nil {
+			rec.State = RecordingFailed
+			rec.token = ""
+			return []*Call{call}, nil
+		}
+
+		rec.ID = recordingID
+		rec.State = RecordingActive
+		rec.StartedAt = time.Now().Unix()
+		return []*Call{call}, nil
+	})
+
+	switch {
+	case startErr != nil:
+		logger.WithFields(map[string]any{
+			"call_id": callID,
+			"error":   startErr.Error(),
+		}).Error("Failed to start call recording")
+		return call, "", startErr
+	case ended:
+		cs.stopRecorder(call.ID, recorder, recordingID)
+		return call, "", fmt.Errorf("call ended")
+	case err != nil:
+		cs.stopRecorder(call.ID, recorder, recordingID)
+		return call, "", err
+	}
+
+	logger.WithFields(map[string]any{
+		"call_id":      callID,
+		"recording_id": recordingID,
+	}).Info("Call recording started")
+
+	return call, token, nil
+}
+
+// StopRecording stops recording a call; either participant may stop it
+func (cs *CallService) StopRecording(callID, username string) (*Call, error) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	var call *Call
+	err := cs.updateLocked(cs.ctx, []string{callID}, nil, func() ([]*Call, error) {
+		var err error
+		if call, err = cs.participantCallLocked(callID, username); err != nil {
+			return nil, err
+		}
+		if call.Recording == nil || call.Recording.State != RecordingActive {
+			return nil, fmt.Errorf("call is not being recorded")
+		}
+
+		cs.stopRecordingLocked(call, username)
+		return []*Call{call}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return call, nil
+}
+
+// stopRecordingLocked marks an active recording stopped and tells the
+// recorder in the background. cs.mu must be held.
+func (cs *CallService) stopRecordingLocked(call *Call, username string) {
+	rec := call.Recording
+	if rec == nil {
+		return
+	}
+	if rec.State == RecordingRequested {
+		// Nobody consented before the call ended
+		rec.State = RecordingDeclined
+		return
+	}
+	if rec.State != RecordingActive {
+		return
+	}
+
+	rec.State = RecordingStopped
+	rec.StoppedAt = time.Now().Unix()
+	rec.StoppedBy = username
+	rec.token = ""
+
+	recorder, recordingID := cs.recorder, rec.ID
+	cs.afterLocked(func() {
+		cs.stopRecorder(call.ID, recorder, recordingID)
+	})
+}
+
+// stopRecorder tells the recorder to stop in the background
+func (cs *CallService) stopRecorder(callID string, recorder Recorder, recordingID string) {
+	if recorder == nil {
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(cs.ctx, recorderTimeout)
+		defer cancel()
+
+		if err := recorder.Stop(ctx, recordingID); err != nil {
+			logger.WithFields(map[string]any{
+				"call_id":      callID,
+				"recording_id": recordingID,
+				"error":        err.Error(),
+			}).Error("Failed to stop call recording")
+		}
+	}()
+}
+
+func newRecordingToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,133 @@
+package calls
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordingPolicyAllows(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy RecordingPolicy
+		role   string
+		want   bool
+	}{
+		{name: "Off", policy: RecordingPolicy{Mode: RecordingPolicyOff}, role: "admin", want: false},
+		{name: "Everyone", policy: RecordingPolicy{Mode: RecordingPolicyEveryone}, role: "member", want: true},
+		{name: "Listed role", policy: RecordingPolicy{Mode: RecordingPolicyRoles, Roles: []string{"admin", "support"}}, role: "support", want: true},
+		{name: "Unlisted role", policy: RecordingPolicy{Mode: RecordingPolicyRoles, Roles: []string{"admin"}}, role: "member", want: false},
+		{name: "Unknown mode", policy: RecordingPolicy{Mode: "sometimes"}, role: "admin", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.policy.Allows(tt.role))
+		})
+	}
+}
+
+type fakeRecorder struct {
+	started []RecordingSession
+	stopped chan string
+}
+
+func (r *fakeRecorder) Start(ctx context.Context, session RecordingSession) (string, error) {
+	r.started = append(r.started, session)
+	return "rec-1", nil
+}
+
+func (r *fakeRecorder) Stop(ctx context.Context, recordingID string) error {
+	r.stopped <- recordingID
+	return nil
+}
+
+// newRecordingService creates a call service that records through a fake.
+// Redis is unreachable; call persistence failures are only logged.
+func newRecordingService(t *testing.T) (*CallService, *fakeRecorder) {
+	rdb := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", MaxRetries: -1, DialerRetries: 1})
+	cs := NewCallService(context.Background(), rdb)
+	t.Cleanup(cs.Close)
+
+	recorder := &fakeRecorder{stopped: make(chan string, 1)}
+	cs.SetRecorder(recorder, RecordingPolicy{Mode: RecordingPolicyEveryone, TokenTTL: time.Hour})
+
+	return cs, recorder
+}
+
+func TestRecordingConsent(t *testing.T) {
+	cs, recorder := newRecordingService(t)
+
+	call, err := cs.InitiateCall("alice", "bob")
+	require.NoError(t, err)
+
+	_, err = cs.RequestRecording(call.ID, "alice")
+	require.Error(t, err, "call is not answered yet")
+
+	require.NoError(t, cs.AnswerCall(call.ID, "bob"))
+
+	_, err = cs.RequestRecording(call.ID, "carol")
+	require.Error(t, err, "only participants may ask")
+
+	call, err = cs.RequestRecording(call.ID, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, RecordingRequested, call.Recording.State)
+
+	_, err = cs.RequestRecording(call.ID, "bob")
+	require.Error(t, err, "a request is already pending")
+
+	_, _, err = cs.AnswerRecording(context.Background(), call.ID, "alice", true)
+	require.Error(t, err, "the requester cannot consent for the other side")
+	assert.Empty(t, recorder.started)
+
+	call, token, err := cs.AnswerRecording(context.Background(), call.ID, "bob", true)
+	require.NoError(t, err)
+	require.NotEmpty(t, token)
+	assert.Equal(t, RecordingActive, call.Recording.State)
+	assert.Equal(t, "rec-1", call.Recording.ID)
+	assert.Equal(t, []string{"alice", "bob"}, call.Recording.ConsentedBy)
+	require.Len(t, recorder.started, 1)
+	assert.Equal(t, token, recorder.started[0].Token)
+
+	// The token never leaves the process with the call metadata
+	data, err := json.Marshal(call)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), token)
+
+	require.NoError(t, cs.EndCall(call.ID, "bob"))
+	assert.Equal(t, RecordingStopped, call.Recording.State)
+	assert.Equal(t, "bob", call.Recording.StoppedBy)
+
+	select {
+	case id := <-recorder.stopped:
+		assert.Equal(t, "rec-1", id)
+	case <-time.After(5 * time.Second):
+		t.Fatal("recorder was not stopped when the call ended")
+	}
+}
+
+func TestRecordingDeclined(t *testing.T) {
+	cs, recorder := newRecordingService(t)
+
+	call, err := cs.InitiateCall("alice", "bob")
+	require.NoError(t, err)
+	require.NoError(t, cs.AnswerCall(call.ID, "bob"))
+
+	_, err = cs.RequestRecording(call.ID, "bob")
+	require.NoError(t, err)
+
+	call, token, err := cs.AnswerRecording(context.Background(), call.ID, "alice", false)
+	require.NoError(t, err)
+	assert.Empty(t, token)
+	assert.Equal(t, RecordingDeclined, call.Recording.State)
+	assert.Empty(t, recorder.started)
+
+	// A declined request may be asked again
+	_, err = cs.RequestRecording(call.ID, "alice")
+	assert.NoError(t, err)
+}
@@ -0,0 +1,453 @@
+package calls
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"exc6/pkg/breaker"
+	"exc6/pkg/instance"
+	"exc6/pkg/keyspace"
+	"exc6/pkg/logger"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/redis/go-redis/v9"
+)
+
+// A call room holds a call between any number of participants, up to its
+// size limit. Participants connect to each other directly (a full mesh), so
+// every pair exchanges its own WebRTC offer, answer and ICE candidates,
+// relayed through the WebSocket manager to the participant they name.
+//
+// Rooms live in Redis rather than on the instance that created them, as
+// their participants may be connected to any instance; every change is a
+// transaction on the room's key. A room is open to the members of its group,
+// or to the users its creator invited, and ends when its last participant
+// leaves. Rooms nobody changed for RoomTTL expire, which also clears
+// participants whose connection dropped without leaving.
+
+const (
+	// MaxRoomSize bounds the participants of a room. Each participant
+	// sends its media to every other, so uplink use grows with the room.
+	MaxRoomSize = 8
+
+	// RoomTTL is how long a room is kept after its last change
+	RoomTTL = 12 * time.Hour
+
+	roomKeyPrefix     = "call_room:"
+	roomUserKeyPrefix = "call_room_user:"
+
+	// roomUpdateRetries bounds attempts at a change that raced another
+	roomUpdateRetries = 5
+
+	roomTimeout = 3 * time.Second
+)
+
+// ParticipantState tracks a participant's connection to the others
+type ParticipantState string
+
+const (
+	// ParticipantJoining participants are still connecting to the others
+	ParticipantJoining ParticipantState = "joining"
+
+	// ParticipantConnected participants have media flowing
+	ParticipantConnected ParticipantState = "connected"
+)
+
+var (
+	// ErrRoomNotFound is returned for rooms that ended or never existed,
+	// and rooms the user may not see
+	ErrRoomNotFound = errors.New("call room not found")
+
+	// ErrRoomFull is returned when joining a room at its size limit
+	ErrRoomFull = errors.New("call room is full")
+
+	// ErrInRoom is returned when the user is already in another room
+	ErrInRoom = errors.New("already in a call room")
+)
+
+var roomEvents = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "call_room_events_total",
+		Help: "Call room changes by event",
+	},
+	[]string{"event"}, // created, joined, left, ended, full
+)
+
+func init() {
+	instance.Registerer().MustRegister(roomEvents)
+
+	keyspace.Register(
+		keyspace.Family{Prefix: roomKeyPrefix, Description: "call rooms and their participants"},
+		keyspace.Family{Prefix: roomUserKeyPrefix, Description: "the call room each user is in"},
+	)
+}
+
+// RoomParticipant is a user in a call room and the state of their media
+type RoomParticipant struct {
+	Username string           `json:"username"`
+	JoinedAt int64            `json:"joined_at"`
+	State    ParticipantState `json:"state"`
+	Muted    bool             `json:"muted"`
+	Video    bool             `json:"video"`
+}
+
+// CallRoom is a call between any number of participants
+type CallRoom struct {
+	ID        string `json:"id"`
+	CreatedBy string `json:"created_by"`
+	CreatedAt int64  `json:"created_at"`
+
+	// GroupID is the group whose members may join; Invited lists who may
+	// join a room without one
+	GroupID string   `json:"group_id,omitempty"`
+	Invited []string `json:"invited,omitempty"`
+
+	MaxParticipants int `json:"max_participants"`
+
+	// Participants are in the order they joined
+	Participants []*RoomParticipant `json:"participants"`
+}
+
+// ParticipantUpdate changes the fields of a participant that are set
+type ParticipantUpdate struct {
+	State *ParticipantState
+	Muted *bool
+	Video *bool
+}
+
+// Participant returns a participant of the room, or nil
+func (r *CallRoom) Participant(username string) *RoomParticipant {
+	for _, p := range r.Participants {
+		if p.Username == username {
+			return p
+		}
+	}
+	return nil
+}
+
+// Usernames returns the participants' usernames
+func (r *CallRoom) Usernames() []string {
+	usernames := make([]string, len(r.Participants))
+	for i, p := range r.Participants {
+		usernames[i] = p.Username
+	}
+	return usernames
+}
+
+// join adds username to the room. Joining again is not an error.
+func (r *CallRoom) join(username string, now time.Time) error {
+	if r.Participant(username) != nil {
+		return nil
+	}
+	if len(r.Participants) >= r.MaxParticipants {
+		return ErrRoomFull
+	}
+	r.Participants = append(r.Participants, &RoomParticipant{
+		Username: username,
+		JoinedAt: now.Unix(),
+		State:    ParticipantJoining,
+	})
+	return nil
+}
+
+// leave removes username from the room and reports whether they were in it
+func (r *CallRoom) leave(username string) bool {
+	n := len(r.Participants)
+	r.Participants = slices.DeleteFunc(r.Participants, func(p *RoomParticipant) bool {
+		return p.Username == username
+	})
+	return len(r.Participants) < n
+}
+
+// update applies a participant's update
+func (r *CallRoom) update(username string, u ParticipantUpdate) error {
+	p := r.Participant(username)
+	if p == nil {
+		return fmt.Errorf("%s is not in this room", username)
+	}
+	if u.State != nil {
+		if *u.State != ParticipantJoining && *u.State != ParticipantConnected {
+			return fmt.Errorf("invalid participant state: %s", *u.State)
+		}
+		p.State = *u.State
+	}
+	if u.Muted != nil {
+		p.Muted = *u.Muted
+	}
+	if u.Video != nil {
+		p.Video = *u.Video
+	}
+	return nil
+}
+
+func roomKey(roomID string) string {
+	return roomKeyPrefix + roomID
+}
+
+func roomUserKey(username string) string {
+	return roomUserKeyPrefix + username
+}
+
+// CreateRoom opens a room for a group's members, or for the users invited,
+// with its creator as the first participant. maxParticipants of zero means
+// MaxRoomSize.
+func (cs *CallService) CreateRoom(ctx context.Context, creator, groupID string, invited []string, maxParticipants int) (*CallRoom, error) {
+	if maxParticipants == 0 {
+		maxParticipants = MaxRoomSize
+	}
+	if maxParticipants < 2 || maxParticipants > MaxRoomSize {
+		return nil, fmt.Errorf("room size must be between 2 and %d", MaxRoomSize)
+	}
+	if groupID == "" && len(invited) == 0 {
+		return nil, fmt.Errorf("a room needs a group or invited users")
+	}
+	if groupID != "" && len(invited) > 0 {
+		return nil, fmt.Errorf("group rooms are open to the group's members and take no invitations")
+	}
+	if len(invited) >= maxParticipants*2 {
+		return nil, fmt.Errorf("too many users invited")
+	}
+	if cs.IsUserInCall(creator) {
+		return nil, fmt.Errorf("already in a call")
+	}
+
+	invited = slices.Compact(slices.Sorted(slices.Values(invited)))
+	invited = slices.DeleteFunc(invited, func(u string) bool { return u == creator })
+
+	now := time.Now()
+	room := &CallRoom{
+		ID:              uuid.NewString(),
+		CreatedBy:       creator,
+		CreatedAt:       now.Unix(),
+		GroupID:         groupID,
+		Invited:         invited,
+		MaxParticipants: maxParticipants,
+	}
+	if err := room.join(creator, now); err != nil {
+		return nil, err
+	}
+
+	if _, err := cs.updateRoom(ctx, room.ID, creator, func(current *CallRoom) (*CallRoom, error) {
+		return room, nil
+	}); err != nil {
+		return nil, err
+	}
+
+	roomEvents.WithLabelValues("created").Inc()
+	logger.WithFields(map[string]any{
+		"room_id":  room.ID,
+		"creator":  creator,
+		"group_id": groupID,
+	}).Info("Call room created")
+
+	return room, nil
+}
+
+// GetRoom returns a room
+func (cs *CallService) GetRoom(ctx context.Context, roomID string) (*CallRoom, error) {
+	result, err := breaker.ExecuteCtx(ctx, cs.cb, func() (interface{}, error) {
+		return cs.rdb.Get(ctx, roomKey(roomID)).Bytes()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The breaker reports redis.Nil as a nil result
+	data, ok := result.([]byte)
+	if !ok {
+		return nil, ErrRoomNotFound
+	}
+
+	var room CallRoom
+	if err := json.Unmarshal(data, &room); err != nil {
+		return nil, err
+	}
+	return &room, nil
+}
+
+// JoinRoom adds username to a room. Callers check that a group room's
+// joiners are members of the group; others must be invited.
+func (cs *CallService) JoinRoom(ctx context.Context, roomID, username string) (*CallRoom, error) {
+	if cs.IsUserInCall(username) {
+		return nil, fmt.Errorf("already in a call")
+	}
+
+	room, err := cs.updateRoom(ctx, roomID, username, func(room *CallRoom) (*CallRoom, error) {
+		if room == nil {
+			return nil, ErrRoomNotFound
+		}
+		if room.GroupID == "" && room.CreatedBy != username && !slices.Contains(room.Invited, username) {
+			return nil, ErrRoomNotFound
+		}
+		return room, room.join(username, time.Now())
+	})
+	switch {
+	case errors.Is(err, ErrRoomFull):
+		roomEvents.WithLabelValues("full").Inc()
+		return nil, err
+	case err != nil:
+		return nil, err
+	}
+
+	roomEvents.WithLabelValues("joined").Inc()
+	return room, nil
+}
+
+// LeaveRoom removes username from a room and reports whether the room ended
+// because they were the last participant
+func (cs *CallService) LeaveRoom(ctx context.Context, roomID, username string) (*CallRoom, bool, error) {
+	room, err := cs.updateRoom(ctx, roomID, username, func(room *CallRoom) (*CallRoom, error) {
+		if room == nil || !room.leave(username) {
+			return nil, ErrRoomNotFound
+		}
+		return room, nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	ended := len(room.Participants) == 0
+	if ended {
+		roomEvents.WithLabelValues("ended").Inc()
+	} else {
+		roomEvents.WithLabelValues("left").Inc()
+	}
+	return room, ended, nil
+}
+
+// UpdateParticipant changes the state of username's media in a room
+func (cs *CallService) UpdateParticipant(ctx context.Context, roomID, username string, u ParticipantUpdate) (*CallRoom, error) {
+	return cs.updateRoom(ctx, roomID, username, func(room *CallRoom) (*CallRoom, error) {
+		if room == nil {
+			return nil, ErrRoomNotFound
+		}
+		return room, room.update(username, u)
+	})
+}
+
+// RoomOf returns the ID of the room username is in, or "" when they are in
+// none
+func (cs *CallService) RoomOf(ctx context.Context, username string) (string, error) {
+	result, err := breaker.ExecuteCtx(ctx, cs.cb, func() (interface{}, error) {
+		return cs.rdb.Get(ctx, roomUserKey(username)).Result()
+	})
+	if err != nil {
+		return "", err
+	}
+	roomID, _ := result.(string)
+	return roomID, nil
+}
+
+// InRoom reports whether every one of usernames is a participant of a room,
+// for relaying signaling between them
+func (cs *CallService) InRoom(ctx context.Context, roomID string, usernames ...string) bool {
+	room, err := cs.GetRoom(ctx, roomID)
+	if err != nil {
+		return false
+	}
+	for _, username := range usernames {
+		if room.Participant(username) == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// updateRoom changes a room on behalf of username in a transaction, retrying
+// when another change raced it. change gets nil for a room that does not
+// exist and returns the room to store; a room left without participants is
+// deleted. username's room entry follows whether they are still in it, and
+// they cannot be added to a room while in another. Changes refused are not
+// failures of Redis, so they are kept from the breaker.
+func (cs *CallService) updateRoom(ctx context.Context, roomID, username string, change func(*CallRoom) (*CallRoom, error)) (*CallRoom, error) {
+	ctx, cancel := context.WithTimeout(ctx, roomTimeout)
+	defer cancel()
+
+	key, userKey := roomKey(roomID), roomUserKey(username)
+
+	var updated *CallRoom
+	var refused error
+	txf := func(tx *redis.Tx) error {
+		updated, refused = nil, nil
+
+		var current *CallRoom
+		data, err := tx.Get(ctx, key).Bytes()
+		switch {
+		case err == nil:
+			current = &CallRoom{}
+			if err := json.Unmarshal(data, current); err != nil {
+				return err
+			}
+		case !errors.Is(err, redis.Nil):
+			return err
+		}
+
+		wasIn := current != nil && current.Participant(username) != nil
+		room, err := change(current)
+		if err != nil {
+			refused = err
+			return nil
+		}
+		isIn := room.Participant(username) != nil
+
+		if isIn && !wasIn {
+			other, err := tx.Get(ctx, userKey).Result()
+			if err != nil && !errors.Is(err, redis.Nil) {
+				return err
+			}
+			if other != "" && other != roomID {
+				refused = ErrInRoom
+				return nil
+			}
+		}
+
+		payload, err := json.Marshal(room)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			if len(room.Participants) == 0 {
+				pipe.Del(ctx, key)
+			} else {
+				pipe.Set(ctx, key, payload, RoomTTL)
+			}
+			if isIn {
+				pipe.Set(ctx, userKey, roomID, RoomTTL)
+			} else if wasIn {
+				pipe.Del(ctx, userKey)
+			}
+			return nil
+		})
+		if err == nil {
+			updated = room
+		}
+		return err
+	}
+
+	_, err := breaker.ExecuteCtx(ctx, cs.cb, func() (interface{}, error) {
+		for range roomUpdateRetries {
+			err := cs.rdb.Watch(ctx, txf, key, userKey)
+			if !errors.Is(err, redis.TxFailedErr) {
+				return nil, err
+			}
+		}
+		return nil, redis.TxFailedErr
+	})
+	if err != nil {
+		logger.WithFields(map[string]any{
+			"room_id":  roomID,
+			"username": username,
+			"error":    err.Error(),
+		}).Error("Circuit breaker: Failed to update call room")
+		return nil, err
+	}
+	if refused != nil {
+		return nil, refused
+	}
+	return updated, nil
+}
@@ -0,0 +1,81 @@
+package calls
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCallRoomMembership(t *testing.T) {
+	now := time.Now()
+	room := &CallRoom{ID: "r1", MaxParticipants: 3}
+
+	require.NoError(t, room.join("alice", now))
+	require.NoError(t, room.join("bob", now))
+	require.NoError(t, room.join("alice", now), "joining again is not an error")
+	require.NoError(t, room.join("carol", now))
+	assert.ErrorIs(t, room.join("dave", now), ErrRoomFull)
+	assert.Equal(t, []string{"alice", "bob", "carol"}, room.Usernames())
+	assert.Equal(t, ParticipantJoining, room.Participant("bob").State)
+
+	assert.True(t, room.leave("bob"))
+	assert.False(t, room.leave("bob"), "bob already left")
+	assert.Nil(t, room.Participant("bob"))
+	require.NoError(t, room.join("dave", now), "a place freed up")
+	assert.Equal(t, []string{"alice", "carol", "dave"}, room.Usernames())
+}
+
+func TestCallRoomParticipantUpdate(t *testing.T) {
+	room := &CallRoom{ID: "r1", MaxParticipants: 2}
+	require.NoError(t, room.join("alice", time.Now()))
+
+	connected, muted := ParticipantConnected, true
+	require.NoError(t, room.update("alice", ParticipantUpdate{State: &connected, Muted: &muted}))
+
+	alice := room.Participant("alice")
+	assert.Equal(t, ParticipantConnected, alice.State)
+	assert.True(t, alice.Muted)
+	assert.False(t, alice.Video, "fields not set are kept")
+
+	invalid := ParticipantState("speaking")
+	assert.Error(t, room.update("alice", ParticipantUpdate{State: &invalid}))
+	assert.Error(t, room.update("bob", ParticipantUpdate{Muted: &muted}), "bob is not in the room")
+}
+
+func TestCreateRoomValidation(t *testing.T) {
+	cs, _ := newRecordingService(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		groupID string
+		invited []string
+		max     int
+	}{
+		{name: "Too large", groupID: "g1", max: MaxRoomSize + 1},
+		{name: "Too small", groupID: "g1", max: 1},
+		{name: "Nobody may join", max: 4},
+		{name: "Group and invitations", groupID: "g1", invited: []string{"bob"}},
+		{name: "Too many invited", invited: []string{"a", "b", "c", "d"}, max: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := cs.CreateRoom(ctx, "alice", tt.groupID, tt.invited, tt.max)
+			assert.Error(t, err)
+		})
+	}
+
+	call, err := cs.InitiateCall("alice", "bob")
+	require.NoError(t, err)
+	_, err = cs.CreateRoom(ctx, "alice", "g1", nil, 0)
+	assert.Error(t, err, "alice is in a call")
+	_, err = cs.JoinRoom(ctx, "r1", "bob")
+	assert.Error(t, err, "bob is in a call")
+	require.NoError(t, cs.EndCall(call.ID, "alice"))
+
+	assert.False(t, cs.InRoom(ctx, "r1", "alice"), "rooms that cannot be read have nobody in them")
+}
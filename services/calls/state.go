@@ -0,0 +1,465 @@
+package calls
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"exc6/pkg/breaker"
+	"exc6/pkg/logger"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Live calls are kept in Redis so that every instance sees the same calls
+// and they survive restarts: call:<id> holds a call and call_user:<username>
+// the call a user is in. Live calls expire ActiveCallTTL after the instances
+// caching them last refreshed them. An instance refreshes the calls its
+// participants changed or acted on within ActiveCallTTL, which connected
+// calls are every few seconds as clients report their quality, so the
+// calls of an instance that went away without ending them don't linger
+// because another instance once read them.
+//
+// Each instance caches the calls and users it has read, and serves them for
+// callCacheTTL before reading them again. Changes are made with
+// WATCH/MULTI: the calls and users involved are read, changed in the cache
+// and written back, and the change starts over if another instance changed
+// any of them in the meantime. An instance that cannot reach Redis carries
+// on with its cache alone and writes the calls it changed back once Redis
+// is reachable again, as it does with calls in use here that Redis lost.
+
+const (
+	// ActiveCallTTL is how long a live call and its participants' entries
+	// are kept after they were last refreshed
+	ActiveCallTTL = 2 * time.Minute
+
+	// EndedCallTTL is how long an ended call is kept
+	EndedCallTTL = 24 * time.Hour
+
+	// callCacheTTL is how long calls and users read from Redis are served
+	// from the cache
+	callCacheTTL = time.Second
+
+	callTimeout   = 3 * time.Second
+	callTxRetries = 5
+)
+
+// errCallConflict is returned when other instances kept changing a call
+var errCallConflict = errors.New("call changed concurrently, try again")
+
+func callKey(callID string) string {
+	return "call:" + callID
+}
+
+func callUserKey(username string) string {
+	return "call_user:" + username
+}
+
+// callTTL is how long a call is kept in Redis
+func callTTL(call *Call) time.Duration {
+	if call.State == CallStateEnded {
+		return EndedCallTTL
+	}
+	return ActiveCallTTL
+}
+
+// afterLocked runs fn once the change being made is stored, or not at all
+// if it is refused. cs.mu must be held.
+func (cs *CallService) afterLocked(fn func()) {
+	cs.committed = append(cs.committed, fn)
+}
+
+// syncLocked refreshes the cache of the given calls and users from Redis,
+// along with the calls those users are in, skipping what was read within
+// callCacheTTL. When Redis cannot be read the cache is left as it is.
+// cs.mu must be held.
+func (cs *CallService) syncLocked(ctx context.Context, callIDs, users []string) {
+	ctx, cancel := context.WithTimeout(ctx, callTimeout)
+	defer cancel()
+
+	_, err := breaker.ExecuteCtx(ctx, cs.cb, func() (any, error) {
+		if err := cs.readLocked(ctx, cs.rdb, cs.staleLocked(userKeys(users))); err != nil {
+			return nil, err
+		}
+		callIDs := append(slices.Clone(callIDs), cs.userCallIDsLocked(users)...)
+		return nil, cs.readLocked(ctx, cs.rdb, cs.staleLocked(callKeys(callIDs)))
+	})
+	if err != nil {
+		logger.WithError(err).Debug("Serving calls from the local cache")
+	}
+}
+
+// staleLocked returns the keys not read within callCacheTTL. cs.mu must be
+// held.
+func (cs *CallService) staleLocked(keys []string) []string {
+	var stale []string
+	for _, key := range keys {
+		if time.Since(cs.synced[key]) >= callCacheTTL {
+			stale = append(stale, key)
+		}
+	}
+	return stale
+}
+
+// readLocked reads keys of calls and users into the cache. cs.mu must be
+// held.
+func (cs *CallService) readLocked(ctx context.Context, cmd redis.Cmdable, keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	values, err := cmd.MGet(ctx, keys...).Result()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for i, key := range keys {
+		value, _ := values[i].(string)
+		if callID, ok := strings.CutPrefix(key, "call:"); ok {
+			cs.cacheCallLocked(callID, value)
+		} else if username, ok := strings.CutPrefix(key, "call_user:"); ok {
+			cs.cacheUserLocked(username, value)
+		}
+		cs.synced[key] = now
+	}
+	return nil
+}
+
+// cacheCallLocked stores a call read from Redis in the cache. The cached
+// call is updated in place, so those holding it see the change, and leaves
+// the cache once ended. Calls Redis no longer has are written back if in
+// use here, and otherwise were let expire and leave the cache too.
+// cs.mu must be held.
+func (cs *CallService) cacheCallLocked(callID, value string) {
+	if _, dirty := cs.dirty[callID]; dirty {
+		// Changed here while Redis was unreachable, so the cache is newer
+		return
+	}
+
+	cached, ok := cs.activeCalls[callID]
+	if value == "" {
+		switch {
+		case !ok:
+		case cs.inUseLocked(callID):
+			cs.dirty[callID] = cached
+		default:
+			cs.forgetLocked(callID)
+		}
+		return
+	}
+
+	var call Call
+	if err := json.Unmarshal([]byte(value), &call); err != nil {
+		logger.WithFields(map[string]any{
+			"call_id": callID,
+			"error":   err.Error(),
+		}).Warn("Failed to unmarshal call")
+		return
+	}
+
+	if !ok {
+		cached = &call
+	} else {
+		if cached.Recording != nil && call.Recording != nil && cached.Recording.RequestedAt == call.Recording.RequestedAt {
+			// The token is never stored
+			call.Recording.token = cached.Recording.token
+		}
+		*cached = call
+	}
+
+	if cached.State == CallStateEnded {
+		cs.forgetLocked(callID)
+		return
+	}
+	cs.activeCalls[callID] = cached
+}
+
+// forgetLocked drops a call and its participants' entries from the cache.
+// cs.mu must be held.
+func (cs *CallService) forgetLocked(callID string) {
+	delete(cs.activeCalls, callID)
+	delete(cs.used, callID)
+	for username, id := range cs.userCalls {
+		if id == callID {
+			delete(cs.userCalls, username)
+		}
+	}
+}
+
+// inUseLocked reports whether a participant changed or acted on a call here
+// within ActiveCallTTL. cs.mu must be held.
+func (cs *CallService) inUseLocked(callID string) bool {
+	used, ok := cs.used[callID]
+	return ok && time.Since(used) < ActiveCallTTL
+}
+
+// cacheUserLocked stores the call a user is in, read from Redis. Users Redis
+// has no entry for keep a call the cache still has, which is then read or
+// written back. cs.mu must be held.
+func (cs *CallService) cacheUserLocked(username, callID string) {
+	if callID == "" {
+		if _, live := cs.activeCalls[cs.userCalls[username]]; !live {
+			delete(cs.userCalls, username)
+		}
+		return
+	}
+	cs.userCalls[username] = callID
+}
+
+// userCallIDsLocked returns the calls users are in. cs.mu must be held.
+func (cs *CallService) userCallIDsLocked(users []string) []string {
+	var callIDs []string
+	for _, username := range users {
+		if callID, ok := cs.userCalls[username]; ok {
+			callIDs = append(callIDs, callID)
+		}
+	}
+	return callIDs
+}
+
+// participantsLocked returns the participants of the cached calls among
+// callIDs. cs.mu must be held.
+func (cs *CallService) participantsLocked(callIDs []string) []string {
+	var users []string
+	for _, callID := range callIDs {
+		if call, ok := cs.activeCalls[callID]; ok {
+			users = append(users, call.Caller, call.Callee)
+		}
+	}
+	return users
+}
+
+// watchLocked watches and reads the given calls and users, then the users
+// in those calls and the calls those users are in, until it finds no more.
+// It returns the users watched. cs.mu must be held.
+func (cs *CallService) watchLocked(ctx context.Context, tx *redis.Tx, callIDs, users []string) ([]string, error) {
+	var watchedCalls, watchedUsers []string
+	for len(callIDs) > 0 || len(users) > 0 {
+		callIDs = unseen(callIDs, watchedCalls)
+		if err := watchRead(ctx, tx, cs, callKeys(callIDs)); err != nil {
+			return nil, err
+		}
+		watchedCalls = append(watchedCalls, callIDs...)
+
+		users = unseen(append(users, cs.participantsLocked(callIDs)...), watchedUsers)
+		if err := watchRead(ctx, tx, cs, userKeys(users)); err != nil {
+			return nil, err
+		}
+		watchedUsers = append(watchedUsers, users...)
+
+		callIDs, users = cs.userCallIDsLocked(users), nil
+	}
+	return watchedUsers, nil
+}
+
+// unseen returns the distinct values not in seen
+func unseen(values, seen []string) []string {
+	var out []string
+	for _, v := range values {
+		if !slices.Contains(seen, v) && !slices.Contains(out, v) {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// updateLocked reads the given calls and users, everyone in those calls and
+// the calls those users are in, then makes change to the cache and stores
+// the calls it returns together with the users' entries. change is called
+// again when another instance changes any of them first, so effects of the
+// change are queued with afterLocked. When Redis cannot be reached the
+// change is made to the cache alone. cs.mu must be held.
+func (cs *CallService) updateLocked(ctx context.Context, callIDs, users []string, change func() ([]*Call, error)) error {
+	ctx, cancel := context.WithTimeout(ctx, callTimeout)
+	defer cancel()
+
+	var changed []*Call
+	var refused error
+	applied := false
+
+	txf := func(tx *redis.Tx) error {
+		if applied {
+			cs.undoLocked(changed, callIDs)
+		}
+		cs.committed, changed, refused, applied = nil, nil, nil, false
+
+		watched, err := cs.watchLocked(ctx, tx, callIDs, users)
+		if err != nil {
+			return err
+		}
+
+		changed, refused = change()
+		applied = true
+		if refused != nil {
+			return nil
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			return cs.writeLocked(ctx, pipe, changed, watched)
+		})
+		return err
+	}
+
+	_, err := breaker.ExecuteCtx(ctx, cs.cb, func() (any, error) {
+		for range callTxRetries {
+			err := cs.rdb.Watch(ctx, txf)
+			if !errors.Is(err, redis.TxFailedErr) {
+				return nil, err
+			}
+		}
+		return nil, errCallConflict
+	})
+
+	switch {
+	case errors.Is(err, errCallConflict):
+		cs.undoLocked(changed, callIDs)
+		return err
+	case err != nil && !applied:
+		// Redis is unreachable: carry on with the cache
+		logger.WithError(err).Warn("Failed to read calls from Redis (continuing with the local cache)")
+		cs.committed = nil
+		changed, refused = change()
+		fallthrough
+	case err != nil:
+		// Changed in the cache but not stored
+		for _, call := range changed {
+			cs.dirty[call.ID] = call
+		}
+	}
+	if refused != nil {
+		cs.committed = nil
+		return refused
+	}
+
+	committed := cs.committed
+	cs.committed = nil
+	for _, fn := range committed {
+		fn()
+	}
+	return nil
+}
+
+// watchRead watches keys and reads them into the cache
+func watchRead(ctx context.Context, tx *redis.Tx, cs *CallService, keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	if err := tx.Watch(ctx, keys...).Err(); err != nil {
+		return err
+	}
+	return cs.readLocked(ctx, tx, keys)
+}
+
+// undoLocked drops the calls an abandoned change created; the calls and
+// users it changed are read again. cs.mu must be held.
+func (cs *CallService) undoLocked(changed []*Call, callIDs []string) {
+	for _, call := range changed {
+		if slices.Contains(callIDs, call.ID) {
+			continue
+		}
+		cs.forgetLocked(call.ID)
+	}
+	clear(cs.synced)
+}
+
+// writeLocked stores calls, which are in use here until ended, and the
+// calls users are in. cs.mu must be held.
+func (cs *CallService) writeLocked(ctx context.Context, pipe redis.Pipeliner, calls []*Call, users []string) error {
+	for _, call := range calls {
+		data, err := json.Marshal(call)
+		if err != nil {
+			return err
+		}
+		pipe.Set(ctx, callKey(call.ID), data, callTTL(call))
+		delete(cs.dirty, call.ID)
+		if call.State == CallStateEnded {
+			delete(cs.used, call.ID)
+		} else {
+			cs.used[call.ID] = time.Now()
+		}
+	}
+	for _, username := range users {
+		if callID, ok := cs.userCalls[username]; ok {
+			pipe.Set(ctx, callUserKey(username), callID, ActiveCallTTL)
+		} else {
+			pipe.Del(ctx, callUserKey(username))
+		}
+	}
+	return nil
+}
+
+// refreshCallsLocked reads the cached calls again, drops those other
+// instances ended or let expire, and keeps those in use here from expiring.
+// Calls changed while Redis could not be reached, or in use here and lost
+// by Redis, are written back. cs.mu must be held.
+func (cs *CallService) refreshCallsLocked(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, callTimeout)
+	defer cancel()
+
+	callIDs := make([]string, 0, len(cs.activeCalls))
+	for callID := range cs.activeCalls {
+		if _, dirty := cs.dirty[callID]; !dirty {
+			callIDs = append(callIDs, callID)
+		}
+	}
+
+	_, err := breaker.ExecuteCtx(ctx, cs.cb, func() (any, error) {
+		if err := cs.readLocked(ctx, cs.rdb, callKeys(callIDs)); err != nil {
+			return nil, err
+		}
+
+		dirty := make([]*Call, 0, len(cs.dirty))
+		for _, call := range cs.dirty {
+			dirty = append(dirty, call)
+		}
+
+		_, err := cs.rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			if err := cs.writeLocked(ctx, pipe, dirty, nil); err != nil {
+				return err
+			}
+			for callID, call := range cs.activeCalls {
+				if !cs.inUseLocked(callID) && !slices.Contains(dirty, call) {
+					// Kept alive by the instances it is in use on, if any
+					continue
+				}
+				pipe.Expire(ctx, callKey(callID), ActiveCallTTL)
+				for _, username := range []string{call.Caller, call.Callee} {
+					if cs.userCalls[username] != callID {
+						continue
+					}
+					if slices.Contains(dirty, call) {
+						pipe.Set(ctx, callUserKey(username), callID, ActiveCallTTL)
+					} else {
+						pipe.Expire(ctx, callUserKey(username), ActiveCallTTL)
+					}
+				}
+			}
+			return nil
+		})
+		return nil, err
+	})
+	if err != nil {
+		return fmt.Errorf("refresh calls: %w", err)
+	}
+	return nil
+}
+
+func callKeys(callIDs []string) []string {
+	keys := make([]string, len(callIDs))
+	for i, callID := range callIDs {
+		keys[i] = callKey(callID)
+	}
+	return keys
+}
+
+func userKeys(users []string) []string {
+	keys := make([]string, len(users))
+	for i, username := range users {
+		keys[i] = callUserKey(username)
+	}
+	return keys
+}
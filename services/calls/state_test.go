@@ -0,0 +1,163 @@
+package calls
+
+import (
+	"context"
+	"encoding/json"
+	"exc6/tests/fakeredis"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newInstances returns n call services sharing one Redis, as instances of
+// the server do
+func newInstances(t *testing.T, n int) (*fakeredis.Server, []*CallService) {
+	srv := fakeredis.New(t)
+	instances := make([]*CallService, n)
+	for i := range instances {
+		instances[i] = NewCallService(context.Background(), srv.Client(t))
+		t.Cleanup(instances[i].Close)
+	}
+	return srv, instances
+}
+
+// refresh runs the periodic refresh of cs now
+func refresh(t *testing.T, cs *CallService) {
+	t.Helper()
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	require.NoError(t, cs.refreshCallsLocked(context.Background()))
+}
+
+// storedCall returns the call Redis holds under callID, if any
+func storedCall(t *testing.T, srv *fakeredis.Server, callID string) *Call {
+	t.Helper()
+	value := srv.Get(callKey(callID))
+	if value == "" {
+		return nil
+	}
+	var call Call
+	require.NoError(t, json.Unmarshal([]byte(value), &call))
+	return &call
+}
+
+func TestInitiateCallConcurrently(t *testing.T) {
+	srv, instances := newInstances(t, 2)
+
+	type result struct {
+		caller string
+		call   *Call
+		err    error
+	}
+	results := make(chan result, 10)
+	for i := range cap(results) {
+		caller := fmt.Sprintf("caller%d", i)
+		go func() {
+			call, err := instances[i%2].InitiateCall(caller, "dave")
+			results <- result{caller, call, err}
+		}()
+	}
+
+	var placed []result
+	for range cap(results) {
+		if r := <-results; r.err == nil {
+			placed = append(placed, r)
+		}
+	}
+	require.Len(t, placed, 1, "dave is placed in one call")
+
+	call := placed[0].call
+	assert.Equal(t, call.ID, srv.Get(callUserKey("dave")))
+	assert.Equal(t, call.ID, srv.Get(callUserKey(placed[0].caller)))
+	for _, cs := range instances {
+		assert.True(t, cs.IsUserInCall(placed[0].caller), "every instance sees the call")
+	}
+}
+
+func TestCrashedInstanceCallsExpire(t *testing.T) {
+	srv, instances := newInstances(t, 3)
+	crashed, other, live := instances[0], instances[1], instances[2]
+
+	call, err := crashed.InitiateCall("alice", "bob")
+	require.NoError(t, err)
+	kept, err := live.InitiateCall("carol", "dave")
+	require.NoError(t, err)
+
+	// Another instance reads the call, as when bob's client looks it up
+	_, err = other.GetCall(call.ID)
+	require.NoError(t, err)
+	require.True(t, other.IsUserInCall("bob"))
+
+	crashed.Close()
+	for range 3 {
+		srv.FastForward(ActiveCallTTL / 2)
+		refresh(t, other)
+		refresh(t, live)
+	}
+
+	assert.Nil(t, storedCall(t, srv, call.ID), "nobody refreshes a call no one uses")
+	assert.False(t, srv.Exists(callUserKey("alice")))
+	assert.False(t, srv.Exists(callUserKey("bob")))
+	assert.NotNil(t, storedCall(t, srv, kept.ID), "the live instance keeps its call")
+
+	refresh(t, other)
+	assert.Nil(t, storedCall(t, srv, call.ID), "an expired call is not written back")
+	assert.False(t, other.IsUserInCall("bob"))
+
+	_, err = other.InitiateCall("erin", "bob")
+	assert.NoError(t, err, "bob is free again")
+}
+
+func TestCallInUseKeptAlive(t *testing.T) {
+	srv, instances := newInstances(t, 2)
+	a, b := instances[0], instances[1]
+
+	call, err := a.InitiateCall("alice", "bob")
+	require.NoError(t, err)
+	require.NoError(t, b.AnswerCall(call.ID, "bob"))
+
+	// Nothing happened on a since: only b, where bob answered, refreshes
+	a.mu.Lock()
+	a.used[call.ID] = time.Now().Add(-ActiveCallTTL)
+	a.mu.Unlock()
+
+	for range 3 {
+		srv.FastForward(ActiveCallTTL / 2)
+		refresh(t, a)
+		refresh(t, b)
+	}
+	stored := storedCall(t, srv, call.ID)
+	require.NotNil(t, stored)
+	assert.Equal(t, CallStateActive, stored.State)
+	assert.Equal(t, call.ID, srv.Get(callUserKey("alice")))
+}
+
+func TestDirtyCallsWrittenBack(t *testing.T) {
+	srv, instances := newInstances(t, 1)
+	cs := instances[0]
+
+	call, err := cs.InitiateCall("alice", "bob")
+	require.NoError(t, err)
+
+	// Answered while Redis is down, which then loses the call
+	srv.SetDown(true)
+	require.NoError(t, cs.AnswerCall(call.ID, "bob"), "the cache carries on")
+	srv.FastForward(ActiveCallTTL + time.Second)
+	srv.SetDown(false)
+	require.False(t, srv.Exists(callKey(call.ID)))
+
+	refresh(t, cs)
+	stored := storedCall(t, srv, call.ID)
+	require.NotNil(t, stored, "the call in use here is written back")
+	assert.Equal(t, CallStateActive, stored.State)
+	assert.Equal(t, call.ID, srv.Get(callUserKey("alice")))
+	assert.Equal(t, call.ID, srv.Get(callUserKey("bob")))
+	assert.Greater(t, srv.TTL(callUserKey("bob")), time.Duration(0))
+
+	cs.mu.Lock()
+	assert.Empty(t, cs.dirty)
+	cs.mu.Unlock()
+}
@@ -0,0 +1,211 @@
+package calls
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Attended transfer: A, in a call with B, puts B on hold and calls C (a
+// consultation call marked with TransferOf). Once C answers, A completes the
+// transfer: the held call and the consultation call end, and a new active
+// call joins B and C. A can instead cancel and resume the call with B.
+
+// Hold puts an active call on hold on behalf of username
+func (cs *CallService) Hold(callID, username string) (*Call, error) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	var call *Call
+	err := cs.updateLocked(cs.ctx, []string{callID}, nil, func() ([]*Call, error) {
+		var err error
+		if call, err = cs.participantCallLocked(callID, username); err != nil {
+			return nil, err
+		}
+		if !CanTransition(call.State, CallStateHeld) {
+			return nil, fmt.Errorf("call cannot be held while %s", call.State)
+		}
+
+		call.State = CallStateHeld
+		call.HeldBy = username
+		return []*Call{call}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return call, nil
+}
+
+// Resume takes a call off hold. Only the participant who held it may.
+func (cs *CallService) Resume(callID, username string) (*Call, error) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	var call *Call
+	err := cs.updateLocked(cs.ctx, []string{callID}, nil, func() ([]*Call, error) {
+		var err error
+		if call, err = cs.participantCallLocked(callID, username); err != nil {
+			return nil, err
+		}
+		if call.State != CallStateHeld {
+			return nil, fmt.Errorf("call is not on hold")
+		}
+		if call.HeldBy != username {
+			return nil, fmt.Errorf("call was put on hold by %s", call.HeldBy)
+		}
+
+		call.State = CallStateActive
+		call.HeldBy = ""
+		return []*Call{call}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return call, nil
+}
+
+// StartTransfer places a consultation call from transferor to target for
+// the call the transferor is holding
+func (cs *CallService) StartTransfer(callID, transferor, target string) (*Call, error) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	var consult *Call
+	err := cs.updateLocked(cs.ctx, []string{callID}, []string{target}, func() ([]*Call, error) {
+		held, err := cs.participantCallLocked(callID, transferor)
+		if err != nil {
+			return nil, err
+		}
+		if held.State != CallStateHeld || held.HeldBy != transferor {
+			return nil, fmt.Errorf("put the call on hold before transferring it")
+		}
+		if target == held.Caller || target == held.Callee {
+			return nil, fmt.Errorf("cannot transfer a call to one of its participants")
+		}
+		if _, inCall := cs.userCalls[target]; inCall {
+			return nil, fmt.Errorf("%s is already in a call", target)
+		}
+		for _, call := range cs.activeCalls {
+			if call.TransferOf == callID {
+				return nil, fmt.Errorf("call is already being transferred")
+			}
+		}
+
+		consult = &Call{
+			ID:          uuid.NewString(),
+			Caller:      transferor,
+			Callee:      target,
+			State:       CallStateRinging,
+			StartedAt:   time.Now().Unix(),
+			TransferOf:  callID,
+			Media:       MediaAudio,
+			Constraints: Negotiate(MediaAudio),
+		}
+
+		// The transferor stays tracked on the held call
+		cs.activeCalls[consult.ID] = consult
+		cs.userCalls[target] = consult.ID
+		return []*Call{consult}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return consult, nil
+}
+
+// CompleteTransfer connects the other participant of the held call with the
+// target of the answered consultation call. The transferor leaves both
+// calls; the returned call is the new one.
+func (cs *CallService) CompleteTransfer(consultID, transferor string) (*Call, error) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	var transferred *Call
+	err := cs.updateLocked(cs.ctx, []string{consultID}, nil, func() ([]*Call, error) {
+		consult, exists := cs.activeCalls[consultID]
+		if !exists || consult.TransferOf == "" {
+			return nil, fmt.Errorf("transfer not found: %s", consultID)
+		}
+		if consult.Caller != transferor {
+			return nil, fmt.Errorf("user %s is not transferring this call", transferor)
+		}
+		if consult.State != CallStateActive {
+			return nil, fmt.Errorf("%s has not answered yet", consult.Callee)
+		}
+
+		// The transferor is in the held call, so it was read too
+		held, exists := cs.activeCalls[consult.TransferOf]
+		if !exists || held.State != CallStateHeld {
+			return nil, fmt.Errorf("the call being transferred has ended")
+		}
+
+		now := time.Now().Unix()
+		transferred = &Call{
+			ID:              uuid.NewString(),
+			Caller:          otherParty(held, transferor),
+			Callee:          consult.Callee,
+			State:           CallStateActive,
+			StartedAt:       now,
+			AnsweredAt:      now,
+			TransferredFrom: held.ID,
+			TransferredBy:   transferor,
+			Media:           MediaAudio,
+			Constraints:     Negotiate(MediaAudio),
+		}
+
+		held.TransferredTo = transferred.ID
+		held.TransferredBy = transferor
+		cs.endCallLocked(held, transferor)
+		cs.endCallLocked(consult, transferor)
+
+		cs.activeCalls[transferred.ID] = transferred
+		cs.userCalls[transferred.Caller] = transferred.ID
+		cs.userCalls[transferred.Callee] = transferred.ID
+		return []*Call{held, consult, transferred}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return transferred, nil
+}
+
+// CancelTransfer hangs up the consultation call; the held call stays on
+// hold for the transferor to resume
+func (cs *CallService) CancelTransfer(consultID, transferor string) (*Call, error) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	var consult *Call
+	err := cs.updateLocked(cs.ctx, []string{consultID}, nil, func() ([]*Call, error) {
+		var exists bool
+		consult, exists = cs.activeCalls[consultID]
+		if !exists || consult.TransferOf == "" {
+			return nil, fmt.Errorf("transfer not found: %s", consultID)
+		}
+		if consult.Caller != transferor {
+			return nil, fmt.Errorf("user %s is not transferring this call", transferor)
+		}
+
+		cs.endCallLocked(consult, transferor)
+		return []*Call{consult}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return consult, nil
+}
+
+// participantCallLocked returns a live call of username, which is then in
+// use here. cs.mu must be held.
+func (cs *CallService) participantCallLocked(callID, username string) (*Call, error) {
+	call, exists := cs.activeCalls[callID]
+	if !exists {
+		return nil, fmt.Errorf("call not found: %s", callID)
+	}
+	if call.Caller != username && call.Callee != username {
+		return nil, fmt.Errorf("user %s is not part of this call", username)
+	}
+	cs.used[callID] = time.Now()
+	return call, nil
+}
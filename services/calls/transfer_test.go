@@ -0,0 +1,125 @@
+package calls
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCanTransition(t *testing.T) {
+	tests := []struct {
+		name     string
+		from, to CallState
+		want     bool
+	}{
+		{name: "Answer ringing call", from: CallStateRinging, to: CallStateActive, want: true},
+		{name: "Hold active call", from: CallStateActive, to: CallStateHeld, want: true},
+		{name: "Resume held call", from: CallStateHeld, to: CallStateActive, want: true},
+		{name: "Hold ringing call", from: CallStateRinging, to: CallStateHeld, want: false},
+		{name: "End held call", from: CallStateHeld, to: CallStateEnded, want: true},
+		{name: "End ended call", from: CallStateEnded, to: CallStateEnded, want: false},
+		{name: "Revive ended call", from: CallStateEnded, to: CallStateActive, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, CanTransition(tt.from, tt.to))
+		})
+	}
+}
+
+func TestHoldResume(t *testing.T) {
+	cs, _ := newRecordingService(t)
+
+	call, err := cs.InitiateCall("alice", "bob")
+	require.NoError(t, err)
+
+	_, err = cs.Hold(call.ID, "alice")
+	require.Error(t, err, "ringing calls cannot be held")
+
+	require.NoError(t, cs.AnswerCall(call.ID, "bob"))
+	answeredAt := call.AnsweredAt
+
+	call, err = cs.Hold(call.ID, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, CallStateHeld, call.State)
+	assert.Equal(t, "alice", call.HeldBy)
+
+	_, err = cs.Resume(call.ID, "bob")
+	require.Error(t, err, "only the participant who held may resume")
+
+	call, err = cs.Resume(call.ID, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, CallStateActive, call.State)
+	assert.Empty(t, call.HeldBy)
+	assert.Equal(t, answeredAt, call.AnsweredAt)
+}
+
+func TestAttendedTransfer(t *testing.T) {
+	cs, _ := newRecordingService(t)
+
+	call, err := cs.InitiateCall("alice", "bob")
+	require.NoError(t, err)
+	require.NoError(t, cs.AnswerCall(call.ID, "bob"))
+
+	_, err = cs.StartTransfer(call.ID, "alice", "carol")
+	require.Error(t, err, "the call must be held first")
+
+	_, err = cs.Hold(call.ID, "alice")
+	require.NoError(t, err)
+
+	consult, err := cs.StartTransfer(call.ID, "alice", "carol")
+	require.NoError(t, err)
+	assert.Equal(t, call.ID, consult.TransferOf)
+
+	_, err = cs.CompleteTransfer(consult.ID, "alice")
+	require.Error(t, err, "carol has not answered yet")
+
+	require.NoError(t, cs.AnswerCall(consult.ID, "carol"))
+
+	transferred, err := cs.CompleteTransfer(consult.ID, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, "bob", transferred.Caller)
+	assert.Equal(t, "carol", transferred.Callee)
+	assert.Equal(t, CallStateActive, transferred.State)
+	assert.Equal(t, call.ID, transferred.TransferredFrom)
+	assert.Equal(t, "alice", transferred.TransferredBy)
+
+	assert.Equal(t, CallStateEnded, call.State)
+	assert.Equal(t, transferred.ID, call.TransferredTo)
+	assert.Equal(t, CallStateEnded, consult.State)
+
+	assert.False(t, cs.IsUserInCall("alice"))
+	current, err := cs.GetUserActiveCall("bob")
+	require.NoError(t, err)
+	assert.Equal(t, transferred.ID, current.ID)
+}
+
+func TestCancelTransfer(t *testing.T) {
+	cs, _ := newRecordingService(t)
+
+	call, err := cs.InitiateCall("alice", "bob")
+	require.NoError(t, err)
+	require.NoError(t, cs.AnswerCall(call.ID, "bob"))
+	_, err = cs.Hold(call.ID, "alice")
+	require.NoError(t, err)
+
+	consult, err := cs.StartTransfer(call.ID, "alice", "carol")
+	require.NoError(t, err)
+
+	_, err = cs.CancelTransfer(consult.ID, "bob")
+	require.Error(t, err, "only the transferring user may cancel")
+
+	_, err = cs.CancelTransfer(consult.ID, "alice")
+	require.NoError(t, err)
+
+	current, err := cs.GetUserActiveCall("alice")
+	require.NoError(t, err, "alice stays on the held call")
+	assert.Equal(t, call.ID, current.ID)
+	assert.False(t, cs.IsUserInCall("carol"))
+
+	call, err = cs.Resume(call.ID, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, CallStateActive, call.State)
+}
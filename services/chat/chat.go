@@ -5,10 +5,18 @@ import (
 	"database/sql"
 	"encoding/json"
 	"exc6/apperrors"
+	"exc6/config"
 	"exc6/db"
 	"exc6/pkg/breaker"
+	"exc6/pkg/keyspace"
 	"exc6/pkg/logger"
+	"exc6/pkg/pagination"
+	"exc6/services/activity"
+	"exc6/services/events"
+	"exc6/services/moderation"
+	"exc6/services/notifications"
 	"fmt"
+	"slices"
 	"sort"
 	"sync"
 	"sync/atomic"
@@ -27,18 +35,64 @@ const (
 	BatchFlushSize          = 100
 	BatchFlushInterval      = 100 * time.Millisecond
 
+	// UnreadTTL bounds how long the unread conversations and receipts of an
+	// inactive user are kept; every message and receipt extends it
+	UnreadTTL = 30 * 24 * time.Hour
+
 	// Persistent queue configuration
 	PersistentQueueKey = "chat:pending_messages"
 	ProcessingQueueKey = "chat:processing_messages"
 	MaxRetries         = 3
 	RetryBackoff       = 5 * time.Second
+
+	// Pub/Sub channels for live delivery. Direct messages are published to the
+	// channel of each participant, group messages to the channel of the group,
+	// so a connection only receives payloads it is allowed to see.
+	UserChannelPrefix  = "chat:user:"
+	GroupChannelPrefix = "chat:group:"
 )
 
+func init() {
+	keyspace.Register(
+		keyspace.Family{Prefix: "chat:conv:", Description: "recent direct messages per conversation"},
+		keyspace.Family{Prefix: "chat:group:", Description: "recent messages per group"},
+		keyspace.Family{Prefix: "chat:unread:", Description: "conversations with unread messages per user"},
+		keyspace.Family{Prefix: receiptsPrefix, Description: "delivered and read positions per user"},
+		keyspace.Family{Prefix: typingPrefix, Description: "recent typing events per typist and conversation"},
+		keyspace.Family{Prefix: reactionsPrefix, Description: "reactions per message"},
+		keyspace.Family{
+			Prefix:      ReactionsDirtyKey,
+			Description: "messages whose reactions changed since the last flush",
+			Exempt:      "set drained by the reaction flusher",
+		},
+		keyspace.Family{
+			Prefix:      PersistentQueueKey,
+			Description: "messages waiting to be persisted",
+			Exempt:      "queue drained by the persistence worker",
+		},
+		keyspace.Family{
+			Prefix:      ProcessingQueueKey,
+			Description: "messages being persisted",
+			Exempt:      "queue drained by the persistence worker",
+		},
+	)
+}
+
+// UserChannel returns the Pub/Sub channel carrying direct messages for a user
+func UserChannel(username string) string {
+	return UserChannelPrefix + username
+}
+
+// GroupChannel returns the Pub/Sub channel carrying messages for a group
+func GroupChannel(groupID string) string {
+	return GroupChannelPrefix + groupID
+}
+
 type ChatService struct {
 	rdb           *redis.Client
 	qdb           *db.Queries
 	producer      *kafka.Producer
-	kafkaTopic    string
+	topics        Topics
 	messageBuffer chan *ChatMessage
 	shutdownOnce  sync.Once
 	shutdownChan  chan struct{}
@@ -50,6 +104,28 @@ type ChatService struct {
 	cbRedis *gobreaker.CircuitBreaker
 	cbKafka *gobreaker.CircuitBreaker
 
+	// limits bound message content
+	limits Limits
+
+	// activity records conversation changes for contact list deltas; may be nil
+	activity *activity.Tracker
+
+	// events records each conversation's event feed; may be nil
+	events *events.Log
+
+	// policy restricts reported accounts and mutes reported senders; may be nil
+	policy *moderation.Policy
+
+	// mutes are the conversations users muted; may be nil
+	mutes *notifications.Service
+
+	// inbox is told about mentions in group messages; may be nil
+	inbox *notifications.NotificationService
+
+	// hooks observe every message accepted by this instance
+	hooksMu sync.RWMutex
+	hooks   []MessageHook
+
 	// Metrics for monitoring
 	metrics struct {
 		messagesQueued  atomic.Int64
@@ -59,9 +135,9 @@ type ChatService struct {
 	}
 }
 
-func NewChatService(ctx context.Context, rdb *redis.Client, qdb *db.Queries, kafkaAddr string) (*ChatService, error) {
+func NewChatService(ctx context.Context, rdb *redis.Client, qdb *db.Queries, kafkaCfg config.KafkaConfig) (*ChatService, error) {
 	p, err := kafka.NewProducer(&kafka.ConfigMap{
-		"bootstrap.servers": kafkaAddr,
+		"bootstrap.servers": kafkaCfg.Address,
 		"client.id":         "go-fiber-dashboard",
 		"acks":              "all",
 		"retries":           3,
@@ -77,11 +153,12 @@ func NewChatService(ctx context.Context, rdb *redis.Client, qdb *db.Queries, kaf
 		rdb:           rdb,
 		qdb:           qdb,
 		producer:      p,
-		kafkaTopic:    "chat-history",
+		topics:        TopicsFrom(kafkaCfg),
 		messageBuffer: make(chan *ChatMessage, MessageBufferSize),
 		shutdownChan:  make(chan struct{}),
 		ctx:           bgCtx,
 		cancel:        cancel,
+		limits:        Limits{MaxLength: DefaultMaxLength, RequestLimit: DefaultRequestLimit},
 
 		// Configure Redis circuit breaker - aggressive settings for cache
 		cbRedis: breaker.New(breaker.Config{
@@ -104,13 +181,18 @@ func NewChatService(ctx context.Context, rdb *redis.Client, qdb *db.Queries, kaf
 		}),
 	}
 
+	loadCtx, loadCancel := context.WithTimeout(ctx, 2*time.Second)
+	cs.loadScripts(loadCtx)
+	loadCancel()
+
 	// Recover any messages left in processing state from previous crash
 	go cs.recoverProcessingMessages()
 
 	// Start background workers
-	cs.wg.Add(2)
+	cs.wg.Add(3)
 	go cs.messageWriter()
 	go cs.persistentQueueWorker()
+	go cs.reactionFlusher()
 
 	logger.Info("Chat service initialized with circuit breakers")
 
@@ -118,7 +200,11 @@ func NewChatService(ctx context.Context, rdb *redis.Client, qdb *db.Queries, kaf
 }
 
 // SendMessage with comprehensive circuit breaker protection
-func (cs *ChatService) SendMessage(ctx context.Context, from, to, content string) (*ChatMessage, error) {
+func (cs *ChatService) SendMessage(ctx context.Context, from, to, content string, opts ...SendOption) (*ChatMessage, error) {
+	if err := cs.checkLength(content); err != nil {
+		return nil, err
+	}
+
 	msg := &ChatMessage{
 		MessageID: uuid.NewString(),
 		FromID:    from,
@@ -126,6 +212,19 @@ func (cs *ChatService) SendMessage(ctx context.Context, from, to, content string
 		Content:   content,
 		Timestamp: time.Now().Unix(),
 	}
+	for _, opt := range opts {
+		opt(msg)
+	}
+	msg.Seal()
+
+	if msg.Subtype != SubtypeText {
+		if err := cs.policy.Check(ctx, from, moderation.ActionSendAttachment); err != nil {
+			return nil, err
+		}
+	}
+	if err := cs.policy.CheckMessage(ctx, from, to); err != nil {
+		return nil, err
+	}
 
 	// 0. Persist to PostgreSQL (Primary Source of Truth)
 	if err := cs.persistMessageToDB(ctx, msg); err != nil {
@@ -138,9 +237,37 @@ func (cs *ChatService) SendMessage(ctx context.Context, from, to, content string
 		// but this is a critical failure for persistence.
 	}
 
-	// 1. Cache message in Redis with circuit breaker
+	if err := cs.deliver(ctx, msg); err != nil {
+		return nil, err
+	}
+
+	return msg, nil
+}
+
+// DeliverPersisted caches, queues and publishes a direct message the caller
+// already wrote to Postgres, e.g. in the same transaction as the change it
+// announces
+func (cs *ChatService) DeliverPersisted(ctx context.Context, msg *ChatMessage) error {
+	return cs.deliver(ctx, msg)
+}
+
+// deliver caches a persisted direct message, counts it as unread, queues it
+// for Kafka and publishes it to both participants
+func (cs *ChatService) deliver(ctx context.Context, msg *ChatMessage) error {
+	from, to := msg.FromID, msg.ToID
+	cs.stampSeq(ctx, msg)
+
+	msgJSON, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	// 1. Cache message, count it as unread and record the conversation's
+	// activity in one script; a recipient who reported the sender or muted
+	// the conversation is not notified
+	countUnread := !cs.policy.Muted(ctx, to, from) && !cs.mutes.Muted(ctx, to, from, "")
 	if _, err := breaker.ExecuteCtx(ctx, cs.cbRedis, func() (any, error) {
-		return nil, cs.cacheMessage(ctx, msg)
+		return nil, cs.recordDirect(ctx, msg, msgJSON, countUnread)
 	}); err != nil {
 		// Create rich error with full context
 		cacheErr := apperrors.NewCacheError(
@@ -158,21 +285,7 @@ func (cs *ChatService) SendMessage(ctx context.Context, from, to, content string
 		// Continue - caching failure is not fatal
 	}
 
-	// 2. Increment unread count
-	if _, err := breaker.ExecuteCtx(ctx, cs.cbRedis, func() (any, error) {
-		return nil, cs.IncrementUnreadCount(ctx, to, from)
-	}); err != nil {
-		unreadErr := apperrors.NewCacheError(
-			"unread_counter_increment",
-			fmt.Sprintf("chat:unread:%s", to),
-			err,
-		).WithDetails("recipient", to).
-			WithDetails("sender", from)
-
-		logger.WithFields(unreadErr.LogFields()).Warn("Failed to increment unread count")
-	}
-
-	// 3. Buffer message for Kafka
+	// 2. Buffer message for Kafka
 	select {
 	case cs.messageBuffer <- msg:
 		cs.incrementMetric("queued")
@@ -201,19 +314,24 @@ func (cs *ChatService) SendMessage(ctx context.Context, from, to, content string
 			logger.WithFields(deliveryErr.LogFields()).Error("Message delivery failed")
 			cs.incrementMetric("failed")
 
-			return nil, deliveryErr
+			return deliveryErr
 		}
 		cs.incrementMetric("queued")
 	}
 
-	// 4. Publish to Redis Pub/Sub (best effort)
-	msgJSON, _ := json.Marshal(msg)
+	// 3. Publish to both participants' channels (best effort)
 	if _, err := breaker.ExecuteCtx(ctx, cs.cbRedis, func() (any, error) {
-		return nil, cs.rdb.Publish(ctx, "chat:messages", msgJSON).Err()
+		pipe := cs.rdb.Pipeline()
+		pipe.Publish(ctx, UserChannel(to), msgJSON)
+		if from != to {
+			pipe.Publish(ctx, UserChannel(from), msgJSON)
+		}
+		_, err := pipe.Exec(ctx)
+		return nil, err
 	}); err != nil {
 		pubsubErr := apperrors.NewCacheError(
 			"pubsub_publish",
-			"chat:messages",
+			UserChannel(to),
 			err,
 		).WithDetails("message_id", msg.MessageID).
 			WithDetails("from", from).
@@ -222,7 +340,92 @@ func (cs *ChatService) SendMessage(ctx context.Context, from, to, content string
 		logger.WithFields(pubsubErr.LogFields()).Warn("Failed to publish to Redis Pub/Sub")
 	}
 
-	return msg, nil
+	cs.logEvent(ctx, events.TypeMessage, msg)
+
+	// Bots and other observers only see what users wrote
+	if msg.Subtype != SubtypeSystem {
+		cs.runHooks(msg)
+	}
+
+	return nil
+}
+
+// SetActivityTracker records conversation changes for contact list deltas
+func (cs *ChatService) SetActivityTracker(tracker *activity.Tracker) {
+	cs.activity = tracker
+}
+
+// SetMutes stops muted conversations from counting as unread
+func (cs *ChatService) SetMutes(mutes *notifications.Service) {
+	cs.mutes = mutes
+}
+
+// SetNotifications notifies group members mentioned with @username
+func (cs *ChatService) SetNotifications(inbox *notifications.NotificationService) {
+	cs.inbox = inbox
+}
+
+// SetEventLog records what happens in conversations for clients keeping a
+// local copy
+func (cs *ChatService) SetEventLog(log *events.Log) {
+	cs.events = log
+}
+
+// SetPolicy enforces moderation restrictions on senders and mutes reported senders
+func (cs *ChatService) SetPolicy(policy *moderation.Policy) {
+	cs.policy = policy
+}
+
+// MessageHook observes a message after it has been accepted. Hooks run on the
+// sending goroutine and must not block; hand work off to a worker instead.
+type MessageHook func(msg *ChatMessage)
+
+// AddMessageHook registers a hook for messages sent through this instance.
+// Each message is observed exactly once across the cluster, by the instance
+// that accepted it.
+func (cs *ChatService) AddMessageHook(hook MessageHook) {
+	cs.hooksMu.Lock()
+	defer cs.hooksMu.Unlock()
+	cs.hooks = append(cs.hooks, hook)
+}
+
+func (cs *ChatService) runHooks(msg *ChatMessage) {
+	cs.hooksMu.RLock()
+	defer cs.hooksMu.RUnlock()
+
+	for _, hook := range cs.hooks {
+		hook(msg)
+	}
+}
+
+// ConversationEvents returns up to limit events after the sequence number
+// since in username's conversation with contact, or in groupID when set.
+// Callers check that username belongs to the group.
+func (cs *ChatService) ConversationEvents(ctx context.Context, username, contact, groupID string, since int64, limit int) (*events.Feed, error) {
+	conversation := events.Group(groupID)
+	if groupID == "" {
+		conversation = events.Direct(username, contact)
+	}
+
+	feed, err := cs.events.Since(ctx, conversation, since, limit)
+	if err != nil {
+		return nil, apperrors.NewCacheError("events_read", conversation, err)
+	}
+	return feed, nil
+}
+
+// logEvent records msg in the event feed of its conversation
+func (cs *ChatService) logEvent(ctx context.Context, eventType string, msg *ChatMessage) {
+	appendEvent(ctx, cs.events, eventType, msg)
+}
+
+// appendEvent records msg in log, which may be nil, under its conversation
+func appendEvent(ctx context.Context, log *events.Log, eventType string, msg *ChatMessage) {
+	conversation := events.Group(msg.GroupID)
+	if msg.GroupID == "" {
+		conversation = events.Direct(msg.FromID, msg.ToID)
+	}
+	log.Append(ctx, conversation, eventType, msg.FromID, msg.MessageID, msg)
 }
 
 // persistMessageToQueue with circuit breaker
@@ -311,7 +514,7 @@ func (cs *ChatService) sendToKafkaWithRetry(msg *ChatMessage, maxRetries int) er
 	}
 
 	chatKey := getChatKey(msg.FromID, msg.ToID)
-	topic := cs.kafkaTopic
+	topic := cs.topics.For(msg)
 
 	kafkaMsg := &kafka.Message{
 		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: kafka.PartitionAny},
@@ -437,8 +640,10 @@ func (cs *ChatService) flushBatch(batch []*ChatMessage) {
 	}).Debug("Batch processed")
 }
 
-// GetHistory with circuit breaker and DB fallback
-func (cs *ChatService) GetHistory(ctx context.Context, user1, user2 string) ([]*ChatMessage, error) {
+// GetHistory with circuit breaker and DB fallback. The result is degraded
+// when the database couldn't be read after a Redis miss or failure, so an
+// empty history there doesn't mean the conversation is empty.
+func (cs *ChatService) GetHistory(ctx context.Context, user1, user2 string) (breaker.Result[[]*ChatMessage], error) {
 	conversationKey := cs.GetConversationKey(user1, user2)
 
 	// Try Redis first
@@ -447,14 +652,18 @@ func (cs *ChatService) GetHistory(ctx context.Context, user1, user2 string) ([]*
 	})
 
 	var messages []*ChatMessage
+	degraded := false
 
 	if err == nil {
-		results := result.([]string)
+		results, _ := result.([]string)
 		for _, res := range results {
 			var msg ChatMessage
 			if err := json.Unmarshal([]byte(res), &msg); err != nil {
 				continue
 			}
+			if !verify("cache", &msg) {
+				continue
+			}
 			messages = append(messages, &msg)
 		}
 	}
@@ -483,109 +692,192 @@ func (cs *ChatService) GetHistory(ctx context.Context, user1, user2 string) ([]*
 					FromID:    dbMsg.FromUsername,
 					ToID:      dbMsg.ToUsername,
 					Content:   dbMsg.Content,
+					Subtype:   dbMsg.Subtype,
 					Timestamp: dbMsg.CreatedAt.Unix(),
+					Deleted:   dbMsg.DeletedAt.Valid,
+				}
+				if dbMsg.EditedAt.Valid {
+					msg.EditedAt = dbMsg.EditedAt.Time.Unix()
 				}
+				msg.Seal()
 				messages = append(messages, msg)
 
 				// Optional: Populate cache (async)
-				go func(m *ChatMessage) {
+				// A copy, as receipts are filled in below
+				go func(m ChatMessage) {
 					// Use background context to not cancel on HTTP timeout
-					cs.cacheMessage(context.Background(), m)
-				}(msg)
+					cs.cacheMessage(context.Background(), &m)
+				}(*msg)
 			}
 		} else {
 			logger.WithError(err).Error("Failed to fetch messages from DB")
+			degraded = true
 		}
 	}
 
-	return messages, nil
+	cs.applyReceipts(ctx, user1, user2, messages)
+
+	breaker.ObserveRead("chat_history", len(messages), degraded)
+	if degraded {
+		return breaker.Degraded(messages), nil
+	}
+	return breaker.Healthy(messages), nil
 }
 
-// GetUnreadMessages with circuit breaker
-func (cs *ChatService) GetUnreadMessages(ctx context.Context, username string) (map[string]int, error) {
-	key := fmt.Sprintf("chat:unread:%s", username)
+// GetHistoryPage returns a page of the conversation from the database, newest
+// first. The cursor is the creation time and ID of the last message of the
+// previous page, so messages sent while paging never shift the results.
+func (cs *ChatService) GetHistoryPage(ctx context.Context, user1, user2 string, page pagination.Params) (pagination.Page[*ChatMessage], error) {
+	limit := pagination.ClampLimit(page.Limit)
+
+	// The first page starts past any message, allowing for clock skew
+	before := time.Now().Add(time.Hour)
+	beforeID := ""
+	if page.After != nil {
+		nanos, err := pagination.ParseIntKey(page.After.Key)
+		if err != nil {
+			return pagination.Page[*ChatMessage]{}, apperrors.NewValidationError("Invalid pagination cursor")
+		}
+		before = time.Unix(0, nanos)
+		beforeID = page.After.ID
+	}
 
-	result, err := breaker.ExecuteCtx(ctx, cs.cbRedis, func() (any, error) {
-		return cs.rdb.HGetAll(ctx, key).Result()
+	rows, err := cs.qdb.GetMessagesBetweenUsersBefore(ctx, db.GetMessagesBetweenUsersBeforeParams{
+		User1:           user1,
+		User2:           user2,
+		BeforeCreatedAt: before,
+		BeforeMessageID: beforeID,
+		RowLimit:        int32(limit + 1),
 	})
-
 	if err != nil {
-		logger.WithFields(map[string]interface{}{
-			"username": username,
-			"error":    err.Error(),
-		}).Error("Circuit breaker: Failed to get unread messages")
-		return make(map[string]int), nil
+		logger.WithFields(map[string]any{
+			"user1": user1,
+			"user2": user2,
+			"error": err.Error(),
+		}).Error("Failed to fetch history page from DB")
+		return pagination.Page[*ChatMessage]{}, apperrors.NewDatabaseError("get message history", err)
 	}
 
-	resultMap := result.(map[string]string)
-	unread := make(map[string]int)
-	for sender, countStr := range resultMap {
-		var count int
-		fmt.Sscanf(countStr, "%d", &count)
-		if count > 0 {
-			unread[sender] = count
+	rowPage := pagination.New(rows, page, func(row db.GetMessagesBetweenUsersBeforeRow) pagination.Cursor {
+		return pagination.Cursor{Key: pagination.TimeKey(row.CreatedAt), ID: row.MessageID}
+	})
+
+	messages := make([]*ChatMessage, 0, len(rowPage.Items))
+	for _, row := range rowPage.Items {
+		msg := &ChatMessage{
+			MessageID: row.MessageID,
+			FromID:    row.FromUsername,
+			ToID:      row.ToUsername,
+			Content:   row.Content,
+			Subtype:   row.Subtype,
+			Timestamp: row.CreatedAt.Unix(),
+			Deleted:   row.DeletedAt.Valid,
+		}
+		if row.EditedAt.Valid {
+			msg.EditedAt = row.EditedAt.Time.Unix()
 		}
+		messages = append(messages, msg)
 	}
-	return unread, nil
-}
+	cs.applyReceipts(ctx, user1, user2, messages)
 
-// IncrementUnreadCount with circuit breaker (already wrapped by caller)
-func (cs *ChatService) IncrementUnreadCount(ctx context.Context, recipient, sender string) error {
-	key := fmt.Sprintf("chat:unread:%s", recipient)
-	return cs.rdb.HIncrBy(ctx, key, sender, 1).Err()
+	return pagination.Page[*ChatMessage]{
+		Items:      messages,
+		NextCursor: rowPage.NextCursor,
+		HasMore:    rowPage.HasMore,
+	}, nil
 }
 
-// MarkConversationRead with circuit breaker
-func (cs *ChatService) MarkConversationRead(ctx context.Context, recipient, sender string) error {
-	key := fmt.Sprintf("chat:unread:%s", recipient)
-
-	_, err := breaker.ExecuteCtx(ctx, cs.cbRedis, func() (any, error) {
-		return nil, cs.rdb.HDel(ctx, key, sender).Err()
+// GetUnreadMessages returns the number of unread messages per contact that
+// sent username any since they last read the conversation
+func (cs *ChatService) GetUnreadMessages(ctx context.Context, username string) (map[string]int, error) {
+	result, err := breaker.ExecuteCtx(ctx, cs.cbRedis, func() (any, error) {
+		return cs.rdb.HKeys(ctx, unreadKey(username)).Result()
 	})
+	if err != nil {
+		logger.WithFields(map[string]interface{}{
+			"username": username,
+			"error":    err.Error(),
+		}).Error("Circuit breaker: Failed to get unread messages")
+		return make(map[string]int), nil
+	}
 
+	// Conversations muted since they became unread no longer count
+	peers, _ := result.([]string)
+	peers = slices.DeleteFunc(peers, func(peer string) bool {
+		return cs.mutes.Muted(ctx, username, peer, "")
+	})
+	unread, err := cs.unreadCounts(ctx, username, peers, nil)
 	if err != nil {
 		logger.WithFields(map[string]interface{}{
-			"recipient": recipient,
-			"sender":    sender,
-			"error":     err.Error(),
-		}).Warn("Circuit breaker: Failed to mark conversation read")
+			"username": username,
+			"error":    err.Error(),
+		}).Error("Circuit breaker: Failed to count unread messages")
+		return make(map[string]int), nil
 	}
+	return unread, nil
+}
 
-	return err
+// MarkConversationRead records that recipient read everything sender sent
+// them so far
+func (cs *ChatService) MarkConversationRead(ctx context.Context, recipient, sender string) error {
+	return cs.MarkRead(ctx, recipient, sender, "", "", 0)
 }
 
-// MarkAllRead with circuit breaker
+// MarkAllRead records that username read every conversation with unread
+// direct messages
 func (cs *ChatService) MarkAllRead(ctx context.Context, username string) error {
-	key := fmt.Sprintf("chat:unread:%s", username)
-
-	_, err := breaker.ExecuteCtx(ctx, cs.cbRedis, func() (any, error) {
-		return nil, cs.rdb.Del(ctx, key).Err()
+	result, err := breaker.ExecuteCtx(ctx, cs.cbRedis, func() (any, error) {
+		return cs.rdb.HKeys(ctx, unreadKey(username)).Result()
 	})
-
 	if err != nil {
 		logger.WithFields(map[string]interface{}{
 			"username": username,
 			"error":    err.Error(),
 		}).Warn("Circuit breaker: Failed to mark all read")
+		return err
 	}
 
-	return err
+	peers, _ := result.([]string)
+	for _, peer := range peers {
+		if err := cs.MarkRead(ctx, username, peer, "", "", 0); err != nil {
+			return err
+		}
+	}
+	cs.activity.TouchList(ctx, username)
+	return nil
 }
 
-// SubscribeToMessages with circuit breaker
-func (cs *ChatService) SubscribeToMessages(ctx context.Context) *redis.PubSub {
+// SubscribeToConversations subscribes to the user's own channel and to the channels
+// of the given groups. More groups can be added later with pubsub.Subscribe.
+func (cs *ChatService) SubscribeToConversations(ctx context.Context, username string, groupIDs []string) *redis.PubSub {
+	channels := conversationChannels(username, groupIDs)
+
 	result, err := breaker.ExecuteCtx(ctx, cs.cbRedis, func() (any, error) {
-		return cs.rdb.Subscribe(ctx, "chat:messages"), nil
+		return cs.rdb.Subscribe(ctx, channels...), nil
 	})
 
 	if err != nil {
-		logger.WithField("error", err).Error("Circuit breaker: Redis unavailable for subscription")
+		logger.WithFields(map[string]any{
+			"username": username,
+			"error":    err.Error(),
+		}).Error("Circuit breaker: Redis unavailable for subscription")
 		return nil
 	}
 
 	return result.(*redis.PubSub)
 }
 
+// conversationChannels returns the user's own channel and the channels of
+// the given groups
+func conversationChannels(username string, groupIDs []string) []string {
+	channels := make([]string, 0, len(groupIDs)+1)
+	channels = append(channels, UserChannel(username))
+	for _, groupID := range groupIDs {
+		channels = append(channels, GroupChannel(groupID))
+	}
+	return channels
+}
+
 // Helper functions
 func (cs *ChatService) cacheMessage(ctx context.Context, msg *ChatMessage) error {
 	msgJSON, err := json.Marshal(msg)
@@ -659,11 +951,22 @@ func (cs *ChatService) persistMessageToDB(ctx context.Context, msg *ChatMessage)
 		ToUserID:   toUserID,
 		Content:    msg.Content,
 		IsGroup:    sql.NullBool{Bool: false, Valid: true}, // Basic 1:1 for now
+		Subtype:    msg.Subtype,
 	})
 
 	return err
 }
 
+// BufferDepth returns the number of messages waiting to be written to Kafka
+func (cs *ChatService) BufferDepth() int {
+	return len(cs.messageBuffer)
+}
+
+// MessagesAccepted returns the running total of messages queued for delivery
+func (cs *ChatService) MessagesAccepted() int64 {
+	return cs.metrics.messagesQueued.Load()
+}
+
 // Metrics helpers
 func (cs *ChatService) incrementMetric(name string) {
 	switch name {
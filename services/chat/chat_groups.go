@@ -3,9 +3,13 @@ package chat
 import (
 	"context"
 	"encoding/json"
+	"exc6/db"
 	"exc6/pkg/breaker"
 	"exc6/pkg/logger"
+	"exc6/services/events"
+	"exc6/services/moderation"
 	"fmt"
+	"slices"
 	"time"
 
 	"github.com/google/uuid"
@@ -14,7 +18,11 @@ import (
 )
 
 // SendGroupMessage sends a message to a group with circuit breaker protection
-func (cs *ChatService) SendGroupMessage(ctx context.Context, from, groupID, content string) (*ChatMessage, error) {
+func (cs *ChatService) SendGroupMessage(ctx context.Context, from, groupID, content string, opts ...SendOption) (*ChatMessage, error) {
+	if err := cs.checkLength(content); err != nil {
+		return nil, err
+	}
+
 	msg := &ChatMessage{
 		MessageID: uuid.NewString(),
 		FromID:    from,
@@ -23,6 +31,16 @@ func (cs *ChatService) SendGroupMessage(ctx context.Context, from, groupID, cont
 		Timestamp: time.Now().Unix(),
 		IsGroup:   true,
 	}
+	for _, opt := range opts {
+		opt(msg)
+	}
+	msg.Seal()
+
+	if msg.Subtype != SubtypeText {
+		if err := cs.policy.Check(ctx, from, moderation.ActionSendAttachment); err != nil {
+			return nil, err
+		}
+	}
 
 	logger.WithFields(map[string]any{
 		"message_id": msg.MessageID,
@@ -30,6 +48,7 @@ func (cs *ChatService) SendGroupMessage(ctx context.Context, from, groupID, cont
 		"group_id":   groupID,
 	}).Debug("Creating group message")
 
+	cs.stampSeq(ctx, msg)
 	msgJSON, err := json.Marshal(msg)
 	if err != nil {
 		return nil, err
@@ -37,19 +56,20 @@ func (cs *ChatService) SendGroupMessage(ctx context.Context, from, groupID, cont
 
 	// Use circuit breaker for Redis operations
 	_, err = breaker.ExecuteCtx(ctx, cs.cbRedis, func() (any, error) {
+		// 1. Cache message and record the group's activity
+		if err := cs.recordGroup(ctx, msg, msgJSON); err != nil {
+			return nil, err
+		}
+
 		pipe := cs.rdb.Pipeline()
 
-		// 1. Cache message
-		cacheKey := fmt.Sprintf("chat:group:%s:messages", msg.GroupID)
-		pipe.ZAdd(ctx, cacheKey, redis.Z{
-			Score:  float64(msg.Timestamp),
-			Member: msgJSON,
-		})
-		pipe.ZRemRangeByRank(ctx, cacheKey, 0, -RecentMessagesCacheSize-1)
-		pipe.Expire(ctx, cacheKey, MessageCacheTTL)
+		// 2. Record the recipients of a message sent with read receipts
+		if msg.Tracked {
+			cs.trackMessage(ctx, pipe, msg)
+		}
 
-		// 2. Publish to global chat:messages channel for WebSocket relay
-		pipe.Publish(ctx, "chat:messages", msgJSON)
+		// 3. Publish to the group's channel for WebSocket relay
+		pipe.Publish(ctx, GroupChannel(msg.GroupID), msgJSON)
 
 		_, err := pipe.Exec(ctx)
 		return nil, err
@@ -63,7 +83,7 @@ func (cs *ChatService) SendGroupMessage(ctx context.Context, from, groupID, cont
 		}).Error("Circuit breaker: Failed to send group message to Redis")
 	}
 
-	// 3. Buffer for Kafka persistence
+	// 4. Buffer for Kafka persistence
 	select {
 	case cs.messageBuffer <- msg:
 		cs.incrementMetric("queued")
@@ -86,9 +106,27 @@ func (cs *ChatService) SendGroupMessage(ctx context.Context, from, groupID, cont
 		cs.incrementMetric("queued")
 	}
 
+	cs.logEvent(ctx, events.TypeMessage, msg)
+	cs.runHooks(msg)
+	cs.notifyMentions(msg)
+
 	return msg, nil
 }
 
+// notifyMentions records the mentions in a group message without holding up
+// its sender
+func (cs *ChatService) notifyMentions(msg *ChatMessage) {
+	if cs.inbox == nil || len(msg.Mentions) == 0 {
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		cs.inbox.NotifyMentions(ctx, msg.FromID, msg.GroupID, msg.MessageID, msg.Content, msg.Mentions)
+	}()
+}
+
 // GetGroupHistory retrieves message history for a group with circuit breaker
 func (cs *ChatService) GetGroupHistory(ctx context.Context, groupID string) ([]*ChatMessage, error) {
 	cacheKey := fmt.Sprintf("chat:group:%s:messages", groupID)
@@ -119,9 +157,18 @@ func (cs *ChatService) GetGroupHistory(ctx context.Context, groupID string) ([]*
 			logger.WithError(err).Warn("Failed to unmarshal group message from cache")
 			continue
 		}
+		if !verify("group_cache", &msg) {
+			continue
+		}
 		messages = append(messages, &msg)
 	}
 
+	// The cache expires a day after the group goes quiet; older messages
+	// are in Postgres once the consumer archived them
+	if len(messages) == 0 {
+		messages = cs.loadGroupHistory(ctx, groupID)
+	}
+
 	logger.WithFields(map[string]any{
 		"group_id":      groupID,
 		"message_count": len(messages),
@@ -130,9 +177,85 @@ func (cs *ChatService) GetGroupHistory(ctx context.Context, groupID string) ([]*
 	return messages, nil
 }
 
+// loadGroupHistory reads the latest messages of a group from Postgres,
+// oldest first, and caches them again. Failures leave the history empty.
+func (cs *ChatService) loadGroupHistory(ctx context.Context, groupID string) []*ChatMessage {
+	id, err := uuid.Parse(groupID)
+	if err != nil {
+		return nil
+	}
+
+	rows, err := cs.qdb.GetGroupMessages(ctx, db.GetGroupMessagesParams{
+		GroupID:  id,
+		RowLimit: RecentMessagesCacheSize,
+	})
+	if err != nil {
+		logger.WithFields(map[string]any{
+			"group_id": groupID,
+			"error":    err.Error(),
+		}).Error("Failed to fetch group history from DB")
+		return nil
+	}
+
+	messages := make([]*ChatMessage, 0, len(rows))
+	for i := len(rows) - 1; i >= 0; i-- {
+		row := rows[i]
+		msg := &ChatMessage{
+			MessageID: row.MessageID,
+			FromID:    row.FromUsername,
+			GroupID:   groupID,
+			Content:   row.Content,
+			Subtype:   row.Subtype,
+			Timestamp: row.CreatedAt.Unix(),
+			IsGroup:   true,
+			Deleted:   row.DeletedAt.Valid,
+		}
+		if row.EditedAt.Valid {
+			msg.EditedAt = row.EditedAt.Time.Unix()
+		}
+		msg.Seal()
+		messages = append(messages, msg)
+	}
+
+	if len(messages) > 0 {
+		go cs.cacheGroupMessages(groupID, messages)
+	}
+	return messages
+}
+
+// cacheGroupMessages puts messages loaded from Postgres back in the group's
+// cache
+func (cs *ChatService) cacheGroupMessages(groupID string, messages []*ChatMessage) {
+	ctx, cancel := context.WithTimeout(cs.ctx, 5*time.Second)
+	defer cancel()
+
+	key := fmt.Sprintf("chat:group:%s:messages", groupID)
+	members := make([]redis.Z, 0, len(messages))
+	for _, msg := range messages {
+		msgJSON, err := json.Marshal(msg)
+		if err != nil {
+			continue
+		}
+		members = append(members, redis.Z{Score: float64(msg.Timestamp), Member: msgJSON})
+	}
+
+	if _, err := breaker.ExecuteCtx(ctx, cs.cbRedis, func() (any, error) {
+		pipe := cs.rdb.Pipeline()
+		pipe.ZAdd(ctx, key, members...)
+		pipe.Expire(ctx, key, MessageCacheTTL)
+		_, err := pipe.Exec(ctx)
+		return nil, err
+	}); err != nil {
+		logger.WithFields(map[string]any{
+			"group_id": groupID,
+			"error":    err.Error(),
+		}).Debug("Failed to cache group history")
+	}
+}
+
 // SubscribeToGroup subscribes to group messages with circuit breaker
 func (cs *ChatService) SubscribeToGroup(ctx context.Context, groupID string) *redis.PubSub {
-	channelName := fmt.Sprintf("chat:group:%s", groupID)
+	channelName := GroupChannel(groupID)
 
 	result, err := breaker.ExecuteCtx(ctx, cs.cbRedis, func() (any, error) {
 		return cs.rdb.Subscribe(ctx, channelName), nil
@@ -155,51 +278,35 @@ func (cs *ChatService) SubscribeToGroup(ctx context.Context, groupID string) *re
 	return result.(*redis.PubSub)
 }
 
-// IncrementGroupUnreadCount increments unread count for a group
-func (cs *ChatService) IncrementGroupUnreadCount(ctx context.Context, groupID, senderUsername string, memberUsernames []string) error {
-	// Don't increment for the sender
-	for _, member := range memberUsernames {
-		if member == senderUsername {
-			continue
-		}
-
-		key := fmt.Sprintf("chat:unread:%s", member)
-		groupKey := fmt.Sprintf("group:%s", groupID)
-
-		_, err := breaker.ExecuteCtx(ctx, cs.cbRedis, func() (any, error) {
-			return nil, cs.rdb.HIncrBy(ctx, key, groupKey, 1).Err()
-		})
-
-		if err != nil {
-			logger.WithFields(map[string]interface{}{
-				"member":   member,
-				"group_id": groupID,
-				"error":    err.Error(),
-			}).Warn("Circuit breaker: Failed to increment group unread count")
-		}
-	}
-
-	return nil
-}
-
-// MarkGroupRead marks a group as read for a user
-func (cs *ChatService) MarkGroupRead(ctx context.Context, username, groupID string) error {
-	key := fmt.Sprintf("chat:unread:%s", username)
-	groupKey := fmt.Sprintf("group:%s", groupID)
-
-	_, err := breaker.ExecuteCtx(ctx, cs.cbRedis, func() (any, error) {
-		return nil, cs.rdb.HDel(ctx, key, groupKey).Err()
+// GetGroupUnread returns the number of unread messages per group of
+// groupIDs that has any since username last read it. Muted groups have none.
+func (cs *ChatService) GetGroupUnread(ctx context.Context, username string, groupIDs []string) (map[string]int, error) {
+	groupIDs = slices.DeleteFunc(slices.Clone(groupIDs), func(groupID string) bool {
+		return cs.mutes.Muted(ctx, username, "", groupID)
 	})
 
+	counts, err := cs.unreadCounts(ctx, username, nil, groupIDs)
 	if err != nil {
 		logger.WithFields(map[string]interface{}{
 			"username": username,
-			"group_id": groupID,
+			"groups":   len(groupIDs),
 			"error":    err.Error(),
-		}).Warn("Circuit breaker: Failed to mark group read")
+		}).Warn("Circuit breaker: Failed to count unread group messages")
+		return make(map[string]int), nil
 	}
 
-	return err
+	unread := make(map[string]int, len(counts))
+	for _, groupID := range groupIDs {
+		if count := counts[receiptField("", groupID)]; count > 0 {
+			unread[groupID] = count
+		}
+	}
+	return unread, nil
+}
+
+// MarkGroupRead records that username read everything sent to the group so far
+func (cs *ChatService) MarkGroupRead(ctx context.Context, username, groupID string) error {
+	return cs.MarkRead(ctx, username, "", groupID, "", 0)
 }
 
 // Additional helper: Check circuit breaker health for group operations
@@ -0,0 +1,78 @@
+package chat
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"exc6/pkg/instance"
+	"exc6/pkg/logger"
+	"fmt"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Every message is sealed with a hash of its content when it is sent or
+// changed, and copies read back from the Redis cache or Kafka are checked
+// against it, so a copy that was corrupted or altered on the way is noticed
+// instead of being archived or shown. The hash is not a signature: someone
+// able to write to Redis or Kafka can reseal what they change.
+//
+// Messages sealed before checksums existed carry none and are trusted.
+
+var checksumMismatches = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "chat_checksum_mismatches_total",
+		Help: "Messages whose content no longer matches the checksum taken when they were sent, by where they were read",
+	},
+	[]string{"source"}, // source: cache, group_cache, kafka
+)
+
+func init() {
+	instance.Registerer().MustRegister(checksumMismatches)
+}
+
+// ContentChecksum hashes the fields of a message its checksum covers. Each
+// field is prefixed with its length so no two messages hash the same fields
+// alike.
+func (m *ChatMessage) ContentChecksum() string {
+	h := sha256.New()
+	for _, field := range []string{
+		m.MessageID, m.FromID, m.ToID, m.GroupID,
+		m.Subtype, m.Content, strconv.FormatInt(m.Timestamp, 10),
+	} {
+		fmt.Fprintf(h, "%d:%s", len(field), field)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Seal sets the message's checksum; call it after any change to the fields
+// it covers
+func (m *ChatMessage) Seal() {
+	m.Checksum = m.ContentChecksum()
+}
+
+// Intact reports whether the message still matches its checksum. Messages
+// without one are intact.
+func (m *ChatMessage) Intact() bool {
+	return m.Checksum == "" || m.Checksum == m.ContentChecksum()
+}
+
+// verify reports whether a message read from source is intact, counting and
+// auditing it when it is not
+func verify(source string, msg *ChatMessage) bool {
+	if msg.Intact() {
+		return true
+	}
+
+	checksumMismatches.WithLabelValues(source).Inc()
+	logger.WithFields(map[string]any{
+		"audit":      "message_checksum_mismatch",
+		"source":     source,
+		"message_id": msg.MessageID,
+		"from":       msg.FromID,
+		"to":         msg.ToID,
+		"group_id":   msg.GroupID,
+		"event":      msg.Event,
+	}).Error("Message content does not match its checksum")
+	return false
+}
@@ -0,0 +1,44 @@
+package chat
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChecksum(t *testing.T) {
+	msg := &ChatMessage{MessageID: "m1", FromID: "alice", ToID: "bob", Content: "hi", Timestamp: 1700000000}
+	assert.True(t, msg.Intact(), "messages without a checksum are trusted")
+
+	msg.Seal()
+	assert.True(t, msg.Intact())
+
+	altered := *msg
+	altered.Content = "hello"
+	assert.False(t, altered.Intact())
+
+	// Moving text between fields changes the checksum
+	shifted := *msg
+	shifted.FromID, shifted.ToID = "alicebob", ""
+	assert.NotEqual(t, msg.Checksum, shifted.ContentChecksum())
+
+	// Fields outside the checksum can change freely
+	read := *msg
+	read.ReadAt = 1700000100
+	assert.True(t, read.Intact())
+}
+
+func TestChangesReseal(t *testing.T) {
+	msg := &ChatMessage{MessageID: "m1", FromID: "alice", ToID: "bob", Content: "hi", Timestamp: 1700000000}
+	msg.Seal()
+
+	edit, err := editing(Limits{MaxLength: DefaultMaxLength}, "hello")
+	require.NoError(t, err)
+	require.NoError(t, edit(msg))
+	assert.True(t, msg.Intact())
+
+	require.NoError(t, tombstone(msg))
+	assert.True(t, msg.Intact())
+	assert.Empty(t, msg.Content)
+}
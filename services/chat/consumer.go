@@ -0,0 +1,339 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"exc6/config"
+	"exc6/db"
+	"exc6/pkg/instance"
+	"exc6/pkg/logger"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Kafka carries every accepted message and every edit and delete, keyed by
+// conversation so a message and its changes stay in order, in the topic of
+// their class. Direct messages
+// and their changes are written to Postgres when they are sent; group
+// messages only get there through the Consumer, which archives the topics into
+// the messages table and also fills in direct messages whose write failed at
+// send time. Writes are idempotent and a record's offset is only stored once
+// it is written, so records read again after a crash or a rebalance are
+// harmless.
+
+const (
+	// HistoryTopic carried every message and change before they were split
+	// into a topic per class; it remains the default topic of direct messages
+	HistoryTopic = "chat-history"
+
+	// ConsumerGroup is the Kafka consumer group archiving the topics; the
+	// instances share their partitions
+	ConsumerGroup = "chat-archiver"
+
+	consumerPollTimeout  = 500 * time.Millisecond
+	consumerWriteTimeout = 5 * time.Second
+
+	// lagInterval is how often the lag of the assigned partitions is measured
+	lagInterval      = 15 * time.Second
+	watermarkTimeout = 2 * time.Second
+)
+
+var (
+	consumerRecords = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "chat_consumer_records_total",
+			Help: "Kafka records handled by the chat history consumer by outcome",
+		},
+		[]string{"result"}, // result: archived, ignored, invalid, tampered, failed
+	)
+
+	consumerOffset = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "chat_consumer_offset",
+			Help: "Next offset the chat history consumer reads per assigned partition",
+		},
+		[]string{"topic", "partition"},
+	)
+
+	consumerLag = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "chat_consumer_lag",
+			Help: "Records of each assigned partition the chat history consumer has yet to read",
+		},
+		[]string{"topic", "partition"},
+	)
+)
+
+func init() {
+	instance.Registerer().MustRegister(consumerRecords, consumerOffset, consumerLag)
+}
+
+// Consumer archives the topics of every message class into Postgres
+type Consumer struct {
+	consumer *kafka.Consumer
+	qdb      *db.Queries
+
+	// positions is the next offset per assigned partition a record was read
+	// from; only the poll loop uses it
+	positions map[partitionKey]kafka.Offset
+
+	cancel    context.CancelFunc
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// partitionKey names a partition of one of the topics
+type partitionKey struct {
+	topic     string
+	partition int32
+}
+
+// NewConsumer joins ConsumerGroup and archives records until ctx is
+// cancelled or Close is called
+func NewConsumer(ctx context.Context, qdb *db.Queries, kafkaCfg config.KafkaConfig) (*Consumer, error) {
+	kc, err := kafka.NewConsumer(&kafka.ConfigMap{
+		"bootstrap.servers": kafkaCfg.Address,
+		"group.id":          ConsumerGroup,
+		"client.id":         "go-fiber-dashboard",
+		"auto.offset.reset": "earliest",
+
+		// Offsets are stored once their record is written and committed in
+		// the background
+		"enable.auto.offset.store": false,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	runCtx, cancel := context.WithCancel(ctx)
+	c := &Consumer{
+		consumer:  kc,
+		qdb:       qdb,
+		positions: make(map[partitionKey]kafka.Offset),
+		cancel:    cancel,
+		done:      make(chan struct{}),
+	}
+
+	if err := kc.SubscribeTopics(TopicsFrom(kafkaCfg).All(), c.rebalance); err != nil {
+		cancel()
+		kc.Close()
+		return nil, err
+	}
+
+	go c.run(runCtx)
+
+	logger.WithField("group", ConsumerGroup).Info("Chat history consumer started")
+
+	return c, nil
+}
+
+func (c *Consumer) run(ctx context.Context) {
+	defer close(c.done)
+
+	ticker := time.NewTicker(lagInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.observeLag()
+		default:
+		}
+
+		switch e := c.consumer.Poll(int(consumerPollTimeout.Milliseconds())).(type) {
+		case *kafka.Message:
+			c.handle(ctx, e)
+		case kafka.Error:
+			logger.WithFields(map[string]any{
+				"code":  e.Code().String(),
+				"error": e.Error(),
+			}).Warn("Chat history consumer error")
+		}
+	}
+}
+
+// handle writes a record and stores its offset. A failed write is retried
+// until it succeeds, as storing a later offset would skip the record.
+func (c *Consumer) handle(ctx context.Context, record *kafka.Message) {
+	producedAt := record.Timestamp
+	if producedAt.IsZero() {
+		producedAt = time.Now()
+	}
+
+	var msg ChatMessage
+	if err := json.Unmarshal(record.Value, &msg); err != nil {
+		consumerRecords.WithLabelValues("invalid").Inc()
+		logger.WithFields(map[string]any{
+			"topic":     topicOf(record.TopicPartition),
+			"partition": record.TopicPartition.Partition,
+			"offset":    int64(record.TopicPartition.Offset),
+			"error":     err.Error(),
+		}).Warn("Skipping undecodable chat history record")
+	} else if !verify("kafka", &msg) {
+		// Archiving it would spread the altered content to Postgres
+		consumerRecords.WithLabelValues("tampered").Inc()
+	} else {
+		for {
+			writeCtx, cancel := context.WithTimeout(ctx, consumerWriteTimeout)
+			result, err := Archive(writeCtx, c.qdb, &msg, producedAt)
+			cancel()
+			if err == nil {
+				consumerRecords.WithLabelValues(result).Inc()
+				break
+			}
+
+			consumerRecords.WithLabelValues("failed").Inc()
+			logger.WithFields(map[string]any{
+				"message_id": msg.MessageID,
+				"event":      msg.Event,
+				"error":      err.Error(),
+			}).Warn("Failed to archive chat history record, retrying")
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(RetryBackoff):
+			}
+		}
+	}
+
+	if _, err := c.consumer.StoreMessage(record); err != nil {
+		logger.WithError(err).Warn("Failed to store chat history consumer offset")
+	}
+
+	key := partitionKey{topic: topicOf(record.TopicPartition), partition: record.TopicPartition.Partition}
+	c.positions[key] = record.TopicPartition.Offset + 1
+	consumerOffset.WithLabelValues(key.labels()...).Set(float64(record.TopicPartition.Offset + 1))
+}
+
+// Archive applies a history record to Postgres and returns the outcome
+// for chat_consumer_records_total. Applying a record again changes nothing.
+func Archive(ctx context.Context, qdb *db.Queries, msg *ChatMessage, producedAt time.Time) (string, error) {
+	var rows int64
+	var err error
+
+	switch msg.Event {
+	case "":
+		params, ok := archiveParams(msg)
+		if !ok {
+			return "invalid", nil
+		}
+		rows, err = qdb.ArchiveMessage(ctx, params)
+	case EventEdit:
+		editedAt := producedAt
+		if msg.EditedAt > 0 {
+			editedAt = time.Unix(msg.EditedAt, 0)
+		}
+		rows, err = qdb.ArchiveMessageEdit(ctx, db.ArchiveMessageEditParams{
+			Content:   msg.Content,
+			EditedAt:  editedAt,
+			MessageID: msg.MessageID,
+		})
+	case EventDelete:
+		rows, err = qdb.ArchiveMessageDelete(ctx, db.ArchiveMessageDeleteParams{
+			DeletedAt: producedAt,
+			MessageID: msg.MessageID,
+		})
+	default:
+		return "invalid", nil
+	}
+
+	if err != nil {
+		return "", err
+	}
+	if rows == 0 {
+		// Already stored, or the message or its participants are gone
+		return "ignored", nil
+	}
+	return "archived", nil
+}
+
+// archiveParams returns the row of a message, or false when the record
+// cannot name one
+func archiveParams(msg *ChatMessage) (db.ArchiveMessageParams, bool) {
+	params := db.ArchiveMessageParams{
+		MessageID:    msg.MessageID,
+		Content:      msg.Content,
+		IsGroup:      msg.IsGroup,
+		Subtype:      msg.Subtype,
+		CreatedAt:    time.Unix(msg.Timestamp, 0),
+		ToUsername:   msg.ToID,
+		FromUsername: msg.FromID,
+	}
+	if msg.MessageID == "" || msg.FromID == "" {
+		return params, false
+	}
+
+	if !msg.IsGroup {
+		return params, msg.ToID != ""
+	}
+	groupID, err := uuid.Parse(msg.GroupID)
+	if err != nil {
+		return params, false
+	}
+	params.GroupID = uuid.NullUUID{UUID: groupID, Valid: true}
+	return params, true
+}
+
+// rebalance forgets the partitions taken from this instance; assignment
+// itself is left to the client
+func (c *Consumer) rebalance(_ *kafka.Consumer, event kafka.Event) error {
+	if revoked, ok := event.(kafka.RevokedPartitions); ok {
+		for _, tp := range revoked.Partitions {
+			key := partitionKey{topic: topicOf(tp), partition: tp.Partition}
+			delete(c.positions, key)
+			consumerOffset.DeleteLabelValues(key.labels()...)
+			consumerLag.DeleteLabelValues(key.labels()...)
+		}
+	}
+	return nil
+}
+
+// observeLag measures how far each partition read from is behind its end
+func (c *Consumer) observeLag() {
+	for key, next := range c.positions {
+		_, high, err := c.consumer.QueryWatermarkOffsets(key.topic, key.partition, int(watermarkTimeout.Milliseconds()))
+		if err != nil {
+			logger.WithFields(map[string]any{
+				"topic":     key.topic,
+				"partition": key.partition,
+				"error":     err.Error(),
+			}).Debug("Failed to query chat history watermarks")
+			continue
+		}
+		consumerLag.WithLabelValues(key.labels()...).Set(float64(max(high-int64(next), 0)))
+	}
+}
+
+// labels are the metric labels of the partition
+func (k partitionKey) labels() []string {
+	return []string{k.topic, strconv.Itoa(int(k.partition))}
+}
+
+// topicOf returns the topic of tp, which the client always sets on records
+// and assignments
+func topicOf(tp kafka.TopicPartition) string {
+	if tp.Topic == nil {
+		return ""
+	}
+	return *tp.Topic
+}
+
+// Close stops archiving, commits the stored offsets and leaves the group
+func (c *Consumer) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		c.cancel()
+		<-c.done
+		err = c.consumer.Close()
+		logger.Info("Chat history consumer stopped")
+	})
+	return err
+}
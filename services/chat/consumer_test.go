@@ -0,0 +1,42 @@
+package chat
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestArchiveParams(t *testing.T) {
+	groupID := uuid.New()
+
+	tests := []struct {
+		name      string
+		msg       ChatMessage
+		wantOK    bool
+		wantGroup uuid.NullUUID
+	}{
+		{name: "Direct message", msg: ChatMessage{MessageID: "m1", FromID: "alice", ToID: "bob"}, wantOK: true},
+		{name: "Direct message without recipient", msg: ChatMessage{MessageID: "m1", FromID: "alice"}},
+		{
+			name:      "Group message",
+			msg:       ChatMessage{MessageID: "m1", FromID: "alice", GroupID: groupID.String(), IsGroup: true},
+			wantOK:    true,
+			wantGroup: uuid.NullUUID{UUID: groupID, Valid: true},
+		},
+		{name: "Group message with invalid group", msg: ChatMessage{MessageID: "m1", FromID: "alice", GroupID: "nope", IsGroup: true}},
+		{name: "Without ID", msg: ChatMessage{FromID: "alice", ToID: "bob"}},
+		{name: "Without sender", msg: ChatMessage{MessageID: "m1", ToID: "bob"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params, ok := archiveParams(&tt.msg)
+			assert.Equal(t, tt.wantOK, ok)
+			if ok {
+				assert.Equal(t, tt.wantGroup, params.GroupID)
+				assert.Equal(t, tt.msg.IsGroup, params.IsGroup)
+			}
+		})
+	}
+}
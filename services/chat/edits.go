@@ -0,0 +1,323 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"exc6/apperrors"
+	"exc6/db"
+	"exc6/pkg/breaker"
+	"exc6/pkg/logger"
+	"exc6/services/events"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Senders can edit and delete their own messages. A change is applied to
+// Postgres for direct messages and to the cached history, then published on
+// the conversation's channels as an event so open clients update the message
+// in place. It is also written to Kafka under the conversation's key, like the
+// message itself, so history consumers can reconcile by message ID. A deleted
+// message is kept as a tombstone with its ID and timestamp but no content.
+
+// Events carried by ChatMessage.Event
+const (
+	EventEdit   = "edit"
+	EventDelete = "delete"
+)
+
+// maxReplaceAttempts bounds the retries when a cached message changes between
+// reading and replacing it
+const maxReplaceAttempts = 3
+
+// MessageRef identifies a message within its conversation
+type MessageRef struct {
+	ID string
+
+	// With is the other participant of a direct conversation
+	With string
+
+	// GroupID is set for group messages
+	GroupID string
+}
+
+// EditMessage replaces the content of a text message username sent
+func (cs *ChatService) EditMessage(ctx context.Context, username string, ref MessageRef, content string) (*ChatMessage, error) {
+	apply, err := editing(cs.limits, content)
+	if err != nil {
+		return nil, err
+	}
+	return cs.changeMessage(ctx, username, ref, EventEdit, content, apply)
+}
+
+// DeleteMessage turns a message username sent into a tombstone
+func (cs *ChatService) DeleteMessage(ctx context.Context, username string, ref MessageRef) (*ChatMessage, error) {
+	return cs.changeMessage(ctx, username, ref, EventDelete, "", tombstone)
+}
+
+// editing returns the change replacing the content of a text message with
+// content, or an error if content cannot replace it
+func editing(limits Limits, content string) (func(*ChatMessage) error, error) {
+	if strings.TrimSpace(content) == "" {
+		return nil, apperrors.New(apperrors.ErrCodeMessageEmpty, "Message content cannot be empty", http.StatusBadRequest)
+	}
+	if err := limits.Check(content); err != nil {
+		return nil, err
+	}
+
+	editedAt := time.Now().Unix()
+	return func(msg *ChatMessage) error {
+		if msg.Subtype != SubtypeText {
+			return apperrors.NewValidationError("Only text messages can be edited")
+		}
+		msg.Content = content
+		msg.EditedAt = editedAt
+		msg.Seal()
+		return nil
+	}, nil
+}
+
+// tombstone is the change deleting a message
+func tombstone(msg *ChatMessage) error {
+	if msg.Subtype == SubtypeSystem {
+		return apperrors.NewValidationError("System messages cannot be deleted")
+	}
+	msg.Content = ""
+	msg.Subtype = SubtypeText
+	msg.Deleted = true
+	msg.Seal()
+	return nil
+}
+
+// changeMessage applies an edit or delete everywhere the message is kept and
+// announces it. Group messages are changed in the cache and reach Postgres
+// through the Consumer, so one that has left the cache can only be changed
+// once loading the group's history caches it again.
+func (cs *ChatService) changeMessage(ctx context.Context, username string, ref MessageRef, event, content string, apply func(*ChatMessage) error) (*ChatMessage, error) {
+	if ref.ID == "" {
+		return nil, apperrors.NewBadRequest("Message ID required")
+	}
+
+	persisted := false
+	if ref.GroupID == "" {
+		rows, err := cs.persistChange(ctx, username, ref, event, content)
+		if err != nil {
+			return nil, err
+		}
+		persisted = rows > 0
+	}
+
+	key := cs.cacheKey(username, ref)
+	msg, err := cs.replaceCached(ctx, key, username, ref.ID, apply)
+	if err != nil {
+		if !persisted {
+			return nil, err
+		}
+		// Postgres accepted the change, so the cache is only lagging; it
+		// catches up when it expires
+		logger.WithFields(map[string]any{
+			"message_id": ref.ID,
+			"key":        key,
+			"error":      err.Error(),
+		}).Warn("Failed to update cached message")
+	}
+
+	if msg == nil {
+		if !persisted {
+			return nil, apperrors.New(apperrors.ErrCodeNotFound, "Message not found", http.StatusNotFound)
+		}
+		msg = &ChatMessage{MessageID: ref.ID, FromID: username, ToID: ref.With}
+		if err := apply(msg); err != nil {
+			return nil, err
+		}
+	}
+
+	change := *msg
+	change.Event = event
+	cs.announceChange(ctx, &change)
+
+	logger.WithFields(map[string]any{
+		"message_id": ref.ID,
+		"username":   username,
+		"group_id":   ref.GroupID,
+		"event":      event,
+	}).Info("Message changed")
+
+	return msg, nil
+}
+
+// persistChange applies a change to a direct message in Postgres and returns
+// the number of rows it touched. The queries only match messages the user
+// sent that can take the change; the cached copy explains a refusal.
+func (cs *ChatService) persistChange(ctx context.Context, username string, ref MessageRef, event, content string) (int64, error) {
+	var rows int64
+	var err error
+	switch event {
+	case EventEdit:
+		rows, err = cs.qdb.EditMessage(ctx, db.EditMessageParams{
+			Content:      content,
+			MessageID:    ref.ID,
+			FromUsername: username,
+			ToUsername:   ref.With,
+		})
+	case EventDelete:
+		rows, err = cs.qdb.DeleteMessage(ctx, db.DeleteMessageParams{
+			MessageID:    ref.ID,
+			FromUsername: username,
+			ToUsername:   ref.With,
+		})
+	}
+	if err != nil {
+		return 0, apperrors.NewDatabaseError("change message", err).WithDetails("message_id", ref.ID)
+	}
+
+	// A deleted message frees its pin; the delete event tells clients
+	if event == EventDelete && rows > 0 {
+		if err := cs.qdb.DeletePinnedMessage(ctx, ref.ID); err != nil {
+			logger.WithFields(map[string]any{
+				"message_id": ref.ID,
+				"error":      err.Error(),
+			}).Warn("Failed to unpin deleted message")
+		}
+	}
+	return rows, nil
+}
+
+// replaceCached applies a change to the cached copy of a message. It returns
+// nil without an error when the message is not cached.
+func (cs *ChatService) replaceCached(ctx context.Context, key, username, messageID string, apply func(*ChatMessage) error) (*ChatMessage, error) {
+	for attempt := 0; attempt < maxReplaceAttempts; attempt++ {
+		current, msg, err := cs.findCached(ctx, key, messageID)
+		if err != nil || msg == nil {
+			return nil, err
+		}
+
+		if msg.FromID != username {
+			return nil, apperrors.NewAuthorizationError(username, "message", "change")
+		}
+		if msg.Deleted {
+			return nil, apperrors.New(apperrors.ErrCodeNotFound, "Message was deleted", http.StatusNotFound)
+		}
+		// Resealing an altered copy would make it look genuine
+		if !verify("cache", msg) {
+			return nil, apperrors.NewCacheError("message_replace", key, fmt.Errorf("message %s does not match its checksum", messageID))
+		}
+		if err := apply(msg); err != nil {
+			return nil, err
+		}
+
+		msgJSON, err := json.Marshal(msg)
+		if err != nil {
+			return nil, err
+		}
+
+		result, err := breaker.ExecuteCtx(ctx, cs.cbRedis, func() (any, error) {
+			return replaceMessageScript.Run(ctx, cs.rdb, []string{key}, current, msgJSON).Int64()
+		})
+		if err != nil {
+			return nil, apperrors.NewCacheError("message_replace", key, err)
+		}
+		if replaced, _ := result.(int64); replaced == 1 {
+			return msg, nil
+		}
+	}
+	return nil, apperrors.NewCacheError("message_replace", key, fmt.Errorf("message %s kept changing", messageID))
+}
+
+// findCached returns the cached JSON and decoded form of a message, or nil
+// when it is not in the cache
+func (cs *ChatService) findCached(ctx context.Context, key, messageID string) (string, *ChatMessage, error) {
+	result, err := breaker.ExecuteCtx(ctx, cs.cbRedis, func() (any, error) {
+		return cs.rdb.ZRange(ctx, key, 0, -1).Result()
+	})
+	if err != nil {
+		return "", nil, apperrors.NewCacheError("message_lookup", key, err)
+	}
+
+	members, _ := result.([]string)
+	for _, member := range members {
+		// Skip decoding messages that cannot match
+		if !strings.Contains(member, messageID) {
+			continue
+		}
+		var msg ChatMessage
+		if err := json.Unmarshal([]byte(member), &msg); err != nil {
+			continue
+		}
+		if msg.MessageID == messageID {
+			return member, &msg, nil
+		}
+	}
+	return "", nil, nil
+}
+
+// announceChange publishes a change to the conversation's channels and queues
+// it for Kafka. The change is already stored, so failures are only logged.
+func (cs *ChatService) announceChange(ctx context.Context, change *ChatMessage) {
+	cs.publishEvent(ctx, change)
+
+	eventType := events.TypeEdit
+	if change.Event == EventDelete {
+		eventType = events.TypeDelete
+	}
+	cs.logEvent(ctx, eventType, change)
+
+	select {
+	case cs.messageBuffer <- change:
+	default:
+		if _, err := breaker.ExecuteCtx(ctx, cs.cbRedis, func() (any, error) {
+			return nil, cs.persistMessageToQueue(ctx, change)
+		}); err != nil {
+			logger.WithFields(map[string]any{
+				"message_id": change.MessageID,
+				"event":      change.Event,
+				"error":      err.Error(),
+			}).Error("Failed to queue message change for Kafka")
+		}
+	}
+}
+
+// publishEvent publishes an event to the conversation's channels: to the
+// group, or to both participants of a direct conversation. Failures are only
+// logged.
+func (cs *ChatService) publishEvent(ctx context.Context, event *ChatMessage) {
+	eventJSON, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+
+	if _, err := breaker.ExecuteCtx(ctx, cs.cbRedis, func() (any, error) {
+		pipe := cs.rdb.Pipeline()
+		for _, channel := range eventChannels(event) {
+			pipe.Publish(ctx, channel, eventJSON)
+		}
+		_, err := pipe.Exec(ctx)
+		return nil, err
+	}); err != nil {
+		logger.WithFields(map[string]any{
+			"message_id": event.MessageID,
+			"event":      event.Event,
+			"error":      err.Error(),
+		}).Warn("Failed to publish message event")
+	}
+}
+
+// eventChannels returns the channels of an event's conversation
+func eventChannels(event *ChatMessage) []string {
+	if event.GroupID != "" {
+		return []string{GroupChannel(event.GroupID)}
+	}
+	if event.FromID == event.ToID {
+		return []string{UserChannel(event.ToID)}
+	}
+	return []string{UserChannel(event.ToID), UserChannel(event.FromID)}
+}
+
+// cacheKey returns the key of the cached history a message belongs to
+func (cs *ChatService) cacheKey(username string, ref MessageRef) string {
+	if ref.GroupID != "" {
+		return fmt.Sprintf("chat:group:%s:messages", ref.GroupID)
+	}
+	return cs.GetConversationKey(username, ref.With)
+}
@@ -0,0 +1,130 @@
+package chat
+
+import (
+	"exc6/apperrors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// DefaultMaxLength bounds message content until SetLimits is called
+const DefaultMaxLength = 4000
+
+// Limits bounds message content so Redis and Kafka entries stay small, and
+// how much non-friends may write
+type Limits struct {
+	// MaxLength is the number of characters a single message may hold
+	MaxLength int
+
+	// Chunking splits oversized text into numbered parts instead of rejecting it
+	Chunking bool
+
+	// MaxChunks caps the parts a split message may produce
+	MaxChunks int
+
+	// RequestLimit is how many messages a non-friend may send before the
+	// recipient replies or accepts their message request
+	RequestLimit int
+}
+
+// SetLimits replaces the message limits. Call it before serving traffic.
+func (cs *ChatService) SetLimits(limits Limits) {
+	cs.limits = limits
+}
+
+// SplitContent returns the messages to send for content: content itself if it
+// fits, numbered parts if chunking is enabled, or a MESSAGE_TOO_LONG error
+func (cs *ChatService) SplitContent(content string) ([]string, error) {
+	return cs.limits.Split(content)
+}
+
+// checkLength enforces MaxLength for every send path, including bots
+func (cs *ChatService) checkLength(content string) error {
+	return cs.limits.Check(content)
+}
+
+// Split returns the messages to send for content under these limits
+func (l Limits) Split(content string) ([]string, error) {
+	length := utf8.RuneCountInString(content)
+	if length <= l.MaxLength {
+		return []string{content}, nil
+	}
+
+	if !l.Chunking {
+		return nil, apperrors.NewMessageTooLong(length, l.MaxLength,
+			"Shorten it or send it as several messages.")
+	}
+
+	parts := SplitMessage(content, l.MaxLength, l.MaxChunks)
+	if parts == nil {
+		return nil, apperrors.NewMessageTooLong(length, l.MaxLength,
+			fmt.Sprintf("Even split into %d parts it would not fit; share it as a file instead.", l.MaxChunks))
+	}
+
+	return parts, nil
+}
+
+// Check returns a MESSAGE_TOO_LONG error if content exceeds MaxLength
+func (l Limits) Check(content string) error {
+	if length := utf8.RuneCountInString(content); length > l.MaxLength {
+		return apperrors.NewMessageTooLong(length, l.MaxLength, "Shorten it or send it as several messages.")
+	}
+	return nil
+}
+
+// SplitMessage splits content into at most maxParts parts of at most maxLength
+// characters each, including a "[i/n] " marker. It prefers to break at line
+// ends, then at spaces. It returns nil if content does not fit in maxParts.
+func SplitMessage(content string, maxLength, maxParts int) []string {
+	// Reserve room for the widest marker so every part fits once numbered
+	budget := maxLength - utf8.RuneCountInString(partMarker(maxParts, maxParts))
+	if budget <= 0 {
+		return nil
+	}
+
+	var chunks []string
+	rest := []rune(content)
+
+	for len(rest) > 0 {
+		if len(chunks) == maxParts {
+			return nil
+		}
+
+		if len(rest) <= budget {
+			chunks = append(chunks, string(rest))
+			break
+		}
+
+		cut := breakPoint(rest[:budget])
+		chunks = append(chunks, strings.TrimRight(string(rest[:cut]), " \n"))
+		rest = rest[cut:]
+	}
+
+	parts := make([]string, len(chunks))
+	for i, chunk := range chunks {
+		parts[i] = partMarker(i+1, len(chunks)) + chunk
+	}
+
+	return parts
+}
+
+// breakPoint returns where to cut window: after the last line break, else
+// after the last space, as long as that keeps at least half of the window;
+// otherwise at its end
+func breakPoint(window []rune) int {
+	half := len(window) / 2
+
+	for _, sep := range []rune{'\n', ' '} {
+		for i := len(window) - 1; i >= half; i-- {
+			if window[i] == sep {
+				return i + 1
+			}
+		}
+	}
+
+	return len(window)
+}
+
+func partMarker(part, total int) string {
+	return fmt.Sprintf("[%d/%d] ", part, total)
+}
@@ -0,0 +1,89 @@
+package chat
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitMessage(t *testing.T) {
+	tests := []struct {
+		name      string
+		content   string
+		maxLength int
+		maxParts  int
+		want      []string
+	}{
+		{
+			name:      "Breaks at spaces",
+			content:   "alpha beta gamma delta epsilon",
+			maxLength: 20,
+			maxParts:  5,
+			want:      []string{"[1/3] alpha beta", "[2/3] gamma delta", "[3/3] epsilon"},
+		},
+		{
+			name:      "Prefers line breaks",
+			content:   "first line\nsecond line here",
+			maxLength: 24,
+			maxParts:  5,
+			want:      []string{"[1/2] first line", "[2/2] second line here"},
+		},
+		{
+			name:      "Hard cut without separators",
+			content:   strings.Repeat("x", 30),
+			maxLength: 16,
+			maxParts:  5,
+			want: []string{
+				"[1/3] " + strings.Repeat("x", 10),
+				"[2/3] " + strings.Repeat("x", 10),
+				"[3/3] " + strings.Repeat("x", 10),
+			},
+		},
+		{
+			name:      "Counts characters, not bytes",
+			content:   strings.Repeat("é", 20),
+			maxLength: 16,
+			maxParts:  5,
+			want: []string{
+				"[1/2] " + strings.Repeat("é", 10),
+				"[2/2] " + strings.Repeat("é", 10),
+			},
+		},
+		{
+			name:      "Too many parts",
+			content:   strings.Repeat("x", 100),
+			maxLength: 16,
+			maxParts:  3,
+			want:      nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parts := SplitMessage(tt.content, tt.maxLength, tt.maxParts)
+			assert.Equal(t, tt.want, parts)
+
+			for _, part := range parts {
+				assert.LessOrEqual(t, utf8.RuneCountInString(part), tt.maxLength)
+			}
+		})
+	}
+}
+
+func TestSplitContent(t *testing.T) {
+	cs := &ChatService{limits: Limits{MaxLength: 100}}
+
+	parts, err := cs.SplitContent("short")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"short"}, parts)
+
+	_, err = cs.SplitContent(strings.Repeat("x", 101))
+	assert.NotNil(t, err)
+
+	cs.SetLimits(Limits{MaxLength: 100, Chunking: true, MaxChunks: 3})
+	parts, err = cs.SplitContent(strings.Repeat("word ", 50))
+	assert.Nil(t, err)
+	assert.Len(t, parts, 3)
+}
@@ -0,0 +1,1199 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"exc6/apperrors"
+	"exc6/pkg/breaker"
+	"exc6/pkg/logger"
+	"exc6/pkg/pagination"
+	"exc6/services/activity"
+	"exc6/services/events"
+	"exc6/services/moderation"
+	"exc6/services/notifications"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// MemoryService keeps conversations in the process instead of Redis,
+// Postgres and Kafka, for unit tests and single-node deployments that can
+// afford to lose history on restart. Each conversation keeps its latest
+// MemoryHistorySize messages.
+//
+// Given a Redis client, messages and events are still published for live
+// delivery; without one, SubscribeToConversations returns nil and clients
+// see new messages when they reload. Message requests are not enforced:
+// everyone may message anyone, as if they were friends. Moderation, mutes,
+// mentions, hooks and event feeds behave as with ChatService when set.
+
+// MemoryHistorySize is how many messages a conversation keeps in memory
+const MemoryHistorySize = 1000
+
+// MemoryService is a Service keeping everything in memory
+type MemoryService struct {
+	// rdb relays live events; may be nil
+	rdb *redis.Client
+
+	limits   Limits
+	activity *activity.Tracker
+	events   *events.Log
+	policy   *moderation.Policy
+	mutes    *notifications.Service
+	inbox    *notifications.NotificationService
+
+	hooksMu sync.RWMutex
+	hooks   []MessageHook
+
+	mu sync.Mutex
+
+	// conversations holds each conversation's messages, oldest first
+	conversations map[string][]*storedMessage
+
+	// seqs holds each conversation's last sequence number
+	seqs map[string]int64
+
+	// unread holds, per user, the newest message of each contact whose
+	// conversation has unread messages
+	unread map[string]map[string]int64
+
+	// receipts holds each reader's positions per conversation field
+	receipts map[string]map[string]*position
+
+	// reactions holds reactor and emoji pairs per message
+	reactions map[string][]string
+
+	// pins holds each direct conversation's pins, most recent first
+	pins map[string][]storedPin
+
+	// tracked holds the receipts of group messages sent with read receipts
+	tracked map[string]*storedTracking
+
+	// typing holds until when typing events are suppressed
+	typing map[string]time.Time
+
+	accepted atomic.Int64
+}
+
+// storedMessage is a message and when it was stored, which orders history
+// pages like the creation time in Postgres
+type storedMessage struct {
+	msg       ChatMessage
+	createdAt time.Time
+}
+
+// position is a reader's delivered and read position in a conversation and
+// when each last moved
+type position struct {
+	delivered, deliveredAt int64
+	read, readAt           int64
+}
+
+type storedPin struct {
+	messageID string
+	pinnedBy  string
+	pinnedAt  time.Time
+}
+
+type storedTracking struct {
+	groupID    string
+	from       string
+	timestamp  int64
+	recipients []string
+	delivered  map[string]int64
+	read       map[string]int64
+}
+
+// NewMemoryService creates an in-memory chat service. rdb may be nil.
+func NewMemoryService(rdb *redis.Client) *MemoryService {
+	logger.Info("Chat service initialized in memory")
+
+	return &MemoryService{
+		rdb:           rdb,
+		limits:        Limits{MaxLength: DefaultMaxLength, RequestLimit: DefaultRequestLimit},
+		conversations: make(map[string][]*storedMessage),
+		seqs:          make(map[string]int64),
+		unread:        make(map[string]map[string]int64),
+		receipts:      make(map[string]map[string]*position),
+		reactions:     make(map[string][]string),
+		pins:          make(map[string][]storedPin),
+		tracked:       make(map[string]*storedTracking),
+		typing:        make(map[string]time.Time),
+	}
+}
+
+// SendMessage stores a direct message and publishes it to both participants
+func (ms *MemoryService) SendMessage(ctx context.Context, from, to, content string, opts ...SendOption) (*ChatMessage, error) {
+	if err := ms.limits.Check(content); err != nil {
+		return nil, err
+	}
+
+	msg := &ChatMessage{
+		MessageID: uuid.NewString(),
+		FromID:    from,
+		ToID:      to,
+		Content:   content,
+		Timestamp: time.Now().Unix(),
+	}
+	for _, opt := range opts {
+		opt(msg)
+	}
+	msg.Seal()
+
+	if msg.Subtype != SubtypeText {
+		if err := ms.policy.Check(ctx, from, moderation.ActionSendAttachment); err != nil {
+			return nil, err
+		}
+	}
+	if err := ms.policy.CheckMessage(ctx, from, to); err != nil {
+		return nil, err
+	}
+
+	if err := ms.deliver(ctx, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// DeliverPersisted stores and publishes a direct message the caller already
+// wrote to Postgres
+func (ms *MemoryService) DeliverPersisted(ctx context.Context, msg *ChatMessage) error {
+	return ms.deliver(ctx, msg)
+}
+
+func (ms *MemoryService) deliver(ctx context.Context, msg *ChatMessage) error {
+	from, to := msg.FromID, msg.ToID
+
+	// A recipient who reported the sender or muted the conversation is not
+	// notified
+	countUnread := !ms.policy.Muted(ctx, to, from) && !ms.mutes.Muted(ctx, to, from, "")
+
+	ms.mu.Lock()
+	ms.store(getChatKey(from, to), msg)
+	if countUnread {
+		if ms.unread[to] == nil {
+			ms.unread[to] = make(map[string]int64)
+		}
+		ms.unread[to][from] = msg.Timestamp
+	}
+	ms.mu.Unlock()
+
+	ms.accepted.Add(1)
+	ms.activity.TouchConversation(ctx, from, to)
+	ms.publish(ctx, msg, eventChannels(msg)...)
+	appendEvent(ctx, ms.events, events.TypeMessage, msg)
+
+	// Bots and other observers only see what users wrote
+	if msg.Subtype != SubtypeSystem {
+		ms.runHooks(msg)
+	}
+	return nil
+}
+
+// SendGroupMessage stores a group message and publishes it to the group
+func (ms *MemoryService) SendGroupMessage(ctx context.Context, from, groupID, content string, opts ...SendOption) (*ChatMessage, error) {
+	if err := ms.limits.Check(content); err != nil {
+		return nil, err
+	}
+
+	msg := &ChatMessage{
+		MessageID: uuid.NewString(),
+		FromID:    from,
+		GroupID:   groupID,
+		Content:   content,
+		Timestamp: time.Now().Unix(),
+		IsGroup:   true,
+	}
+	for _, opt := range opts {
+		opt(msg)
+	}
+	msg.Seal()
+
+	if msg.Subtype != SubtypeText {
+		if err := ms.policy.Check(ctx, from, moderation.ActionSendAttachment); err != nil {
+			return nil, err
+		}
+	}
+
+	ms.mu.Lock()
+	ms.store(groupKey(groupID), msg)
+	if msg.Tracked {
+		ms.track(msg)
+	}
+	ms.mu.Unlock()
+
+	ms.accepted.Add(1)
+	ms.activity.TouchGroup(ctx, groupID)
+	ms.publish(ctx, msg, GroupChannel(groupID))
+	appendEvent(ctx, ms.events, events.TypeMessage, msg)
+	ms.runHooks(msg)
+
+	if ms.inbox != nil && len(msg.Mentions) > 0 {
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			ms.inbox.NotifyMentions(ctx, msg.FromID, msg.GroupID, msg.MessageID, msg.Content, msg.Mentions)
+		}()
+	}
+
+	return msg, nil
+}
+
+// SplitContent returns the messages to send for content
+func (ms *MemoryService) SplitContent(content string) ([]string, error) {
+	return ms.limits.Split(content)
+}
+
+// GetHistory returns the latest RecentMessagesCacheSize messages of a direct
+// conversation, oldest first. Memory is never degraded.
+func (ms *MemoryService) GetHistory(ctx context.Context, user1, user2 string) (breaker.Result[[]*ChatMessage], error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	stored := ms.conversations[getChatKey(user1, user2)]
+	messages := copyMessages(stored[max(len(stored)-RecentMessagesCacheSize, 0):])
+	ms.applyReceipts(user1, user2, messages)
+	return breaker.Healthy(messages), nil
+}
+
+// GetHistoryPage returns a page of a direct conversation, newest first
+func (ms *MemoryService) GetHistoryPage(ctx context.Context, user1, user2 string, page pagination.Params) (pagination.Page[*ChatMessage], error) {
+	if page.After != nil {
+		if _, err := pagination.ParseIntKey(page.After.Key); err != nil {
+			return pagination.Page[*ChatMessage]{}, apperrors.NewValidationError("Invalid pagination cursor")
+		}
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	stored := pagination.Apply(ms.conversations[getChatKey(user1, user2)], page, pagination.Descending, storedCursor)
+	messages := copyMessages(stored.Items)
+	ms.applyReceipts(user1, user2, messages)
+
+	return pagination.Page[*ChatMessage]{
+		Items:      messages,
+		NextCursor: stored.NextCursor,
+		HasMore:    stored.HasMore,
+	}, nil
+}
+
+// GetGroupHistory returns the latest RecentMessagesCacheSize messages of a
+// group, oldest first
+func (ms *MemoryService) GetGroupHistory(ctx context.Context, groupID string) ([]*ChatMessage, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	stored := ms.conversations[groupKey(groupID)]
+	return copyMessages(stored[max(len(stored)-RecentMessagesCacheSize, 0):]), nil
+}
+
+// MessagesAfter returns up to limit messages of a conversation sent after
+// ref.ID, oldest first, see ChatService.MessagesAfter. Messages past
+// MemoryHistorySize are gone, so a gap reaching further is reported as cut.
+func (ms *MemoryService) MessagesAfter(ctx context.Context, username string, ref MessageRef, since int64, limit int) ([]*ChatMessage, bool, error) {
+	if since <= 0 {
+		return nil, false, apperrors.NewValidationError("The time of the last message is required")
+	}
+	limit = min(max(limit, 1), MaxResumeMessages)
+
+	ms.mu.Lock()
+	stored := copyMessages(ms.conversations[memoryKey(username, ref)])
+	ms.mu.Unlock()
+
+	missed, complete := messagesAfter(stored, ref.ID, since)
+	if !complete {
+		missed = stored
+	}
+	messages, more := truncate(missed, limit)
+	return messages, more || (!complete && len(stored) > 0), nil
+}
+
+// SearchConversation returns the text messages between username and contact
+// that contain query, newest first
+func (ms *MemoryService) SearchConversation(ctx context.Context, username, contact, query string, page pagination.Params) (pagination.Page[SearchResult], error) {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return pagination.Page[SearchResult]{}, apperrors.NewValidationError("Search query is required")
+	}
+	if utf8.RuneCountInString(query) > MaxSearchLength {
+		return pagination.Page[SearchResult]{}, apperrors.NewValidationError(fmt.Sprintf("Search query cannot be longer than %d characters", MaxSearchLength))
+	}
+	if page.After != nil {
+		if _, err := pagination.ParseIntKey(page.After.Key); err != nil {
+			return pagination.Page[SearchResult]{}, apperrors.NewValidationError("Invalid pagination cursor")
+		}
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	var found []*storedMessage
+	matches := make(map[*storedMessage][]Match)
+	for _, stored := range ms.conversations[getChatKey(username, contact)] {
+		if stored.msg.Subtype != SubtypeText || stored.msg.Deleted {
+			continue
+		}
+		if m := matchOffsets(stored.msg.Content, query); len(m) > 0 {
+			found = append(found, stored)
+			matches[stored] = m
+		}
+	}
+
+	stored := pagination.Apply(found, page, pagination.Descending, storedCursor)
+	results := make([]SearchResult, 0, len(stored.Items))
+	for _, s := range stored.Items {
+		msg := s.msg
+		results = append(results, SearchResult{
+			Message: &msg,
+			Matches: matches[s],
+			Anchor:  historyAnchor(s.createdAt),
+		})
+	}
+
+	return pagination.Page[SearchResult]{
+		Items:      results,
+		NextCursor: stored.NextCursor,
+		HasMore:    stored.HasMore,
+	}, nil
+}
+
+// GetConversationKey names the conversation between two users
+func (ms *MemoryService) GetConversationKey(user1, user2 string) string {
+	return getChatKey(user1, user2)
+}
+
+// SubscribeToConversations subscribes to the user's own channel and to the
+// channels of the given groups, or returns nil without Redis
+func (ms *MemoryService) SubscribeToConversations(ctx context.Context, username string, groupIDs []string) *redis.PubSub {
+	if ms.rdb == nil {
+		return nil
+	}
+	return ms.rdb.Subscribe(ctx, conversationChannels(username, groupIDs)...)
+}
+
+// PublishTyping tells the other participants of a conversation that from is
+// typing, at most once per TypingDedupTTL
+func (ms *MemoryService) PublishTyping(ctx context.Context, from, to, groupID string) error {
+	if (to == "") == (groupID == "") || to == from {
+		return apperrors.NewBadRequest("Either a contact or a group is required")
+	}
+
+	key := receiptField(to, groupID) + ":" + from
+	now := time.Now()
+
+	ms.mu.Lock()
+	if now.Before(ms.typing[key]) {
+		ms.mu.Unlock()
+		return nil
+	}
+	for k, until := range ms.typing {
+		if now.After(until) {
+			delete(ms.typing, k)
+		}
+	}
+	ms.typing[key] = now.Add(TypingDedupTTL)
+	ms.mu.Unlock()
+
+	channel := UserChannel(to)
+	if groupID != "" {
+		channel = GroupChannel(groupID)
+	}
+	ms.publish(ctx, &ChatMessage{
+		FromID:    from,
+		ToID:      to,
+		GroupID:   groupID,
+		IsGroup:   groupID != "",
+		Timestamp: now.Unix(),
+		Event:     EventTyping,
+	}, channel)
+	return nil
+}
+
+// ConversationEvents returns up to limit events after the sequence number
+// since in username's conversation with contact, or in groupID when set
+func (ms *MemoryService) ConversationEvents(ctx context.Context, username, contact, groupID string, since int64, limit int) (*events.Feed, error) {
+	conversation := events.Group(groupID)
+	if groupID == "" {
+		conversation = events.Direct(username, contact)
+	}
+
+	feed, err := ms.events.Since(ctx, conversation, since, limit)
+	if err != nil {
+		return nil, apperrors.NewCacheError("events_read", conversation, err)
+	}
+	return feed, nil
+}
+
+// GetUnreadMessages returns the number of unread messages per contact
+func (ms *MemoryService) GetUnreadMessages(ctx context.Context, username string) (map[string]int, error) {
+	ms.mu.Lock()
+	peers := make([]string, 0, len(ms.unread[username]))
+	for peer := range ms.unread[username] {
+		peers = append(peers, peer)
+	}
+	ms.mu.Unlock()
+
+	// Conversations muted since they became unread no longer count
+	peers = slices.DeleteFunc(peers, func(peer string) bool {
+		return ms.mutes.Muted(ctx, username, peer, "")
+	})
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	unread := make(map[string]int, len(peers))
+	for _, peer := range peers {
+		if count := ms.countUnread(username, getChatKey(username, peer), receiptField(peer, "")); count > 0 {
+			unread[peer] = count
+		}
+	}
+	return unread, nil
+}
+
+// GetGroupUnread returns the number of unread messages per group of groupIDs
+// that has any. Muted groups have none.
+func (ms *MemoryService) GetGroupUnread(ctx context.Context, username string, groupIDs []string) (map[string]int, error) {
+	groupIDs = slices.DeleteFunc(slices.Clone(groupIDs), func(groupID string) bool {
+		return ms.mutes.Muted(ctx, username, "", groupID)
+	})
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	unread := make(map[string]int, len(groupIDs))
+	for _, groupID := range groupIDs {
+		if count := ms.countUnread(username, groupKey(groupID), receiptField("", groupID)); count > 0 {
+			unread[groupID] = count
+		}
+	}
+	return unread, nil
+}
+
+// MarkConversationRead records that recipient read everything sender sent
+// them so far
+func (ms *MemoryService) MarkConversationRead(ctx context.Context, recipient, sender string) error {
+	return ms.MarkRead(ctx, recipient, sender, "", "", 0)
+}
+
+// MarkAllRead records that username read every conversation with unread
+// direct messages
+func (ms *MemoryService) MarkAllRead(ctx context.Context, username string) error {
+	ms.mu.Lock()
+	peers := make([]string, 0, len(ms.unread[username]))
+	for peer := range ms.unread[username] {
+		peers = append(peers, peer)
+	}
+	ms.mu.Unlock()
+
+	for _, peer := range peers {
+		if err := ms.MarkRead(ctx, username, peer, "", "", 0); err != nil {
+			return err
+		}
+	}
+	ms.activity.TouchList(ctx, username)
+	return nil
+}
+
+// MarkGroupRead records that username read everything sent to the group so far
+func (ms *MemoryService) MarkGroupRead(ctx context.Context, username, groupID string) error {
+	return ms.MarkRead(ctx, username, "", groupID, "", 0)
+}
+
+// MarkDelivered records that username received the direct messages sender
+// sent them up to timestamp
+func (ms *MemoryService) MarkDelivered(ctx context.Context, username, sender, messageID string, timestamp int64) error {
+	if sender == "" || sender == username {
+		return apperrors.NewBadRequest("Sender required")
+	}
+	return ms.markPosition(ctx, username, sender, "", messageID, timestamp, EventDelivered)
+}
+
+// MarkRead records that username read a conversation, with peer or in
+// groupID, up to timestamp
+func (ms *MemoryService) MarkRead(ctx context.Context, username, peer, groupID, messageID string, timestamp int64) error {
+	if (peer == "") == (groupID == "") || peer == username {
+		return apperrors.NewBadRequest("Either a contact or a group is required")
+	}
+	return ms.markPosition(ctx, username, peer, groupID, messageID, timestamp, EventRead)
+}
+
+// markPosition moves a position and announces the receipt when it moved
+func (ms *MemoryService) markPosition(ctx context.Context, username, peer, groupID, messageID string, timestamp int64, event string) error {
+	now := time.Now().Unix()
+
+	// Clients cannot acknowledge ahead of the clock
+	if timestamp <= 0 || timestamp > now {
+		timestamp = now
+	}
+	field := receiptField(peer, groupID)
+
+	ms.mu.Lock()
+	if ms.receipts[username] == nil {
+		ms.receipts[username] = make(map[string]*position)
+	}
+	pos := ms.receipts[username][field]
+	if pos == nil {
+		pos = &position{}
+		ms.receipts[username][field] = pos
+	}
+
+	moved := false
+	if timestamp > pos.delivered {
+		pos.delivered, pos.deliveredAt = timestamp, now
+		moved = true
+	}
+	if event == EventRead {
+		moved = false
+		if timestamp > pos.read {
+			pos.read, pos.readAt = timestamp, now
+			moved = true
+		}
+		if latest, ok := ms.unread[username][field]; ok && latest <= timestamp {
+			delete(ms.unread[username], field)
+		}
+	}
+	ms.mu.Unlock()
+
+	if moved {
+		receipt := &ChatMessage{
+			MessageID: messageID,
+			FromID:    username,
+			ToID:      peer,
+			GroupID:   groupID,
+			IsGroup:   groupID != "",
+			Timestamp: timestamp,
+			Event:     event,
+		}
+		if event == EventRead {
+			receipt.ReadAt = now
+		} else {
+			receipt.DeliveredAt = now
+		}
+		channel := UserChannel(peer)
+		if groupID != "" {
+			channel = GroupChannel(groupID)
+		}
+		ms.publish(ctx, receipt, channel)
+		if event == EventRead {
+			appendEvent(ctx, ms.events, events.TypeRead, receipt)
+		}
+	}
+
+	if event == EventRead {
+		if groupID != "" {
+			ms.activity.TouchGroupRead(ctx, username, groupID)
+		} else {
+			ms.activity.TouchRead(ctx, username, peer)
+		}
+	}
+	return nil
+}
+
+// MarkTrackedDelivered records that a group message reached username, if it
+// is tracked and they are one of its recipients
+func (ms *MemoryService) MarkTrackedDelivered(ctx context.Context, username, groupID, messageID string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if t := ms.trackedIn(groupID, messageID); t != nil {
+		t.record(username, false)
+	}
+	return nil
+}
+
+// MarkGroupDelivered records that username received every tracked message of
+// the group
+func (ms *MemoryService) MarkGroupDelivered(ctx context.Context, username, groupID string) error {
+	return ms.markTracked(username, groupID, "", time.Now().Unix(), false)
+}
+
+// MarkTrackedRead applies a group read receipt to tracked messages: username
+// read messageID and, when upTo is set, everything sent until that Unix time
+func (ms *MemoryService) MarkTrackedRead(ctx context.Context, username, groupID, messageID string, upTo int64) error {
+	// Clients cannot read ahead of the clock
+	return ms.markTracked(username, groupID, messageID, min(upTo, time.Now().Unix()), true)
+}
+
+func (ms *MemoryService) markTracked(username, groupID, messageID string, upTo int64, read bool) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	since := trackedSince()
+	for id, t := range ms.tracked {
+		if t.groupID != groupID || t.timestamp < since {
+			continue
+		}
+		if id == messageID || upTo > 0 && t.timestamp <= upTo {
+			t.record(username, read)
+		}
+	}
+	return nil
+}
+
+// TrackedReceipts returns the status of each recipient of a tracked message
+func (ms *MemoryService) TrackedReceipts(ctx context.Context, groupID, messageID string) (*TrackedMessage, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	t := ms.trackedIn(groupID, messageID)
+	if t == nil {
+		return nil, apperrors.New(apperrors.ErrCodeNotFound, "No read receipts were requested for this message", http.StatusNotFound)
+	}
+
+	tm := t.summary(messageID)
+	for _, username := range t.recipients {
+		tm.Members = append(tm.Members, MemberReceipt{
+			Username:    username,
+			DeliveredAt: t.delivered[username],
+			ReadAt:      t.read[username],
+		})
+	}
+	sort.Slice(tm.Members, func(i, j int) bool { return tm.Members[i].Username < tm.Members[j].Username })
+	return tm, nil
+}
+
+// TrackedMessages returns the counts of the group's most recent tracked
+// messages, newest first
+func (ms *MemoryService) TrackedMessages(ctx context.Context, groupID string, limit int) ([]TrackedMessage, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	since := trackedSince()
+	list := []TrackedMessage{}
+	for id, t := range ms.tracked {
+		if t.groupID == groupID && t.timestamp >= since {
+			list = append(list, *t.summary(id))
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Timestamp != list[j].Timestamp {
+			return list[i].Timestamp > list[j].Timestamp
+		}
+		return list[i].MessageID > list[j].MessageID
+	})
+	if limit > 0 && len(list) > limit {
+		list = list[:limit]
+	}
+	return list, nil
+}
+
+// EditMessage replaces the content of a text message username sent
+func (ms *MemoryService) EditMessage(ctx context.Context, username string, ref MessageRef, content string) (*ChatMessage, error) {
+	apply, err := editing(ms.limits, content)
+	if err != nil {
+		return nil, err
+	}
+	return ms.changeMessage(ctx, username, ref, EventEdit, apply)
+}
+
+// DeleteMessage turns a message username sent into a tombstone and unpins it
+func (ms *MemoryService) DeleteMessage(ctx context.Context, username string, ref MessageRef) (*ChatMessage, error) {
+	return ms.changeMessage(ctx, username, ref, EventDelete, tombstone)
+}
+
+func (ms *MemoryService) changeMessage(ctx context.Context, username string, ref MessageRef, event string, apply func(*ChatMessage) error) (*ChatMessage, error) {
+	if ref.ID == "" {
+		return nil, apperrors.NewBadRequest("Message ID required")
+	}
+
+	ms.mu.Lock()
+	key := memoryKey(username, ref)
+	stored := ms.find(key, ref.ID)
+	switch {
+	case stored == nil:
+		ms.mu.Unlock()
+		return nil, apperrors.New(apperrors.ErrCodeNotFound, "Message not found", http.StatusNotFound)
+	case stored.msg.FromID != username:
+		ms.mu.Unlock()
+		return nil, apperrors.NewAuthorizationError(username, "message", "change")
+	case stored.msg.Deleted:
+		ms.mu.Unlock()
+		return nil, apperrors.New(apperrors.ErrCodeNotFound, "Message was deleted", http.StatusNotFound)
+	}
+
+	msg := stored.msg
+	if err := apply(&msg); err != nil {
+		ms.mu.Unlock()
+		return nil, err
+	}
+	stored.msg = msg
+	if event == EventDelete {
+		ms.pins[key] = slices.DeleteFunc(ms.pins[key], func(pin storedPin) bool { return pin.messageID == ref.ID })
+	}
+	ms.mu.Unlock()
+
+	change := msg
+	change.Event = event
+	ms.publish(ctx, &change, eventChannels(&change)...)
+
+	eventType := events.TypeEdit
+	if event == EventDelete {
+		eventType = events.TypeDelete
+	}
+	appendEvent(ctx, ms.events, eventType, &change)
+
+	logger.WithFields(map[string]any{
+		"message_id": ref.ID,
+		"username":   username,
+		"group_id":   ref.GroupID,
+		"event":      event,
+	}).Info("Message changed")
+
+	return &msg, nil
+}
+
+// AddReaction reacts to a message with emoji on behalf of username and
+// returns the message's reactions
+func (ms *MemoryService) AddReaction(ctx context.Context, username string, ref MessageRef, emoji string) ([]Reaction, error) {
+	return ms.react(ctx, username, ref, emoji, true)
+}
+
+// RemoveReaction withdraws a reaction of username and returns the message's
+// reactions
+func (ms *MemoryService) RemoveReaction(ctx context.Context, username string, ref MessageRef, emoji string) ([]Reaction, error) {
+	return ms.react(ctx, username, ref, emoji, false)
+}
+
+// GetReactions returns the reactions of a message username can see
+func (ms *MemoryService) GetReactions(ctx context.Context, username string, ref MessageRef) ([]Reaction, error) {
+	if err := ms.checkMessage(username, ref); err != nil {
+		return nil, err
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	return summarizeReactions(ms.reactions[ref.ID]), nil
+}
+
+func (ms *MemoryService) react(ctx context.Context, username string, ref MessageRef, emoji string, add bool) ([]Reaction, error) {
+	if !validReaction(emoji) {
+		return nil, apperrors.NewValidationError("Reactions must be a single emoji or custom emoji shortcode")
+	}
+	if err := ms.checkMessage(username, ref); err != nil {
+		return nil, err
+	}
+
+	member := username + reactionSeparator + emoji
+	event := EventReactionRemoved
+	if add {
+		event = EventReactionAdded
+	}
+
+	ms.mu.Lock()
+	members := ms.reactions[ref.ID]
+	changed := false
+	switch i := slices.Index(members, member); {
+	case add && i < 0:
+		mine := 0
+		for _, m := range members {
+			if strings.HasPrefix(m, username+reactionSeparator) {
+				mine++
+			}
+		}
+		if mine >= MaxReactionsPerUser {
+			ms.mu.Unlock()
+			return nil, apperrors.NewValidationError(fmt.Sprintf("You cannot add more than %d reactions to a message", MaxReactionsPerUser))
+		}
+		ms.reactions[ref.ID] = append(members, member)
+		changed = true
+	case !add && i >= 0:
+		ms.reactions[ref.ID] = slices.Delete(members, i, i+1)
+		changed = true
+	}
+	reactions := summarizeReactions(ms.reactions[ref.ID])
+	ms.mu.Unlock()
+
+	if changed {
+		change := &ChatMessage{
+			MessageID: ref.ID,
+			FromID:    username,
+			ToID:      ref.With,
+			GroupID:   ref.GroupID,
+			IsGroup:   ref.GroupID != "",
+			Content:   emoji,
+			Timestamp: time.Now().Unix(),
+			Event:     event,
+			Reactions: reactions,
+		}
+		ms.publish(ctx, change, eventChannels(change)...)
+		appendEvent(ctx, ms.events, events.TypeReaction, change)
+	}
+
+	return reactions, nil
+}
+
+// checkMessage confirms that a message exists in the conversation ref names
+// as seen by username and was not deleted
+func (ms *MemoryService) checkMessage(username string, ref MessageRef) error {
+	if ref.ID == "" {
+		return apperrors.NewBadRequest("Message ID required")
+	}
+	if (ref.With == "") == (ref.GroupID == "") || ref.With == username {
+		return apperrors.NewBadRequest("Either a contact or a group is required")
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	stored := ms.find(memoryKey(username, ref), ref.ID)
+	if stored == nil {
+		return apperrors.New(apperrors.ErrCodeNotFound, "Message not found", http.StatusNotFound)
+	}
+	if stored.msg.Deleted {
+		return apperrors.New(apperrors.ErrCodeNotFound, "Message was deleted", http.StatusNotFound)
+	}
+	return nil
+}
+
+// PinMessage pins a message of the conversation between username and contact
+// and returns the conversation's pins
+func (ms *MemoryService) PinMessage(ctx context.Context, username, contact, messageID string) ([]Pin, error) {
+	if err := ms.checkMessage(username, MessageRef{ID: messageID, With: contact}); err != nil {
+		return nil, err
+	}
+
+	key := getChatKey(username, contact)
+	ms.mu.Lock()
+	pinned := slices.ContainsFunc(ms.pins[key], func(pin storedPin) bool { return pin.messageID == messageID })
+	if !pinned {
+		if len(ms.pins[key]) >= MaxPinnedMessages {
+			ms.mu.Unlock()
+			return nil, apperrors.NewValidationError(fmt.Sprintf("A conversation can have at most %d pinned messages", MaxPinnedMessages))
+		}
+		ms.pins[key] = slices.Insert(ms.pins[key], 0, storedPin{messageID: messageID, pinnedBy: username, pinnedAt: time.Now()})
+	}
+	ms.mu.Unlock()
+
+	if !pinned {
+		ms.publish(ctx, pinEvent(username, contact, messageID, EventPinned), UserChannel(contact), UserChannel(username))
+	}
+	return ms.GetPinnedMessages(ctx, username, contact)
+}
+
+// UnpinMessage unpins a message of the conversation between username and
+// contact and returns the conversation's pins
+func (ms *MemoryService) UnpinMessage(ctx context.Context, username, contact, messageID string) ([]Pin, error) {
+	if messageID == "" {
+		return nil, apperrors.NewBadRequest("Message ID required")
+	}
+
+	key := getChatKey(username, contact)
+	ms.mu.Lock()
+	before := len(ms.pins[key])
+	ms.pins[key] = slices.DeleteFunc(ms.pins[key], func(pin storedPin) bool { return pin.messageID == messageID })
+	unpinned := len(ms.pins[key]) < before
+	ms.mu.Unlock()
+
+	if unpinned {
+		ms.publish(ctx, pinEvent(username, contact, messageID, EventUnpinned), UserChannel(contact), UserChannel(username))
+	}
+	return ms.GetPinnedMessages(ctx, username, contact)
+}
+
+// GetPinnedMessages returns the pins of the conversation between username and
+// contact, most recently pinned first
+func (ms *MemoryService) GetPinnedMessages(ctx context.Context, username, contact string) ([]Pin, error) {
+	if contact == "" || contact == username {
+		return nil, apperrors.NewBadRequest("A contact is required")
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	key := getChatKey(username, contact)
+	pins := make([]Pin, 0, len(ms.pins[key]))
+	for _, pin := range ms.pins[key] {
+		stored := ms.find(key, pin.messageID)
+		if stored == nil || stored.msg.Deleted {
+			continue
+		}
+		msg := stored.msg
+		pins = append(pins, Pin{Message: &msg, PinnedBy: pin.pinnedBy, PinnedAt: pin.pinnedAt})
+	}
+	return pins, nil
+}
+
+// AdmitMessage admits every message: message requests are not kept in memory
+func (ms *MemoryService) AdmitMessage(ctx context.Context, from, to string) error {
+	return nil
+}
+
+// ListMessageRequests returns no requests, as none are kept in memory
+func (ms *MemoryService) ListMessageRequests(ctx context.Context, username string) ([]MessageRequest, error) {
+	return []MessageRequest{}, nil
+}
+
+// AcceptMessageRequest fails, as there are no requests to answer
+func (ms *MemoryService) AcceptMessageRequest(ctx context.Context, username, sender string) error {
+	return apperrors.New(apperrors.ErrCodeNotFound, "No message request from "+sender, http.StatusNotFound)
+}
+
+// DeclineMessageRequest fails, as there are no requests to answer
+func (ms *MemoryService) DeclineMessageRequest(ctx context.Context, username, sender string) error {
+	return apperrors.New(apperrors.ErrCodeNotFound, "No message request from "+sender, http.StatusNotFound)
+}
+
+// BufferDepth is always zero: messages are stored as they are sent
+func (ms *MemoryService) BufferDepth() int {
+	return 0
+}
+
+// MessagesAccepted returns the running total of messages stored
+func (ms *MemoryService) MessagesAccepted() int64 {
+	return ms.accepted.Load()
+}
+
+// GetMetrics returns the message counts in the shape ChatService reports them
+func (ms *MemoryService) GetMetrics() map[string]any {
+	ms.mu.Lock()
+	conversations := len(ms.conversations)
+	ms.mu.Unlock()
+
+	accepted := ms.accepted.Load()
+	return map[string]any{
+		"backend": "memory",
+		"messages": map[string]int64{
+			"queued":  accepted,
+			"sent":    accepted,
+			"failed":  0,
+			"dropped": 0,
+		},
+		"conversations": conversations,
+	}
+}
+
+// SetLimits replaces the message limits. Call it before serving traffic.
+func (ms *MemoryService) SetLimits(limits Limits) {
+	ms.limits = limits
+}
+
+// SetActivityTracker records conversation changes for contact list deltas
+func (ms *MemoryService) SetActivityTracker(tracker *activity.Tracker) {
+	ms.activity = tracker
+}
+
+// SetMutes stops muted conversations from counting as unread
+func (ms *MemoryService) SetMutes(mutes *notifications.Service) {
+	ms.mutes = mutes
+}
+
+// SetNotifications notifies group members mentioned with @username
+func (ms *MemoryService) SetNotifications(inbox *notifications.NotificationService) {
+	ms.inbox = inbox
+}
+
+// SetEventLog records what happens in conversations
+func (ms *MemoryService) SetEventLog(log *events.Log) {
+	ms.events = log
+}
+
+// SetPolicy enforces moderation restrictions on senders and mutes reported senders
+func (ms *MemoryService) SetPolicy(policy *moderation.Policy) {
+	ms.policy = policy
+}
+
+// AddMessageHook registers a hook for messages sent through this service
+func (ms *MemoryService) AddMessageHook(hook MessageHook) {
+	ms.hooksMu.Lock()
+	defer ms.hooksMu.Unlock()
+	ms.hooks = append(ms.hooks, hook)
+}
+
+func (ms *MemoryService) runHooks(msg *ChatMessage) {
+	ms.hooksMu.RLock()
+	defer ms.hooksMu.RUnlock()
+
+	for _, hook := range ms.hooks {
+		hook(msg)
+	}
+}
+
+// Close does nothing; what is in memory is lost with the process
+func (ms *MemoryService) Close() error {
+	return nil
+}
+
+// store gives msg the conversation's next sequence number and appends a
+// copy of it, dropping the oldest messages beyond MemoryHistorySize. Callers
+// hold mu.
+func (ms *MemoryService) store(key string, msg *ChatMessage) {
+	if msg.Seq == 0 {
+		ms.seqs[key]++
+		msg.Seq = ms.seqs[key]
+	}
+
+	messages := append(ms.conversations[key], &storedMessage{msg: *msg, createdAt: time.Now()})
+	if len(messages) > MemoryHistorySize {
+		messages = slices.Clone(messages[len(messages)-MemoryHistorySize:])
+	}
+	ms.conversations[key] = messages
+}
+
+// find returns a message of a conversation, or nil. Callers hold mu.
+func (ms *MemoryService) find(key, messageID string) *storedMessage {
+	for _, stored := range ms.conversations[key] {
+		if stored.msg.MessageID == messageID {
+			return stored
+		}
+	}
+	return nil
+}
+
+// countUnread counts the messages of a conversation others sent after the
+// reader's read position, leaving out deleted ones. Callers hold mu.
+func (ms *MemoryService) countUnread(username, key, field string) int {
+	var read int64
+	if pos := ms.receipts[username][field]; pos != nil {
+		read = pos.read
+	}
+
+	count := 0
+	for _, stored := range ms.conversations[key] {
+		if stored.msg.Timestamp > read && stored.msg.FromID != username && !stored.msg.Deleted {
+			count++
+		}
+	}
+	return count
+}
+
+// applyReceipts fills in DeliveredAt and ReadAt of the messages username
+// sent peer from peer's positions. Callers hold mu.
+func (ms *MemoryService) applyReceipts(username, peer string, messages []*ChatMessage) {
+	pos := ms.receipts[peer][receiptField(username, "")]
+	if pos == nil || peer == username {
+		return
+	}
+
+	for _, msg := range messages {
+		if msg.FromID != username {
+			continue
+		}
+		if msg.Timestamp <= pos.delivered {
+			msg.DeliveredAt = pos.deliveredAt
+		}
+		if msg.Timestamp <= pos.read {
+			msg.ReadAt = pos.readAt
+		}
+	}
+}
+
+// track records the recipients of a tracked group message and forgets
+// tracked messages that expired. Callers hold mu.
+func (ms *MemoryService) track(msg *ChatMessage) {
+	since := trackedSince()
+	for id, t := range ms.tracked {
+		if t.timestamp < since {
+			delete(ms.tracked, id)
+		}
+	}
+
+	ms.tracked[msg.MessageID] = &storedTracking{
+		groupID:    msg.GroupID,
+		from:       msg.FromID,
+		timestamp:  msg.Timestamp,
+		recipients: slices.Clone(msg.recipients),
+		delivered:  make(map[string]int64),
+		read:       make(map[string]int64),
+	}
+}
+
+// trackedIn returns a tracked message of the group that has not expired, or
+// nil. Callers hold mu.
+func (ms *MemoryService) trackedIn(groupID, messageID string) *storedTracking {
+	t := ms.tracked[messageID]
+	if t == nil || t.groupID != groupID || t.timestamp < trackedSince() {
+		return nil
+	}
+	return t
+}
+
+// publish relays an event on channels for live delivery, when there is Redis
+// to relay it through. Failures are only logged.
+func (ms *MemoryService) publish(ctx context.Context, event *ChatMessage, channels ...string) {
+	if ms.rdb == nil {
+		return
+	}
+
+	eventJSON, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+
+	pipe := ms.rdb.Pipeline()
+	for _, channel := range channels {
+		pipe.Publish(ctx, channel, eventJSON)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		logger.WithFields(map[string]any{
+			"message_id": event.MessageID,
+			"event":      event.Event,
+			"error":      err.Error(),
+		}).Warn("Failed to publish message event")
+	}
+}
+
+// record sets the delivery, and read if read is set, time of username if they
+// are a recipient. Only the first time is kept.
+func (t *storedTracking) record(username string, read bool) {
+	if !slices.Contains(t.recipients, username) {
+		return
+	}
+
+	now := time.Now().Unix()
+	if t.delivered[username] == 0 {
+		t.delivered[username] = now
+	}
+	if read && t.read[username] == 0 {
+		t.read[username] = now
+	}
+}
+
+// summary returns the counts of a tracked message
+func (t *storedTracking) summary(messageID string) *TrackedMessage {
+	return &TrackedMessage{
+		MessageID:  messageID,
+		GroupID:    t.groupID,
+		From:       t.from,
+		Timestamp:  t.timestamp,
+		Recipients: int64(len(t.recipients)),
+		Delivered:  int64(len(t.delivered)),
+		Read:       int64(len(t.read)),
+	}
+}
+
+// storedCursor orders messages like history pages from Postgres
+func storedCursor(stored *storedMessage) pagination.Cursor {
+	return pagination.Cursor{Key: pagination.TimeKey(stored.createdAt), ID: stored.msg.MessageID}
+}
+
+// copyMessages returns copies of stored messages, so callers can fill them in
+func copyMessages(stored []*storedMessage) []*ChatMessage {
+	messages := make([]*ChatMessage, len(stored))
+	for i, s := range stored {
+		msg := s.msg
+		messages[i] = &msg
+	}
+	return messages
+}
+
+// memoryKey names the conversation ref belongs to as seen by username
+func memoryKey(username string, ref MessageRef) string {
+	if ref.GroupID != "" {
+		return groupKey(ref.GroupID)
+	}
+	return getChatKey(username, ref.With)
+}
+
+func groupKey(groupID string) string {
+	return "group:" + groupID
+}
@@ -0,0 +1,187 @@
+package chat
+
+import (
+	"context"
+	"exc6/pkg/pagination"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryServiceMessages(t *testing.T) {
+	ctx := context.Background()
+	ms := NewMemoryService(nil)
+
+	var hooked []string
+	ms.AddMessageHook(func(msg *ChatMessage) { hooked = append(hooked, msg.Content) })
+
+	first, err := ms.SendMessage(ctx, "alice", "bob", "hello")
+	require.NoError(t, err)
+	_, err = ms.SendMessage(ctx, "alice", "bob", "are you there?")
+	require.NoError(t, err)
+	_, err = ms.SendMessage(ctx, "bob", "alice", "yes")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"hello", "are you there?", "yes"}, hooked)
+	assert.Equal(t, int64(3), ms.MessagesAccepted())
+
+	history, err := ms.GetHistory(ctx, "bob", "alice")
+	require.NoError(t, err)
+	assert.False(t, history.Degraded)
+	require.Len(t, history.Value, 3)
+	assert.Equal(t, first.MessageID, history.Value[0].MessageID)
+
+	unread, err := ms.GetUnreadMessages(ctx, "bob")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"alice": 2}, unread)
+
+	require.NoError(t, ms.MarkConversationRead(ctx, "bob", "alice"))
+	unread, err = ms.GetUnreadMessages(ctx, "bob")
+	require.NoError(t, err)
+	assert.Empty(t, unread)
+
+	// The sender sees the receipt on their messages
+	history, err = ms.GetHistory(ctx, "alice", "bob")
+	require.NoError(t, err)
+	assert.NotZero(t, history.Value[0].ReadAt)
+	assert.Zero(t, history.Value[2].ReadAt)
+
+	_, err = ms.SendMessage(ctx, "alice", "bob", string(make([]rune, DefaultMaxLength+1)))
+	assert.Error(t, err)
+}
+
+func TestMemoryServiceHistoryPages(t *testing.T) {
+	ctx := context.Background()
+	ms := NewMemoryService(nil)
+
+	for i := range 5 {
+		_, err := ms.SendMessage(ctx, "alice", "bob", fmt.Sprintf("message %d", i))
+		require.NoError(t, err)
+	}
+
+	page, err := ms.GetHistoryPage(ctx, "alice", "bob", pagination.Params{Limit: 3})
+	require.NoError(t, err)
+	require.Len(t, page.Items, 3)
+	assert.True(t, page.HasMore)
+	assert.Equal(t, "message 4", page.Items[0].Content)
+
+	cursor, err := pagination.Decode(page.NextCursor)
+	require.NoError(t, err)
+	page, err = ms.GetHistoryPage(ctx, "alice", "bob", pagination.Params{Limit: 3, After: cursor})
+	require.NoError(t, err)
+	require.Len(t, page.Items, 2)
+	assert.False(t, page.HasMore)
+	assert.Equal(t, "message 1", page.Items[0].Content)
+
+	results, err := ms.SearchConversation(ctx, "bob", "alice", "MESSAGE 3", pagination.Params{})
+	require.NoError(t, err)
+	require.Len(t, results.Items, 1)
+	assert.Equal(t, []Match{{Start: 0, End: 9}}, results.Items[0].Matches)
+}
+
+func TestMemoryServiceGroups(t *testing.T) {
+	ctx := context.Background()
+	ms := NewMemoryService(nil)
+
+	msg, err := ms.SendGroupMessage(ctx, "alice", "g1", "standup at 10", WithTracking([]string{"bob", "carol"}))
+	require.NoError(t, err)
+	_, err = ms.SendGroupMessage(ctx, "bob", "g1", "ok")
+	require.NoError(t, err)
+
+	history, err := ms.GetGroupHistory(ctx, "g1")
+	require.NoError(t, err)
+	assert.Len(t, history, 2)
+
+	unread, err := ms.GetGroupUnread(ctx, "carol", []string{"g1", "g2"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"g1": 2}, unread)
+
+	require.NoError(t, ms.MarkTrackedDelivered(ctx, "bob", "g1", msg.MessageID))
+	require.NoError(t, ms.MarkTrackedRead(ctx, "carol", "g1", msg.MessageID, 0))
+	require.NoError(t, ms.MarkGroupRead(ctx, "carol", "g1"))
+
+	unread, err = ms.GetGroupUnread(ctx, "carol", []string{"g1"})
+	require.NoError(t, err)
+	assert.Empty(t, unread)
+
+	receipts, err := ms.TrackedReceipts(ctx, "g1", msg.MessageID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), receipts.Recipients)
+	assert.Equal(t, int64(2), receipts.Delivered)
+	assert.Equal(t, int64(1), receipts.Read)
+
+	_, err = ms.TrackedReceipts(ctx, "g2", msg.MessageID)
+	assert.Error(t, err)
+
+	tracked, err := ms.TrackedMessages(ctx, "g1", 10)
+	require.NoError(t, err)
+	require.Len(t, tracked, 1)
+	assert.Equal(t, msg.MessageID, tracked[0].MessageID)
+}
+
+func TestMemoryServiceChanges(t *testing.T) {
+	ctx := context.Background()
+	ms := NewMemoryService(nil)
+
+	msg, err := ms.SendMessage(ctx, "alice", "bob", "hello")
+	require.NoError(t, err)
+	ref := MessageRef{ID: msg.MessageID, With: "bob"}
+
+	// Only the sender can change a message
+	_, err = ms.EditMessage(ctx, "bob", MessageRef{ID: msg.MessageID, With: "alice"}, "hi")
+	assert.Error(t, err)
+
+	edited, err := ms.EditMessage(ctx, "alice", ref, "hello there")
+	require.NoError(t, err)
+	assert.Equal(t, "hello there", edited.Content)
+	assert.NotZero(t, edited.EditedAt)
+
+	reactions, err := ms.AddReaction(ctx, "bob", MessageRef{ID: msg.MessageID, With: "alice"}, "👍")
+	require.NoError(t, err)
+	assert.Equal(t, []Reaction{{Emoji: "👍", Users: []string{"bob"}}}, reactions)
+
+	reactions, err = ms.RemoveReaction(ctx, "bob", MessageRef{ID: msg.MessageID, With: "alice"}, "👍")
+	require.NoError(t, err)
+	assert.Empty(t, reactions)
+
+	pins, err := ms.PinMessage(ctx, "bob", "alice", msg.MessageID)
+	require.NoError(t, err)
+	require.Len(t, pins, 1)
+	assert.Equal(t, "bob", pins[0].PinnedBy)
+	assert.Equal(t, "hello there", pins[0].Message.Content)
+
+	// Deleting a message unpins it
+	deleted, err := ms.DeleteMessage(ctx, "alice", ref)
+	require.NoError(t, err)
+	assert.True(t, deleted.Deleted)
+	assert.Empty(t, deleted.Content)
+
+	pins, err = ms.GetPinnedMessages(ctx, "alice", "bob")
+	require.NoError(t, err)
+	assert.Empty(t, pins)
+
+	_, err = ms.AddReaction(ctx, "bob", MessageRef{ID: msg.MessageID, With: "alice"}, "👍")
+	assert.Error(t, err)
+	_, err = ms.DeleteMessage(ctx, "alice", ref)
+	assert.Error(t, err)
+}
+
+func TestMemoryServiceHistoryLimit(t *testing.T) {
+	ctx := context.Background()
+	ms := NewMemoryService(nil)
+
+	first, err := ms.SendMessage(ctx, "alice", "bob", "first")
+	require.NoError(t, err)
+	for range MemoryHistorySize {
+		_, err := ms.SendMessage(ctx, "alice", "bob", "more")
+		require.NoError(t, err)
+	}
+
+	history, err := ms.GetHistory(ctx, "alice", "bob")
+	require.NoError(t, err)
+	assert.Len(t, history.Value, RecentMessagesCacheSize)
+
+	_, err = ms.EditMessage(ctx, "alice", MessageRef{ID: first.MessageID, With: "bob"}, "changed")
+	assert.Error(t, err)
+}
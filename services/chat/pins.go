@@ -0,0 +1,167 @@
+package chat
+
+import (
+	"context"
+	"exc6/apperrors"
+	"exc6/db"
+	"fmt"
+	"time"
+)
+
+// Either participant of a direct conversation can pin up to MaxPinnedMessages
+// of its messages for both of them. Pins are kept in Postgres with the
+// conversation's pair of users, and every change is published to both
+// participants as an event so open clients update their pinned list. Deleting
+// a pinned message unpins it.
+
+// Pin events carried by ChatMessage.Event
+const (
+	EventPinned   = "pinned"
+	EventUnpinned = "unpinned"
+)
+
+// MaxPinnedMessages is how many messages a direct conversation can have pinned
+const MaxPinnedMessages = 10
+
+// Pin is a pinned message and who pinned it
+type Pin struct {
+	Message  *ChatMessage `json:"message"`
+	PinnedBy string       `json:"pinned_by"`
+	PinnedAt time.Time    `json:"pinned_at"`
+}
+
+// PinMessage pins a message of the conversation between username and contact
+// and returns the conversation's pins. Pinning a pinned message changes
+// nothing.
+func (cs *ChatService) PinMessage(ctx context.Context, username, contact, messageID string) ([]Pin, error) {
+	if err := cs.checkMessage(ctx, username, MessageRef{ID: messageID, With: contact}); err != nil {
+		return nil, err
+	}
+
+	rows, err := cs.qdb.PinMessage(ctx, db.PinMessageParams{
+		Username:  username,
+		Contact:   contact,
+		MessageID: messageID,
+		MaxPins:   MaxPinnedMessages,
+	})
+	if err != nil {
+		return nil, apperrors.NewDatabaseError("pin message", err).WithDetails("message_id", messageID)
+	}
+
+	pins, err := cs.GetPinnedMessages(ctx, username, contact)
+	if err != nil {
+		return nil, err
+	}
+
+	if rows == 0 {
+		for _, pin := range pins {
+			if pin.Message.MessageID == messageID {
+				return pins, nil
+			}
+		}
+		return nil, apperrors.NewValidationError(fmt.Sprintf("A conversation can have at most %d pinned messages", MaxPinnedMessages))
+	}
+
+	cs.announcePin(ctx, username, contact, messageID, EventPinned)
+	return pins, nil
+}
+
+// UnpinMessage unpins a message of the conversation between username and
+// contact and returns the conversation's pins
+func (cs *ChatService) UnpinMessage(ctx context.Context, username, contact, messageID string) ([]Pin, error) {
+	if messageID == "" {
+		return nil, apperrors.NewBadRequest("Message ID required")
+	}
+
+	rows, err := cs.qdb.UnpinMessage(ctx, db.UnpinMessageParams{
+		MessageID: messageID,
+		Username:  username,
+		Contact:   contact,
+	})
+	if err != nil {
+		return nil, apperrors.NewDatabaseError("unpin message", err).WithDetails("message_id", messageID)
+	}
+
+	if rows > 0 {
+		cs.announcePin(ctx, username, contact, messageID, EventUnpinned)
+	}
+	return cs.GetPinnedMessages(ctx, username, contact)
+}
+
+// GetPinnedMessages returns the pins of the conversation between username and
+// contact, most recently pinned first
+func (cs *ChatService) GetPinnedMessages(ctx context.Context, username, contact string) ([]Pin, error) {
+	if contact == "" || contact == username {
+		return nil, apperrors.NewBadRequest("A contact is required")
+	}
+
+	rows, err := cs.qdb.GetPinnedMessages(ctx, db.GetPinnedMessagesParams{
+		Username: username,
+		Contact:  contact,
+	})
+	if err != nil {
+		return nil, apperrors.NewDatabaseError("get pinned messages", err).
+			WithDetails("username", username).
+			WithDetails("contact", contact)
+	}
+
+	pins := make([]Pin, 0, len(rows))
+	for _, row := range rows {
+		pin := pinOf(row, username, contact)
+		if pin.Message == nil {
+			// Not stored yet, so it is still in the cached history
+			_, cached, err := cs.findCached(ctx, cs.GetConversationKey(username, contact), row.MessageID)
+			if err != nil {
+				return nil, err
+			}
+			if cached == nil || cached.Deleted {
+				continue
+			}
+			pin.Message = cached
+		}
+		pins = append(pins, pin)
+	}
+	return pins, nil
+}
+
+// announcePin tells both participants that username changed a pin
+func (cs *ChatService) announcePin(ctx context.Context, username, contact, messageID, event string) {
+	cs.publishEvent(ctx, pinEvent(username, contact, messageID, event))
+}
+
+// pinEvent is the event telling both participants that username changed a pin
+func pinEvent(username, contact, messageID, event string) *ChatMessage {
+	return &ChatMessage{
+		MessageID: messageID,
+		FromID:    username,
+		ToID:      contact,
+		Timestamp: time.Now().Unix(),
+		Event:     event,
+	}
+}
+
+// pinOf converts a stored pin of the conversation between username and
+// contact, leaving Message nil when the message is not stored yet
+func pinOf(row db.GetPinnedMessagesRow, username, contact string) Pin {
+	pin := Pin{PinnedBy: row.PinnedBy, PinnedAt: row.PinnedAt}
+	if !row.CreatedAt.Valid {
+		return pin
+	}
+
+	to := contact
+	if row.FromUsername.String == contact {
+		to = username
+	}
+	pin.Message = &ChatMessage{
+		MessageID: row.MessageID,
+		FromID:    row.FromUsername.String,
+		ToID:      to,
+		Content:   row.Content.String,
+		Subtype:   row.Subtype.String,
+		Timestamp: row.CreatedAt.Time.Unix(),
+	}
+	if row.EditedAt.Valid {
+		pin.Message.EditedAt = row.EditedAt.Time.Unix()
+	}
+	return pin
+}
@@ -0,0 +1,44 @@
+package chat
+
+import (
+	"database/sql"
+	"exc6/db"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPinOf(t *testing.T) {
+	pinnedAt := time.Unix(1700000100, 0)
+	createdAt := time.Unix(1700000000, 0)
+
+	t.Run("Stored message", func(t *testing.T) {
+		pin := pinOf(db.GetPinnedMessagesRow{
+			MessageID:    "m1",
+			PinnedBy:     "alice",
+			PinnedAt:     pinnedAt,
+			Content:      sql.NullString{String: "hello", Valid: true},
+			Subtype:      sql.NullString{Valid: true},
+			CreatedAt:    sql.NullTime{Time: createdAt, Valid: true},
+			EditedAt:     sql.NullTime{Time: pinnedAt, Valid: true},
+			FromUsername: sql.NullString{String: "bob", Valid: true},
+		}, "alice", "bob")
+
+		assert.Equal(t, "alice", pin.PinnedBy)
+		assert.Equal(t, pinnedAt, pin.PinnedAt)
+		require.NotNil(t, pin.Message)
+		assert.Equal(t, "bob", pin.Message.FromID)
+		assert.Equal(t, "alice", pin.Message.ToID)
+		assert.Equal(t, "hello", pin.Message.Content)
+		assert.Equal(t, createdAt.Unix(), pin.Message.Timestamp)
+		assert.Equal(t, pinnedAt.Unix(), pin.Message.EditedAt)
+	})
+
+	t.Run("Message not stored yet", func(t *testing.T) {
+		pin := pinOf(db.GetPinnedMessagesRow{MessageID: "m2", PinnedBy: "bob", PinnedAt: pinnedAt}, "alice", "bob")
+		assert.Nil(t, pin.Message)
+		assert.Equal(t, "bob", pin.PinnedBy)
+	})
+}
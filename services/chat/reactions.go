@@ -0,0 +1,388 @@
+package chat
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"exc6/apperrors"
+	"exc6/db"
+	"exc6/pkg/breaker"
+	"exc6/pkg/logger"
+	"exc6/services/events"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Participants of a conversation can react to its messages with emoji. The
+// reactions of a message are a set in Redis of reactor and emoji pairs, which
+// changes atomically and expires with the cached history. Changed messages
+// are marked and a flusher on every instance writes their sets to Postgres
+// every ReactionFlushInterval; a set that expired is loaded from there again
+// when it is next read. Every change is published to the conversation as an
+// event carrying the message's reactions, so clients do not need to count.
+
+// Reaction events carried by ChatMessage.Event
+const (
+	EventReactionAdded   = "reaction_added"
+	EventReactionRemoved = "reaction_removed"
+)
+
+const (
+	reactionsPrefix = "chat:reactions:"
+
+	// ReactionsDirtyKey holds the IDs of messages whose reactions changed
+	// since they were last flushed
+	ReactionsDirtyKey = "chat:reactions_dirty"
+
+	// ReactionTTL is how long the reactions of a message stay in Redis after
+	// their last change
+	ReactionTTL = MessageCacheTTL
+
+	// ReactionFlushInterval is how often changed reactions are written to
+	// Postgres
+	ReactionFlushInterval = 10 * time.Second
+
+	// MaxReactionsPerUser is how many different emoji one user can add to a
+	// message
+	MaxReactionsPerUser = 20
+
+	reactionFlushBatch = 100
+	maxReactionLength  = 32
+
+	// reactionSeparator joins reactor and emoji in a set member; usernames
+	// cannot contain it
+	reactionSeparator = "|"
+)
+
+// customEmojiPattern matches a custom emoji shortcode
+var customEmojiPattern = regexp.MustCompile(`^:[a-z0-9_+-]{2,32}:$`)
+
+// Reaction is an emoji and who reacted with it
+type Reaction struct {
+	Emoji string   `json:"emoji"`
+	Users []string `json:"users"`
+}
+
+// AddReaction reacts to a message with emoji on behalf of username and
+// returns the message's reactions. Group membership is the caller's to check.
+func (cs *ChatService) AddReaction(ctx context.Context, username string, ref MessageRef, emoji string) ([]Reaction, error) {
+	return cs.react(ctx, username, ref, emoji, true)
+}
+
+// RemoveReaction withdraws a reaction of username and returns the message's
+// reactions
+func (cs *ChatService) RemoveReaction(ctx context.Context, username string, ref MessageRef, emoji string) ([]Reaction, error) {
+	return cs.react(ctx, username, ref, emoji, false)
+}
+
+// GetReactions returns the reactions of a message username can see
+func (cs *ChatService) GetReactions(ctx context.Context, username string, ref MessageRef) ([]Reaction, error) {
+	if err := cs.checkMessage(ctx, username, ref); err != nil {
+		return nil, err
+	}
+
+	members, err := cs.loadReactions(ctx, ref.ID)
+	if err != nil {
+		return nil, err
+	}
+	return summarizeReactions(members), nil
+}
+
+func (cs *ChatService) react(ctx context.Context, username string, ref MessageRef, emoji string, add bool) ([]Reaction, error) {
+	if !validReaction(emoji) {
+		return nil, apperrors.NewValidationError("Reactions must be a single emoji or custom emoji shortcode")
+	}
+	if err := cs.checkMessage(ctx, username, ref); err != nil {
+		return nil, err
+	}
+
+	key := reactionsPrefix + ref.ID
+	adding := ""
+	event := EventReactionRemoved
+	if add {
+		adding = "1"
+		event = EventReactionAdded
+	}
+
+	var changed int64
+	for attempt := 0; attempt < 2; attempt++ {
+		if _, err := cs.loadReactions(ctx, ref.ID); err != nil {
+			return nil, err
+		}
+
+		result, err := breaker.ExecuteCtx(ctx, cs.cbRedis, func() (any, error) {
+			return reactionScript.Run(ctx, cs.rdb,
+				[]string{key, ReactionsDirtyKey},
+				username+reactionSeparator+emoji, username+reactionSeparator, MaxReactionsPerUser,
+				int64(ReactionTTL/time.Second), ref.ID, adding,
+			).Int64()
+		})
+		if err != nil {
+			return nil, apperrors.NewCacheError("reaction_update", key, err)
+		}
+
+		// The set expired between loading and changing it
+		if changed, _ = result.(int64); changed != -2 {
+			break
+		}
+	}
+
+	switch changed {
+	case -2:
+		return nil, apperrors.NewCacheError("reaction_update", key, fmt.Errorf("reactions of %s kept expiring", ref.ID))
+	case -1:
+		return nil, apperrors.NewValidationError(fmt.Sprintf("You cannot add more than %d reactions to a message", MaxReactionsPerUser))
+	}
+
+	members, err := cs.loadReactions(ctx, ref.ID)
+	if err != nil {
+		return nil, err
+	}
+	reactions := summarizeReactions(members)
+
+	if changed == 1 {
+		change := &ChatMessage{
+			MessageID: ref.ID,
+			FromID:    username,
+			ToID:      ref.With,
+			GroupID:   ref.GroupID,
+			IsGroup:   ref.GroupID != "",
+			Content:   emoji,
+			Timestamp: time.Now().Unix(),
+			Event:     event,
+			Reactions: reactions,
+		}
+		cs.publishEvent(ctx, change)
+		cs.logEvent(ctx, events.TypeReaction, change)
+	}
+
+	return reactions, nil
+}
+
+// checkMessage confirms that a message exists in the conversation ref
+// names as seen by username and was not deleted
+func (cs *ChatService) checkMessage(ctx context.Context, username string, ref MessageRef) error {
+	if ref.ID == "" {
+		return apperrors.NewBadRequest("Message ID required")
+	}
+	if (ref.With == "") == (ref.GroupID == "") || ref.With == username {
+		return apperrors.NewBadRequest("Either a contact or a group is required")
+	}
+
+	// The cache holds the conversation's messages only
+	_, cached, err := cs.findCached(ctx, cs.cacheKey(username, ref), ref.ID)
+	if err != nil {
+		return err
+	}
+	if cached != nil {
+		if cached.Deleted {
+			return apperrors.New(apperrors.ErrCodeNotFound, "Message was deleted", http.StatusNotFound)
+		}
+		return nil
+	}
+
+	row, err := cs.qdb.GetMessageParticipants(ctx, ref.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return apperrors.New(apperrors.ErrCodeNotFound, "Message not found", http.StatusNotFound)
+	}
+	if err != nil {
+		return apperrors.NewDatabaseError("get message", err).WithDetails("message_id", ref.ID)
+	}
+
+	inConversation := row.GroupID.Valid && row.GroupID.UUID.String() == ref.GroupID
+	if ref.GroupID == "" {
+		inConversation = !row.GroupID.Valid &&
+			(row.FromUsername == username && row.ToUsername == ref.With ||
+				row.FromUsername == ref.With && row.ToUsername == username)
+	}
+	if !inConversation {
+		return apperrors.New(apperrors.ErrCodeNotFound, "Message not found", http.StatusNotFound)
+	}
+	if row.Deleted {
+		return apperrors.New(apperrors.ErrCodeNotFound, "Message was deleted", http.StatusNotFound)
+	}
+	return nil
+}
+
+// loadReactions returns the members of a message's reaction set, loading it
+// from Postgres first when it is not in Redis
+func (cs *ChatService) loadReactions(ctx context.Context, messageID string) ([]string, error) {
+	key := reactionsPrefix + messageID
+
+	result, err := breaker.ExecuteCtx(ctx, cs.cbRedis, func() (any, error) {
+		return cs.rdb.SMembers(ctx, key).Result()
+	})
+	if err != nil {
+		return nil, apperrors.NewCacheError("reactions_get", key, err)
+	}
+	if members, _ := result.([]string); len(members) > 0 {
+		return members, nil
+	}
+
+	rows, err := cs.qdb.GetMessageReactions(ctx, messageID)
+	if err != nil {
+		return nil, apperrors.NewDatabaseError("get reactions", err).WithDetails("message_id", messageID)
+	}
+
+	// The empty member marks the set as loaded
+	members := []string{""}
+	for _, row := range rows {
+		members = append(members, row.Username+reactionSeparator+row.Emoji)
+	}
+
+	if _, err := breaker.ExecuteCtx(ctx, cs.cbRedis, func() (any, error) {
+		pipe := cs.rdb.TxPipeline()
+		pipe.SAdd(ctx, key, toAny(members)...)
+		pipe.Expire(ctx, key, ReactionTTL)
+		_, err := pipe.Exec(ctx)
+		return nil, err
+	}); err != nil {
+		return nil, apperrors.NewCacheError("reactions_load", key, err)
+	}
+	return members, nil
+}
+
+// reactionFlusher writes changed reactions to Postgres until shutdown
+func (cs *ChatService) reactionFlusher() {
+	defer cs.wg.Done()
+
+	ticker := time.NewTicker(ReactionFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			cs.flushReactions(cs.ctx)
+		case <-cs.shutdownChan:
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			cs.flushReactions(ctx)
+			cancel()
+			return
+		}
+	}
+}
+
+// flushReactions writes the reactions of the messages marked as changed. A
+// message that fails is marked again for the next round.
+func (cs *ChatService) flushReactions(ctx context.Context) {
+	for {
+		result, err := breaker.ExecuteCtx(ctx, cs.cbRedis, func() (any, error) {
+			return cs.rdb.SPopN(ctx, ReactionsDirtyKey, reactionFlushBatch).Result()
+		})
+		if err != nil {
+			logger.WithError(err).Warn("Failed to read reactions to flush")
+			return
+		}
+
+		messageIDs, _ := result.([]string)
+		for i, messageID := range messageIDs {
+			if err := cs.flushMessageReactions(ctx, messageID); err != nil {
+				logger.WithFields(map[string]any{
+					"message_id": messageID,
+					"error":      err.Error(),
+				}).Warn("Failed to flush reactions")
+
+				if err := cs.rdb.SAdd(ctx, ReactionsDirtyKey, toAny(messageIDs[i:])...).Err(); err != nil {
+					logger.WithError(err).Error("Failed to requeue reactions to flush")
+				}
+				return
+			}
+		}
+
+		if len(messageIDs) < reactionFlushBatch {
+			return
+		}
+	}
+}
+
+// flushMessageReactions replaces the stored reactions of a message with its
+// set in Redis. A set that expired in the meantime was flushed before it did.
+func (cs *ChatService) flushMessageReactions(ctx context.Context, messageID string) error {
+	members, err := cs.rdb.SMembers(ctx, reactionsPrefix+messageID).Result()
+	if err != nil {
+		return err
+	}
+	if len(members) == 0 {
+		return nil
+	}
+
+	params := db.InsertMessageReactionsParams{MessageID: messageID}
+	for _, member := range members {
+		username, emoji, ok := strings.Cut(member, reactionSeparator)
+		if !ok {
+			continue
+		}
+		params.Usernames = append(params.Usernames, username)
+		params.Emojis = append(params.Emojis, emoji)
+	}
+
+	if err := cs.qdb.DeleteMessageReactions(ctx, messageID); err != nil {
+		return err
+	}
+	if len(params.Usernames) == 0 {
+		return nil
+	}
+	return cs.qdb.InsertMessageReactions(ctx, params)
+}
+
+// summarizeReactions groups the members of a reaction set by emoji, ordered
+// by emoji with the users of each in order
+func summarizeReactions(members []string) []Reaction {
+	users := make(map[string][]string)
+	for _, member := range members {
+		username, emoji, ok := strings.Cut(member, reactionSeparator)
+		if !ok {
+			continue
+		}
+		users[emoji] = append(users[emoji], username)
+	}
+
+	reactions := make([]Reaction, 0, len(users))
+	for emoji, names := range users {
+		sort.Strings(names)
+		reactions = append(reactions, Reaction{Emoji: emoji, Users: names})
+	}
+	sort.Slice(reactions, func(i, j int) bool { return reactions[i].Emoji < reactions[j].Emoji })
+	return reactions
+}
+
+// validReaction accepts one Unicode emoji, possibly a sequence joined with
+// modifiers, or a custom emoji shortcode
+func validReaction(emoji string) bool {
+	if customEmojiPattern.MatchString(emoji) {
+		return true
+	}
+	if emoji == "" || len(emoji) > maxReactionLength || !utf8.ValidString(emoji) {
+		return false
+	}
+
+	symbol := false
+	for _, r := range emoji {
+		if r < utf8.RuneSelf {
+			// Digits, # and * start keycap sequences
+			if !unicode.IsDigit(r) && r != '#' && r != '*' {
+				return false
+			}
+			continue
+		}
+		if unicode.IsLetter(r) || unicode.IsSpace(r) {
+			return false
+		}
+		symbol = true
+	}
+	return symbol
+}
+
+func toAny(values []string) []any {
+	args := make([]any, len(values))
+	for i, v := range values {
+		args[i] = v
+	}
+	return args
+}
@@ -0,0 +1,44 @@
+package chat
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidReaction(t *testing.T) {
+	tests := []struct {
+		name  string
+		emoji string
+		want  bool
+	}{
+		{name: "Emoji", emoji: "👍", want: true},
+		{name: "Skin tone", emoji: "👍🏽", want: true},
+		{name: "Joined sequence", emoji: "👩‍💻", want: true},
+		{name: "Keycap", emoji: "1️⃣", want: true},
+		{name: "Custom shortcode", emoji: ":party-parrot:", want: true},
+		{name: "Empty", emoji: ""},
+		{name: "Text", emoji: "lol"},
+		{name: "Text with emoji", emoji: "ok👍"},
+		{name: "Digits only", emoji: "42"},
+		{name: "Separator", emoji: "👍|"},
+		{name: "Unknown shortcode form", emoji: ":Party:"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, validReaction(tt.emoji))
+		})
+	}
+}
+
+func TestSummarizeReactions(t *testing.T) {
+	members := []string{"", "carol|👍", "alice|🎉", "alice|👍"}
+
+	assert.Equal(t, []Reaction{
+		{Emoji: "🎉", Users: []string{"alice"}},
+		{Emoji: "👍", Users: []string{"alice", "carol"}},
+	}, summarizeReactions(members))
+
+	assert.Empty(t, summarizeReactions([]string{""}))
+}
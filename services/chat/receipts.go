@@ -0,0 +1,270 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"exc6/apperrors"
+	"exc6/db"
+	"exc6/pkg/breaker"
+	"exc6/pkg/logger"
+	"exc6/services/events"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Receipts follow one model in direct and group conversations: each reader
+// has a delivered and a read position per conversation, the send time of the
+// newest message they received or read, and a receipt covers every message
+// up to its position. Positions only move forward. They are kept in a hash
+// per reader together with when each last moved, which is what DeliveredAt
+// and ReadAt report. Message times are in seconds, so a receipt also covers
+// messages sent in the same second as the one it names.
+//
+// Unread counts are derived from the read position and the cached history
+// rather than counted separately, so direct and group conversations agree
+// with what was read. A direct conversation whose cache has expired is
+// counted in Postgres. Group members are tracked only by read position; per
+// member delivery is recorded for messages sent with read receipts.
+//
+// When a position moves, the receipt is published to the conversation as an
+// event: to the other participant of a direct conversation, or to the group.
+
+// Receipt events carried by ChatMessage.Event
+const (
+	EventDelivered = "delivered"
+	EventRead      = "read"
+)
+
+const receiptsPrefix = "chat:receipts:"
+
+// MarkDelivered records that username received the direct messages sender
+// sent them up to timestamp, the send time of messageID. A zero timestamp
+// means everything sent so far.
+func (cs *ChatService) MarkDelivered(ctx context.Context, username, sender, messageID string, timestamp int64) error {
+	if sender == "" || sender == username {
+		return apperrors.NewBadRequest("Sender required")
+	}
+	return cs.markPosition(ctx, username, sender, "", messageID, timestamp, EventDelivered)
+}
+
+// MarkRead records that username read a conversation, with peer or in
+// groupID, up to timestamp, the send time of messageID. A zero timestamp
+// means everything sent so far. Reading implies delivery.
+func (cs *ChatService) MarkRead(ctx context.Context, username, peer, groupID, messageID string, timestamp int64) error {
+	if (peer == "") == (groupID == "") || peer == username {
+		return apperrors.NewBadRequest("Either a contact or a group is required")
+	}
+	return cs.markPosition(ctx, username, peer, groupID, messageID, timestamp, EventRead)
+}
+
+// markPosition moves a position and announces the receipt when it moved
+func (cs *ChatService) markPosition(ctx context.Context, username, peer, groupID, messageID string, timestamp int64, event string) error {
+	now := time.Now().Unix()
+
+	// Clients cannot acknowledge ahead of the clock
+	if timestamp <= 0 || timestamp > now {
+		timestamp = now
+	}
+
+	field := receiptField(peer, groupID)
+	reading := ""
+	if event == EventRead {
+		reading = "1"
+	}
+
+	result, err := breaker.ExecuteCtx(ctx, cs.cbRedis, func() (any, error) {
+		return advanceReceiptScript.Run(ctx, cs.rdb,
+			[]string{receiptsKey(username), unreadKey(username)},
+			field, timestamp, now, reading, int64(UnreadTTL/time.Second),
+		).Int64()
+	})
+	if err != nil {
+		logger.WithFields(map[string]any{
+			"username": username,
+			"peer":     peer,
+			"group_id": groupID,
+			"event":    event,
+			"error":    err.Error(),
+		}).Warn("Circuit breaker: Failed to record receipt")
+		return apperrors.NewCacheError("receipt_update", receiptsKey(username), err)
+	}
+
+	if moved, _ := result.(int64); moved == 1 {
+		receipt := &ChatMessage{
+			MessageID: messageID,
+			FromID:    username,
+			ToID:      peer,
+			GroupID:   groupID,
+			IsGroup:   groupID != "",
+			Timestamp: timestamp,
+			Event:     event,
+		}
+		if event == EventRead {
+			receipt.ReadAt = now
+		} else {
+			receipt.DeliveredAt = now
+		}
+		cs.publishReceipt(ctx, receipt)
+		if event == EventRead {
+			cs.logEvent(ctx, events.TypeRead, receipt)
+		}
+	}
+
+	if event == EventRead {
+		if groupID != "" {
+			cs.activity.TouchGroupRead(ctx, username, groupID)
+		} else {
+			cs.activity.TouchRead(ctx, username, peer)
+		}
+	}
+	return nil
+}
+
+// publishReceipt tells the conversation a position moved. Receipts are not
+// kept anywhere else, so a failure is only logged.
+func (cs *ChatService) publishReceipt(ctx context.Context, receipt *ChatMessage) {
+	channel := UserChannel(receipt.ToID)
+	if receipt.GroupID != "" {
+		channel = GroupChannel(receipt.GroupID)
+	}
+
+	receiptJSON, err := json.Marshal(receipt)
+	if err != nil {
+		return
+	}
+
+	if _, err := breaker.ExecuteCtx(ctx, cs.cbRedis, func() (any, error) {
+		return nil, cs.rdb.Publish(ctx, channel, receiptJSON).Err()
+	}); err != nil {
+		logger.WithFields(map[string]any{
+			"reader":  receipt.FromID,
+			"channel": channel,
+			"event":   receipt.Event,
+			"error":   err.Error(),
+		}).Warn("Failed to publish receipt")
+	}
+}
+
+// unreadCounts returns the number of unread messages per conversation
+// field, leaving out conversations without any
+func (cs *ChatService) unreadCounts(ctx context.Context, username string, peers, groupIDs []string) (map[string]int, error) {
+	fields := make([]string, 0, len(peers)+len(groupIDs))
+	keys := make([]string, 0, len(peers)+len(groupIDs)+1)
+	keys = append(keys, receiptsKey(username))
+	for _, peer := range peers {
+		fields = append(fields, receiptField(peer, ""))
+		keys = append(keys, cs.GetConversationKey(username, peer))
+	}
+	for _, groupID := range groupIDs {
+		fields = append(fields, receiptField("", groupID))
+		keys = append(keys, fmt.Sprintf("chat:group:%s:messages", groupID))
+	}
+
+	counts := make(map[string]int, len(fields))
+	if len(fields) == 0 {
+		return counts, nil
+	}
+
+	args := make([]any, 0, len(fields)+1)
+	args = append(args, username)
+	for _, field := range fields {
+		args = append(args, field)
+	}
+
+	result, err := breaker.ExecuteCtx(ctx, cs.cbRedis, func() (any, error) {
+		return unreadCountScript.Run(ctx, cs.rdb, keys, args...).Int64Slice()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	values, _ := result.([]int64)
+	for i := 0; i+1 < len(values) && i/2 < len(fields); i += 2 {
+		count, read := values[i], values[i+1]
+		if count < 0 && i/2 < len(peers) {
+			// The cached history expired; Postgres has the rest
+			count = cs.countUnreadStored(ctx, username, peers[i/2], read)
+		}
+		if count > 0 {
+			counts[fields[i/2]] = int(count)
+		}
+	}
+	return counts, nil
+}
+
+// countUnreadStored counts the messages peer sent username after the read
+// position in Postgres
+func (cs *ChatService) countUnreadStored(ctx context.Context, username, peer string, read int64) int64 {
+	count, err := cs.qdb.CountUnreadMessages(ctx, db.CountUnreadMessagesParams{
+		FromUsername: peer,
+		ToUsername:   username,
+		Since:        time.Unix(read+1, 0),
+	})
+	if err != nil {
+		logger.WithFields(map[string]any{
+			"username": username,
+			"peer":     peer,
+			"error":    err.Error(),
+		}).Warn("Failed to count unread messages")
+		return 0
+	}
+	return count
+}
+
+// applyReceipts fills in DeliveredAt and ReadAt of the messages username
+// sent peer from peer's positions
+func (cs *ChatService) applyReceipts(ctx context.Context, username, peer string, messages []*ChatMessage) {
+	if len(messages) == 0 || peer == username {
+		return
+	}
+
+	field := receiptField(username, "")
+	result, err := breaker.ExecuteCtx(ctx, cs.cbRedis, func() (any, error) {
+		return cs.rdb.HMGet(ctx, receiptsKey(peer),
+			field+":delivered", field+":delivered_at", field+":read", field+":read_at").Result()
+	})
+	if err != nil {
+		return
+	}
+
+	values, _ := result.([]any)
+	if len(values) != 4 {
+		return
+	}
+	position := func(i int) int64 {
+		s, _ := values[i].(string)
+		v, _ := strconv.ParseInt(s, 10, 64)
+		return v
+	}
+	delivered, deliveredAt, read, readAt := position(0), position(1), position(2), position(3)
+
+	for _, msg := range messages {
+		if msg.FromID != username {
+			continue
+		}
+		if msg.Timestamp <= delivered {
+			msg.DeliveredAt = deliveredAt
+		}
+		if msg.Timestamp <= read {
+			msg.ReadAt = readAt
+		}
+	}
+}
+
+// receiptField names a conversation in a reader's receipts and unread
+// conversations: the other participant, or the group
+func receiptField(peer, groupID string) string {
+	if groupID != "" {
+		return "group:" + groupID
+	}
+	return peer
+}
+
+func receiptsKey(username string) string {
+	return receiptsPrefix + username
+}
+
+func unreadKey(username string) string {
+	return "chat:unread:" + username
+}
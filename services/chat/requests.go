@@ -0,0 +1,185 @@
+package chat
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"exc6/apperrors"
+	"exc6/db"
+	"exc6/pkg/logger"
+	"net/http"
+	"time"
+	"unicode/utf8"
+)
+
+// Direct messages between users who are not friends are message requests.
+// The sender may send Limits.RequestLimit messages until the recipient
+// replies or accepts; a declined request admits nothing more until the
+// recipient writes to the sender. Only messages users send are counted, so
+// bots and reminders reach anyone. Requests are kept in Postgres with the
+// number of messages they hold.
+
+// Message request statuses
+const (
+	RequestPending  = "pending"
+	RequestAccepted = "accepted"
+	RequestDeclined = "declined"
+)
+
+const (
+	// DefaultRequestLimit bounds message requests until SetLimits is called
+	DefaultRequestLimit = 3
+
+	// messageRequestsLimit is how many pending requests the inbox lists
+	messageRequestsLimit = 100
+
+	// previewLength bounds the latest message shown with a request
+	previewLength = 140
+)
+
+// MessageRequest is a pending request in its recipient's inbox
+type MessageRequest struct {
+	From      string    `json:"from"`
+	Messages  int       `json:"messages"`
+	Preview   string    `json:"preview"`
+	Subtype   string    `json:"subtype,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// AdmitMessage returns an error if from may not send another direct message
+// to to, and otherwise counts it against from's message request when they are
+// not friends. Writing to a user whose request is pending accepts it. Call it
+// before each message a user sends.
+func (cs *ChatService) AdmitMessage(ctx context.Context, from, to string) error {
+	if from == to {
+		return nil
+	}
+
+	state, err := cs.qdb.GetMessageRequestState(ctx, db.GetMessageRequestStateParams{
+		Recipient: to,
+		Sender:    from,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return apperrors.NewUserNotFound()
+	}
+	if err != nil {
+		// Fail open like the moderation checks: messaging outlives Postgres
+		logger.WithFields(map[string]any{
+			"from":  from,
+			"to":    to,
+			"error": err.Error(),
+		}).Warn("Failed to check message request")
+		return nil
+	}
+
+	switch {
+	case state.Friends, state.Status == RequestAccepted:
+		return nil
+
+	case state.ReverseStatus != "":
+		// A reply to the recipient's own request
+		if state.ReverseStatus != RequestAccepted {
+			if _, err := cs.setRequestStatus(ctx, to, from, RequestAccepted); err != nil {
+				logger.WithError(err).Warn("Failed to accept replied message request")
+			}
+		}
+		return nil
+
+	case state.Replied:
+		return nil
+
+	case state.Status == RequestDeclined:
+		return apperrors.NewMessageRequestPending(to, cs.limits.RequestLimit)
+	}
+
+	rows, err := cs.qdb.RecordMessageRequest(ctx, db.RecordMessageRequestParams{
+		Sender:      from,
+		Recipient:   to,
+		MaxMessages: int32(cs.limits.RequestLimit),
+	})
+	if err != nil {
+		logger.WithFields(map[string]any{
+			"from":  from,
+			"to":    to,
+			"error": err.Error(),
+		}).Warn("Failed to record message request")
+		return nil
+	}
+	if rows == 0 {
+		return apperrors.NewMessageRequestPending(to, cs.limits.RequestLimit)
+	}
+	return nil
+}
+
+// ListMessageRequests returns the pending requests to username, most
+// recently active first
+func (cs *ChatService) ListMessageRequests(ctx context.Context, username string) ([]MessageRequest, error) {
+	rows, err := cs.qdb.ListMessageRequests(ctx, db.ListMessageRequestsParams{
+		Recipient: username,
+		RowLimit:  messageRequestsLimit,
+	})
+	if err != nil {
+		return nil, apperrors.NewDatabaseError("list message requests", err).WithDetails("username", username)
+	}
+
+	requests := make([]MessageRequest, 0, len(rows))
+	for _, row := range rows {
+		requests = append(requests, MessageRequest{
+			From:      row.Sender,
+			Messages:  int(row.Messages),
+			Preview:   preview(row.LatestContent.String),
+			Subtype:   row.LatestSubtype.String,
+			CreatedAt: row.CreatedAt,
+			UpdatedAt: row.UpdatedAt,
+		})
+	}
+	return requests, nil
+}
+
+// AcceptMessageRequest lets sender message username freely
+func (cs *ChatService) AcceptMessageRequest(ctx context.Context, username, sender string) error {
+	return cs.answerRequest(ctx, username, sender, RequestAccepted)
+}
+
+// DeclineMessageRequest stops sender from messaging username and clears
+// their unread messages
+func (cs *ChatService) DeclineMessageRequest(ctx context.Context, username, sender string) error {
+	if err := cs.answerRequest(ctx, username, sender, RequestDeclined); err != nil {
+		return err
+	}
+
+	if err := cs.MarkConversationRead(ctx, username, sender); err != nil {
+		logger.WithError(err).Warn("Failed to clear unread messages of declined request")
+	}
+	return nil
+}
+
+func (cs *ChatService) answerRequest(ctx context.Context, username, sender, status string) error {
+	rows, err := cs.setRequestStatus(ctx, sender, username, status)
+	if err != nil {
+		return apperrors.NewDatabaseError("answer message request", err).
+			WithDetails("username", username).
+			WithDetails("sender", sender)
+	}
+	if rows == 0 {
+		return apperrors.New(apperrors.ErrCodeNotFound, "No message request from "+sender, http.StatusNotFound)
+	}
+	return nil
+}
+
+func (cs *ChatService) setRequestStatus(ctx context.Context, sender, recipient, status string) (int64, error) {
+	return cs.qdb.SetMessageRequestStatus(ctx, db.SetMessageRequestStatusParams{
+		Status:    status,
+		Sender:    sender,
+		Recipient: recipient,
+	})
+}
+
+// preview shortens content to previewLength characters
+func preview(content string) string {
+	if utf8.RuneCountInString(content) <= previewLength {
+		return content
+	}
+	return string([]rune(content)[:previewLength-1]) + "…"
+}
@@ -0,0 +1,21 @@
+package chat
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPreview(t *testing.T) {
+	assert.Equal(t, "hello", preview("hello"))
+	assert.Equal(t, "", preview(""))
+
+	long := preview(strings.Repeat("é", previewLength+10))
+	assert.Equal(t, previewLength, utf8.RuneCountInString(long))
+	assert.True(t, strings.HasSuffix(long, "…"))
+
+	exact := strings.Repeat("a", previewLength)
+	assert.Equal(t, exact, preview(exact))
+}
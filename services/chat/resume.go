@@ -0,0 +1,173 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"exc6/apperrors"
+	"exc6/db"
+	"exc6/pkg/breaker"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Clients reconnecting over WebSocket name the last message they have of each
+// open conversation and are sent what came after it. The Redis cache serves
+// short gaps; when it no longer reaches back to the client's last message the
+// gap is read from Postgres. Group messages only get there through the
+// Consumer, so the newest messages of a long group gap can be missing.
+
+// MaxResumeMessages bounds the messages replayed per conversation; clients
+// further behind reload the conversation
+const MaxResumeMessages = 200
+
+// MessagesAfter returns up to limit messages of the conversation of ref sent
+// after ref.ID, the last message the user has, oldest first; since is that
+// message's unix time. When ref.ID is not found, messages from the same
+// second are included, so callers skip IDs they already have. The bool
+// reports whether more messages were left out.
+func (cs *ChatService) MessagesAfter(ctx context.Context, username string, ref MessageRef, since int64, limit int) ([]*ChatMessage, bool, error) {
+	if since <= 0 {
+		return nil, false, apperrors.NewValidationError("The time of the last message is required")
+	}
+	limit = min(max(limit, 1), MaxResumeMessages)
+
+	key := cs.cacheKey(username, ref)
+	source := "cache"
+	if ref.GroupID != "" {
+		source = "group_cache"
+	}
+
+	result, err := breaker.ExecuteCtx(ctx, cs.cbRedis, func() (any, error) {
+		return cs.rdb.ZRange(ctx, key, 0, -1).Result()
+	})
+	if err == nil {
+		members, _ := result.([]string)
+		cached := make([]*ChatMessage, 0, len(members))
+		for _, member := range members {
+			var msg ChatMessage
+			if err := json.Unmarshal([]byte(member), &msg); err != nil || !verify(source, &msg) {
+				continue
+			}
+			cached = append(cached, &msg)
+		}
+		if missed, ok := messagesAfter(cached, ref.ID, since); ok {
+			messages, more := truncate(missed, limit)
+			return messages, more, nil
+		}
+	}
+
+	// Extra rows leave room for the messages of the last one's second
+	// that the client already has
+	rowLimit := int32(limit + RecentMessagesCacheSize)
+	stored, err := cs.storedMessagesAfter(ctx, username, ref, time.Unix(since, 0), rowLimit)
+	if err != nil {
+		return nil, false, err
+	}
+
+	messages, more := truncate(afterID(stored, ref.ID), limit)
+	return messages, more || len(stored) == int(rowLimit), nil
+}
+
+// storedMessagesAfter reads the messages of a conversation sent from after
+// on from Postgres, oldest first
+func (cs *ChatService) storedMessagesAfter(ctx context.Context, username string, ref MessageRef, after time.Time, rowLimit int32) ([]*ChatMessage, error) {
+	var messages []*ChatMessage
+
+	if ref.GroupID == "" {
+		rows, err := cs.qdb.GetMessagesBetweenUsersAfter(ctx, db.GetMessagesBetweenUsersAfterParams{
+			User1:          username,
+			User2:          ref.With,
+			AfterCreatedAt: after,
+			RowLimit:       rowLimit,
+		})
+		if err != nil {
+			return nil, apperrors.NewDatabaseError("get missed messages", err)
+		}
+		for _, row := range rows {
+			msg := &ChatMessage{
+				MessageID: row.MessageID,
+				FromID:    row.FromUsername,
+				ToID:      row.ToUsername,
+				Content:   row.Content,
+				Subtype:   row.Subtype,
+				Timestamp: row.CreatedAt.Unix(),
+				Deleted:   row.DeletedAt.Valid,
+			}
+			if row.EditedAt.Valid {
+				msg.EditedAt = row.EditedAt.Time.Unix()
+			}
+			messages = append(messages, msg)
+		}
+		return messages, nil
+	}
+
+	groupID, err := uuid.Parse(ref.GroupID)
+	if err != nil {
+		return nil, apperrors.NewValidationError("Invalid group ID")
+	}
+	rows, err := cs.qdb.GetGroupMessagesAfter(ctx, db.GetGroupMessagesAfterParams{
+		GroupID:        groupID,
+		AfterCreatedAt: after,
+		RowLimit:       rowLimit,
+	})
+	if err != nil {
+		return nil, apperrors.NewDatabaseError("get missed group messages", err)
+	}
+	for _, row := range rows {
+		msg := &ChatMessage{
+			MessageID: row.MessageID,
+			FromID:    row.FromUsername,
+			GroupID:   ref.GroupID,
+			Content:   row.Content,
+			Subtype:   row.Subtype,
+			Timestamp: row.CreatedAt.Unix(),
+			IsGroup:   true,
+			Deleted:   row.DeletedAt.Valid,
+		}
+		if row.EditedAt.Valid {
+			msg.EditedAt = row.EditedAt.Time.Unix()
+		}
+		messages = append(messages, msg)
+	}
+	return messages, nil
+}
+
+// messagesAfter returns the cached messages, oldest first, that follow the
+// message lastID, or those sent since when lastID is not among them. It
+// reports false when the cache does not reach back to since, so some may be
+// missing from it.
+func messagesAfter(cached []*ChatMessage, lastID string, since int64) ([]*ChatMessage, bool) {
+	if slices.ContainsFunc(cached, func(msg *ChatMessage) bool { return msg.MessageID == lastID }) {
+		return afterID(cached, lastID), true
+	}
+	if len(cached) == 0 || cached[0].Timestamp > since {
+		return nil, false
+	}
+
+	i := slices.IndexFunc(cached, func(msg *ChatMessage) bool { return msg.Timestamp >= since })
+	if i < 0 {
+		return nil, true
+	}
+	return cached[i:], true
+}
+
+// afterID returns the messages following the message lastID, or all of them
+// when lastID is not among them
+func afterID(messages []*ChatMessage, lastID string) []*ChatMessage {
+	for i, msg := range messages {
+		if lastID != "" && msg.MessageID == lastID {
+			return messages[i+1:]
+		}
+	}
+	return messages
+}
+
+// truncate keeps the first limit messages and reports whether any were cut
+func truncate(messages []*ChatMessage, limit int) ([]*ChatMessage, bool) {
+	if len(messages) > limit {
+		return messages[:limit], true
+	}
+	return messages, false
+}
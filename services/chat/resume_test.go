@@ -0,0 +1,77 @@
+package chat
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessagesAfter(t *testing.T) {
+	cached := []*ChatMessage{
+		{MessageID: "m1", Timestamp: 100},
+		{MessageID: "m2", Timestamp: 200},
+		{MessageID: "m3", Timestamp: 200},
+		{MessageID: "m4", Timestamp: 300},
+	}
+	ids := func(messages []*ChatMessage) []string {
+		var got []string
+		for _, msg := range messages {
+			got = append(got, msg.MessageID)
+		}
+		return got
+	}
+
+	tests := []struct {
+		name   string
+		lastID string
+		since  int64
+		want   []string
+		ok     bool
+	}{
+		{name: "Known last message", lastID: "m2", since: 200, want: []string{"m3", "m4"}, ok: true},
+		{name: "Up to date", lastID: "m4", since: 300, ok: true},
+		{name: "Unknown last message keeps its second", lastID: "gone", since: 200, want: []string{"m2", "m3", "m4"}, ok: true},
+		{name: "Nothing since", lastID: "gone", since: 400, ok: true},
+		{name: "Cache does not reach back", lastID: "m0", since: 50},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			missed, ok := messagesAfter(cached, tt.lastID, tt.since)
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.want, ids(missed))
+		})
+	}
+
+	_, ok := messagesAfter(nil, "m1", 100)
+	assert.False(t, ok, "an empty cache reaches nowhere")
+}
+
+func TestMemoryServiceMessagesAfter(t *testing.T) {
+	ctx := context.Background()
+	ms := NewMemoryService(nil)
+
+	first, err := ms.SendMessage(ctx, "alice", "bob", "hello")
+	require.NoError(t, err)
+	second, err := ms.SendMessage(ctx, "bob", "alice", "hi")
+	require.NoError(t, err)
+	third, err := ms.SendMessage(ctx, "alice", "bob", "how are you?")
+	require.NoError(t, err)
+
+	missed, more, err := ms.MessagesAfter(ctx, "bob", MessageRef{ID: first.MessageID, With: "alice"}, first.Timestamp, 1)
+	require.NoError(t, err)
+	assert.True(t, more)
+	require.Len(t, missed, 1)
+	assert.Equal(t, second.MessageID, missed[0].MessageID)
+
+	missed, more, err = ms.MessagesAfter(ctx, "bob", MessageRef{ID: second.MessageID, With: "alice"}, second.Timestamp, MaxResumeMessages)
+	require.NoError(t, err)
+	assert.False(t, more)
+	require.Len(t, missed, 1)
+	assert.Equal(t, third.MessageID, missed[0].MessageID)
+
+	_, _, err = ms.MessagesAfter(ctx, "bob", MessageRef{With: "alice"}, 0, MaxResumeMessages)
+	assert.Error(t, err)
+}
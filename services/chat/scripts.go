@@ -0,0 +1,272 @@
+package chat
+
+import (
+	"context"
+	"exc6/pkg/logger"
+	"exc6/services/activity"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Accepting a message touches several keys: the cached history, the
+// recipient's unread conversations and the contact list activity of the
+// participants. Scripts update them in one step, so a failure cannot leave a
+// message cached but not listed as unread and each message costs one round
+// trip.
+// They run with EVALSHA; when Redis answers NOSCRIPT, e.g. after a restart or
+// failover, go-redis falls back to EVAL, which loads the script again.
+
+// directMessageScript caches a direct message, lists the conversation as
+// unread for the recipient and records the conversation's activity.
+//
+// KEYS: conversation cache, recipient's unread conversations, sender's
+// activity, recipient's activity
+//
+// ARGV: timestamp, message JSON, cache size, cache TTL (s), unread field ("" to
+// skip), unread TTL (s), activity time (ms, "" to skip), activity cutoff (ms),
+// activity retention (ms), sender's activity member, recipient's activity member
+var directMessageScript = redis.NewScript(`
+redis.call('ZADD', KEYS[1], ARGV[1], ARGV[2])
+redis.call('ZREMRANGEBYRANK', KEYS[1], 0, -tonumber(ARGV[3]) - 1)
+redis.call('EXPIRE', KEYS[1], ARGV[4])
+
+if ARGV[5] ~= '' then
+	redis.call('HSET', KEYS[2], ARGV[5], ARGV[1])
+	redis.call('EXPIRE', KEYS[2], ARGV[6])
+end
+
+if ARGV[7] ~= '' then
+	local touch = function(key, member)
+		redis.call('ZADD', key, ARGV[7], member)
+		redis.call('ZREMRANGEBYSCORE', key, '-inf', ARGV[8])
+		redis.call('PEXPIRE', key, ARGV[9])
+	end
+	touch(KEYS[3], ARGV[10])
+	if KEYS[4] ~= KEYS[3] then
+		touch(KEYS[4], ARGV[11])
+	end
+end
+
+return 0
+`)
+
+// groupMessageScript caches a group message and records the group's activity.
+//
+// KEYS: group cache, groups activity
+//
+// ARGV: timestamp, message JSON, cache size, cache TTL (s), group ID,
+// activity time (ms, "" to skip), activity cutoff (ms), activity retention (ms)
+var groupMessageScript = redis.NewScript(`
+redis.call('ZADD', KEYS[1], ARGV[1], ARGV[2])
+redis.call('ZREMRANGEBYRANK', KEYS[1], 0, -tonumber(ARGV[3]) - 1)
+redis.call('EXPIRE', KEYS[1], ARGV[4])
+
+if ARGV[6] ~= '' then
+	redis.call('ZADD', KEYS[2], ARGV[6], ARGV[5])
+	redis.call('ZREMRANGEBYSCORE', KEYS[2], '-inf', ARGV[7])
+	redis.call('PEXPIRE', KEYS[2], ARGV[8])
+end
+
+return 0
+`)
+
+// replaceMessageScript swaps a cached message for its edited version, keeping
+// its score. Nothing is added when the old version is gone, so two concurrent
+// edits cannot both end up in the history.
+//
+// KEYS: message cache
+//
+// ARGV: current message JSON, new message JSON
+//
+// Returns 1 when the message was replaced, 0 when it was not cached.
+var replaceMessageScript = redis.NewScript(`
+local score = redis.call('ZSCORE', KEYS[1], ARGV[1])
+if not score then
+	return 0
+end
+redis.call('ZREM', KEYS[1], ARGV[1])
+redis.call('ZADD', KEYS[1], score, ARGV[2])
+return 1
+`)
+
+// advanceReceiptScript moves a reader's delivered position in a conversation,
+// and the read position too when reading, if the new position is later. A
+// conversation read up to its newest message leaves the unread conversations.
+//
+// KEYS: reader's receipts, reader's unread conversations
+//
+// ARGV: conversation field, position (s), receipt time (s), "1" when reading,
+// receipts TTL (s)
+//
+// Returns 1 when the requested position moved, 0 when it already covered the
+// new one.
+var advanceReceiptScript = redis.NewScript(`
+local position = tonumber(ARGV[2])
+local function advance(kind)
+	local field = ARGV[1] .. ':' .. kind
+	if position <= (tonumber(redis.call('HGET', KEYS[1], field)) or 0) then
+		return 0
+	end
+	redis.call('HSET', KEYS[1], field, ARGV[2], field .. '_at', ARGV[3])
+	return 1
+end
+
+local moved = advance('delivered')
+if ARGV[4] ~= '' then
+	moved = advance('read')
+	local latest = tonumber(redis.call('HGET', KEYS[2], ARGV[1]))
+	if latest and latest <= position then
+		redis.call('HDEL', KEYS[2], ARGV[1])
+	end
+end
+redis.call('EXPIRE', KEYS[1], ARGV[5])
+return moved
+`)
+
+// unreadCountScript counts the cached messages of each conversation that
+// arrived after the reader's read position, leaving out their own and
+// deleted ones.
+//
+// KEYS: reader's receipts, then the cache of each conversation in ARGV order
+//
+// ARGV: reader, then one receipts field per conversation
+//
+// Returns the count and read position of each conversation, with a count of
+// -1 when its cache has expired.
+var unreadCountScript = redis.NewScript(`
+local result = {}
+for i = 2, #ARGV do
+	local read = redis.call('HGET', KEYS[1], ARGV[i] .. ':read') or '0'
+	local count = -1
+	if redis.call('EXISTS', KEYS[i]) == 1 then
+		count = 0
+		for _, member in ipairs(redis.call('ZRANGEBYSCORE', KEYS[i], '(' .. read, '+inf')) do
+			local ok, msg = pcall(cjson.decode, member)
+			if ok and msg.from ~= ARGV[1] and not msg.deleted then
+				count = count + 1
+			end
+		end
+	end
+	result[#result + 1] = count
+	result[#result + 1] = tonumber(read)
+end
+return result
+`)
+
+// reactionScript adds or removes a reaction of a message and marks the
+// message for the next flush. The set holds an empty member while it is
+// loaded, so an empty set is told apart from one that expired.
+//
+// KEYS: message's reactions, messages to flush
+//
+// ARGV: reaction member, reactor's member prefix, reactions allowed per
+// reactor, reactions TTL (s), message ID, "1" to add or "" to remove
+//
+// Returns 1 when the reactions changed, 0 when they already were as asked,
+// -1 when the reactor has no reactions left and -2 when the set is not
+// loaded.
+var reactionScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return -2
+end
+
+if ARGV[6] ~= '' then
+	if redis.call('SISMEMBER', KEYS[1], ARGV[1]) == 1 then
+		return 0
+	end
+	local mine = 0
+	for _, member in ipairs(redis.call('SMEMBERS', KEYS[1])) do
+		if string.sub(member, 1, #ARGV[2]) == ARGV[2] then
+			mine = mine + 1
+		end
+	end
+	if mine >= tonumber(ARGV[3]) then
+		return -1
+	end
+	redis.call('SADD', KEYS[1], ARGV[1])
+elseif redis.call('SREM', KEYS[1], ARGV[1]) == 0 then
+	return 0
+end
+
+redis.call('EXPIRE', KEYS[1], ARGV[4])
+redis.call('SADD', KEYS[2], ARGV[5])
+return 1
+`)
+
+// loadScripts caches the scripts in Redis ahead of the first message. A
+// failure is not fatal: running a script loads it.
+func (cs *ChatService) loadScripts(ctx context.Context) {
+	for _, script := range []*redis.Script{directMessageScript, groupMessageScript, replaceMessageScript, advanceReceiptScript, unreadCountScript, reactionScript} {
+		if err := script.Load(ctx, cs.rdb).Err(); err != nil {
+			logger.WithError(err).Warn("Failed to preload chat scripts")
+			return
+		}
+	}
+}
+
+// recordDirect caches a direct message, lists the conversation as unread for
+// the recipient when countUnread is set, and records the conversation's
+// activity
+func (cs *ChatService) recordDirect(ctx context.Context, msg *ChatMessage, msgJSON []byte, countUnread bool) error {
+	from, to := msg.FromID, msg.ToID
+
+	unreadField := ""
+	if countUnread {
+		unreadField = from
+	}
+	activityAt, cutoff := cs.activityWindow()
+
+	keys := []string{
+		cs.GetConversationKey(from, to),
+		unreadKey(to),
+		activity.UserKey(from),
+		activity.UserKey(to),
+	}
+	return directMessageScript.Run(ctx, cs.rdb, keys,
+		msg.Timestamp,
+		msgJSON,
+		RecentMessagesCacheSize,
+		int64(MessageCacheTTL/time.Second),
+		unreadField,
+		int64(UnreadTTL/time.Second),
+		activityAt,
+		cutoff,
+		activity.Retention.Milliseconds(),
+		activity.ConversationMember(to),
+		activity.ConversationMember(from),
+	).Err()
+}
+
+// recordGroup caches a group message and records the group's activity
+func (cs *ChatService) recordGroup(ctx context.Context, msg *ChatMessage, msgJSON []byte) error {
+	activityAt, cutoff := cs.activityWindow()
+
+	keys := []string{
+		fmt.Sprintf("chat:group:%s:messages", msg.GroupID),
+		activity.GroupsKey(),
+	}
+	return groupMessageScript.Run(ctx, cs.rdb, keys,
+		msg.Timestamp,
+		msgJSON,
+		RecentMessagesCacheSize,
+		int64(MessageCacheTTL/time.Second),
+		msg.GroupID,
+		activityAt,
+		cutoff,
+		activity.Retention.Milliseconds(),
+	).Err()
+}
+
+// activityWindow returns the time of an activity record and the time before
+// which records are dropped, in unix milliseconds, or "" when activity is
+// not tracked
+func (cs *ChatService) activityWindow() (string, string) {
+	if !cs.activity.Enabled() {
+		return "", ""
+	}
+	now := time.Now().UnixMilli()
+	return strconv.FormatInt(now, 10), strconv.FormatInt(now-activity.Retention.Milliseconds(), 10)
+}
@@ -0,0 +1,155 @@
+package chat
+
+import (
+	"context"
+	"exc6/apperrors"
+	"exc6/db"
+	"exc6/pkg/logger"
+	"exc6/pkg/pagination"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Search finds text messages of one conversation containing a query,
+// ignoring case, newest first. Each result carries the byte offsets of the
+// matches for highlighting and an anchor: the history cursor whose page
+// starts at the message, so a client can jump to it and page on from there.
+// Deleted messages and GIFs are not searched.
+
+// MaxSearchLength is the longest query accepted, in characters
+const MaxSearchLength = 100
+
+// Match is the position of a match in a message's content, as byte offsets
+// with End exclusive
+type Match struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// SearchResult is a message matching a search
+type SearchResult struct {
+	Message *ChatMessage `json:"message"`
+	Matches []Match      `json:"matches"`
+
+	// Anchor is the history cursor of the page starting at the message
+	Anchor string `json:"anchor"`
+}
+
+// SearchConversation returns the messages between username and contact that
+// contain query
+func (cs *ChatService) SearchConversation(ctx context.Context, username, contact, query string, page pagination.Params) (pagination.Page[SearchResult], error) {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return pagination.Page[SearchResult]{}, apperrors.NewValidationError("Search query is required")
+	}
+	if utf8.RuneCountInString(query) > MaxSearchLength {
+		return pagination.Page[SearchResult]{}, apperrors.NewValidationError(fmt.Sprintf("Search query cannot be longer than %d characters", MaxSearchLength))
+	}
+
+	limit := pagination.ClampLimit(page.Limit)
+
+	before := time.Now().Add(time.Hour)
+	beforeID := ""
+	if page.After != nil {
+		nanos, err := pagination.ParseIntKey(page.After.Key)
+		if err != nil {
+			return pagination.Page[SearchResult]{}, apperrors.NewValidationError("Invalid pagination cursor")
+		}
+		before = time.Unix(0, nanos)
+		beforeID = page.After.ID
+	}
+
+	rows, err := cs.qdb.SearchConversationMessages(ctx, db.SearchConversationMessagesParams{
+		User1:           username,
+		User2:           contact,
+		Pattern:         "%" + escapeLike(query) + "%",
+		BeforeCreatedAt: before,
+		BeforeMessageID: beforeID,
+		RowLimit:        int32(limit + 1),
+	})
+	if err != nil {
+		logger.WithFields(map[string]any{
+			"username": username,
+			"contact":  contact,
+			"error":    err.Error(),
+		}).Error("Failed to search conversation")
+		return pagination.Page[SearchResult]{}, apperrors.NewDatabaseError("search conversation", err)
+	}
+
+	rowPage := pagination.New(rows, page, func(row db.SearchConversationMessagesRow) pagination.Cursor {
+		return pagination.Cursor{Key: pagination.TimeKey(row.CreatedAt), ID: row.MessageID}
+	})
+
+	results := make([]SearchResult, 0, len(rowPage.Items))
+	for _, row := range rowPage.Items {
+		msg := &ChatMessage{
+			MessageID: row.MessageID,
+			FromID:    row.FromUsername,
+			ToID:      row.ToUsername,
+			Content:   row.Content,
+			Timestamp: row.CreatedAt.Unix(),
+		}
+		if row.EditedAt.Valid {
+			msg.EditedAt = row.EditedAt.Time.Unix()
+		}
+		results = append(results, SearchResult{
+			Message: msg,
+			Matches: matchOffsets(row.Content, query),
+			Anchor:  historyAnchor(row.CreatedAt),
+		})
+	}
+
+	return pagination.Page[SearchResult]{
+		Items:      results,
+		NextCursor: rowPage.NextCursor,
+		HasMore:    rowPage.HasMore,
+	}, nil
+}
+
+// historyAnchor returns the history cursor whose page starts at a message
+// created at createdAt. Postgres keeps microseconds, so no message lies
+// between the message and the cursor unless it was created in the same
+// microsecond.
+func historyAnchor(createdAt time.Time) string {
+	return pagination.Cursor{Key: pagination.TimeKey(createdAt.Add(time.Microsecond))}.Encode()
+}
+
+// escapeLike quotes the LIKE wildcards in s
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// matchOffsets returns the non-overlapping matches of query in content,
+// ignoring case the way strings.EqualFold does
+func matchOffsets(content, query string) []Match {
+	var matches []Match
+	for start := 0; start < len(content); {
+		if end := matchAt(content, start, query); end > start {
+			matches = append(matches, Match{Start: start, End: end})
+			start = end
+			continue
+		}
+		_, size := utf8.DecodeRuneInString(content[start:])
+		start += size
+	}
+	return matches
+}
+
+// matchAt returns the end of query matched at content[start:], or -1. Case
+// variants may differ in length, so content is walked rune by rune.
+func matchAt(content string, start int, query string) int {
+	i := start
+	for _, q := range query {
+		if i >= len(content) {
+			return -1
+		}
+		r, size := utf8.DecodeRuneInString(content[i:])
+		if !strings.EqualFold(string(r), string(q)) {
+			return -1
+		}
+		i += size
+	}
+	return i
+}
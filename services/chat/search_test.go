@@ -0,0 +1,33 @@
+package chat
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchOffsets(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		query   string
+		want    []Match
+	}{
+		{name: "Single match", content: "see you tomorrow", query: "you", want: []Match{{Start: 4, End: 7}}},
+		{name: "Ignores case", content: "Hello HELLO hello", query: "hello", want: []Match{{0, 5}, {6, 11}, {12, 17}}},
+		{name: "Non-overlapping", content: "aaaa", query: "aa", want: []Match{{0, 2}, {2, 4}}},
+		{name: "Byte offsets after multibyte text", content: "café au lait", query: "lait", want: []Match{{Start: 9, End: 13}}},
+		{name: "Multibyte query", content: "CAFÉ café", query: "café", want: []Match{{0, 5}, {6, 11}}},
+		{name: "No match", content: "nothing here", query: "else"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, matchOffsets(tt.content, tt.query))
+		})
+	}
+}
+
+func TestEscapeLike(t *testing.T) {
+	assert.Equal(t, `100\% \_done\\`, escapeLike(`100% _done\`))
+}
@@ -0,0 +1,114 @@
+package chat
+
+import (
+	"context"
+	"exc6/pkg/breaker"
+	"exc6/pkg/instance"
+	"exc6/pkg/keyspace"
+	"exc6/pkg/logger"
+	"exc6/services/events"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Every message is stamped with the next sequence number of its
+// conversation, counted in Redis, so readers can put messages that arrive
+// out of order back in place, drop those they already have and notice ones
+// that never arrived. Messages sent while Redis is unavailable, and those
+// rebuilt from Postgres, carry none and are shown in arrival order.
+
+const seqKeyPrefix = "chat:seq:"
+
+var (
+	sequenceGaps = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "chat_sequence_gaps_total",
+			Help: "Sequence numbers skipped in the messages relayed to a reader",
+		},
+	)
+
+	sequenceDisorder = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "chat_sequence_out_of_order_total",
+			Help: "Messages relayed to a reader after a later message of their conversation, or more than once",
+		},
+		[]string{"kind"}, // kind: late, duplicate
+	)
+)
+
+func init() {
+	instance.Registerer().MustRegister(sequenceGaps, sequenceDisorder)
+
+	keyspace.Register(keyspace.Family{
+		Prefix:      seqKeyPrefix,
+		Description: "last sequence number per conversation",
+		Exempt:      "sequence numbers must never go back",
+	})
+}
+
+// conversationOf returns the conversation a message belongs to
+func conversationOf(msg *ChatMessage) string {
+	if msg.IsGroup {
+		return events.Group(msg.GroupID)
+	}
+	return events.Direct(msg.FromID, msg.ToID)
+}
+
+// stampSeq gives a message the next sequence number of its conversation.
+// Failing to count is not fatal: the message is delivered without one.
+func (cs *ChatService) stampSeq(ctx context.Context, msg *ChatMessage) {
+	if msg.Seq != 0 {
+		return
+	}
+
+	seq, err := breaker.ExecuteCtx(ctx, cs.cbRedis, func() (any, error) {
+		return cs.rdb.Incr(ctx, seqKeyPrefix+conversationOf(msg)).Result()
+	})
+	if err != nil {
+		logger.WithFields(map[string]any{
+			"message_id": msg.MessageID,
+			"error":      err.Error(),
+		}).Warn("Failed to stamp message sequence number")
+		return
+	}
+	msg.Seq = seq.(int64)
+}
+
+// SequenceWatch follows the sequence numbers of the messages relayed to one
+// reader and counts the gaps and reorderings it sees. It is not safe for
+// concurrent use.
+type SequenceWatch struct {
+	last map[string]int64
+}
+
+// NewSequenceWatch creates a watch for a reader that has seen no messages
+func NewSequenceWatch() *SequenceWatch {
+	return &SequenceWatch{last: make(map[string]int64)}
+}
+
+// Observe records a message relayed to the reader and reports whether the
+// reader was already relayed it. The first message of each conversation only
+// sets where counting starts, as the reader loaded what came before with the
+// history.
+func (w *SequenceWatch) Observe(msg *ChatMessage) (duplicate bool) {
+	if msg.Seq == 0 {
+		return false
+	}
+
+	conversation := conversationOf(msg)
+	last, seen := w.last[conversation]
+	switch {
+	case !seen:
+	case msg.Seq == last:
+		sequenceDisorder.WithLabelValues("duplicate").Inc()
+		return true
+	case msg.Seq < last:
+		// Readers put it back in place
+		sequenceDisorder.WithLabelValues("late").Inc()
+		return false
+	case msg.Seq > last+1:
+		sequenceGaps.Add(float64(msg.Seq - last - 1))
+	}
+	w.last[conversation] = msg.Seq
+	return false
+}
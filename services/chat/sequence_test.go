@@ -0,0 +1,58 @@
+package chat
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSequenceWatch(t *testing.T) {
+	direct := func(from, to string, seq int64) *ChatMessage {
+		return &ChatMessage{FromID: from, ToID: to, Seq: seq}
+	}
+	w := NewSequenceWatch()
+
+	assert.False(t, w.Observe(direct("alice", "bob", 7)), "the first message sets where counting starts")
+	assert.False(t, w.Observe(direct("bob", "alice", 8)), "both directions share a conversation")
+	assert.True(t, w.Observe(direct("alice", "bob", 8)))
+	assert.False(t, w.Observe(direct("alice", "bob", 11)))
+	assert.False(t, w.Observe(direct("alice", "bob", 9)), "late messages are put back in place by readers")
+	assert.Equal(t, int64(11), w.last[conversationOf(direct("alice", "bob", 0))])
+
+	// Other conversations count separately
+	assert.False(t, w.Observe(&ChatMessage{FromID: "alice", GroupID: "g1", IsGroup: true, Seq: 8}))
+	assert.False(t, w.Observe(direct("alice", "carol", 8)))
+
+	// Messages without a sequence number are never duplicates
+	assert.False(t, w.Observe(direct("alice", "bob", 0)))
+	assert.False(t, w.Observe(direct("alice", "bob", 0)))
+}
+
+func TestMemoryServiceSequence(t *testing.T) {
+	ctx := context.Background()
+	ms := NewMemoryService(nil)
+
+	var seqs []int64
+	for _, from := range []string{"alice", "bob", "alice"} {
+		to := map[string]string{"alice": "bob", "bob": "alice"}[from]
+		msg, err := ms.SendMessage(ctx, from, to, "hi")
+		require.NoError(t, err)
+		seqs = append(seqs, msg.Seq)
+	}
+	assert.Equal(t, []int64{1, 2, 3}, seqs)
+
+	other, err := ms.SendMessage(ctx, "alice", "carol", "hi")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), other.Seq)
+
+	group, err := ms.SendGroupMessage(ctx, "alice", "g1", "hi")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), group.Seq)
+
+	history, err := ms.GetHistory(ctx, "bob", "alice")
+	require.NoError(t, err)
+	require.Len(t, history.Value, 3)
+	assert.Equal(t, int64(3), history.Value[2].Seq)
+}
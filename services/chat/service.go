@@ -0,0 +1,84 @@
+package chat
+
+import (
+	"context"
+	"exc6/pkg/breaker"
+	"exc6/pkg/pagination"
+	"exc6/services/activity"
+	"exc6/services/events"
+	"exc6/services/moderation"
+	"exc6/services/notifications"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Service is what handlers and other services use to send and read
+// messages. ChatService keeps messages in Redis, Postgres and Kafka;
+// MemoryService keeps them in the process, for unit tests and single-node
+// deployments.
+type Service interface {
+	// Messages
+	SendMessage(ctx context.Context, from, to, content string, opts ...SendOption) (*ChatMessage, error)
+	DeliverPersisted(ctx context.Context, msg *ChatMessage) error
+	SendGroupMessage(ctx context.Context, from, groupID, content string, opts ...SendOption) (*ChatMessage, error)
+	SplitContent(content string) ([]string, error)
+	GetHistory(ctx context.Context, user1, user2 string) (breaker.Result[[]*ChatMessage], error)
+	GetHistoryPage(ctx context.Context, user1, user2 string, page pagination.Params) (pagination.Page[*ChatMessage], error)
+	GetGroupHistory(ctx context.Context, groupID string) ([]*ChatMessage, error)
+	SearchConversation(ctx context.Context, username, contact, query string, page pagination.Params) (pagination.Page[SearchResult], error)
+	GetConversationKey(user1, user2 string) string
+
+	// Live delivery
+	SubscribeToConversations(ctx context.Context, username string, groupIDs []string) *redis.PubSub
+	PublishTyping(ctx context.Context, from, to, groupID string) error
+	ConversationEvents(ctx context.Context, username, contact, groupID string, since int64, limit int) (*events.Feed, error)
+	MessagesAfter(ctx context.Context, username string, ref MessageRef, since int64, limit int) ([]*ChatMessage, bool, error)
+
+	// Unread counts and receipts
+	GetUnreadMessages(ctx context.Context, username string) (map[string]int, error)
+	GetGroupUnread(ctx context.Context, username string, groupIDs []string) (map[string]int, error)
+	MarkConversationRead(ctx context.Context, recipient, sender string) error
+	MarkAllRead(ctx context.Context, username string) error
+	MarkGroupRead(ctx context.Context, username, groupID string) error
+	MarkDelivered(ctx context.Context, username, sender, messageID string, timestamp int64) error
+	MarkRead(ctx context.Context, username, peer, groupID, messageID string, timestamp int64) error
+
+	// Read receipts of tracked group messages
+	MarkTrackedDelivered(ctx context.Context, username, groupID, messageID string) error
+	MarkGroupDelivered(ctx context.Context, username, groupID string) error
+	MarkTrackedRead(ctx context.Context, username, groupID, messageID string, upTo int64) error
+	TrackedReceipts(ctx context.Context, groupID, messageID string) (*TrackedMessage, error)
+	TrackedMessages(ctx context.Context, groupID string, limit int) ([]TrackedMessage, error)
+
+	// Edits, reactions and pins
+	EditMessage(ctx context.Context, username string, ref MessageRef, content string) (*ChatMessage, error)
+	DeleteMessage(ctx context.Context, username string, ref MessageRef) (*ChatMessage, error)
+	AddReaction(ctx context.Context, username string, ref MessageRef, emoji string) ([]Reaction, error)
+	RemoveReaction(ctx context.Context, username string, ref MessageRef, emoji string) ([]Reaction, error)
+	GetReactions(ctx context.Context, username string, ref MessageRef) ([]Reaction, error)
+	PinMessage(ctx context.Context, username, contact, messageID string) ([]Pin, error)
+	UnpinMessage(ctx context.Context, username, contact, messageID string) ([]Pin, error)
+	GetPinnedMessages(ctx context.Context, username, contact string) ([]Pin, error)
+
+	// Message requests
+	AdmitMessage(ctx context.Context, from, to string) error
+	ListMessageRequests(ctx context.Context, username string) ([]MessageRequest, error)
+	AcceptMessageRequest(ctx context.Context, username, sender string) error
+	DeclineMessageRequest(ctx context.Context, username, sender string) error
+
+	// Monitoring
+	BufferDepth() int
+	MessagesAccepted() int64
+	GetMetrics() map[string]any
+
+	// Wiring, done before serving traffic
+	SetLimits(limits Limits)
+	SetActivityTracker(tracker *activity.Tracker)
+	SetMutes(mutes *notifications.Service)
+	SetNotifications(inbox *notifications.NotificationService)
+	SetEventLog(log *events.Log)
+	SetPolicy(policy *moderation.Policy)
+	AddMessageHook(hook MessageHook)
+
+	Close() error
+}
@@ -0,0 +1,140 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"exc6/pkg/breaker"
+	"exc6/tests/fakeredis"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubscribeToConversations(t *testing.T) {
+	srv := fakeredis.New(t)
+	rdb := srv.Client(t)
+	cs := &ChatService{rdb: rdb, cbRedis: breaker.New(breaker.Config{Name: "test-subscribe"})}
+	t.Cleanup(func() { breaker.Forget(cs.cbRedis) })
+	ctx := context.Background()
+
+	pubsub := cs.SubscribeToConversations(ctx, "alice", []string{"g1", "g2"})
+	require.NotNil(t, pubsub)
+	t.Cleanup(func() { pubsub.Close() })
+	for range 3 {
+		_, err := pubsub.Receive(ctx)
+		require.NoError(t, err)
+	}
+
+	// Only the user's own channel and those of their groups
+	for _, channel := range []string{UserChannel("alice"), GroupChannel("g1"), GroupChannel("g2")} {
+		assert.Equal(t, 1, srv.Subscribers(channel), channel)
+	}
+	for _, channel := range []string{UserChannel("bob"), GroupChannel("g3"), "chat:messages"} {
+		assert.Zero(t, srv.Subscribers(channel), channel)
+	}
+
+	for _, channel := range []string{UserChannel("bob"), GroupChannel("g3"), UserChannel("alice"), GroupChannel("g2")} {
+		require.NoError(t, rdb.Publish(ctx, channel, channel).Err())
+	}
+	for _, want := range []string{UserChannel("alice"), GroupChannel("g2")} {
+		msgCtx, cancel := context.WithTimeout(ctx, time.Second)
+		msg, err := pubsub.ReceiveMessage(msgCtx)
+		cancel()
+		require.NoError(t, err)
+		assert.Equal(t, want, msg.Payload, "traffic of other conversations never arrives")
+	}
+}
+
+func TestMemorySubscribeWithoutRedis(t *testing.T) {
+	assert.Nil(t, NewMemoryService(nil).SubscribeToConversations(context.Background(), "alice", nil))
+}
+
+// cpuTime returns the CPU time used by the process so far
+func cpuTime() time.Duration {
+	var usage syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &usage); err != nil {
+		return 0
+	}
+	return time.Duration(usage.Utime.Nano() + usage.Stime.Nano())
+}
+
+// BenchmarkRelayFanout measures the relay work for direct messages with 1000
+// connections, each receiving, decoding and filtering what its subscription
+// is sent. With global every connection follows one channel carrying all
+// messages, as before they were published per conversation.
+func BenchmarkRelayFanout(b *testing.B) {
+	const (
+		numConnections = 1000
+		globalChannel  = "chat:messages"
+	)
+
+	for _, global := range []bool{true, false} {
+		b.Run(fmt.Sprintf("global=%t", global), func(b *testing.B) {
+			rdb := fakeredis.New(b).Client(b)
+			ctx := context.Background()
+
+			var received, relevant atomic.Int64
+			var wg sync.WaitGroup
+			pubsubs := make([]*redis.PubSub, numConnections)
+			for i := range pubsubs {
+				username := fmt.Sprintf("user%d", i)
+				channel := UserChannel(username)
+				if global {
+					channel = globalChannel
+				}
+				pubsubs[i] = rdb.Subscribe(ctx, channel)
+				_, err := pubsubs[i].Receive(ctx)
+				require.NoError(b, err)
+
+				wg.Add(1)
+				go func(pubsub *redis.PubSub) {
+					defer wg.Done()
+					for msg := range pubsub.Channel() {
+						var chatMsg ChatMessage
+						if json.Unmarshal([]byte(msg.Payload), &chatMsg) == nil &&
+							(chatMsg.FromID == username || chatMsg.ToID == username) {
+							relevant.Add(1)
+						}
+						received.Add(1)
+					}
+				}(pubsubs[i])
+			}
+
+			payload, err := json.Marshal(NewSystemMessage("user0", "user1", "hello"))
+			require.NoError(b, err)
+			perMessage := int64(2)
+			if global {
+				perMessage = numConnections
+			}
+
+			start := cpuTime()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if global {
+					rdb.Publish(ctx, globalChannel, payload)
+				} else {
+					rdb.Publish(ctx, UserChannel("user1"), payload)
+					rdb.Publish(ctx, UserChannel("user0"), payload)
+				}
+			}
+			for received.Load() < perMessage*int64(b.N) {
+				time.Sleep(time.Millisecond)
+			}
+			b.StopTimer()
+			b.ReportMetric(float64((cpuTime()-start).Microseconds())/float64(b.N), "cpu-µs/msg")
+			require.Equal(b, 2*int64(b.N), relevant.Load(), "both participants get every message")
+
+			for _, pubsub := range pubsubs {
+				pubsub.Close()
+			}
+			wg.Wait()
+		})
+	}
+}
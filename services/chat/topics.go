@@ -0,0 +1,150 @@
+package chat
+
+import (
+	"context"
+	"exc6/config"
+	"exc6/pkg/logger"
+	"strconv"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+// Messages are produced to a Kafka topic per class so each can be retained
+// as long as it has to be: direct messages for compliance, group messages
+// and system notices only until they are archived. A message and its changes
+// share a class, so they stay in one topic and in order.
+
+// Message classes
+const (
+	ClassDirect = "direct"
+	ClassGroup  = "group"
+	ClassSystem = "system"
+)
+
+// topicAdminTimeout bounds creating and describing the topics
+const topicAdminTimeout = 10 * time.Second
+
+// Topics are the Kafka topics of each message class
+type Topics struct {
+	Direct string
+	Group  string
+	System string
+}
+
+// TopicsFrom returns the topics named by cfg
+func TopicsFrom(cfg config.KafkaConfig) Topics {
+	return Topics{Direct: cfg.Direct.Topic, Group: cfg.Group.Topic, System: cfg.System.Topic}
+}
+
+// Class returns the class of a message or change record
+func Class(msg *ChatMessage) string {
+	switch {
+	case msg.Subtype == SubtypeSystem:
+		return ClassSystem
+	case msg.IsGroup || msg.GroupID != "":
+		return ClassGroup
+	default:
+		return ClassDirect
+	}
+}
+
+// For returns the topic msg is produced to
+func (t Topics) For(msg *ChatMessage) string {
+	switch Class(msg) {
+	case ClassSystem:
+		return t.System
+	case ClassGroup:
+		return t.Group
+	default:
+		return t.Direct
+	}
+}
+
+// All returns every topic, once each
+func (t Topics) All() []string {
+	var topics []string
+	seen := make(map[string]bool)
+	for _, topic := range []string{t.Direct, t.Group, t.System} {
+		if topic == "" || seen[topic] {
+			continue
+		}
+		seen[topic] = true
+		topics = append(topics, topic)
+	}
+	return topics
+}
+
+// EnsureTopics creates the topics of cfg that are missing with their
+// retention. Existing topics are left as they are; a retention differing
+// from cfg is only logged, as changing it is up to the operators.
+func EnsureTopics(ctx context.Context, cfg config.KafkaConfig) error {
+	admin, err := kafka.NewAdminClient(&kafka.ConfigMap{
+		"bootstrap.servers": cfg.Address,
+		"client.id":         "go-fiber-dashboard",
+	})
+	if err != nil {
+		return err
+	}
+	defer admin.Close()
+
+	retention := map[string]time.Duration{
+		cfg.Direct.Topic: cfg.Direct.Retention,
+		cfg.Group.Topic:  cfg.Group.Retention,
+		cfg.System.Topic: cfg.System.Retention,
+	}
+
+	specs := make([]kafka.TopicSpecification, 0, len(retention))
+	for _, topic := range TopicsFrom(cfg).All() {
+		specs = append(specs, kafka.TopicSpecification{
+			Topic:             topic,
+			NumPartitions:     cfg.Partitions,
+			ReplicationFactor: cfg.ReplicationFactor,
+			Config:            map[string]string{"retention.ms": retentionMs(retention[topic])},
+		})
+	}
+
+	results, err := admin.CreateTopics(ctx, specs, kafka.SetAdminOperationTimeout(topicAdminTimeout))
+	if err != nil {
+		return err
+	}
+
+	var existing []kafka.ConfigResource
+	for _, result := range results {
+		switch result.Error.Code() {
+		case kafka.ErrNoError:
+			logger.WithFields(map[string]any{
+				"topic":     result.Topic,
+				"retention": retention[result.Topic].String(),
+			}).Info("Created Kafka topic")
+		case kafka.ErrTopicAlreadyExists:
+			existing = append(existing, kafka.ConfigResource{Type: kafka.ResourceTopic, Name: result.Topic})
+		default:
+			return result.Error
+		}
+	}
+	if len(existing) == 0 {
+		return nil
+	}
+
+	described, err := admin.DescribeConfigs(ctx, existing)
+	if err != nil {
+		return err
+	}
+	for _, resource := range described {
+		want := retentionMs(retention[resource.Name])
+		if got, ok := resource.Config["retention.ms"]; ok && got.Value != want {
+			logger.WithFields(map[string]any{
+				"topic":     resource.Name,
+				"retention": got.Value,
+				"expected":  want,
+			}).Warn("Kafka topic retention differs from its configuration")
+		}
+	}
+	return nil
+}
+
+// retentionMs formats a retention as the retention.ms topic setting
+func retentionMs(d time.Duration) string {
+	return strconv.FormatInt(d.Milliseconds(), 10)
+}
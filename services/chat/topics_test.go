@@ -0,0 +1,34 @@
+package chat
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTopicsFor(t *testing.T) {
+	topics := Topics{Direct: "direct", Group: "group", System: "system"}
+
+	tests := []struct {
+		name string
+		msg  ChatMessage
+		want string
+	}{
+		{name: "Direct message", msg: ChatMessage{FromID: "alice", ToID: "bob"}, want: "direct"},
+		{name: "Direct edit", msg: ChatMessage{FromID: "alice", ToID: "bob", Event: EventEdit}, want: "direct"},
+		{name: "Group message", msg: ChatMessage{FromID: "alice", GroupID: "g1", IsGroup: true}, want: "group"},
+		{name: "Group delete", msg: ChatMessage{FromID: "alice", GroupID: "g1", Event: EventDelete}, want: "group"},
+		{name: "System notice", msg: ChatMessage{FromID: "alice", ToID: "bob", Subtype: SubtypeSystem}, want: "system"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, topics.For(&tt.msg))
+		})
+	}
+}
+
+func TestTopicsAll(t *testing.T) {
+	assert.Equal(t, []string{"direct", "group", "system"}, Topics{Direct: "direct", Group: "group", System: "system"}.All())
+	assert.Equal(t, []string{"history"}, Topics{Direct: "history", Group: "history"}.All())
+}
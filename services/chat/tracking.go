@@ -0,0 +1,372 @@
+package chat
+
+import (
+	"context"
+	"exc6/apperrors"
+	"exc6/pkg/breaker"
+	"exc6/pkg/keyspace"
+	"exc6/pkg/logger"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Group admins can ask for read receipts on an announcement. Only those
+// messages are tracked: the first time each member present when it was sent
+// receives it and reads it is recorded against the message, and the sender
+// and group admins can see who has. Ordinary group messages record nothing
+// per member, and tracking data expires after TrackedMessageTTL.
+
+const (
+	// TrackedMessageTTL is how long delivery and read status is kept
+	TrackedMessageTTL = 30 * 24 * time.Hour
+
+	// MaxTrackedListed is the number of tracked messages listed per group
+	MaxTrackedListed = 100
+
+	trackedPrefix = "chat:tracked:"
+)
+
+func init() {
+	keyspace.Register(keyspace.Family{Prefix: trackedPrefix, Description: "delivery and read status of group messages sent with read receipts"})
+}
+
+// WithTracking records delivery and read status of the message for each of
+// recipients
+func WithTracking(recipients []string) SendOption {
+	return func(msg *ChatMessage) {
+		msg.Tracked = true
+		msg.recipients = recipients
+	}
+}
+
+// MemberReceipt is one recipient's status, as Unix times; zero means not yet
+type MemberReceipt struct {
+	Username    string `json:"username"`
+	DeliveredAt int64  `json:"delivered_at,omitempty"`
+	ReadAt      int64  `json:"read_at,omitempty"`
+}
+
+// TrackedMessage summarizes the receipts of a tracked message
+type TrackedMessage struct {
+	MessageID  string          `json:"message_id"`
+	GroupID    string          `json:"group_id"`
+	From       string          `json:"from"`
+	Timestamp  int64           `json:"timestamp"`
+	Recipients int64           `json:"recipients"`
+	Delivered  int64           `json:"delivered"`
+	Read       int64           `json:"read"`
+	Members    []MemberReceipt `json:"members,omitempty"`
+}
+
+// trackedRef is a tracked message and when it was sent
+type trackedRef struct {
+	id        string
+	timestamp int64
+}
+
+// trackMessage queues the records of a tracked message on pipe
+func (cs *ChatService) trackMessage(ctx context.Context, pipe redis.Pipeliner, msg *ChatMessage) {
+	expiresAt := trackedExpiry(msg.Timestamp)
+	index := trackedGroupKey(msg.GroupID)
+
+	pipe.HSet(ctx, trackedKey(msg.MessageID), "group_id", msg.GroupID, "from", msg.FromID, "timestamp", msg.Timestamp)
+	pipe.ExpireAt(ctx, trackedKey(msg.MessageID), expiresAt)
+
+	if len(msg.recipients) > 0 {
+		members := make([]any, len(msg.recipients))
+		for i, r := range msg.recipients {
+			members[i] = r
+		}
+		pipe.SAdd(ctx, recipientsKey(msg.MessageID), members...)
+		pipe.ExpireAt(ctx, recipientsKey(msg.MessageID), expiresAt)
+	}
+
+	pipe.ZAdd(ctx, index, redis.Z{Score: float64(msg.Timestamp), Member: msg.MessageID})
+	pipe.ZRemRangeByScore(ctx, index, "-inf", "("+strconv.FormatInt(trackedSince(), 10))
+	pipe.Expire(ctx, index, TrackedMessageTTL)
+}
+
+// MarkTrackedDelivered records that a group message reached username, if it
+// is tracked and they are one of its recipients
+func (cs *ChatService) MarkTrackedDelivered(ctx context.Context, username, groupID, messageID string) error {
+	result, err := breaker.ExecuteCtx(ctx, cs.cbRedis, func() (any, error) {
+		return cs.rdb.ZScore(ctx, trackedGroupKey(groupID), messageID).Result()
+	})
+	if err != nil {
+		return err
+	}
+	score, ok := result.(float64)
+	if !ok {
+		// Not tracked
+		return nil
+	}
+
+	return cs.recordReceipts(ctx, username, []trackedRef{{id: messageID, timestamp: int64(score)}}, false)
+}
+
+// MarkGroupDelivered records that username received every tracked message of
+// the group, e.g. when they load its history
+func (cs *ChatService) MarkGroupDelivered(ctx context.Context, username, groupID string) error {
+	refs, err := cs.trackedUpTo(ctx, groupID, "", time.Now().Unix())
+	if err != nil {
+		return err
+	}
+	return cs.recordReceipts(ctx, username, refs, false)
+}
+
+// MarkTrackedRead applies a group read receipt to tracked messages: username
+// read messageID and, when upTo is set, everything sent until that Unix time.
+// A read message counts as delivered too.
+func (cs *ChatService) MarkTrackedRead(ctx context.Context, username, groupID, messageID string, upTo int64) error {
+	// Clients cannot read ahead of the clock
+	upTo = min(upTo, time.Now().Unix())
+
+	refs, err := cs.trackedUpTo(ctx, groupID, messageID, upTo)
+	if err != nil {
+		return err
+	}
+	return cs.recordReceipts(ctx, username, refs, true)
+}
+
+// trackedUpTo returns the group's tracked messages sent until upTo, plus
+// messageID if it is tracked
+func (cs *ChatService) trackedUpTo(ctx context.Context, groupID, messageID string, upTo int64) ([]trackedRef, error) {
+	index := trackedGroupKey(groupID)
+
+	var byScore *redis.ZSliceCmd
+	var own *redis.FloatCmd
+	_, err := breaker.ExecuteCtx(ctx, cs.cbRedis, func() (any, error) {
+		pipe := cs.rdb.Pipeline()
+		if upTo > 0 {
+			byScore = pipe.ZRangeByScoreWithScores(ctx, index, &redis.ZRangeBy{
+				Min: strconv.FormatInt(trackedSince(), 10),
+				Max: strconv.FormatInt(upTo, 10),
+			})
+		}
+		if messageID != "" {
+			own = pipe.ZScore(ctx, index, messageID)
+		}
+		_, err := pipe.Exec(ctx)
+		if err == redis.Nil {
+			// The receipt's message is not tracked
+			err = nil
+		}
+		return nil, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	var refs []trackedRef
+	if byScore != nil {
+		for _, z := range byScore.Val() {
+			id, _ := z.Member.(string)
+			seen[id] = true
+			refs = append(refs, trackedRef{id: id, timestamp: int64(z.Score)})
+		}
+	}
+	if own != nil && own.Err() == nil && !seen[messageID] {
+		refs = append(refs, trackedRef{id: messageID, timestamp: int64(own.Val())})
+	}
+	return refs, nil
+}
+
+// recordReceipts sets the delivery, and read if read is set, time of username
+// on each message they are a recipient of. Only the first time is kept.
+func (cs *ChatService) recordReceipts(ctx context.Context, username string, refs []trackedRef, read bool) error {
+	if len(refs) == 0 {
+		return nil
+	}
+
+	now := time.Now().Unix()
+	_, err := breaker.ExecuteCtx(ctx, cs.cbRedis, func() (any, error) {
+		checks := make([]*redis.BoolCmd, len(refs))
+		pipe := cs.rdb.Pipeline()
+		for i, ref := range refs {
+			checks[i] = pipe.SIsMember(ctx, recipientsKey(ref.id), username)
+		}
+		if _, err := pipe.Exec(ctx); err != nil {
+			return nil, err
+		}
+
+		pipe = cs.rdb.Pipeline()
+		queued := 0
+		for i, ref := range refs {
+			if !checks[i].Val() {
+				continue
+			}
+			expiresAt := trackedExpiry(ref.timestamp)
+			pipe.HSetNX(ctx, deliveredKey(ref.id), username, now)
+			pipe.ExpireAt(ctx, deliveredKey(ref.id), expiresAt)
+			if read {
+				pipe.HSetNX(ctx, readKey(ref.id), username, now)
+				pipe.ExpireAt(ctx, readKey(ref.id), expiresAt)
+			}
+			queued++
+		}
+		if queued == 0 {
+			return nil, nil
+		}
+		_, err := pipe.Exec(ctx)
+		return nil, err
+	})
+
+	if err != nil {
+		logger.WithFields(map[string]any{
+			"username": username,
+			"messages": len(refs),
+			"read":     read,
+			"error":    err.Error(),
+		}).Warn("Circuit breaker: Failed to record message receipts")
+	}
+	return err
+}
+
+// TrackedReceipts returns the status of each recipient of a tracked message
+func (cs *ChatService) TrackedReceipts(ctx context.Context, groupID, messageID string) (*TrackedMessage, error) {
+	var meta, delivered, read *redis.MapStringStringCmd
+	var recipients *redis.StringSliceCmd
+	_, err := breaker.ExecuteCtx(ctx, cs.cbRedis, func() (any, error) {
+		pipe := cs.rdb.Pipeline()
+		meta = pipe.HGetAll(ctx, trackedKey(messageID))
+		recipients = pipe.SMembers(ctx, recipientsKey(messageID))
+		delivered = pipe.HGetAll(ctx, deliveredKey(messageID))
+		read = pipe.HGetAll(ctx, readKey(messageID))
+		_, err := pipe.Exec(ctx)
+		return nil, err
+	})
+	if err != nil {
+		return nil, apperrors.NewCacheError("tracked_receipts", trackedKey(messageID), err)
+	}
+
+	fields := meta.Val()
+	if fields["group_id"] != groupID {
+		return nil, apperrors.New(apperrors.ErrCodeNotFound, "No read receipts were requested for this message", http.StatusNotFound)
+	}
+
+	tm := trackedMessage(messageID, fields)
+	deliveredAt, readAt := delivered.Val(), read.Val()
+	for _, username := range recipients.Val() {
+		r := MemberReceipt{
+			Username:    username,
+			DeliveredAt: parseUnix(deliveredAt[username]),
+			ReadAt:      parseUnix(readAt[username]),
+		}
+		if r.DeliveredAt > 0 {
+			tm.Delivered++
+		}
+		if r.ReadAt > 0 {
+			tm.Read++
+		}
+		tm.Members = append(tm.Members, r)
+	}
+	tm.Recipients = int64(len(tm.Members))
+	sort.Slice(tm.Members, func(i, j int) bool { return tm.Members[i].Username < tm.Members[j].Username })
+
+	return tm, nil
+}
+
+// TrackedMessages returns the counts of the group's most recent tracked
+// messages, newest first
+func (cs *ChatService) TrackedMessages(ctx context.Context, groupID string, limit int) ([]TrackedMessage, error) {
+	result, err := breaker.ExecuteCtx(ctx, cs.cbRedis, func() (any, error) {
+		return cs.rdb.ZRevRangeByScore(ctx, trackedGroupKey(groupID), &redis.ZRangeBy{
+			Min:   strconv.FormatInt(trackedSince(), 10),
+			Max:   "+inf",
+			Count: int64(limit),
+		}).Result()
+	})
+	if err != nil {
+		return nil, apperrors.NewCacheError("tracked_messages", trackedGroupKey(groupID), err)
+	}
+
+	ids, _ := result.([]string)
+	if len(ids) == 0 {
+		return []TrackedMessage{}, nil
+	}
+
+	type counts struct {
+		meta                        *redis.MapStringStringCmd
+		recipients, delivered, read *redis.IntCmd
+	}
+	cmds := make([]counts, len(ids))
+	_, err = breaker.ExecuteCtx(ctx, cs.cbRedis, func() (any, error) {
+		pipe := cs.rdb.Pipeline()
+		for i, id := range ids {
+			cmds[i] = counts{
+				meta:       pipe.HGetAll(ctx, trackedKey(id)),
+				recipients: pipe.SCard(ctx, recipientsKey(id)),
+				delivered:  pipe.HLen(ctx, deliveredKey(id)),
+				read:       pipe.HLen(ctx, readKey(id)),
+			}
+		}
+		_, err := pipe.Exec(ctx)
+		return nil, err
+	})
+	if err != nil {
+		return nil, apperrors.NewCacheError("tracked_messages", trackedGroupKey(groupID), err)
+	}
+
+	list := make([]TrackedMessage, 0, len(ids))
+	for i, id := range ids {
+		fields := cmds[i].meta.Val()
+		if fields["group_id"] != groupID {
+			// Expired since the index was read
+			continue
+		}
+		tm := trackedMessage(id, fields)
+		tm.Recipients = cmds[i].recipients.Val()
+		tm.Delivered = cmds[i].delivered.Val()
+		tm.Read = cmds[i].read.Val()
+		list = append(list, *tm)
+	}
+	return list, nil
+}
+
+func trackedMessage(id string, fields map[string]string) *TrackedMessage {
+	return &TrackedMessage{
+		MessageID: id,
+		GroupID:   fields["group_id"],
+		From:      fields["from"],
+		Timestamp: parseUnix(fields["timestamp"]),
+	}
+}
+
+// trackedSince is the send time of the oldest message still tracked
+func trackedSince() int64 {
+	return time.Now().Add(-TrackedMessageTTL).Unix()
+}
+
+func trackedExpiry(timestamp int64) time.Time {
+	return time.Unix(timestamp, 0).Add(TrackedMessageTTL)
+}
+
+func parseUnix(s string) int64 {
+	v, _ := strconv.ParseInt(s, 10, 64)
+	return v
+}
+
+func trackedKey(messageID string) string {
+	return trackedPrefix + messageID
+}
+
+func recipientsKey(messageID string) string {
+	return trackedPrefix + messageID + ":recipients"
+}
+
+func deliveredKey(messageID string) string {
+	return trackedPrefix + messageID + ":delivered"
+}
+
+func readKey(messageID string) string {
+	return trackedPrefix + messageID + ":read"
+}
+
+func trackedGroupKey(groupID string) string {
+	return trackedPrefix + "group:" + groupID
+}
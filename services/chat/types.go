@@ -1,5 +1,13 @@
 package chat
 
+import (
+	"exc6/services/notifications"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+)
+
 type ChatMessage struct {
 	MessageID string `json:"id"`
 	FromID    string `json:"from"`
@@ -8,4 +16,102 @@ type ChatMessage struct {
 	Content   string `json:"content"`
 	Timestamp int64  `json:"timestamp"`
 	IsGroup   bool   `json:"is_group"`
+	Subtype   string `json:"subtype,omitempty"` // empty for plain text, see Subtype* constants
+
+	// Tracked is set on group messages sent with read receipts
+	Tracked bool `json:"tracked,omitempty"`
+
+	// Mentions are the group members mentioned with @username
+	Mentions []string `json:"mentions,omitempty"`
+
+	// EditedAt is when the sender last changed the content, in unix seconds
+	EditedAt int64 `json:"edited_at,omitempty"`
+
+	// Deleted marks a tombstone: the sender withdrew the message and it has
+	// no content
+	Deleted bool `json:"deleted,omitempty"`
+
+	// DeliveredAt and ReadAt are when the recipient of a direct message
+	// received and read it, in unix seconds. They are filled in for the
+	// sender when history is loaded and carried by receipt events.
+	DeliveredAt int64 `json:"delivered_at,omitempty"`
+	ReadAt      int64 `json:"read_at,omitempty"`
+
+	// Event is set on the edit, delete, receipt and reaction records
+	// published, and the edit and delete records written to Kafka, see
+	// Event* constants; stored messages never carry it
+	Event string `json:"event,omitempty"`
+
+	// Reactions are all reactions to the message after a reaction event;
+	// Content carries the emoji added or removed and FromID its reactor
+	Reactions []Reaction `json:"reactions,omitempty"`
+
+	// Checksum is the ContentChecksum taken when the message was sent or
+	// last changed, see Seal
+	Checksum string `json:"checksum,omitempty"`
+
+	// Seq is the message's place in its conversation, see stampSeq; zero
+	// when it could not be counted. The checksum does not cover it.
+	Seq int64 `json:"seq,omitempty"`
+
+	// recipients are the members whose receipts a tracked message records
+	recipients []string
+}
+
+// Message subtypes. Content carries the subtype's payload (e.g. the GIF URL).
+const (
+	SubtypeText = ""
+	SubtypeGIF  = "gif"
+
+	// SubtypeAttachment carries the ID of an attachment the sender
+	// encrypted; clients fetch its blob and key envelope by it
+	SubtypeAttachment = "attachment"
+
+	// SubtypeSystem is a notice about the conversation, such as the two
+	// users becoming friends, rather than something either of them wrote
+	SubtypeSystem = "system"
+)
+
+// NewSystemMessage builds a system notice in the conversation between from
+// and to; from is the user whose action it announces
+func NewSystemMessage(from, to, content string) *ChatMessage {
+	msg := &ChatMessage{
+		MessageID: uuid.NewString(),
+		FromID:    from,
+		ToID:      to,
+		Content:   content,
+		Timestamp: time.Now().Unix(),
+		Subtype:   SubtypeSystem,
+	}
+	msg.Seal()
+	return msg
+}
+
+// SendOption customizes an outgoing message
+type SendOption func(*ChatMessage)
+
+// WithSubtype marks a message as a rich subtype
+func WithSubtype(subtype string) SendOption {
+	return func(msg *ChatMessage) {
+		msg.Subtype = subtype
+	}
+}
+
+// WithMentions tags a group message with the members it mentions with
+// @username, the sender aside
+func WithMentions(members []string) SendOption {
+	return func(msg *ChatMessage) {
+		msg.Mentions = mentionedMembers(msg.Content, msg.FromID, members)
+	}
+}
+
+// mentionedMembers returns the members mentioned in content other than from
+func mentionedMembers(content, from string, members []string) []string {
+	var mentioned []string
+	for _, username := range notifications.Mentions(content) {
+		if username != from && slices.Contains(members, username) {
+			mentioned = append(mentioned, username)
+		}
+	}
+	return mentioned
 }
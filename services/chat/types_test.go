@@ -0,0 +1,32 @@
+package chat
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithMentions(t *testing.T) {
+	members := []string{"bob", "carol", "alice"}
+
+	tests := []struct {
+		name    string
+		content string
+		want    []string
+	}{
+		{name: "Member", content: "@bob look", want: []string{"bob"}},
+		{name: "Several", content: "@carol and @bob, @carol", want: []string{"carol", "bob"}},
+		{name: "Not a member", content: "@mallory hi"},
+		{name: "Sender", content: "@alice note to self"},
+		{name: "Email", content: "mail bob@example.com"},
+		{name: "None", content: "hello"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := &ChatMessage{FromID: "alice", Content: tt.content}
+			WithMentions(members)(msg)
+			assert.Equal(t, tt.want, msg.Mentions)
+		})
+	}
+}
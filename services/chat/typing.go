@@ -0,0 +1,66 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"exc6/apperrors"
+	"exc6/pkg/breaker"
+	"time"
+)
+
+// Typing events are ephemeral: they are published to the conversation and
+// kept nowhere else. A marker key per typist and conversation lives for
+// TypingDedupTTL, and events arriving while it exists are dropped, so a
+// client repeating the event on every keystroke costs one publish per window
+// across all instances.
+
+// EventTyping is carried by ChatMessage.Event for typing events
+const EventTyping = "typing"
+
+const typingPrefix = "chat:typing:"
+
+const (
+	// TypingDedupTTL is how long repeats of a typing event are suppressed
+	TypingDedupTTL = 2 * time.Second
+
+	// TypingDisplay is how long clients show the indicator after an event
+	TypingDisplay = 5 * time.Second
+)
+
+// PublishTyping tells the other participants of a conversation, with to or in
+// groupID, that from is typing. Group membership is the caller's to check.
+func (cs *ChatService) PublishTyping(ctx context.Context, from, to, groupID string) error {
+	if (to == "") == (groupID == "") || to == from {
+		return apperrors.NewBadRequest("Either a contact or a group is required")
+	}
+
+	key := typingPrefix + receiptField(to, groupID) + ":" + from
+	channel := UserChannel(to)
+	if groupID != "" {
+		channel = GroupChannel(groupID)
+	}
+
+	event, err := json.Marshal(&ChatMessage{
+		FromID:    from,
+		ToID:      to,
+		GroupID:   groupID,
+		IsGroup:   groupID != "",
+		Timestamp: time.Now().Unix(),
+		Event:     EventTyping,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = breaker.ExecuteCtx(ctx, cs.cbRedis, func() (any, error) {
+		fresh, err := cs.rdb.SetNX(ctx, key, 1, TypingDedupTTL).Result()
+		if err != nil || !fresh {
+			return nil, err
+		}
+		return nil, cs.rdb.Publish(ctx, channel, event).Err()
+	})
+	if err != nil {
+		return apperrors.NewCacheError("typing_publish", key, err)
+	}
+	return nil
+}
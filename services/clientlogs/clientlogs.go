@@ -0,0 +1,289 @@
+package clientlogs
+
+import (
+	"context"
+	"encoding/json"
+	"exc6/apperrors"
+	"exc6/config"
+	"exc6/pkg/breaker"
+	"exc6/pkg/instance"
+	"exc6/pkg/keyspace"
+	"exc6/pkg/logger"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/redis/go-redis/v9"
+	"github.com/sony/gobreaker"
+)
+
+// Browsers report their own errors in batches: uncaught JS errors, why a
+// WebSocket closed, and why a call failed. Reports are sampled, written to
+// the application log with the request IDs involved so they line up with
+// server-side logs, and the most recent ones are kept in Redis for the admin
+// view.
+
+const (
+	recentKey = "client_logs:recent"
+
+	// recentTTL drops the stored reports once nothing has been reported
+	// for a while
+	recentTTL = 7 * 24 * time.Hour
+
+	// MaxBatch is the number of reports accepted per request
+	MaxBatch = 50
+
+	maxMessageLength = 1000
+	maxStackLength   = 8000
+	maxFieldLength   = 500
+	maxContextKeys   = 20
+)
+
+func init() {
+	keyspace.Register(keyspace.Family{Prefix: recentKey, Description: "recent client-side error reports"})
+}
+
+// Kind classifies a report
+type Kind string
+
+const (
+	KindJSError      Kind = "js_error"
+	KindWSDisconnect Kind = "ws_disconnect"
+	KindCallFailure  Kind = "call_failure"
+)
+
+var kinds = map[Kind]bool{KindJSError: true, KindWSDisconnect: true, KindCallFailure: true}
+
+var reportsTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "client_log_reports_total",
+		Help: "Client-side error reports received, by kind and outcome",
+	},
+	[]string{"kind", "outcome"}, // outcome: accepted, sampled_out, invalid
+)
+
+func init() {
+	instance.Registerer().MustRegister(reportsTotal)
+}
+
+// Report is one error as sent by a client
+type Report struct {
+	Kind    Kind   `json:"kind"`
+	Message string `json:"message"`
+	Stack   string `json:"stack,omitempty"`
+	URL     string `json:"url,omitempty"`
+
+	// RequestID is the X-Request-ID of a server response the error relates to
+	RequestID string `json:"request_id,omitempty"`
+	CallID    string `json:"call_id,omitempty"`
+	Code      int    `json:"code,omitempty"` // e.g. the WebSocket close code
+
+	// OccurredAt is the client's clock, in Unix milliseconds
+	OccurredAt int64             `json:"occurred_at,omitempty"`
+	Context    map[string]string `json:"context,omitempty"`
+}
+
+// Entry is a stored report
+type Entry struct {
+	Report
+	Username        string    `json:"username"`
+	UserAgent       string    `json:"user_agent,omitempty"`
+	IngestRequestID string    `json:"ingest_request_id,omitempty"`
+	Instance        string    `json:"instance"`
+	ReceivedAt      time.Time `json:"received_at"`
+}
+
+// Result counts what happened to a batch
+type Result struct {
+	Accepted   int `json:"accepted"`
+	SampledOut int `json:"sampled_out"`
+	Invalid    int `json:"invalid"`
+}
+
+// Service ingests client error reports
+type Service struct {
+	rdb *redis.Client
+	cb  *gobreaker.CircuitBreaker
+	cfg config.ClientLogsConfig
+
+	// sample decides whether a report is kept; replaced in tests
+	sample func() bool
+}
+
+// NewService creates the service
+func NewService(rdb *redis.Client, cfg config.ClientLogsConfig) *Service {
+	return &Service{
+		rdb: rdb,
+		cfg: cfg,
+		sample: func() bool {
+			return rand.Float64() < cfg.SampleRate
+		},
+		cb: breaker.New(breaker.Config{
+			Name:        "redis-clientlogs",
+			MaxRequests: 3,
+			Interval:    60 * time.Second,
+			Timeout:     30 * time.Second,
+			Threshold:   0.5,
+			MinRequests: 5,
+		}),
+	}
+}
+
+// BatchesPerMinute is the per-user rate limit on batches
+func (s *Service) BatchesPerMinute() int64 {
+	return int64(s.cfg.PerMinute)
+}
+
+// Ingest logs and stores a batch of reports from username. ingestRequestID
+// is the ID of the request that carried the batch.
+func (s *Service) Ingest(ctx context.Context, username, userAgent, ingestRequestID string, reports []Report) (Result, error) {
+	var result Result
+	if len(reports) == 0 {
+		return result, apperrors.NewValidationError("No reports in the batch")
+	}
+	if len(reports) > MaxBatch {
+		return result, apperrors.NewValidationError(fmt.Sprintf("Batches are limited to %d reports", MaxBatch))
+	}
+
+	now := time.Now()
+	entries := make([]any, 0, len(reports))
+	for _, r := range reports {
+		if !kinds[r.Kind] || strings.TrimSpace(r.Message) == "" {
+			result.Invalid++
+			// Unknown kinds share a label so clients cannot add series
+			kind := string(r.Kind)
+			if !kinds[r.Kind] {
+				kind = "unknown"
+			}
+			reportsTotal.WithLabelValues(kind, "invalid").Inc()
+			continue
+		}
+		// Call failures are rare and each one matters
+		if r.Kind != KindCallFailure && !s.sample() {
+			result.SampledOut++
+			reportsTotal.WithLabelValues(string(r.Kind), "sampled_out").Inc()
+			continue
+		}
+
+		entry := Entry{
+			Report:          sanitize(r),
+			Username:        username,
+			UserAgent:       truncate(userAgent, maxFieldLength),
+			IngestRequestID: ingestRequestID,
+			Instance:        instance.ID(),
+			ReceivedAt:      now,
+		}
+		forward(entry)
+
+		if data, err := json.Marshal(entry); err == nil {
+			entries = append(entries, data)
+		}
+		result.Accepted++
+		reportsTotal.WithLabelValues(string(r.Kind), "accepted").Inc()
+	}
+
+	if len(entries) == 0 || s.cfg.MaxStored == 0 {
+		return result, nil
+	}
+
+	if _, err := breaker.ExecuteCtx(ctx, s.cb, func() (any, error) {
+		pipe := s.rdb.TxPipeline()
+		pipe.LPush(ctx, recentKey, entries...)
+		pipe.LTrim(ctx, recentKey, 0, int64(s.cfg.MaxStored-1))
+		pipe.Expire(ctx, recentKey, recentTTL)
+		_, err := pipe.Exec(ctx)
+		return nil, err
+	}); err != nil {
+		// The reports are already in the log
+		logger.WithError(err).Warn("Failed to store client error reports")
+	}
+
+	return result, nil
+}
+
+// Recent returns up to limit stored reports, newest first
+func (s *Service) Recent(ctx context.Context, limit int) ([]Entry, error) {
+	result, err := breaker.ExecuteCtx(ctx, s.cb, func() (any, error) {
+		return s.rdb.LRange(ctx, recentKey, 0, int64(limit-1)).Result()
+	})
+	if err != nil {
+		return nil, apperrors.NewCacheError("client_logs_recent", recentKey, err)
+	}
+
+	raw, _ := result.([]string)
+	entries := make([]Entry, 0, len(raw))
+	for _, item := range raw {
+		var e Entry
+		if err := json.Unmarshal([]byte(item), &e); err != nil {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// lineBreaks keeps client text on one log line so it cannot forge entries
+var lineBreaks = strings.NewReplacer("\r\n", `\n`, "\n", `\n`, "\r", `\n`)
+
+// forward writes a report to the application log
+func forward(e Entry) {
+	fields := map[string]any{
+		"source":            "client",
+		"kind":              e.Kind,
+		"username":          e.Username,
+		"ingest_request_id": e.IngestRequestID,
+	}
+	if e.RequestID != "" {
+		fields["request_id"] = lineBreaks.Replace(e.RequestID)
+	}
+	if e.CallID != "" {
+		fields["call_id"] = lineBreaks.Replace(e.CallID)
+	}
+	if e.Code != 0 {
+		fields["code"] = e.Code
+	}
+	if e.URL != "" {
+		fields["url"] = lineBreaks.Replace(e.URL)
+	}
+	if e.Stack != "" {
+		fields["stack"] = lineBreaks.Replace(e.Stack)
+	}
+	for k, v := range e.Context {
+		fields["ctx_"+lineBreaks.Replace(k)] = lineBreaks.Replace(v)
+	}
+
+	logger.WithFields(fields).Warn("Client error: %s", lineBreaks.Replace(e.Message))
+}
+
+// sanitize bounds the size of a report
+func sanitize(r Report) Report {
+	r.Message = truncate(r.Message, maxMessageLength)
+	r.Stack = truncate(r.Stack, maxStackLength)
+	r.URL = truncate(r.URL, maxFieldLength)
+	r.RequestID = truncate(r.RequestID, 100)
+	r.CallID = truncate(r.CallID, 100)
+
+	if len(r.Context) > 0 {
+		ctx := make(map[string]string, min(len(r.Context), maxContextKeys))
+		for k, v := range r.Context {
+			if len(ctx) == maxContextKeys {
+				break
+			}
+			ctx[truncate(k, 50)] = truncate(v, maxFieldLength)
+		}
+		r.Context = ctx
+	}
+	return r
+}
+
+// truncate cuts s to at most n runes
+func truncate(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	runes := []rune(s)
+	return string(runes[:n]) + "…"
+}
@@ -0,0 +1,86 @@
+package clientlogs
+
+import (
+	"context"
+	"exc6/config"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIngest(t *testing.T) {
+	// MaxStored 0 keeps Redis out of the test
+	svc := NewService(nil, config.ClientLogsConfig{SampleRate: 1, PerMinute: 10})
+
+	tests := []struct {
+		name    string
+		sample  bool
+		reports []Report
+		want    Result
+		wantErr bool
+	}{
+		{
+			name:    "empty batch",
+			reports: nil,
+			wantErr: true,
+		},
+		{
+			name:    "oversized batch",
+			reports: make([]Report, MaxBatch+1),
+			wantErr: true,
+		},
+		{
+			name:   "valid and invalid reports",
+			sample: true,
+			reports: []Report{
+				{Kind: KindJSError, Message: "boom"},
+				{Kind: "made_up", Message: "boom"},
+				{Kind: KindWSDisconnect, Message: "  "},
+			},
+			want: Result{Accepted: 1, Invalid: 2},
+		},
+		{
+			name:   "call failures are never sampled out",
+			sample: false,
+			reports: []Report{
+				{Kind: KindJSError, Message: "boom"},
+				{Kind: KindCallFailure, Message: "ice failed", CallID: "c1"},
+			},
+			want: Result{Accepted: 1, SampledOut: 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc.sample = func() bool { return tt.sample }
+
+			got, err := svc.Ingest(context.Background(), "alice", "test", "req-1", tt.reports)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestSanitize(t *testing.T) {
+	ctx := make(map[string]string)
+	for i := range maxContextKeys + 5 {
+		ctx[strings.Repeat("k", i+1)] = "v"
+	}
+
+	r := sanitize(Report{
+		Message: strings.Repeat("é", maxMessageLength+10),
+		URL:     strings.Repeat("u", maxFieldLength+1),
+		Context: ctx,
+	})
+
+	assert.Equal(t, maxMessageLength+1, len([]rune(r.Message)))
+	assert.True(t, strings.HasSuffix(r.Message, "…"))
+	assert.Equal(t, maxFieldLength+1, len([]rune(r.URL)))
+	assert.Len(t, r.Context, maxContextKeys)
+}
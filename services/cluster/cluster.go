@@ -0,0 +1,209 @@
+package cluster
+
+import (
+	"context"
+	"exc6/pkg/breaker"
+	"exc6/pkg/instance"
+	"exc6/pkg/keyspace"
+	"exc6/pkg/logger"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/sony/gobreaker"
+)
+
+const (
+	// instancesKey is a sorted set of instance IDs scored by last heartbeat (unix seconds)
+	instancesKey = "cluster:instances"
+
+	// instanceKeyPrefix prefixes the per-instance metadata hash
+	instanceKeyPrefix = "cluster:instance:"
+
+	heartbeatInterval = 10 * time.Second
+
+	// An instance that missed three heartbeats is no longer listed
+	staleAfter = 3 * heartbeatInterval
+)
+
+func init() {
+	keyspace.Register(
+		keyspace.Family{Prefix: instancesKey, Description: "live instances by last heartbeat"},
+		keyspace.Family{Prefix: instanceKeyPrefix, Description: "metadata per instance"},
+	)
+}
+
+// Instance describes a server instance seen through its heartbeat
+type Instance struct {
+	ID          string    `json:"id"`
+	Hostname    string    `json:"hostname"`
+	StartedAt   time.Time `json:"started_at"`
+	LastSeen    time.Time `json:"last_seen"`
+	Connections int       `json:"ws_connections"`
+	Self        bool      `json:"self"`
+}
+
+// ClusterService publishes this instance's heartbeat to Redis and lists
+// the instances that have been seen recently
+type ClusterService struct {
+	rdb    *redis.Client
+	cb     *gobreaker.CircuitBreaker
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu                sync.RWMutex
+	connectionCounter func() int
+}
+
+// NewClusterService creates the service and starts the heartbeat loop
+func NewClusterService(ctx context.Context, rdb *redis.Client) *ClusterService {
+	bgCtx, cancel := context.WithCancel(ctx)
+
+	cs := &ClusterService{
+		rdb:    rdb,
+		ctx:    bgCtx,
+		cancel: cancel,
+		cb: breaker.New(breaker.Config{
+			Name:        "redis-cluster",
+			MaxRequests: 3,
+			Interval:    60 * time.Second,
+			Timeout:     30 * time.Second,
+			Threshold:   0.5,
+			MinRequests: 5,
+		}),
+	}
+
+	go cs.heartbeatLoop()
+
+	return cs
+}
+
+// SetConnectionCounter registers a function reporting the local WebSocket connection count
+func (cs *ClusterService) SetConnectionCounter(fn func() int) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.connectionCounter = fn
+}
+
+func (cs *ClusterService) heartbeatLoop() {
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+
+	cs.heartbeat()
+
+	for {
+		select {
+		case <-ticker.C:
+			cs.heartbeat()
+		case <-cs.ctx.Done():
+			return
+		}
+	}
+}
+
+// heartbeat records this instance as alive
+func (cs *ClusterService) heartbeat() {
+	ctx, cancel := context.WithTimeout(cs.ctx, 3*time.Second)
+	defer cancel()
+
+	cs.mu.RLock()
+	counter := cs.connectionCounter
+	cs.mu.RUnlock()
+
+	connections := 0
+	if counter != nil {
+		connections = counter()
+	}
+
+	now := time.Now()
+	key := instanceKeyPrefix + instance.ID()
+
+	_, err := breaker.ExecuteCtx(ctx, cs.cb, func() (interface{}, error) {
+		pipe := cs.rdb.Pipeline()
+		pipe.ZAdd(ctx, instancesKey, redis.Z{Score: float64(now.Unix()), Member: instance.ID()})
+		pipe.HSet(ctx, key, map[string]any{
+			"hostname":       instance.Hostname(),
+			"started_at":     instance.StartedAt().Unix(),
+			"ws_connections": connections,
+		})
+		pipe.Expire(ctx, key, staleAfter)
+		pipe.Expire(ctx, instancesKey, staleAfter)
+		pipe.ZRemRangeByScore(ctx, instancesKey, "-inf", strconv.FormatInt(now.Add(-staleAfter).Unix(), 10))
+		_, err := pipe.Exec(ctx)
+		return nil, err
+	})
+
+	if err != nil {
+		logger.WithError(err).Warn("Circuit breaker: Failed to publish cluster heartbeat")
+	}
+}
+
+// ListInstances returns the instances that sent a heartbeat recently, oldest first
+func (cs *ClusterService) ListInstances(ctx context.Context) ([]Instance, error) {
+	result, err := breaker.ExecuteCtx(ctx, cs.cb, func() (interface{}, error) {
+		cutoff := strconv.FormatInt(time.Now().Add(-staleAfter).Unix(), 10)
+
+		members, err := cs.rdb.ZRangeByScoreWithScores(ctx, instancesKey, &redis.ZRangeBy{
+			Min: cutoff,
+			Max: "+inf",
+		}).Result()
+		if err != nil {
+			return nil, err
+		}
+
+		instances := make([]Instance, 0, len(members))
+		for _, member := range members {
+			id, ok := member.Member.(string)
+			if !ok {
+				continue
+			}
+
+			meta, err := cs.rdb.HGetAll(ctx, instanceKeyPrefix+id).Result()
+			if err != nil {
+				return nil, err
+			}
+
+			startedAt, _ := strconv.ParseInt(meta["started_at"], 10, 64)
+			connections, _ := strconv.Atoi(meta["ws_connections"])
+
+			instances = append(instances, Instance{
+				ID:          id,
+				Hostname:    meta["hostname"],
+				StartedAt:   time.Unix(startedAt, 0),
+				LastSeen:    time.Unix(int64(member.Score), 0),
+				Connections: connections,
+				Self:        id == instance.ID(),
+			})
+		}
+
+		sort.Slice(instances, func(i, j int) bool {
+			return instances[i].StartedAt.Before(instances[j].StartedAt)
+		})
+
+		return instances, nil
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to list cluster instances: %w", err)
+	}
+
+	return result.([]Instance), nil
+}
+
+// Close stops the heartbeat and removes this instance from the listing
+func (cs *ClusterService) Close() {
+	cs.cancel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	pipe := cs.rdb.Pipeline()
+	pipe.ZRem(ctx, instancesKey, instance.ID())
+	pipe.Del(ctx, instanceKeyPrefix+instance.ID())
+	if _, err := pipe.Exec(ctx); err != nil {
+		logger.WithError(err).Warn("Failed to deregister cluster instance")
+	}
+}
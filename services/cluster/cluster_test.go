@@ -0,0 +1,88 @@
+package cluster
+
+import (
+	"context"
+	"exc6/pkg/instance"
+	"exc6/tests/fakeredis"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// addInstance writes the heartbeat of another instance, last seen at seen
+func addInstance(t *testing.T, rdb *redis.Client, id string, startedAt, seen time.Time, connections int) {
+	t.Helper()
+	ctx := context.Background()
+	require.NoError(t, rdb.ZAdd(ctx, instancesKey, redis.Z{Score: float64(seen.Unix()), Member: id}).Err())
+	require.NoError(t, rdb.HSet(ctx, instanceKeyPrefix+id, map[string]any{
+		"hostname":       "host-" + id,
+		"started_at":     startedAt.Unix(),
+		"ws_connections": connections,
+	}).Err())
+}
+
+// listed returns the IDs ListInstances reports, in order
+func listed(t *testing.T, cs *ClusterService) []string {
+	t.Helper()
+	instances, err := cs.ListInstances(context.Background())
+	require.NoError(t, err)
+	ids := make([]string, len(instances))
+	for i, inst := range instances {
+		ids[i] = inst.ID
+	}
+	return ids
+}
+
+func TestListInstances(t *testing.T) {
+	srv := fakeredis.New(t)
+	rdb := srv.Client(t)
+
+	cs := NewClusterService(context.Background(), rdb)
+	cs.SetConnectionCounter(func() int { return 7 })
+	require.Eventually(t, func() bool { return srv.Exists(instanceKeyPrefix + instance.ID()) }, time.Second, 10*time.Millisecond)
+	cs.heartbeat()
+
+	now := time.Now()
+	addInstance(t, rdb, "older", instance.StartedAt().Add(-time.Hour), now, 3)
+	addInstance(t, rdb, "newer", instance.StartedAt().Add(time.Hour), now, 0)
+	addInstance(t, rdb, "stale", instance.StartedAt(), now.Add(-staleAfter-time.Second), 5)
+
+	instances, err := cs.ListInstances(context.Background())
+	require.NoError(t, err)
+	require.Len(t, instances, 3, "instances that missed their heartbeats are left out")
+
+	assert.Equal(t, []string{"older", instance.ID(), "newer"}, listed(t, cs), "oldest first")
+	self := instances[1]
+	assert.True(t, self.Self)
+	assert.Equal(t, instance.Hostname(), self.Hostname)
+	assert.Equal(t, 7, self.Connections)
+	assert.Equal(t, instance.StartedAt().Unix(), self.StartedAt.Unix())
+
+	assert.False(t, instances[0].Self)
+	assert.Equal(t, "host-older", instances[0].Hostname)
+	assert.Equal(t, 3, instances[0].Connections)
+	assert.Equal(t, now.Unix(), instances[0].LastSeen.Unix())
+
+	// The next heartbeat prunes the stale entry
+	cs.heartbeat()
+	_, err = rdb.ZScore(context.Background(), instancesKey, "stale").Result()
+	assert.ErrorIs(t, err, redis.Nil)
+	assert.Greater(t, srv.TTL(instanceKeyPrefix+instance.ID()), time.Duration(0))
+
+	cs.Close()
+	assert.Equal(t, []string{"older", "newer"}, listed(t, cs), "a closed instance deregisters")
+	assert.False(t, srv.Exists(instanceKeyPrefix+instance.ID()))
+}
+
+func TestListInstancesRedisDown(t *testing.T) {
+	srv := fakeredis.New(t)
+	cs := NewClusterService(context.Background(), srv.Client(t))
+	t.Cleanup(cs.Close)
+
+	srv.SetDown(true)
+	_, err := cs.ListInstances(context.Background())
+	assert.Error(t, err)
+}
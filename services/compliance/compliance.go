@@ -0,0 +1,312 @@
+package compliance
+
+import (
+	"context"
+	"encoding/json"
+	"exc6/apperrors"
+	"exc6/db"
+	"exc6/pkg/breaker"
+	"exc6/pkg/logger"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sony/gobreaker"
+)
+
+// Compliance teams manage the policy through the admin API instead of env
+// vars. Every change is stored as a new version in Postgres, which keeps the
+// history; the env vars only provide the policy in force until the first
+// change. Each instance polls for new versions and hands them to the services
+// that enforce them.
+
+const (
+	// pollInterval is how often each instance checks for a new version
+	pollInterval = 30 * time.Second
+
+	// HistoryLimit is the number of versions the history lists
+	HistoryLimit = 50
+
+	// MaxCommentLength bounds the note stored with a change
+	MaxCommentLength = 500
+)
+
+// Revision is one version of the policy
+type Revision struct {
+	Version   int32     `json:"version"`
+	Policy    Policy    `json:"policy"`
+	ChangedBy string    `json:"changed_by,omitempty"`
+	Comment   string    `json:"comment,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+
+	// Default is set for the policy from the environment, before any change
+	Default bool `json:"default,omitempty"`
+}
+
+// Listener applies a policy when it changes
+type Listener func(Policy)
+
+// Service stores the compliance policy and distributes changes
+type Service struct {
+	qdb      *db.Queries
+	cb       *gobreaker.CircuitBreaker
+	defaults Policy
+
+	mu        sync.RWMutex
+	current   Revision
+	listeners []Listener
+}
+
+// NewService creates the service, loads the policy in force and polls for
+// changes until ctx is cancelled. defaults is used until a policy is saved.
+func NewService(ctx context.Context, qdb *db.Queries, defaults Policy) *Service {
+	defaults.normalize()
+
+	s := &Service{
+		qdb:      qdb,
+		defaults: defaults,
+		current:  Revision{Policy: defaults, Default: true},
+		cb: breaker.New(breaker.Config{
+			Name:        "postgres-compliance",
+			MaxRequests: 5,
+			Interval:    60 * time.Second,
+			Timeout:     30 * time.Second,
+			Threshold:   0.5,
+			MinRequests: 5,
+		}),
+	}
+
+	loadCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	s.refresh(loadCtx)
+	cancel()
+
+	go s.run(ctx)
+
+	return s
+}
+
+// OnChange registers a listener and calls it with the policy in force
+func (s *Service) OnChange(fn Listener) {
+	s.mu.Lock()
+	s.listeners = append(s.listeners, fn)
+	policy := s.current.Policy
+	s.mu.Unlock()
+
+	fn(policy)
+}
+
+// Current returns the policy in force
+func (s *Service) Current() Revision {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.current
+}
+
+// Update validates and saves a new version of the policy. version must be
+// the version being replaced, so concurrent edits do not overwrite each
+// other.
+func (s *Service) Update(ctx context.Context, version int32, policy Policy, changedBy, comment string) (*Revision, error) {
+	policy.normalize()
+	comment = strings.TrimSpace(comment)
+
+	if problems := policy.Validate(); len(problems) > 0 {
+		return nil, apperrors.NewValidationError("The policy is invalid").
+			WithOperation("compliance_policy_validation").
+			WithDetails("violations", problems)
+	}
+	if len(comment) > MaxCommentLength {
+		return nil, apperrors.NewValidationError(fmt.Sprintf("Comments are limited to %d characters", MaxCommentLength))
+	}
+
+	if unknown, err := s.unknownUsers(ctx, policy.LegalHold.Users); err != nil {
+		return nil, err
+	} else if len(unknown) > 0 {
+		return nil, apperrors.NewValidationError("The policy is invalid").
+			WithOperation("compliance_policy_validation").
+			WithDetails("violations", []string{"legal_hold.users has unknown users: " + strings.Join(unknown, ", ")})
+	}
+
+	data, err := json.Marshal(policy)
+	if err != nil {
+		return nil, apperrors.NewInternalError("Failed to encode policy").WithInternal(err)
+	}
+
+	result, err := breaker.ExecuteCtx(ctx, s.cb, func() (any, error) {
+		row, err := s.qdb.CreateCompliancePolicy(ctx, db.CreateCompliancePolicyParams{
+			Version:   version + 1,
+			Policy:    data,
+			ChangedBy: changedBy,
+			Comment:   comment,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return row, nil
+	})
+	if err != nil {
+		return nil, apperrors.NewDatabaseError("save compliance policy", err)
+	}
+	row, ok := result.(db.CreateCompliancePolicyRow)
+	if !ok {
+		// sql.ErrNoRows, which the breaker does not count as a failure: the
+		// version was taken by another change
+		return nil, apperrors.New(apperrors.ErrCodeValidationFailed, "The policy was changed by someone else; reload it and retry", http.StatusConflict)
+	}
+
+	rev := Revision{
+		Version:   row.Version,
+		Policy:    policy,
+		ChangedBy: changedBy,
+		Comment:   comment,
+		CreatedAt: row.CreatedAt,
+	}
+	s.apply(rev)
+
+	logger.WithFields(map[string]any{
+		"version":    rev.Version,
+		"changed_by": changedBy,
+		"legal_hold": len(policy.LegalHold.Users),
+		"retention":  time.Duration(policy.Retention.Messages).String(),
+	}).Info("Compliance policy changed")
+
+	return &rev, nil
+}
+
+// History returns the most recent versions, newest first. The policy from
+// the environment is listed last until it has been replaced HistoryLimit times.
+func (s *Service) History(ctx context.Context) ([]Revision, error) {
+	result, err := breaker.ExecuteCtx(ctx, s.cb, func() (any, error) {
+		return s.qdb.ListCompliancePolicies(ctx, HistoryLimit)
+	})
+	if err != nil {
+		return nil, apperrors.NewDatabaseError("list compliance policies", err)
+	}
+
+	rows, _ := result.([]db.ListCompliancePoliciesRow)
+	history := make([]Revision, 0, len(rows)+1)
+	for _, row := range rows {
+		rev, err := revision(row.Version, row.Policy, row.ChangedBy.String, row.Comment, row.CreatedAt)
+		if err != nil {
+			logger.WithFields(map[string]any{
+				"version": row.Version,
+				"error":   err.Error(),
+			}).Warn("Skipping unreadable compliance policy")
+			continue
+		}
+		history = append(history, rev)
+	}
+	if len(rows) < HistoryLimit {
+		history = append(history, Revision{Policy: s.defaults, Default: true})
+	}
+
+	return history, nil
+}
+
+// unknownUsers returns the usernames that do not exist
+func (s *Service) unknownUsers(ctx context.Context, usernames []string) ([]string, error) {
+	if len(usernames) == 0 {
+		return nil, nil
+	}
+
+	result, err := breaker.ExecuteCtx(ctx, s.cb, func() (any, error) {
+		return s.qdb.GetUsersByUsernames(ctx, usernames)
+	})
+	if err != nil {
+		return nil, apperrors.NewDatabaseError("check legal hold users", err)
+	}
+
+	users, _ := result.([]db.User)
+	found := make(map[string]bool, len(users))
+	for _, u := range users {
+		found[u.Username] = true
+	}
+
+	var unknown []string
+	for _, name := range usernames {
+		if !found[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	return unknown, nil
+}
+
+func (s *Service) run(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			refreshCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+			s.refresh(refreshCtx)
+			cancel()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// refresh applies the latest stored version if it is newer than the current one
+func (s *Service) refresh(ctx context.Context) {
+	result, err := breaker.ExecuteCtx(ctx, s.cb, func() (any, error) {
+		row, err := s.qdb.GetLatestCompliancePolicy(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return row, nil
+	})
+	if err != nil {
+		// Keep the policy in force until the database is back
+		logger.WithError(err).Warn("Failed to load compliance policy")
+		return
+	}
+	row, ok := result.(db.GetLatestCompliancePolicyRow)
+	if !ok {
+		// Nothing saved yet
+		return
+	}
+
+	rev, err := revision(row.Version, row.Policy, row.ChangedBy.String, row.Comment, row.CreatedAt)
+	if err != nil {
+		logger.WithFields(map[string]any{
+			"version": row.Version,
+			"error":   err.Error(),
+		}).Error("Ignoring unreadable compliance policy")
+		return
+	}
+
+	s.apply(rev)
+}
+
+// apply makes rev current and notifies listeners, unless a version at least
+// as new is already in force
+func (s *Service) apply(rev Revision) {
+	s.mu.Lock()
+	if !s.current.Default && rev.Version <= s.current.Version {
+		s.mu.Unlock()
+		return
+	}
+	s.current = rev
+	listeners := s.listeners
+	s.mu.Unlock()
+
+	for _, fn := range listeners {
+		fn(rev.Policy)
+	}
+}
+
+func revision(version int32, data json.RawMessage, changedBy, comment string, createdAt time.Time) (Revision, error) {
+	rev := Revision{
+		Version:   version,
+		ChangedBy: changedBy,
+		Comment:   comment,
+		CreatedAt: createdAt,
+	}
+	if err := json.Unmarshal(data, &rev.Policy); err != nil {
+		return rev, err
+	}
+	rev.Policy.normalize()
+	return rev, nil
+}
@@ -0,0 +1,63 @@
+package compliance
+
+import (
+	"context"
+	"errors"
+	"exc6/apperrors"
+	"exc6/tests/fakedb"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newService(t *testing.T) (*Service, *fakedb.Fake, *[]Policy) {
+	fake := fakedb.New(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	s := NewService(ctx, fake.Queries(), validPolicy())
+	applied := new([]Policy)
+	s.OnChange(func(p Policy) { *applied = append(*applied, p) })
+	return s, fake, applied
+}
+
+func TestUpdate(t *testing.T) {
+	s, fake, applied := newService(t)
+	assert.True(t, s.Current().Default, "nothing saved yet")
+
+	created := time.Now().UTC().Truncate(time.Second)
+	fake.Return("CreateCompliancePolicy", fakedb.Row(int32(1), created))
+
+	policy := validPolicy()
+	policy.Retention.Messages = Duration(90 * 24 * time.Hour)
+
+	rev, err := s.Update(context.Background(), 0, policy, "admin", "keep messages for 90 days")
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), rev.Version)
+	assert.Equal(t, created, rev.CreatedAt)
+	assert.Equal(t, int32(1), s.Current().Version)
+	require.Len(t, *applied, 2)
+	assert.Equal(t, policy.Retention.Messages, (*applied)[1].Retention.Messages)
+}
+
+func TestUpdateConflict(t *testing.T) {
+	s, fake, applied := newService(t)
+
+	// ON CONFLICT DO NOTHING returns no row when the version is taken
+	fake.Return("CreateCompliancePolicy", fakedb.Result{})
+
+	policy := validPolicy()
+	policy.Retention.Messages = Duration(90 * 24 * time.Hour)
+
+	_, err := s.Update(context.Background(), 0, policy, "admin", "")
+	var appErr *apperrors.AppError
+	require.True(t, errors.As(err, &appErr))
+	assert.Equal(t, http.StatusConflict, appErr.StatusCode)
+
+	assert.True(t, s.Current().Default, "the losing policy is not applied")
+	assert.Len(t, *applied, 1, "listeners only saw the policy in force")
+}
@@ -0,0 +1,161 @@
+package compliance
+
+import (
+	"encoding/json"
+	"exc6/config"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Limits on what a policy may set
+const (
+	MinMessageRetention = 24 * time.Hour
+	MaxCallChatTTL      = 7 * 24 * time.Hour
+	MaxExportMessages   = 100000
+	MaxShareExpiry      = 90 * 24 * time.Hour
+	MaxLegalHoldUsers   = 1000
+)
+
+// Duration is a time.Duration written as a string such as "720h" in JSON
+type Duration time.Duration
+
+// MarshalJSON writes the duration as a string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON reads a duration string; an empty string is zero
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"720h\"")
+	}
+	if s == "" {
+		*d = 0
+		return nil
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("invalid duration %q", s)
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// Policy holds the organization's retention, legal hold, export and
+// moderation settings
+type Policy struct {
+	Retention  RetentionPolicy  `json:"retention"`
+	LegalHold  LegalHoldPolicy  `json:"legal_hold"`
+	Export     ExportPolicy     `json:"export"`
+	Moderation ModerationPolicy `json:"moderation"`
+}
+
+// RetentionPolicy sets how long conversations are kept
+type RetentionPolicy struct {
+	Messages Duration `json:"messages"`  // Age at which messages are deleted; zero keeps them forever
+	CallChat Duration `json:"call_chat"` // How long in-call messages are kept under the call
+}
+
+// LegalHoldPolicy exempts users' conversations from retention
+type LegalHoldPolicy struct {
+	Users  []string `json:"users"`
+	Reason string   `json:"reason,omitempty"`
+}
+
+// ExportPolicy limits transcripts and share links
+type ExportPolicy struct {
+	MaxMessages    int      `json:"max_messages"`
+	ShareLinks     bool     `json:"share_links"`
+	ShareMaxExpiry Duration `json:"share_max_expiry"`
+}
+
+// ModerationPolicy sets when abuse reports restrict an account
+type ModerationPolicy struct {
+	ReportThreshold     int      `json:"report_threshold"`
+	ReportWindow        Duration `json:"report_window"`
+	RestrictionDuration Duration `json:"restriction_duration"`
+}
+
+// DefaultPolicy is the policy in force until one is saved, taken from the
+// environment. Messages are kept forever and nobody is on hold.
+func DefaultPolicy(cfg *config.Config) Policy {
+	return Policy{
+		Retention: RetentionPolicy{
+			CallChat: Duration(cfg.CallChat.TTL),
+		},
+		Export: ExportPolicy{
+			MaxMessages:    cfg.Export.MaxMessages,
+			ShareLinks:     true,
+			ShareMaxExpiry: Duration(MaxShareExpiry),
+		},
+		Moderation: ModerationPolicy{
+			ReportThreshold:     cfg.Moderation.ReportThreshold,
+			ReportWindow:        Duration(cfg.Moderation.ReportWindow),
+			RestrictionDuration: Duration(cfg.Moderation.RestrictionDuration),
+		},
+	}
+}
+
+// ModerationConfig returns the moderation settings in the form the
+// moderation policy takes
+func (p Policy) ModerationConfig() config.ModerationConfig {
+	return config.ModerationConfig{
+		ReportThreshold:     p.Moderation.ReportThreshold,
+		ReportWindow:        time.Duration(p.Moderation.ReportWindow),
+		RestrictionDuration: time.Duration(p.Moderation.RestrictionDuration),
+	}
+}
+
+// normalize trims and sorts the legal hold list and drops duplicates
+func (p *Policy) normalize() {
+	users := make([]string, 0, len(p.LegalHold.Users))
+	for _, u := range p.LegalHold.Users {
+		if u = strings.TrimSpace(u); u != "" {
+			users = append(users, u)
+		}
+	}
+	slices.Sort(users)
+	p.LegalHold.Users = slices.Compact(users)
+	p.LegalHold.Reason = strings.TrimSpace(p.LegalHold.Reason)
+}
+
+// Validate lists what is wrong with the policy
+func (p Policy) Validate() []string {
+	var problems []string
+
+	if p.Retention.Messages != 0 && time.Duration(p.Retention.Messages) < MinMessageRetention {
+		problems = append(problems, fmt.Sprintf("retention.messages must be 0 (keep forever) or at least %s", MinMessageRetention))
+	}
+	if p.Retention.CallChat <= 0 || time.Duration(p.Retention.CallChat) > MaxCallChatTTL {
+		problems = append(problems, fmt.Sprintf("retention.call_chat must be between 1s and %s", MaxCallChatTTL))
+	}
+
+	if len(p.LegalHold.Users) > MaxLegalHoldUsers {
+		problems = append(problems, fmt.Sprintf("legal_hold.users is limited to %d users", MaxLegalHoldUsers))
+	}
+	if len(p.LegalHold.Users) > 0 && p.LegalHold.Reason == "" {
+		problems = append(problems, "legal_hold.reason is required while users are on hold")
+	}
+
+	if p.Export.MaxMessages < 1 || p.Export.MaxMessages > MaxExportMessages {
+		problems = append(problems, fmt.Sprintf("export.max_messages must be between 1 and %d", MaxExportMessages))
+	}
+	if p.Export.ShareMaxExpiry <= 0 || time.Duration(p.Export.ShareMaxExpiry) > MaxShareExpiry {
+		problems = append(problems, fmt.Sprintf("export.share_max_expiry must be between 1s and %s", MaxShareExpiry))
+	}
+
+	if p.Moderation.ReportThreshold < 1 {
+		problems = append(problems, "moderation.report_threshold must be >= 1")
+	}
+	if p.Moderation.ReportWindow <= 0 {
+		problems = append(problems, "moderation.report_window must be > 0")
+	}
+	if p.Moderation.RestrictionDuration <= 0 {
+		problems = append(problems, "moderation.restriction_duration must be > 0")
+	}
+
+	return problems
+}
@@ -0,0 +1,91 @@
+package compliance
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func validPolicy() Policy {
+	return Policy{
+		Retention: RetentionPolicy{CallChat: Duration(2 * time.Hour)},
+		Export:    ExportPolicy{MaxMessages: 5000, ShareLinks: true, ShareMaxExpiry: Duration(MaxShareExpiry)},
+		Moderation: ModerationPolicy{
+			ReportThreshold:     3,
+			ReportWindow:        Duration(24 * time.Hour),
+			RestrictionDuration: Duration(72 * time.Hour),
+		},
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		change  func(p *Policy)
+		invalid bool
+	}{
+		{name: "Valid", change: func(p *Policy) {}},
+		{name: "Retention too short", change: func(p *Policy) { p.Retention.Messages = Duration(time.Hour) }, invalid: true},
+		{name: "Retention of a year", change: func(p *Policy) { p.Retention.Messages = Duration(365 * 24 * time.Hour) }},
+		{name: "Hold without reason", change: func(p *Policy) { p.LegalHold.Users = []string{"alice"} }, invalid: true},
+		{name: "Hold with reason", change: func(p *Policy) {
+			p.LegalHold.Users = []string{"alice"}
+			p.LegalHold.Reason = "Case 42"
+		}},
+		{name: "No export messages", change: func(p *Policy) { p.Export.MaxMessages = 0 }, invalid: true},
+		{name: "Share expiry too long", change: func(p *Policy) { p.Export.ShareMaxExpiry = Duration(MaxShareExpiry + time.Hour) }, invalid: true},
+		{name: "Zero report threshold", change: func(p *Policy) { p.Moderation.ReportThreshold = 0 }, invalid: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := validPolicy()
+			tt.change(&p)
+			if tt.invalid {
+				assert.NotEmpty(t, p.Validate())
+			} else {
+				assert.Empty(t, p.Validate())
+			}
+		})
+	}
+}
+
+func TestPolicyJSON(t *testing.T) {
+	var p Policy
+	err := json.Unmarshal([]byte(`{
+		"retention": {"messages": "8760h", "call_chat": "2h"},
+		"legal_hold": {"users": [" bob", "alice", "bob", ""], "reason": "Case 42"}
+	}`), &p)
+	require.NoError(t, err)
+	p.normalize()
+
+	assert.Equal(t, Duration(8760*time.Hour), p.Retention.Messages)
+	assert.Equal(t, []string{"alice", "bob"}, p.LegalHold.Users)
+
+	data, err := json.Marshal(p.Retention)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"messages": "8760h0m0s", "call_chat": "2h0m0s"}`, string(data))
+
+	assert.Error(t, json.Unmarshal([]byte(`{"retention": {"messages": 3600}}`), &p))
+}
+
+func TestApplyKeepsNewestVersion(t *testing.T) {
+	s := &Service{current: Revision{Policy: validPolicy(), Default: true}}
+
+	var applied []int
+	s.OnChange(func(p Policy) { applied = append(applied, p.Export.MaxMessages) })
+
+	v2 := validPolicy()
+	v2.Export.MaxMessages = 200
+	s.apply(Revision{Version: 2, Policy: v2})
+
+	v1 := validPolicy()
+	v1.Export.MaxMessages = 100
+	s.apply(Revision{Version: 1, Policy: v1})
+
+	assert.Equal(t, int32(2), s.Current().Version)
+	assert.Equal(t, []int{5000, 200}, applied)
+}
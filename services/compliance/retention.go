@@ -0,0 +1,102 @@
+package compliance
+
+import (
+	"context"
+	"exc6/db"
+	"exc6/pkg/breaker"
+	"exc6/pkg/instance"
+	"exc6/pkg/logger"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Messages older than the retention period are deleted from Postgres in
+// batches, except those of users under legal hold. Recent-message caches in
+// Redis are not touched; they expire on their own a day after a conversation
+// goes quiet. Every instance purges, which is harmless since deletes are
+// idempotent.
+
+const (
+	// purgeInterval is how often expired messages are deleted
+	purgeInterval = time.Hour
+
+	// purgeBatch is the number of messages deleted per statement
+	purgeBatch = 1000
+)
+
+var purgedMessages = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Name: "compliance_messages_purged_total",
+		Help: "Messages deleted because they were older than the retention period",
+	},
+)
+
+func init() {
+	instance.Registerer().MustRegister(purgedMessages)
+}
+
+// StartRetention deletes expired messages every purgeInterval until ctx is
+// cancelled
+func (s *Service) StartRetention(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(purgeInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				s.Purge(ctx, time.Now())
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Purge deletes messages older than the retention period at now and returns
+// how many were deleted. Nothing is deleted when retention is off.
+func (s *Service) Purge(ctx context.Context, now time.Time) int64 {
+	policy := s.Current().Policy
+	if policy.Retention.Messages <= 0 {
+		return 0
+	}
+
+	cutoff := now.Add(-time.Duration(policy.Retention.Messages))
+	held := policy.LegalHold.Users
+	if held == nil {
+		held = []string{}
+	}
+
+	var total int64
+	for ctx.Err() == nil {
+		result, err := breaker.ExecuteCtx(ctx, s.cb, func() (any, error) {
+			return s.qdb.PurgeExpiredMessages(ctx, db.PurgeExpiredMessagesParams{
+				Cutoff: cutoff,
+				Held:   held,
+				Batch:  purgeBatch,
+			})
+		})
+		if err != nil {
+			logger.WithError(err).Warn("Failed to purge expired messages")
+			break
+		}
+
+		n, _ := result.(int64)
+		total += n
+		purgedMessages.Add(float64(n))
+		if n < purgeBatch {
+			break
+		}
+	}
+
+	if total > 0 {
+		logger.WithFields(map[string]any{
+			"deleted":    total,
+			"cutoff":     cutoff,
+			"legal_hold": len(held),
+		}).Info("Purged expired messages")
+	}
+
+	return total
+}
@@ -0,0 +1,102 @@
+package demo
+
+import (
+	"context"
+	"exc6/pkg/logger"
+	"exc6/services/chat"
+	"exc6/services/friends"
+	"exc6/services/groups"
+	"math/rand"
+	"time"
+)
+
+// NewsBot posts a scripted headline to a random friend or group on every tick
+const NewsBot = "newsbot"
+
+var headlines = []string{
+	"📰 Local bakery wins national sourdough award",
+	"📰 City council approves new bike lanes downtown",
+	"📰 Astronomers spot a comet visible to the naked eye this week",
+	"📰 Weekend forecast: sunny with a light breeze",
+	"📰 Library extends opening hours during exam season",
+	"📰 Community garden opens applications for spring plots",
+	"📰 Museum announces free entry on the first Sunday of the month",
+}
+
+// BotRunner drives the scripted demo bots. Interactive bots (echobot,
+// remindbot) run on the bot engine instead.
+type BotRunner struct {
+	csrv     chat.Service
+	fsrv     *friends.FriendService
+	gsrv     *groups.GroupService
+	interval time.Duration
+}
+
+func NewBotRunner(csrv chat.Service, fsrv *friends.FriendService, gsrv *groups.GroupService, interval time.Duration) *BotRunner {
+	return &BotRunner{
+		csrv:     csrv,
+		fsrv:     fsrv,
+		gsrv:     gsrv,
+		interval: interval,
+	}
+}
+
+// Start runs the bots until ctx is cancelled
+func (b *BotRunner) Start(ctx context.Context) {
+	go b.runNewsBot(ctx)
+}
+
+func (b *BotRunner) runNewsBot(ctx context.Context) {
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			b.postHeadline(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// postHeadline sends a headline to a random friend or group of the news bot
+func (b *BotRunner) postHeadline(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	headline := headlines[rand.Intn(len(headlines))]
+
+	var targets []func() error
+
+	if friendList, err := b.fsrv.GetUserFriends(ctx, NewsBot); err == nil {
+		for _, f := range friendList.Value {
+			to := f.Username
+			targets = append(targets, func() error {
+				_, err := b.csrv.SendMessage(ctx, NewsBot, to, headline)
+				return err
+			})
+		}
+	}
+
+	if groupList, err := b.gsrv.GetUserGroups(ctx, NewsBot); err == nil {
+		for _, g := range groupList {
+			groupID := g.ID
+			targets = append(targets, func() error {
+				_, err := b.csrv.SendGroupMessage(ctx, NewsBot, groupID, headline)
+				return err
+			})
+		}
+	}
+
+	if len(targets) == 0 {
+		return
+	}
+
+	if err := targets[rand.Intn(len(targets))](); err != nil {
+		logger.WithFields(map[string]interface{}{
+			"bot":   NewsBot,
+			"error": err.Error(),
+		}).Warn("Demo bot failed to send message")
+	}
+}
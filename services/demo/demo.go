@@ -0,0 +1,394 @@
+package demo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"exc6/db"
+	"exc6/pkg/logger"
+	"exc6/services/chat"
+	"exc6/services/friends"
+	"exc6/services/groups"
+	"exc6/utils"
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+// Password is shared by every seeded demo account
+const Password = "demo-password"
+
+// demoUser is a seeded account
+type demoUser struct {
+	Username string
+	Icon     string
+
+	// Bot accounts get a random password instead of Password, so nobody
+	// can sign in as them
+	Bot bool
+}
+
+// users are the seeded accounts
+var users = []demoUser{
+	{Username: "alice", Icon: "gradient-blue"},
+	{Username: "bob", Icon: "gradient-green"},
+	{Username: "carol", Icon: "gradient-purple"},
+	{Username: "dave", Icon: "gradient-orange"},
+	{Username: "erin", Icon: "gradient-rose"},
+	{Username: "frank", Icon: "gradient-teal"},
+	{Username: "newsbot", Icon: "solid-signal", Bot: true},
+	{Username: "echobot", Icon: "gradient-cyan", Bot: true},
+}
+
+// friendships are accepted friendships, requester first
+var friendships = [][2]string{
+	{"alice", "bob"},
+	{"alice", "carol"},
+	{"alice", "dave"},
+	{"bob", "carol"},
+	{"bob", "erin"},
+	{"carol", "frank"},
+	{"dave", "erin"},
+	{"alice", "newsbot"},
+	{"bob", "newsbot"},
+	{"alice", "echobot"},
+	{"carol", "echobot"},
+}
+
+// pendingRequests are friend requests left open so the request UI has content
+var pendingRequests = [][2]string{
+	{"frank", "alice"},
+	{"erin", "carol"},
+}
+
+// demoGroup is a seeded group and its scripted history
+type demoGroup struct {
+	Name        string
+	Description string
+	Icon        string
+	Owner       string
+	Members     []string
+	History     []line
+}
+
+// line is a single scripted message
+type line struct {
+	From    string
+	To      string // empty for group messages
+	Content string
+}
+
+var demoGroups = []demoGroup{
+	{
+		Name:        "Weekend Hikers",
+		Description: "Planning trips, sharing trail photos",
+		Icon:        "gradient-green",
+		Owner:       "alice",
+		Members:     []string{"bob", "carol", "dave", "newsbot"},
+		History: []line{
+			{From: "alice", Content: "Anyone up for the ridge trail on Saturday?"},
+			{From: "bob", Content: "I'm in! What time are we meeting?"},
+			{From: "carol", Content: "Count me in too, I'll bring snacks 🥪"},
+			{From: "alice", Content: "Let's say 8am at the north parking lot"},
+			{From: "dave", Content: "Might be late, I'll ping here when I'm close"},
+		},
+	},
+	{
+		Name:        "Book Club",
+		Description: "One book a month, no spoilers before Friday",
+		Icon:        "gradient-amber",
+		Owner:       "carol",
+		Members:     []string{"alice", "erin", "frank", "echobot"},
+		History: []line{
+			{From: "carol", Content: "This month's pick is up, who's started?"},
+			{From: "erin", Content: "Halfway through, the second act is great"},
+			{From: "frank", Content: "Just picked it up from the library"},
+			{From: "alice", Content: "No spoilers please, I'm on chapter 3 😅"},
+		},
+	},
+}
+
+var directHistory = []line{
+	{From: "alice", To: "bob", Content: "Hey Bob! Did you get the photos from last week?"},
+	{From: "bob", To: "alice", Content: "Yes, they came out great. The sunset one especially"},
+	{From: "alice", To: "bob", Content: "Send me that one when you get a chance"},
+	{From: "bob", To: "alice", Content: "Done 👍"},
+	{From: "alice", To: "carol", Content: "Are we still on for lunch tomorrow?"},
+	{From: "carol", To: "alice", Content: "Yep! 12:30 at the usual place"},
+	{From: "bob", To: "erin", Content: "Thanks for the help with the presentation"},
+	{From: "erin", To: "bob", Content: "Anytime, it went really well"},
+	{From: "dave", To: "erin", Content: "Did you see the game last night?"},
+	{From: "erin", To: "dave", Content: "Missed it, was it good?"},
+	{From: "newsbot", To: "alice", Content: "Welcome to SArAChat! I'll share a headline every now and then."},
+	{From: "echobot", To: "alice", Content: "Hi! Send me a message and I'll echo it back."},
+}
+
+// Summary reports what a seed run created
+type Summary struct {
+	Users       int  `json:"users"`
+	Friendships int  `json:"friendships"`
+	Groups      int  `json:"groups"`
+	Messages    int  `json:"messages"`
+	Skipped     bool `json:"skipped"`
+}
+
+// Seeder creates the demo dataset through the regular services so caches,
+// Kafka history and pub/sub see the same data as real traffic
+type Seeder struct {
+	qdb  *db.Queries
+	csrv chat.Service
+	fsrv *friends.FriendService
+	gsrv *groups.GroupService
+}
+
+func NewSeeder(qdb *db.Queries, csrv chat.Service, fsrv *friends.FriendService, gsrv *groups.GroupService) *Seeder {
+	return &Seeder{
+		qdb:  qdb,
+		csrv: csrv,
+		fsrv: fsrv,
+		gsrv: gsrv,
+	}
+}
+
+// Seed creates whatever part of the demo dataset is missing. Writes go
+// through several services, so a run cannot be one transaction; instead
+// every account, friendship, group, membership and message is looked for
+// before it is created, and a run that failed halfway is completed by the
+// next one. Skipped is set when everything was present already.
+func (s *Seeder) Seed(ctx context.Context) (*Summary, error) {
+	summary := &Summary{}
+
+	passwordHash, appErr := utils.HashPassword(Password)
+	if appErr != nil {
+		return nil, appErr
+	}
+
+	for _, u := range users {
+		created, err := s.ensureUser(ctx, u, passwordHash)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create demo user %s: %w", u.Username, err)
+		}
+		if created {
+			summary.Users++
+		}
+	}
+
+	for _, pair := range friendships {
+		created, err := s.ensureFriendship(ctx, pair[0], pair[1])
+		if err != nil {
+			return nil, fmt.Errorf("failed to create friendship %s-%s: %w", pair[0], pair[1], err)
+		}
+		if created {
+			summary.Friendships++
+		}
+	}
+
+	for _, pair := range pendingRequests {
+		if err := s.ensureRequest(ctx, pair[0], pair[1]); err != nil {
+			return nil, fmt.Errorf("failed to create friend request %s-%s: %w", pair[0], pair[1], err)
+		}
+	}
+
+	for _, line := range directHistory {
+		history, err := s.csrv.GetHistory(ctx, line.From, line.To)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read demo history of %s: %w", line.From, err)
+		}
+		if history.Degraded {
+			return nil, fmt.Errorf("demo history of %s is unavailable", line.From)
+		}
+		if contains(history.Value, line) {
+			continue
+		}
+
+		if _, err := s.csrv.SendMessage(ctx, line.From, line.To, line.Content); err != nil {
+			return nil, fmt.Errorf("failed to seed message from %s: %w", line.From, err)
+		}
+		summary.Messages++
+	}
+
+	for _, g := range demoGroups {
+		groupID, created, err := s.ensureGroup(ctx, g)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create demo group %s: %w", g.Name, err)
+		}
+		if created {
+			summary.Groups++
+		}
+
+		for _, member := range g.Members {
+			if err := s.ensureMember(ctx, groupID, g.Owner, member); err != nil {
+				return nil, fmt.Errorf("failed to add %s to demo group %s: %w", member, g.Name, err)
+			}
+		}
+
+		history, err := s.csrv.GetGroupHistory(ctx, groupID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read history of demo group %s: %w", g.Name, err)
+		}
+		for _, line := range g.History {
+			if contains(history, line) {
+				continue
+			}
+			if _, err := s.csrv.SendGroupMessage(ctx, line.From, groupID, line.Content); err != nil {
+				return nil, fmt.Errorf("failed to seed group message from %s: %w", line.From, err)
+			}
+			summary.Messages++
+		}
+	}
+
+	if summary.Users+summary.Friendships+summary.Groups+summary.Messages == 0 {
+		logger.Info("Demo dataset already present, nothing to seed")
+		summary.Skipped = true
+		return summary, nil
+	}
+
+	logger.WithFields(map[string]interface{}{
+		"users":       summary.Users,
+		"friendships": summary.Friendships,
+		"groups":      summary.Groups,
+		"messages":    summary.Messages,
+	}).Info("Demo dataset seeded")
+
+	return summary, nil
+}
+
+// ensureUser creates a demo account unless it exists
+func (s *Seeder) ensureUser(ctx context.Context, u demoUser, passwordHash string) (bool, error) {
+	_, err := s.qdb.GetUserByUsername(ctx, u.Username)
+	if err == nil {
+		return false, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return false, err
+	}
+	return true, s.createUser(ctx, u, passwordHash)
+}
+
+// ensureFriendship makes requester and addressee friends unless they are,
+// sending the request first unless it is pending
+func (s *Seeder) ensureFriendship(ctx context.Context, requester, addressee string) (bool, error) {
+	friends, err := s.qdb.AreFriends(ctx, db.AreFriendsParams{User1: requester, User2: addressee})
+	if err != nil {
+		return false, err
+	}
+	if friends {
+		return false, nil
+	}
+
+	if err := s.ensureRequest(ctx, requester, addressee); err != nil {
+		return false, err
+	}
+	return true, s.fsrv.AcceptFriendRequest(ctx, addressee, requester)
+}
+
+// ensureRequest sends a friend request unless the two are friends or it is
+// pending
+func (s *Seeder) ensureRequest(ctx context.Context, requester, addressee string) error {
+	friends, err := s.qdb.AreFriends(ctx, db.AreFriendsParams{User1: requester, User2: addressee})
+	if err != nil {
+		return err
+	}
+	if friends {
+		return nil
+	}
+
+	pending, err := s.fsrv.GetFriendRequests(ctx, addressee)
+	if err != nil {
+		return err
+	}
+	for _, request := range pending {
+		if request.Username == requester {
+			return nil
+		}
+	}
+
+	return s.fsrv.SendFriendRequest(ctx, requester, addressee)
+}
+
+// ensureGroup returns the ID of the group named g.Name among the owner's
+// groups, creating it if there is none
+func (s *Seeder) ensureGroup(ctx context.Context, g demoGroup) (string, bool, error) {
+	joined, err := s.gsrv.GetUserGroups(ctx, g.Owner)
+	if err != nil {
+		return "", false, err
+	}
+	for _, info := range joined {
+		if info.Name == g.Name {
+			return info.ID, false, nil
+		}
+	}
+
+	info, err := s.gsrv.CreateGroup(ctx, g.Owner, g.Name, g.Description, g.Icon)
+	if err != nil {
+		return "", false, err
+	}
+	return info.ID, true, nil
+}
+
+// ensureMember makes member a member of the group unless they are, inviting
+// them first unless they were
+func (s *Seeder) ensureMember(ctx context.Context, groupID, owner, member string) error {
+	isMember, err := s.gsrv.IsMember(ctx, groupID, member)
+	if err != nil {
+		return err
+	}
+	if isMember {
+		return nil
+	}
+
+	invites, err := s.gsrv.ListInvites(ctx, member)
+	if err != nil {
+		return err
+	}
+	invited := false
+	for _, invite := range invites {
+		if invite.GroupID == groupID {
+			invited = true
+			break
+		}
+	}
+
+	if !invited {
+		if _, err := s.gsrv.InviteMember(ctx, groupID, owner, member); err != nil {
+			return err
+		}
+	}
+	_, err = s.gsrv.AcceptInvite(ctx, groupID, member)
+	return err
+}
+
+// contains reports whether history has the scripted line already
+func contains(history []*chat.ChatMessage, l line) bool {
+	for _, msg := range history {
+		if msg.FromID == l.From && msg.Content == l.Content {
+			return true
+		}
+	}
+	return false
+}
+
+// createUser creates a demo account, bots as bot accounts
+func (s *Seeder) createUser(ctx context.Context, u demoUser, passwordHash string) error {
+	if !u.Bot {
+		_, err := s.qdb.CreateUser(ctx, db.CreateUserParams{
+			Username:     u.Username,
+			PasswordHash: passwordHash,
+			Icon:         sql.NullString{String: u.Icon, Valid: true},
+			CustomIcon:   sql.NullString{String: "", Valid: true},
+		})
+		return err
+	}
+
+	botHash, appErr := utils.HashPassword(uuid.NewString())
+	if appErr != nil {
+		return appErr
+	}
+	_, err := s.qdb.CreateBotUser(ctx, db.CreateBotUserParams{
+		Username:     u.Username,
+		PasswordHash: botHash,
+		Icon:         sql.NullString{String: u.Icon, Valid: true},
+		CustomIcon:   sql.NullString{String: "", Valid: true},
+	})
+	return err
+}
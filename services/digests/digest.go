@@ -0,0 +1,109 @@
+package digests
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Conversation is a direct conversation or group with missed activity
+type Conversation struct {
+	// Name is the other user of a direct conversation, or the group's name
+	Name string
+
+	// GroupID is set for groups
+	GroupID string
+
+	// Count is the number of messages or mentions
+	Count int
+}
+
+// Link returns where the conversation opens, relative to the app's URL
+func (c Conversation) Link() string {
+	if c.GroupID != "" {
+		return "/dashboard?group=" + url.QueryEscape(c.GroupID)
+	}
+	return "/dashboard?chat=" + url.QueryEscape(c.Name)
+}
+
+// Digest is what a user missed since Since
+type Digest struct {
+	Username string
+	Since    time.Time
+
+	// Messages lists direct conversations by their latest message
+	Messages []Conversation
+
+	// Mentions lists groups where the user was mentioned
+	Mentions []Conversation
+
+	// FriendRequests lists users waiting for an answer
+	FriendRequests []string
+}
+
+// Empty reports whether nothing happened worth a digest
+func (d *Digest) Empty() bool {
+	return len(d.Messages) == 0 && len(d.Mentions) == 0 && len(d.FriendRequests) == 0
+}
+
+// Render returns the subject and plain text body of the digest email, with
+// links under baseURL
+func (d *Digest) Render(baseURL, unsubscribeURL string) (string, string) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Hi %s,\n\nHere is what happened since %s.\n", d.Username, d.Since.UTC().Format("Jan 2 15:04 MST"))
+
+	if len(d.Messages) > 0 {
+		b.WriteString("\nNew messages\n")
+		for _, c := range d.Messages {
+			fmt.Fprintf(&b, "  %s: %s\n    %s%s\n", c.Name, plural(c.Count, "message", "messages"), baseURL, c.Link())
+		}
+	}
+
+	if len(d.Mentions) > 0 {
+		b.WriteString("\nMentions\n")
+		for _, c := range d.Mentions {
+			fmt.Fprintf(&b, "  %s: %s\n    %s%s\n", c.Name, plural(c.Count, "mention", "mentions"), baseURL, c.Link())
+		}
+	}
+
+	if len(d.FriendRequests) > 0 {
+		b.WriteString("\nFriend requests\n")
+		fmt.Fprintf(&b, "  From %s\n    %s/friends\n", strings.Join(d.FriendRequests, ", "), baseURL)
+	}
+
+	fmt.Fprintf(&b, "\nYou get this email at most once a day while you are away. To stop it, open %s\n", unsubscribeURL)
+
+	return d.subject(), b.String()
+}
+
+// subject names the most important thing the user missed
+func (d *Digest) subject() string {
+	messages := 0
+	for _, c := range d.Messages {
+		messages += c.Count
+	}
+	mentions := 0
+	for _, c := range d.Mentions {
+		mentions += c.Count
+	}
+
+	var parts []string
+	if messages > 0 {
+		parts = append(parts, plural(messages, "new message", "new messages"))
+	}
+	if mentions > 0 {
+		parts = append(parts, plural(mentions, "mention", "mentions"))
+	}
+	if len(d.FriendRequests) > 0 {
+		parts = append(parts, plural(len(d.FriendRequests), "friend request", "friend requests"))
+	}
+	return "You have " + strings.Join(parts, ", ")
+}
+
+func plural(count int, singular, plural string) string {
+	if count == 1 {
+		return "1 " + singular
+	}
+	return fmt.Sprintf("%d %s", count, plural)
+}
@@ -0,0 +1,52 @@
+package digests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConversationLink(t *testing.T) {
+	assert.Equal(t, "/dashboard?chat=alice", Conversation{Name: "alice"}.Link())
+	assert.Equal(t, "/dashboard?group=7d3c", Conversation{Name: "Hiking club", GroupID: "7d3c"}.Link())
+}
+
+func TestDigestEmpty(t *testing.T) {
+	assert.True(t, (&Digest{Username: "bob"}).Empty())
+	assert.False(t, (&Digest{Username: "bob", FriendRequests: []string{"carol"}}).Empty())
+}
+
+func TestDigestRender(t *testing.T) {
+	d := &Digest{
+		Username: "bob",
+		Since:    time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC),
+		Messages: []Conversation{
+			{Name: "alice", Count: 3},
+			{Name: "dave", Count: 1},
+		},
+		Mentions:       []Conversation{{Name: "Hiking club", GroupID: "7d3c", Count: 1}},
+		FriendRequests: []string{"carol", "erin"},
+	}
+
+	subject, body := d.Render("https://chat.example.com", "https://chat.example.com/digests/unsubscribe/abc")
+
+	assert.Equal(t, "You have 4 new messages, 1 mention, 2 friend requests", subject)
+	assert.Contains(t, body, "Hi bob,")
+	assert.Contains(t, body, "since Mar 1 09:30 UTC")
+	assert.Contains(t, body, "alice: 3 messages\n    https://chat.example.com/dashboard?chat=alice\n")
+	assert.Contains(t, body, "dave: 1 message\n")
+	assert.Contains(t, body, "Hiking club: 1 mention\n    https://chat.example.com/dashboard?group=7d3c\n")
+	assert.Contains(t, body, "From carol, erin\n    https://chat.example.com/friends\n")
+	assert.Contains(t, body, "https://chat.example.com/digests/unsubscribe/abc")
+}
+
+func TestDigestRenderOmitsEmptySections(t *testing.T) {
+	d := &Digest{Username: "bob", FriendRequests: []string{"carol"}}
+
+	subject, body := d.Render("https://chat.example.com", "https://chat.example.com/digests/unsubscribe/abc")
+
+	assert.Equal(t, "You have 1 friend request", subject)
+	assert.NotContains(t, body, "New messages")
+	assert.NotContains(t, body, "Mentions")
+}
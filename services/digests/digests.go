@@ -0,0 +1,306 @@
+package digests
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"exc6/apperrors"
+	"exc6/config"
+	"exc6/db"
+	"exc6/pkg/breaker"
+	"exc6/pkg/logger"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sony/gobreaker"
+)
+
+// Users who have not been connected for a while get at most one email a day
+// listing what they missed: direct messages per sender, mentions per group
+// and pending friend requests, each linking to the conversation. Every
+// instance refreshes the last-seen time of the users connected to it and
+// then claims due users from Postgres, so a digest is sent once however many
+// instances run. Users without an email address in their profile are
+// skipped, and anyone can opt out.
+
+const (
+	// Period is the shortest time between two digests of a user
+	Period = 24 * time.Hour
+
+	// batchSize is the number of users claimed at a time
+	batchSize = 100
+
+	// maxItems bounds the senders, groups and friend requests listed
+	maxItems = 10
+
+	// UnsubscribePath is where the unsubscribe link of each email points,
+	// followed by the user's token
+	UnsubscribePath = "/digests/unsubscribe/"
+)
+
+// Presence lists the users connected to this instance
+type Presence interface {
+	GetOnlineUsers() []string
+}
+
+// Mailer delivers email
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// ErrUnknownToken is returned for unsubscribe tokens of no user
+var ErrUnknownToken = errors.New("unknown unsubscribe token")
+
+// Settings is a user's choice to receive digests
+type Settings struct {
+	Enabled bool `json:"enabled"`
+}
+
+// Service sends the digests
+type Service struct {
+	qdb      *db.Queries
+	cb       *gobreaker.CircuitBreaker
+	mailer   Mailer
+	presence Presence
+	cfg      config.DigestConfig
+}
+
+// NewService creates the service and looks for due digests every
+// cfg.Interval until ctx is cancelled. It returns nil when digests are
+// disabled.
+func NewService(ctx context.Context, cfg config.DigestConfig, qdb *db.Queries, mailer Mailer, presence Presence) *Service {
+	if !cfg.Enabled {
+		return nil
+	}
+
+	s := &Service{
+		qdb:      qdb,
+		mailer:   mailer,
+		presence: presence,
+		cfg:      cfg,
+		cb: breaker.New(breaker.Config{
+			Name:        "postgres-digests",
+			MaxRequests: 5,
+			Interval:    60 * time.Second,
+			Timeout:     30 * time.Second,
+			Threshold:   0.5,
+			MinRequests: 5,
+		}),
+	}
+
+	go s.run(ctx)
+
+	return s
+}
+
+func (s *Service) run(ctx context.Context) {
+	ticker := time.NewTicker(s.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			sent, err := s.RunOnce(ctx)
+			if err != nil {
+				logger.WithError(err).Error("Digest run failed")
+				continue
+			}
+			if sent > 0 {
+				logger.WithField("sent", sent).Info("Digests sent")
+			}
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// RunOnce records the users connected to this instance as seen, then sends
+// the digests that are due. It returns the number sent.
+func (s *Service) RunOnce(ctx context.Context) (int, error) {
+	if online := s.presence.GetOnlineUsers(); len(online) > 0 {
+		if _, err := breaker.ExecuteCtx(ctx, s.cb, func() (any, error) {
+			return nil, s.qdb.TouchDigestLastSeen(ctx, online)
+		}); err != nil {
+			logger.WithError(err).Error("Circuit breaker: Failed to record connected users as seen")
+			return 0, err
+		}
+	}
+
+	sent := 0
+	for {
+		now := time.Now()
+		result, err := breaker.ExecuteCtx(ctx, s.cb, func() (any, error) {
+			return s.qdb.ClaimDigestRecipients(ctx, db.ClaimDigestRecipientsParams{
+				InactiveBefore: now.Add(-s.cfg.InactiveAfter),
+				SentBefore:     now.Add(-Period),
+				RowLimit:       batchSize,
+			})
+		})
+		if err != nil {
+			logger.WithError(err).Error("Circuit breaker: Failed to claim digest recipients")
+			return sent, err
+		}
+
+		recipients, _ := result.([]db.ClaimDigestRecipientsRow)
+		for _, recipient := range recipients {
+			if s.send(ctx, recipient) {
+				sent++
+			}
+		}
+
+		if len(recipients) < batchSize {
+			return sent, nil
+		}
+	}
+}
+
+// send builds and mails the digest of a claimed user; it reports whether an
+// email went out. A failed digest is not retried before the next period.
+func (s *Service) send(ctx context.Context, recipient db.ClaimDigestRecipientsRow) bool {
+	if recipient.Email == "" {
+		return false
+	}
+
+	digest, err := s.build(ctx, recipient)
+	if err != nil {
+		logger.WithFields(map[string]any{
+			"username": recipient.Username,
+			"error":    err.Error(),
+		}).Warn("Failed to build digest")
+		return false
+	}
+	if digest.Empty() {
+		return false
+	}
+
+	unsubscribeURL := s.cfg.BaseURL + UnsubscribePath + recipient.UnsubscribeToken.String()
+	subject, body := digest.Render(s.cfg.BaseURL, unsubscribeURL)
+	if err := s.mailer.Send(ctx, Message{
+		To:             recipient.Email,
+		Subject:        subject,
+		Body:           body,
+		UnsubscribeURL: unsubscribeURL,
+	}); err != nil {
+		logger.WithFields(map[string]any{
+			"username": recipient.Username,
+			"error":    err.Error(),
+		}).Warn("Failed to send digest")
+		return false
+	}
+	return true
+}
+
+// build collects what the recipient missed since their digest starts
+func (s *Service) build(ctx context.Context, recipient db.ClaimDigestRecipientsRow) (*Digest, error) {
+	digest := &Digest{Username: recipient.Username, Since: recipient.Since}
+
+	_, err := breaker.ExecuteCtx(ctx, s.cb, func() (any, error) {
+		messages, err := s.qdb.GetDigestDirectMessages(ctx, db.GetDigestDirectMessagesParams{
+			UserID:   recipient.UserID,
+			Since:    recipient.Since,
+			RowLimit: maxItems,
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, row := range messages {
+			digest.Messages = append(digest.Messages, Conversation{Name: row.Username, Count: int(row.Messages)})
+		}
+
+		mentions, err := s.qdb.GetDigestMentions(ctx, db.GetDigestMentionsParams{
+			UserID:   recipient.UserID,
+			Since:    recipient.Since,
+			Username: recipient.Username,
+			RowLimit: maxItems,
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, row := range mentions {
+			digest.Mentions = append(digest.Mentions, Conversation{GroupID: row.ID.String(), Name: row.Name, Count: int(row.Mentions)})
+		}
+
+		digest.FriendRequests, err = s.qdb.GetDigestFriendRequests(ctx, db.GetDigestFriendRequestsParams{
+			UserID:   recipient.UserID,
+			Since:    recipient.Since,
+			RowLimit: maxItems,
+		})
+		return nil, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return digest, nil
+}
+
+// GetSettings returns whether username receives digests
+func (s *Service) GetSettings(ctx context.Context, username string) (*Settings, error) {
+	result, err := breaker.ExecuteCtx(ctx, s.cb, func() (any, error) {
+		user, err := s.qdb.GetUserByUsername(ctx, username)
+		if err != nil {
+			return nil, err
+		}
+
+		optedOut, err := s.qdb.GetDigestOptOut(ctx, user.ID)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+		return &Settings{Enabled: !optedOut}, nil
+	})
+	if err != nil {
+		logger.WithFields(map[string]any{
+			"username": username,
+			"error":    err.Error(),
+		}).Error("Circuit breaker: Failed to get digest settings")
+		return nil, apperrors.NewDatabaseError("get digest settings", err)
+	}
+
+	settings, _ := result.(*Settings)
+	return settings, nil
+}
+
+// UpdateSettings opts username in or out of digests
+func (s *Service) UpdateSettings(ctx context.Context, username string, enabled bool) (*Settings, error) {
+	_, err := breaker.ExecuteCtx(ctx, s.cb, func() (any, error) {
+		user, err := s.qdb.GetUserByUsername(ctx, username)
+		if err != nil {
+			return nil, err
+		}
+
+		return nil, s.qdb.SetDigestOptOut(ctx, db.SetDigestOptOutParams{
+			UserID:   user.ID,
+			OptedOut: !enabled,
+		})
+	})
+	if err != nil {
+		logger.WithFields(map[string]any{
+			"username": username,
+			"error":    err.Error(),
+		}).Error("Circuit breaker: Failed to update digest settings")
+		return nil, apperrors.NewDatabaseError("update digest settings", err)
+	}
+
+	return &Settings{Enabled: enabled}, nil
+}
+
+// Unsubscribe opts out the user whose emails carry token
+func (s *Service) Unsubscribe(ctx context.Context, token string) error {
+	id, err := uuid.Parse(token)
+	if err != nil {
+		return ErrUnknownToken
+	}
+
+	result, err := breaker.ExecuteCtx(ctx, s.cb, func() (any, error) {
+		return s.qdb.UnsubscribeDigest(ctx, id)
+	})
+	if err != nil {
+		logger.WithError(err).Error("Circuit breaker: Failed to unsubscribe from digests")
+		return apperrors.NewDatabaseError("unsubscribe from digests", err)
+	}
+
+	if rows, _ := result.(int64); rows == 0 {
+		return ErrUnknownToken
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package digests
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// Message is a plain text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+
+	// UnsubscribeURL is offered to mail clients for one-click unsubscribe
+	// (RFC 8058)
+	UnsubscribeURL string
+}
+
+// SMTPMailer sends email through a mail server, upgrading to TLS when the
+// server offers it
+type SMTPMailer struct {
+	addr string
+	from *mail.Address
+	auth smtp.Auth
+}
+
+// NewSMTPMailer creates a mailer for the server at addr sending as from,
+// such as "Chat <noreply@example.com>". Without a username mail is sent
+// unauthenticated.
+func NewSMTPMailer(addr, username, password, from string) (*SMTPMailer, error) {
+	sender, err := mail.ParseAddress(from)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sender %q: %w", from, err)
+	}
+
+	m := &SMTPMailer{addr: addr, from: sender}
+	if username != "" {
+		host, _, _ := net.SplitHostPort(addr)
+		m.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return m, nil
+}
+
+// Send implements Mailer. The context only bounds the wait before sending,
+// as net/smtp has no cancellation.
+func (m *SMTPMailer) Send(ctx context.Context, msg Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	to, err := mail.ParseAddress(msg.To)
+	if err != nil {
+		return fmt.Errorf("invalid recipient %q: %w", msg.To, err)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", m.from)
+	fmt.Fprintf(&b, "To: %s\r\n", to)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	if msg.UnsubscribeURL != "" {
+		fmt.Fprintf(&b, "List-Unsubscribe: <%s>\r\n", msg.UnsubscribeURL)
+		b.WriteString("List-Unsubscribe-Post: List-Unsubscribe=One-Click\r\n")
+	}
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+
+	return smtp.SendMail(m.addr, m.auth, m.from.Address, []string{to.Address}, []byte(b.String()))
+}
@@ -0,0 +1,245 @@
+package directory
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"exc6/apperrors"
+	"exc6/db"
+	"exc6/pkg/breaker"
+	"exc6/pkg/logger"
+	"exc6/pkg/pagination"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/sony/gobreaker"
+)
+
+// The directory lists users who opted in, with a few interest tags, for a
+// "find people" page. Listing is off by default. Users who are not listed
+// never appear in the directory and are left out of prefix user search as
+// well, so they can only be found by someone who knows their username. The
+// listing endpoint is rate limited per user and pages are capped, so the
+// directory cannot be scraped faster than a person could browse it.
+
+const (
+	// MaxTags is the number of interest tags a listing can carry
+	MaxTags = 5
+
+	// MaxTagLength is the longest tag accepted, in characters
+	MaxTagLength = 24
+)
+
+// Entry is a listed user
+type Entry struct {
+	Username    string   `json:"username"`
+	DisplayName string   `json:"display_name,omitempty"`
+	Icon        string   `json:"icon,omitempty"`
+	CustomIcon  string   `json:"custom_icon,omitempty"`
+	Tags        []string `json:"tags"`
+}
+
+// Cursor orders the directory by username
+func (e Entry) Cursor() pagination.Cursor {
+	return pagination.Cursor{Key: e.Username}
+}
+
+// Settings is a user's choice to be listed
+type Settings struct {
+	Listed   bool      `json:"listed"`
+	Tags     []string  `json:"tags"`
+	ListedAt time.Time `json:"listed_at,omitempty"`
+}
+
+// Service manages directory listings
+type Service struct {
+	qdb *db.Queries
+	cb  *gobreaker.CircuitBreaker
+}
+
+// NewService creates a directory service
+func NewService(qdb *db.Queries) *Service {
+	return &Service{
+		qdb: qdb,
+		cb: breaker.New(breaker.Config{
+			Name:        "postgres-directory",
+			MaxRequests: 10,
+			Interval:    60 * time.Second,
+			Timeout:     45 * time.Second,
+			Threshold:   0.6,
+			MinRequests: 10,
+		}),
+	}
+}
+
+// List returns a page of listed users, ordered by username, optionally only
+// those with tag
+func (s *Service) List(ctx context.Context, tag string, page pagination.Params) (pagination.Page[Entry], error) {
+	tag = normalizeTag(tag)
+	limit := pagination.ClampLimit(page.Limit)
+
+	after := ""
+	if page.After != nil {
+		after = page.After.Key
+	}
+
+	result, err := breaker.ExecuteCtx(ctx, s.cb, func() (any, error) {
+		return s.qdb.ListDirectory(ctx, db.ListDirectoryParams{
+			Tag:           tag,
+			AfterUsername: after,
+			RowLimit:      int32(limit + 1),
+		})
+	})
+	if err != nil {
+		logger.WithFields(map[string]any{
+			"tag":   tag,
+			"error": err.Error(),
+		}).Error("Circuit breaker: Failed to list directory")
+		return pagination.Page[Entry]{}, apperrors.NewDatabaseError("list directory", err)
+	}
+
+	rows, _ := result.([]db.ListDirectoryRow)
+	entries := make([]Entry, 0, len(rows))
+	for _, row := range rows {
+		entries = append(entries, Entry{
+			Username:    row.Username,
+			DisplayName: row.DisplayName,
+			Icon:        row.Icon.String,
+			CustomIcon:  row.CustomIcon.String,
+			Tags:        row.Tags,
+		})
+	}
+
+	return pagination.New(entries, page, Entry.Cursor), nil
+}
+
+// GetSettings returns whether username is listed, and with which tags
+func (s *Service) GetSettings(ctx context.Context, username string) (*Settings, error) {
+	result, err := breaker.ExecuteCtx(ctx, s.cb, func() (any, error) {
+		user, err := s.qdb.GetUserByUsername(ctx, username)
+		if err != nil {
+			return nil, err
+		}
+
+		listing, err := s.qdb.GetDirectoryListing(ctx, user.ID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return &Settings{Tags: []string{}}, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return settingsOf(listing), nil
+	})
+	if err != nil {
+		logger.WithFields(map[string]any{
+			"username": username,
+			"error":    err.Error(),
+		}).Error("Circuit breaker: Failed to get directory settings")
+		return nil, apperrors.NewDatabaseError("get directory settings", err)
+	}
+
+	// Not-found errors don't trip the breaker and come back as an empty result
+	if result == nil {
+		return nil, apperrors.NewUserNotFound()
+	}
+
+	return result.(*Settings), nil
+}
+
+// UpdateSettings lists username with tags, or removes them from the
+// directory when listed is false
+func (s *Service) UpdateSettings(ctx context.Context, username string, listed bool, tags []string) (*Settings, error) {
+	tags, err := NormalizeTags(tags)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := breaker.ExecuteCtx(ctx, s.cb, func() (any, error) {
+		user, err := s.qdb.GetUserByUsername(ctx, username)
+		if err != nil {
+			return nil, err
+		}
+
+		if !listed {
+			if err := s.qdb.DeleteDirectoryListing(ctx, user.ID); err != nil {
+				return nil, err
+			}
+			return &Settings{Tags: []string{}}, nil
+		}
+
+		listing, err := s.qdb.UpsertDirectoryListing(ctx, db.UpsertDirectoryListingParams{
+			UserID: user.ID,
+			Tags:   tags,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return settingsOf(listing), nil
+	})
+	if err != nil {
+		logger.WithFields(map[string]any{
+			"username": username,
+			"error":    err.Error(),
+		}).Error("Circuit breaker: Failed to update directory settings")
+		return nil, apperrors.NewDatabaseError("update directory settings", err)
+	}
+
+	if result == nil {
+		return nil, apperrors.NewUserNotFound()
+	}
+
+	logger.WithFields(map[string]any{
+		"username": username,
+		"listed":   listed,
+	}).Info("Directory listing updated")
+
+	return result.(*Settings), nil
+}
+
+// NormalizeTags lowercases tags, drops empty ones and duplicates and checks
+// the rest
+func NormalizeTags(tags []string) ([]string, error) {
+	normalized := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = normalizeTag(tag)
+		if tag == "" || slices.Contains(normalized, tag) {
+			continue
+		}
+		if !validTag(tag) {
+			return nil, apperrors.NewValidationError(fmt.Sprintf("Tags use letters, digits and dashes, up to %d characters", MaxTagLength))
+		}
+		normalized = append(normalized, tag)
+	}
+
+	if len(normalized) > MaxTags {
+		return nil, apperrors.NewValidationError(fmt.Sprintf("A listing can have at most %d tags", MaxTags))
+	}
+	return normalized, nil
+}
+
+func normalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimPrefix(strings.TrimSpace(tag), "#"))
+}
+
+func validTag(tag string) bool {
+	runes := []rune(tag)
+	if len(runes) > MaxTagLength || runes[0] == '-' {
+		return false
+	}
+	for _, r := range runes {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+func settingsOf(listing db.DirectoryListing) *Settings {
+	tags := listing.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	return &Settings{Listed: true, Tags: tags, ListedAt: listing.ListedAt}
+}
@@ -0,0 +1,39 @@
+package directory
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeTags(t *testing.T) {
+	tags, err := NormalizeTags([]string{" Hiking ", "#go", "hiking", "", "board-games"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"hiking", "go", "board-games"}, tags)
+
+	tags, err = NormalizeTags(nil)
+	require.NoError(t, err)
+	assert.Empty(t, tags)
+}
+
+func TestNormalizeTagsRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		tags []string
+	}{
+		{name: "Spaces", tags: []string{"rock climbing"}},
+		{name: "Punctuation", tags: []string{"c++"}},
+		{name: "Leading dash", tags: []string{"-go"}},
+		{name: "Too long", tags: []string{strings.Repeat("a", MaxTagLength+1)}},
+		{name: "Too many", tags: []string{"a", "b", "c", "d", "e", "f"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NormalizeTags(tt.tags)
+			assert.Error(t, err)
+		})
+	}
+}
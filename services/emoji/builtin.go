@@ -0,0 +1,159 @@
+package emoji
+
+// builtin maps the shortcodes every deployment understands to Unicode emoji
+var builtin = map[string]string{
+	// Faces
+	"smile":            "😄",
+	"grin":             "😁",
+	"joy":              "😂",
+	"rofl":             "🤣",
+	"slightly_smiling": "🙂",
+	"upside_down":      "🙃",
+	"wink":             "😉",
+	"blush":            "😊",
+	"innocent":         "😇",
+	"heart_eyes":       "😍",
+	"star_struck":      "🤩",
+	"kissing_heart":    "😘",
+	"yum":              "😋",
+	"stuck_out_tongue": "😛",
+	"thinking":         "🤔",
+	"shushing":         "🤫",
+	"neutral_face":     "😐",
+	"expressionless":   "😑",
+	"no_mouth":         "😶",
+	"smirk":            "😏",
+	"unamused":         "😒",
+	"roll_eyes":        "🙄",
+	"grimacing":        "😬",
+	"relieved":         "😌",
+	"pensive":          "😔",
+	"sleepy":           "😪",
+	"sleeping":         "😴",
+	"mask":             "😷",
+	"nerd":             "🤓",
+	"sunglasses":       "😎",
+	"confused":         "😕",
+	"worried":          "😟",
+	"open_mouth":       "😮",
+	"astonished":       "😲",
+	"flushed":          "😳",
+	"pleading":         "🥺",
+	"cry":              "😢",
+	"sob":              "😭",
+	"scream":           "😱",
+	"angry":            "😠",
+	"rage":             "😡",
+	"skull":            "💀",
+	"clown":            "🤡",
+	"ghost":            "👻",
+	"alien":            "👽",
+	"robot":            "🤖",
+	"poop":             "💩",
+	"partying":         "🥳",
+	"exploding_head":   "🤯",
+	"hugs":             "🤗",
+	"facepalm":         "🤦",
+	"shrug":            "🤷",
+
+	// Hands and people
+	"+1":           "👍",
+	"thumbsup":     "👍",
+	"-1":           "👎",
+	"thumbsdown":   "👎",
+	"ok_hand":      "👌",
+	"clap":         "👏",
+	"wave":         "👋",
+	"raised_hand":  "✋",
+	"pray":         "🙏",
+	"muscle":       "💪",
+	"point_up":     "☝️",
+	"v":            "✌️",
+	"crossed":      "🤞",
+	"handshake":    "🤝",
+	"raised_hands": "🙌",
+	"eyes":         "👀",
+	"brain":        "🧠",
+
+	// Hearts and symbols
+	"heart":            "❤️",
+	"orange_heart":     "🧡",
+	"yellow_heart":     "💛",
+	"green_heart":      "💚",
+	"blue_heart":       "💙",
+	"purple_heart":     "💜",
+	"black_heart":      "🖤",
+	"broken_heart":     "💔",
+	"sparkling_heart":  "💖",
+	"100":              "💯",
+	"fire":             "🔥",
+	"sparkles":         "✨",
+	"star":             "⭐",
+	"zap":              "⚡",
+	"boom":             "💥",
+	"tada":             "🎉",
+	"confetti_ball":    "🎊",
+	"check":            "✅",
+	"white_check_mark": "✅",
+	"x":                "❌",
+	"warning":          "⚠️",
+	"question":         "❓",
+	"exclamation":      "❗",
+	"zzz":              "💤",
+	"bulb":             "💡",
+	"bell":             "🔔",
+	"lock":             "🔒",
+	"key":              "🔑",
+	"link":             "🔗",
+	"pushpin":          "📌",
+	"memo":             "📝",
+	"calendar":         "📅",
+	"alarm_clock":      "⏰",
+	"hourglass":        "⌛",
+	"rocket":           "🚀",
+	"trophy":           "🏆",
+	"medal":            "🏅",
+	"gift":             "🎁",
+	"balloon":          "🎈",
+	"bug":              "🐛",
+	"wrench":           "🔧",
+	"hammer":           "🔨",
+	"gear":             "⚙️",
+	"chart":            "📈",
+	"money":            "💰",
+	"email":            "📧",
+	"phone":            "📱",
+	"computer":         "💻",
+	"camera":           "📷",
+	"musical_note":     "🎵",
+	"speech_balloon":   "💬",
+
+	// Nature, food and travel
+	"sun":        "☀️",
+	"cloud":      "☁️",
+	"rainbow":    "🌈",
+	"snowflake":  "❄️",
+	"umbrella":   "☔",
+	"earth":      "🌍",
+	"seedling":   "🌱",
+	"rose":       "🌹",
+	"cat":        "🐱",
+	"dog":        "🐶",
+	"unicorn":    "🦄",
+	"coffee":     "☕",
+	"tea":        "🍵",
+	"beer":       "🍺",
+	"wine":       "🍷",
+	"pizza":      "🍕",
+	"burger":     "🍔",
+	"taco":       "🌮",
+	"cake":       "🍰",
+	"cookie":     "🍪",
+	"apple":      "🍎",
+	"avocado":    "🥑",
+	"car":        "🚗",
+	"airplane":   "✈️",
+	"house":      "🏠",
+	"soccer":     "⚽",
+	"basketball": "🏀",
+}
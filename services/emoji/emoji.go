@@ -0,0 +1,248 @@
+package emoji
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"exc6/apperrors"
+	"exc6/db"
+	"exc6/pkg/breaker"
+	"exc6/pkg/cache"
+	"exc6/pkg/logger"
+	"exc6/services/uploads"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sony/gobreaker"
+)
+
+const (
+	// MaxCustom caps the number of custom emoji per deployment
+	MaxCustom = 500
+
+	// URLPrefix is where uploaded emoji images are served from
+	URLPrefix = "/uploads/emoji/"
+
+	catalogKey = "catalog"
+)
+
+var (
+	// shortcodePattern is what a custom emoji may be named
+	shortcodePattern = regexp.MustCompile(`^[a-z0-9_+-]{2,32}$`)
+
+	// referencePattern finds :shortcode: references in message text
+	referencePattern = regexp.MustCompile(`:([a-z0-9_+-]{1,32}):`)
+)
+
+// Custom is an emoji uploaded for this deployment
+type Custom struct {
+	Shortcode string `json:"shortcode"`
+	URL       string `json:"url"`
+}
+
+// Catalog lists every shortcode clients can render. Version changes whenever
+// custom emoji are added or removed.
+type Catalog struct {
+	Version string            `json:"version"`
+	Builtin map[string]string `json:"builtin"`
+	Custom  []Custom          `json:"custom"`
+}
+
+// Expand replaces built-in :shortcode: references with their Unicode emoji.
+// Custom shortcodes are left in place for clients to render from the catalog.
+func Expand(content string) string {
+	if !strings.Contains(content, ":") {
+		return content
+	}
+
+	return referencePattern.ReplaceAllStringFunc(content, func(ref string) string {
+		if emoji, ok := builtin[ref[1:len(ref)-1]]; ok {
+			return emoji
+		}
+		return ref
+	})
+}
+
+// ValidateShortcode checks the name of a new custom emoji
+func ValidateShortcode(shortcode string) error {
+	if !shortcodePattern.MatchString(shortcode) {
+		return apperrors.NewValidationError("Shortcodes must be 2-32 characters of a-z, 0-9, _, + or -")
+	}
+	if _, ok := builtin[shortcode]; ok {
+		return apperrors.NewValidationError(fmt.Sprintf(":%s: is a built-in emoji", shortcode))
+	}
+	return nil
+}
+
+// EmojiService manages custom emoji. The catalog is cached in process and
+// dropped on every instance through the invalidator when emoji change.
+type EmojiService struct {
+	qdb         *db.Queries
+	cb          *gobreaker.CircuitBreaker
+	local       *cache.Local[string, *Catalog]
+	invalidator *cache.Invalidator
+
+	// dir holds images uploaded before the upload store, served under URLPrefix
+	dir string
+
+	// uploads holds the images of new emoji; may be nil
+	uploads *uploads.Store
+}
+
+// NewEmojiService creates the service storing images in uploadsDir/emoji
+func NewEmojiService(qdb *db.Queries, inv *cache.Invalidator, uploadsDir string) *EmojiService {
+	es := &EmojiService{
+		qdb:         qdb,
+		local:       cache.NewLocal[string, *Catalog](1, 10*time.Minute),
+		invalidator: inv,
+		dir:         filepath.Join(uploadsDir, "emoji"),
+		cb: breaker.New(breaker.Config{
+			Name:        "postgres-emoji",
+			MaxRequests: 5,
+			Interval:    60 * time.Second,
+			Timeout:     30 * time.Second,
+			Threshold:   0.5,
+			MinRequests: 5,
+		}),
+	}
+
+	inv.OnInvalidate(cache.KindEmoji, func(keys []string) {
+		es.local.Purge()
+	})
+
+	return es
+}
+
+// SetUploadStore sets the store whose references emoji images hold
+func (es *EmojiService) SetUploadStore(store *uploads.Store) {
+	es.uploads = store
+}
+
+// Catalog returns the built-in and custom emoji
+func (es *EmojiService) Catalog(ctx context.Context) (*Catalog, error) {
+	if catalog, ok := es.local.Get(catalogKey); ok {
+		return catalog, nil
+	}
+
+	result, err := breaker.ExecuteCtx(ctx, es.cb, func() (interface{}, error) {
+		return es.qdb.ListCustomEmoji(ctx)
+	})
+	if err != nil {
+		logger.WithError(err).Error("Circuit breaker: Failed to list custom emoji")
+		return nil, apperrors.NewDatabaseError("list custom emoji", err)
+	}
+
+	rows, _ := result.([]db.CustomEmoji)
+
+	catalog := &Catalog{
+		Builtin: builtin,
+		Custom:  make([]Custom, 0, len(rows)),
+	}
+
+	version := sha1.New()
+	for _, row := range rows {
+		catalog.Custom = append(catalog.Custom, Custom{Shortcode: row.Shortcode, URL: row.ImageUrl})
+		fmt.Fprintf(version, "%s=%s\n", row.Shortcode, row.ImageUrl)
+	}
+	catalog.Version = hex.EncodeToString(version.Sum(nil))[:16]
+
+	es.local.Set(catalogKey, catalog)
+
+	return catalog, nil
+}
+
+// Add registers an uploaded image under shortcode
+func (es *EmojiService) Add(ctx context.Context, shortcode, imageURL string, createdBy uuid.UUID) (*Custom, error) {
+	if err := ValidateShortcode(shortcode); err != nil {
+		return nil, err
+	}
+
+	catalog, err := es.Catalog(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(catalog.Custom) >= MaxCustom {
+		return nil, apperrors.NewValidationError(fmt.Sprintf("At most %d custom emoji are allowed", MaxCustom))
+	}
+	for _, custom := range catalog.Custom {
+		if custom.Shortcode == shortcode {
+			return nil, apperrors.New(apperrors.ErrCodeInvalidInput, fmt.Sprintf(":%s: already exists", shortcode), http.StatusConflict)
+		}
+	}
+
+	_, err = breaker.ExecuteCtx(ctx, es.cb, func() (interface{}, error) {
+		return es.qdb.CreateCustomEmoji(ctx, db.CreateCustomEmojiParams{
+			Shortcode: shortcode,
+			ImageUrl:  imageURL,
+			CreatedBy: uuid.NullUUID{UUID: createdBy, Valid: true},
+		})
+	})
+	if err != nil {
+		logger.WithFields(map[string]interface{}{
+			"shortcode": shortcode,
+			"error":     err.Error(),
+		}).Error("Circuit breaker: Failed to create custom emoji")
+		return nil, apperrors.NewDatabaseError("create custom emoji", err)
+	}
+
+	es.invalidate(ctx, shortcode)
+
+	return &Custom{Shortcode: shortcode, URL: imageURL}, nil
+}
+
+// Remove deletes a custom emoji and its image
+func (es *EmojiService) Remove(ctx context.Context, shortcode string) error {
+	result, err := breaker.ExecuteCtx(ctx, es.cb, func() (interface{}, error) {
+		row, err := es.qdb.DeleteCustomEmoji(ctx, shortcode)
+		if err != nil {
+			return nil, err
+		}
+		return row, nil
+	})
+	if err != nil {
+		logger.WithFields(map[string]interface{}{
+			"shortcode": shortcode,
+			"error":     err.Error(),
+		}).Error("Circuit breaker: Failed to delete custom emoji")
+		return apperrors.NewDatabaseError("delete custom emoji", err)
+	}
+
+	// Unknown shortcodes delete nothing: sql.ErrNoRows, which the breaker
+	// passes on as no result
+	row, ok := result.(db.CustomEmoji)
+	if !ok {
+		return apperrors.New(apperrors.ErrCodeNotFound, fmt.Sprintf(":%s: not found", shortcode), http.StatusNotFound)
+	}
+
+	es.invalidate(ctx, shortcode)
+
+	if uploads.IsObjectURL(row.ImageUrl) && es.uploads != nil {
+		if err := es.uploads.Release(ctx, row.ImageUrl); err != nil {
+			// The collector corrects the reference count
+			logger.WithError(err).Warn("Failed to release custom emoji image")
+		}
+	} else if strings.HasPrefix(row.ImageUrl, URLPrefix) {
+		path := filepath.Join(es.dir, filepath.Base(row.ImageUrl))
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			logger.WithFields(map[string]interface{}{
+				"path":  path,
+				"error": err.Error(),
+			}).Warn("Failed to delete custom emoji image")
+		}
+	}
+
+	return nil
+}
+
+func (es *EmojiService) invalidate(ctx context.Context, shortcode string) {
+	if err := es.invalidator.Invalidate(ctx, cache.KindEmoji, shortcode); err != nil {
+		// Other instances pick the change up when their cached catalog expires
+		logger.WithError(err).Warn("Failed to broadcast emoji invalidation")
+	}
+}
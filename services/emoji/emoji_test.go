@@ -0,0 +1,109 @@
+package emoji
+
+import (
+	"context"
+	"errors"
+	"exc6/apperrors"
+	"exc6/pkg/cache"
+	"exc6/tests/fakedb"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpand(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{
+			name:  "Built-in shortcode",
+			input: "nice :+1:",
+			want:  "nice 👍",
+		},
+		{
+			name:  "Several shortcodes",
+			input: ":fire::fire: ship it :rocket:",
+			want:  "🔥🔥 ship it 🚀",
+		},
+		{
+			name:  "Custom shortcode left for clients",
+			input: "hi :partyparrot:",
+			want:  "hi :partyparrot:",
+		},
+		{
+			name:  "Times are not shortcodes",
+			input: "meet at 12:30:00",
+			want:  "meet at 12:30:00",
+		},
+		{
+			name:  "Uppercase is not a shortcode",
+			input: ":FIRE:",
+			want:  ":FIRE:",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, Expand(tt.input))
+		})
+	}
+}
+
+func TestValidateShortcode(t *testing.T) {
+	assert.Nil(t, ValidateShortcode("partyparrot"))
+	assert.Nil(t, ValidateShortcode("ship-it_2"))
+	assert.NotNil(t, ValidateShortcode("fire"), "built-in shortcodes are reserved")
+	assert.NotNil(t, ValidateShortcode("a"))
+	assert.NotNil(t, ValidateShortcode("Party"))
+	assert.NotNil(t, ValidateShortcode("has space"))
+}
+
+// newService returns a service over a fake database and the emoji
+// invalidations it makes. Redis is down, so those only apply locally.
+func newService(t *testing.T) (*EmojiService, *fakedb.Fake, *[]string) {
+	fake := fakedb.New(t)
+
+	rdb := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", MaxRetries: -1, DialerRetries: 1})
+	t.Cleanup(func() { rdb.Close() })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	inv := cache.NewInvalidator(ctx, rdb)
+	invalidated := new([]string)
+	inv.OnInvalidate(cache.KindEmoji, func(keys []string) {
+		*invalidated = append(*invalidated, keys...)
+	})
+
+	return NewEmojiService(fake.Queries(), inv, t.TempDir()), fake, invalidated
+}
+
+func TestRemove(t *testing.T) {
+	es, fake, invalidated := newService(t)
+	ctx := context.Background()
+
+	err := es.Remove(ctx, "partyparrot")
+	var appErr *apperrors.AppError
+	require.True(t, errors.As(err, &appErr))
+	assert.Equal(t, http.StatusNotFound, appErr.StatusCode)
+	assert.Empty(t, *invalidated, "nothing removed, nothing to invalidate")
+
+	image := filepath.Join(es.dir, "partyparrot.gif")
+	require.NoError(t, os.MkdirAll(es.dir, 0o755))
+	require.NoError(t, os.WriteFile(image, []byte("GIF89a"), 0o644))
+
+	fake.Return("DeleteCustomEmoji", fakedb.Row(uuid.New(), "partyparrot", URLPrefix+"partyparrot.gif", nil, time.Now()))
+
+	require.NoError(t, es.Remove(ctx, "partyparrot"))
+	assert.Equal(t, []string{"partyparrot"}, *invalidated)
+	assert.NoFileExists(t, image)
+}
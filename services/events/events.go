@@ -0,0 +1,273 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"exc6/pkg/breaker"
+	"exc6/pkg/keyspace"
+	"exc6/pkg/logger"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/sony/gobreaker"
+)
+
+// Each conversation has an ordered feed of what happened in it, so clients
+// can keep a local copy up to date from the last sequence number they saw
+// instead of fetching the whole history again. Sequence numbers come from a
+// per-conversation counter and only grow. The feed keeps the latest
+// MaxEvents events for Retention; a client further behind is told to reset
+// and reload the history.
+const (
+	feedKeyPrefix = "events:feed:" // conversation -> events scored by sequence number
+	seqKeyPrefix  = "events:seq:"  // conversation -> last sequence number
+
+	// MaxEvents is how many events a conversation's feed keeps
+	MaxEvents = 1000
+
+	// Retention is how long an idle conversation's feed is kept
+	Retention = 30 * 24 * time.Hour
+
+	// MaxPage is the most events returned at once
+	MaxPage = 500
+)
+
+// Event types
+const (
+	TypeMessage    = "message"
+	TypeEdit       = "edit"
+	TypeDelete     = "delete"
+	TypeReaction   = "reaction"
+	TypeRead       = "read"
+	TypeMembership = "membership"
+)
+
+func init() {
+	keyspace.Register(
+		keyspace.Family{Prefix: feedKeyPrefix, Description: "event feed per conversation"},
+		keyspace.Family{
+			Prefix:      seqKeyPrefix,
+			Description: "last event sequence number per conversation",
+			Exempt:      "sequence numbers must never go back, so the counters outlive their feeds",
+		},
+	)
+}
+
+// Event is something that happened in a conversation
+type Event struct {
+	Seq       int64  `json:"seq"`
+	Type      string `json:"type"`
+	Actor     string `json:"actor"`
+	MessageID string `json:"message_id,omitempty"`
+	Timestamp int64  `json:"timestamp"` // unix milliseconds
+
+	// Data is the event's payload: the message for message, edit and delete
+	// events, the reaction event for reactions, the receipt for reads and
+	// the change for membership events
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+// Feed is a page of a conversation's events
+type Feed struct {
+	Events []Event `json:"events"`
+
+	// LatestSeq is the sequence number of the conversation's latest event
+	LatestSeq int64 `json:"latest_seq"`
+
+	// Reset is set when events after the cursor are no longer kept; the
+	// client must reload the history and continue from LatestSeq
+	Reset bool `json:"reset"`
+
+	// HasMore is set when more events follow the last one returned
+	HasMore bool `json:"has_more"`
+}
+
+// Direct returns the conversation between two users
+func Direct(user1, user2 string) string {
+	if user1 > user2 {
+		user1, user2 = user2, user1
+	}
+	return "direct:" + user1 + ":" + user2
+}
+
+// Group returns the conversation of a group
+func Group(groupID string) string {
+	return "group:" + groupID
+}
+
+// appendScript gives an event the conversation's next sequence number and
+// adds it to the feed, trimming the oldest events.
+//
+// KEYS: feed, sequence counter
+//
+// ARGV: event JSON without its sequence number, feed size, feed TTL (s)
+var appendScript = redis.NewScript(`
+local seq = redis.call('INCR', KEYS[2])
+redis.call('ZADD', KEYS[1], seq, seq .. ':' .. ARGV[1])
+redis.call('ZREMRANGEBYRANK', KEYS[1], 0, -tonumber(ARGV[2]) - 1)
+redis.call('EXPIRE', KEYS[1], ARGV[3])
+return seq
+`)
+
+// Log records conversation events in Redis. A nil Log records nothing.
+type Log struct {
+	rdb *redis.Client
+	cb  *gobreaker.CircuitBreaker
+}
+
+// NewLog creates an event log backed by Redis
+func NewLog(rdb *redis.Client) *Log {
+	return &Log{
+		rdb: rdb,
+		cb: breaker.New(breaker.Config{
+			Name:        "redis-events",
+			MaxRequests: 5,
+			Interval:    60 * time.Second,
+			Timeout:     30 * time.Second,
+			Threshold:   0.5,
+			MinRequests: 5,
+		}),
+	}
+}
+
+// Append records an event in a conversation's feed with data as its
+// payload. The event already happened, so failures are only logged.
+func (l *Log) Append(ctx context.Context, conversation, eventType, actor, messageID string, data any) {
+	if l == nil {
+		return
+	}
+
+	event := Event{
+		Type:      eventType,
+		Actor:     actor,
+		MessageID: messageID,
+		Timestamp: time.Now().UnixMilli(),
+	}
+	if data != nil {
+		payload, err := json.Marshal(data)
+		if err != nil {
+			return
+		}
+		event.Data = payload
+	}
+	eventJSON, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+
+	// The request context may already be close to its deadline
+	appendCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 2*time.Second)
+	defer cancel()
+
+	if _, err := breaker.ExecuteCtx(appendCtx, l.cb, func() (any, error) {
+		return nil, appendScript.Run(appendCtx, l.rdb,
+			[]string{feedKeyPrefix + conversation, seqKeyPrefix + conversation},
+			eventJSON, MaxEvents, int64(Retention/time.Second),
+		).Err()
+	}); err != nil {
+		logger.WithFields(map[string]any{
+			"conversation": conversation,
+			"type":         eventType,
+			"error":        err.Error(),
+		}).Warn("Circuit breaker: Failed to record conversation event")
+	}
+}
+
+// Since returns up to limit events of a conversation after the sequence
+// number since, oldest first
+func (l *Log) Since(ctx context.Context, conversation string, since int64, limit int) (*Feed, error) {
+	if l == nil {
+		return &Feed{Events: []Event{}, Reset: since > 0}, nil
+	}
+	if limit < 1 || limit > MaxPage {
+		limit = MaxPage
+	}
+
+	var (
+		events *redis.StringSliceCmd
+		oldest *redis.ZSliceCmd
+		latest *redis.StringCmd
+	)
+
+	_, err := breaker.ExecuteCtx(ctx, l.cb, func() (any, error) {
+		// MULTI keeps the page, the oldest event and the counter consistent
+		pipe := l.rdb.TxPipeline()
+		events = pipe.ZRangeByScore(ctx, feedKeyPrefix+conversation, &redis.ZRangeBy{
+			Min:   "(" + strconv.FormatInt(since, 10),
+			Max:   "+inf",
+			Count: int64(limit) + 1,
+		})
+		oldest = pipe.ZRangeWithScores(ctx, feedKeyPrefix+conversation, 0, 0)
+		latest = pipe.Get(ctx, seqKeyPrefix+conversation)
+
+		_, err := pipe.Exec(ctx)
+		if errors.Is(err, redis.Nil) {
+			// The conversation has no events yet
+			err = nil
+		}
+		return nil, err
+	})
+	if err != nil {
+		logger.WithFields(map[string]any{
+			"conversation": conversation,
+			"error":        err.Error(),
+		}).Warn("Circuit breaker: Failed to read conversation events")
+		return nil, err
+	}
+
+	feed := &Feed{Events: make([]Event, 0, len(events.Val()))}
+	feed.LatestSeq, _ = latest.Int64()
+
+	oldestSeq := feed.LatestSeq + 1
+	if entries := oldest.Val(); len(entries) > 0 {
+		oldestSeq = int64(entries[0].Score)
+	}
+	feed.Reset = needsReset(since, oldestSeq, feed.LatestSeq)
+	if feed.Reset {
+		return feed, nil
+	}
+
+	for _, member := range events.Val() {
+		event, ok := parseMember(member)
+		if !ok {
+			continue
+		}
+		if len(feed.Events) == limit {
+			feed.HasMore = true
+			break
+		}
+		feed.Events = append(feed.Events, event)
+	}
+	return feed, nil
+}
+
+// needsReset reports whether a client at since missed events: those between
+// since and the oldest kept are gone, or since is ahead of the counter
+func needsReset(since, oldestSeq, latestSeq int64) bool {
+	if since > latestSeq {
+		return true
+	}
+	return since < latestSeq && since+1 < oldestSeq
+}
+
+// parseMember decodes a feed member, "<seq>:<event JSON>"
+func parseMember(member string) (Event, bool) {
+	seqPart, eventJSON, ok := strings.Cut(member, ":")
+	if !ok {
+		return Event{}, false
+	}
+	seq, err := strconv.ParseInt(seqPart, 10, 64)
+	if err != nil {
+		return Event{}, false
+	}
+
+	var event Event
+	if err := json.Unmarshal([]byte(eventJSON), &event); err != nil {
+		return Event{}, false
+	}
+	event.Seq = seq
+	return event, true
+}
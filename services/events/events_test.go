@@ -0,0 +1,46 @@
+package events
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDirect(t *testing.T) {
+	assert.Equal(t, Direct("alice", "bob"), Direct("bob", "alice"))
+	assert.NotEqual(t, Direct("alice", "bob"), Direct("alice", "carol"))
+}
+
+func TestNeedsReset(t *testing.T) {
+	tests := []struct {
+		name      string
+		since     int64
+		oldestSeq int64
+		latestSeq int64
+		want      bool
+	}{
+		{name: "No events yet", since: 0, oldestSeq: 1, latestSeq: 0, want: false},
+		{name: "Up to date", since: 10, oldestSeq: 5, latestSeq: 10, want: false},
+		{name: "Next event kept", since: 4, oldestSeq: 5, latestSeq: 10, want: false},
+		{name: "Events trimmed", since: 3, oldestSeq: 5, latestSeq: 10, want: true},
+		{name: "Feed expired", since: 3, oldestSeq: 11, latestSeq: 10, want: true},
+		{name: "Ahead of the counter", since: 12, oldestSeq: 5, latestSeq: 10, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, needsReset(tt.since, tt.oldestSeq, tt.latestSeq))
+		})
+	}
+}
+
+func TestParseMember(t *testing.T) {
+	event, ok := parseMember(`42:{"seq":0,"type":"edit","actor":"alice","message_id":"m1","timestamp":1700000000000}`)
+	assert.True(t, ok)
+	assert.Equal(t, Event{Seq: 42, Type: TypeEdit, Actor: "alice", MessageID: "m1", Timestamp: 1700000000000}, event)
+
+	_, ok = parseMember("not an event")
+	assert.False(t, ok)
+	_, ok = parseMember("x:{}")
+	assert.False(t, ok)
+}
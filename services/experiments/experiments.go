@@ -0,0 +1,323 @@
+package experiments
+
+import (
+	"context"
+	"exc6/apperrors"
+	"exc6/config"
+	"exc6/pkg/breaker"
+	"exc6/pkg/instance"
+	"exc6/pkg/keyspace"
+	"exc6/pkg/logger"
+	"exc6/server/middleware/canary"
+	"exc6/services/chat"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/redis/go-redis/v9"
+	"github.com/sony/gobreaker"
+)
+
+// Experiments split users between buckets the same way canary routing picks
+// a variant: by hashing, here of the experiment name and user ID, so each
+// user stays in one bucket. A user counts towards an experiment from their
+// first exposure, when code serving a variant asks for their bucket. The
+// exposure is logged as an analytics event and remembered in Redis, and from
+// then on the user's messages and WebSocket sessions are added to their
+// bucket's totals.
+
+const (
+	keyPrefix = "experiments:"
+
+	// Metrics aggregated per bucket
+	MetricUsers          = "users"
+	MetricMessagesSent   = "messages_sent"
+	MetricSessions       = "sessions"
+	MetricSessionSeconds = "session_seconds"
+
+	queueSize = 1000
+)
+
+func init() {
+	keyspace.Register(keyspace.Family{Prefix: keyPrefix, Description: "experiment exposures and per-bucket totals"})
+}
+
+var (
+	exposuresTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "experiment_exposures_total",
+			Help: "Users exposed to an experiment for the first time, by experiment and bucket",
+		},
+		[]string{"experiment", "bucket"},
+	)
+
+	droppedEvents = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "experiment_events_dropped_total",
+			Help: "Message and session events not counted because the queue was full",
+		},
+	)
+)
+
+func init() {
+	instance.Registerer().MustRegister(exposuresTotal)
+	instance.Registerer().MustRegister(droppedEvents)
+}
+
+// Experiment is a configured experiment
+type Experiment struct {
+	Name    string   `json:"name"`
+	Buckets []string `json:"buckets"`
+	Weights []int    `json:"weights"`
+}
+
+// BucketResult holds the totals for one bucket
+type BucketResult struct {
+	Bucket            string  `json:"bucket"`
+	Users             int64   `json:"users"`
+	MessagesSent      int64   `json:"messages_sent"`
+	MessagesPerUser   float64 `json:"messages_per_user"`
+	Sessions          int64   `json:"sessions"`
+	AvgSessionSeconds float64 `json:"avg_session_seconds"`
+}
+
+// event adds values to the totals of the buckets username was exposed to
+type event struct {
+	username string
+	values   map[string]float64
+}
+
+// Service assigns users to experiment buckets and aggregates their metrics
+type Service struct {
+	rdb *redis.Client
+	cb  *gobreaker.CircuitBreaker
+
+	experiments map[string]Experiment
+	names       []string
+
+	queue chan event
+}
+
+// NewService creates the service for the configured experiments. It counts
+// messages sent through csrv and processes events until ctx is cancelled.
+func NewService(ctx context.Context, rdb *redis.Client, cfgs []config.ExperimentConfig, csrv chat.Service) *Service {
+	s := &Service{
+		rdb:         rdb,
+		experiments: make(map[string]Experiment, len(cfgs)),
+		queue:       make(chan event, queueSize),
+		cb: breaker.New(breaker.Config{
+			Name:        "redis-experiments",
+			MaxRequests: 3,
+			Interval:    60 * time.Second,
+			Timeout:     30 * time.Second,
+			Threshold:   0.5,
+			MinRequests: 5,
+		}),
+	}
+
+	for _, cfg := range cfgs {
+		s.experiments[cfg.Name] = Experiment{Name: cfg.Name, Buckets: cfg.Buckets, Weights: cfg.Weights}
+		s.names = append(s.names, cfg.Name)
+	}
+
+	if len(s.names) > 0 {
+		csrv.AddMessageHook(s.observeMessage)
+		go s.worker(ctx)
+	}
+
+	return s
+}
+
+// List returns the configured experiments
+func (s *Service) List() []Experiment {
+	list := make([]Experiment, 0, len(s.names))
+	for _, name := range s.names {
+		list = append(list, s.experiments[name])
+	}
+	return list
+}
+
+// Assign returns the bucket userID falls in, without recording an exposure
+func (s *Service) Assign(name, userID string) (string, bool) {
+	exp, ok := s.experiments[name]
+	if !ok {
+		return "", false
+	}
+	return exp.Buckets[canary.Bucket(name+":"+userID, exp.Weights)], true
+}
+
+// Expose returns the user's bucket and records their first exposure. Once
+// exposed, a user keeps the bucket they were counted in even if the weights
+// change later.
+func (s *Service) Expose(ctx context.Context, name, userID, username string) (string, error) {
+	bucket, ok := s.Assign(name, userID)
+	if !ok {
+		return "", apperrors.New(apperrors.ErrCodeNotFound, "Experiment not found", http.StatusNotFound)
+	}
+
+	var added *redis.BoolCmd
+	var current *redis.StringCmd
+	if _, err := breaker.ExecuteCtx(ctx, s.cb, func() (any, error) {
+		pipe := s.rdb.TxPipeline()
+		added = pipe.HSetNX(ctx, exposedKey(name), username, bucket)
+		current = pipe.HGet(ctx, exposedKey(name), username)
+		_, err := pipe.Exec(ctx)
+		return nil, err
+	}); err != nil {
+		// Serve the assigned variant; the exposure goes uncounted
+		logger.WithError(err).Warn("Failed to record experiment exposure")
+		return bucket, nil
+	}
+
+	if !added.Val() {
+		if recorded := current.Val(); recorded != "" {
+			return recorded, nil
+		}
+		return bucket, nil
+	}
+
+	s.add(ctx, name, bucket, map[string]float64{MetricUsers: 1})
+	exposuresTotal.WithLabelValues(name, bucket).Inc()
+
+	logger.WithFields(map[string]any{
+		"event":      "experiment_exposure",
+		"experiment": name,
+		"bucket":     bucket,
+		"user_id":    userID,
+		"username":   username,
+	}).Info("Experiment exposure")
+
+	return bucket, nil
+}
+
+// Results returns the totals of each bucket of an experiment
+func (s *Service) Results(ctx context.Context, name string) ([]BucketResult, error) {
+	exp, ok := s.experiments[name]
+	if !ok {
+		return nil, apperrors.New(apperrors.ErrCodeNotFound, "Experiment not found", http.StatusNotFound)
+	}
+
+	cmds := make([]*redis.MapStringStringCmd, len(exp.Buckets))
+	if _, err := breaker.ExecuteCtx(ctx, s.cb, func() (any, error) {
+		pipe := s.rdb.Pipeline()
+		for i, bucket := range exp.Buckets {
+			cmds[i] = pipe.HGetAll(ctx, bucketKey(name, bucket))
+		}
+		_, err := pipe.Exec(ctx)
+		return nil, err
+	}); err != nil {
+		return nil, apperrors.NewCacheError("experiment_results", keyPrefix+name, err)
+	}
+
+	results := make([]BucketResult, len(exp.Buckets))
+	for i, bucket := range exp.Buckets {
+		totals := cmds[i].Val()
+		r := BucketResult{
+			Bucket:       bucket,
+			Users:        int64(parseFloat(totals[MetricUsers])),
+			MessagesSent: int64(parseFloat(totals[MetricMessagesSent])),
+			Sessions:     int64(parseFloat(totals[MetricSessions])),
+		}
+		if r.Users > 0 {
+			r.MessagesPerUser = float64(r.MessagesSent) / float64(r.Users)
+		}
+		if r.Sessions > 0 {
+			r.AvgSessionSeconds = parseFloat(totals[MetricSessionSeconds]) / float64(r.Sessions)
+		}
+		results[i] = r
+	}
+	return results, nil
+}
+
+// SessionEnded counts a finished WebSocket session; it implements
+// websocket.SessionObserver
+func (s *Service) SessionEnded(username string, duration time.Duration) {
+	s.enqueue(event{username: username, values: map[string]float64{
+		MetricSessions:       1,
+		MetricSessionSeconds: duration.Seconds(),
+	}})
+}
+
+// observeMessage counts a sent message; it runs as a chat.MessageHook
+func (s *Service) observeMessage(msg *chat.ChatMessage) {
+	s.enqueue(event{username: msg.FromID, values: map[string]float64{MetricMessagesSent: 1}})
+}
+
+// enqueue hands an event to the worker without blocking
+func (s *Service) enqueue(e event) {
+	if len(s.names) == 0 {
+		return
+	}
+	select {
+	case s.queue <- e:
+	default:
+		droppedEvents.Inc()
+	}
+}
+
+func (s *Service) worker(ctx context.Context) {
+	for {
+		select {
+		case e := <-s.queue:
+			recordCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+			s.record(recordCtx, e)
+			cancel()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// record adds an event to the buckets its user was exposed to
+func (s *Service) record(ctx context.Context, e event) {
+	cmds := make([]*redis.StringCmd, len(s.names))
+	if _, err := breaker.ExecuteCtx(ctx, s.cb, func() (any, error) {
+		pipe := s.rdb.Pipeline()
+		for i, name := range s.names {
+			cmds[i] = pipe.HGet(ctx, exposedKey(name), e.username)
+		}
+		_, err := pipe.Exec(ctx)
+		return nil, err
+	}); err != nil {
+		logger.WithError(err).Debug("Failed to look up experiment exposures")
+		return
+	}
+
+	for i, name := range s.names {
+		if bucket := cmds[i].Val(); bucket != "" {
+			s.add(ctx, name, bucket, e.values)
+		}
+	}
+}
+
+// add increments the totals of a bucket
+func (s *Service) add(ctx context.Context, name, bucket string, values map[string]float64) {
+	if _, err := breaker.ExecuteCtx(ctx, s.cb, func() (any, error) {
+		pipe := s.rdb.Pipeline()
+		for metric, v := range values {
+			pipe.HIncrByFloat(ctx, bucketKey(name, bucket), metric, v)
+		}
+		_, err := pipe.Exec(ctx)
+		return nil, err
+	}); err != nil {
+		logger.WithFields(map[string]any{
+			"experiment": name,
+			"bucket":     bucket,
+			"error":      err.Error(),
+		}).Warn("Failed to update experiment totals")
+	}
+}
+
+func exposedKey(name string) string {
+	return keyPrefix + name + ":exposed"
+}
+
+func bucketKey(name, bucket string) string {
+	return keyPrefix + name + ":bucket:" + bucket
+}
+
+func parseFloat(s string) float64 {
+	v, _ := strconv.ParseFloat(s, 64)
+	return v
+}
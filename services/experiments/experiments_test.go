@@ -0,0 +1,161 @@
+package experiments
+
+import (
+	"bytes"
+	"context"
+	"exc6/apperrors"
+	"exc6/config"
+	"exc6/pkg/logger"
+	"exc6/services/chat"
+	"exc6/tests/fakeredis"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newService returns a service running one experiment split evenly between
+// control and variant
+func newService(t *testing.T) (*fakeredis.Server, *Service, *chat.MemoryService) {
+	srv := fakeredis.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	csrv := chat.NewMemoryService(nil)
+	s := NewService(ctx, srv.Client(t), []config.ExperimentConfig{
+		{Name: "compose", Buckets: []string{"control", "variant"}, Weights: []int{50, 50}},
+	}, csrv)
+	return srv, s, csrv
+}
+
+// usersIn returns n user IDs falling in bucket of the compose experiment
+func usersIn(t *testing.T, s *Service, bucket string, n int) []string {
+	t.Helper()
+	var userIDs []string
+	for i := 0; len(userIDs) < n; i++ {
+		require.Less(t, i, 1000, "no users fall in %s", bucket)
+		userID := fmt.Sprintf("user-%d", i)
+		if assigned, _ := s.Assign("compose", userID); assigned == bucket {
+			userIDs = append(userIDs, userID)
+		}
+	}
+	return userIDs
+}
+
+// captureLogs sends the default logger to a buffer for the rest of the test
+func captureLogs(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+	previous := logger.GetDefault()
+	captured, err := logger.NewWithConfig(logger.Config{Output: &buf, Level: logger.INFO})
+	require.NoError(t, err)
+	logger.SetDefault(captured)
+	t.Cleanup(func() { logger.SetDefault(previous) })
+	return &buf
+}
+
+func TestExpose(t *testing.T) {
+	srv, s, _ := newService(t)
+	ctx := context.Background()
+	logs := captureLogs(t)
+
+	aliceID := usersIn(t, s, "variant", 1)[0]
+	exposures := exposuresTotal.WithLabelValues("compose", "variant")
+	before := testutil.ToFloat64(exposures)
+
+	bucket, err := s.Expose(ctx, "compose", aliceID, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, "variant", bucket)
+	assert.Equal(t, 1.0, testutil.ToFloat64(exposures)-before)
+	assert.Equal(t, 1, strings.Count(logs.String(), "event=experiment_exposure"))
+	assert.Contains(t, logs.String(), "user_id="+aliceID)
+	assert.Contains(t, logs.String(), "bucket=variant")
+
+	// Only the first exposure is counted and logged
+	bucket, err = s.Expose(ctx, "compose", aliceID, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, "variant", bucket)
+	assert.Equal(t, 1.0, testutil.ToFloat64(exposures)-before)
+	assert.Equal(t, 1, strings.Count(logs.String(), "event=experiment_exposure"))
+
+	// A user keeps the bucket they were counted in when the weights change
+	s.experiments["compose"] = Experiment{Name: "compose", Buckets: []string{"control", "variant"}, Weights: []int{100, 0}}
+	assigned, _ := s.Assign("compose", aliceID)
+	require.Equal(t, "control", assigned)
+	bucket, err = s.Expose(ctx, "compose", aliceID, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, "variant", bucket)
+
+	results, err := s.Results(ctx, "compose")
+	require.NoError(t, err)
+	assert.Equal(t, []BucketResult{{Bucket: "control"}, {Bucket: "variant", Users: 1}}, results)
+
+	_, err = s.Expose(ctx, "unknown", aliceID, "alice")
+	var appErr *apperrors.AppError
+	require.ErrorAs(t, err, &appErr)
+	assert.Equal(t, apperrors.ErrCodeNotFound, appErr.Code)
+
+	// The variant is still served while Redis is down, uncounted
+	srv.SetDown(true)
+	bobID := usersIn(t, s, "control", 1)[0]
+	bucket, err = s.Expose(ctx, "compose", bobID, "bob")
+	require.NoError(t, err)
+	assert.Equal(t, "control", bucket)
+	assert.Equal(t, 1, strings.Count(logs.String(), "event=experiment_exposure"))
+}
+
+func TestResults(t *testing.T) {
+	srv, s, csrv := newService(t)
+	ctx := context.Background()
+
+	control := usersIn(t, s, "control", 1)
+	variant := usersIn(t, s, "variant", 2)
+	for userID, username := range map[string]string{control[0]: "alice", variant[0]: "bob", variant[1]: "carol"} {
+		_, err := s.Expose(ctx, "compose", userID, username)
+		require.NoError(t, err)
+	}
+
+	// dave was never exposed, so nothing they do is counted
+	for from, sent := range map[string]int{"alice": 2, "bob": 3, "carol": 1, "dave": 4} {
+		for range sent {
+			_, err := csrv.SendMessage(ctx, from, "erin", "hello")
+			require.NoError(t, err)
+		}
+	}
+	s.SessionEnded("alice", 30*time.Second)
+	s.SessionEnded("bob", 10*time.Second)
+	s.SessionEnded("bob", 50*time.Second)
+	s.SessionEnded("dave", time.Hour)
+
+	want := []BucketResult{
+		{Bucket: "control", Users: 1, MessagesSent: 2, MessagesPerUser: 2, Sessions: 1, AvgSessionSeconds: 30},
+		{Bucket: "variant", Users: 2, MessagesSent: 4, MessagesPerUser: 2, Sessions: 2, AvgSessionSeconds: 30},
+	}
+	require.Eventually(t, func() bool {
+		results, err := s.Results(ctx, "compose")
+		require.NoError(t, err)
+		return assert.ObjectsAreEqual(want, results)
+	}, time.Second, 10*time.Millisecond)
+
+	_, err := s.Results(ctx, "unknown")
+	var appErr *apperrors.AppError
+	require.ErrorAs(t, err, &appErr)
+	assert.Equal(t, apperrors.ErrCodeNotFound, appErr.Code)
+
+	srv.SetDown(true)
+	_, err = s.Results(ctx, "compose")
+	assert.Error(t, err)
+}
+
+func TestNoExperiments(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	s := NewService(ctx, fakeredis.New(t).Client(t), nil, chat.NewMemoryService(nil))
+
+	assert.Empty(t, s.List())
+	s.SessionEnded("alice", time.Minute)
+	assert.Empty(t, s.queue, "events are not queued without a worker")
+}
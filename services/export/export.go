@@ -0,0 +1,292 @@
+package export
+
+import (
+	"context"
+	"exc6/apperrors"
+	"exc6/db"
+	"exc6/pkg/breaker"
+	"exc6/pkg/logger"
+	"exc6/services/chat"
+	"exc6/services/groups"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sony/gobreaker"
+)
+
+// Format is an export file format
+type Format string
+
+const (
+	FormatHTML Format = "html"
+	FormatPDF  Format = "pdf"
+)
+
+// pageSize is the number of rows read per query when paging through history
+const pageSize = 500
+
+// Range limits a transcript to messages sent within [From, To). Zero bounds are open.
+type Range struct {
+	From time.Time
+	To   time.Time
+}
+
+func (r Range) contains(t time.Time) bool {
+	return (r.From.IsZero() || !t.Before(r.From)) && (r.To.IsZero() || t.Before(r.To))
+}
+
+// Entry is one message of a transcript
+type Entry struct {
+	From    string
+	Content string
+	Subtype string
+	SentAt  time.Time
+}
+
+// Transcript is a conversation prepared for export
+type Transcript struct {
+	Title        string
+	Participants []string
+	Range        Range
+	Entries      []Entry
+	GeneratedAt  time.Time
+
+	// Partial is set when older messages were left out: group history is only
+	// kept for recent messages, and exports are capped at the configured limit
+	Partial bool
+}
+
+// First returns the time of the oldest entry
+func (t *Transcript) First() time.Time {
+	if len(t.Entries) == 0 {
+		return time.Time{}
+	}
+	return t.Entries[0].SentAt
+}
+
+// Last returns the time of the newest entry
+func (t *Transcript) Last() time.Time {
+	if len(t.Entries) == 0 {
+		return time.Time{}
+	}
+	return t.Entries[len(t.Entries)-1].SentAt
+}
+
+// ExportService builds conversation transcripts and renders them as HTML or PDF
+type ExportService struct {
+	qdb      *db.Queries
+	csrv     chat.Service
+	gsrv     *groups.GroupService
+	renderer Renderer
+	cb       *gobreaker.CircuitBreaker
+
+	mu     sync.RWMutex
+	limits Limits
+}
+
+// Limits caps exports and share links
+type Limits struct {
+	MaxMessages    int           // Messages per transcript; older messages are left out
+	ShareLinks     bool          // Whether share links can be created
+	ShareMaxExpiry time.Duration // Longest a share link stays valid
+}
+
+// NewExportService creates the service. renderer may be nil, which disables PDF exports.
+func NewExportService(qdb *db.Queries, csrv chat.Service, gsrv *groups.GroupService, renderer Renderer, maxMessages int) *ExportService {
+	return &ExportService{
+		qdb:      qdb,
+		csrv:     csrv,
+		gsrv:     gsrv,
+		renderer: renderer,
+		limits: Limits{
+			MaxMessages:    maxMessages,
+			ShareLinks:     true,
+			ShareMaxExpiry: ShareMaxExpiry,
+		},
+		cb: breaker.New(breaker.Config{
+			Name:        "postgres-export",
+			MaxRequests: 5,
+			Interval:    60 * time.Second,
+			Timeout:     30 * time.Second,
+			Threshold:   0.5,
+			MinRequests: 5,
+		}),
+	}
+}
+
+// SetLimits changes the export and share link limits. Existing links keep
+// their expiry.
+func (es *ExportService) SetLimits(limits Limits) {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	es.limits = limits
+}
+
+func (es *ExportService) currentLimits() Limits {
+	es.mu.RLock()
+	defer es.mu.RUnlock()
+	return es.limits
+}
+
+// PDFEnabled reports whether a PDF renderer is configured
+func (es *ExportService) PDFEnabled() bool {
+	return es.renderer != nil
+}
+
+// DirectTranscript builds the transcript of username's conversation with contact
+func (es *ExportService) DirectTranscript(ctx context.Context, username, contact string, rng Range) (*Transcript, error) {
+	maxMessages := es.currentLimits().MaxMessages
+	transcript := &Transcript{
+		Title:        "Conversation with " + contact,
+		Participants: []string{username, contact},
+		Range:        rng,
+		GeneratedAt:  time.Now(),
+	}
+
+	// Rows come newest first; page until the range start or the cap is reached
+	for offset := 0; ; offset += pageSize {
+		result, err := breaker.ExecuteCtx(ctx, es.cb, func() (interface{}, error) {
+			return es.qdb.GetMessagesBetweenUsers(ctx, db.GetMessagesBetweenUsersParams{
+				Username:   username,
+				Username_2: contact,
+				Limit:      pageSize,
+				Offset:     int32(offset),
+			})
+		})
+		if err != nil {
+			logger.WithFields(map[string]interface{}{
+				"username": username,
+				"contact":  contact,
+				"error":    err.Error(),
+			}).Error("Circuit breaker: Failed to load conversation for export")
+			return nil, apperrors.NewDatabaseError("export conversation", err)
+		}
+
+		rows, _ := result.([]db.GetMessagesBetweenUsersRow)
+		for _, row := range rows {
+			if !rng.From.IsZero() && row.CreatedAt.Before(rng.From) {
+				return transcript.finish(), nil
+			}
+			if !rng.contains(row.CreatedAt) || row.DeletedAt.Valid {
+				continue
+			}
+			if len(transcript.Entries) == maxMessages {
+				transcript.Partial = true
+				return transcript.finish(), nil
+			}
+
+			transcript.Entries = append(transcript.Entries, Entry{
+				From:    row.FromUsername,
+				Content: row.Content,
+				Subtype: row.Subtype,
+				SentAt:  row.CreatedAt,
+			})
+		}
+
+		if len(rows) < pageSize {
+			return transcript.finish(), nil
+		}
+	}
+}
+
+// GroupTranscript builds the transcript of a group's recent history. username
+// must be a member.
+func (es *ExportService) GroupTranscript(ctx context.Context, username, groupID string, rng Range) (*Transcript, error) {
+	info, err := es.gsrv.GetGroupInfo(ctx, groupID, username)
+	if err != nil {
+		return nil, err
+	}
+
+	members, err := es.gsrv.GetGroupMembers(ctx, groupID, username)
+	if err != nil {
+		return nil, err
+	}
+
+	messages, err := es.csrv.GetGroupHistory(ctx, groupID)
+	if err != nil {
+		return nil, apperrors.NewInternalError("Failed to load group history").WithInternal(err)
+	}
+
+	maxMessages := es.currentLimits().MaxMessages
+	transcript := &Transcript{
+		Title:       info.Name,
+		Range:       rng,
+		GeneratedAt: time.Now(),
+		Partial:     len(messages) >= chat.RecentMessagesCacheSize,
+	}
+	for _, member := range members {
+		transcript.Participants = append(transcript.Participants, member.Username)
+	}
+
+	// Newest first, matching DirectTranscript, so the cap keeps the latest messages
+	sort.Slice(messages, func(i, j int) bool {
+		return messages[i].Timestamp > messages[j].Timestamp
+	})
+
+	for _, msg := range messages {
+		sentAt := time.Unix(msg.Timestamp, 0)
+		if !rng.contains(sentAt) || msg.Deleted {
+			continue
+		}
+		if len(transcript.Entries) == maxMessages {
+			transcript.Partial = true
+			break
+		}
+
+		transcript.Entries = append(transcript.Entries, Entry{
+			From:    msg.FromID,
+			Content: msg.Content,
+			Subtype: msg.Subtype,
+			SentAt:  sentAt,
+		})
+	}
+
+	return transcript.finish(), nil
+}
+
+// finish puts entries in chronological order
+func (t *Transcript) finish() *Transcript {
+	for i, j := 0, len(t.Entries)-1; i < j; i, j = i+1, j-1 {
+		t.Entries[i], t.Entries[j] = t.Entries[j], t.Entries[i]
+	}
+	return t
+}
+
+// Render produces the transcript in the requested format and returns the
+// file contents with their content type
+func (es *ExportService) Render(ctx context.Context, transcript *Transcript, format Format) ([]byte, string, error) {
+	switch format {
+	case FormatHTML:
+		body, err := renderHTML(transcript)
+		return body, "text/html; charset=utf-8", err
+
+	case FormatPDF:
+		if es.renderer == nil {
+			return nil, "", apperrors.New(apperrors.ErrCodeServiceUnavail, "PDF export is not configured", http.StatusNotImplemented)
+		}
+
+		body, err := renderHTML(transcript)
+		if err != nil {
+			return nil, "", err
+		}
+		header, err := renderHeader(transcript)
+		if err != nil {
+			return nil, "", err
+		}
+
+		pdf, err := es.renderer.RenderPDF(ctx, Document{Body: body, Header: header})
+		if err != nil {
+			logger.WithFields(map[string]interface{}{
+				"title": transcript.Title,
+				"error": err.Error(),
+			}).Error("Failed to render PDF export")
+			return nil, "", err
+		}
+		return pdf, "application/pdf", nil
+
+	default:
+		return nil, "", apperrors.NewValidationError("Unsupported export format (use html or pdf)")
+	}
+}
@@ -0,0 +1,215 @@
+package export
+
+import (
+	"context"
+	"errors"
+	"exc6/apperrors"
+	"exc6/services/chat"
+	"exc6/services/groups"
+	"exc6/tests/fakedb"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// directMessages answers GetMessagesBetweenUsers with count messages, one a
+// minute from start, newest first as the query does
+func directMessages(fake *fakedb.Fake, start time.Time, count int, deleted map[int]bool) {
+	fake.On("GetMessagesBetweenUsers", func(args []any) (fakedb.Result, error) {
+		limit, offset := int(args[2].(int64)), int(args[3].(int64))
+		var result fakedb.Result
+		for i := count - 1 - offset; i >= 0 && len(result.Rows) < limit; i-- {
+			var deletedAt any
+			if deleted[i] {
+				deletedAt = start
+			}
+			from := "alice"
+			if i%2 == 1 {
+				from = "bob"
+			}
+			result.Rows = append(result.Rows, []any{
+				fmt.Sprintf("m%d", i), fmt.Sprintf("message %d", i), chat.SubtypeText,
+				start.Add(time.Duration(i) * time.Minute), nil, deletedAt, from, "other",
+			})
+		}
+		return result, nil
+	})
+}
+
+func contents(transcript *Transcript) []string {
+	out := make([]string, len(transcript.Entries))
+	for i, entry := range transcript.Entries {
+		out[i] = entry.Content
+	}
+	return out
+}
+
+func TestDirectTranscript(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	ctx := context.Background()
+
+	t.Run("Pages through the whole history", func(t *testing.T) {
+		fake := fakedb.New(t)
+		directMessages(fake, start, pageSize+20, map[int]bool{1: true})
+		es := NewExportService(fake.Queries(), nil, nil, nil, 10000)
+
+		transcript, err := es.DirectTranscript(ctx, "alice", "bob", Range{})
+		require.NoError(t, err)
+		assert.Len(t, fake.Calls("GetMessagesBetweenUsers"), 2)
+		assert.Len(t, transcript.Entries, pageSize+19, "deleted messages are left out")
+		assert.False(t, transcript.Partial)
+		assert.Equal(t, "message 0", transcript.Entries[0].Content, "oldest first")
+		assert.Equal(t, "message 2", transcript.Entries[1].Content)
+		assert.Equal(t, "bob", transcript.Entries[len(transcript.Entries)-1].From)
+		assert.Equal(t, []string{"alice", "bob"}, transcript.Participants)
+	})
+
+	t.Run("Range", func(t *testing.T) {
+		fake := fakedb.New(t)
+		directMessages(fake, start, 2*pageSize, nil)
+		es := NewExportService(fake.Queries(), nil, nil, nil, 10000)
+
+		rng := Range{From: start.Add((pageSize + 1) * time.Minute), To: start.Add((pageSize + 4) * time.Minute)}
+		transcript, err := es.DirectTranscript(ctx, "alice", "bob", rng)
+		require.NoError(t, err)
+		want := []string{fmt.Sprintf("message %d", pageSize+1), fmt.Sprintf("message %d", pageSize+2), fmt.Sprintf("message %d", pageSize+3)}
+		assert.Equal(t, want, contents(transcript))
+		assert.Len(t, fake.Calls("GetMessagesBetweenUsers"), 1, "paging stops at the range start")
+	})
+
+	t.Run("Capped to the latest messages", func(t *testing.T) {
+		fake := fakedb.New(t)
+		directMessages(fake, start, 10, nil)
+		es := NewExportService(fake.Queries(), nil, nil, nil, 3)
+
+		transcript, err := es.DirectTranscript(ctx, "alice", "bob", Range{})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"message 7", "message 8", "message 9"}, contents(transcript))
+		assert.True(t, transcript.Partial)
+	})
+
+	t.Run("Database failure", func(t *testing.T) {
+		fake := fakedb.New(t)
+		fake.Fail("GetMessagesBetweenUsers", errors.New("connection reset"))
+		es := NewExportService(fake.Queries(), nil, nil, nil, 10)
+
+		_, err := es.DirectTranscript(ctx, "alice", "bob", Range{})
+		assert.Error(t, err)
+	})
+}
+
+// groupHistory serves a fixed group history
+type groupHistory struct {
+	chat.Service
+	messages []*chat.ChatMessage
+}
+
+func (g *groupHistory) GetGroupHistory(ctx context.Context, groupID string) ([]*chat.ChatMessage, error) {
+	return g.messages, nil
+}
+
+func TestGroupTranscript(t *testing.T) {
+	fake := fakedb.New(t)
+	groupID, aliceID := uuid.New(), uuid.New()
+	now := time.Now()
+	fake.Return("GetUserByUsername", fakedb.Row(aliceID, now, now, "alice", "user", "hash", nil, nil))
+	fake.On("IsGroupMember", func(args []any) (fakedb.Result, error) {
+		return fakedb.Row(args[1] == aliceID.String()), nil
+	})
+	fake.Return("GetGroupByID", fakedb.Row(groupID, "Hikers", nil, nil, nil, aliceID, now, now, groups.KindGroup))
+	fake.Return("GetGroupMembers", fakedb.Result{Rows: [][]any{
+		{aliceID, "alice", nil, nil, groups.RoleOwner, now},
+		{uuid.New(), "bob", nil, nil, groups.RoleMember, now},
+	}})
+
+	// The history may come out of order; the transcript sorts it
+	history := &groupHistory{messages: []*chat.ChatMessage{
+		{FromID: "bob", Content: "second", Timestamp: 200},
+		{FromID: "alice", Content: "first", Timestamp: 100},
+		{FromID: "bob", Content: "gone", Timestamp: 250, Deleted: true},
+		{FromID: "alice", Content: "third", Timestamp: 300},
+	}}
+	es := NewExportService(fake.Queries(), history, groups.NewGroupService(fake.Queries()), nil, 10)
+	ctx := context.Background()
+
+	transcript, err := es.GroupTranscript(ctx, "alice", groupID.String(), Range{})
+	require.NoError(t, err)
+	assert.Equal(t, "Hikers", transcript.Title)
+	assert.Equal(t, []string{"alice", "bob"}, transcript.Participants)
+	assert.Equal(t, []string{"first", "second", "third"}, contents(transcript))
+	assert.False(t, transcript.Partial)
+
+	es.SetLimits(Limits{MaxMessages: 2})
+	transcript, err = es.GroupTranscript(ctx, "alice", groupID.String(), Range{To: time.Unix(300, 0)})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"first", "second"}, contents(transcript))
+	assert.False(t, transcript.Partial, "the range, not the cap, left the rest out")
+
+	transcript, err = es.GroupTranscript(ctx, "alice", groupID.String(), Range{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"second", "third"}, contents(transcript))
+	assert.True(t, transcript.Partial)
+
+	fake.Return("GetUserByUsername", fakedb.Row(uuid.New(), now, now, "mallory", "user", "hash", nil, nil))
+	_, err = es.GroupTranscript(ctx, "mallory", groupID.String(), Range{})
+	var appErr *apperrors.AppError
+	require.True(t, errors.As(err, &appErr), "got %v", err)
+	assert.Equal(t, http.StatusForbidden, appErr.StatusCode, "members only")
+}
+
+// stubRenderer records the document it was asked to print
+type stubRenderer struct {
+	doc Document
+	err error
+}
+
+func (r *stubRenderer) RenderPDF(ctx context.Context, doc Document) ([]byte, error) {
+	r.doc = doc
+	return []byte("%PDF"), r.err
+}
+
+func TestRender(t *testing.T) {
+	transcript := &Transcript{
+		Title:        "Conversation with bob",
+		Participants: []string{"alice", "bob"},
+		Entries:      []Entry{{From: "bob", Content: "<b>hi</b>", SentAt: time.Now()}},
+		GeneratedAt:  time.Now(),
+	}
+	ctx := context.Background()
+
+	renderer := &stubRenderer{}
+	es := NewExportService(nil, nil, nil, renderer, 10)
+	assert.True(t, es.PDFEnabled())
+
+	body, contentType, err := es.Render(ctx, transcript, FormatHTML)
+	require.NoError(t, err)
+	assert.Equal(t, "text/html; charset=utf-8", contentType)
+	assert.Contains(t, string(body), "&lt;b&gt;hi&lt;/b&gt;", "content is escaped")
+
+	pdf, contentType, err := es.Render(ctx, transcript, FormatPDF)
+	require.NoError(t, err)
+	assert.Equal(t, "application/pdf", contentType)
+	assert.Equal(t, "%PDF", string(pdf))
+	assert.Equal(t, body, renderer.doc.Body, "the PDF prints the HTML export")
+	assert.Contains(t, string(renderer.doc.Header), "Conversation with bob")
+
+	renderer.err = errors.New("renderer down")
+	_, _, err = es.Render(ctx, transcript, FormatPDF)
+	assert.Error(t, err)
+
+	_, _, err = es.Render(ctx, transcript, "docx")
+	var appErr *apperrors.AppError
+	require.True(t, errors.As(err, &appErr))
+	assert.Equal(t, http.StatusBadRequest, appErr.StatusCode)
+
+	es = NewExportService(nil, nil, nil, nil, 10)
+	assert.False(t, es.PDFEnabled())
+	_, _, err = es.Render(ctx, transcript, FormatPDF)
+	require.True(t, errors.As(err, &appErr))
+	assert.Equal(t, http.StatusNotImplemented, appErr.StatusCode)
+}
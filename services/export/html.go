@@ -0,0 +1,182 @@
+package export
+
+import (
+	"bytes"
+	"exc6/services/chat"
+	"html/template"
+	"strings"
+	"time"
+)
+
+var funcs = template.FuncMap{
+	"isGIF": func(subtype string) bool { return subtype == chat.SubtypeGIF },
+	"join":  strings.Join,
+	"date": func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return t.UTC().Format("2 Jan 2006")
+	},
+	"time": func(t time.Time) string { return t.UTC().Format("2 Jan 2006 15:04") },
+	"day":  func(t time.Time) string { return t.UTC().Format("Monday, 2 January 2006") },
+	// newDay reports whether entry i starts a new calendar day
+	"newDay": func(entries []Entry, i int) bool {
+		const layout = "2006-01-02"
+		return i == 0 || entries[i-1].SentAt.UTC().Format(layout) != entries[i].SentAt.UTC().Format(layout)
+	},
+}
+
+// headerTemplate is printed on every PDF page
+var headerTemplate = template.Must(template.New("header").Funcs(funcs).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<style>
+  body { font-family: Helvetica, Arial, sans-serif; font-size: 8pt; color: #555; margin: 0 0.6in; width: 100%; }
+  .row { display: flex; justify-content: space-between; border-bottom: 1px solid #ccc; padding-bottom: 4px; }
+</style>
+</head>
+<body>
+  <div class="row">
+    <span>{{.Title}} &middot; {{join .Participants ", "}}</span>
+    <span>{{template "range" .}} &middot; page <span class="pageNumber"></span> of <span class="totalPages"></span></span>
+  </div>
+</body>
+</html>
+{{define "range"}}{{if .Entries}}{{date .First}} &ndash; {{date .Last}}{{else}}No messages{{end}}{{end}}`))
+
+// transcriptTemplate is the printable transcript, also served as the HTML export
+var transcriptTemplate = template.Must(template.Must(headerTemplate.Clone()).New("transcript").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+  body { font-family: Helvetica, Arial, sans-serif; font-size: 10pt; color: #111; margin: 0; }
+  h1 { font-size: 16pt; margin: 0 0 4px; }
+  .meta { color: #555; margin-bottom: 16px; }
+  .meta div { margin: 2px 0; }
+  .note { color: #8a6d3b; font-style: italic; }
+  .day { font-weight: bold; color: #555; border-bottom: 1px solid #ddd; margin: 16px 0 6px; padding-bottom: 2px; }
+  .msg { margin: 4px 0; page-break-inside: avoid; }
+  .msg .who { font-weight: bold; }
+  .msg .when { color: #888; font-size: 8pt; margin-left: 6px; }
+  .msg .text { white-space: pre-wrap; word-break: break-word; margin-top: 1px; }
+  .thumb { display: block; max-width: 160px; max-height: 120px; margin-top: 4px; border-radius: 4px; }
+</style>
+</head>
+<body>
+  <h1>{{.Title}}</h1>
+  <div class="meta">
+    <div>Participants: {{join .Participants ", "}}</div>
+    <div>Messages: {{len .Entries}}{{if .Entries}} ({{template "range" .}}){{end}}</div>
+    <div>Exported: {{time .GeneratedAt}} UTC</div>
+    {{if .Partial}}<div class="note">Older messages are not included in this export.</div>{{end}}
+  </div>
+  {{$entries := .Entries}}
+  {{range $i, $e := .Entries}}
+    {{if newDay $entries $i}}<div class="day">{{day $e.SentAt}}</div>{{end}}
+    <div class="msg">
+      <span class="who">{{$e.From}}</span><span class="when">{{time $e.SentAt}}</span>
+      {{if isGIF $e.Subtype}}
+        <img class="thumb" src="{{$e.Content}}" alt="GIF">
+      {{else}}
+        <div class="text">{{$e.Content}}</div>
+      {{end}}
+    </div>
+  {{end}}
+</body>
+</html>`))
+
+// sharePageTemplate is the public page behind a share link: the passcode
+// form, the shared messages, or a notice that the link is not available
+var sharePageTemplate = template.Must(template.Must(headerTemplate.Clone()).New("share").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="robots" content="noindex, nofollow">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Shared conversation</title>
+<style>
+  body { font-family: Helvetica, Arial, sans-serif; font-size: 11pt; color: #111; max-width: 720px; margin: 24px auto; padding: 0 16px; }
+  h1 { font-size: 16pt; margin: 0 0 4px; }
+  .meta { color: #555; margin-bottom: 16px; }
+  .note { color: #8a6d3b; font-style: italic; }
+  .error { color: #a94442; }
+  .day { font-weight: bold; color: #555; border-bottom: 1px solid #ddd; margin: 16px 0 6px; padding-bottom: 2px; }
+  .msg { margin: 6px 0; }
+  .msg .who { font-weight: bold; }
+  .msg .when { color: #888; font-size: 9pt; margin-left: 6px; }
+  .msg .text { white-space: pre-wrap; word-break: break-word; margin-top: 1px; }
+  .thumb { display: block; max-width: 160px; max-height: 120px; margin-top: 4px; border-radius: 4px; }
+  form input { padding: 6px; font-size: 11pt; }
+</style>
+</head>
+<body>
+{{if .Unavailable}}
+  <h1>Link not available</h1>
+  <p class="meta">This link does not exist, has expired or was revoked by its owner.</p>
+{{else if .Transcript}}
+  {{with .Transcript}}
+  <h1>{{.Title}}</h1>
+  <div class="meta">
+    <div>Participants: {{join .Participants ", "}}</div>
+    <div>Messages: {{len .Entries}}{{if .Entries}} ({{template "range" .}}){{end}}</div>
+    {{if .Partial}}<div class="note">Older messages are not included.</div>{{end}}
+  </div>
+  {{$entries := .Entries}}
+  {{range $i, $e := .Entries}}
+    {{if newDay $entries $i}}<div class="day">{{day $e.SentAt}}</div>{{end}}
+    <div class="msg">
+      <span class="who">{{$e.From}}</span><span class="when">{{time $e.SentAt}}</span>
+      {{if isGIF $e.Subtype}}
+        <img class="thumb" src="{{$e.Content}}" alt="GIF">
+      {{else}}
+        <div class="text">{{$e.Content}}</div>
+      {{end}}
+    </div>
+  {{end}}
+  {{end}}
+{{else}}
+  <h1>Shared conversation</h1>
+  <p class="meta">This conversation is protected by a passcode.</p>
+  {{if .WrongPasscode}}<p class="error">That passcode is not correct.</p>{{end}}
+  <form method="post">
+    <input type="password" name="passcode" placeholder="Passcode" aria-label="Passcode" required autofocus>
+    <button type="submit">View</button>
+  </form>
+{{end}}
+</body>
+</html>`))
+
+// SharePage is what the share page shows: Unavailable, else the transcript
+// when set, else the passcode form
+type SharePage struct {
+	Unavailable   bool
+	Transcript    *Transcript
+	WrongPasscode bool
+}
+
+// RenderSharePage renders the public page of a share link
+func RenderSharePage(page SharePage) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := sharePageTemplate.Execute(&buf, page); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func renderHTML(transcript *Transcript) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := transcriptTemplate.Execute(&buf, transcript); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func renderHeader(transcript *Transcript) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := headerTemplate.Execute(&buf, transcript); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,100 @@
+package export
+
+import (
+	"bytes"
+	"context"
+	"exc6/pkg/httpclient"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strings"
+)
+
+// maxPDFSize bounds the rendered document read back from the renderer
+const maxPDFSize = 50 << 20
+
+// Document is a self-contained HTML page to print. Header is printed at the
+// top of every page.
+type Document struct {
+	Body   []byte
+	Header []byte
+}
+
+// Renderer converts HTML to PDF
+type Renderer interface {
+	RenderPDF(ctx context.Context, doc Document) ([]byte, error)
+}
+
+// ChromiumRenderer renders through a Gotenberg-compatible HTML-to-PDF service
+// (POST /forms/chromium/convert/html with index.html and header.html parts)
+type ChromiumRenderer struct {
+	client  *httpclient.Client
+	baseURL string
+}
+
+// NewChromiumRenderer creates a renderer for the service at baseURL
+func NewChromiumRenderer(client *httpclient.Client, baseURL string) *ChromiumRenderer {
+	return &ChromiumRenderer{
+		client:  client,
+		baseURL: strings.TrimRight(baseURL, "/"),
+	}
+}
+
+// RenderPDF implements Renderer
+func (r *ChromiumRenderer) RenderPDF(ctx context.Context, doc Document) ([]byte, error) {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+
+	files := []struct {
+		name    string
+		content []byte
+	}{
+		{"index.html", doc.Body},
+		{"header.html", doc.Header},
+	}
+	for _, file := range files {
+		if file.content == nil {
+			continue
+		}
+		part, err := form.CreateFormFile("files", file.name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := part.Write(file.content); err != nil {
+			return nil, err
+		}
+	}
+
+	fields := map[string]string{
+		"printBackground": "true",
+		"marginTop":       "0.9", // inches, leaves room for the header
+		"marginBottom":    "0.6",
+	}
+	for name, value := range fields {
+		if err := form.WriteField(name, value); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := form.Close(); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.baseURL+"/forms/chromium/convert/html", &body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if err := httpclient.CheckStatus(resp); err != nil {
+		return nil, err
+	}
+
+	return io.ReadAll(io.LimitReader(resp.Body, maxPDFSize))
+}
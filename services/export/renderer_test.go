@@ -0,0 +1,69 @@
+package export
+
+import (
+	"context"
+	"errors"
+	"exc6/apperrors"
+	"exc6/pkg/httpclient"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChromiumRenderer(t *testing.T) {
+	var files map[string]string
+	var fields map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "/forms/chromium/convert/html", r.URL.Path)
+		require.NoError(t, r.ParseMultipartForm(1<<20))
+
+		files = make(map[string]string)
+		for _, header := range r.MultipartForm.File["files"] {
+			f, err := header.Open()
+			require.NoError(t, err)
+			content, err := io.ReadAll(f)
+			require.NoError(t, err)
+			files[header.Filename] = string(content)
+		}
+		fields = make(map[string]string)
+		for name, values := range r.MultipartForm.Value {
+			fields[name] = values[0]
+		}
+
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Write([]byte("%PDF-1.7"))
+	}))
+	t.Cleanup(server.Close)
+
+	r := NewChromiumRenderer(httpclient.New(), server.URL+"/")
+
+	pdf, err := r.RenderPDF(context.Background(), Document{Body: []byte("<p>body</p>"), Header: []byte("<p>header</p>")})
+	require.NoError(t, err)
+	assert.Equal(t, "%PDF-1.7", string(pdf))
+	assert.Equal(t, map[string]string{"index.html": "<p>body</p>", "header.html": "<p>header</p>"}, files)
+	assert.Equal(t, "true", fields["printBackground"])
+	assert.NotEmpty(t, fields["marginTop"])
+
+	_, err = r.RenderPDF(context.Background(), Document{Body: []byte("<p>body</p>")})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"index.html": "<p>body</p>"}, files, "no header part without a header")
+}
+
+func TestChromiumRendererFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "chromium crashed", http.StatusInternalServerError)
+	}))
+	t.Cleanup(server.Close)
+
+	r := NewChromiumRenderer(httpclient.New(), server.URL)
+	_, err := r.RenderPDF(context.Background(), Document{Body: []byte("<p>body</p>")})
+
+	var appErr *apperrors.AppError
+	require.True(t, errors.As(err, &appErr), "got %v", err)
+	assert.Equal(t, http.StatusBadGateway, appErr.StatusCode)
+}
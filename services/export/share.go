@@ -0,0 +1,326 @@
+package export
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"exc6/apperrors"
+	"exc6/db"
+	"exc6/pkg/breaker"
+	"exc6/pkg/logger"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// A user can share a range of a direct conversation through a read-only
+// link, e.g. with a support agent. The link shows the owner's messages under
+// their name and everyone else anonymized, may require a passcode and stops
+// working when it expires or is revoked. Only a hash of the token is stored.
+
+const (
+	// ShareMaxExpiry is the longest a share link stays valid unless the
+	// limits say otherwise
+	ShareMaxExpiry = 90 * 24 * time.Hour
+
+	// SharePasscodeMinLength is the shortest passcode accepted
+	SharePasscodeMinLength = 4
+
+	// SharePasscodeMaxAttempts is how many wrong passcodes a link takes
+	// before it is revoked
+	SharePasscodeMaxAttempts = 10
+
+	shareTokenBytes = 24
+)
+
+var (
+	// ErrShareUnavailable is returned for unknown, expired and revoked links alike
+	ErrShareUnavailable = apperrors.New(apperrors.ErrCodeNotFound, "This link is not available", http.StatusNotFound)
+
+	// ErrSharePasscode is returned when the link needs a passcode and the
+	// given one is missing or wrong
+	ErrSharePasscode = apperrors.New(apperrors.ErrCodeUnauthorized, "Passcode required", http.StatusUnauthorized)
+)
+
+// ShareRequest describes a link to create
+type ShareRequest struct {
+	Contact  string
+	Range    Range
+	Passcode string        // Optional
+	Expiry   time.Duration // Zero for the longest expiry allowed
+}
+
+// ShareLink is a created link as listed to its owner
+type ShareLink struct {
+	ID        string     `json:"id"`
+	From      *time.Time `json:"from,omitempty"`
+	To        time.Time  `json:"to"`
+	Protected bool       `json:"protected"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+
+	// Token is only set when the link is created
+	Token string `json:"token,omitempty"`
+}
+
+// CreateShareLink creates a link to username's conversation with
+// req.Contact. An open-ended range ends now, so later messages are never
+// shared.
+func (es *ExportService) CreateShareLink(ctx context.Context, username string, req ShareRequest) (*ShareLink, error) {
+	limits := es.currentLimits()
+	if !limits.ShareLinks {
+		return nil, apperrors.New(apperrors.ErrCodeUnauthorized, "Share links are disabled by the organization's policy", http.StatusForbidden)
+	}
+	if req.Contact == "" || req.Contact == username {
+		return nil, apperrors.NewValidationError("A contact is required")
+	}
+	if req.Expiry < 0 || req.Expiry > limits.ShareMaxExpiry {
+		return nil, apperrors.NewValidationError(fmt.Sprintf("Expiry must be at most %s", limits.ShareMaxExpiry))
+	}
+	if req.Expiry == 0 {
+		req.Expiry = limits.ShareMaxExpiry
+	}
+	if req.Passcode != "" && len(req.Passcode) < SharePasscodeMinLength {
+		return nil, apperrors.NewValidationError(fmt.Sprintf("Passcode must be at least %d characters", SharePasscodeMinLength))
+	}
+
+	now := time.Now()
+	rng := req.Range
+	if rng.To.IsZero() || rng.To.After(now) {
+		rng.To = now
+	}
+	if !rng.From.IsZero() && !rng.From.Before(rng.To) {
+		return nil, apperrors.NewValidationError("The range must start in the past")
+	}
+
+	token, tokenHash, err := newShareToken()
+	if err != nil {
+		return nil, apperrors.NewInternalError("Failed to create share link").WithInternal(err)
+	}
+
+	params := db.CreateShareLinkParams{
+		TokenHash: tokenHash,
+		Owner:     username,
+		Contact:   req.Contact,
+		RangeFrom: sql.NullTime{Time: rng.From, Valid: !rng.From.IsZero()},
+		RangeTo:   rng.To,
+		ExpiresAt: sql.NullTime{Time: now.Add(req.Expiry), Valid: true},
+	}
+	if req.Passcode != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(req.Passcode), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, apperrors.NewValidationError("Invalid passcode")
+		}
+		params.PasscodeHash = sql.NullString{String: string(hash), Valid: true}
+	}
+
+	result, err := breaker.ExecuteCtx(ctx, es.cb, func() (interface{}, error) {
+		row, err := es.qdb.CreateShareLink(ctx, params)
+		if err != nil {
+			return nil, err
+		}
+		return row, nil
+	})
+	if err != nil {
+		return nil, apperrors.NewDatabaseError("create share link", err)
+	}
+	row, ok := result.(db.CreateShareLinkRow)
+	if !ok {
+		// sql.ErrNoRows, which the breaker lets through as no result: the
+		// contact does not exist
+		return nil, apperrors.NewUserNotFound()
+	}
+
+	link := &ShareLink{
+		ID:        row.ID.String(),
+		From:      nullTime(params.RangeFrom),
+		To:        rng.To,
+		Protected: params.PasscodeHash.Valid,
+		ExpiresAt: nullTime(params.ExpiresAt),
+		CreatedAt: row.CreatedAt,
+		Token:     token,
+	}
+
+	logger.WithFields(map[string]interface{}{
+		"username":  username,
+		"contact":   req.Contact,
+		"link_id":   link.ID,
+		"protected": link.Protected,
+	}).Info("Share link created")
+
+	return link, nil
+}
+
+// ShareLinks lists username's live links to their conversation with contact
+func (es *ExportService) ShareLinks(ctx context.Context, username, contact string) ([]ShareLink, error) {
+	result, err := breaker.ExecuteCtx(ctx, es.cb, func() (interface{}, error) {
+		return es.qdb.ListShareLinks(ctx, db.ListShareLinksParams{Owner: username, Contact: contact})
+	})
+	if err != nil {
+		return nil, apperrors.NewDatabaseError("list share links", err)
+	}
+
+	rows, _ := result.([]db.ListShareLinksRow)
+	links := make([]ShareLink, 0, len(rows))
+	for _, row := range rows {
+		links = append(links, ShareLink{
+			ID:        row.ID.String(),
+			From:      nullTime(row.RangeFrom),
+			To:        row.RangeTo,
+			Protected: row.Protected,
+			ExpiresAt: nullTime(row.ExpiresAt),
+			CreatedAt: row.CreatedAt,
+		})
+	}
+	return links, nil
+}
+
+// RevokeShareLink disables one of username's links
+func (es *ExportService) RevokeShareLink(ctx context.Context, username, id string) error {
+	linkID, err := uuid.Parse(id)
+	if err != nil {
+		return apperrors.NewValidationError("Invalid link ID")
+	}
+
+	result, err := breaker.ExecuteCtx(ctx, es.cb, func() (interface{}, error) {
+		return es.qdb.RevokeShareLink(ctx, db.RevokeShareLinkParams{ID: linkID, Owner: username})
+	})
+	if err != nil {
+		return apperrors.NewDatabaseError("revoke share link", err)
+	}
+	if n, _ := result.(int64); n == 0 {
+		return apperrors.New(apperrors.ErrCodeNotFound, "Share link not found", http.StatusNotFound)
+	}
+
+	logger.WithFields(map[string]interface{}{
+		"username": username,
+		"link_id":  id,
+	}).Info("Share link revoked")
+
+	return nil
+}
+
+// OpenShareLink returns the anonymized transcript behind token.
+// ErrSharePasscode means the link is protected and passcode did not match.
+func (es *ExportService) OpenShareLink(ctx context.Context, token, passcode string) (*Transcript, error) {
+	result, err := breaker.ExecuteCtx(ctx, es.cb, func() (interface{}, error) {
+		row, err := es.qdb.GetShareLinkByToken(ctx, hashShareToken(token))
+		if err != nil {
+			return nil, err
+		}
+		return row, nil
+	})
+	if err != nil {
+		return nil, apperrors.NewDatabaseError("open share link", err)
+	}
+	// No result for an unknown token
+	link, ok := result.(db.GetShareLinkByTokenRow)
+	if !ok || link.RevokedAt.Valid || (link.ExpiresAt.Valid && !time.Now().Before(link.ExpiresAt.Time)) {
+		return nil, ErrShareUnavailable
+	}
+
+	if link.PasscodeHash.Valid {
+		if passcode == "" {
+			return nil, ErrSharePasscode
+		}
+		if bcrypt.CompareHashAndPassword([]byte(link.PasscodeHash.String), []byte(passcode)) != nil {
+			return nil, es.recordPasscodeFailure(ctx, link.ID)
+		}
+	}
+
+	rng := Range{To: link.RangeTo}
+	if link.RangeFrom.Valid {
+		rng.From = link.RangeFrom.Time
+	}
+
+	transcript, err := es.DirectTranscript(ctx, link.Owner, link.Contact, rng)
+	if err != nil {
+		return nil, err
+	}
+
+	return anonymize(transcript, link.Owner), nil
+}
+
+// recordPasscodeFailure counts a wrong passcode for a link, which is revoked
+// at SharePasscodeMaxAttempts. It returns the error to answer the attempt
+// with.
+func (es *ExportService) recordPasscodeFailure(ctx context.Context, linkID uuid.UUID) error {
+	result, err := breaker.ExecuteCtx(ctx, es.cb, func() (interface{}, error) {
+		return es.qdb.RecordShareLinkFailure(ctx, db.RecordShareLinkFailureParams{
+			MaxAttempts: SharePasscodeMaxAttempts,
+			ID:          linkID,
+		})
+	})
+	if err != nil {
+		// Refuse the attempt either way; guesses are also limited per address
+		logger.WithFields(map[string]interface{}{
+			"link_id": linkID,
+			"error":   err.Error(),
+		}).Warn("Failed to record wrong share link passcode")
+		return ErrSharePasscode
+	}
+
+	if attempts, _ := result.(int32); attempts >= SharePasscodeMaxAttempts {
+		logger.WithFields(map[string]interface{}{
+			"link_id":  linkID,
+			"attempts": attempts,
+		}).Warn("Share link revoked after too many wrong passcodes")
+		return ErrShareUnavailable
+	}
+	return ErrSharePasscode
+}
+
+// anonymize replaces every participant but owner with a numbered placeholder
+func anonymize(t *Transcript, owner string) *Transcript {
+	aliases := map[string]string{owner: owner}
+	alias := func(name string) string {
+		if a, ok := aliases[name]; ok {
+			return a
+		}
+		a := fmt.Sprintf("Participant %d", len(aliases))
+		aliases[name] = a
+		return a
+	}
+
+	out := *t
+	out.Title = "Shared conversation"
+	out.Participants = make([]string, 0, len(t.Participants))
+	for _, p := range t.Participants {
+		out.Participants = append(out.Participants, alias(p))
+	}
+	out.Entries = make([]Entry, len(t.Entries))
+	for i, e := range t.Entries {
+		e.From = alias(e.From)
+		out.Entries[i] = e
+	}
+	return &out
+}
+
+// newShareToken returns a random URL-safe token and the hash stored for it
+func newShareToken() (string, string, error) {
+	b := make([]byte, shareTokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	return token, hashShareToken(token), nil
+}
+
+func hashShareToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func nullTime(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	v := t.Time
+	return &v
+}
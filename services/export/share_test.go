@@ -0,0 +1,131 @@
+package export
+
+import (
+	"context"
+	"errors"
+	"exc6/apperrors"
+	"exc6/tests/fakedb"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestAnonymize(t *testing.T) {
+	sent := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	transcript := &Transcript{
+		Title:        "Conversation with bob",
+		Participants: []string{"alice", "bob"},
+		Entries: []Entry{
+			{From: "bob", Content: "hi", SentAt: sent},
+			{From: "alice", Content: "hello", SentAt: sent},
+			{From: "bob", Content: "bye", SentAt: sent},
+		},
+	}
+
+	got := anonymize(transcript, "alice")
+
+	assert.Equal(t, "Shared conversation", got.Title)
+	assert.Equal(t, []string{"alice", "Participant 1"}, got.Participants)
+	assert.Equal(t, []string{"Participant 1", "alice", "Participant 1"},
+		[]string{got.Entries[0].From, got.Entries[1].From, got.Entries[2].From})
+	assert.Equal(t, "bob", transcript.Entries[0].From, "the original transcript is left alone")
+}
+
+func TestRenderSharePage(t *testing.T) {
+	tests := []struct {
+		name    string
+		page    SharePage
+		want    string
+		notWant string
+	}{
+		{name: "Unavailable", page: SharePage{Unavailable: true}, want: "Link not available", notWant: "passcode"},
+		{name: "Passcode form", page: SharePage{}, want: `name="passcode"`, notWant: "not correct"},
+		{name: "Wrong passcode", page: SharePage{WrongPasscode: true}, want: "not correct"},
+		{
+			name: "Transcript",
+			page: SharePage{Transcript: &Transcript{
+				Title:        "Shared conversation",
+				Participants: []string{"alice", "Participant 1"},
+				Entries:      []Entry{{From: "Participant 1", Content: "<b>hi</b>", SentAt: time.Now()}},
+			}},
+			want:    "&lt;b&gt;hi&lt;/b&gt;",
+			notWant: `name="passcode"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, err := RenderSharePage(tt.page)
+			require.NoError(t, err)
+			assert.Contains(t, string(body), tt.want)
+			if tt.notWant != "" {
+				assert.NotContains(t, string(body), tt.notWant)
+			}
+		})
+	}
+}
+
+func TestHashShareToken(t *testing.T) {
+	token, hash, err := newShareToken()
+	require.NoError(t, err)
+
+	assert.Len(t, token, 32)
+	assert.Equal(t, hash, hashShareToken(token))
+	assert.NotEqual(t, token, hash)
+}
+
+func TestCreateShareLinkUnknownContact(t *testing.T) {
+	fake := fakedb.New(t)
+	es := NewExportService(fake.Queries(), nil, nil, nil, 100)
+
+	// The insert selects the contact, so there is no row without one
+	_, err := es.CreateShareLink(context.Background(), "alice", ShareRequest{Contact: "nobody"})
+	var appErr *apperrors.AppError
+	require.True(t, errors.As(err, &appErr))
+	assert.Equal(t, http.StatusNotFound, appErr.StatusCode)
+}
+
+func TestOpenShareLink(t *testing.T) {
+	fake := fakedb.New(t)
+	es := NewExportService(fake.Queries(), nil, nil, nil, 100)
+	ctx := context.Background()
+
+	_, err := es.OpenShareLink(ctx, "unknown-token", "")
+	assert.Equal(t, ErrShareUnavailable, err, "unknown token")
+
+	linkID := uuid.New()
+	hash, err := bcrypt.GenerateFromPassword([]byte("1234"), bcrypt.MinCost)
+	require.NoError(t, err)
+	now := time.Now()
+	fake.Return("GetShareLinkByToken", fakedb.Row(
+		linkID, "alice", "bob", nil, now, string(hash), now.Add(time.Hour), nil, now,
+	))
+
+	attempts := int32(0)
+	fake.On("RecordShareLinkFailure", func(args []any) (fakedb.Result, error) {
+		attempts++
+		return fakedb.Row(attempts), nil
+	})
+
+	_, err = es.OpenShareLink(ctx, "token", "")
+	assert.Equal(t, ErrSharePasscode, err, "no passcode")
+	assert.Empty(t, fake.Calls("RecordShareLinkFailure"), "asking for the passcode is not a failure")
+
+	for i := 1; i < SharePasscodeMaxAttempts; i++ {
+		_, err = es.OpenShareLink(ctx, "token", "0000")
+		require.Equal(t, ErrSharePasscode, err, "attempt %d", i)
+	}
+
+	// The last allowed failure revokes the link
+	_, err = es.OpenShareLink(ctx, "token", "0000")
+	assert.Equal(t, ErrShareUnavailable, err)
+
+	calls := fake.Calls("RecordShareLinkFailure")
+	require.Len(t, calls, SharePasscodeMaxAttempts)
+	assert.Equal(t, []any{int64(SharePasscodeMaxAttempts), linkID.String()}, calls[0])
+}
@@ -2,20 +2,44 @@ package friends
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"exc6/apperrors"
 	"exc6/db"
 	"exc6/pkg/breaker"
+	"exc6/pkg/cache"
 	"exc6/pkg/logger"
+	"exc6/pkg/pagination"
+	"exc6/services/activity"
+	"exc6/services/chat"
+	"sort"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/sony/gobreaker"
 )
 
+// friendsFallbackTTL is how long a friend list is kept to be served while
+// the database is unavailable
+const friendsFallbackTTL = time.Hour
+
+// ConnectedNotice opens the conversation between two users who became friends
+const ConnectedNotice = "You are now connected"
+
 // FriendService handles friend-related operations
 type FriendService struct {
 	qdb *db.Queries
 	cb  *gobreaker.CircuitBreaker
+
+	// lastGood holds each user's last friend list read from the database
+	lastGood *cache.Local[string, []FriendInfo]
+
+	// activity records friendship changes for contact list deltas; may be nil
+	activity *activity.Tracker
+
+	// sqldb and csrv open the conversation when a request is accepted; may be nil
+	sqldb *sql.DB
+	csrv  chat.Service
 }
 
 func NewFriendService(qdb *db.Queries) *FriendService {
@@ -29,21 +53,40 @@ func NewFriendService(qdb *db.Queries) *FriendService {
 			Threshold:   0.6, // Higher threshold for DB
 			MinRequests: 10,
 		}),
+		lastGood: cache.NewLocal[string, []FriendInfo](10000, friendsFallbackTTL),
 	}
 }
 
+// SetActivityTracker records friendship changes for contact list deltas
+func (fs *FriendService) SetActivityTracker(tracker *activity.Tracker) {
+	fs.activity = tracker
+}
+
+// SetConversations makes accepting a friend request open the conversation
+// with a system notice, stored in the same transaction as the acceptance
+func (fs *FriendService) SetConversations(sqldb *sql.DB, csrv chat.Service) {
+	fs.sqldb = sqldb
+	fs.csrv = csrv
+}
+
 // FriendInfo represents a friend with their user details
 type FriendInfo struct {
-	FriendID   string
-	Username   string
-	Icon       string
-	CustomIcon string
-	Accepted   bool
-	CreatedAt  time.Time
+	FriendID   string    `json:"friend_id"`
+	Username   string    `json:"username"`
+	Icon       string    `json:"icon,omitempty"`
+	CustomIcon string    `json:"custom_icon,omitempty"`
+	Accepted   bool      `json:"accepted"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// Cursor orders friends and search results by username
+func (f FriendInfo) Cursor() pagination.Cursor {
+	return pagination.Cursor{Key: f.Username, ID: f.FriendID}
 }
 
-// GetUserFriends returns all accepted friends for a user
-func (fs *FriendService) GetUserFriends(ctx context.Context, username string) ([]FriendInfo, error) {
+// GetUserFriends returns all accepted friends for a user. While the database is unavailable the last list read for the user is
+// served instead, marked degraded; without one the degraded list is empty.
+func (fs *FriendService) GetUserFriends(ctx context.Context, username string) (breaker.Result[[]FriendInfo], error) {
 	result, err := breaker.ExecuteCtx(ctx, fs.cb, func() (interface{}, error) {
 		// Get user
 		user, err := fs.qdb.GetUserByUsername(ctx, username)
@@ -77,10 +120,18 @@ func (fs *FriendService) GetUserFriends(ctx context.Context, username string) ([
 			"username": username,
 			"error":    err.Error(),
 		}).Error("Circuit breaker: Failed to get user friends")
-		return nil, apperrors.NewDatabaseError("get friends", err)
+
+		friends, _ := fs.lastGood.Get(username)
+		breaker.ObserveRead("friends", len(friends), true)
+		return breaker.Degraded(friends), nil
 	}
 
-	return result.([]FriendInfo), nil
+	// A missing user has no friends
+	friends, _ := result.([]FriendInfo)
+	fs.lastGood.Set(username, friends)
+
+	breaker.ObserveRead("friends", len(friends), false)
+	return breaker.Healthy(friends), nil
 }
 
 // GetFriendRequests returns pending friend requests for a user
@@ -177,8 +228,10 @@ func (fs *FriendService) SendFriendRequest(ctx context.Context, fromUsername, to
 	return nil
 }
 
-// AcceptFriendRequest accepts a pending friend request
+// AcceptFriendRequest accepts a pending friend request. With SetConversations
+// it also opens the conversation between the two users.
 func (fs *FriendService) AcceptFriendRequest(ctx context.Context, username, requesterUsername string) error {
+	var notice *chat.ChatMessage
 	_, err := breaker.ExecuteCtx(ctx, fs.cb, func() (interface{}, error) {
 		user, err := fs.qdb.GetUserByUsername(ctx, username)
 		if err != nil {
@@ -190,11 +243,21 @@ func (fs *FriendService) AcceptFriendRequest(ctx context.Context, username, requ
 			return nil, apperrors.NewBadRequest("Requester not found")
 		}
 
-		_, err = fs.qdb.AcceptFriend(ctx, db.AcceptFriendParams{
+		params := db.AcceptFriendParams{
 			UserID:   uuid.NullUUID{UUID: requester.ID, Valid: true},
 			FriendID: uuid.NullUUID{UUID: user.ID, Valid: true},
-		})
+		}
 
+		if fs.sqldb == nil || fs.csrv == nil {
+			_, err = fs.qdb.AcceptFriend(ctx, params)
+			return nil, err
+		}
+
+		msg := chat.NewSystemMessage(username, requesterUsername, ConnectedNotice)
+		accepted, err := fs.acceptWithNotice(ctx, params, msg, user.ID, requester.ID)
+		if accepted {
+			notice = msg
+		}
 		return nil, err
 	})
 
@@ -207,9 +270,56 @@ func (fs *FriendService) AcceptFriendRequest(ctx context.Context, username, requ
 		return apperrors.NewDatabaseError("accept friend request", err)
 	}
 
+	fs.activity.TouchList(ctx, username, requesterUsername)
+
+	if notice != nil {
+		// The notice is stored; caching and publishing it only affect
+		// what the users see until they reload the conversation
+		if err := fs.csrv.DeliverPersisted(ctx, notice); err != nil {
+			logger.WithFields(map[string]interface{}{
+				"username":  username,
+				"requester": requesterUsername,
+				"error":     err.Error(),
+			}).Warn("Failed to deliver friend connection notice")
+		}
+	}
+
 	return nil
 }
 
+// acceptWithNotice accepts a pending request and stores the notice opening
+// the conversation in one transaction. It reports false, storing nothing,
+// when there was no pending request.
+func (fs *FriendService) acceptWithNotice(ctx context.Context, params db.AcceptFriendParams, notice *chat.ChatMessage, fromID, toID uuid.UUID) (bool, error) {
+	tx, err := fs.sqldb.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	q := fs.qdb.WithTx(tx)
+
+	if _, err := q.AcceptFriend(ctx, params); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	if _, err := q.CreateMessage(ctx, db.CreateMessageParams{
+		MessageID:  notice.MessageID,
+		FromUserID: fromID,
+		ToUserID:   uuid.NullUUID{UUID: toID, Valid: true},
+		Content:    notice.Content,
+		IsGroup:    sql.NullBool{Bool: false, Valid: true},
+		Subtype:    notice.Subtype,
+	}); err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
 // RemoveFriend removes a friendship
 func (fs *FriendService) RemoveFriend(ctx context.Context, username, friendUsername string) error {
 	_, err := breaker.ExecuteCtx(ctx, fs.cb, func() (interface{}, error) {
@@ -250,25 +360,33 @@ func (fs *FriendService) RemoveFriend(ctx context.Context, username, friendUsern
 		return err
 	}
 
+	fs.activity.TouchList(ctx, username, friendUsername)
+
 	return nil
 }
 
-// SearchUsers searches for users by username
-func (fs *FriendService) SearchUsers(ctx context.Context, currentUsername, query string) ([]FriendInfo, error) {
+// SearchUsers returns a page of users whose username starts with query,
+// excluding the current user and their friends, ordered by username. Only
+// users listed in the directory match a prefix; others must be searched for
+// by their exact username, so search cannot enumerate them.
+func (fs *FriendService) SearchUsers(ctx context.Context, currentUsername, query string, page pagination.Params) (pagination.Page[FriendInfo], error) {
 	if query == "" {
-		return []FriendInfo{}, nil
+		return pagination.New([]FriendInfo{}, page, FriendInfo.Cursor), nil
 	}
 
+	limit := pagination.ClampLimit(page.Limit)
+
 	result, err := breaker.ExecuteCtx(ctx, fs.cb, func() (interface{}, error) {
 		currentUser, err := fs.qdb.GetUserByUsername(ctx, currentUsername)
 		if err != nil {
 			return nil, err
 		}
 
-		allUsernames, err := fs.qdb.GetAllUsernames(ctx)
+		allUsernames, err := fs.qdb.GetDiscoverableUsernames(ctx, query)
 		if err != nil {
 			return nil, err
 		}
+		sort.Strings(allUsernames)
 
 		// Get current friends to exclude them
 		friendships, _ := fs.qdb.GetFriends(ctx, uuid.NullUUID{UUID: currentUser.ID, Valid: true})
@@ -289,6 +407,11 @@ func (fs *FriendService) SearchUsers(ctx context.Context, currentUsername, query
 				continue
 			}
 
+			// Usernames are unique, so the key alone positions the cursor
+			if page.After != nil && username <= page.After.Key {
+				continue
+			}
+
 			// Simple prefix search
 			if len(username) >= len(query) && username[:len(query)] == query {
 				user, err := fs.qdb.GetUserByUsername(ctx, username)
@@ -308,7 +431,8 @@ func (fs *FriendService) SearchUsers(ctx context.Context, currentUsername, query
 					CustomIcon: user.CustomIcon.String,
 				})
 
-				if len(results) >= 10 {
+				// One extra result tells whether another page exists
+				if len(results) > limit {
 					break
 				}
 			}
@@ -323,10 +447,10 @@ func (fs *FriendService) SearchUsers(ctx context.Context, currentUsername, query
 			"query":    query,
 			"error":    err.Error(),
 		}).Error("Circuit breaker: Failed to search users")
-		return nil, apperrors.NewDatabaseError("search users", err)
+		return pagination.Page[FriendInfo]{}, apperrors.NewDatabaseError("search users", err)
 	}
 
-	return result.([]FriendInfo), nil
+	return pagination.New(result.([]FriendInfo), page, FriendInfo.Cursor), nil
 }
 
 // GetMetrics returns circuit breaker metrics
@@ -0,0 +1,143 @@
+package friends
+
+import (
+	"context"
+	"errors"
+	"exc6/services/chat"
+	"exc6/tests/fakedb"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// deliveringChat records the notices handed over for delivery
+type deliveringChat struct {
+	chat.Service
+
+	mu        sync.Mutex
+	delivered []*chat.ChatMessage
+}
+
+func (c *deliveringChat) DeliverPersisted(_ context.Context, msg *chat.ChatMessage) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.delivered = append(c.delivered, msg)
+	return nil
+}
+
+// newService returns a service over a fake database knowing alice and bob,
+// where bob has a pending request to alice
+func newService(t *testing.T) (*FriendService, *fakedb.Fake, map[string]uuid.UUID) {
+	fake := fakedb.New(t)
+	ids := map[string]uuid.UUID{"alice": uuid.New(), "bob": uuid.New()}
+	now := time.Now()
+
+	fake.On("GetUserByUsername", func(args []any) (fakedb.Result, error) {
+		id, ok := ids[args[0].(string)]
+		if !ok {
+			return fakedb.Result{}, nil
+		}
+		return fakedb.Row(id, now, now, args[0], "user", "hash", nil, nil), nil
+	})
+
+	var mu sync.Mutex
+	pending := true
+	fake.On("AcceptFriend", func(args []any) (fakedb.Result, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if !pending {
+			return fakedb.Result{}, nil
+		}
+		pending = false
+		return fakedb.Row(uuid.New(), args[0], args[1], now, true), nil
+	})
+	fake.On("CreateMessage", func(args []any) (fakedb.Result, error) {
+		return fakedb.Row(uuid.New(), args[0], args[1], args[2], args[5], args[3], args[4], now, args[6], nil, nil), nil
+	})
+
+	return NewFriendService(fake.Queries()), fake, ids
+}
+
+func TestAcceptFriendRequest(t *testing.T) {
+	fs, fake, ids := newService(t)
+	csrv := &deliveringChat{}
+	fs.SetConversations(fake.DB(), csrv)
+	ctx := context.Background()
+
+	require.NoError(t, fs.AcceptFriendRequest(ctx, "alice", "bob"))
+
+	accepted := fake.Calls("AcceptFriend")
+	require.Len(t, accepted, 1)
+	assert.Equal(t, ids["bob"].String(), accepted[0][0], "bob sent the request")
+	assert.Equal(t, ids["alice"].String(), accepted[0][1])
+
+	created := fake.Calls("CreateMessage")
+	require.Len(t, created, 1)
+	assert.Equal(t, ids["alice"].String(), created[0][1])
+	assert.Equal(t, ids["bob"].String(), created[0][2])
+	assert.Equal(t, ConnectedNotice, created[0][3])
+	assert.Equal(t, chat.SubtypeSystem, created[0][6])
+	assert.Equal(t, 1, fake.Commits())
+
+	require.Len(t, csrv.delivered, 1)
+	notice := csrv.delivered[0]
+	assert.Equal(t, created[0][0], notice.MessageID, "the stored notice is delivered")
+	assert.Equal(t, "alice", notice.FromID)
+	assert.Equal(t, "bob", notice.ToID)
+
+	// Accepting again finds no pending request: nothing is stored or sent
+	require.NoError(t, fs.AcceptFriendRequest(ctx, "alice", "bob"))
+	assert.Len(t, fake.Calls("AcceptFriend"), 2)
+	assert.Len(t, fake.Calls("CreateMessage"), 1)
+	assert.Equal(t, 1, fake.Commits())
+	assert.Len(t, csrv.delivered, 1)
+}
+
+func TestAcceptFriendRequestConcurrently(t *testing.T) {
+	fs, fake, _ := newService(t)
+	csrv := &deliveringChat{}
+	fs.SetConversations(fake.DB(), csrv)
+
+	var wg sync.WaitGroup
+	for range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, fs.AcceptFriendRequest(context.Background(), "alice", "bob"))
+		}()
+	}
+	wg.Wait()
+
+	assert.Len(t, fake.Calls("CreateMessage"), 1)
+	assert.Len(t, csrv.delivered, 1, "one notice however many accepts race")
+}
+
+func TestAcceptFriendRequestNoticeFails(t *testing.T) {
+	fs, fake, _ := newService(t)
+	csrv := &deliveringChat{}
+	fs.SetConversations(fake.DB(), csrv)
+
+	fake.Fail("CreateMessage", errors.New("connection reset"))
+	assert.Error(t, fs.AcceptFriendRequest(context.Background(), "alice", "bob"))
+
+	assert.Len(t, fake.Calls("AcceptFriend"), 1)
+	assert.Zero(t, fake.Commits())
+	assert.Equal(t, 1, fake.Rollbacks(), "the acceptance is rolled back with the notice")
+	assert.Empty(t, csrv.delivered)
+}
+
+func TestAcceptFriendRequestWithoutConversations(t *testing.T) {
+	fs, fake, _ := newService(t)
+
+	require.NoError(t, fs.AcceptFriendRequest(context.Background(), "alice", "bob"))
+	assert.Len(t, fake.Calls("AcceptFriend"), 1)
+	assert.Empty(t, fake.Calls("CreateMessage"))
+	assert.Zero(t, fake.Commits())
+
+	err := fs.AcceptFriendRequest(context.Background(), "alice", "nobody")
+	assert.Error(t, err)
+}
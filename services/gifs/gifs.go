@@ -0,0 +1,341 @@
+package gifs
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"exc6/apperrors"
+	"exc6/config"
+	"exc6/pkg/breaker"
+	"exc6/pkg/httpclient"
+	"exc6/pkg/keyspace"
+	"exc6/pkg/logger"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/sony/gobreaker"
+)
+
+const cacheKeyPrefix = "gifs:search:"
+
+func init() {
+	keyspace.Register(keyspace.Family{Prefix: cacheKeyPrefix, Description: "cached GIF search results"})
+}
+
+// MaxQueryLength bounds search terms forwarded to the provider
+const MaxQueryLength = 100
+
+// GIF is a single search result, normalized across providers
+type GIF struct {
+	ID         string `json:"id"`
+	Title      string `json:"title"`
+	URL        string `json:"url"`
+	PreviewURL string `json:"preview_url"`
+	Width      int    `json:"width"`
+	Height     int    `json:"height"`
+}
+
+// Page is a page of results; Next is an opaque cursor for the following page
+type Page struct {
+	Results []GIF  `json:"results"`
+	Next    string `json:"next,omitempty"`
+}
+
+// provider is a GIF search backend
+type provider interface {
+	name() string
+	search(ctx context.Context, query, cursor string, limit int) (*Page, error)
+
+	// mediaHosts are the hosts GIF URLs of this provider are served from
+	mediaHosts() []string
+}
+
+// GifService proxies GIF search so provider API keys stay on the server.
+// Results are filtered by the configured content rating and cached in Redis.
+type GifService struct {
+	cfg      config.GifConfig
+	provider provider
+	rdb      *redis.Client
+	cb       *gobreaker.CircuitBreaker
+}
+
+// NewGifService creates the service. It returns nil if no provider is configured.
+func NewGifService(cfg config.GifConfig, client *httpclient.Client, rdb *redis.Client) *GifService {
+	var p provider
+	switch cfg.Provider {
+	case "giphy":
+		p = &giphy{client: client, apiKey: cfg.APIKey, rating: cfg.Rating}
+	case "tenor":
+		p = &tenor{client: client, apiKey: cfg.APIKey, rating: cfg.Rating}
+	default:
+		return nil
+	}
+
+	return &GifService{
+		cfg:      cfg,
+		provider: p,
+		rdb:      rdb,
+		cb: breaker.New(breaker.Config{
+			Name:        "redis-gifs",
+			MaxRequests: 5,
+			Interval:    60 * time.Second,
+			Timeout:     30 * time.Second,
+			Threshold:   0.5,
+			MinRequests: 5,
+		}),
+	}
+}
+
+// Search returns a page of GIFs for query; an empty query returns trending GIFs
+func (gs *GifService) Search(ctx context.Context, query, cursor string) (*Page, error) {
+	query = strings.TrimSpace(query)
+	if len(query) > MaxQueryLength {
+		return nil, apperrors.NewValidationError("Search query is too long")
+	}
+
+	key := gs.cacheKey(query, cursor)
+
+	if page := gs.cached(ctx, key); page != nil {
+		return page, nil
+	}
+
+	page, err := gs.provider.search(ctx, query, cursor, gs.cfg.Limit)
+	if err != nil {
+		logger.WithFields(map[string]interface{}{
+			"provider": gs.provider.name(),
+			"error":    err.Error(),
+		}).Warn("GIF search failed")
+		return nil, err
+	}
+
+	gs.store(ctx, key, page)
+
+	return page, nil
+}
+
+// GIFURL returns rawURL as it is stored for a GIF message, reporting false
+// unless it points at the configured provider's media CDN. URLs are put in
+// img attributes by the clients, so ones with quotes, angle brackets or
+// whitespace are refused rather than escaped.
+func (gs *GifService) GIFURL(rawURL string) (string, bool) {
+	if strings.ContainsAny(rawURL, "\"'<>\\` \t\r\n") {
+		return "", false
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme != "https" || u.Host == "" || u.User != nil || u.Opaque != "" {
+		return "", false
+	}
+	if !httpclient.HostAllowed(gs.provider.mediaHosts(), u.Hostname()) {
+		return "", false
+	}
+
+	u.Fragment = ""
+	return u.String(), true
+}
+
+// SearchesPerMinute is the per-user search rate limit
+func (gs *GifService) SearchesPerMinute() int64 {
+	return int64(gs.cfg.SearchesPerMinute)
+}
+
+func (gs *GifService) cacheKey(query, cursor string) string {
+	sum := sha1.Sum([]byte(strings.ToLower(query) + "\x00" + cursor))
+	return cacheKeyPrefix + gs.provider.name() + ":" + gs.cfg.Rating + ":" + hex.EncodeToString(sum[:])
+}
+
+// cached returns a cached page, or nil on a miss or Redis failure
+func (gs *GifService) cached(ctx context.Context, key string) *Page {
+	result, err := breaker.ExecuteCtx(ctx, gs.cb, func() (interface{}, error) {
+		return gs.rdb.Get(ctx, key).Bytes()
+	})
+	if err != nil || result == nil {
+		return nil
+	}
+
+	var page Page
+	if err := json.Unmarshal(result.([]byte), &page); err != nil {
+		return nil
+	}
+	return &page
+}
+
+func (gs *GifService) store(ctx context.Context, key string, page *Page) {
+	body, err := json.Marshal(page)
+	if err != nil {
+		return
+	}
+
+	if _, err := breaker.ExecuteCtx(ctx, gs.cb, func() (interface{}, error) {
+		return nil, gs.rdb.Set(ctx, key, body, gs.cfg.CacheTTL).Err()
+	}); err != nil {
+		logger.WithError(err).Warn("Circuit breaker: Failed to cache GIF results")
+	}
+}
+
+// giphy implements the GIPHY search API
+type giphy struct {
+	client *httpclient.Client
+	apiKey string
+	rating string
+}
+
+func (g *giphy) name() string { return "giphy" }
+
+func (g *giphy) mediaHosts() []string { return []string{"*.giphy.com"} }
+
+func (g *giphy) search(ctx context.Context, query, cursor string, limit int) (*Page, error) {
+	endpoint := "https://api.giphy.com/v1/gifs/trending"
+	params := url.Values{
+		"api_key": {g.apiKey},
+		"limit":   {strconv.Itoa(limit)},
+		"rating":  {g.rating},
+	}
+	if query != "" {
+		endpoint = "https://api.giphy.com/v1/gifs/search"
+		params.Set("q", query)
+	}
+	if cursor != "" {
+		params.Set("offset", cursor)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint+"?"+params.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var body struct {
+		Data []struct {
+			ID     string `json:"id"`
+			Title  string `json:"title"`
+			Images struct {
+				Original struct {
+					URL    string `json:"url"`
+					Width  string `json:"width"`
+					Height string `json:"height"`
+				} `json:"original"`
+				FixedWidthSmall struct {
+					URL string `json:"url"`
+				} `json:"fixed_width_small"`
+			} `json:"images"`
+		} `json:"data"`
+		Pagination struct {
+			Offset     int `json:"offset"`
+			Count      int `json:"count"`
+			TotalCount int `json:"total_count"`
+		} `json:"pagination"`
+	}
+	if err := g.client.DoJSON(req, &body); err != nil {
+		return nil, err
+	}
+
+	page := &Page{Results: make([]GIF, 0, len(body.Data))}
+	for _, item := range body.Data {
+		page.Results = append(page.Results, GIF{
+			ID:         item.ID,
+			Title:      item.Title,
+			URL:        item.Images.Original.URL,
+			PreviewURL: item.Images.FixedWidthSmall.URL,
+			Width:      atoi(item.Images.Original.Width),
+			Height:     atoi(item.Images.Original.Height),
+		})
+	}
+
+	if next := body.Pagination.Offset + body.Pagination.Count; body.Pagination.Count > 0 && next < body.Pagination.TotalCount {
+		page.Next = strconv.Itoa(next)
+	}
+
+	return page, nil
+}
+
+// tenor implements the Tenor v2 search API
+type tenor struct {
+	client *httpclient.Client
+	apiKey string
+	rating string
+}
+
+func (t *tenor) name() string { return "tenor" }
+
+func (t *tenor) mediaHosts() []string { return []string{"*.tenor.com"} }
+
+// contentFilter maps a GIPHY-style rating onto Tenor's content filter
+func (t *tenor) contentFilter() string {
+	switch t.rating {
+	case "g":
+		return "high"
+	case "pg":
+		return "medium"
+	case "pg-13":
+		return "low"
+	default:
+		return "off"
+	}
+}
+
+func (t *tenor) search(ctx context.Context, query, cursor string, limit int) (*Page, error) {
+	endpoint := "https://tenor.googleapis.com/v2/featured"
+	params := url.Values{
+		"key":           {t.apiKey},
+		"limit":         {strconv.Itoa(limit)},
+		"contentfilter": {t.contentFilter()},
+		"media_filter":  {"gif,tinygif"},
+	}
+	if query != "" {
+		endpoint = "https://tenor.googleapis.com/v2/search"
+		params.Set("q", query)
+	}
+	if cursor != "" {
+		params.Set("pos", cursor)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint+"?"+params.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	type media struct {
+		URL  string `json:"url"`
+		Dims []int  `json:"dims"`
+	}
+	var body struct {
+		Results []struct {
+			ID           string `json:"id"`
+			Description  string `json:"content_description"`
+			MediaFormats struct {
+				GIF     media `json:"gif"`
+				TinyGIF media `json:"tinygif"`
+			} `json:"media_formats"`
+		} `json:"results"`
+		Next string `json:"next"`
+	}
+	if err := t.client.DoJSON(req, &body); err != nil {
+		return nil, err
+	}
+
+	page := &Page{Results: make([]GIF, 0, len(body.Results)), Next: body.Next}
+	for _, item := range body.Results {
+		gif := GIF{
+			ID:         item.ID,
+			Title:      item.Description,
+			URL:        item.MediaFormats.GIF.URL,
+			PreviewURL: item.MediaFormats.TinyGIF.URL,
+		}
+		if dims := item.MediaFormats.GIF.Dims; len(dims) == 2 {
+			gif.Width, gif.Height = dims[0], dims[1]
+		}
+		page.Results = append(page.Results, gif)
+	}
+
+	return page, nil
+}
+
+func atoi(s string) int {
+	n, _ := strconv.Atoi(s)
+	return n
+}
@@ -0,0 +1,41 @@
+package gifs
+
+import (
+	"exc6/config"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGIFURL(t *testing.T) {
+	gs := NewGifService(config.GifConfig{Provider: "giphy"}, nil, nil)
+	require.NotNil(t, gs)
+
+	tests := []struct {
+		name string
+		raw  string
+		want string // "" when refused
+	}{
+		{"Media URL", "https://media.giphy.com/media/abc/giphy.gif", "https://media.giphy.com/media/abc/giphy.gif"},
+		{"With query", "https://i.giphy.com/abc.gif?cid=1", "https://i.giphy.com/abc.gif?cid=1"},
+		{"Fragment dropped", "https://media.giphy.com/abc.gif#x", "https://media.giphy.com/abc.gif"},
+		{"Quote injected", `https://media.giphy.com/x"onerror="alert(document.cookie)"x=".gif`, ""},
+		{"Single quote", "https://media.giphy.com/x'onerror='alert(1)'.gif", ""},
+		{"Angle brackets", "https://media.giphy.com/<script>.gif", ""},
+		{"Whitespace", "https://media.giphy.com/x onerror=alert(1).gif", ""},
+		{"Plain HTTP", "http://media.giphy.com/abc.gif", ""},
+		{"Other host", "https://evil.example/abc.gif", ""},
+		{"Lookalike host", "https://giphy.com.evil.example/abc.gif", ""},
+		{"User info", "https://media.giphy.com@evil.example/abc.gif", ""},
+		{"JavaScript", "javascript:alert(1)", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := gs.GIFURL(tt.raw)
+			assert.Equal(t, tt.want != "", ok)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,264 @@
+package groups
+
+import (
+	"context"
+	"exc6/apperrors"
+	"exc6/db"
+	"exc6/pkg/breaker"
+	"exc6/pkg/logger"
+	"exc6/services/moderation"
+	"exc6/utils"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Group DMs are ad-hoc conversations of a few people. They are groups of
+// kind KindDM, so messages, unread counts, receipts and live delivery work
+// exactly as for groups, keyed by the group ID. They have no name and no
+// admins: any participant may add people up to MaxDMParticipants or leave,
+// nobody can remove others or delete the conversation, and it goes away with
+// its last participant. A participant can turn one into a regular group,
+// becoming its owner; the ID and with it the history stay.
+
+// Group kinds
+const (
+	KindGroup = "group"
+	KindDM    = "dm"
+)
+
+const (
+	// MinDMParticipants and MaxDMParticipants bound the size of a group DM,
+	// counting its creator. Two people use a direct conversation instead.
+	MinDMParticipants = 3
+	MaxDMParticipants = 10
+)
+
+// CreateDM starts a group DM between creatorUsername and participants
+func (gs *GroupService) CreateDM(ctx context.Context, creatorUsername string, participants []string) (*GroupInfo, error) {
+	others, err := dmParticipants(creatorUsername, participants)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := gs.policy.Check(ctx, creatorUsername, moderation.ActionCreateGroup); err != nil {
+		return nil, err
+	}
+
+	result, err := breaker.ExecuteCtx(ctx, gs.cb, func() (any, error) {
+		creator, err := gs.qdb.GetUserByUsername(ctx, creatorUsername)
+		if err != nil {
+			return nil, err
+		}
+
+		users, err := gs.qdb.GetUsersByUsernames(ctx, others)
+		if err != nil {
+			return nil, err
+		}
+		if len(users) != len(others) {
+			return nil, apperrors.NewBadRequest("User not found")
+		}
+
+		group, err := gs.qdb.CreateGroupDM(ctx, creator.ID)
+		if err != nil {
+			return nil, apperrors.NewDatabaseError("group_dm_insert", err)
+		}
+
+		for _, id := range append([]uuid.UUID{creator.ID}, userIDs(users)...) {
+			if _, err := gs.qdb.AddGroupMember(ctx, db.AddGroupMemberParams{
+				GroupID: group.ID,
+				UserID:  id,
+				Role:    RoleMember,
+			}); err != nil {
+				// Rollback - delete group
+				gs.qdb.DeleteGroup(ctx, group.ID)
+				return nil, apperrors.NewDatabaseError("group_dm_member_insert", err).
+					WithDetails("group_id", group.ID)
+			}
+		}
+
+		return &GroupInfo{
+			ID:          group.ID.String(),
+			Name:        dmName(others),
+			CreatedBy:   creatorUsername,
+			MemberCount: len(others) + 1,
+			UserRole:    RoleMember,
+			CreatedAt:   group.CreatedAt,
+			Kind:        KindDM,
+		}, nil
+	})
+	if err != nil {
+		logger.WithFields(map[string]any{
+			"creator":      creatorUsername,
+			"participants": len(others),
+			"error":        err.Error(),
+		}).Error("Circuit breaker: Failed to create group DM")
+		return nil, err
+	}
+
+	info := result.(*GroupInfo)
+	gs.publishMembership(ctx, info.ID, MembershipJoined, append([]string{creatorUsername}, others...)...)
+
+	return info, nil
+}
+
+// ConvertDM turns a group DM into a regular group named name, with username
+// as its owner
+func (gs *GroupService) ConvertDM(ctx context.Context, groupID, username, name string) (*GroupInfo, error) {
+	if err := utils.ValidateGroupName(name); err != nil {
+		return nil, err
+	}
+
+	groupUUID, err := uuid.Parse(groupID)
+	if err != nil {
+		return nil, apperrors.NewBadRequest("Invalid group ID")
+	}
+
+	result, err := breaker.ExecuteCtx(ctx, gs.cb, func() (any, error) {
+		user, err := gs.qdb.GetUserByUsername(ctx, username)
+		if err != nil {
+			return nil, err
+		}
+
+		isMember, err := gs.qdb.IsGroupMember(ctx, db.IsGroupMemberParams{
+			GroupID: groupUUID,
+			UserID:  user.ID,
+		})
+		if err != nil || !isMember {
+			return nil, apperrors.New(apperrors.ErrCodeUnauthorized, "Not a member of this group", 403)
+		}
+
+		group, err := gs.qdb.ConvertGroupDM(ctx, db.ConvertGroupDMParams{ID: groupUUID, Name: name})
+		if err != nil {
+			// No row when it is already a regular group
+			return nil, apperrors.NewBadRequest("Only group DMs can be converted")
+		}
+
+		if _, err := gs.qdb.UpdateMemberRole(ctx, db.UpdateMemberRoleParams{
+			GroupID: groupUUID,
+			UserID:  user.ID,
+			Role:    RoleOwner,
+		}); err != nil {
+			return nil, apperrors.NewDatabaseError("update role", err)
+		}
+
+		members, err := gs.qdb.GetGroupMembers(ctx, groupUUID)
+		if err != nil {
+			return nil, err
+		}
+		usernames := make([]string, 0, len(members))
+		for _, member := range members {
+			usernames = append(usernames, member.Username)
+		}
+
+		info := &GroupInfo{
+			ID:          group.ID.String(),
+			Name:        group.Name,
+			CreatedBy:   username,
+			MemberCount: len(members),
+			UserRole:    RoleOwner,
+			CreatedAt:   group.CreatedAt,
+			Kind:        group.Kind,
+		}
+		return dmConversion{info: info, members: usernames}, nil
+	})
+	if err != nil {
+		logger.WithFields(map[string]any{
+			"group_id": groupID,
+			"username": username,
+			"error":    err.Error(),
+		}).Error("Circuit breaker: Failed to convert group DM")
+		return nil, err
+	}
+
+	conversion := result.(dmConversion)
+	gs.InvalidateGroup(ctx, groupID)
+
+	// The conversation is renamed in every participant's contact list
+	gs.activity.TouchList(ctx, conversion.members...)
+
+	return conversion.info, nil
+}
+
+// dmConversion is the outcome of ConvertDM inside the breaker
+type dmConversion struct {
+	info    *GroupInfo
+	members []string
+}
+
+// checkDMAdd allows a participant of a group DM to add someone while it is
+// below MaxDMParticipants
+func (gs *GroupService) checkDMAdd(ctx context.Context, groupID, adderID uuid.UUID) error {
+	isMember, err := gs.qdb.IsGroupMember(ctx, db.IsGroupMemberParams{
+		GroupID: groupID,
+		UserID:  adderID,
+	})
+	if err != nil || !isMember {
+		return apperrors.New(apperrors.ErrCodeUnauthorized, "Not a member of this group", 403)
+	}
+
+	count, err := gs.qdb.GetGroupMemberCount(ctx, groupID)
+	if err != nil {
+		return err
+	}
+	if count >= MaxDMParticipants {
+		return apperrors.NewValidationError(fmt.Sprintf("Group DMs cannot have more than %d participants", MaxDMParticipants))
+	}
+	return nil
+}
+
+// displayName returns a group's name, or for a group DM the names of the
+// participants other than username
+func (gs *GroupService) displayName(ctx context.Context, group db.Group, username string) string {
+	if group.Kind != KindDM {
+		return group.Name
+	}
+
+	members, err := gs.qdb.GetGroupMembers(ctx, group.ID)
+	if err != nil {
+		return "Group DM"
+	}
+	others := make([]string, 0, len(members))
+	for _, member := range members {
+		if member.Username != username {
+			others = append(others, member.Username)
+		}
+	}
+	return dmName(others)
+}
+
+// dmParticipants returns the participants of a new group DM besides its
+// creator, without duplicates, checking the size limits
+func dmParticipants(creator string, participants []string) ([]string, error) {
+	others := make([]string, 0, len(participants))
+	for _, name := range participants {
+		name = strings.TrimSpace(name)
+		if name == "" || name == creator || slices.Contains(others, name) {
+			continue
+		}
+		others = append(others, name)
+	}
+
+	if n := len(others) + 1; n < MinDMParticipants || n > MaxDMParticipants {
+		return nil, apperrors.NewValidationError(fmt.Sprintf("Group DMs have %d to %d participants", MinDMParticipants, MaxDMParticipants))
+	}
+	return others, nil
+}
+
+// dmName names a group DM after participants
+func dmName(participants []string) string {
+	if len(participants) == 0 {
+		return "Group DM"
+	}
+	return strings.Join(participants, ", ")
+}
+
+func userIDs(users []db.User) []uuid.UUID {
+	ids := make([]uuid.UUID, 0, len(users))
+	for _, user := range users {
+		ids = append(ids, user.ID)
+	}
+	return ids
+}
@@ -0,0 +1,24 @@
+package groups
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDMParticipants(t *testing.T) {
+	others, err := dmParticipants("alice", []string{"bob", " carol ", "bob", "alice", ""})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"bob", "carol"}, others, "duplicates and the creator are dropped")
+
+	_, err = dmParticipants("alice", []string{"bob", "alice"})
+	assert.Error(t, err, "two people use a direct conversation")
+
+	many := []string{"b", "c", "d", "e", "f", "g", "h", "i", "j", "k"}
+	_, err = dmParticipants("alice", many)
+	assert.Error(t, err, "over the participant limit")
+
+	_, err = dmParticipants("alice", many[:MaxDMParticipants-1])
+	assert.NoError(t, err)
+}
@@ -3,20 +3,47 @@ package groups
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"exc6/apperrors"
 	"exc6/db"
 	"exc6/pkg/breaker"
+	"exc6/pkg/cache"
 	"exc6/pkg/logger"
+	"exc6/pkg/pagination"
+	"exc6/services/activity"
+	"exc6/services/events"
+	"exc6/services/moderation"
 	"exc6/utils"
+	"slices"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
 	"github.com/sony/gobreaker"
 )
 
 type GroupService struct {
 	qdb *db.Queries
 	cb  *gobreaker.CircuitBreaker
+
+	// groupCache holds group rows by ID; entries are dropped through the invalidator
+	groupCache  *cache.Local[string, db.Group]
+	invalidator *cache.Invalidator
+
+	// rdb publishes membership events and stores join links; nil disables them
+	rdb *redis.Client
+
+	// activity records membership changes for contact list deltas; may be nil
+	activity *activity.Tracker
+
+	// events records membership changes in the groups' event feeds; may be nil
+	events *events.Log
+
+	// policy restricts reported accounts; may be nil
+	policy *moderation.Policy
+
+	// directAdd lets admins add members without inviting them
+	directAdd bool
 }
 
 func NewGroupService(qdb *db.Queries) *GroupService {
@@ -30,20 +57,67 @@ func NewGroupService(qdb *db.Queries) *GroupService {
 			Threshold:   0.6,
 			MinRequests: 10,
 		}),
+		groupCache: cache.NewLocal[string, db.Group](5000, 5*time.Minute),
+	}
+}
+
+// SetInvalidator connects the group cache to cross-instance invalidation events
+func (gs *GroupService) SetInvalidator(inv *cache.Invalidator) {
+	gs.invalidator = inv
+	inv.OnInvalidate(cache.KindGroup, func(keys []string) {
+		gs.groupCache.Delete(keys...)
+	})
+}
+
+// SetPolicy stops restricted accounts from creating groups
+func (gs *GroupService) SetPolicy(policy *moderation.Policy) {
+	gs.policy = policy
+}
+
+// InvalidateGroup drops a group from the local cache and from every other instance's cache
+func (gs *GroupService) InvalidateGroup(ctx context.Context, groupID string) {
+	if gs.invalidator == nil {
+		gs.groupCache.Delete(groupID)
+		return
+	}
+	gs.invalidator.Invalidate(ctx, cache.KindGroup, groupID)
+}
+
+// getGroup returns a group row, served from the local cache when possible
+func (gs *GroupService) getGroup(ctx context.Context, groupID uuid.UUID) (db.Group, error) {
+	if group, ok := gs.groupCache.Get(groupID.String()); ok {
+		return group, nil
+	}
+
+	group, err := gs.qdb.GetGroupByID(ctx, groupID)
+	if err != nil {
+		return group, err
 	}
+
+	gs.groupCache.Set(groupID.String(), group)
+	return group, nil
 }
 
 // GroupInfo represents a group with additional metadata
 type GroupInfo struct {
-	ID          string
-	Name        string
-	Description string
-	Icon        string
-	CustomIcon  string
-	CreatedBy   string
-	MemberCount int
-	UserRole    string
-	CreatedAt   time.Time
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	Icon        string    `json:"icon,omitempty"`
+	CustomIcon  string    `json:"custom_icon,omitempty"`
+	CreatedBy   string    `json:"created_by"`
+	MemberCount int       `json:"member_count"`
+	UserRole    string    `json:"user_role"`
+	CreatedAt   time.Time `json:"created_at"`
+
+	// Kind is KindGroup or KindDM; group DMs are named after their other
+	// participants
+	Kind string `json:"kind"`
+}
+
+// Cursor orders groups by name
+func (g GroupInfo) Cursor() pagination.Cursor {
+	return pagination.Cursor{Key: g.Name, ID: g.ID}
 }
 
 // MemberInfo represents a group member
@@ -66,6 +140,10 @@ func (gs *GroupService) CreateGroup(ctx context.Context, creatorUsername, name,
 			WithContext("creator", creatorUsername)
 	}
 
+	if err := gs.policy.Check(ctx, creatorUsername, moderation.ActionCreateGroup); err != nil {
+		return nil, err
+	}
+
 	result, err := breaker.ExecuteCtx(ctx, gs.cb, func() (interface{}, error) {
 		// Get creator
 		creator, err := gs.qdb.GetUserByUsername(ctx, creatorUsername)
@@ -94,11 +172,11 @@ func (gs *GroupService) CreateGroup(ctx context.Context, creatorUsername, name,
 				WithContext("step", "inserting_group")
 		}
 
-		// Add creator as admin
+		// Add creator as owner
 		_, err = gs.qdb.AddGroupMember(ctx, db.AddGroupMemberParams{
 			GroupID: group.ID,
 			UserID:  creator.ID,
-			Role:    "admin",
+			Role:    RoleOwner,
 		})
 		if err != nil {
 			// Rollback - delete group
@@ -121,8 +199,9 @@ func (gs *GroupService) CreateGroup(ctx context.Context, creatorUsername, name,
 			CustomIcon:  group.CustomIcon.String,
 			CreatedBy:   creator.Username,
 			MemberCount: 1,
-			UserRole:    "admin",
+			UserRole:    RoleOwner,
 			CreatedAt:   group.CreatedAt,
+			Kind:        group.Kind,
 		}, nil
 	})
 
@@ -144,7 +223,10 @@ func (gs *GroupService) CreateGroup(ctx context.Context, creatorUsername, name,
 		return nil, wrappedErr
 	}
 
-	return result.(*GroupInfo), nil
+	info := result.(*GroupInfo)
+	gs.publishMembership(ctx, info.ID, MembershipJoined, creatorUsername)
+
+	return info, nil
 }
 
 // GetUserGroups returns all groups a user is a member of
@@ -174,20 +256,21 @@ func (gs *GroupService) GetUserGroups(ctx context.Context, username string) ([]G
 				UserID:  user.ID,
 			})
 
-			role := "member"
+			role := RoleMember
 			if err == nil {
 				role = member.Role
 			}
 
 			infos = append(infos, GroupInfo{
 				ID:          group.ID.String(),
-				Name:        group.Name,
+				Name:        gs.displayName(ctx, group, username),
 				Description: group.Description.String,
 				Icon:        group.Icon.String,
 				CustomIcon:  group.CustomIcon.String,
 				MemberCount: int(count),
 				UserRole:    role,
 				CreatedAt:   group.CreatedAt,
+				Kind:        group.Kind,
 			})
 		}
 
@@ -227,7 +310,7 @@ func (gs *GroupService) GetGroupInfo(ctx context.Context, groupID, username stri
 			return nil, apperrors.New(apperrors.ErrCodeUnauthorized, "Not a member of this group", 403)
 		}
 
-		group, err := gs.qdb.GetGroupByID(ctx, groupUUID)
+		group, err := gs.getGroup(ctx, groupUUID)
 		if err != nil {
 			return nil, err
 		}
@@ -241,7 +324,7 @@ func (gs *GroupService) GetGroupInfo(ctx context.Context, groupID, username stri
 			UserID:  user.ID,
 		})
 
-		role := "member"
+		role := RoleMember
 		if err == nil {
 			role = member.Role
 		}
@@ -255,7 +338,7 @@ func (gs *GroupService) GetGroupInfo(ctx context.Context, groupID, username stri
 
 		return &GroupInfo{
 			ID:          group.ID.String(),
-			Name:        group.Name,
+			Name:        gs.displayName(ctx, group, username),
 			Description: group.Description.String,
 			Icon:        group.Icon.String,
 			CustomIcon:  group.CustomIcon.String,
@@ -263,6 +346,7 @@ func (gs *GroupService) GetGroupInfo(ctx context.Context, groupID, username stri
 			MemberCount: int(count),
 			UserRole:    role,
 			CreatedAt:   group.CreatedAt,
+			Kind:        group.Kind,
 		}, nil
 	})
 
@@ -278,6 +362,42 @@ func (gs *GroupService) GetGroupInfo(ctx context.Context, groupID, username stri
 	return result.(*GroupInfo), nil
 }
 
+// IsMember reports whether a user belongs to a group
+func (gs *GroupService) IsMember(ctx context.Context, groupID, username string) (bool, error) {
+	result, err := breaker.ExecuteCtx(ctx, gs.cb, func() (interface{}, error) {
+		user, err := gs.qdb.GetUserByUsername(ctx, username)
+		if err != nil {
+			return nil, err
+		}
+
+		groupUUID, err := uuid.Parse(groupID)
+		if err != nil {
+			return false, nil
+		}
+
+		return gs.qdb.IsGroupMember(ctx, db.IsGroupMemberParams{
+			GroupID: groupUUID,
+			UserID:  user.ID,
+		})
+	})
+
+	if err != nil {
+		logger.WithFields(map[string]interface{}{
+			"group_id": groupID,
+			"username": username,
+			"error":    err.Error(),
+		}).Error("Circuit breaker: Failed to check group membership")
+		return false, apperrors.NewDatabaseError("check group membership", err)
+	}
+
+	// Unknown users come back as an empty result
+	if result == nil {
+		return false, nil
+	}
+
+	return result.(bool), nil
+}
+
 // GetGroupMembers returns all members of a group
 func (gs *GroupService) GetGroupMembers(ctx context.Context, groupID, username string) ([]MemberInfo, error) {
 	result, err := breaker.ExecuteCtx(ctx, gs.cb, func() (interface{}, error) {
@@ -332,7 +452,9 @@ func (gs *GroupService) GetGroupMembers(ctx context.Context, groupID, username s
 	return result.([]MemberInfo), nil
 }
 
-// AddMember adds a user to a group (only admins can add)
+// AddMember adds a user to a group. Admins and the owner can add to a group
+// when direct adds are enabled, and otherwise invite; any participant can add
+// to a group DM up to MaxDMParticipants.
 func (gs *GroupService) AddMember(ctx context.Context, groupID, adderUsername, newMemberUsername string) error {
 	_, err := breaker.ExecuteCtx(ctx, gs.cb, func() (interface{}, error) {
 		// Get adder
@@ -352,13 +474,26 @@ func (gs *GroupService) AddMember(ctx context.Context, groupID, adderUsername, n
 			return nil, apperrors.NewBadRequest("Invalid group ID")
 		}
 
-		// Check if adder is admin
-		isAdmin, err := gs.qdb.IsGroupAdmin(ctx, db.IsGroupAdminParams{
-			GroupID: groupUUID,
-			UserID:  adder.ID,
-		})
-		if err != nil || !isAdmin {
-			return nil, apperrors.New(apperrors.ErrCodeUnauthorized, "Only admins can add members", 403)
+		group, err := gs.getGroup(ctx, groupUUID)
+		if err != nil {
+			return nil, err
+		}
+
+		if group.Kind == KindDM {
+			if err := gs.checkDMAdd(ctx, groupUUID, adder.ID); err != nil {
+				return nil, err
+			}
+		} else {
+			role, err := gs.memberRole(ctx, groupUUID, adder.ID)
+			if err != nil {
+				return nil, err
+			}
+			if !RoleAtLeast(role, RoleAdmin) {
+				return nil, apperrors.New(apperrors.ErrCodeUnauthorized, "Only admins can add members", 403)
+			}
+			if !gs.directAdd {
+				return nil, apperrors.NewBadRequest("Invite users to join this group")
+			}
 		}
 
 		// Check if user is already a member
@@ -374,7 +509,7 @@ func (gs *GroupService) AddMember(ctx context.Context, groupID, adderUsername, n
 		_, err = gs.qdb.AddGroupMember(ctx, db.AddGroupMemberParams{
 			GroupID: groupUUID,
 			UserID:  newMember.ID,
-			Role:    "member",
+			Role:    RoleMember,
 		})
 
 		return nil, err
@@ -390,9 +525,14 @@ func (gs *GroupService) AddMember(ctx context.Context, groupID, adderUsername, n
 		return err
 	}
 
+	gs.publishMembership(ctx, groupID, MembershipJoined, newMemberUsername)
+
 	return nil
 }
 
+// RemoveMember removes a user from a group. Members can leave; moderators and
+// above can remove those ranked below them. When the owner leaves, the
+// highest ranked, longest standing member becomes the owner.
 func (gs *GroupService) RemoveMember(ctx context.Context, groupID, removerUsername, targetUsername string) error {
 	_, err := breaker.ExecuteCtx(ctx, gs.cb, func() (interface{}, error) {
 		remover, err := gs.qdb.GetUserByUsername(ctx, removerUsername)
@@ -410,18 +550,18 @@ func (gs *GroupService) RemoveMember(ctx context.Context, groupID, removerUserna
 			return nil, apperrors.NewBadRequest("Invalid group ID")
 		}
 
-		// Check if remover is admin or removing themselves
-		isAdmin, err := gs.qdb.IsGroupAdmin(ctx, db.IsGroupAdminParams{
-			GroupID: groupUUID,
-			UserID:  remover.ID,
-		})
+		removerRole, err := gs.memberRole(ctx, groupUUID, remover.ID)
+		if err != nil {
+			return nil, err
+		}
+		targetRole, err := gs.memberRole(ctx, groupUUID, targetUsername.ID)
 		if err != nil {
 			return nil, err
 		}
 
 		isSelf := remover.ID == targetUsername.ID
-		if !isAdmin && !isSelf {
-			return nil, apperrors.New(apperrors.ErrCodeUnauthorized, "Only admins can remove members", 403)
+		if !isSelf && !canRemove(removerRole, targetRole) {
+			return nil, apperrors.New(apperrors.ErrCodeUnauthorized, "Only members of a higher role can remove this member", 403)
 		}
 
 		// Remove member
@@ -440,6 +580,10 @@ func (gs *GroupService) RemoveMember(ctx context.Context, groupID, removerUserna
 			if err != nil {
 				return nil, apperrors.NewDatabaseError("delete empty group", err)
 			}
+		} else if targetRole == RoleOwner {
+			if _, err := gs.qdb.PromoteGroupSuccessor(ctx, groupUUID); err != nil {
+				return nil, apperrors.NewDatabaseError("promote group owner", err)
+			}
 		}
 
 		return nil, nil
@@ -455,13 +599,19 @@ func (gs *GroupService) RemoveMember(ctx context.Context, groupID, removerUserna
 		return err
 	}
 
+	gs.publishMembership(ctx, groupID, MembershipLeft, targetUsername)
+
 	return nil
 }
 
+// UpdateMemberRole changes the role of a member. Admins and the owner can
+// change the roles of those ranked below them to roles below their own, so
+// only the owner can promote or demote admins. Giving someone the owner role
+// transfers ownership and makes the previous owner an admin.
 func (gs *GroupService) UpdateMemberRole(ctx context.Context, groupID, updaterUsername, targetUsername, newRole string) error {
 	_, err := breaker.ExecuteCtx(ctx, gs.cb, func() (interface{}, error) {
-		if newRole != "admin" && newRole != "member" {
-			return nil, apperrors.NewValidationError("Role must be 'admin' or 'member'")
+		if !ValidRole(newRole) {
+			return nil, apperrors.NewValidationError("Role must be 'owner', 'admin', 'moderator' or 'member'")
 		}
 
 		// Get updater
@@ -481,15 +631,36 @@ func (gs *GroupService) UpdateMemberRole(ctx context.Context, groupID, updaterUs
 			return nil, apperrors.NewBadRequest("Invalid group ID")
 		}
 
-		// Check if updater is admin
-		isAdmin, err := gs.qdb.IsGroupAdmin(ctx, db.IsGroupAdminParams{
-			GroupID: groupUUID,
-			UserID:  updater.ID,
-		})
-		if err != nil || !isAdmin {
+		updaterRole, err := gs.memberRole(ctx, groupUUID, updater.ID)
+		if err != nil {
+			return nil, err
+		}
+		if !RoleAtLeast(updaterRole, RoleAdmin) {
 			return nil, apperrors.New(apperrors.ErrCodeUnauthorized, "Only admins can change roles", 403)
 		}
 
+		targetRole, err := gs.memberRole(ctx, groupUUID, target.ID)
+		if err != nil {
+			return nil, err
+		}
+		if targetRole == "" {
+			return nil, apperrors.NewBadRequest("User is not a member")
+		}
+
+		if !slices.Contains(assignableRoles(updaterRole, targetRole), newRole) {
+			return nil, apperrors.New(apperrors.ErrCodeUnauthorized, "Only members of a higher role can give this role", 403)
+		}
+
+		if newRole == RoleOwner {
+			if _, err := gs.qdb.TransferGroupOwnership(ctx, db.TransferGroupOwnershipParams{
+				NewOwner: target.ID,
+				GroupID:  groupUUID,
+			}); err != nil {
+				return nil, apperrors.NewDatabaseError("transfer ownership", err)
+			}
+			return nil, nil
+		}
+
 		// Update role
 		_, err = gs.qdb.UpdateMemberRole(ctx, db.UpdateMemberRoleParams{
 			GroupID: groupUUID,
@@ -517,9 +688,9 @@ func (gs *GroupService) UpdateMemberRole(ctx context.Context, groupID, updaterUs
 	return nil
 }
 
-// DeleteGroup deletes a group (admin only)
+// DeleteGroup deletes a group (owner only)
 func (gs *GroupService) DeleteGroup(ctx context.Context, groupID, username string) error {
-	_, err := breaker.ExecuteCtx(ctx, gs.cb, func() (interface{}, error) {
+	result, err := breaker.ExecuteCtx(ctx, gs.cb, func() (interface{}, error) {
 		user, err := gs.qdb.GetUserByUsername(ctx, username)
 		if err != nil {
 			return nil, err
@@ -530,18 +701,30 @@ func (gs *GroupService) DeleteGroup(ctx context.Context, groupID, username strin
 			return nil, apperrors.NewBadRequest("Invalid group ID")
 		}
 
-		// Check if user is admin
-		isAdmin, err := gs.qdb.IsGroupAdmin(ctx, db.IsGroupAdminParams{
-			GroupID: groupUUID,
-			UserID:  user.ID,
-		})
-		if err != nil || !isAdmin {
-			return nil, apperrors.New(apperrors.ErrCodeUnauthorized, "Only admins can delete groups", 403)
+		role, err := gs.memberRole(ctx, groupUUID, user.ID)
+		if err != nil {
+			return nil, err
+		}
+		if role != RoleOwner {
+			return nil, apperrors.New(apperrors.ErrCodeUnauthorized, "Only the owner can delete the group", 403)
+		}
+
+		// Collect members before the CASCADE removes them so they can be notified
+		members, err := gs.qdb.GetGroupMembers(ctx, groupUUID)
+		if err != nil {
+			return nil, err
 		}
 
 		// Delete group (CASCADE will remove members)
-		_, err = gs.qdb.DeleteGroup(ctx, groupUUID)
-		return nil, err
+		if _, err = gs.qdb.DeleteGroup(ctx, groupUUID); err != nil {
+			return nil, err
+		}
+
+		usernames := make([]string, 0, len(members))
+		for _, member := range members {
+			usernames = append(usernames, member.Username)
+		}
+		return usernames, nil
 	})
 
 	if err != nil {
@@ -553,9 +736,30 @@ func (gs *GroupService) DeleteGroup(ctx context.Context, groupID, username strin
 		return err
 	}
 
+	gs.InvalidateGroup(ctx, groupID)
+
+	if usernames, ok := result.([]string); ok {
+		gs.publishMembership(ctx, groupID, MembershipLeft, usernames...)
+	}
+
 	return nil
 }
 
+// memberRole returns the role of a user in a group, or "" if they are not a member
+func (gs *GroupService) memberRole(ctx context.Context, groupID, userID uuid.UUID) (string, error) {
+	member, err := gs.qdb.GetGroupMember(ctx, db.GetGroupMemberParams{
+		GroupID: groupID,
+		UserID:  userID,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return member.Role, nil
+}
+
 // GetMetrics returns circuit breaker metrics
 func (gs *GroupService) GetMetrics() map[string]interface{} {
 	state := gs.cb.State()
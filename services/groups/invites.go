@@ -0,0 +1,280 @@
+package groups
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"exc6/apperrors"
+	"exc6/db"
+	"exc6/pkg/breaker"
+	"exc6/pkg/logger"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Admins invite people to regular groups rather than adding them: the
+// invitee joins by accepting and may decline instead. Invitations stay in
+// Postgres until answered. Adding members directly remains available behind
+// SetDirectAdd for deployments that rely on it; participants of group DMs
+// are always added directly.
+
+// Invite is a pending invitation to a group
+type Invite struct {
+	GroupID   string    `json:"group_id"`
+	GroupName string    `json:"group_name,omitempty"`
+	From      string    `json:"from"`
+	To        string    `json:"to,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// SetDirectAdd lets admins add members to regular groups without inviting
+// them
+func (gs *GroupService) SetDirectAdd(enabled bool) {
+	gs.directAdd = enabled
+}
+
+// InviteMember invites a user to a group. Admins and the owner can invite.
+func (gs *GroupService) InviteMember(ctx context.Context, groupID, inviterUsername, inviteeUsername string) (*Invite, error) {
+	result, err := breaker.ExecuteCtx(ctx, gs.cb, func() (any, error) {
+		inviter, err := gs.qdb.GetUserByUsername(ctx, inviterUsername)
+		if err != nil {
+			return nil, err
+		}
+
+		invitee, err := gs.qdb.GetUserByUsername(ctx, inviteeUsername)
+		if err != nil {
+			return nil, apperrors.NewBadRequest("User not found")
+		}
+
+		groupUUID, err := uuid.Parse(groupID)
+		if err != nil {
+			return nil, apperrors.NewBadRequest("Invalid group ID")
+		}
+
+		group, err := gs.getGroup(ctx, groupUUID)
+		if err != nil {
+			return nil, err
+		}
+		if group.Kind == KindDM {
+			return nil, apperrors.NewBadRequest("Add participants to a group DM directly")
+		}
+
+		role, err := gs.memberRole(ctx, groupUUID, inviter.ID)
+		if err != nil {
+			return nil, err
+		}
+		if !RoleAtLeast(role, RoleAdmin) {
+			return nil, apperrors.New(apperrors.ErrCodeUnauthorized, "Only admins can invite members", 403)
+		}
+
+		isMember, _ := gs.qdb.IsGroupMember(ctx, db.IsGroupMemberParams{
+			GroupID: groupUUID,
+			UserID:  invitee.ID,
+		})
+		if isMember {
+			return nil, apperrors.NewBadRequest("User is already a member")
+		}
+
+		rows, err := gs.qdb.CreatePendingInvite(ctx, db.CreatePendingInviteParams{
+			GroupID:   groupUUID,
+			InviteeID: invitee.ID,
+			InviterID: inviter.ID,
+		})
+		if err != nil {
+			return nil, apperrors.NewDatabaseError("invite member", err)
+		}
+		if rows == 0 {
+			return nil, apperrors.NewBadRequest("User is already invited")
+		}
+
+		return &Invite{
+			GroupID:   groupID,
+			GroupName: group.Name,
+			From:      inviter.Username,
+			To:        invitee.Username,
+			CreatedAt: time.Now(),
+		}, nil
+	})
+	if err != nil {
+		logger.WithFields(map[string]any{
+			"group_id": groupID,
+			"inviter":  inviterUsername,
+			"invitee":  inviteeUsername,
+			"error":    err.Error(),
+		}).Error("Circuit breaker: Failed to invite member")
+		return nil, err
+	}
+
+	return result.(*Invite), nil
+}
+
+// AcceptInvite makes username a member of a group they were invited to and
+// returns the invitation
+func (gs *GroupService) AcceptInvite(ctx context.Context, groupID, username string) (*Invite, error) {
+	result, err := breaker.ExecuteCtx(ctx, gs.cb, func() (any, error) {
+		user, groupUUID, inviter, err := gs.answerInvite(ctx, groupID, username)
+		if err != nil {
+			return nil, err
+		}
+
+		group, err := gs.getGroup(ctx, groupUUID)
+		if err != nil {
+			return nil, err
+		}
+
+		// They may have been added directly meanwhile
+		isMember, _ := gs.qdb.IsGroupMember(ctx, db.IsGroupMemberParams{
+			GroupID: groupUUID,
+			UserID:  user.ID,
+		})
+		if !isMember {
+			if _, err := gs.qdb.AddGroupMember(ctx, db.AddGroupMemberParams{
+				GroupID: groupUUID,
+				UserID:  user.ID,
+				Role:    RoleMember,
+			}); err != nil {
+				return nil, apperrors.NewDatabaseError("accept invite", err)
+			}
+		}
+
+		return &Invite{
+			GroupID:   groupID,
+			GroupName: group.Name,
+			From:      inviter,
+			To:        username,
+		}, nil
+	})
+	if err != nil {
+		logger.WithFields(map[string]any{
+			"group_id": groupID,
+			"username": username,
+			"error":    err.Error(),
+		}).Error("Circuit breaker: Failed to accept invite")
+		return nil, err
+	}
+
+	gs.publishMembership(ctx, groupID, MembershipJoined, username)
+
+	return result.(*Invite), nil
+}
+
+// DeclineInvite drops the invitation of username to a group
+func (gs *GroupService) DeclineInvite(ctx context.Context, groupID, username string) error {
+	_, err := breaker.ExecuteCtx(ctx, gs.cb, func() (any, error) {
+		_, _, _, err := gs.answerInvite(ctx, groupID, username)
+		return nil, err
+	})
+	if err != nil {
+		logger.WithFields(map[string]any{
+			"group_id": groupID,
+			"username": username,
+			"error":    err.Error(),
+		}).Error("Circuit breaker: Failed to decline invite")
+		return err
+	}
+	return nil
+}
+
+// answerInvite deletes the invitation of username to a group, returning the
+// user, the group ID and who sent it
+func (gs *GroupService) answerInvite(ctx context.Context, groupID, username string) (db.User, uuid.UUID, string, error) {
+	user, err := gs.qdb.GetUserByUsername(ctx, username)
+	if err != nil {
+		return db.User{}, uuid.Nil, "", err
+	}
+
+	groupUUID, err := uuid.Parse(groupID)
+	if err != nil {
+		return db.User{}, uuid.Nil, "", apperrors.NewBadRequest("Invalid group ID")
+	}
+
+	inviter, err := gs.qdb.DeletePendingInvite(ctx, db.DeletePendingInviteParams{
+		GroupID:   groupUUID,
+		InviteeID: user.ID,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return db.User{}, uuid.Nil, "", apperrors.New(apperrors.ErrCodeNotFound, "No invitation to this group", http.StatusNotFound)
+	}
+	if err != nil {
+		return db.User{}, uuid.Nil, "", apperrors.NewDatabaseError("answer invite", err)
+	}
+	return user, groupUUID, inviter, nil
+}
+
+// ListInvites returns the pending invitations of username, newest first
+func (gs *GroupService) ListInvites(ctx context.Context, username string) ([]Invite, error) {
+	result, err := breaker.ExecuteCtx(ctx, gs.cb, func() (any, error) {
+		user, err := gs.qdb.GetUserByUsername(ctx, username)
+		if err != nil {
+			return nil, err
+		}
+
+		rows, err := gs.qdb.ListUserPendingInvites(ctx, user.ID)
+		if err != nil {
+			return nil, apperrors.NewDatabaseError("list invites", err)
+		}
+
+		invites := make([]Invite, 0, len(rows))
+		for _, row := range rows {
+			invites = append(invites, Invite{
+				GroupID:   row.GroupID.String(),
+				GroupName: row.GroupName,
+				From:      row.Inviter,
+				CreatedAt: row.CreatedAt,
+			})
+		}
+		return invites, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result.([]Invite), nil
+}
+
+// ListGroupInvites returns the pending invitations to a group, newest
+// first. Only admins and the owner can list them.
+func (gs *GroupService) ListGroupInvites(ctx context.Context, groupID, username string) ([]Invite, error) {
+	result, err := breaker.ExecuteCtx(ctx, gs.cb, func() (any, error) {
+		user, err := gs.qdb.GetUserByUsername(ctx, username)
+		if err != nil {
+			return nil, err
+		}
+
+		groupUUID, err := uuid.Parse(groupID)
+		if err != nil {
+			return nil, apperrors.NewBadRequest("Invalid group ID")
+		}
+
+		role, err := gs.memberRole(ctx, groupUUID, user.ID)
+		if err != nil {
+			return nil, err
+		}
+		if !RoleAtLeast(role, RoleAdmin) {
+			return nil, apperrors.New(apperrors.ErrCodeUnauthorized, "Only admins can view invitations", 403)
+		}
+
+		rows, err := gs.qdb.ListGroupPendingInvites(ctx, groupUUID)
+		if err != nil {
+			return nil, apperrors.NewDatabaseError("list group invites", err)
+		}
+
+		invites := make([]Invite, 0, len(rows))
+		for _, row := range rows {
+			invites = append(invites, Invite{
+				GroupID:   groupID,
+				From:      row.Inviter,
+				To:        row.Invitee,
+				CreatedAt: row.CreatedAt,
+			})
+		}
+		return invites, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result.([]Invite), nil
+}
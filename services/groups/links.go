@@ -0,0 +1,422 @@
+package groups
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"exc6/apperrors"
+	"exc6/db"
+	"exc6/pkg/breaker"
+	"exc6/pkg/keyspace"
+	"exc6/pkg/logger"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// Admins can also share a link that lets anyone holding it join a regular
+// group, a limited number of times or until it expires. Links live in Redis
+// and expire with their key; only a hash of the token is stored, so the
+// token cannot be recovered from Redis or from the link ID shown to admins.
+
+const (
+	// InviteLinkMaxTTL is the longest a join link stays valid
+	InviteLinkMaxTTL = 30 * 24 * time.Hour
+
+	// InviteLinkDefaultTTL is how long a join link stays valid unless set
+	InviteLinkDefaultTTL = 7 * 24 * time.Hour
+
+	// InviteLinkMaxUses caps the uses of a limited join link
+	InviteLinkMaxUses = 1000
+
+	linkKeyPrefix      = "groups:link:"  // link ID -> link hash
+	groupLinkKeyPrefix = "groups:links:" // group ID -> set of link IDs
+
+	linkTokenBytes = 24
+	linkIDLength   = 16
+)
+
+// ErrInviteLinkUnavailable is returned for unknown, used up, expired and
+// revoked links alike
+var ErrInviteLinkUnavailable = apperrors.New(apperrors.ErrCodeNotFound, "This invite link is not available", http.StatusNotFound)
+
+func init() {
+	keyspace.Register(keyspace.Family{
+		Prefix:      linkKeyPrefix,
+		Description: "group join links",
+	})
+	keyspace.Register(keyspace.Family{
+		Prefix:      groupLinkKeyPrefix,
+		Description: "join links of each group",
+	})
+}
+
+// InviteLink is a join link as listed to the group's admins
+type InviteLink struct {
+	ID        string    `json:"id"`
+	GroupID   string    `json:"group_id"`
+	CreatedBy string    `json:"created_by"`
+	MaxUses   int       `json:"max_uses"`  // 0 for unlimited
+	Remaining int       `json:"remaining"` // -1 when unlimited
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+
+	// Token is only set when the link is created
+	Token string `json:"token,omitempty"`
+}
+
+// consumeLinkScript spends one use of a join link, deleting it when none is
+// left, and returns the group ID or nil if the link is not available.
+//
+// KEYS: link, group's links
+//
+// ARGV: token hash, link ID
+var consumeLinkScript = redis.NewScript(`
+local link = redis.call('HMGET', KEYS[1], 'hash', 'group_id', 'remaining')
+if not link[1] or link[1] ~= ARGV[1] then
+	return false
+end
+
+if tonumber(link[3]) >= 0 then
+	local remaining = redis.call('HINCRBY', KEYS[1], 'remaining', -1)
+	if remaining < 0 then
+		return false
+	end
+	if remaining == 0 then
+		redis.call('DEL', KEYS[1])
+		redis.call('SREM', KEYS[2], ARGV[2])
+	end
+end
+
+return link[2]
+`)
+
+// CreateInviteLink creates a link to join a group, usable maxUses times (0
+// for unlimited) for ttl (0 for InviteLinkDefaultTTL). Admins and the owner
+// can create links.
+func (gs *GroupService) CreateInviteLink(ctx context.Context, groupID, createdBy string, maxUses int, ttl time.Duration) (*InviteLink, error) {
+	if gs.rdb == nil {
+		return nil, apperrors.New(apperrors.ErrCodeInternal, "Invite links are not available", http.StatusServiceUnavailable)
+	}
+	if maxUses < 0 || maxUses > InviteLinkMaxUses {
+		return nil, apperrors.NewValidationError(fmt.Sprintf("Max uses must be between 0 and %d", InviteLinkMaxUses))
+	}
+	if ttl < 0 || ttl > InviteLinkMaxTTL {
+		return nil, apperrors.NewValidationError(fmt.Sprintf("Expiry must be at most %s", InviteLinkMaxTTL))
+	}
+	if ttl == 0 {
+		ttl = InviteLinkDefaultTTL
+	}
+
+	groupUUID, err := gs.requireLinkAdmin(ctx, groupID, createdBy)
+	if err != nil {
+		return nil, err
+	}
+
+	token, tokenHash, err := newLinkToken()
+	if err != nil {
+		return nil, apperrors.NewInternalError("Failed to create invite link").WithInternal(err)
+	}
+
+	now := time.Now()
+	link := &InviteLink{
+		ID:        tokenHash[:linkIDLength],
+		GroupID:   groupUUID.String(),
+		CreatedBy: createdBy,
+		MaxUses:   maxUses,
+		Remaining: maxUses,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+		Token:     token,
+	}
+	if maxUses == 0 {
+		link.Remaining = -1
+	}
+
+	pipe := gs.rdb.TxPipeline()
+	pipe.HSet(ctx, linkKey(link.ID),
+		"hash", tokenHash,
+		"group_id", link.GroupID,
+		"created_by", createdBy,
+		"max_uses", maxUses,
+		"remaining", link.Remaining,
+		"created_at", now.Unix(),
+		"expires_at", link.ExpiresAt.Unix(),
+	)
+	pipe.Expire(ctx, linkKey(link.ID), ttl)
+	pipe.SAdd(ctx, groupLinksKey(link.GroupID), link.ID)
+	pipe.Expire(ctx, groupLinksKey(link.GroupID), InviteLinkMaxTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, apperrors.NewInternalError("Failed to create invite link").WithInternal(err)
+	}
+
+	logger.WithFields(map[string]any{
+		"group_id": link.GroupID,
+		"username": createdBy,
+		"link_id":  link.ID,
+		"max_uses": maxUses,
+		"ttl":      ttl.String(),
+	}).Info("Group invite link created")
+
+	return link, nil
+}
+
+// InviteLinks lists the live join links of a group, newest first. Admins and
+// the owner can list them.
+func (gs *GroupService) InviteLinks(ctx context.Context, groupID, username string) ([]InviteLink, error) {
+	if gs.rdb == nil {
+		return []InviteLink{}, nil
+	}
+
+	groupUUID, err := gs.requireLinkAdmin(ctx, groupID, username)
+	if err != nil {
+		return nil, err
+	}
+
+	setKey := groupLinksKey(groupUUID.String())
+	ids, err := gs.rdb.SMembers(ctx, setKey).Result()
+	if err != nil {
+		return nil, apperrors.NewInternalError("Failed to list invite links").WithInternal(err)
+	}
+
+	pipe := gs.rdb.Pipeline()
+	cmds := make([]*redis.MapStringStringCmd, len(ids))
+	for i, id := range ids {
+		cmds[i] = pipe.HGetAll(ctx, linkKey(id))
+	}
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return nil, apperrors.NewInternalError("Failed to list invite links").WithInternal(err)
+	}
+
+	links := make([]InviteLink, 0, len(ids))
+	var expired []any
+	for i, id := range ids {
+		fields := cmds[i].Val()
+		if len(fields) == 0 {
+			expired = append(expired, id)
+			continue
+		}
+		links = append(links, parseInviteLink(id, fields))
+	}
+	if len(expired) > 0 {
+		gs.rdb.SRem(ctx, setKey, expired...)
+	}
+
+	sort.Slice(links, func(i, j int) bool {
+		return links[i].CreatedAt.After(links[j].CreatedAt)
+	})
+	return links, nil
+}
+
+// RevokeInviteLink disables a join link of a group. Admins and the owner can
+// revoke links.
+func (gs *GroupService) RevokeInviteLink(ctx context.Context, groupID, username, linkID string) error {
+	if gs.rdb == nil {
+		return apperrors.New(apperrors.ErrCodeNotFound, "Invite link not found", http.StatusNotFound)
+	}
+
+	groupUUID, err := gs.requireLinkAdmin(ctx, groupID, username)
+	if err != nil {
+		return err
+	}
+
+	// The set only holds the group's own links
+	removed, err := gs.rdb.SRem(ctx, groupLinksKey(groupUUID.String()), linkID).Result()
+	if err != nil {
+		return apperrors.NewInternalError("Failed to revoke invite link").WithInternal(err)
+	}
+	if removed == 0 {
+		return apperrors.New(apperrors.ErrCodeNotFound, "Invite link not found", http.StatusNotFound)
+	}
+	if err := gs.rdb.Del(ctx, linkKey(linkID)).Err(); err != nil {
+		return apperrors.NewInternalError("Failed to revoke invite link").WithInternal(err)
+	}
+
+	logger.WithFields(map[string]any{
+		"group_id": groupID,
+		"username": username,
+		"link_id":  linkID,
+	}).Info("Group invite link revoked")
+
+	return nil
+}
+
+// JoinByLink makes username a member of the group behind token, spending one
+// of the link's uses, and returns the group ID. Members following the link
+// again spend nothing.
+func (gs *GroupService) JoinByLink(ctx context.Context, token, username string) (string, error) {
+	if gs.rdb == nil || token == "" {
+		return "", ErrInviteLinkUnavailable
+	}
+
+	tokenHash := hashLinkToken(token)
+	linkID := tokenHash[:linkIDLength]
+
+	link, err := gs.rdb.HMGet(ctx, linkKey(linkID), "hash", "group_id").Result()
+	if err != nil {
+		return "", apperrors.NewInternalError("Failed to open invite link").WithInternal(err)
+	}
+	if hash, _ := link[0].(string); hash != tokenHash {
+		return "", ErrInviteLinkUnavailable
+	}
+	groupID, _ := link[1].(string)
+
+	// Refusals are returned once the breaker is done, so they don't count
+	// as database failures
+	var refused error
+	joined := false
+	_, err = breaker.ExecuteCtx(ctx, gs.cb, func() (any, error) {
+		user, err := gs.qdb.GetUserByUsername(ctx, username)
+		if errors.Is(err, sql.ErrNoRows) {
+			refused = apperrors.NewUserNotFound()
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		groupUUID, err := uuid.Parse(groupID)
+		if err != nil {
+			refused = ErrInviteLinkUnavailable
+			return nil, nil
+		}
+
+		isMember, _ := gs.qdb.IsGroupMember(ctx, db.IsGroupMemberParams{
+			GroupID: groupUUID,
+			UserID:  user.ID,
+		})
+		if isMember {
+			return nil, nil
+		}
+
+		// The link may have been used up or revoked meanwhile
+		consumed, err := consumeLinkScript.Run(ctx, gs.rdb, []string{linkKey(linkID), groupLinksKey(groupID)}, tokenHash, linkID).Text()
+		if errors.Is(err, redis.Nil) || err == nil && consumed != groupID {
+			refused = ErrInviteLinkUnavailable
+			return nil, nil
+		}
+		if err != nil {
+			return nil, apperrors.NewInternalError("Failed to open invite link").WithInternal(err)
+		}
+
+		if _, err := gs.qdb.AddGroupMember(ctx, db.AddGroupMemberParams{
+			GroupID: groupUUID,
+			UserID:  user.ID,
+			Role:    RoleMember,
+		}); err != nil {
+			return nil, apperrors.NewDatabaseError("join group", err)
+		}
+
+		joined = true
+		return nil, nil
+	})
+	if err != nil {
+		logger.WithFields(map[string]any{
+			"link_id":  linkID,
+			"username": username,
+			"error":    err.Error(),
+		}).Error("Circuit breaker: Failed to join group by link")
+		return "", err
+	}
+	if refused != nil {
+		return "", refused
+	}
+
+	if joined {
+		logger.WithFields(map[string]any{
+			"group_id": groupID,
+			"username": username,
+			"link_id":  linkID,
+		}).Info("Group joined by invite link")
+		gs.publishMembership(ctx, groupID, MembershipJoined, username)
+	}
+
+	return groupID, nil
+}
+
+// requireLinkAdmin checks that username administers the regular group
+// groupID and returns its ID
+func (gs *GroupService) requireLinkAdmin(ctx context.Context, groupID, username string) (uuid.UUID, error) {
+	result, err := breaker.ExecuteCtx(ctx, gs.cb, func() (any, error) {
+		user, err := gs.qdb.GetUserByUsername(ctx, username)
+		if err != nil {
+			return nil, err
+		}
+
+		groupUUID, err := uuid.Parse(groupID)
+		if err != nil {
+			return nil, apperrors.NewBadRequest("Invalid group ID")
+		}
+
+		group, err := gs.getGroup(ctx, groupUUID)
+		if err != nil {
+			return nil, err
+		}
+		if group.Kind == KindDM {
+			return nil, apperrors.NewBadRequest("Group DMs have no invite links")
+		}
+
+		role, err := gs.memberRole(ctx, groupUUID, user.ID)
+		if err != nil {
+			return nil, err
+		}
+		if !RoleAtLeast(role, RoleAdmin) {
+			return nil, apperrors.New(apperrors.ErrCodeUnauthorized, "Only admins can manage invite links", 403)
+		}
+
+		return groupUUID, nil
+	})
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	return result.(uuid.UUID), nil
+}
+
+func parseInviteLink(id string, fields map[string]string) InviteLink {
+	maxUses, _ := strconv.Atoi(fields["max_uses"])
+	remaining, _ := strconv.Atoi(fields["remaining"])
+	createdAt, _ := strconv.ParseInt(fields["created_at"], 10, 64)
+	expiresAt, _ := strconv.ParseInt(fields["expires_at"], 10, 64)
+
+	return InviteLink{
+		ID:        id,
+		GroupID:   fields["group_id"],
+		CreatedBy: fields["created_by"],
+		MaxUses:   maxUses,
+		Remaining: remaining,
+		CreatedAt: time.Unix(createdAt, 0),
+		ExpiresAt: time.Unix(expiresAt, 0),
+	}
+}
+
+func linkKey(id string) string {
+	return linkKeyPrefix + id
+}
+
+func groupLinksKey(groupID string) string {
+	return groupLinkKeyPrefix + groupID
+}
+
+func newLinkToken() (string, string, error) {
+	b := make([]byte, linkTokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	return token, hashLinkToken(token), nil
+}
+
+func hashLinkToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,144 @@
+package groups
+
+import (
+	"context"
+	"errors"
+	"exc6/apperrors"
+	"exc6/tests/fakedb"
+	"exc6/tests/fakeredis"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewLinkToken(t *testing.T) {
+	token, hash, err := newLinkToken()
+	require.NoError(t, err)
+
+	assert.Len(t, token, 32, "24 random bytes, base64url encoded")
+	assert.Equal(t, hashLinkToken(token), hash)
+	assert.NotContains(t, hash, token)
+
+	other, _, err := newLinkToken()
+	require.NoError(t, err)
+	assert.NotEqual(t, token, other)
+}
+
+func TestParseInviteLink(t *testing.T) {
+	link := parseInviteLink("abc", map[string]string{
+		"group_id":   "group",
+		"created_by": "alice",
+		"max_uses":   "5",
+		"remaining":  "2",
+		"created_at": "1700000000",
+		"expires_at": "1700086400",
+	})
+
+	assert.Equal(t, InviteLink{
+		ID:        "abc",
+		GroupID:   "group",
+		CreatedBy: "alice",
+		MaxUses:   5,
+		Remaining: 2,
+		CreatedAt: time.Unix(1700000000, 0),
+		ExpiresAt: time.Unix(1700086400, 0),
+	}, link)
+}
+
+// newLinkService returns a service over a fake database, where alice owns a
+// group bob is a member of, and a fake Redis
+func newLinkService(t *testing.T) (*GroupService, *fakedb.Fake, *fakeredis.Server, uuid.UUID) {
+	fake := fakedb.New(t)
+	srv := fakeredis.New(t)
+	groupID := uuid.New()
+	now := time.Now()
+
+	users := map[string]uuid.UUID{"alice": uuid.New(), "bob": uuid.New(), "carol": uuid.New()}
+	roles := map[string]string{users["alice"].String(): RoleOwner, users["bob"].String(): RoleMember}
+
+	fake.On("GetUserByUsername", func(args []any) (fakedb.Result, error) {
+		id, ok := users[args[0].(string)]
+		if !ok {
+			return fakedb.Result{}, nil
+		}
+		return fakedb.Row(id, now, now, args[0], "user", "hash", nil, nil), nil
+	})
+	fake.Return("GetGroupByID", fakedb.Row(groupID, "Hikers", nil, nil, nil, users["alice"], now, now, KindGroup))
+	fake.On("GetGroupMember", func(args []any) (fakedb.Result, error) {
+		role, ok := roles[args[1].(string)]
+		if !ok {
+			return fakedb.Result{}, nil
+		}
+		return fakedb.Row(uuid.New(), args[0], args[1], role, now), nil
+	})
+	fake.On("IsGroupMember", func(args []any) (fakedb.Result, error) {
+		_, ok := roles[args[1].(string)]
+		return fakedb.Row(ok), nil
+	})
+
+	gs := NewGroupService(fake.Queries())
+	gs.SetEventPublisher(srv.Client(t))
+	return gs, fake, srv, groupID
+}
+
+func TestCreateInviteLink(t *testing.T) {
+	gs, _, srv, groupID := newLinkService(t)
+	ctx := context.Background()
+
+	_, err := gs.CreateInviteLink(ctx, groupID.String(), "bob", 1, 0)
+	var appErr *apperrors.AppError
+	require.True(t, errors.As(err, &appErr), "got %v", err)
+	assert.Equal(t, http.StatusForbidden, appErr.StatusCode, "members cannot create links")
+
+	link, err := gs.CreateInviteLink(ctx, groupID.String(), "alice", 3, time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, 3, link.Remaining)
+	assert.Equal(t, hashLinkToken(link.Token)[:linkIDLength], link.ID)
+	assert.InDelta(t, time.Hour.Seconds(), srv.TTL(linkKey(link.ID)).Seconds(), 1)
+
+	links, err := gs.InviteLinks(ctx, groupID.String(), "alice")
+	require.NoError(t, err)
+	require.Len(t, links, 1)
+	assert.Empty(t, links[0].Token, "the token is not stored")
+
+	// Expired links drop out of the list
+	srv.FastForward(time.Hour)
+	links, err = gs.InviteLinks(ctx, groupID.String(), "alice")
+	require.NoError(t, err)
+	assert.Empty(t, links)
+}
+
+func TestJoinByLinkRefused(t *testing.T) {
+	gs, fake, _, groupID := newLinkService(t)
+	ctx := context.Background()
+
+	link, err := gs.CreateInviteLink(ctx, groupID.String(), "alice", 1, 0)
+	require.NoError(t, err)
+
+	// Enough refusals to trip the breaker, were they counted as failures
+	for range 12 {
+		_, err := gs.JoinByLink(ctx, "not-a-token", "carol")
+		assert.ErrorIs(t, err, ErrInviteLinkUnavailable)
+	}
+
+	_, err = gs.JoinByLink(ctx, link.Token, "nobody")
+	var appErr *apperrors.AppError
+	require.True(t, errors.As(err, &appErr), "got %v", err)
+	assert.Equal(t, http.StatusNotFound, appErr.StatusCode)
+	assert.Zero(t, gs.cb.Counts().TotalFailures)
+
+	// A member following the link again spends nothing
+	joined, err := gs.JoinByLink(ctx, link.Token, "bob")
+	require.NoError(t, err)
+	assert.Equal(t, groupID.String(), joined)
+	assert.Empty(t, fake.Calls("AddGroupMember"))
+
+	require.NoError(t, gs.RevokeInviteLink(ctx, groupID.String(), "alice", link.ID))
+	_, err = gs.JoinByLink(ctx, link.Token, "carol")
+	assert.ErrorIs(t, err, ErrInviteLinkUnavailable, "revoked")
+	assert.Empty(t, fake.Calls("AddGroupMember"))
+}
@@ -0,0 +1,96 @@
+package groups
+
+import (
+	"context"
+	"encoding/json"
+	"exc6/pkg/logger"
+	"exc6/services/activity"
+	"exc6/services/events"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// MembershipChannelPrefix prefixes the per-user channel carrying membership changes
+const MembershipChannelPrefix = "groups:membership:"
+
+// MembershipChange describes how a user's membership changed
+type MembershipChange string
+
+const (
+	MembershipJoined MembershipChange = "joined"
+	MembershipLeft   MembershipChange = "left"
+)
+
+// MembershipEvent tells a user's live connections that their group set changed
+type MembershipEvent struct {
+	GroupID   string           `json:"group_id"`
+	Username  string           `json:"username"`
+	Change    MembershipChange `json:"change"`
+	Timestamp int64            `json:"timestamp"`
+}
+
+// MembershipChannel returns the channel carrying membership events for a user
+func MembershipChannel(username string) string {
+	return MembershipChannelPrefix + username
+}
+
+// SetEventPublisher enables membership events on Redis Pub/Sub
+func (gs *GroupService) SetEventPublisher(rdb *redis.Client) {
+	gs.rdb = rdb
+}
+
+// SetActivityTracker records membership changes for contact list deltas
+func (gs *GroupService) SetActivityTracker(tracker *activity.Tracker) {
+	gs.activity = tracker
+}
+
+// SetEventLog records membership changes in the groups' event feeds
+func (gs *GroupService) SetEventLog(log *events.Log) {
+	gs.events = log
+}
+
+// publishMembership notifies each user's connections of a membership change (best effort)
+func (gs *GroupService) publishMembership(ctx context.Context, groupID string, change MembershipChange, usernames ...string) {
+	// The group appears in or disappears from these users' contact lists
+	gs.activity.TouchList(ctx, usernames...)
+
+	for _, username := range usernames {
+		gs.events.Append(ctx, events.Group(groupID), events.TypeMembership, username, "", MembershipEvent{
+			GroupID:   groupID,
+			Username:  username,
+			Change:    change,
+			Timestamp: time.Now().Unix(),
+		})
+	}
+
+	if gs.rdb == nil || len(usernames) == 0 {
+		return
+	}
+
+	// The request context may already be close to its deadline
+	pubCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 2*time.Second)
+	defer cancel()
+
+	pipe := gs.rdb.Pipeline()
+	for _, username := range usernames {
+		payload, err := json.Marshal(MembershipEvent{
+			GroupID:   groupID,
+			Username:  username,
+			Change:    change,
+			Timestamp: time.Now().Unix(),
+		})
+		if err != nil {
+			continue
+		}
+		pipe.Publish(pubCtx, MembershipChannel(username), payload)
+	}
+
+	if _, err := pipe.Exec(pubCtx); err != nil {
+		logger.WithFields(map[string]interface{}{
+			"group_id": groupID,
+			"change":   change,
+			"error":    err.Error(),
+		}).Warn("Failed to publish group membership event")
+	}
+}
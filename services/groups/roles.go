@@ -0,0 +1,79 @@
+package groups
+
+// Member roles of a group, from most to least privileged. A regular group
+// has one owner; group DMs have members only.
+const (
+	RoleOwner     = "owner"
+	RoleAdmin     = "admin"
+	RoleModerator = "moderator"
+	RoleMember    = "member"
+)
+
+// roleRanks orders the roles; a role holds every permission of those below it
+var roleRanks = map[string]int{
+	RoleMember:    1,
+	RoleModerator: 2,
+	RoleAdmin:     3,
+	RoleOwner:     4,
+}
+
+// ValidRole reports whether role is a group role
+func ValidRole(role string) bool {
+	return roleRanks[role] > 0
+}
+
+// RoleAtLeast reports whether role ranks at or above min. Non-members,
+// whose role is empty, rank below everyone.
+func RoleAtLeast(role, min string) bool {
+	return ValidRole(role) && roleRanks[role] >= roleRanks[min]
+}
+
+// outranks reports whether role ranks strictly above other
+func outranks(role, other string) bool {
+	return roleRanks[role] > roleRanks[other]
+}
+
+// canRemove reports whether a member with role may remove one with target.
+// Moderators and above remove those below them; anyone may leave on their own.
+func canRemove(role, target string) bool {
+	return RoleAtLeast(role, RoleModerator) && outranks(role, target)
+}
+
+// assignableRoles lists the roles a member with role may give one with
+// target: admins and above change the roles of those below them to roles
+// below their own, and the owner may also hand over ownership
+func assignableRoles(role, target string) []string {
+	if !RoleAtLeast(role, RoleAdmin) || !outranks(role, target) {
+		return nil
+	}
+
+	roles := []string{RoleOwner, RoleAdmin, RoleModerator, RoleMember}
+	assignable := make([]string, 0, len(roles))
+	for _, r := range roles {
+		if outranks(role, r) || (role == RoleOwner && r == RoleOwner) {
+			assignable = append(assignable, r)
+		}
+	}
+	return assignable
+}
+
+// IsAdmin reports whether the user administers the group
+func (g GroupInfo) IsAdmin() bool {
+	return RoleAtLeast(g.UserRole, RoleAdmin)
+}
+
+// IsOwner reports whether the user owns the group
+func (g GroupInfo) IsOwner() bool {
+	return g.UserRole == RoleOwner
+}
+
+// CanRemove reports whether the user may remove a member with role
+func (g GroupInfo) CanRemove(role string) bool {
+	return canRemove(g.UserRole, role)
+}
+
+// AssignableRoles lists the roles the user may give a member with role,
+// including role itself, or nil if they may not change it
+func (g GroupInfo) AssignableRoles(role string) []string {
+	return assignableRoles(g.UserRole, role)
+}
@@ -0,0 +1,31 @@
+package groups
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoleAtLeast(t *testing.T) {
+	assert.True(t, RoleAtLeast(RoleOwner, RoleAdmin))
+	assert.True(t, RoleAtLeast(RoleModerator, RoleModerator))
+	assert.False(t, RoleAtLeast(RoleModerator, RoleAdmin))
+	assert.False(t, RoleAtLeast("", RoleMember), "non-members have no role")
+	assert.False(t, RoleAtLeast("superuser", RoleMember))
+}
+
+func TestCanRemove(t *testing.T) {
+	assert.True(t, canRemove(RoleModerator, RoleMember))
+	assert.True(t, canRemove(RoleOwner, RoleAdmin))
+	assert.False(t, canRemove(RoleModerator, RoleModerator))
+	assert.False(t, canRemove(RoleAdmin, RoleOwner))
+	assert.False(t, canRemove(RoleMember, RoleMember))
+}
+
+func TestAssignableRoles(t *testing.T) {
+	assert.Equal(t, []string{RoleOwner, RoleAdmin, RoleModerator, RoleMember}, assignableRoles(RoleOwner, RoleAdmin))
+	assert.Equal(t, []string{RoleModerator, RoleMember}, assignableRoles(RoleAdmin, RoleMember))
+	assert.Nil(t, assignableRoles(RoleAdmin, RoleAdmin), "only the owner changes admins")
+	assert.Nil(t, assignableRoles(RoleModerator, RoleMember))
+	assert.Nil(t, assignableRoles(RoleOwner, RoleOwner))
+}
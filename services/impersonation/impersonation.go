@@ -0,0 +1,420 @@
+package impersonation
+
+import (
+	"context"
+	"database/sql"
+	"exc6/apperrors"
+	"exc6/db"
+	"exc6/pkg/breaker"
+	"exc6/pkg/instance"
+	"exc6/pkg/keyspace"
+	"exc6/pkg/logger"
+	"exc6/services/notifications"
+	"exc6/services/sessions"
+	"exc6/services/users"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/redis/go-redis/v9"
+	"github.com/sony/gobreaker"
+)
+
+// Support staff can sign in as a user to see what they see, but only with
+// the user's consent and only to look. The user grants support access for a
+// limited time; an admin then starts an impersonation with a reason, which is
+// recorded before the session is issued. The session carries the admin's
+// name, so every log line of it is marked, and it may not change anything.
+// It ends when the admin leaves it or after SessionTTL, whichever is first,
+// and the user is told about it in their inbox once it has ended.
+
+const (
+	// SessionTTL is the longest an impersonation session lasts
+	SessionTTL = 30 * time.Minute
+
+	// ConsentTTL is how long a grant of support access lasts
+	ConsentTTL = 24 * time.Hour
+
+	// MaxReasonLength bounds the reason given for an impersonation
+	MaxReasonLength = 500
+
+	// adminRole is the role that may impersonate and may not be impersonated
+	adminRole = "admin"
+
+	// consentKeyPrefix prefixes the key present while a user grants support
+	// access; it expires with the grant
+	consentKeyPrefix = "support_access:"
+
+	sweepInterval = time.Minute
+	batchSize     = 100
+	listLimit     = 100
+)
+
+var impersonations = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "impersonation_sessions_total",
+		Help: "Impersonation sessions started by admins and how they ended",
+	},
+	[]string{"event"}, // started, ended, expired
+)
+
+func init() {
+	instance.Registerer().MustRegister(impersonations)
+	keyspace.Register(keyspace.Family{Prefix: consentKeyPrefix, Description: "support access granted by users"})
+}
+
+// Consent is a user's grant of support access
+type Consent struct {
+	Username  string    `json:"username"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Record is an entry of the impersonation audit trail
+type Record struct {
+	ID        string     `json:"id"`
+	Admin     string     `json:"admin"`
+	Username  string     `json:"username"`
+	Reason    string     `json:"reason"`
+	StartedAt time.Time  `json:"started_at"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	EndedAt   *time.Time `json:"ended_at,omitempty"`
+
+	// Notified is set once the user was told about the impersonation
+	Notified bool `json:"notified"`
+}
+
+// Service records impersonations and issues their sessions
+type Service struct {
+	qdb   *db.Queries
+	rdb   *redis.Client
+	smngr *sessions.SessionManager
+	usrv  *users.UserService
+
+	// inbox tells users about ended impersonations; may be nil
+	inbox *notifications.NotificationService
+
+	cb  *gobreaker.CircuitBreaker
+	rcb *gobreaker.CircuitBreaker
+}
+
+// NewService creates the service and ends expired impersonations until ctx
+// is cancelled
+func NewService(ctx context.Context, qdb *db.Queries, rdb *redis.Client, smngr *sessions.SessionManager, usrv *users.UserService, inbox *notifications.NotificationService) *Service {
+	s := &Service{
+		qdb:   qdb,
+		rdb:   rdb,
+		smngr: smngr,
+		usrv:  usrv,
+		inbox: inbox,
+		cb: breaker.New(breaker.Config{
+			Name:        "postgres-impersonation",
+			MaxRequests: 5,
+			Interval:    60 * time.Second,
+			Timeout:     30 * time.Second,
+			Threshold:   0.5,
+			MinRequests: 5,
+		}),
+		rcb: breaker.New(breaker.Config{
+			Name:        "redis-impersonation",
+			MaxRequests: 5,
+			Interval:    60 * time.Second,
+			Timeout:     30 * time.Second,
+			Threshold:   0.5,
+			MinRequests: 5,
+		}),
+	}
+
+	go s.run(ctx)
+
+	return s
+}
+
+// Grant lets support staff impersonate username for ConsentTTL
+func (s *Service) Grant(ctx context.Context, username string) (*Consent, error) {
+	expires := time.Now().Add(ConsentTTL).Truncate(time.Second)
+
+	_, err := breaker.ExecuteCtx(ctx, s.rcb, func() (interface{}, error) {
+		return nil, s.rdb.Set(ctx, consentKeyPrefix+username, expires.Unix(), ConsentTTL).Err()
+	})
+	if err != nil {
+		return nil, apperrors.NewCacheError("grant support access", consentKeyPrefix+username, err)
+	}
+
+	logger.WithFields(map[string]any{
+		"username":   username,
+		"expires_at": expires,
+	}).Info("Support access granted")
+
+	return &Consent{Username: username, ExpiresAt: expires}, nil
+}
+
+// Revoke withdraws username's support access. Impersonations already
+// started run their course.
+func (s *Service) Revoke(ctx context.Context, username string) error {
+	_, err := breaker.ExecuteCtx(ctx, s.rcb, func() (interface{}, error) {
+		return nil, s.rdb.Del(ctx, consentKeyPrefix+username).Err()
+	})
+	if err != nil {
+		return apperrors.NewCacheError("revoke support access", consentKeyPrefix+username, err)
+	}
+
+	logger.WithField("username", username).Info("Support access revoked")
+	return nil
+}
+
+// Consent returns username's grant of support access, or nil without one
+func (s *Service) Consent(ctx context.Context, username string) (*Consent, error) {
+	result, err := breaker.ExecuteCtx(ctx, s.rcb, func() (interface{}, error) {
+		return s.rdb.TTL(ctx, consentKeyPrefix+username).Result()
+	})
+	if err != nil {
+		return nil, apperrors.NewCacheError("check support access", consentKeyPrefix+username, err)
+	}
+
+	ttl := result.(time.Duration)
+	if ttl <= 0 {
+		return nil, nil
+	}
+	return &Consent{Username: username, ExpiresAt: time.Now().Add(ttl).Truncate(time.Second)}, nil
+}
+
+// Start records that admin is signing in as username for reason and returns
+// the session to sign in with. It lasts SessionTTL, or until username's
+// consent expires if that is sooner.
+func (s *Service) Start(ctx context.Context, admin, username, reason string) (*sessions.Session, *Record, error) {
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return nil, nil, apperrors.NewValidationError("A reason is required to access a user's account")
+	}
+	if len(reason) > MaxReasonLength {
+		return nil, nil, apperrors.NewValidationError(fmt.Sprintf("The reason must be at most %d characters", MaxReasonLength))
+	}
+	if username == admin {
+		return nil, nil, apperrors.NewValidationError("You cannot impersonate yourself")
+	}
+
+	user, err := s.usrv.GetByUsername(ctx, username)
+	if err != nil {
+		return nil, nil, err
+	}
+	if user.Role == adminRole {
+		return nil, nil, apperrors.NewAuthorizationError(admin, "user:"+username, "impersonate")
+	}
+
+	consent, err := s.Consent(ctx, username)
+	if err != nil {
+		return nil, nil, err
+	}
+	if consent == nil {
+		return nil, nil, apperrors.New(apperrors.ErrCodeUnauthorized, username+" has not granted support access", http.StatusForbidden).
+			WithDetails("username", username)
+	}
+
+	now := time.Now()
+	expires := now.Add(SessionTTL).Truncate(time.Second)
+	if consent.ExpiresAt.Before(expires) {
+		expires = consent.ExpiresAt
+	}
+
+	// The audit record comes first: no record, no session
+	sessionID := uuid.NewString()
+	result, err := breaker.ExecuteCtx(ctx, s.cb, func() (interface{}, error) {
+		row, err := s.qdb.CreateImpersonation(ctx, db.CreateImpersonationParams{
+			Reason:    reason,
+			SessionID: sessionID,
+			ExpiresAt: expires,
+			Username:  username,
+			Admin:     admin,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return row, nil
+	})
+	if err != nil {
+		return nil, nil, apperrors.NewDatabaseError("record impersonation", err)
+	}
+	// Nothing is inserted if either account is gone: sql.ErrNoRows, which
+	// the breaker passes on as no result
+	row, ok := result.(db.Impersonation)
+	if !ok {
+		return nil, nil, apperrors.NewUserNotFound()
+	}
+
+	session := sessions.NewSession(sessionID, user.ID.String(), username, now.Unix(), now.Unix())
+	session.Impersonator = admin
+	session.ExpiresAt = expires.Unix()
+	if err := s.smngr.SaveSession(ctx, session); err != nil {
+		return nil, nil, apperrors.NewSessionError("create impersonation session", sessionID, err)
+	}
+
+	impersonations.WithLabelValues("started").Inc()
+	logger.WithFields(map[string]any{
+		"impersonation_id": row.ID.String(),
+		"impersonator":     admin,
+		"username":         username,
+		"reason":           reason,
+		"expires_at":       expires,
+	}).Warn("Impersonation started")
+
+	return session, recordFrom(row, username), nil
+}
+
+// End ends the impersonation of sessionID, signs its session out and tells
+// the user. Ending one that already ended only signs the session out.
+func (s *Service) End(ctx context.Context, sessionID string) (*Record, error) {
+	if err := s.smngr.DeleteSession(ctx, sessionID); err != nil {
+		return nil, apperrors.NewSessionError("end impersonation session", sessionID, err)
+	}
+
+	result, err := breaker.ExecuteCtx(ctx, s.cb, func() (interface{}, error) {
+		row, err := s.qdb.EndImpersonation(ctx, sessionID)
+		if err != nil {
+			return nil, err
+		}
+		return row, nil
+	})
+	if err != nil {
+		return nil, apperrors.NewDatabaseError("end impersonation", err)
+	}
+	// Unknown and already ended impersonations update nothing
+	row, ok := result.(db.Impersonation)
+	if !ok {
+		return nil, nil
+	}
+
+	impersonations.WithLabelValues("ended").Inc()
+	logger.WithFields(map[string]any{
+		"impersonation_id": row.ID.String(),
+		"impersonator":     row.AdminUsername,
+		"duration":         row.EndedAt.Time.Sub(row.StartedAt).Round(time.Second).String(),
+	}).Warn("Impersonation ended")
+
+	s.notifyEnded(ctx)
+
+	return recordFrom(row, ""), nil
+}
+
+// List returns the latest impersonations, of username when it is set
+func (s *Service) List(ctx context.Context, username string) ([]Record, error) {
+	result, err := breaker.ExecuteCtx(ctx, s.cb, func() (interface{}, error) {
+		return s.qdb.ListImpersonations(ctx, db.ListImpersonationsParams{
+			Username: sql.NullString{String: username, Valid: username != ""},
+			RowLimit: listLimit,
+		})
+	})
+	if err != nil {
+		return nil, apperrors.NewDatabaseError("list impersonations", err)
+	}
+
+	rows, _ := result.([]db.ListImpersonationsRow)
+	records := make([]Record, 0, len(rows))
+	for _, row := range rows {
+		records = append(records, *recordFrom(db.Impersonation{
+			ID:            row.ID,
+			AdminUsername: row.AdminUsername,
+			Reason:        row.Reason,
+			StartedAt:     row.StartedAt,
+			ExpiresAt:     row.ExpiresAt,
+			EndedAt:       row.EndedAt,
+			NotifiedAt:    row.NotifiedAt,
+		}, row.Username))
+	}
+	return records, nil
+}
+
+func (s *Service) run(ctx context.Context) {
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.sweep(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// sweep ends the impersonations that expired and tells their users
+func (s *Service) sweep(ctx context.Context) {
+	result, err := breaker.ExecuteCtx(ctx, s.cb, func() (interface{}, error) {
+		return s.qdb.EndExpiredImpersonations(ctx)
+	})
+	if err != nil {
+		logger.WithError(err).Error("Failed to end expired impersonations")
+		return
+	}
+
+	rows, _ := result.([]db.Impersonation)
+	for _, row := range rows {
+		// Expired sessions are refused already; this frees them
+		s.smngr.DeleteSession(ctx, row.SessionID)
+
+		impersonations.WithLabelValues("expired").Inc()
+		logger.WithFields(map[string]any{
+			"impersonation_id": row.ID.String(),
+			"impersonator":     row.AdminUsername,
+		}).Warn("Impersonation expired")
+	}
+
+	s.notifyEnded(ctx)
+}
+
+// notifyEnded tells users about impersonations of them that ended since they
+// were last told. Those that fail are retried on the next sweep.
+func (s *Service) notifyEnded(ctx context.Context) {
+	if s.inbox == nil {
+		return
+	}
+
+	result, err := breaker.ExecuteCtx(ctx, s.cb, func() (interface{}, error) {
+		return s.qdb.ListUnnotifiedImpersonations(ctx, batchSize)
+	})
+	if err != nil {
+		logger.WithError(err).Error("Failed to list impersonations to notify")
+		return
+	}
+
+	rows, _ := result.([]db.ListUnnotifiedImpersonationsRow)
+	for _, row := range rows {
+		duration := row.EndedAt.Time.Sub(row.StartedAt).Round(time.Second)
+		s.inbox.Notify(ctx, row.Username, notifications.TypeImpersonation, row.AdminUsername,
+			fmt.Sprintf("Support accessed your account for %s: %s", duration, row.Reason),
+			map[string]string{
+				"impersonation_id": row.ID.String(),
+				"reason":           row.Reason,
+				"started_at":       row.StartedAt.UTC().Format(time.RFC3339),
+				"ended_at":         row.EndedAt.Time.UTC().Format(time.RFC3339),
+			})
+
+		if _, err := breaker.ExecuteCtx(ctx, s.cb, func() (interface{}, error) {
+			return nil, s.qdb.MarkImpersonationNotified(ctx, row.ID)
+		}); err != nil {
+			logger.WithFields(map[string]any{
+				"impersonation_id": row.ID.String(),
+				"error":            err.Error(),
+			}).Error("Failed to mark impersonation notified")
+		}
+	}
+}
+
+func recordFrom(row db.Impersonation, username string) *Record {
+	r := &Record{
+		ID:        row.ID.String(),
+		Admin:     row.AdminUsername,
+		Username:  username,
+		Reason:    row.Reason,
+		StartedAt: row.StartedAt,
+		ExpiresAt: row.ExpiresAt,
+		Notified:  row.NotifiedAt.Valid,
+	}
+	if row.EndedAt.Valid {
+		r.EndedAt = &row.EndedAt.Time
+	}
+	return r
+}
@@ -0,0 +1,112 @@
+package impersonation
+
+import (
+	"context"
+	"errors"
+	"exc6/apperrors"
+	"exc6/pkg/cache"
+	"exc6/services/notifications"
+	"exc6/services/sessions"
+	"exc6/services/users"
+	"exc6/tests/fakedb"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newService returns a service over a fake database knowing alice and the
+// admin. Redis is down, so nobody has granted support access.
+func newService(t *testing.T) (*Service, *fakedb.Fake) {
+	fake := fakedb.New(t)
+
+	roles := map[string]string{"alice": "user", "root": adminRole}
+	fake.On("GetUserByUsername", func(args []any) (fakedb.Result, error) {
+		username := args[0].(string)
+		role, ok := roles[username]
+		if !ok {
+			return fakedb.Result{}, nil
+		}
+		now := time.Now()
+		return fakedb.Row(uuid.New(), now, now, username, role, "hash", nil, nil), nil
+	})
+
+	rdb := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", MaxRetries: -1, DialerRetries: 1})
+	t.Cleanup(func() { rdb.Close() })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	inv := cache.NewInvalidator(ctx, rdb)
+	usrv := users.NewUserService(fake.Queries(), inv)
+	inbox := notifications.NewNotificationService(fake.Queries())
+
+	return NewService(ctx, fake.Queries(), rdb, sessions.NewSessionManager(rdb), usrv, inbox), fake
+}
+
+func requireStatus(t *testing.T, err error, status int) {
+	t.Helper()
+	var appErr *apperrors.AppError
+	require.True(t, errors.As(err, &appErr), "got %v", err)
+	assert.Equal(t, status, appErr.StatusCode)
+}
+
+func TestStartRefused(t *testing.T) {
+	s, fake := newService(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		name     string
+		username string
+		reason   string
+		status   int
+	}{
+		{"No reason", "alice", "  ", http.StatusBadRequest},
+		{"Long reason", "alice", strings.Repeat("x", MaxReasonLength+1), http.StatusBadRequest},
+		{"Themselves", "admin", "ticket 42", http.StatusBadRequest},
+		{"Unknown user", "nobody", "ticket 42", http.StatusNotFound},
+		{"Another admin", "root", "ticket 42", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			session, record, err := s.Start(ctx, "admin", tt.username, tt.reason)
+			requireStatus(t, err, tt.status)
+			assert.Nil(t, session)
+			assert.Nil(t, record)
+		})
+	}
+
+	assert.Empty(t, fake.Calls("CreateImpersonation"))
+}
+
+func TestEnd(t *testing.T) {
+	s, fake := newService(t)
+	ctx := context.Background()
+
+	// Unknown or already ended: the session is signed out, nothing else
+	record, err := s.End(ctx, "unknown")
+	require.NoError(t, err)
+	assert.Nil(t, record)
+	assert.Empty(t, fake.Calls("ListUnnotifiedImpersonations"), "nobody is notified")
+
+	started := time.Now().Add(-10 * time.Minute)
+	ended := time.Now()
+	fake.On("EndImpersonation", func(args []any) (fakedb.Result, error) {
+		return fakedb.Row(uuid.New(), uuid.New(), "admin", uuid.New(), "ticket 42", args[0],
+			started, started.Add(SessionTTL), ended, nil), nil
+	})
+
+	record, err = s.End(ctx, "session-1")
+	require.NoError(t, err)
+	require.NotNil(t, record)
+	assert.Equal(t, "admin", record.Admin)
+	require.NotNil(t, record.EndedAt)
+	assert.WithinDuration(t, ended, *record.EndedAt, time.Second)
+	assert.Len(t, fake.Calls("ListUnnotifiedImpersonations"), 1, "the user is told")
+}
@@ -0,0 +1,327 @@
+package maintenance
+
+import (
+	"context"
+	"encoding/json"
+	"exc6/apperrors"
+	"exc6/pkg/breaker"
+	"exc6/pkg/keyspace"
+	"exc6/pkg/logger"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"github.com/sony/gobreaker"
+)
+
+// An admin schedules one maintenance window at a time. The window is kept in
+// Redis so every instance sees it; each instance polls it and announces the
+// countdown to its own clients, so no announcement is relayed between
+// instances. Between the start and the end of the window the instance is in
+// maintenance mode.
+
+const windowKey = "maintenance:window"
+
+// pollInterval is how often each instance reads the window
+const pollInterval = time.Second
+
+// MaxDuration is the longest window that can be scheduled
+const MaxDuration = 24 * time.Hour
+
+func init() {
+	keyspace.Register(keyspace.Family{Prefix: windowKey, Description: "the scheduled maintenance window"})
+}
+
+// State is the stage of a maintenance window an announcement is about
+type State string
+
+const (
+	StateScheduled State = "scheduled" // a window was scheduled
+	StateCountdown State = "countdown" // the window starts soon
+	StateActive    State = "active"    // the window started
+	StateEnded     State = "ended"     // the window is over
+	StateCancelled State = "cancelled" // the window was cancelled before it ended
+)
+
+// Window is a scheduled maintenance window
+type Window struct {
+	ID          string    `json:"id"`
+	StartsAt    time.Time `json:"starts_at"`
+	EndsAt      time.Time `json:"ends_at"`
+	Message     string    `json:"message,omitempty"`
+	ScheduledBy string    `json:"scheduled_by"`
+}
+
+// Announcement tells clients about a maintenance window
+type Announcement struct {
+	State  State  `json:"state"`
+	Window Window `json:"window"`
+
+	// SecondsLeft counts down to the start, or to the end once active
+	SecondsLeft int64 `json:"seconds_left"`
+}
+
+// Notifier delivers announcements to this instance's clients
+type Notifier func(Announcement)
+
+// Service schedules maintenance windows and announces them
+type Service struct {
+	rdb   *redis.Client
+	cb    *gobreaker.CircuitBreaker
+	leads []time.Duration // longest first
+
+	mu        sync.RWMutex
+	current   *Window
+	announced map[time.Duration]bool
+	started   bool
+	notifiers []Notifier
+}
+
+// NewService creates the service and starts polling for windows until ctx
+// is cancelled. The countdown is announced when each of leads is left
+// before a window starts.
+func NewService(ctx context.Context, rdb *redis.Client, leads []time.Duration) *Service {
+	leads = slices.Clone(leads)
+	slices.Sort(leads)
+	slices.Reverse(leads)
+
+	s := &Service{
+		rdb:   rdb,
+		leads: leads,
+		cb: breaker.New(breaker.Config{
+			Name:        "redis-maintenance",
+			MaxRequests: 3,
+			Interval:    60 * time.Second,
+			Timeout:     30 * time.Second,
+			Threshold:   0.5,
+			MinRequests: 5,
+		}),
+	}
+
+	go s.run(ctx)
+
+	return s
+}
+
+// OnAnnouncement registers a notifier for announcements
+func (s *Service) OnAnnouncement(fn Notifier) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.notifiers = append(s.notifiers, fn)
+}
+
+// Schedule stores a window, replacing any scheduled one
+func (s *Service) Schedule(ctx context.Context, startsAt, endsAt time.Time, message, scheduledBy string) (*Window, error) {
+	now := time.Now()
+	if !startsAt.After(now) {
+		return nil, apperrors.NewValidationError("Maintenance must start in the future")
+	}
+	if !endsAt.After(startsAt) {
+		return nil, apperrors.NewValidationError("Maintenance must end after it starts")
+	}
+	if endsAt.Sub(startsAt) > MaxDuration {
+		return nil, apperrors.NewValidationError(fmt.Sprintf("Maintenance cannot last longer than %s", MaxDuration))
+	}
+
+	w := &Window{
+		ID:          uuid.NewString(),
+		StartsAt:    startsAt.UTC(),
+		EndsAt:      endsAt.UTC(),
+		Message:     message,
+		ScheduledBy: scheduledBy,
+	}
+
+	data, err := json.Marshal(w)
+	if err != nil {
+		return nil, err
+	}
+
+	// The key outlives the window briefly so every instance sees it end
+	ttl := time.Until(w.EndsAt) + time.Minute
+	if _, err := breaker.ExecuteCtx(ctx, s.cb, func() (any, error) {
+		return nil, s.rdb.Set(ctx, windowKey, data, ttl).Err()
+	}); err != nil {
+		return nil, apperrors.NewCacheError("maintenance_schedule", windowKey, err)
+	}
+
+	logger.WithFields(map[string]any{
+		"window_id":    w.ID,
+		"starts_at":    w.StartsAt,
+		"ends_at":      w.EndsAt,
+		"scheduled_by": scheduledBy,
+	}).Info("Maintenance scheduled")
+
+	return w, nil
+}
+
+// Cancel removes the scheduled window; an active window ends early
+func (s *Service) Cancel(ctx context.Context) error {
+	if _, err := breaker.ExecuteCtx(ctx, s.cb, func() (any, error) {
+		return nil, s.rdb.Del(ctx, windowKey).Err()
+	}); err != nil {
+		return apperrors.NewCacheError("maintenance_cancel", windowKey, err)
+	}
+	return nil
+}
+
+// Status returns the current window as an announcement, or nil when none is
+// scheduled. The instance is in maintenance mode while its state is active.
+func (s *Service) Status(now time.Time) *Announcement {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.current == nil || !now.Before(s.current.EndsAt) {
+		return nil
+	}
+
+	state := StateScheduled
+	if !now.Before(s.current.StartsAt) {
+		state = StateActive
+	}
+	a := announce(state, *s.current, now)
+	return &a
+}
+
+func (s *Service) run(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.poll(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// poll reads the window and announces what changed since the last poll
+func (s *Service) poll(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, pollInterval)
+	defer cancel()
+
+	result, err := breaker.ExecuteCtx(ctx, s.cb, func() (any, error) {
+		return s.rdb.Get(ctx, windowKey).Bytes()
+	})
+	if err != nil {
+		// Keep the last known window until Redis is back
+		logger.WithError(err).Debug("Failed to read maintenance window")
+		return
+	}
+
+	var w *Window
+	if data, ok := result.([]byte); ok {
+		w = &Window{}
+		if err := json.Unmarshal(data, w); err != nil {
+			logger.WithError(err).Warn("Ignoring malformed maintenance window")
+			w = nil
+		}
+	}
+
+	now := time.Now()
+	announcements := s.advance(w, now)
+
+	if w != nil && !now.Before(w.EndsAt) {
+		if err := s.rdb.Del(ctx, windowKey).Err(); err != nil {
+			logger.WithError(err).Debug("Failed to delete ended maintenance window")
+		}
+	}
+
+	s.mu.RLock()
+	notifiers := s.notifiers
+	s.mu.RUnlock()
+
+	for _, a := range announcements {
+		for _, notify := range notifiers {
+			notify(a)
+		}
+	}
+}
+
+// advance moves the known window to w as seen at now and returns the
+// announcements due
+func (s *Service) advance(w *Window, now time.Time) []Announcement {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var out []Announcement
+
+	if w == nil || (s.current != nil && s.current.ID != w.ID) {
+		if s.current != nil && w == nil {
+			state := StateCancelled
+			if !now.Before(s.current.EndsAt) {
+				state = StateEnded
+			}
+			out = append(out, announce(state, *s.current, now))
+		}
+		s.current = nil
+		if w == nil {
+			return out
+		}
+	}
+
+	if s.current == nil {
+		if !now.Before(w.EndsAt) {
+			return out
+		}
+
+		// Leads already passed when the window is first seen are skipped
+		s.current = w
+		s.announced = make(map[time.Duration]bool, len(s.leads))
+		for _, lead := range s.leads {
+			if !now.Before(w.StartsAt.Add(-lead)) {
+				s.announced[lead] = true
+			}
+		}
+		s.started = !now.Before(w.StartsAt)
+
+		state := StateScheduled
+		if s.started {
+			state = StateActive
+		}
+		return append(out, announce(state, *w, now))
+	}
+
+	// The window itself may have been rescheduled under the same ID
+	s.current = w
+
+	if !s.started {
+		due := false
+		for _, lead := range s.leads {
+			if !s.announced[lead] && !now.Before(w.StartsAt.Add(-lead)) {
+				s.announced[lead] = true
+				due = true
+			}
+		}
+		if !now.Before(w.StartsAt) {
+			s.started = true
+			out = append(out, announce(StateActive, *w, now))
+		} else if due {
+			out = append(out, announce(StateCountdown, *w, now))
+		}
+	}
+
+	if !now.Before(w.EndsAt) {
+		out = append(out, announce(StateEnded, *w, now))
+		s.current = nil
+	}
+
+	return out
+}
+
+func announce(state State, w Window, now time.Time) Announcement {
+	a := Announcement{State: state, Window: w}
+
+	switch state {
+	case StateScheduled, StateCountdown:
+		a.SecondsLeft = int64(w.StartsAt.Sub(now).Round(time.Second) / time.Second)
+	case StateActive:
+		a.SecondsLeft = int64(w.EndsAt.Sub(now).Round(time.Second) / time.Second)
+	}
+
+	return a
+}
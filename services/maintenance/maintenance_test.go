@@ -0,0 +1,77 @@
+package maintenance
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func states(announcements []Announcement) []State {
+	out := make([]State, 0, len(announcements))
+	for _, a := range announcements {
+		out = append(out, a.State)
+	}
+	return out
+}
+
+func TestAdvance(t *testing.T) {
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	w := &Window{ID: "w1", StartsAt: start, EndsAt: start.Add(30 * time.Minute)}
+
+	s := &Service{leads: []time.Duration{10 * time.Minute, 5 * time.Minute, time.Minute}}
+
+	steps := []struct {
+		name   string
+		window *Window
+		at     time.Time
+		want   []State
+	}{
+		{name: "Seen after the first lead", window: w, at: start.Add(-7 * time.Minute), want: []State{StateScheduled}},
+		{name: "Nothing due", window: w, at: start.Add(-6 * time.Minute), want: []State{}},
+		{name: "Five minutes left", window: w, at: start.Add(-5 * time.Minute), want: []State{StateCountdown}},
+		{name: "Same lead once", window: w, at: start.Add(-4 * time.Minute), want: []State{}},
+		{name: "Missed lead with start", window: w, at: start, want: []State{StateActive}},
+		{name: "Still active", window: w, at: start.Add(time.Minute), want: []State{}},
+		{name: "Ended", window: w, at: start.Add(30 * time.Minute), want: []State{StateEnded}},
+		{name: "Key gone after end", window: nil, at: start.Add(31 * time.Minute), want: []State{}},
+	}
+
+	for _, step := range steps {
+		got := s.advance(step.window, step.at)
+		assert.Equal(t, step.want, states(got), step.name)
+	}
+}
+
+func TestAdvanceCancelled(t *testing.T) {
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	w := &Window{ID: "w1", StartsAt: start, EndsAt: start.Add(30 * time.Minute)}
+
+	s := &Service{leads: []time.Duration{time.Minute}}
+
+	s.advance(w, start.Add(-time.Hour))
+	assert.NotNil(t, s.Status(start.Add(-time.Hour)))
+
+	got := s.advance(nil, start.Add(-30*time.Minute))
+	assert.Equal(t, []State{StateCancelled}, states(got))
+	assert.Nil(t, s.Status(start.Add(-30*time.Minute)))
+}
+
+func TestStatus(t *testing.T) {
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	s := &Service{current: &Window{ID: "w1", StartsAt: start, EndsAt: start.Add(30 * time.Minute)}}
+
+	before := s.Status(start.Add(-90 * time.Second))
+	if assert.NotNil(t, before) {
+		assert.Equal(t, StateScheduled, before.State)
+		assert.Equal(t, int64(90), before.SecondsLeft)
+	}
+
+	during := s.Status(start.Add(10 * time.Minute))
+	if assert.NotNil(t, during) {
+		assert.Equal(t, StateActive, during.State)
+		assert.Equal(t, int64(20*60), during.SecondsLeft)
+	}
+
+	assert.Nil(t, s.Status(start.Add(30*time.Minute)))
+}
@@ -0,0 +1,492 @@
+package moderation
+
+import (
+	"context"
+	"exc6/apperrors"
+	"exc6/config"
+	"exc6/db"
+	"exc6/pkg/breaker"
+	"exc6/pkg/cache"
+	"exc6/pkg/logger"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sony/gobreaker"
+)
+
+// Action is something a restricted account may not do
+type Action string
+
+const (
+	ActionCreateGroup      Action = "create_group"
+	ActionMessageNonFriend Action = "message_non_friend"
+	ActionSendAttachment   Action = "send_attachment"
+)
+
+const (
+	// MaxReasonLength bounds the free-text reason of a report
+	MaxReasonLength = 500
+
+	// reportsListLimit is how many reports the admin view shows per account
+	reportsListLimit = 50
+
+	// cacheTTL bounds how stale a restriction or mute seen by another
+	// instance can be if an invalidation event is lost
+	cacheTTL = time.Minute
+)
+
+// Restriction is an active restriction on an account
+type Restriction struct {
+	Username  string    `json:"username"`
+	Reason    string    `json:"reason"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+
+	// Reviewed is set once a moderator upheld the restriction
+	Reviewed bool `json:"reviewed"`
+}
+
+// Report is an abuse report filed against an account
+type Report struct {
+	ID        string    `json:"id"`
+	Reporter  string    `json:"reporter"`
+	Reason    string    `json:"reason"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Policy decides what accounts may do based on abuse reports. When enough
+// distinct users report an account within the report window, the account
+// is restricted until a moderator reviews it or the restriction expires.
+// Reporting a user also mutes their messages for the reporter.
+//
+// Checks fail open: if the database is unavailable nobody is restricted.
+// A nil Policy allows everything.
+type Policy struct {
+	qdb         *db.Queries
+	cb          *gobreaker.CircuitBreaker
+	invalidator *cache.Invalidator
+
+	cfgMu sync.RWMutex
+	cfg   config.ModerationConfig
+
+	// restrictions caches lookups by username; a nil value means unrestricted
+	restrictions *cache.Local[string, *Restriction]
+
+	// mutes caches whether a recipient reported a sender, by muteKey
+	mutes *cache.Local[string, bool]
+}
+
+// NewPolicy creates the moderation policy
+func NewPolicy(qdb *db.Queries, inv *cache.Invalidator, cfg config.ModerationConfig) *Policy {
+	p := &Policy{
+		qdb:          qdb,
+		cfg:          cfg,
+		invalidator:  inv,
+		restrictions: cache.NewLocal[string, *Restriction](10000, cacheTTL),
+		mutes:        cache.NewLocal[string, bool](10000, cacheTTL),
+		cb: breaker.New(breaker.Config{
+			Name:        "postgres-moderation",
+			MaxRequests: 5,
+			Interval:    60 * time.Second,
+			Timeout:     30 * time.Second,
+			Threshold:   0.5,
+			MinRequests: 5,
+		}),
+	}
+
+	inv.OnInvalidate(cache.KindRestriction, func(keys []string) {
+		p.restrictions.Delete(keys...)
+	})
+	inv.OnInvalidate(cache.KindMute, func(keys []string) {
+		p.mutes.Delete(keys...)
+	})
+
+	return p
+}
+
+// SetConfig changes the report threshold, window and restriction duration.
+// Restrictions already in place keep their expiry.
+func (p *Policy) SetConfig(cfg config.ModerationConfig) {
+	p.cfgMu.Lock()
+	defer p.cfgMu.Unlock()
+	p.cfg = cfg
+}
+
+func (p *Policy) config() config.ModerationConfig {
+	p.cfgMu.RLock()
+	defer p.cfgMu.RUnlock()
+	return p.cfg
+}
+
+func muteKey(recipient, sender string) string {
+	return recipient + ":" + sender
+}
+
+// Report files an abuse report and restricts the reported account once the
+// report threshold is reached
+func (p *Policy) Report(ctx context.Context, reporter, reported, reason string) error {
+	reason = strings.TrimSpace(reason)
+	if reporter == reported {
+		return apperrors.NewValidationError("You cannot report yourself")
+	}
+	if len(reason) > MaxReasonLength {
+		return apperrors.NewValidationError(fmt.Sprintf("Reasons are limited to %d characters", MaxReasonLength))
+	}
+
+	reporterUser, err := p.user(ctx, reporter)
+	if err != nil {
+		return err
+	}
+	reportedUser, err := p.user(ctx, reported)
+	if err != nil {
+		return err
+	}
+
+	cfg := p.config()
+	since := time.Now().Add(-cfg.ReportWindow)
+
+	result, err := breaker.ExecuteCtx(ctx, p.cb, func() (interface{}, error) {
+		return p.qdb.HasReportedSince(ctx, db.HasReportedSinceParams{
+			ReporterID: reporterUser.ID,
+			ReportedID: reportedUser.ID,
+			CreatedAt:  since,
+		})
+	})
+	if err != nil {
+		logger.WithError(err).Error("Circuit breaker: Failed to check previous reports")
+		return apperrors.NewDatabaseError("check previous reports", err)
+	}
+	if already, _ := result.(bool); already {
+		return apperrors.New(apperrors.ErrCodeInvalidInput, fmt.Sprintf("You already reported %s", reported), http.StatusConflict)
+	}
+
+	_, err = breaker.ExecuteCtx(ctx, p.cb, func() (interface{}, error) {
+		return p.qdb.CreateAbuseReport(ctx, db.CreateAbuseReportParams{
+			ReporterID: reporterUser.ID,
+			ReportedID: reportedUser.ID,
+			Reason:     reason,
+		})
+	})
+	if err != nil {
+		logger.WithFields(map[string]interface{}{
+			"reporter": reporter,
+			"reported": reported,
+			"error":    err.Error(),
+		}).Error("Circuit breaker: Failed to create abuse report")
+		return apperrors.NewDatabaseError("create abuse report", err)
+	}
+
+	p.invalidate(ctx, cache.KindMute, muteKey(reporter, reported))
+
+	logger.WithFields(map[string]interface{}{
+		"reporter": reporter,
+		"reported": reported,
+	}).Info("Abuse report filed")
+
+	result, err = breaker.ExecuteCtx(ctx, p.cb, func() (interface{}, error) {
+		return p.qdb.CountReportersSince(ctx, db.CountReportersSinceParams{
+			ReportedID: reportedUser.ID,
+			CreatedAt:  since,
+		})
+	})
+	if err != nil {
+		// The next report re-evaluates the threshold
+		logger.WithError(err).Warn("Circuit breaker: Failed to count abuse reports")
+		return nil
+	}
+
+	reporters, _ := result.(int64)
+	if reporters < int64(cfg.ReportThreshold) {
+		return nil
+	}
+
+	result, err = breaker.ExecuteCtx(ctx, p.cb, func() (interface{}, error) {
+		restriction, err := p.qdb.RestrictUser(ctx, db.RestrictUserParams{
+			UserID:    reportedUser.ID,
+			Reason:    fmt.Sprintf("Reported by %d users within %s", reporters, cfg.ReportWindow),
+			ExpiresAt: time.Now().Add(cfg.RestrictionDuration),
+		})
+		if err != nil {
+			return nil, err
+		}
+		return restriction, nil
+	})
+	if err != nil {
+		logger.WithError(err).Error("Circuit breaker: Failed to restrict reported user")
+		return nil
+	}
+
+	// An active restriction is left alone, and the insert returns
+	// sql.ErrNoRows, which the breaker passes on as no result
+	restriction, ok := result.(db.UserRestriction)
+	if !ok {
+		return nil
+	}
+
+	p.invalidate(ctx, cache.KindRestriction, reported)
+
+	logger.WithFields(map[string]interface{}{
+		"username":   reported,
+		"reporters":  reporters,
+		"expires_at": restriction.ExpiresAt,
+	}).Warn("Account restricted after abuse reports")
+
+	return nil
+}
+
+// Check returns an error if username may not perform the action
+func (p *Policy) Check(ctx context.Context, username string, action Action) error {
+	if p == nil {
+		return nil
+	}
+
+	restriction := p.restriction(ctx, username)
+	if restriction == nil {
+		return nil
+	}
+
+	return apperrors.NewRestrictedError(username, string(action), restriction.ExpiresAt)
+}
+
+// CheckMessage returns an error if from may not send a direct message to to.
+// Restricted accounts may still message their friends.
+func (p *Policy) CheckMessage(ctx context.Context, from, to string) error {
+	if p == nil || from == to {
+		return nil
+	}
+
+	restriction := p.restriction(ctx, from)
+	if restriction == nil {
+		return nil
+	}
+
+	result, err := breaker.ExecuteCtx(ctx, p.cb, func() (interface{}, error) {
+		return p.qdb.AreFriends(ctx, db.AreFriendsParams{User1: from, User2: to})
+	})
+	if err != nil {
+		logger.WithError(err).Warn("Circuit breaker: Failed to check friendship for restricted user")
+		return nil
+	}
+	if friends, _ := result.(bool); friends {
+		return nil
+	}
+
+	return apperrors.NewRestrictedError(from, string(ActionMessageNonFriend), restriction.ExpiresAt)
+}
+
+// Muted reports whether recipient reported sender and no longer wants to be
+// notified of their messages
+func (p *Policy) Muted(ctx context.Context, recipient, sender string) bool {
+	if p == nil || recipient == sender {
+		return false
+	}
+
+	key := muteKey(recipient, sender)
+	if muted, ok := p.mutes.Get(key); ok {
+		return muted
+	}
+
+	result, err := breaker.ExecuteCtx(ctx, p.cb, func() (interface{}, error) {
+		return p.qdb.HasReported(ctx, db.HasReportedParams{Reporter: recipient, Reported: sender})
+	})
+	if err != nil {
+		logger.WithError(err).Warn("Circuit breaker: Failed to check conversation mute")
+		return false
+	}
+
+	muted, _ := result.(bool)
+	p.mutes.Set(key, muted)
+
+	return muted
+}
+
+// ListRestrictions returns the active restrictions, those awaiting review first
+func (p *Policy) ListRestrictions(ctx context.Context) ([]Restriction, error) {
+	result, err := breaker.ExecuteCtx(ctx, p.cb, func() (interface{}, error) {
+		return p.qdb.ListActiveRestrictions(ctx)
+	})
+	if err != nil {
+		logger.WithError(err).Error("Circuit breaker: Failed to list restrictions")
+		return nil, apperrors.NewDatabaseError("list restrictions", err)
+	}
+
+	rows, _ := result.([]db.ListActiveRestrictionsRow)
+
+	restrictions := make([]Restriction, 0, len(rows))
+	for _, row := range rows {
+		restrictions = append(restrictions, Restriction{
+			Username:  row.Username,
+			Reason:    row.Reason,
+			CreatedAt: row.CreatedAt,
+			ExpiresAt: row.ExpiresAt,
+			Reviewed:  row.ReviewedAt.Valid,
+		})
+	}
+
+	return restrictions, nil
+}
+
+// ReportsAgainst returns the most recent reports filed against username
+func (p *Policy) ReportsAgainst(ctx context.Context, username string) ([]Report, error) {
+	user, err := p.user(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := breaker.ExecuteCtx(ctx, p.cb, func() (interface{}, error) {
+		return p.qdb.ListReportsAgainst(ctx, db.ListReportsAgainstParams{
+			ReportedID: user.ID,
+			Limit:      reportsListLimit,
+		})
+	})
+	if err != nil {
+		logger.WithError(err).Error("Circuit breaker: Failed to list abuse reports")
+		return nil, apperrors.NewDatabaseError("list abuse reports", err)
+	}
+
+	rows, _ := result.([]db.ListReportsAgainstRow)
+
+	reports := make([]Report, 0, len(rows))
+	for _, row := range rows {
+		reports = append(reports, Report{
+			ID:        row.ID.String(),
+			Reporter:  row.Reporter,
+			Reason:    row.Reason,
+			CreatedAt: row.CreatedAt,
+		})
+	}
+
+	return reports, nil
+}
+
+// Review records a moderator's decision on a restriction. Upholding it keeps
+// the account restricted for another RestrictionDuration; otherwise it is lifted.
+func (p *Policy) Review(ctx context.Context, username, reviewer string, uphold bool) error {
+	user, err := p.user(ctx, username)
+	if err != nil {
+		return err
+	}
+
+	var result interface{}
+	if uphold {
+		reviewerUser, lookupErr := p.user(ctx, reviewer)
+		if lookupErr != nil {
+			return lookupErr
+		}
+
+		result, err = breaker.ExecuteCtx(ctx, p.cb, func() (interface{}, error) {
+			restriction, err := p.qdb.ReviewRestriction(ctx, db.ReviewRestrictionParams{
+				UserID:     user.ID,
+				ExpiresAt:  time.Now().Add(p.config().RestrictionDuration),
+				ReviewedBy: uuid.NullUUID{UUID: reviewerUser.ID, Valid: true},
+			})
+			if err != nil {
+				return nil, err
+			}
+			return restriction, nil
+		})
+	} else {
+		result, err = breaker.ExecuteCtx(ctx, p.cb, func() (interface{}, error) {
+			restriction, err := p.qdb.LiftRestriction(ctx, user.ID)
+			if err != nil {
+				return nil, err
+			}
+			return restriction, nil
+		})
+	}
+	if err != nil {
+		logger.WithFields(map[string]interface{}{
+			"username": username,
+			"uphold":   uphold,
+			"error":    err.Error(),
+		}).Error("Circuit breaker: Failed to review restriction")
+		return apperrors.NewDatabaseError("review restriction", err)
+	}
+
+	// Without an active restriction there is no row, sql.ErrNoRows, which
+	// the breaker passes on as no result
+	if _, ok := result.(db.UserRestriction); !ok {
+		return apperrors.New(apperrors.ErrCodeNotFound, fmt.Sprintf("%s is not restricted", username), http.StatusNotFound)
+	}
+
+	p.invalidate(ctx, cache.KindRestriction, username)
+
+	logger.WithFields(map[string]interface{}{
+		"username": username,
+		"reviewer": reviewer,
+		"uphold":   uphold,
+	}).Info("Restriction reviewed")
+
+	return nil
+}
+
+// restriction returns the active restriction on username, or nil
+func (p *Policy) restriction(ctx context.Context, username string) *Restriction {
+	restriction, ok := p.restrictions.Get(username)
+	if !ok {
+		result, err := breaker.ExecuteCtx(ctx, p.cb, func() (interface{}, error) {
+			row, err := p.qdb.GetActiveRestriction(ctx, username)
+			if err != nil {
+				return nil, err
+			}
+			return row, nil
+		})
+		if err != nil {
+			logger.WithFields(map[string]interface{}{
+				"username": username,
+				"error":    err.Error(),
+			}).Warn("Circuit breaker: Failed to look up restriction")
+			return nil
+		}
+
+		// Unrestricted accounts have no row
+		if row, ok := result.(db.GetActiveRestrictionRow); ok {
+			restriction = &Restriction{
+				Username:  row.Username,
+				Reason:    row.Reason,
+				CreatedAt: row.CreatedAt,
+				ExpiresAt: row.ExpiresAt,
+				Reviewed:  row.ReviewedAt.Valid,
+			}
+		}
+		p.restrictions.Set(username, restriction)
+	}
+
+	if restriction != nil && time.Now().After(restriction.ExpiresAt) {
+		return nil
+	}
+	return restriction
+}
+
+func (p *Policy) user(ctx context.Context, username string) (db.User, error) {
+	result, err := breaker.ExecuteCtx(ctx, p.cb, func() (interface{}, error) {
+		user, err := p.qdb.GetUserByUsername(ctx, username)
+		if err != nil {
+			return nil, err
+		}
+		return user, nil
+	})
+	if err != nil {
+		logger.WithError(err).Error("Circuit breaker: Failed to look up user")
+		return db.User{}, apperrors.NewDatabaseError("get user", err)
+	}
+
+	// Unknown usernames are sql.ErrNoRows, which the breaker passes on as
+	// no result
+	user, ok := result.(db.User)
+	if !ok {
+		return db.User{}, apperrors.NewUserNotFound()
+	}
+	return user, nil
+}
+
+func (p *Policy) invalidate(ctx context.Context, kind cache.Kind, key string) {
+	if err := p.invalidator.Invalidate(ctx, kind, key); err != nil {
+		// Other instances pick the change up when their cached entry expires
+		logger.WithError(err).Warn("Failed to broadcast moderation invalidation")
+	}
+}
@@ -0,0 +1,171 @@
+package moderation
+
+import (
+	"context"
+	"errors"
+	"exc6/apperrors"
+	"exc6/config"
+	"exc6/pkg/cache"
+	"exc6/tests/fakedb"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testConfig = config.ModerationConfig{
+	ReportThreshold:     2,
+	ReportWindow:        24 * time.Hour,
+	RestrictionDuration: 72 * time.Hour,
+}
+
+// newPolicy returns a policy over a fake database knowing the given users,
+// and the restriction invalidations it makes. Redis is down, so those only
+// apply locally.
+func newPolicy(t *testing.T, usernames ...string) (*Policy, *fakedb.Fake, *[]string) {
+	fake := fakedb.New(t)
+
+	ids := make(map[string]uuid.UUID, len(usernames))
+	for _, username := range usernames {
+		ids[username] = uuid.New()
+	}
+	fake.On("GetUserByUsername", func(args []any) (fakedb.Result, error) {
+		username := args[0].(string)
+		id, ok := ids[username]
+		if !ok {
+			return fakedb.Result{}, nil
+		}
+		now := time.Now()
+		return fakedb.Row(id, now, now, username, "user", "hash", nil, nil), nil
+	})
+
+	rdb := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", MaxRetries: -1, DialerRetries: 1})
+	t.Cleanup(func() { rdb.Close() })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	inv := cache.NewInvalidator(ctx, rdb)
+	invalidated := new([]string)
+	inv.OnInvalidate(cache.KindRestriction, func(keys []string) {
+		*invalidated = append(*invalidated, keys...)
+	})
+
+	return NewPolicy(fake.Queries(), inv, testConfig), fake, invalidated
+}
+
+func restrictionRow(userID string) fakedb.Result {
+	now := time.Now()
+	return fakedb.Row(userID, "Reported", now, now.Add(testConfig.RestrictionDuration), nil, nil)
+}
+
+func requireStatus(t *testing.T, err error, status int) {
+	t.Helper()
+	var appErr *apperrors.AppError
+	require.True(t, errors.As(err, &appErr), "got %v", err)
+	assert.Equal(t, status, appErr.StatusCode)
+}
+
+func TestReportUnknownUser(t *testing.T) {
+	p, fake, _ := newPolicy(t, "alice")
+
+	err := p.Report(context.Background(), "alice", "nobody", "spam")
+	requireStatus(t, err, http.StatusNotFound)
+	assert.Empty(t, fake.Calls("CreateAbuseReport"), "no report against a zero user ID")
+}
+
+func TestReportRestrictsAtThreshold(t *testing.T) {
+	p, fake, invalidated := newPolicy(t, "alice", "mallory")
+	ctx := context.Background()
+
+	fake.Return("HasReportedSince", fakedb.Row(false))
+	fake.Return("CountReportersSince", fakedb.Row(int64(1)))
+
+	require.NoError(t, p.Report(ctx, "alice", "mallory", "spam"))
+	assert.Len(t, fake.Calls("CreateAbuseReport"), 1)
+	assert.Empty(t, fake.Calls("RestrictUser"), "below the threshold")
+
+	fake.Return("CountReportersSince", fakedb.Row(int64(2)))
+	fake.On("RestrictUser", func(args []any) (fakedb.Result, error) {
+		return restrictionRow(args[0].(string)), nil
+	})
+
+	require.NoError(t, p.Report(ctx, "alice", "mallory", "spam"))
+	assert.Len(t, fake.Calls("RestrictUser"), 1)
+	assert.Equal(t, []string{"mallory"}, *invalidated)
+}
+
+func TestReportAlreadyRestricted(t *testing.T) {
+	p, fake, invalidated := newPolicy(t, "alice", "mallory")
+
+	fake.Return("HasReportedSince", fakedb.Row(false))
+	fake.Return("CountReportersSince", fakedb.Row(int64(5)))
+	// The upsert leaves an active restriction alone and returns no row
+
+	require.NoError(t, p.Report(context.Background(), "alice", "mallory", "spam"))
+	assert.Len(t, fake.Calls("RestrictUser"), 1)
+	assert.Empty(t, *invalidated, "an unchanged restriction is not invalidated")
+}
+
+func TestReportTwice(t *testing.T) {
+	p, fake, _ := newPolicy(t, "alice", "mallory")
+
+	fake.Return("HasReportedSince", fakedb.Row(true))
+
+	err := p.Report(context.Background(), "alice", "mallory", "spam")
+	requireStatus(t, err, http.StatusConflict)
+	assert.Empty(t, fake.Calls("CreateAbuseReport"))
+}
+
+func TestReview(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Uphold", func(t *testing.T) {
+		p, fake, invalidated := newPolicy(t, "mallory", "admin")
+		fake.On("ReviewRestriction", func(args []any) (fakedb.Result, error) {
+			return restrictionRow(args[0].(string)), nil
+		})
+
+		require.NoError(t, p.Review(ctx, "mallory", "admin", true))
+		assert.Equal(t, []string{"mallory"}, *invalidated)
+	})
+
+	t.Run("Lift", func(t *testing.T) {
+		p, fake, invalidated := newPolicy(t, "mallory", "admin")
+		fake.On("LiftRestriction", func(args []any) (fakedb.Result, error) {
+			return restrictionRow(args[0].(string)), nil
+		})
+
+		require.NoError(t, p.Review(ctx, "mallory", "admin", false))
+		assert.Equal(t, []string{"mallory"}, *invalidated)
+	})
+
+	for _, uphold := range []bool{true, false} {
+		p, _, invalidated := newPolicy(t, "bob", "admin")
+
+		err := p.Review(ctx, "bob", "admin", uphold)
+		requireStatus(t, err, http.StatusNotFound)
+		assert.Empty(t, *invalidated, "uphold=%v of an unrestricted user", uphold)
+	}
+
+	p, _, _ := newPolicy(t, "admin")
+	requireStatus(t, p.Review(ctx, "nobody", "admin", true), http.StatusNotFound)
+}
+
+func TestCheck(t *testing.T) {
+	p, fake, _ := newPolicy(t)
+	ctx := context.Background()
+
+	assert.NoError(t, p.Check(ctx, "bob", ActionCreateGroup), "no restriction row")
+
+	now := time.Now()
+	fake.Return("GetActiveRestriction", fakedb.Row(uuid.New(), "mallory", "Reported", now, now.Add(time.Hour), nil))
+
+	err := p.Check(ctx, "mallory", ActionCreateGroup)
+	require.Error(t, err)
+	assert.NoError(t, p.Check(ctx, "bob", ActionCreateGroup), "bob's lookup is cached")
+}
@@ -0,0 +1,454 @@
+package notifications
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"exc6/apperrors"
+	"exc6/db"
+	"exc6/pkg/breaker"
+	"exc6/pkg/logger"
+	"exc6/pkg/pagination"
+	"net/http"
+	"regexp"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/sony/gobreaker"
+)
+
+// Notifications go through the NotificationService, which keeps the ones a
+// user may want to come back to in their inbox and delivers every one live:
+// to the notification stream, and for inbox entries to the user's WebSocket
+// connections along with their unread count.
+
+// Notification types
+const (
+	TypeFriendRequest = "friend_request"
+	TypeFriendAccept  = "friend_accept"
+	TypeGroupInvite   = "group_invite"
+	TypeGroupJoin     = "group_join"
+	TypeMention       = "mention"
+	TypeMissedCall    = "missed_call"
+
+	// TypeImpersonation tells a user that support staff used their account
+	TypeImpersonation = "impersonation"
+
+	// TypeCall is an incoming call. It is only delivered live; the inbox
+	// gets a missed call if it goes unanswered.
+	TypeCall = "call"
+)
+
+// PriorityHigh marks notifications delivered even in muted conversations
+const PriorityHigh = "high"
+
+// inboxTypes are the notification types kept in the inbox
+var inboxTypes = map[string]bool{
+	TypeFriendRequest: true,
+	TypeFriendAccept:  true,
+	TypeGroupInvite:   true,
+	TypeGroupJoin:     true,
+	TypeMention:       true,
+	TypeMissedCall:    true,
+	TypeImpersonation: true,
+}
+
+const (
+	// MaxMentions is the most users notified of a single message
+	MaxMentions = 20
+
+	// mentionPreview is the length in runes of the message quoted in a
+	// mention
+	mentionPreview = 140
+)
+
+// mentionPattern matches @username where it is not part of a longer word
+var mentionPattern = regexp.MustCompile(`(?:^|[^a-zA-Z0-9_-])@([a-zA-Z0-9_-]+)`)
+
+// Notification is an inbox entry, or a live-only notification
+type Notification struct {
+	ID        string            `json:"id,omitempty"`
+	Type      string            `json:"type"`
+	From      string            `json:"from"`
+	Content   string            `json:"content"`
+	Data      map[string]string `json:"data,omitempty"`
+	Timestamp int64             `json:"timestamp"` // unix seconds
+	Read      bool              `json:"read"`
+	Priority  string            `json:"priority,omitempty"`
+
+	// createdAt orders inbox entries more finely than Timestamp
+	createdAt time.Time
+}
+
+// Cursor orders notifications by time
+func (n Notification) Cursor() pagination.Cursor {
+	return pagination.Cursor{Key: pagination.TimeKey(n.createdAt), ID: n.ID}
+}
+
+// CreatedAt is when an inbox entry was recorded
+func (n Notification) CreatedAt() time.Time {
+	return n.createdAt
+}
+
+// Filter narrows a listing of the inbox
+type Filter struct {
+	// UnreadOnly lists only unread notifications
+	UnreadOnly bool
+
+	// Type lists only notifications of one type; empty lists every type
+	Type string
+}
+
+// Inbox is a page of a user's notifications with their unread count
+type Inbox struct {
+	pagination.Page[Notification]
+	Unread int64 `json:"unread"`
+}
+
+// Pusher delivers new inbox entries to a user's open connections
+type Pusher interface {
+	PushNotification(username string, n *Notification, unread int64)
+}
+
+// Stream publishes events to a user's notification stream
+type Stream interface {
+	PublishAsync(topic, eventType string, data any)
+}
+
+// NotificationService records notifications and delivers them live. A nil
+// NotificationService notifies no one.
+type NotificationService struct {
+	qdb    *db.Queries
+	cb     *gobreaker.CircuitBreaker
+	pusher Pusher
+	stream Stream
+}
+
+// NewNotificationService creates the notification service
+func NewNotificationService(qdb *db.Queries) *NotificationService {
+	return &NotificationService{
+		qdb: qdb,
+		cb: breaker.New(breaker.Config{
+			Name:        "postgres-inbox",
+			MaxRequests: 5,
+			Interval:    60 * time.Second,
+			Timeout:     30 * time.Second,
+			Threshold:   0.5,
+			MinRequests: 5,
+		}),
+	}
+}
+
+// SetPusher sets where new inbox entries are pushed
+func (ns *NotificationService) SetPusher(pusher Pusher) {
+	ns.pusher = pusher
+}
+
+// SetStream sets the notification stream every notification is published to
+func (ns *NotificationService) SetStream(stream Stream) {
+	ns.stream = stream
+}
+
+// Notify notifies the user to of something done by from, keeping it in their
+// inbox unless the type is live-only. What caused it already happened, so failures
+// are only logged.
+func (ns *NotificationService) Notify(ctx context.Context, to, notificationType, from, content string, data map[string]string) {
+	if ns == nil {
+		return
+	}
+
+	n := &Notification{
+		Type:      notificationType,
+		From:      from,
+		Content:   content,
+		Data:      data,
+		Timestamp: time.Now().Unix(),
+	}
+
+	if inboxTypes[notificationType] {
+		payload, err := encodeData(data)
+		if err != nil {
+			return
+		}
+
+		result, err := breaker.ExecuteCtx(ctx, ns.cb, func() (any, error) {
+			return ns.qdb.CreateNotification(ctx, db.CreateNotificationParams{
+				Type:     notificationType,
+				Content:  content,
+				Data:     payload,
+				Actor:    from,
+				Username: to,
+			})
+		})
+		if err != nil {
+			logger.WithFields(map[string]any{
+				"to":    to,
+				"type":  notificationType,
+				"error": err.Error(),
+			}).Warn("Circuit breaker: Failed to record notification")
+		} else {
+			row, _ := result.(db.CreateNotificationRow)
+			n.ID, n.Timestamp = row.ID.String(), row.CreatedAt.Unix()
+			ns.push(ctx, to, n)
+		}
+	}
+
+	if ns.stream != nil {
+		ns.stream.PublishAsync(to, notificationType, n)
+	}
+}
+
+// NotifyMentions notifies the members of groupID that a message by from
+// mentions. Mentions are high priority: they reach users who muted the group.
+func (ns *NotificationService) NotifyMentions(ctx context.Context, from, groupID, messageID, content string, usernames []string) {
+	if ns == nil {
+		return
+	}
+
+	if len(usernames) == 0 {
+		return
+	}
+	groupUUID, err := uuid.Parse(groupID)
+	if err != nil {
+		return
+	}
+
+	data := map[string]string{"group_id": groupID, "message_id": messageID}
+	payload, err := encodeData(data)
+	if err != nil {
+		return
+	}
+	preview := truncate(content, mentionPreview)
+
+	result, err := breaker.ExecuteCtx(ctx, ns.cb, func() (any, error) {
+		return ns.qdb.CreateMentionNotifications(ctx, db.CreateMentionNotificationsParams{
+			Content:   preview,
+			Data:      payload,
+			GroupID:   groupUUID,
+			Actor:     from,
+			Usernames: usernames,
+		})
+	})
+	if err != nil {
+		logger.WithFields(map[string]any{
+			"group_id": groupID,
+			"error":    err.Error(),
+		}).Warn("Circuit breaker: Failed to record mentions")
+		return
+	}
+
+	rows, _ := result.([]db.CreateMentionNotificationsRow)
+	for _, row := range rows {
+		n := &Notification{
+			ID:        row.ID.String(),
+			Type:      TypeMention,
+			From:      from,
+			Content:   preview,
+			Data:      data,
+			Timestamp: row.CreatedAt.Unix(),
+			Priority:  PriorityHigh,
+		}
+		ns.push(ctx, row.Username, n)
+		if ns.stream != nil {
+			ns.stream.PublishAsync(row.Username, TypeMention, n)
+		}
+	}
+}
+
+// push delivers a new inbox entry with the user's unread count
+func (ns *NotificationService) push(ctx context.Context, username string, n *Notification) {
+	if ns.pusher == nil {
+		return
+	}
+
+	unread, err := ns.UnreadCount(ctx, username)
+	if err != nil {
+		logger.WithError(err).Warn("Failed to count unread notifications")
+		return
+	}
+	ns.pusher.PushNotification(username, n, unread)
+}
+
+// List returns a page of username's inbox matching filter, newest first,
+// with the unread count of the notifications it lists
+func (ns *NotificationService) List(ctx context.Context, username string, page pagination.Params, filter Filter) (*Inbox, error) {
+	params := db.ListNotificationsParams{
+		Username:   username,
+		UnreadOnly: filter.UnreadOnly,
+		Type:       sql.NullString{String: filter.Type, Valid: filter.Type != ""},
+		RowLimit:   int32(pagination.ClampLimit(page.Limit) + 1),
+	}
+	if page.After != nil {
+		nanos, err := pagination.ParseIntKey(page.After.Key)
+		if err != nil {
+			return nil, apperrors.NewValidationError("Invalid pagination cursor")
+		}
+		id, err := uuid.Parse(page.After.ID)
+		if err != nil {
+			return nil, apperrors.NewValidationError("Invalid pagination cursor")
+		}
+		params.BeforeAt = sql.NullTime{Time: time.Unix(0, nanos), Valid: true}
+		params.BeforeID = id
+	}
+
+	result, err := breaker.ExecuteCtx(ctx, ns.cb, func() (any, error) {
+		return ns.qdb.ListNotifications(ctx, params)
+	})
+	if err != nil {
+		return nil, apperrors.NewDatabaseError("list notifications", err)
+	}
+
+	rows, _ := result.([]db.ListNotificationsRow)
+	items := make([]Notification, 0, len(rows))
+	for _, row := range rows {
+		items = append(items, notificationFromRow(row))
+	}
+
+	unread, err := ns.unreadCount(ctx, username, filter.Type)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Inbox{Page: pagination.New(items, page, Notification.Cursor), Unread: unread}, nil
+}
+
+// Between returns up to limit of username's inbox entries recorded after
+// after and before before, oldest first
+func (ns *NotificationService) Between(ctx context.Context, username string, after, before time.Time, limit int) ([]Notification, error) {
+	result, err := breaker.ExecuteCtx(ctx, ns.cb, func() (any, error) {
+		return ns.qdb.ListNotificationsBetween(ctx, db.ListNotificationsBetweenParams{
+			Username: username,
+			AfterAt:  after,
+			BeforeAt: before,
+			RowLimit: int32(limit),
+		})
+	})
+	if err != nil {
+		return nil, apperrors.NewDatabaseError("list notifications", err)
+	}
+
+	rows, _ := result.([]db.ListNotificationsBetweenRow)
+	items := make([]Notification, 0, len(rows))
+	for _, row := range rows {
+		items = append(items, notificationFromRow(db.ListNotificationsRow(row)))
+	}
+	return items, nil
+}
+
+// notificationFromRow converts an inbox entry
+func notificationFromRow(row db.ListNotificationsRow) Notification {
+	n := Notification{
+		ID:        row.ID.String(),
+		Type:      row.Type,
+		From:      row.Actor.String,
+		Content:   row.Content,
+		Timestamp: row.CreatedAt.Unix(),
+		Read:      row.ReadAt.Valid,
+		createdAt: row.CreatedAt,
+	}
+	if row.Type == TypeMention {
+		n.Priority = PriorityHigh
+	}
+	if err := json.Unmarshal(row.Data, &n.Data); err != nil {
+		n.Data = nil
+	}
+	return n
+}
+
+// UnreadCount returns the number of unread notifications in username's inbox
+func (ns *NotificationService) UnreadCount(ctx context.Context, username string) (int64, error) {
+	return ns.unreadCount(ctx, username, "")
+}
+
+// unreadCount counts username's unread notifications of one type, or of
+// every type when notificationType is empty
+func (ns *NotificationService) unreadCount(ctx context.Context, username, notificationType string) (int64, error) {
+	result, err := breaker.ExecuteCtx(ctx, ns.cb, func() (any, error) {
+		return ns.qdb.CountUnreadNotifications(ctx, db.CountUnreadNotificationsParams{
+			Username: username,
+			Type:     sql.NullString{String: notificationType, Valid: notificationType != ""},
+		})
+	})
+	if err != nil {
+		return 0, apperrors.NewDatabaseError("count unread notifications", err)
+	}
+
+	unread, _ := result.(int64)
+	return unread, nil
+}
+
+// MarkRead marks one of username's notifications as read
+func (ns *NotificationService) MarkRead(ctx context.Context, username, id string) error {
+	notificationID, err := uuid.Parse(id)
+	if err != nil {
+		return apperrors.NewBadRequest("Invalid notification ID")
+	}
+
+	result, err := breaker.ExecuteCtx(ctx, ns.cb, func() (any, error) {
+		return ns.qdb.MarkNotificationRead(ctx, db.MarkNotificationReadParams{ID: notificationID, Username: username})
+	})
+	if err != nil {
+		return apperrors.NewDatabaseError("mark notification read", err)
+	}
+	if rows, _ := result.(int64); rows == 0 {
+		return apperrors.New(apperrors.ErrCodeNotFound, "Notification not found", http.StatusNotFound)
+	}
+	return nil
+}
+
+// MarkAllRead marks every notification in username's inbox as read
+func (ns *NotificationService) MarkAllRead(ctx context.Context, username string) error {
+	if ns == nil {
+		return nil
+	}
+
+	if _, err := breaker.ExecuteCtx(ctx, ns.cb, func() (any, error) {
+		return ns.qdb.MarkAllNotificationsRead(ctx, username)
+	}); err != nil {
+		return apperrors.NewDatabaseError("mark notifications read", err)
+	}
+	return nil
+}
+
+// Mentions returns the distinct usernames mentioned with @username in
+// content, at most MaxMentions
+func Mentions(content string) []string {
+	var usernames []string
+	seen := make(map[string]bool)
+
+	for _, match := range mentionPattern.FindAllStringSubmatch(content, -1) {
+		username := match[1]
+		if seen[username] {
+			continue
+		}
+		seen[username] = true
+		usernames = append(usernames, username)
+
+		if len(usernames) == MaxMentions {
+			break
+		}
+	}
+	return usernames
+}
+
+// encodeData encodes a notification's data for storage
+func encodeData(data map[string]string) (json.RawMessage, error) {
+	if data == nil {
+		return json.RawMessage("{}"), nil
+	}
+	payload, err := json.Marshal(data)
+	if err != nil {
+		logger.WithError(err).Warn("Failed to encode notification data")
+		return nil, err
+	}
+	return payload, nil
+}
+
+// truncate shortens s to at most n runes
+func truncate(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n-1]) + "…"
+}
@@ -0,0 +1,52 @@
+package notifications
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMentions(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []string
+	}{
+		{name: "None", content: "hello there", want: nil},
+		{name: "Start and middle", content: "@alice ask @bob-2 about it", want: []string{"alice", "bob-2"}},
+		{name: "Punctuation ends the name", content: "thanks @carol!", want: []string{"carol"}},
+		{name: "Repeated once", content: "@dave @dave", want: []string{"dave"}},
+		{name: "Email addresses are not mentions", content: "mail erin@example.com", want: nil},
+		{name: "Bare at sign", content: "meet @ noon", want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, Mentions(tt.content))
+		})
+	}
+}
+
+func TestMentionsCapped(t *testing.T) {
+	var b strings.Builder
+	for i := range MaxMentions + 5 {
+		fmt.Fprintf(&b, "@user%d ", i)
+	}
+
+	assert.Len(t, Mentions(b.String()), MaxMentions)
+}
+
+func TestTruncate(t *testing.T) {
+	assert.Equal(t, "short", truncate("short", 10))
+	assert.Equal(t, "héll…", truncate("héllo world", 5))
+}
+
+func TestNilNotificationServiceNotifiesNoOne(t *testing.T) {
+	var ns *NotificationService
+	ns.Notify(context.Background(), "bob", TypeFriendRequest, "alice", "New friend request", nil)
+	ns.NotifyMentions(context.Background(), "alice", "g1", "m1", "@bob hi", []string{"bob"})
+	assert.NoError(t, ns.MarkAllRead(context.Background(), "bob"))
+}
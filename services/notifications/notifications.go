@@ -0,0 +1,241 @@
+package notifications
+
+import (
+	"context"
+	"database/sql"
+	"exc6/apperrors"
+	"exc6/db"
+	"exc6/pkg/breaker"
+	"exc6/pkg/cache"
+	"exc6/pkg/logger"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sony/gobreaker"
+)
+
+// Users mute a direct conversation or a group for a while or until they
+// unmute it. Messages in a muted conversation are still delivered but do not
+// notify: they are not counted as unread, live messages are flagged as muted
+// and notifications about the conversation are left out of the stream.
+//
+// Lookups fail open: if the database is unavailable nothing is muted.
+
+// MaxMuteDuration is the longest timed mute; longer mutes last until unmuted
+const MaxMuteDuration = 365 * 24 * time.Hour
+
+// cacheTTL bounds how stale a user's cached mutes can get on an instance
+// that missed an invalidation
+const cacheTTL = time.Minute
+
+// Mute is a muted conversation: a contact or a group
+type Mute struct {
+	Contact   string     `json:"contact,omitempty"`
+	GroupID   string     `json:"group_id,omitempty"`
+	GroupName string     `json:"group_name,omitempty"`
+	Until     *time.Time `json:"until,omitempty"` // nil until unmuted
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// Service manages conversation mutes. A nil Service mutes nothing.
+type Service struct {
+	qdb         *db.Queries
+	cb          *gobreaker.CircuitBreaker
+	invalidator *cache.Invalidator
+
+	// mutes caches each user's active mutes by conversationKey
+	mutes *cache.Local[string, map[string]*time.Time]
+}
+
+// NewService creates the mute service
+func NewService(qdb *db.Queries, inv *cache.Invalidator) *Service {
+	s := &Service{
+		qdb:         qdb,
+		invalidator: inv,
+		mutes:       cache.NewLocal[string, map[string]*time.Time](10000, cacheTTL),
+		cb: breaker.New(breaker.Config{
+			Name:        "postgres-notifications",
+			MaxRequests: 5,
+			Interval:    60 * time.Second,
+			Timeout:     30 * time.Second,
+			Threshold:   0.5,
+			MinRequests: 5,
+		}),
+	}
+
+	inv.OnInvalidate(cache.KindConversationMutes, func(keys []string) {
+		s.mutes.Delete(keys...)
+	})
+
+	return s
+}
+
+// Mute mutes username's conversation with contact, or groupID when set, for
+// duration, or until unmuted when it is zero
+func (s *Service) Mute(ctx context.Context, username, contact, groupID string, duration time.Duration) (*Mute, error) {
+	if duration < 0 || duration > MaxMuteDuration {
+		return nil, apperrors.NewValidationError(fmt.Sprintf("Duration must be at most %s; omit it to mute until unmuted", MaxMuteDuration))
+	}
+
+	now := time.Now()
+	mute := &Mute{Contact: contact, GroupID: groupID, CreatedAt: now}
+	until := sql.NullTime{}
+	if duration > 0 {
+		until = sql.NullTime{Time: now.Add(duration), Valid: true}
+		mute.Until = &until.Time
+	}
+
+	result, err := s.change(ctx, username, contact, groupID,
+		func(groupUUID uuid.UUID) (int64, error) {
+			return s.qdb.MuteGroup(ctx, db.MuteGroupParams{MutedUntil: until, GroupID: groupUUID, Username: username})
+		},
+		func() (int64, error) {
+			return s.qdb.MuteContact(ctx, db.MuteContactParams{MutedUntil: until, Username: username, Contact: contact})
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	if result == 0 {
+		if groupID != "" {
+			return nil, apperrors.New(apperrors.ErrCodeUnauthorized, "You are not a member of this group", http.StatusForbidden)
+		}
+		return nil, apperrors.NewUserNotFound()
+	}
+
+	logger.WithFields(map[string]any{
+		"username": username,
+		"contact":  contact,
+		"group_id": groupID,
+		"duration": duration.String(),
+	}).Info("Conversation muted")
+
+	return mute, nil
+}
+
+// Unmute lifts username's mute of their conversation with contact, or of
+// groupID when set
+func (s *Service) Unmute(ctx context.Context, username, contact, groupID string) error {
+	result, err := s.change(ctx, username, contact, groupID,
+		func(groupUUID uuid.UUID) (int64, error) {
+			return s.qdb.UnmuteGroup(ctx, db.UnmuteGroupParams{GroupID: groupUUID, Username: username})
+		},
+		func() (int64, error) {
+			return s.qdb.UnmuteContact(ctx, db.UnmuteContactParams{Username: username, Contact: contact})
+		},
+	)
+	if err != nil {
+		return err
+	}
+	if result == 0 {
+		return apperrors.New(apperrors.ErrCodeNotFound, "This conversation is not muted", http.StatusNotFound)
+	}
+
+	logger.WithFields(map[string]any{
+		"username": username,
+		"contact":  contact,
+		"group_id": groupID,
+	}).Info("Conversation unmuted")
+
+	return nil
+}
+
+// change runs the group or contact variant of a mute update and drops
+// username's cached mutes on every instance
+func (s *Service) change(ctx context.Context, username, contact, groupID string, group func(uuid.UUID) (int64, error), direct func() (int64, error)) (int64, error) {
+	if (contact == "") == (groupID == "") || contact == username {
+		return 0, apperrors.NewBadRequest("Either a contact or a group is required")
+	}
+
+	var groupUUID uuid.UUID
+	if groupID != "" {
+		var err error
+		if groupUUID, err = uuid.Parse(groupID); err != nil {
+			return 0, apperrors.NewBadRequest("Invalid group ID")
+		}
+	}
+
+	result, err := breaker.ExecuteCtx(ctx, s.cb, func() (any, error) {
+		if groupID != "" {
+			return group(groupUUID)
+		}
+		return direct()
+	})
+	if err != nil {
+		return 0, apperrors.NewDatabaseError("update conversation mute", err)
+	}
+
+	s.mutes.Delete(username)
+	if err := s.invalidator.Invalidate(ctx, cache.KindConversationMutes, username); err != nil {
+		// Other instances pick the change up when their cached entry expires
+		logger.WithError(err).Warn("Failed to broadcast conversation mute invalidation")
+	}
+
+	rows, _ := result.(int64)
+	return rows, nil
+}
+
+// List returns username's muted conversations, newest first
+func (s *Service) List(ctx context.Context, username string) ([]Mute, error) {
+	result, err := breaker.ExecuteCtx(ctx, s.cb, func() (any, error) {
+		return s.qdb.ListMutes(ctx, username)
+	})
+	if err != nil {
+		return nil, apperrors.NewDatabaseError("list conversation mutes", err)
+	}
+
+	rows, _ := result.([]db.ListMutesRow)
+	mutes := make([]Mute, 0, len(rows))
+	for _, row := range rows {
+		mute := Mute{
+			Contact:   row.Contact.String,
+			GroupName: row.GroupName.String,
+			CreatedAt: row.CreatedAt,
+		}
+		if row.GroupID.Valid {
+			mute.GroupID = row.GroupID.UUID.String()
+		}
+		if row.MutedUntil.Valid {
+			until := row.MutedUntil.Time
+			mute.Until = &until
+		}
+		mutes = append(mutes, mute)
+	}
+	return mutes, nil
+}
+
+// Muted reports whether username muted their conversation with contact, or
+// groupID when set
+func (s *Service) Muted(ctx context.Context, username, contact, groupID string) bool {
+	if s == nil || username == "" {
+		return false
+	}
+
+	mutes, ok := s.mutes.Get(username)
+	if !ok {
+		list, err := s.List(ctx, username)
+		if err != nil {
+			logger.WithError(err).Warn("Failed to check conversation mutes")
+			return false
+		}
+
+		mutes = make(map[string]*time.Time, len(list))
+		for _, mute := range list {
+			mutes[conversationKey(mute.Contact, mute.GroupID)] = mute.Until
+		}
+		s.mutes.Set(username, mutes)
+	}
+
+	until, muted := mutes[conversationKey(contact, groupID)]
+	return muted && (until == nil || time.Now().Before(*until))
+}
+
+// conversationKey identifies a conversation in a user's cached mutes
+func conversationKey(contact, groupID string) string {
+	if groupID != "" {
+		return "group:" + groupID
+	}
+	return "user:" + contact
+}
@@ -0,0 +1,22 @@
+package notifications
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConversationKey(t *testing.T) {
+	assert.Equal(t, "user:alice", conversationKey("alice", ""))
+	assert.Equal(t, "group:g1", conversationKey("", "g1"))
+	// A group takes precedence over a contact
+	assert.Equal(t, "group:g1", conversationKey("alice", "g1"))
+	assert.NotEqual(t, conversationKey("g1", ""), conversationKey("", "g1"))
+}
+
+func TestNilServiceMutesNothing(t *testing.T) {
+	var s *Service
+	assert.False(t, s.Muted(context.Background(), "alice", "bob", ""))
+	assert.False(t, s.Muted(context.Background(), "alice", "", "g1"))
+}
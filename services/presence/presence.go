@@ -0,0 +1,540 @@
+package presence
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"exc6/apperrors"
+	"exc6/pkg/breaker"
+	"exc6/pkg/instance"
+	"exc6/pkg/keyspace"
+	"exc6/pkg/logger"
+	"exc6/services/friends"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/sony/gobreaker"
+)
+
+// A user is online while any instance holds a WebSocket connection for them.
+// Each instance records its sessions in Redis with an expiry it pushes
+// forward on every heartbeat, so the users of an instance that dies go
+// offline once their sessions expire rather than staying online forever. The
+// instance that takes a user online or offline publishes the change once,
+// and every instance forwards it to the user's friends connected there.
+
+const (
+	// onlineKey is a sorted set of online users scored by when they expire
+	// (unix seconds)
+	onlineKey = "presence:online"
+
+	// sessionsKeyPrefix prefixes a user's instances with a connection,
+	// scored by when they expire (unix seconds)
+	sessionsKeyPrefix = "presence:sessions:"
+
+	// lastSeenKeyPrefix prefixes when a user was last online (unix seconds)
+	lastSeenKeyPrefix = "presence:seen:"
+
+	// ChangesChannel carries presence changes between instances
+	ChangesChannel = "presence:changes"
+
+	// HeartbeatInterval is how often an instance renews its sessions
+	HeartbeatInterval = 30 * time.Second
+
+	// OnlineTTL is how long a session lasts without a heartbeat
+	OnlineTTL = 3 * HeartbeatInterval
+
+	// LastSeenRetention is how long a user's last seen time is kept
+	LastSeenRetention = 90 * 24 * time.Hour
+
+	// updateQueueSize bounds connects and disconnects waiting to be recorded
+	updateQueueSize = 1024
+
+	presenceTimeout = 3 * time.Second
+)
+
+func init() {
+	keyspace.Register(
+		keyspace.Family{Prefix: onlineKey, Description: "online users by session expiry"},
+		keyspace.Family{Prefix: sessionsKeyPrefix, Description: "instances holding a user's connections"},
+		keyspace.Family{Prefix: lastSeenKeyPrefix, Description: "when each user was last online"},
+	)
+}
+
+// connectScript records a session of a user on an instance.
+//
+// KEYS: online users, user's sessions
+//
+// ARGV: username, instance ID, expiry (unix s), TTL (s), now (unix s)
+//
+// Returns 1 when the user was offline before.
+var connectScript = redis.NewScript(`
+local before = redis.call('ZSCORE', KEYS[1], ARGV[1])
+redis.call('ZADD', KEYS[2], ARGV[3], ARGV[2])
+redis.call('EXPIRE', KEYS[2], ARGV[4])
+redis.call('ZADD', KEYS[1], 'GT', ARGV[3], ARGV[1])
+redis.call('EXPIRE', KEYS[1], ARGV[4])
+if before and tonumber(before) > tonumber(ARGV[5]) then
+	return 0
+end
+return 1
+`)
+
+// disconnectScript removes a session of a user. Once no session is left the
+// user goes offline and their last seen time is recorded.
+//
+// KEYS: online users, user's sessions, user's last seen
+//
+// ARGV: username, instance ID, now (unix s), last seen retention (s)
+//
+// Returns 1 when the user went offline.
+var disconnectScript = redis.NewScript(`
+redis.call('ZREM', KEYS[2], ARGV[2])
+redis.call('ZREMRANGEBYSCORE', KEYS[2], '-inf', ARGV[3])
+if redis.call('ZCARD', KEYS[2]) > 0 then
+	return 0
+end
+redis.call('SET', KEYS[3], ARGV[3], 'EX', ARGV[4])
+return redis.call('ZREM', KEYS[1], ARGV[1])
+`)
+
+// expireScript takes a user offline whose sessions all expired, recording
+// when their last heartbeat was as their last seen time.
+//
+// KEYS: online users, user's last seen
+//
+// ARGV: username, now (unix s), TTL (s), last seen retention (s)
+//
+// Returns 1 when the user went offline, so only one instance publishes it.
+var expireScript = redis.NewScript(`
+local expiry = redis.call('ZSCORE', KEYS[1], ARGV[1])
+if not expiry or tonumber(expiry) > tonumber(ARGV[2]) then
+	return 0
+end
+redis.call('ZREM', KEYS[1], ARGV[1])
+redis.call('SET', KEYS[2], tonumber(expiry) - tonumber(ARGV[3]), 'EX', ARGV[4])
+return 1
+`)
+
+// Change is a user going online or offline
+type Change struct {
+	Username string    `json:"username"`
+	Online   bool      `json:"online"`
+	LastSeen time.Time `json:"last_seen"`
+
+	// Recipients are the friends told about the change
+	Recipients []string `json:"recipients"`
+}
+
+// Notifier tells a friend connected to this instance about a change
+type Notifier func(recipient string, change Change)
+
+// LocalUsers lists the users connected to this instance
+type LocalUsers interface {
+	GetOnlineUsers() []string
+}
+
+// update is a connect or disconnect waiting to be recorded
+type update struct {
+	username string
+	online   bool
+}
+
+// Service tracks which users are online across instances
+type Service struct {
+	rdb     *redis.Client
+	cb      *gobreaker.CircuitBreaker
+	friends *friends.FriendService
+	ctx     context.Context
+	cancel  context.CancelFunc
+	updates chan update
+
+	mu       sync.RWMutex
+	local    LocalUsers
+	onChange Notifier
+}
+
+// NewService creates the service and starts recording presence until ctx is
+// cancelled or the service is closed
+func NewService(ctx context.Context, rdb *redis.Client, fsrv *friends.FriendService) *Service {
+	bgCtx, cancel := context.WithCancel(ctx)
+
+	s := &Service{
+		rdb:     rdb,
+		friends: fsrv,
+		ctx:     bgCtx,
+		cancel:  cancel,
+		updates: make(chan update, updateQueueSize),
+		cb: breaker.New(breaker.Config{
+			Name:        "redis-presence",
+			MaxRequests: 3,
+			Interval:    60 * time.Second,
+			Timeout:     30 * time.Second,
+			Threshold:   0.5,
+			MinRequests: 5,
+		}),
+	}
+
+	go s.run()
+	go s.subscribe()
+
+	return s
+}
+
+// SetLocalUsers sets whose sessions the heartbeat renews
+func (s *Service) SetLocalUsers(local LocalUsers) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.local = local
+}
+
+// OnChange sets what is called for each friend of a user whose presence
+// changed. It runs on every instance and should deliver to the friends
+// connected there.
+func (s *Service) OnChange(fn Notifier) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onChange = fn
+}
+
+// Connected records that a user connected to this instance. It does not block.
+func (s *Service) Connected(username string) {
+	s.enqueue(update{username: username, online: true})
+}
+
+// Disconnected records that a user's connection to this instance ended. It
+// does not block.
+func (s *Service) Disconnected(username string) {
+	s.enqueue(update{username: username, online: false})
+}
+
+// enqueue queues an update; when the queue is full it is dropped and the
+// heartbeat corrects the user's presence later
+func (s *Service) enqueue(u update) {
+	select {
+	case s.updates <- u:
+	default:
+		logger.WithField("username", u.username).Warn("Presence update queue full, dropping update")
+	}
+}
+
+// IsOnline reports whether the user is connected to any instance
+func (s *Service) IsOnline(ctx context.Context, username string) (bool, error) {
+	result, err := breaker.ExecuteCtx(ctx, s.cb, func() (any, error) {
+		expiry, err := s.rdb.ZScore(ctx, onlineKey, username).Result()
+		if errors.Is(err, redis.Nil) {
+			return false, nil
+		}
+		return expiry > float64(time.Now().Unix()), err
+	})
+	if err != nil {
+		return false, err
+	}
+	return result.(bool), nil
+}
+
+// GetLastSeen returns when the user was last online: now while they are
+// online and the zero time when they have not been seen within
+// LastSeenRetention
+func (s *Service) GetLastSeen(ctx context.Context, username string) (time.Time, error) {
+	result, err := breaker.ExecuteCtx(ctx, s.cb, func() (any, error) {
+		now := time.Now()
+
+		pipe := s.rdb.Pipeline()
+		expiry := pipe.ZScore(ctx, onlineKey, username)
+		seen := pipe.Get(ctx, lastSeenKeyPrefix+username)
+		if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+			return nil, err
+		}
+
+		if score, err := expiry.Result(); err == nil && score > float64(now.Unix()) {
+			return now, nil
+		}
+		return lastSeen(seen.Val()), nil
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+	return result.(time.Time), nil
+}
+
+// GetOnlineFriends returns the user's accepted friends who are online
+func (s *Service) GetOnlineFriends(ctx context.Context, username string) ([]string, error) {
+	list, err := s.friends.GetUserFriends(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+	accepted := acceptedFriends(list.Value)
+	if len(accepted) == 0 {
+		return []string{}, nil
+	}
+
+	result, err := breaker.ExecuteCtx(ctx, s.cb, func() (any, error) {
+		return s.rdb.ZMScore(ctx, onlineKey, accepted...).Result()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	now := float64(time.Now().Unix())
+	online := make([]string, 0, len(accepted))
+	for i, expiry := range result.([]float64) {
+		if expiry > now {
+			online = append(online, accepted[i])
+		}
+	}
+	return online, nil
+}
+
+// Presence is whether a user is online and when they were last seen
+type Presence struct {
+	Username string    `json:"username"`
+	Online   bool      `json:"online"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// FriendPresence returns the presence of one of the user's accepted friends.
+// Only friends may see each other's presence, so anyone else is not found.
+func (s *Service) FriendPresence(ctx context.Context, username, friend string) (*Presence, error) {
+	list, err := s.friends.GetUserFriends(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+	if !slices.Contains(acceptedFriends(list.Value), friend) {
+		return nil, apperrors.New(apperrors.ErrCodeNotFound, "Friend not found", http.StatusNotFound)
+	}
+
+	seen, err := s.GetLastSeen(ctx, friend)
+	if err != nil {
+		return nil, err
+	}
+	online, err := s.IsOnline(ctx, friend)
+	if err != nil {
+		return nil, err
+	}
+	return &Presence{Username: friend, Online: online, LastSeen: seen}, nil
+}
+
+// Close stops recording presence and takes the users connected here offline
+func (s *Service) Close() {
+	s.cancel()
+
+	s.mu.RLock()
+	local := s.local
+	s.mu.RUnlock()
+	if local == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	for _, username := range local.GetOnlineUsers() {
+		s.record(ctx, update{username: username, online: false})
+	}
+}
+
+func (s *Service) run() {
+	ticker := time.NewTicker(HeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case u := <-s.updates:
+			ctx, cancel := context.WithTimeout(s.ctx, presenceTimeout)
+			s.record(ctx, u)
+			cancel()
+		case <-ticker.C:
+			s.heartbeat()
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
+
+// record writes a connect or disconnect and publishes the change it caused
+func (s *Service) record(ctx context.Context, u update) {
+	now := time.Now()
+	result, err := breaker.ExecuteCtx(ctx, s.cb, func() (any, error) {
+		if u.online {
+			return connectScript.Run(ctx, s.rdb,
+				[]string{onlineKey, sessionsKeyPrefix + u.username},
+				u.username, instance.ID(), now.Add(OnlineTTL).Unix(), int(OnlineTTL.Seconds()), now.Unix(),
+			).Int64()
+		}
+		return disconnectScript.Run(ctx, s.rdb,
+			[]string{onlineKey, sessionsKeyPrefix + u.username, lastSeenKeyPrefix + u.username},
+			u.username, instance.ID(), now.Unix(), int(LastSeenRetention.Seconds()),
+		).Int64()
+	})
+	if err != nil {
+		logger.WithFields(map[string]any{
+			"username": u.username,
+			"online":   u.online,
+			"error":    err.Error(),
+		}).Warn("Circuit breaker: Failed to record presence")
+		return
+	}
+
+	if result.(int64) == 1 {
+		s.publish(ctx, Change{Username: u.username, Online: u.online, LastSeen: now.UTC()})
+	}
+}
+
+// heartbeat renews the sessions of the users connected here and takes the
+// users whose sessions all expired offline
+func (s *Service) heartbeat() {
+	ctx, cancel := context.WithTimeout(s.ctx, presenceTimeout)
+	defer cancel()
+
+	s.mu.RLock()
+	local := s.local
+	s.mu.RUnlock()
+
+	var users []string
+	if local != nil {
+		users = local.GetOnlineUsers()
+	}
+
+	now := time.Now()
+	var changes []Change
+	_, err := breaker.ExecuteCtx(ctx, s.cb, func() (any, error) {
+		changes = changes[:0]
+
+		if len(users) > 0 {
+			pipe := s.rdb.Pipeline()
+			cmds := make([]*redis.Cmd, len(users))
+			for i, username := range users {
+				cmds[i] = connectScript.Eval(ctx, pipe,
+					[]string{onlineKey, sessionsKeyPrefix + username},
+					username, instance.ID(), now.Add(OnlineTTL).Unix(), int(OnlineTTL.Seconds()), now.Unix(),
+				)
+			}
+			if _, err := pipe.Exec(ctx); err != nil {
+				return nil, err
+			}
+			// Users another instance's sweep took offline are back
+			for i, cmd := range cmds {
+				if n, _ := cmd.Int64(); n == 1 {
+					changes = append(changes, Change{Username: users[i], Online: true, LastSeen: now.UTC()})
+				}
+			}
+		}
+
+		expired, err := s.rdb.ZRangeByScore(ctx, onlineKey, &redis.ZRangeBy{
+			Min: "-inf",
+			Max: strconv.FormatInt(now.Unix(), 10),
+		}).Result()
+		if err != nil || len(expired) == 0 {
+			return nil, err
+		}
+
+		pipe := s.rdb.Pipeline()
+		cmds := make([]*redis.Cmd, len(expired))
+		for i, username := range expired {
+			cmds[i] = expireScript.Eval(ctx, pipe,
+				[]string{onlineKey, lastSeenKeyPrefix + username},
+				username, now.Unix(), int(OnlineTTL.Seconds()), int(LastSeenRetention.Seconds()),
+			)
+		}
+		if _, err := pipe.Exec(ctx); err != nil {
+			return nil, err
+		}
+		for i, cmd := range cmds {
+			if n, _ := cmd.Int64(); n == 1 {
+				changes = append(changes, Change{Username: expired[i], LastSeen: now.Add(-OnlineTTL).UTC()})
+			}
+		}
+		return nil, nil
+	})
+	if err != nil {
+		logger.WithError(err).Warn("Circuit breaker: Failed to renew presence")
+		return
+	}
+
+	for _, change := range changes {
+		s.publish(ctx, change)
+	}
+}
+
+// publish tells every instance about a change, addressed to the user's friends
+func (s *Service) publish(ctx context.Context, change Change) {
+	list, err := s.friends.GetUserFriends(ctx, change.Username)
+	if err != nil {
+		logger.WithError(err).WithField("username", change.Username).Warn("Failed to list friends for presence change")
+		return
+	}
+	change.Recipients = acceptedFriends(list.Value)
+	if len(change.Recipients) == 0 {
+		return
+	}
+
+	payload, err := json.Marshal(change)
+	if err != nil {
+		logger.WithError(err).Error("Failed to marshal presence change")
+		return
+	}
+	if err := s.rdb.Publish(ctx, ChangesChannel, payload).Err(); err != nil {
+		logger.WithError(err).WithField("username", change.Username).Warn("Failed to publish presence change")
+	}
+}
+
+// subscribe hands the changes published by any instance to the OnChange
+// function
+func (s *Service) subscribe() {
+	pubsub := s.rdb.Subscribe(s.ctx, ChangesChannel)
+	defer pubsub.Close()
+
+	ch := pubsub.Channel()
+
+	for {
+		select {
+		case msg := <-ch:
+			if msg == nil {
+				logger.Warn("Presence PubSub channel closed, stopping subscription")
+				return
+			}
+
+			var change Change
+			if err := json.Unmarshal([]byte(msg.Payload), &change); err != nil {
+				logger.WithError(err).Error("Failed to unmarshal presence change")
+				continue
+			}
+
+			s.mu.RLock()
+			onChange := s.onChange
+			s.mu.RUnlock()
+			if onChange == nil {
+				continue
+			}
+			for _, recipient := range change.Recipients {
+				onChange(recipient, change)
+			}
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
+
+// acceptedFriends returns the usernames of the accepted friends in list
+func acceptedFriends(list []friends.FriendInfo) []string {
+	usernames := make([]string, 0, len(list))
+	for _, friend := range list {
+		if friend.Accepted {
+			usernames = append(usernames, friend.Username)
+		}
+	}
+	return usernames
+}
+
+// lastSeen parses a stored last seen time; the zero time when there is none
+func lastSeen(value string) time.Time {
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || seconds <= 0 {
+		return time.Time{}
+	}
+	return time.Unix(seconds, 0).UTC()
+}
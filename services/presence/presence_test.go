@@ -0,0 +1,25 @@
+package presence
+
+import (
+	"exc6/services/friends"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAcceptedFriends(t *testing.T) {
+	list := []friends.FriendInfo{
+		{Username: "bob", Accepted: true},
+		{Username: "carol", Accepted: false},
+		{Username: "dave", Accepted: true},
+	}
+	assert.Equal(t, []string{"bob", "dave"}, acceptedFriends(list))
+	assert.Empty(t, acceptedFriends(nil))
+}
+
+func TestLastSeen(t *testing.T) {
+	assert.Equal(t, time.Unix(1700000000, 0).UTC(), lastSeen("1700000000"))
+	assert.True(t, lastSeen("").IsZero(), "never seen")
+	assert.True(t, lastSeen("garbage").IsZero())
+}
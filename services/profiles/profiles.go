@@ -0,0 +1,401 @@
+package profiles
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"exc6/apperrors"
+	"exc6/db"
+	"exc6/pkg/breaker"
+	"exc6/pkg/logger"
+	"exc6/utils"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sony/gobreaker"
+)
+
+// SensitiveFieldCooldown is the minimum time between changes of a sensitive field
+const SensitiveFieldCooldown = 24 * time.Hour
+
+// fieldRule describes how a single profile field is normalized, validated and throttled
+type fieldRule struct {
+	normalize func(string) string
+	validate  func(string) *apperrors.AppError
+	sensitive bool
+}
+
+// fieldRules is the validation pipeline for every editable profile field.
+// An empty value always clears the field and skips validation.
+var fieldRules = map[string]fieldRule{
+	"display_name": {
+		normalize: strings.TrimSpace,
+		validate:  utils.ValidateDisplayName,
+	},
+	"email": {
+		normalize: func(v string) string { return strings.ToLower(strings.TrimSpace(v)) },
+		validate:  utils.ValidateEmail,
+		sensitive: true,
+	},
+	"phone": {
+		normalize: func(v string) string {
+			return strings.NewReplacer(" ", "", "-", "", "(", "", ")", "").Replace(strings.TrimSpace(v))
+		},
+		validate:  utils.ValidatePhone,
+		sensitive: true,
+	},
+	"website": {
+		normalize: strings.TrimSpace,
+		validate:  utils.ValidateURL,
+	},
+}
+
+// ProfileService manages the extended, self-service profile fields
+type ProfileService struct {
+	qdb   *db.Queries
+	sqldb *sql.DB
+	cb    *gobreaker.CircuitBreaker
+}
+
+func NewProfileService(qdb *db.Queries, sqldb *sql.DB) *ProfileService {
+	return &ProfileService{
+		qdb:   qdb,
+		sqldb: sqldb,
+		cb: breaker.New(breaker.Config{
+			Name:        "postgres-profiles",
+			MaxRequests: 10,
+			Interval:    60 * time.Second,
+			Timeout:     45 * time.Second,
+			Threshold:   0.6,
+			MinRequests: 10,
+		}),
+	}
+}
+
+// Profile represents the editable profile fields of a user
+type Profile struct {
+	Username    string    `json:"username"`
+	DisplayName string    `json:"display_name"`
+	Email       string    `json:"email"`
+	Phone       string    `json:"phone"`
+	Website     string    `json:"website"`
+	UpdatedAt   time.Time `json:"updated_at,omitempty"`
+}
+
+// FieldChange is a single audited profile field change
+type FieldChange struct {
+	Field     string    `json:"field"`
+	OldValue  string    `json:"old_value"`
+	NewValue  string    `json:"new_value"`
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+// get returns the current value of a field
+func (p *Profile) get(field string) string {
+	switch field {
+	case "display_name":
+		return p.DisplayName
+	case "email":
+		return p.Email
+	case "phone":
+		return p.Phone
+	case "website":
+		return p.Website
+	}
+	return ""
+}
+
+// set updates the value of a field
+func (p *Profile) set(field, value string) {
+	switch field {
+	case "display_name":
+		p.DisplayName = value
+	case "email":
+		p.Email = value
+	case "phone":
+		p.Phone = value
+	case "website":
+		p.Website = value
+	}
+}
+
+// ValidateFields runs the normalization and validation pipeline without touching
+// the database. It returns the normalized values or a validation error listing
+// every violation.
+func ValidateFields(changes map[string]string) (map[string]string, *apperrors.AppError) {
+	normalized := make(map[string]string, len(changes))
+	violations := make(map[string]string)
+
+	for field, value := range changes {
+		rule, ok := fieldRules[field]
+		if !ok {
+			violations[field] = "Field cannot be edited"
+			continue
+		}
+
+		if rule.normalize != nil {
+			value = rule.normalize(value)
+		}
+
+		if value != "" {
+			if err := rule.validate(value); err != nil {
+				violations[field] = err.Message
+				continue
+			}
+		}
+
+		normalized[field] = value
+	}
+
+	if len(violations) > 0 {
+		return nil, apperrors.NewValidationError("One or more profile fields are invalid").
+			WithOperation("profile_field_validation").
+			WithDetails("violations", violations)
+	}
+
+	return normalized, nil
+}
+
+// GetProfile returns the extended profile of a user
+func (ps *ProfileService) GetProfile(ctx context.Context, username string) (*Profile, error) {
+	result, err := breaker.ExecuteCtx(ctx, ps.cb, func() (interface{}, error) {
+		user, err := ps.qdb.GetUserByUsername(ctx, username)
+		if err != nil {
+			return nil, err
+		}
+
+		return loadProfile(ctx, ps.qdb, user)
+	})
+
+	if err != nil {
+		logger.WithFields(map[string]interface{}{
+			"username": username,
+			"error":    err.Error(),
+		}).Error("Circuit breaker: Failed to get profile")
+		return nil, apperrors.NewDatabaseError("get profile", err)
+	}
+
+	// Not-found errors don't trip the breaker and come back as an empty result
+	if result == nil {
+		return nil, apperrors.NewUserNotFound()
+	}
+
+	return result.(*Profile), nil
+}
+
+// UpdateFields applies a partial update to a user's profile. Every field is
+// validated before anything is written, sensitive fields are limited to one
+// change per SensitiveFieldCooldown, and each effective change is recorded
+// in the audit history.
+func (ps *ProfileService) UpdateFields(ctx context.Context, username string, changes map[string]string) (*Profile, error) {
+	normalized, verr := ValidateFields(changes)
+	if verr != nil {
+		return nil, verr.WithContext("username", username)
+	}
+
+	// A change inside the cooldown is not a database failure, so it is
+	// returned outside the breaker
+	var limited *apperrors.AppError
+	result, err := breaker.ExecuteCtx(ctx, ps.cb, func() (interface{}, error) {
+		user, err := ps.qdb.GetUserByUsername(ctx, username)
+		if err != nil {
+			return nil, err
+		}
+
+		profile, cooldown, err := ps.applyFields(ctx, user, normalized)
+		if err != nil {
+			return nil, err
+		}
+		if cooldown != nil {
+			limited = cooldown
+			return nil, nil
+		}
+		return profile, nil
+	})
+
+	if err != nil {
+		logger.WithFields(map[string]interface{}{
+			"username": username,
+			"error":    err.Error(),
+		}).Error("Circuit breaker: Failed to update profile")
+		return nil, apperrors.NewDatabaseError("update profile", err)
+	}
+
+	if limited != nil {
+		return nil, limited.WithContext("username", username)
+	}
+
+	if result == nil {
+		return nil, apperrors.NewUserNotFound()
+	}
+
+	return result.(*Profile), nil
+}
+
+// applyFields writes the fields of normalized that change in one
+// transaction holding the user's row, so concurrent updates cannot both pass
+// the cooldown check. The audit rows the check reads are written in it too.
+// If a sensitive field changed too recently it writes nothing and returns
+// the rate limit error.
+func (ps *ProfileService) applyFields(ctx context.Context, user db.User, normalized map[string]string) (*Profile, *apperrors.AppError, error) {
+	tx, err := ps.sqldb.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	q := ps.qdb.WithTx(tx)
+
+	if err := q.LockUserProfile(ctx, user.ID); err != nil {
+		return nil, nil, err
+	}
+
+	profile, err := loadProfile(ctx, q, user)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Only fields whose value actually changes are written and audited
+	fields := make([]string, 0, len(normalized))
+	for field, value := range normalized {
+		if profile.get(field) != value {
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+
+	if len(fields) == 0 {
+		return profile, nil, nil
+	}
+
+	for _, field := range fields {
+		if !fieldRules[field].sensitive {
+			continue
+		}
+
+		last, err := q.GetLastProfileChange(ctx, db.GetLastProfileChangeParams{
+			UserID: user.ID,
+			Field:  field,
+		})
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return nil, nil, err
+		}
+
+		if err == nil && time.Since(last.ChangedAt) < SensitiveFieldCooldown {
+			return nil, apperrors.New(apperrors.ErrCodeRateLimited, "This field can only be changed once per day", http.StatusTooManyRequests).
+				WithOperation("profile_field_update").
+				WithDetails("field", field).
+				WithDetails("retry_after", last.ChangedAt.Add(SensitiveFieldCooldown).UTC()), nil
+		}
+	}
+
+	previous := *profile
+	for _, field := range fields {
+		profile.set(field, normalized[field])
+	}
+
+	row, err := q.UpsertUserProfile(ctx, db.UpsertUserProfileParams{
+		UserID:      user.ID,
+		DisplayName: nullString(profile.DisplayName),
+		Email:       nullString(profile.Email),
+		Phone:       nullString(profile.Phone),
+		Website:     nullString(profile.Website),
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	profile.UpdatedAt = row.UpdatedAt
+
+	// The audit rows also start the cooldown, so the update fails without them
+	for _, field := range fields {
+		if _, err := q.CreateProfileChange(ctx, db.CreateProfileChangeParams{
+			UserID:   user.ID,
+			Field:    field,
+			OldValue: nullString(previous.get(field)),
+			NewValue: nullString(profile.get(field)),
+		}); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, nil, err
+	}
+
+	logger.WithFields(map[string]interface{}{
+		"username": user.Username,
+		"fields":   fields,
+	}).Info("Profile fields updated")
+
+	return profile, nil, nil
+}
+
+// GetChangeHistory returns the most recent audited profile changes of a user
+func (ps *ProfileService) GetChangeHistory(ctx context.Context, username string, limit int) ([]FieldChange, error) {
+	result, err := breaker.ExecuteCtx(ctx, ps.cb, func() (interface{}, error) {
+		user, err := ps.qdb.GetUserByUsername(ctx, username)
+		if err != nil {
+			return nil, err
+		}
+
+		rows, err := ps.qdb.GetProfileChanges(ctx, db.GetProfileChangesParams{
+			UserID: user.ID,
+			Limit:  int32(limit),
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		history := make([]FieldChange, 0, len(rows))
+		for _, row := range rows {
+			history = append(history, FieldChange{
+				Field:     row.Field,
+				OldValue:  row.OldValue.String,
+				NewValue:  row.NewValue.String,
+				ChangedAt: row.ChangedAt,
+			})
+		}
+
+		return history, nil
+	})
+
+	if err != nil {
+		logger.WithFields(map[string]interface{}{
+			"username": username,
+			"error":    err.Error(),
+		}).Error("Circuit breaker: Failed to get profile history")
+		return nil, apperrors.NewDatabaseError("get profile history", err)
+	}
+
+	if result == nil {
+		return nil, apperrors.NewUserNotFound()
+	}
+
+	return result.([]FieldChange), nil
+}
+
+// loadProfile reads the stored profile, returning an empty one if none exists yet
+func loadProfile(ctx context.Context, q *db.Queries, user db.User) (*Profile, error) {
+	row, err := q.GetUserProfile(ctx, user.ID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return &Profile{Username: user.Username}, nil
+		}
+		return nil, err
+	}
+
+	return &Profile{
+		Username:    user.Username,
+		DisplayName: row.DisplayName.String,
+		Email:       row.Email.String,
+		Phone:       row.Phone.String,
+		Website:     row.Website.String,
+		UpdatedAt:   row.UpdatedAt,
+	}, nil
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
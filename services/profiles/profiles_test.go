@@ -0,0 +1,90 @@
+package profiles
+
+import (
+	"context"
+	"errors"
+	"exc6/apperrors"
+	"exc6/tests/fakedb"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newService returns a service over a fake database knowing alice, whose
+// stored profile has only an email
+func newService(t *testing.T) (*ProfileService, *fakedb.Fake) {
+	fake := fakedb.New(t)
+	aliceID := uuid.New()
+	now := time.Now()
+
+	fake.On("GetUserByUsername", func(args []any) (fakedb.Result, error) {
+		if args[0] != "alice" {
+			return fakedb.Result{}, nil
+		}
+		return fakedb.Row(aliceID, now, now, "alice", "user", "hash", nil, nil), nil
+	})
+	fake.Return("GetUserProfile", fakedb.Row(aliceID, nil, "alice@example.com", nil, nil, now))
+	fake.On("UpsertUserProfile", func(args []any) (fakedb.Result, error) {
+		return fakedb.Row(append(args, time.Now())...), nil
+	})
+	fake.On("CreateProfileChange", func(args []any) (fakedb.Result, error) {
+		return fakedb.Row(append([]any{uuid.New()}, append(args, time.Now())...)...), nil
+	})
+
+	return NewProfileService(fake.Queries(), fake.DB()), fake
+}
+
+func requireStatus(t *testing.T, err error, status int) {
+	t.Helper()
+	var appErr *apperrors.AppError
+	require.True(t, errors.As(err, &appErr), "got %v", err)
+	assert.Equal(t, status, appErr.StatusCode)
+}
+
+func TestUpdateFields(t *testing.T) {
+	ps, fake := newService(t)
+
+	profile, err := ps.UpdateFields(context.Background(), "alice", map[string]string{
+		"display_name": "  Alice ",
+		"email":        "ALICE@example.com",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "Alice", profile.DisplayName)
+	assert.Equal(t, "alice@example.com", profile.Email)
+
+	changes := fake.Calls("CreateProfileChange")
+	require.Len(t, changes, 1, "the email did not change")
+	assert.Equal(t, "display_name", changes[0][1])
+	assert.Len(t, fake.Calls("LockUserProfile"), 1)
+	assert.Equal(t, 1, fake.Commits())
+
+	_, err = ps.UpdateFields(context.Background(), "nobody", map[string]string{"website": "https://example.com"})
+	requireStatus(t, err, http.StatusNotFound)
+}
+
+func TestUpdateFieldsCooldown(t *testing.T) {
+	ps, fake := newService(t)
+	ctx := context.Background()
+
+	fake.On("GetLastProfileChange", func(args []any) (fakedb.Result, error) {
+		return fakedb.Row(uuid.New(), args[0], args[1], "old@example.com", "alice@example.com", time.Now().Add(-time.Hour)), nil
+	})
+
+	// Enough refusals to trip the breaker, were they counted as failures
+	for i := 0; i < 20; i++ {
+		_, err := ps.UpdateFields(ctx, "alice", map[string]string{"email": "new@example.com"})
+		requireStatus(t, err, http.StatusTooManyRequests)
+	}
+
+	assert.Empty(t, fake.Calls("UpsertUserProfile"), "nothing is written inside the cooldown")
+	assert.Zero(t, fake.Commits())
+	assert.Zero(t, ps.cb.Counts().TotalFailures)
+
+	// Fields without a cooldown are still editable
+	_, err := ps.UpdateFields(ctx, "alice", map[string]string{"website": "https://example.com"})
+	require.NoError(t, err)
+}
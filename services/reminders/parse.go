@@ -0,0 +1,144 @@
+package reminders
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// durationUnits maps unit words and suffixes to their length
+var durationUnits = map[string]time.Duration{
+	"s": time.Second, "sec": time.Second, "secs": time.Second, "second": time.Second, "seconds": time.Second,
+	"m": time.Minute, "min": time.Minute, "mins": time.Minute, "minute": time.Minute, "minutes": time.Minute,
+	"h": time.Hour, "hr": time.Hour, "hrs": time.Hour, "hour": time.Hour, "hours": time.Hour,
+	"d": 24 * time.Hour, "day": 24 * time.Hour, "days": 24 * time.Hour,
+	"w": 7 * 24 * time.Hour, "week": 7 * 24 * time.Hour, "weeks": 7 * 24 * time.Hour,
+}
+
+// Request is a parsed reminder request
+type Request struct {
+	Text  string
+	Delay time.Duration // time until the first delivery
+	Every time.Duration // zero for one-off reminders
+}
+
+// ParseRequest parses the natural language form used by "/remind":
+//
+//	me in 2h to stretch
+//	in 90 minutes call mom
+//	me every 1d to drink water
+//	30m check the oven
+//
+// "me" and "to" are optional. Durations are Go durations ("1h30m") or a number
+// followed by a unit ("2 hours", "3d", "1w").
+func ParseRequest(input string) (*Request, error) {
+	tokens := strings.Fields(input)
+
+	if len(tokens) > 0 && strings.EqualFold(tokens[0], "me") {
+		tokens = tokens[1:]
+	}
+
+	recurring := false
+	if len(tokens) > 0 {
+		switch strings.ToLower(tokens[0]) {
+		case "every":
+			recurring = true
+			tokens = tokens[1:]
+		case "in":
+			tokens = tokens[1:]
+		}
+	}
+
+	if len(tokens) == 0 {
+		return nil, errors.New("missing duration")
+	}
+
+	d, consumed, err := parseDurationTokens(tokens)
+	if err != nil {
+		return nil, err
+	}
+	tokens = tokens[consumed:]
+
+	if len(tokens) > 0 && strings.EqualFold(tokens[0], "to") {
+		tokens = tokens[1:]
+	}
+
+	text := strings.Join(tokens, " ")
+	if text == "" {
+		return nil, errors.New("missing reminder text")
+	}
+
+	req := &Request{Text: text, Delay: d}
+	if recurring {
+		req.Every = d
+	}
+
+	return req, nil
+}
+
+// ParseDuration parses a single duration such as "90m", "2h", "3d" or "1w"
+func ParseDuration(s string) (time.Duration, error) {
+	d, consumed, err := parseDurationTokens(strings.Fields(s))
+	if err != nil {
+		return 0, err
+	}
+	if consumed != len(strings.Fields(s)) {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	return d, nil
+}
+
+// parseDurationTokens reads a duration from the first one or two tokens and
+// returns how many tokens it used
+func parseDurationTokens(tokens []string) (time.Duration, int, error) {
+	first := strings.ToLower(tokens[0])
+
+	// "1h30m", "90s"
+	if d, err := time.ParseDuration(first); err == nil {
+		return validDuration(d, 1, first)
+	}
+
+	// "3d", "1w"
+	if n, unit := splitNumber(first); n > 0 && unit != "" {
+		if u, ok := durationUnits[unit]; ok {
+			return validDuration(time.Duration(n)*u, 1, first)
+		}
+	}
+
+	// "2 hours", "an hour"
+	if len(tokens) > 1 {
+		n, err := strconv.Atoi(first)
+		if first == "a" || first == "an" {
+			n, err = 1, nil
+		}
+		if err == nil {
+			if u, ok := durationUnits[strings.ToLower(tokens[1])]; ok {
+				return validDuration(time.Duration(n)*u, 2, first+" "+tokens[1])
+			}
+		}
+	}
+
+	return 0, 0, fmt.Errorf("invalid duration %q (try 10m, 2h, 3 days or 1w)", tokens[0])
+}
+
+func validDuration(d time.Duration, consumed int, raw string) (time.Duration, int, error) {
+	if d <= 0 {
+		return 0, 0, fmt.Errorf("duration %q must be positive", raw)
+	}
+	return d, consumed, nil
+}
+
+// splitNumber splits "30d" into 30 and "d"
+func splitNumber(s string) (int, string) {
+	i := 0
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+	n, err := strconv.Atoi(s[:i])
+	if err != nil {
+		return 0, ""
+	}
+	return n, s[i:]
+}
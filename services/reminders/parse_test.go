@@ -0,0 +1,99 @@
+package reminders
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRequest(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		text    string
+		delay   time.Duration
+		every   time.Duration
+		wantErr bool
+	}{
+		{
+			name:  "Full form",
+			input: "me in 2h to stretch",
+			text:  "stretch",
+			delay: 2 * time.Hour,
+		},
+		{
+			name:  "Number and unit word",
+			input: "in 90 minutes call mom",
+			text:  "call mom",
+			delay: 90 * time.Minute,
+		},
+		{
+			name:  "Bare duration",
+			input: "30m check the oven",
+			text:  "check the oven",
+			delay: 30 * time.Minute,
+		},
+		{
+			name:  "Compound Go duration",
+			input: "me in 1h30m to leave",
+			text:  "leave",
+			delay: 90 * time.Minute,
+		},
+		{
+			name:  "Article as count",
+			input: "me in an hour to eat",
+			text:  "eat",
+			delay: time.Hour,
+		},
+		{
+			name:  "Recurring daily",
+			input: "me every 1d to drink water",
+			text:  "drink water",
+			delay: 24 * time.Hour,
+			every: 24 * time.Hour,
+		},
+		{
+			name:  "Recurring weeks",
+			input: "every 2 weeks water the plants",
+			text:  "water the plants",
+			delay: 14 * 24 * time.Hour,
+			every: 14 * 24 * time.Hour,
+		},
+		{
+			name:    "Missing text",
+			input:   "me in 2h",
+			wantErr: true,
+		},
+		{
+			name:    "Missing duration",
+			input:   "me to stretch",
+			wantErr: true,
+		},
+		{
+			name:    "Unparseable duration",
+			input:   "in soon stretch",
+			wantErr: true,
+		},
+		{
+			name:    "Empty",
+			input:   "",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := ParseRequest(tt.input)
+			if tt.wantErr {
+				assert.NotNil(t, err)
+				return
+			}
+
+			assert.Nil(t, err)
+			assert.Equal(t, tt.text, req.Text)
+			assert.Equal(t, tt.delay, req.Delay)
+			assert.Equal(t, tt.every, req.Every)
+		})
+	}
+}
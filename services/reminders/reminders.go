@@ -0,0 +1,426 @@
+package reminders
+
+import (
+	"context"
+	"encoding/json"
+	"exc6/apperrors"
+	"exc6/pkg/breaker"
+	"exc6/pkg/keyspace"
+	"exc6/pkg/logger"
+	"exc6/services/chat"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"github.com/sony/gobreaker"
+)
+
+const (
+	// dueKey is a sorted set of reminder IDs scored by due time (unix seconds)
+	dueKey = "reminders:due"
+
+	// reminderKeyPrefix prefixes the JSON body of a reminder
+	reminderKeyPrefix = "reminders:item:"
+
+	// userKeyPrefix prefixes the set of a user's reminder IDs
+	userKeyPrefix = "reminders:user:"
+
+	pollInterval = 5 * time.Second
+	batchSize    = 100
+
+	// MaxPerUser caps the number of pending reminders per user
+	MaxPerUser = 50
+
+	// MaxDelay is the furthest ahead a reminder can be scheduled
+	MaxDelay = 365 * 24 * time.Hour
+
+	// MinInterval is the shortest recurrence interval
+	MinInterval = time.Minute
+
+	// SenderUsername is the account reminders are delivered from
+	SenderUsername = "remindbot"
+
+	// claimLease is how long a claimed reminder is hidden from other
+	// instances; if the claiming one dies, the reminder is delivered again
+	claimLease = time.Minute
+
+	// retryBase is the wait before the first retry of a failed delivery; it
+	// doubles with every further failure
+	retryBase = 30 * time.Second
+
+	// maxAttempts is how many times a delivery is tried before the
+	// reminder is dropped
+	maxAttempts = 8
+)
+
+// createScript stores a reminder unless the user has MaxPerUser pending,
+// returning 0 then and 1 otherwise.
+//
+// KEYS: user's reminders, reminder body, due reminders
+//
+// ARGV: reminder ID, body, due (unix seconds), the limit
+var createScript = redis.NewScript(`
+if redis.call('SCARD', KEYS[1]) >= tonumber(ARGV[4]) then
+	return 0
+end
+redis.call('SET', KEYS[2], ARGV[2])
+redis.call('SADD', KEYS[1], ARGV[1])
+redis.call('ZADD', KEYS[3], ARGV[3], ARGV[1])
+return 1
+`)
+
+// claimScript leases a due reminder to one instance by moving it past the
+// lease, returning 1 when this call claimed it.
+//
+// KEYS: due reminders
+//
+// ARGV: reminder ID, now, lease end (unix seconds)
+var claimScript = redis.NewScript(`
+local due = redis.call('ZSCORE', KEYS[1], ARGV[1])
+if not due or tonumber(due) > tonumber(ARGV[2]) then
+	return 0
+end
+redis.call('ZADD', KEYS[1], ARGV[3], ARGV[1])
+return 1
+`)
+
+// rescheduleScript stores a claimed reminder's new body and due time,
+// unless it was cancelled meanwhile; returns 1 when rescheduled.
+//
+// KEYS: due reminders, reminder body
+//
+// ARGV: reminder ID, body, due (unix seconds)
+var rescheduleScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[2]) == 0 then
+	redis.call('ZREM', KEYS[1], ARGV[1])
+	return 0
+end
+redis.call('SET', KEYS[2], ARGV[2])
+redis.call('ZADD', KEYS[1], ARGV[3], ARGV[1])
+return 1
+`)
+
+func init() {
+	const exempt = "removed when the reminder fires or is cancelled"
+	keyspace.Register(
+		keyspace.Family{Prefix: dueKey, Description: "pending reminders by due time", Exempt: exempt},
+		keyspace.Family{Prefix: reminderKeyPrefix, Description: "reminder bodies", Exempt: exempt},
+		keyspace.Family{Prefix: userKeyPrefix, Description: "pending reminders per user", Exempt: exempt},
+	)
+}
+
+// Reminder is a scheduled personal reminder
+type Reminder struct {
+	ID       string    `json:"id"`
+	Username string    `json:"username"`
+	Text     string    `json:"text"`
+	DueAt    time.Time `json:"due_at"`
+
+	// EverySeconds is the recurrence interval; zero for one-off reminders
+	EverySeconds int64     `json:"every_seconds,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+
+	// Attempts counts failed deliveries of the current occurrence
+	Attempts int `json:"attempts,omitempty"`
+}
+
+// Interval returns the recurrence interval
+func (r *Reminder) Interval() time.Duration {
+	return time.Duration(r.EverySeconds) * time.Second
+}
+
+// ReminderService stores reminders in Redis and delivers them as direct
+// messages from SenderUsername when they are due
+type ReminderService struct {
+	rdb  *redis.Client
+	csrv chat.Service
+	cb   *gobreaker.CircuitBreaker
+	ctx  context.Context
+}
+
+// NewReminderService creates the service and starts the delivery scheduler
+func NewReminderService(ctx context.Context, rdb *redis.Client, csrv chat.Service) *ReminderService {
+	rs := &ReminderService{
+		rdb:  rdb,
+		csrv: csrv,
+		ctx:  ctx,
+		cb: breaker.New(breaker.Config{
+			Name:        "redis-reminders",
+			MaxRequests: 5,
+			Interval:    60 * time.Second,
+			Timeout:     30 * time.Second,
+			Threshold:   0.5,
+			MinRequests: 5,
+		}),
+	}
+
+	go rs.scheduler()
+
+	return rs
+}
+
+// Create schedules a reminder for username after delay, repeating every
+// interval if it is non-zero
+func (rs *ReminderService) Create(ctx context.Context, username, text string, delay, every time.Duration) (*Reminder, error) {
+	if text == "" {
+		return nil, apperrors.NewValidationError("Reminder text is required")
+	}
+	if len(text) > 500 {
+		return nil, apperrors.NewValidationError("Reminder text must be at most 500 characters")
+	}
+	if delay <= 0 || delay > MaxDelay {
+		return nil, apperrors.NewValidationError("Reminders must be due within the next year")
+	}
+	if every != 0 && every < MinInterval {
+		return nil, apperrors.NewValidationError("Recurring reminders must repeat at most once a minute")
+	}
+
+	now := time.Now()
+	reminder := &Reminder{
+		ID:           uuid.NewString(),
+		Username:     username,
+		Text:         text,
+		DueAt:        now.Add(delay).Truncate(time.Second),
+		EverySeconds: int64(every / time.Second),
+		CreatedAt:    now,
+	}
+
+	body, err := json.Marshal(reminder)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := breaker.ExecuteCtx(ctx, rs.cb, func() (interface{}, error) {
+		return createScript.Run(ctx, rs.rdb,
+			[]string{userKeyPrefix + username, reminderKeyPrefix + reminder.ID, dueKey},
+			reminder.ID, body, reminder.DueAt.Unix(), MaxPerUser,
+		).Int()
+	})
+	if err != nil {
+		logger.WithFields(map[string]interface{}{
+			"username": username,
+			"error":    err.Error(),
+		}).Error("Circuit breaker: Failed to create reminder")
+		return nil, apperrors.NewCacheError("reminder_create", userKeyPrefix+username, err)
+	}
+	if created, _ := result.(int); created == 0 {
+		return nil, apperrors.New(apperrors.ErrCodeRateLimited, fmt.Sprintf("You can have at most %d pending reminders", MaxPerUser), http.StatusTooManyRequests)
+	}
+
+	return reminder, nil
+}
+
+// List returns the pending reminders of a user, soonest first
+func (rs *ReminderService) List(ctx context.Context, username string) ([]Reminder, error) {
+	result, err := breaker.ExecuteCtx(ctx, rs.cb, func() (interface{}, error) {
+		ids, err := rs.rdb.SMembers(ctx, userKeyPrefix+username).Result()
+		if err != nil {
+			return nil, err
+		}
+
+		reminders := make([]Reminder, 0, len(ids))
+		for _, id := range ids {
+			reminder, err := rs.load(ctx, id)
+			if err == redis.Nil {
+				// Dangling ID from a delivery that raced with this read
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			reminders = append(reminders, *reminder)
+		}
+
+		sort.Slice(reminders, func(i, j int) bool {
+			return reminders[i].DueAt.Before(reminders[j].DueAt)
+		})
+
+		return reminders, nil
+	})
+
+	if err != nil {
+		logger.WithFields(map[string]interface{}{
+			"username": username,
+			"error":    err.Error(),
+		}).Error("Circuit breaker: Failed to list reminders")
+		return nil, apperrors.NewCacheError("reminder_list", userKeyPrefix+username, err)
+	}
+
+	return result.([]Reminder), nil
+}
+
+// Cancel deletes a pending reminder owned by username
+func (rs *ReminderService) Cancel(ctx context.Context, username, id string) error {
+	result, err := breaker.ExecuteCtx(ctx, rs.cb, func() (interface{}, error) {
+		owned, err := rs.rdb.SIsMember(ctx, userKeyPrefix+username, id).Result()
+		if err != nil || !owned {
+			return owned, err
+		}
+
+		pipe := rs.rdb.TxPipeline()
+		pipe.ZRem(ctx, dueKey, id)
+		pipe.Del(ctx, reminderKeyPrefix+id)
+		pipe.SRem(ctx, userKeyPrefix+username, id)
+		_, err = pipe.Exec(ctx)
+		return true, err
+	})
+
+	if err != nil {
+		logger.WithFields(map[string]interface{}{
+			"username":    username,
+			"reminder_id": id,
+			"error":       err.Error(),
+		}).Error("Circuit breaker: Failed to cancel reminder")
+		return apperrors.NewCacheError("reminder_cancel", reminderKeyPrefix+id, err)
+	}
+
+	if owned, _ := result.(bool); !owned {
+		return apperrors.New(apperrors.ErrCodeNotFound, "Reminder not found", http.StatusNotFound)
+	}
+
+	return nil
+}
+
+func (rs *ReminderService) load(ctx context.Context, id string) (*Reminder, error) {
+	body, err := rs.rdb.Get(ctx, reminderKeyPrefix+id).Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	var reminder Reminder
+	if err := json.Unmarshal(body, &reminder); err != nil {
+		return nil, err
+	}
+
+	return &reminder, nil
+}
+
+func (rs *ReminderService) scheduler() {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			rs.deliverDue()
+		case <-rs.ctx.Done():
+			return
+		}
+	}
+}
+
+// deliverDue sends every reminder that is due. Each reminder is leased with
+// claimScript, so only one instance delivers it; it stays in the due set
+// until it was delivered, so a failed or interrupted delivery is retried.
+func (rs *ReminderService) deliverDue() {
+	ctx, cancel := context.WithTimeout(rs.ctx, pollInterval)
+	defer cancel()
+
+	now := time.Now()
+	result, err := breaker.ExecuteCtx(ctx, rs.cb, func() (interface{}, error) {
+		return rs.rdb.ZRangeByScore(ctx, dueKey, &redis.ZRangeBy{
+			Min:   "-inf",
+			Max:   strconv.FormatInt(now.Unix(), 10),
+			Count: batchSize,
+		}).Result()
+	})
+	if err != nil {
+		logger.WithError(err).Warn("Circuit breaker: Failed to poll due reminders")
+		return
+	}
+
+	ids, _ := result.([]string)
+	for _, id := range ids {
+		claimed, err := claimScript.Run(ctx, rs.rdb, []string{dueKey}, id, now.Unix(), now.Add(claimLease).Unix()).Int()
+		if err != nil || claimed == 0 {
+			continue
+		}
+
+		rs.deliver(ctx, id)
+	}
+}
+
+func (rs *ReminderService) deliver(ctx context.Context, id string) {
+	reminder, err := rs.load(ctx, id)
+	if err != nil {
+		if err == redis.Nil {
+			// Cancelled after it was claimed
+			rs.rdb.ZRem(ctx, dueKey, id)
+			return
+		}
+		logger.WithFields(map[string]interface{}{
+			"reminder_id": id,
+			"error":       err.Error(),
+		}).Error("Failed to load due reminder")
+		return
+	}
+
+	if _, err := rs.csrv.SendMessage(ctx, SenderUsername, reminder.Username, "⏰ Reminder: "+reminder.Text); err != nil {
+		reminder.Attempts++
+		fields := map[string]interface{}{
+			"reminder_id": id,
+			"username":    reminder.Username,
+			"attempts":    reminder.Attempts,
+			"error":       err.Error(),
+		}
+		if reminder.Attempts < maxAttempts {
+			logger.WithFields(fields).Warn("Failed to deliver reminder, will retry")
+			rs.reschedule(ctx, reminder, time.Now().Add(retryDelay(reminder.Attempts)))
+			return
+		}
+		logger.WithFields(fields).Error("Failed to deliver reminder, giving up")
+	}
+
+	if reminder.EverySeconds == 0 {
+		rs.remove(ctx, reminder)
+		return
+	}
+
+	// Recurring: schedule the next occurrence, skipping any missed while down
+	next := reminder.DueAt
+	for !next.After(time.Now()) {
+		next = next.Add(reminder.Interval())
+	}
+	reminder.DueAt = next
+	reminder.Attempts = 0
+	rs.reschedule(ctx, reminder, next)
+}
+
+// reschedule stores a claimed reminder to be delivered at due
+func (rs *ReminderService) reschedule(ctx context.Context, reminder *Reminder, due time.Time) {
+	body, err := json.Marshal(reminder)
+	if err != nil {
+		return
+	}
+
+	err = rescheduleScript.Run(ctx, rs.rdb, []string{dueKey, reminderKeyPrefix + reminder.ID},
+		reminder.ID, body, due.Unix(),
+	).Err()
+	if err != nil {
+		// The lease runs out and the reminder is delivered again
+		logger.WithFields(map[string]interface{}{
+			"reminder_id": reminder.ID,
+			"error":       err.Error(),
+		}).Error("Failed to reschedule reminder")
+	}
+}
+
+// remove deletes a delivered one-off reminder
+func (rs *ReminderService) remove(ctx context.Context, reminder *Reminder) {
+	pipe := rs.rdb.TxPipeline()
+	pipe.ZRem(ctx, dueKey, reminder.ID)
+	pipe.Del(ctx, reminderKeyPrefix+reminder.ID)
+	pipe.SRem(ctx, userKeyPrefix+reminder.Username, reminder.ID)
+	if _, err := pipe.Exec(ctx); err != nil {
+		logger.WithError(err).Warn("Failed to clean up delivered reminder")
+	}
+}
+
+// retryDelay is the wait after the given number of failed deliveries
+func retryDelay(attempts int) time.Duration {
+	return retryBase << (attempts - 1)
+}
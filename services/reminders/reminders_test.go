@@ -0,0 +1,21 @@
+package reminders
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryDelay(t *testing.T) {
+	assert.Equal(t, retryBase, retryDelay(1))
+	assert.Equal(t, 2*retryBase, retryDelay(2))
+	assert.Equal(t, 64*retryBase, retryDelay(maxAttempts-1))
+
+	// The last retry happens within a day of the first failure
+	var total time.Duration
+	for attempt := 1; attempt < maxAttempts; attempt++ {
+		total += retryDelay(attempt)
+	}
+	assert.Less(t, total, 24*time.Hour)
+}
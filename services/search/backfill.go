@@ -0,0 +1,554 @@
+package search
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"exc6/apperrors"
+	"exc6/db"
+	"exc6/pkg/instance"
+	"exc6/pkg/keyspace"
+	"exc6/pkg/logger"
+	"exc6/services/chat"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/redis/go-redis/v9"
+)
+
+// The history consumer archives Kafka into Postgres from where its consumer
+// group left off, so messages produced before it first ran can be missing
+// from the index. Those records predate the topic per message class and are
+// all in HistoryTopic. A backfill reads it from the start up to the end
+// offsets seen when it began and applies each record as the consumer does;
+// applying a record twice changes nothing. The next offset of each partition
+// is checkpointed in Redis, so a stopped or interrupted backfill resumes
+// where it left off on any instance, and a Redis lock runs it on one
+// instance at a time. Records are applied at a bounded rate to spare
+// Postgres and the brokers.
+
+// Backfill states
+const (
+	BackfillIdle    = "idle"
+	BackfillRunning = "running"
+	BackfillStopped = "stopped"
+	BackfillDone    = "done"
+	BackfillFailed  = "failed"
+)
+
+const (
+	backfillKeyPrefix  = "search:backfill:"
+	backfillOffsetsKey = backfillKeyPrefix + "offsets" // partition -> next offset to apply
+	backfillTargetsKey = backfillKeyPrefix + "targets" // partition -> end offset when the backfill began
+	backfillStateKey   = backfillKeyPrefix + "state"
+	backfillLockKey    = backfillKeyPrefix + "lock"
+	backfillStopKey    = backfillKeyPrefix + "stop"
+
+	// backfillLockTTL bounds how long a crashed instance blocks the backfill;
+	// the running instance refreshes the lock at every checkpoint
+	backfillLockTTL = 30 * time.Second
+
+	checkpointInterval   = 2 * time.Second
+	backfillPollTimeout  = 500 * time.Millisecond
+	backfillKafkaTimeout = 10 * time.Second
+	backfillWriteTimeout = 5 * time.Second
+)
+
+var backfillRecords = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "search_backfill_records_total",
+		Help: "History records applied by the search backfill by outcome",
+	},
+	[]string{"result"}, // result: archived, ignored, invalid, failed
+)
+
+func init() {
+	instance.Registerer().MustRegister(backfillRecords)
+	keyspace.Register(keyspace.Family{
+		Prefix:      backfillKeyPrefix,
+		Description: "search backfill checkpoint, progress and lock",
+		Exempt:      "checkpoint kept until the backfill is reset; the lock and stop request expire",
+	})
+}
+
+// PartitionProgress is how far the backfill got in one partition
+type PartitionProgress struct {
+	Partition int32 `json:"partition"`
+	Offset    int64 `json:"offset"`
+	Target    int64 `json:"target"`
+}
+
+// BackfillProgress reports the state of the backfill
+type BackfillProgress struct {
+	State      string              `json:"state"`
+	Instance   string              `json:"instance,omitempty"`
+	Records    int64               `json:"records"`
+	Archived   int64               `json:"archived"`
+	Remaining  int64               `json:"remaining"`
+	Percent    float64             `json:"percent"`
+	Partitions []PartitionProgress `json:"partitions"`
+	StartedAt  *time.Time          `json:"started_at,omitempty"`
+	UpdatedAt  *time.Time          `json:"updated_at,omitempty"`
+	Error      string              `json:"error,omitempty"`
+}
+
+// Backfill indexes the history in Kafka that Postgres is missing
+type Backfill struct {
+	ctx       context.Context
+	qdb       *db.Queries
+	rdb       *redis.Client
+	kafkaAddr string
+
+	// pace is the time between two records
+	pace time.Duration
+
+	mu     sync.Mutex
+	cancel context.CancelFunc // stops the run on this instance; nil when none
+}
+
+// NewBackfill creates a backfill applying at most rate records per second.
+// Runs stop, keeping their checkpoint, when ctx is cancelled.
+func NewBackfill(ctx context.Context, qdb *db.Queries, rdb *redis.Client, kafkaAddr string, rate int) *Backfill {
+	return &Backfill{
+		ctx:       ctx,
+		qdb:       qdb,
+		rdb:       rdb,
+		kafkaAddr: kafkaAddr,
+		pace:      time.Second / time.Duration(max(rate, 1)),
+	}
+}
+
+// Start runs the backfill on this instance from its checkpoint, or from the
+// beginning of the topic if reset or there is none
+func (b *Backfill) Start(ctx context.Context, reset bool) error {
+	acquired, err := b.rdb.SetNX(ctx, backfillLockKey, instance.ID(), backfillLockTTL).Result()
+	if err != nil {
+		return apperrors.NewInternalError("Failed to lock the search backfill").WithInternal(err)
+	}
+	if !acquired {
+		holder, _ := b.rdb.Get(ctx, backfillLockKey).Result()
+		return apperrors.New(apperrors.ErrCodeInvalidInput, "The search backfill is already running on "+holder, http.StatusConflict)
+	}
+
+	run, err := b.prepare(ctx, reset)
+	if err != nil {
+		b.release()
+		return apperrors.NewInternalError("Failed to start the search backfill").WithInternal(err)
+	}
+
+	runCtx, cancel := context.WithCancel(b.ctx)
+	b.mu.Lock()
+	b.cancel = cancel
+	b.mu.Unlock()
+
+	go func() {
+		defer cancel()
+		b.run(runCtx, run)
+	}()
+
+	return nil
+}
+
+// Stop asks the instance running the backfill to stop at its next
+// checkpoint. Start resumes it.
+func (b *Backfill) Stop(ctx context.Context) error {
+	b.mu.Lock()
+	cancel := b.cancel
+	b.mu.Unlock()
+	if cancel != nil {
+		cancel()
+		return nil
+	}
+
+	if err := b.rdb.Set(ctx, backfillStopKey, 1, backfillLockTTL).Err(); err != nil {
+		return apperrors.NewInternalError("Failed to stop the search backfill").WithInternal(err)
+	}
+	return nil
+}
+
+// Progress reads the state of the backfill from Redis
+func (b *Backfill) Progress(ctx context.Context) (*BackfillProgress, error) {
+	pipe := b.rdb.Pipeline()
+	stateCmd := pipe.HGetAll(ctx, backfillStateKey)
+	offsetsCmd := pipe.HGetAll(ctx, backfillOffsetsKey)
+	targetsCmd := pipe.HGetAll(ctx, backfillTargetsKey)
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return nil, apperrors.NewInternalError("Failed to read search backfill progress").WithInternal(err)
+	}
+
+	state := stateCmd.Val()
+	progress := summarize(parseOffsets(offsetsCmd.Val()), parseOffsets(targetsCmd.Val()))
+	progress.State = state["state"]
+	if progress.State == "" {
+		progress.State = BackfillIdle
+	}
+	progress.Instance = state["instance"]
+	progress.Records, _ = strconv.ParseInt(state["records"], 10, 64)
+	progress.Archived, _ = strconv.ParseInt(state["archived"], 10, 64)
+	progress.StartedAt = parseTime(state["started_at"])
+	progress.UpdatedAt = parseTime(state["updated_at"])
+	progress.Error = state["error"]
+	return progress, nil
+}
+
+// backfillRun is what a run reads: the next offset and the end offset of
+// each partition
+type backfillRun struct {
+	consumer *kafka.Consumer
+	offsets  map[int32]int64
+	targets  map[int32]int64
+}
+
+// prepare connects to Kafka and assigns the partitions left to apply. The
+// end offsets are recorded on the first run so new records, which the
+// history consumer archives, do not keep the backfill going.
+func (b *Backfill) prepare(ctx context.Context, reset bool) (*backfillRun, error) {
+	if reset {
+		if err := b.rdb.Del(ctx, backfillOffsetsKey, backfillTargetsKey, backfillStateKey).Err(); err != nil {
+			return nil, err
+		}
+	}
+	if err := b.rdb.Del(ctx, backfillStopKey).Err(); err != nil {
+		return nil, err
+	}
+
+	offsets, err := b.rdb.HGetAll(ctx, backfillOffsetsKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	targets, err := b.rdb.HGetAll(ctx, backfillTargetsKey).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	kc, err := kafka.NewConsumer(&kafka.ConfigMap{
+		"bootstrap.servers":  b.kafkaAddr,
+		"group.id":           "search-backfill",
+		"client.id":          "go-fiber-dashboard",
+		"auto.offset.reset":  "earliest",
+		"enable.auto.commit": false,
+
+		// The end of a partition may hold transaction markers that are
+		// never delivered, so reaching it also completes the partition
+		"enable.partition.eof": true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	run := &backfillRun{
+		consumer: kc,
+		offsets:  parseOffsets(offsets),
+		targets:  parseOffsets(targets),
+	}
+
+	if len(run.targets) == 0 {
+		topic := chat.HistoryTopic
+		metadata, err := kc.GetMetadata(&topic, false, int(backfillKafkaTimeout.Milliseconds()))
+		if err != nil {
+			kc.Close()
+			return nil, err
+		}
+		for _, p := range metadata.Topics[topic].Partitions {
+			_, high, err := kc.QueryWatermarkOffsets(topic, p.ID, int(backfillKafkaTimeout.Milliseconds()))
+			if err != nil {
+				kc.Close()
+				return nil, err
+			}
+			run.targets[p.ID] = high
+		}
+		if err := b.rdb.HSet(ctx, backfillTargetsKey, formatOffsets(run.targets)).Err(); err != nil {
+			kc.Close()
+			return nil, err
+		}
+	}
+
+	// Records before the low watermark were deleted by retention
+	topic := chat.HistoryTopic
+	var assignment []kafka.TopicPartition
+	for partition, target := range run.targets {
+		low, _, err := kc.QueryWatermarkOffsets(topic, partition, int(backfillKafkaTimeout.Milliseconds()))
+		if err != nil {
+			kc.Close()
+			return nil, err
+		}
+		offset := min(max(run.offsets[partition], low), target)
+		run.offsets[partition] = offset
+		if offset >= target {
+			continue
+		}
+		assignment = append(assignment, kafka.TopicPartition{Topic: &topic, Partition: partition, Offset: kafka.Offset(offset)})
+	}
+	if err := kc.Assign(assignment); err != nil {
+		kc.Close()
+		return nil, err
+	}
+
+	if err := b.rdb.HSet(ctx, backfillStateKey,
+		"state", BackfillRunning,
+		"instance", instance.ID(),
+		"started_at", time.Now().Format(time.RFC3339),
+		"updated_at", time.Now().Format(time.RFC3339),
+		"error", "",
+	).Err(); err != nil {
+		kc.Close()
+		return nil, err
+	}
+
+	return run, nil
+}
+
+// run applies records until every partition reached its target, ctx is
+// cancelled or a stop is requested, checkpointing as it goes
+func (b *Backfill) run(ctx context.Context, run *backfillRun) {
+	defer run.consumer.Close()
+	defer b.release()
+
+	logger.WithField("partitions", len(run.targets)).Info("Search backfill started")
+
+	var records, archived int64
+	lastCheckpoint := time.Now()
+	next := time.Now()
+
+	finish := func(state string, runErr error) {
+		b.checkpoint(run, &records, &archived, state, runErr)
+		logger.WithFields(map[string]any{
+			"state":    state,
+			"progress": summarize(run.offsets, run.targets).Percent,
+		}).Info("Search backfill finished")
+	}
+
+	for {
+		if complete(run.offsets, run.targets) {
+			finish(BackfillDone, nil)
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			finish(BackfillStopped, nil)
+			return
+		default:
+		}
+
+		if time.Since(lastCheckpoint) >= checkpointInterval {
+			if stop := b.checkpoint(run, &records, &archived, BackfillRunning, nil); stop {
+				finish(BackfillStopped, nil)
+				return
+			}
+			lastCheckpoint = time.Now()
+		}
+
+		switch e := run.consumer.Poll(int(backfillPollTimeout.Milliseconds())).(type) {
+		case *kafka.Message:
+			partition := e.TopicPartition.Partition
+			offset := int64(e.TopicPartition.Offset)
+			if offset >= run.targets[partition] {
+				// Newer records are the history consumer's
+				b.finishPartition(run, partition)
+				continue
+			}
+
+			// Pace the records so the backfill never floods Postgres
+			next = next.Add(b.pace)
+			if wait := time.Until(next); wait > 0 {
+				time.Sleep(wait)
+			} else {
+				next = time.Now()
+			}
+
+			result, err := b.apply(ctx, e)
+			if err != nil {
+				finish(BackfillStopped, nil)
+				return
+			}
+			backfillRecords.WithLabelValues(result).Inc()
+			records++
+			if result == "archived" {
+				archived++
+			}
+			run.offsets[partition] = offset + 1
+
+		case kafka.PartitionEOF:
+			if int64(e.Offset) >= run.targets[e.Partition] {
+				b.finishPartition(run, e.Partition)
+			}
+
+		case kafka.Error:
+			if e.IsFatal() {
+				finish(BackfillFailed, e)
+				return
+			}
+			logger.WithFields(map[string]any{
+				"code":  e.Code().String(),
+				"error": e.Error(),
+			}).Warn("Search backfill Kafka error")
+		}
+	}
+}
+
+// finishPartition marks a partition as applied up to its target and stops
+// fetching it
+func (b *Backfill) finishPartition(run *backfillRun, partition int32) {
+	run.offsets[partition] = run.targets[partition]
+
+	topic := chat.HistoryTopic
+	if err := run.consumer.Pause([]kafka.TopicPartition{{Topic: &topic, Partition: partition}}); err != nil {
+		logger.WithError(err).Debug("Failed to pause backfilled partition")
+	}
+}
+
+// apply archives a record, retrying failed writes like the history consumer.
+// It only fails when ctx is cancelled.
+func (b *Backfill) apply(ctx context.Context, record *kafka.Message) (string, error) {
+	var msg chat.ChatMessage
+	if err := json.Unmarshal(record.Value, &msg); err != nil {
+		return "invalid", nil
+	}
+
+	producedAt := record.Timestamp
+	if producedAt.IsZero() {
+		producedAt = time.Now()
+	}
+
+	for {
+		writeCtx, cancel := context.WithTimeout(ctx, backfillWriteTimeout)
+		result, err := chat.Archive(writeCtx, b.qdb, &msg, producedAt)
+		cancel()
+		if err == nil {
+			return result, nil
+		}
+
+		backfillRecords.WithLabelValues("failed").Inc()
+		logger.WithFields(map[string]any{
+			"message_id": msg.MessageID,
+			"error":      err.Error(),
+		}).Warn("Search backfill failed to archive a record, retrying")
+
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(chat.RetryBackoff):
+		}
+	}
+}
+
+// checkpoint saves the offsets and counts, refreshes the lock and reports
+// whether a stop was requested. The counts are reset once added.
+func (b *Backfill) checkpoint(run *backfillRun, records, archived *int64, state string, runErr error) bool {
+	// Checkpoints are written even when the run was cancelled
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	errMsg := ""
+	if runErr != nil {
+		errMsg = runErr.Error()
+	}
+
+	pipe := b.rdb.TxPipeline()
+	if len(run.offsets) > 0 {
+		pipe.HSet(ctx, backfillOffsetsKey, formatOffsets(run.offsets))
+	}
+	pipe.HIncrBy(ctx, backfillStateKey, "records", *records)
+	pipe.HIncrBy(ctx, backfillStateKey, "archived", *archived)
+	pipe.HSet(ctx, backfillStateKey, "state", state, "updated_at", time.Now().Format(time.RFC3339), "error", errMsg)
+	pipe.Expire(ctx, backfillLockKey, backfillLockTTL)
+	stop := pipe.Exists(ctx, backfillStopKey)
+	if _, err := pipe.Exec(ctx); err != nil {
+		logger.WithError(err).Warn("Failed to checkpoint search backfill")
+		return false
+	}
+
+	*records, *archived = 0, 0
+	return stop.Val() > 0
+}
+
+// release forgets the run and gives up the lock if this instance holds it
+func (b *Backfill) release() {
+	b.mu.Lock()
+	b.cancel = nil
+	b.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	if holder, err := b.rdb.Get(ctx, backfillLockKey).Result(); err == nil && holder == instance.ID() {
+		b.rdb.Del(ctx, backfillLockKey)
+	}
+}
+
+// complete reports whether every partition reached its target
+func complete(offsets, targets map[int32]int64) bool {
+	for partition, target := range targets {
+		if offsets[partition] < target {
+			return false
+		}
+	}
+	return true
+}
+
+// summarize computes the progress per partition and overall. Offsets count
+// from zero, so records deleted by retention count as applied.
+func summarize(offsets, targets map[int32]int64) *BackfillProgress {
+	progress := &BackfillProgress{Partitions: make([]PartitionProgress, 0, len(targets))}
+
+	var done, total int64
+	for partition, target := range targets {
+		offset := min(offsets[partition], target)
+		progress.Partitions = append(progress.Partitions, PartitionProgress{
+			Partition: partition,
+			Offset:    offset,
+			Target:    target,
+		})
+		done += offset
+		total += target
+	}
+	sort.Slice(progress.Partitions, func(i, j int) bool {
+		return progress.Partitions[i].Partition < progress.Partitions[j].Partition
+	})
+
+	progress.Remaining = total - done
+	if total > 0 {
+		progress.Percent = float64(done) * 100 / float64(total)
+	} else if len(targets) > 0 {
+		progress.Percent = 100
+	}
+	return progress
+}
+
+func parseOffsets(hash map[string]string) map[int32]int64 {
+	offsets := make(map[int32]int64, len(hash))
+	for field, value := range hash {
+		partition, err := strconv.ParseInt(field, 10, 32)
+		if err != nil {
+			continue
+		}
+		offset, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			continue
+		}
+		offsets[int32(partition)] = offset
+	}
+	return offsets
+}
+
+func formatOffsets(offsets map[int32]int64) map[string]any {
+	hash := make(map[string]any, len(offsets))
+	for partition, offset := range offsets {
+		hash[strconv.Itoa(int(partition))] = offset
+	}
+	return hash
+}
+
+func parseTime(value string) *time.Time {
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil
+	}
+	return &t
+}
@@ -0,0 +1,42 @@
+package search
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSummarize(t *testing.T) {
+	offsets := map[int32]int64{0: 50, 1: 120}
+	targets := map[int32]int64{1: 100, 0: 100, 2: 0}
+
+	progress := summarize(offsets, targets)
+
+	assert.Equal(t, []PartitionProgress{
+		{Partition: 0, Offset: 50, Target: 100},
+		{Partition: 1, Offset: 100, Target: 100},
+		{Partition: 2, Offset: 0, Target: 0},
+	}, progress.Partitions)
+	assert.Equal(t, int64(50), progress.Remaining)
+	assert.Equal(t, 75.0, progress.Percent)
+}
+
+func TestSummarizeEmptyTopic(t *testing.T) {
+	assert.Equal(t, 100.0, summarize(nil, map[int32]int64{0: 0}).Percent)
+	assert.Zero(t, summarize(nil, nil).Percent)
+}
+
+func TestComplete(t *testing.T) {
+	targets := map[int32]int64{0: 10, 1: 5}
+
+	assert.False(t, complete(map[int32]int64{0: 10}, targets))
+	assert.False(t, complete(map[int32]int64{0: 10, 1: 4}, targets))
+	assert.True(t, complete(map[int32]int64{0: 10, 1: 5}, targets))
+	assert.True(t, complete(nil, nil))
+}
+
+func TestParseOffsets(t *testing.T) {
+	offsets := parseOffsets(map[string]string{"0": "42", "3": "7", "bogus": "1", "4": "x"})
+
+	assert.Equal(t, map[int32]int64{0: 42, 3: 7}, offsets)
+}
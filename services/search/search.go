@@ -0,0 +1,184 @@
+package search
+
+import (
+	"context"
+	"exc6/apperrors"
+	"exc6/db"
+	"exc6/pkg/logger"
+	"exc6/pkg/pagination"
+	"exc6/services/chat"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+// Search finds text messages across all conversations of a user with
+// Postgres full-text search. Messages are indexed as they are written to the
+// messages table: direct messages when they are sent, group messages when the
+// history consumer archives them from Kafka, so a group message becomes
+// searchable once the consumer caught up. Queries use web search syntax
+// ("quoted phrases", or, -excluded) over whole words, ignoring case; results
+// are newest first and carry the byte offsets of the matched words for
+// highlighting. Deleted messages and GIFs are not searched.
+
+// Result is a message matching a search
+type Result struct {
+	Message *chat.ChatMessage `json:"message"`
+	Matches []chat.Match      `json:"matches"`
+}
+
+// Service searches messages
+type Service struct {
+	qdb *db.Queries
+
+	// backfill indexes history missing from Postgres; may be nil
+	backfill *Backfill
+}
+
+// NewService creates a search service
+func NewService(qdb *db.Queries) *Service {
+	return &Service{qdb: qdb}
+}
+
+// SetBackfill enables backfilling the index from Kafka
+func (s *Service) SetBackfill(b *Backfill) {
+	s.backfill = b
+}
+
+// Backfill returns the index backfill, or nil if it is not enabled
+func (s *Service) Backfill() *Backfill {
+	return s.backfill
+}
+
+// Search returns the messages username can read that match query. with
+// limits the search to one conversation: a group ID or a contact's username.
+func (s *Service) Search(ctx context.Context, username, query, with string, page pagination.Params) (pagination.Page[Result], error) {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return pagination.Page[Result]{}, apperrors.NewValidationError("Search query is required")
+	}
+	if utf8.RuneCountInString(query) > chat.MaxSearchLength {
+		return pagination.Page[Result]{}, apperrors.NewValidationError(fmt.Sprintf("Search query cannot be longer than %d characters", chat.MaxSearchLength))
+	}
+
+	params := db.SearchMessagesParams{
+		Username:        username,
+		Query:           query,
+		BeforeCreatedAt: time.Now().Add(time.Hour),
+		RowLimit:        int32(pagination.ClampLimit(page.Limit) + 1),
+	}
+
+	if with = strings.TrimSpace(with); with != "" {
+		if groupID, err := uuid.Parse(with); err == nil {
+			params.WithGroup = uuid.NullUUID{UUID: groupID, Valid: true}
+		} else {
+			params.WithUser = with
+		}
+	}
+
+	if page.After != nil {
+		nanos, err := pagination.ParseIntKey(page.After.Key)
+		if err != nil {
+			return pagination.Page[Result]{}, apperrors.NewValidationError("Invalid pagination cursor")
+		}
+		params.BeforeCreatedAt = time.Unix(0, nanos)
+		params.BeforeMessageID = page.After.ID
+	}
+
+	rows, err := s.qdb.SearchMessages(ctx, params)
+	if err != nil {
+		logger.WithFields(map[string]any{
+			"username": username,
+			"with":     with,
+			"error":    err.Error(),
+		}).Error("Failed to search messages")
+		return pagination.Page[Result]{}, apperrors.NewDatabaseError("search messages", err)
+	}
+
+	rowPage := pagination.New(rows, page, func(row db.SearchMessagesRow) pagination.Cursor {
+		return pagination.Cursor{Key: pagination.TimeKey(row.CreatedAt), ID: row.MessageID}
+	})
+
+	words := queryTerms(query)
+	results := make([]Result, 0, len(rowPage.Items))
+	for _, row := range rowPage.Items {
+		msg := &chat.ChatMessage{
+			MessageID: row.MessageID,
+			FromID:    row.FromUsername,
+			ToID:      row.ToUsername,
+			Content:   row.Content,
+			Timestamp: row.CreatedAt.Unix(),
+		}
+		if row.GroupID.Valid {
+			msg.GroupID = row.GroupID.UUID.String()
+			msg.IsGroup = true
+		}
+		if row.EditedAt.Valid {
+			msg.EditedAt = row.EditedAt.Time.Unix()
+		}
+		results = append(results, Result{
+			Message: msg,
+			Matches: highlight(row.Content, words),
+		})
+	}
+
+	return pagination.Page[Result]{
+		Items:      results,
+		NextCursor: rowPage.NextCursor,
+		HasMore:    rowPage.HasMore,
+	}, nil
+}
+
+// queryTerms returns the words of a web search query that a match contains,
+// leaving out the or operator and excluded words
+func queryTerms(query string) []string {
+	var terms []string
+	for _, field := range strings.Fields(query) {
+		if strings.HasPrefix(field, "-") || strings.EqualFold(field, "or") {
+			continue
+		}
+		terms = append(terms, strings.FieldsFunc(field, isSeparator)...)
+	}
+	return terms
+}
+
+// highlight returns the words of content equal to one of terms, ignoring
+// case, as byte offsets
+func highlight(content string, terms []string) []chat.Match {
+	var matches []chat.Match
+	for start := 0; start < len(content); {
+		r, size := utf8.DecodeRuneInString(content[start:])
+		if isSeparator(r) {
+			start += size
+			continue
+		}
+
+		end := start + size
+		for end < len(content) {
+			r, size := utf8.DecodeRuneInString(content[end:])
+			if isSeparator(r) {
+				break
+			}
+			end += size
+		}
+
+		for _, term := range terms {
+			if strings.EqualFold(content[start:end], term) {
+				matches = append(matches, chat.Match{Start: start, End: end})
+				break
+			}
+		}
+		start = end
+	}
+	return matches
+}
+
+// isSeparator reports whether r separates words, roughly as the Postgres
+// text search parser splits them
+func isSeparator(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+}
@@ -0,0 +1,49 @@
+package search
+
+import (
+	"exc6/services/chat"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueryTerms(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  []string
+	}{
+		{name: "Words", query: "lunch tomorrow", want: []string{"lunch", "tomorrow"}},
+		{name: "Phrase", query: `"see you" soon`, want: []string{"see", "you", "soon"}},
+		{name: "Or operator", query: "pizza or pasta", want: []string{"pizza", "pasta"}},
+		{name: "Excluded word", query: "meeting -cancelled", want: []string{"meeting"}},
+		{name: "Punctuation", query: "hello, world!", want: []string{"hello", "world"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, queryTerms(tt.query))
+		})
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		terms   []string
+		want    []chat.Match
+	}{
+		{name: "Whole words only", content: "cat concat cat", terms: []string{"cat"}, want: []chat.Match{{Start: 0, End: 3}, {Start: 11, End: 14}}},
+		{name: "Ignores case", content: "Hello HELLO", terms: []string{"hello"}, want: []chat.Match{{Start: 0, End: 5}, {Start: 6, End: 11}}},
+		{name: "Several terms", content: "see you soon!", terms: []string{"soon", "see"}, want: []chat.Match{{Start: 0, End: 3}, {Start: 8, End: 12}}},
+		{name: "Byte offsets after multibyte text", content: "café au lait", terms: []string{"lait"}, want: []chat.Match{{Start: 9, End: 13}}},
+		{name: "No match", content: "nothing here", terms: []string{"else"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, highlight(tt.content, tt.terms))
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package sessions
+
+import (
+	"exc6/pkg/instance"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Each instance keeps recently used sessions in an LRU so requests stay
+// authenticated while Redis is unreachable.
+
+// DefaultCacheCapacity is the number of sessions kept locally unless
+// SetCacheCapacity says otherwise
+const DefaultCacheCapacity = 10000
+
+var (
+	cacheLookups = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "session_cache_lookups_total",
+			Help: "Local session cache lookups, by result",
+		},
+		[]string{"result"}, // result: hit, miss
+	)
+
+	cacheEvictions = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "session_cache_evictions_total",
+			Help: "Sessions evicted from the local cache to make room",
+		},
+	)
+
+	cacheSize = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "session_cache_size",
+			Help: "Sessions in the local cache",
+		},
+	)
+)
+
+func init() {
+	instance.Registerer().MustRegister(cacheLookups)
+	instance.Registerer().MustRegister(cacheEvictions)
+	instance.Registerer().MustRegister(cacheSize)
+}
+
+// CachedSession is a session in the local cache with its ID masked
+type CachedSession struct {
+	SessionID    string    `json:"session_id"`
+	UserID       string    `json:"user_id"`
+	Username     string    `json:"username"`
+	LastActivity time.Time `json:"last_activity"`
+	LoginTime    time.Time `json:"login_time"`
+}
+
+// CacheSnapshot describes the local cache
+type CacheSnapshot struct {
+	Capacity int             `json:"capacity"`
+	Size     int             `json:"size"`
+	Sessions []CachedSession `json:"sessions"`
+}
+
+// SetCacheCapacity sets how many sessions are kept locally, evicting the
+// least recently used ones if the cache is over the new capacity
+func (smngr *SessionManager) SetCacheCapacity(capacity int) {
+	smngr.cacheMu.Lock()
+	defer smngr.cacheMu.Unlock()
+
+	smngr.capacity = max(capacity, 1)
+	for smngr.evictList.Len() > smngr.capacity {
+		smngr.evictOldest()
+	}
+	cacheSize.Set(float64(smngr.evictList.Len()))
+}
+
+// CachedSessions returns up to limit cached sessions, most recently used
+// first. Session IDs are bearer credentials, so only a prefix is shown.
+func (smngr *SessionManager) CachedSessions(limit int) CacheSnapshot {
+	smngr.cacheMu.RLock()
+	defer smngr.cacheMu.RUnlock()
+
+	snapshot := CacheSnapshot{
+		Capacity: smngr.capacity,
+		Size:     smngr.evictList.Len(),
+		Sessions: make([]CachedSession, 0, min(limit, smngr.evictList.Len())),
+	}
+	for elem := smngr.evictList.Front(); elem != nil && len(snapshot.Sessions) < limit; elem = elem.Next() {
+		s := elem.Value.(*Session)
+		snapshot.Sessions = append(snapshot.Sessions, CachedSession{
+			SessionID:    maskSessionID(s.SessionID),
+			UserID:       s.UserID,
+			Username:     s.Username,
+			LastActivity: time.Unix(s.LastActivity, 0),
+			LoginTime:    time.Unix(s.LoginTime, 0),
+		})
+	}
+	return snapshot
+}
+
+// evictOldest drops the least recently used session; cacheMu must be held
+func (smngr *SessionManager) evictOldest() {
+	oldest := smngr.evictList.Back()
+	if oldest == nil {
+		return
+	}
+	smngr.evictList.Remove(oldest)
+	delete(smngr.cache, oldest.Value.(*Session).SessionID)
+	cacheEvictions.Inc()
+}
+
+// maskSessionID keeps enough of an ID to tell sessions apart
+func maskSessionID(id string) string {
+	if len(id) <= 8 {
+		return "****"
+	}
+	return id[:8] + "****"
+}
@@ -0,0 +1,29 @@
+package sessions
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCacheCapacity(t *testing.T) {
+	smngr := NewSessionManager(nil)
+	smngr.SetCacheCapacity(3)
+
+	for i := 0; i < 5; i++ {
+		smngr.updateCache(NewSession(fmt.Sprintf("session-%d-abcdef", i), "u", fmt.Sprintf("user%d", i), 0, 0))
+	}
+
+	snapshot := smngr.CachedSessions(10)
+	assert.Equal(t, 3, snapshot.Capacity)
+	assert.Equal(t, 3, snapshot.Size)
+	assert.Equal(t, "user4", snapshot.Sessions[0].Username, "most recently used first")
+	assert.Equal(t, "session-****", snapshot.Sessions[0].SessionID)
+
+	// Shrinking evicts the least recently used sessions
+	smngr.SetCacheCapacity(1)
+	snapshot = smngr.CachedSessions(10)
+	assert.Equal(t, 1, snapshot.Size)
+	assert.Equal(t, "user4", snapshot.Sessions[0].Username)
+}
@@ -0,0 +1,154 @@
+package sessions
+
+import (
+	"context"
+	"exc6/db"
+	"exc6/pkg/breaker"
+	"exc6/pkg/logger"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sony/gobreaker"
+)
+
+// durableCleanupInterval is how often expired sessions are purged from Postgres
+const durableCleanupInterval = time.Hour
+
+// durableStore keeps a copy of every session in Postgres so a Redis outage
+// longer than the local cache lifetime does not log users out. Writes are
+// best effort and happen in the background; reads only on a Redis miss.
+type durableStore struct {
+	qdb *db.Queries
+	cb  *gobreaker.CircuitBreaker
+}
+
+// SetDurableStore enables the Postgres session fallback. Expired rows are
+// purged until ctx is cancelled.
+func (smngr *SessionManager) SetDurableStore(ctx context.Context, qdb *db.Queries) {
+	smngr.durable = &durableStore{
+		qdb: qdb,
+		cb: breaker.New(breaker.Config{
+			Name:        "postgres-sessions",
+			MaxRequests: 5,
+			Interval:    60 * time.Second,
+			Timeout:     30 * time.Second,
+			Threshold:   0.5,
+			MinRequests: 5,
+		}),
+	}
+
+	go smngr.durable.cleanup(ctx)
+}
+
+func (ds *durableStore) save(ctx context.Context, session *Session) {
+	userID, err := uuid.Parse(session.UserID)
+	if err != nil {
+		logger.WithFields(map[string]interface{}{
+			"session_id": session.SessionID,
+			"user_id":    session.UserID,
+			"error":      err.Error(),
+		}).Warn("Not persisting session with an invalid user ID to Postgres")
+		return
+	}
+
+	_, err = breaker.ExecuteCtx(ctx, ds.cb, func() (interface{}, error) {
+		return nil, ds.qdb.UpsertSession(ctx, db.UpsertSessionParams{
+			SessionID:    session.SessionID,
+			UserID:       userID,
+			Username:     session.Username,
+			LoginTime:    session.LoginTime,
+			LastActivity: session.LastActivity,
+			ExpiresAt:    time.Now().Add(sessionTTL),
+		})
+	})
+	if err != nil {
+		logger.WithFields(map[string]interface{}{
+			"session_id": session.SessionID,
+			"error":      err.Error(),
+		}).Warn("Circuit breaker: Failed to persist session to Postgres")
+	}
+}
+
+// get returns the session, or nil if it is unknown or expired
+func (ds *durableStore) get(ctx context.Context, sessionID string) *Session {
+	result, err := breaker.ExecuteCtx(ctx, ds.cb, func() (interface{}, error) {
+		row, err := ds.qdb.GetSession(ctx, sessionID)
+		if err != nil {
+			return nil, err
+		}
+		return row, nil
+	})
+	if err != nil {
+		logger.WithFields(map[string]interface{}{
+			"session_id": sessionID,
+			"error":      err.Error(),
+		}).Warn("Circuit breaker: Failed to read session from Postgres")
+		return nil
+	}
+
+	// Unknown and expired sessions are sql.ErrNoRows, which the breaker
+	// passes on as no result rather than as a failure
+	row, ok := result.(db.Session)
+	if !ok || row.SessionID == "" {
+		return nil
+	}
+
+	return NewSession(row.SessionID, row.UserID.String(), row.Username, row.LastActivity, row.LoginTime)
+}
+
+// touch extends the session; it reports whether the session still exists
+func (ds *durableStore) touch(ctx context.Context, sessionID string) bool {
+	result, err := breaker.ExecuteCtx(ctx, ds.cb, func() (interface{}, error) {
+		return ds.qdb.TouchSession(ctx, db.TouchSessionParams{
+			SessionID:    sessionID,
+			LastActivity: time.Now().Unix(),
+			ExpiresAt:    time.Now().Add(sessionTTL),
+		})
+	})
+	if err != nil {
+		logger.WithFields(map[string]interface{}{
+			"session_id": sessionID,
+			"error":      err.Error(),
+		}).Warn("Circuit breaker: Failed to renew session in Postgres")
+		return false
+	}
+
+	rows, _ := result.(int64)
+	return rows > 0
+}
+
+func (ds *durableStore) delete(ctx context.Context, sessionID string) {
+	if _, err := breaker.ExecuteCtx(ctx, ds.cb, func() (interface{}, error) {
+		return nil, ds.qdb.DeleteSession(ctx, sessionID)
+	}); err != nil {
+		logger.WithFields(map[string]interface{}{
+			"session_id": sessionID,
+			"error":      err.Error(),
+		}).Warn("Circuit breaker: Failed to delete session from Postgres")
+	}
+}
+
+func (ds *durableStore) cleanup(ctx context.Context) {
+	ticker := time.NewTicker(durableCleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			cleanupCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+			result, err := breaker.ExecuteCtx(cleanupCtx, ds.cb, func() (interface{}, error) {
+				return ds.qdb.DeleteExpiredSessions(cleanupCtx)
+			})
+			cancel()
+
+			if err != nil {
+				logger.WithError(err).Warn("Circuit breaker: Failed to purge expired sessions")
+			} else if purged, _ := result.(int64); purged > 0 {
+				logger.WithField("purged", purged).Debug("Purged expired sessions from Postgres")
+			}
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package sessions
+
+import (
+	"context"
+	"exc6/tests/fakedb"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestDurableFallback looks sessions up with Redis down, so only the
+// Postgres copy can vouch for them
+func TestDurableFallback(t *testing.T) {
+	userID := uuid.New()
+	now := time.Now()
+
+	fake := fakedb.New(t)
+	stored := map[string]time.Time{
+		"valid":   now.Add(time.Hour),
+		"expired": now.Add(-time.Minute),
+	}
+	// As the query does, leave out expired rows
+	fake.On("GetSession", func(args []any) (fakedb.Result, error) {
+		id := args[0].(string)
+		expiresAt, ok := stored[id]
+		if !ok || !expiresAt.After(time.Now()) {
+			return fakedb.Result{}, nil
+		}
+		return fakedb.Row(id, userID, "alice", now.Unix(), now.Unix(), expiresAt), nil
+	})
+
+	rdb := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", MaxRetries: -1, DialerRetries: 1})
+	t.Cleanup(func() { rdb.Close() })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	smngr := NewSessionManager(rdb)
+	smngr.SetDurableStore(ctx, fake.Queries())
+
+	for _, id := range []string{"unknown", "expired"} {
+		session, err := smngr.GetSession(ctx, id)
+		require.NoError(t, err)
+		assert.Nil(t, session, "%s session", id)
+	}
+
+	session, err := smngr.GetSession(ctx, "valid")
+	require.NoError(t, err)
+	require.NotNil(t, session)
+	assert.Equal(t, "alice", session.Username)
+	assert.Equal(t, userID.String(), session.UserID)
+
+	// Now cached locally; unknown sessions must not have been
+	cached, _ := smngr.getFromLocalCache("valid")
+	assert.NotNil(t, cached)
+	cached, _ = smngr.getFromLocalCache("unknown")
+	assert.Nil(t, cached)
+}
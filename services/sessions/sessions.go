@@ -3,7 +3,9 @@ package sessions
 import (
 	"container/list"
 	"context"
+	"errors"
 	"exc6/pkg/breaker"
+	"exc6/pkg/keyspace"
 	"exc6/pkg/logger"
 	"fmt"
 	"strconv"
@@ -14,12 +16,28 @@ import (
 	"github.com/sony/gobreaker"
 )
 
+// sessionTTL is how long a session lives without activity
+const sessionTTL = 24 * time.Hour
+
+var errIncompleteSession = errors.New("session data is incomplete")
+
+func init() {
+	keyspace.Register(keyspace.Family{Prefix: "session:", Description: "login sessions"})
+}
+
 type Session struct {
 	SessionID    string
 	UserID       string
 	Username     string
 	LastActivity int64
 	LoginTime    int64
+
+	// Impersonator is the admin acting as the user in this session, if any
+	Impersonator string
+
+	// ExpiresAt is when the session ends regardless of activity, in Unix
+	// seconds; zero for sessions that live as long as they are used
+	ExpiresAt int64
 }
 
 func NewSession(sessionID, userID, username string, lastActivity, loginTime int64) *Session {
@@ -33,13 +51,33 @@ func NewSession(sessionID, userID, username string, lastActivity, loginTime int6
 }
 
 func (s *Session) Marshal() map[string]any {
-	return map[string]any{
+	data := map[string]any{
 		"session_id":    s.SessionID,
 		"user_id":       s.UserID,
 		"username":      s.Username,
 		"last_activity": s.LastActivity,
 		"login_time":    s.LoginTime,
 	}
+	if s.Impersonator != "" {
+		data["impersonator"] = s.Impersonator
+	}
+	if s.ExpiresAt != 0 {
+		data["expires_at"] = s.ExpiresAt
+	}
+	return data
+}
+
+// Expired reports whether the session has reached its ExpiresAt
+func (s *Session) Expired(now time.Time) bool {
+	return s.ExpiresAt != 0 && now.Unix() >= s.ExpiresAt
+}
+
+// ttl is how long Redis keeps the session without activity
+func (s *Session) ttl() time.Duration {
+	if s.ExpiresAt != 0 {
+		return max(time.Until(time.Unix(s.ExpiresAt, 0)), time.Second)
+	}
+	return sessionTTL
 }
 
 func (s *Session) Unmarshal(data map[string]string) error {
@@ -47,6 +85,12 @@ func (s *Session) Unmarshal(data map[string]string) error {
 	s.UserID = data["user_id"]
 	s.Username = data["username"]
 
+	// A partial hash, e.g. one recreated by a field update after expiry,
+	// must not authenticate as an empty user
+	if s.SessionID == "" || s.Username == "" {
+		return errIncompleteSession
+	}
+
 	var err error
 	s.LastActivity, err = strconv.ParseInt(data["last_activity"], 10, 64)
 	if err != nil {
@@ -54,6 +98,15 @@ func (s *Session) Unmarshal(data map[string]string) error {
 	}
 
 	s.LoginTime, err = strconv.ParseInt(data["login_time"], 10, 64)
+	if err != nil {
+		return err
+	}
+
+	s.Impersonator = data["impersonator"]
+	s.ExpiresAt = 0
+	if v, ok := data["expires_at"]; ok {
+		s.ExpiresAt, err = strconv.ParseInt(v, 10, 64)
+	}
 	return err
 }
 
@@ -66,6 +119,9 @@ type SessionManager struct {
 	evictList *list.List
 	capacity  int
 	cacheMu   sync.RWMutex
+
+	// durable is the optional Postgres fallback; nil when disabled
+	durable *durableStore
 }
 
 func NewSessionManager(rdb *redis.Client) *SessionManager {
@@ -81,7 +137,7 @@ func NewSessionManager(rdb *redis.Client) *SessionManager {
 		}),
 		cache:     make(map[string]*list.Element),
 		evictList: list.New(),
-		capacity:  10000, // Max 10k local sessions
+		capacity:  DefaultCacheCapacity,
 	}
 }
 
@@ -98,17 +154,13 @@ func (smngr *SessionManager) updateCache(session *Session) {
 
 	// Evict if full
 	if smngr.evictList.Len() >= smngr.capacity {
-		oldest := smngr.evictList.Back()
-		if oldest != nil {
-			smngr.evictList.Remove(oldest)
-			s := oldest.Value.(*Session)
-			delete(smngr.cache, s.SessionID)
-		}
+		smngr.evictOldest()
 	}
 
 	// Add new
 	elem := smngr.evictList.PushFront(session)
 	smngr.cache[session.SessionID] = elem
+	cacheSize.Set(float64(smngr.evictList.Len()))
 }
 
 func (smngr *SessionManager) SaveSession(ctx context.Context, session *Session) error {
@@ -125,7 +177,7 @@ func (smngr *SessionManager) SaveSession(ctx context.Context, session *Session)
 		_, err := breaker.ExecuteCtx(bgCtx, smngr.cb, func() (interface{}, error) {
 			pipe := smngr.rdb.Pipeline()
 			pipe.HSet(bgCtx, sessionKey, session.Marshal())
-			pipe.Expire(bgCtx, sessionKey, 24*time.Hour)
+			pipe.Expire(bgCtx, sessionKey, session.ttl())
 			_, err := pipe.Exec(bgCtx)
 			return nil, err
 		})
@@ -136,12 +188,30 @@ func (smngr *SessionManager) SaveSession(ctx context.Context, session *Session)
 				"error":      err.Error(),
 			}).Error("Async session persistence to Redis failed (session remains in local cache)")
 		}
+
+		// The durable copy has no room for an impersonator, and impersonated
+		// sessions are short enough to do without it
+		if smngr.durable != nil && session.Impersonator == "" {
+			smngr.durable.save(bgCtx, session)
+		}
 	}()
 
 	return nil
 }
 
+// GetSession returns the session, or nil if it is unknown or expired
 func (smngr *SessionManager) GetSession(ctx context.Context, sessionID string) (*Session, error) {
+	session, err := smngr.getSession(ctx, sessionID)
+	if err != nil || session == nil {
+		return session, err
+	}
+	if session.Expired(time.Now()) {
+		return nil, nil
+	}
+	return session, nil
+}
+
+func (smngr *SessionManager) getSession(ctx context.Context, sessionID string) (*Session, error) {
 	sessionKey := "session:" + sessionID
 
 	// 1. Try to fetch from Redis
@@ -152,14 +222,14 @@ func (smngr *SessionManager) GetSession(ctx context.Context, sessionID string) (
 	// 2. Fallback to local cache if Redis fails or returns error
 	if err != nil {
 		logger.WithField("error", err).Warn("Circuit breaker open/error: Checking local session cache")
-		return smngr.getFromLocalCache(sessionID)
+		return smngr.getFromFallback(ctx, sessionID, false)
 	}
 
 	sessionData := result.(map[string]string)
 
 	// 3. If Redis returns empty, check local cache
 	if len(sessionData) == 0 {
-		return smngr.getFromLocalCache(sessionID)
+		return smngr.getFromFallback(ctx, sessionID, true)
 	}
 
 	session := &Session{}
@@ -173,6 +243,39 @@ func (smngr *SessionManager) GetSession(ctx context.Context, sessionID string) (
 	return session, nil
 }
 
+// getFromFallback looks a session up in the local cache, then in Postgres.
+// Sessions found in Postgres are written back to Redis when it is reachable.
+func (smngr *SessionManager) getFromFallback(ctx context.Context, sessionID string, redisUp bool) (*Session, error) {
+	if session, _ := smngr.getFromLocalCache(sessionID); session != nil || smngr.durable == nil {
+		return session, nil
+	}
+
+	session := smngr.durable.get(ctx, sessionID)
+	if session == nil {
+		return nil, nil
+	}
+
+	smngr.updateCache(session)
+
+	if redisUp {
+		go func() {
+			bgCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			sessionKey := "session:" + session.SessionID
+			breaker.ExecuteCtx(bgCtx, smngr.cb, func() (interface{}, error) {
+				pipe := smngr.rdb.Pipeline()
+				pipe.HSet(bgCtx, sessionKey, session.Marshal())
+				pipe.Expire(bgCtx, sessionKey, session.ttl())
+				_, err := pipe.Exec(bgCtx)
+				return nil, err
+			})
+		}()
+	}
+
+	return session, nil
+}
+
 // Helper to get from local cache with LRU promotion
 func (smngr *SessionManager) getFromLocalCache(sessionID string) (*Session, error) {
 	smngr.cacheMu.Lock() // Write lock needed for MoveToFront
@@ -180,8 +283,10 @@ func (smngr *SessionManager) getFromLocalCache(sessionID string) (*Session, erro
 
 	if elem, ok := smngr.cache[sessionID]; ok {
 		smngr.evictList.MoveToFront(elem)
+		cacheLookups.WithLabelValues("hit").Inc()
 		return elem.Value.(*Session), nil
 	}
+	cacheLookups.WithLabelValues("miss").Inc()
 	return nil, nil // Not found in either
 }
 
@@ -247,7 +352,14 @@ func (smngr *SessionManager) UpdateSessionField(ctx context.Context, sessionID,
 		if exists == 0 {
 			return nil, fmt.Errorf("session not found: %s", sessionID)
 		}
-		return nil, smngr.rdb.HSet(ctx, sessionKey, field, value).Err()
+
+		// ExpireNX only applies if the session expired since the check above
+		// and HSet recreated it, so the fragment cannot outlive sessionTTL
+		pipe := smngr.rdb.Pipeline()
+		pipe.HSet(ctx, sessionKey, field, value)
+		pipe.ExpireNX(ctx, sessionKey, sessionTTL)
+		_, err = pipe.Exec(ctx)
+		return nil, err
 	})
 
 	if err != nil {
@@ -284,11 +396,16 @@ func (smngr *SessionManager) RenewSession(ctx context.Context, sessionID string)
 
 		pipe := smngr.rdb.Pipeline()
 		pipe.HSet(ctx, sessionKey, "last_activity", time.Now().Unix())
-		pipe.Expire(ctx, sessionKey, 24*time.Hour)
+		pipe.Expire(ctx, sessionKey, sessionTTL)
 		_, err = pipe.Exec(ctx)
 		return nil, err
 	})
 
+	// Keep the durable copy alive too; it alone is enough to keep the session valid
+	if smngr.durable != nil && smngr.durable.touch(ctx, sessionID) {
+		err = nil
+	}
+
 	if err != nil {
 		logger.WithFields(map[string]interface{}{
 			"session_id": sessionID,
@@ -306,6 +423,7 @@ func (smngr *SessionManager) DeleteSession(ctx context.Context, sessionID string
 	if elem, ok := smngr.cache[sessionID]; ok {
 		smngr.evictList.Remove(elem)
 		delete(smngr.cache, sessionID)
+		cacheSize.Set(float64(smngr.evictList.Len()))
 	}
 	smngr.cacheMu.Unlock()
 
@@ -317,6 +435,10 @@ func (smngr *SessionManager) DeleteSession(ctx context.Context, sessionID string
 		breaker.ExecuteCtx(bgCtx, smngr.cb, func() (interface{}, error) {
 			return nil, smngr.rdb.Del(bgCtx, "session:"+sessionID).Err()
 		})
+
+		if smngr.durable != nil {
+			smngr.durable.delete(bgCtx, sessionID)
+		}
 	}()
 
 	return nil
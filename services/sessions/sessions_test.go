@@ -0,0 +1,134 @@
+package sessions
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessionUnmarshal(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    map[string]string
+		wantErr bool
+	}{
+		{
+			name: "Complete session",
+			data: map[string]string{
+				"session_id":    "s1",
+				"user_id":       "u1",
+				"username":      "alice",
+				"last_activity": "1700000000",
+				"login_time":    "1690000000",
+			},
+		},
+		{
+			name: "Impersonated session",
+			data: map[string]string{
+				"session_id":    "s1",
+				"user_id":       "u1",
+				"username":      "alice",
+				"last_activity": "1700000000",
+				"login_time":    "1690000000",
+				"impersonator":  "admin",
+				"expires_at":    "1700001800",
+			},
+		},
+		{
+			name: "Malformed expiry",
+			data: map[string]string{
+				"session_id":    "s1",
+				"username":      "alice",
+				"last_activity": "1700000000",
+				"login_time":    "1690000000",
+				"expires_at":    "soon",
+			},
+			wantErr: true,
+		},
+		{
+			name:    "Only a refreshed field",
+			data:    map[string]string{"last_activity": "1700000000"},
+			wantErr: true,
+		},
+		{
+			name: "Malformed timestamp",
+			data: map[string]string{
+				"session_id":    "s1",
+				"username":      "alice",
+				"last_activity": "yesterday",
+				"login_time":    "1690000000",
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := (&Session{}).Unmarshal(tt.data)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestSessionExpired(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+
+	assert.False(t, (&Session{}).Expired(now), "sessions without an expiry live on")
+	assert.False(t, (&Session{ExpiresAt: now.Unix() + 1}).Expired(now))
+	assert.True(t, (&Session{ExpiresAt: now.Unix()}).Expired(now))
+
+	s := &Session{SessionID: "s1", Username: "alice", Impersonator: "admin", ExpiresAt: now.Unix() + 60}
+	fields := make(map[string]string)
+	for k, v := range s.Marshal() {
+		fields[k] = fmt.Sprint(v)
+	}
+	var roundTrip Session
+	require.NoError(t, roundTrip.Unmarshal(fields))
+	assert.Equal(t, *s, roundTrip)
+}
+
+func FuzzSessionUnmarshal(f *testing.F) {
+	f.Add("s1", "u1", "alice", "1700000000", "1690000000")
+	f.Add("", "", "", "", "")
+	f.Add("s1", "u1", "alice", "-9223372036854775809", "0x10")
+
+	f.Fuzz(func(t *testing.T, sessionID, userID, username, lastActivity, loginTime string) {
+		data := map[string]string{
+			"session_id":    sessionID,
+			"user_id":       userID,
+			"username":      username,
+			"last_activity": lastActivity,
+			"login_time":    loginTime,
+		}
+
+		var s Session
+		if err := s.Unmarshal(data); err != nil {
+			return
+		}
+		require.NotEmpty(t, s.SessionID)
+		require.NotEmpty(t, s.Username)
+
+		// Marshal must produce a hash that reads back identically
+		fields := make(map[string]string)
+		for k, v := range s.Marshal() {
+			switch v := v.(type) {
+			case string:
+				fields[k] = v
+			case int64:
+				fields[k] = strconv.FormatInt(v, 10)
+			}
+		}
+
+		var roundTrip Session
+		require.NoError(t, roundTrip.Unmarshal(fields))
+		assert.Equal(t, s, roundTrip)
+	})
+}
@@ -0,0 +1,366 @@
+package status
+
+import (
+	"context"
+	"encoding/json"
+	"exc6/apperrors"
+	"exc6/pkg/breaker"
+	"exc6/pkg/keyspace"
+	"exc6/pkg/logger"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"github.com/sony/gobreaker"
+)
+
+// The public status page tells users whether a problem is on their end. Each
+// component reports a coarse level derived from the circuit breakers of the
+// backends it depends on and from a self-check each instance runs in the
+// background, so serving the page never touches a backend. Admins annotate
+// the page with incidents, which are kept in Redis so every instance shows
+// the same ones. The computed status is cached briefly per instance.
+
+const incidentsKey = "status:incidents"
+
+const (
+	// checkInterval is how often each instance runs the self-checks
+	checkInterval = 30 * time.Second
+
+	// checkTimeout bounds a single self-check
+	checkTimeout = 5 * time.Second
+
+	// cacheTTL is how long a computed status is served before it is rebuilt
+	cacheTTL = 15 * time.Second
+
+	// IncidentRetention is how long a resolved incident stays on the page
+	IncidentRetention = 7 * 24 * time.Hour
+)
+
+func init() {
+	keyspace.Register(keyspace.Family{Prefix: incidentsKey, Description: "incidents shown on the public status page"})
+}
+
+// Level is the availability of a component
+type Level string
+
+const (
+	LevelOperational Level = "operational" // working normally
+	LevelDegraded    Level = "degraded"    // some requests fail fast while a backend recovers
+	LevelOutage      Level = "outage"      // the self-check fails
+)
+
+// worse reports whether l is a less healthy level than other
+func (l Level) worse(other Level) bool {
+	rank := map[Level]int{LevelOperational: 0, LevelDegraded: 1, LevelOutage: 2}
+	return rank[l] > rank[other]
+}
+
+// Component is a user-facing feature on the status page
+type Component struct {
+	Name string
+
+	// Breakers names the circuit breakers of the backends it depends on
+	Breakers []string
+
+	// Check probes the component; nil skips the self-check
+	Check func(ctx context.Context) error
+}
+
+// ComponentStatus is the availability of one component
+type ComponentStatus struct {
+	Name  string `json:"name"`
+	Level Level  `json:"level"`
+}
+
+// Incident is an admin's annotation about a problem
+type Incident struct {
+	ID         string     `json:"id"`
+	Title      string     `json:"title"`
+	Message    string     `json:"message,omitempty"`
+	Components []string   `json:"components,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+}
+
+// Status is what the status page shows
+type Status struct {
+	Level      Level             `json:"level"`
+	Components []ComponentStatus `json:"components"`
+	Incidents  []Incident        `json:"incidents"`
+	UpdatedAt  time.Time         `json:"updated_at"`
+}
+
+// Service computes the public status and keeps incidents
+type Service struct {
+	rdb        *redis.Client
+	cb         *gobreaker.CircuitBreaker
+	components []Component
+
+	mu        sync.RWMutex
+	failing   map[string]bool // component name -> self-check failed
+	incidents []Incident      // last incidents read from Redis
+	cached    *Status
+}
+
+// NewService creates the service and runs the self-checks until ctx is
+// cancelled
+func NewService(ctx context.Context, rdb *redis.Client, components []Component) *Service {
+	s := &Service{
+		rdb:        rdb,
+		components: components,
+		failing:    make(map[string]bool, len(components)),
+		cb: breaker.New(breaker.Config{
+			Name:        "redis-status",
+			MaxRequests: 3,
+			Interval:    60 * time.Second,
+			Timeout:     30 * time.Second,
+			Threshold:   0.5,
+			MinRequests: 5,
+		}),
+	}
+
+	go s.run(ctx)
+
+	return s
+}
+
+// Status returns the current status, rebuilding it when the cached one is
+// older than cacheTTL
+func (s *Service) Status(ctx context.Context) Status {
+	now := time.Now()
+
+	s.mu.RLock()
+	cached := s.cached
+	s.mu.RUnlock()
+	if cached != nil && now.Sub(cached.UpdatedAt) < cacheTTL {
+		return *cached
+	}
+
+	incidents, err := s.loadIncidents(ctx, now)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		// Keep showing the last known incidents until Redis is back
+		logger.WithError(err).Debug("Failed to read status incidents")
+	} else {
+		s.incidents = incidents
+	}
+
+	status := evaluate(s.components, breaker.States(), s.failing)
+	status.Incidents = s.incidents
+	status.UpdatedAt = now
+	s.cached = &status
+	return status
+}
+
+// CreateIncident records an incident affecting components, which must be
+// names of known components
+func (s *Service) CreateIncident(ctx context.Context, title, message string, components []string, createdBy string) (*Incident, error) {
+	title = strings.TrimSpace(title)
+	if title == "" {
+		return nil, apperrors.NewValidationError("Incident title is required")
+	}
+	for _, name := range components {
+		if !slices.ContainsFunc(s.components, func(c Component) bool { return c.Name == name }) {
+			return nil, apperrors.NewValidationError("Unknown component: " + name)
+		}
+	}
+
+	incident := &Incident{
+		ID:         uuid.NewString(),
+		Title:      title,
+		Message:    strings.TrimSpace(message),
+		Components: components,
+		CreatedAt:  time.Now().UTC(),
+	}
+	if err := s.saveIncident(ctx, incident); err != nil {
+		return nil, err
+	}
+
+	logger.WithFields(map[string]any{
+		"incident_id": incident.ID,
+		"components":  components,
+		"created_by":  createdBy,
+	}).Info("Status incident created")
+
+	return incident, nil
+}
+
+// ResolveIncident marks an incident resolved; it stays on the page for
+// IncidentRetention
+func (s *Service) ResolveIncident(ctx context.Context, id string) (*Incident, error) {
+	result, err := breaker.ExecuteCtx(ctx, s.cb, func() (any, error) {
+		return s.rdb.HGet(ctx, incidentsKey, id).Bytes()
+	})
+	if err != nil {
+		return nil, apperrors.NewCacheError("status_incident_get", incidentsKey, err)
+	}
+	data, _ := result.([]byte)
+	if len(data) == 0 {
+		return nil, apperrors.New(apperrors.ErrCodeNotFound, "Incident not found", http.StatusNotFound)
+	}
+
+	var incident Incident
+	if err := json.Unmarshal(data, &incident); err != nil {
+		return nil, err
+	}
+	if incident.ResolvedAt == nil {
+		now := time.Now().UTC()
+		incident.ResolvedAt = &now
+		if err := s.saveIncident(ctx, &incident); err != nil {
+			return nil, err
+		}
+	}
+	return &incident, nil
+}
+
+// DeleteIncident removes an incident from the page
+func (s *Service) DeleteIncident(ctx context.Context, id string) error {
+	result, err := breaker.ExecuteCtx(ctx, s.cb, func() (any, error) {
+		return s.rdb.HDel(ctx, incidentsKey, id).Result()
+	})
+	if err != nil {
+		return apperrors.NewCacheError("status_incident_delete", incidentsKey, err)
+	}
+	if deleted, _ := result.(int64); deleted == 0 {
+		return apperrors.New(apperrors.ErrCodeNotFound, "Incident not found", http.StatusNotFound)
+	}
+	s.invalidate()
+	return nil
+}
+
+func (s *Service) saveIncident(ctx context.Context, incident *Incident) error {
+	data, err := json.Marshal(incident)
+	if err != nil {
+		return err
+	}
+
+	// Incidents nobody touched for IncidentRetention go with the key
+	if _, err := breaker.ExecuteCtx(ctx, s.cb, func() (any, error) {
+		pipe := s.rdb.TxPipeline()
+		pipe.HSet(ctx, incidentsKey, incident.ID, data)
+		pipe.Expire(ctx, incidentsKey, IncidentRetention)
+		_, err := pipe.Exec(ctx)
+		return nil, err
+	}); err != nil {
+		return apperrors.NewCacheError("status_incident_save", incidentsKey, err)
+	}
+	s.invalidate()
+	return nil
+}
+
+// loadIncidents reads the incidents still shown at now, newest first, and
+// drops the ones resolved longer than IncidentRetention ago
+func (s *Service) loadIncidents(ctx context.Context, now time.Time) ([]Incident, error) {
+	result, err := breaker.ExecuteCtx(ctx, s.cb, func() (any, error) {
+		return s.rdb.HGetAll(ctx, incidentsKey).Result()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	fields, _ := result.(map[string]string)
+	incidents := make([]Incident, 0, len(fields))
+	var expired []string
+	for id, data := range fields {
+		var incident Incident
+		if err := json.Unmarshal([]byte(data), &incident); err != nil {
+			continue
+		}
+		if incident.ResolvedAt != nil && now.Sub(*incident.ResolvedAt) > IncidentRetention {
+			expired = append(expired, id)
+			continue
+		}
+		incidents = append(incidents, incident)
+	}
+
+	if len(expired) > 0 {
+		if err := s.rdb.HDel(ctx, incidentsKey, expired...).Err(); err != nil {
+			logger.WithError(err).Debug("Failed to delete expired status incidents")
+		}
+	}
+
+	slices.SortFunc(incidents, func(a, b Incident) int {
+		return b.CreatedAt.Compare(a.CreatedAt)
+	})
+	return incidents, nil
+}
+
+// invalidate makes the next Status call rebuild the status, so an admin sees
+// their change on this instance right away
+func (s *Service) invalidate() {
+	s.mu.Lock()
+	s.cached = nil
+	s.mu.Unlock()
+}
+
+func (s *Service) run(ctx context.Context) {
+	s.check(ctx)
+
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.check(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// check runs the self-checks and records which components fail
+func (s *Service) check(ctx context.Context) {
+	failing := make(map[string]bool, len(s.components))
+	for _, c := range s.components {
+		if c.Check == nil {
+			continue
+		}
+
+		checkCtx, cancel := context.WithTimeout(ctx, checkTimeout)
+		err := c.Check(checkCtx)
+		cancel()
+		if err != nil {
+			failing[c.Name] = true
+			logger.WithFields(map[string]any{
+				"component": c.Name,
+				"error":     err.Error(),
+			}).Warn("Status self-check failed")
+		}
+	}
+
+	s.mu.Lock()
+	s.failing = failing
+	s.mu.Unlock()
+}
+
+// evaluate derives the level of each component from the breaker states by
+// name and the components whose self-check failed. The overall level is the
+// worst component's.
+func evaluate(components []Component, breakers map[string]string, failing map[string]bool) Status {
+	status := Status{Level: LevelOperational, Components: make([]ComponentStatus, 0, len(components))}
+	for _, c := range components {
+		level := LevelOperational
+		if failing[c.Name] {
+			level = LevelOutage
+		} else {
+			for _, name := range c.Breakers {
+				if state := breakers[name]; state == gobreaker.StateOpen.String() || state == gobreaker.StateHalfOpen.String() {
+					level = LevelDegraded
+				}
+			}
+		}
+
+		status.Components = append(status.Components, ComponentStatus{Name: c.Name, Level: level})
+		if level.worse(status.Level) {
+			status.Level = level
+		}
+	}
+	return status
+}
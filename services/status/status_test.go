@@ -0,0 +1,67 @@
+package status
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvaluate(t *testing.T) {
+	components := []Component{
+		{Name: "chat", Breakers: []string{"redis-chat", "kafka-chat"}},
+		{Name: "calls", Breakers: []string{"redis-calls"}},
+		{Name: "uploads"},
+	}
+
+	tests := []struct {
+		name     string
+		breakers map[string]string
+		failing  map[string]bool
+		want     []Level
+		overall  Level
+	}{
+		{
+			name:     "All closed",
+			breakers: map[string]string{"redis-chat": "closed", "kafka-chat": "closed", "redis-calls": "closed"},
+			want:     []Level{LevelOperational, LevelOperational, LevelOperational},
+			overall:  LevelOperational,
+		},
+		{
+			name:     "One breaker open",
+			breakers: map[string]string{"redis-chat": "closed", "kafka-chat": "open"},
+			want:     []Level{LevelDegraded, LevelOperational, LevelOperational},
+			overall:  LevelDegraded,
+		},
+		{
+			name:     "Breaker half-open",
+			breakers: map[string]string{"redis-calls": "half-open"},
+			want:     []Level{LevelOperational, LevelDegraded, LevelOperational},
+			overall:  LevelDegraded,
+		},
+		{
+			name:     "Self-check failed",
+			breakers: map[string]string{"redis-calls": "open"},
+			failing:  map[string]bool{"uploads": true},
+			want:     []Level{LevelOperational, LevelDegraded, LevelOutage},
+			overall:  LevelOutage,
+		},
+		{
+			name:    "Unknown breaker",
+			want:    []Level{LevelOperational, LevelOperational, LevelOperational},
+			overall: LevelOperational,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status := evaluate(components, tt.breakers, tt.failing)
+
+			levels := make([]Level, 0, len(status.Components))
+			for _, c := range status.Components {
+				levels = append(levels, c.Level)
+			}
+			assert.Equal(t, tt.want, levels)
+			assert.Equal(t, tt.overall, status.Level)
+		})
+	}
+}
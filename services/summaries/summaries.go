@@ -0,0 +1,259 @@
+package summaries
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"exc6/apperrors"
+	"exc6/config"
+	"exc6/pkg/breaker"
+	"exc6/pkg/keyspace"
+	"exc6/pkg/logger"
+	"exc6/services/chat"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/sony/gobreaker"
+)
+
+// A participant can ask for a summary of the latest messages of a direct or
+// group conversation. The transcript is sent to a Summarizer, by default an
+// LLM behind an HTTP API, so the feature is off for the whole organization
+// unless configured. Summaries are cached per conversation and transcript, so
+// asking again before anyone writes, edits or deletes a message reuses the
+// result, and every summary is marked as AI-generated.
+
+const cacheKeyPrefix = "summaries:"
+
+func init() {
+	keyspace.Register(keyspace.Family{Prefix: cacheKeyPrefix, Description: "cached AI conversation summaries"})
+}
+
+const (
+	// Notice is shown with every summary
+	Notice = "AI-generated summary. It may leave out or misstate what was said."
+
+	// maxLineLength bounds each message in the transcript, in characters
+	maxLineLength = 1000
+)
+
+// Line is a message of the transcript to summarize
+type Line struct {
+	From    string
+	Content string
+	At      time.Time
+}
+
+// Summarizer writes a summary of a transcript of at most maxLength characters
+type Summarizer interface {
+	Summarize(ctx context.Context, transcript []Line, maxLength int) (string, error)
+}
+
+// Conversation names a direct conversation by its other participant or a
+// group by ID
+type Conversation struct {
+	Contact string
+	GroupID string
+}
+
+// Summary is a generated summary of a conversation's latest messages
+type Summary struct {
+	Text         string    `json:"text"`
+	AIGenerated  bool      `json:"ai_generated"`
+	Notice       string    `json:"notice"`
+	Model        string    `json:"model"`
+	MessageCount int       `json:"message_count"`
+	Through      string    `json:"through"` // ID of the last message summarized
+	GeneratedAt  time.Time `json:"generated_at"`
+	Cached       bool      `json:"cached"`
+}
+
+// Service summarizes conversations
+type Service struct {
+	cfg        config.SummaryConfig
+	summarizer Summarizer
+	cs         chat.Service
+	rdb        *redis.Client
+	cb         *gobreaker.CircuitBreaker
+}
+
+// NewService creates the service. It returns nil when summaries are disabled.
+func NewService(cfg config.SummaryConfig, summarizer Summarizer, cs chat.Service, rdb *redis.Client) *Service {
+	if !cfg.Enabled || summarizer == nil {
+		return nil
+	}
+
+	return &Service{
+		cfg:        cfg,
+		summarizer: summarizer,
+		cs:         cs,
+		rdb:        rdb,
+		cb: breaker.New(breaker.Config{
+			Name:        "redis-summaries",
+			MaxRequests: 5,
+			Interval:    60 * time.Second,
+			Timeout:     30 * time.Second,
+			Threshold:   0.5,
+			MinRequests: 5,
+		}),
+	}
+}
+
+// Summarize returns a summary of the latest messages of a conversation of
+// username. Group membership is the caller's to check.
+func (s *Service) Summarize(ctx context.Context, username string, conv Conversation) (*Summary, error) {
+	var messages []*chat.ChatMessage
+	var conversationKey string
+	if conv.GroupID != "" {
+		history, err := s.cs.GetGroupHistory(ctx, conv.GroupID)
+		if err != nil {
+			return nil, apperrors.NewCacheError("group_history", conv.GroupID, err)
+		}
+		messages = history
+		conversationKey = "group:" + conv.GroupID
+	} else {
+		if conv.Contact == "" || conv.Contact == username {
+			return nil, apperrors.NewBadRequest("A contact or group is required")
+		}
+		history, err := s.cs.GetHistory(ctx, username, conv.Contact)
+		if err != nil {
+			return nil, err
+		}
+		messages = history.Value
+		conversationKey = s.cs.GetConversationKey(username, conv.Contact)
+	}
+
+	transcript, through := Transcript(messages, s.cfg.MaxMessages)
+	if len(transcript) == 0 {
+		return nil, apperrors.NewValidationError("There are no messages to summarize")
+	}
+
+	key := s.cacheKey(conversationKey, transcript)
+	if summary := s.cached(ctx, key); summary != nil {
+		summary.Cached = true
+		return summary, nil
+	}
+
+	text, err := s.summarizer.Summarize(ctx, transcript, s.cfg.MaxLength)
+	if err != nil {
+		logger.WithFields(map[string]any{
+			"username": username,
+			"group_id": conv.GroupID,
+			"error":    err.Error(),
+		}).Warn("Conversation summary failed")
+		return nil, err
+	}
+
+	text = truncate(strings.TrimSpace(text), s.cfg.MaxLength)
+	if text == "" {
+		return nil, apperrors.New(apperrors.ErrCodeServiceUnavail, "The summary provider returned no summary", http.StatusBadGateway)
+	}
+
+	summary := &Summary{
+		Text:         text,
+		AIGenerated:  true,
+		Notice:       Notice,
+		Model:        s.cfg.Model,
+		MessageCount: len(transcript),
+		Through:      through,
+		GeneratedAt:  time.Now(),
+	}
+	s.store(ctx, key, summary)
+
+	logger.WithFields(map[string]any{
+		"username": username,
+		"group_id": conv.GroupID,
+		"messages": len(transcript),
+	}).Info("Conversation summarized")
+
+	return summary, nil
+}
+
+// PerMinute is the per-user summary rate limit
+func (s *Service) PerMinute() int64 {
+	return int64(s.cfg.PerMinute)
+}
+
+// Transcript returns the latest max messages people wrote, oldest first, and
+// the ID of the last one. System notices and deleted messages are left out.
+func Transcript(messages []*chat.ChatMessage, max int) ([]Line, string) {
+	lines := make([]Line, 0, min(len(messages), max))
+	through := ""
+	for i := len(messages) - 1; i >= 0 && len(lines) < max; i-- {
+		msg := messages[i]
+		if msg.Deleted || msg.Subtype == chat.SubtypeSystem || msg.Event != "" {
+			continue
+		}
+		if through == "" {
+			through = msg.MessageID
+		}
+
+		content := truncate(msg.Content, maxLineLength)
+		switch msg.Subtype {
+		case chat.SubtypeGIF:
+			content = "[GIF]"
+		case chat.SubtypeAttachment:
+			content = "[Attachment]"
+		}
+		lines = append(lines, Line{From: msg.FromID, Content: content, At: time.Unix(msg.Timestamp, 0)})
+	}
+
+	for i, j := 0, len(lines)-1; i < j; i, j = i+1, j-1 {
+		lines[i], lines[j] = lines[j], lines[i]
+	}
+	return lines, through
+}
+
+// cacheKey covers every line of the transcript rather than its last message,
+// so an edit or delete anywhere in it misses the cache
+func (s *Service) cacheKey(conversationKey string, transcript []Line) string {
+	h := sha1.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%d", conversationKey, s.cfg.Model, s.cfg.MaxMessages)
+	for _, line := range transcript {
+		fmt.Fprintf(h, "\x00%s\x00%d\x00%s", line.From, line.At.Unix(), line.Content)
+	}
+	return cacheKeyPrefix + hex.EncodeToString(h.Sum(nil))
+}
+
+// cached returns a cached summary, or nil on a miss or Redis failure
+func (s *Service) cached(ctx context.Context, key string) *Summary {
+	result, err := breaker.ExecuteCtx(ctx, s.cb, func() (any, error) {
+		return s.rdb.Get(ctx, key).Bytes()
+	})
+	if err != nil || result == nil {
+		return nil
+	}
+
+	var summary Summary
+	if err := json.Unmarshal(result.([]byte), &summary); err != nil {
+		return nil
+	}
+	return &summary
+}
+
+func (s *Service) store(ctx context.Context, key string, summary *Summary) {
+	body, err := json.Marshal(summary)
+	if err != nil {
+		return
+	}
+
+	if _, err := breaker.ExecuteCtx(ctx, s.cb, func() (any, error) {
+		return nil, s.rdb.Set(ctx, key, body, s.cfg.CacheTTL).Err()
+	}); err != nil {
+		logger.WithError(err).Warn("Circuit breaker: Failed to cache summary")
+	}
+}
+
+// truncate shortens s to at most max characters, marking the cut
+func truncate(s string, max int) string {
+	if utf8.RuneCountInString(s) <= max {
+		return s
+	}
+	runes := []rune(s)
+	return fmt.Sprintf("%s…", strings.TrimSpace(string(runes[:max-1])))
+}
@@ -0,0 +1,114 @@
+package summaries
+
+import (
+	"context"
+	"exc6/config"
+	"exc6/services/chat"
+	"exc6/tests/fakeredis"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// groupChat serves a fixed group history
+type groupChat struct {
+	chat.Service
+	history []*chat.ChatMessage
+}
+
+func (g *groupChat) GetGroupHistory(ctx context.Context, groupID string) ([]*chat.ChatMessage, error) {
+	return g.history, nil
+}
+
+// countingSummarizer summarizes by counting the calls it gets
+type countingSummarizer struct {
+	calls int
+}
+
+func (c *countingSummarizer) Summarize(ctx context.Context, transcript []Line, maxLength int) (string, error) {
+	c.calls++
+	return "summary", nil
+}
+
+func TestTranscript(t *testing.T) {
+	messages := []*chat.ChatMessage{
+		{MessageID: "m1", FromID: "alice", Content: "first", Timestamp: 100},
+		{MessageID: "m2", FromID: "bob", Content: "you are now connected", Subtype: chat.SubtypeSystem, Timestamp: 101},
+		{MessageID: "m3", FromID: "bob", Content: "https://media.giphy.com/x.gif", Subtype: chat.SubtypeGIF, Timestamp: 102},
+		{MessageID: "m4", FromID: "alice", Deleted: true, Timestamp: 103},
+		{MessageID: "m5", FromID: "bob", Content: "last", Timestamp: 104},
+	}
+
+	t.Run("Latest messages people wrote, oldest first", func(t *testing.T) {
+		lines, through := Transcript(messages, 10)
+		assert.Equal(t, "m5", through)
+		assert.Equal(t, []Line{
+			{From: "alice", Content: "first", At: time.Unix(100, 0)},
+			{From: "bob", Content: "[GIF]", At: time.Unix(102, 0)},
+			{From: "bob", Content: "last", At: time.Unix(104, 0)},
+		}, lines)
+	})
+
+	t.Run("Limited to the latest max", func(t *testing.T) {
+		lines, through := Transcript(messages, 2)
+		assert.Equal(t, "m5", through)
+		assert.Len(t, lines, 2)
+		assert.Equal(t, "[GIF]", lines[0].Content)
+	})
+
+	t.Run("Nothing to summarize", func(t *testing.T) {
+		lines, through := Transcript(messages[1:2], 10)
+		assert.Empty(t, lines)
+		assert.Empty(t, through)
+	})
+}
+
+func TestSummaryCache(t *testing.T) {
+	history := &groupChat{history: []*chat.ChatMessage{
+		{MessageID: "m1", FromID: "alice", Content: "lunch at noon?", Timestamp: 100},
+		{MessageID: "m2", FromID: "bob", Content: "sure", Timestamp: 101},
+	}}
+	summarizer := &countingSummarizer{}
+	cfg := config.SummaryConfig{Enabled: true, Model: "test", MaxMessages: 10, MaxLength: 100, CacheTTL: time.Hour}
+	s := NewService(cfg, summarizer, history, fakeredis.New(t).Client(t))
+
+	ctx := context.Background()
+	conv := Conversation{GroupID: "g1"}
+	summarize := func() *Summary {
+		t.Helper()
+		summary, err := s.Summarize(ctx, "alice", conv)
+		require.NoError(t, err)
+		return summary
+	}
+
+	assert.False(t, summarize().Cached)
+	assert.True(t, summarize().Cached, "nothing changed")
+	assert.Equal(t, 1, summarizer.calls)
+
+	// An edit keeps the last message but changes the transcript
+	history.history[0] = &chat.ChatMessage{MessageID: "m1", FromID: "alice", Content: "lunch at one?", Timestamp: 100, EditedAt: 110}
+	assert.False(t, summarize().Cached, "edited")
+	assert.Equal(t, 2, summarizer.calls)
+
+	history.history[0] = &chat.ChatMessage{MessageID: "m1", FromID: "alice", Deleted: true, Timestamp: 100}
+	summary := summarize()
+	assert.False(t, summary.Cached, "deleted")
+	assert.Equal(t, 1, summary.MessageCount)
+	assert.Equal(t, 3, summarizer.calls)
+}
+
+func TestFormatTranscript(t *testing.T) {
+	at := time.Date(2026, 3, 1, 9, 30, 0, 0, time.UTC)
+	got := FormatTranscript([]Line{
+		{From: "alice", Content: "two\nlines", At: at},
+		{From: "bob", Content: "ok", At: at},
+	})
+	assert.Equal(t, "[2026-03-01 09:30] alice: two lines\n[2026-03-01 09:30] bob: ok\n", got)
+}
+
+func TestTruncate(t *testing.T) {
+	assert.Equal(t, "short", truncate("short", 10))
+	assert.Equal(t, "héllo…", truncate("héllo world", 6))
+}
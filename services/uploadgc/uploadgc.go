@@ -0,0 +1,375 @@
+package uploadgc
+
+import (
+	"context"
+	"exc6/config"
+	"exc6/db"
+	"exc6/pkg/breaker"
+	"exc6/pkg/instance"
+	"exc6/pkg/logger"
+	"exc6/services/uploads"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sony/gobreaker"
+)
+
+const (
+	// urlPrefix is where the uploads directory is served from
+	urlPrefix = "/uploads/"
+
+	// minAge skips files saved moments ago whose database row may not be
+	// written yet
+	minAge = time.Hour
+
+	// objectsPrefix is the directory of content-addressed uploads, relative
+	// to the uploads directory
+	objectsPrefix = "objects" + string(filepath.Separator)
+)
+
+var (
+	filesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "upload_gc_files_total",
+			Help: "Files handled by the upload garbage collector by action",
+		},
+		[]string{"action"}, // quarantined, restored, deleted
+	)
+
+	reclaimedBytes = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "upload_gc_reclaimed_bytes_total",
+			Help: "Bytes freed by deleting quarantined uploads",
+		},
+	)
+
+	objectBytes = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "upload_objects_bytes",
+			Help: "Bytes of content-addressed uploads: stored counts shared objects once, saved is what duplicates would have taken",
+		},
+		[]string{"kind"}, // stored, saved
+	)
+)
+
+func init() {
+	instance.Registerer().MustRegister(filesTotal)
+	instance.Registerer().MustRegister(reclaimedBytes)
+	instance.Registerer().MustRegister(objectBytes)
+}
+
+// Result summarizes one collection pass
+type Result struct {
+	Scanned        int
+	Quarantined    int
+	Restored       int
+	Deleted        int
+	ReclaimedBytes int64
+
+	// Reconciled counts objects whose reference count was corrected
+	Reconciled int64
+}
+
+// Collector removes uploaded files no database row refers to. Orphans are
+// first moved to a quarantine directory, restored if they become referenced
+// again, and deleted once they have been quarantined for the configured period.
+// A content-addressed object is one file however many rows share it, so it is
+// scanned and reclaimed once.
+type Collector struct {
+	qdb *db.Queries
+	cb  *gobreaker.CircuitBreaker
+
+	uploadsDir    string
+	quarantineDir string
+	period        time.Duration
+}
+
+// NewCollector creates the collector and runs it every cfg.GCInterval until ctx
+// is cancelled. It returns nil if the interval is zero.
+func NewCollector(ctx context.Context, qdb *db.Queries, uploadsDir string, cfg config.UploadConfig) *Collector {
+	if cfg.GCInterval <= 0 {
+		return nil
+	}
+
+	c := &Collector{
+		qdb:           qdb,
+		uploadsDir:    uploadsDir,
+		quarantineDir: cfg.QuarantineDir,
+		period:        cfg.QuarantinePeriod,
+		cb: breaker.New(breaker.Config{
+			Name:        "postgres-uploadgc",
+			MaxRequests: 5,
+			Interval:    60 * time.Second,
+			Timeout:     30 * time.Second,
+			Threshold:   0.5,
+			MinRequests: 5,
+		}),
+	}
+
+	go c.run(ctx, cfg.GCInterval)
+
+	return c
+}
+
+func (c *Collector) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			result, err := c.Collect(ctx)
+			if err != nil {
+				logger.WithError(err).Error("Upload garbage collection failed")
+				continue
+			}
+
+			logger.WithFields(map[string]interface{}{
+				"scanned":         result.Scanned,
+				"quarantined":     result.Quarantined,
+				"restored":        result.Restored,
+				"deleted":         result.Deleted,
+				"reclaimed_bytes": result.ReclaimedBytes,
+				"reconciled":      result.Reconciled,
+			}).Info("Upload garbage collection finished")
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Collect runs one pass: correct object reference counts, quarantine
+// orphans, restore quarantined files that are referenced again and delete
+// those past the quarantine period
+func (c *Collector) Collect(ctx context.Context) (*Result, error) {
+	result := &Result{}
+
+	if err := c.reconcile(ctx, result); err != nil {
+		return nil, err
+	}
+
+	referenced, err := c.references(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.quarantineOrphans(referenced, result); err != nil {
+		return result, err
+	}
+
+	if err := c.sweepQuarantine(ctx, referenced, result); err != nil {
+		return result, err
+	}
+
+	c.updateObjectStats(ctx)
+
+	return result, nil
+}
+
+// reconcile sets each object's reference count to the rows referring to it.
+// Objects acquired or released within minAge are left alone, as the row
+// they were acquired for may not be written yet.
+func (c *Collector) reconcile(ctx context.Context, result *Result) error {
+	queryCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	rows, err := breaker.ExecuteCtx(queryCtx, c.cb, func() (interface{}, error) {
+		return c.qdb.ReconcileUploadObjectRefs(queryCtx, time.Now().Add(-minAge))
+	})
+	if err != nil {
+		logger.WithError(err).Error("Circuit breaker: Failed to reconcile upload references")
+		return err
+	}
+
+	result.Reconciled, _ = rows.(int64)
+	return nil
+}
+
+func (c *Collector) updateObjectStats(ctx context.Context) {
+	result, err := breaker.ExecuteCtx(ctx, c.cb, func() (interface{}, error) {
+		return c.qdb.GetUploadObjectStats(ctx)
+	})
+	if err != nil {
+		logger.WithError(err).Warn("Failed to read upload object stats")
+		return
+	}
+
+	if stats, ok := result.(db.GetUploadObjectStatsRow); ok {
+		objectBytes.WithLabelValues("stored").Set(float64(stats.StoredBytes))
+		objectBytes.WithLabelValues("saved").Set(float64(stats.SavedBytes))
+	}
+}
+
+// references returns the upload paths, relative to the uploads directory,
+// that the database refers to
+func (c *Collector) references(ctx context.Context) (map[string]bool, error) {
+	queryCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	result, err := breaker.ExecuteCtx(queryCtx, c.cb, func() (interface{}, error) {
+		return c.qdb.ListUploadReferences(queryCtx)
+	})
+	if err != nil {
+		logger.WithError(err).Error("Circuit breaker: Failed to list upload references")
+		return nil, err
+	}
+
+	urls, _ := result.([]string)
+	referenced := make(map[string]bool, len(urls))
+	for _, url := range urls {
+		referenced[filepath.FromSlash(strings.TrimPrefix(url, urlPrefix))] = true
+
+		// Resized variants live and die with their object
+		if uploads.IsObjectURL(url) {
+			for _, v := range uploads.Variants {
+				variant := uploads.VariantURL(url, v.Name)
+				referenced[filepath.FromSlash(strings.TrimPrefix(variant, urlPrefix))] = true
+			}
+		}
+	}
+	return referenced, nil
+}
+
+func (c *Collector) quarantineOrphans(referenced map[string]bool, result *Result) error {
+	cutoff := time.Now().Add(-minAge)
+
+	return filepath.WalkDir(c.uploadsDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+
+		// Skip placeholders such as .gitkeep and anything in hidden directories
+		if strings.HasPrefix(d.Name(), ".") && path != c.uploadsDir {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(c.uploadsDir, path)
+		if err != nil {
+			return nil
+		}
+
+		result.Scanned++
+
+		if referenced[rel] {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil || info.ModTime().After(cutoff) {
+			return nil
+		}
+
+		if err := c.move(path, filepath.Join(c.quarantineDir, rel)); err != nil {
+			logger.WithFields(map[string]interface{}{
+				"path":  rel,
+				"error": err.Error(),
+			}).Warn("Failed to quarantine orphaned upload")
+			return nil
+		}
+
+		result.Quarantined++
+		filesTotal.WithLabelValues("quarantined").Inc()
+		return nil
+	})
+}
+
+func (c *Collector) sweepQuarantine(ctx context.Context, referenced map[string]bool, result *Result) error {
+	expired := time.Now().Add(-c.period)
+
+	return filepath.WalkDir(c.quarantineDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(c.quarantineDir, path)
+		if err != nil {
+			return nil
+		}
+
+		if referenced[rel] {
+			if err := c.move(path, filepath.Join(c.uploadsDir, rel)); err != nil {
+				logger.WithFields(map[string]interface{}{
+					"path":  rel,
+					"error": err.Error(),
+				}).Warn("Failed to restore quarantined upload")
+				return nil
+			}
+
+			result.Restored++
+			filesTotal.WithLabelValues("restored").Inc()
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil || info.ModTime().After(expired) {
+			return nil
+		}
+
+		if err := os.Remove(path); err != nil {
+			if !os.IsNotExist(err) {
+				logger.WithFields(map[string]interface{}{
+					"path":  rel,
+					"error": err.Error(),
+				}).Warn("Failed to delete quarantined upload")
+			}
+			return nil
+		}
+
+		if url := urlPrefix + filepath.ToSlash(rel); strings.HasPrefix(rel, objectsPrefix) && !uploads.IsVariantURL(url) {
+			c.forgetObject(ctx, url)
+		}
+
+		result.Deleted++
+		result.ReclaimedBytes += info.Size()
+		filesTotal.WithLabelValues("deleted").Inc()
+		reclaimedBytes.Add(float64(info.Size()))
+		return nil
+	})
+}
+
+// forgetObject deletes the row of an object whose file was deleted, unless
+// it was acquired again meanwhile
+func (c *Collector) forgetObject(ctx context.Context, url string) {
+	if _, err := breaker.ExecuteCtx(ctx, c.cb, func() (interface{}, error) {
+		return nil, c.qdb.DeleteUploadObject(ctx, url)
+	}); err != nil {
+		logger.WithFields(map[string]interface{}{
+			"url":   url,
+			"error": err.Error(),
+		}).Warn("Failed to delete upload object row")
+	}
+}
+
+// move renames a file, creating the destination directory. The modification
+// time is set to now so quarantine age counts from the move.
+func (c *Collector) move(from, to string) error {
+	if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(from, to); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	return os.Chtimes(to, now, now)
+}
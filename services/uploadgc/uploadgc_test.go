@@ -0,0 +1,116 @@
+package uploadgc
+
+import (
+	"context"
+	"errors"
+	"exc6/config"
+	"exc6/services/uploads"
+	"exc6/tests/fakedb"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeFile creates a file under dir, last modified age ago
+func writeFile(t *testing.T, dir, rel, content string, age time.Duration) {
+	t.Helper()
+	path := filepath.Join(dir, filepath.FromSlash(rel))
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	at := time.Now().Add(-age)
+	require.NoError(t, os.Chtimes(path, at, at))
+}
+
+func exists(dir, rel string) bool {
+	_, err := os.Stat(filepath.Join(dir, filepath.FromSlash(rel)))
+	return err == nil
+}
+
+// relPath returns the path of an upload URL relative to the uploads directory
+func relPath(url string) string {
+	return strings.TrimPrefix(url, urlPrefix)
+}
+
+func newCollector(t *testing.T, fake *fakedb.Fake, period time.Duration) (*Collector, string, string) {
+	uploadsDir, quarantineDir := t.TempDir(), t.TempDir()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	c := NewCollector(ctx, fake.Queries(), uploadsDir, config.UploadConfig{
+		GCInterval:       time.Hour,
+		QuarantineDir:    quarantineDir,
+		QuarantinePeriod: period,
+	})
+	require.NotNil(t, c)
+	return c, uploadsDir, quarantineDir
+}
+
+func TestCollect(t *testing.T) {
+	const (
+		kept     = uploads.URLPrefix + "ab/abc.png"
+		orphan   = uploads.URLPrefix + "cd/cde.png"
+		restored = "/uploads/icons/back.png"
+	)
+	fake := fakedb.New(t)
+	fake.Return("ReconcileUploadObjectRefs", fakedb.Affected(2))
+	fake.Return("ListUploadReferences", fakedb.Result{Rows: [][]any{{kept}, {restored}}})
+
+	c, uploadsDir, quarantineDir := newCollector(t, fake, 24*time.Hour)
+	writeFile(t, uploadsDir, relPath(kept), "kept", 2*time.Hour)
+	writeFile(t, uploadsDir, relPath(uploads.VariantURL(kept, "avatar")), "variant", 2*time.Hour)
+	writeFile(t, uploadsDir, relPath(orphan), "orphan", 2*time.Hour)
+	writeFile(t, uploadsDir, "icons/old.png", "old", 2*time.Hour)
+	writeFile(t, uploadsDir, "icons/new.png", "new", time.Minute)
+	writeFile(t, uploadsDir, ".gitkeep", "", 2*time.Hour)
+	writeFile(t, quarantineDir, relPath(restored), "back", 48*time.Hour)
+	writeFile(t, quarantineDir, "icons/gone.png", "gone", 48*time.Hour)
+
+	result, err := c.Collect(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, &Result{Scanned: 5, Quarantined: 2, Restored: 1, Deleted: 1, ReclaimedBytes: 4, Reconciled: 2}, result)
+
+	assert.True(t, exists(uploadsDir, relPath(kept)))
+	assert.True(t, exists(uploadsDir, relPath(uploads.VariantURL(kept, "avatar"))), "variants live with their object")
+	assert.True(t, exists(uploadsDir, "icons/new.png"), "too recent to be an orphan")
+	assert.True(t, exists(uploadsDir, ".gitkeep"))
+	assert.True(t, exists(uploadsDir, relPath(restored)), "referenced again")
+	assert.True(t, exists(quarantineDir, relPath(orphan)))
+	assert.True(t, exists(quarantineDir, "icons/old.png"))
+	assert.False(t, exists(quarantineDir, "icons/gone.png"))
+	assert.Empty(t, fake.Calls("DeleteUploadObject"), "only objects have rows")
+
+	// Quarantine age counts from the move
+	result, err = c.Collect(context.Background())
+	require.NoError(t, err)
+	assert.Zero(t, result.Deleted)
+
+	c.period = 0
+	result, err = c.Collect(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 2, result.Deleted)
+	assert.Equal(t, int64(len("orphan")+len("old")), result.ReclaimedBytes)
+	assert.Equal(t, [][]any{{orphan}}, fake.Calls("DeleteUploadObject"))
+}
+
+func TestCollectWithoutReferences(t *testing.T) {
+	fake := fakedb.New(t)
+	fake.Fail("ListUploadReferences", errors.New("connection reset"))
+
+	c, uploadsDir, quarantineDir := newCollector(t, fake, 0)
+	writeFile(t, uploadsDir, "icons/old.png", "old", 2*time.Hour)
+	writeFile(t, quarantineDir, "icons/gone.png", "gone", 48*time.Hour)
+
+	_, err := c.Collect(context.Background())
+	assert.Error(t, err)
+	assert.True(t, exists(uploadsDir, "icons/old.png"), "nothing is an orphan unless the references are known")
+	assert.True(t, exists(quarantineDir, "icons/gone.png"))
+}
+
+func TestNewCollectorDisabled(t *testing.T) {
+	assert.Nil(t, NewCollector(context.Background(), nil, t.TempDir(), config.UploadConfig{}))
+}
@@ -0,0 +1,315 @@
+package uploads
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"exc6/apperrors"
+	"exc6/db"
+	"exc6/infrastructure/storage"
+	"exc6/pkg/breaker"
+	"exc6/pkg/instance"
+	"exc6/pkg/logger"
+	"io"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Attachments are encrypted by the sender's client before they are uploaded,
+// with a key the server never sees: the blob is stored as uploaded and the
+// key travels in an opaque envelope stored with the attachment, which the
+// clients of the recipients open with keys they hold. Nothing here can look
+// inside a blob, so uploads are checked against what the sender declares,
+// the plaintext's type and size, and against fixed limits: the blob must be
+// as large as an encryption of that plaintext and must not look like a
+// plaintext file.
+//
+// Each attachment belongs to the conversation it was uploaded for and is
+// only served to its participants, through the server rather than from the
+// uploads directory.
+
+const (
+	// attachmentsDir is the key prefix, and local directory under the
+	// uploads directory, of attachment blobs
+	attachmentsDir = "attachments"
+
+	// AttachmentsPath is where the uploads directory would serve blobs;
+	// requests for it are refused
+	AttachmentsPath = "/uploads/" + attachmentsDir + "/"
+
+	// MaxAttachmentSize bounds the declared plaintext size, so a blob fits
+	// the server's 4 MiB request body limit with its envelope
+	MaxAttachmentSize = 3 << 20
+
+	// MaxEncryptionOverhead is how much larger than its plaintext a blob may
+	// be, for the IV, authentication tag and padding of its cipher
+	MaxEncryptionOverhead = 1024
+
+	// MaxEnvelopeSize bounds the key envelope
+	MaxEnvelopeSize = 4096
+
+	// sniffLen is how much of a blob content sniffing looks at
+	sniffLen = 512
+)
+
+// AttachmentTypes are the plaintext types senders may declare
+var AttachmentTypes = []string{
+	"application/pdf",
+	"application/zip",
+	"audio/mpeg",
+	"audio/ogg",
+	"image/gif",
+	"image/jpeg",
+	"image/png",
+	"image/webp",
+	"text/plain",
+	"video/mp4",
+}
+
+var attachmentsTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "upload_attachments_total",
+		Help: "Encrypted attachments by result: stored, rejected by the declared metadata or content checks, or served",
+	},
+	[]string{"result"}, // stored, rejected, served
+)
+
+func init() {
+	instance.Registerer().MustRegister(attachmentsTotal)
+}
+
+// Attachment is an encrypted blob and what its sender declared about it
+type Attachment struct {
+	ID        string `json:"id"`
+	Owner     string `json:"owner"`
+	Recipient string `json:"recipient,omitempty"`
+	GroupID   string `json:"group_id,omitempty"`
+
+	// Type and Size are the declared type and size of the plaintext
+	Type string `json:"type"`
+	Size int64  `json:"size"`
+
+	BlobSize int64  `json:"blob_size"`
+	Envelope string `json:"envelope"`
+
+	// URL is where participants download the blob
+	URL string `json:"url"`
+
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Declaration is what the sender states about an attachment's plaintext
+type Declaration struct {
+	Type     string
+	Size     int64
+	Envelope string
+}
+
+// Check verifies a declaration against the limits and the size of the blob
+// encrypting it
+func (d Declaration) Check(blobSize int64) error {
+	if !slices.Contains(AttachmentTypes, d.Type) {
+		return apperrors.NewInvalidFileType(AttachmentTypes)
+	}
+	if d.Size <= 0 {
+		return apperrors.NewValidationError("Attachment size must be declared")
+	}
+	if d.Size > MaxAttachmentSize {
+		return apperrors.NewFileTooLarge(MaxAttachmentSize)
+	}
+	if blobSize < d.Size || blobSize > d.Size+MaxEncryptionOverhead {
+		return apperrors.NewValidationError("Attachment does not match its declared size")
+	}
+	if d.Envelope == "" || len(d.Envelope) > MaxEnvelopeSize {
+		return apperrors.NewValidationError("Attachment key envelope is missing or too large")
+	}
+	return nil
+}
+
+// checkCiphertext rejects blobs that content sniffing recognizes, such as an
+// image uploaded without being encrypted; ciphertext looks like random
+// bytes. Sniffing matches a few two-byte signatures, so about one blob in
+// tens of thousands is rejected by chance; encrypting it again with a new IV
+// gets it through.
+func checkCiphertext(content []byte) error {
+	if http.DetectContentType(content[:min(len(content), sniffLen)]) != "application/octet-stream" {
+		return apperrors.NewValidationError("Attachment is not encrypted")
+	}
+	return nil
+}
+
+func attachmentKey(id string) string {
+	return attachmentsDir + "/" + id
+}
+
+// AttachmentURL returns where the blob of an attachment is downloaded
+func AttachmentURL(id string) string {
+	return "/api/v1/attachments/" + id + "/blob"
+}
+
+// PutAttachment stores a blob owner encrypted for their conversation with
+// recipient, or for a group they belong to
+func (s *Store) PutAttachment(ctx context.Context, owner, recipient, groupID string, d Declaration, blob io.Reader, blobSize int64) (*Attachment, error) {
+	if (recipient == "") == (groupID == "") {
+		return nil, apperrors.NewValidationError("An attachment belongs to either a contact or a group")
+	}
+	var group uuid.UUID
+	if groupID != "" {
+		var err error
+		if group, err = uuid.Parse(groupID); err != nil {
+			return nil, apperrors.NewValidationError("Invalid group ID")
+		}
+	}
+
+	if err := d.Check(blobSize); err != nil {
+		attachmentsTotal.WithLabelValues("rejected").Inc()
+		return nil, err
+	}
+
+	content, err := io.ReadAll(io.LimitReader(blob, blobSize+1))
+	if err != nil {
+		return nil, apperrors.NewFileUploadError("", "failed to read file", err)
+	}
+	if int64(len(content)) != blobSize {
+		attachmentsTotal.WithLabelValues("rejected").Inc()
+		return nil, apperrors.NewValidationError("Attachment does not match its declared size")
+	}
+	if err := checkCiphertext(content); err != nil {
+		attachmentsTotal.WithLabelValues("rejected").Inc()
+		return nil, err
+	}
+
+	id := uuid.New()
+	key := attachmentKey(id.String())
+	if err := s.backend.Put(ctx, key, bytes.NewReader(content), "application/octet-stream"); err != nil {
+		return nil, apperrors.NewFileUploadError("", "failed to save file", err)
+	}
+
+	// A blob without its row is left to the upload collector
+	result, err := breaker.ExecuteCtx(ctx, s.cb, func() (interface{}, error) {
+		var createdAt time.Time
+		var err error
+		if recipient != "" {
+			createdAt, err = s.qdb.CreateDirectAttachment(ctx, db.CreateDirectAttachmentParams{
+				ID:            id,
+				ContentType:   d.Type,
+				PlaintextSize: d.Size,
+				BlobSize:      blobSize,
+				KeyEnvelope:   d.Envelope,
+				Username:      owner,
+				Recipient:     recipient,
+			})
+		} else {
+			createdAt, err = s.qdb.CreateGroupAttachment(ctx, db.CreateGroupAttachmentParams{
+				ID:            id,
+				ContentType:   d.Type,
+				PlaintextSize: d.Size,
+				BlobSize:      blobSize,
+				KeyEnvelope:   d.Envelope,
+				GroupID:       group,
+				Username:      owner,
+			})
+		}
+		if err != nil {
+			return nil, err
+		}
+		return createdAt, nil
+	})
+	if err != nil {
+		logger.WithFields(map[string]interface{}{
+			"owner": owner,
+			"error": err.Error(),
+		}).Error("Circuit breaker: Failed to record attachment")
+		return nil, apperrors.NewDatabaseError("create attachment", err)
+	}
+
+	// Nothing is inserted without such a recipient, or for non-members of
+	// the group: sql.ErrNoRows, which the breaker passes on as no result
+	createdAt, ok := result.(time.Time)
+	if !ok {
+		s.backend.Delete(ctx, key)
+		if recipient != "" {
+			return nil, apperrors.NewUserNotFound()
+		}
+		return nil, apperrors.NewAuthorizationError(owner, "group", "attach")
+	}
+
+	attachmentsTotal.WithLabelValues("stored").Inc()
+	return &Attachment{
+		ID:        id.String(),
+		Owner:     owner,
+		Recipient: recipient,
+		GroupID:   groupID,
+		Type:      d.Type,
+		Size:      d.Size,
+		BlobSize:  blobSize,
+		Envelope:  d.Envelope,
+		URL:       AttachmentURL(id.String()),
+		CreatedAt: createdAt,
+	}, nil
+}
+
+// GetAttachment returns an attachment username may read. Attachments of
+// other conversations are reported as not found.
+func (s *Store) GetAttachment(ctx context.Context, username, id string) (*Attachment, error) {
+	notFound := apperrors.New(apperrors.ErrCodeNotFound, "Attachment not found", http.StatusNotFound)
+
+	attachmentID, err := uuid.Parse(id)
+	if err != nil {
+		return nil, notFound
+	}
+
+	result, err := breaker.ExecuteCtx(ctx, s.cb, func() (interface{}, error) {
+		row, err := s.qdb.GetAttachment(ctx, db.GetAttachmentParams{Username: username, ID: attachmentID})
+		if err != nil {
+			return nil, err
+		}
+		return row, nil
+	})
+	if err != nil {
+		logger.WithFields(map[string]interface{}{
+			"attachment_id": id,
+			"error":         err.Error(),
+		}).Error("Circuit breaker: Failed to get attachment")
+		return nil, apperrors.NewDatabaseError("get attachment", err)
+	}
+
+	// Attachments username may not read are sql.ErrNoRows too
+	row, ok := result.(db.GetAttachmentRow)
+	if !ok {
+		return nil, notFound
+	}
+
+	attachment := &Attachment{
+		ID:        row.ID.String(),
+		Owner:     row.Owner,
+		Recipient: row.Recipient.String,
+		Type:      row.ContentType,
+		Size:      row.PlaintextSize,
+		BlobSize:  row.BlobSize,
+		Envelope:  row.KeyEnvelope,
+		URL:       AttachmentURL(row.ID.String()),
+		CreatedAt: row.CreatedAt,
+	}
+	if row.GroupID.Valid {
+		attachment.GroupID = row.GroupID.UUID.String()
+	}
+	return attachment, nil
+}
+
+// OpenAttachment opens the blob of an attachment; the caller closes it
+func (s *Store) OpenAttachment(ctx context.Context, attachment *Attachment) (io.ReadCloser, error) {
+	blob, err := s.backend.Get(ctx, attachmentKey(attachment.ID))
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, apperrors.New(apperrors.ErrCodeNotFound, "Attachment not found", http.StatusNotFound)
+	}
+	if err != nil {
+		return nil, apperrors.NewFileUploadError("", "failed to open file", err)
+	}
+	attachmentsTotal.WithLabelValues("served").Inc()
+	return blob, nil
+}
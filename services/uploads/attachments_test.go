@@ -0,0 +1,116 @@
+package uploads
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"exc6/apperrors"
+	"exc6/infrastructure/storage"
+	"exc6/tests/fakedb"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeclarationCheck(t *testing.T) {
+	d := Declaration{Type: "image/png", Size: 1000, Envelope: "wrapped-key"}
+	assert.NoError(t, d.Check(1000+28), "AES-GCM adds an IV and a tag")
+	assert.NoError(t, d.Check(1000+MaxEncryptionOverhead))
+
+	assert.Error(t, d.Check(999), "smaller than the plaintext")
+	assert.Error(t, d.Check(1000+MaxEncryptionOverhead+1))
+
+	for name, bad := range map[string]Declaration{
+		"unknown type": {Type: "application/x-msdownload", Size: 1000, Envelope: "k"},
+		"no size":      {Type: "image/png", Envelope: "k"},
+		"too large":    {Type: "image/png", Size: MaxAttachmentSize + 1, Envelope: "k"},
+		"no envelope":  {Type: "image/png", Size: 1000},
+		"big envelope": {Type: "image/png", Size: 1000, Envelope: string(make([]byte, MaxEnvelopeSize+1))},
+	} {
+		assert.Error(t, bad.Check(bad.Size), name)
+	}
+}
+
+func TestCheckCiphertext(t *testing.T) {
+	assert.NoError(t, checkCiphertext(bytes.Repeat([]byte{0x00, 0xff, 0x13, 0x9a}, 300)))
+
+	png := append([]byte{0x89, 'P', 'N', 'G', 0x0D, 0x0A, 0x1A, 0x0A}, make([]byte, 100)...)
+	assert.Error(t, checkCiphertext(png), "an unencrypted image")
+	assert.Error(t, checkCiphertext([]byte("just some text")), "unencrypted text")
+}
+
+func TestPutAttachmentRejects(t *testing.T) {
+	ctx := context.Background()
+	s := &Store{}
+	d := Declaration{Type: "text/plain", Size: 8, Envelope: "k"}
+	blob := bytes.Repeat([]byte{0x00, 0xff, 0x13, 0x9a}, 9)
+
+	_, err := s.PutAttachment(ctx, "alice", "bob", "a3c1f6d2-6f1e-4b8e-9d3a-2f4d5c6b7a81", d, bytes.NewReader(blob), int64(len(blob)))
+	assert.Error(t, err, "both a contact and a group")
+
+	_, err = s.PutAttachment(ctx, "alice", "", "", d, bytes.NewReader(blob), int64(len(blob)))
+	assert.Error(t, err, "neither a contact nor a group")
+
+	_, err = s.PutAttachment(ctx, "alice", "bob", "", d, bytes.NewReader(blob[:10]), int64(len(blob)))
+	assert.Error(t, err, "a blob shorter than its stated size")
+
+	_, err = s.PutAttachment(ctx, "alice", "bob", "", d, bytes.NewReader([]byte("plain text message")), 18)
+	assert.Error(t, err, "plaintext")
+}
+
+func requireNotFound(t *testing.T, err error) {
+	t.Helper()
+	var appErr *apperrors.AppError
+	require.True(t, errors.As(err, &appErr), "got %v", err)
+	assert.Equal(t, http.StatusNotFound, appErr.StatusCode)
+}
+
+func TestPutAttachment(t *testing.T) {
+	fake := fakedb.New(t)
+	s := NewStore(fake.Queries(), storage.NewLocal(t.TempDir(), "/uploads/"), 0)
+	ctx := context.Background()
+	d := Declaration{Type: "text/plain", Size: 8, Envelope: "k"}
+	blob := bytes.Repeat([]byte{0x00, 0xff, 0x13, 0x9a}, 9)
+
+	// No such recipient: nothing is inserted
+	_, err := s.PutAttachment(ctx, "alice", "nobody", "", d, bytes.NewReader(blob), int64(len(blob)))
+	requireNotFound(t, err)
+
+	createdAt := time.Now().UTC().Truncate(time.Second)
+	fake.Return("CreateDirectAttachment", fakedb.Row(createdAt))
+
+	attachment, err := s.PutAttachment(ctx, "alice", "bob", "", d, bytes.NewReader(blob), int64(len(blob)))
+	require.NoError(t, err)
+	assert.Equal(t, createdAt, attachment.CreatedAt)
+
+	blobReader, err := s.OpenAttachment(ctx, attachment)
+	require.NoError(t, err)
+	blobReader.Close()
+}
+
+func TestGetAttachment(t *testing.T) {
+	fake := fakedb.New(t)
+	s := NewStore(fake.Queries(), storage.NewLocal(t.TempDir(), "/uploads/"), 0)
+	ctx := context.Background()
+	id := uuid.New()
+
+	_, err := s.GetAttachment(ctx, "alice", "not-a-uuid")
+	requireNotFound(t, err)
+
+	// Unknown attachments and those of other conversations have no row
+	_, err = s.GetAttachment(ctx, "mallory", id.String())
+	requireNotFound(t, err)
+
+	fake.Return("GetAttachment", fakedb.Row(id, "alice", "bob", nil, "image/png", int64(100), int64(128), "k", time.Now()))
+
+	attachment, err := s.GetAttachment(ctx, "bob", id.String())
+	require.NoError(t, err)
+	assert.Equal(t, id.String(), attachment.ID)
+	assert.Equal(t, "alice", attachment.Owner)
+	assert.Equal(t, "bob", attachment.Recipient)
+	assert.Empty(t, attachment.GroupID)
+}
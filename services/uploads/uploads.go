@@ -0,0 +1,276 @@
+package uploads
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"exc6/apperrors"
+	"exc6/db"
+	"exc6/infrastructure/storage"
+	"exc6/pkg/breaker"
+	"exc6/pkg/instance"
+	"exc6/pkg/logger"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sony/gobreaker"
+)
+
+const (
+	// objectsDir is the key prefix, and local directory under the uploads
+	// directory, of content-addressed objects
+	objectsDir = "objects"
+
+	// URLPrefix is where objects are served from
+	URLPrefix = "/uploads/" + objectsDir + "/"
+)
+
+// extensions maps the detected MIME type of an upload to the extension its
+// object is stored with, so identical content always gets the same name
+var extensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+var (
+	objectsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "upload_objects_total",
+			Help: "Uploads stored by result: new object or deduplicated against an existing one",
+		},
+		[]string{"result"}, // new, deduplicated
+	)
+
+	dedupSavedBytes = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "upload_dedup_saved_bytes_total",
+			Help: "Bytes not written because the upload matched an existing object",
+		},
+	)
+)
+
+func init() {
+	instance.Registerer().MustRegister(objectsTotal)
+	instance.Registerer().MustRegister(dedupSavedBytes)
+}
+
+// Object is a stored upload
+type Object struct {
+	Hash string `json:"sha256"`
+	URL  string `json:"url"`
+	Size int64  `json:"size"`
+
+	// Variants maps variant names to the URLs of resized copies
+	Variants map[string]string `json:"variants"`
+
+	// Deduplicated is set when the content was already stored
+	Deduplicated bool `json:"deduplicated"`
+}
+
+// Store keeps uploads by the SHA-256 of their content, so an image uploaded
+// by many users is written once. Each object counts the rows referring to
+// it: Put acquires a reference and Release drops one. Objects are never
+// deleted here; objects without references are left to the upload
+// collector, which also reconciles the counts.
+//
+// A user's uploads are charged against their quota by the objects their
+// icon, emoji and group icons refer to, so an object shared between users,
+// or used twice by one, is counted once for each of them.
+type Store struct {
+	qdb *db.Queries
+	cb  *gobreaker.CircuitBreaker
+
+	backend storage.Storage
+	quota   int64
+}
+
+// NewStore creates a store keeping objects in backend, allowing each user
+// quota bytes of uploads (0 for no limit)
+func NewStore(qdb *db.Queries, backend storage.Storage, quota int64) *Store {
+	return &Store{
+		qdb:     qdb,
+		backend: backend,
+		quota:   quota,
+		cb: breaker.New(breaker.Config{
+			Name:        "postgres-uploads",
+			MaxRequests: 10,
+			Interval:    60 * time.Second,
+			Timeout:     45 * time.Second,
+			Threshold:   0.6,
+			MinRequests: 10,
+		}),
+	}
+}
+
+// Check verifies that objects can be written, for the status page
+func (s *Store) Check(ctx context.Context) error {
+	const key = objectsDir + "/.upload-check"
+	if err := s.backend.Put(ctx, key, strings.NewReader("ok"), "text/plain"); err != nil {
+		return err
+	}
+	return s.backend.Delete(ctx, key)
+}
+
+// IsObjectURL reports whether url points at a content-addressed object
+func IsObjectURL(url string) bool {
+	return strings.HasPrefix(url, URLPrefix)
+}
+
+// objectKey returns the storage key and URL of an object. Objects are spread
+// over directories named after the first two hex digits of their hash.
+func objectKey(hash, ext string) (string, string) {
+	rel := hash[:2] + "/" + hash + ext
+	return objectsDir + "/" + rel, URLPrefix + rel
+}
+
+// SignedURL returns where browsers can fetch the object at url from for at
+// least ttl, for backends that do not serve objects at their URL
+func (s *Store) SignedURL(ctx context.Context, url string, ttl time.Duration) (string, error) {
+	if !IsObjectURL(url) {
+		return "", storage.ErrNotFound
+	}
+	return s.backend.SignedURL(ctx, objectsDir+"/"+strings.TrimPrefix(url, URLPrefix), ttl)
+}
+
+// Put stores content of an already validated image for owner and acquires a
+// reference to it. If the same content is stored already, the existing object
+// is returned and no second copy is kept. Content owner does not refer to yet
+// must fit in their quota.
+func (s *Store) Put(ctx context.Context, owner uuid.UUID, r io.Reader, mimeType string) (*Object, error) {
+	ext, ok := extensions[mimeType]
+	if !ok {
+		return nil, apperrors.NewValidationError("Unsupported upload type")
+	}
+
+	// Validated images are small enough to hash in memory before choosing
+	// the key they are stored under
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return nil, apperrors.NewFileUploadError("", "failed to read file", err)
+	}
+
+	sum := sha256.Sum256(content)
+	hash := hex.EncodeToString(sum[:])
+	key, url := objectKey(hash, ext)
+	size := int64(len(content))
+
+	obj := &Object{Hash: hash, URL: url, Size: size, Variants: variantURLs(url)}
+
+	if err := s.checkQuota(ctx, owner, hash, size); err != nil {
+		return nil, err
+	}
+
+	existing, err := s.backend.Get(ctx, key)
+	switch {
+	case err == nil:
+		// A collector pass racing this upload may still remove the object;
+		// the reference acquired below makes the next pass keep it
+		existing.Close()
+		obj.Deduplicated = true
+	case errors.Is(err, storage.ErrNotFound):
+		if err := s.backend.Put(ctx, key, bytes.NewReader(content), mimeType); err != nil {
+			return nil, apperrors.NewFileUploadError("", "failed to save file", err)
+		}
+		s.storeVariants(ctx, key, content)
+	default:
+		return nil, apperrors.NewFileUploadError("", "failed to look up stored file", err)
+	}
+
+	_, err = breaker.ExecuteCtx(ctx, s.cb, func() (interface{}, error) {
+		return s.qdb.AcquireUploadObject(ctx, db.AcquireUploadObjectParams{
+			Sha256:    hash,
+			Url:       url,
+			SizeBytes: size,
+		})
+	})
+	if err != nil {
+		logger.WithFields(map[string]interface{}{
+			"sha256": hash,
+			"error":  err.Error(),
+		}).Error("Circuit breaker: Failed to acquire upload object")
+		return nil, apperrors.NewDatabaseError("acquire upload object", err)
+	}
+
+	if obj.Deduplicated {
+		objectsTotal.WithLabelValues("deduplicated").Inc()
+		dedupSavedBytes.Add(float64(size))
+	} else {
+		objectsTotal.WithLabelValues("new").Inc()
+	}
+
+	logger.WithFields(map[string]interface{}{
+		"sha256":       hash,
+		"size":         size,
+		"deduplicated": obj.Deduplicated,
+	}).Debug("Upload stored")
+
+	return obj, nil
+}
+
+// checkQuota refuses content of size bytes with the given hash if it would
+// take owner's uploads past the quota. Uploads in progress are not counted
+// until something refers to them; the per-user upload throttle bounds how
+// far concurrent uploads can overshoot.
+func (s *Store) checkQuota(ctx context.Context, owner uuid.UUID, hash string, size int64) error {
+	if s.quota <= 0 {
+		return nil
+	}
+
+	result, err := breaker.ExecuteCtx(ctx, s.cb, func() (interface{}, error) {
+		usage, err := s.qdb.GetUserUploadUsage(ctx, db.GetUserUploadUsageParams{
+			ID:     owner,
+			Sha256: hash,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return usage, nil
+	})
+	if err != nil {
+		logger.WithFields(map[string]interface{}{
+			"owner": owner,
+			"error": err.Error(),
+		}).Error("Circuit breaker: Failed to get upload usage")
+		return apperrors.NewDatabaseError("get upload usage", err)
+	}
+
+	usage, _ := result.(db.GetUserUploadUsageRow)
+	if usage.HoldsObject {
+		// Nothing to charge for content the user already refers to
+		return nil
+	}
+	if usage.UsedBytes+size > s.quota {
+		return apperrors.NewUploadQuotaExceeded(s.quota).
+			WithDetails("used_bytes", usage.UsedBytes)
+	}
+
+	return nil
+}
+
+// Release drops a reference to the object at url. URLs of files stored
+// before deduplication are ignored.
+func (s *Store) Release(ctx context.Context, url string) error {
+	if !IsObjectURL(url) {
+		return nil
+	}
+
+	_, err := breaker.ExecuteCtx(ctx, s.cb, func() (interface{}, error) {
+		return s.qdb.ReleaseUploadObject(ctx, url)
+	})
+	if err != nil {
+		logger.WithFields(map[string]interface{}{
+			"url":   url,
+			"error": err.Error(),
+		}).Error("Circuit breaker: Failed to release upload object")
+		return apperrors.NewDatabaseError("release upload object", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,80 @@
+package uploads
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"exc6/apperrors"
+	"exc6/infrastructure/storage"
+	"exc6/tests/fakedb"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newQuotaStore returns a store allowing quota bytes per user over a fake
+// database reporting usage as what the user's uploads take up
+func newQuotaStore(t *testing.T, quota int64, used int64, holds bool) (*Store, *fakedb.Fake, storage.Storage) {
+	fake := fakedb.New(t)
+	fake.Return("GetUserUploadUsage", fakedb.Row(used, holds))
+	fake.On("AcquireUploadObject", func(args []any) (fakedb.Result, error) {
+		now := time.Now()
+		return fakedb.Row(args[0], args[1], args[2], int32(1), now, now), nil
+	})
+
+	backend := storage.NewLocal(t.TempDir(), "/uploads/")
+	return NewStore(fake.Queries(), backend, quota), fake, backend
+}
+
+func TestPutQuota(t *testing.T) {
+	ctx := context.Background()
+	owner := uuid.New()
+	content := bytes.Repeat([]byte("x"), 50)
+
+	s, fake, backend := newQuotaStore(t, 100, 60, false)
+	_, err := s.Put(ctx, owner, bytes.NewReader(content), "image/png")
+	var appErr *apperrors.AppError
+	require.True(t, errors.As(err, &appErr), "got %v", err)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, appErr.StatusCode)
+	assert.Equal(t, apperrors.ErrCodeUploadQuota, appErr.Code)
+	assert.Empty(t, fake.Calls("AcquireUploadObject"))
+	assert.Zero(t, s.cb.Counts().TotalFailures, "a full quota is not a database failure")
+
+	usage := fake.Calls("GetUserUploadUsage")
+	require.Len(t, usage, 1)
+	assert.Equal(t, owner.String(), usage[0][0])
+
+	key, _ := objectKey(usage[0][1].(string), ".png")
+	_, err = backend.Get(ctx, key)
+	assert.ErrorIs(t, err, storage.ErrNotFound, "nothing is written past the quota")
+
+	// An object the user already refers to is counted once
+	s, fake, _ = newQuotaStore(t, 100, 60, true)
+	obj, err := s.Put(ctx, owner, bytes.NewReader(content), "image/png")
+	require.NoError(t, err)
+	assert.Len(t, fake.Calls("AcquireUploadObject"), 1)
+	assert.Equal(t, int64(50), obj.Size)
+
+	s, fake, _ = newQuotaStore(t, 100, 50, false)
+	_, err = s.Put(ctx, owner, bytes.NewReader(content), "image/png")
+	require.NoError(t, err, "exactly at the quota")
+	assert.Len(t, fake.Calls("AcquireUploadObject"), 1)
+}
+
+func TestPutNoQuota(t *testing.T) {
+	s, fake, _ := newQuotaStore(t, 0, 1<<40, false)
+
+	obj, err := s.Put(context.Background(), uuid.New(), bytes.NewReader([]byte("icon")), "image/png")
+	require.NoError(t, err)
+	assert.False(t, obj.Deduplicated)
+	assert.Empty(t, fake.Calls("GetUserUploadUsage"), "usage is not looked up")
+
+	obj, err = s.Put(context.Background(), uuid.New(), bytes.NewReader([]byte("icon")), "image/png")
+	require.NoError(t, err)
+	assert.True(t, obj.Deduplicated, "another user uploading the same content")
+	assert.Len(t, fake.Calls("AcquireUploadObject"), 2)
+}
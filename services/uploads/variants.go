@@ -0,0 +1,167 @@
+package uploads
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"exc6/apperrors"
+	"exc6/infrastructure/storage"
+	"exc6/pkg/images"
+	"exc6/pkg/logger"
+	"io"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Variant is a resized copy stored next to every image object
+type Variant struct {
+	Name string
+
+	// Size bounds the width and height of the copy in pixels
+	Size int
+}
+
+// Variants are generated for every image so small renderings such as
+// avatars need not download the original, which may be 4096px wide
+var Variants = []Variant{
+	{Name: "avatar", Size: 64},
+	{Name: "preview", Size: 512},
+}
+
+// variantExt returns the extension variants of an object with ext are stored
+// with: JPEG photos stay JPEG, everything else is PNG
+func variantExt(ext string) string {
+	if ext == ".jpg" {
+		return ".jpg"
+	}
+	return ".png"
+}
+
+// VariantURL returns the URL of the named variant of the object at url, as
+// "/uploads/objects/ab/<hash>_64.png". Other URLs and unknown names return
+// url itself, so templates can pass any icon through.
+func VariantURL(url, name string) string {
+	if !IsObjectURL(url) {
+		return url
+	}
+	for _, v := range Variants {
+		if v.Name == name {
+			ext := path.Ext(url)
+			return strings.TrimSuffix(url, ext) + "_" + strconv.Itoa(v.Size) + variantExt(ext)
+		}
+	}
+	return url
+}
+
+// variantURLs returns the URLs of every variant of the object at url
+func variantURLs(url string) map[string]string {
+	urls := make(map[string]string, len(Variants))
+	for _, v := range Variants {
+		urls[v.Name] = VariantURL(url, v.Name)
+	}
+	return urls
+}
+
+// originalsOf returns the keys the object a variant URL was generated from
+// may be stored under; PNG variants come from PNG, GIF and WebP objects alike
+func originalsOf(url string) []string {
+	if !IsObjectURL(url) {
+		return nil
+	}
+
+	ext := path.Ext(url)
+	base, size, ok := strings.Cut(strings.TrimSuffix(url, ext), "_")
+	if !ok {
+		return nil
+	}
+
+	for _, v := range Variants {
+		if strconv.Itoa(v.Size) != size {
+			continue
+		}
+
+		var keys []string
+		for _, objExt := range extensions {
+			if variantExt(objExt) == ext {
+				keys = append(keys, objectsDir+"/"+strings.TrimPrefix(base, URLPrefix)+objExt)
+			}
+		}
+		sort.Strings(keys)
+		return keys
+	}
+	return nil
+}
+
+// IsVariantURL reports whether url points at a variant of an object
+func IsVariantURL(url string) bool {
+	return len(originalsOf(url)) > 0
+}
+
+// Variant returns the variant at url, generating the variants of its object
+// if they are missing, such as for objects stored before variants existed
+func (s *Store) Variant(ctx context.Context, url string) ([]byte, error) {
+	for _, key := range originalsOf(url) {
+		r, err := s.backend.Get(ctx, key)
+		if errors.Is(err, storage.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, apperrors.NewFileUploadError("", "failed to read stored file", err)
+		}
+
+		content, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			return nil, apperrors.NewFileUploadError("", "failed to read stored file", err)
+		}
+
+		variant, err := s.generateVariants(ctx, key, content, objectsDir+"/"+strings.TrimPrefix(url, URLPrefix))
+		if err != nil {
+			return nil, apperrors.NewFileUploadError("", "failed to generate variants", err)
+		}
+		return variant, nil
+	}
+	return nil, apperrors.New(apperrors.ErrCodeNotFound, "Upload not found", http.StatusNotFound)
+}
+
+// storeVariants writes the variants of the object under key. Failures leave
+// the original usable and are logged; missing variants are generated again
+// when requested.
+func (s *Store) storeVariants(ctx context.Context, key string, content []byte) {
+	if _, err := s.generateVariants(ctx, key, content, ""); err != nil {
+		logger.WithFields(map[string]interface{}{
+			"key":   key,
+			"error": err.Error(),
+		}).Warn("Failed to store upload variants")
+	}
+}
+
+// generateVariants resizes the object under key and stores each variant,
+// returning the content of the one stored under want
+func (s *Store) generateVariants(ctx context.Context, key string, content []byte, want string) ([]byte, error) {
+	img, format, err := images.Decode(content)
+	if err != nil {
+		return nil, err
+	}
+
+	url := URLPrefix + strings.TrimPrefix(key, objectsDir+"/")
+	var wanted []byte
+	for _, v := range Variants {
+		variant, mimeType, err := images.Encode(images.Fit(img, v.Size), format)
+		if err != nil {
+			return nil, err
+		}
+
+		variantKey := objectsDir + "/" + strings.TrimPrefix(VariantURL(url, v.Name), URLPrefix)
+		if err := s.backend.Put(ctx, variantKey, bytes.NewReader(variant), mimeType); err != nil {
+			return nil, err
+		}
+		if variantKey == want {
+			wanted = variant
+		}
+	}
+	return wanted, nil
+}
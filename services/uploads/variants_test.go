@@ -0,0 +1,32 @@
+package uploads
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVariantURL(t *testing.T) {
+	assert.Equal(t, "/uploads/objects/ab/abcd_64.jpg", VariantURL("/uploads/objects/ab/abcd.jpg", "avatar"))
+	assert.Equal(t, "/uploads/objects/ab/abcd_512.png", VariantURL("/uploads/objects/ab/abcd.webp", "preview"))
+
+	// Anything else passes through
+	assert.Equal(t, "/uploads/objects/ab/abcd.png", VariantURL("/uploads/objects/ab/abcd.png", "huge"))
+	assert.Equal(t, "/uploads/icons/legacy.png", VariantURL("/uploads/icons/legacy.png", "avatar"))
+	assert.Equal(t, "", VariantURL("", "avatar"))
+}
+
+func TestOriginalsOf(t *testing.T) {
+	assert.Equal(t, []string{"objects/ab/abcd.jpg"}, originalsOf("/uploads/objects/ab/abcd_64.jpg"))
+	assert.Equal(t, []string{
+		"objects/ab/abcd.gif",
+		"objects/ab/abcd.png",
+		"objects/ab/abcd.webp",
+	}, originalsOf("/uploads/objects/ab/abcd_512.png"))
+
+	assert.Empty(t, originalsOf("/uploads/objects/ab/abcd.png"), "an object")
+	assert.Empty(t, originalsOf("/uploads/objects/ab/abcd_100.png"), "an unknown size")
+	assert.Empty(t, originalsOf("/uploads/icons/a_64.png"), "outside the object store")
+
+	assert.True(t, IsVariantURL(VariantURL("/uploads/objects/ab/abcd.gif", "avatar")))
+}
@@ -0,0 +1,243 @@
+package users
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"exc6/apperrors"
+	"exc6/db"
+	"exc6/pkg/breaker"
+	"exc6/pkg/cache"
+	"exc6/pkg/logger"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sony/gobreaker"
+)
+
+// UserService is the single way handlers read and write user accounts.
+// Lookups by username are served from an in-process cache that is kept
+// consistent across instances through the invalidator, and every database
+// call goes through a circuit breaker.
+type UserService struct {
+	qdb         *db.Queries
+	cb          *gobreaker.CircuitBreaker
+	local       *cache.Local[string, db.User]
+	invalidator *cache.Invalidator
+}
+
+// NewUserService creates a user service subscribed to user invalidation events
+func NewUserService(qdb *db.Queries, inv *cache.Invalidator) *UserService {
+	us := &UserService{
+		qdb: qdb,
+		cb: breaker.New(breaker.Config{
+			Name:        "postgres-users",
+			MaxRequests: 10,
+			Interval:    60 * time.Second,
+			Timeout:     45 * time.Second,
+			Threshold:   0.6,
+			MinRequests: 10,
+		}),
+		local:       cache.NewLocal[string, db.User](10000, 5*time.Minute),
+		invalidator: inv,
+	}
+
+	inv.OnInvalidate(cache.KindUser, func(keys []string) {
+		us.local.Delete(keys...)
+	})
+
+	return us
+}
+
+// IsNotFound reports whether err means the user doesn't exist
+func IsNotFound(err error) bool {
+	var appErr *apperrors.AppError
+	return errors.As(err, &appErr) && appErr.Code == apperrors.ErrCodeUserNotFound
+}
+
+// GetByUsername returns the user, loading it from the database on a miss.
+// A missing user is reported as apperrors.NewUserNotFound.
+func (us *UserService) GetByUsername(ctx context.Context, username string) (db.User, error) {
+	if user, ok := us.local.Get(username); ok {
+		return user, nil
+	}
+
+	result, err := breaker.ExecuteCtx(ctx, us.cb, func() (interface{}, error) {
+		user, err := us.qdb.GetUserByUsername(ctx, username)
+		if err != nil {
+			return nil, err
+		}
+		return user, nil
+	})
+
+	if err != nil {
+		logger.WithFields(map[string]interface{}{
+			"username": username,
+			"error":    err.Error(),
+		}).Error("Circuit breaker: Failed to get user")
+		return db.User{}, apperrors.NewDatabaseError("get user", err)
+	}
+
+	// Not-found errors don't trip the breaker and come back as an empty result
+	user, ok := result.(db.User)
+	if !ok {
+		return db.User{}, apperrors.NewUserNotFound()
+	}
+
+	us.local.Set(username, user)
+	return user, nil
+}
+
+// Exists reports whether a user with the given username exists
+func (us *UserService) Exists(ctx context.Context, username string) (bool, error) {
+	_, err := us.GetByUsername(ctx, username)
+	if IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// MaxLookup is the most users Lookup resolves in one call
+const MaxLookup = 100
+
+// Lookup resolves keys, each a username or a user ID, to users. Usernames are
+// served from the cache where possible; the rest are loaded in at most two
+// queries. Keys that match no user are left out of the result. When the
+// database fails, the users found in the cache are returned as degraded.
+func (us *UserService) Lookup(ctx context.Context, keys []string) (breaker.Result[map[string]db.User], error) {
+	if len(keys) > MaxLookup {
+		return breaker.Result[map[string]db.User]{}, apperrors.NewValidationError(fmt.Sprintf("Cannot look up more than %d users at once", MaxLookup))
+	}
+
+	found := make(map[string]db.User, len(keys))
+	var usernames []string
+	var ids []uuid.UUID
+	idKeys := make(map[uuid.UUID]string)
+	for _, key := range keys {
+		if id, err := uuid.Parse(key); err == nil {
+			ids = append(ids, id)
+			idKeys[id] = key
+		} else if user, ok := us.local.Get(key); ok {
+			found[key] = user
+		} else {
+			usernames = append(usernames, key)
+		}
+	}
+
+	if len(usernames) == 0 && len(ids) == 0 {
+		breaker.ObserveRead("users_lookup", len(found), false)
+		return breaker.Healthy(found), nil
+	}
+
+	result, err := breaker.ExecuteCtx(ctx, us.cb, func() (interface{}, error) {
+		var loaded []db.User
+		if len(usernames) > 0 {
+			byName, err := us.qdb.GetUsersByUsernames(ctx, usernames)
+			if err != nil {
+				return nil, err
+			}
+			loaded = append(loaded, byName...)
+		}
+		if len(ids) > 0 {
+			byID, err := us.qdb.GetUsersByIDs(ctx, ids)
+			if err != nil {
+				return nil, err
+			}
+			loaded = append(loaded, byID...)
+		}
+		return loaded, nil
+	})
+
+	if err != nil {
+		logger.WithFields(map[string]interface{}{
+			"keys":  len(keys),
+			"error": err.Error(),
+		}).Error("Circuit breaker: Failed to look up users")
+		breaker.ObserveRead("users_lookup", len(found), true)
+		return breaker.Degraded(found), nil
+	}
+
+	loaded, _ := result.([]db.User)
+	for _, user := range loaded {
+		us.local.Set(user.Username, user)
+		if key, ok := idKeys[user.ID]; ok {
+			found[key] = user
+		}
+		if slices.Contains(usernames, user.Username) {
+			found[user.Username] = user
+		}
+	}
+
+	breaker.ObserveRead("users_lookup", len(found), false)
+	return breaker.Healthy(found), nil
+}
+
+// Create registers a new account with a default icon
+func (us *UserService) Create(ctx context.Context, username, passwordHash, icon string) (db.User, error) {
+	result, err := breaker.ExecuteCtx(ctx, us.cb, func() (interface{}, error) {
+		return us.qdb.CreateUser(ctx, db.CreateUserParams{
+			Username:     username,
+			PasswordHash: passwordHash,
+			Icon:         sql.NullString{String: icon, Valid: true},
+			CustomIcon:   sql.NullString{String: "", Valid: true},
+		})
+	})
+
+	if err != nil {
+		logger.WithFields(map[string]interface{}{
+			"username": username,
+			"error":    err.Error(),
+		}).Error("Circuit breaker: Failed to create user")
+		return db.User{}, apperrors.NewDatabaseError("create user", err)
+	}
+
+	user, _ := result.(db.User)
+	return user, nil
+}
+
+// UpdateProfile writes the username and icons of user, which was loaded as
+// oldUsername, and drops both names from every instance's cache
+func (us *UserService) UpdateProfile(ctx context.Context, oldUsername string, user db.User) (db.User, error) {
+	result, err := breaker.ExecuteCtx(ctx, us.cb, func() (interface{}, error) {
+		return us.qdb.UpdateUser(ctx, db.UpdateUserParams{
+			ID:         user.ID,
+			Username:   user.Username,
+			Icon:       user.Icon,
+			CustomIcon: user.CustomIcon,
+		})
+	})
+
+	if err != nil {
+		logger.WithFields(map[string]interface{}{
+			"username":     oldUsername,
+			"new_username": user.Username,
+			"error":        err.Error(),
+		}).Error("Circuit breaker: Failed to update user")
+		return db.User{}, apperrors.NewDatabaseError("update user", err)
+	}
+
+	updated, ok := result.(db.User)
+	if !ok {
+		return db.User{}, apperrors.NewUserNotFound()
+	}
+
+	// Other instances may still hold the old avatar/username
+	if err := us.Invalidate(ctx, oldUsername, updated.Username); err != nil {
+		logger.WithFields(map[string]interface{}{
+			"username": updated.Username,
+			"error":    err.Error(),
+		}).Warn("Failed to invalidate user cache")
+	}
+
+	return updated, nil
+}
+
+// Invalidate drops the given usernames on every instance
+func (us *UserService) Invalidate(ctx context.Context, usernames ...string) error {
+	return us.invalidator.Invalidate(ctx, cache.KindUser, usernames...)
+}
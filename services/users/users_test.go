@@ -0,0 +1,61 @@
+package users
+
+import (
+	"context"
+	"database/sql"
+	"exc6/apperrors"
+	"exc6/db"
+	"exc6/pkg/cache"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsNotFound(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "Nil", err: nil, want: false},
+		{name: "User not found", err: apperrors.NewUserNotFound(), want: true},
+		{name: "Wrapped", err: fmt.Errorf("lookup: %w", apperrors.NewUserNotFound()), want: true},
+		{name: "Database error", err: apperrors.NewDatabaseError("get user", sql.ErrConnDone), want: false},
+		{name: "Raw no rows", err: sql.ErrNoRows, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, IsNotFound(tt.err))
+		})
+	}
+}
+
+func TestLookupServedFromCache(t *testing.T) {
+	us := &UserService{local: cache.NewLocal[string, db.User](10, time.Minute)}
+	alice := db.User{ID: uuid.New(), Username: "alice"}
+	us.local.Set("alice", alice)
+
+	result, err := us.Lookup(context.Background(), []string{"alice"})
+	require.NoError(t, err)
+	assert.False(t, result.Degraded)
+	assert.Equal(t, map[string]db.User{"alice": alice}, result.Value)
+}
+
+func TestLookupLimit(t *testing.T) {
+	us := &UserService{local: cache.NewLocal[string, db.User](10, time.Minute)}
+
+	keys := make([]string, MaxLookup+1)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("user%d", i)
+	}
+
+	_, err := us.Lookup(context.Background(), keys)
+	var appErr *apperrors.AppError
+	require.ErrorAs(t, err, &appErr)
+	assert.Equal(t, apperrors.ErrCodeValidationFailed, appErr.Code)
+}
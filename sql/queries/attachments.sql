@@ -0,0 +1,31 @@
+-- name: CreateDirectAttachment :one
+-- Records an attachment username uploaded for their conversation with
+-- recipient; no row is written for an unknown recipient
+INSERT INTO attachments (id, owner_id, recipient_id, content_type, plaintext_size, blob_size, key_envelope)
+SELECT @id::uuid, u.id, r.id, @content_type::text, @plaintext_size::bigint, @blob_size::bigint, @key_envelope::text
+FROM users u, users r
+WHERE u.username = @username::text AND r.username = @recipient::text AND u.id <> r.id
+RETURNING created_at;
+
+-- name: CreateGroupAttachment :one
+-- Records an attachment username uploaded for a group; no row is written
+-- unless they are a member
+INSERT INTO attachments (id, owner_id, group_id, content_type, plaintext_size, blob_size, key_envelope)
+SELECT @id::uuid, u.id, gm.group_id, @content_type::text, @plaintext_size::bigint, @blob_size::bigint, @key_envelope::text
+FROM users u
+JOIN group_members gm ON gm.user_id = u.id AND gm.group_id = @group_id::uuid
+WHERE u.username = @username::text
+RETURNING created_at;
+
+-- name: GetAttachment :one
+-- The attachment if username may read it: as its owner, its recipient or a
+-- member of its group
+SELECT a.id, o.username AS owner, r.username AS recipient, a.group_id, a.content_type,
+       a.plaintext_size, a.blob_size, a.key_envelope, a.created_at
+FROM attachments a
+JOIN users o ON o.id = a.owner_id
+LEFT JOIN users r ON r.id = a.recipient_id
+JOIN users u ON u.username = @username::text
+WHERE a.id = @id::uuid
+    AND (a.owner_id = u.id OR a.recipient_id = u.id
+        OR EXISTS (SELECT 1 FROM group_members gm WHERE gm.group_id = a.group_id AND gm.user_id = u.id));
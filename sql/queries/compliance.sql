@@ -0,0 +1,36 @@
+-- name: CreateCompliancePolicy :one
+INSERT INTO compliance_policies (version, policy, changed_by, comment)
+VALUES (sqlc.arg(version), sqlc.arg(policy), (SELECT id FROM users WHERE username = sqlc.arg(changed_by)::text), sqlc.arg(comment))
+ON CONFLICT (version) DO NOTHING
+RETURNING version, created_at;
+
+-- name: GetLatestCompliancePolicy :one
+SELECT p.version, p.policy, u.username AS changed_by, p.comment, p.created_at
+FROM compliance_policies p
+LEFT JOIN users u ON p.changed_by = u.id
+ORDER BY p.version DESC
+LIMIT 1;
+
+-- name: ListCompliancePolicies :many
+SELECT p.version, p.policy, u.username AS changed_by, p.comment, p.created_at
+FROM compliance_policies p
+LEFT JOIN users u ON p.changed_by = u.id
+ORDER BY p.version DESC
+LIMIT $1;
+
+-- name: PurgeExpiredMessages :execrows
+-- Deletes up to batch messages sent before cutoff, keeping those sent by or to a
+-- held user and those of groups such a user belongs to
+DELETE FROM messages
+WHERE id IN (
+    SELECT m.id FROM messages m
+    WHERE m.created_at < sqlc.arg(cutoff)
+      AND m.from_user_id NOT IN (SELECT id FROM users WHERE username = ANY(sqlc.arg(held)::text[]))
+      AND (m.to_user_id IS NULL OR m.to_user_id NOT IN (SELECT id FROM users WHERE username = ANY(sqlc.arg(held)::text[])))
+      AND (m.group_id IS NULL OR m.group_id NOT IN (
+          SELECT gm.group_id FROM group_members gm
+          JOIN users u ON gm.user_id = u.id
+          WHERE u.username = ANY(sqlc.arg(held)::text[])
+      ))
+    LIMIT sqlc.arg(batch)
+);
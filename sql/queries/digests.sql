@@ -0,0 +1,81 @@
+-- name: TouchDigestLastSeen :exec
+-- Records usernames as seen now
+INSERT INTO notification_digests (user_id, last_seen_at)
+SELECT id, NOW() FROM users WHERE username = ANY(@usernames::text[])
+ON CONFLICT (user_id) DO UPDATE
+SET last_seen_at = NOW();
+
+-- name: GetDigestOptOut :one
+SELECT opted_out FROM notification_digests WHERE user_id = $1;
+
+-- name: SetDigestOptOut :exec
+INSERT INTO notification_digests (user_id, opted_out)
+VALUES ($1, $2)
+ON CONFLICT (user_id) DO UPDATE
+SET opted_out = EXCLUDED.opted_out;
+
+-- name: UnsubscribeDigest :execrows
+UPDATE notification_digests
+SET opted_out = TRUE
+WHERE unsubscribe_token = $1;
+
+-- name: ClaimDigestRecipients :many
+-- Marks up to row_limit users due a digest as sent one and returns them with
+-- the start of the activity it covers: their last visit or their last digest,
+-- whichever is later. Rows claimed by another instance are skipped.
+WITH due AS (
+    SELECT d.user_id, GREATEST(d.last_seen_at, COALESCE(d.last_sent_at, d.last_seen_at))::timestamptz AS since
+    FROM notification_digests d
+    WHERE NOT d.opted_out
+        AND d.last_seen_at < @inactive_before::timestamptz
+        AND (d.last_sent_at IS NULL OR d.last_sent_at < @sent_before::timestamptz)
+    ORDER BY d.user_id
+    LIMIT @row_limit
+    FOR UPDATE SKIP LOCKED
+)
+UPDATE notification_digests n
+SET last_sent_at = NOW()
+FROM due
+JOIN users u ON u.id = due.user_id
+LEFT JOIN user_profiles p ON p.user_id = due.user_id
+WHERE n.user_id = due.user_id
+RETURNING u.id AS user_id, u.username, COALESCE(p.email, '')::text AS email, due.since, n.unsubscribe_token;
+
+-- name: GetDigestDirectMessages :many
+-- Direct messages to user_id after since, counted per sender
+SELECT u.username, COUNT(*)::int AS messages, MAX(m.created_at)::timestamptz AS latest
+FROM messages m
+JOIN users u ON u.id = m.from_user_id
+WHERE m.to_user_id = @user_id::uuid
+    AND m.created_at > @since
+    AND m.deleted_at IS NULL
+    AND m.subtype <> 'system'
+GROUP BY u.username
+ORDER BY latest DESC
+LIMIT @row_limit;
+
+-- name: GetDigestMentions :many
+-- Messages after since that mention @username in groups user_id belongs to,
+-- counted per group
+SELECT g.id, g.name, COUNT(*)::int AS mentions, MAX(m.created_at)::timestamptz AS latest
+FROM messages m
+JOIN groups g ON g.id = m.group_id
+JOIN group_members gm ON gm.group_id = m.group_id AND gm.user_id = @user_id
+WHERE m.created_at > @since
+    AND m.from_user_id <> @user_id
+    AND m.deleted_at IS NULL
+    AND m.content ~* ('(^|[^[:alnum:]_-])@' || @username::text || '($|[^[:alnum:]_-])')
+GROUP BY g.id, g.name
+ORDER BY latest DESC
+LIMIT @row_limit;
+
+-- name: GetDigestFriendRequests :many
+-- Users who sent user_id a friend request after since that is still pending
+SELECT u.username
+FROM friends f
+JOIN users u ON u.id = f.user_id
+WHERE f.friend_id = @user_id::uuid
+    AND NOT f.accepted
+    AND f.created_at > @since
+ORDER BY f.created_at DESC
+LIMIT @row_limit;
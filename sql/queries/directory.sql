@@ -0,0 +1,36 @@
+-- name: GetDirectoryListing :one
+SELECT * FROM directory_listings WHERE user_id = $1;
+
+-- name: UpsertDirectoryListing :one
+INSERT INTO directory_listings (user_id, tags)
+VALUES ($1, $2)
+ON CONFLICT (user_id) DO UPDATE
+SET tags = EXCLUDED.tags
+RETURNING *;
+
+-- name: DeleteDirectoryListing :exec
+DELETE FROM directory_listings WHERE user_id = $1;
+
+-- name: ListDirectory :many
+-- Listed users after after_username, optionally only those with tag
+SELECT
+    u.username,
+    u.icon,
+    u.custom_icon,
+    COALESCE(p.display_name, '')::text as display_name,
+    d.tags
+FROM directory_listings d
+JOIN users u ON u.id = d.user_id
+LEFT JOIN user_profiles p ON p.user_id = u.id
+WHERE (@tag::text = '' OR @tag::text = ANY(d.tags))
+    AND u.username > @after_username::text
+ORDER BY u.username
+LIMIT @row_limit;
+
+-- name: GetDiscoverableUsernames :many
+-- Usernames user search may return: those of listed users, and username
+-- itself whether listed or not
+SELECT u.username FROM users u
+WHERE u.username = @username::text
+    OR EXISTS (SELECT 1 FROM directory_listings d WHERE d.user_id = u.id)
+ORDER BY u.username;
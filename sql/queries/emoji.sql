@@ -0,0 +1,13 @@
+-- name: CreateCustomEmoji :one
+INSERT INTO custom_emoji (shortcode, image_url, created_by)
+VALUES ($1, $2, $3)
+RETURNING *;
+
+-- name: ListCustomEmoji :many
+SELECT * FROM custom_emoji
+ORDER BY shortcode;
+
+-- name: DeleteCustomEmoji :one
+DELETE FROM custom_emoji
+WHERE shortcode = $1
+RETURNING *;
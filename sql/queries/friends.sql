@@ -6,7 +6,7 @@ RETURNING *;
 -- name: AcceptFriend :one
 UPDATE friends
 SET accepted = true
-WHERE user_id = $1 AND friend_id = $2
+WHERE user_id = $1 AND friend_id = $2 AND NOT accepted
 RETURNING *;
 
 -- name: RemoveFreind :one
@@ -31,4 +31,14 @@ ORDER BY f.created_at DESC;
 
 -- name: GetFriendRequests :many
 SELECT * FROM friends 
-WHERE friend_id = $1 AND accepted = false;
\ No newline at end of file
+WHERE friend_id = $1 AND accepted = false;
+-- name: AreFriends :one
+SELECT EXISTS (
+    SELECT 1 FROM friends f
+    JOIN users a ON a.username = @user1
+    JOIN users b ON b.username = @user2
+    WHERE f.accepted = true AND (
+        (f.user_id = a.id AND f.friend_id = b.id) OR
+        (f.user_id = b.id AND f.friend_id = a.id)
+    )
+);
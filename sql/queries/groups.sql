@@ -3,6 +3,17 @@ INSERT INTO groups (name, description, icon, custom_icon, created_by)
 VALUES ($1, $2, $3, $4, $5)
 RETURNING *;
 
+-- name: CreateGroupDM :one
+INSERT INTO groups (name, created_by, kind)
+VALUES ('', $1, 'dm')
+RETURNING *;
+
+-- name: ConvertGroupDM :one
+UPDATE groups
+SET kind = 'group', name = $2, updated_at = NOW()
+WHERE id = $1 AND kind = 'dm'
+RETURNING *;
+
 -- name: GetGroupByID :one
 SELECT * FROM groups WHERE id = $1;
 
@@ -58,8 +69,31 @@ SELECT EXISTS(
 -- name: IsGroupAdmin :one
 SELECT EXISTS(
     SELECT 1 FROM group_members
-    WHERE group_id = $1 AND user_id = $2 AND role = 'admin'
+    WHERE group_id = $1 AND user_id = $2 AND role IN ('owner', 'admin')
 ) AS is_admin;
 
 -- name: GetGroupMemberCount :one
-SELECT COUNT(*) FROM group_members WHERE group_id = $1;
\ No newline at end of file
+SELECT COUNT(*) FROM group_members WHERE group_id = $1;
+
+-- name: TransferGroupOwnership :execrows
+-- Makes new_owner the owner and the previous owner an admin in one statement
+UPDATE group_members
+SET role = CASE WHEN user_id = @new_owner::uuid THEN 'owner' ELSE 'admin' END
+WHERE group_id = @group_id AND (user_id = @new_owner::uuid OR role = 'owner');
+
+-- name: PromoteGroupSuccessor :execrows
+-- Makes the highest ranked, longest standing member the owner of a group
+-- left without one
+UPDATE group_members
+SET role = 'owner'
+WHERE group_id = $1
+    AND user_id = (
+        SELECT m.user_id FROM group_members m
+        WHERE m.group_id = $1
+        ORDER BY CASE m.role WHEN 'admin' THEN 0 WHEN 'moderator' THEN 1 ELSE 2 END, m.joined_at
+        LIMIT 1
+    )
+    AND NOT EXISTS (
+        SELECT 1 FROM group_members o
+        WHERE o.group_id = $1 AND o.role = 'owner'
+    );
@@ -0,0 +1,44 @@
+-- name: CreateImpersonation :one
+-- Records that admin signed in as username with session_id
+INSERT INTO impersonations (admin_id, admin_username, user_id, reason, session_id, expires_at)
+SELECT a.id, a.username, u.id, @reason::text, @session_id::text, @expires_at::timestamptz
+FROM users u, users a
+WHERE u.username = @username::text AND a.username = @admin::text
+RETURNING *;
+
+-- name: EndImpersonation :one
+-- Ends the impersonation of session_id unless it already ended
+UPDATE impersonations
+SET ended_at = NOW()
+WHERE session_id = @session_id::text AND ended_at IS NULL
+RETURNING *;
+
+-- name: EndExpiredImpersonations :many
+-- Ends the impersonations past their expiry
+UPDATE impersonations
+SET ended_at = expires_at
+WHERE ended_at IS NULL AND expires_at <= NOW()
+RETURNING *;
+
+-- name: ListUnnotifiedImpersonations :many
+-- Ended impersonations whose user has not been told yet
+SELECT i.*, u.username
+FROM impersonations i
+JOIN users u ON u.id = i.user_id
+WHERE i.ended_at IS NOT NULL AND i.notified_at IS NULL
+ORDER BY i.ended_at
+LIMIT @row_limit;
+
+-- name: MarkImpersonationNotified :exec
+UPDATE impersonations
+SET notified_at = NOW()
+WHERE id = @id::uuid;
+
+-- name: ListImpersonations :many
+-- The latest impersonations, of username when set
+SELECT i.*, u.username
+FROM impersonations i
+JOIN users u ON u.id = i.user_id
+WHERE sqlc.narg(username)::text IS NULL OR u.username = sqlc.narg(username)::text
+ORDER BY i.started_at DESC
+LIMIT @row_limit;
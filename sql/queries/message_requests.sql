@@ -0,0 +1,71 @@
+-- name: GetMessageRequestState :one
+-- How a direct message from sender to recipient is admitted: freely between
+-- friends or once the recipient wrote to the sender, otherwise through the
+-- sender's request. status and reverse_status are the requests of the sender
+-- and of the recipient, empty if there is none.
+SELECT
+    EXISTS (
+        SELECT 1 FROM friends f
+        WHERE f.accepted = true AND (
+            (f.user_id = s.id AND f.friend_id = r.id) OR
+            (f.user_id = r.id AND f.friend_id = s.id)
+        )
+    ) AS friends,
+    EXISTS (
+        SELECT 1 FROM messages m
+        WHERE m.from_user_id = r.id AND m.to_user_id = s.id AND m.subtype <> 'system'
+    ) AS replied,
+    COALESCE(own.status, '')::text AS status,
+    COALESCE(theirs.status, '')::text AS reverse_status
+FROM users s
+JOIN users r ON r.username = @recipient::text
+LEFT JOIN message_requests own ON own.sender_id = s.id AND own.recipient_id = r.id
+LEFT JOIN message_requests theirs ON theirs.sender_id = r.id AND theirs.recipient_id = s.id
+WHERE s.username = @sender::text;
+
+-- name: RecordMessageRequest :execrows
+-- Counts a message against the sender's request, unless the request is no
+-- longer pending or already holds max_messages messages
+INSERT INTO message_requests (sender_id, recipient_id, messages)
+SELECT s.id, r.id, 1
+FROM users s, users r
+WHERE s.username = @sender::text AND r.username = @recipient::text
+ON CONFLICT (sender_id, recipient_id) DO UPDATE
+SET messages = message_requests.messages + 1, updated_at = NOW()
+WHERE message_requests.status = 'pending'
+    AND message_requests.messages < @max_messages::int;
+
+-- name: SetMessageRequestStatus :execrows
+UPDATE message_requests mr
+SET status = @status::text, updated_at = NOW()
+FROM users s, users r
+WHERE mr.sender_id = s.id
+    AND mr.recipient_id = r.id
+    AND s.username = @sender::text
+    AND r.username = @recipient::text;
+
+-- name: ListMessageRequests :many
+-- The pending requests to recipient, most recently active first, with the
+-- latest message of each
+SELECT
+    s.username AS sender,
+    mr.messages,
+    mr.created_at,
+    mr.updated_at,
+    latest.content AS latest_content,
+    latest.subtype AS latest_subtype
+FROM message_requests mr
+JOIN users r ON r.id = mr.recipient_id
+JOIN users s ON s.id = mr.sender_id
+LEFT JOIN LATERAL (
+    SELECT m.content, m.subtype
+    FROM messages m
+    WHERE m.from_user_id = mr.sender_id
+        AND m.to_user_id = mr.recipient_id
+        AND m.deleted_at IS NULL
+    ORDER BY m.created_at DESC
+    LIMIT 1
+) latest ON true
+WHERE r.username = @recipient::text AND mr.status = 'pending'
+ORDER BY mr.updated_at DESC, s.username
+LIMIT @row_limit::int;
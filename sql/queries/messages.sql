@@ -5,16 +5,20 @@ INSERT INTO messages (
     to_user_id,
     content,
     is_group,
-    group_id
+    group_id,
+    subtype
 ) VALUES (
-    $1, $2, $3, $4, $5, $6
+    $1, $2, $3, $4, $5, $6, $7
 ) RETURNING *;
 
 -- name: GetMessagesBetweenUsers :many
 SELECT
     m.message_id,
     m.content,
+    m.subtype,
     m.created_at,
+    m.edited_at,
+    m.deleted_at,
     u_from.username as from_username,
     u_to.username as to_username
 FROM messages m
@@ -25,3 +29,191 @@ WHERE
     (u_from.username = $2 AND u_to.username = $1)
 ORDER BY m.created_at DESC
 LIMIT $3 OFFSET $4;
+
+-- name: GetMessagesBetweenUsersAfter :many
+SELECT
+    m.message_id,
+    m.content,
+    m.subtype,
+    m.created_at,
+    m.edited_at,
+    m.deleted_at,
+    u_from.username as from_username,
+    u_to.username as to_username
+FROM messages m
+JOIN users u_from ON m.from_user_id = u_from.id
+JOIN users u_to ON m.to_user_id = u_to.id
+WHERE
+    ((u_from.username = @user1 AND u_to.username = @user2) OR
+     (u_from.username = @user2 AND u_to.username = @user1))
+    AND m.created_at >= @after_created_at::timestamptz
+ORDER BY m.created_at ASC, m.message_id ASC
+LIMIT @row_limit;
+
+-- name: GetMessagesBetweenUsersBefore :many
+SELECT
+    m.message_id,
+    m.content,
+    m.subtype,
+    m.created_at,
+    m.edited_at,
+    m.deleted_at,
+    u_from.username as from_username,
+    u_to.username as to_username
+FROM messages m
+JOIN users u_from ON m.from_user_id = u_from.id
+JOIN users u_to ON m.to_user_id = u_to.id
+WHERE
+    ((u_from.username = @user1 AND u_to.username = @user2) OR
+     (u_from.username = @user2 AND u_to.username = @user1))
+    AND (m.created_at, m.message_id) < (@before_created_at::timestamptz, @before_message_id::text)
+ORDER BY m.created_at DESC, m.message_id DESC
+LIMIT @row_limit;
+
+-- name: EditMessage :execrows
+UPDATE messages
+SET content = @content, edited_at = NOW()
+WHERE message_id = @message_id
+    AND from_user_id = (SELECT id FROM users WHERE username = @from_username::text)
+    AND to_user_id = (SELECT id FROM users WHERE username = @to_username::text)
+    AND subtype = ''
+    AND deleted_at IS NULL;
+
+-- name: DeleteMessage :execrows
+UPDATE messages
+SET content = '', deleted_at = NOW()
+WHERE message_id = @message_id
+    AND from_user_id = (SELECT id FROM users WHERE username = @from_username::text)
+    AND to_user_id = (SELECT id FROM users WHERE username = @to_username::text)
+    AND subtype <> 'system'
+    AND deleted_at IS NULL;
+
+-- name: CountUnreadMessages :one
+SELECT COUNT(*) FROM messages
+WHERE from_user_id = (SELECT id FROM users WHERE username = @from_username::text)
+    AND to_user_id = (SELECT id FROM users WHERE username = @to_username::text)
+    AND created_at >= @since::timestamptz
+    AND deleted_at IS NULL;
+
+-- name: SearchConversationMessages :many
+SELECT
+    m.message_id,
+    m.content,
+    m.created_at,
+    m.edited_at,
+    u_from.username as from_username,
+    u_to.username as to_username
+FROM messages m
+JOIN users u_from ON m.from_user_id = u_from.id
+JOIN users u_to ON m.to_user_id = u_to.id
+WHERE
+    ((u_from.username = @user1 AND u_to.username = @user2) OR
+     (u_from.username = @user2 AND u_to.username = @user1))
+    AND m.subtype = ''
+    AND m.deleted_at IS NULL
+    AND m.content ILIKE @pattern::text
+    AND (m.created_at, m.message_id) < (@before_created_at::timestamptz, @before_message_id::text)
+ORDER BY m.created_at DESC, m.message_id DESC
+LIMIT @row_limit;
+
+-- name: ArchiveMessage :execrows
+-- Writes a message read from Kafka unless it is already stored or its
+-- sender, recipient or group no longer exists
+INSERT INTO messages (
+    message_id,
+    from_user_id,
+    to_user_id,
+    group_id,
+    content,
+    is_group,
+    subtype,
+    created_at
+)
+SELECT @message_id::text, u_from.id, u_to.id, g.id, @content::text, @is_group::boolean, @subtype::text, @created_at::timestamptz
+FROM users u_from
+LEFT JOIN users u_to ON u_to.username = @to_username::text
+LEFT JOIN groups g ON g.id = sqlc.narg(group_id)::uuid
+WHERE u_from.username = @from_username::text
+    AND CASE WHEN @is_group::boolean THEN g.id IS NOT NULL ELSE u_to.id IS NOT NULL END
+ON CONFLICT (message_id) DO NOTHING;
+
+-- name: ArchiveMessageEdit :execrows
+-- Applies an edit read from Kafka unless a later one is already stored
+UPDATE messages
+SET content = @content, edited_at = @edited_at::timestamptz
+WHERE message_id = @message_id
+    AND deleted_at IS NULL
+    AND (edited_at IS NULL OR edited_at < @edited_at::timestamptz);
+
+-- name: ArchiveMessageDelete :execrows
+UPDATE messages
+SET content = '', deleted_at = @deleted_at::timestamptz
+WHERE message_id = @message_id
+    AND deleted_at IS NULL;
+
+-- name: GetGroupMessages :many
+SELECT
+    m.message_id,
+    m.content,
+    m.subtype,
+    m.created_at,
+    m.edited_at,
+    m.deleted_at,
+    u_from.username as from_username
+FROM messages m
+JOIN users u_from ON m.from_user_id = u_from.id
+WHERE m.group_id = @group_id::uuid
+ORDER BY m.created_at DESC, m.message_id DESC
+LIMIT @row_limit;
+
+-- name: GetGroupMessagesAfter :many
+SELECT
+    m.message_id,
+    m.content,
+    m.subtype,
+    m.created_at,
+    m.edited_at,
+    m.deleted_at,
+    u_from.username as from_username
+FROM messages m
+JOIN users u_from ON m.from_user_id = u_from.id
+WHERE m.group_id = @group_id::uuid
+    AND m.created_at >= @after_created_at::timestamptz
+ORDER BY m.created_at ASC, m.message_id ASC
+LIMIT @row_limit;
+
+-- name: SearchMessages :many
+-- Full-text search over the conversations of username: direct messages they
+-- sent or received and messages of the groups they belong to, optionally
+-- limited to the conversation with with_user or to the group with_group
+SELECT
+    m.message_id,
+    m.content,
+    m.created_at,
+    m.edited_at,
+    m.group_id,
+    u_from.username as from_username,
+    COALESCE(u_to.username, '')::text as to_username
+FROM messages m
+JOIN users me ON me.username = @username::text
+JOIN users u_from ON m.from_user_id = u_from.id
+LEFT JOIN users u_to ON m.to_user_id = u_to.id
+WHERE
+    to_tsvector('simple', m.content) @@ websearch_to_tsquery('simple', @query::text)
+    AND m.subtype = ''
+    AND m.deleted_at IS NULL
+    AND (
+        (m.group_id IS NULL
+            AND (m.from_user_id = me.id OR m.to_user_id = me.id)
+            AND (@with_user::text = '' OR
+                 CASE WHEN m.from_user_id = me.id THEN u_to.username ELSE u_from.username END = @with_user::text)
+            AND sqlc.narg(with_group)::uuid IS NULL)
+        OR
+        (m.group_id IS NOT NULL
+            AND EXISTS (SELECT 1 FROM group_members gm WHERE gm.group_id = m.group_id AND gm.user_id = me.id)
+            AND @with_user::text = ''
+            AND (sqlc.narg(with_group)::uuid IS NULL OR m.group_id = sqlc.narg(with_group)::uuid))
+    )
+    AND (m.created_at, m.message_id) < (@before_created_at::timestamptz, @before_message_id::text)
+ORDER BY m.created_at DESC, m.message_id DESC
+LIMIT @row_limit;
@@ -0,0 +1,66 @@
+-- name: CreateAbuseReport :one
+INSERT INTO abuse_reports (reporter_id, reported_id, reason)
+VALUES ($1, $2, $3)
+RETURNING *;
+
+-- name: HasReportedSince :one
+SELECT EXISTS (
+    SELECT 1 FROM abuse_reports
+    WHERE reporter_id = $1 AND reported_id = $2 AND created_at > $3
+);
+
+-- name: HasReported :one
+SELECT EXISTS (
+    SELECT 1 FROM abuse_reports a
+    JOIN users reporter ON a.reporter_id = reporter.id
+    JOIN users reported ON a.reported_id = reported.id
+    WHERE reporter.username = @reporter AND reported.username = @reported
+);
+
+-- name: CountReportersSince :one
+SELECT COUNT(DISTINCT reporter_id) FROM abuse_reports
+WHERE reported_id = $1 AND created_at > $2;
+
+-- name: ListReportsAgainst :many
+SELECT a.id, u.username AS reporter, a.reason, a.created_at
+FROM abuse_reports a
+JOIN users u ON a.reporter_id = u.id
+WHERE a.reported_id = $1
+ORDER BY a.created_at DESC
+LIMIT $2;
+
+-- name: RestrictUser :one
+INSERT INTO user_restrictions (user_id, reason, expires_at)
+VALUES ($1, $2, $3)
+ON CONFLICT (user_id) DO UPDATE
+SET reason = EXCLUDED.reason,
+    created_at = NOW(),
+    expires_at = EXCLUDED.expires_at,
+    reviewed_by = NULL,
+    reviewed_at = NULL
+WHERE user_restrictions.expires_at <= NOW()
+RETURNING *;
+
+-- name: GetActiveRestriction :one
+SELECT r.user_id, u.username, r.reason, r.created_at, r.expires_at, r.reviewed_at
+FROM user_restrictions r
+JOIN users u ON r.user_id = u.id
+WHERE u.username = $1 AND r.expires_at > NOW();
+
+-- name: ListActiveRestrictions :many
+SELECT r.user_id, u.username, r.reason, r.created_at, r.expires_at, r.reviewed_at
+FROM user_restrictions r
+JOIN users u ON r.user_id = u.id
+WHERE r.expires_at > NOW()
+ORDER BY r.reviewed_at IS NOT NULL, r.created_at DESC;
+
+-- name: ReviewRestriction :one
+UPDATE user_restrictions
+SET expires_at = $2, reviewed_by = $3, reviewed_at = NOW()
+WHERE user_id = $1 AND expires_at > NOW()
+RETURNING *;
+
+-- name: LiftRestriction :one
+DELETE FROM user_restrictions
+WHERE user_id = $1
+RETURNING *;
@@ -0,0 +1,43 @@
+-- name: MuteContact :execrows
+-- Mutes username's conversation with contact until muted_until, or until
+-- unmuted when it is NULL
+INSERT INTO conversation_mutes (user_id, contact_id, muted_until)
+SELECT u.id, c.id, sqlc.narg(muted_until)::timestamptz
+FROM users u, users c
+WHERE u.username = @username::text AND c.username = @contact::text AND u.id <> c.id
+ON CONFLICT (user_id, contact_id) WHERE contact_id IS NOT NULL DO UPDATE
+SET muted_until = EXCLUDED.muted_until, created_at = NOW();
+
+-- name: MuteGroup :execrows
+-- Mutes a group username belongs to until muted_until, or until unmuted when
+-- it is NULL
+INSERT INTO conversation_mutes (user_id, group_id, muted_until)
+SELECT u.id, gm.group_id, sqlc.narg(muted_until)::timestamptz
+FROM users u
+JOIN group_members gm ON gm.user_id = u.id AND gm.group_id = @group_id::uuid
+WHERE u.username = @username::text
+ON CONFLICT (user_id, group_id) WHERE group_id IS NOT NULL DO UPDATE
+SET muted_until = EXCLUDED.muted_until, created_at = NOW();
+
+-- name: UnmuteContact :execrows
+DELETE FROM conversation_mutes m
+USING users u, users c
+WHERE m.user_id = u.id AND m.contact_id = c.id
+    AND u.username = @username::text AND c.username = @contact::text;
+
+-- name: UnmuteGroup :execrows
+DELETE FROM conversation_mutes m
+USING users u
+WHERE m.user_id = u.id AND m.group_id = @group_id::uuid
+    AND u.username = @username::text;
+
+-- name: ListMutes :many
+-- The conversations username muted that are still muted, newest first
+SELECT c.username AS contact, g.id AS group_id, g.name AS group_name, m.muted_until, m.created_at
+FROM conversation_mutes m
+JOIN users u ON u.id = m.user_id
+LEFT JOIN users c ON c.id = m.contact_id
+LEFT JOIN groups g ON g.id = m.group_id
+WHERE u.username = @username::text
+    AND (m.muted_until IS NULL OR m.muted_until > NOW())
+ORDER BY m.created_at DESC;
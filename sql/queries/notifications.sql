@@ -0,0 +1,71 @@
+-- name: CreateNotification :one
+-- Records a notification for username caused by actor, who may be empty
+INSERT INTO notifications (user_id, type, actor_id, content, data)
+SELECT u.id, @type::text, a.id, @content::text, @data::jsonb
+FROM users u
+LEFT JOIN users a ON a.username = @actor::text
+WHERE u.username = @username::text
+RETURNING id, created_at;
+
+-- name: CreateMentionNotifications :many
+-- Records a mention by actor for each of usernames who belongs to group_id,
+-- returning who was notified
+WITH inserted AS (
+    INSERT INTO notifications (user_id, type, actor_id, content, data)
+    SELECT u.id, 'mention', a.id, @content::text, @data::jsonb
+    FROM users u
+    JOIN group_members gm ON gm.user_id = u.id AND gm.group_id = @group_id::uuid
+    JOIN users a ON a.username = @actor::text
+    WHERE u.username = ANY(@usernames::text[]) AND u.id <> a.id
+    RETURNING id, user_id, created_at
+)
+SELECT i.id, u.username, i.created_at
+FROM inserted i
+JOIN users u ON u.id = i.user_id;
+
+-- name: ListNotifications :many
+-- A page of username's notifications, newest first, of one type when type
+-- is set, after the cursor (before_at, before_id) when before_at is set
+SELECT n.id, n.type, a.username AS actor, n.content, n.data, n.created_at, n.read_at
+FROM notifications n
+JOIN users u ON u.id = n.user_id
+LEFT JOIN users a ON a.id = n.actor_id
+WHERE u.username = @username::text
+    AND (NOT @unread_only::bool OR n.read_at IS NULL)
+    AND (sqlc.narg(type)::text IS NULL OR n.type = sqlc.narg(type)::text)
+    AND (sqlc.narg(before_at)::timestamptz IS NULL OR (n.created_at, n.id) < (sqlc.narg(before_at)::timestamptz, @before_id::uuid))
+ORDER BY n.created_at DESC, n.id DESC
+LIMIT @row_limit;
+
+-- name: ListNotificationsBetween :many
+-- username's notifications created after after_at and before before_at,
+-- oldest first
+SELECT n.id, n.type, a.username AS actor, n.content, n.data, n.created_at, n.read_at
+FROM notifications n
+JOIN users u ON u.id = n.user_id
+LEFT JOIN users a ON a.id = n.actor_id
+WHERE u.username = @username::text
+    AND n.created_at > @after_at::timestamptz
+    AND n.created_at < @before_at::timestamptz
+ORDER BY n.created_at, n.id
+LIMIT @row_limit;
+
+-- name: CountUnreadNotifications :one
+-- Counts username's unread notifications, of one type when type is set
+SELECT COUNT(*)
+FROM notifications n
+JOIN users u ON u.id = n.user_id
+WHERE u.username = @username::text AND n.read_at IS NULL
+    AND (sqlc.narg(type)::text IS NULL OR n.type = sqlc.narg(type)::text);
+
+-- name: MarkNotificationRead :execrows
+UPDATE notifications n
+SET read_at = COALESCE(n.read_at, NOW())
+FROM users u
+WHERE n.user_id = u.id AND n.id = @id::uuid AND u.username = @username::text;
+
+-- name: MarkAllNotificationsRead :execrows
+UPDATE notifications n
+SET read_at = NOW()
+FROM users u
+WHERE n.user_id = u.id AND u.username = @username::text AND n.read_at IS NULL;
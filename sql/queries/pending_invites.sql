@@ -0,0 +1,27 @@
+-- name: CreatePendingInvite :execrows
+INSERT INTO pending_invites (group_id, invitee_id, inviter_id)
+VALUES ($1, $2, $3)
+ON CONFLICT (group_id, invitee_id) DO NOTHING;
+
+-- name: DeletePendingInvite :one
+-- Removes an answered invitation, returning who sent it
+DELETE FROM pending_invites pi
+USING users u
+WHERE pi.group_id = $1 AND pi.invitee_id = $2 AND u.id = pi.inviter_id
+RETURNING u.username AS inviter;
+
+-- name: ListUserPendingInvites :many
+SELECT pi.group_id, g.name AS group_name, inviter.username AS inviter, pi.created_at
+FROM pending_invites pi
+INNER JOIN groups g ON g.id = pi.group_id
+INNER JOIN users inviter ON inviter.id = pi.inviter_id
+WHERE pi.invitee_id = $1
+ORDER BY pi.created_at DESC;
+
+-- name: ListGroupPendingInvites :many
+SELECT invitee.username AS invitee, inviter.username AS inviter, pi.created_at
+FROM pending_invites pi
+INNER JOIN users invitee ON invitee.id = pi.invitee_id
+INNER JOIN users inviter ON inviter.id = pi.inviter_id
+WHERE pi.group_id = $1
+ORDER BY pi.created_at DESC;
@@ -0,0 +1,51 @@
+-- name: GetPinnedMessages :many
+-- The pins of the conversation between username and contact, newest first.
+-- Messages the history writer has not stored yet have no content here.
+SELECT
+    p.message_id,
+    u_pin.username as pinned_by,
+    p.pinned_at,
+    m.content,
+    m.subtype,
+    m.created_at,
+    m.edited_at,
+    u_from.username as from_username
+FROM pinned_messages p
+JOIN users a ON a.username = @username::text
+JOIN users b ON b.username = @contact::text
+JOIN users u_pin ON u_pin.id = p.pinned_by
+LEFT JOIN messages m ON m.message_id = p.message_id
+LEFT JOIN users u_from ON u_from.id = m.from_user_id
+WHERE p.user_low = LEAST(a.id, b.id)
+    AND p.user_high = GREATEST(a.id, b.id)
+    AND m.deleted_at IS NULL
+ORDER BY p.pinned_at DESC, p.message_id;
+
+-- name: PinMessage :execrows
+-- Pins a message unless it is pinned already or the conversation has
+-- max_pins pins
+WITH pair AS (
+    SELECT LEAST(a.id, b.id) AS user_low, GREATEST(a.id, b.id) AS user_high, a.id AS pinned_by
+    FROM users a, users b
+    WHERE a.username = @username::text AND b.username = @contact::text
+)
+INSERT INTO pinned_messages (message_id, user_low, user_high, pinned_by)
+SELECT @message_id::text, pair.user_low, pair.user_high, pair.pinned_by
+FROM pair
+WHERE (
+    SELECT COUNT(*) FROM pinned_messages p
+    WHERE p.user_low = pair.user_low AND p.user_high = pair.user_high
+) < @max_pins::int
+ON CONFLICT (message_id) DO NOTHING;
+
+-- name: UnpinMessage :execrows
+DELETE FROM pinned_messages p
+USING users a, users b
+WHERE p.message_id = @message_id::text
+    AND a.username = @username::text
+    AND b.username = @contact::text
+    AND p.user_low = LEAST(a.id, b.id)
+    AND p.user_high = GREATEST(a.id, b.id);
+
+-- name: DeletePinnedMessage :exec
+DELETE FROM pinned_messages WHERE message_id = $1;
@@ -0,0 +1,34 @@
+-- name: GetUserProfile :one
+SELECT * FROM user_profiles WHERE user_id = $1;
+
+-- name: UpsertUserProfile :one
+INSERT INTO user_profiles (user_id, display_name, email, phone, website)
+VALUES ($1, $2, $3, $4, $5)
+ON CONFLICT (user_id) DO UPDATE
+SET display_name = EXCLUDED.display_name,
+    email = EXCLUDED.email,
+    phone = EXCLUDED.phone,
+    website = EXCLUDED.website,
+    updated_at = NOW()
+RETURNING *;
+
+-- name: CreateProfileChange :one
+INSERT INTO profile_changes (user_id, field, old_value, new_value)
+VALUES ($1, $2, $3, $4)
+RETURNING *;
+
+-- name: GetLastProfileChange :one
+SELECT * FROM profile_changes
+WHERE user_id = $1 AND field = $2
+ORDER BY changed_at DESC
+LIMIT 1;
+
+-- name: GetProfileChanges :many
+SELECT * FROM profile_changes
+WHERE user_id = $1
+ORDER BY changed_at DESC
+LIMIT $2;
+
+-- name: LockUserProfile :exec
+-- Holds the user's row until the transaction ends, serializing profile updates
+SELECT id FROM users WHERE id = $1 FOR UPDATE;
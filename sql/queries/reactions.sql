@@ -0,0 +1,30 @@
+-- name: GetMessageReactions :many
+SELECT u.username, r.emoji
+FROM message_reactions r
+JOIN users u ON u.id = r.user_id
+WHERE r.message_id = $1
+ORDER BY r.created_at, u.username;
+
+-- name: DeleteMessageReactions :exec
+DELETE FROM message_reactions WHERE message_id = $1;
+
+-- name: InsertMessageReactions :exec
+-- Writes the reactions of a message given as parallel arrays, skipping users
+-- that no longer exist
+INSERT INTO message_reactions (message_id, user_id, emoji)
+SELECT @message_id::text, u.id, r.emoji
+FROM unnest(@usernames::text[], @emojis::text[]) AS r(username, emoji)
+JOIN users u ON u.username = r.username
+ON CONFLICT DO NOTHING;
+
+-- name: GetMessageParticipants :one
+-- The conversation a stored message belongs to
+SELECT
+    m.group_id,
+    u_from.username as from_username,
+    COALESCE(u_to.username, '')::text as to_username,
+    (m.deleted_at IS NOT NULL)::boolean as deleted
+FROM messages m
+JOIN users u_from ON m.from_user_id = u_from.id
+LEFT JOIN users u_to ON m.to_user_id = u_to.id
+WHERE m.message_id = $1;
@@ -0,0 +1,24 @@
+-- name: UpsertSession :exec
+INSERT INTO sessions (session_id, user_id, username, login_time, last_activity, expires_at)
+VALUES ($1, $2, $3, $4, $5, $6)
+ON CONFLICT (session_id) DO UPDATE
+SET username = EXCLUDED.username,
+    last_activity = EXCLUDED.last_activity,
+    expires_at = EXCLUDED.expires_at;
+
+-- name: GetSession :one
+SELECT * FROM sessions
+WHERE session_id = $1 AND expires_at > NOW();
+
+-- name: TouchSession :execrows
+UPDATE sessions
+SET last_activity = $2, expires_at = $3
+WHERE session_id = $1 AND expires_at > NOW();
+
+-- name: DeleteSession :exec
+DELETE FROM sessions
+WHERE session_id = $1;
+
+-- name: DeleteExpiredSessions :execrows
+DELETE FROM sessions
+WHERE expires_at <= NOW();
@@ -0,0 +1,39 @@
+-- name: CreateShareLink :one
+INSERT INTO share_links (token_hash, owner_id, contact_id, range_from, range_to, passcode_hash, expires_at)
+SELECT $1, o.id, c.id, $4, $5, $6, $7
+FROM users o, users c
+WHERE o.username = $2 AND c.username = $3
+RETURNING id, created_at;
+
+-- name: GetShareLinkByToken :one
+SELECT s.id, o.username AS owner, c.username AS contact, s.range_from, s.range_to,
+       s.passcode_hash, s.expires_at, s.revoked_at, s.created_at
+FROM share_links s
+JOIN users o ON s.owner_id = o.id
+JOIN users c ON s.contact_id = c.id
+WHERE s.token_hash = $1;
+
+-- name: ListShareLinks :many
+SELECT s.id, s.range_from, s.range_to, s.passcode_hash IS NOT NULL AS protected,
+       s.expires_at, s.created_at
+FROM share_links s
+JOIN users o ON s.owner_id = o.id
+JOIN users c ON s.contact_id = c.id
+WHERE o.username = $1 AND c.username = $2
+  AND s.revoked_at IS NULL
+  AND (s.expires_at IS NULL OR s.expires_at > NOW())
+ORDER BY s.created_at DESC;
+
+-- name: RevokeShareLink :execrows
+UPDATE share_links
+SET revoked_at = NOW()
+WHERE id = $1
+  AND owner_id = (SELECT id FROM users WHERE username = $2)
+  AND revoked_at IS NULL;
+
+-- name: RecordShareLinkFailure :one
+UPDATE share_links
+SET failed_attempts = failed_attempts + 1,
+    revoked_at = CASE WHEN failed_attempts + 1 >= @max_attempts::int THEN COALESCE(revoked_at, NOW()) ELSE revoked_at END
+WHERE id = @id
+RETURNING failed_attempts;
@@ -0,0 +1,68 @@
+-- name: ListUploadReferences :many
+SELECT custom_icon::text AS url FROM users
+WHERE custom_icon LIKE '/uploads/%'
+UNION
+SELECT custom_icon::text FROM groups
+WHERE custom_icon LIKE '/uploads/%'
+UNION
+SELECT image_url FROM custom_emoji
+WHERE image_url LIKE '/uploads/%'
+UNION
+SELECT url FROM upload_objects
+WHERE ref_count > 0
+UNION
+SELECT '/uploads/attachments/' || id::text FROM attachments;
+
+-- name: AcquireUploadObject :one
+INSERT INTO upload_objects (sha256, url, size_bytes, ref_count)
+VALUES ($1, $2, $3, 1)
+ON CONFLICT (sha256) DO UPDATE
+SET ref_count = upload_objects.ref_count + 1, updated_at = NOW()
+RETURNING *;
+
+-- name: ReleaseUploadObject :one
+UPDATE upload_objects
+SET ref_count = GREATEST(ref_count - 1, 0), updated_at = NOW()
+WHERE url = $1
+RETURNING ref_count;
+
+-- name: ReconcileUploadObjectRefs :execrows
+UPDATE upload_objects o
+SET ref_count = r.refs, updated_at = NOW()
+FROM (
+    SELECT u.sha256, COUNT(ref.url)::int AS refs
+    FROM upload_objects u
+    LEFT JOIN (
+        SELECT custom_icon::text AS url FROM users
+        UNION ALL
+        SELECT custom_icon::text FROM groups
+        UNION ALL
+        SELECT image_url FROM custom_emoji
+    ) ref ON ref.url = u.url
+    GROUP BY u.sha256
+) r
+WHERE o.sha256 = r.sha256 AND o.ref_count <> r.refs AND o.updated_at < $1;
+
+-- name: DeleteUploadObject :exec
+DELETE FROM upload_objects
+WHERE url = $1 AND ref_count = 0;
+
+-- name: GetUploadObjectStats :one
+SELECT COUNT(*) AS objects,
+       COALESCE(SUM(size_bytes), 0)::bigint AS stored_bytes,
+       COALESCE(SUM(size_bytes * GREATEST(ref_count - 1, 0)), 0)::bigint AS saved_bytes
+FROM upload_objects;
+
+-- name: GetUserUploadUsage :one
+-- Bytes of the distinct objects the user's icon, emoji and group icons refer
+-- to, leaving out the object with hash $2, and whether they refer to it
+SELECT COALESCE(SUM(o.size_bytes) FILTER (WHERE o.sha256 <> $2), 0)::bigint AS used_bytes,
+       COALESCE(BOOL_OR(o.sha256 = $2), false)::boolean AS holds_object
+FROM upload_objects o
+WHERE o.url IN (
+    SELECT custom_icon::text FROM users WHERE id = $1
+    UNION
+    SELECT custom_icon::text FROM groups WHERE created_by = $1
+    UNION
+    SELECT image_url FROM custom_emoji WHERE created_by = $1
+);
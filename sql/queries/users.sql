@@ -3,12 +3,20 @@ INSERT INTO users (username, password_hash, icon, custom_icon)
 VALUES ($1, $2, $3, $4)
 RETURNING *;
 
+-- name: CreateBotUser :one
+INSERT INTO users (username, password_hash, icon, custom_icon, role)
+VALUES ($1, $2, $3, $4, 'bot')
+RETURNING *;
+
 -- name: GetUserByID :one
 SELECT * FROM users WHERE id = $1;
 
 -- name: GetUserByUsername :one
 SELECT * FROM users WHERE username = $1;
 
+-- name: GetUsersByIDs :many
+SELECT * FROM users WHERE id = ANY($1::uuid[]);
+
 -- name: GetUsersByUsernames :many
 SELECT * FROM users WHERE username = ANY($1::text[]);
 
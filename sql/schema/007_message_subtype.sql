@@ -0,0 +1,6 @@
+-- +goose Up
+-- Distinguishes rich message kinds (e.g. 'gif') from plain text ('')
+ALTER TABLE messages ADD COLUMN subtype TEXT NOT NULL DEFAULT '';
+
+-- +goose Down
+ALTER TABLE messages DROP COLUMN subtype;
@@ -0,0 +1,11 @@
+-- +goose Up
+CREATE TABLE custom_emoji (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    shortcode TEXT NOT NULL UNIQUE,
+    image_url TEXT NOT NULL,
+    created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- +goose Down
+DROP TABLE custom_emoji;
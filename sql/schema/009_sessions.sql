@@ -0,0 +1,14 @@
+-- +goose Up
+CREATE TABLE sessions (
+    session_id TEXT PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    username TEXT NOT NULL,
+    login_time BIGINT NOT NULL,
+    last_activity BIGINT NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX idx_sessions_expires_at ON sessions(expires_at);
+
+-- +goose Down
+DROP TABLE sessions;
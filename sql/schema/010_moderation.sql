@@ -0,0 +1,24 @@
+-- +goose Up
+CREATE TABLE abuse_reports (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    reporter_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    reported_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    reason TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_abuse_reports_reported ON abuse_reports(reported_id, created_at DESC);
+CREATE INDEX idx_abuse_reports_reporter ON abuse_reports(reporter_id, reported_id);
+
+CREATE TABLE user_restrictions (
+    user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    reason TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMPTZ NOT NULL,
+    reviewed_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    reviewed_at TIMESTAMPTZ
+);
+
+-- +goose Down
+DROP TABLE user_restrictions;
+DROP TABLE abuse_reports;
@@ -0,0 +1,15 @@
+-- +goose Up
+-- Uploaded files stored once by content hash. ref_count is the number of rows
+-- pointing at url; the upload collector reconciles it against the tables that
+-- reference uploads.
+CREATE TABLE upload_objects (
+    sha256 TEXT PRIMARY KEY,
+    url TEXT NOT NULL UNIQUE,
+    size_bytes BIGINT NOT NULL,
+    ref_count INTEGER NOT NULL DEFAULT 0 CHECK (ref_count >= 0),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- +goose Down
+DROP TABLE upload_objects;
@@ -0,0 +1,20 @@
+-- +goose Up
+-- Read-only links to a range of a direct conversation. Only a hash of the
+-- token is stored; the link itself is shown to its owner once.
+CREATE TABLE share_links (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    token_hash TEXT NOT NULL UNIQUE,
+    owner_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    contact_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    range_from TIMESTAMPTZ,
+    range_to TIMESTAMPTZ NOT NULL,
+    passcode_hash TEXT,
+    expires_at TIMESTAMPTZ,
+    revoked_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_share_links_owner ON share_links(owner_id, contact_id, created_at DESC);
+
+-- +goose Down
+DROP TABLE share_links;
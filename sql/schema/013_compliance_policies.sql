@@ -0,0 +1,13 @@
+-- +goose Up
+-- Every change to the compliance policy is a new row; the highest version is
+-- in force. Rows are never updated, so the table is the change history.
+CREATE TABLE compliance_policies (
+    version INTEGER PRIMARY KEY,
+    policy JSONB NOT NULL,
+    changed_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    comment TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- +goose Down
+DROP TABLE compliance_policies;
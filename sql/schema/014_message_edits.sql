@@ -0,0 +1,10 @@
+-- +goose Up
+-- Edited messages keep their row and creation time. Deleted messages become
+-- tombstones: the content is cleared and the row stays so history consumers
+-- and paging cursors still see the message ID.
+ALTER TABLE messages ADD COLUMN edited_at TIMESTAMPTZ;
+ALTER TABLE messages ADD COLUMN deleted_at TIMESTAMPTZ;
+
+-- +goose Down
+ALTER TABLE messages DROP COLUMN deleted_at;
+ALTER TABLE messages DROP COLUMN edited_at;
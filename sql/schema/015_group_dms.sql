@@ -0,0 +1,7 @@
+-- +goose Up
+-- Group DMs are ad-hoc groups without a name or admins; converting one into
+-- a regular group only changes its kind, so its history stays.
+ALTER TABLE groups ADD COLUMN kind TEXT NOT NULL DEFAULT 'group' CHECK (kind IN ('group', 'dm'));
+
+-- +goose Down
+ALTER TABLE groups DROP COLUMN kind;
@@ -0,0 +1,9 @@
+-- +goose Up
+-- Full-text search over message content. The 'simple' configuration only
+-- lowercases words, without stemming or stop words, as conversations mix
+-- languages. Postgres maintains the index as messages are written, both at
+-- send time and by the history consumer.
+CREATE INDEX idx_messages_search ON messages USING GIN (to_tsvector('simple', content));
+
+-- +goose Down
+DROP INDEX idx_messages_search;
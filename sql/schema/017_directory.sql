@@ -0,0 +1,14 @@
+-- +goose Up
+-- Users who chose to be discoverable. Only they are listed in the directory
+-- and matched by partial names in user search; everyone else can only be
+-- found by their exact username.
+CREATE TABLE directory_listings (
+    user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    tags TEXT[] NOT NULL DEFAULT '{}',
+    listed_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_directory_listings_tags ON directory_listings USING GIN (tags);
+
+-- +goose Down
+DROP TABLE directory_listings;
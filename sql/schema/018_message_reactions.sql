@@ -0,0 +1,15 @@
+-- +goose Up
+-- Reactions are kept in Redis while they change and written here by a
+-- periodic flush. message_id has no foreign key: group messages reach the
+-- messages table through the history consumer, possibly after their first
+-- reactions were flushed.
+CREATE TABLE message_reactions (
+    message_id VARCHAR(255) NOT NULL,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    emoji TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (message_id, user_id, emoji)
+);
+
+-- +goose Down
+DROP TABLE message_reactions;
@@ -0,0 +1,17 @@
+-- +goose Up
+-- Messages pinned in direct conversations. The conversation is the ordered
+-- pair of its participants' IDs. message_id has no foreign key: a message
+-- can be pinned while the history writer is still storing it.
+CREATE TABLE pinned_messages (
+    message_id VARCHAR(255) PRIMARY KEY,
+    user_low UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    user_high UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    pinned_by UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    pinned_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    CHECK (user_low < user_high)
+);
+
+CREATE INDEX idx_pinned_messages_conversation ON pinned_messages(user_low, user_high, pinned_at);
+
+-- +goose Down
+DROP TABLE pinned_messages;
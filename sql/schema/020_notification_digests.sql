@@ -0,0 +1,17 @@
+-- +goose Up
+-- Daily digests of missed activity for users who have not been connected for
+-- a while. last_seen_at is refreshed while the user has a WebSocket
+-- connection; users first seen after digests were introduced get a row then.
+-- The unsubscribe token in each email opts out without logging in.
+CREATE TABLE notification_digests (
+    user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    opted_out BOOLEAN NOT NULL DEFAULT FALSE,
+    last_seen_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_sent_at TIMESTAMPTZ,
+    unsubscribe_token UUID NOT NULL UNIQUE DEFAULT gen_random_uuid()
+);
+
+CREATE INDEX idx_notification_digests_last_seen ON notification_digests(last_seen_at) WHERE NOT opted_out;
+
+-- +goose Down
+DROP TABLE notification_digests;
@@ -0,0 +1,19 @@
+-- +goose Up
+-- Direct messages between users who are not friends are message requests.
+-- The sender may send a few messages until the recipient replies or accepts;
+-- once declined, the sender cannot write again unless the recipient does.
+CREATE TABLE message_requests (
+    sender_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    recipient_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    status VARCHAR(16) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'accepted', 'declined')),
+    messages INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (sender_id, recipient_id),
+    CHECK (sender_id <> recipient_id)
+);
+
+CREATE INDEX idx_message_requests_pending ON message_requests(recipient_id, updated_at DESC) WHERE status = 'pending';
+
+-- +goose Down
+DROP TABLE message_requests;
@@ -0,0 +1,22 @@
+-- +goose Up
+-- Group roles are ordered: owner > admin > moderator > member. Each regular
+-- group gets one owner: its creator if still an admin, else its longest
+-- standing admin, else its longest standing member.
+ALTER TABLE group_members ADD CONSTRAINT group_members_role_check
+    CHECK (role IN ('owner', 'admin', 'moderator', 'member'));
+
+UPDATE group_members gm
+SET role = 'owner'
+FROM (
+    SELECT DISTINCT ON (m.group_id) m.group_id, m.user_id
+    FROM group_members m
+    INNER JOIN groups g ON g.id = m.group_id
+    WHERE g.kind = 'group'
+    ORDER BY m.group_id, m.role = 'admin' DESC, m.user_id = g.created_by DESC, m.joined_at
+) heir
+WHERE gm.group_id = heir.group_id AND gm.user_id = heir.user_id;
+
+-- +goose Down
+UPDATE group_members SET role = 'admin' WHERE role = 'owner';
+UPDATE group_members SET role = 'member' WHERE role = 'moderator';
+ALTER TABLE group_members DROP CONSTRAINT group_members_role_check;
@@ -0,0 +1,15 @@
+-- +goose Up
+-- Invitations to join a group. A row lives until the invitee accepts or
+-- declines, or the group or either user is deleted.
+CREATE TABLE pending_invites (
+    group_id UUID NOT NULL REFERENCES groups(id) ON DELETE CASCADE,
+    invitee_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    inviter_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (group_id, invitee_id)
+);
+
+CREATE INDEX idx_pending_invites_invitee ON pending_invites(invitee_id, created_at DESC);
+
+-- +goose Down
+DROP TABLE pending_invites;
@@ -0,0 +1,18 @@
+-- +goose Up
+-- Conversations a user muted: a direct conversation, by its contact, or a
+-- group. muted_until is NULL for mutes that last until the user unmutes;
+-- expired rows are ignored and replaced by the next mute.
+CREATE TABLE conversation_mutes (
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    contact_id UUID REFERENCES users(id) ON DELETE CASCADE,
+    group_id UUID REFERENCES groups(id) ON DELETE CASCADE,
+    muted_until TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    CHECK ((contact_id IS NULL) <> (group_id IS NULL))
+);
+
+CREATE UNIQUE INDEX idx_conversation_mutes_contact ON conversation_mutes(user_id, contact_id) WHERE contact_id IS NOT NULL;
+CREATE UNIQUE INDEX idx_conversation_mutes_group ON conversation_mutes(user_id, group_id) WHERE group_id IS NOT NULL;
+
+-- +goose Down
+DROP TABLE conversation_mutes;
@@ -0,0 +1,20 @@
+-- +goose Up
+-- Each user's notification inbox. actor_id is who caused the notification
+-- and is cleared when that user is deleted; data holds type-specific fields
+-- such as group_id or call_id. read_at is NULL until the user reads it.
+CREATE TABLE notifications (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    type TEXT NOT NULL,
+    actor_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    content TEXT NOT NULL,
+    data JSONB NOT NULL DEFAULT '{}',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    read_at TIMESTAMPTZ
+);
+
+CREATE INDEX idx_notifications_user ON notifications(user_id, created_at DESC, id DESC);
+CREATE INDEX idx_notifications_unread ON notifications(user_id) WHERE read_at IS NULL;
+
+-- +goose Down
+DROP TABLE notifications;
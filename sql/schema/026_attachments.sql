@@ -0,0 +1,23 @@
+-- +goose Up
+-- Attachments encrypted by their sender before upload. The blob is stored
+-- as uploaded; content_type and plaintext_size are what the sender declared
+-- and key_envelope is the opaque key material recipients decrypt it with.
+-- Each attachment belongs to one conversation: a direct one, by its
+-- recipient, or a group.
+CREATE TABLE attachments (
+    id UUID PRIMARY KEY,
+    owner_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    recipient_id UUID REFERENCES users(id) ON DELETE CASCADE,
+    group_id UUID REFERENCES groups(id) ON DELETE CASCADE,
+    content_type TEXT NOT NULL,
+    plaintext_size BIGINT NOT NULL,
+    blob_size BIGINT NOT NULL,
+    key_envelope TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    CHECK ((recipient_id IS NULL) <> (group_id IS NULL))
+);
+
+CREATE INDEX idx_attachments_owner ON attachments(owner_id);
+
+-- +goose Down
+DROP TABLE attachments;
@@ -0,0 +1,23 @@
+-- +goose Up
+-- Audit trail of support staff signing in as users. Each row is one
+-- impersonation session: who started it, for whom, why, and until when.
+-- ended_at is set when the admin ends it or it expires, and notified_at
+-- once the user has been told about it.
+CREATE TABLE impersonations (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    admin_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    admin_username TEXT NOT NULL,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    reason TEXT NOT NULL,
+    session_id TEXT NOT NULL UNIQUE,
+    started_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMPTZ NOT NULL,
+    ended_at TIMESTAMPTZ,
+    notified_at TIMESTAMPTZ
+);
+
+CREATE INDEX idx_impersonations_user ON impersonations(user_id, started_at DESC);
+CREATE INDEX idx_impersonations_open ON impersonations(expires_at) WHERE ended_at IS NULL;
+
+-- +goose Down
+DROP TABLE impersonations;
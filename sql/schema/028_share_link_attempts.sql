@@ -0,0 +1,7 @@
+-- +goose Up
+-- Wrong passcodes entered for a share link. The link is revoked once they
+-- reach the limit, so a passcode cannot be guessed from many addresses.
+ALTER TABLE share_links ADD COLUMN failed_attempts INT NOT NULL DEFAULT 0;
+
+-- +goose Down
+ALTER TABLE share_links DROP COLUMN failed_attempts;
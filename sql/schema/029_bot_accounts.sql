@@ -0,0 +1,9 @@
+-- +goose Up
+-- Bot accounts have the role 'bot', so the bot engine never takes over a
+-- person's account that happens to have a bot's username. Accounts the
+-- engine created so far are recognised by the icon it gave them.
+UPDATE users SET role = 'bot'
+WHERE username IN ('echobot', 'remindbot') AND icon = 'solid-signal' AND custom_icon = '';
+
+-- +goose Down
+UPDATE users SET role = 'member' WHERE role = 'bot';
@@ -0,0 +1,106 @@
+package clients
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// inbox buffers received items so a script can wait for the one it expects
+// while items it has not asked about yet stay available to later expectations
+type inbox[T any] struct {
+	mu      sync.Mutex
+	items   []T
+	arrived chan struct{}
+	closed  chan struct{}
+	err     error
+}
+
+func newInbox[T any]() *inbox[T] {
+	return &inbox[T]{
+		arrived: make(chan struct{}, 1),
+		closed:  make(chan struct{}),
+	}
+}
+
+func (in *inbox[T]) push(item T) {
+	in.mu.Lock()
+	in.items = append(in.items, item)
+	in.mu.Unlock()
+
+	select {
+	case in.arrived <- struct{}{}:
+	default:
+	}
+}
+
+// close stops the inbox; expectations still see items received before err
+func (in *inbox[T]) close(err error) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	select {
+	case <-in.closed:
+		return
+	default:
+	}
+	in.err = err
+	close(in.closed)
+}
+
+// take removes and returns the first buffered item matching match
+func (in *inbox[T]) take(match func(T) bool) (T, bool) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	for i, item := range in.items {
+		if match(item) {
+			in.items = append(in.items[:i], in.items[i+1:]...)
+			return item, true
+		}
+	}
+
+	var zero T
+	return zero, false
+}
+
+// expect waits up to timeout for an item matching match
+func (in *inbox[T]) expect(match func(T) bool, timeout time.Duration) (T, error) {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	for {
+		if item, ok := in.take(match); ok {
+			return item, nil
+		}
+
+		select {
+		case <-in.arrived:
+		case <-in.closed:
+			// One last look at items that arrived before the close
+			if item, ok := in.take(match); ok {
+				return item, nil
+			}
+			var zero T
+			return zero, fmt.Errorf("connection closed before expected message arrived: %v (%d unmatched)", in.err, in.pending())
+		case <-deadline.C:
+			var zero T
+			return zero, fmt.Errorf("no matching message within %s (%d unmatched)", timeout, in.pending())
+		}
+	}
+}
+
+// expectNone returns an error if an item matching match arrives within d
+func (in *inbox[T]) expectNone(match func(T) bool, d time.Duration) error {
+	item, err := in.expect(match, d)
+	if err != nil {
+		return nil
+	}
+	return fmt.Errorf("unexpected message: %+v", item)
+}
+
+func (in *inbox[T]) pending() int {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	return len(in.items)
+}
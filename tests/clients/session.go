@@ -0,0 +1,245 @@
+package clients
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Session is a user logged in to a running server
+type Session struct {
+	BaseURL   string // e.g. http://127.0.0.1:41234
+	Username  string
+	SessionID string
+	CSRFToken string
+
+	client *http.Client
+}
+
+// Register creates an account and logs it in
+func Register(ctx context.Context, baseURL, username, password string) (*Session, error) {
+	if err := CreateAccount(ctx, baseURL, username, password); err != nil {
+		return nil, err
+	}
+	return Login(ctx, baseURL, username, password)
+}
+
+// CreateAccount creates an account without logging it in
+func CreateAccount(ctx context.Context, baseURL, username, password string) error {
+	form := url.Values{}
+	form.Set("username", username)
+	form.Set("password", password)
+	form.Set("confirm_password", password)
+
+	s := newSession(baseURL, username)
+
+	resp, err := s.Do(ctx, http.MethodPost, "/register", form)
+	if err != nil {
+		return err
+	}
+	if err := checkStatus(resp); err != nil {
+		return fmt.Errorf("register %s: %w", username, err)
+	}
+	return nil
+}
+
+// Login starts a session and fetches its CSRF token
+func Login(ctx context.Context, baseURL, username, password string) (*Session, error) {
+	form := url.Values{}
+	form.Set("username", username)
+	form.Set("password", password)
+
+	s := newSession(baseURL, username)
+
+	resp, err := s.Do(ctx, http.MethodPost, "/login", form)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkStatus(resp); err != nil {
+		return nil, fmt.Errorf("login %s: %w", username, err)
+	}
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == "session_id" {
+			s.SessionID = cookie.Value
+		}
+	}
+	if s.SessionID == "" {
+		return nil, fmt.Errorf("login %s: no session cookie", username)
+	}
+
+	// Authenticated pages hand out the CSRF token
+	resp, err = s.Do(ctx, http.MethodGet, "/dashboard", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == "csrf_token" {
+			s.CSRFToken = cookie.Value
+		}
+	}
+	if s.CSRFToken == "" {
+		s.CSRFToken = resp.Header.Get("X-CSRF-Token")
+	}
+
+	return s, nil
+}
+
+func newSession(baseURL, username string) *Session {
+	return &Session{
+		BaseURL:  strings.TrimSuffix(baseURL, "/"),
+		Username: username,
+		client: &http.Client{
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+// Post sends a form as this user. The caller closes the response body.
+func (s *Session) Post(ctx context.Context, path string, form url.Values) (*http.Response, error) {
+	return s.Do(ctx, http.MethodPost, path, form)
+}
+
+// PostOK sends a form and returns an error unless the response is 2xx
+func (s *Session) PostOK(ctx context.Context, path string, form url.Values) error {
+	resp, err := s.Post(ctx, path, form)
+	if err != nil {
+		return err
+	}
+	if err := checkStatus(resp); err != nil {
+		return fmt.Errorf("POST %s as %s: %w", path, s.Username, err)
+	}
+	return nil
+}
+
+// DoOK sends a request and returns an error unless the response is 2xx
+func (s *Session) DoOK(ctx context.Context, method, path string, form url.Values) error {
+	resp, err := s.Do(ctx, method, path, form)
+	if err != nil {
+		return err
+	}
+	if err := checkStatus(resp); err != nil {
+		return fmt.Errorf("%s %s as %s: %w", method, path, s.Username, err)
+	}
+	return nil
+}
+
+// PostJSON sends a form and decodes the JSON response into v
+func (s *Session) PostJSON(ctx context.Context, path string, form url.Values, v any) error {
+	resp, err := s.Post(ctx, path, form)
+	if err != nil {
+		return err
+	}
+	return decode(resp, v, "POST "+path+" as "+s.Username)
+}
+
+// GetJSON fetches path as this user and decodes the JSON response into v
+func (s *Session) GetJSON(ctx context.Context, path string, v any) error {
+	resp, err := s.Do(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return err
+	}
+	return decode(resp, v, "GET "+path+" as "+s.Username)
+}
+
+// DialWS opens the user's chat WebSocket
+func (s *Session) DialWS(ctx context.Context) (*WSClient, error) {
+	return DialWS(ctx, "ws"+strings.TrimPrefix(s.BaseURL, "http")+"/ws/chat", s.Username, s.SessionID)
+}
+
+// DialSSE opens an event stream at path as this user
+func (s *Session) DialSSE(ctx context.Context, path string) (*SSEClient, error) {
+	return DialSSE(ctx, s.BaseURL+path, s.Username, s.SessionID)
+}
+
+// Do sends a request as this user, with a form body when form is non-nil.
+// The caller closes the response body.
+func (s *Session) Do(ctx context.Context, method, path string, form url.Values) (*http.Response, error) {
+	var body io.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, s.BaseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	return s.send(req)
+}
+
+// SendJSON sends v as a JSON body as this user. The caller closes the
+// response body.
+func (s *Session) SendJSON(ctx context.Context, method, path string, v any) (*http.Response, error) {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, s.BaseURL+path, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	return s.send(req)
+}
+
+// send adds the user's session and CSRF token to req and sends it
+func (s *Session) send(req *http.Request) (*http.Response, error) {
+	if s.SessionID != "" {
+		req.AddCookie(&http.Cookie{Name: "session_id", Value: s.SessionID})
+	}
+	if s.CSRFToken != "" {
+		req.Header.Set("X-CSRF-Token", s.CSRFToken)
+		req.AddCookie(&http.Cookie{Name: "csrf_token", Value: s.CSRFToken})
+	}
+
+	return s.client.Do(req)
+}
+
+// StatusError is returned for responses outside 2xx
+type StatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("status %d: %s", e.StatusCode, e.Body)
+}
+
+func checkStatus(resp *http.Response) error {
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return &StatusError{StatusCode: resp.StatusCode, Body: string(body)}
+	}
+
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+func decode(resp *http.Response, v any, what string) error {
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("%s: %w", what, &StatusError{StatusCode: resp.StatusCode, Body: string(body)})
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("%s: decode response: %w", what, err)
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package clients
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Event is one Server-Sent Event
+type Event struct {
+	ID    string
+	Event string // "message" when the stream names no event
+	Data  string
+}
+
+// EventPredicate selects the event a script is waiting for
+type EventPredicate func(ev Event) bool
+
+// EventNamed matches events of the given name
+func EventNamed(name string) EventPredicate {
+	return func(ev Event) bool { return ev.Event == name }
+}
+
+// DataContains matches events whose data contains s
+func DataContains(s string) EventPredicate {
+	return func(ev Event) bool { return strings.Contains(ev.Data, s) }
+}
+
+// SSEClient is a scripted text/event-stream subscription of one user
+type SSEClient struct {
+	Username string
+
+	cancel context.CancelFunc
+	inbox  *inbox[Event]
+}
+
+// DialSSE opens an event stream with the given session
+func DialSSE(ctx context.Context, url, username, sessionID string) (*SSEClient, error) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	req.AddCookie(&http.Cookie{Name: "session_id", Value: sessionID})
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("event stream request as %s failed: %w", username, err)
+	}
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		resp.Body.Close()
+		cancel()
+		return nil, fmt.Errorf("event stream as %s: unexpected response %d %s", username, resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	c := &SSEClient{
+		Username: username,
+		cancel:   cancel,
+		inbox:    newInbox[Event](),
+	}
+
+	go c.read(resp)
+
+	return c, nil
+}
+
+func (c *SSEClient) read(resp *http.Response) {
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var ev Event
+	var data []string
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if line == "" {
+			// A blank line dispatches the event
+			if len(data) > 0 {
+				ev.Data = strings.Join(data, "\n")
+				if ev.Event == "" {
+					ev.Event = "message"
+				}
+				c.inbox.push(ev)
+			}
+			ev, data = Event{}, nil
+			continue
+		}
+		if strings.HasPrefix(line, ":") {
+			continue // comment, used as keep-alive
+		}
+
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "event":
+			ev.Event = value
+		case "data":
+			data = append(data, value)
+		case "id":
+			ev.ID = value
+		}
+	}
+
+	c.inbox.close(scanner.Err())
+}
+
+// Expect waits up to timeout for an event matching pred and consumes it
+func (c *SSEClient) Expect(pred EventPredicate, timeout time.Duration) (Event, error) {
+	ev, err := c.inbox.expect(pred, timeout)
+	if err != nil {
+		return Event{}, fmt.Errorf("%s: %w", c.Username, err)
+	}
+	return ev, nil
+}
+
+// ExpectNone returns an error if an event matching pred arrives within d
+func (c *SSEClient) ExpectNone(pred EventPredicate, d time.Duration) error {
+	if err := c.inbox.expectNone(pred, d); err != nil {
+		return fmt.Errorf("%s: %w", c.Username, err)
+	}
+	return nil
+}
+
+// Close ends the stream
+func (c *SSEClient) Close() {
+	c.cancel()
+}
@@ -0,0 +1,142 @@
+package clients
+
+import (
+	"context"
+	"exc6/server/websocket"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	fastws "github.com/fasthttp/websocket"
+)
+
+// Origin is sent with WebSocket handshakes; the server only accepts known origins
+const Origin = "http://localhost:3000"
+
+// Predicate selects the WebSocket message a script is waiting for
+type Predicate func(msg *websocket.Message) bool
+
+// OfType matches messages of the given type
+func OfType(t websocket.MessageType) Predicate {
+	return func(msg *websocket.Message) bool { return msg.Type == t }
+}
+
+// From matches messages sent by username
+func From(username string) Predicate {
+	return func(msg *websocket.Message) bool { return msg.From == username }
+}
+
+// InGroup matches messages sent to a group
+func InGroup(groupID string) Predicate {
+	return func(msg *websocket.Message) bool { return msg.GroupID == groupID }
+}
+
+// WithID matches messages with the given ID
+func WithID(id string) Predicate {
+	return func(msg *websocket.Message) bool { return msg.ID == id }
+}
+
+// WithContent matches messages with exactly this content
+func WithContent(content string) Predicate {
+	return func(msg *websocket.Message) bool { return msg.Content == content }
+}
+
+// All matches messages every predicate matches
+func All(preds ...Predicate) Predicate {
+	return func(msg *websocket.Message) bool {
+		for _, pred := range preds {
+			if !pred(msg) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// WSClient is a scripted WebSocket connection of one user. Server pings are
+// answered automatically and never returned by Expect.
+type WSClient struct {
+	Username string
+
+	conn    *fastws.Conn
+	writeMu sync.Mutex
+	inbox   *inbox[*websocket.Message]
+}
+
+// DialWS connects to the chat WebSocket endpoint with the given session
+func DialWS(ctx context.Context, url, username, sessionID string) (*WSClient, error) {
+	header := http.Header{}
+	header.Add("Cookie", "session_id="+sessionID)
+	header.Add("Origin", Origin)
+
+	dialer := fastws.Dialer{HandshakeTimeout: 10 * time.Second}
+
+	conn, _, err := dialer.DialContext(ctx, url, header)
+	if err != nil {
+		return nil, fmt.Errorf("websocket dial as %s failed: %w", username, err)
+	}
+
+	c := &WSClient{
+		Username: username,
+		conn:     conn,
+		inbox:    newInbox[*websocket.Message](),
+	}
+
+	go c.read()
+
+	return c, nil
+}
+
+func (c *WSClient) read() {
+	for {
+		var msg websocket.Message
+		if err := c.conn.ReadJSON(&msg); err != nil {
+			c.inbox.close(err)
+			return
+		}
+
+		if msg.Type == websocket.MessageTypePing {
+			c.Send(&websocket.Message{Type: websocket.MessageTypePong})
+			continue
+		}
+
+		c.inbox.push(&msg)
+	}
+}
+
+// Send writes a typed message; the server fills in From and Timestamp
+func (c *WSClient) Send(msg *websocket.Message) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	c.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	return c.conn.WriteJSON(msg)
+}
+
+// Expect waits up to timeout for a message matching pred and consumes it.
+// Messages that do not match stay buffered for later expectations.
+func (c *WSClient) Expect(pred Predicate, timeout time.Duration) (*websocket.Message, error) {
+	msg, err := c.inbox.expect(pred, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", c.Username, err)
+	}
+	return msg, nil
+}
+
+// ExpectNone returns an error if a message matching pred arrives within d
+func (c *WSClient) ExpectNone(pred Predicate, d time.Duration) error {
+	if err := c.inbox.expectNone(pred, d); err != nil {
+		return fmt.Errorf("%s: %w", c.Username, err)
+	}
+	return nil
+}
+
+// Close closes the connection
+func (c *WSClient) Close() error {
+	c.writeMu.Lock()
+	c.conn.WriteMessage(fastws.CloseMessage, fastws.FormatCloseMessage(fastws.CloseNormalClosure, ""))
+	c.writeMu.Unlock()
+
+	return c.conn.Close()
+}
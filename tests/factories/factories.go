@@ -0,0 +1,127 @@
+// Package factories builds users, friendships, groups and messages for tests.
+// IDs are derived from names and timestamps count up from Epoch, so the same
+// calls always build the same data and rendered output can be compared
+// against golden files.
+package factories
+
+import (
+	"database/sql"
+	"exc6/db"
+	"exc6/services/chat"
+	"exc6/services/friends"
+	"exc6/services/groups"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Epoch is when the first record a Factory builds was created. It is midday
+// UTC long ago, so timestamps render as the same date in any time zone.
+var Epoch = time.Date(2024, time.March, 5, 12, 0, 0, 0, time.UTC)
+
+// namespace scopes the IDs derived from names
+var namespace = uuid.MustParse("6f1c2a4e-3b0d-4e8a-9c57-2d41f0b8e913")
+
+// Factory builds records a minute apart from Epoch. It is not safe for
+// concurrent use.
+type Factory struct {
+	n int
+}
+
+// New returns a factory whose first record is created at Epoch
+func New() *Factory {
+	return &Factory{}
+}
+
+// ID derives a stable ID from a kind of record and its name
+func ID(kind, name string) uuid.UUID {
+	return uuid.NewSHA1(namespace, []byte(kind+":"+name))
+}
+
+// next returns the creation time of the next record
+func (f *Factory) next() time.Time {
+	t := Epoch.Add(time.Duration(f.n) * time.Minute)
+	f.n++
+	return t
+}
+
+// User builds a user with the default icon
+func (f *Factory) User(username string) db.User {
+	created := f.next()
+	return db.User{
+		ID:        ID("user", username),
+		CreatedAt: created,
+		UpdatedAt: created,
+		Username:  username,
+		Role:      "member",
+		Icon:      sql.NullString{String: "gradient-blue", Valid: true},
+	}
+}
+
+// Friendship builds the row recording that user befriended friend
+func (f *Factory) Friendship(user, friend db.User, accepted bool) db.Friend {
+	return db.Friend{
+		ID:        ID("friendship", user.Username+"/"+friend.Username),
+		UserID:    uuid.NullUUID{UUID: user.ID, Valid: true},
+		FriendID:  uuid.NullUUID{UUID: friend.ID, Valid: true},
+		CreatedAt: f.next(),
+		Accepted:  accepted,
+	}
+}
+
+// Friend builds friend as listed among a user's accepted friends
+func (f *Factory) Friend(friend db.User) friends.FriendInfo {
+	return friends.FriendInfo{
+		FriendID:   friend.ID.String(),
+		Username:   friend.Username,
+		Icon:       friend.Icon.String,
+		CustomIcon: friend.CustomIcon.String,
+		Accepted:   true,
+		CreatedAt:  f.next(),
+	}
+}
+
+// Group builds a group as seen by a member with role
+func (f *Factory) Group(name, createdBy, role string, members int) *groups.GroupInfo {
+	return &groups.GroupInfo{
+		ID:          ID("group", name).String(),
+		Name:        name,
+		Icon:        "gradient-purple",
+		CreatedBy:   createdBy,
+		MemberCount: members,
+		UserRole:    role,
+		CreatedAt:   f.next(),
+		Kind:        groups.KindGroup,
+	}
+}
+
+// Message builds a direct message from one user to another
+func (f *Factory) Message(from, to, content string) *chat.ChatMessage {
+	sent := f.next()
+	return &chat.ChatMessage{
+		MessageID: messageID(sent),
+		FromID:    from,
+		ToID:      to,
+		Content:   content,
+		Timestamp: sent.Unix(),
+	}
+}
+
+// GroupMessage builds a message sent to a group
+func (f *Factory) GroupMessage(from string, group *groups.GroupInfo, content string) *chat.ChatMessage {
+	sent := f.next()
+	return &chat.ChatMessage{
+		MessageID: messageID(sent),
+		FromID:    from,
+		GroupID:   group.ID,
+		Content:   content,
+		Timestamp: sent.Unix(),
+		IsGroup:   true,
+	}
+}
+
+// messageID names a message by when it was sent
+func messageID(sent time.Time) string {
+	return fmt.Sprintf("msg-%d", sent.Unix())
+}
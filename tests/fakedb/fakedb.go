@@ -0,0 +1,281 @@
+// Package fakedb is a database/sql driver for unit tests. It answers sqlc
+// queries by their name ("-- name: GetSession :one") with whatever the test
+// registered for them, so services can be tested against *db.Queries without
+// Postgres. Queries nobody registered return no rows and affect none, which
+// is how a lookup of something that does not exist looks to the caller.
+package fakedb
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"exc6/db"
+	"io"
+	"regexp"
+	"sync"
+	"testing"
+)
+
+// Result answers one query. Rows are returned to queries, one value per
+// column; statements report Affected rows, or len(Rows) when it is zero.
+type Result struct {
+	Rows     [][]any
+	Affected int64
+}
+
+// Handler answers a query given its arguments, as the driver sees them:
+// UUIDs and other Valuers arrive as what their Value method returns
+type Handler func(args []any) (Result, error)
+
+// Row returns a Result of one row
+func Row(values ...any) Result {
+	return Result{Rows: [][]any{values}}
+}
+
+// Affected returns a Result for a statement that changed n rows
+func Affected(n int64) Result {
+	return Result{Affected: n}
+}
+
+// Fake is an in-memory database answering queries with handlers
+type Fake struct {
+	sqlDB *sql.DB
+
+	mu        sync.Mutex
+	handlers  map[string]Handler
+	calls     map[string][][]any
+	commits   int
+	rollbacks int
+}
+
+// New returns a fake closed when the test ends
+func New(t testing.TB) *Fake {
+	f := &Fake{
+		handlers: make(map[string]Handler),
+		calls:    make(map[string][][]any),
+	}
+	f.sqlDB = sql.OpenDB(connector{f})
+	t.Cleanup(func() { f.sqlDB.Close() })
+	return f
+}
+
+// DB returns the fake as a *sql.DB, for services running transactions
+func (f *Fake) DB() *sql.DB {
+	return f.sqlDB
+}
+
+// Queries returns the queries of the fake
+func (f *Fake) Queries() *db.Queries {
+	return db.New(f.sqlDB)
+}
+
+// On answers the named query with h, replacing any earlier handler
+func (f *Fake) On(name string, h Handler) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.handlers[name] = h
+}
+
+// Return answers the named query with the same result every time
+func (f *Fake) Return(name string, result Result) {
+	f.On(name, func([]any) (Result, error) { return result, nil })
+}
+
+// Fail answers the named query with err
+func (f *Fake) Fail(name string, err error) {
+	f.On(name, func([]any) (Result, error) { return Result{}, err })
+}
+
+// Calls returns the arguments of each run of the named query so far
+func (f *Fake) Calls(name string) [][]any {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([][]any(nil), f.calls[name]...)
+}
+
+// Commits returns how many transactions were committed
+func (f *Fake) Commits() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.commits
+}
+
+// Rollbacks returns how many transactions were rolled back, including those
+// rolled back after their commit, which database/sql ignores
+func (f *Fake) Rollbacks() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.rollbacks
+}
+
+var queryName = regexp.MustCompile(`^\s*-- name: (\w+)`)
+
+// run records a query and returns its handler's answer
+func (f *Fake) run(query string, args []driver.NamedValue) (Result, error) {
+	name := query
+	if m := queryName.FindStringSubmatch(query); m != nil {
+		name = m[1]
+	}
+
+	values := make([]any, len(args))
+	for i, arg := range args {
+		values[i] = arg.Value
+	}
+
+	f.mu.Lock()
+	f.calls[name] = append(f.calls[name], values)
+	h := f.handlers[name]
+	f.mu.Unlock()
+
+	if h == nil {
+		return Result{}, nil
+	}
+	return h(values)
+}
+
+type connector struct {
+	f *Fake
+}
+
+func (c connector) Connect(context.Context) (driver.Conn, error) {
+	return &conn{f: c.f}, nil
+}
+
+func (c connector) Driver() driver.Driver {
+	return fakeDriver{}
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(string) (driver.Conn, error) {
+	return nil, driver.ErrSkip
+}
+
+type conn struct {
+	f *Fake
+}
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	return &stmt{c: c, query: query}, nil
+}
+
+func (c *conn) Close() error {
+	return nil
+}
+
+func (c *conn) Begin() (driver.Tx, error) {
+	return &tx{f: c.f}, nil
+}
+
+func (c *conn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	result, err := c.f.run(query, args)
+	if err != nil {
+		return nil, err
+	}
+	return newRows(result.Rows)
+}
+
+func (c *conn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	result, err := c.f.run(query, args)
+	if err != nil {
+		return nil, err
+	}
+	if result.Affected == 0 {
+		result.Affected = int64(len(result.Rows))
+	}
+	return driver.RowsAffected(result.Affected), nil
+}
+
+// CheckNamedValue lets any Valuer through as what it returns and everything
+// else as the driver's default conversion makes it
+func (c *conn) CheckNamedValue(nv *driver.NamedValue) error {
+	v, err := driver.DefaultParameterConverter.ConvertValue(nv.Value)
+	if err != nil {
+		return err
+	}
+	nv.Value = v
+	return nil
+}
+
+type stmt struct {
+	c     *conn
+	query string
+}
+
+func (s *stmt) Close() error  { return nil }
+func (s *stmt) NumInput() int { return -1 }
+
+func (s *stmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.c.ExecContext(context.Background(), s.query, named(args))
+}
+
+func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.c.QueryContext(context.Background(), s.query, named(args))
+}
+
+func named(args []driver.Value) []driver.NamedValue {
+	nv := make([]driver.NamedValue, len(args))
+	for i, v := range args {
+		nv[i] = driver.NamedValue{Ordinal: i + 1, Value: v}
+	}
+	return nv
+}
+
+type tx struct {
+	f *Fake
+}
+
+func (t *tx) Commit() error {
+	t.f.mu.Lock()
+	t.f.commits++
+	t.f.mu.Unlock()
+	return nil
+}
+
+func (t *tx) Rollback() error {
+	t.f.mu.Lock()
+	t.f.rollbacks++
+	t.f.mu.Unlock()
+	return nil
+}
+
+type rows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+// newRows converts the values of a handler's rows as query arguments are
+// converted, so UUIDs and nullable types scan back into themselves
+func newRows(in [][]any) (*rows, error) {
+	r := &rows{}
+	for _, row := range in {
+		if r.columns == nil {
+			r.columns = make([]string, len(row))
+			for i := range row {
+				r.columns[i] = "c" + string(rune('a'+i%26))
+			}
+		}
+		values := make([]driver.Value, len(row))
+		for i, v := range row {
+			cv, err := driver.DefaultParameterConverter.ConvertValue(v)
+			if err != nil {
+				return nil, err
+			}
+			values[i] = cv
+		}
+		r.values = append(r.values, values)
+	}
+	return r, nil
+}
+
+func (r *rows) Columns() []string { return r.columns }
+func (r *rows) Close() error      { return nil }
+
+func (r *rows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
@@ -0,0 +1,967 @@
+package fakeredis
+
+import (
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// command runs with srv.mu held, given the arguments after its name.
+// arity counts the name too: n means exactly n arguments, -n at least n.
+type command struct {
+	arity int
+	run   func(s *Server, args []string) any
+}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"PING":   {-1, cmdPing},
+		"ECHO":   {2, func(s *Server, args []string) any { return args[0] }},
+		"SELECT": {2, func(s *Server, args []string) any { return simple("OK") }},
+		"CLIENT": {-2, func(s *Server, args []string) any { return simple("OK") }},
+
+		"DEL":     {-2, cmdDel},
+		"UNLINK":  {-2, cmdDel},
+		"EXISTS":  {-2, cmdExists},
+		"EXPIRE":  {3, cmdExpire(time.Second)},
+		"PEXPIRE": {3, cmdExpire(time.Millisecond)},
+		"TTL":     {2, cmdTTL(time.Second)},
+		"PTTL":    {2, cmdTTL(time.Millisecond)},
+		"PERSIST": {2, cmdPersist},
+		"KEYS":    {2, cmdKeys},
+
+		"GET":    {2, cmdGet},
+		"SET":    {-3, cmdSet},
+		"SETNX":  {3, cmdSetNX},
+		"SETEX":  {4, cmdSetEX},
+		"MGET":   {-2, cmdMGet},
+		"INCR":   {2, func(s *Server, args []string) any { return incrBy(s, args[0], 1) }},
+		"DECR":   {2, func(s *Server, args []string) any { return incrBy(s, args[0], -1) }},
+		"INCRBY": {3, cmdIncrBy},
+
+		"HSET":         {-4, cmdHSet},
+		"HSETNX":       {4, cmdHSetNX},
+		"HGET":         {3, cmdHGet},
+		"HMGET":        {-3, cmdHMGet},
+		"HGETALL":      {2, cmdHGetAll},
+		"HDEL":         {-3, cmdHDel},
+		"HLEN":         {2, cmdHLen},
+		"HINCRBY":      {4, cmdHIncrBy},
+		"HINCRBYFLOAT": {4, cmdHIncrByFloat},
+
+		"SADD":      {-3, cmdSAdd},
+		"SREM":      {-3, cmdSRem},
+		"SMEMBERS":  {2, cmdSMembers},
+		"SCARD":     {2, cmdSCard},
+		"SISMEMBER": {3, cmdSIsMember},
+
+		"ZADD":             {-4, cmdZAdd},
+		"ZREM":             {-3, cmdZRem},
+		"ZCARD":            {2, cmdZCard},
+		"ZSCORE":           {3, cmdZScore},
+		"ZMSCORE":          {-3, cmdZMScore},
+		"ZRANGE":           {-4, cmdZRange(false)},
+		"ZREVRANGE":        {-4, cmdZRange(true)},
+		"ZRANGEBYSCORE":    {-4, cmdZRangeByScore(false)},
+		"ZREVRANGEBYSCORE": {-4, cmdZRangeByScore(true)},
+		"ZREMRANGEBYRANK":  {4, cmdZRemRangeByRank},
+		"ZREMRANGEBYSCORE": {4, cmdZRemRangeByScore},
+
+		"LPUSH":  {-3, cmdPush(true)},
+		"RPUSH":  {-3, cmdPush(false)},
+		"LRANGE": {4, cmdLRange},
+		"LTRIM":  {4, cmdLTrim},
+		"LLEN":   {2, cmdLLen},
+
+		"PUBLISH": {3, func(s *Server, args []string) any { return s.publish(args[0], args[1]) }},
+	}
+}
+
+var (
+	errWrongType = errReply("WRONGTYPE Operation against a key holding the wrong kind of value")
+	errNotInt    = errReply("ERR value is not an integer or out of range")
+	errNotFloat  = errReply("ERR value is not a valid float")
+	errSyntax    = errReply("ERR syntax error")
+)
+
+func cmdPing(s *Server, args []string) any {
+	if len(args) > 0 {
+		return args[0]
+	}
+	return simple("PONG")
+}
+
+func cmdDel(s *Server, args []string) any {
+	var n int64
+	for _, key := range args {
+		if s.lookup(key) != nil {
+			s.remove(key)
+			n++
+		}
+	}
+	return n
+}
+
+func cmdExists(s *Server, args []string) any {
+	var n int64
+	for _, key := range args {
+		if s.lookup(key) != nil {
+			n++
+		}
+	}
+	return n
+}
+
+func cmdExpire(unit time.Duration) func(s *Server, args []string) any {
+	return func(s *Server, args []string) any {
+		n, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return errNotInt
+		}
+		e := s.lookup(args[0])
+		if e == nil {
+			return int64(0)
+		}
+		if n <= 0 {
+			s.remove(args[0])
+			return int64(1)
+		}
+		e.expires = s.now().Add(time.Duration(n) * unit)
+		s.touch(args[0])
+		return int64(1)
+	}
+}
+
+func cmdTTL(unit time.Duration) func(s *Server, args []string) any {
+	return func(s *Server, args []string) any {
+		e := s.lookup(args[0])
+		switch {
+		case e == nil:
+			return int64(-2)
+		case e.expires.IsZero():
+			return int64(-1)
+		}
+		left := e.expires.Sub(s.now())
+		return int64((left + unit - 1) / unit)
+	}
+}
+
+func cmdPersist(s *Server, args []string) any {
+	e := s.lookup(args[0])
+	if e == nil || e.expires.IsZero() {
+		return int64(0)
+	}
+	e.expires = time.Time{}
+	s.touch(args[0])
+	return int64(1)
+}
+
+func cmdKeys(s *Server, args []string) any {
+	var keys []string
+	for key := range s.keys {
+		if s.lookup(key) == nil {
+			continue
+		}
+		if pathMatch(args[0], key) {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// pathMatch matches key against a glob pattern supporting * and ?
+func pathMatch(pattern, key string) bool {
+	if pattern == "" {
+		return key == ""
+	}
+	switch pattern[0] {
+	case '*':
+		for i := 0; i <= len(key); i++ {
+			if pathMatch(pattern[1:], key[i:]) {
+				return true
+			}
+		}
+		return false
+	case '?':
+		return key != "" && pathMatch(pattern[1:], key[1:])
+	default:
+		return key != "" && key[0] == pattern[0] && pathMatch(pattern[1:], key[1:])
+	}
+}
+
+// stringAt returns the string at key, whether there is one, and a reply to
+// send instead if key holds another type
+func stringAt(s *Server, key string) (string, bool, any) {
+	e := s.lookup(key)
+	if e == nil {
+		return "", false, nil
+	}
+	value, ok := e.value.(string)
+	if !ok {
+		return "", false, errWrongType
+	}
+	return value, true, nil
+}
+
+func cmdGet(s *Server, args []string) any {
+	value, ok, fail := stringAt(s, args[0])
+	if fail != nil {
+		return fail
+	}
+	if !ok {
+		return nil
+	}
+	return value
+}
+
+func cmdSet(s *Server, args []string) any {
+	key, value := args[0], args[1]
+	var ttl time.Duration
+	var nx, xx, keepTTL, get bool
+	for i := 2; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "KEEPTTL":
+			keepTTL = true
+		case "GET":
+			get = true
+		case "EX", "PX":
+			if i+1 == len(args) {
+				return errSyntax
+			}
+			n, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil || n <= 0 {
+				return errReply("ERR invalid expire time in 'set' command")
+			}
+			unit := time.Second
+			if strings.ToUpper(args[i]) == "PX" {
+				unit = time.Millisecond
+			}
+			ttl = time.Duration(n) * unit
+			i++
+		default:
+			return errSyntax
+		}
+	}
+
+	old := s.lookup(key)
+	var previous any
+	if get && old != nil {
+		value, ok := old.value.(string)
+		if !ok {
+			return errWrongType
+		}
+		previous = value
+	}
+	if nx && old != nil || xx && old == nil {
+		if get {
+			return previous
+		}
+		return nil
+	}
+
+	e := &entry{value: value}
+	if ttl > 0 {
+		e.expires = s.now().Add(ttl)
+	} else if keepTTL && old != nil {
+		e.expires = old.expires
+	}
+	s.put(key, e)
+
+	if get {
+		return previous
+	}
+	return simple("OK")
+}
+
+func cmdSetNX(s *Server, args []string) any {
+	if s.lookup(args[0]) != nil {
+		return int64(0)
+	}
+	s.put(args[0], &entry{value: args[1]})
+	return int64(1)
+}
+
+func cmdSetEX(s *Server, args []string) any {
+	return cmdSet(s, []string{args[0], args[2], "EX", args[1]})
+}
+
+func cmdMGet(s *Server, args []string) any {
+	values := make([]any, len(args))
+	for i, key := range args {
+		if value, ok, _ := stringAt(s, key); ok {
+			values[i] = value
+		}
+	}
+	return values
+}
+
+func cmdIncrBy(s *Server, args []string) any {
+	by, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return errNotInt
+	}
+	return incrBy(s, args[0], by)
+}
+
+func incrBy(s *Server, key string, by int64) any {
+	value, ok, fail := stringAt(s, key)
+	if fail != nil {
+		return fail
+	}
+	var n int64
+	if ok {
+		var err error
+		if n, err = strconv.ParseInt(value, 10, 64); err != nil {
+			return errNotInt
+		}
+	}
+	n += by
+
+	e := s.lookup(key)
+	if e == nil {
+		e = &entry{}
+	}
+	e.value = strconv.FormatInt(n, 10)
+	s.put(key, e)
+	return n
+}
+
+// container returns the value of type T at key, creating it if create is
+// set, and a reply to send instead if key holds another type
+func container[T hash | set | zset | list](s *Server, key string, create bool) (T, any) {
+	e := s.lookup(key)
+	if e == nil {
+		var zero T
+		if !create {
+			return zero, nil
+		}
+		switch any(zero).(type) {
+		case hash:
+			e = &entry{value: hash{}}
+		case set:
+			e = &entry{value: set{}}
+		case zset:
+			e = &entry{value: zset{}}
+		case list:
+			e = &entry{value: list{}}
+		}
+		s.keys[key] = e
+	}
+	value, ok := e.value.(T)
+	if !ok {
+		var zero T
+		return zero, errWrongType
+	}
+	return value, nil
+}
+
+// changed records a change of the container at key, removing it if empty
+func changed(s *Server, key string, size int) {
+	if size == 0 {
+		s.remove(key)
+		return
+	}
+	s.touch(key)
+}
+
+func cmdHSet(s *Server, args []string) any {
+	if len(args)%2 != 1 {
+		return errReply("ERR wrong number of arguments for 'hset' command")
+	}
+	h, fail := container[hash](s, args[0], true)
+	if fail != nil {
+		return fail
+	}
+	var added int64
+	for i := 1; i < len(args); i += 2 {
+		if _, ok := h[args[i]]; !ok {
+			added++
+		}
+		h[args[i]] = args[i+1]
+	}
+	changed(s, args[0], len(h))
+	return added
+}
+
+func cmdHSetNX(s *Server, args []string) any {
+	h, fail := container[hash](s, args[0], true)
+	if fail != nil {
+		return fail
+	}
+	if _, ok := h[args[1]]; ok {
+		return int64(0)
+	}
+	h[args[1]] = args[2]
+	changed(s, args[0], len(h))
+	return int64(1)
+}
+
+func cmdHGet(s *Server, args []string) any {
+	h, fail := container[hash](s, args[0], false)
+	if fail != nil {
+		return fail
+	}
+	value, ok := h[args[1]]
+	if !ok {
+		return nil
+	}
+	return value
+}
+
+func cmdHMGet(s *Server, args []string) any {
+	h, fail := container[hash](s, args[0], false)
+	if fail != nil {
+		return fail
+	}
+	values := make([]any, len(args)-1)
+	for i, field := range args[1:] {
+		if value, ok := h[field]; ok {
+			values[i] = value
+		}
+	}
+	return values
+}
+
+func cmdHGetAll(s *Server, args []string) any {
+	h, fail := container[hash](s, args[0], false)
+	if fail != nil {
+		return fail
+	}
+	fields := make([]string, 0, len(h))
+	for field := range h {
+		fields = append(fields, field)
+	}
+	slices.Sort(fields)
+	out := make([]string, 0, 2*len(h))
+	for _, field := range fields {
+		out = append(out, field, h[field])
+	}
+	return out
+}
+
+func cmdHDel(s *Server, args []string) any {
+	h, fail := container[hash](s, args[0], false)
+	if fail != nil || h == nil {
+		return orZero(fail)
+	}
+	var n int64
+	for _, field := range args[1:] {
+		if _, ok := h[field]; ok {
+			delete(h, field)
+			n++
+		}
+	}
+	changed(s, args[0], len(h))
+	return n
+}
+
+func cmdHLen(s *Server, args []string) any {
+	h, fail := container[hash](s, args[0], false)
+	if fail != nil {
+		return fail
+	}
+	return int64(len(h))
+}
+
+func cmdHIncrBy(s *Server, args []string) any {
+	by, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		return errNotInt
+	}
+	h, fail := container[hash](s, args[0], true)
+	if fail != nil {
+		return fail
+	}
+	var n int64
+	if value, ok := h[args[1]]; ok {
+		if n, err = strconv.ParseInt(value, 10, 64); err != nil {
+			return errReply("ERR hash value is not an integer")
+		}
+	}
+	n += by
+	h[args[1]] = strconv.FormatInt(n, 10)
+	changed(s, args[0], len(h))
+	return n
+}
+
+func cmdHIncrByFloat(s *Server, args []string) any {
+	by, err := strconv.ParseFloat(args[2], 64)
+	if err != nil {
+		return errNotFloat
+	}
+	h, fail := container[hash](s, args[0], true)
+	if fail != nil {
+		return fail
+	}
+	var f float64
+	if value, ok := h[args[1]]; ok {
+		if f, err = strconv.ParseFloat(value, 64); err != nil {
+			return errReply("ERR hash value is not a float")
+		}
+	}
+	f += by
+	h[args[1]] = formatFloat(f)
+	changed(s, args[0], len(h))
+	return h[args[1]]
+}
+
+func cmdSAdd(s *Server, args []string) any {
+	members, fail := container[set](s, args[0], true)
+	if fail != nil {
+		return fail
+	}
+	var added int64
+	for _, member := range args[1:] {
+		if _, ok := members[member]; !ok {
+			members[member] = struct{}{}
+			added++
+		}
+	}
+	changed(s, args[0], len(members))
+	return added
+}
+
+func cmdSRem(s *Server, args []string) any {
+	members, fail := container[set](s, args[0], false)
+	if fail != nil || members == nil {
+		return orZero(fail)
+	}
+	var n int64
+	for _, member := range args[1:] {
+		if _, ok := members[member]; ok {
+			delete(members, member)
+			n++
+		}
+	}
+	changed(s, args[0], len(members))
+	return n
+}
+
+func cmdSMembers(s *Server, args []string) any {
+	members, fail := container[set](s, args[0], false)
+	if fail != nil {
+		return fail
+	}
+	out := make([]string, 0, len(members))
+	for member := range members {
+		out = append(out, member)
+	}
+	slices.Sort(out)
+	return out
+}
+
+func cmdSCard(s *Server, args []string) any {
+	members, fail := container[set](s, args[0], false)
+	if fail != nil {
+		return fail
+	}
+	return int64(len(members))
+}
+
+func cmdSIsMember(s *Server, args []string) any {
+	members, fail := container[set](s, args[0], false)
+	if fail != nil {
+		return fail
+	}
+	if _, ok := members[args[1]]; ok {
+		return int64(1)
+	}
+	return int64(0)
+}
+
+func orZero(fail any) any {
+	if fail != nil {
+		return fail
+	}
+	return int64(0)
+}
+
+// member is a sorted set member with its score
+type member struct {
+	name  string
+	score float64
+}
+
+// sorted returns the members of z by score, then name
+func (z zset) sorted() []member {
+	members := make([]member, 0, len(z))
+	for name, score := range z {
+		members = append(members, member{name, score})
+	}
+	slices.SortFunc(members, func(a, b member) int {
+		if a.score != b.score {
+			if a.score < b.score {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(a.name, b.name)
+	})
+	return members
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+func parseFloat(value string) (float64, bool) {
+	switch strings.ToLower(value) {
+	case "inf", "+inf":
+		return math.Inf(1), true
+	case "-inf":
+		return math.Inf(-1), true
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	return f, err == nil
+}
+
+func cmdZAdd(s *Server, args []string) any {
+	key := args[0]
+	args = args[1:]
+	var nx, xx, gt, lt, ch bool
+	for len(args) > 0 {
+		switch strings.ToUpper(args[0]) {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "GT":
+			gt = true
+		case "LT":
+			lt = true
+		case "CH":
+			ch = true
+		default:
+			goto pairs
+		}
+		args = args[1:]
+	}
+pairs:
+	if len(args) == 0 || len(args)%2 != 0 {
+		return errSyntax
+	}
+	for i := 0; i < len(args); i += 2 {
+		if _, ok := parseFloat(args[i]); !ok {
+			return errNotFloat
+		}
+	}
+
+	z, fail := container[zset](s, key, true)
+	if fail != nil {
+		return fail
+	}
+	var added, updated int64
+	for i := 0; i < len(args); i += 2 {
+		score, _ := parseFloat(args[i])
+		name := args[i+1]
+		old, exists := z[name]
+		switch {
+		case exists && nx, !exists && xx:
+			continue
+		case exists && gt && score <= old, exists && lt && score >= old:
+			continue
+		}
+		z[name] = score
+		if !exists {
+			added++
+		} else if old != score {
+			updated++
+		}
+	}
+	changed(s, key, len(z))
+	if ch {
+		return added + updated
+	}
+	return added
+}
+
+func cmdZRem(s *Server, args []string) any {
+	z, fail := container[zset](s, args[0], false)
+	if fail != nil || z == nil {
+		return orZero(fail)
+	}
+	var n int64
+	for _, name := range args[1:] {
+		if _, ok := z[name]; ok {
+			delete(z, name)
+			n++
+		}
+	}
+	changed(s, args[0], len(z))
+	return n
+}
+
+func cmdZCard(s *Server, args []string) any {
+	z, fail := container[zset](s, args[0], false)
+	if fail != nil {
+		return fail
+	}
+	return int64(len(z))
+}
+
+func cmdZScore(s *Server, args []string) any {
+	z, fail := container[zset](s, args[0], false)
+	if fail != nil {
+		return fail
+	}
+	score, ok := z[args[1]]
+	if !ok {
+		return nil
+	}
+	return formatFloat(score)
+}
+
+func cmdZMScore(s *Server, args []string) any {
+	z, fail := container[zset](s, args[0], false)
+	if fail != nil {
+		return fail
+	}
+	scores := make([]any, len(args)-1)
+	for i, name := range args[1:] {
+		if score, ok := z[name]; ok {
+			scores[i] = formatFloat(score)
+		}
+	}
+	return scores
+}
+
+// rankRange resolves start and stop, which count from the end when
+// negative, to a slice range of n members
+func rankRange(start, stop string, n int) (int, int, bool) {
+	from, err1 := strconv.Atoi(start)
+	to, err2 := strconv.Atoi(stop)
+	if err1 != nil || err2 != nil {
+		return 0, 0, false
+	}
+	if from < 0 {
+		from += n
+	}
+	if to < 0 {
+		to += n
+	}
+	from = max(from, 0)
+	to = min(to, n-1)
+	if from > to {
+		return 0, 0, true
+	}
+	return from, to + 1, true
+}
+
+func withScores(members []member, scores bool) []string {
+	out := make([]string, 0, len(members))
+	for _, m := range members {
+		out = append(out, m.name)
+		if scores {
+			out = append(out, formatFloat(m.score))
+		}
+	}
+	return out
+}
+
+func cmdZRange(reverse bool) func(s *Server, args []string) any {
+	return func(s *Server, args []string) any {
+		scores := false
+		for _, opt := range args[3:] {
+			if strings.ToUpper(opt) != "WITHSCORES" {
+				return errSyntax
+			}
+			scores = true
+		}
+
+		z, fail := container[zset](s, args[0], false)
+		if fail != nil {
+			return fail
+		}
+		members := z.sorted()
+		if reverse {
+			slices.Reverse(members)
+		}
+		from, to, ok := rankRange(args[1], args[2], len(members))
+		if !ok {
+			return errNotInt
+		}
+		return withScores(members[from:to], scores)
+	}
+}
+
+// scoreBound parses a score range bound, exclusive when prefixed by "("
+func scoreBound(value string) (float64, bool, bool) {
+	exclusive := strings.HasPrefix(value, "(")
+	f, ok := parseFloat(strings.TrimPrefix(value, "("))
+	return f, exclusive, ok
+}
+
+// inScoreRange returns the members of z scored between the bounds from and
+// to
+func inScoreRange(z zset, from, to string) ([]member, bool) {
+	lo, loEx, ok1 := scoreBound(from)
+	hi, hiEx, ok2 := scoreBound(to)
+	if !ok1 || !ok2 {
+		return nil, false
+	}
+
+	var out []member
+	for _, m := range z.sorted() {
+		if m.score < lo || loEx && m.score == lo || m.score > hi || hiEx && m.score == hi {
+			continue
+		}
+		out = append(out, m)
+	}
+	return out, true
+}
+
+func cmdZRangeByScore(reverse bool) func(s *Server, args []string) any {
+	return func(s *Server, args []string) any {
+		from, to := args[1], args[2]
+		if reverse {
+			from, to = to, from
+		}
+
+		scores := false
+		offset, count := 0, -1
+		opts := args[3:]
+		for i := 0; i < len(opts); i++ {
+			switch strings.ToUpper(opts[i]) {
+			case "WITHSCORES":
+				scores = true
+			case "LIMIT":
+				if i+2 >= len(opts) {
+					return errSyntax
+				}
+				var err1, err2 error
+				offset, err1 = strconv.Atoi(opts[i+1])
+				count, err2 = strconv.Atoi(opts[i+2])
+				if err1 != nil || err2 != nil {
+					return errNotInt
+				}
+				i += 2
+			default:
+				return errSyntax
+			}
+		}
+
+		z, fail := container[zset](s, args[0], false)
+		if fail != nil {
+			return fail
+		}
+		members, ok := inScoreRange(z, from, to)
+		if !ok {
+			return errReply("ERR min or max is not a float")
+		}
+		if reverse {
+			slices.Reverse(members)
+		}
+		if offset > 0 {
+			members = members[min(offset, len(members)):]
+		}
+		if count >= 0 && count < len(members) {
+			members = members[:count]
+		}
+		return withScores(members, scores)
+	}
+}
+
+func cmdZRemRangeByRank(s *Server, args []string) any {
+	z, fail := container[zset](s, args[0], false)
+	if fail != nil || z == nil {
+		return orZero(fail)
+	}
+	members := z.sorted()
+	from, to, ok := rankRange(args[1], args[2], len(members))
+	if !ok {
+		return errNotInt
+	}
+	for _, m := range members[from:to] {
+		delete(z, m.name)
+	}
+	changed(s, args[0], len(z))
+	return int64(to - from)
+}
+
+func cmdZRemRangeByScore(s *Server, args []string) any {
+	z, fail := container[zset](s, args[0], false)
+	if fail != nil || z == nil {
+		return orZero(fail)
+	}
+	members, ok := inScoreRange(z, args[1], args[2])
+	if !ok {
+		return errReply("ERR min or max is not a float")
+	}
+	for _, m := range members {
+		delete(z, m.name)
+	}
+	changed(s, args[0], len(z))
+	return int64(len(members))
+}
+
+func cmdPush(left bool) func(s *Server, args []string) any {
+	return func(s *Server, args []string) any {
+		l, fail := container[list](s, args[0], true)
+		if fail != nil {
+			return fail
+		}
+		for _, value := range args[1:] {
+			if left {
+				l = append(list{value}, l...)
+			} else {
+				l = append(l, value)
+			}
+		}
+		s.keys[args[0]].value = l
+		changed(s, args[0], len(l))
+		return int64(len(l))
+	}
+}
+
+func cmdLRange(s *Server, args []string) any {
+	l, fail := container[list](s, args[0], false)
+	if fail != nil {
+		return fail
+	}
+	from, to, ok := rankRange(args[1], args[2], len(l))
+	if !ok {
+		return errNotInt
+	}
+	return []string(l[from:to])
+}
+
+func cmdLTrim(s *Server, args []string) any {
+	l, fail := container[list](s, args[0], false)
+	if fail != nil {
+		return fail
+	}
+	if l == nil {
+		return simple("OK")
+	}
+	from, to, ok := rankRange(args[1], args[2], len(l))
+	if !ok {
+		return errNotInt
+	}
+	l = slices.Clone(l[from:to])
+	s.keys[args[0]].value = l
+	changed(s, args[0], len(l))
+	return simple("OK")
+}
+
+func cmdLLen(s *Server, args []string) any {
+	l, fail := container[list](s, args[0], false)
+	if fail != nil {
+		return fail
+	}
+	return int64(len(l))
+}
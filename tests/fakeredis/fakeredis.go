@@ -0,0 +1,513 @@
+// Package fakeredis is an in-memory Redis server for unit tests. It speaks
+// RESP2 over TCP, so services are tested through a real *redis.Client, and
+// implements the commands the services use: strings, hashes, sets, sorted
+// sets, lists, key expiry, WATCH/MULTI/EXEC and pub/sub. Lua scripts are
+// not supported; code running them is tested against Redis in
+// tests/integration.
+//
+// Time is the server's own: FastForward moves it on to expire keys without
+// waiting, and SetDown makes the server unreachable, as a Redis outage looks
+// to clients.
+package fakeredis
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Server is an in-memory Redis server
+type Server struct {
+	ln net.Listener
+
+	mu      sync.Mutex
+	keys    map[string]*entry
+	version map[string]uint64
+	offset  time.Duration
+	down    bool
+	conns   map[*conn]struct{}
+	subs    map[string]map[*conn]struct{}
+}
+
+// entry is a key's value, one of string, hash, set, zset or list
+type entry struct {
+	value   any
+	expires time.Time
+}
+
+type (
+	hash map[string]string
+	set  map[string]struct{}
+	zset map[string]float64
+	list []string
+)
+
+// New starts a server stopped when the test ends
+func New(t testing.TB) *Server {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("fakeredis: %v", err)
+	}
+
+	s := &Server{
+		ln:      ln,
+		keys:    make(map[string]*entry),
+		version: make(map[string]uint64),
+		conns:   make(map[*conn]struct{}),
+		subs:    make(map[string]map[*conn]struct{}),
+	}
+	go s.serve()
+
+	t.Cleanup(s.close)
+	return s
+}
+
+// Addr returns the address the server listens on
+func (s *Server) Addr() string {
+	return s.ln.Addr().String()
+}
+
+// Client returns a client of the server closed when the test ends. It does
+// not retry commands, so failures while the server is down show at once.
+func (s *Server) Client(t testing.TB) *redis.Client {
+	rdb := redis.NewClient(&redis.Options{
+		Addr:          s.Addr(),
+		Protocol:      2,
+		MaxRetries:    -1,
+		DialerRetries: 1,
+	})
+	t.Cleanup(func() { rdb.Close() })
+	return rdb
+}
+
+// FastForward moves the server's clock on by d, expiring keys whose time
+// to live ran out
+func (s *Server) FastForward(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.offset += d
+}
+
+// SetDown closes every connection and refuses new ones while down is set
+func (s *Server) SetDown(down bool) {
+	s.mu.Lock()
+	s.down = down
+	var closing []*conn
+	if down {
+		for c := range s.conns {
+			closing = append(closing, c)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, c := range closing {
+		c.nc.Close()
+	}
+}
+
+// Exists reports whether key holds a value
+func (s *Server) Exists(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lookup(key) != nil
+}
+
+// Get returns the string stored at key, or "" if there is none
+func (s *Server) Get(key string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e := s.lookup(key); e != nil {
+		value, _ := e.value.(string)
+		return value
+	}
+	return ""
+}
+
+// TTL returns the time key has left to live, or 0 if it does not expire or
+// does not exist
+func (s *Server) TTL(key string) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e := s.lookup(key); e != nil && !e.expires.IsZero() {
+		return e.expires.Sub(s.now())
+	}
+	return 0
+}
+
+// Set stores a string at key without a time to live
+func (s *Server) Set(key, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.put(key, &entry{value: value})
+}
+
+// Del removes key, as another client would
+func (s *Server) Del(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.lookup(key) != nil {
+		s.remove(key)
+	}
+}
+
+// Subscribers returns how many connections are subscribed to channel
+func (s *Server) Subscribers(channel string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.subs[channel])
+}
+
+func (s *Server) close() {
+	s.ln.Close()
+	s.SetDown(true)
+}
+
+func (s *Server) serve() {
+	for {
+		nc, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+
+		c := &conn{srv: s, nc: nc, r: bufio.NewReader(nc), w: bufio.NewWriter(nc)}
+		s.mu.Lock()
+		if s.down {
+			s.mu.Unlock()
+			nc.Close()
+			continue
+		}
+		s.conns[c] = struct{}{}
+		s.mu.Unlock()
+
+		go c.serve()
+	}
+}
+
+// now returns the server's time. s.mu must be held.
+func (s *Server) now() time.Time {
+	return time.Now().Add(s.offset)
+}
+
+// lookup returns the live entry at key, removing it if it expired. s.mu
+// must be held.
+func (s *Server) lookup(key string) *entry {
+	e, ok := s.keys[key]
+	if !ok {
+		return nil
+	}
+	if !e.expires.IsZero() && !s.now().Before(e.expires) {
+		s.remove(key)
+		return nil
+	}
+	return e
+}
+
+// put stores e at key. s.mu must be held.
+func (s *Server) put(key string, e *entry) {
+	s.keys[key] = e
+	s.touch(key)
+}
+
+// remove deletes key. s.mu must be held.
+func (s *Server) remove(key string) {
+	delete(s.keys, key)
+	s.touch(key)
+}
+
+// touch records a change of key, failing transactions watching it. s.mu
+// must be held.
+func (s *Server) touch(key string) {
+	s.version[key]++
+}
+
+// conn is a client connection
+type conn struct {
+	srv *Server
+	nc  net.Conn
+	r   *bufio.Reader
+
+	// w is shared with publishers, who hold srv.mu while writing
+	w *bufio.Writer
+
+	watched    map[string]uint64
+	queued     [][]string
+	multi      bool
+	dirtyMulti bool
+	subscribed map[string]struct{}
+}
+
+func (c *conn) serve() {
+	defer func() {
+		s := c.srv
+		s.mu.Lock()
+		delete(s.conns, c)
+		for channel := range c.subscribed {
+			delete(s.subs[channel], c)
+		}
+		s.mu.Unlock()
+		c.nc.Close()
+	}()
+
+	for {
+		args, err := readCommand(c.r)
+		if err != nil {
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+
+		s := c.srv
+		s.mu.Lock()
+		reply := c.handle(args)
+		writeReply(c.w, reply)
+		err = c.w.Flush()
+		s.mu.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// handle runs a command of the connection. srv.mu must be held.
+func (c *conn) handle(args []string) any {
+	name := strings.ToUpper(args[0])
+
+	switch name {
+	case "MULTI":
+		if c.multi {
+			return errReply("ERR MULTI calls can not be nested")
+		}
+		c.multi, c.queued = true, nil
+		return simple("OK")
+	case "EXEC":
+		if !c.multi {
+			return errReply("ERR EXEC without MULTI")
+		}
+		return c.exec()
+	case "DISCARD":
+		if !c.multi {
+			return errReply("ERR DISCARD without MULTI")
+		}
+		c.multi, c.queued, c.dirtyMulti, c.watched = false, nil, false, nil
+		return simple("OK")
+	case "WATCH":
+		if c.multi {
+			return errReply("ERR WATCH inside MULTI is not allowed")
+		}
+		if c.watched == nil {
+			c.watched = make(map[string]uint64)
+		}
+		for _, key := range args[1:] {
+			c.srv.lookup(key)
+			c.watched[key] = c.srv.version[key]
+		}
+		return simple("OK")
+	case "UNWATCH":
+		c.watched = nil
+		return simple("OK")
+	case "SUBSCRIBE":
+		return c.subscribe(args[1:])
+	case "UNSUBSCRIBE":
+		return c.unsubscribe(args[1:])
+	case "PING":
+		if len(c.subscribed) > 0 {
+			payload := ""
+			if len(args) > 1 {
+				payload = args[1]
+			}
+			return []any{"pong", payload}
+		}
+	}
+
+	cmd, ok := commands[name]
+	if !ok {
+		if c.multi {
+			c.dirtyMulti = true
+		}
+		return errReply(fmt.Sprintf("ERR unknown command '%s'", args[0]))
+	}
+	if cmd.arity > 0 && len(args) != cmd.arity || cmd.arity < 0 && len(args) < -cmd.arity {
+		if c.multi {
+			c.dirtyMulti = true
+		}
+		return errReply(fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(args[0])))
+	}
+
+	if c.multi {
+		c.queued = append(c.queued, args)
+		return simple("QUEUED")
+	}
+	return cmd.run(c.srv, args[1:])
+}
+
+// exec runs the queued commands unless a watched key changed. srv.mu must
+// be held.
+func (c *conn) exec() any {
+	queued, dirty, watched := c.queued, c.dirtyMulti, c.watched
+	c.multi, c.queued, c.dirtyMulti, c.watched = false, nil, false, nil
+
+	if dirty {
+		return errReply("EXECABORT Transaction discarded because of previous errors.")
+	}
+	for key, version := range watched {
+		c.srv.lookup(key)
+		if c.srv.version[key] != version {
+			return nilArray{}
+		}
+	}
+
+	replies := make([]any, len(queued))
+	for i, args := range queued {
+		replies[i] = commands[strings.ToUpper(args[0])].run(c.srv, args[1:])
+	}
+	return replies
+}
+
+// subscribe adds the connection to channels. srv.mu must be held.
+func (c *conn) subscribe(channels []string) any {
+	if c.subscribed == nil {
+		c.subscribed = make(map[string]struct{})
+	}
+	replies := make([]any, 0, len(channels))
+	for _, channel := range channels {
+		c.subscribed[channel] = struct{}{}
+		if c.srv.subs[channel] == nil {
+			c.srv.subs[channel] = make(map[*conn]struct{})
+		}
+		c.srv.subs[channel][c] = struct{}{}
+		replies = append(replies, []any{"subscribe", channel, int64(len(c.subscribed))})
+	}
+	return multiReply(replies)
+}
+
+// unsubscribe removes the connection from channels, or from all of them.
+// srv.mu must be held.
+func (c *conn) unsubscribe(channels []string) any {
+	if len(channels) == 0 {
+		for channel := range c.subscribed {
+			channels = append(channels, channel)
+		}
+	}
+	replies := make([]any, 0, len(channels))
+	for _, channel := range channels {
+		delete(c.subscribed, channel)
+		delete(c.srv.subs[channel], c)
+		replies = append(replies, []any{"unsubscribe", channel, int64(len(c.subscribed))})
+	}
+	if len(replies) == 0 {
+		replies = append(replies, []any{"unsubscribe", nil, int64(0)})
+	}
+	return multiReply(replies)
+}
+
+// publish sends message to the subscribers of channel and returns how many
+// there are. s.mu must be held.
+func (s *Server) publish(channel, message string) int64 {
+	for c := range s.subs[channel] {
+		writeReply(c.w, []any{"message", channel, message})
+		c.w.Flush()
+	}
+	return int64(len(s.subs[channel]))
+}
+
+// Replies are written as RESP2: simple strings, errors, integers, bulk
+// strings (a nil any for a missing one), arrays and nil arrays. multiReply
+// is several replies to one command, as SUBSCRIBE sends.
+type (
+	simple     string
+	errReply   string
+	nilArray   struct{}
+	multiReply []any
+)
+
+func writeReply(w *bufio.Writer, reply any) {
+	switch r := reply.(type) {
+	case simple:
+		fmt.Fprintf(w, "+%s\r\n", string(r))
+	case errReply:
+		fmt.Fprintf(w, "-%s\r\n", string(r))
+	case int64:
+		fmt.Fprintf(w, ":%d\r\n", r)
+	case int:
+		fmt.Fprintf(w, ":%d\r\n", r)
+	case string:
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(r), r)
+	case nil:
+		w.WriteString("$-1\r\n")
+	case nilArray:
+		w.WriteString("*-1\r\n")
+	case []any:
+		fmt.Fprintf(w, "*%d\r\n", len(r))
+		for _, item := range r {
+			writeReply(w, item)
+		}
+	case []string:
+		fmt.Fprintf(w, "*%d\r\n", len(r))
+		for _, item := range r {
+			writeReply(w, item)
+		}
+	case multiReply:
+		for _, item := range r {
+			writeReply(w, item)
+		}
+	default:
+		panic(fmt.Sprintf("fakeredis: cannot write %T", reply))
+	}
+}
+
+// readCommand reads a command sent as an array of bulk strings, or inline
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return strings.Fields(line), nil
+	}
+
+	n, err := strconv.Atoi(line[1:])
+	if err != nil {
+		return nil, fmt.Errorf("fakeredis: bad array header %q", line)
+	}
+	args := make([]string, n)
+	for i := range args {
+		header, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(header, "$") {
+			return nil, fmt.Errorf("fakeredis: bad bulk header %q", header)
+		}
+		size, err := strconv.Atoi(header[1:])
+		if err != nil || size < 0 {
+			return nil, fmt.Errorf("fakeredis: bad bulk header %q", header)
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasSuffix(line, "\r\n") {
+		return "", errors.New("fakeredis: line not ended by CRLF")
+	}
+	return line[:len(line)-2], nil
+}
@@ -0,0 +1,107 @@
+package fakeredis
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpiry(t *testing.T) {
+	s := New(t)
+	rdb := s.Client(t)
+	ctx := context.Background()
+
+	require.NoError(t, rdb.Set(ctx, "a", "1", time.Minute).Err())
+	require.NoError(t, rdb.Set(ctx, "b", "2", 0).Err())
+	values, err := rdb.MGet(ctx, "a", "b", "c").Result()
+	require.NoError(t, err)
+	assert.Equal(t, []any{"1", "2", nil}, values)
+
+	s.FastForward(30 * time.Second)
+	require.NoError(t, rdb.Expire(ctx, "a", time.Minute).Err())
+	s.FastForward(45 * time.Second)
+	assert.True(t, s.Exists("a"), "the expiry was pushed back")
+
+	s.FastForward(15 * time.Second)
+	assert.ErrorIs(t, rdb.Get(ctx, "a").Err(), redis.Nil)
+	assert.Equal(t, "2", s.Get("b"))
+}
+
+func TestWatch(t *testing.T) {
+	s := New(t)
+	rdb := s.Client(t)
+	ctx := context.Background()
+
+	incr := func(interfere func()) error {
+		return rdb.Watch(ctx, func(tx *redis.Tx) error {
+			n, err := tx.Get(ctx, "n").Int()
+			if err != nil && err != redis.Nil {
+				return err
+			}
+			interfere()
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.Set(ctx, "n", n+1, time.Minute)
+				return nil
+			})
+			return err
+		}, "n")
+	}
+
+	require.NoError(t, incr(func() {}))
+	assert.ErrorIs(t, incr(func() { s.Set("n", "10") }), redis.TxFailedErr)
+	assert.Equal(t, "10", s.Get("n"))
+
+	// Expiring counts as a change
+	require.NoError(t, incr(func() {}))
+	assert.ErrorIs(t, incr(func() { s.FastForward(2 * time.Minute) }), redis.TxFailedErr)
+}
+
+func TestSortedSet(t *testing.T) {
+	rdb := New(t).Client(t)
+	ctx := context.Background()
+
+	require.NoError(t, rdb.ZAdd(ctx, "z", redis.Z{Score: 2, Member: "b"}, redis.Z{Score: 1, Member: "a"}, redis.Z{Score: 3, Member: "c"}).Err())
+
+	members, err := rdb.ZRevRangeByScore(ctx, "z", &redis.ZRangeBy{Min: "(1", Max: "+inf"}).Result()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"c", "b"}, members)
+
+	scored, err := rdb.ZRangeByScoreWithScores(ctx, "z", &redis.ZRangeBy{Min: "-inf", Max: "2"}).Result()
+	require.NoError(t, err)
+	assert.Equal(t, []redis.Z{{Score: 1, Member: "a"}, {Score: 2, Member: "b"}}, scored)
+
+	require.NoError(t, rdb.ZRemRangeByRank(ctx, "z", 0, -2).Err())
+	assert.Equal(t, []string{"c"}, rdb.ZRange(ctx, "z", 0, -1).Val())
+}
+
+func TestPubSub(t *testing.T) {
+	rdb := New(t).Client(t)
+	ctx := context.Background()
+
+	sub := rdb.Subscribe(ctx, "events")
+	defer sub.Close()
+	_, err := sub.Receive(ctx)
+	require.NoError(t, err)
+
+	require.NoError(t, rdb.Publish(ctx, "events", "hello").Err())
+	msg, err := sub.ReceiveMessage(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", msg.Payload)
+}
+
+func TestDown(t *testing.T) {
+	s := New(t)
+	rdb := s.Client(t)
+	ctx := context.Background()
+
+	require.NoError(t, rdb.Set(ctx, "a", "1", 0).Err())
+	s.SetDown(true)
+	assert.Error(t, rdb.Get(ctx, "a").Err())
+
+	s.SetDown(false)
+	assert.Equal(t, "1", rdb.Get(ctx, "a").Val(), "data survives the outage")
+}
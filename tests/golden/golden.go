@@ -0,0 +1,32 @@
+// Package golden compares output with the files under a test package's
+// testdata/golden directory. Run the tests with -update to rewrite the files
+// after an intended change, then review the diff.
+package golden
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var update = flag.Bool("update", false, "rewrite golden files with the current output")
+
+// Assert checks got against testdata/golden/<name>.golden
+func Assert(t *testing.T, name, got string) {
+	t.Helper()
+
+	path := filepath.Join("testdata", "golden", name+".golden")
+	if *update {
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(got), 0o644))
+		return
+	}
+
+	want, err := os.ReadFile(path)
+	require.NoError(t, err, "missing golden file, run with -update to create it")
+	assert.Equal(t, string(want), got, "output differs from %s, run with -update if the change is intended", path)
+}
@@ -0,0 +1,196 @@
+package integration
+
+import (
+	"context"
+	"errors"
+	"exc6/config"
+	infraredis "exc6/infrastructure/redis"
+	"exc6/server/websocket"
+	"exc6/services/calls"
+	"exc6/tests/clients"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type callResponse struct {
+	CallID string `json:"call_id"`
+	Status string `json:"status"`
+}
+
+func TestCallSignaling(t *testing.T) {
+	baseURL := startServer(t)
+
+	alice := newUser(t, baseURL, "alice")
+	bob := newUser(t, baseURL, "bob")
+
+	aliceWS := connect(t, alice)
+	bobWS := connect(t, bob)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	var call callResponse
+	require.NoError(t, alice.PostJSON(ctx, "/call/initiate/"+bob.Username, nil, &call))
+	require.NotEmpty(t, call.CallID)
+	assert.Equal(t, "ringing", call.Status)
+
+	// Offer, answer and ICE candidates are relayed between the peers as sent
+	require.NoError(t, aliceWS.Send(&websocket.Message{
+		Type: websocket.MessageTypeCallOffer,
+		ID:   call.CallID,
+		To:   bob.Username,
+		Data: map[string]any{"sdp": "v=0 offer"},
+	}))
+
+	offer, err := bobWS.Expect(clients.All(clients.OfType(websocket.MessageTypeCallOffer), clients.From(alice.Username)), expectTimeout)
+	require.NoError(t, err)
+	assert.Equal(t, call.CallID, offer.ID)
+	assert.Equal(t, "v=0 offer", offer.Data["sdp"])
+
+	require.NoError(t, bobWS.Send(&websocket.Message{
+		Type: websocket.MessageTypeCallICE,
+		ID:   call.CallID,
+		To:   alice.Username,
+		Data: map[string]any{"candidate": "candidate:1 1 udp 1 127.0.0.1 9 typ host"},
+	}))
+
+	_, err = aliceWS.Expect(clients.All(clients.OfType(websocket.MessageTypeCallICE), clients.From(bob.Username)), expectTimeout)
+	require.NoError(t, err)
+
+	// Answering over HTTP notifies the caller
+	var answered callResponse
+	require.NoError(t, bob.PostJSON(ctx, "/call/answer/"+call.CallID, nil, &answered))
+	assert.Equal(t, "active", answered.Status)
+
+	answer, err := aliceWS.Expect(clients.OfType(websocket.MessageTypeCallAnswer), expectTimeout)
+	require.NoError(t, err)
+	assert.Equal(t, call.CallID, answer.ID)
+	assert.Equal(t, true, answer.Data["accepted"])
+
+	// Either party hanging up notifies the other
+	require.NoError(t, alice.PostOK(ctx, "/call/end/"+call.CallID, nil))
+
+	end, err := bobWS.Expect(clients.OfType(websocket.MessageTypeCallEnd), expectTimeout)
+	require.NoError(t, err)
+	assert.Equal(t, alice.Username, end.Data["ended_by"])
+}
+
+func TestICEServers(t *testing.T) {
+	baseURL := startServer(t)
+	alice := newUser(t, baseURL, "alice")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var resp struct {
+		ICEServers []calls.ICEServer `json:"ice_servers"`
+		TTL        int               `json:"ttl"`
+		ExpiresAt  int64             `json:"expires_at"`
+	}
+	require.NoError(t, alice.GetJSON(ctx, "/call/ice-servers", &resp))
+	require.Len(t, resp.ICEServers, 2)
+	assert.Greater(t, resp.TTL, 0)
+
+	// The TURN server accepts the credentials until they expire
+	turn := resp.ICEServers[1]
+	user, credential := calls.TURNCredentials(testTURNSecret, alice.Username, time.Unix(resp.ExpiresAt, 0))
+	assert.Equal(t, user, turn.Username)
+	assert.Equal(t, credential, turn.Credential)
+}
+
+func TestPresence(t *testing.T) {
+	baseURL := startServer(t)
+
+	alice := newUser(t, baseURL, "alice")
+	bob := newUser(t, baseURL, "bob")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	// Calls can only be placed to users with an open connection
+	initiate := func() (*callResponse, error) {
+		var call callResponse
+		if err := alice.PostJSON(ctx, "/call/initiate/"+bob.Username, nil, &call); err != nil {
+			return nil, err
+		}
+		return &call, nil
+	}
+
+	_, err := initiate()
+	var statusErr *clients.StatusError
+	require.True(t, errors.As(err, &statusErr), "calling an offline user fails: %v", err)
+	assert.Equal(t, http.StatusBadRequest, statusErr.StatusCode)
+
+	bobWS, err := bob.DialWS(ctx)
+	require.NoError(t, err)
+
+	var call *callResponse
+	require.Eventually(t, func() bool {
+		call, err = initiate()
+		return err == nil
+	}, 5*time.Second, 50*time.Millisecond, "user is online once connected")
+	require.NoError(t, alice.PostOK(ctx, "/call/end/"+call.CallID, nil))
+
+	require.NoError(t, bobWS.Close())
+
+	require.Eventually(t, func() bool {
+		_, err := initiate()
+		return errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusBadRequest
+	}, 5*time.Second, 50*time.Millisecond, "user is offline once disconnected")
+}
+
+// TestCallStateSharedAcrossInstances checks that instances see each other's
+// calls and cannot place a user in two calls at once
+func TestCallStateSharedAcrossInstances(t *testing.T) {
+	cfg, err := config.Load()
+	require.NoError(t, err)
+	rdb, err := infraredis.NewClient(cfg.Redis)
+	require.NoError(t, err)
+	defer rdb.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	a, b := calls.NewCallService(ctx, rdb), calls.NewCallService(ctx, rdb)
+	defer a.Close()
+	defer b.Close()
+
+	user := func(name string) string {
+		return fmt.Sprintf("it_%s_%d", name, time.Now().UnixNano()%1e9)
+	}
+	alice, bob, carol, dave := user("alice"), user("bob"), user("carol"), user("dave")
+
+	call, err := a.InitiateCall(alice, bob)
+	require.NoError(t, err)
+
+	assert.True(t, b.IsUserInCall(bob), "a new instance sees the call")
+	_, err = b.InitiateCall(carol, bob)
+	require.Error(t, err, "bob is in a call placed elsewhere")
+
+	require.NoError(t, b.AnswerCall(call.ID, bob))
+	answered, err := a.GetCall(call.ID)
+	require.NoError(t, err)
+	assert.Equal(t, calls.CallStateActive, answered.State)
+
+	// Racing instances cannot both call dave
+	results := make(chan error, 2)
+	go func() { _, err := a.InitiateCall(carol, dave); results <- err }()
+	go func() { _, err := b.InitiateCall(user("erin"), dave); results <- err }()
+	failed := 0
+	for range 2 {
+		if <-results != nil {
+			failed++
+		}
+	}
+	assert.Equal(t, 1, failed, "exactly one call to dave succeeds")
+
+	require.NoError(t, b.EndCall(call.ID, bob))
+	require.Eventually(t, func() bool {
+		return !a.IsUserInCall(alice)
+	}, 5*time.Second, 100*time.Millisecond, "the call ended everywhere")
+}
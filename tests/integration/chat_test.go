@@ -0,0 +1,359 @@
+package integration
+
+import (
+	"context"
+	"errors"
+	"exc6/pkg/pagination"
+	"exc6/server/websocket"
+	"exc6/services/chat"
+	"exc6/services/search"
+	"exc6/services/summaries"
+	"exc6/tests/clients"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDirectChat(t *testing.T) {
+	baseURL := startServer(t)
+
+	alice := newUser(t, baseURL, "alice")
+	bob := newUser(t, baseURL, "bob")
+	carol := newUser(t, baseURL, "carol")
+
+	aliceWS := connect(t, alice)
+	bobWS := connect(t, bob)
+	carolWS := connect(t, carol)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	t.Run("HTTP send reaches both participants", func(t *testing.T) {
+		content := "hello over http " + t.Name()
+		require.NoError(t, alice.PostOK(ctx, "/chat/"+bob.Username, url.Values{"content": {content}}))
+
+		match := clients.All(clients.OfType(websocket.MessageTypeChat), clients.From(alice.Username), clients.WithContent(content))
+
+		msg, err := bobWS.Expect(match, expectTimeout)
+		require.NoError(t, err)
+		assert.Equal(t, bob.Username, msg.To)
+		assert.NotEmpty(t, msg.ID)
+
+		_, err = aliceWS.Expect(match, expectTimeout)
+		require.NoError(t, err, "sender's other tabs receive their own message")
+
+		assert.NoError(t, carolWS.ExpectNone(match, time.Second))
+	})
+
+	t.Run("Recipient accepts the message request of a non-friend", func(t *testing.T) {
+		var inbox struct {
+			Requests []chat.MessageRequest `json:"requests"`
+		}
+		require.NoError(t, bob.GetJSON(ctx, "/api/v1/message-requests", &inbox))
+		require.Len(t, inbox.Requests, 1)
+		assert.Equal(t, alice.Username, inbox.Requests[0].From)
+		assert.Equal(t, 1, inbox.Requests[0].Messages)
+
+		require.NoError(t, bob.PostOK(ctx, "/api/v1/message-requests/"+alice.Username+"/accept", nil))
+
+		require.NoError(t, bob.GetJSON(ctx, "/api/v1/message-requests", &inbox))
+		assert.Empty(t, inbox.Requests)
+	})
+
+	t.Run("WebSocket send reaches recipient", func(t *testing.T) {
+		content := "hello over websocket"
+		require.NoError(t, aliceWS.Send(&websocket.Message{
+			Type:    websocket.MessageTypeChat,
+			To:      bob.Username,
+			Content: content,
+		}))
+
+		msg, err := bobWS.Expect(clients.All(clients.From(alice.Username), clients.WithContent(content)), expectTimeout)
+		require.NoError(t, err)
+		assert.Equal(t, websocket.MessageTypeChat, msg.Type)
+	})
+
+	t.Run("Sender cannot be spoofed", func(t *testing.T) {
+		content := "spoofed sender"
+		require.NoError(t, aliceWS.Send(&websocket.Message{
+			Type:    websocket.MessageTypeChat,
+			From:    carol.Username,
+			To:      bob.Username,
+			Content: content,
+		}))
+
+		msg, err := bobWS.Expect(clients.WithContent(content), expectTimeout)
+		require.NoError(t, err)
+		assert.Equal(t, alice.Username, msg.From)
+	})
+
+	t.Run("Sender edits and deletes a message in place", func(t *testing.T) {
+		require.NoError(t, alice.PostOK(ctx, "/chat/"+bob.Username, url.Values{"content": {"before edit"}}))
+		sent, err := bobWS.Expect(clients.All(clients.OfType(websocket.MessageTypeChat), clients.WithContent("before edit")), expectTimeout)
+		require.NoError(t, err)
+
+		path := "/api/v1/chat/" + bob.Username + "/messages/" + sent.ID
+		require.NoError(t, alice.DoOK(ctx, http.MethodPatch, path, url.Values{"content": {"after edit"}}))
+
+		edit, err := bobWS.Expect(clients.All(clients.OfType(websocket.MessageTypeEdit), clients.WithID(sent.ID)), expectTimeout)
+		require.NoError(t, err)
+		assert.Equal(t, "after edit", edit.Content)
+
+		_, err = aliceWS.Expect(clients.All(clients.OfType(websocket.MessageTypeEdit), clients.WithID(sent.ID)), expectTimeout)
+		require.NoError(t, err, "sender's other tabs see the edit")
+
+		err = bob.DoOK(ctx, http.MethodPatch, "/api/v1/chat/"+alice.Username+"/messages/"+sent.ID, url.Values{"content": {"hijacked"}})
+		var statusErr *clients.StatusError
+		require.True(t, errors.As(err, &statusErr), "only the sender may edit: %v", err)
+		assert.Equal(t, http.StatusForbidden, statusErr.StatusCode)
+
+		require.NoError(t, alice.DoOK(ctx, http.MethodDelete, path, nil))
+
+		deleted, err := bobWS.Expect(clients.All(clients.OfType(websocket.MessageTypeDelete), clients.WithID(sent.ID)), expectTimeout)
+		require.NoError(t, err)
+		assert.Empty(t, deleted.Content)
+		assert.NoError(t, carolWS.ExpectNone(clients.WithID(sent.ID), time.Second))
+	})
+
+	t.Run("Reactions reach both participants", func(t *testing.T) {
+		require.NoError(t, alice.PostOK(ctx, "/chat/"+bob.Username, url.Values{"content": {"react to me"}}))
+		sent, err := bobWS.Expect(clients.All(clients.OfType(websocket.MessageTypeChat), clients.WithContent("react to me")), expectTimeout)
+		require.NoError(t, err)
+
+		path := "/chat/message/" + sent.ID + "/reactions"
+		var result struct {
+			Reactions []chat.Reaction `json:"reactions"`
+		}
+		require.NoError(t, bob.PostJSON(ctx, path, url.Values{"emoji": {"👍"}, "contact": {alice.Username}}, &result))
+		assert.Equal(t, []chat.Reaction{{Emoji: "👍", Users: []string{bob.Username}}}, result.Reactions)
+
+		reaction := clients.All(clients.OfType(websocket.MessageTypeReaction), clients.WithID(sent.ID))
+		msg, err := aliceWS.Expect(reaction, expectTimeout)
+		require.NoError(t, err)
+		assert.Equal(t, bob.Username, msg.From)
+		assert.Equal(t, "👍", msg.Content)
+		assert.NoError(t, carolWS.ExpectNone(reaction, time.Second))
+
+		err = carol.PostOK(ctx, path, url.Values{"emoji": {"👍"}, "contact": {alice.Username}})
+		var statusErr *clients.StatusError
+		require.True(t, errors.As(err, &statusErr), "outsiders cannot react: %v", err)
+		assert.Equal(t, http.StatusNotFound, statusErr.StatusCode)
+
+		require.NoError(t, bob.DoOK(ctx, http.MethodDelete, path, url.Values{"emoji": {"👍"}, "contact": {alice.Username}}))
+		_, err = aliceWS.Expect(reaction, expectTimeout)
+		require.NoError(t, err)
+
+		require.NoError(t, alice.GetJSON(ctx, path+"?contact="+bob.Username, &result))
+		assert.Empty(t, result.Reactions)
+	})
+
+	t.Run("Pins are shared by both participants", func(t *testing.T) {
+		require.NoError(t, alice.PostOK(ctx, "/chat/"+bob.Username, url.Values{"content": {"pin me"}}))
+		sent, err := bobWS.Expect(clients.All(clients.OfType(websocket.MessageTypeChat), clients.WithContent("pin me")), expectTimeout)
+		require.NoError(t, err)
+
+		path := "/api/v1/chat/" + alice.Username + "/pins/" + sent.ID
+		var result struct {
+			Pins []chat.Pin `json:"pins"`
+		}
+		require.NoError(t, bob.PostJSON(ctx, path, nil, &result))
+		require.Len(t, result.Pins, 1)
+		assert.Equal(t, sent.ID, result.Pins[0].Message.MessageID)
+		assert.Equal(t, bob.Username, result.Pins[0].PinnedBy)
+
+		pin := clients.All(clients.OfType(websocket.MessageTypePin), clients.WithID(sent.ID))
+		msg, err := aliceWS.Expect(pin, expectTimeout)
+		require.NoError(t, err)
+		assert.Equal(t, bob.Username, msg.From)
+		assert.NoError(t, carolWS.ExpectNone(pin, time.Second))
+
+		require.NoError(t, alice.GetJSON(ctx, "/api/v1/chat/"+bob.Username+"/pins", &result))
+		require.Len(t, result.Pins, 1)
+		assert.Equal(t, "pin me", result.Pins[0].Message.Content)
+
+		err = carol.PostOK(ctx, "/api/v1/chat/"+alice.Username+"/pins/"+sent.ID, nil)
+		var statusErr *clients.StatusError
+		require.True(t, errors.As(err, &statusErr), "outsiders cannot pin: %v", err)
+		assert.Equal(t, http.StatusNotFound, statusErr.StatusCode)
+
+		require.NoError(t, alice.DoOK(ctx, http.MethodDelete, "/api/v1/chat/"+bob.Username+"/pins/"+sent.ID, nil))
+		_, err = bobWS.Expect(pin, expectTimeout)
+		require.NoError(t, err)
+
+		require.NoError(t, bob.GetJSON(ctx, "/api/v1/chat/"+alice.Username+"/pins", &result))
+		assert.Empty(t, result.Pins)
+	})
+
+	t.Run("Summaries are marked as AI-generated and cached", func(t *testing.T) {
+		require.NoError(t, bob.PostOK(ctx, "/chat/"+alice.Username, url.Values{"content": {"summarize us"}}))
+		_, err := aliceWS.Expect(clients.All(clients.OfType(websocket.MessageTypeChat), clients.WithContent("summarize us")), expectTimeout)
+		require.NoError(t, err)
+
+		var summary summaries.Summary
+		require.NoError(t, alice.PostJSON(ctx, "/chat/"+bob.Username+"/summarize", nil, &summary))
+		assert.True(t, summary.AIGenerated)
+		assert.NotEmpty(t, summary.Notice)
+		assert.Contains(t, summary.Text, "the last from "+bob.Username)
+		assert.False(t, summary.Cached)
+
+		require.NoError(t, bob.PostJSON(ctx, "/chat/"+alice.Username+"/summarize", nil, &summary))
+		assert.True(t, summary.Cached, "the other participant gets the same summary until someone writes")
+	})
+
+	t.Run("Search finds messages and anchors into the history", func(t *testing.T) {
+		require.NoError(t, alice.PostOK(ctx, "/chat/"+bob.Username, url.Values{"content": {"where is the Needle now"}}))
+
+		// Messages reach Postgres through Kafka
+		searchCtx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		var results pagination.Page[chat.SearchResult]
+		require.Eventually(t, func() bool {
+			return bob.GetJSON(searchCtx, "/chat/"+alice.Username+"/search?q=needle", &results) == nil && len(results.Items) == 1
+		}, 15*time.Second, 250*time.Millisecond)
+
+		found := results.Items[0]
+		assert.Equal(t, []chat.Match{{Start: 13, End: 19}}, found.Matches)
+
+		var history pagination.Page[*chat.ChatMessage]
+		require.NoError(t, bob.GetJSON(searchCtx, "/api/v1/chat/"+alice.Username+"/history?cursor="+found.Anchor, &history))
+		require.NotEmpty(t, history.Items)
+		assert.Equal(t, found.Message.MessageID, history.Items[0].MessageID)
+	})
+
+	t.Run("Full-text search covers the requester's conversations only", func(t *testing.T) {
+		require.NoError(t, alice.PostOK(ctx, "/chat/"+bob.Username, url.Values{"content": {"the quick brown Foxes"}}))
+
+		searchCtx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		var results pagination.Page[search.Result]
+		require.Eventually(t, func() bool {
+			return bob.GetJSON(searchCtx, "/chat/search?q=foxes+quick", &results) == nil && len(results.Items) == 1
+		}, 15*time.Second, 250*time.Millisecond)
+
+		found := results.Items[0]
+		assert.Equal(t, alice.Username, found.Message.FromID)
+		assert.Equal(t, []chat.Match{{Start: 4, End: 9}, {Start: 16, End: 21}}, found.Matches)
+
+		require.NoError(t, bob.GetJSON(searchCtx, "/chat/search?q=foxes&with="+alice.Username, &results))
+		assert.Len(t, results.Items, 1)
+
+		require.NoError(t, bob.GetJSON(searchCtx, "/chat/search?q=foxes&with="+carol.Username, &results))
+		assert.Empty(t, results.Items, "with limits the search to one conversation")
+
+		require.NoError(t, carol.GetJSON(searchCtx, "/chat/search?q=foxes", &results))
+		assert.Empty(t, results.Items, "other people's conversations are not searched")
+	})
+
+	t.Run("Read receipts are coalesced per conversation", func(t *testing.T) {
+		for _, id := range []string{"r1", "r2", "r3"} {
+			require.NoError(t, aliceWS.Send(&websocket.Message{Type: websocket.MessageTypeRead, To: bob.Username, ID: id}))
+		}
+
+		receipt := clients.All(clients.OfType(websocket.MessageTypeRead), clients.From(alice.Username))
+
+		msg, err := bobWS.Expect(receipt, expectTimeout)
+		require.NoError(t, err)
+		assert.Equal(t, "r3", msg.ID, "only the newest position is forwarded")
+
+		assert.NoError(t, bobWS.ExpectNone(receipt, 2*time.Second))
+		assert.NoError(t, carolWS.ExpectNone(receipt, time.Second))
+	})
+
+	t.Run("Typing events reach the recipient once per window", func(t *testing.T) {
+		for i := 0; i < 2; i++ {
+			require.NoError(t, aliceWS.Send(&websocket.Message{Type: websocket.MessageTypeTyping, To: bob.Username}))
+		}
+
+		typing := clients.All(clients.OfType(websocket.MessageTypeTyping), clients.From(alice.Username))
+
+		_, err := bobWS.Expect(typing, expectTimeout)
+		require.NoError(t, err)
+		assert.NoError(t, bobWS.ExpectNone(typing, time.Second), "repeats are suppressed")
+		assert.NoError(t, aliceWS.ExpectNone(typing, time.Second), "typists do not see themselves")
+		assert.NoError(t, carolWS.ExpectNone(typing, time.Second))
+	})
+
+	t.Run("Activity states are coalesced and expire", func(t *testing.T) {
+		for i := 0; i < 5; i++ {
+			require.NoError(t, aliceWS.Send(&websocket.Message{Type: websocket.MessageTypeActivity, To: bob.Username, Content: websocket.ActivityTyping}))
+		}
+
+		activity := clients.All(clients.OfType(websocket.MessageTypeActivity), clients.From(alice.Username))
+
+		msg, err := bobWS.Expect(activity, expectTimeout)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]any{alice.Username: websocket.ActivityTyping}, msg.Data["states"])
+
+		assert.NoError(t, bobWS.ExpectNone(activity, time.Second), "repeats are not forwarded")
+		assert.NoError(t, carolWS.ExpectNone(activity, time.Second))
+
+		// Without a repeat the state expires
+		msg, err = bobWS.Expect(activity, 10*time.Second)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]any{alice.Username: websocket.ActivityIdle}, msg.Data["states"])
+	})
+}
+
+func TestMessageRequests(t *testing.T) {
+	baseURL := startServer(t)
+
+	alice := newUser(t, baseURL, "alice")
+	bob := newUser(t, baseURL, "bob")
+	carol := newUser(t, baseURL, "carol")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var inbox struct {
+		Requests []chat.MessageRequest `json:"requests"`
+	}
+
+	t.Run("Non-friends are limited until the recipient replies", func(t *testing.T) {
+		for i := 0; i < chat.DefaultRequestLimit; i++ {
+			require.NoError(t, alice.PostOK(ctx, "/chat/"+bob.Username, url.Values{"content": {"hi bob"}}))
+		}
+
+		err := alice.PostOK(ctx, "/chat/"+bob.Username, url.Values{"content": {"one too many"}})
+		var statusErr *clients.StatusError
+		require.True(t, errors.As(err, &statusErr), "the request is full: %v", err)
+		assert.Equal(t, http.StatusForbidden, statusErr.StatusCode)
+
+		require.NoError(t, bob.GetJSON(ctx, "/api/v1/message-requests", &inbox))
+		require.Len(t, inbox.Requests, 1)
+		assert.Equal(t, alice.Username, inbox.Requests[0].From)
+		assert.Equal(t, chat.DefaultRequestLimit, inbox.Requests[0].Messages)
+		assert.Equal(t, "hi bob", inbox.Requests[0].Preview)
+
+		require.NoError(t, bob.PostOK(ctx, "/chat/"+alice.Username, url.Values{"content": {"hi alice"}}))
+		require.NoError(t, alice.PostOK(ctx, "/chat/"+bob.Username, url.Values{"content": {"thanks for replying"}}))
+
+		require.NoError(t, bob.GetJSON(ctx, "/api/v1/message-requests", &inbox))
+		assert.Empty(t, inbox.Requests, "replying accepts the request")
+	})
+
+	t.Run("Declined requests admit nothing more", func(t *testing.T) {
+		require.NoError(t, carol.PostOK(ctx, "/chat/"+bob.Username, url.Values{"content": {"hi from carol"}}))
+		require.NoError(t, bob.PostOK(ctx, "/api/v1/message-requests/"+carol.Username+"/decline", nil))
+
+		err := carol.PostOK(ctx, "/chat/"+bob.Username, url.Values{"content": {"hello?"}})
+		var statusErr *clients.StatusError
+		require.True(t, errors.As(err, &statusErr), "declined: %v", err)
+		assert.Equal(t, http.StatusForbidden, statusErr.StatusCode)
+
+		require.NoError(t, bob.GetJSON(ctx, "/api/v1/message-requests", &inbox))
+		assert.Empty(t, inbox.Requests)
+	})
+
+	t.Run("Answering needs a request", func(t *testing.T) {
+		err := bob.PostOK(ctx, "/api/v1/message-requests/"+carol.Username+"x/accept", nil)
+		var statusErr *clients.StatusError
+		require.True(t, errors.As(err, &statusErr), "no such request: %v", err)
+		assert.Equal(t, http.StatusNotFound, statusErr.StatusCode)
+	})
+}
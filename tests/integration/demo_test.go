@@ -0,0 +1,78 @@
+package integration
+
+import (
+	"context"
+	"database/sql"
+	"exc6/config"
+	"exc6/db"
+	"exc6/services/chat"
+	"exc6/services/demo"
+	"exc6/services/friends"
+	"exc6/services/groups"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestDemoSeedCompletes seeds the demo dataset, takes parts of it away as a
+// run that failed halfway would have left it, and checks that seeding again
+// puts back only those parts
+func TestDemoSeedCompletes(t *testing.T) {
+	cfg, err := config.Load()
+	require.NoError(t, err)
+
+	dbConn, err := sql.Open("postgres", dbString)
+	require.NoError(t, err)
+	t.Cleanup(func() { dbConn.Close() })
+	qdb := db.New(dbConn)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	chatSvc, err := chat.NewChatService(ctx, newRedis(t), qdb, cfg.Kafka)
+	require.NoError(t, err)
+	t.Cleanup(func() { chatSvc.Close() })
+
+	friendSvc := friends.NewFriendService(qdb)
+	friendSvc.SetConversations(dbConn, chatSvc)
+	groupSvc := groups.NewGroupService(qdb)
+	seeder := demo.NewSeeder(qdb, chatSvc, friendSvc, groupSvc)
+
+	// The dataset may be there from an earlier run
+	_, err = seeder.Seed(ctx)
+	require.NoError(t, err)
+
+	summary, err := seeder.Seed(ctx)
+	require.NoError(t, err)
+	assert.True(t, summary.Skipped, "a complete dataset is left alone")
+	assert.Zero(t, summary.Users+summary.Friendships+summary.Groups+summary.Messages)
+
+	require.NoError(t, friendSvc.RemoveFriend(ctx, "alice", "newsbot"))
+
+	joined, err := groupSvc.GetUserGroups(ctx, "alice")
+	require.NoError(t, err)
+	var hikers string
+	for _, info := range joined {
+		if info.Name == "Weekend Hikers" {
+			hikers = info.ID
+		}
+	}
+	require.NotEmpty(t, hikers)
+	require.NoError(t, groupSvc.RemoveMember(ctx, hikers, "alice", "dave"))
+
+	summary, err = seeder.Seed(ctx)
+	require.NoError(t, err)
+	assert.False(t, summary.Skipped)
+	assert.Equal(t, 1, summary.Friendships, "only the missing friendship")
+	assert.Zero(t, summary.Users)
+	assert.Zero(t, summary.Groups, "the existing group is reused")
+	assert.Zero(t, summary.Messages, "scripted messages are not repeated")
+
+	friends, err := qdb.AreFriends(ctx, db.AreFriendsParams{User1: "alice", User2: "newsbot"})
+	require.NoError(t, err)
+	assert.True(t, friends)
+	member, err := groupSvc.IsMember(ctx, hikers, "dave")
+	require.NoError(t, err)
+	assert.True(t, member)
+}
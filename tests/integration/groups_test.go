@@ -0,0 +1,327 @@
+package integration
+
+import (
+	"context"
+	"exc6/config"
+	infraredis "exc6/infrastructure/redis"
+	"exc6/pkg/pagination"
+	"exc6/server/websocket"
+	"exc6/services/groups"
+	"exc6/tests/clients"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGroupChat(t *testing.T) {
+	baseURL := startServer(t)
+
+	alice := newUser(t, baseURL, "alice")
+	bob := newUser(t, baseURL, "bob")
+	carol := newUser(t, baseURL, "carol")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 45*time.Second)
+	defer cancel()
+
+	name := fmt.Sprintf("Integration %d", time.Now().UnixNano()%1e6)
+	require.NoError(t, alice.PostOK(ctx, "/groups/create", url.Values{"name": {name}}))
+
+	var page pagination.Page[groups.GroupInfo]
+	require.NoError(t, alice.GetJSON(ctx, "/api/v1/groups", &page))
+	require.Len(t, page.Items, 1)
+	groupID := page.Items[0].ID
+
+	require.NoError(t, alice.PostOK(ctx, "/groups/"+groupID+"/members", url.Values{"username": {bob.Username}}))
+
+	aliceWS := connect(t, alice)
+	bobWS := connect(t, bob)
+	carolWS := connect(t, carol)
+
+	t.Run("Members receive group messages", func(t *testing.T) {
+		content := "hello group"
+		require.NoError(t, alice.PostOK(ctx, "/groups/"+groupID+"/send", url.Values{"content": {content}}))
+
+		match := clients.All(
+			clients.OfType(websocket.MessageTypeGroupChat),
+			clients.InGroup(groupID),
+			clients.From(alice.Username),
+			clients.WithContent(content),
+		)
+
+		_, err := bobWS.Expect(match, expectTimeout)
+		require.NoError(t, err)
+
+		_, err = aliceWS.Expect(match, expectTimeout)
+		require.NoError(t, err)
+
+		assert.NoError(t, carolWS.ExpectNone(clients.InGroup(groupID), time.Second), "non-members see nothing")
+	})
+
+	t.Run("Members added while connected start receiving", func(t *testing.T) {
+		require.NoError(t, alice.PostOK(ctx, "/groups/"+groupID+"/members", url.Values{"username": {carol.Username}}))
+
+		// Membership events resubscribe the open connection
+		content := "welcome carol"
+		require.Eventually(t, func() bool {
+			if err := alice.PostOK(ctx, "/groups/"+groupID+"/send", url.Values{"content": {content}}); err != nil {
+				return false
+			}
+			_, err := carolWS.Expect(clients.All(clients.InGroup(groupID), clients.WithContent(content)), time.Second)
+			return err == nil
+		}, 10*time.Second, 100*time.Millisecond)
+	})
+
+	t.Run("History outlives the cache", func(t *testing.T) {
+		content := "archived group message"
+		require.NoError(t, alice.PostOK(ctx, "/groups/"+groupID+"/send", url.Values{"content": {content}}))
+
+		cfg, err := config.Load()
+		require.NoError(t, err)
+		rdb, err := infraredis.NewClient(cfg.Redis)
+		require.NoError(t, err)
+		defer rdb.Close()
+
+		// Group messages reach Postgres through Kafka; drop the cache until
+		// the history comes back without it
+		require.Eventually(t, func() bool {
+			if err := rdb.Del(ctx, "chat:group:"+groupID+":messages").Err(); err != nil {
+				return false
+			}
+			resp, err := alice.Do(ctx, "GET", "/groups/"+groupID+"/chat", nil)
+			if err != nil {
+				return false
+			}
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			return err == nil && strings.Contains(string(body), content)
+		}, 20*time.Second, 500*time.Millisecond)
+	})
+
+	t.Run("Removed members stop receiving", func(t *testing.T) {
+		resp, err := alice.Do(ctx, "DELETE", "/groups/"+groupID+"/members/"+bob.Username, nil)
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Less(t, resp.StatusCode, 300)
+
+		// Give the membership event time to unsubscribe the connection
+		time.Sleep(500 * time.Millisecond)
+
+		content := "bob is gone"
+		require.NoError(t, alice.PostOK(ctx, "/groups/"+groupID+"/send", url.Values{"content": {content}}))
+
+		_, err = carolWS.Expect(clients.WithContent(content), expectTimeout)
+		require.NoError(t, err)
+		assert.NoError(t, bobWS.ExpectNone(clients.WithContent(content), time.Second))
+	})
+}
+
+func TestGroupRoles(t *testing.T) {
+	baseURL := startServer(t)
+
+	alice := newUser(t, baseURL, "alice")
+	bob := newUser(t, baseURL, "bob")
+	carol := newUser(t, baseURL, "carol")
+	dave := newUser(t, baseURL, "dave")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	name := fmt.Sprintf("Roles %d", time.Now().UnixNano()%1e6)
+	require.NoError(t, alice.PostOK(ctx, "/groups/create", url.Values{"name": {name}}))
+
+	var page pagination.Page[groups.GroupInfo]
+	require.NoError(t, alice.GetJSON(ctx, "/api/v1/groups", &page))
+	require.Len(t, page.Items, 1)
+	group := page.Items[0]
+	assert.Equal(t, groups.RoleOwner, group.UserRole, "the creator owns the group")
+
+	for _, member := range []string{bob.Username, carol.Username, dave.Username} {
+		require.NoError(t, alice.PostOK(ctx, "/groups/"+group.ID+"/members", url.Values{"username": {member}}))
+	}
+
+	setRole := func(s *clients.Session, member, role string) int {
+		resp, err := s.Do(ctx, "PUT", "/groups/"+group.ID+"/members/"+member+"/role", url.Values{"role": {role}})
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	t.Run("Owner promotes members", func(t *testing.T) {
+		assert.Less(t, setRole(alice, bob.Username, groups.RoleAdmin), 300)
+		assert.Less(t, setRole(alice, carol.Username, groups.RoleModerator), 300)
+		assert.Equal(t, 400, setRole(alice, dave.Username, "superuser"))
+	})
+
+	t.Run("Admins manage only lower roles", func(t *testing.T) {
+		assert.Less(t, setRole(bob, dave.Username, groups.RoleModerator), 300)
+		assert.Less(t, setRole(bob, dave.Username, groups.RoleMember), 300)
+		assert.Equal(t, 403, setRole(bob, dave.Username, groups.RoleAdmin), "only the owner promotes admins")
+		assert.Equal(t, 403, setRole(bob, alice.Username, groups.RoleMember))
+	})
+
+	t.Run("Moderators remove members but not admins", func(t *testing.T) {
+		resp, err := carol.Do(ctx, "DELETE", "/groups/"+group.ID+"/members/"+bob.Username, nil)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, 403, resp.StatusCode)
+
+		require.NoError(t, carol.DoOK(ctx, "DELETE", "/groups/"+group.ID+"/members/"+dave.Username, nil))
+	})
+
+	t.Run("Only the owner deletes the group", func(t *testing.T) {
+		resp, err := bob.Do(ctx, "DELETE", "/groups/"+group.ID, nil)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, 403, resp.StatusCode)
+	})
+
+	t.Run("Owner transfers ownership", func(t *testing.T) {
+		assert.Less(t, setRole(alice, bob.Username, groups.RoleOwner), 300)
+
+		require.NoError(t, alice.GetJSON(ctx, "/api/v1/groups", &page))
+		require.Len(t, page.Items, 1)
+		assert.Equal(t, groups.RoleAdmin, page.Items[0].UserRole, "the previous owner stays an admin")
+
+		assert.Equal(t, 403, setRole(alice, bob.Username, groups.RoleMember))
+		require.NoError(t, bob.DoOK(ctx, "DELETE", "/groups/"+group.ID, nil))
+	})
+}
+
+func TestGroupInvites(t *testing.T) {
+	baseURL := startServer(t)
+
+	alice := newUser(t, baseURL, "alice")
+	bob := newUser(t, baseURL, "bob")
+	carol := newUser(t, baseURL, "carol")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	name := fmt.Sprintf("Invites %d", time.Now().UnixNano()%1e6)
+	require.NoError(t, alice.PostOK(ctx, "/groups/create", url.Values{"name": {name}}))
+
+	var page pagination.Page[groups.GroupInfo]
+	require.NoError(t, alice.GetJSON(ctx, "/api/v1/groups", &page))
+	require.Len(t, page.Items, 1)
+	groupID := page.Items[0].ID
+
+	bobWS := connect(t, bob)
+
+	type invites struct {
+		Invites []groups.Invite `json:"invites"`
+	}
+
+	t.Run("Invitee is notified", func(t *testing.T) {
+		require.NoError(t, alice.PostOK(ctx, "/groups/"+groupID+"/invites", url.Values{"username": {bob.Username}}))
+		require.NoError(t, alice.PostOK(ctx, "/groups/"+groupID+"/invites", url.Values{"username": {carol.Username}}))
+
+		msg, err := bobWS.Expect(clients.All(clients.OfType(websocket.MessageTypeNotification), clients.From(alice.Username)), expectTimeout)
+		require.NoError(t, err)
+		assert.Equal(t, groupID, msg.GroupID)
+
+		var pending invites
+		require.NoError(t, bob.GetJSON(ctx, "/api/v1/invites", &pending))
+		require.Len(t, pending.Invites, 1)
+		assert.Equal(t, name, pending.Invites[0].GroupName)
+		assert.Equal(t, alice.Username, pending.Invites[0].From)
+
+		require.NoError(t, alice.GetJSON(ctx, "/groups/"+groupID+"/invites", &pending))
+		assert.Len(t, pending.Invites, 2)
+	})
+
+	t.Run("Invitations are not repeated", func(t *testing.T) {
+		resp, err := alice.Do(ctx, "POST", "/groups/"+groupID+"/invites", url.Values{"username": {bob.Username}})
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, 400, resp.StatusCode)
+	})
+
+	t.Run("Only admins invite", func(t *testing.T) {
+		resp, err := carol.Do(ctx, "POST", "/groups/"+groupID+"/invites", url.Values{"username": {bob.Username}})
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, 403, resp.StatusCode)
+	})
+
+	t.Run("Accepting joins the group", func(t *testing.T) {
+		require.NoError(t, bob.PostOK(ctx, "/groups/"+groupID+"/invites/accept", nil))
+
+		require.NoError(t, bob.GetJSON(ctx, "/api/v1/groups", &page))
+		require.Len(t, page.Items, 1)
+		assert.Equal(t, groupID, page.Items[0].ID)
+		assert.Equal(t, groups.RoleMember, page.Items[0].UserRole)
+	})
+
+	t.Run("Declining drops the invitation", func(t *testing.T) {
+		require.NoError(t, carol.PostOK(ctx, "/groups/"+groupID+"/invites/decline", nil))
+
+		require.NoError(t, carol.GetJSON(ctx, "/api/v1/groups", &page))
+		assert.Empty(t, page.Items)
+
+		resp, err := carol.Do(ctx, "POST", "/groups/"+groupID+"/invites/accept", nil)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, 404, resp.StatusCode)
+	})
+}
+
+func TestGroupDM(t *testing.T) {
+	baseURL := startServer(t)
+
+	alice := newUser(t, baseURL, "alice")
+	bob := newUser(t, baseURL, "bob")
+	carol := newUser(t, baseURL, "carol")
+	dave := newUser(t, baseURL, "dave")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	var dm groups.GroupInfo
+	require.NoError(t, alice.PostJSON(ctx, "/api/v1/dms", url.Values{"participants": {bob.Username, carol.Username}}, &dm))
+	assert.Equal(t, groups.KindDM, dm.Kind)
+	assert.Equal(t, 3, dm.MemberCount)
+
+	carolWS := connect(t, carol)
+
+	t.Run("Participants receive messages", func(t *testing.T) {
+		content := "hello everyone"
+		require.NoError(t, bob.PostOK(ctx, "/groups/"+dm.ID+"/send", url.Values{"content": {content}}))
+
+		_, err := carolWS.Expect(clients.All(clients.InGroup(dm.ID), clients.From(bob.Username), clients.WithContent(content)), expectTimeout)
+		require.NoError(t, err)
+	})
+
+	t.Run("Any participant adds people", func(t *testing.T) {
+		require.NoError(t, bob.PostOK(ctx, "/groups/"+dm.ID+"/members", url.Values{"username": {dave.Username}}))
+
+		var page pagination.Page[groups.GroupInfo]
+		require.NoError(t, dave.GetJSON(ctx, "/api/v1/groups", &page))
+		require.Len(t, page.Items, 1)
+		assert.Equal(t, groups.KindDM, page.Items[0].Kind)
+		assert.Contains(t, page.Items[0].Name, alice.Username, "named after the other participants")
+	})
+
+	t.Run("Participants cannot remove others", func(t *testing.T) {
+		resp, err := alice.Do(ctx, "DELETE", "/groups/"+dm.ID+"/members/"+bob.Username, nil)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.GreaterOrEqual(t, resp.StatusCode, 400)
+	})
+
+	t.Run("Converts into a group", func(t *testing.T) {
+		var group groups.GroupInfo
+		require.NoError(t, carol.PostJSON(ctx, "/api/v1/dms/"+dm.ID+"/convert", url.Values{"name": {"Book club"}}, &group))
+		assert.Equal(t, dm.ID, group.ID, "the conversation and its history stay")
+		assert.Equal(t, groups.KindGroup, group.Kind)
+		assert.Equal(t, groups.RoleOwner, group.UserRole)
+
+		// Now carol administers it like any group
+		require.NoError(t, carol.DoOK(ctx, "DELETE", "/groups/"+dm.ID+"/members/"+dave.Username, nil))
+	})
+}
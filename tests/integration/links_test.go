@@ -0,0 +1,137 @@
+package integration
+
+import (
+	"context"
+	"database/sql"
+	"exc6/db"
+	"exc6/services/groups"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestInviteLinkUses runs joins by link against real Redis, where the uses of
+// a link are counted by a script
+func TestInviteLinkUses(t *testing.T) {
+	dbConn, err := sql.Open("postgres", dbString)
+	require.NoError(t, err)
+	t.Cleanup(func() { dbConn.Close() })
+	qdb := db.New(dbConn)
+
+	rdb := newRedis(t)
+	gs := groups.NewGroupService(qdb)
+	gs.SetEventPublisher(rdb)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	suffix := time.Now().UnixNano() % 1e9
+	newUser := func(name string) string {
+		username := fmt.Sprintf("%s%d", name, suffix)
+		_, err := qdb.CreateUser(ctx, db.CreateUserParams{Username: username, PasswordHash: "hash"})
+		require.NoError(t, err)
+		return username
+	}
+	owner := newUser("owner")
+	group, err := gs.CreateGroup(ctx, owner, fmt.Sprintf("Links %d", suffix), "", "")
+	require.NoError(t, err)
+
+	remaining := func(linkID string) int {
+		links, err := gs.InviteLinks(ctx, group.ID, owner)
+		require.NoError(t, err)
+		for _, link := range links {
+			if link.ID == linkID {
+				return link.Remaining
+			}
+		}
+		return 0
+	}
+
+	t.Run("Uses are counted down and the link goes at zero", func(t *testing.T) {
+		link, err := gs.CreateInviteLink(ctx, group.ID, owner, 2, time.Hour)
+		require.NoError(t, err)
+
+		first := newUser("first")
+		joined, err := gs.JoinByLink(ctx, link.Token, first)
+		require.NoError(t, err)
+		assert.Equal(t, group.ID, joined)
+		assert.Equal(t, 1, remaining(link.ID))
+
+		_, err = gs.JoinByLink(ctx, link.Token, first)
+		require.NoError(t, err)
+		assert.Equal(t, 1, remaining(link.ID), "a member joining again spends nothing")
+
+		_, err = gs.JoinByLink(ctx, link.Token, newUser("second"))
+		require.NoError(t, err)
+		assert.Zero(t, rdb.Exists(ctx, "groups:link:"+link.ID).Val(), "the used up link is deleted")
+		assert.False(t, rdb.SIsMember(ctx, "groups:links:"+group.ID, link.ID).Val())
+
+		_, err = gs.JoinByLink(ctx, link.Token, newUser("third"))
+		assert.ErrorIs(t, err, groups.ErrInviteLinkUnavailable)
+	})
+
+	t.Run("Concurrent joins spend a use each", func(t *testing.T) {
+		link, err := gs.CreateInviteLink(ctx, group.ID, owner, 1, time.Hour)
+		require.NoError(t, err)
+
+		users := make([]string, 8)
+		for i := range users {
+			users[i] = newUser(fmt.Sprintf("racer%d_", i))
+		}
+
+		errs := make([]error, len(users))
+		var wg sync.WaitGroup
+		for i, username := range users {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, errs[i] = gs.JoinByLink(ctx, link.Token, username)
+			}()
+		}
+		wg.Wait()
+
+		succeeded := 0
+		for _, err := range errs {
+			if err == nil {
+				succeeded++
+				continue
+			}
+			assert.ErrorIs(t, err, groups.ErrInviteLinkUnavailable)
+		}
+		assert.Equal(t, 1, succeeded, "a single use admits a single user")
+	})
+
+	t.Run("Revoking races joins", func(t *testing.T) {
+		link, err := gs.CreateInviteLink(ctx, group.ID, owner, 0, time.Hour)
+		require.NoError(t, err)
+
+		users := make([]string, 8)
+		for i := range users {
+			users[i] = newUser(fmt.Sprintf("late%d_", i))
+		}
+
+		errs := make([]error, len(users))
+		var wg sync.WaitGroup
+		for i, username := range users {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, errs[i] = gs.JoinByLink(ctx, link.Token, username)
+			}()
+		}
+		require.NoError(t, gs.RevokeInviteLink(ctx, group.ID, owner, link.ID))
+		wg.Wait()
+
+		for _, err := range errs {
+			if err != nil {
+				assert.ErrorIs(t, err, groups.ErrInviteLinkUnavailable)
+			}
+		}
+		_, err = gs.JoinByLink(ctx, link.Token, newUser("after"))
+		assert.ErrorIs(t, err, groups.ErrInviteLinkUnavailable, "revoked")
+	})
+}
@@ -0,0 +1,53 @@
+package integration
+
+import (
+	"context"
+	"exc6/tests/clients"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNotificationStream(t *testing.T) {
+	baseURL := startServer(t)
+
+	alice := newUser(t, baseURL, "alice")
+	bob := newUser(t, baseURL, "bob")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	requests, err := bob.DialSSE(ctx, "/sse/notifications?types=friend_request")
+	require.NoError(t, err)
+	defer requests.Close()
+
+	calls, err := bob.DialSSE(ctx, "/sse/notifications?types=call")
+	require.NoError(t, err)
+	defer calls.Close()
+
+	require.NoError(t, alice.PostOK(ctx, "/friends/request/"+bob.Username, nil))
+
+	ev, err := requests.Expect(clients.EventNamed("friend_request"), expectTimeout)
+	require.NoError(t, err)
+	assert.Contains(t, ev.Data, alice.Username)
+	assert.NotEmpty(t, ev.ID)
+
+	assert.NoError(t, calls.ExpectNone(clients.EventNamed("friend_request"), time.Second), "filtered out by type")
+
+	t.Run("Reconnect replays missed events", func(t *testing.T) {
+		replay, err := bob.DialSSE(ctx, "/sse/notifications?last_event_id=0-1")
+		require.NoError(t, err)
+		defer replay.Close()
+
+		ev, err := replay.Expect(clients.EventNamed("friend_request"), expectTimeout)
+		require.NoError(t, err)
+		assert.Contains(t, ev.Data, alice.Username)
+	})
+
+	t.Run("Unknown type is rejected", func(t *testing.T) {
+		_, err := bob.DialSSE(ctx, "/sse/notifications?types=nonsense")
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,103 @@
+package integration
+
+import (
+	"context"
+	"exc6/config"
+	infraredis "exc6/infrastructure/redis"
+	"exc6/pkg/keyspace"
+	"exc6/pkg/pagination"
+	"exc6/server/handlers"
+	"exc6/services/activity"
+	"exc6/tests/clients"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestRedisKeysExpire fails when the app writes a Redis key that belongs to
+// no registered family, or has no TTL without a documented exemption
+func TestRedisKeysExpire(t *testing.T) {
+	baseURL := startServer(t)
+
+	alice := newUser(t, baseURL, "alice")
+	bob := newUser(t, baseURL, "bob")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// Sessions, CSRF tokens and rate limit buckets exist already; a message
+	// adds the conversation cache and unread counters
+	require.NoError(t, alice.PostOK(ctx, "/chat/"+bob.Username, url.Values{"content": {"ttl audit"}}))
+	connect(t, bob)
+
+	cfg, err := config.Load()
+	require.NoError(t, err)
+	rdb, err := infraredis.NewClient(cfg.Redis)
+	require.NoError(t, err)
+	defer rdb.Close()
+
+	report, err := keyspace.Audit(ctx, rdb, keyspace.Options{SampleSize: 10})
+	require.NoError(t, err)
+
+	for _, s := range report.Families {
+		assert.Zero(t, s.Violations(), "%s keys without TTL, e.g. %v", s.Prefix, s.Examples)
+	}
+	assert.Zero(t, report.Unregistered.Keys, "unregistered keys, e.g. %v", report.Unregistered.Examples)
+}
+
+// TestMessageBookkeeping checks that accepting a message lists the
+// conversation as unread and updates contact list activity, including after
+// Redis lost its scripts
+func TestMessageBookkeeping(t *testing.T) {
+	baseURL := startServer(t)
+
+	alice := newUser(t, baseURL, "alice")
+	bob := newUser(t, baseURL, "bob")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	cfg, err := config.Load()
+	require.NoError(t, err)
+	rdb, err := infraredis.NewClient(cfg.Redis)
+	require.NoError(t, err)
+	defer rdb.Close()
+
+	require.NoError(t, alice.PostOK(ctx, "/chat/"+bob.Username, url.Values{"content": {"first"}}))
+
+	assert.Equal(t, 1, unreadFrom(ctx, t, bob, alice.Username))
+
+	for _, username := range []string{alice.Username, bob.Username} {
+		count, err := rdb.ZCard(ctx, activity.UserKey(username)).Result()
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), count, "activity recorded for %s", username)
+	}
+
+	// As after a restart or failover: the next message reloads the script
+	require.NoError(t, rdb.ScriptFlush(ctx).Err())
+	require.NoError(t, alice.PostOK(ctx, "/chat/"+bob.Username, url.Values{"content": {"second"}}))
+
+	assert.Equal(t, 2, unreadFrom(ctx, t, bob, alice.Username))
+
+	// Reading the conversation clears it
+	require.NoError(t, bob.PostOK(ctx, "/notifications/mark-read", nil))
+	assert.Zero(t, unreadFrom(ctx, t, bob, alice.Username))
+}
+
+// unreadFrom returns the number of unread messages session has from sender,
+// as listed in its notifications
+func unreadFrom(ctx context.Context, t *testing.T, session *clients.Session, sender string) int {
+	t.Helper()
+
+	var page pagination.Page[handlers.Notification]
+	require.NoError(t, session.GetJSON(ctx, "/api/v1/notifications", &page))
+	for _, n := range page.Items {
+		if n.Type == "unread_messages" && n.From == sender {
+			return n.Count
+		}
+	}
+	return 0
+}
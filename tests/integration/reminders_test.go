@@ -0,0 +1,116 @@
+package integration
+
+import (
+	"context"
+	"errors"
+	"exc6/apperrors"
+	"exc6/config"
+	infraredis "exc6/infrastructure/redis"
+	"exc6/services/chat"
+	"exc6/services/reminders"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// flakyChat fails the first deliveries it is asked for
+type flakyChat struct {
+	chat.Service
+	failures atomic.Int32
+	sent     atomic.Int32
+}
+
+func (f *flakyChat) SendMessage(ctx context.Context, from, to, content string, opts ...chat.SendOption) (*chat.ChatMessage, error) {
+	if f.failures.Add(-1) >= 0 {
+		return nil, errors.New("chat unavailable")
+	}
+	f.sent.Add(1)
+	return &chat.ChatMessage{}, nil
+}
+
+func newRedis(t *testing.T) *redis.Client {
+	t.Helper()
+
+	cfg, err := config.Load()
+	require.NoError(t, err)
+	rdb, err := infraredis.NewClient(cfg.Redis)
+	require.NoError(t, err)
+	t.Cleanup(func() { rdb.Close() })
+	return rdb
+}
+
+// TestReminderRetry checks that a reminder whose delivery failed stays
+// pending and is retried later instead of being lost
+func TestReminderRetry(t *testing.T) {
+	rdb := newRedis(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	flaky := &flakyChat{}
+	flaky.failures.Store(1)
+	rs := reminders.NewReminderService(ctx, rdb, flaky)
+
+	username := fmt.Sprintf("it_reminded_%d", time.Now().UnixNano()%1e9)
+	reminder, err := rs.Create(ctx, username, "stand up", time.Second, 0)
+	require.NoError(t, err)
+	t.Cleanup(func() { rs.Cancel(context.Background(), username, reminder.ID) })
+
+	// The scheduler polls every few seconds
+	require.Eventually(t, func() bool {
+		pending, err := rs.List(ctx, username)
+		return err == nil && len(pending) == 1 && pending[0].Attempts == 1
+	}, 15*time.Second, 200*time.Millisecond, "the failed delivery is counted and the reminder kept")
+
+	due, err := rdb.ZScore(ctx, "reminders:due", reminder.ID).Result()
+	require.NoError(t, err)
+	assert.Greater(t, due, float64(time.Now().Add(20*time.Second).Unix()), "retried after a backoff")
+	assert.Zero(t, flaky.sent.Load())
+}
+
+// TestReminderLimit creates reminders concurrently; only MaxPerUser of them
+// may be stored
+func TestReminderLimit(t *testing.T) {
+	rdb := newRedis(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rs := reminders.NewReminderService(ctx, rdb, &flakyChat{})
+	username := fmt.Sprintf("it_busy_%d", time.Now().UnixNano()%1e9)
+
+	var created, limited atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < reminders.MaxPerUser+20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			reminder, err := rs.Create(ctx, username, "later", time.Hour, 0)
+			var appErr *apperrors.AppError
+			switch {
+			case err == nil:
+				created.Add(1)
+				t.Cleanup(func() { rs.Cancel(context.Background(), username, reminder.ID) })
+			case errors.As(err, &appErr) && appErr.StatusCode == http.StatusTooManyRequests:
+				limited.Add(1)
+			default:
+				t.Errorf("create: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(reminders.MaxPerUser), created.Load())
+	assert.Equal(t, int32(20), limited.Load())
+
+	pending, err := rs.List(ctx, username)
+	require.NoError(t, err)
+	assert.Len(t, pending, reminders.MaxPerUser)
+}
@@ -0,0 +1,239 @@
+package integration
+
+import (
+	"context"
+	"database/sql"
+	"exc6/config"
+	"exc6/db"
+	infraredis "exc6/infrastructure/redis"
+	"exc6/infrastructure/storage"
+	"exc6/pkg/cache"
+	"exc6/pkg/httpclient"
+	"exc6/server"
+	"exc6/server/handlers"
+	"exc6/server/middleware/canary"
+	"exc6/server/sse"
+	_websocket "exc6/server/websocket"
+	"exc6/services/activity"
+	"exc6/services/calls"
+	"exc6/services/chat"
+	"exc6/services/clientlogs"
+	"exc6/services/cluster"
+	"exc6/services/compliance"
+	"exc6/services/digests"
+	"exc6/services/directory"
+	"exc6/services/emoji"
+	"exc6/services/experiments"
+	"exc6/services/export"
+	"exc6/services/friends"
+	"exc6/services/gifs"
+	"exc6/services/groups"
+	"exc6/services/impersonation"
+	"exc6/services/maintenance"
+	"exc6/services/moderation"
+	"exc6/services/notifications"
+	"exc6/services/presence"
+	"exc6/services/profiles"
+	"exc6/services/reminders"
+	"exc6/services/search"
+	"exc6/services/sessions"
+	"exc6/services/status"
+	"exc6/services/summaries"
+	"exc6/services/uploads"
+	"exc6/services/users"
+	"exc6/tests/clients"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	password = "TestPass123!"
+
+	// expectTimeout bounds how long a client waits for a live message
+	expectTimeout = 5 * time.Second
+
+	// testTURNSecret is shared with the TURN server the calls are given
+	testTURNSecret = "integration-turn-secret"
+)
+
+// startServer runs the full application on a random local port and returns its base URL
+func startServer(t *testing.T) string {
+	t.Helper()
+
+	cfg, err := config.Load()
+	require.NoError(t, err, "Failed to load test config")
+
+	dbConn, err := sql.Open("postgres", dbString)
+	require.NoError(t, err, "Failed to open database connection")
+	t.Cleanup(func() { dbConn.Close() })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	require.NoError(t, dbConn.PingContext(ctx), "Failed to ping database")
+	qdb := db.New(dbConn)
+
+	rdb, err := infraredis.NewClient(cfg.Redis)
+	require.NoError(t, err, "Failed to connect to Redis")
+	t.Cleanup(func() { rdb.Close() })
+
+	chatSvc, err := chat.NewChatService(ctx, rdb, qdb, cfg.Kafka)
+	require.NoError(t, err, "Failed to create chat service")
+	t.Cleanup(func() { chatSvc.Close() })
+
+	archiver, err := chat.NewConsumer(ctx, qdb, cfg.Kafka)
+	require.NoError(t, err, "Failed to start chat history consumer")
+	t.Cleanup(func() { archiver.Close() })
+
+	activityTracker := activity.NewTracker(rdb)
+	chatSvc.SetActivityTracker(activityTracker)
+
+	sessionMgr := sessions.NewSessionManager(rdb)
+	friendSvc := friends.NewFriendService(qdb)
+	friendSvc.SetActivityTracker(activityTracker)
+	friendSvc.SetConversations(dbConn, chatSvc)
+	invalidator := cache.NewInvalidator(ctx, rdb)
+	usrv := users.NewUserService(qdb, invalidator)
+	groupSvc := groups.NewGroupService(qdb)
+	groupSvc.SetInvalidator(invalidator)
+	groupSvc.SetEventPublisher(rdb)
+	groupSvc.SetActivityTracker(activityTracker)
+	// Most tests add members directly; TestGroupInvites covers invitations
+	groupSvc.SetDirectAdd(true)
+	policy := moderation.NewPolicy(qdb, invalidator, cfg.Moderation)
+	chatSvc.SetPolicy(policy)
+	groupSvc.SetPolicy(policy)
+	mutesSvc := notifications.NewService(qdb, invalidator)
+	chatSvc.SetMutes(mutesSvc)
+	wsManager := _websocket.NewManager(ctx, rdb)
+	wsManager.SetReadTracker(chatSvc)
+	wsManager.SetDeliveryTracker(chatSvc)
+	wsManager.SetTypingPublisher(chatSvc)
+	wsManager.SetGroupService(groupSvc)
+	callSvc := calls.NewCallService(ctx, rdb)
+	callSvc.SetICE(calls.ICEOptions{
+		STUNURLs: []string{"stun:127.0.0.1:3478"},
+		TURNURLs: []string{"turn:127.0.0.1:3478"},
+		Secret:   testTURNSecret,
+	})
+	profileSvc := profiles.NewProfileService(qdb, dbConn)
+	clusterSvc := cluster.NewClusterService(ctx, rdb)
+	reminderSvc := reminders.NewReminderService(ctx, rdb, chatSvc)
+	sseBroker := sse.NewBroker(ctx, rdb, sse.Options{})
+	inboxSvc := notifications.NewNotificationService(qdb)
+	inboxSvc.SetPusher(wsManager)
+	inboxSvc.SetStream(sseBroker)
+	sseBroker.SetArchive(handlers.NotificationArchive(inboxSvc))
+	chatSvc.SetNotifications(inboxSvc)
+	callSvc.SetNotifications(inboxSvc)
+	impersonationSvc := impersonation.NewService(ctx, qdb, rdb, sessionMgr, usrv, inboxSvc)
+	maintenanceSvc := maintenance.NewService(ctx, rdb, cfg.Maintenance.AnnounceAt)
+	maintenanceSvc.OnAnnouncement(handlers.AnnounceMaintenance(wsManager, sseBroker))
+	complianceSvc := compliance.NewService(ctx, qdb, compliance.DefaultPolicy(cfg))
+	clientLogsSvc := clientlogs.NewService(rdb, cfg.ClientLogs)
+	experimentsSvc := experiments.NewService(ctx, rdb, cfg.Experiments, chatSvc)
+	uploadStore := uploads.NewStore(qdb, storage.NewLocal(cfg.Server.UploadsDir, "/uploads/"), cfg.Upload.UserQuota)
+	emojiSvc := emoji.NewEmojiService(qdb, invalidator, cfg.Server.UploadsDir)
+	emojiSvc.SetUploadStore(uploadStore)
+	exportSvc := export.NewExportService(qdb, chatSvc, groupSvc, nil, cfg.Export.MaxMessages)
+	gifSvc := gifs.NewGifService(cfg.Gifs, httpclient.New(), rdb)
+	statusSvc := status.NewService(ctx, rdb, []status.Component{{Name: "uploads", Check: uploadStore.Check}})
+	searchSvc := search.NewService(qdb)
+	directorySvc := directory.NewService(qdb)
+	canaries := canary.NewRegistry()
+	presenceSvc := presence.NewService(ctx, rdb, friendSvc)
+	presenceSvc.SetLocalUsers(wsManager)
+	presenceSvc.OnChange(handlers.NotifyPresence(wsManager))
+	wsManager.SetPresenceTracker(presenceSvc)
+
+	// Summaries run against a stub instead of an LLM provider
+	summaryCfg := cfg.Summaries
+	summaryCfg.Enabled = true
+	summarySvc := summaries.NewService(summaryCfg, stubSummarizer{}, chatSvc, rdb)
+
+	// Digests are mailed to a stub; the scheduled job never runs during a test
+	digestCfg := cfg.Digests
+	digestCfg.Enabled = true
+	digestCfg.Interval = time.Hour
+	digestSvc := digests.NewService(ctx, digestCfg, qdb, stubMailer{}, wsManager)
+
+	srv, err := server.NewServer(cfg, qdb, rdb, chatSvc, sessionMgr, friendSvc, groupSvc, wsManager, callSvc, profileSvc, clusterSvc, usrv, reminderSvc, gifSvc, emojiSvc, exportSvc, activityTracker, policy, canaries, uploadStore, sseBroker, maintenanceSvc, complianceSvc, clientLogsSvc, experimentsSvc, statusSvc, searchSvc, directorySvc, summarySvc, digestSvc, mutesSvc, inboxSvc, presenceSvc, impersonationSvc)
+	require.NoError(t, err, "Failed to create server")
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err, "Failed to create listener")
+
+	go srv.App.Listener(listener)
+	t.Cleanup(func() { srv.App.Shutdown() })
+
+	return "http://" + listener.Addr().String()
+}
+
+// stubSummarizer summarizes a transcript by counting it
+type stubSummarizer struct{}
+
+func (stubSummarizer) Summarize(_ context.Context, transcript []summaries.Line, _ int) (string, error) {
+	return fmt.Sprintf("%d messages, the last from %s", len(transcript), transcript[len(transcript)-1].From), nil
+}
+
+// stubMailer discards digests
+type stubMailer struct{}
+
+func (stubMailer) Send(context.Context, digests.Message) error {
+	return nil
+}
+
+// newUser registers a uniquely named user
+func newUser(t *testing.T, baseURL, name string) *clients.Session {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	username := fmt.Sprintf("it_%s_%d", name, time.Now().UnixNano()%1e9)
+	session, err := clients.Register(ctx, baseURL, username, password)
+	require.NoError(t, err)
+
+	return session
+}
+
+// newAdmin registers a uniquely named user with the admin role. The role is
+// set before the first login, so no instance has the user cached without it.
+func newAdmin(t *testing.T, baseURL, name string) *clients.Session {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	username := fmt.Sprintf("it_%s_%d", name, time.Now().UnixNano()%1e9)
+	require.NoError(t, clients.CreateAccount(ctx, baseURL, username, password))
+
+	dbConn, err := sql.Open("postgres", dbString)
+	require.NoError(t, err)
+	defer dbConn.Close()
+	_, err = dbConn.ExecContext(ctx, "UPDATE users SET role = 'admin' WHERE username = $1", username)
+	require.NoError(t, err)
+
+	session, err := clients.Login(ctx, baseURL, username, password)
+	require.NoError(t, err)
+
+	return session
+}
+
+// connect opens the user's WebSocket, closed when the test ends
+func connect(t *testing.T, session *clients.Session) *clients.WSClient {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	ws, err := session.DialWS(ctx)
+	require.NoError(t, err)
+	t.Cleanup(func() { ws.Close() })
+
+	return ws
+}
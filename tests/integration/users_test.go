@@ -0,0 +1,308 @@
+package integration
+
+import (
+	"context"
+	"errors"
+	"exc6/server/websocket"
+	"exc6/tests/clients"
+	"net/http"
+	"net/url"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUserLookup(t *testing.T) {
+	baseURL := startServer(t)
+
+	alice := newUser(t, baseURL, "alice")
+	bob := newUser(t, baseURL, "bob")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var byName struct {
+		Users []struct {
+			ID       string `json:"id"`
+			Username string `json:"username"`
+		} `json:"users"`
+		NotFound []string `json:"not_found"`
+	}
+	form := url.Values{"users": {bob.Username, "nobody-" + alice.Username, alice.Username}}
+	require.NoError(t, alice.PostJSON(ctx, "/api/v1/users/lookup", form, &byName))
+
+	require.Len(t, byName.Users, 2)
+	assert.Equal(t, bob.Username, byName.Users[0].Username, "cards keep the request order")
+	assert.Equal(t, alice.Username, byName.Users[1].Username)
+	assert.Equal(t, []string{"nobody-" + alice.Username}, byName.NotFound)
+
+	t.Run("By ID", func(t *testing.T) {
+		var byID struct {
+			Users []struct {
+				Username string `json:"username"`
+			} `json:"users"`
+		}
+		require.NoError(t, alice.PostJSON(ctx, "/api/v1/users/lookup", url.Values{"users": {byName.Users[0].ID}}, &byID))
+		require.Len(t, byID.Users, 1)
+		assert.Equal(t, bob.Username, byID.Users[0].Username)
+	})
+}
+
+func TestDirectory(t *testing.T) {
+	baseURL := startServer(t)
+
+	alice := newUser(t, baseURL, "alice")
+	bob := newUser(t, baseURL, "bob")
+	carol := newUser(t, baseURL, "carol")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	type listing struct {
+		Username string   `json:"username"`
+		Tags     []string `json:"tags"`
+	}
+	var directory struct {
+		Items []listing `json:"items"`
+	}
+	var found struct {
+		Items []struct {
+			Username string `json:"username"`
+		} `json:"items"`
+	}
+
+	require.NoError(t, alice.DoOK(ctx, http.MethodPut, "/api/v1/directory/settings", url.Values{"listed": {"true"}, "tags": {"Hiking", "#go"}}))
+
+	var settings struct {
+		Listed bool     `json:"listed"`
+		Tags   []string `json:"tags"`
+	}
+	require.NoError(t, alice.GetJSON(ctx, "/api/v1/directory/settings", &settings))
+	assert.True(t, settings.Listed)
+	assert.Equal(t, []string{"hiking", "go"}, settings.Tags)
+
+	require.NoError(t, bob.GetJSON(ctx, "/api/v1/directory?tag=hiking", &directory))
+	assert.Contains(t, directory.Items, listing{Username: alice.Username, Tags: []string{"hiking", "go"}})
+	for _, entry := range directory.Items {
+		assert.NotEqual(t, bob.Username, entry.Username, "users are unlisted until they opt in")
+	}
+
+	t.Run("Search only enumerates listed users", func(t *testing.T) {
+		require.NoError(t, bob.GetJSON(ctx, "/api/v1/friends/search?q="+alice.Username[:len(alice.Username)-1], &found))
+		require.Len(t, found.Items, 1)
+		assert.Equal(t, alice.Username, found.Items[0].Username)
+
+		require.NoError(t, bob.GetJSON(ctx, "/api/v1/friends/search?q="+carol.Username[:len(carol.Username)-1], &found))
+		assert.Empty(t, found.Items)
+
+		require.NoError(t, bob.GetJSON(ctx, "/api/v1/friends/search?q="+carol.Username, &found))
+		require.Len(t, found.Items, 1, "unlisted users are found by their exact username")
+	})
+
+	t.Run("Opting out removes the listing", func(t *testing.T) {
+		require.NoError(t, alice.DoOK(ctx, http.MethodPut, "/api/v1/directory/settings", url.Values{"listed": {"false"}}))
+
+		require.NoError(t, bob.GetJSON(ctx, "/api/v1/directory?tag=hiking", &directory))
+		for _, entry := range directory.Items {
+			assert.NotEqual(t, alice.Username, entry.Username)
+		}
+	})
+}
+
+func TestFriendPresence(t *testing.T) {
+	baseURL := startServer(t)
+
+	alice := newUser(t, baseURL, "alice")
+	bob := newUser(t, baseURL, "bob")
+	carol := newUser(t, baseURL, "carol")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	require.NoError(t, alice.PostOK(ctx, "/friends/request/"+bob.Username, nil))
+	require.NoError(t, bob.PostOK(ctx, "/friends/accept/"+alice.Username, nil))
+
+	var online struct {
+		Online []string `json:"online"`
+	}
+	onlineFriends := func(s *clients.Session) []string {
+		require.NoError(t, s.GetJSON(ctx, "/api/v1/friends/online", &online))
+		return online.Online
+	}
+
+	aliceWS, err := alice.DialWS(ctx)
+	require.NoError(t, err)
+	defer aliceWS.Close()
+
+	require.Eventually(t, func() bool {
+		return slices.Contains(onlineFriends(bob), alice.Username)
+	}, 5*time.Second, 50*time.Millisecond, "alice is online once connected")
+	assert.Empty(t, onlineFriends(alice))
+
+	bobWS, err := bob.DialWS(ctx)
+	require.NoError(t, err)
+
+	bobPresence := clients.All(clients.OfType(websocket.MessageTypePresence), clients.From(bob.Username))
+	msg, err := aliceWS.Expect(bobPresence, expectTimeout)
+	require.NoError(t, err)
+	assert.Equal(t, true, msg.Data["online"])
+
+	// Closing one of several devices keeps the user online
+	bobPhone, err := bob.DialWS(ctx)
+	require.NoError(t, err)
+	_, err = bobPhone.Expect(clients.OfType(websocket.MessageTypeCapabilities), expectTimeout)
+	require.NoError(t, err)
+	require.NoError(t, bobWS.Close())
+	assert.NoError(t, aliceWS.ExpectNone(bobPresence, time.Second))
+	assert.Contains(t, onlineFriends(alice), bob.Username)
+
+	require.NoError(t, bobPhone.Close())
+
+	msg, err = aliceWS.Expect(bobPresence, expectTimeout)
+	require.NoError(t, err)
+	assert.Equal(t, false, msg.Data["online"])
+
+	var presence struct {
+		Username string    `json:"username"`
+		Online   bool      `json:"online"`
+		LastSeen time.Time `json:"last_seen"`
+	}
+	require.NoError(t, alice.GetJSON(ctx, "/api/v1/friends/"+bob.Username+"/presence", &presence))
+	assert.False(t, presence.Online)
+	assert.WithinDuration(t, time.Now(), presence.LastSeen, time.Minute)
+
+	t.Run("Only friends see presence", func(t *testing.T) {
+		err := carol.GetJSON(ctx, "/api/v1/friends/"+alice.Username+"/presence", &presence)
+		var statusErr *clients.StatusError
+		require.True(t, errors.As(err, &statusErr), "expected a status error: %v", err)
+		assert.Equal(t, http.StatusNotFound, statusErr.StatusCode)
+	})
+}
+
+func TestImpersonation(t *testing.T) {
+	baseURL := startServer(t)
+
+	admin := newAdmin(t, baseURL, "admin")
+	alice := newUser(t, baseURL, "alice")
+	bob := newUser(t, baseURL, "bob")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	impersonate := func(reason string) *http.Response {
+		resp, err := admin.Post(ctx, "/admin/impersonate/"+alice.Username, url.Values{"reason": {reason}})
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp
+	}
+
+	assert.Equal(t, http.StatusForbidden, impersonate("ticket 42").StatusCode, "alice has not granted access")
+	require.NoError(t, alice.PostOK(ctx, "/api/v1/support-access", nil))
+	assert.Equal(t, http.StatusBadRequest, impersonate("").StatusCode, "a reason is required")
+
+	resp := impersonate("ticket 42: messages not loading")
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	// The admin's client now holds alice's support session
+	support := *admin
+	support.Username, support.CSRFToken = alice.Username, ""
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == "session_id" {
+			support.SessionID = cookie.Value
+		}
+	}
+	require.NotEqual(t, admin.SessionID, support.SessionID)
+
+	var access struct {
+		Granted bool `json:"granted"`
+	}
+	require.NoError(t, support.GetJSON(ctx, "/api/v1/support-access", &access), "reads as alice")
+	assert.True(t, access.Granted)
+
+	t.Run("Changes are refused", func(t *testing.T) {
+		resp, err := support.Post(ctx, "/chat/"+bob.Username, url.Values{"message": {"hello"}})
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+		_, err = support.DialWS(ctx)
+		assert.Error(t, err, "no WebSocket to send over")
+	})
+
+	t.Run("Ending restores the admin", func(t *testing.T) {
+		resp, err := support.Do(ctx, http.MethodGet, "/dashboard", nil)
+		require.NoError(t, err)
+		resp.Body.Close()
+		for _, cookie := range resp.Cookies() {
+			if cookie.Name == "csrf_token" {
+				support.CSRFToken = cookie.Value
+			}
+		}
+
+		require.NoError(t, support.PostOK(ctx, "/impersonation/end", nil))
+
+		resp, err = support.Do(ctx, http.MethodGet, "/api/v1/support-access", nil)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, "the support session is gone")
+
+		var trail struct {
+			Impersonations []struct {
+				Admin   string     `json:"admin"`
+				Reason  string     `json:"reason"`
+				EndedAt *time.Time `json:"ended_at"`
+			} `json:"impersonations"`
+		}
+		require.NoError(t, admin.GetJSON(ctx, "/admin/impersonations?username="+alice.Username, &trail))
+		require.Len(t, trail.Impersonations, 1)
+		assert.Equal(t, admin.Username, trail.Impersonations[0].Admin)
+		assert.Equal(t, "ticket 42: messages not loading", trail.Impersonations[0].Reason)
+		assert.NotNil(t, trail.Impersonations[0].EndedAt)
+	})
+
+	t.Run("The user is told", func(t *testing.T) {
+		var inbox struct {
+			Items []struct {
+				Type string `json:"type"`
+				From string `json:"from"`
+			} `json:"items"`
+		}
+		require.NoError(t, alice.GetJSON(ctx, "/api/v1/notifications/inbox", &inbox))
+		require.NotEmpty(t, inbox.Items)
+		assert.Equal(t, "impersonation", inbox.Items[0].Type)
+		assert.Equal(t, admin.Username, inbox.Items[0].From)
+	})
+}
+
+// TestClientLogs sends a report as the browser script does and finds it in
+// the admin's list
+func TestClientLogs(t *testing.T) {
+	baseURL := startServer(t)
+
+	admin := newAdmin(t, baseURL, "admin")
+	alice := newUser(t, baseURL, "alice")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// Call failures are never sampled out
+	resp, err := alice.SendJSON(ctx, http.MethodPost, "/api/v1/client-logs", map[string]any{
+		"reports": []map[string]any{{"kind": "call_failure", "message": "ICE failed", "url": "/dashboard"}},
+	})
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusAccepted, resp.StatusCode)
+
+	type report struct {
+		Username string `json:"username"`
+		Message  string `json:"message"`
+	}
+	var recent struct {
+		Reports []report `json:"reports"`
+	}
+	require.NoError(t, admin.GetJSON(ctx, "/admin/client-logs", &recent))
+	assert.Contains(t, recent.Reports, report{Username: alice.Username, Message: "ICE failed"})
+}
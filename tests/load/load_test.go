@@ -6,16 +6,45 @@ import (
 	"exc6/config"
 	"exc6/db"
 	infraredis "exc6/infrastructure/redis"
+	"exc6/infrastructure/storage"
+	"exc6/pkg/cache"
+	"exc6/pkg/httpclient"
 	"exc6/pkg/logger"
 	"exc6/server"
+	"exc6/server/handlers"
+	"exc6/server/middleware/canary"
+	"exc6/server/sse"
 	_websocket "exc6/server/websocket"
+	"exc6/services/activity"
 	"exc6/services/calls"
 	"exc6/services/chat"
+	"exc6/services/clientlogs"
+	"exc6/services/cluster"
+	"exc6/services/compliance"
+	"exc6/services/digests"
+	"exc6/services/directory"
+	"exc6/services/emoji"
+	"exc6/services/experiments"
+	"exc6/services/export"
 	"exc6/services/friends"
+	"exc6/services/gifs"
 	"exc6/services/groups"
+	"exc6/services/impersonation"
+	"exc6/services/maintenance"
+	"exc6/services/moderation"
+	"exc6/services/notifications"
+	"exc6/services/presence"
+	"exc6/services/profiles"
+	"exc6/services/reminders"
+	"exc6/services/search"
 	"exc6/services/sessions"
+	"exc6/services/status"
+	"exc6/services/summaries"
+	"exc6/services/uploads"
+	"exc6/services/users"
 	"fmt"
 	"io"
+	"math"
 	"math/rand"
 	"net"
 	"net/http"
@@ -27,6 +56,7 @@ import (
 	"strings"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 
@@ -85,6 +115,7 @@ func TestConcurrentUserLogins(t *testing.T) {
 		totalLatency   int64
 		wg             sync.WaitGroup
 		progressTicker = time.NewTicker(2 * time.Second)
+		stages         = newStageTimings()
 	)
 
 	// Create a channel to signal completion
@@ -131,7 +162,7 @@ func TestConcurrentUserLogins(t *testing.T) {
 			ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
 			defer cancel()
 
-			err := attemptLogin(ctx, app, user.Username, user.Password)
+			err := attemptLogin(ctx, app, user.Username, user.Password, stages)
 			latency := time.Since(reqStart)
 
 			if err == nil {
@@ -183,6 +214,15 @@ func TestConcurrentUserLogins(t *testing.T) {
 	t.Logf("Avg Latency: %v", avgLatency)
 	t.Logf("Throughput: %.2f req/sec", throughput)
 
+	// Which stage of the server the latency went to
+	stageAverages := stages.averages()
+	stageFields := make(map[string]any, len(stageAverages))
+	for stage, avg := range stageAverages {
+		stageFields[stage] = avg
+		t.Logf("Avg %s: %v", stage, avg)
+	}
+	testLogger.WithFields(stageFields).Info("=== Login Latency By Stage ===")
+
 	assert.GreaterOrEqual(t, successRate, 95.0, "Success rate should be >= 95%")
 	assert.Less(t, avgLatency, 500*time.Millisecond, "Average latency should be < 500ms")
 	assert.Greater(t, throughput, 100.0, "Throughput should be > 100 req/sec")
@@ -466,6 +506,126 @@ func TestWebSocketConnectionStorm(t *testing.T) {
 	testLogger.Info("WebSocket connection storm test completed successfully")
 }
 
+// TestRelayFanoutCPU measures the CPU spent relaying chat traffic while many
+// connections are idle. Only two users exchange messages, so with per-user
+// channels the remaining connections should receive (and filter) nothing.
+func TestRelayFanoutCPU(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping load test in short mode")
+	}
+
+	testLogger.Info("========================================")
+	testLogger.Info("Starting Relay Fan-out CPU Test")
+	testLogger.Info("========================================")
+
+	const (
+		numConnections = 1000
+		numMessages    = 500
+	)
+
+	app, cleanup := setupTestApp(t)
+	defer cleanup()
+
+	serverAddr, stopServer := startTestServer(app)
+	defer stopServer()
+
+	users := createTestUsers(t, app, numConnections)
+
+	var (
+		connected int64
+		conns     []*fastws.Conn
+	)
+
+	for i, user := range users {
+		ws, err := connectWebSocket(serverAddr, user.SessionID)
+		if err != nil {
+			testLogger.WithError(err).WithField("username", user.Username).Warn("WebSocket connection failed")
+			continue
+		}
+		connected++
+		conns = append(conns, ws)
+
+		// Drain incoming frames so the server never blocks on this client
+		go func(ws *fastws.Conn) {
+			for {
+				if _, err := receiveMessage(ws); err != nil {
+					return
+				}
+			}
+		}(ws)
+
+		if i%50 == 0 {
+			time.Sleep(100 * time.Millisecond)
+		}
+	}
+	defer func() {
+		for _, ws := range conns {
+			ws.Close()
+		}
+	}()
+
+	filteredBefore := relayMetric(t, app, "filtered")
+	cpuBefore := processCPUTime()
+	startTime := time.Now()
+
+	sender, recipient := users[0], users[1]
+	var sendErrors int64
+	for i := 0; i < numMessages; i++ {
+		if err := sendMessage(app, sender.SessionID, sender.CSRFToken, recipient.Username, fmt.Sprintf("fan-out %d", i)); err != nil {
+			sendErrors++
+		}
+	}
+
+	// Give relays time to drain
+	time.Sleep(2 * time.Second)
+
+	cpuUsed := processCPUTime() - cpuBefore
+	filtered := relayMetric(t, app, "filtered") - filteredBefore
+	duration := time.Since(startTime)
+
+	testLogger.WithFields(map[string]any{
+		"connections":      connected,
+		"messages":         numMessages,
+		"send_errors":      sendErrors,
+		"cpu_time":         cpuUsed,
+		"cpu_per_message":  cpuUsed / numMessages,
+		"filtered_payload": filtered,
+		"duration":         duration,
+	}).Info("=== Relay Fan-out CPU Results ===")
+
+	t.Logf("=== Relay Fan-out CPU Results ===")
+	t.Logf("Connections: %d", connected)
+	t.Logf("Messages: %d (errors: %d)", numMessages, sendErrors)
+	t.Logf("CPU time: %v (%v per message)", cpuUsed, cpuUsed/numMessages)
+	t.Logf("Payloads filtered by relays: %.0f", filtered)
+
+	assert.GreaterOrEqual(t, float64(connected), numConnections*0.95, "Connection success rate >= 95%")
+	assert.Equal(t, 0.0, filtered, "Relays should only receive payloads for their own conversations")
+}
+
+// processCPUTime returns the user+system CPU time consumed by this process
+func processCPUTime() time.Duration {
+	var usage syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &usage); err != nil {
+		return 0
+	}
+	return time.Duration(usage.Utime.Nano() + usage.Stime.Nano())
+}
+
+// relayMetric sums ws_relay_payloads_total for the given result label
+func relayMetric(t *testing.T, app *TestApp, result string) float64 {
+	var total float64
+	for key, value := range getCircuitBreakerMetrics(t, app) {
+		if !strings.HasPrefix(key, "ws_relay_payloads_total{") || !strings.Contains(key, `result="`+result+`"`) {
+			continue
+		}
+		if v, ok := value.(float64); ok {
+			total += v
+		}
+	}
+	return total
+}
+
 // TestDatabaseQueryPerformance tests database under load
 func TestDatabaseQueryPerformance(t *testing.T) {
 	if testing.Short() {
@@ -672,7 +832,7 @@ type TestApp struct {
 	App        *fiber.App
 	DB         *db.Queries
 	RDB        *redis.Client
-	ChatSvc    *chat.ChatService
+	ChatSvc    chat.Service
 	SessionMgr *sessions.SessionManager
 }
 
@@ -696,6 +856,9 @@ func setupTestApp(t *testing.T) (*TestApp, func()) {
 	// Ensure log directory exists for server.log
 	os.Setenv("LOG_FILE", "./tests/load/log/server.log")
 
+	// Responses carry a per-stage breakdown for the latency reports
+	os.Setenv("TIMING_HEADERS", "true")
+
 	cfg, err := config.Load()
 	require.NoError(t, err, "Failed to load test config")
 
@@ -745,16 +908,72 @@ func setupTestApp(t *testing.T) (*TestApp, func()) {
 	testLogger.Info("Redis flushed")
 
 	testLogger.Info("Initializing services")
-	chatSvc, err := chat.NewChatService(ctx, rdb, qdb, cfg.Kafka.Address)
+	chatSvc, err := chat.NewChatService(ctx, rdb, qdb, cfg.Kafka)
 	require.NoError(t, err, "Failed to create chat service")
+	// Load traffic is between strangers; message requests would cap it
+	chatSvc.SetLimits(chat.Limits{MaxLength: cfg.Messages.MaxLength, RequestLimit: math.MaxInt32})
+	archiver, err := chat.NewConsumer(ctx, qdb, cfg.Kafka)
+	require.NoError(t, err, "Failed to start chat history consumer")
+	defer archiver.Close()
+	activityTracker := activity.NewTracker(rdb)
+	chatSvc.SetActivityTracker(activityTracker)
 
 	sessionMgr := sessions.NewSessionManager(rdb)
 	friendSvc := friends.NewFriendService(qdb)
+	friendSvc.SetActivityTracker(activityTracker)
+	friendSvc.SetConversations(dbConn, chatSvc)
+	invalidator := cache.NewInvalidator(ctx, rdb)
+	usrv := users.NewUserService(qdb, invalidator)
 	groupSvc := groups.NewGroupService(qdb)
+	groupSvc.SetInvalidator(invalidator)
+	groupSvc.SetEventPublisher(rdb)
+	groupSvc.SetActivityTracker(activityTracker)
+	policy := moderation.NewPolicy(qdb, invalidator, cfg.Moderation)
+	chatSvc.SetPolicy(policy)
+	groupSvc.SetPolicy(policy)
+	mutesSvc := notifications.NewService(qdb, invalidator)
+	chatSvc.SetMutes(mutesSvc)
 	wsManager := _websocket.NewManager(ctx, rdb)
+	wsManager.SetReadTracker(chatSvc)
+	wsManager.SetDeliveryTracker(chatSvc)
+	wsManager.SetTypingPublisher(chatSvc)
+	wsManager.SetGroupService(groupSvc)
 	callSvc := calls.NewCallService(ctx, rdb)
-
-	srv, err := server.NewServer(cfg, qdb, rdb, chatSvc, sessionMgr, friendSvc, groupSvc, wsManager, callSvc)
+	profileSvc := profiles.NewProfileService(qdb, dbConn)
+	clusterSvc := cluster.NewClusterService(ctx, rdb)
+	reminderSvc := reminders.NewReminderService(ctx, rdb, chatSvc)
+	sseBroker := sse.NewBroker(ctx, rdb, sse.Options{})
+	inboxSvc := notifications.NewNotificationService(qdb)
+	inboxSvc.SetPusher(wsManager)
+	inboxSvc.SetStream(sseBroker)
+	sseBroker.SetArchive(handlers.NotificationArchive(inboxSvc))
+	chatSvc.SetNotifications(inboxSvc)
+	callSvc.SetNotifications(inboxSvc)
+	impersonationSvc := impersonation.NewService(ctx, qdb, rdb, sessionMgr, usrv, inboxSvc)
+	maintenanceSvc := maintenance.NewService(ctx, rdb, cfg.Maintenance.AnnounceAt)
+	maintenanceSvc.OnAnnouncement(handlers.AnnounceMaintenance(wsManager, sseBroker))
+	complianceSvc := compliance.NewService(ctx, qdb, compliance.DefaultPolicy(cfg))
+	clientLogsSvc := clientlogs.NewService(rdb, cfg.ClientLogs)
+	experimentsSvc := experiments.NewService(ctx, rdb, cfg.Experiments, chatSvc)
+	uploadStore := uploads.NewStore(qdb, storage.NewLocal(cfg.Server.UploadsDir, "/uploads/"), cfg.Upload.UserQuota)
+	emojiSvc := emoji.NewEmojiService(qdb, invalidator, cfg.Server.UploadsDir)
+	emojiSvc.SetUploadStore(uploadStore)
+	exportSvc := export.NewExportService(qdb, chatSvc, groupSvc, nil, cfg.Export.MaxMessages)
+	gifSvc := gifs.NewGifService(cfg.Gifs, httpclient.New(), rdb)
+	statusSvc := status.NewService(ctx, rdb, []status.Component{{Name: "uploads", Check: uploadStore.Check}})
+	searchSvc := search.NewService(qdb)
+	directorySvc := directory.NewService(qdb)
+	summarySvc := summaries.NewService(cfg.Summaries, nil, chatSvc, rdb)
+	digestSvc := digests.NewService(ctx, cfg.Digests, qdb, nil, nil)
+
+	presenceSvc := presence.NewService(ctx, rdb, friendSvc)
+	presenceSvc.SetLocalUsers(wsManager)
+	presenceSvc.OnChange(handlers.NotifyPresence(wsManager))
+	wsManager.SetPresenceTracker(presenceSvc)
+
+	canaries := canary.NewRegistry()
+
+	srv, err := server.NewServer(cfg, qdb, rdb, chatSvc, sessionMgr, friendSvc, groupSvc, wsManager, callSvc, profileSvc, clusterSvc, usrv, reminderSvc, gifSvc, emojiSvc, exportSvc, activityTracker, policy, canaries, uploadStore, sseBroker, maintenanceSvc, complianceSvc, clientLogsSvc, experimentsSvc, statusSvc, searchSvc, directorySvc, summarySvc, digestSvc, mutesSvc, inboxSvc, presenceSvc, impersonationSvc)
 	require.NoError(t, err, "Failed to create server")
 
 	testApp := &TestApp{
@@ -927,7 +1146,53 @@ func loginUser(app *TestApp, username, password string) (string, string, error)
 	return sessionID, csrfToken, nil
 }
 
-func attemptLogin(ctx context.Context, app *TestApp, username, password string) error {
+// stageTimings adds up the Server-Timing breakdowns of responses
+type stageTimings struct {
+	mu     sync.Mutex
+	totals map[string]time.Duration
+	count  int
+}
+
+func newStageTimings() *stageTimings {
+	return &stageTimings{totals: make(map[string]time.Duration)}
+}
+
+// add records a Server-Timing header such as "auth;dur=210.3, total;dur=240.1"
+func (s *stageTimings) add(header string) {
+	if s == nil || header == "" {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, metric := range strings.Split(header, ",") {
+		params := strings.Split(strings.TrimSpace(metric), ";")
+		for _, param := range params[1:] {
+			if ms, ok := strings.CutPrefix(param, "dur="); ok {
+				if dur, err := strconv.ParseFloat(ms, 64); err == nil {
+					s.totals[params[0]] += time.Duration(dur * float64(time.Millisecond))
+				}
+			}
+		}
+	}
+	s.count++
+}
+
+// averages returns the mean time per response of each stage
+func (s *stageTimings) averages() map[string]time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	averages := make(map[string]time.Duration, len(s.totals))
+	for stage, total := range s.totals {
+		averages[stage] = total / time.Duration(max(s.count, 1))
+	}
+	return averages
+}
+
+// attemptLogin logs a user in, adding the response's breakdown to stages
+// when set
+func attemptLogin(ctx context.Context, app *TestApp, username, password string, stages *stageTimings) error {
 	form := url.Values{}
 	form.Add("username", username)
 	form.Add("password", password)
@@ -940,6 +1205,7 @@ func attemptLogin(ctx context.Context, app *TestApp, username, password string)
 	if err != nil {
 		return err
 	}
+	stages.add(resp.Header.Get("Server-Timing"))
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
@@ -1197,7 +1463,7 @@ func BenchmarkLogin(b *testing.B) {
 		i := 0
 		for pb.Next() {
 			user := users[i%len(users)]
-			_ = attemptLogin(context.Background(), app, user.Username, user.Password)
+			_ = attemptLogin(context.Background(), app, user.Username, user.Password, nil)
 			i++
 		}
 	})
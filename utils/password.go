@@ -15,7 +15,7 @@ func ValidatePasswordStrength(password string) *apperrors.AppError {
 }
 
 func HashPassword(password string) (string, *apperrors.AppError) {
-	hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), PasswordCost())
 	if err != nil {
 		return "", apperrors.New(apperrors.ErrCodeInternal, "Failed to hash password", 500).WithInternal(err)
 	}
@@ -0,0 +1,81 @@
+package utils
+
+import (
+	"exc6/pkg/instance"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// passwordCost is the bcrypt cost of new hashes. Existing hashes keep the
+// cost they were created with and still verify.
+var passwordCost atomic.Int32
+
+var passwordHashDuration = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "password_hash_duration_seconds",
+		Help: "Time one bcrypt hash took at the configured cost, measured at startup",
+	},
+)
+
+func init() {
+	SetPasswordCost(bcrypt.DefaultCost)
+	instance.Registerer().MustRegister(passwordHashDuration)
+}
+
+// PasswordCost returns the bcrypt cost used for new hashes
+func PasswordCost() int {
+	return int(passwordCost.Load())
+}
+
+// SetPasswordCost sets the bcrypt cost used for new hashes
+func SetPasswordCost(cost int) {
+	passwordCost.Store(int32(cost))
+}
+
+// PasswordCalibration is the outcome of CalibratePasswordCost
+type PasswordCalibration struct {
+	Cost     int           // Cost in effect after calibration
+	Duration time.Duration // Measured time of one hash at Cost
+	Slow     bool          // Whether the configured cost exceeded the target
+}
+
+// CalibratePasswordCost measures how long one hash takes at the current cost
+// and records it in the gauge. If hashing is slower than target and adjust is
+// set, the cost is lowered step by step, but never below minCost.
+func CalibratePasswordCost(target time.Duration, adjust bool, minCost int) PasswordCalibration {
+	result := PasswordCalibration{Cost: PasswordCost()}
+	result.Duration = measureHash(result.Cost)
+	result.Slow = result.Duration > target
+
+	if result.Slow && adjust {
+		if cost := costForTarget(result.Cost, minCost, result.Duration, target); cost != result.Cost {
+			SetPasswordCost(cost)
+			result.Cost = cost
+			result.Duration = measureHash(cost)
+		}
+	}
+
+	passwordHashDuration.Set(result.Duration.Seconds())
+
+	return result
+}
+
+// costForTarget estimates the highest cost at or below cost whose hash time
+// fits target, given the time measured at cost. Each step halves the work.
+func costForTarget(cost, minCost int, measured, target time.Duration) int {
+	for cost > minCost && measured > target {
+		cost--
+		measured /= 2
+	}
+	return cost
+}
+
+// measureHash returns the time of one hash at cost
+func measureHash(cost int) time.Duration {
+	start := time.Now()
+	bcrypt.GenerateFromPassword([]byte("calibration-password"), cost)
+	return time.Since(start)
+}
@@ -2,12 +2,17 @@ package utils
 
 import (
 	"exc6/apperrors"
+	"net/mail"
+	"net/url"
 	"regexp"
+	"strings"
+	"unicode/utf8"
 )
 
 var (
 	usernameRegex  = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
 	groupNameRegex = regexp.MustCompile(`^[a-zA-Z0-9_\-\s]+$`)
+	phoneRegex     = regexp.MustCompile(`^\+?[0-9]{7,15}$`)
 )
 
 // ValidateUsername checks if the username meets security requirements
@@ -43,3 +48,64 @@ func ValidateGroupName(name string) *apperrors.AppError {
 
 	return nil
 }
+
+// ValidateDisplayName checks the length and content of a display name
+func ValidateDisplayName(name string) *apperrors.AppError {
+	length := utf8.RuneCountInString(name)
+	if length < 2 {
+		return apperrors.NewValidationError("Display name must be at least 2 characters long")
+	}
+
+	if length > 50 {
+		return apperrors.NewValidationError("Display name cannot exceed 50 characters")
+	}
+
+	for _, r := range name {
+		if r < 0x20 || r == 0x7f {
+			return apperrors.NewValidationError("Display name cannot contain control characters")
+		}
+	}
+
+	return nil
+}
+
+// ValidateEmail checks that the value is a single plain email address
+func ValidateEmail(email string) *apperrors.AppError {
+	if len(email) > 254 {
+		return apperrors.NewValidationError("Email cannot exceed 254 characters")
+	}
+
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email || !strings.Contains(email[strings.LastIndex(email, "@"):], ".") {
+		return apperrors.NewValidationError("Email address is not valid")
+	}
+
+	return nil
+}
+
+// ValidatePhone checks for an optionally '+' prefixed number of 7-15 digits
+func ValidatePhone(phone string) *apperrors.AppError {
+	if !phoneRegex.MatchString(phone) {
+		return apperrors.NewValidationError("Phone number must contain 7 to 15 digits and may start with '+'")
+	}
+
+	return nil
+}
+
+// ValidateURL checks that the value is an absolute http(s) URL
+func ValidateURL(raw string) *apperrors.AppError {
+	if len(raw) > 2048 {
+		return apperrors.NewValidationError("URL cannot exceed 2048 characters")
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return apperrors.NewValidationError("URL is not valid")
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return apperrors.NewValidationError("URL must use http or https")
+	}
+
+	return nil
+}
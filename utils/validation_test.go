@@ -2,6 +2,7 @@ package utils
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -105,3 +106,128 @@ func TestValidateGroupName(t *testing.T) {
 		})
 	}
 }
+
+func TestValidateEmail(t *testing.T) {
+	tests := []struct {
+		name    string
+		email   string
+		wantErr bool
+	}{
+		{
+			name:    "Valid email",
+			email:   "alice@example.com",
+			wantErr: false,
+		},
+		{
+			name:    "Missing domain dot",
+			email:   "alice@localhost",
+			wantErr: true,
+		},
+		{
+			name:    "Display name form",
+			email:   "Alice <alice@example.com>",
+			wantErr: true,
+		},
+		{
+			name:    "Missing at sign",
+			email:   "alice.example.com",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateEmail(tt.email)
+			if tt.wantErr {
+				assert.NotNil(t, err)
+			} else {
+				assert.Nil(t, err)
+			}
+		})
+	}
+}
+
+func TestValidateURL(t *testing.T) {
+	tests := []struct {
+		name    string
+		url     string
+		wantErr bool
+	}{
+		{
+			name:    "Valid https URL",
+			url:     "https://example.com/me",
+			wantErr: false,
+		},
+		{
+			name:    "Javascript scheme",
+			url:     "javascript:alert(1)",
+			wantErr: true,
+		},
+		{
+			name:    "Relative path",
+			url:     "/profile",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateURL(tt.url)
+			if tt.wantErr {
+				assert.NotNil(t, err)
+			} else {
+				assert.Nil(t, err)
+			}
+		})
+	}
+}
+
+func TestCostForTarget(t *testing.T) {
+	tests := []struct {
+		name     string
+		cost     int
+		minCost  int
+		measured time.Duration
+		target   time.Duration
+		want     int
+	}{
+		{
+			name:     "Within target",
+			cost:     12,
+			minCost:  10,
+			measured: 200 * time.Millisecond,
+			target:   250 * time.Millisecond,
+			want:     12,
+		},
+		{
+			name:     "One step down",
+			cost:     12,
+			minCost:  10,
+			measured: 400 * time.Millisecond,
+			target:   250 * time.Millisecond,
+			want:     11,
+		},
+		{
+			name:     "Several steps down",
+			cost:     14,
+			minCost:  10,
+			measured: 1600 * time.Millisecond,
+			target:   250 * time.Millisecond,
+			want:     11,
+		},
+		{
+			name:     "Stops at minimum",
+			cost:     12,
+			minCost:  11,
+			measured: 2 * time.Second,
+			target:   250 * time.Millisecond,
+			want:     11,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, costForTarget(tt.cost, tt.minCost, tt.measured, tt.target))
+		})
+	}
+}